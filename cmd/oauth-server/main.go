@@ -16,6 +16,7 @@ import (
 	"k8s.io/component-base/logs"
 
 	"github.com/openshift/library-go/pkg/serviceability"
+	"github.com/openshift/oauth-server/pkg/cmd/loadgen"
 	openshift_integrated_oauth_server "github.com/openshift/oauth-server/pkg/cmd/oauth-server"
 	"github.com/openshift/oauth-server/pkg/version"
 )
@@ -63,7 +64,14 @@ func NewOpenshiftIntegratedOAuthServerCommand(stopCh <-chan struct{}) (*cobra.Co
 		return nil, err
 	}
 
+	validate, err := openshift_integrated_oauth_server.NewValidateCommand(os.Stdout, os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+
 	cmd.AddCommand(startOsin)
+	cmd.AddCommand(validate)
+	cmd.AddCommand(loadgen.NewLoadGenCommand(os.Stdout, os.Stderr, stopCh))
 
 	return cmd, nil
 }
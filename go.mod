@@ -5,7 +5,9 @@ go 1.18
 require (
 	github.com/RangelReale/osincli v0.0.0
 	github.com/davecgh/go-spew v1.1.1
+	github.com/fsnotify/fsnotify v1.4.9
 	github.com/gophercloud/gophercloud v0.24.0
+	github.com/gorilla/securecookie v1.1.1
 	github.com/gorilla/sessions v1.2.1
 	github.com/openshift/api v0.0.0-20211012185411-2e1b88be96db
 	github.com/openshift/build-machinery-go v0.0.0-20210806203541-4ea9b6da3a37
@@ -51,7 +53,6 @@ require (
 	github.com/google/gofuzz v1.1.0 // indirect
 	github.com/google/uuid v1.1.2 // indirect
 	github.com/googleapis/gnostic v0.5.5 // indirect
-	github.com/gorilla/securecookie v1.1.1 // indirect
 	github.com/grpc-ecosystem/go-grpc-prometheus v1.2.0 // indirect
 	github.com/grpc-ecosystem/grpc-gateway v1.16.0 // indirect
 	github.com/imdario/mergo v0.3.7 // indirect
@@ -7,6 +7,7 @@ package api
 // Calls to Error() will be passed directly to the wrapped error.
 type AuthorizationDeniedError struct {
 	identity UserIdentityInfo
+	message  string
 	error
 }
 
@@ -19,9 +20,22 @@ func NewAuthorizationDeniedError(identity UserIdentityInfo, err error) Authoriza
 	}
 }
 
+// NewAuthorizationDeniedErrorWithMessage is like NewAuthorizationDeniedError,
+// but also carries a message that may be shown to the user.
+func NewAuthorizationDeniedErrorWithMessage(identity UserIdentityInfo, err error, message string) AuthorizationDeniedError {
+	return AuthorizationDeniedError{
+		identity: identity,
+		message:  message,
+		error:    err,
+	}
+}
+
 // Identity returns identity information relative to a denied access attempt.
 func (e AuthorizationDeniedError) Identity() UserIdentityInfo { return e.identity }
 
+// UserMessage returns the message to show to the user, if any.
+func (e AuthorizationDeniedError) UserMessage() string { return e.message }
+
 // Unwrap returns the underlying error to satisfy errors.As() and errors.Is().
 func (e AuthorizationDeniedError) Unwrap() error { return e.error }
 
@@ -49,3 +63,54 @@ func (e AuthorizationFailedError) Identity() UserIdentityInfo { return e.identit
 
 // Unwrap returns the underlying error to satisfy errors.As() and errors.Is().
 func (e AuthorizationFailedError) Unwrap() error { return e.error }
+
+// InvalidStateError is raised when the state of an identity provider callback
+// cannot be verified, e.g. because its CSRF token does not match the one of
+// the browser.
+//
+// Calls to Error() will be passed directly to the wrapped error.
+type InvalidStateError struct {
+	error
+}
+
+// NewInvalidStateError wraps the given error in an InvalidStateError type.
+func NewInvalidStateError(err error) InvalidStateError {
+	return InvalidStateError{error: err}
+}
+
+// Unwrap returns the underlying error to satisfy errors.As() and errors.Is().
+func (e InvalidStateError) Unwrap() error { return e.error }
+
+// IdentityProviderError is raised when an identity provider returns an error
+// or cannot be reached, before an identity is known.
+//
+// Calls to Error() will be passed directly to the wrapped error.
+type IdentityProviderError struct {
+	error
+}
+
+// NewIdentityProviderError wraps the given error in an IdentityProviderError
+// type.
+func NewIdentityProviderError(err error) IdentityProviderError {
+	return IdentityProviderError{error: err}
+}
+
+// Unwrap returns the underlying error to satisfy errors.As() and errors.Is().
+func (e IdentityProviderError) Unwrap() error { return e.error }
+
+// RedirectLoopError is raised when a login succeeds, but the user was sent
+// back to log in too often already, e.g. because the browser does not keep
+// the session cookie.
+//
+// Calls to Error() will be passed directly to the wrapped error.
+type RedirectLoopError struct {
+	error
+}
+
+// NewRedirectLoopError wraps the given error in a RedirectLoopError type.
+func NewRedirectLoopError(err error) RedirectLoopError {
+	return RedirectLoopError{error: err}
+}
+
+// Unwrap returns the underlying error to satisfy errors.As() and errors.Is().
+func (e RedirectLoopError) Unwrap() error { return e.error }
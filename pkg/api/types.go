@@ -16,10 +16,19 @@ const (
 	IdentityDisplayNameKey = "name"
 	// IdentityEmailKey is the key for an optional email address in an identity's Extra map
 	IdentityEmailKey = "email"
+	// IdentityEmailVerifiedKey is set to "true" in an identity's Extra map if the identity provider verified that
+	// the email address belongs to the user
+	IdentityEmailVerifiedKey = "email_verified"
 	// IdentityPreferredUsernameKey is the key for an optional preferred username in an identity's Extra map.
 	// This is useful when the immutable providerUserName is different than the login used to authenticate
 	// If present, this extra value is used as the preferred username
 	IdentityPreferredUsernameKey = "preferred_username"
+	// IdentityGroupsKey is the key for an optional comma-separated list of the provider groups
+	// that authorized the identity to log in, in an identity's Extra map
+	IdentityGroupsKey = "groups"
+	// IdentityCredentialsVersionKey is the key for an optional version of the credentials the identity logged in
+	// with, in an identity's Extra map. It changes whenever the password of the identity changes.
+	IdentityCredentialsVersionKey = "credentials_version"
 )
 
 // UserIdentityInfo contains information about an identity.  Identities are distinct from users.  An authentication server of
@@ -118,6 +127,25 @@ type ProviderInfo struct {
 	Name string
 	// URL to login using this identity provider
 	URL string
+	// DisplayName is shown to users instead of the name, if it is set
+	DisplayName string
+	// IconURL is the location of an icon representing the identity provider, if any
+	IconURL string
+	// Description tells users which accounts the identity provider is for, if it is set
+	Description string
+	// Order sorts the identity providers in ascending order
+	Order int
+	// Remembered is set for the identity provider the user chose the last time
+	Remembered bool
+}
+
+// ProviderDisplay is the configured presentation of an identity provider, which the ProviderInfo of the
+// provider is filled in with
+type ProviderDisplay struct {
+	DisplayName string
+	IconURL     string
+	Description string
+	Order       int
 }
 
 // OAuthClientGetter exposes a way to get a specific client.  This is useful for other registries to get scope limitations
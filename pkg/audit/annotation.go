@@ -16,6 +16,18 @@ const (
 	// DecisionAnnotation is an annotation key for the authentication decision
 	// used for audit events.
 	DecisionAnnotation = "authentication.openshift.io/decision"
+	// BootstrapUserAnnotation is an annotation key for the first login of
+	// the bootstrap user with a password and for the reason a login of the
+	// bootstrap user with a valid password was rejected.
+	BootstrapUserAnnotation = "authentication.openshift.io/bootstrap-user"
+	// DegradedAnnotation is an annotation key for logins that succeeded
+	// without their identity provider, e.g. with cached credentials while
+	// the provider is unavailable.
+	DegradedAnnotation = "authentication.openshift.io/degraded"
+	// AnomalyAnnotation is an annotation key for the comma separated
+	// anomalies of logins, e.g. new-device for a login from a device the
+	// user did not log in from before.
+	AnomalyAnnotation = "authentication.openshift.io/anomaly"
 
 	// AllowDecision is logged on a successful authentication.
 	AllowDecision Decision = "allow"
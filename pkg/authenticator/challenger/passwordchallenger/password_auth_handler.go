@@ -8,7 +8,7 @@ import (
 )
 
 type basicPasswordAuthHandler struct {
-	realm string
+	challenge string
 }
 
 // CSRFTokenHeader must be passed when requesting a WWW-Authenticate Basic challenge to prevent CSRF attacks on browsers.
@@ -19,7 +19,14 @@ const CSRFTokenHeader = "X-CSRF-Token"
 
 // NewBasicAuthChallenger returns a AuthenticationChallenger that responds with a basic auth challenge for the supplied realm
 func NewBasicAuthChallenger(realm string) oauthhandlers.AuthenticationChallenger {
-	return &basicPasswordAuthHandler{realm}
+	return NewBasicAuthChallengerWithParameters(realm)
+}
+
+// NewBasicAuthChallengerWithParameters returns a AuthenticationChallenger that responds with a basic auth challenge for
+// the supplied realm, followed by the parameters, e.g. a URL that tells users where to reset their password
+func NewBasicAuthChallengerWithParameters(realm string, parameters ...oauthhandlers.ChallengeParameter) oauthhandlers.AuthenticationChallenger {
+	realmParameter := oauthhandlers.ChallengeParameter{Name: "realm", Value: realm}
+	return &basicPasswordAuthHandler{oauthhandlers.FormatChallenge("Basic", append([]oauthhandlers.ChallengeParameter{realmParameter}, parameters...)...)}
 }
 
 // AuthenticationChallenge returns a header that indicates a basic auth challenge for the supplied realm
@@ -36,7 +43,7 @@ func (h *basicPasswordAuthHandler) AuthenticationChallenge(req *http.Request) (h
 			),
 		)
 	} else {
-		headers.Add("WWW-Authenticate", fmt.Sprintf(`Basic realm="%s"`, h.realm))
+		headers.Add("WWW-Authenticate", h.challenge)
 	}
 
 	return headers, nil
@@ -5,6 +5,8 @@ import (
 	"net/http"
 	"strings"
 	"testing"
+
+	oauthhandlers "github.com/openshift/oauth-server/pkg/oauth/handlers"
 )
 
 func TestAuthChallengeNeeded(t *testing.T) {
@@ -47,3 +49,18 @@ func TestAuthChallengeWithoutCSRF(t *testing.T) {
 	}
 
 }
+
+func TestAuthChallengeWithParameters(t *testing.T) {
+	handler := NewBasicAuthChallengerWithParameters(`corp "ldap"`, oauthhandlers.ChallengeParameter{Name: "reset_url", Value: "https://sso.example.com/reset"})
+
+	req, _ := http.NewRequest("GET", "", nil)
+	req.Header.Set(CSRFTokenHeader, "1")
+	header, err := handler.AuthenticationChallenge(req)
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	expectedChallenge := `Basic realm="corp \"ldap\"", reset_url="https://sso.example.com/reset"`
+	if challenge := header.Get("WWW-Authenticate"); challenge != expectedChallenge {
+		t.Errorf("Expected %v, got %v", expectedChallenge, challenge)
+	}
+}
@@ -0,0 +1,29 @@
+package schemechallenger
+
+import (
+	"net/http"
+
+	"github.com/openshift/oauth-server/pkg/authenticator/challenger/passwordchallenger"
+	oauthhandlers "github.com/openshift/oauth-server/pkg/oauth/handlers"
+)
+
+type schemeChallenger struct {
+	challenge string
+}
+
+// New returns an AuthenticationChallenger that challenges with a fixed scheme, e.g. Negotiate for a proxy in front of
+// the server that authenticates users with Kerberos. Like basic challenges, the challenge is only sent to requests
+// with the X-CSRF-Token header, so that browsers do not answer it on their own.
+func New(scheme string, parameters ...oauthhandlers.ChallengeParameter) oauthhandlers.AuthenticationChallenger {
+	return &schemeChallenger{challenge: oauthhandlers.FormatChallenge(scheme, parameters...)}
+}
+
+// AuthenticationChallenge returns a header with the challenge of the scheme
+func (c *schemeChallenger) AuthenticationChallenge(req *http.Request) (http.Header, error) {
+	headers := http.Header{}
+	// the basic challenger warns about requests without the header
+	if len(req.Header.Get(passwordchallenger.CSRFTokenHeader)) > 0 {
+		headers.Add("WWW-Authenticate", c.challenge)
+	}
+	return headers, nil
+}
@@ -0,0 +1,27 @@
+package schemechallenger
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/openshift/oauth-server/pkg/authenticator/challenger/passwordchallenger"
+	oauthhandlers "github.com/openshift/oauth-server/pkg/oauth/handlers"
+)
+
+func TestSchemeChallenge(t *testing.T) {
+	handler := New("Negotiate")
+	req, _ := http.NewRequest("GET", "", nil)
+	if header, _ := handler.AuthenticationChallenge(req); len(header) != 0 {
+		t.Errorf("Unexpected challenge without CSRF header %v", header)
+	}
+
+	handler = New("Bearer", oauthhandlers.ChallengeParameter{Name: "realm", Value: "corp"}, oauthhandlers.ChallengeParameter{Name: "scope", Value: "user:full"})
+	req.Header.Set(passwordchallenger.CSRFTokenHeader, "1")
+	header, err := handler.AuthenticationChallenge(req)
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if expected, challenge := `Bearer realm="corp", scope="user:full"`, header.Get("WWW-Authenticate"); challenge != expected {
+		t.Errorf("Expected %v, got %v", expected, challenge)
+	}
+}
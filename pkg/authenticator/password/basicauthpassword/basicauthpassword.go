@@ -25,11 +25,15 @@ import (
 //   {"id":"userid"}
 // A successful response may also include name and/or email:
 //   {"id":"userid", "name": "User Name", "email":"user@example.com"}
+// and the groups of the user:
+//   {"id":"userid", "groups": ["admins", "developers"]}
+// Successful responses that do not match the schema of RemoteUserData are errors.
 type Authenticator struct {
 	providerName string
 	url          string
 	client       *http.Client
 	mapper       authapi.UserIdentityMapper
+	fields       Fields
 }
 
 // RemoteUserData holds user data returned from a remote basic-auth protected endpoint.
@@ -46,6 +50,8 @@ type RemoteUserData struct {
 	PreferredUsername string `json:"preferred_username"`
 	// Email is the end-User's preferred e-mail address. Optional.
 	Email string `json:"email"`
+	// Groups are the names of the groups the end-User is a member of. Optional.
+	Groups []string `json:"groups"`
 }
 
 // RemoteError holds error data returned from a remote authentication request
@@ -57,8 +63,9 @@ var RedirectAttemptedError = errors.New("Redirect attempted")
 
 // New returns an authenticator which will make a basic auth call to the given url.
 // A custom transport can be provided (typically to customize TLS options like trusted roots or present a client certificate).
-// If no transport is provided, http.DefaultTransport is used
-func New(providerName string, url string, transport http.RoundTripper, mapper authapi.UserIdentityMapper) openshiftauthenticator.PasswordAuthenticator {
+// If no transport is provided, http.DefaultTransport is used.
+// The names of the fields in successful responses can be customized, empty names use DefaultFields.
+func New(providerName string, url string, transport http.RoundTripper, mapper authapi.UserIdentityMapper, fields Fields) openshiftauthenticator.PasswordAuthenticator {
 	if transport == nil {
 		transport = http.DefaultTransport
 	}
@@ -70,11 +77,11 @@ func New(providerName string, url string, transport http.RoundTripper, mapper au
 		return RedirectAttemptedError
 	}
 
-	return &Authenticator{providerName, url, client, mapper}
+	return &Authenticator{providerName, url, client, mapper, fields}
 }
 
 func (a *Authenticator) AuthenticatePassword(ctx context.Context, username, password string) (*authenticator.Response, bool, error) {
-	req, err := http.NewRequest("GET", a.url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", a.url, nil)
 	if err != nil {
 		return nil, false, err
 	}
@@ -90,7 +97,7 @@ func (a *Authenticator) AuthenticatePassword(ctx context.Context, username, pass
 
 	resp, err := a.client.Do(req)
 	if err != nil {
-		return nil, false, err
+		return nil, false, authapi.NewIdentityProviderError(err)
 	}
 	defer resp.Body.Close()
 
@@ -100,30 +107,35 @@ func (a *Authenticator) AuthenticatePassword(ctx context.Context, username, pass
 
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return nil, false, err
+		return nil, false, authapi.NewIdentityProviderError(err)
 	}
 
 	remoteError := RemoteError{}
 	if err := json.Unmarshal(body, &remoteError); err != nil {
+		// the endpoint is unavailable, e.g. behind a proxy that does not answer with JSON
+		if resp.StatusCode >= http.StatusInternalServerError {
+			return nil, false, authapi.NewIdentityProviderError(fmt.Errorf("An error occurred while authenticating (%d)", resp.StatusCode))
+		}
 		return nil, false, err
 	}
 	if remoteError.Error != "" {
+		if resp.StatusCode >= http.StatusInternalServerError {
+			return nil, false, authapi.NewIdentityProviderError(errors.New(remoteError.Error))
+		}
 		return nil, false, errors.New(remoteError.Error)
 	}
 
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return nil, false, authapi.NewIdentityProviderError(fmt.Errorf("An error occurred while authenticating (%d)", resp.StatusCode))
+	}
 	if resp.StatusCode != http.StatusOK {
 		return nil, false, fmt.Errorf("An error occurred while authenticating (%d)", resp.StatusCode)
 	}
 
-	remoteUserData := RemoteUserData{}
-	err = json.Unmarshal(body, &remoteUserData)
+	remoteUserData, err := parseRemoteUserData(body, a.fields)
 	if err != nil {
 		return nil, false, err
 	}
-
-	if len(remoteUserData.Subject) == 0 {
-		return nil, false, errors.New("Could not retrieve user data")
-	}
 	identity := authapi.NewDefaultUserIdentityInfo(a.providerName, remoteUserData.Subject)
 
 	if len(remoteUserData.Name) > 0 {
@@ -135,6 +147,9 @@ func (a *Authenticator) AuthenticatePassword(ctx context.Context, username, pass
 	if len(remoteUserData.Email) > 0 {
 		identity.Extra[authapi.IdentityEmailKey] = remoteUserData.Email
 	}
+	if len(remoteUserData.Groups) > 0 {
+		identity.Extra[authapi.IdentityGroupsKey] = strings.Join(remoteUserData.Groups, ",")
+	}
 
 	return identitymapper.ResponseFor(a.mapper, identity)
 }
@@ -1,9 +1,17 @@
 package basicauthpassword
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
 	"testing"
+
+	"k8s.io/apiserver/pkg/authentication/user"
+
+	"github.com/openshift/oauth-server/pkg/api"
 )
 
 func TestUnmarshal(t *testing.T) {
@@ -43,3 +51,107 @@ func TestUnmarshal(t *testing.T) {
 	}
 
 }
+
+type testMapper struct {
+	identity api.UserIdentityInfo
+}
+
+func (m *testMapper) UserFor(identityInfo api.UserIdentityInfo) (user.Info, error) {
+	m.identity = identityInfo
+	return &user.DefaultInfo{Name: identityInfo.GetProviderUserName()}, nil
+}
+
+func TestAuthenticatePassword(t *testing.T) {
+	testcases := map[string]struct {
+		fields        Fields
+		response      string
+		expectErr     bool
+		expectSubject string
+		expectExtra   map[string]string
+	}{
+		"minimal": {
+			response:      `{"sub": "12345"}`,
+			expectSubject: "12345",
+			expectExtra:   map[string]string{},
+		},
+		"groups": {
+			response:      `{"sub": "12345", "preferred_username": "bob", "groups": ["admins", "developers"], "department": 42}`,
+			expectSubject: "12345",
+			expectExtra: map[string]string{
+				api.IdentityPreferredUsernameKey: "bob",
+				api.IdentityGroupsKey:            "admins,developers",
+			},
+		},
+		"custom fields": {
+			fields:        Fields{Subject: "uid", Email: "mail", Groups: "memberOf"},
+			response:      `{"uid": "12345", "sub": "ignored", "mail": "bob@example.com", "memberOf": ["admins"], "name": null}`,
+			expectSubject: "12345",
+			expectExtra: map[string]string{
+				api.IdentityEmailKey:  "bob@example.com",
+				api.IdentityGroupsKey: "admins",
+			},
+		},
+		"missing subject": {
+			response:  `{"name": "Bob"}`,
+			expectErr: true,
+		},
+		"numeric subject": {
+			response:  `{"sub": 12345}`,
+			expectErr: true,
+		},
+		"groups not an array": {
+			response:  `{"sub": "12345", "groups": "admins"}`,
+			expectErr: true,
+		},
+		"group not a string": {
+			response:  `{"sub": "12345", "groups": ["admins", 1]}`,
+			expectErr: true,
+		},
+		"group with comma": {
+			response:  `{"sub": "12345", "groups": ["cn=admins,dc=example"]}`,
+			expectErr: true,
+		},
+		"not an object": {
+			response:  `["12345"]`,
+			expectErr: true,
+		},
+	}
+
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if username, password, ok := r.BasicAuth(); !ok || username != "bob" || password != "secret" {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				fmt.Fprint(w, tc.response)
+			}))
+			defer server.Close()
+
+			mapper := &testMapper{}
+			auth := New("basicauth", server.URL, nil, mapper, tc.fields)
+
+			_, ok, err := auth.AuthenticatePassword(context.TODO(), "bob", "wrong")
+			if ok || err != nil {
+				t.Fatalf("expected failed login with wrong password, got %v %v", ok, err)
+			}
+
+			_, ok, err = auth.AuthenticatePassword(context.TODO(), "bob", "secret")
+			if tc.expectErr {
+				if err == nil {
+					t.Fatalf("expected error, got none")
+				}
+				return
+			}
+			if err != nil || !ok {
+				t.Fatalf("expected successful login, got %v %v", ok, err)
+			}
+			if mapper.identity.GetProviderUserName() != tc.expectSubject {
+				t.Errorf("expected subject %q, got %q", tc.expectSubject, mapper.identity.GetProviderUserName())
+			}
+			if !reflect.DeepEqual(mapper.identity.GetExtra(), tc.expectExtra) {
+				t.Errorf("expected extra %v, got %v", tc.expectExtra, mapper.identity.GetExtra())
+			}
+		})
+	}
+}
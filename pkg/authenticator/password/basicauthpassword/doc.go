@@ -1,3 +1,13 @@
 // Package basicauthpassword implements authenticator.Password by making a BasicAuth call
 // to a remote endpoint and extracting user information from a JSON response.
+//
+// A successful response must be a JSON object with the following fields, whose names can be customized:
+//
+//	sub                 string, required    identifier of the user
+//	name                string, optional    display name
+//	preferred_username  string, optional    preferred username
+//	email               string, optional    email address
+//	groups              []string, optional  names of the groups of the user, must not contain commas
+//
+// Other fields are ignored. Responses that do not match are rejected with an error.
 package basicauthpassword
@@ -0,0 +1,130 @@
+package basicauthpassword
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Fields holds the names of the fields of a successful response.
+// Empty names default to the names documented on RemoteUserData.
+type Fields struct {
+	Subject           string
+	Name              string
+	PreferredUsername string
+	Email             string
+	Groups            string
+}
+
+// DefaultFields are the documented field names of a successful response
+var DefaultFields = Fields{
+	Subject:           "sub",
+	Name:              "name",
+	PreferredUsername: "preferred_username",
+	Email:             "email",
+	Groups:            "groups",
+}
+
+func (f Fields) withDefaults() Fields {
+	if len(f.Subject) == 0 {
+		f.Subject = DefaultFields.Subject
+	}
+	if len(f.Name) == 0 {
+		f.Name = DefaultFields.Name
+	}
+	if len(f.PreferredUsername) == 0 {
+		f.PreferredUsername = DefaultFields.PreferredUsername
+	}
+	if len(f.Email) == 0 {
+		f.Email = DefaultFields.Email
+	}
+	if len(f.Groups) == 0 {
+		f.Groups = DefaultFields.Groups
+	}
+	return f
+}
+
+// SchemaError is returned if a successful response does not match the documented schema
+type SchemaError struct {
+	Field  string
+	Reason string
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("invalid user data in response: field %q %s", e.Field, e.Reason)
+}
+
+// parseRemoteUserData validates a successful response and extracts the user data using the given field names.
+// The response must be a JSON object. The subject is a required non-empty string, name, preferred username
+// and email are optional strings and groups is an optional array of strings. Other fields are ignored.
+func parseRemoteUserData(body []byte, fields Fields) (*RemoteUserData, error) {
+	fields = fields.withDefaults()
+
+	data := map[string]interface{}{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&data); err != nil {
+		return nil, fmt.Errorf("invalid user data in response: %v", err)
+	}
+
+	userData := &RemoteUserData{}
+	var err error
+	if userData.Subject, err = stringField(data, fields.Subject); err != nil {
+		return nil, err
+	}
+	if len(userData.Subject) == 0 {
+		return nil, &SchemaError{Field: fields.Subject, Reason: "is required"}
+	}
+	if userData.Name, err = stringField(data, fields.Name); err != nil {
+		return nil, err
+	}
+	if userData.PreferredUsername, err = stringField(data, fields.PreferredUsername); err != nil {
+		return nil, err
+	}
+	if userData.Email, err = stringField(data, fields.Email); err != nil {
+		return nil, err
+	}
+	if userData.Groups, err = groupsField(data, fields.Groups); err != nil {
+		return nil, err
+	}
+	return userData, nil
+}
+
+// stringField returns the value of an optional string field, null is treated as absent
+func stringField(data map[string]interface{}, field string) (string, error) {
+	value, ok := data[field]
+	if !ok || value == nil {
+		return "", nil
+	}
+	s, ok := value.(string)
+	if !ok {
+		return "", &SchemaError{Field: field, Reason: fmt.Sprintf("must be a string, got %T", value)}
+	}
+	return s, nil
+}
+
+// groupsField returns the value of an optional array of group names, null is treated as absent
+func groupsField(data map[string]interface{}, field string) ([]string, error) {
+	value, ok := data[field]
+	if !ok || value == nil {
+		return nil, nil
+	}
+	values, ok := value.([]interface{})
+	if !ok {
+		return nil, &SchemaError{Field: field, Reason: fmt.Sprintf("must be an array of strings, got %T", value)}
+	}
+	groups := make([]string, 0, len(values))
+	for i, v := range values {
+		group, ok := v.(string)
+		if !ok {
+			return nil, &SchemaError{Field: field, Reason: fmt.Sprintf("must be an array of strings, got %T at index %d", v, i)}
+		}
+		// groups are passed on as a comma-separated list
+		if len(group) == 0 || strings.Contains(group, ",") {
+			return nil, &SchemaError{Field: field, Reason: fmt.Sprintf("contains invalid group name %q", group)}
+		}
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
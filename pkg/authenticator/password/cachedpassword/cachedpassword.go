@@ -0,0 +1,123 @@
+// Package cachedpassword lets password logins succeed while their identity provider is unavailable, by remembering
+// a salted hash of the credentials of successful logins for a bounded time.
+package cachedpassword
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"k8s.io/apimachinery/pkg/util/cache"
+	kaudit "k8s.io/apiserver/pkg/audit"
+	"k8s.io/apiserver/pkg/authentication/authenticator"
+	"k8s.io/klog/v2"
+
+	authapi "github.com/openshift/oauth-server/pkg/api"
+	"github.com/openshift/oauth-server/pkg/audit"
+	openshiftauthenticator "github.com/openshift/oauth-server/pkg/authenticator"
+)
+
+const (
+	// DefaultMaxAge is how long credentials are remembered after a successful login by default
+	DefaultMaxAge = time.Hour
+	// DefaultMaxEntries is the number of users whose credentials are remembered by default
+	DefaultMaxEntries = 1000
+
+	// DegradedReason is the value of the degraded audit annotation of logins with cached credentials
+	DegradedReason = "cached-credentials"
+
+	// the argon2id parameters of the hashes, which only live in memory
+	saltLength  = 16
+	keyLength   = 32
+	hashTime    = 2
+	hashMemory  = 19 * 1024
+	hashThreads = 1
+)
+
+// entry holds the credentials and the response of a successful login
+type entry struct {
+	salt     []byte
+	key      []byte
+	response *authenticator.Response
+}
+
+// Credentials remembers the credentials of successful logins, it can be shared by the password authenticators of a
+// provider, e.g. the one of the login form and the one of basic auth headers
+type Credentials struct {
+	maxAge  time.Duration
+	entries *cache.LRUExpireCache
+}
+
+// NewCredentials returns Credentials that remember the credentials of at most maxEntries users for maxAge after their
+// last successful login. The least recently used ones are forgotten first.
+func NewCredentials(maxAge time.Duration, maxEntries int) *Credentials {
+	return &Credentials{maxAge: maxAge, entries: cache.NewLRUExpireCache(maxEntries)}
+}
+
+type cachedPassword struct {
+	providerName string
+	delegate     openshiftauthenticator.PasswordAuthenticator
+	credentials  *Credentials
+}
+
+// New returns a password authenticator that remembers the credentials of successful logins of delegate. While
+// delegate fails with an authapi.IdentityProviderError, logins with remembered credentials succeed with the response
+// of the remembered login, and the audit event of the request is annotated as degraded.
+func New(providerName string, delegate openshiftauthenticator.PasswordAuthenticator, credentials *Credentials) openshiftauthenticator.PasswordAuthenticator {
+	return &cachedPassword{
+		providerName: providerName,
+		delegate:     delegate,
+		credentials:  credentials,
+	}
+}
+
+func (a *cachedPassword) AuthenticatePassword(ctx context.Context, username, password string) (*authenticator.Response, bool, error) {
+	response, ok, err := a.delegate.AuthenticatePassword(ctx, username, password)
+	switch {
+	case err == nil && ok:
+		a.credentials.remember(username, password, response)
+	case err == nil:
+		// the credentials are not valid anymore, e.g. because the password was changed
+		a.credentials.entries.Remove(username)
+	case errors.As(err, &authapi.IdentityProviderError{}):
+		if cached, ok := a.credentials.recall(username, password); ok {
+			klog.Warningf("Logged in %q with cached credentials while identity provider %q is unavailable: %v", username, a.providerName, err)
+			kaudit.AddAuditAnnotation(ctx, audit.DegradedAnnotation, DegradedReason)
+			return cached, true, nil
+		}
+	}
+	return response, ok, err
+}
+
+// remember stores a salted hash of the credentials with the response of their login
+func (c *Credentials) remember(username, password string, response *authenticator.Response) {
+	if len(username) == 0 || len(password) == 0 {
+		return
+	}
+	salt := make([]byte, saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		klog.Errorf("Unable to cache the credentials of %q: %v", username, err)
+		return
+	}
+	c.entries.Add(username, &entry{salt: salt, key: hash(password, salt), response: response}, c.maxAge)
+}
+
+// recall returns the response of the last login with the credentials, if they are remembered
+func (c *Credentials) recall(username, password string) (*authenticator.Response, bool) {
+	value, ok := c.entries.Get(username)
+	if !ok {
+		return nil, false
+	}
+	cached := value.(*entry)
+	if subtle.ConstantTimeCompare(hash(password, cached.salt), cached.key) != 1 {
+		return nil, false
+	}
+	return cached.response, true
+}
+
+func hash(password string, salt []byte) []byte {
+	return argon2.IDKey([]byte(password), salt, hashTime, hashMemory, hashThreads, keyLength)
+}
@@ -0,0 +1,146 @@
+package cachedpassword
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/cache"
+	apiaudit "k8s.io/apiserver/pkg/apis/audit"
+	kaudit "k8s.io/apiserver/pkg/audit"
+	"k8s.io/apiserver/pkg/authentication/authenticator"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+
+	authapi "github.com/openshift/oauth-server/pkg/api"
+	"github.com/openshift/oauth-server/pkg/audit"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+// fakeAuthenticator accepts the password of its users, or fails with err
+type fakeAuthenticator struct {
+	users map[string]string
+	err   error
+}
+
+func (a *fakeAuthenticator) AuthenticatePassword(ctx context.Context, username, password string) (*authenticator.Response, bool, error) {
+	if a.err != nil {
+		return nil, false, a.err
+	}
+	if expected, ok := a.users[username]; !ok || expected != password {
+		return nil, false, nil
+	}
+	return &authenticator.Response{User: &user.DefaultInfo{Name: username}}, true, nil
+}
+
+func TestAuthenticatePassword(t *testing.T) {
+	outage := authapi.NewIdentityProviderError(errors.New("connection refused"))
+
+	testCases := map[string]struct {
+		// Login is the password of a login before the outage, if any
+		Login string
+		// Elapsed is the time between that login and the outage
+		Elapsed time.Duration
+		// Rejected makes the provider reject the credentials after the login, e.g. because the password was changed
+		Rejected bool
+		// Err is the error of the provider during the outage
+		Err      error
+		Password string
+
+		ExpectedOK       bool
+		ExpectedErr      bool
+		ExpectedDegraded bool
+	}{
+		"remembered credentials": {
+			Login:            "secret",
+			Err:              outage,
+			Password:         "secret",
+			ExpectedOK:       true,
+			ExpectedDegraded: true,
+		},
+		"wrong password": {
+			Login:       "secret",
+			Err:         outage,
+			Password:    "guess",
+			ExpectedErr: true,
+		},
+		"no login": {
+			Err:         outage,
+			Password:    "secret",
+			ExpectedErr: true,
+		},
+		"expired": {
+			Login:       "secret",
+			Elapsed:     2 * time.Hour,
+			Err:         outage,
+			Password:    "secret",
+			ExpectedErr: true,
+		},
+		"rejected credentials": {
+			Login:       "secret",
+			Rejected:    true,
+			Err:         outage,
+			Password:    "secret",
+			ExpectedErr: true,
+		},
+		"not an outage": {
+			Login:       "secret",
+			Err:         errors.New("misconfigured"),
+			Password:    "secret",
+			ExpectedErr: true,
+		},
+	}
+
+	for k, testCase := range testCases {
+		t.Run(k, func(t *testing.T) {
+			clock := &fakeClock{now: time.Now()}
+			credentials := &Credentials{maxAge: time.Hour, entries: cache.NewLRUExpireCacheWithClock(10, clock)}
+			delegate := &fakeAuthenticator{users: map[string]string{"bob": testCase.Login}}
+			auth := New("idp", delegate, credentials)
+
+			if len(testCase.Login) > 0 {
+				if _, ok, err := auth.AuthenticatePassword(context.TODO(), "bob", testCase.Login); !ok || err != nil {
+					t.Fatalf("expected login, got %v, %v", ok, err)
+				}
+			}
+			if testCase.Rejected {
+				delegate.users = nil
+				if _, ok, err := auth.AuthenticatePassword(context.TODO(), "bob", testCase.Login); ok || err != nil {
+					t.Fatalf("expected rejection, got %v, %v", ok, err)
+				}
+			}
+			clock.now = clock.now.Add(testCase.Elapsed)
+			delegate.err = testCase.Err
+
+			req := httptest.NewRequest("POST", "/login", nil)
+			req = req.WithContext(kaudit.WithAuditAnnotations(req.Context()))
+			response, ok, err := auth.AuthenticatePassword(req.Context(), "bob", testCase.Password)
+			if ok != testCase.ExpectedOK {
+				t.Errorf("expected ok=%v, got %v", testCase.ExpectedOK, ok)
+			}
+			if (err != nil) != testCase.ExpectedErr {
+				t.Errorf("expected error=%v, got %v", testCase.ExpectedErr, err)
+			}
+			if ok && response.User.GetName() != "bob" {
+				t.Errorf("expected the remembered user, got %#v", response.User)
+			}
+
+			ev, err := kaudit.NewEventFromRequest(req, time.Now(), apiaudit.LevelMetadata, &authorizer.AttributesRecord{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if degraded := ev.Annotations[audit.DegradedAnnotation] == DegradedReason; degraded != testCase.ExpectedDegraded {
+				t.Errorf("expected degraded=%v, got annotations %v", testCase.ExpectedDegraded, ev.Annotations)
+			}
+		})
+	}
+}
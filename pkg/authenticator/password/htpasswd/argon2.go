@@ -0,0 +1,57 @@
+package htpasswd
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// maxArgon2Memory bounds the memory in KiB a single hash may request, so that a
+// malformed htpasswd file cannot make logins allocate unbounded amounts of memory
+const maxArgon2Memory = 1024 * 1024
+
+// testArgon2idPassword checks a password against a hash in the PHC string format
+// produced by the reference implementation and most tools, e.g.
+// $argon2id$v=19$m=65536,t=3,p=4$c29tZXNhbHQ$RdescudvJCsgt3ub+b+dWRWJTmaaJObG
+func testArgon2idPassword(password, hash string) (bool, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return false, errors.New("Malformed argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return false, fmt.Errorf("Malformed argon2id hash: invalid version: %v", err)
+	}
+	if version != argon2.Version {
+		return false, fmt.Errorf("Unsupported argon2id version %d", version)
+	}
+
+	var memory, time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return false, fmt.Errorf("Malformed argon2id hash: invalid parameters: %v", err)
+	}
+	if memory > maxArgon2Memory {
+		return false, fmt.Errorf("argon2id memory parameter %d exceeds the maximum of %d", memory, maxArgon2Memory)
+	}
+	if time == 0 || threads == 0 {
+		return false, errors.New("Malformed argon2id hash: invalid parameters")
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil || len(salt) == 0 {
+		return false, errors.New("Malformed argon2id hash: invalid salt")
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return false, errors.New("Malformed argon2id hash: invalid hash")
+	}
+
+	testkey := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(testkey, key) == 1, nil
+}
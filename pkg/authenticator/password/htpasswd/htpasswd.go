@@ -4,10 +4,14 @@ import (
 	"bufio"
 	"context"
 	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
 	"errors"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"golang.org/x/crypto/bcrypt"
 	"k8s.io/klog/v2"
@@ -23,13 +27,27 @@ import (
 type Authenticator struct {
 	providerName string
 	file         string
-	fileInfo     os.FileInfo
 	mapper       authapi.UserIdentityMapper
-	usernames    map[string]string
+
+	// usernames holds the map[string]string of usernames to password hashes.
+	// It is replaced as a whole when the file changes.
+	usernames atomic.Value
+	// watching is set while Run watches the file, otherwise the file is checked for changes on every login
+	watching int32
+
+	// loadLock serializes loads and guards fileInfo and onReload
+	loadLock sync.Mutex
+	fileInfo os.FileInfo
+	onReload func()
+
+	// credentialsVersion adds the version of the password hash to identities
+	credentialsVersion int32
 }
 
+var _ openshiftauthenticator.PasswordAuthenticator = &Authenticator{}
+
 // New returns an authenticator which will validate usernames and passwords against the given htpasswd file
-func New(providerName string, file string, mapper authapi.UserIdentityMapper) (openshiftauthenticator.PasswordAuthenticator, error) {
+func New(providerName string, file string, mapper authapi.UserIdentityMapper) (*Authenticator, error) {
 	auth := &Authenticator{
 		providerName: providerName,
 		file:         file,
@@ -42,8 +60,10 @@ func New(providerName string, file string, mapper authapi.UserIdentityMapper) (o
 }
 
 func (a *Authenticator) AuthenticatePassword(ctx context.Context, username, password string) (*authenticator.Response, bool, error) {
-	if err := a.loadIfNeeded(); err != nil {
-		return nil, false, err
+	if atomic.LoadInt32(&a.watching) == 0 {
+		if err := a.loadIfNeeded(); err != nil {
+			return nil, false, err
+		}
 	}
 
 	if len(username) > 255 {
@@ -52,7 +72,7 @@ func (a *Authenticator) AuthenticatePassword(ctx context.Context, username, pass
 	if strings.Contains(username, ":") {
 		return nil, false, errors.New("Usernames may not contain : characters")
 	}
-	hash, ok := a.usernames[username]
+	hash, ok := a.usernames.Load().(map[string]string)[username]
 	if !ok {
 		return nil, false, nil
 	}
@@ -61,6 +81,9 @@ func (a *Authenticator) AuthenticatePassword(ctx context.Context, username, pass
 	}
 
 	identity := authapi.NewDefaultUserIdentityInfo(a.providerName, username)
+	if atomic.LoadInt32(&a.credentialsVersion) != 0 {
+		identity.Extra[authapi.IdentityCredentialsVersionKey] = credentialsVersion(hash)
+	}
 
 	return identitymapper.ResponseFor(a.mapper, identity)
 }
@@ -74,6 +97,7 @@ func (a *Authenticator) load() error {
 
 	newusernames := map[string]string{}
 	warnedusernames := map[string]bool{}
+	legacyusernames := 0
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
 		line := scanner.Text()
@@ -97,39 +121,77 @@ func (a *Authenticator) load() error {
 			}
 		}
 		newusernames[username] = password
+		if isLegacyHash(password) {
+			legacyusernames++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if legacyusernames > 0 {
+		klog.Warningf("%s contains %d users with MD5 or SHA-1 password hashes, use bcrypt or argon2id instead", a.file, legacyusernames)
 	}
 
-	a.usernames = newusernames
+	// swap the whole map so that concurrent logins see either the old or the new file
+	a.usernames.Store(newusernames)
 
 	return nil
 }
 
 func (a *Authenticator) loadIfNeeded() error {
+	a.loadLock.Lock()
+	defer a.loadLock.Unlock()
+
 	info, err := os.Stat(a.file)
 	if err != nil {
 		return err
 	}
-	if a.fileInfo == nil || a.fileInfo.ModTime() != info.ModTime() {
+	if a.fileInfo == nil || a.fileInfo.ModTime() != info.ModTime() || a.fileInfo.Size() != info.Size() {
 		klog.V(4).Infof("Loading htpasswd file %s...", a.file)
-		loadingErr := a.load()
-		if loadingErr != nil {
+		if err := a.load(); err != nil {
 			return err
 		}
 
+		reloaded := a.fileInfo != nil
 		a.fileInfo = info
+		if reloaded && a.onReload != nil {
+			a.onReload()
+		}
 		return nil
 	}
 	return nil
 }
 
+// OnReload sets a function that is called whenever the file was reloaded after a change
+func (a *Authenticator) OnReload(f func()) {
+	a.loadLock.Lock()
+	defer a.loadLock.Unlock()
+	a.onReload = f
+}
+
+// ReportCredentialsVersion adds a version to identities that changes whenever the password hash of the user changes
+func (a *Authenticator) ReportCredentialsVersion() {
+	atomic.StoreInt32(&a.credentialsVersion, 1)
+}
+
+// credentialsVersion derives a version from the password hash that does not reveal the hash
+func credentialsVersion(hash string) string {
+	sum := sha256.Sum256([]byte(hash))
+	return base64.RawURLEncoding.EncodeToString(sum[:16])
+}
+
 func testPassword(password, hash string) (bool, error) {
 	switch {
 	case strings.HasPrefix(hash, "$apr1$"):
 		// MD5, default
 		return testMD5Password(password, hash)
-	case strings.HasPrefix(hash, "$2y$") || strings.HasPrefix(hash, "$2a$"):
+	case strings.HasPrefix(hash, "$2y$") || strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$"):
 		// Bcrypt, secure
 		return testBCryptPassword(password, hash)
+	case strings.HasPrefix(hash, "$argon2id$"):
+		// Argon2id, secure
+		return testArgon2idPassword(password, hash)
 	case strings.HasPrefix(hash, "{SHA}"):
 		// SHA-1, insecure
 		return testSHAPassword(password, hash[5:])
@@ -141,6 +203,11 @@ func testPassword(password, hash string) (bool, error) {
 	}
 }
 
+// isLegacyHash returns true for hash types that are too fast to compute to be considered secure
+func isLegacyHash(hash string) bool {
+	return strings.HasPrefix(hash, "$apr1$") || strings.HasPrefix(hash, "{SHA}")
+}
+
 func testSHAPassword(password, hash string) (bool, error) {
 	if len(hash) == 0 {
 		return false, errors.New("Invalid SHA hash")
@@ -150,7 +217,7 @@ func testSHAPassword(password, hash string) (bool, error) {
 	// Base-64 encode
 	base64shasum := base64.StdEncoding.EncodeToString(shasum[:])
 	// Compare
-	match := hash == base64shasum
+	match := subtle.ConstantTimeCompare([]byte(hash), []byte(base64shasum)) == 1
 	return match, nil
 }
 
@@ -184,8 +251,8 @@ func testMD5Password(password, hash string) (bool, error) {
 		return false, errors.New("Malformed MD5 hash: missing hash")
 	}
 
-	testhash := string(aprMD5([]byte(password), []byte(salt)))
-	match := testhash == hash
+	testhash := aprMD5([]byte(password), []byte(salt))
+	match := subtle.ConstantTimeCompare(testhash, []byte(hash)) == 1
 
 	return match, nil
 }
@@ -1,6 +1,18 @@
 package htpasswd
 
-import "testing"
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apiserver/pkg/authentication/user"
+
+	"github.com/openshift/oauth-server/pkg/api"
+)
 
 func TestPasswordHashes(t *testing.T) {
 	testCases := []struct {
@@ -170,6 +182,50 @@ func TestPasswordHashes(t *testing.T) {
 			Match:    false,
 			Error:    true,
 		},
+		{
+			Name:     "bcrypt 2b match",
+			Password: "password",
+			Hash:     "$2b$10$Fk32bQky/.91nbecGjFfPO1m97V12d.ickjAzpNF22NgMKs4qWDOK",
+			Match:    true,
+			Error:    false,
+		},
+
+		// argon2 -id -t 1 -m 16 -p 2 -l 32, salt "somesaltsomesalt"
+		{
+			Name:     "argon2id match",
+			Password: "password",
+			Hash:     "$argon2id$v=19$m=65536,t=1,p=2$c29tZXNhbHRzb21lc2FsdA$Wbaud+1CKXSqBM+9gZ9ezQMMSoH9wJldExiNfAg3iKY",
+			Match:    true,
+			Error:    false,
+		},
+		{
+			Name:     "argon2id mismatch",
+			Password: "mypassword",
+			Hash:     "$argon2id$v=19$m=65536,t=1,p=2$c29tZXNhbHRzb21lc2FsdA$Wbaud+1CKXSqBM+9gZ9ezQMMSoH9wJldExiNfAg3iKY",
+			Match:    false,
+			Error:    false,
+		},
+		{
+			Name:     "argon2id unsupported version",
+			Password: "password",
+			Hash:     "$argon2id$v=16$m=65536,t=1,p=2$c29tZXNhbHRzb21lc2FsdA$Wbaud+1CKXSqBM+9gZ9ezQMMSoH9wJldExiNfAg3iKY",
+			Match:    false,
+			Error:    true,
+		},
+		{
+			Name:     "argon2id excessive memory",
+			Password: "password",
+			Hash:     "$argon2id$v=19$m=4294967295,t=1,p=2$c29tZXNhbHRzb21lc2FsdA$Wbaud+1CKXSqBM+9gZ9ezQMMSoH9wJldExiNfAg3iKY",
+			Match:    false,
+			Error:    true,
+		},
+		{
+			Name:     "argon2id missing salt",
+			Password: "password",
+			Hash:     "$argon2id$v=19$m=65536,t=1,p=2$$Wbaud+1CKXSqBM+9gZ9ezQMMSoH9wJldExiNfAg3iKY",
+			Match:    false,
+			Error:    true,
+		},
 	}
 
 	for _, testCase := range testCases {
@@ -182,3 +238,110 @@ func TestPasswordHashes(t *testing.T) {
 		}
 	}
 }
+
+type testMapper struct{}
+
+func (testMapper) UserFor(identity api.UserIdentityInfo) (user.Info, error) {
+	return &user.DefaultInfo{Name: identity.GetProviderUserName()}, nil
+}
+
+func TestReload(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		watch bool
+	}{
+		{name: "watched", watch: true},
+		{name: "checked on login", watch: false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "htpasswd")
+			// htpasswd -n -b "username" "password"
+			writeFile := func(username string) {
+				if err := ioutil.WriteFile(file+".tmp", []byte(username+":$apr1$6TMtuxUJ$0M76TkGjp0qVg/e7rfk22.\n"), 0600); err != nil {
+					t.Fatal(err)
+				}
+				// replace the file like a secret volume update would
+				if err := os.Rename(file+".tmp", file); err != nil {
+					t.Fatal(err)
+				}
+			}
+			writeFile("alice")
+
+			auth, err := New("htpasswd", file, testMapper{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.watch {
+				stopCh := make(chan struct{})
+				defer close(stopCh)
+				go auth.Run(10*time.Millisecond, stopCh)
+			}
+
+			login := func(username string) bool {
+				_, ok, err := auth.AuthenticatePassword(context.TODO(), username, "password")
+				if err != nil {
+					t.Fatal(err)
+				}
+				return ok
+			}
+			if !login("alice") {
+				t.Fatalf("expected alice to log in")
+			}
+
+			// make sure the modification time changes on filesystems with coarse timestamps
+			time.Sleep(10 * time.Millisecond)
+			writeFile("bob")
+			if err := wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+				return login("bob"), nil
+			}); err != nil {
+				t.Fatalf("bob was not able to log in after reload: %v", err)
+			}
+			if login("alice") {
+				t.Errorf("expected alice to be removed")
+			}
+		})
+	}
+}
+
+type recordingMapper struct {
+	identity api.UserIdentityInfo
+}
+
+func (m *recordingMapper) UserFor(identity api.UserIdentityInfo) (user.Info, error) {
+	m.identity = identity
+	return &user.DefaultInfo{Name: identity.GetProviderUserName()}, nil
+}
+
+func TestCredentialsVersion(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "htpasswd")
+	// htpasswd -n -b "username" "password", with the same hash for both users
+	if err := ioutil.WriteFile(file, []byte("alice:$apr1$6TMtuxUJ$0M76TkGjp0qVg/e7rfk22.\nbob:$apr1$6TMtuxUJ$0M76TkGjp0qVg/e7rfk22.\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	mapper := &recordingMapper{}
+	auth, err := New("htpasswd", file, mapper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	version := func(username string) string {
+		if _, ok, err := auth.AuthenticatePassword(context.TODO(), username, "password"); !ok || err != nil {
+			t.Fatalf("expected %s to log in, got %v", username, err)
+		}
+		return mapper.identity.GetExtra()[api.IdentityCredentialsVersionKey]
+	}
+
+	if v := version("alice"); len(v) > 0 {
+		t.Errorf("expected no credentials version unless reported, got %q", v)
+	}
+	auth.ReportCredentialsVersion()
+	alice := version("alice")
+	if len(alice) == 0 || alice != version("alice") {
+		t.Errorf("expected a stable credentials version, got %q", alice)
+	}
+	if alice != version("bob") {
+		t.Errorf("expected the version to only depend on the password hash")
+	}
+	if alice == "$apr1$6TMtuxUJ$0M76TkGjp0qVg/e7rfk22." {
+		t.Errorf("expected the version to not reveal the hash")
+	}
+}
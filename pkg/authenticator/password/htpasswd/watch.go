@@ -0,0 +1,63 @@
+package htpasswd
+
+import (
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/klog/v2"
+)
+
+// DefaultPollInterval is how often a watched htpasswd file is checked for changes
+// in case filesystem notifications are unavailable or missed
+const DefaultPollInterval = time.Minute
+
+// Run watches the htpasswd file and reloads it when it changes, until stopCh is closed.
+// Changes are detected using filesystem notifications and, as a fallback, by checking
+// the file every pollInterval. While Run is active, logins no longer check the file.
+func (a *Authenticator) Run(pollInterval time.Duration, stopCh <-chan struct{}) {
+	atomic.StoreInt32(&a.watching, 1)
+	defer atomic.StoreInt32(&a.watching, 0)
+
+	var events <-chan fsnotify.Event
+	var errs <-chan error
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		klog.Warningf("Unable to watch htpasswd file %s, falling back to polling: %v", a.file, err)
+	} else {
+		defer watcher.Close()
+		// watch the directory, mounted secrets and editors replace the file instead of writing to it
+		if err := watcher.Add(filepath.Dir(a.file)); err != nil {
+			klog.Warningf("Unable to watch htpasswd file %s, falling back to polling: %v", a.file, err)
+		} else {
+			events, errs = watcher.Events, watcher.Errors
+		}
+	}
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopCh:
+			return
+		case event := <-events:
+			klog.V(5).Infof("htpasswd watch event %v", event)
+			a.reload()
+		case err := <-errs:
+			utilruntime.HandleError(err)
+		case <-ticker.C:
+			a.reload()
+		}
+	}
+}
+
+// reload loads the file if it changed, keeping the current credentials if that fails
+func (a *Authenticator) reload() {
+	if err := a.loadIfNeeded(); err != nil {
+		klog.Warningf("Error reloading htpasswd file %s, keeping previous credentials: %v", a.file, err)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"runtime/debug"
+	"strings"
 
 	"github.com/gophercloud/gophercloud"
 	"github.com/gophercloud/gophercloud/openstack"
@@ -19,6 +20,39 @@ import (
 	"github.com/openshift/oauth-server/pkg/authenticator/identitymapper"
 )
 
+const (
+	// ApplicationCredentialPrefix marks usernames that are Keystone application credential IDs.
+	// The password is used as the application credential secret.
+	ApplicationCredentialPrefix = "applicationcredential:"
+
+	// IdentityUserDomainKey is the key for the name of the Keystone domain of the user in an identity's Extra map
+	IdentityUserDomainKey = "keystone_user_domain"
+	// IdentityProjectKey is the key for the name of the Keystone project the login was scoped to in an identity's Extra map
+	IdentityProjectKey = "keystone_project"
+	// IdentityDomainKey is the key for the name of the Keystone domain the login was scoped to in an identity's Extra map.
+	// For project scoped logins this is the domain of the project.
+	IdentityDomainKey = "keystone_domain"
+)
+
+// Options holds optional settings of the Keystone authenticator
+type Options struct {
+	// ScopeDomainName requests a token scoped to the named domain, so that only users
+	// with a role on that domain can log in. If ScopeProjectName is also set, it is
+	// the domain of the project instead.
+	ScopeDomainName string
+	// ScopeProjectName requests a token scoped to the named project, so that only users
+	// with a role on that project can log in. The project is looked up in ScopeDomainName,
+	// or the domain of the users if that is empty.
+	ScopeProjectName string
+	// ApplicationCredentials allows logins with application credentials, using
+	// ApplicationCredentialPrefix followed by the credential ID as username and
+	// the secret as password. The identity is that of the owner of the credential.
+	// Application credentials are bound to the project they were created in, so with
+	// ScopeProjectName only credentials of that project can log in, and with only
+	// ScopeDomainName none can.
+	ApplicationCredentials bool
+}
+
 // keystonePasswordAuthenticator uses OpenStack keystone to authenticate a user by password
 type keystonePasswordAuthenticator struct {
 	providerName        string
@@ -27,39 +61,111 @@ type keystonePasswordAuthenticator struct {
 	domainName          string
 	identityMapper      authapi.UserIdentityMapper
 	useKeystoneIdentity bool
+	options             Options
 }
 
 // New creates a new password authenticator that uses OpenStack keystone to authenticate a user by password
 // A custom transport can be provided (typically to customize TLS options like trusted roots or present a client certificate).
 // If no transport is provided, http.DefaultTransport is used
-func New(providerName string, url string, transport http.RoundTripper, domainName string, identityMapper authapi.UserIdentityMapper, useKeystoneIdentity bool) openshiftauthenticator.PasswordAuthenticator {
+func New(providerName string, url string, transport http.RoundTripper, domainName string, identityMapper authapi.UserIdentityMapper, useKeystoneIdentity bool, options Options) openshiftauthenticator.PasswordAuthenticator {
 	if transport == nil {
 		transport = http.DefaultTransport
 	}
 	client := &http.Client{Transport: transport}
-	return &keystonePasswordAuthenticator{providerName, url, client, domainName, identityMapper, useKeystoneIdentity}
+	return &keystonePasswordAuthenticator{providerName, url, client, domainName, identityMapper, useKeystoneIdentity, options}
 }
 
-// Authenticate user and return his Keystone ID
-func getUserIDv3(client *gophercloud.ProviderClient, options tokens3.AuthOptionsBuilder, eo gophercloud.EndpointOpts) (string, error) {
+// keystoneLogin describes the user and scope of an issued token
+type keystoneLogin struct {
+	user    *tokens3.User
+	project *tokens3.Project
+	domain  *tokens3.Domain
+}
+
+// Authenticate user and return the user and scope of the issued token
+func login(client *gophercloud.ProviderClient, options tokens3.AuthOptionsBuilder, eo gophercloud.EndpointOpts) (*keystoneLogin, error) {
 	// Override the generated service endpoint with the one returned by the version endpoint.
 	v3Client, err := openstack.NewIdentityV3(client, eo)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	// Issue new unscoped token
+	// Issue new token, unscoped unless a scope is requested
 	result := tokens3.Create(v3Client, options)
 	if result.Err != nil {
-		return "", result.Err
+		return nil, result.Err
 	}
 
 	user, err := result.ExtractUser()
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	project, err := result.ExtractProject()
+	if err != nil {
+		return nil, err
+	}
+	domain, err := result.ExtractDomain()
+	if err != nil {
+		return nil, err
 	}
 
-	return user.ID, nil
+	return &keystoneLogin{user: user, project: project, domain: domain}, nil
+}
+
+// authOptions returns the options to authenticate the given credentials
+func (a keystonePasswordAuthenticator) authOptions(username, password string) (*gophercloud.AuthOptions, bool) {
+	opts := &gophercloud.AuthOptions{IdentityEndpoint: a.url}
+
+	if strings.HasPrefix(username, ApplicationCredentialPrefix) {
+		if !a.options.ApplicationCredentials {
+			return nil, false
+		}
+		id := strings.TrimPrefix(username, ApplicationCredentialPrefix)
+		if len(id) == 0 {
+			return nil, false
+		}
+		// application credentials are bound to the project they were created for and must not request a scope
+		opts.ApplicationCredentialID = id
+		opts.ApplicationCredentialSecret = password
+		opts.Scope = &gophercloud.AuthScope{}
+		return opts, true
+	}
+
+	opts.Username = username
+	opts.Password = password
+	opts.DomainName = a.domainName
+	opts.Scope = &gophercloud.AuthScope{}
+	switch {
+	case len(a.options.ScopeProjectName) > 0:
+		opts.Scope.ProjectName = a.options.ScopeProjectName
+		opts.Scope.DomainName = a.options.ScopeDomainName
+		if len(opts.Scope.DomainName) == 0 {
+			opts.Scope.DomainName = a.domainName
+		}
+	case len(a.options.ScopeDomainName) > 0:
+		opts.Scope.DomainName = a.options.ScopeDomainName
+	}
+	return opts, true
+}
+
+// inScope returns true if the token issued for an application credential satisfies the configured scope.
+// Application credentials cannot request a scope, their tokens are always scoped to the project the
+// credential was created in.
+func (a keystonePasswordAuthenticator) inScope(result *keystoneLogin) bool {
+	switch {
+	case len(a.options.ScopeProjectName) > 0:
+		domainName := a.options.ScopeDomainName
+		if len(domainName) == 0 {
+			domainName = a.domainName
+		}
+		return result.project != nil &&
+			result.project.Name == a.options.ScopeProjectName &&
+			(result.project.Domain.ID == domainName || strings.EqualFold(result.project.Domain.Name, domainName))
+	case len(a.options.ScopeDomainName) > 0:
+		// a project scoped token does not show a role on the domain
+		return false
+	}
+	return true
 }
 
 // AuthenticatePassword approves any login attempt which is successfully validated with Keystone
@@ -75,11 +181,9 @@ func (a keystonePasswordAuthenticator) AuthenticatePassword(ctx context.Context,
 		return nil, false, nil
 	}
 
-	opts := gophercloud.AuthOptions{
-		IdentityEndpoint: a.url,
-		Username:         username,
-		Password:         password,
-		DomainName:       a.domainName,
+	opts, ok := a.authOptions(username, password)
+	if !ok {
+		return nil, false, nil
 	}
 
 	// Calling NewClient/Authenticate manually rather than simply calling AuthenticatedClient
@@ -91,7 +195,7 @@ func (a keystonePasswordAuthenticator) AuthenticatePassword(ctx context.Context,
 	}
 
 	client.HTTPClient = *a.client
-	userid, err := getUserIDv3(client, &opts, gophercloud.EndpointOpts{})
+	result, err := login(client, opts, gophercloud.EndpointOpts{})
 
 	if err != nil {
 		if _, ok := err.(gophercloud.ErrDefault401); ok {
@@ -101,13 +205,34 @@ func (a keystonePasswordAuthenticator) AuthenticatePassword(ctx context.Context,
 		return nil, false, err
 	}
 
-	providerUserID := username
+	// application credentials log in as the user that owns them
+	preferredUsername := username
+	if len(opts.ApplicationCredentialID) > 0 {
+		if !a.inScope(result) {
+			klog.V(4).Infof("Application credential %s of user %s is not valid for the scope of identity provider %s", opts.ApplicationCredentialID, result.user.Name, a.providerName)
+			return nil, false, nil
+		}
+		preferredUsername = result.user.Name
+	}
+
+	providerUserID := preferredUsername
 	if a.useKeystoneIdentity {
-		providerUserID = userid
+		providerUserID = result.user.ID
 	}
 
 	identity := authapi.NewDefaultUserIdentityInfo(a.providerName, providerUserID)
-	identity.Extra[authapi.IdentityPreferredUsernameKey] = username
+	identity.Extra[authapi.IdentityPreferredUsernameKey] = preferredUsername
+	if len(result.user.Domain.Name) > 0 {
+		identity.Extra[IdentityUserDomainKey] = result.user.Domain.Name
+	}
+	if result.project != nil {
+		identity.Extra[IdentityProjectKey] = result.project.Name
+		if len(result.project.Domain.Name) > 0 {
+			identity.Extra[IdentityDomainKey] = result.project.Domain.Name
+		}
+	} else if result.domain != nil {
+		identity.Extra[IdentityDomainKey] = result.domain.Name
+	}
 
 	return identitymapper.ResponseFor(a.identityMapper, identity)
 }
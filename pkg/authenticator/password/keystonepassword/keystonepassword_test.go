@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"reflect"
 	"testing"
 
 	th "github.com/gophercloud/gophercloud/testhelper"
@@ -94,7 +95,7 @@ func TestKeystoneLogin(t *testing.T) {
 	// -----Test Claim strategy with enabled Keystone identity-----
 	mapperClaim := TestUserIdentityMapperClaim{map[string]string{}}
 	keystoneID = "initial_keystone_id"
-	keystoneAuth := New("keystone_auth", th.Endpoint(), http.DefaultTransport, "default", &mapperClaim, true)
+	keystoneAuth := New("keystone_auth", th.Endpoint(), http.DefaultTransport, "default", &mapperClaim, true, Options{})
 
 	// 1. User authenticates for the first time, new identity is created
 	_, ok, err := keystoneAuth.AuthenticatePassword(context.TODO(), "testuser", "testpw")
@@ -119,7 +120,7 @@ func TestKeystoneLogin(t *testing.T) {
 	// -----Test Claim strategy with disabled Keystone identity-----
 	mapperClaim = TestUserIdentityMapperClaim{map[string]string{}}
 	keystoneID = "initial_keystone_id"
-	keystoneAuth = New("keystone_auth", th.Endpoint(), http.DefaultTransport, "default", &mapperClaim, false)
+	keystoneAuth = New("keystone_auth", th.Endpoint(), http.DefaultTransport, "default", &mapperClaim, false, Options{})
 
 	// 1. User authenticates for the first time, new identity is created
 	_, ok, err = keystoneAuth.AuthenticatePassword(context.TODO(), "testuser", "testpw")
@@ -141,3 +142,173 @@ func TestKeystoneLogin(t *testing.T) {
 	th.CheckEquals(t, true, ok)
 	th.AssertNoErr(t, err)
 }
+
+func TestKeystoneScopedLogin(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+
+	th.Mux.HandleFunc("/v3/auth/tokens", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("X-Subject-Token", "0123456789")
+		type AuthRequest struct {
+			Auth struct {
+				Identity struct {
+					Methods  []string
+					Password struct {
+						User struct {
+							Name     string
+							Password string
+						}
+					}
+					ApplicationCredential struct {
+						ID     string
+						Secret string
+					} `json:"application_credential"`
+				}
+				Scope struct {
+					Project struct {
+						Name   string
+						Domain struct{ Name string }
+					}
+					Domain struct{ Name string }
+				}
+			}
+		}
+		var x AuthRequest
+		body, _ := ioutil.ReadAll(r.Body)
+		th.AssertNoErr(t, json.Unmarshal(body, &x))
+		identity, scope := x.Auth.Identity, x.Auth.Scope
+
+		user := `"user": {"domain": {"id": "default", "name": "Default"}, "id": "user-id", "name": "owner"}`
+		switch {
+		case identity.Password.User.Name == "testuser" && identity.Password.User.Password == "testpw" && scope.Project.Name == "dev" && scope.Project.Domain.Name == "default":
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintln(w, `{"token": {`+user+`, "project": {"domain": {"id": "default", "name": "Default"}, "id": "project-id", "name": "dev"}}}`)
+		case identity.Password.User.Name == "testuser" && identity.Password.User.Password == "testpw" && scope.Domain.Name == "admins":
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintln(w, `{"token": {`+user+`, "domain": {"id": "admins-id", "name": "admins"}}}`)
+		case identity.ApplicationCredential.ID == "appcred" && identity.ApplicationCredential.Secret == "secret" && len(scope.Project.Name) == 0 && len(scope.Domain.Name) == 0:
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintln(w, `{"token": {`+user+`, "project": {"domain": {"id": "default", "name": "Default"}, "id": "project-id", "name": "ci"}}}`)
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	})
+
+	testcases := map[string]struct {
+		Options       Options
+		Username      string
+		Password      string
+		ExpectSuccess bool
+		ExpectExtra   map[string]string
+	}{
+		"project scope": {
+			Options:       Options{ScopeProjectName: "dev"},
+			Username:      "testuser",
+			Password:      "testpw",
+			ExpectSuccess: true,
+			ExpectExtra: map[string]string{
+				api.IdentityPreferredUsernameKey: "testuser",
+				IdentityUserDomainKey:            "Default",
+				IdentityProjectKey:               "dev",
+				IdentityDomainKey:                "Default",
+			},
+		},
+		"project scope without role": {
+			Options:  Options{ScopeProjectName: "prod"},
+			Username: "testuser",
+			Password: "testpw",
+		},
+		"domain scope": {
+			Options:       Options{ScopeDomainName: "admins"},
+			Username:      "testuser",
+			Password:      "testpw",
+			ExpectSuccess: true,
+			ExpectExtra: map[string]string{
+				api.IdentityPreferredUsernameKey: "testuser",
+				IdentityUserDomainKey:            "Default",
+				IdentityDomainKey:                "admins",
+			},
+		},
+		"application credential": {
+			Options:       Options{ApplicationCredentials: true},
+			Username:      ApplicationCredentialPrefix + "appcred",
+			Password:      "secret",
+			ExpectSuccess: true,
+			ExpectExtra: map[string]string{
+				api.IdentityPreferredUsernameKey: "owner",
+				IdentityUserDomainKey:            "Default",
+				IdentityProjectKey:               "ci",
+				IdentityDomainKey:                "Default",
+			},
+		},
+		"application credential of the scoped project": {
+			Options:       Options{ApplicationCredentials: true, ScopeProjectName: "ci"},
+			Username:      ApplicationCredentialPrefix + "appcred",
+			Password:      "secret",
+			ExpectSuccess: true,
+			ExpectExtra: map[string]string{
+				api.IdentityPreferredUsernameKey: "owner",
+				IdentityUserDomainKey:            "Default",
+				IdentityProjectKey:               "ci",
+				IdentityDomainKey:                "Default",
+			},
+		},
+		"application credential of another project": {
+			Options:  Options{ApplicationCredentials: true, ScopeProjectName: "dev"},
+			Username: ApplicationCredentialPrefix + "appcred",
+			Password: "secret",
+		},
+		"application credential of the scoped project in another domain": {
+			Options:  Options{ApplicationCredentials: true, ScopeProjectName: "ci", ScopeDomainName: "admins"},
+			Username: ApplicationCredentialPrefix + "appcred",
+			Password: "secret",
+		},
+		"application credential with domain scope": {
+			Options:  Options{ApplicationCredentials: true, ScopeDomainName: "Default"},
+			Username: ApplicationCredentialPrefix + "appcred",
+			Password: "secret",
+		},
+		"application credential with wrong secret": {
+			Options:  Options{ApplicationCredentials: true},
+			Username: ApplicationCredentialPrefix + "appcred",
+			Password: "wrong",
+		},
+		"application credentials disabled": {
+			Username: ApplicationCredentialPrefix + "appcred",
+			Password: "secret",
+		},
+	}
+
+	for k, tc := range testcases {
+		mapper := &testIdentityRecorder{}
+		keystoneAuth := New("keystone_auth", th.Endpoint(), http.DefaultTransport, "default", mapper, false, tc.Options)
+		_, ok, err := keystoneAuth.AuthenticatePassword(context.TODO(), tc.Username, tc.Password)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", k, err)
+			continue
+		}
+		if ok != tc.ExpectSuccess {
+			t.Errorf("%s: expected success %v, got %v", k, tc.ExpectSuccess, ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if mapper.identity.GetProviderUserName() != tc.ExpectExtra[api.IdentityPreferredUsernameKey] {
+			t.Errorf("%s: unexpected provider user name %q", k, mapper.identity.GetProviderUserName())
+		}
+		if !reflect.DeepEqual(mapper.identity.GetExtra(), tc.ExpectExtra) {
+			t.Errorf("%s: expected extra %v, got %v", k, tc.ExpectExtra, mapper.identity.GetExtra())
+		}
+	}
+}
+
+// testIdentityRecorder records the identity it maps
+type testIdentityRecorder struct {
+	identity api.UserIdentityInfo
+}
+
+func (m *testIdentityRecorder) UserFor(identityInfo api.UserIdentityInfo) (user.Info, error) {
+	m.identity = identityInfo
+	return &user.DefaultInfo{Name: identityInfo.GetProviderUserName()}, nil
+}
@@ -0,0 +1,231 @@
+package ldappassword
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/ldap.v2"
+	"k8s.io/klog/v2"
+
+	"github.com/openshift/library-go/pkg/security/ldaputil"
+)
+
+// GroupAuthorization restricts logins to members of at least one of the required groups
+type GroupAuthorization struct {
+	// RequiredGroups holds the DNs of the groups that are allowed to log in. It may only be empty if AllGroups is
+	// set, all users can log in then.
+	RequiredGroups []string
+	// AllGroups passes on all groups of the user as the groups of the identity instead of only the required groups,
+	// so that the memberships of users are synchronized at login
+	AllGroups bool
+
+	// MembershipAttribute is the attribute of user entries listing the DNs of the user's groups.
+	// It is ignored if GroupSearch is set.
+	MembershipAttribute string
+	// GroupSearch finds the groups of a user by searching for group entries referencing the user
+	GroupSearch *GroupSearch
+}
+
+// GroupSearch describes a search for the group entries a user is a member of
+type GroupSearch struct {
+	BaseDN string
+	Scope  ldaputil.Scope
+	Filter string
+
+	// MemberAttribute is the attribute of group entries holding the DNs of members
+	MemberAttribute string
+	// NameAttribute is the attribute of group entries used as the group name
+	NameAttribute string
+}
+
+// userAttributes returns the attributes of user entries needed to check group membership
+func (g *GroupAuthorization) userAttributes() []string {
+	if g.GroupSearch != nil {
+		return nil
+	}
+	return []string{g.MembershipAttribute}
+}
+
+// requiredGroup returns true if dn is one of the required groups
+func (g *GroupAuthorization) requiredGroup(dn string) bool {
+	for _, required := range g.RequiredGroups {
+		if equalDNs(required, dn) {
+			return true
+		}
+	}
+	return false
+}
+
+// group is a group entry of a user
+type group struct {
+	dn   string
+	name string
+}
+
+// needsSearch returns true if finding the groups of the user entry requires searching the directory
+func (g *GroupAuthorization) needsSearch(user *ldap.Entry) bool {
+	if g.GroupSearch != nil {
+		return true
+	}
+	_, _, incomplete := rangedValues(user, g.MembershipAttribute)
+	return incomplete
+}
+
+// userGroups returns the groups the user entry is a member of.
+// The connection must be bound with permissions to search for groups.
+func (g *GroupAuthorization) userGroups(l ldap.Client, user *ldap.Entry) ([]group, error) {
+	groups := []group{}
+
+	if g.GroupSearch == nil {
+		dns, err := membershipValues(l, user, g.MembershipAttribute)
+		if err != nil {
+			return nil, err
+		}
+		for _, dn := range dns {
+			groups = append(groups, group{dn: dn, name: nameFromDN(dn)})
+		}
+		return groups, nil
+	}
+
+	filter := fmt.Sprintf("(&%s(%s=%s))",
+		g.GroupSearch.Filter,
+		ldap.EscapeFilter(g.GroupSearch.MemberAttribute),
+		ldap.EscapeFilter(user.DN),
+	)
+	searchRequest := ldap.NewSearchRequest(
+		g.GroupSearch.BaseDN,
+		int(g.GroupSearch.Scope),
+		ldap.NeverDerefAliases,
+		0, // no size limit, a user may be a member of many groups
+		0,
+		false,
+		filter,
+		[]string{g.GroupSearch.NameAttribute},
+		nil,
+	)
+
+	klog.V(4).Infof("searching for groups with %s", filter)
+	results, err := l.Search(searchRequest)
+	if err != nil {
+		return nil, fmt.Errorf("error searching for groups of %q: %v", user.DN, err)
+	}
+	for _, entry := range results.Entries {
+		name := entry.GetAttributeValue(g.GroupSearch.NameAttribute)
+		if len(name) == 0 {
+			name = nameFromDN(entry.DN)
+		}
+		groups = append(groups, group{dn: entry.DN, name: name})
+	}
+	return groups, nil
+}
+
+// membershipValues returns all values of the membership attribute of the user entry. Servers like Active Directory
+// return large multi-valued attributes in ranges, e.g. memberOf;range=0-1499, the remaining ranges are retrieved
+// with further searches for the entry.
+func membershipValues(l ldap.Client, user *ldap.Entry, attribute string) ([]string, error) {
+	values, next, incomplete := rangedValues(user, attribute)
+	for incomplete {
+		rangedAttribute := fmt.Sprintf("%s;range=%d-*", attribute, next)
+		searchRequest := ldap.NewSearchRequest(
+			user.DN,
+			ldap.ScopeBaseObject,
+			ldap.NeverDerefAliases,
+			1,
+			0,
+			false,
+			"(objectClass=*)",
+			[]string{rangedAttribute},
+			nil,
+		)
+		klog.V(4).Infof("retrieving %s of %q", rangedAttribute, user.DN)
+		results, err := l.Search(searchRequest)
+		if err != nil {
+			return nil, fmt.Errorf("error retrieving %s of %q: %v", rangedAttribute, user.DN, err)
+		}
+		if len(results.Entries) != 1 {
+			return nil, fmt.Errorf("error retrieving %s of %q: expected one entry, got %d", rangedAttribute, user.DN, len(results.Entries))
+		}
+		var rangeValues []string
+		previous := next
+		rangeValues, next, incomplete = rangedValues(results.Entries[0], attribute)
+		if len(rangeValues) == 0 || (incomplete && next <= previous) {
+			return nil, fmt.Errorf("error retrieving %s of %q: the server returned no progress", rangedAttribute, user.DN)
+		}
+		values = append(values, rangeValues...)
+	}
+	return values, nil
+}
+
+// rangedValues returns the values of the attribute of the entry, whether a range of the attribute is missing, and
+// the index of the first missing value
+func rangedValues(entry *ldap.Entry, attribute string) ([]string, int, bool) {
+	values := []string{}
+	for _, entryAttribute := range entry.Attributes {
+		name, options, _ := strings.Cut(entryAttribute.Name, ";")
+		if !strings.EqualFold(name, attribute) {
+			continue
+		}
+		values = append(values, entryAttribute.Values...)
+		if len(options) == 0 {
+			continue
+		}
+		for _, option := range strings.Split(options, ";") {
+			bounds, ok := cutPrefixFold(option, "range=")
+			if !ok {
+				continue
+			}
+			_, high, _ := strings.Cut(bounds, "-")
+			if high == "*" {
+				continue
+			}
+			last, err := strconv.Atoi(high)
+			if err != nil {
+				continue
+			}
+			return values, last + 1, true
+		}
+	}
+	return values, 0, false
+}
+
+func cutPrefixFold(s, prefix string) (string, bool) {
+	if len(s) < len(prefix) || !strings.EqualFold(s[:len(prefix)], prefix) {
+		return s, false
+	}
+	return s[len(prefix):], true
+}
+
+// equalDNs compares two DNs ignoring case and insignificant whitespace.
+// DNs that cannot be parsed are compared as strings.
+func equalDNs(a, b string) bool {
+	parsedA, errA := ldap.ParseDN(a)
+	parsedB, errB := ldap.ParseDN(b)
+	if errA != nil || errB != nil {
+		return strings.EqualFold(a, b)
+	}
+	if len(parsedA.RDNs) != len(parsedB.RDNs) {
+		return false
+	}
+	for i := range parsedA.RDNs {
+		attrsA, attrsB := parsedA.RDNs[i].Attributes, parsedB.RDNs[i].Attributes
+		if len(attrsA) != len(attrsB) {
+			return false
+		}
+		for j := range attrsA {
+			if !strings.EqualFold(attrsA[j].Type, attrsB[j].Type) || !strings.EqualFold(attrsA[j].Value, attrsB[j].Value) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// nameFromDN returns the value of the first RDN of dn, e.g. admins for cn=admins,dc=example,dc=com
+func nameFromDN(dn string) string {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil || len(parsed.RDNs) == 0 || len(parsed.RDNs[0].Attributes) == 0 {
+		return dn
+	}
+	return parsed.RDNs[0].Attributes[0].Value
+}
@@ -44,6 +44,10 @@ func (f *DefaultLDAPUserIdentityFactory) IdentityFor(user *ldap.Entry) (identity
 		id.Extra[authapi.IdentityPreferredUsernameKey] = prefUser
 	}
 
+	if version := f.Definer.CredentialsVersion(user); len(version) != 0 {
+		id.Extra[authapi.IdentityCredentialsVersionKey] = version
+	}
+
 	identity = id
 	return
 }
@@ -59,6 +63,14 @@ func NewLDAPUserAttributeDefiner(attributeMapping osinv1.LDAPAttributeMapping) L
 type LDAPUserAttributeDefiner struct {
 	// attributeMapping holds the attributes mapped to email, name, preferred username and ID
 	attributeMapping osinv1.LDAPAttributeMapping
+	// credentialsVersion holds the attributes that change with the password, e.g. pwdChangedTime
+	credentialsVersion []string
+}
+
+// WithCredentialsVersion returns a definer that also reads the version of the credentials from the attributes
+func (d LDAPUserAttributeDefiner) WithCredentialsVersion(attributes ...string) LDAPUserAttributeDefiner {
+	d.credentialsVersion = attributes
+	return d
 }
 
 // AllAttributes gets all attributes listed in the LDAPUserAttributeDefiner
@@ -67,6 +79,7 @@ func (d *LDAPUserAttributeDefiner) AllAttributes() sets.String {
 	attrs.Insert(d.attributeMapping.Name...)
 	attrs.Insert(d.attributeMapping.PreferredUsername...)
 	attrs.Insert(d.attributeMapping.ID...)
+	attrs.Insert(d.credentialsVersion...)
 	return attrs
 }
 
@@ -85,6 +98,11 @@ func (d *LDAPUserAttributeDefiner) PreferredUsername(user *ldap.Entry) string {
 	return ldaputil.GetAttributeValue(user, d.attributeMapping.PreferredUsername)
 }
 
+// CredentialsVersion extracts the version of the credentials from an LDAP user entry
+func (d *LDAPUserAttributeDefiner) CredentialsVersion(user *ldap.Entry) string {
+	return ldaputil.GetAttributeValue(user, d.credentialsVersion)
+}
+
 // ID extracts the ID value from an LDAP user entry
 func (d *LDAPUserAttributeDefiner) ID(user *ldap.Entry) string {
 	// support binary ID fields as those the only stable identifiers in some environments
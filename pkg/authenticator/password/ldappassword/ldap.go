@@ -2,8 +2,11 @@ package ldappassword
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
 	"runtime/debug"
+	"strings"
 	"time"
 
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
@@ -33,8 +36,26 @@ type Options struct {
 	// attribute with a non-empty value is used for all but the latter identity field. If no LDAP attributes
 	// are given for the ID address, login fails.
 	UserAttributeDefiner LDAPUserAttributeDefiner
+
+	// FollowReferrals enables chasing of search result references to other LDAP servers
+	FollowReferrals bool
+	// MaxReferralHops limits how many referrals deep a search is followed
+	MaxReferralHops int
+	// ReferralClientConfig returns the client config used to connect to the server of a referral URL.
+	// It is required if FollowReferrals is set.
+	ReferralClientConfig func(url string) (ldapclient.Config, error)
+	// AllowedReferralHosts are the hosts referrals may point to, as host or host:port. Referrals to other
+	// hosts or with another scheme than URL are not followed, as the servers they point to are sent the bind
+	// credentials and the password of the user. It is required if FollowReferrals is set.
+	AllowedReferralHosts []string
+
+	// GroupAuthorization optionally restricts logins to members of specific groups
+	GroupAuthorization *GroupAuthorization
 }
 
+// DefaultMaxReferralHops is used when referrals are followed without an explicit hop limit
+const DefaultMaxReferralHops = 5
+
 // Authenticator validates username/passwords against an LDAP v3 server
 type Authenticator struct {
 	providerName    string
@@ -51,6 +72,20 @@ func New(providerName string, options Options, mapper authapi.UserIdentityMapper
 	// IDP other than LDAP. Changing the global LDAP connection timeout here to
 	// 30s to fix this scenario and make it consistent with other OAuth IDPs
 	ldap.DefaultTimeout = 30 * time.Second
+	if options.FollowReferrals {
+		if options.ReferralClientConfig == nil {
+			return nil, fmt.Errorf("following referrals requires a referral client config")
+		}
+		if len(options.AllowedReferralHosts) == 0 {
+			return nil, fmt.Errorf("following referrals requires allowed referral hosts")
+		}
+		if options.MaxReferralHops <= 0 {
+			options.MaxReferralHops = DefaultMaxReferralHops
+		}
+	}
+	if options.GroupAuthorization != nil && len(options.GroupAuthorization.RequiredGroups) == 0 && !options.GroupAuthorization.AllGroups {
+		return nil, fmt.Errorf("group authorization requires at least one group")
+	}
 	auth := &Authenticator{
 		providerName: providerName,
 		options:      options,
@@ -91,7 +126,7 @@ func (a *Authenticator) getIdentity(username, password string) (authapi.UserIden
 	// Make the connection and bind to it if a bind DN and password were given
 	l, err := a.options.ClientConfig.Connect()
 	if err != nil {
-		return nil, false, err
+		return nil, false, authapi.NewIdentityProviderError(err)
 	}
 	defer l.Close()
 
@@ -100,7 +135,7 @@ func (a *Authenticator) getIdentity(username, password string) (authapi.UserIden
 			// If the configured bindDN/bindPassword encounters errors, that blocks all logins
 			// Handle as a severe error in addition to returning an error to fail this particular login
 			utilruntime.HandleError(fmt.Errorf("error binding to %s for search phase: %v", bindDN, err))
-			return nil, false, err
+			return nil, false, authapi.NewIdentityProviderError(err)
 		}
 	}
 
@@ -114,6 +149,9 @@ func (a *Authenticator) getIdentity(username, password string) (authapi.UserIden
 	// Build list of attributes to retrieve
 	attrs := sets.NewString(a.options.URL.QueryAttribute)
 	attrs.Insert(a.options.UserAttributeDefiner.AllAttributes().List()...)
+	if a.options.GroupAuthorization != nil {
+		attrs.Insert(a.options.GroupAuthorization.userAttributes()...)
+	}
 
 	// Search for LDAP record
 	searchRequest := ldap.NewSearchRequest(
@@ -129,45 +167,36 @@ func (a *Authenticator) getIdentity(username, password string) (authapi.UserIden
 	)
 
 	klog.V(4).Infof("searching for %s", filter)
-	results, err := l.Search(searchRequest)
+	entries, err := a.search(l, searchRequest, 0)
+	defer closeReferralConnections(entries, l)
 	if err != nil {
-		return nil, false, err
+		return nil, false, authapi.NewIdentityProviderError(err)
 	}
 
-	if len(results.Entries) == 0 {
+	if len(entries) == 0 {
 		// 0 results means a missing username, not an error
 		klog.V(4).Infof("no entries matching %s", filter)
 		return nil, false, nil
 	}
-	if len(results.Entries) > 1 {
+	if len(entries) > 1 {
 		// More than 1 result means a misconfigured server filter or query parameter
 		return nil, false, fmt.Errorf("multiple entries found matching %q", username)
 	}
 
-	entry := results.Entries[0]
+	entry := entries[0].Entry
 	klog.V(4).Infof("found dn=%q for %s", entry.DN, filter)
 
-	// Bind with given username and password to attempt to authenticate
-	if err := l.Bind(entry.DN, password); err != nil {
+	// Bind with given username and password to attempt to authenticate, using the
+	// connection to the server the entry was found on in case a referral was followed
+	if err := entries[0].conn.Bind(entry.DN, password); err != nil {
 		klog.V(4).Infof("error binding password for %q: %v", entry.DN, err)
-		if err, ok := err.(*ldap.Error); ok {
-			switch err.ResultCode {
-			case ldap.LDAPResultInappropriateAuthentication:
-				// inappropriateAuthentication (48)
-				//    Indicates the server requires the client that had attempted
-				//    to bind anonymously or without supplying credentials to
-				//    provide some form of credentials.
-				fallthrough
-			case ldap.LDAPResultInvalidCredentials:
-				// invalidCredentials (49)
-				//    Indicates that the provided credentials (e.g., the user's name
-				//    and password) are invalid.
-
-				// Authentication failed, return false, but no error
-				return nil, false, nil
-			}
+		// only a server that cannot be reached or is too busy is an outage of the identity provider. Every other
+		// failure fails the login, e.g. invalidCredentials (49), or unwillingToPerform (53) and constraintViolation
+		// (19) of locked or disabled accounts, so that they are not served from cached credentials.
+		if !bindUnavailable(err) {
+			return nil, false, nil
 		}
-		return nil, false, err
+		return nil, false, authapi.NewIdentityProviderError(err)
 	}
 
 	// Build the identity
@@ -175,5 +204,184 @@ func (a *Authenticator) getIdentity(username, password string) (authapi.UserIden
 	if err != nil {
 		return nil, false, err
 	}
+
+	if a.options.GroupAuthorization != nil {
+		if err := a.authorizeGroups(l, entry, identity); err != nil {
+			return nil, false, err
+		}
+	}
 	return identity, true, nil
 }
+
+// bindUnavailable returns true if a bind failed because the server could not be reached or was too busy to answer
+func bindUnavailable(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	var ldapErr *ldap.Error
+	if !errors.As(err, &ldapErr) {
+		return false
+	}
+	switch ldapErr.ResultCode {
+	case ldap.ErrorNetwork, ldap.LDAPResultBusy, ldap.LDAPResultUnavailable:
+		return true
+	}
+	return false
+}
+
+// authorizeGroups returns an AuthorizationDeniedError if the user is not a member of any of
+// the required groups. The names of the matched groups, or of all groups, are added to the identity.
+func (a *Authenticator) authorizeGroups(l ldap.Client, entry *ldap.Entry, identity authapi.UserIdentityInfo) error {
+	groupAuthorization := a.options.GroupAuthorization
+	// the connection may be bound as the user now, groups are searched for with the bind credentials
+	if bindDN, bindPassword := a.options.ClientConfig.GetBindCredentials(); len(bindDN) > 0 && groupAuthorization.needsSearch(entry) {
+		if err := l.Bind(bindDN, bindPassword); err != nil {
+			return fmt.Errorf("error binding to %s for group search: %v", bindDN, err)
+		}
+	}
+
+	userGroups, err := groupAuthorization.userGroups(l, entry)
+	if err != nil {
+		return authapi.NewAuthorizationFailedError(identity, err)
+	}
+	authorized, all := []string{}, []string{}
+	for _, group := range userGroups {
+		all = append(all, group.name)
+		if groupAuthorization.requiredGroup(group.dn) {
+			authorized = append(authorized, group.name)
+		}
+	}
+	if len(groupAuthorization.RequiredGroups) > 0 && len(authorized) == 0 {
+		return authapi.NewAuthorizationDeniedError(identity, fmt.Errorf("user %q is not a member of any of the required groups %v", entry.DN, groupAuthorization.RequiredGroups))
+	}
+
+	groups := authorized
+	if groupAuthorization.AllGroups {
+		groups = all
+	}
+	klog.V(4).Infof("dn=%q is a member of the groups %v", entry.DN, groups)
+	identity.GetExtra()[authapi.IdentityGroupsKey] = strings.Join(groups, ",")
+	return nil
+}
+
+// searchEntry is an LDAP entry together with the connection to the server it was found on
+type searchEntry struct {
+	*ldap.Entry
+	conn ldap.Client
+}
+
+// search runs the search request and, if enabled, follows the search result references
+// returned by the server until more than one entry is found or the hop limit is reached.
+// Connections to referred servers are returned with their entries, the caller is
+// responsible for closing them. Entries are returned even if an error occurs.
+func (a *Authenticator) search(l ldap.Client, searchRequest *ldap.SearchRequest, hops int) ([]searchEntry, error) {
+	results, err := l.Search(searchRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]searchEntry, 0, len(results.Entries))
+	for _, entry := range results.Entries {
+		entries = append(entries, searchEntry{Entry: entry, conn: l})
+	}
+
+	if !a.options.FollowReferrals || len(results.Referrals) == 0 {
+		return entries, nil
+	}
+	if hops >= a.options.MaxReferralHops {
+		klog.V(4).Infof("not following %d referrals, hop limit of %d reached", len(results.Referrals), a.options.MaxReferralHops)
+		return entries, nil
+	}
+
+	for _, referral := range results.Referrals {
+		// we only need to know whether the entry is unique
+		if len(entries) > 1 {
+			break
+		}
+
+		referralEntries, err := a.searchReferral(referral, searchRequest, hops+1)
+		entries = append(entries, referralEntries...)
+		if err != nil {
+			return entries, err
+		}
+	}
+	return entries, nil
+}
+
+// searchReferral connects to the server of the referral URL and repeats the search there
+func (a *Authenticator) searchReferral(referral string, searchRequest *ldap.SearchRequest, hops int) ([]searchEntry, error) {
+	referralURL, err := ldaputil.ParseURL(referral)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing referral %q: %v", referral, err)
+	}
+	if !a.allowedReferral(referralURL) {
+		klog.V(2).Infof("not following referral to %s, it is not an allowed %s referral host", referralURL.Host, a.options.URL.Scheme)
+		return nil, nil
+	}
+	clientConfig, err := a.options.ReferralClientConfig(referral)
+	if err != nil {
+		return nil, fmt.Errorf("error creating client for referral %q: %v", referral, err)
+	}
+
+	klog.V(4).Infof("following referral to %s (hop %d)", clientConfig.Host(), hops)
+	l, err := clientConfig.Connect()
+	if err != nil {
+		return nil, err
+	}
+	if bindDN, bindPassword := clientConfig.GetBindCredentials(); len(bindDN) > 0 {
+		if err := l.Bind(bindDN, bindPassword); err != nil {
+			l.Close()
+			return nil, fmt.Errorf("error binding to %s for search phase: %v", clientConfig.Host(), err)
+		}
+	}
+
+	// a referral keeps the scope and filter of the original search, but may specify another base
+	referralRequest := *searchRequest
+	if len(referralURL.BaseDN) > 0 {
+		referralRequest.BaseDN = referralURL.BaseDN
+	}
+
+	entries, err := a.search(l, &referralRequest, hops)
+	if !usesConnection(entries, l) {
+		l.Close()
+	}
+	return entries, err
+}
+
+// allowedReferral returns true if the referral URL has the scheme of the configured URL and points to an allowed host
+func (a *Authenticator) allowedReferral(referralURL ldaputil.LDAPURL) bool {
+	if referralURL.Scheme != a.options.URL.Scheme {
+		return false
+	}
+	hostname, _, err := net.SplitHostPort(referralURL.Host)
+	if err != nil {
+		return false
+	}
+	for _, allowed := range a.options.AllowedReferralHosts {
+		if strings.EqualFold(allowed, referralURL.Host) || strings.EqualFold(allowed, hostname) {
+			return true
+		}
+	}
+	return false
+}
+
+func usesConnection(entries []searchEntry, l ldap.Client) bool {
+	for _, entry := range entries {
+		if entry.conn == l {
+			return true
+		}
+	}
+	return false
+}
+
+// closeReferralConnections closes all connections of the entries except for l
+func closeReferralConnections(entries []searchEntry, l ldap.Client) {
+	closed := map[ldap.Client]bool{l: true}
+	for _, entry := range entries {
+		if !closed[entry.conn] {
+			closed[entry.conn] = true
+			entry.conn.Close()
+		}
+	}
+}
@@ -0,0 +1,494 @@
+package ldappassword
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"gopkg.in/ldap.v2"
+
+	osinv1 "github.com/openshift/api/osin/v1"
+	"github.com/openshift/library-go/pkg/security/ldapclient"
+	"github.com/openshift/library-go/pkg/security/ldaputil"
+
+	authapi "github.com/openshift/oauth-server/pkg/api"
+)
+
+// fakeServer answers searches for a base DN with a fixed result and accepts binds for known DNs
+type fakeServer struct {
+	host      string
+	results   map[string]*ldap.SearchResult
+	passwords map[string]string
+	// bindError is returned by binds of users if set
+	bindError error
+
+	searches int
+	open     int
+}
+
+func (s *fakeServer) Connect() (ldap.Client, error) {
+	s.open++
+	return &fakeClient{server: s}, nil
+}
+
+func (s *fakeServer) GetBindCredentials() (string, string) { return "", "" }
+func (s *fakeServer) Host() string                         { return s.host }
+
+type fakeClient struct {
+	// unused methods panic
+	ldap.Client
+	server *fakeServer
+}
+
+func (c *fakeClient) Search(request *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	c.server.searches++
+	if result, ok := c.server.results[request.BaseDN]; ok {
+		return result, nil
+	}
+	return &ldap.SearchResult{}, nil
+}
+
+func (c *fakeClient) Bind(username, password string) error {
+	if c.server.bindError != nil {
+		return c.server.bindError
+	}
+	if expected, ok := c.server.passwords[username]; ok && expected == password {
+		return nil
+	}
+	return ldap.NewError(ldap.LDAPResultInvalidCredentials, fmt.Errorf("invalid credentials"))
+}
+
+func (c *fakeClient) Close() { c.server.open-- }
+
+func userEntry(dn, uid string) *ldap.Entry {
+	return ldap.NewEntry(dn, map[string][]string{"uid": {uid}})
+}
+
+func TestReferrals(t *testing.T) {
+	newServers := func() (*fakeServer, map[string]*fakeServer) {
+		root := &fakeServer{
+			host: "root.example.com:389",
+			results: map[string]*ldap.SearchResult{
+				"dc=example,dc=com": {Referrals: []string{"ldap://child.example.com/dc=child,dc=example,dc=com"}},
+			},
+		}
+		child := &fakeServer{
+			host: "child.example.com:389",
+			results: map[string]*ldap.SearchResult{
+				"dc=child,dc=example,dc=com": {
+					Entries:   []*ldap.Entry{userEntry("uid=bob,dc=child,dc=example,dc=com", "bob")},
+					Referrals: []string{"ldap://grandchild.example.com/dc=grandchild,dc=child,dc=example,dc=com"},
+				},
+			},
+			passwords: map[string]string{"uid=bob,dc=child,dc=example,dc=com": "password"},
+		}
+		grandchild := &fakeServer{
+			host: "grandchild.example.com:389",
+			results: map[string]*ldap.SearchResult{
+				"dc=grandchild,dc=child,dc=example,dc=com": {
+					Entries: []*ldap.Entry{userEntry("uid=bob,dc=grandchild,dc=child,dc=example,dc=com", "bob")},
+				},
+			},
+		}
+		return root, map[string]*fakeServer{
+			"ldap://child.example.com/dc=child,dc=example,dc=com":                    child,
+			"ldap://grandchild.example.com/dc=grandchild,dc=child,dc=example,dc=com": grandchild,
+		}
+	}
+
+	for _, tc := range []struct {
+		name            string
+		followReferrals bool
+		maxHops         int
+		allowedHosts    []string
+		password        string
+
+		expectOK    bool
+		expectError bool
+		// expectNotFollowed is set if the child server must not be searched
+		expectNotFollowed bool
+	}{
+		{
+			name:              "referrals ignored",
+			password:          "password",
+			expectOK:          false,
+			expectNotFollowed: true,
+		},
+		{
+			name:            "referral followed",
+			followReferrals: true,
+			maxHops:         1,
+			password:        "password",
+			expectOK:        true,
+		},
+		{
+			name:            "referral to host and port followed",
+			followReferrals: true,
+			maxHops:         1,
+			allowedHosts:    []string{"CHILD.example.com:389"},
+			password:        "password",
+			expectOK:        true,
+		},
+		{
+			name:              "referral to other host refused",
+			followReferrals:   true,
+			maxHops:           1,
+			allowedHosts:      []string{"other.example.com", "child.example.com:636"},
+			password:          "password",
+			expectOK:          false,
+			expectNotFollowed: true,
+		},
+		{
+			name:            "referral followed, wrong password",
+			followReferrals: true,
+			maxHops:         1,
+			password:        "wrong",
+			expectOK:        false,
+		},
+		{
+			name:            "second hop finds duplicate",
+			followReferrals: true,
+			maxHops:         2,
+			password:        "password",
+			expectError:     true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			root, referred := newServers()
+			url, err := ldaputil.ParseURL("ldap://root.example.com/dc=example,dc=com?uid")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			allowedHosts := tc.allowedHosts
+			if allowedHosts == nil {
+				allowedHosts = []string{"child.example.com", "grandchild.example.com"}
+			}
+			auth, err := New("ldap", Options{
+				URL:                  url,
+				ClientConfig:         root,
+				UserAttributeDefiner: NewLDAPUserAttributeDefiner(osinv1.LDAPAttributeMapping{ID: []string{"uid"}}),
+				FollowReferrals:      tc.followReferrals,
+				MaxReferralHops:      tc.maxHops,
+				AllowedReferralHosts: allowedHosts,
+				ReferralClientConfig: func(referral string) (ldapclient.Config, error) {
+					server, ok := referred[referral]
+					if !ok {
+						return nil, fmt.Errorf("unexpected referral %s", referral)
+					}
+					return server, nil
+				},
+			}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			identity, ok, err := auth.(*Authenticator).getIdentity("bob", tc.password)
+			if tc.expectError != (err != nil) {
+				t.Fatalf("expected error %v, got %v", tc.expectError, err)
+			}
+			if tc.expectOK != ok {
+				t.Fatalf("expected ok %v, got %v", tc.expectOK, ok)
+			}
+			if ok && identity.GetProviderUserName() != "Ym9i" { // base64 of the uid
+				t.Errorf("unexpected identity %#v", identity)
+			}
+
+			for name, server := range referred {
+				if server.open != 0 {
+					t.Errorf("%d connections to %s were not closed", server.open, name)
+				}
+			}
+			if tc.expectNotFollowed && referred["ldap://child.example.com/dc=child,dc=example,dc=com"].searches != 0 {
+				t.Errorf("referral was followed although disabled or not allowed")
+			}
+		})
+	}
+}
+
+func TestAllowedReferral(t *testing.T) {
+	url, err := ldaputil.ParseURL("ldaps://root.example.com/dc=example,dc=com?uid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := &Authenticator{options: Options{URL: url, AllowedReferralHosts: []string{"child.example.com", "other.example.com:3269"}}}
+	for referral, expected := range map[string]bool{
+		"ldaps://child.example.com/dc=child":        true,
+		"ldaps://child.example.com:3269/dc=child":   true,
+		"ldap://child.example.com/dc=child":         false,
+		"ldaps://other.example.com:3269/dc=other":   true,
+		"ldaps://other.example.com/dc=other":        false,
+		"ldaps://evil.example.com/dc=child":         false,
+		"ldaps://child.example.com.evil.com/dc=foo": false,
+	} {
+		referralURL, err := ldaputil.ParseURL(referral)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if allowed := auth.allowedReferral(referralURL); allowed != expected {
+			t.Errorf("%s: expected allowed %v, got %v", referral, expected, allowed)
+		}
+	}
+}
+
+func TestAuthenticatePasswordWithoutReferrals(t *testing.T) {
+	server := &fakeServer{
+		results: map[string]*ldap.SearchResult{
+			"dc=example,dc=com": {Entries: []*ldap.Entry{userEntry("uid=alice,dc=example,dc=com", "alice")}},
+		},
+		passwords: map[string]string{"uid=alice,dc=example,dc=com": "password"},
+	}
+	url, err := ldaputil.ParseURL("ldap://example.com/dc=example,dc=com?uid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth, err := New("ldap", Options{
+		URL:                  url,
+		ClientConfig:         server,
+		UserAttributeDefiner: NewLDAPUserAttributeDefiner(osinv1.LDAPAttributeMapping{ID: []string{"uid"}}),
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok, err := auth.(*Authenticator).getIdentity("alice", "password"); !ok || err != nil {
+		t.Errorf("expected successful login, got %v %v", ok, err)
+	}
+	if server.open != 0 {
+		t.Errorf("%d connections were not closed", server.open)
+	}
+	if _, err := New("ldap", Options{FollowReferrals: true}, nil); err == nil {
+		t.Errorf("expected error for referrals without client config")
+	}
+	referralClientConfig := func(string) (ldapclient.Config, error) { return server, nil }
+	if _, err := New("ldap", Options{FollowReferrals: true, ReferralClientConfig: referralClientConfig}, nil); err == nil {
+		t.Errorf("expected error for referrals without allowed hosts")
+	}
+}
+
+func TestBindFailures(t *testing.T) {
+	url, err := ldaputil.ParseURL("ldap://example.com/dc=example,dc=com?uid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, tc := range map[string]struct {
+		bindError           error
+		expectProviderError bool
+	}{
+		"invalid credentials":    {bindError: ldap.NewError(ldap.LDAPResultInvalidCredentials, errors.New("invalid"))},
+		"locked account":         {bindError: ldap.NewError(ldap.LDAPResultUnwillingToPerform, errors.New("locked"))},
+		"password expired":       {bindError: ldap.NewError(ldap.LDAPResultConstraintViolation, errors.New("expired"))},
+		"operations error":       {bindError: ldap.NewError(ldap.LDAPResultOperationsError, errors.New("failed"))},
+		"network error":          {bindError: ldap.NewError(ldap.ErrorNetwork, errors.New("connection reset")), expectProviderError: true},
+		"busy":                   {bindError: ldap.NewError(ldap.LDAPResultBusy, errors.New("busy")), expectProviderError: true},
+		"unavailable":            {bindError: ldap.NewError(ldap.LDAPResultUnavailable, errors.New("unavailable")), expectProviderError: true},
+		"wrapped connection err": {bindError: fmt.Errorf("bind: %w", &net.OpError{Op: "read", Err: errors.New("timeout")}), expectProviderError: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			server := &fakeServer{
+				results: map[string]*ldap.SearchResult{
+					"dc=example,dc=com": {Entries: []*ldap.Entry{userEntry("uid=alice,dc=example,dc=com", "alice")}},
+				},
+				bindError: tc.bindError,
+			}
+			auth, err := New("ldap", Options{
+				URL:                  url,
+				ClientConfig:         server,
+				UserAttributeDefiner: NewLDAPUserAttributeDefiner(osinv1.LDAPAttributeMapping{ID: []string{"uid"}}),
+			}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, ok, err := auth.(*Authenticator).getIdentity("alice", "password")
+			if ok {
+				t.Fatal("expected the login to fail")
+			}
+			if isProviderError := errors.As(err, &authapi.IdentityProviderError{}); isProviderError != tc.expectProviderError {
+				t.Errorf("expected identity provider error %v, got %v", tc.expectProviderError, err)
+			}
+			if !tc.expectProviderError && err != nil {
+				t.Errorf("expected a plain authentication failure, got %v", err)
+			}
+		})
+	}
+}
+
+func TestGroupAuthorization(t *testing.T) {
+	const userDN = "uid=alice,dc=example,dc=com"
+	newServer := func() *fakeServer {
+		user := ldap.NewEntry(userDN, map[string][]string{
+			"uid":      {"alice"},
+			"memberOf": {"CN=Admins, OU=Groups,DC=example,DC=com", "cn=users,ou=groups,dc=example,dc=com"},
+		})
+		return &fakeServer{
+			results: map[string]*ldap.SearchResult{
+				"dc=example,dc=com": {Entries: []*ldap.Entry{user}},
+				"ou=groups,dc=example,dc=com": {Entries: []*ldap.Entry{
+					ldap.NewEntry("cn=admins,ou=groups,dc=example,dc=com", map[string][]string{"displayName": {"Administrators"}}),
+					ldap.NewEntry("cn=users,ou=groups,dc=example,dc=com", nil),
+				}},
+			},
+			passwords: map[string]string{userDN: "password"},
+		}
+	}
+	groupSearch := &GroupSearch{
+		BaseDN:          "ou=groups,dc=example,dc=com",
+		Scope:           ldaputil.ScopeWholeSubtree,
+		Filter:          "(objectClass=*)",
+		MemberAttribute: "member",
+		NameAttribute:   "displayName",
+	}
+
+	for _, tc := range []struct {
+		name          string
+		authorization *GroupAuthorization
+
+		expectDenied bool
+		expectGroups string
+	}{
+		{
+			name: "memberOf matches",
+			authorization: &GroupAuthorization{
+				RequiredGroups:      []string{"cn=admins,ou=groups,dc=example,dc=com", "cn=other,ou=groups,dc=example,dc=com"},
+				MembershipAttribute: "memberOf",
+			},
+			expectGroups: "Admins",
+		},
+		{
+			name: "memberOf does not match",
+			authorization: &GroupAuthorization{
+				RequiredGroups:      []string{"cn=other,ou=groups,dc=example,dc=com"},
+				MembershipAttribute: "memberOf",
+			},
+			expectDenied: true,
+		},
+		{
+			name: "group search matches",
+			authorization: &GroupAuthorization{
+				RequiredGroups: []string{"cn=admins,ou=groups,dc=example,dc=com", "cn=users,ou=groups,dc=example,dc=com"},
+				GroupSearch:    groupSearch,
+			},
+			expectGroups: "Administrators,users",
+		},
+		{
+			name: "group search does not match",
+			authorization: &GroupAuthorization{
+				RequiredGroups: []string{"cn=other,ou=groups,dc=example,dc=com"},
+				GroupSearch:    groupSearch,
+			},
+			expectDenied: true,
+		},
+		{
+			name: "all groups",
+			authorization: &GroupAuthorization{
+				AllGroups:           true,
+				MembershipAttribute: "memberOf",
+			},
+			expectGroups: "Admins,users",
+		},
+		{
+			name: "all groups of required groups",
+			authorization: &GroupAuthorization{
+				RequiredGroups: []string{"cn=users,ou=groups,dc=example,dc=com"},
+				AllGroups:      true,
+				GroupSearch:    groupSearch,
+			},
+			expectGroups: "Administrators,users",
+		},
+		{
+			name: "all groups do not bypass required groups",
+			authorization: &GroupAuthorization{
+				RequiredGroups:      []string{"cn=other,ou=groups,dc=example,dc=com"},
+				AllGroups:           true,
+				MembershipAttribute: "memberOf",
+			},
+			expectDenied: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			url, err := ldaputil.ParseURL("ldap://example.com/dc=example,dc=com?uid")
+			if err != nil {
+				t.Fatal(err)
+			}
+			auth, err := New("ldap", Options{
+				URL:                  url,
+				ClientConfig:         newServer(),
+				UserAttributeDefiner: NewLDAPUserAttributeDefiner(osinv1.LDAPAttributeMapping{ID: []string{"uid"}}),
+				GroupAuthorization:   tc.authorization,
+			}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			identity, ok, err := auth.(*Authenticator).getIdentity("alice", "password")
+			if tc.expectDenied {
+				var deniedErr authapi.AuthorizationDeniedError
+				if !errors.As(err, &deniedErr) || ok {
+					t.Fatalf("expected authorization denied error, got %v %v", ok, err)
+				}
+				return
+			}
+			if err != nil || !ok {
+				t.Fatalf("expected successful login, got %v %v", ok, err)
+			}
+			if groups := identity.GetExtra()[authapi.IdentityGroupsKey]; groups != tc.expectGroups {
+				t.Errorf("expected groups %q, got %q", tc.expectGroups, groups)
+			}
+		})
+	}
+}
+
+func TestRangedGroupMembership(t *testing.T) {
+	const userDN = "uid=alice,dc=example,dc=com"
+	server := &fakeServer{
+		results: map[string]*ldap.SearchResult{
+			"dc=example,dc=com": {Entries: []*ldap.Entry{ldap.NewEntry(userDN, map[string][]string{
+				"uid":                {"alice"},
+				"memberOf;range=0-1": {"cn=a,dc=example,dc=com", "cn=b,dc=example,dc=com"},
+			})}},
+			// the remaining ranges are retrieved from the entry of the user
+			userDN: {Entries: []*ldap.Entry{ldap.NewEntry(userDN, map[string][]string{
+				"memberOf;range=2-*": {"cn=c,dc=example,dc=com"},
+			})}},
+		},
+		passwords: map[string]string{userDN: "password"},
+	}
+	url, err := ldaputil.ParseURL("ldap://example.com/dc=example,dc=com?uid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth, err := New("ldap", Options{
+		URL:                  url,
+		ClientConfig:         server,
+		UserAttributeDefiner: NewLDAPUserAttributeDefiner(osinv1.LDAPAttributeMapping{ID: []string{"uid"}}),
+		GroupAuthorization: &GroupAuthorization{
+			RequiredGroups:      []string{"cn=c,dc=example,dc=com"},
+			AllGroups:           true,
+			MembershipAttribute: "memberOf",
+		},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	identity, ok, err := auth.(*Authenticator).getIdentity("alice", "password")
+	if err != nil || !ok {
+		t.Fatalf("expected successful login, got %v %v", ok, err)
+	}
+	if groups := identity.GetExtra()[authapi.IdentityGroupsKey]; groups != "a,b,c" {
+		t.Errorf("expected groups a,b,c, got %q", groups)
+	}
+	if server.searches != 2 {
+		t.Errorf("expected the remaining range to be retrieved with one search, got %d searches", server.searches)
+	}
+
+	// a server that never completes the range must not loop
+	server.results[userDN] = &ldap.SearchResult{Entries: []*ldap.Entry{ldap.NewEntry(userDN, map[string][]string{
+		"memberOf;range=2-3": {},
+	})}}
+	if _, _, err := auth.(*Authenticator).getIdentity("alice", "password"); err == nil {
+		t.Errorf("expected an error for a range without progress")
+	}
+}
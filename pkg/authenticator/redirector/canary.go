@@ -0,0 +1,96 @@
+package redirector
+
+import (
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+
+	"github.com/openshift/oauth-server/pkg/oauth/external"
+	oauthhandlers "github.com/openshift/oauth-server/pkg/oauth/handlers"
+	metrics "github.com/openshift/oauth-server/pkg/prometheus"
+	"github.com/openshift/oauth-server/pkg/server/cookies"
+)
+
+const (
+	// canaryCookieName is the name of the cookie holding the bucket of the browser, a number from 0 to 99. It is
+	// shared by all canaries, so that a browser that is sent to the target of one canary is sent to the others too.
+	canaryCookieName = "canary"
+	// canaryCookieMaxAge is how long a browser keeps its bucket, in seconds
+	canaryCookieMaxAge = 90 * 24 * 60 * 60
+	canaryBuckets      = 100
+)
+
+// Canary is the target of a canary, the identity provider a share of the logins is sent to
+type Canary struct {
+	// Name is the name of the identity provider
+	Name       string
+	Redirector oauthhandlers.AuthenticationRedirector
+	// Percentage of the browsers that are sent to the target
+	Percentage int
+	// Users are sent to the target if the login_hint of the authorize request names them
+	Users []string
+}
+
+// NewCanaryRedirector returns an oauthhandlers.AuthenticationRedirector that sends the logins with the identity provider
+// to the target of the canary or to the given redirector of the provider itself. The bucket that decides on the
+// percentage is kept in a cookie with the given options, so that browsers keep logging in with the same provider.
+func NewCanaryRedirector(provider string, redirector oauthhandlers.AuthenticationRedirector, canary Canary, cookie cookies.Options) oauthhandlers.AuthenticationRedirector {
+	users := sets.NewString()
+	for _, user := range canary.Users {
+		users.Insert(strings.ToLower(user))
+	}
+	return &canaryRedirector{
+		provider:   provider,
+		redirector: redirector,
+		canary:     canary,
+		users:      users,
+		cookie:     cookie,
+		bucket:     func() int { return rand.Intn(canaryBuckets) },
+	}
+}
+
+type canaryRedirector struct {
+	provider   string
+	redirector oauthhandlers.AuthenticationRedirector
+	canary     Canary
+	users      sets.String
+	cookie     cookies.Options
+	// bucket assigns browsers without a bucket
+	bucket func() int
+}
+
+// AuthenticationRedirect redirects to the target of the canary or to the identity provider
+func (c *canaryRedirector) AuthenticationRedirect(w http.ResponseWriter, req *http.Request) error {
+	if hint := req.URL.Query().Get(external.LoginHintParam); len(hint) > 0 && c.users.Has(strings.ToLower(hint)) {
+		return c.redirect(c.canary.Name, metrics.UserReason, c.canary.Redirector, w, req)
+	}
+	if c.browserBucket(w, req) < c.canary.Percentage {
+		return c.redirect(c.canary.Name, metrics.PercentageReason, c.canary.Redirector, w, req)
+	}
+	return c.redirect(c.provider, metrics.PercentageReason, c.redirector, w, req)
+}
+
+func (c *canaryRedirector) redirect(target, reason string, redirector oauthhandlers.AuthenticationRedirector, w http.ResponseWriter, req *http.Request) error {
+	klog.V(4).Infof("Sending login with identity provider %q to %q by %s", c.provider, target, reason)
+	metrics.RecordCanaryRoute(c.provider, target, reason)
+	return redirector.AuthenticationRedirect(w, req)
+}
+
+// browserBucket returns the bucket of the browser, or assigns one if the browser has none or an invalid one
+func (c *canaryRedirector) browserBucket(w http.ResponseWriter, req *http.Request) int {
+	name := c.cookie.Name(canaryCookieName)
+	if cookie, err := req.Cookie(name); err == nil {
+		if bucket, err := strconv.Atoi(cookie.Value); err == nil && bucket >= 0 && bucket < canaryBuckets {
+			return bucket
+		}
+	}
+	bucket := c.bucket()
+	cookie := c.cookie.New(name, strconv.Itoa(bucket))
+	cookie.MaxAge = canaryCookieMaxAge
+	c.cookie.Set(w, cookie)
+	return bucket
+}
@@ -0,0 +1,68 @@
+package redirector
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/openshift/oauth-server/pkg/server/cookies"
+)
+
+type namedRedirector string
+
+func (n namedRedirector) AuthenticationRedirect(w http.ResponseWriter, req *http.Request) error {
+	http.Redirect(w, req, "/"+string(n), http.StatusFound)
+	return nil
+}
+
+func TestCanaryRedirector(t *testing.T) {
+	redirector := NewCanaryRedirector("old-tenant", namedRedirector("old-tenant"), Canary{
+		Name:       "new-tenant",
+		Redirector: namedRedirector("new-tenant"),
+		Percentage: 20,
+		Users:      []string{"Alice@example.com"},
+	}, cookies.Options{NamePrefix: cookies.SecurePrefix, Secure: true}).(*canaryRedirector)
+	next := 0
+	redirector.bucket = func() int { return next }
+
+	testCases := []struct {
+		name           string
+		query          string
+		cookie         string
+		bucket         int
+		expectedTarget string
+		expectedCookie string
+	}{
+		{name: "listed user", query: "?login_hint=alice@EXAMPLE.com", cookie: "99", expectedTarget: "/new-tenant"},
+		{name: "other user", query: "?login_hint=bob@example.com", cookie: "99", expectedTarget: "/old-tenant"},
+		{name: "new browser in the canary", bucket: 19, expectedTarget: "/new-tenant", expectedCookie: "19"},
+		{name: "new browser outside of the canary", bucket: 20, expectedTarget: "/old-tenant", expectedCookie: "20"},
+		{name: "browser keeps its bucket", cookie: "5", bucket: 50, expectedTarget: "/new-tenant"},
+		{name: "invalid bucket is replaced", cookie: "100", bucket: 70, expectedTarget: "/old-tenant", expectedCookie: "70"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			next = tc.bucket
+			req := httptest.NewRequest(http.MethodGet, "/oauth/authorize"+tc.query, nil)
+			if len(tc.cookie) > 0 {
+				req.AddCookie(&http.Cookie{Name: "__Secure-canary", Value: tc.cookie})
+			}
+			resp := httptest.NewRecorder()
+			if err := redirector.AuthenticationRedirect(resp, req); err != nil {
+				t.Fatal(err)
+			}
+			if location := resp.Header().Get("Location"); location != tc.expectedTarget {
+				t.Errorf("expected to be sent to %s, got %s", tc.expectedTarget, location)
+			}
+			var cookie string
+			for _, c := range resp.Result().Cookies() {
+				if c.Name == "__Secure-canary" {
+					cookie = c.Value
+				}
+			}
+			if cookie != tc.expectedCookie {
+				t.Errorf("expected bucket cookie %q, got %q", tc.expectedCookie, cookie)
+			}
+		})
+	}
+}
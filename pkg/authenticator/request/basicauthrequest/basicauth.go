@@ -9,6 +9,7 @@ import (
 	"k8s.io/apiserver/pkg/authentication/authenticator"
 	"k8s.io/klog/v2"
 
+	"github.com/openshift/oauth-server/pkg/api"
 	"github.com/openshift/oauth-server/pkg/audit"
 	openshiftauthenticator "github.com/openshift/oauth-server/pkg/authenticator"
 	metrics "github.com/openshift/oauth-server/pkg/prometheus"
@@ -40,7 +41,11 @@ func (authHandler *basicAuthRequestHandler) AuthenticateRequest(req *http.Reques
 
 	audit.AddUsernameAnnotation(req, username)
 
+	var authorizationDeniedError api.AuthorizationDeniedError
 	switch {
+	case errors.As(err, &authorizationDeniedError):
+		klog.V(4).Infof(`Login with provider %q denied for login %q: %v`, authHandler.provider, username, err)
+		metrics.RecordBasicPasswordAuth(metrics.FailResult)
 	case err != nil:
 		klog.Errorf(`Error authenticating login %q with provider %q: %v`, username, authHandler.provider, err)
 		metrics.RecordBasicPasswordAuth(metrics.ErrorResult)
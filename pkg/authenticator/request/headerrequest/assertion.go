@@ -0,0 +1,96 @@
+package headerrequest
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const (
+	// DefaultAssertionHeader carries the assertion of the proxy, unless another header is configured
+	DefaultAssertionHeader = "X-Remote-User-Assertion"
+
+	// assertionLeeway allows for clock skew between the proxy and this server
+	assertionLeeway = time.Minute
+)
+
+// AssertionVerifier checks the JWT an authenticating proxy signs for the user it relays. Client certificates only
+// prove that a request came through a trusted connection, the assertion proves that the proxy itself authenticated
+// the user.
+type AssertionVerifier struct {
+	header    string
+	keys      jose.JSONWebKeySet
+	issuer    string
+	audiences []string
+	maxAge    time.Duration
+	now       func() time.Time
+}
+
+// NewAssertionVerifier returns a verifier of the assertions in header, which must be signed by one of the keys,
+// be issued by issuer if it is set, name one of the audiences and be issued at most maxAge ago
+func NewAssertionVerifier(header string, keys jose.JSONWebKeySet, issuer string, audiences []string, maxAge time.Duration) *AssertionVerifier {
+	if len(header) == 0 {
+		header = DefaultAssertionHeader
+	}
+	return &AssertionVerifier{header: header, keys: keys, issuer: issuer, audiences: audiences, maxAge: maxAge, now: time.Now}
+}
+
+// Verify returns an error unless the request has a valid assertion for the user with the given id
+func (v *AssertionVerifier) Verify(req *http.Request, id string) error {
+	values := req.Header.Values(v.header)
+	if len(values) != 1 {
+		return fmt.Errorf("exactly one %s header is required", v.header)
+	}
+	token, err := jwt.ParseSigned(values[0])
+	if err != nil {
+		return fmt.Errorf("assertion is not a signed JWT: %v", err)
+	}
+	if len(token.Headers) != 1 {
+		return errors.New("assertion must have exactly one signature")
+	}
+
+	claims := jwt.Claims{}
+	verified := false
+	for i := range v.keys.Keys {
+		key := v.keys.Keys[i]
+		if (len(token.Headers[0].KeyID) > 0 && key.KeyID != token.Headers[0].KeyID) || key.Use == "enc" {
+			continue
+		}
+		if err := token.Claims(&key, &claims); err == nil {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return errors.New("assertion signature could not be verified with the keys of the proxy")
+	}
+
+	if claims.Expiry == nil || claims.IssuedAt == nil {
+		return errors.New("assertion requires an expiration and issue time")
+	}
+	now := v.now()
+	if err := claims.ValidateWithLeeway(jwt.Expected{Issuer: v.issuer, Time: now}, assertionLeeway); err != nil {
+		return fmt.Errorf("invalid assertion claims: %v", err)
+	}
+	if v.maxAge > 0 && claims.IssuedAt.Time().Add(v.maxAge+assertionLeeway).Before(now) {
+		return errors.New("assertion is too old")
+	}
+	audienceMatches := false
+	for _, audience := range v.audiences {
+		if claims.Audience.Contains(audience) {
+			audienceMatches = true
+			break
+		}
+	}
+	if !audienceMatches {
+		return fmt.Errorf("assertion is not issued for this server, its audience is %v", []string(claims.Audience))
+	}
+	if claims.Subject != id {
+		return errors.New("assertion is issued for another user")
+	}
+	return nil
+}
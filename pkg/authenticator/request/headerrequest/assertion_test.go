@@ -0,0 +1,133 @@
+package headerrequest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"net/http"
+	"testing"
+	"time"
+
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func TestAssertionVerifier(t *testing.T) {
+	now := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+	sign := func(t *testing.T, keyID string, claims jwt.Claims) (string, jose.JSONWebKey) {
+		privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key := jose.JSONWebKey{Key: privateKey, KeyID: keyID, Algorithm: string(jose.ES256)}
+		signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: key}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertion, err := jwt.Signed(signer).Claims(claims).CompactSerialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return assertion, key.Public()
+	}
+	valid := func() jwt.Claims {
+		return jwt.Claims{
+			Issuer:   "proxy",
+			Subject:  "bob",
+			Audience: jwt.Audience{"https://oauth.example.com"},
+			IssuedAt: jwt.NewNumericDate(now.Add(-time.Minute)),
+			Expiry:   jwt.NewNumericDate(now.Add(time.Minute)),
+		}
+	}
+
+	testCases := map[string]struct {
+		claims     func(jwt.Claims) jwt.Claims
+		otherKey   bool
+		header     string
+		expectedOK bool
+	}{
+		"valid": {
+			expectedOK: true,
+		},
+		"one of several audiences": {
+			claims: func(c jwt.Claims) jwt.Claims {
+				c.Audience = jwt.Audience{"https://other.example.com", "https://oauth.example.com"}
+				return c
+			},
+			expectedOK: true,
+		},
+		"missing header": {
+			header: "-",
+		},
+		"not a JWT": {
+			header: "bob",
+		},
+		"unknown key": {
+			otherKey: true,
+		},
+		"other user": {
+			claims: func(c jwt.Claims) jwt.Claims {
+				c.Subject = "alice"
+				return c
+			},
+		},
+		"other audience": {
+			claims: func(c jwt.Claims) jwt.Claims {
+				c.Audience = jwt.Audience{"https://other.example.com"}
+				return c
+			},
+		},
+		"other issuer": {
+			claims: func(c jwt.Claims) jwt.Claims {
+				c.Issuer = "intermediate"
+				return c
+			},
+		},
+		"expired": {
+			claims: func(c jwt.Claims) jwt.Claims {
+				c.Expiry = jwt.NewNumericDate(now.Add(-2 * time.Minute))
+				return c
+			},
+		},
+		"too old": {
+			claims: func(c jwt.Claims) jwt.Claims {
+				c.IssuedAt = jwt.NewNumericDate(now.Add(-10 * time.Minute))
+				return c
+			},
+		},
+		"no issue time": {
+			claims: func(c jwt.Claims) jwt.Claims {
+				c.IssuedAt = nil
+				return c
+			},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			claims := valid()
+			if tc.claims != nil {
+				claims = tc.claims(claims)
+			}
+			assertion, key := sign(t, "proxy-1", claims)
+			if tc.otherKey {
+				_, key = sign(t, "proxy-1", claims)
+			}
+			verifier := NewAssertionVerifier("", jose.JSONWebKeySet{Keys: []jose.JSONWebKey{key}}, "proxy", []string{"https://oauth.example.com"}, 5*time.Minute)
+			verifier.now = func() time.Time { return now }
+
+			req, _ := http.NewRequest(http.MethodGet, "https://oauth.example.com/oauth/authorize", nil)
+			switch tc.header {
+			case "":
+				req.Header.Set("X-Remote-User-Assertion", assertion)
+			case "-":
+			default:
+				req.Header.Set("X-Remote-User-Assertion", tc.header)
+			}
+
+			err := verifier.Verify(req, "bob")
+			if tc.expectedOK != (err == nil) {
+				t.Errorf("expected ok=%v, got %v", tc.expectedOK, err)
+			}
+		})
+	}
+}
@@ -2,9 +2,11 @@ package headerrequest
 
 import (
 	"net/http"
+	"net/url"
 	"strings"
 
 	"k8s.io/apiserver/pkg/authentication/authenticator"
+	"k8s.io/klog/v2"
 
 	authapi "github.com/openshift/oauth-server/pkg/api"
 	"github.com/openshift/oauth-server/pkg/audit"
@@ -20,6 +22,21 @@ type Config struct {
 	PreferredUsernameHeaders []string
 	// EmailHeaders lists the headers to check (in order, case-insensitively) for an email address. The first header with a value wins.
 	EmailHeaders []string
+
+	// GroupHeaders lists the headers to check (case-insensitively) for group names. The values of all headers are combined.
+	GroupHeaders []string
+	// GroupSeparator splits a single header value into multiple groups, e.g. "," for "admins,developers". If empty, every header value is a single group.
+	GroupSeparator string
+	// GroupPrefix is removed from group names that start with it, e.g. "role:" for proxies that qualify group names
+	GroupPrefix string
+	// ExtraHeaderPrefixes lists header prefixes (case-insensitive) whose headers are added to the identity's extra attributes.
+	// The key is the lower-cased remainder of the header name, e.g. X-Remote-Extra-Department for prefix X-Remote-Extra- is stored as department.
+	// Multiple values are joined by commas. Extra headers cannot override the attributes set by the other headers.
+	ExtraHeaderPrefixes []string
+
+	// Assertion verifies the signed assertion of the proxy for the identity, if it is set. Requests without a valid
+	// assertion fail to authenticate.
+	Assertion *AssertionVerifier
 }
 
 type Authenticator struct {
@@ -37,6 +54,12 @@ func (a *Authenticator) AuthenticateRequest(req *http.Request) (*authenticator.R
 	if len(id) == 0 {
 		return nil, false, nil
 	}
+	if a.config.Assertion != nil {
+		if err := a.config.Assertion.Verify(req, id); err != nil {
+			klog.V(2).Infof("Rejecting identity %q of identity provider %q relayed without a valid assertion: %v", id, a.providerName, err)
+			return nil, false, err
+		}
+	}
 
 	identity := authapi.NewDefaultUserIdentityInfo(a.providerName, id)
 
@@ -49,6 +72,14 @@ func (a *Authenticator) AuthenticateRequest(req *http.Request) (*authenticator.R
 	if preferredUsername := headerValue(req.Header, a.config.PreferredUsernameHeaders); len(preferredUsername) > 0 {
 		identity.Extra[authapi.IdentityPreferredUsernameKey] = preferredUsername
 	}
+	if groups := headerGroups(req.Header, a.config.GroupHeaders, a.config.GroupSeparator, a.config.GroupPrefix); len(groups) > 0 {
+		identity.Extra[authapi.IdentityGroupsKey] = strings.Join(groups, ",")
+	}
+	for key, value := range headerExtra(req.Header, a.config.ExtraHeaderPrefixes) {
+		if _, exists := identity.Extra[key]; !exists {
+			identity.Extra[key] = value
+		}
+	}
 
 	res, ok, err := identitymapper.ResponseFor(a.mapper, identity)
 	if res != nil && res.User != nil {
@@ -71,3 +102,65 @@ func headerValue(h http.Header, headerNames []string) string {
 	}
 	return ""
 }
+
+// headerGroups returns the distinct group names found in the given headers, in the order they appear
+func headerGroups(h http.Header, headerNames []string, separator, prefix string) []string {
+	groups := []string{}
+	seen := map[string]bool{}
+	for _, headerName := range headerNames {
+		headerName = strings.TrimSpace(headerName)
+		if len(headerName) == 0 {
+			continue
+		}
+		for _, headerValue := range h.Values(headerName) {
+			values := []string{headerValue}
+			if len(separator) > 0 {
+				values = strings.Split(headerValue, separator)
+			}
+			for _, group := range values {
+				group = strings.TrimPrefix(strings.TrimSpace(group), prefix)
+				if len(group) == 0 || seen[group] {
+					continue
+				}
+				seen[group] = true
+				groups = append(groups, group)
+			}
+		}
+	}
+	return groups
+}
+
+// headerExtra returns the extra attributes found in headers starting with one of the given prefixes
+func headerExtra(h http.Header, prefixes []string) map[string]string {
+	extra := map[string]string{}
+	for _, prefix := range prefixes {
+		prefix = strings.ToLower(strings.TrimSpace(prefix))
+		if len(prefix) == 0 {
+			continue
+		}
+		for headerName, headerValues := range h {
+			lowerName := strings.ToLower(headerName)
+			if !strings.HasPrefix(lowerName, prefix) {
+				continue
+			}
+			key := strings.TrimPrefix(lowerName, prefix)
+			// allow keys with characters that are not valid in header names, like the kube-apiserver does
+			if unescaped, err := url.PathUnescape(key); err == nil {
+				key = unescaped
+			}
+			if len(key) == 0 || len(extra[key]) > 0 {
+				continue
+			}
+			values := []string{}
+			for _, value := range headerValues {
+				if value = strings.TrimSpace(value); len(value) > 0 {
+					values = append(values, value)
+				}
+			}
+			if len(values) > 0 {
+				extra[key] = strings.Join(values, ",")
+			}
+		}
+	}
+	return extra
+}
@@ -97,6 +97,54 @@ func TestRequestHeader(t *testing.T) {
 				},
 			},
 		},
+		"groups and extra attributes": {
+			Config: Config{
+				IDHeaders:           []string{"x-id"},
+				EmailHeaders:        []string{"x-email"},
+				GroupHeaders:        []string{"x-forwarded-groups", "x-groups"},
+				GroupSeparator:      ",",
+				GroupPrefix:         "role:",
+				ExtraHeaderPrefixes: []string{"X-Remote-Extra-"},
+			},
+			RequestHeaders: http.Header{
+				"X-Id":                         {"bob"},
+				"X-Email":                      {"bob@example.com"},
+				"X-Forwarded-Groups":           {"role:admins, developers", "ops"},
+				"X-Groups":                     {"developers,,qa"},
+				"X-Remote-Extra-Department":    {"engineering", "research"},
+				"X-Remote-Extra-Cost%2fcenter": {"42"},
+				"X-Remote-Extra-Email":         {"mallory@example.com"},
+			},
+			ExpectedUsername: "bob",
+			ExpectedIdentity: &api.DefaultUserIdentityInfo{
+				ProviderName:     "testprovider",
+				ProviderUserName: "bob",
+				Extra: map[string]string{
+					api.IdentityEmailKey:  "bob@example.com",
+					api.IdentityGroupsKey: "admins,developers,ops,qa",
+					"department":          "engineering,research",
+					"cost/center":         "42",
+				},
+			},
+		},
+		"unsplit groups": {
+			Config: Config{
+				IDHeaders:    []string{"x-id"},
+				GroupHeaders: []string{"x-groups"},
+			},
+			RequestHeaders: http.Header{
+				"X-Id":     {"bob"},
+				"X-Groups": {"platform admins", "developers;qa"},
+			},
+			ExpectedUsername: "bob",
+			ExpectedIdentity: &api.DefaultUserIdentityInfo{
+				ProviderName:     "testprovider",
+				ProviderUserName: "bob",
+				Extra: map[string]string{
+					api.IdentityGroupsKey: "platform admins,developers;qa",
+				},
+			},
+		},
 	}
 
 	for k, testcase := range testcases {
@@ -0,0 +1,217 @@
+// Package throttle slows down guessing passwords with basic auth, e.g. with oc login. Every failed attempt of a source
+// IP to log in as a user delays the response to it for longer, and once it failed too often the credentials for the
+// user are no longer checked and the basic challenger stops asking for them, so that the identity providers are not
+// sent every guess. Failures are counted per source IP and username, so that the clients of a proxy that share its
+// address, e.g. behind a router without trusted proxies, cannot lock each other out, and per source IP, so that a
+// source cannot guess the passwords of many users.
+package throttle
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/cache"
+	"k8s.io/apiserver/pkg/authentication/authenticator"
+	"k8s.io/klog/v2"
+
+	oauthhandlers "github.com/openshift/oauth-server/pkg/oauth/handlers"
+	metrics "github.com/openshift/oauth-server/pkg/prometheus"
+	"github.com/openshift/oauth-server/pkg/server/clientip"
+)
+
+// maxTrackedSources bounds the memory used to count failures, the least recently failing sources are forgotten first
+const maxTrackedSources = 10000
+
+// Throttle counts the failed basic auth attempts of source IPs per username and in total. The response to the nth
+// failure of a source for a username is delayed by initialDelay doubled n-1 times, at most by maxDelay. Once a source
+// failed suppressAfter times for a username, its credentials for the username are ignored, and once it failed
+// suppressSourceAfter times in total, all its credentials are ignored. Failures are forgotten after window passed
+// without another failure, successful attempts do not reset them, so that knowing one password does not allow to keep
+// guessing others. The methods of a nil Throttle never slow down requests.
+type Throttle struct {
+	initialDelay        time.Duration
+	maxDelay            time.Duration
+	suppressAfter       int
+	suppressSourceAfter int
+	window              time.Duration
+
+	lock     sync.Mutex
+	failures *cache.LRUExpireCache
+	after    func(time.Duration) <-chan time.Time
+}
+
+// NewThrottle returns a Throttle that delays the responses to failed attempts and suppresses sources that failed
+// suppressAfter times within window to log in as a user, or suppressSourceAfter times for all users
+func NewThrottle(initialDelay, maxDelay time.Duration, suppressAfter, suppressSourceAfter int, window time.Duration) *Throttle {
+	return &Throttle{
+		initialDelay:        initialDelay,
+		maxDelay:            maxDelay,
+		suppressAfter:       suppressAfter,
+		suppressSourceAfter: suppressSourceAfter,
+		window:              window,
+		failures:            cache.NewLRUExpireCache(maxTrackedSources),
+		after:               time.After,
+	}
+}
+
+// failureKeys are the keys the failures of a request are counted under
+type failureKeys struct {
+	// source counts the failures of the source IP for all usernames
+	source string
+	// user counts the failures of the source IP for the username
+	user string
+}
+
+// Suppressed returns true if the source IP of the request failed too often, in total or to log in as the user of its
+// basic auth credentials. Requests without credentials are never suppressed.
+func (t *Throttle) Suppressed(req *http.Request) bool {
+	if t == nil {
+		return false
+	}
+	keys, ok := requestKeys(req)
+	if !ok {
+		return false
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.suppressed(keys)
+}
+
+func (t *Throttle) suppressed(keys failureKeys) bool {
+	return t.count(keys.user) >= t.suppressAfter || t.count(keys.source) >= t.suppressSourceAfter
+}
+
+// reserve counts an attempt as failed before its credentials are checked and returns the failures of the source for
+// the username including it, unless the source is suppressed. Concurrent attempts are counted as they start, so that
+// no more attempts than allowed are checked at once. The attempt is released if it did not fail.
+func (t *Throttle) reserve(keys failureKeys) (int, bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.suppressed(keys) {
+		return 0, false
+	}
+	t.failures.Add(keys.source, t.count(keys.source)+1, t.window)
+	failures := t.count(keys.user) + 1
+	t.failures.Add(keys.user, failures, t.window)
+	return failures, true
+}
+
+// release uncounts a reserved attempt that did not fail
+func (t *Throttle) release(keys failureKeys) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for _, key := range []string{keys.source, keys.user} {
+		if count := t.count(key); count > 1 {
+			t.failures.Add(key, count-1, t.window)
+		} else {
+			t.failures.Remove(key)
+		}
+	}
+}
+
+// delay returns how long the response to the nth failure is delayed
+func (t *Throttle) delay(failures int) time.Duration {
+	delay := t.initialDelay
+	for i := 1; i < failures && delay < t.maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > t.maxDelay {
+		delay = t.maxDelay
+	}
+	return delay
+}
+
+func (t *Throttle) count(key string) int {
+	if count, ok := t.failures.Get(key); ok {
+		return count.(int)
+	}
+	return 0
+}
+
+// Authenticator wraps the authenticator of basic auth credentials. Requests with credentials from suppressed sources
+// are not authenticated without checking them, and the responses to failed attempts are delayed.
+func (t *Throttle) Authenticator(delegate authenticator.Request) authenticator.Request {
+	if t == nil {
+		return delegate
+	}
+	return authenticator.RequestFunc(func(req *http.Request) (*authenticator.Response, bool, error) {
+		if !strings.HasPrefix(req.Header.Get("Authorization"), "Basic ") {
+			return delegate.AuthenticateRequest(req)
+		}
+		keys, ok := requestKeys(req)
+		if !ok {
+			return delegate.AuthenticateRequest(req)
+		}
+		failures, ok := t.reserve(keys)
+		if !ok {
+			username, _, _ := req.BasicAuth()
+			klog.V(4).Infof("Ignoring basic auth credentials for %q from %s, it failed to log in too often", username, clientip.SourceIP(req))
+			metrics.RecordBasicPasswordThrottle(metrics.SuppressedAction)
+			return nil, false, nil
+		}
+
+		resp, ok, err := delegate.AuthenticateRequest(req)
+		// errors of the identity providers do not tell whether the password was guessed wrong
+		if ok || err != nil {
+			t.release(keys)
+			return resp, ok, err
+		}
+		delay := t.delay(failures)
+		if delay <= 0 {
+			return resp, ok, err
+		}
+		metrics.RecordBasicPasswordThrottle(metrics.DelayedAction)
+		select {
+		case <-t.after(delay):
+		case <-req.Context().Done():
+		}
+		return resp, ok, err
+	})
+}
+
+// Challenger wraps the basic challenger. Suppressed sources are warned instead of challenged for the credentials they
+// sent, and told to retry once their failures are forgotten.
+func (t *Throttle) Challenger(delegate oauthhandlers.AuthenticationChallenger) oauthhandlers.AuthenticationChallenger {
+	if t == nil {
+		return delegate
+	}
+	return &challenger{throttle: t, delegate: delegate}
+}
+
+type challenger struct {
+	throttle *Throttle
+	delegate oauthhandlers.AuthenticationChallenger
+}
+
+func (c *challenger) AuthenticationChallenge(req *http.Request) (http.Header, error) {
+	if !c.throttle.Suppressed(req) {
+		return c.delegate.AuthenticationChallenge(req)
+	}
+	headers := http.Header{}
+	headers.Add("Warning",
+		fmt.Sprintf(
+			`%s %s "Too many failed logins, try again in %v"`,
+			oauthhandlers.WarningHeaderMiscCode,
+			oauthhandlers.WarningHeaderOpenShiftSource,
+			c.throttle.window,
+		),
+	)
+	headers.Set("Retry-After", strconv.Itoa(int(c.throttle.window.Seconds())))
+	return headers, nil
+}
+
+// requestKeys returns the keys of the failures of the source IP and the username of the basic auth credentials of req,
+// and false without credentials
+func requestKeys(req *http.Request) (failureKeys, bool) {
+	username, _, ok := req.BasicAuth()
+	if !ok {
+		return failureKeys{}, false
+	}
+	source := clientip.SourceIP(req)
+	// the IP does not contain a slash, the username may
+	return failureKeys{source: source, user: source + "/" + username}, true
+}
@@ -0,0 +1,177 @@
+package throttle
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apiserver/pkg/authentication/authenticator"
+	"k8s.io/apiserver/pkg/authentication/user"
+)
+
+type testChallenger struct{}
+
+func (testChallenger) AuthenticationChallenge(req *http.Request) (http.Header, error) {
+	return http.Header{"Www-Authenticate": {`Basic realm="openshift"`}}, nil
+}
+
+func TestThrottle(t *testing.T) {
+	throttle := NewThrottle(time.Second, 5*time.Second, 5, 8, time.Hour)
+	delays := []time.Duration{}
+	throttle.after = func(delay time.Duration) <-chan time.Time {
+		delays = append(delays, delay)
+		done := make(chan time.Time, 1)
+		done <- time.Time{}
+		return done
+	}
+
+	checked := 0
+	auth := throttle.Authenticator(authenticator.RequestFunc(func(req *http.Request) (*authenticator.Response, bool, error) {
+		checked++
+		username, password, _ := req.BasicAuth()
+		if password != "correct" {
+			return nil, false, nil
+		}
+		return &authenticator.Response{User: &user.DefaultInfo{Name: username}}, true, nil
+	}))
+	challenger := throttle.Challenger(testChallenger{})
+	request := func(remoteAddr, password string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/oauth/authorize", nil)
+		req.RemoteAddr = remoteAddr
+		if len(password) > 0 {
+			req.SetBasicAuth("alice", password)
+		}
+		return req
+	}
+
+	for i := 0; i < 4; i++ {
+		if _, ok, _ := auth.AuthenticateRequest(request("192.0.2.1:1234", "wrong")); ok {
+			t.Fatal("unexpected authentication")
+		}
+	}
+	// knowing the password does not reset the failures
+	if _, ok, _ := auth.AuthenticateRequest(request("192.0.2.1:1235", "correct")); !ok {
+		t.Error("expected the correct password to authenticate before the source is suppressed")
+	}
+	if _, ok, _ := auth.AuthenticateRequest(request("192.0.2.1:1236", "wrong")); ok {
+		t.Fatal("unexpected authentication")
+	}
+	if expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}; !reflect.DeepEqual(delays, expected) {
+		t.Errorf("expected delays %v, got %v", expected, delays)
+	}
+
+	checked = 0
+	if _, ok, _ := auth.AuthenticateRequest(request("192.0.2.1:1237", "correct")); ok || checked != 0 {
+		t.Errorf("expected the credentials of the suppressed source not to be checked, got %v %d", ok, checked)
+	}
+	// the challenger is asked again for the credentials that were ignored
+	headers, _ := challenger.AuthenticationChallenge(request("192.0.2.1:1238", "correct"))
+	if len(headers.Get("WWW-Authenticate")) > 0 || !strings.Contains(headers.Get("Warning"), "Too many failed logins") || headers.Get("Retry-After") != "3600" {
+		t.Errorf("expected the suppressed source to be warned instead of challenged, got %v", headers)
+	}
+
+	if headers, _ := challenger.AuthenticationChallenge(request("192.0.2.1:1238", "")); len(headers.Get("WWW-Authenticate")) == 0 {
+		t.Errorf("expected requests without credentials to be challenged, got %v", headers)
+	}
+
+	if _, ok, _ := auth.AuthenticateRequest(request("198.51.100.1:1234", "correct")); !ok || checked != 1 {
+		t.Error("expected other sources not to be suppressed")
+	}
+	// clients behind the same proxy share its address, they must not lock out each other
+	bob := request("192.0.2.1:1239", "")
+	bob.SetBasicAuth("bob", "correct")
+	if _, ok, _ := auth.AuthenticateRequest(bob); !ok || checked != 2 {
+		t.Error("expected other users of the source not to be suppressed")
+	}
+	if headers, _ := challenger.AuthenticationChallenge(bob); len(headers.Get("WWW-Authenticate")) == 0 {
+		t.Errorf("expected other users of the source to be challenged, got %v", headers)
+	}
+	if headers, _ := challenger.AuthenticationChallenge(request("198.51.100.1:1234", "")); len(headers.Get("WWW-Authenticate")) == 0 {
+		t.Errorf("expected other sources to be challenged, got %v", headers)
+	}
+
+	// the source failed 5 times for alice, guessing the passwords of other users is limited in total
+	for _, username := range []string{"carol", "dave", "erin"} {
+		guess := request("192.0.2.1:1240", "")
+		guess.SetBasicAuth(username, "wrong")
+		if _, ok, _ := auth.AuthenticateRequest(guess); ok {
+			t.Fatal("unexpected authentication")
+		}
+	}
+	checked = 0
+	if _, ok, _ := auth.AuthenticateRequest(bob); ok || checked != 0 {
+		t.Errorf("expected the credentials of a source that failed too often for all users not to be checked, got %v %d", ok, checked)
+	}
+
+	var nilThrottle *Throttle
+	if nilThrottle.Suppressed(request("192.0.2.1:1234", "wrong")) {
+		t.Error("expected a nil throttle not to slow down requests")
+	}
+}
+
+func TestThrottleConcurrentAttempts(t *testing.T) {
+	throttle := NewThrottle(time.Second, time.Second, 3, 30, time.Hour)
+	throttle.after = func(delay time.Duration) <-chan time.Time {
+		done := make(chan time.Time, 1)
+		done <- time.Time{}
+		return done
+	}
+
+	// the credentials of all attempts are checked at the same time, before any of them failed
+	started, release := make(chan struct{}), make(chan struct{})
+	auth := throttle.Authenticator(authenticator.RequestFunc(func(req *http.Request) (*authenticator.Response, bool, error) {
+		started <- struct{}{}
+		<-release
+		return nil, false, nil
+	}))
+
+	const attempts = 10
+	var checked int32
+	var wg sync.WaitGroup
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodGet, "/oauth/authorize", nil)
+			req.RemoteAddr = "192.0.2.1:1234"
+			req.SetBasicAuth("alice", "wrong")
+			auth.AuthenticateRequest(req)
+		}()
+	}
+	// suppressed attempts return without being checked, the others wait to be released
+	deadline := time.After(wait.ForeverTestTimeout)
+	for int(atomic.LoadInt32(&checked)) < 3 {
+		select {
+		case <-started:
+			atomic.AddInt32(&checked, 1)
+		case <-deadline:
+			t.Fatalf("expected 3 attempts to be checked, got %d", checked)
+		}
+	}
+	close(release)
+	wg.Wait()
+	select {
+	case <-started:
+		t.Error("expected the attempts over the limit not to be checked")
+	default:
+	}
+
+	// attempts that succeed do not count as failures
+	auth = throttle.Authenticator(authenticator.RequestFunc(func(req *http.Request) (*authenticator.Response, bool, error) {
+		return &authenticator.Response{User: &user.DefaultInfo{Name: "bob"}}, true, nil
+	}))
+	for i := 0; i < 5; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/oauth/authorize", nil)
+		req.RemoteAddr = "198.51.100.1:1234"
+		req.SetBasicAuth("bob", "correct")
+		if _, ok, _ := auth.AuthenticateRequest(req); !ok {
+			t.Fatalf("expected successful attempt %d to authenticate", i)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package benchmark
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openshift/osin"
+
+	"k8s.io/apiserver/pkg/authentication/user"
+
+	"github.com/openshift/oauth-server/pkg/osinserver"
+	"github.com/openshift/oauth-server/pkg/osinserver/teststorage"
+)
+
+const (
+	testClient   = "test-client"
+	testSecret   = "secret"
+	testRedirect = "http://localhost/redirect"
+	testUser     = "user"
+	testPassword = "password"
+)
+
+// newTestServer starts an in-process osin server that authenticates authorize requests using basic auth
+func newTestServer(t testing.TB) (*httptest.Server, Target) {
+	storage := teststorage.New()
+	storage.Clients[testClient] = &osin.DefaultClient{
+		Id:          testClient,
+		Secret:      testSecret,
+		RedirectUri: testRedirect,
+	}
+
+	server := osinserver.New(
+		osinserver.NewDefaultServerConfig(),
+		storage,
+		osinserver.AuthorizeHandlerFunc(func(ar *osin.AuthorizeRequest, resp *osin.Response, w http.ResponseWriter) (bool, error) {
+			username, password, ok := ar.HttpRequest.BasicAuth()
+			if !ok || username != testUser || password != testPassword {
+				w.WriteHeader(http.StatusUnauthorized)
+				return true, nil
+			}
+			ar.Authorized = true
+			ar.UserData = &user.DefaultInfo{Name: username}
+			return false, nil
+		}),
+		osinserver.AccessHandlerFunc(func(ar *osin.AccessRequest, w http.ResponseWriter) error {
+			ar.Authorized = true
+			ar.GenerateRefresh = false
+			return nil
+		}),
+		osinserver.NewDefaultErrorHandler(),
+		nil,
+		nil,
+		nil,
+		nil,
+		nil,
+		nil,
+	)
+
+	mux := http.NewServeMux()
+	server.Install(mux, "/oauth")
+	testServer := httptest.NewServer(mux)
+	t.Cleanup(testServer.Close)
+
+	return testServer, Target{
+		ServerURL:    testServer.URL,
+		ClientID:     testClient,
+		ClientSecret: testSecret,
+		RedirectURI:  testRedirect,
+		Username:     testUser,
+		Password:     testPassword,
+	}
+}
+
+func TestScenarios(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		scenario func(Target) Scenario
+	}{
+		{name: "code", scenario: NewCodeFlow},
+		{name: "password", scenario: NewPasswordFlow},
+		{name: "validate", scenario: NewTokenValidation},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server, target := newTestServer(t)
+
+			result := NewRunner(server.Client(), Options{Requests: 5}).Run(context.Background(), tc.scenario(target))
+			if result.Scenario != tc.name {
+				t.Errorf("expected scenario name %q, got %q", tc.name, result.Scenario)
+			}
+			if result.Total() != 5 {
+				t.Errorf("expected 5 runs, got %d", result.Total())
+			}
+			if result.Failures() != 0 {
+				t.Errorf("expected no failures, got %v", result.Errors)
+			}
+		})
+	}
+}
+
+func TestScenarioFailures(t *testing.T) {
+	server, target := newTestServer(t)
+	target.Password = "wrong"
+
+	result := NewRunner(server.Client(), Options{Requests: 3}).Run(context.Background(), NewPasswordFlow(target))
+	if result.Failures() != 3 {
+		t.Fatalf("expected 3 failures, got %d", result.Failures())
+	}
+	if result.Errors["authorize returned 401, expected 302"] != 3 {
+		t.Errorf("unexpected errors: %v", result.Errors)
+	}
+
+	report := &strings.Builder{}
+	result.Report(report)
+	if !strings.Contains(report.String(), "requests:   3 (3 failed)") {
+		t.Errorf("unexpected report:\n%s", report.String())
+	}
+}
+
+func TestResultPercentile(t *testing.T) {
+	result := &Result{Errors: map[string]int{}}
+	for i := 1; i <= 100; i++ {
+		result.record(time.Duration(i)*time.Millisecond, nil)
+	}
+	result.record(time.Duration(1000)*time.Millisecond, errors.New("boom"))
+
+	if p := result.Percentile(50); p != time.Duration(51)*time.Millisecond {
+		t.Errorf("unexpected p50 %s", p)
+	}
+	if p := result.Percentile(100); p != time.Duration(1000)*time.Millisecond {
+		t.Errorf("unexpected max %s", p)
+	}
+	if result.Failures() != 1 || result.Errors["boom"] != 1 {
+		t.Errorf("unexpected errors %v", result.Errors)
+	}
+}
+
+func BenchmarkCodeFlow(b *testing.B) {
+	benchmarkScenario(b, NewCodeFlow)
+}
+
+func BenchmarkPasswordFlow(b *testing.B) {
+	benchmarkScenario(b, NewPasswordFlow)
+}
+
+func BenchmarkTokenValidation(b *testing.B) {
+	benchmarkScenario(b, NewTokenValidation)
+}
+
+func benchmarkScenario(b *testing.B, newScenario func(Target) Scenario) {
+	server, target := newTestServer(b)
+	scenario := newScenario(target)
+	client := server.Client()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := scenario.Run(context.Background(), client); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package benchmark
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Options control how a Runner drives a Scenario
+type Options struct {
+	// Concurrency is the number of workers running the scenario in parallel
+	Concurrency int
+	// Requests is the total number of scenario runs. If zero, Duration is used instead.
+	Requests int
+	// Duration bounds how long the scenario is run for when Requests is zero
+	Duration time.Duration
+}
+
+// Runner runs a Scenario with the given Options and collects the results
+type Runner struct {
+	client  *http.Client
+	options Options
+}
+
+// NewRunner returns a Runner that uses client for all requests
+func NewRunner(client *http.Client, options Options) *Runner {
+	if options.Concurrency <= 0 {
+		options.Concurrency = 1
+	}
+	if options.Requests <= 0 && options.Duration <= 0 {
+		options.Requests = 1
+	}
+	return &Runner{client: client, options: options}
+}
+
+// Run executes the scenario until the configured number of requests has been
+// sent, the duration has passed or ctx is cancelled, whichever comes first.
+func (r *Runner) Run(ctx context.Context, scenario Scenario) *Result {
+	if r.options.Requests <= 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.options.Duration)
+		defer cancel()
+	}
+
+	work := make(chan struct{})
+	go func() {
+		defer close(work)
+		for i := 0; r.options.Requests <= 0 || i < r.options.Requests; i++ {
+			select {
+			case work <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	result := &Result{Scenario: scenario.Name(), Errors: map[string]int{}}
+	start := time.Now()
+
+	var wg sync.WaitGroup
+	for i := 0; i < r.options.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range work {
+				runStart := time.Now()
+				err := scenario.Run(ctx, r.client)
+				if err != nil && ctx.Err() != nil {
+					// the run was interrupted by the end of the benchmark, do not count it
+					return
+				}
+				result.record(time.Since(runStart), err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	result.Elapsed = time.Since(start)
+	return result
+}
+
+// Result holds the outcome of a benchmark run
+type Result struct {
+	Scenario string
+	Elapsed  time.Duration
+
+	// Errors counts failed runs by error message
+	Errors map[string]int
+
+	lock      sync.Mutex
+	latencies []time.Duration
+	failures  int
+}
+
+func (r *Result) record(latency time.Duration, err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.latencies = append(r.latencies, latency)
+	if err != nil {
+		r.failures++
+		r.Errors[err.Error()]++
+	}
+}
+
+// Total returns the number of completed runs
+func (r *Result) Total() int {
+	return len(r.latencies)
+}
+
+// Failures returns the number of runs that returned an error
+func (r *Result) Failures() int {
+	return r.failures
+}
+
+// Percentile returns the latency below which the given percentage of runs completed
+func (r *Result) Percentile(p float64) time.Duration {
+	if len(r.latencies) == 0 {
+		return 0
+	}
+	sorted := make([]time.Duration, len(r.latencies))
+	copy(sorted, r.latencies)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	idx := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	if idx >= len(sorted) {
+		idx = len(sorted) - 1
+	}
+	return sorted[idx]
+}
+
+// Throughput returns the number of completed runs per second
+func (r *Result) Throughput() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Total()) / r.Elapsed.Seconds()
+}
+
+// Report writes a human-readable summary of the result
+func (r *Result) Report(w io.Writer) {
+	fmt.Fprintf(w, "scenario:   %s\n", r.Scenario)
+	fmt.Fprintf(w, "requests:   %d (%d failed)\n", r.Total(), r.Failures())
+	fmt.Fprintf(w, "elapsed:    %s\n", r.Elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "throughput: %.2f/s\n", r.Throughput())
+	fmt.Fprintf(w, "latency:    p50=%s p90=%s p99=%s max=%s\n",
+		r.Percentile(50).Round(time.Microsecond),
+		r.Percentile(90).Round(time.Microsecond),
+		r.Percentile(99).Round(time.Microsecond),
+		r.Percentile(100).Round(time.Microsecond),
+	)
+
+	if len(r.Errors) == 0 {
+		return
+	}
+	messages := make([]string, 0, len(r.Errors))
+	for msg := range r.Errors {
+		messages = append(messages, msg)
+	}
+	sort.Strings(messages)
+	fmt.Fprintln(w, "errors:")
+	for _, msg := range messages {
+		fmt.Fprintf(w, "  %6d  %s\n", r.Errors[msg], msg)
+	}
+}
+
+// lazyToken caches a token that is obtained on first use
+type lazyToken struct {
+	lock  sync.Mutex
+	token string
+}
+
+func (t *lazyToken) get(fetch func() (string, error)) (string, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if len(t.token) > 0 {
+		return t.token, nil
+	}
+	token, err := fetch()
+	if err != nil {
+		return "", err
+	}
+	t.token = token
+	return token, nil
+}
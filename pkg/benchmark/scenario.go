@@ -0,0 +1,254 @@
+// Package benchmark drives synthetic OAuth flows against a running oauth-server
+// and reports latency and error statistics for them.
+package benchmark
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/openshift/library-go/pkg/oauth/oauthdiscovery"
+)
+
+// Scenario is a single synthetic operation against the OAuth server.
+// Implementations must be safe for concurrent use.
+type Scenario interface {
+	// Name identifies the scenario in reports
+	Name() string
+	// Run performs the operation once and returns an error if it did not succeed
+	Run(ctx context.Context, client *http.Client) error
+}
+
+// Target describes the OAuth server under test and the credentials used to drive it
+type Target struct {
+	// ServerURL is the base URL of the OAuth server, e.g. https://oauth-openshift.apps.example.com
+	ServerURL string
+
+	ClientID     string
+	ClientSecret string
+	RedirectURI  string
+
+	Username string
+	Password string
+
+	// Scopes requested by the code flow. Defaults to user:full if empty.
+	Scopes []string
+}
+
+func (t *Target) endpoint(p string) string {
+	return strings.TrimSuffix(t.ServerURL, "/") + path.Join(oauthdiscovery.OpenShiftOAuthAPIPrefix, p)
+}
+
+func (t *Target) scope() string {
+	if len(t.Scopes) == 0 {
+		return "user:full"
+	}
+	return strings.Join(t.Scopes, " ")
+}
+
+// NewCodeFlow returns a scenario that runs a full authorization code flow:
+// an authorize request authenticated with basic auth, followed by the exchange
+// of the returned code at the token endpoint.
+func NewCodeFlow(target Target) Scenario {
+	return &codeFlow{target: target}
+}
+
+type codeFlow struct {
+	target Target
+}
+
+func (c *codeFlow) Name() string { return "code" }
+
+func (c *codeFlow) Run(ctx context.Context, client *http.Client) error {
+	_, err := codeFlowToken(ctx, client, &c.target)
+	return err
+}
+
+func codeFlowToken(ctx context.Context, client *http.Client, target *Target) (string, error) {
+	q := url.Values{
+		"client_id":     {target.ClientID},
+		"response_type": {"code"},
+		"redirect_uri":  {target.RedirectURI},
+		"scope":         {target.scope()},
+		"state":         {"benchmark"},
+	}
+	location, err := authorize(ctx, client, target, q)
+	if err != nil {
+		return "", err
+	}
+	code := location.Query().Get("code")
+	if len(code) == 0 {
+		return "", fmt.Errorf("authorize redirect did not contain a code: %s", redactedLocation(location))
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"client_id":     {target.ClientID},
+		"client_secret": {target.ClientSecret},
+		"redirect_uri":  {target.RedirectURI},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target.endpoint(oauthdiscovery.TokenPath), strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token exchange returned %d", resp.StatusCode)
+	}
+
+	tokenResponse := struct {
+		AccessToken string `json:"access_token"`
+	}{}
+	if err := json.Unmarshal(body, &tokenResponse); err != nil {
+		return "", fmt.Errorf("unable to decode token response: %v", err)
+	}
+	if len(tokenResponse.AccessToken) == 0 {
+		return "", fmt.Errorf("token response did not contain an access token")
+	}
+	return tokenResponse.AccessToken, nil
+}
+
+// NewPasswordFlow returns a scenario that obtains a token the way "oc login -u" does:
+// an implicit grant authorize request authenticated with basic auth credentials.
+// This is the oauth-server equivalent of a resource owner password grant.
+func NewPasswordFlow(target Target) Scenario {
+	return &passwordFlow{target: target}
+}
+
+type passwordFlow struct {
+	target Target
+}
+
+func (p *passwordFlow) Name() string { return "password" }
+
+func (p *passwordFlow) Run(ctx context.Context, client *http.Client) error {
+	_, err := passwordFlowToken(ctx, client, &p.target)
+	return err
+}
+
+func passwordFlowToken(ctx context.Context, client *http.Client, target *Target) (string, error) {
+	q := url.Values{
+		"client_id":     {target.ClientID},
+		"response_type": {"token"},
+	}
+	if len(target.RedirectURI) > 0 {
+		q.Set("redirect_uri", target.RedirectURI)
+	}
+	location, err := authorize(ctx, client, target, q)
+	if err != nil {
+		return "", err
+	}
+	fragment, err := url.ParseQuery(location.Fragment)
+	if err != nil {
+		return "", fmt.Errorf("unable to parse authorize redirect fragment: %v", err)
+	}
+	token := fragment.Get("access_token")
+	if len(token) == 0 {
+		return "", fmt.Errorf("authorize redirect did not contain an access token: %s", redactedLocation(location))
+	}
+	return token, nil
+}
+
+// NewTokenValidation returns a scenario that repeatedly validates a single
+// access token against the OAuth server info endpoint. The token is obtained
+// once, lazily, using the password flow.
+func NewTokenValidation(target Target) Scenario {
+	return &tokenValidation{target: target}
+}
+
+type tokenValidation struct {
+	target Target
+	token  lazyToken
+}
+
+func (v *tokenValidation) Name() string { return "validate" }
+
+func (v *tokenValidation) Run(ctx context.Context, client *http.Client) error {
+	token, err := v.token.get(func() (string, error) {
+		return passwordFlowToken(ctx, client, &v.target)
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.target.endpoint(oauthdiscovery.InfoPath), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("token validation returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// authorize sends an authorize request with basic auth credentials and returns the redirect location
+func authorize(ctx context.Context, client *http.Client, target *Target, q url.Values) (*url.URL, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.endpoint(oauthdiscovery.AuthorizePath)+"?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(target.Username, target.Password)
+	// identify as a CLI-like client so that challenges are used instead of login pages
+	req.Header.Set("X-CSRF-Token", "1")
+
+	resp, err := noRedirects(client).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusFound {
+		return nil, fmt.Errorf("authorize returned %d, expected %d", resp.StatusCode, http.StatusFound)
+	}
+	location, err := resp.Location()
+	if err != nil {
+		return nil, err
+	}
+	if errCode := location.Query().Get("error"); len(errCode) > 0 {
+		return nil, fmt.Errorf("authorize returned error %q", errCode)
+	}
+	return location, nil
+}
+
+// noRedirects returns a shallow copy of the client that does not follow redirects
+func noRedirects(client *http.Client) *http.Client {
+	c := *client
+	c.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return &c
+}
+
+// redactedLocation strips secrets from a redirect location before it is used in errors
+func redactedLocation(u *url.URL) string {
+	c := *u
+	c.RawQuery, c.Fragment = "", ""
+	return c.String()
+}
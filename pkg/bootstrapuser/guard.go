@@ -0,0 +1,343 @@
+// Package bootstrapuser restricts the kube:admin bootstrap user, which logs in with the password in the kubeadmin
+// secret. Its password can be required to be rotated after its first login, and the bootstrap user can disable
+// itself once a user logged in with another identity provider.
+package bootstrapuser
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	kaudit "k8s.io/apiserver/pkg/audit"
+	"k8s.io/apiserver/pkg/authentication/authenticator"
+	"k8s.io/apiserver/pkg/authentication/user"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+
+	bootstrap "github.com/openshift/library-go/pkg/authentication/bootstrapauthenticator"
+
+	authapi "github.com/openshift/oauth-server/pkg/api"
+	"github.com/openshift/oauth-server/pkg/audit"
+)
+
+const (
+	// StateConfigMap is the config map in kube-system that holds the state of the bootstrap user. Deleting it
+	// enables a disabled bootstrap user again.
+	StateConfigMap = "kubeadmin-state"
+
+	// DefaultRotationGracePeriod is how long the password of the bootstrap user may be used after its first login
+	DefaultRotationGracePeriod = time.Hour
+
+	// values of the audit annotation
+	firstLogin       = "first-login"
+	rotationRequired = "rotation-required"
+	disabled         = "disabled"
+
+	// keys of the state config map
+	passwordFingerprintKey = "passwordFingerprint"
+	firstLoginKey          = "firstLogin"
+	disabledKey            = "disabled"
+	disabledByKey          = "disabledBy"
+
+	// secretName is the name of the secret in kube-system that holds the password hash of the bootstrap user
+	secretName = "kubeadmin"
+)
+
+// Policy restricts the bootstrap user
+type Policy struct {
+	// RequirePasswordRotation rejects the password of the bootstrap user once the grace period after its first
+	// login passed, until the password in the kubeadmin secret changes
+	RequirePasswordRotation bool
+	// RotationGracePeriod defaults to DefaultRotationGracePeriod
+	RotationGracePeriod time.Duration
+	// DisableAfterIdentityProviderLogin disables the bootstrap user once a user logged in with another identity provider
+	DisableAfterIdentityProviderLogin bool
+}
+
+// state is the content of the state config map
+type state struct {
+	// passwordFingerprint identifies the password hash that was first used at firstLogin
+	passwordFingerprint string
+	firstLogin          time.Time
+	// disabled is when the bootstrap user was disabled after a login with the disabledBy identity provider
+	disabled   time.Time
+	disabledBy string
+}
+
+// Guard enforces the policy of the bootstrap user. It implements bootstrap.BootstrapUserDataGetter, the bootstrap
+// user cannot log in, and its sessions are not valid, while the guard rejects it.
+type Guard struct {
+	getter     bootstrap.BootstrapUserDataGetter
+	configMaps corev1client.ConfigMapInterface
+	// lister reads the cached state config map once synced, if set
+	lister    corev1listers.ConfigMapNamespaceLister
+	hasSynced cache.InformerSynced
+	policy    Policy
+	recorder  record.EventRecorder
+	now       func() time.Time
+}
+
+// NewGuard returns a guard of the bootstrap user data of the getter. State changes are recorded as events of the
+// kubeadmin secret. The state is read from the API on every login.
+func NewGuard(getter bootstrap.BootstrapUserDataGetter, configMaps corev1client.ConfigMapsGetter, policy Policy, recorder record.EventRecorder) *Guard {
+	return NewGuardWithInformer(getter, configMaps, nil, policy, recorder)
+}
+
+// NewGuardWithInformer returns a guard that reads the state from the informer of config maps in kube-system, e.g. one
+// restricted to StateConfigMap, once it synced. Changes of the state are still read from and written to the API.
+func NewGuardWithInformer(getter bootstrap.BootstrapUserDataGetter, configMaps corev1client.ConfigMapsGetter, informer cache.SharedIndexInformer, policy Policy, recorder record.EventRecorder) *Guard {
+	if policy.RotationGracePeriod <= 0 {
+		policy.RotationGracePeriod = DefaultRotationGracePeriod
+	}
+	g := &Guard{
+		getter:     getter,
+		configMaps: configMaps.ConfigMaps(metav1.NamespaceSystem),
+		policy:     policy,
+		recorder:   recorder,
+		now:        time.Now,
+	}
+	if informer != nil {
+		g.lister = corev1listers.NewConfigMapLister(informer.GetIndexer()).ConfigMaps(metav1.NamespaceSystem)
+		g.hasSynced = informer.HasSynced
+	}
+	return g
+}
+
+// Get returns the bootstrap user data, unless the policy rejects the bootstrap user
+func (g *Guard) Get() (*bootstrap.BootstrapUserData, bool, error) {
+	data, ok, err := g.getter.Get()
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+	s, err := g.load()
+	if err != nil {
+		return nil, false, err
+	}
+	if len(g.rejection(s, data)) > 0 {
+		return nil, false, nil
+	}
+	return data, true, nil
+}
+
+// IsEnabled returns false once the bootstrap user is disabled. Bootstrap users that have to rotate their password
+// stay enabled, rotating the password lets them log in again.
+func (g *Guard) IsEnabled() (bool, error) {
+	enabled, err := g.getter.IsEnabled()
+	if err != nil || !enabled || !g.policy.DisableAfterIdentityProviderLogin {
+		return enabled, err
+	}
+	s, err := g.load()
+	if err != nil {
+		return false, err
+	}
+	return s.disabled.IsZero(), nil
+}
+
+// rejection returns why the policy rejects the bootstrap user with the given data, or an empty string
+func (g *Guard) rejection(s *state, data *bootstrap.BootstrapUserData) string {
+	if g.policy.DisableAfterIdentityProviderLogin && !s.disabled.IsZero() {
+		return disabled
+	}
+	if g.policy.RequirePasswordRotation && s.passwordFingerprint == passwordFingerprint(data) && !g.now().Before(s.firstLogin.Add(g.policy.RotationGracePeriod)) {
+		return rotationRequired
+	}
+	return ""
+}
+
+// Password returns the password authenticator of the bootstrap user, which records its first login with a password
+func (g *Guard) Password() bootstrap.Password {
+	return &password{guard: g, delegate: bootstrap.New(g.getter)}
+}
+
+type password struct {
+	guard    *Guard
+	delegate bootstrap.Password
+}
+
+func (p *password) AuthenticatePassword(ctx context.Context, username, password string) (*authenticator.Response, bool, error) {
+	resp, ok, err := p.delegate.AuthenticatePassword(ctx, username, password)
+	if err != nil || !ok {
+		return resp, ok, err
+	}
+
+	g := p.guard
+	data, ok, err := g.getter.Get()
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+	s, err := g.load()
+	if err != nil {
+		return nil, false, err
+	}
+	if reason := g.rejection(s, data); len(reason) > 0 {
+		klog.V(2).Infof("rejecting login of %s: %s", bootstrap.BootstrapUser, reason)
+		kaudit.AddAuditAnnotation(ctx, audit.BootstrapUserAnnotation, reason)
+		return nil, false, nil
+	}
+	if !g.policy.RequirePasswordRotation || s.passwordFingerprint == passwordFingerprint(data) {
+		return resp, true, nil
+	}
+
+	changed, err := g.update(func(s *state) bool {
+		if s.passwordFingerprint == passwordFingerprint(data) {
+			return false
+		}
+		s.passwordFingerprint = passwordFingerprint(data)
+		s.firstLogin = g.now()
+		return true
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("unable to record the first login of %s: %v", bootstrap.BootstrapUser, err)
+	}
+	if changed {
+		kaudit.AddAuditAnnotation(ctx, audit.BootstrapUserAnnotation, firstLogin)
+		g.event("BootstrapUserFirstLogin", "%s logged in for the first time with its current password, the password must be rotated within %s", bootstrap.BootstrapUser, g.policy.RotationGracePeriod)
+	}
+	return resp, true, nil
+}
+
+// UserIdentityMapper returns a mapper that disables the bootstrap user once the delegate mapped an identity to a user
+func (g *Guard) UserIdentityMapper(delegate authapi.UserIdentityMapper) authapi.UserIdentityMapper {
+	return &userMapper{guard: g, delegate: delegate}
+}
+
+type userMapper struct {
+	guard    *Guard
+	delegate authapi.UserIdentityMapper
+}
+
+func (m *userMapper) UserFor(identityInfo authapi.UserIdentityInfo) (user.Info, error) {
+	u, err := m.delegate.UserFor(identityInfo)
+	if err != nil {
+		return nil, err
+	}
+	// the login succeeds even if the bootstrap user could not be disabled, the next login tries again
+	if err := m.guard.disable(u.GetName(), identityInfo.GetProviderName()); err != nil {
+		utilruntime.HandleError(fmt.Errorf("unable to disable %s: %v", bootstrap.BootstrapUser, err))
+	}
+	return u, nil
+}
+
+// disable records that the bootstrap user was disabled by a login of the user with the provider
+func (g *Guard) disable(username, providerName string) error {
+	s, err := g.load()
+	if err != nil || !s.disabled.IsZero() {
+		return err
+	}
+	// do not create the state of clusters without a bootstrap user
+	if enabled, err := g.getter.IsEnabled(); err != nil || !enabled {
+		return err
+	}
+
+	changed, err := g.update(func(s *state) bool {
+		if !s.disabled.IsZero() {
+			return false
+		}
+		s.disabled = g.now()
+		s.disabledBy = providerName
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if changed {
+		klog.Infof("%s was disabled after user %q logged in with identity provider %q", bootstrap.BootstrapUser, username, providerName)
+		g.event("BootstrapUserDisabled", "%s was disabled after user %q logged in with identity provider %q", bootstrap.BootstrapUser, username, providerName)
+	}
+	return nil
+}
+
+// load returns the state of the bootstrap user, or an empty state if it has none
+func (g *Guard) load() (*state, error) {
+	var configMap *corev1.ConfigMap
+	var err error
+	if g.lister != nil && g.hasSynced() {
+		configMap, err = g.lister.Get(StateConfigMap)
+	} else {
+		configMap, err = g.configMaps.Get(context.TODO(), StateConfigMap, metav1.GetOptions{})
+	}
+	if kerrors.IsNotFound(err) {
+		return &state{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeState(configMap.Data), nil
+}
+
+// update changes the state of the bootstrap user and returns whether it was changed
+func (g *Guard) update(change func(s *state) bool) (bool, error) {
+	changed := false
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		configMap, err := g.configMaps.Get(context.TODO(), StateConfigMap, metav1.GetOptions{})
+		notFound := kerrors.IsNotFound(err)
+		if err != nil && !notFound {
+			return err
+		}
+		if notFound {
+			configMap = &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: StateConfigMap, Namespace: metav1.NamespaceSystem}}
+		}
+
+		s := decodeState(configMap.Data)
+		if changed = change(s); !changed {
+			return nil
+		}
+		configMap.Data = encodeState(s)
+		if notFound {
+			_, err = g.configMaps.Create(context.TODO(), configMap, metav1.CreateOptions{})
+			if kerrors.IsAlreadyExists(err) {
+				// retry with the config map of the other replica
+				return kerrors.NewConflict(corev1.Resource("configmaps"), StateConfigMap, err)
+			}
+			return err
+		}
+		_, err = g.configMaps.Update(context.TODO(), configMap, metav1.UpdateOptions{})
+		return err
+	})
+	return changed, err
+}
+
+func (g *Guard) event(reason, messageFmt string, args ...interface{}) {
+	if g.recorder == nil {
+		return
+	}
+	g.recorder.Eventf(&corev1.ObjectReference{Kind: "Secret", APIVersion: "v1", Namespace: metav1.NamespaceSystem, Name: secretName}, corev1.EventTypeNormal, reason, messageFmt, args...)
+}
+
+func decodeState(data map[string]string) *state {
+	s := &state{passwordFingerprint: data[passwordFingerprintKey], disabledBy: data[disabledByKey]}
+	// invalid times are treated as unset
+	s.firstLogin, _ = time.Parse(time.RFC3339, data[firstLoginKey])
+	s.disabled, _ = time.Parse(time.RFC3339, data[disabledKey])
+	return s
+}
+
+func encodeState(s *state) map[string]string {
+	data := map[string]string{}
+	if len(s.passwordFingerprint) > 0 {
+		data[passwordFingerprintKey] = s.passwordFingerprint
+		data[firstLoginKey] = s.firstLogin.UTC().Format(time.RFC3339)
+	}
+	if !s.disabled.IsZero() {
+		data[disabledKey] = s.disabled.UTC().Format(time.RFC3339)
+		data[disabledByKey] = s.disabledBy
+	}
+	return data
+}
+
+// passwordFingerprint identifies the password hash of the bootstrap user without revealing it. Unlike the UID of
+// the data, it does not change when the secret is updated without changing the password.
+func passwordFingerprint(data *bootstrap.BootstrapUserData) string {
+	hash := sha256.Sum256(data.PasswordHash)
+	return base64.RawURLEncoding.EncodeToString(hash[:16])
+}
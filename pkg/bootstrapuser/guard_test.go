@@ -0,0 +1,212 @@
+package bootstrapuser
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	apiaudit "k8s.io/apiserver/pkg/apis/audit"
+	kaudit "k8s.io/apiserver/pkg/audit"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+
+	bootstrap "github.com/openshift/library-go/pkg/authentication/bootstrapauthenticator"
+
+	authapi "github.com/openshift/oauth-server/pkg/api"
+	"github.com/openshift/oauth-server/pkg/audit"
+)
+
+const (
+	oldPassword = "aaaaa-bbbbb-ccccc-ddddd"
+	newPassword = "eeeee-fffff-ggggg-hhhhh"
+)
+
+type testGetter struct {
+	data    *bootstrap.BootstrapUserData
+	enabled bool
+}
+
+func (g *testGetter) Get() (*bootstrap.BootstrapUserData, bool, error) {
+	return g.data, g.enabled, nil
+}
+
+func (g *testGetter) IsEnabled() (bool, error) {
+	return g.enabled, nil
+}
+
+func newTestGetter(t *testing.T, password string) *testGetter {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testGetter{data: &bootstrap.BootstrapUserData{PasswordHash: hash, UID: password}, enabled: true}
+}
+
+type testMapper struct {
+	err error
+}
+
+func (m *testMapper) UserFor(identityInfo authapi.UserIdentityInfo) (user.Info, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	return &user.DefaultInfo{Name: identityInfo.GetProviderUserName()}, nil
+}
+
+// login logs in with the password and returns whether it succeeded and the bootstrap user audit annotation
+func login(t *testing.T, guard *Guard, password string) (bool, string) {
+	t.Helper()
+	req := httptest.NewRequest("POST", "/login", nil)
+	req = req.WithContext(kaudit.WithAuditAnnotations(req.Context()))
+	_, ok, err := guard.Password().AuthenticatePassword(req.Context(), "kubeadmin", password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev, err := kaudit.NewEventFromRequest(req, time.Now(), apiaudit.LevelRequestResponse, &authorizer.AttributesRecord{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ok, ev.Annotations[audit.BootstrapUserAnnotation]
+}
+
+func TestPasswordRotation(t *testing.T) {
+	getter := newTestGetter(t, oldPassword)
+	client := fake.NewSimpleClientset()
+	recorder := record.NewFakeRecorder(10)
+	guard := NewGuard(getter, client.CoreV1(), Policy{RequirePasswordRotation: true}, recorder)
+	now := time.Now()
+	guard.now = func() time.Time { return now }
+
+	if ok, annotation := login(t, guard, oldPassword); !ok || annotation != firstLogin {
+		t.Fatalf("expected the first login to succeed and be audited, got %v %q", ok, annotation)
+	}
+	if len(recorder.Events) != 1 {
+		t.Errorf("expected an event for the first login, got %d", len(recorder.Events))
+	}
+	if ok, annotation := login(t, guard, oldPassword); !ok || len(annotation) > 0 {
+		t.Errorf("expected logins within the grace period to succeed, got %v %q", ok, annotation)
+	}
+	if _, ok, _ := guard.Get(); !ok {
+		t.Errorf("expected the bootstrap user to be valid within the grace period")
+	}
+
+	now = now.Add(DefaultRotationGracePeriod)
+	if ok, annotation := login(t, guard, oldPassword); ok || annotation != rotationRequired {
+		t.Errorf("expected the password to require rotation, got %v %q", ok, annotation)
+	}
+	if _, ok, _ := guard.Get(); ok {
+		t.Errorf("expected the sessions of the bootstrap user to be invalid once the password must be rotated")
+	}
+	if enabled, _ := guard.IsEnabled(); !enabled {
+		t.Errorf("expected the bootstrap user to stay enabled")
+	}
+
+	// rotating the password starts another grace period
+	getter.data = newTestGetter(t, newPassword).data
+	if ok, annotation := login(t, guard, newPassword); !ok || annotation != firstLogin {
+		t.Errorf("expected the rotated password to log in, got %v %q", ok, annotation)
+	}
+	configMap, err := client.CoreV1().ConfigMaps(metav1.NamespaceSystem).Get(context.TODO(), StateConfigMap, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if configMap.Data[passwordFingerprintKey] != passwordFingerprint(getter.data) || configMap.Data[firstLoginKey] != now.UTC().Format(time.RFC3339) {
+		t.Errorf("unexpected state %v", configMap.Data)
+	}
+}
+
+func TestDisableAfterIdentityProviderLogin(t *testing.T) {
+	getter := newTestGetter(t, oldPassword)
+	client := fake.NewSimpleClientset()
+	recorder := record.NewFakeRecorder(10)
+	guard := NewGuard(getter, client.CoreV1(), Policy{DisableAfterIdentityProviderLogin: true}, recorder)
+	identity := authapi.NewDefaultUserIdentityInfo("ldap", "alice")
+
+	if _, err := guard.UserIdentityMapper(&testMapper{err: errors.New("denied")}).UserFor(identity); err == nil {
+		t.Fatal("expected the error of the delegate")
+	}
+	if ok, _ := login(t, guard, oldPassword); !ok {
+		t.Fatal("expected the bootstrap user to log in until another login succeeded")
+	}
+
+	if _, err := guard.UserIdentityMapper(&testMapper{}).UserFor(identity); err != nil {
+		t.Fatal(err)
+	}
+	if len(recorder.Events) != 1 {
+		t.Errorf("expected an event for disabling the bootstrap user, got %d", len(recorder.Events))
+	}
+	if ok, annotation := login(t, guard, oldPassword); ok || annotation != disabled {
+		t.Errorf("expected the bootstrap user to be disabled, got %v %q", ok, annotation)
+	}
+	if enabled, _ := guard.IsEnabled(); enabled {
+		t.Errorf("expected the bootstrap user to be disabled")
+	}
+	if _, ok, _ := guard.Get(); ok {
+		t.Errorf("expected the sessions of the bootstrap user to be invalid")
+	}
+
+	// further logins do not disable it again
+	if _, err := guard.UserIdentityMapper(&testMapper{}).UserFor(authapi.NewDefaultUserIdentityInfo("github", "bob")); err != nil {
+		t.Fatal(err)
+	}
+	configMap, err := client.CoreV1().ConfigMaps(metav1.NamespaceSystem).Get(context.TODO(), StateConfigMap, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if configMap.Data[disabledByKey] != "ldap" || len(recorder.Events) != 1 {
+		t.Errorf("expected the first login to be recorded once, got %v", configMap.Data)
+	}
+}
+
+func TestDisableWithoutBootstrapUser(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	guard := NewGuard(&testGetter{}, client.CoreV1(), Policy{DisableAfterIdentityProviderLogin: true}, nil)
+	if _, err := guard.UserIdentityMapper(&testMapper{}).UserFor(authapi.NewDefaultUserIdentityInfo("ldap", "alice")); err != nil {
+		t.Fatal(err)
+	}
+	if configMaps, _ := client.CoreV1().ConfigMaps(metav1.NamespaceSystem).List(context.TODO(), metav1.ListOptions{}); len(configMaps.Items) != 0 {
+		t.Errorf("expected no state for clusters without a bootstrap user, got %v", configMaps.Items)
+	}
+}
+
+func TestGuardWithInformer(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	informer := coreinformers.NewConfigMapInformer(client, metav1.NamespaceSystem, 0, cache.Indexers{})
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go informer.Run(stopCh)
+	if !cache.WaitForCacheSync(stopCh, informer.HasSynced) {
+		t.Fatal("informer did not sync")
+	}
+	guard := NewGuardWithInformer(newTestGetter(t, oldPassword), client.CoreV1(), informer, Policy{DisableAfterIdentityProviderLogin: true}, nil)
+
+	if _, err := guard.UserIdentityMapper(&testMapper{}).UserFor(authapi.NewDefaultUserIdentityInfo("ldap", "alice")); err != nil {
+		t.Fatal(err)
+	}
+	if err := wait.PollImmediate(10*time.Millisecond, wait.ForeverTestTimeout, func() (bool, error) {
+		enabled, err := guard.IsEnabled()
+		return !enabled, err
+	}); err != nil {
+		t.Fatalf("expected the informer to observe the disabled bootstrap user: %v", err)
+	}
+
+	client.ClearActions()
+	if ok, annotation := login(t, guard, oldPassword); ok || annotation != disabled {
+		t.Errorf("expected the bootstrap user to be disabled, got %v %q", ok, annotation)
+	}
+	if _, err := guard.UserIdentityMapper(&testMapper{}).UserFor(authapi.NewDefaultUserIdentityInfo("ldap", "bob")); err != nil {
+		t.Fatal(err)
+	}
+	if actions := client.Actions(); len(actions) != 0 {
+		t.Errorf("expected logins to read the state from the informer, got %v", actions)
+	}
+}
@@ -0,0 +1,178 @@
+package loadgen
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	knet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/client-go/util/cert"
+
+	"github.com/openshift/oauth-server/pkg/benchmark"
+)
+
+type LoadGenOptions struct {
+	Target  benchmark.Target
+	Options benchmark.Options
+
+	Scenarios []string
+
+	CAFile   string
+	Insecure bool
+	Timeout  time.Duration
+}
+
+func NewLoadGenCommand(out, errout io.Writer, stopCh <-chan struct{}) *cobra.Command {
+	options := &LoadGenOptions{
+		Options: benchmark.Options{
+			Concurrency: 10,
+			Duration:    30 * time.Second,
+		},
+		Scenarios: []string{"password"},
+		Timeout:   30 * time.Second,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "loadgen",
+		Short: "Drive synthetic OAuth flows against an OAuth server and report latencies",
+		Long: `Drive synthetic OAuth flows against an OAuth server and report latencies.
+
+Supported scenarios:
+  code      authorization code flow, authenticated with basic auth, followed by a code exchange
+  password  implicit grant authenticated with basic auth, as used by "oc login -u"
+  validate  validation of a single access token against the info endpoint
+
+The target should be a test instance, every run of the code and password
+scenarios creates an access token.`,
+		// errors are printed by main, which also sets the exit code
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := options.Validate(); err != nil {
+				return err
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go func() {
+				select {
+				case <-stopCh:
+					cancel()
+				case <-ctx.Done():
+				}
+			}()
+
+			return options.Run(ctx, out)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&options.Target.ServerURL, "server", options.Target.ServerURL, "Base URL of the OAuth server.")
+	flags.StringVar(&options.Target.ClientID, "client-id", "openshift-challenging-client", "OAuth client to request tokens for.")
+	flags.StringVar(&options.Target.ClientSecret, "client-secret", "", "Secret of the OAuth client, required for the code scenario.")
+	flags.StringVar(&options.Target.RedirectURI, "redirect-uri", "", "Redirect URI registered for the OAuth client.")
+	flags.StringVar(&options.Target.Username, "username", "", "Username used for basic auth.")
+	flags.StringVar(&options.Target.Password, "password", "", "Password used for basic auth.")
+	flags.StringSliceVar(&options.Target.Scopes, "scopes", nil, "Scopes requested in the code scenario.")
+	flags.StringSliceVar(&options.Scenarios, "scenario", options.Scenarios, "Scenarios to run, in order. One of code, password or validate.")
+	flags.IntVar(&options.Options.Concurrency, "concurrency", options.Options.Concurrency, "Number of parallel workers.")
+	flags.IntVar(&options.Options.Requests, "requests", 0, "Number of runs per scenario. If zero, --duration is used.")
+	flags.DurationVar(&options.Options.Duration, "duration", options.Options.Duration, "How long each scenario runs if --requests is not set.")
+	flags.DurationVar(&options.Timeout, "request-timeout", options.Timeout, "Timeout for a single HTTP request.")
+	flags.StringVar(&options.CAFile, "certificate-authority", "", "CA bundle used to verify the OAuth server.")
+	flags.BoolVar(&options.Insecure, "insecure-skip-tls-verify", false, "Do not verify the OAuth server certificate.")
+
+	return cmd
+}
+
+func (o *LoadGenOptions) Validate() error {
+	if len(o.Target.ServerURL) == 0 {
+		return errors.New("--server is required")
+	}
+	if len(o.Target.Username) == 0 || len(o.Target.Password) == 0 {
+		return errors.New("--username and --password are required")
+	}
+	if len(o.Scenarios) == 0 {
+		return errors.New("at least one --scenario is required")
+	}
+	for _, name := range o.Scenarios {
+		if _, err := o.scenario(name); err != nil {
+			return err
+		}
+		if name == "code" && (len(o.Target.ClientSecret) == 0 || len(o.Target.RedirectURI) == 0) {
+			return errors.New("the code scenario requires --client-secret and --redirect-uri")
+		}
+	}
+	return nil
+}
+
+// errRequestsFailed is returned once all scenarios ran if any run failed, so that the command exits non-zero
+var errRequestsFailed = errors.New("some requests failed")
+
+// Run runs all scenarios in order and writes their reports to out.
+// It returns errRequestsFailed if any scenario run failed.
+func (o *LoadGenOptions) Run(ctx context.Context, out io.Writer) error {
+	client, err := o.httpClient()
+	if err != nil {
+		return err
+	}
+
+	runner := benchmark.NewRunner(client, o.Options)
+	failed := false
+	for i, name := range o.Scenarios {
+		scenario, err := o.scenario(name)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			fmt.Fprintln(out)
+		}
+		result := runner.Run(ctx, scenario)
+		result.Report(out)
+		failed = failed || result.Failures() > 0
+
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if failed {
+		return errRequestsFailed
+	}
+	return nil
+}
+
+func (o *LoadGenOptions) scenario(name string) (benchmark.Scenario, error) {
+	switch name {
+	case "code":
+		return benchmark.NewCodeFlow(o.Target), nil
+	case "password":
+		return benchmark.NewPasswordFlow(o.Target), nil
+	case "validate":
+		return benchmark.NewTokenValidation(o.Target), nil
+	default:
+		return nil, fmt.Errorf("unknown scenario %q", name)
+	}
+}
+
+func (o *LoadGenOptions) httpClient() (*http.Client, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: o.Insecure}
+	if len(o.CAFile) > 0 {
+		roots, err := cert.NewPool(o.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading cert pool from ca file %s: %v", o.CAFile, err)
+		}
+		tlsConfig.RootCAs = roots
+	}
+
+	transport := knet.SetTransportDefaults(&http.Transport{TLSClientConfig: tlsConfig})
+	// every worker keeps its connection alive between runs
+	transport.MaxIdleConnsPerHost = o.Options.Concurrency
+
+	return &http.Client{Transport: transport, Timeout: o.Timeout}, nil
+}
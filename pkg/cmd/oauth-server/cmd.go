@@ -21,11 +21,16 @@ import (
 	"github.com/openshift/library-go/pkg/serviceability"
 
 	"github.com/openshift/oauth-server/pkg/audit"
+	oauthserverconfig "github.com/openshift/oauth-server/pkg/config"
+	"github.com/openshift/oauth-server/pkg/oauthserver"
 )
 
 type OsinServerOptions struct {
-	ConfigFile string
-	Audit      *options.AuditOptions
+	ConfigFile         string
+	ExtendedConfigFile string
+	// ReloadConfig rebuilds the OAuth handlers when the configuration files change
+	ReloadConfig bool
+	Audit        *options.AuditOptions
 }
 
 func NewOsinServerCommand(out, errout io.Writer, stopCh <-chan struct{}) (*cobra.Command, error) {
@@ -70,6 +75,13 @@ func NewOsinServerCommand(out, errout io.Writer, stopCh <-chan struct{}) (*cobra
 		return nil, err
 	}
 
+	flags.StringVar(&options.ExtendedConfigFile, "extended-config", "", "Location of an optional configuration file for settings that are not part of the osin configuration.")
+	if err := cmd.MarkFlagFilename("extended-config", "yaml", "yml", "json"); err != nil {
+		return nil, err
+	}
+
+	flags.BoolVar(&options.ReloadConfig, "reload-config", options.ReloadConfig, "Rebuild the identity providers and pages without a restart when the configuration files or the files they reference change.")
+
 	return cmd, nil
 }
 
@@ -86,24 +98,59 @@ func (o *OsinServerOptions) Validate() error {
 }
 
 func (o *OsinServerOptions) RunOsinServer(stopCh <-chan struct{}) error {
-	configContent, err := ioutil.ReadFile(o.ConfigFile)
+	config, err := readOsinServerConfig(o.ConfigFile)
 	if err != nil {
 		return err
 	}
 
+	extendedConfig, err := oauthserverconfig.ReadExtendedOAuthConfig(o.ExtendedConfigFile)
+	if err != nil {
+		return err
+	}
+
+	var reload *oauthserver.ConfigReload
+	if o.ReloadConfig {
+		reload = &oauthserver.ConfigReload{
+			Files: []string{o.ConfigFile},
+			Load: func() (*osinv1.OAuthConfig, *oauthserverconfig.ExtendedOAuthConfig, error) {
+				config, err := readOsinServerConfig(o.ConfigFile)
+				if err != nil {
+					return nil, nil, err
+				}
+				extendedConfig, err := oauthserverconfig.ReadExtendedOAuthConfig(o.ExtendedConfigFile)
+				if err != nil {
+					return nil, nil, err
+				}
+				return &config.OAuthConfig, extendedConfig, nil
+			},
+		}
+		if len(o.ExtendedConfigFile) > 0 {
+			reload.Files = append(reload.Files, o.ExtendedConfigFile)
+		}
+	}
+
+	return RunOsinServer(config, extendedConfig, o.Audit, reload, stopCh)
+}
+
+// readOsinServerConfig reads the osin configuration file
+func readOsinServerConfig(configFile string) (*osinv1.OsinServerConfig, error) {
+	configContent, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return nil, err
+	}
+
 	// TODO this probably needs to be updated to a container inside openshift/api/osin/v1
 	scheme := runtime.NewScheme()
 	utilruntime.Must(osinv1.Install(scheme))
 	codecs := serializer.NewCodecFactory(scheme)
 	obj, err := runtime.Decode(codecs.UniversalDecoder(osinv1.GroupVersion, configv1.GroupVersion), configContent)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	config, ok := obj.(*osinv1.OsinServerConfig)
 	if !ok {
-		return fmt.Errorf("expected OsinServerConfig, got %T", config)
+		return nil, fmt.Errorf("expected OsinServerConfig, got %T", obj)
 	}
-
-	return RunOsinServer(config, o.Audit, stopCh)
+	return config, nil
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"net/http"
+	"os"
 
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
@@ -17,13 +18,21 @@ import (
 	"k8s.io/apiserver/pkg/authorization/authorizer"
 	"k8s.io/apiserver/pkg/authorization/path"
 	genericapiserver "k8s.io/apiserver/pkg/server"
+	"k8s.io/apiserver/pkg/server/dynamiccertificates"
 	"k8s.io/apiserver/pkg/server/options"
 	genericapiserveroptions "k8s.io/apiserver/pkg/server/options"
+	"k8s.io/klog/v2"
 
+	configv1 "github.com/openshift/api/config/v1"
 	osinv1 "github.com/openshift/api/osin/v1"
 	"github.com/openshift/library-go/pkg/config/helpers"
 	"github.com/openshift/library-go/pkg/config/serving"
+
+	oauthserverconfig "github.com/openshift/oauth-server/pkg/config"
+	"github.com/openshift/oauth-server/pkg/logging"
 	"github.com/openshift/oauth-server/pkg/oauthserver"
+	"github.com/openshift/oauth-server/pkg/server/clientip"
+	"github.com/openshift/oauth-server/pkg/server/servingcert"
 
 	// for metrics
 	_ "github.com/openshift/library-go/pkg/controller/metrics"
@@ -31,16 +40,28 @@ import (
 
 // RunOsinServer starts a server that is based on the osin and kubernetes/apiserver frameworks.
 //
-// AuditOptions could be changed into a general options solution.
-func RunOsinServer(osinConfig *osinv1.OsinServerConfig, audit *options.AuditOptions, stopCh <-chan struct{}) error {
+// AuditOptions could be changed into a general options solution. The OAuth handlers are rebuilt when the
+// configuration changes if reload is set.
+func RunOsinServer(osinConfig *osinv1.OsinServerConfig, extendedConfig *oauthserverconfig.ExtendedOAuthConfig, audit *options.AuditOptions, reload *oauthserver.ConfigReload, stopCh <-chan struct{}) error {
 	if osinConfig == nil {
 		return errors.New("osin server requires non-empty oauthConfig")
 	}
 
-	oauthServerConfig, err := newOAuthServerConfig(osinConfig, audit)
+	if extendedConfig == nil {
+		extendedConfig = &oauthserverconfig.ExtendedOAuthConfig{}
+	}
+
+	if loggingConfig := extendedConfig.Logging; loggingConfig != nil && loggingConfig.Format == oauthserverconfig.LogFormatJSON {
+		klog.SetLogger(logging.NewJSONLogger(os.Stderr))
+	}
+
+	oauthServerConfig, err := newOAuthServerConfig(osinConfig, extendedConfig, audit)
 	if err != nil {
 		return err
 	}
+	if reload != nil {
+		oauthServerConfig.EnableReload(*reload)
+	}
 
 	oauthServer, err := oauthServerConfig.Complete().New(genericapiserver.NewEmptyDelegate())
 	if err != nil {
@@ -50,7 +71,7 @@ func RunOsinServer(osinConfig *osinv1.OsinServerConfig, audit *options.AuditOpti
 	return oauthServer.GenericAPIServer.PrepareRun().Run(stopCh)
 }
 
-func newOAuthServerConfig(osinConfig *osinv1.OsinServerConfig, audit *options.AuditOptions) (*oauthserver.OAuthServerConfig, error) {
+func newOAuthServerConfig(osinConfig *osinv1.OsinServerConfig, extendedConfig *oauthserverconfig.ExtendedOAuthConfig, audit *options.AuditOptions) (*oauthserver.OAuthServerConfig, error) {
 	scheme := runtime.NewScheme()
 	metav1.AddToGroupVersion(scheme, corev1.SchemeGroupVersion)
 	genericConfig := genericapiserver.NewRecommendedConfig(serializer.NewCodecFactory(scheme))
@@ -62,6 +83,16 @@ func newOAuthServerConfig(osinConfig *osinv1.OsinServerConfig, audit *options.Au
 	if err := servingOptions.ApplyTo(&genericConfig.Config.SecureServing, &genericConfig.Config.LoopbackClientConfig); err != nil {
 		return nil, err
 	}
+	if err := watchServingCertificates(genericConfig.Config.SecureServing, osinConfig.ServingInfo); err != nil {
+		return nil, err
+	}
+	if proxies := extendedConfig.TrustedProxies; proxies != nil && proxies.ProxyProtocol {
+		trusted, err := clientip.NewTrusted(proxies.CIDRs)
+		if err != nil {
+			return nil, err
+		}
+		genericConfig.Config.SecureServing.Listener = clientip.NewProxyProtocolListener(genericConfig.Config.SecureServing.Listener, trusted, clientip.DefaultHeaderTimeout)
+	}
 	if err := audit.ApplyTo(&genericConfig.Config); err != nil {
 		return nil, err
 	}
@@ -69,35 +100,71 @@ func newOAuthServerConfig(osinConfig *osinv1.OsinServerConfig, audit *options.Au
 	// the oauth-server must only run in http1 to avoid http2 connection re-use problems when improperly re-using a wildcard certificate
 	genericConfig.Config.SecureServing.DisableHTTP2 = true
 
-	authenticationOptions := genericapiserveroptions.NewDelegatingAuthenticationOptions()
-	authenticationOptions.ClientCert.ClientCA = osinConfig.ServingInfo.ClientCA
-	authenticationOptions.RemoteKubeConfigFile = osinConfig.KubeClientConfig.KubeConfig
-	if err := authenticationOptions.ApplyTo(&genericConfig.Authentication, genericConfig.SecureServing, genericConfig.OpenAPIConfig); err != nil {
+	if err := applyDelegatingAuth(genericConfig, osinConfig.ServingInfo.ClientCA, osinConfig.KubeClientConfig.KubeConfig); err != nil {
 		return nil, err
 	}
 
-	// These are paths for which we bypass kube authentication/authorization
-	// TODO better formalize / generate this list as trailing * matters
-	alwaysAllowedPaths := []string{ // The five sections are:
-		"/healthz", "/healthz/", // 1. Health checks (root, no wildcard)
-		"/oauth/*",           // 2. OAuth (wildcard)
-		"/login", "/login/*", // 3. Login (both root and wildcard)
-		"/logout", "/logout/", // 4. Logout (root, no wildcard)
-		"/oauth2callback/*", // 5. OAuth callbacks (wildcard)
+	// TODO You need real overrides for rate limiting
+	kubeClientConfig, err := helpers.GetKubeConfigOrInClusterConfig(osinConfig.KubeClientConfig.KubeConfig, osinConfig.KubeClientConfig.ConnectionOverrides)
+	if err != nil {
+		return nil, err
+	}
+
+	oauthServerConfig, err := oauthserver.NewOAuthServerConfig(osinConfig.OAuthConfig, *extendedConfig, kubeClientConfig, genericConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	oauthServerConfig.GenericConfig.CorsAllowedOriginList = osinConfig.CORSAllowedOrigins
+
+	return oauthServerConfig, nil
+}
+
+// alwaysAllowedPaths are the paths for which we bypass kube authentication/authorization. All other paths, e.g. the
+// /debug endpoints and /upstream-tokens, require the caller to be authorized for the non-resource URL.
+// TODO better formalize / generate this list as trailing * matters
+var alwaysAllowedPaths = []string{ // The nine sections are:
+	"/healthz", "/healthz/", // 1. Health checks (root, no wildcard)
+	"/oauth/*",           // 2. OAuth (wildcard)
+	"/login", "/login/*", // 3. Login (both root and wildcard)
+	"/logout", "/logout/", // 4. Logout (root, no wildcard)
+	"/logout/backchannel/*", // 5. Back-channel logout of identity providers (wildcard)
+	"/oauth2callback/*",     // 6. OAuth callbacks (wildcard)
+	"/static/*",             // 7. Static assets of the theme (wildcard)
+	"/readyz", "/livez",     // 8. Readiness and liveness probes (root, no wildcard)
+	"/scim/v2/*", // 9. SCIM provisioning of identity providers, authenticated with their own tokens (wildcard)
+}
+
+// applyDelegatingAuth authenticates and authorizes requests with the kube API server of kubeConfig, except for the
+// always allowed paths, whose requests are served as anonymous if they are not authenticated. Client certificates
+// are verified with the clientCA file, if set.
+func applyDelegatingAuth(genericConfig *genericapiserver.RecommendedConfig, clientCA, kubeConfig string) error {
+	authenticationOptions := genericapiserveroptions.NewDelegatingAuthenticationOptions()
+	authenticationOptions.ClientCert.ClientCA = clientCA
+	if len(clientCA) > 0 {
+		clientCABundle, err := servingcert.NewCABundle("client-ca-bundle", clientCA)
+		if err != nil {
+			return err
+		}
+		authenticationOptions.ClientCert.CAContentProvider = clientCABundle
+	}
+	authenticationOptions.RemoteKubeConfigFile = kubeConfig
+	if err := authenticationOptions.ApplyTo(&genericConfig.Authentication, genericConfig.SecureServing, genericConfig.OpenAPIConfig); err != nil {
+		return err
 	}
 
 	authorizationOptions := genericapiserveroptions.NewDelegatingAuthorizationOptions().
 		WithAlwaysAllowPaths(alwaysAllowedPaths...).
 		WithAlwaysAllowGroups(user.SystemPrivilegedGroup)
-	authorizationOptions.RemoteKubeConfigFile = osinConfig.KubeClientConfig.KubeConfig
+	authorizationOptions.RemoteKubeConfigFile = kubeConfig
 	if err := authorizationOptions.ApplyTo(&genericConfig.Authorization); err != nil {
-		return nil, err
+		return err
 	}
 
 	// set up a path authorizer so that we can check allowed paths in the authenticator below
 	pathAuthorizer, err := path.NewAuthorizer(alwaysAllowedPaths)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	anonymousAuthenticator := anonymous.NewAuthenticator()
@@ -119,18 +186,31 @@ func newOAuthServerConfig(osinConfig *osinv1.OsinServerConfig, audit *options.Au
 		}),
 	)
 
-	// TODO You need real overrides for rate limiting
-	kubeClientConfig, err := helpers.GetKubeConfigOrInClusterConfig(osinConfig.KubeClientConfig.KubeConfig, osinConfig.KubeClientConfig.ConnectionOverrides)
-	if err != nil {
-		return nil, err
-	}
+	return nil
+}
 
-	oauthServerConfig, err := oauthserver.NewOAuthServerConfig(osinConfig.OAuthConfig, kubeClientConfig, genericConfig)
-	if err != nil {
-		return nil, err
+// watchServingCertificates replaces the serving certificates with ones that are reloaded as soon as their files
+// change, so that rotated certificates are served without waiting for the generic API server to poll them
+func watchServingCertificates(secureServing *genericapiserver.SecureServingInfo, servingInfo configv1.HTTPServingInfo) error {
+	if secureServing == nil {
+		return nil
+	}
+	if len(servingInfo.CertFile) > 0 || len(servingInfo.KeyFile) > 0 {
+		cert, err := servingcert.NewCertKey("serving-cert", servingInfo.CertFile, servingInfo.KeyFile)
+		if err != nil {
+			return err
+		}
+		secureServing.Cert = cert
 	}
 
-	oauthServerConfig.GenericConfig.CorsAllowedOriginList = osinConfig.CORSAllowedOrigins
-
-	return oauthServerConfig, nil
+	sniCerts := make([]dynamiccertificates.SNICertKeyContentProvider, 0, len(servingInfo.NamedCertificates))
+	for _, namedCert := range servingInfo.NamedCertificates {
+		sniCert, err := servingcert.NewSNICertKey("sni-serving-cert", namedCert.CertFile, namedCert.KeyFile, namedCert.Names...)
+		if err != nil {
+			return err
+		}
+		sniCerts = append(sniCerts, sniCert)
+	}
+	secureServing.SNICerts = sniCerts
+	return nil
 }
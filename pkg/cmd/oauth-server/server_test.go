@@ -0,0 +1,116 @@
+package oauth_server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/util/sets"
+	genericapifilters "k8s.io/apiserver/pkg/endpoints/filters"
+	"k8s.io/apiserver/pkg/endpoints/request"
+	genericapiserver "k8s.io/apiserver/pkg/server"
+
+	"github.com/openshift/oauth-server/pkg/server/headers"
+)
+
+// fakeKubeAPIServer rejects all tokens and denies all subject access reviews, like the kube API server does for
+// tokens it does not know
+func fakeKubeAPIServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case strings.HasSuffix(req.URL.Path, "/tokenreviews"):
+			review := &authenticationv1.TokenReview{}
+			if err := json.NewDecoder(req.Body).Decode(review); err != nil {
+				t.Error(err)
+			}
+			review.Status = authenticationv1.TokenReviewStatus{Authenticated: false}
+			json.NewEncoder(w).Encode(review)
+		case strings.HasSuffix(req.URL.Path, "/subjectaccessreviews"):
+			review := &authorizationv1.SubjectAccessReview{}
+			if err := json.NewDecoder(req.Body).Decode(review); err != nil {
+				t.Error(err)
+			}
+			review.Status = authorizationv1.SubjectAccessReviewStatus{Allowed: false}
+			json.NewEncoder(w).Encode(review)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(&metav1.Status{Status: metav1.StatusFailure, Reason: metav1.StatusReasonNotFound, Code: http.StatusNotFound})
+		}
+	}))
+}
+
+func TestAlwaysAllowedPathsKeepTheirCredentials(t *testing.T) {
+	kubeAPIServer := fakeKubeAPIServer(t)
+	defer kubeAPIServer.Close()
+	kubeConfig := filepath.Join(t.TempDir(), "kubeconfig")
+	if err := ioutil.WriteFile(kubeConfig, []byte(`apiVersion: v1
+kind: Config
+clusters:
+- name: kube
+  cluster:
+    server: `+kubeAPIServer.URL+`
+contexts:
+- name: kube
+  context:
+    cluster: kube
+    user: oauth-server
+current-context: kube
+users:
+- name: oauth-server
+  user:
+    token: oauth-server-token
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	scheme := runtime.NewScheme()
+	codecs := serializer.NewCodecFactory(scheme)
+	genericConfig := genericapiserver.NewRecommendedConfig(codecs)
+	if err := applyDelegatingAuth(genericConfig, "", kubeConfig); err != nil {
+		t.Fatal(err)
+	}
+
+	// the parts of the generic handler chain that authenticate and authorize requests, as the OAuth server uses them
+	served := map[string]string{}
+	var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		served[req.URL.Path] = req.Header.Get("Authorization")
+	})
+	handler = headers.WithRestoreAuthorizationHeader(handler)
+	handler = genericapifilters.WithAuthorization(handler, genericConfig.Authorization.Authorizer, codecs)
+	handler = genericapifilters.WithAuthentication(handler, genericConfig.Authentication.Authenticator, genericapifilters.Unauthorized(codecs), nil)
+	handler = genericapifilters.WithRequestInfo(handler, &request.RequestInfoFactory{APIPrefixes: sets.NewString("api", "apis"), GrouplessAPIPrefixes: sets.NewString("api")})
+	handler = headers.WithPreserveAuthorizationHeader(handler)
+
+	for _, testCase := range []struct {
+		path   string
+		served bool
+	}{
+		{path: "/scim/v2/okta/Users", served: true},
+		{path: "/logout/backchannel/okta", served: true},
+		{path: "/debug/identity-providers", served: false},
+	} {
+		req := httptest.NewRequest(http.MethodGet, testCase.path, nil)
+		req.Header.Set("Authorization", "Bearer idp-token")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		authorization, ok := served[testCase.path]
+		if ok != testCase.served {
+			t.Errorf("%s: expected served %v, got status %d", testCase.path, testCase.served, w.Code)
+			continue
+		}
+		if ok && authorization != "Bearer idp-token" {
+			t.Errorf("%s: expected the handler to get the token of the request, got %q", testCase.path, authorization)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package oauth_server
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	genericapiserver "k8s.io/apiserver/pkg/server"
+
+	configv1 "github.com/openshift/api/config/v1"
+
+	oauthserverconfig "github.com/openshift/oauth-server/pkg/config"
+	"github.com/openshift/oauth-server/pkg/oauthserver"
+	"github.com/openshift/oauth-server/pkg/server/servingcert"
+)
+
+type ValidateOptions struct {
+	ConfigFile         string
+	ExtendedConfigFile string
+}
+
+// NewValidateCommand returns a command that checks the configuration files without starting the server, e.g. to
+// gate changes in CI. It exits with 1 if the configuration is invalid.
+func NewValidateCommand(out, errout io.Writer) (*cobra.Command, error) {
+	options := &ValidateOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "validate",
+		Short: "Validate the configuration of the osin server",
+		Long: "Validate loads the configuration files, builds every identity provider, template and key file they " +
+			"reference, and reports all errors at once with the paths of their fields. The cluster is not contacted, " +
+			"identity providers may be, e.g. to discover the metadata of OpenID providers.",
+		Run: func(c *cobra.Command, args []string) {
+			if errs := options.Validate(); len(errs) > 0 {
+				fmt.Fprintf(errout, "Invalid configuration %s\n", options.ConfigFile)
+				for _, err := range errs {
+					fmt.Fprintf(errout, "  %s: %s\n", err.Field, err.Detail)
+				}
+				os.Exit(1)
+			}
+			fmt.Fprintln(out, "The configuration is valid")
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&options.ConfigFile, "config", "", "Location of the osin configuration file to validate.")
+	if err := cmd.MarkFlagFilename("config", "yaml", "yml"); err != nil {
+		return nil, err
+	}
+	if err := cmd.MarkFlagRequired("config"); err != nil {
+		return nil, err
+	}
+	flags.StringVar(&options.ExtendedConfigFile, "extended-config", "", "Location of an optional configuration file for settings that are not part of the osin configuration.")
+	if err := cmd.MarkFlagFilename("extended-config", "yaml", "yml", "json"); err != nil {
+		return nil, err
+	}
+
+	return cmd, nil
+}
+
+// Validate returns the errors of the configuration files
+func (o *ValidateOptions) Validate() field.ErrorList {
+	errs := field.ErrorList{}
+
+	config, err := readOsinServerConfig(o.ConfigFile)
+	if err != nil {
+		errs = append(errs, field.Invalid(field.NewPath("config"), o.ConfigFile, err.Error()))
+	}
+	extendedConfig, err := oauthserverconfig.ReadExtendedOAuthConfig(o.ExtendedConfigFile)
+	if err != nil {
+		errs = append(errs, field.Invalid(field.NewPath("extendedConfig"), o.ExtendedConfigFile, err.Error()))
+	}
+	if config == nil || extendedConfig == nil {
+		return errs
+	}
+
+	errs = append(errs, validateServingInfo(field.NewPath("servingInfo"), config.ServingInfo)...)
+	return append(errs, oauthserver.ValidateConfig(config.OAuthConfig, *extendedConfig)...)
+}
+
+// validateServingInfo loads the serving certificates and the client CA
+func validateServingInfo(servingPath *field.Path, servingInfo configv1.HTTPServingInfo) field.ErrorList {
+	errs := field.ErrorList{}
+	if err := watchServingCertificates(&genericapiserver.SecureServingInfo{}, servingInfo); err != nil {
+		errs = append(errs, field.Invalid(servingPath, servingInfo.CertFile, err.Error()))
+	}
+	if clientCA := servingInfo.ClientCA; len(clientCA) > 0 {
+		if _, err := servingcert.NewCABundle("client-ca-bundle", clientCA); err != nil {
+			errs = append(errs, field.Invalid(servingPath.Child("clientCA"), clientCA, err.Error()))
+		}
+	}
+	return errs
+}
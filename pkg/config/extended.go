@@ -0,0 +1,2218 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/http/httpguts"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/yaml"
+
+	oauthv1 "github.com/openshift/api/oauth/v1"
+
+	"github.com/openshift/oauth-server/pkg/server/cookies"
+)
+
+// ExtendedOAuthConfig holds configuration for oauth-server features that are
+// not part of osinv1.OsinServerConfig. It is read from the optional file
+// passed via --extended-config, all fields are optional.
+type ExtendedOAuthConfig struct {
+	metav1.TypeMeta `json:",inline"`
+
+	// IdentityProviders holds additional settings for the identity providers
+	// configured in osinv1.OAuthConfig, matched by name
+	IdentityProviders []IdentityProviderExtension `json:"identityProviders,omitempty"`
+
+	// IdentityAuthorizationWebhook is called after an identity was mapped to a user and
+	// before the login succeeds. It can allow or deny the login and add extra attributes.
+	IdentityAuthorizationWebhook *IdentityAuthorizationWebhook `json:"identityAuthorizationWebhook,omitempty"`
+
+	// Deprovisioning revokes the tokens and sessions of users once they or one of their identities
+	// are deleted. It requires permission to list and delete OAuth access and authorize tokens.
+	Deprovisioning *Deprovisioning `json:"deprovisioning,omitempty"`
+
+	// SessionStorage configures where the values of login sessions are kept. By default, they are
+	// stored in the session cookie. Other storages keep them on the server, the cookie only holds an ID.
+	// Revocations of sessions, e.g. by deprovisioning and back-channel logouts, are kept in the storage
+	// as well, so that every server sharing it rejects the revoked sessions.
+	SessionStorage *SessionStorage `json:"sessionStorage,omitempty"`
+
+	// ProviderSelection configures the page that lets users choose an identity provider
+	ProviderSelection *ProviderSelection `json:"providerSelection,omitempty"`
+
+	// TokenLimit restricts the number of access tokens and sessions a user may hold at the same time.
+	// Limiting access tokens requires permission to list and delete OAuth access tokens.
+	TokenLimit *TokenLimit `json:"tokenLimit,omitempty"`
+
+	// TokenFormat configures which formats of access and authorize tokens the server still accepts, e.g. while
+	// tokens of an earlier format have not expired yet. Issued tokens always have the sha256~ prefix.
+	TokenFormat *TokenFormat `json:"tokenFormat,omitempty"`
+
+	// TokenGarbageCollection periodically deletes access and authorize tokens that expired or timed out.
+	// It requires permission to list and delete OAuth access and authorize tokens.
+	TokenGarbageCollection *TokenGarbageCollection `json:"tokenGarbageCollection,omitempty"`
+
+	// TokenStorage configures where access and authorize tokens are kept. By default, they are objects of the
+	// Kubernetes API. The API server does not know tokens of other storages, it has to authenticate them with a
+	// webhook token authenticator that posts TokenReviews to /oauth/tokenreview with a client certificate.
+	TokenStorage *TokenStorage `json:"tokenStorage,omitempty"`
+
+	// BootstrapUser restricts the kube:admin bootstrap user. Its state is kept in the kubeadmin-state config map in
+	// kube-system, which requires permission to get, list, watch, create and update it. Deleting the config map enables a
+	// disabled bootstrap user again.
+	BootstrapUser *BootstrapUser `json:"bootstrapUser,omitempty"`
+
+	// DeviceAuthorization lets tools without a browser log in with the device authorization grant (RFC 8628).
+	// Users enter the user code of a tool at /oauth/device and log in with any identity provider. Pending requests
+	// are kept in redis if sessions are stored there, else in memory, which requires a single replica.
+	DeviceAuthorization *DeviceAuthorization `json:"deviceAuthorization,omitempty"`
+
+	// Cookies configures the attributes of the session and CSRF cookies
+	Cookies *CookieAttributes `json:"cookies,omitempty"`
+
+	// CSRF configures the tokens that protect the forms of the server against cross-site request forgery
+	CSRF *CSRF `json:"csrf,omitempty"`
+
+	// SessionSecrets configures the rotation of the secrets that session cookies are signed and encrypted with.
+	// The sessionSecretsFile of the session config is reloaded whenever it changes, its first secret encodes new cookies.
+	SessionSecrets *SessionSecrets `json:"sessionSecrets,omitempty"`
+
+	// Theme replaces the built-in login, provider selection, grant and error pages with the
+	// templates of a directory, and serves its static assets at /static/
+	Theme *Theme `json:"theme,omitempty"`
+
+	// TermsOfService asks users to accept the terms of service before they are issued an authorize code.
+	// The version a user accepted is recorded in an annotation of the user, which requires permission
+	// to update users. Users that decline are redirected to the client with an access_denied error.
+	TermsOfService *TermsOfService `json:"termsOfService,omitempty"`
+
+	// SecurityHeaders overrides the Content-Security-Policy, Strict-Transport-Security, Referrer-Policy and
+	// X-Content-Type-Options headers and the sites that may embed the pages. Unset fields keep the defaults.
+	SecurityHeaders *SecurityHeaders `json:"securityHeaders,omitempty"`
+
+	// LoginChallenge requires users to solve a CAPTCHA or a proof-of-work challenge on the password login
+	// forms once their source IP or username failed to log in too often. Failures are counted per replica.
+	LoginChallenge *LoginChallenge `json:"loginChallenge,omitempty"`
+
+	// BasicAuthThrottle delays the responses to failed basic auth attempts of a source IP, e.g. password guesses with
+	// oc login, and stops checking its credentials for a user once it failed too often. Failures are counted per
+	// source IP and username, per source IP, and per replica. Behind a router or load balancer, TrustedProxies must be set, otherwise
+	// all clients share the address of the proxy and anyone can suppress the basic auth logins of a user by guessing
+	// their password.
+	BasicAuthThrottle *BasicAuthThrottle `json:"basicAuthThrottle,omitempty"`
+
+	// Redirects restricts where users are sent back to once they logged in, by default only to the authorize
+	// endpoint, and fails logins that keep sending users back to log in, e.g. because the browser drops the session
+	// cookie
+	Redirects *Redirects `json:"redirects,omitempty"`
+
+	// Maintenance turns away new logins with a 503 page that tells users that logins are disabled, e.g. while
+	// identity providers are migrated. Users that are logged in are still served, as are token, introspection and
+	// revocation requests. It is turned on and off by changing this setting, which applies when the configuration is
+	// reloaded or the server restarts, so that all replicas agree. Authorized GET requests to /debug/maintenance
+	// return its status.
+	Maintenance *Maintenance `json:"maintenance,omitempty"`
+
+	// Clients holds additional settings for OAuth clients, matched by name
+	Clients []ClientExtension `json:"clients,omitempty"`
+
+	// OAuthClients defines OAuthClients in the configuration, e.g. for bootstrap environments where the API is not
+	// the source of truth for clients. They are created or updated when the server starts, whenever the configuration
+	// is reloaded and periodically, which requires permission to get, list, create, update and delete OAuthClients.
+	// Defined clients that are removed from the configuration are deleted. OAuthClients that were created through
+	// the API are left alone.
+	OAuthClients *OAuthClients `json:"oauthClients,omitempty"`
+
+	// JARM signs the authorization responses of clients that ask for the jwt, query.jwt or fragment.jwt
+	// response modes, or that require signed responses. The public key is served at /oauth/jwks.
+	JARM *JARM `json:"jarm,omitempty"`
+
+	// Shutdown lets logins that are in progress complete before the server stops, e.g. during rolling updates
+	Shutdown *Shutdown `json:"shutdown,omitempty"`
+
+	// LoginBudget limits the time the server spends on each request of a login: the authorize request, the callback
+	// of the identity provider and the submitted login form. Their calls to identity providers and the identity
+	// mapping share the budget, the login fails once it is spent instead of keeping the user waiting.
+	LoginBudget *LoginBudget `json:"loginBudget,omitempty"`
+
+	// UserProvisioning tunes how identity mappers that provision users cope with an overloaded API server. Unless
+	// it is set, 5 mappings in a row that fail because the API server throttles or times out pause the provisioning
+	// for 30s, and logins show a page that asks the user to try again.
+	UserProvisioning *UserProvisioning `json:"userProvisioning,omitempty"`
+
+	// IdentityProviderHealth periodically checks that the token endpoints of OAuth identity providers, the URLs of
+	// basic auth and keystone identity providers and the LDAP servers are reachable, and that LDAP binds succeed.
+	// The results are served as JSON at /debug/identity-providers, which requires authorization.
+	IdentityProviderHealth *IdentityProviderHealth `json:"identityProviderHealth,omitempty"`
+
+	// IdentityProviderConnections tunes the connections to OAuth, LDAP, basic auth and keystone identity providers.
+	// Identity providers with the same CA and client certificate share their connections.
+	IdentityProviderConnections *IdentityProviderConnections `json:"identityProviderConnections,omitempty"`
+
+	// TrustedProxies are the proxies in front of the server, e.g. load balancers. Audit events, login rate limits and
+	// logs record the client addresses the proxies pass instead of the addresses of the proxies.
+	TrustedProxies *TrustedProxies `json:"trustedProxies,omitempty"`
+
+	// CORS lets browser applications on other origins call the token, info, JWKS and device authorization endpoints,
+	// e.g. single-page applications that are public clients and use the authorization code flow with PKCE. It applies
+	// to origins that do not match the corsAllowedOrigins of the osin config, which apply to all endpoints.
+	CORS *CORS `json:"cors,omitempty"`
+
+	// ExternalURLs configures servers that are deployed behind ingresses that route a path prefix to them or that are
+	// reachable under several hostnames. The masterPublicURL of the osin config must include the path prefix.
+	ExternalURLs *ExternalURLs `json:"externalURLs,omitempty"`
+
+	// Issuers are logical issuers hosted by the server in addition to the default one that the rest of the configuration
+	// describes, e.g. one per tenant. Each has its own identity providers, token lifetimes, branding and sessions.
+	// Requests for the host and path of the URL of an issuer are served by the issuer, other requests by the default
+	// issuer, which does not offer the identity providers of the issuers.
+	Issuers []Issuer `json:"issuers,omitempty"`
+
+	// Logging configures the format of the log lines. The verbosity can be changed while the server runs with
+	// authorized PUT requests to /debug/logging, e.g. ?verbosity=5&duration=15m.
+	Logging *Logging `json:"logging,omitempty"`
+
+	// ErrorPages configures the help shown on error pages and in their JSON variant, e.g. whom to ask for access
+	// when a login was denied.
+	ErrorPages *ErrorPages `json:"errorPages,omitempty"`
+
+	// Challengers configures the order in which the challengers of clients that respond with challenges are asked for
+	// WWW-Authenticate headers, which clients usually try in order. The challengers of a client can be restricted with
+	// the challengers of its client settings.
+	Challengers *Challengers `json:"challengers,omitempty"`
+
+	// SilentAuthentication configures authorize requests with prompt=none, which are answered without showing any page,
+	// e.g. to refresh the tokens of a console in a hidden iframe. They return login_required if the user has no session.
+	SilentAuthentication *SilentAuthentication `json:"silentAuthentication,omitempty"`
+
+	// AuthenticationContext defines the authentication context classes clients can require with the acr_values
+	// parameter of authorize requests. Users whose login does not satisfy a required class log in again with an
+	// identity provider that does. The methods and the highest satisfied class of a login are recorded in the tokens
+	// of the user as amr and acr, see /oauth/info and the token review endpoint.
+	AuthenticationContext *AuthenticationContext `json:"authenticationContext,omitempty"`
+
+	// PKCE enforces proof keys for code exchange (RFC 7636) in authorize requests for codes. Without it, code
+	// challenges are optional and may use the plain method. The openshift_auth_authorize_pkce_total metric counts
+	// the challenge methods of clients, so that requirements can be introduced once clients send challenges.
+	PKCE *PKCE `json:"pkce,omitempty"`
+
+	// Consent configures how long users' approvals of the scopes of clients last. Users are asked to approve scopes
+	// again once they expire, and can list and withdraw their approvals at /oauth/self/consents.
+	Consent *Consent `json:"consent,omitempty"`
+
+	// LoginAnomalies detects interactive logins from devices and regions users did not log in from before, and from
+	// another region shortly after their previous login. The devices and regions are kept in an annotation of the
+	// users, which requires permission to update users.
+	LoginAnomalies *LoginAnomalies `json:"loginAnomalies,omitempty"`
+
+	// IdentityConflicts serves /debug/identity-conflicts, which describes the links between an identity and a user on
+	// GET and re-links or merges them on PUT, e.g. ?identity=idp:bob&user=bob&resolution=merge. Callers must be
+	// allowed to get, or to update, the users and the identity involved. The endpoint is not served without it.
+	IdentityConflicts *IdentityConflicts `json:"identityConflicts,omitempty"`
+
+	// UpstreamTokens keeps the access and refresh tokens of logins with the identity providers that opt in with
+	// keepUpstreamTokens, so that in-cluster components can call the API of a provider on behalf of its users. They
+	// are retrieved with GET and refreshed with POST on /upstream-tokens?identity=<identity> or
+	// ?user=<user>&provider=<provider>, which requires authorization for that non-resource URL. Users may use their
+	// own tokens, the tokens of other users require permission to get, or to update, their users/upstreamtokens
+	// subresource. The tokens are deleted with the user by deprovisioning, forced logouts and SCIM.
+	UpstreamTokens *UpstreamTokens `json:"upstreamTokens,omitempty"`
+}
+
+// ClientExtension holds additional settings for a single OAuth client
+type ClientExtension struct {
+	// Name must match the name of an OAuthClient
+	Name string `json:"name"`
+
+	// JWKSFile holds the public keys of the client as a JWK set. They verify the request objects the client
+	// passes in the request parameter of authorize requests (JAR, RFC 9101). Request objects must be issued by
+	// the client, have the masterPublicURL as audience and expire.
+	JWKSFile string `json:"jwksFile,omitempty"`
+	// RequireRequestObject rejects authorize requests of the client that do not have a signed request object
+	RequireRequestObject bool `json:"requireRequestObject,omitempty"`
+	// RequireJARM returns all authorization responses to the client as signed JWTs. It requires the jarm config.
+	RequireJARM bool `json:"requireJARM,omitempty"`
+
+	// TLSClientAuth authenticates the client at the token endpoint with its TLS client certificate instead of
+	// its secret (RFC 8705). Token requests of the client without a matching certificate are rejected.
+	TLSClientAuth *TLSClientAuth `json:"tlsClientAuth,omitempty"`
+
+	// DPoP is Allowed or Required. The access tokens of clients that may use DPoP are bound to the key of
+	// the DPoP proof of their token request (RFC 9449). Proofs of other clients are ignored.
+	DPoP DPoPPolicy `json:"dpop,omitempty"`
+
+	// TokenPolicy overrides the token lifetimes and scope restrictions of the OAuthClient
+	TokenPolicy *ClientTokenPolicy `json:"tokenPolicy,omitempty"`
+
+	// Resources are the resource indicators the client may pass in authorize and token requests to restrict
+	// the audience of its access tokens (RFC 8707). Resource parameters of clients without resources are ignored.
+	Resources []string `json:"resources,omitempty"`
+
+	// TokenExchange allows the client to exchange the access tokens of users for tokens with fewer scopes,
+	// restricted audiences or for other users to act for them (RFC 8693)
+	TokenExchange *TokenExchange `json:"tokenExchange,omitempty"`
+
+	// Challengers are the names of the challengers that respond to the client if it responds with challenges,
+	// e.g. basic-challenge. By default, all challengers respond.
+	Challengers []string `json:"challengers,omitempty"`
+
+	// IdentityProviders are the names of the identity providers the client offers its users, e.g. only the
+	// corporate SSO for the console. Challengers only respond to the client if they ask for the credentials of one
+	// of them. Users that logged in with another provider, e.g. that have a session of another provider or send
+	// its credentials, are asked to log in again. By default, all identity providers are offered.
+	IdentityProviders []string `json:"identityProviders,omitempty"`
+
+	// MaxAgeSeconds is the default of the max_age parameter of the authorize requests of the client. Users who
+	// logged in longer ago log in again, 0 asks them to log in for every authorize request.
+	MaxAgeSeconds *int32 `json:"maxAgeSeconds,omitempty"`
+
+	// PKCE overrides the pkce settings for the authorize requests of the client
+	PKCE *ClientPKCE `json:"pkce,omitempty"`
+
+	// ConsentVersion is the version of the consent users give the client, e.g. the date of its privacy policy.
+	// Changing it asks all users of the client to approve its scopes again.
+	ConsentVersion string `json:"consentVersion,omitempty"`
+
+	// RedirectURIs determines how the redirect URIs of requests of the client are matched against the redirect
+	// URIs of the OAuthClient. By default, they match a redirect URI with the same scheme and host, and its path or
+	// a subpath of it.
+	RedirectURIs *ClientRedirectURIs `json:"redirectURIs,omitempty"`
+
+	// NativeApp designates a public client that runs on the devices of its users, e.g. a desktop tool that cannot
+	// keep a secret (RFC 8252). It must use the authorization code flow with S256 code challenges. Its redirect URIs
+	// on loopback interfaces match any port, and redirect URIs with private-use schemes, e.g.
+	// com.example.app:/callback, match exactly.
+	NativeApp bool `json:"nativeApp,omitempty"`
+}
+
+// ClientRedirectURIs determines which redirect URIs match the redirect URIs of a client
+type ClientRedirectURIs struct {
+	// Matching is Prefix or Exact. Defaults to Prefix.
+	Matching RedirectURIMatching `json:"matching,omitempty"`
+	// LoopbackAnyPort matches redirect URIs on loopback interfaces with any port, e.g. http://127.0.0.1/callback
+	// matches http://127.0.0.1:51004/callback, for native apps that listen on a port assigned by the operating
+	// system (RFC 8252)
+	LoopbackAnyPort bool `json:"loopbackAnyPort,omitempty"`
+}
+
+// RedirectURIMatching determines how much of a redirect URI of a client a redirect URI must match
+type RedirectURIMatching string
+
+const (
+	// RedirectURIMatchingPrefix matches redirect URIs with the same scheme and host, and the same path or a subpath
+	RedirectURIMatchingPrefix RedirectURIMatching = "Prefix"
+	// RedirectURIMatchingExact only matches the redirect URIs of the client themselves
+	RedirectURIMatchingExact RedirectURIMatching = "Exact"
+)
+
+// LoginAnomalies configures the detection of anomalous logins. Anomalous logins are annotated in audit events with
+// authentication.openshift.io/anomaly and counted by the openshift_auth_login_anomalies_total metric.
+type LoginAnomalies struct {
+	// RegionsFile maps the addresses of clients to coarse regions, one network in CIDR notation and its region per
+	// line, e.g. "192.0.2.0/24 eu-west". Without it, only logins from new devices are detected.
+	RegionsFile string `json:"regionsFile,omitempty"`
+	// ImpossibleTravelWindow is the time after a login in which a login from another region is reported as
+	// impossible travel, e.g. 2h. By default, it is not reported.
+	ImpossibleTravelWindow metav1.Duration `json:"impossibleTravelWindow,omitempty"`
+	// MaxFingerprints is the number of devices and regions remembered per user. Defaults to 10.
+	MaxFingerprints int `json:"maxFingerprints,omitempty"`
+	// RequiredMethods are the authentication methods that anomalous logins must have used, e.g. ["mfa"]. Other
+	// anomalous logins are rejected, so that users log in again with an identity provider that provides them.
+	RequiredMethods []string `json:"requiredMethods,omitempty"`
+	// Webhook is sent a LoginAnomalyNotification for every anomalous login, e.g. to warn the user
+	Webhook *LoginAnomalyWebhook `json:"webhook,omitempty"`
+}
+
+// UpstreamTokens configures where the tokens of identity providers are kept
+type UpstreamTokens struct {
+	// Namespace holds one secret per user and identity provider with the encrypted tokens. It requires permission to
+	// get, list, create, update and delete secrets in the namespace.
+	Namespace string `json:"namespace"`
+	// SecretFile holds the secret the tokens are encrypted with, e.g. 32 random bytes. Tokens encrypted with a
+	// previous secret cannot be read anymore, users have to log in again.
+	SecretFile string `json:"secretFile"`
+}
+
+// LoginAnomalyWebhook configures the endpoint that is notified of anomalous logins
+type LoginAnomalyWebhook struct {
+	// URL is the https endpoint that notifications are POSTed to
+	URL string `json:"url"`
+	// CA is an optional file with trusted certificate authorities for the endpoint
+	CA string `json:"ca,omitempty"`
+	// CertFile and KeyFile are an optional client certificate presented to the endpoint
+	CertFile string `json:"certFile,omitempty"`
+	KeyFile  string `json:"keyFile,omitempty"`
+	// Timeout limits the duration of a single call. Defaults to 10s.
+	Timeout metav1.Duration `json:"timeout,omitempty"`
+}
+
+// Consent configures the approvals of scopes
+type Consent struct {
+	// MaxAge is how long the approval of a scope lasts, e.g. 2160h. By default, approvals do not expire.
+	MaxAge metav1.Duration `json:"maxAge,omitempty"`
+}
+
+// PKCE determines which authorize requests for codes must have a code_challenge
+type PKCE struct {
+	// RequireForPublicClients rejects requests of clients without a secret that do not have a code_challenge
+	RequireForPublicClients bool `json:"requireForPublicClients,omitempty"`
+	// RequireForConfidentialClients rejects requests of clients with a secret that do not have a code_challenge
+	RequireForConfidentialClients bool `json:"requireForConfidentialClients,omitempty"`
+	// AllowPlain accepts the plain code_challenge_method. By default only S256 is accepted.
+	AllowPlain bool `json:"allowPlain,omitempty"`
+}
+
+// ClientPKCE overrides the pkce settings that are set for a client
+type ClientPKCE struct {
+	// Required rejects requests of the client that do not have a code_challenge
+	Required *bool `json:"required,omitempty"`
+	// AllowPlain accepts the plain code_challenge_method of the client
+	AllowPlain *bool `json:"allowPlain,omitempty"`
+}
+
+// TokenExchange determines which tokens a client may exchange. * allows all users.
+type TokenExchange struct {
+	// Subjects are the names of the users whose access tokens the client may exchange
+	Subjects []string `json:"subjects"`
+	// Actors are the names of the users that may act for the subjects. Their access tokens are passed as actor
+	// tokens and recorded in the exchanged tokens. Without actors, requests with actor tokens are rejected.
+	Actors []string `json:"actors,omitempty"`
+	// Audiences are the audiences the client may restrict the exchanged tokens to
+	Audiences []string `json:"audiences,omitempty"`
+}
+
+// ClientTokenPolicy overrides the settings of an OAuthClient, it is enforced when grants are checked and tokens
+// are issued
+type ClientTokenPolicy struct {
+	// AccessTokenMaxAgeSeconds overrides the lifetime of the access tokens of the client
+	AccessTokenMaxAgeSeconds *int32 `json:"accessTokenMaxAgeSeconds,omitempty"`
+	// AccessTokenInactivityTimeoutSeconds overrides the inactivity timeout of the access tokens of the client.
+	// 0 disables the timeout, otherwise it must be at least 300.
+	AccessTokenInactivityTimeoutSeconds *int32 `json:"accessTokenInactivityTimeoutSeconds,omitempty"`
+	// ScopeRestrictions replace the scope restrictions of the OAuthClient, they may restrict or expand the
+	// scopes the client may request
+	ScopeRestrictions []oauthv1.ScopeRestriction `json:"scopeRestrictions,omitempty"`
+	// AllowAllScopes lets the client request all scopes, regardless of the restrictions of the OAuthClient
+	AllowAllScopes bool `json:"allowAllScopes,omitempty"`
+}
+
+// OAuthClients holds the definitions of OAuthClients
+type OAuthClients struct {
+	// Clients are defined inline
+	Clients []OAuthClientDefinition `json:"clients,omitempty"`
+	// Files hold lists of client definitions in YAML or JSON, e.g. the keys of a mounted config map
+	Files []string `json:"files,omitempty"`
+}
+
+// OAuthClientDefinition defines an OAuthClient
+type OAuthClientDefinition struct {
+	// Name is the client_id of the client
+	Name string `json:"name"`
+	// SecretFile holds the secret of the client, surrounding whitespace is ignored. Clients without a secret are
+	// public clients.
+	SecretFile string `json:"secretFile,omitempty"`
+	// RedirectURIs are the URIs the client may be redirected to
+	RedirectURIs []string `json:"redirectURIs"`
+	// GrantMethod is auto, prompt or deny. Defaults to the grant method of the server.
+	GrantMethod oauthv1.GrantHandlerType `json:"grantMethod,omitempty"`
+	// ScopeRestrictions restrict the scopes the client may request, it may request any scope without them
+	ScopeRestrictions []oauthv1.ScopeRestriction `json:"scopeRestrictions,omitempty"`
+	// RespondWithChallenges asks for credentials with challenges instead of redirects, e.g. for command line tools
+	RespondWithChallenges bool `json:"respondWithChallenges,omitempty"`
+	// AccessTokenMaxAgeSeconds overrides the lifetime of the access tokens of the client
+	AccessTokenMaxAgeSeconds *int32 `json:"accessTokenMaxAgeSeconds,omitempty"`
+	// AccessTokenInactivityTimeoutSeconds overrides the inactivity timeout of the access tokens of the client.
+	// 0 disables the timeout, otherwise it must be at least 300.
+	AccessTokenInactivityTimeoutSeconds *int32 `json:"accessTokenInactivityTimeoutSeconds,omitempty"`
+}
+
+// DPoPPolicy determines whether a client binds its access tokens to DPoP keys
+type DPoPPolicy string
+
+const (
+	// DPoPAllowed binds the access tokens of token requests with DPoP proofs
+	DPoPAllowed DPoPPolicy = "Allowed"
+	// DPoPRequired rejects token requests without DPoP proofs
+	DPoPRequired DPoPPolicy = "Required"
+)
+
+// TLSClientAuth describes the certificates that authenticate a client. Every field that is set must match.
+type TLSClientAuth struct {
+	// CAFile holds the PEM encoded CA bundle that verifies the client certificates. It may hold the
+	// self-signed certificate of the client itself.
+	CAFile string `json:"caFile"`
+	// SubjectDN is the expected subject distinguished name of the certificate in RFC 4514 format
+	SubjectDN string `json:"subjectDN,omitempty"`
+	// DNSName is an expected DNS subject alternative name of the certificate
+	DNSName string `json:"dnsName,omitempty"`
+	// URI is an expected URI subject alternative name of the certificate
+	URI string `json:"uri,omitempty"`
+
+	// CertificateBoundTokens binds the access tokens issued to the client to its certificate. The info
+	// endpoint only describes bound tokens to requests that present the same certificate.
+	CertificateBoundTokens bool `json:"certificateBoundTokens,omitempty"`
+}
+
+// JARM configures JWT-secured authorization responses
+type JARM struct {
+	// SigningKeyFile holds the PEM encoded RSA or ECDSA private key that signs the responses
+	SigningKeyFile string `json:"signingKeyFile"`
+}
+
+// LoginChallengeType is the kind of challenge users solve
+type LoginChallengeType string
+
+const (
+	// LoginChallengeHCaptcha shows the hCaptcha widget and verifies its responses with hCaptcha
+	LoginChallengeHCaptcha LoginChallengeType = "HCaptcha"
+	// LoginChallengeReCAPTCHA shows the reCAPTCHA widget and verifies its responses with Google
+	LoginChallengeReCAPTCHA LoginChallengeType = "ReCAPTCHA"
+	// LoginChallengeProofOfWork has browsers compute hashes, it does not depend on third parties
+	// but requires JavaScript
+	LoginChallengeProofOfWork LoginChallengeType = "ProofOfWork"
+)
+
+// LoginChallenge configures the challenge of the password login forms. The default Content-Security-Policy
+// allows the widgets of the CAPTCHA services, custom policies have to allow them.
+type LoginChallenge struct {
+	// Type is HCaptcha, ReCAPTCHA or ProofOfWork
+	Type LoginChallengeType `json:"type"`
+	// FailureThreshold is the number of failed logins of a source IP or username after which
+	// the challenge is required. Defaults to 5.
+	FailureThreshold int `json:"failureThreshold,omitempty"`
+	// FailureWindow is how long failed logins are counted after the last one. Defaults to 15m.
+	FailureWindow metav1.Duration `json:"failureWindow,omitempty"`
+	// Captcha holds the keys of the site for HCaptcha and ReCAPTCHA
+	Captcha *CaptchaSite `json:"captcha,omitempty"`
+	// ProofOfWorkDifficulty is the number of leading zero bits of the hashes browsers have to find,
+	// every additional bit doubles the work. Defaults to 16, at most 32.
+	ProofOfWorkDifficulty int `json:"proofOfWorkDifficulty,omitempty"`
+}
+
+// BasicAuthThrottle configures how failed basic auth attempts are slowed down. Attempts are counted per source IP and
+// username, which requires TrustedProxies to tell clients behind proxies apart.
+type BasicAuthThrottle struct {
+	// InitialDelay is how long the response to the first failed attempt of a source IP for a username is delayed, it
+	// doubles with every further failure. Defaults to 1s.
+	InitialDelay metav1.Duration `json:"initialDelay,omitempty"`
+	// MaxDelay limits the delay of the responses. Defaults to 30s.
+	MaxDelay metav1.Duration `json:"maxDelay,omitempty"`
+	// SuppressAfter is the number of failed attempts of a source IP for a username after which its credentials for
+	// the username are not checked and it is no longer challenged for them. Defaults to 10.
+	SuppressAfter int `json:"suppressAfter,omitempty"`
+	// SuppressSourceAfter is the number of failed attempts of a source IP for all usernames after which none of its
+	// credentials are checked, so that a source cannot guess the passwords of many users. Defaults to 10 times
+	// suppressAfter.
+	SuppressSourceAfter int `json:"suppressSourceAfter,omitempty"`
+	// FailureWindow is how long failed attempts are counted after the last one. Defaults to 15m.
+	FailureWindow metav1.Duration `json:"failureWindow,omitempty"`
+}
+
+// Redirects configures the "then" URLs of logins
+type Redirects struct {
+	// AllowedPaths are server-relative paths that users may be sent back to once they logged in, in addition to
+	// /oauth/authorize. Their subpaths are allowed as well.
+	AllowedPaths []string `json:"allowedPaths,omitempty"`
+	// MaxLoginLoops is how often a login may send a user back before it fails instead. Defaults to 3.
+	MaxLoginLoops int `json:"maxLoginLoops,omitempty"`
+}
+
+// Maintenance configures the maintenance mode
+type Maintenance struct {
+	// Enabled turns away new logins from the start
+	Enabled bool `json:"enabled,omitempty"`
+	// Message replaces the built-in message of the page, e.g. to say when logins are back
+	Message string `json:"message,omitempty"`
+	// RetryAfter is sent in the Retry-After header of the page. Defaults to 5m.
+	RetryAfter metav1.Duration `json:"retryAfter,omitempty"`
+	// Template is the file of a template that replaces the built-in page. It is executed with the Message,
+	// RetryAfter and Locale fields and must render the message. It takes precedence over the template of the theme.
+	Template string `json:"template,omitempty"`
+}
+
+// CaptchaSite holds the keys a CAPTCHA service issued for the site
+type CaptchaSite struct {
+	// SiteKey is shown in the widget
+	SiteKey string `json:"siteKey"`
+	// SecretFile holds the secret key that verifies the responses of the widget
+	SecretFile string `json:"secretFile"`
+}
+
+// SecurityHeaders holds the values of the security headers of all responses
+type SecurityHeaders struct {
+	// ContentSecurityPolicy replaces the default policy, e.g. to allow a theme to load assets from a CDN.
+	// A frame-ancestors directive is added from FrameAncestors unless the policy has one.
+	ContentSecurityPolicy string `json:"contentSecurityPolicy,omitempty"`
+	// StrictTransportSecurity is not sent by default, as it locks browsers out of servers with self-signed
+	// certificates, e.g. "max-age=31536000; includeSubDomains"
+	StrictTransportSecurity string `json:"strictTransportSecurity,omitempty"`
+	// ReferrerPolicy defaults to strict-origin-when-cross-origin
+	ReferrerPolicy string `json:"referrerPolicy,omitempty"`
+	// ContentTypeOptions is the value of X-Content-Type-Options, defaults to nosniff
+	ContentTypeOptions string `json:"contentTypeOptions,omitempty"`
+	// FrameAncestors are the CSP sources that may embed the pages, e.g. 'self' or https://console.example.com.
+	// By default, the pages cannot be embedded. Setting it drops the X-Frame-Options header.
+	FrameAncestors []string `json:"frameAncestors,omitempty"`
+}
+
+// TermsOfService holds the terms users have to accept
+type TermsOfService struct {
+	// Version identifies the terms. Changing it asks every user to accept the terms again.
+	Version string `json:"version"`
+	// File holds the text of the terms, it is shown as plain text
+	File string `json:"file"`
+}
+
+// Theme is a directory, such as a mounted ConfigMap, with the optional templates login.html,
+// provider-selection.html, grant.html, error.html and terms.html, and the static assets in its subdirectory static.
+// Templates receive the same data as the built-in pages and are validated on startup. Templates set
+// in osinv1.OAuthConfig take precedence over the ones of the theme.
+type Theme struct {
+	// Directory holds the templates and static assets
+	Directory string `json:"directory"`
+	// StaticMaxAge is how long browsers cache the static assets. Defaults to 1h.
+	StaticMaxAge metav1.Duration `json:"staticMaxAge,omitempty"`
+}
+
+// SessionSecrets holds secrets that were used to encode session cookies before
+type SessionSecrets struct {
+	// PreviousSecretsFiles are files in the format of the sessionSecretsFile, e.g. the previous version of a
+	// mounted secret. Their secrets only decode existing cookies, so that the sessionSecretsFile can be replaced
+	// without logging out every user. They are reloaded whenever they change.
+	PreviousSecretsFiles []string `json:"previousSecretsFiles,omitempty"`
+}
+
+// CookieSameSite is the SameSite attribute of cookies
+type CookieSameSite string
+
+const (
+	CookieSameSiteLax    CookieSameSite = "Lax"
+	CookieSameSiteStrict CookieSameSite = "Strict"
+	CookieSameSiteNone   CookieSameSite = "None"
+)
+
+// CSRF configures the CSRF tokens of forms. By default, forms repeat the random value of the CSRF cookie.
+type CSRF struct {
+	// Signed issues CSRF tokens that are signed with the session secrets, bound to the CSRF cookie and expire.
+	// Replicas that share the session secrets validate each other's tokens. It requires a session config.
+	Signed bool `json:"signed,omitempty"`
+	// TokenLifetime is how long signed tokens are valid, e.g. how long a login page may be left open. Defaults to 1h.
+	TokenLifetime metav1.Duration `json:"tokenLifetime,omitempty"`
+}
+
+// Shutdown configures the shutdown of the server. Once it is asked to stop, readiness checks fail and requests that
+// start new logins are rejected with 503, so that load balancers send them to other replicas. Identity provider
+// callbacks, login forms and token requests are served until the grace period ends, then the listeners close and
+// the requests in flight finish. The termination grace period of the pod must be longer than the grace period.
+type Shutdown struct {
+	// LoginGracePeriod is how long logins that are in progress may take to complete, e.g. 30s
+	LoginGracePeriod metav1.Duration `json:"loginGracePeriod"`
+}
+
+// LoginBudget configures the time budget of the requests of logins
+type LoginBudget struct {
+	// Timeout is the total time of a request of a login. Defaults to 30s.
+	Timeout metav1.Duration `json:"timeout,omitempty"`
+}
+
+// UserProvisioning configures the circuit breaker that all identity providers share for the API server. Mappings are
+// retried with a jittered exponential backoff before they count as failed.
+type UserProvisioning struct {
+	// BreakerThreshold is the number of mappings in a row that fail because the API server throttles or times out
+	// before provisioning pauses. Defaults to 5.
+	BreakerThreshold int `json:"breakerThreshold,omitempty"`
+	// BreakerCooldown is how long provisioning pauses. Defaults to 30s.
+	BreakerCooldown metav1.Duration `json:"breakerCooldown,omitempty"`
+}
+
+// IdentityProviderHealth configures the checks of the identity providers
+type IdentityProviderHealth struct {
+	// Interval between checks. Defaults to 30s.
+	Interval metav1.Duration `json:"interval,omitempty"`
+	// Timeout of a single check. Defaults to 5s. It also limits each step of the diagnoses that authorized POST
+	// requests to /debug/identity-provider-diagnosis run on demand.
+	Timeout metav1.Duration `json:"timeout,omitempty"`
+	// Readiness fails the readiness check of the server while any identity provider is unhealthy. As all replicas
+	// check the same identity providers, it only helps if some replicas cannot reach them, e.g. due to network policies.
+	Readiness bool `json:"readiness,omitempty"`
+}
+
+// IdentityProviderConnections configures the pool of connections to identity providers, so that high login rates
+// reuse connections instead of exhausting the ephemeral ports of the server
+type IdentityProviderConnections struct {
+	// MaxIdleConnsPerHost is the number of idle connections kept open to each host. Defaults to 100.
+	MaxIdleConnsPerHost int `json:"maxIdleConnsPerHost,omitempty"`
+	// IdleConnTimeout is how long idle connections are kept open. Defaults to 90s.
+	IdleConnTimeout metav1.Duration `json:"idleConnTimeout,omitempty"`
+	// TLSSessionCacheSize is the number of TLS sessions kept to resume the handshakes of new connections. Defaults
+	// to 64.
+	TLSSessionCacheSize int `json:"tlsSessionCacheSize,omitempty"`
+	// DisableHTTP2 only speaks HTTP/1.1 with identity providers, e.g. behind proxies that mishandle HTTP/2
+	DisableHTTP2 bool `json:"disableHTTP2,omitempty"`
+}
+
+// TrustedProxies configures how client addresses are passed by proxies. The X-Forwarded-For and X-Real-IP headers
+// of requests from the proxies are trusted, the client is the last address of X-Forwarded-For that is not a trusted
+// proxy. The headers of requests from other addresses are removed.
+type TrustedProxies struct {
+	// CIDRs are the networks of the proxies, e.g. 10.0.0.0/16
+	CIDRs []string `json:"cidrs"`
+	// ProxyProtocol accepts the PROXY protocol header (version 1 or 2) on connections from the proxies, which TCP load
+	// balancers send to pass the client address. Connections without header are accepted as well, e.g. from health checks.
+	ProxyProtocol bool `json:"proxyProtocol,omitempty"`
+}
+
+// CORS configures the cross-origin requests browsers allow. Cookies are never sent with these requests.
+type CORS struct {
+	// AllowedOrigins are regular expressions that the Origin header must match, e.g. ^https://app\.example\.com$
+	AllowedOrigins []string `json:"allowedOrigins"`
+	// AllowedMethods defaults to GET and POST
+	AllowedMethods []string `json:"allowedMethods,omitempty"`
+	// AllowedHeaders defaults to Authorization, Content-Type and DPoP
+	AllowedHeaders []string `json:"allowedHeaders,omitempty"`
+	// ExposedHeaders are the response headers applications may read in addition to the CORS-safelisted ones
+	ExposedHeaders []string `json:"exposedHeaders,omitempty"`
+	// MaxAge is how long browsers may cache the responses to preflight requests, e.g. 10m
+	MaxAge metav1.Duration `json:"maxAge,omitempty"`
+}
+
+// ExternalURLs configures the URLs under which users reach the server in addition to the masterPublicURL
+type ExternalURLs struct {
+	// PathPrefix is the path that the ingress routes to the server, e.g. /auth. Requests are accepted with and without
+	// the prefix, so the ingress may remove it or pass it on. Redirects, links and forms include it.
+	PathPrefix string `json:"pathPrefix,omitempty"`
+	// AlternateHostnames are hosts, optionally with port, under which the server is reachable in addition to the host
+	// of the masterPublicURL, e.g. an internal and an external hostname. Users that log in with OAuth identity providers
+	// are sent back to the callback under the host they used, which must be registered at the identity providers.
+	AlternateHostnames []string `json:"alternateHostnames,omitempty"`
+}
+
+// Issuer is a logical issuer hosted by the server
+type Issuer struct {
+	// Name identifies the issuer, it is part of the name of its session cookie
+	Name string `json:"name"`
+	// URL replaces the masterPublicURL for the issuer, e.g. https://tenant-a.example.com or
+	// https://oauth.example.com/tenant-a. Its host, and its path if set, select the requests of the issuer.
+	URL string `json:"url"`
+	// IdentityProviders are the names of the identity providers of the osin config the issuer offers
+	IdentityProviders []string `json:"identityProviders"`
+	// AccessTokenMaxAgeSeconds, AuthorizeTokenMaxAgeSeconds and AccessTokenInactivityTimeout replace the
+	// tokenConfig of the osin config if set
+	AccessTokenMaxAgeSeconds     *int32           `json:"accessTokenMaxAgeSeconds,omitempty"`
+	AuthorizeTokenMaxAgeSeconds  *int32           `json:"authorizeTokenMaxAgeSeconds,omitempty"`
+	AccessTokenInactivityTimeout *metav1.Duration `json:"accessTokenInactivityTimeout,omitempty"`
+	// Theme replaces the pages of the issuer, the templates of the osin config do not apply if it is set
+	Theme *Theme `json:"theme,omitempty"`
+}
+
+// Logging configures the log output of the server
+type Logging struct {
+	// Format of the log lines, Text or JSON. Defaults to Text. JSON lines hold the time, verbosity, caller and message
+	// of the line and the context of login flows, e.g. the login attempt, identity provider and user.
+	Format LogFormat `json:"format,omitempty"`
+}
+
+// LogFormat is the format of log lines
+type LogFormat string
+
+const (
+	// LogFormatText writes the lines of klog
+	LogFormatText LogFormat = "Text"
+	// LogFormatJSON writes a JSON object per line
+	LogFormatJSON LogFormat = "JSON"
+)
+
+// ErrorPages configures the error pages
+type ErrorPages struct {
+	// Help holds the text shown for error codes, e.g. access_denied or identity_provider_error
+	Help []ErrorHelp `json:"help,omitempty"`
+}
+
+// ErrorHelp is the help shown for an error code
+type ErrorHelp struct {
+	// Code is the error code the help is shown for
+	Code string `json:"code"`
+	// Text is shown in languages without localized text
+	Text string `json:"text"`
+	// LocalizedText is the text by language, e.g. "ja"
+	LocalizedText map[string]string `json:"localizedText,omitempty"`
+}
+
+// Challengers configures the challengers, they are named basic-challenge for password and OAuth identity providers,
+// requestheader-<identity provider name>-redirect for request header identity providers and placeholder for the one
+// that points to the token request page if no identity provider is a challenger
+type Challengers struct {
+	// Order are the names of the challengers that are asked first, in order. The other challengers follow by name.
+	// Their challenges are sent in this order, clients use the first scheme they support.
+	Order []string `json:"order"`
+	// Timeout limits how long each challenger is waited for, e.g. a request header identity provider whose
+	// challenges come from a slow proxy. The challengers are asked concurrently, the challenges of those that time out
+	// are left out. Defaults to 5s.
+	Timeout metav1.Duration `json:"timeout,omitempty"`
+	// Basic customizes the challenges of the basic-challenge challenger
+	Basic *BasicChallenge `json:"basic,omitempty"`
+	// Schemes are additional challengers with a fixed scheme, e.g. Negotiate if a proxy in front of the server
+	// authenticates users with Kerberos. They are only sent to requests with the X-CSRF-Token header.
+	Schemes []ChallengeScheme `json:"schemes,omitempty"`
+}
+
+// BasicChallenge customizes the basic auth challenges
+type BasicChallenge struct {
+	// Realm replaces the openshift realm, if set
+	Realm string `json:"realm,omitempty"`
+	// Parameters follow the realm, e.g. a URL that tells users where to reset their password
+	Parameters []ChallengeParameter `json:"parameters,omitempty"`
+}
+
+// ChallengeScheme is a challenger that challenges with a fixed scheme
+type ChallengeScheme struct {
+	// Name is the name of the challenger, e.g. in the challenger order and the challengers of clients
+	Name string `json:"name"`
+	// Scheme is the authentication scheme of the challenge, e.g. Negotiate
+	Scheme string `json:"scheme"`
+	// Parameters are the auth parameters of the challenge
+	Parameters []ChallengeParameter `json:"parameters,omitempty"`
+}
+
+// ChallengeParameter is an auth parameter of a challenge, its value is quoted
+type ChallengeParameter struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// SilentAuthentication configures authorize requests with prompt=none
+type SilentAuthentication struct {
+	// KeepSessions keeps the session of a user after an authorize flow, which ends it by default. Without it, silent
+	// requests only succeed while a login is in progress. Sessions still expire after the sessionMaxAgeSeconds of the
+	// session config, which is required.
+	KeepSessions bool `json:"keepSessions,omitempty"`
+}
+
+// AuthenticationContext configures the authentication context classes
+type AuthenticationContext struct {
+	// Classes are ordered from the lowest to the highest, logins satisfy the highest class whose methods they have
+	Classes []AuthenticationContextClass `json:"classes"`
+}
+
+// AuthenticationContextClass is a class clients can require, e.g. {"name": "mfa", "methods": ["mfa"]}
+type AuthenticationContextClass struct {
+	// Name is the value of the class in acr_values and the acr of tokens
+	Name string `json:"name"`
+	// Methods are the authentication methods a login must all have to satisfy the class. Logins have the methods of
+	// their identity provider, and those in the amr claim of the ID token of OpenID providers.
+	Methods []string `json:"methods"`
+}
+
+// CookieAttributes configures the session and CSRF cookies. SameSite None, name prefixes and partitioned
+// cookies require the cookies to be secure, i.e. an https masterPublicURL.
+type CookieAttributes struct {
+	// SameSite is Lax, Strict or None. None is needed to log in from pages embedded in other sites.
+	// By default the attribute is not set, which browsers treat as Lax.
+	SameSite CookieSameSite `json:"sameSite,omitempty"`
+	// Domain shares the cookies with all hosts of the domain, e.g. example.com for hosts under a parent domain.
+	// By default, cookies are only sent to the host that set them.
+	Domain string `json:"domain,omitempty"`
+	// Path restricts the cookies to paths below it. Defaults to /.
+	Path string `json:"path,omitempty"`
+	// NamePrefix is prepended to the cookie names, either __Host- or __Secure-. __Host- does not allow a
+	// domain or a path other than /.
+	NamePrefix string `json:"namePrefix,omitempty"`
+	// Partitioned keeps the cookies of embedded logins apart per top-level site. It requires SameSite None.
+	Partitioned bool `json:"partitioned,omitempty"`
+}
+
+// ProviderSelection configures the provider selection page
+type ProviderSelection struct {
+	// RememberChoice remembers the identity provider a user chose in a cookie and lists it first
+	// the next time the selection page is shown
+	RememberChoice bool `json:"rememberChoice,omitempty"`
+	// SkipSelection sends users whose choice is remembered to the same identity provider without showing the
+	// selection page. Authorize requests with prompt=select_account still show it. It requires rememberChoice and a
+	// session config, whose secrets sign the cookie.
+	SkipSelection bool `json:"skipSelection,omitempty"`
+}
+
+// TokenLimitPolicy determines what happens when a user that holds the maximum number of access tokens or sessions
+// logs in
+type TokenLimitPolicy string
+
+const (
+	// TokenLimitReject denies the new login
+	TokenLimitReject TokenLimitPolicy = "Reject"
+	// TokenLimitEvictOldest deletes the oldest access tokens or sessions of the user to make room for the new one
+	TokenLimitEvictOldest TokenLimitPolicy = "EvictOldest"
+)
+
+// TokenLimit configures the maximum number of access tokens and sessions per user. Tokens that expired or
+// timed out and expired sessions are not counted. The limits are enforced when tokens and sessions are
+// issued, existing ones are left alone.
+type TokenLimit struct {
+	// MaxAccessTokensPerUser is the number of access tokens a user may hold, 0 does not limit them
+	MaxAccessTokensPerUser int `json:"maxAccessTokensPerUser"`
+	// MaxSessionsPerUser is the number of login sessions a user may hold, 0 does not limit them. It requires
+	// sessions stored on the server, i.e. the Memory or Redis session storage, sessions in cookies cannot be
+	// counted.
+	MaxSessionsPerUser int `json:"maxSessionsPerUser,omitempty"`
+	// Policy is Reject or EvictOldest. Defaults to Reject.
+	Policy TokenLimitPolicy `json:"policy,omitempty"`
+}
+
+// TokenFormat configures the accepted formats of tokens. Issued tokens always have the sha256~ prefix, the
+// only one the API server authenticates, and are always accepted.
+type TokenFormat struct {
+	// AcceptedPrefixes are other prefixes of tokens that remain valid, e.g. of tokens issued with a custom prefix
+	// by earlier releases. They must end with ~, the tokens are looked up by the sha256~ digest of their random part.
+	AcceptedPrefixes []string `json:"acceptedPrefixes,omitempty"`
+	// RejectLegacy rejects legacy tokens, once those issued before the migration to SHA256 tokens expired
+	RejectLegacy bool `json:"rejectLegacy,omitempty"`
+}
+
+// TokenGarbageCollection configures the deletion of expired tokens. Every replica collects tokens, the
+// collections are jittered so that replicas rarely list the same tokens at the same time.
+type TokenGarbageCollection struct {
+	// Interval between collections. Defaults to 1h.
+	Interval metav1.Duration `json:"interval,omitempty"`
+	// BatchSize is the number of tokens that are listed at once. Defaults to 500.
+	BatchSize int64 `json:"batchSize,omitempty"`
+}
+
+// TokenStorageType is the kind of storage for access and authorize tokens
+type TokenStorageType string
+
+const (
+	// TokenStorageKubernetes keeps tokens as OAuthAccessToken and OAuthAuthorizeToken objects of the Kubernetes API
+	TokenStorageKubernetes TokenStorageType = "Kubernetes"
+	// TokenStorageMemory keeps tokens in the memory of the server, they are lost on restarts and not shared
+	// between multiple instances
+	TokenStorageMemory TokenStorageType = "Memory"
+	// TokenStorageRedis keeps tokens in redis
+	TokenStorageRedis TokenStorageType = "Redis"
+)
+
+// TokenStorage configures the storage of access and authorize tokens
+type TokenStorage struct {
+	// Type is Kubernetes, Memory or Redis
+	Type TokenStorageType `json:"type"`
+	// Redis is required for the Redis type. It may be the server that sessions are stored in.
+	Redis *RedisSessionStorage `json:"redis,omitempty"`
+	// Reviewer authenticates the API server at /oauth/tokenreview, it is required for the Memory and Redis types.
+	// Token reviews without its client certificate are rejected, so that nobody else can check tokens or keep
+	// them from timing out.
+	Reviewer *TokenReviewer `json:"reviewer,omitempty"`
+}
+
+// TokenReviewer describes the client certificate of the webhook token authenticator of the API server
+type TokenReviewer struct {
+	// CAFile holds the PEM encoded CA bundle that verifies the client certificate
+	CAFile string `json:"caFile"`
+	// SubjectDN is the expected subject distinguished name of the certificate in RFC 4514 format, e.g.
+	// CN=system:kube-apiserver
+	SubjectDN string `json:"subjectDN,omitempty"`
+}
+
+// BootstrapUser configures the restrictions of the bootstrap user
+type BootstrapUser struct {
+	// RequirePasswordRotation rejects the password of the bootstrap user once the grace period after its first
+	// login passed. Changing the password in the kubeadmin secret lets it log in again.
+	RequirePasswordRotation bool `json:"requirePasswordRotation,omitempty"`
+	// RotationGracePeriod is how long the password may be used after the first login. Defaults to 1h.
+	RotationGracePeriod metav1.Duration `json:"rotationGracePeriod,omitempty"`
+	// DisableAfterIdentityProviderLogin disables the bootstrap user once a user logged in with another identity provider
+	DisableAfterIdentityProviderLogin bool `json:"disableAfterIdentityProviderLogin,omitempty"`
+}
+
+// DeviceAuthorization configures the device authorization grant
+type DeviceAuthorization struct {
+	// Clients are the names of the OAuth clients that may use the grant. Their redirect URIs must include
+	// <masterPublicURL>/oauth/device/callback.
+	Clients []string `json:"clients"`
+	// CodeLifetime is how long users have to enter a user code. Defaults to 10m.
+	CodeLifetime metav1.Duration `json:"codeLifetime,omitempty"`
+	// PollInterval is the minimum interval between the token requests of a tool. Defaults to 5s.
+	PollInterval metav1.Duration `json:"pollInterval,omitempty"`
+}
+
+// SessionStorageType is the kind of storage for login sessions
+type SessionStorageType string
+
+const (
+	// SessionStorageCookie keeps the session in the session cookie
+	SessionStorageCookie SessionStorageType = "Cookie"
+	// SessionStorageMemory keeps sessions in the memory of the server, they are lost on restarts
+	// and not shared between multiple instances
+	SessionStorageMemory SessionStorageType = "Memory"
+	// SessionStorageRedis keeps sessions in redis
+	SessionStorageRedis SessionStorageType = "Redis"
+)
+
+// SessionStorage configures the storage of login sessions
+type SessionStorage struct {
+	// Type is Cookie, Memory or Redis
+	Type SessionStorageType `json:"type"`
+	// Redis is required for the Redis type
+	Redis *RedisSessionStorage `json:"redis,omitempty"`
+}
+
+// RedisSessionStorage configures the redis server that sessions are stored in
+type RedisSessionStorage struct {
+	// Address is the host:port of the server
+	Address string `json:"address"`
+	// Username is the optional ACL user to authenticate as
+	Username string `json:"username,omitempty"`
+	// PasswordFile is an optional file with the password to authenticate with
+	PasswordFile string `json:"passwordFile,omitempty"`
+	// DB is the number of the database to use
+	DB int `json:"db,omitempty"`
+	// TLS connects to the server with TLS
+	TLS bool `json:"tls,omitempty"`
+	// CA is an optional file with trusted certificate authorities for TLS connections
+	CA string `json:"ca,omitempty"`
+	// KeyPrefix is prepended to all keys, e.g. "oauth-server:"
+	KeyPrefix string `json:"keyPrefix,omitempty"`
+	// Timeout limits the duration of a single command. Defaults to 5s.
+	Timeout metav1.Duration `json:"timeout,omitempty"`
+}
+
+// IdentityConflicts configures the resolution of identity conflicts. It has no settings yet, setting it enables the
+// endpoint.
+type IdentityConflicts struct{}
+
+// Deprovisioning configures the cleanup after users and identities are deleted
+type Deprovisioning struct {
+	// DeleteIdentities deletes the identities of deleted users, so that a new user is
+	// provisioned instead of the login failing when the identity is used again
+	DeleteIdentities bool `json:"deleteIdentities,omitempty"`
+}
+
+// IdentityAuthorizationWebhook configures the endpoint that authorizes logins
+type IdentityAuthorizationWebhook struct {
+	// URL is the https endpoint that IdentityAuthorizationReviews are POSTed to
+	URL string `json:"url"`
+	// CA is an optional file with trusted certificate authorities for the endpoint
+	CA string `json:"ca,omitempty"`
+	// CertFile and KeyFile are an optional client certificate presented to the endpoint
+	CertFile string `json:"certFile,omitempty"`
+	KeyFile  string `json:"keyFile,omitempty"`
+	// Timeout limits the duration of a single call. Defaults to 10s.
+	Timeout metav1.Duration `json:"timeout,omitempty"`
+}
+
+// IdentityProviderExtension holds additional settings for a single identity provider
+type IdentityProviderExtension struct {
+	// Name must match the name of an identity provider in osinv1.OAuthConfig
+	Name string `json:"name"`
+
+	// UsernameTemplate is a Go template that determines the name of the user for new identities
+	// instead of their preferred username, e.g. "{{.preferred_username}}@{{.providerName}}" or
+	// "{{.email | localpart | lower}}". It requires a mapping method other than lookup.
+	UsernameTemplate string `json:"usernameTemplate,omitempty"`
+
+	// Transform derives attributes of identities from the attributes asserted by the provider
+	Transform *IdentityTransform `json:"transform,omitempty"`
+
+	// Access restricts which identities of the provider may log in
+	Access *IdentityAccess `json:"access,omitempty"`
+
+	// GroupSync reconciles the membership of users in groups with the groups asserted by the provider
+	GroupSync *GroupSync `json:"groupSync,omitempty"`
+
+	// CredentialsRevocation revokes the tokens and sessions of users once the credentials of their identity change
+	CredentialsRevocation *CredentialsRevocation `json:"credentialsRevocation,omitempty"`
+
+	// SCIM lets the provider push the lifecycle of its users and groups to /scim/v2/<provider name>. It requires
+	// permission to manage users, identities and groups, and to list and delete OAuth access and authorize tokens.
+	SCIM *SCIM `json:"scim,omitempty"`
+
+	// OfflineFallback lets users log in with the credentials of a recent successful login while the provider is
+	// unavailable. It only applies to LDAP and basic auth providers. An LDAP provider is only unavailable if it
+	// cannot be reached or answers busy or unavailable, accounts it refuses, e.g. locked ones, cannot log in.
+	OfflineFallback *OfflineFallback `json:"offlineFallback,omitempty"`
+
+	// IdentityCache caches the identities the OAuth identity provider returns for access tokens for a short time, so
+	// that retried callbacks do not look up the user at the provider again. It does not apply to CAS.
+	IdentityCache *IdentityCache `json:"identityCache,omitempty"`
+
+	// MappingCache caches the users that the identities of the provider are mapped to for a short time, so that
+	// repeated logins do not get or create identities and users every time
+	MappingCache *MappingCache `json:"mappingCache,omitempty"`
+
+	// KeepUpstreamTokens keeps the access and refresh tokens that the OAuth identity provider issues when users log
+	// in, see upstreamTokens. GitLab providers and GitHub providers with github.revokeToken revoke their tokens after
+	// the login, they cannot keep them.
+	KeepUpstreamTokens bool `json:"keepUpstreamTokens,omitempty"`
+
+	// Authorize changes the authorize requests of OAuth identity providers, e.g. to request fewer scopes than the
+	// provider does by default. It does not apply to CAS.
+	Authorize *AuthorizeRequest `json:"authorize,omitempty"`
+
+	// LDAP holds settings that only apply to LDAP identity providers
+	LDAP *LDAPExtension `json:"ldap,omitempty"`
+	// GitHub holds settings that only apply to GitHub identity providers
+	GitHub *GitHubExtension `json:"github,omitempty"`
+	// OpenID holds settings that only apply to OpenID identity providers
+	OpenID *OpenIDExtension `json:"openID,omitempty"`
+	// Auth0 turns an OpenID identity provider into an Auth0 application, whose endpoints are derived from the domain
+	// of the tenant. The URLs and claims of the OpenID identity provider are not used.
+	Auth0 *Auth0Extension `json:"auth0,omitempty"`
+	// Cognito turns an OpenID identity provider into an app client of a Cognito user pool. The URLs and claims of
+	// the OpenID identity provider are not used, the groups of the pool become the groups of the identity.
+	Cognito *CognitoExtension `json:"cognito,omitempty"`
+	// Keycloak turns an OpenID identity provider into a client of a Keycloak realm. The URLs and claims of the OpenID
+	// identity provider are not used, the roles of users are passed on as extra attributes of their identities.
+	Keycloak *KeycloakExtension `json:"keycloak,omitempty"`
+	// CAS turns an OpenID identity provider into a service of a CAS server, e.g. of a university without an OpenID
+	// Connect bridge. Users log in with service tickets, only the client ID and the CA of the OpenID identity provider
+	// are used. It cannot be used as challenger.
+	CAS *CASExtension `json:"cas,omitempty"`
+	// Social turns an OpenID identity provider into an application of a consumer platform, e.g. for lab clusters whose
+	// contributors log in with their Discord account, or for users in regions where Yandex or VK accounts are common.
+	// Only the client of the OpenID identity provider is used.
+	Social *SocialExtension `json:"social,omitempty"`
+	// Keystone holds settings that only apply to Keystone identity providers
+	Keystone *KeystoneExtension `json:"keystone,omitempty"`
+	// RequestHeader holds settings that only apply to request header identity providers
+	RequestHeader *RequestHeaderExtension `json:"requestHeader,omitempty"`
+	// BasicAuth holds settings that only apply to basic auth identity providers
+	BasicAuth *BasicAuthExtension `json:"basicAuth,omitempty"`
+
+	// Display determines how the provider is presented on the provider selection page
+	Display *ProviderDisplay `json:"display,omitempty"`
+
+	// Canary sends a share of the logins with the provider to another identity provider, e.g. a second configuration
+	// of the same provider for a new tenant, to try it with some users before all of them are migrated
+	Canary *Canary `json:"canary,omitempty"`
+
+	// AuthenticationMethods are recorded for the logins of the provider in addition to pwd for password providers and
+	// sso for other providers, e.g. mfa for a provider that always requires a second factor or pki for an
+	// authenticating proxy that checks smart cards
+	AuthenticationMethods []string `json:"authenticationMethods,omitempty"`
+
+	// UserExtra passes extra attributes of identities on to the extra user info of their logins, which sessions and
+	// tokens keep and token reviews return to authorizers and audit logs
+	UserExtra []UserExtra `json:"userExtra,omitempty"`
+}
+
+// Canary routes browser logins of an identity provider to another one. The other provider is not offered on the
+// provider selection page while it is the target of a canary, users reach it through the provider of the canary only.
+type Canary struct {
+	// IdentityProvider is the name of the identity provider of the osin config the logins are sent to. It must be
+	// used as login.
+	IdentityProvider string `json:"identityProvider"`
+	// Percentage of the browsers that are sent to the other provider, 0 to 100. Browsers are assigned once and keep
+	// their assignment in a cookie, raising the percentage only moves more browsers to the other provider.
+	Percentage int `json:"percentage,omitempty"`
+	// Users are always sent to the other provider if the authorize request names them in the login_hint parameter.
+	// They are compared case-insensitively.
+	Users []string `json:"users,omitempty"`
+}
+
+// UserExtra copies an extra attribute of identities into the extra user info
+type UserExtra struct {
+	// Attribute is the extra attribute of identities, e.g. email or a claim in the extraClaims of OpenID providers
+	Attribute string `json:"attribute"`
+	// Key is the key of the extra user info, e.g. department.example.com. Keys are lower case and cannot be in the
+	// openshift.io, kubernetes.io and k8s.io domains or their subdomains, e.g. authentication.kubernetes.io/pod-name,
+	// which authorizers trust.
+	Key string `json:"key"`
+}
+
+// CredentialsRevocation revokes the access and authorize tokens and the sessions of a user once the credentials
+// of one of its identities change. It requires permission to list and delete OAuth access and authorize tokens.
+type CredentialsRevocation struct {
+	// OnLogin compares the version of the credentials an identity logs in with to the version of its previous login,
+	// which is recorded in an annotation of the user and requires permission to update users. htpasswd providers
+	// derive the version from the password hash, LDAP providers read it from the versionAttribute.
+	OnLogin bool `json:"onLogin,omitempty"`
+	// VersionAttribute is the LDAP attribute that changes with the password, e.g. pwdChangedTime
+	VersionAttribute string `json:"versionAttribute,omitempty"`
+	// WebhookSecretFile holds the bearer token the identity provider authenticates with when it posts the username
+	// parameter of users whose credentials changed to /oauth/credentials-changed/<provider name>, in an
+	// "Authorization: Bearer <token>" header. The sessions are revoked on every server that shares the
+	// sessionStorage, with sessions stored in cookies only on the server that received the post.
+	WebhookSecretFile string `json:"webhookSecretFile,omitempty"`
+}
+
+// SCIM configures the SCIM 2.0 endpoint of an identity provider. SCIM users are the identities of the provider, their
+// userName is the provider user name, so it must match the id claim, login or attribute the provider logs in with.
+// New users are provisioned like at their first login. Deactivated and deleted users cannot log in, and their tokens
+// and sessions are revoked.
+type SCIM struct {
+	// TokenFile holds the bearer token the identity provider authenticates with
+	TokenFile string `json:"tokenFile"`
+	// GroupPrefix is prepended to the display names of SCIM groups to name the groups, e.g. okta:
+	GroupPrefix string `json:"groupPrefix,omitempty"`
+}
+
+// OfflineFallback remembers a salted hash of the credentials of successful password logins in memory. Logins with
+// remembered credentials succeed while the provider cannot be reached or fails, and their audit events are annotated
+// with authentication.openshift.io/degraded. Logins whose credentials are rejected by the provider are forgotten.
+type OfflineFallback struct {
+	// MaxAge is how long credentials are remembered after a successful login. Defaults to 1h.
+	MaxAge metav1.Duration `json:"maxAge,omitempty"`
+	// MaxEntries is the number of users whose credentials are remembered. Defaults to 1000.
+	MaxEntries int `json:"maxEntries,omitempty"`
+}
+
+// IdentityCache holds the identities of access tokens in memory, keyed by a hash of the access token. Only successful
+// lookups are cached.
+type IdentityCache struct {
+	// TTL is how long the identity of an access token is cached. Defaults to 30s.
+	TTL metav1.Duration `json:"ttl,omitempty"`
+	// MaxEntries is the number of access tokens whose identity is cached. Defaults to 1000.
+	MaxEntries int `json:"maxEntries,omitempty"`
+}
+
+// MappingCache holds the names and UIDs of the users of identities in memory, keyed by the provider user name of the
+// identity. Failed mappings are not cached and forget the cached user of their identity.
+type MappingCache struct {
+	// TTL is how long the user of an identity is cached. Users that are deleted or linked to another identity may
+	// still log in for this long. Defaults to 10s.
+	TTL metav1.Duration `json:"ttl,omitempty"`
+	// MaxEntries is the number of identities whose user is cached. Defaults to 1000.
+	MaxEntries int `json:"maxEntries,omitempty"`
+}
+
+// AuthorizeRequest holds the settings of the authorize requests sent to an OAuth identity provider
+type AuthorizeRequest struct {
+	// Scopes replace the scopes the provider requests by default, e.g. read_api next to read_user for GitLab. OpenID
+	// Connect providers require openid among them.
+	Scopes []string `json:"scopes,omitempty"`
+	// Parameters are added to every authorize request, e.g. prompt or a vendor specific parameter. They replace the
+	// parameters the provider adds with the same name, but not the login and domain hints of the user. The parameters
+	// of the OAuth protocol cannot be set.
+	Parameters map[string]string `json:"parameters,omitempty"`
+}
+
+// ProviderDisplay describes how an identity provider is presented on the provider selection page
+type ProviderDisplay struct {
+	// DisplayName is shown to users instead of the name of the provider
+	DisplayName string `json:"displayName,omitempty"`
+	// IconURL is an https URL or an absolute path of an icon shown next to the provider
+	IconURL string `json:"iconURL,omitempty"`
+	// Description is shown below the provider, e.g. to tell users which accounts it is for
+	Description string `json:"description,omitempty"`
+	// Order sorts the providers in ascending order. Providers with the same order are
+	// listed in the order of the configuration.
+	Order int `json:"order,omitempty"`
+}
+
+// IdentityAccess holds allow and deny lists for identities. Entries are exact names or globs such as
+// "*-admin" or "*.example.com". Identities matching a denied entry are rejected. If any allowed entries
+// are set, identities must match at least one of them. Email addresses only match allowed entries if the
+// identity provider verified them: OpenID providers that set the email_verified claim, Slack, Discord and
+// Twitch. Rejected logins are recorded as denied.
+type IdentityAccess struct {
+	// AllowedUsers match the preferred username or the email address of identities
+	AllowedUsers []string `json:"allowedUsers,omitempty"`
+	// DeniedUsers match the preferred username or the email address of identities
+	DeniedUsers []string `json:"deniedUsers,omitempty"`
+	// AllowedEmailDomains match the domain of the email address of identities, e.g. example.com
+	AllowedEmailDomains []string `json:"allowedEmailDomains,omitempty"`
+	// DeniedEmailDomains match the domain of the email address of identities
+	DeniedEmailDomains []string `json:"deniedEmailDomains,omitempty"`
+}
+
+// GroupSync configures the synchronization of groups on every login. Groups of OpenID identity
+// providers are always synchronized, this additionally synchronizes the groups of LDAP, request
+// header and basic auth providers and the organizations and teams ("org/team") of GitHub users.
+// Users are added to the groups they are asserted to be members of, groups are created as needed,
+// and users are removed from groups of the provider they are no longer asserted to be members of.
+type GroupSync struct {
+	// Prefix is prepended to the names of groups, e.g. "github:". If set, users are only removed
+	// from groups with the prefix.
+	Prefix string `json:"prefix,omitempty"`
+}
+
+// IdentityTransform holds expressions that derive the attributes of identities. Expressions
+// use the small expression language of identitytransform.Program, which resembles but is not
+// the Common Expression Language, and can refer to the variables identity (providerName,
+// providerUserName) and claims (the extra attributes of the identity, sub and the list of
+// groups), e.g. claims.upn.lowerAscii().split("@")[0]. Expressions are limited in length,
+// nesting and the work of evaluating them. Attributes without an expression are not changed.
+type IdentityTransform struct {
+	// Username must evaluate to a non-empty string that is used as preferred username
+	Username string `json:"username,omitempty"`
+	// DisplayName must evaluate to a string
+	DisplayName string `json:"displayName,omitempty"`
+	// Email must evaluate to a string
+	Email string `json:"email,omitempty"`
+	// Groups must evaluate to a list of strings that replaces the groups of the identity
+	Groups string `json:"groups,omitempty"`
+}
+
+// GitHubExtension holds additional settings for GitHub identity providers
+type GitHubExtension struct {
+	// RevokeToken deletes the access token of users at GitHub once their identity, organizations and teams have
+	// been read, instead of leaving a token that can read their email and organizations valid until they revoke it
+	RevokeToken bool `json:"revokeToken,omitempty"`
+}
+
+// LDAPExtension holds additional settings for LDAP identity providers. Connections to ldap:// URLs already use
+// StartTLS and verify the server with the CA of the identity provider unless insecure is set on it, so there is no
+// setting for StartTLS.
+type LDAPExtension struct {
+	// FollowReferrals enables chasing of search result references returned by the
+	// server, e.g. when a search in an Active Directory forest spans multiple domains
+	FollowReferrals bool `json:"followReferrals,omitempty"`
+	// MaxReferralHops limits how many referrals are followed during a single search.
+	// Defaults to 5 if FollowReferrals is set.
+	MaxReferralHops int `json:"maxReferralHops,omitempty"`
+	// AllowedReferralHosts are the hosts referrals may point to, as host or host:port. Referrals to other
+	// hosts, or with another scheme than the URL of the identity provider, are not followed, since the servers
+	// they point to are sent the bind credentials and the passwords of users. It is required if FollowReferrals
+	// is set.
+	AllowedReferralHosts []string `json:"allowedReferralHosts,omitempty"`
+
+	// RequiredGroups is a list of group DNs. If set, only members of at least one
+	// of these groups are allowed to log in.
+	RequiredGroups []string `json:"requiredGroups,omitempty"`
+	// GroupMembershipAttribute is the attribute of user entries that lists the DNs of
+	// the groups a user is a member of. Defaults to memberOf if RequiredGroups or
+	// SyncGroups is set and GroupSearch is not.
+	GroupMembershipAttribute string `json:"groupMembershipAttribute,omitempty"`
+	// GroupSearch finds the groups of a user by searching for group entries that
+	// reference the user's DN, for servers that do not maintain memberOf
+	GroupSearch *LDAPGroupSearch `json:"groupSearch,omitempty"`
+	// SyncGroups resolves all groups of the user at every login, not only the required groups, and passes them on
+	// as the groups of the identity. It requires groupSync, which then reconciles the memberships of the users that
+	// log in, instead of a batch synchronization of the whole directory.
+	SyncGroups bool `json:"syncGroups,omitempty"`
+}
+
+// OpenIDExtension holds additional settings for OpenID identity providers.
+// Endpoints are resolved in order of precedence from the individual overrides,
+// the discovery document and the URLs of the identity provider.
+type OpenIDExtension struct {
+	// Issuer enables discovery of the provider metadata from the issuer at startup
+	Issuer string `json:"issuer,omitempty"`
+	// DiscoveryDocument is the path to a pinned snapshot of the discovery document.
+	// If set, it is used instead of fetching the document from the issuer.
+	DiscoveryDocument string `json:"discoveryDocument,omitempty"`
+
+	// TokenURL overrides the token endpoint
+	TokenURL string `json:"tokenURL,omitempty"`
+	// UserInfoURL overrides the userinfo endpoint
+	UserInfoURL string `json:"userInfoURL,omitempty"`
+	// JWKSURL overrides the JWKS URL. If a JWKS URL is known, the signature of ID tokens is verified.
+	JWKSURL string `json:"jwksURL,omitempty"`
+	// EndSessionURL overrides the end session endpoint. If an end session endpoint is known,
+	// users that log out are redirected to it to end their session at the provider as well.
+	EndSessionURL string `json:"endSessionURL,omitempty"`
+	// BackChannelLogout accepts logout tokens from the provider at /logout/backchannel/<name>
+	// and revokes the sessions and tokens of the user named by the token. Logout tokens must be
+	// signed, so the provider needs a JWKS URL, and sub must be the first ID claim.
+	BackChannelLogout bool `json:"backChannelLogout,omitempty"`
+	// DomainHint passes the domain_hint parameter of authorize requests to the provider, e.g. for Azure AD. The
+	// login_hint parameter is always passed.
+	DomainHint bool `json:"domainHint,omitempty"`
+	// ExtraClaims are copied from the ID token and userinfo into the extra attributes of identities under their
+	// name, e.g. department or tenant, so that userExtra can pass them on
+	ExtraClaims []string `json:"extraClaims,omitempty"`
+}
+
+// Auth0Extension holds the settings of an Auth0 application
+type Auth0Extension struct {
+	// Domain is the domain of the tenant, e.g. example.eu.auth0.com, or its custom domain, e.g. login.example.com.
+	// ID tokens must be issued by the domain.
+	Domain string `json:"domain"`
+	// Connection pins logins to a connection of the application, e.g. an enterprise directory, by passing the
+	// connection parameter
+	Connection string `json:"connection,omitempty"`
+	// ConnectionClaim is the claim an Action of the tenant adds to ID tokens with the name of the connection, e.g.
+	// https://example.com/connection. If it is set, logins with other connections are rejected. Otherwise users can
+	// remove the connection parameter to log in with another connection of the application.
+	ConnectionClaim string `json:"connectionClaim,omitempty"`
+	// UserInfo fetches the claims of /userinfo in addition to those of the ID token. Auth0 rate limits /userinfo per
+	// user, so it is off unless Actions add claims to the userinfo response only.
+	UserInfo bool `json:"userInfo,omitempty"`
+}
+
+// CognitoExtension holds the settings of an app client of a Cognito user pool
+type CognitoExtension struct {
+	// Domain is the domain of the user pool, e.g. example.auth.eu-west-1.amazoncognito.com, or its custom domain
+	Domain string `json:"domain"`
+	// UserPoolID is the ID of the user pool, e.g. eu-west-1_AbCdEf123. ID tokens must be issued by the pool.
+	UserPoolID string `json:"userPoolID"`
+}
+
+// KeycloakExtension holds the settings of a client of a Keycloak realm
+type KeycloakExtension struct {
+	// URL is the URL of the Keycloak server, e.g. https://sso.example.com, including /auth for versions before 17
+	URL string `json:"url"`
+	// Realm is the name of the realm. ID tokens must be issued by the realm.
+	Realm string `json:"realm"`
+	// SingleLogout redirects users that log out to the realm to end their session there as well
+	SingleLogout bool `json:"singleLogout,omitempty"`
+	// BackChannelLogout accepts logout tokens from the realm at /logout/backchannel/<name> and revokes the sessions
+	// and tokens of the user named by the token
+	BackChannelLogout bool `json:"backChannelLogout,omitempty"`
+}
+
+// CASExtension holds the settings of a CAS server. Tickets are validated with the CAS 3.0 protocol, which releases
+// the attributes of users.
+type CASExtension struct {
+	// URL is the URL of the CAS server, e.g. https://cas.example.edu/cas
+	URL string `json:"url"`
+	// PreferredUsernameAttributes are the attributes with the preferred username. Defaults to the name of the user.
+	PreferredUsernameAttributes []string `json:"preferredUsernameAttributes,omitempty"`
+	// EmailAttributes are the attributes with the email address. Defaults to mail and email.
+	EmailAttributes []string `json:"emailAttributes,omitempty"`
+	// NameAttributes are the attributes with the display name. Defaults to displayName and cn.
+	NameAttributes []string `json:"nameAttributes,omitempty"`
+	// GroupAttributes are the attributes with the groups of the user, e.g. memberOf
+	GroupAttributes []string `json:"groupAttributes,omitempty"`
+}
+
+// SocialPlatform is a consumer platform users can log in with
+type SocialPlatform string
+
+const (
+	SocialPlatformDiscord SocialPlatform = "Discord"
+	SocialPlatformSlack   SocialPlatform = "Slack"
+	SocialPlatformTwitch  SocialPlatform = "Twitch"
+	SocialPlatformYandex  SocialPlatform = "Yandex"
+	SocialPlatformVK      SocialPlatform = "VK"
+)
+
+// SocialExtension holds the settings of an application of a consumer platform
+type SocialExtension struct {
+	// Platform is Discord, Slack, Twitch, Yandex or VK
+	Platform SocialPlatform `json:"platform"`
+	// Communities are the IDs of the Discord guilds or Slack workspaces users must be a member of one of. If there
+	// are none, every user of the platform can log in. The other platforms have no communities.
+	Communities []string `json:"communities,omitempty"`
+}
+
+// KeystoneExtension holds additional settings for Keystone identity providers
+type KeystoneExtension struct {
+	// ScopeDomainName requests tokens scoped to this domain, so that only users with
+	// a role on the domain can log in. If ScopeProjectName is set, it is the domain of
+	// the project instead and defaults to the domain name of the identity provider.
+	ScopeDomainName string `json:"scopeDomainName,omitempty"`
+	// ScopeProjectName requests tokens scoped to this project, so that only users
+	// with a role on the project can log in
+	ScopeProjectName string `json:"scopeProjectName,omitempty"`
+	// ApplicationCredentials allows logging in with an application credential by passing
+	// "applicationcredential:<id>" as username and the secret as password. The login is
+	// mapped to the user owning the credential. With ScopeProjectName, only credentials
+	// created in that project can log in. With only ScopeDomainName, none can, since
+	// application credentials are always scoped to a project.
+	ApplicationCredentials bool `json:"applicationCredentials,omitempty"`
+}
+
+// RequestHeaderExtension holds additional settings for request header identity providers
+type RequestHeaderExtension struct {
+	// GroupHeaders lists the headers that contain the groups of the user, e.g. X-Forwarded-Groups.
+	// The values of all headers are combined.
+	GroupHeaders []string `json:"groupHeaders,omitempty"`
+	// GroupSeparator splits header values into multiple groups. Defaults to ",".
+	GroupSeparator *string `json:"groupSeparator,omitempty"`
+	// GroupPrefix is stripped from group names that start with it
+	GroupPrefix string `json:"groupPrefix,omitempty"`
+	// ExtraHeaderPrefixes lists header prefixes, e.g. X-Remote-Extra-. Headers starting with one of
+	// the prefixes are added to the extra attributes of the identity, keyed by the lower-cased
+	// remainder of the header name.
+	ExtraHeaderPrefixes []string `json:"extraHeaderPrefixes,omitempty"`
+	// Assertion requires the proxy to sign the user it relays, so that an intermediate that can reach the server
+	// with a trusted client certificate cannot log in as arbitrary users by setting the headers
+	Assertion *ProxyAssertion `json:"assertion,omitempty"`
+}
+
+// ProxyAssertion configures the signed JWT an authenticating proxy sends along with the headers. Its sub claim must
+// be the user of the identity headers, and it must be issued for this server and recently.
+type ProxyAssertion struct {
+	// Header carries the JWT. Defaults to X-Remote-User-Assertion.
+	Header string `json:"header,omitempty"`
+	// JWKSFile holds the public keys of the proxy as a JSON web key set
+	JWKSFile string `json:"jwksFile"`
+	// Issuer is the required iss claim, if it is set
+	Issuer string `json:"issuer,omitempty"`
+	// Audiences are accepted in the aud claim. Defaults to the masterPublicURL of the server.
+	Audiences []string `json:"audiences,omitempty"`
+	// MaxAge limits the time since the iat claim of the JWT. Defaults to 5m.
+	MaxAge metav1.Duration `json:"maxAge,omitempty"`
+}
+
+// BasicAuthExtension holds additional settings for basic auth identity providers
+type BasicAuthExtension struct {
+	// Fields overrides the names of the fields in successful responses of the remote endpoint
+	Fields BasicAuthFields `json:"fields,omitempty"`
+}
+
+// BasicAuthFields holds the names of the fields in successful responses of a basic auth
+// identity provider. Empty names default to the documented field names.
+type BasicAuthFields struct {
+	// Subject is the field of the required identifier of the user. Defaults to sub.
+	Subject string `json:"subject,omitempty"`
+	// Name is the field of the display name. Defaults to name.
+	Name string `json:"name,omitempty"`
+	// PreferredUsername is the field of the preferred username. Defaults to preferred_username.
+	PreferredUsername string `json:"preferredUsername,omitempty"`
+	// Email is the field of the email address. Defaults to email.
+	Email string `json:"email,omitempty"`
+	// Groups is the field of the array of group names. Defaults to groups.
+	Groups string `json:"groups,omitempty"`
+}
+
+// LDAPGroupSearch describes how to search for the groups of a user
+type LDAPGroupSearch struct {
+	// BaseDN is the DN of the branch of the directory where groups are searched
+	BaseDN string `json:"baseDN"`
+	// Scope is one of base, one or sub. Defaults to sub.
+	Scope string `json:"scope,omitempty"`
+	// Filter is a valid LDAP filter that is combined with the membership filter.
+	// Defaults to (objectClass=*).
+	Filter string `json:"filter,omitempty"`
+	// MemberAttribute is the attribute of group entries that holds the DNs of members.
+	// Defaults to member.
+	MemberAttribute string `json:"memberAttribute,omitempty"`
+	// NameAttribute is the attribute of group entries used as the group name.
+	// Defaults to cn.
+	NameAttribute string `json:"nameAttribute,omitempty"`
+}
+
+// reservedUserExtraDomains are the domains of the keys of extra user info that the platform sets and authorizers trust
+var reservedUserExtraDomains = []string{"openshift.io", "kubernetes.io", "k8s.io"}
+
+// reservedUserExtraKey returns true if the domain of the key, the part before the first slash, is one of the reserved
+// domains or one of their subdomains
+func reservedUserExtraKey(key string) bool {
+	domain := strings.SplitN(key, "/", 2)[0]
+	for _, reserved := range reservedUserExtraDomains {
+		if domain == reserved || strings.HasSuffix(domain, "."+reserved) {
+			return true
+		}
+	}
+	return false
+}
+
+// IdentityProvider returns the extension settings for the identity provider
+// with the given name. A zero value is returned if there are none.
+func (c *ExtendedOAuthConfig) IdentityProvider(name string) IdentityProviderExtension {
+	for _, idp := range c.IdentityProviders {
+		if idp.Name == name {
+			return idp
+		}
+	}
+	return IdentityProviderExtension{Name: name}
+}
+
+// BackChannelLogout returns true if the identity provider may log out users through the back channel
+func (e IdentityProviderExtension) BackChannelLogout() bool {
+	return (e.OpenID != nil && e.OpenID.BackChannelLogout) || (e.Keycloak != nil && e.Keycloak.BackChannelLogout)
+}
+
+// Client returns the settings of the OAuth client with the given name
+func (c *ExtendedOAuthConfig) Client(name string) ClientExtension {
+	for _, client := range c.Clients {
+		if client.Name == name {
+			return client
+		}
+	}
+	return ClientExtension{Name: name}
+}
+
+// issuerNamePattern matches issuer names, they are part of cookie names
+var issuerNamePattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+// tokenPrefixPattern matches token prefixes, tokens must stay usable in URLs and headers
+var tokenPrefixPattern = regexp.MustCompile(`^[A-Za-z0-9_]+~$`)
+
+// reservedAuthorizeParameters are set by the OAuth client and cannot be added to authorize requests
+var reservedAuthorizeParameters = sets.NewString("response_type", "client_id", "redirect_uri", "scope", "state", "code_challenge", "code_challenge_method")
+
+// ReadExtendedOAuthConfig reads an ExtendedOAuthConfig from the given YAML or JSON file.
+// An empty filename results in an empty configuration.
+func ReadExtendedOAuthConfig(filename string) (*ExtendedOAuthConfig, error) {
+	extendedConfig := &ExtendedOAuthConfig{}
+	if len(filename) == 0 {
+		return extendedConfig, nil
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	jsonData, err := yaml.ToJSON(data)
+	if err != nil {
+		// probably just json already
+		jsonData = data
+	}
+
+	decoder := json.NewDecoder(bytes.NewBuffer(jsonData))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(extendedConfig); err != nil {
+		return nil, fmt.Errorf("error reading extended config %s: %v", filename, err)
+	}
+
+	names := map[string]bool{}
+	for _, idp := range extendedConfig.IdentityProviders {
+		if len(idp.Name) == 0 {
+			return nil, fmt.Errorf("extended config %s: identity provider settings require a name", filename)
+		}
+		if names[idp.Name] {
+			return nil, fmt.Errorf("extended config %s: duplicate settings for identity provider %q", filename, idp.Name)
+		}
+		names[idp.Name] = true
+		if fallback := idp.OfflineFallback; fallback != nil && (fallback.MaxAge.Duration < 0 || fallback.MaxEntries < 0) {
+			return nil, fmt.Errorf("extended config %s: offline fallback max age and max entries of identity provider %q cannot be negative", filename, idp.Name)
+		}
+		if identityCache := idp.IdentityCache; identityCache != nil && (identityCache.TTL.Duration < 0 || identityCache.MaxEntries < 0) {
+			return nil, fmt.Errorf("extended config %s: identity cache ttl and max entries of identity provider %q cannot be negative", filename, idp.Name)
+		}
+		if mappingCache := idp.MappingCache; mappingCache != nil && (mappingCache.TTL.Duration < 0 || mappingCache.MaxEntries < 0) {
+			return nil, fmt.Errorf("extended config %s: mapping cache ttl and max entries of identity provider %q cannot be negative", filename, idp.Name)
+		}
+		if authorize := idp.Authorize; authorize != nil {
+			for _, scope := range authorize.Scopes {
+				if len(scope) == 0 || strings.ContainsAny(scope, " \t\n") {
+					return nil, fmt.Errorf("extended config %s: authorize scope %q of identity provider %q is invalid", filename, scope, idp.Name)
+				}
+			}
+			for name := range authorize.Parameters {
+				if len(name) == 0 || reservedAuthorizeParameters.Has(name) {
+					return nil, fmt.Errorf("extended config %s: authorize parameter %q of identity provider %q cannot be set", filename, name, idp.Name)
+				}
+			}
+			if idp.CAS != nil {
+				return nil, fmt.Errorf("extended config %s: identity provider %q cannot have authorize settings together with cas settings", filename, idp.Name)
+			}
+		}
+		if idp.KeepUpstreamTokens && extendedConfig.UpstreamTokens == nil {
+			return nil, fmt.Errorf("extended config %s: keepUpstreamTokens of identity provider %q requires upstreamTokens", filename, idp.Name)
+		}
+		if display := idp.Display; display != nil && len(display.IconURL) > 0 {
+			if u, err := url.Parse(display.IconURL); err != nil || (u.Scheme != "https" && (len(u.Scheme) > 0 || len(u.Host) > 0 || !strings.HasPrefix(u.Path, "/"))) {
+				return nil, fmt.Errorf("extended config %s: icon of identity provider %q must be an https URL or an absolute path", filename, idp.Name)
+			}
+		}
+		if ldap := idp.LDAP; ldap != nil && ldap.SyncGroups && idp.GroupSync == nil {
+			return nil, fmt.Errorf("extended config %s: LDAP syncGroups of identity provider %q requires groupSync", filename, idp.Name)
+		}
+		if ldap := idp.LDAP; ldap != nil && ldap.FollowReferrals && len(ldap.AllowedReferralHosts) == 0 {
+			return nil, fmt.Errorf("extended config %s: LDAP followReferrals of identity provider %q requires allowedReferralHosts", filename, idp.Name)
+		}
+		if scim := idp.SCIM; scim != nil && len(scim.TokenFile) == 0 {
+			return nil, fmt.Errorf("extended config %s: SCIM of identity provider %q requires a tokenFile", filename, idp.Name)
+		}
+		extraKeys := map[string]bool{}
+		for _, userExtra := range idp.UserExtra {
+			if len(userExtra.Attribute) == 0 || len(userExtra.Key) == 0 {
+				return nil, fmt.Errorf("extended config %s: user extra of identity provider %q requires an attribute and a key", filename, idp.Name)
+			}
+			if userExtra.Key != strings.ToLower(userExtra.Key) || reservedUserExtraKey(userExtra.Key) {
+				return nil, fmt.Errorf("extended config %s: user extra key %q of identity provider %q must be lower case and outside of the %s domains", filename, userExtra.Key, idp.Name, strings.Join(reservedUserExtraDomains, ", "))
+			}
+			if extraKeys[userExtra.Key] {
+				return nil, fmt.Errorf("extended config %s: duplicate user extra key %q of identity provider %q", filename, userExtra.Key, idp.Name)
+			}
+			extraKeys[userExtra.Key] = true
+		}
+		if auth0 := idp.Auth0; auth0 != nil {
+			if len(auth0.Domain) == 0 {
+				return nil, fmt.Errorf("extended config %s: auth0 of identity provider %q requires a domain", filename, idp.Name)
+			}
+			if len(auth0.ConnectionClaim) > 0 && len(auth0.Connection) == 0 {
+				return nil, fmt.Errorf("extended config %s: auth0 connection claim of identity provider %q requires a connection", filename, idp.Name)
+			}
+			if idp.OpenID != nil {
+				return nil, fmt.Errorf("extended config %s: identity provider %q cannot have both auth0 and openID settings", filename, idp.Name)
+			}
+		}
+		if cognito := idp.Cognito; cognito != nil {
+			if len(cognito.Domain) == 0 || len(cognito.UserPoolID) == 0 {
+				return nil, fmt.Errorf("extended config %s: cognito of identity provider %q requires a domain and a userPoolID", filename, idp.Name)
+			}
+			if idp.OpenID != nil || idp.Auth0 != nil {
+				return nil, fmt.Errorf("extended config %s: identity provider %q cannot have cognito settings together with openID or auth0 settings", filename, idp.Name)
+			}
+		}
+		if keycloak := idp.Keycloak; keycloak != nil {
+			if len(keycloak.URL) == 0 || len(keycloak.Realm) == 0 {
+				return nil, fmt.Errorf("extended config %s: keycloak of identity provider %q requires a url and a realm", filename, idp.Name)
+			}
+			if idp.OpenID != nil || idp.Auth0 != nil || idp.Cognito != nil {
+				return nil, fmt.Errorf("extended config %s: identity provider %q cannot have keycloak settings together with openID, auth0 or cognito settings", filename, idp.Name)
+			}
+		}
+		if cas := idp.CAS; cas != nil {
+			if len(cas.URL) == 0 {
+				return nil, fmt.Errorf("extended config %s: cas of identity provider %q requires a url", filename, idp.Name)
+			}
+			if idp.OpenID != nil || idp.Auth0 != nil || idp.Cognito != nil || idp.Keycloak != nil {
+				return nil, fmt.Errorf("extended config %s: identity provider %q cannot have cas settings together with openID, auth0, cognito or keycloak settings", filename, idp.Name)
+			}
+		}
+		if social := idp.Social; social != nil {
+			switch social.Platform {
+			case SocialPlatformDiscord, SocialPlatformSlack:
+			case SocialPlatformTwitch, SocialPlatformYandex, SocialPlatformVK:
+				if len(social.Communities) > 0 {
+					return nil, fmt.Errorf("extended config %s: social platform %s of identity provider %q has no communities", filename, social.Platform, idp.Name)
+				}
+			default:
+				return nil, fmt.Errorf("extended config %s: social platform of identity provider %q must be Discord, Slack, Twitch, Yandex or VK", filename, idp.Name)
+			}
+			if idp.OpenID != nil || idp.Auth0 != nil || idp.Cognito != nil || idp.Keycloak != nil || idp.CAS != nil {
+				return nil, fmt.Errorf("extended config %s: identity provider %q cannot have social settings together with openID, auth0, cognito, keycloak or cas settings", filename, idp.Name)
+			}
+		}
+		if requestHeader := idp.RequestHeader; requestHeader != nil && requestHeader.Assertion != nil {
+			if len(requestHeader.Assertion.JWKSFile) == 0 {
+				return nil, fmt.Errorf("extended config %s: assertion of identity provider %q requires a jwksFile", filename, idp.Name)
+			}
+			if requestHeader.Assertion.MaxAge.Duration < 0 {
+				return nil, fmt.Errorf("extended config %s: assertion max age of identity provider %q cannot be negative", filename, idp.Name)
+			}
+		}
+		if canary := idp.Canary; canary != nil {
+			if len(canary.IdentityProvider) == 0 || canary.IdentityProvider == idp.Name {
+				return nil, fmt.Errorf("extended config %s: canary of identity provider %q requires another identity provider", filename, idp.Name)
+			}
+			if canary.Percentage < 0 || canary.Percentage > 100 {
+				return nil, fmt.Errorf("extended config %s: canary percentage of identity provider %q must be between 0 and 100", filename, idp.Name)
+			}
+		}
+	}
+	canaryTargets := map[string]string{}
+	for _, idp := range extendedConfig.IdentityProviders {
+		if canary := idp.Canary; canary != nil {
+			if other, ok := canaryTargets[canary.IdentityProvider]; ok {
+				return nil, fmt.Errorf("extended config %s: identity provider %q is the canary of both %q and %q", filename, canary.IdentityProvider, other, idp.Name)
+			}
+			canaryTargets[canary.IdentityProvider] = idp.Name
+		}
+	}
+	for _, idp := range extendedConfig.IdentityProviders {
+		if other, ok := canaryTargets[idp.Name]; ok && idp.Canary != nil {
+			return nil, fmt.Errorf("extended config %s: identity provider %q cannot both have a canary and be the canary of %q", filename, idp.Name, other)
+		}
+	}
+	if oauthClients := extendedConfig.OAuthClients; oauthClients != nil {
+		if err := validateOAuthClientDefinitions(oauthClients.Clients); err != nil {
+			return nil, fmt.Errorf("extended config %s: %v", filename, err)
+		}
+		for _, file := range oauthClients.Files {
+			if len(file) == 0 {
+				return nil, fmt.Errorf("extended config %s: OAuth client files cannot be empty", filename)
+			}
+		}
+	}
+	clientNames := map[string]bool{}
+	for _, client := range extendedConfig.Clients {
+		if len(client.Name) == 0 {
+			return nil, fmt.Errorf("extended config %s: client settings require a name", filename)
+		}
+		if clientNames[client.Name] {
+			return nil, fmt.Errorf("extended config %s: duplicate settings for client %q", filename, client.Name)
+		}
+		clientNames[client.Name] = true
+		if client.RequireRequestObject && len(client.JWKSFile) == 0 {
+			return nil, fmt.Errorf("extended config %s: client %q requires request objects but has no jwksFile", filename, client.Name)
+		}
+		if client.RequireJARM && extendedConfig.JARM == nil {
+			return nil, fmt.Errorf("extended config %s: client %q requires signed responses but jarm is not configured", filename, client.Name)
+		}
+		switch client.DPoP {
+		case "", DPoPAllowed, DPoPRequired:
+		default:
+			return nil, fmt.Errorf("extended config %s: unknown DPoP policy %q of client %q", filename, client.DPoP, client.Name)
+		}
+		if policy := client.TokenPolicy; policy != nil {
+			if maxAge := policy.AccessTokenMaxAgeSeconds; maxAge != nil && *maxAge < 0 {
+				return nil, fmt.Errorf("extended config %s: access token max age of client %q must not be negative", filename, client.Name)
+			}
+			if timeout := policy.AccessTokenInactivityTimeoutSeconds; timeout != nil && *timeout != 0 && *timeout < 300 {
+				return nil, fmt.Errorf("extended config %s: access token inactivity timeout of client %q must be 0 or at least 300 seconds", filename, client.Name)
+			}
+			if policy.AllowAllScopes && len(policy.ScopeRestrictions) > 0 {
+				return nil, fmt.Errorf("extended config %s: client %q may not both allow all scopes and restrict them", filename, client.Name)
+			}
+		}
+		for _, resource := range client.Resources {
+			if u, err := url.Parse(resource); err != nil || !u.IsAbs() || len(u.Fragment) > 0 {
+				return nil, fmt.Errorf("extended config %s: resource %q of client %q must be an absolute URI without fragment", filename, resource, client.Name)
+			}
+		}
+		if tokenExchange := client.TokenExchange; tokenExchange != nil && len(tokenExchange.Subjects) == 0 {
+			return nil, fmt.Errorf("extended config %s: token exchange of client %q requires subjects", filename, client.Name)
+		}
+		for _, challenger := range client.Challengers {
+			if len(challenger) == 0 {
+				return nil, fmt.Errorf("extended config %s: challengers of client %q cannot be empty", filename, client.Name)
+			}
+		}
+		for _, idp := range client.IdentityProviders {
+			if len(idp) == 0 {
+				return nil, fmt.Errorf("extended config %s: identity providers of client %q cannot be empty", filename, client.Name)
+			}
+		}
+		if maxAge := client.MaxAgeSeconds; maxAge != nil && *maxAge < 0 {
+			return nil, fmt.Errorf("extended config %s: max age of client %q must not be negative", filename, client.Name)
+		}
+		if redirectURIs := client.RedirectURIs; redirectURIs != nil {
+			switch redirectURIs.Matching {
+			case "", RedirectURIMatchingPrefix, RedirectURIMatchingExact:
+			default:
+				return nil, fmt.Errorf("extended config %s: unknown redirect URI matching %q of client %q", filename, redirectURIs.Matching, client.Name)
+			}
+		}
+		if clientPKCE := client.PKCE; client.NativeApp && clientPKCE != nil {
+			if clientPKCE.Required != nil && !*clientPKCE.Required {
+				return nil, fmt.Errorf("extended config %s: native app %q requires pkce", filename, client.Name)
+			}
+			if clientPKCE.AllowPlain != nil && *clientPKCE.AllowPlain {
+				return nil, fmt.Errorf("extended config %s: native app %q may not allow the plain pkce method", filename, client.Name)
+			}
+		}
+		if tlsClientAuth := client.TLSClientAuth; tlsClientAuth != nil {
+			if len(tlsClientAuth.CAFile) == 0 {
+				return nil, fmt.Errorf("extended config %s: TLS client authentication of client %q requires a caFile", filename, client.Name)
+			}
+			if len(tlsClientAuth.SubjectDN) == 0 && len(tlsClientAuth.DNSName) == 0 && len(tlsClientAuth.URI) == 0 {
+				return nil, fmt.Errorf("extended config %s: TLS client authentication of client %q requires a subjectDN, dnsName or uri", filename, client.Name)
+			}
+		}
+	}
+	if challengers := extendedConfig.Challengers; challengers != nil {
+		challengerNames := map[string]bool{}
+		for _, name := range challengers.Order {
+			if len(name) == 0 {
+				return nil, fmt.Errorf("extended config %s: challenger order cannot contain empty names", filename)
+			}
+			if challengerNames[name] {
+				return nil, fmt.Errorf("extended config %s: duplicate challenger %q in challenger order", filename, name)
+			}
+			challengerNames[name] = true
+		}
+		if challengers.Timeout.Duration < 0 {
+			return nil, fmt.Errorf("extended config %s: challenger timeout cannot be negative", filename)
+		}
+		if basic := challengers.Basic; basic != nil {
+			if !httpguts.ValidHeaderFieldValue(basic.Realm) {
+				return nil, fmt.Errorf("extended config %s: invalid basic challenge realm %q", filename, basic.Realm)
+			}
+			if err := validateChallengeParameters(basic.Parameters); err != nil {
+				return nil, fmt.Errorf("extended config %s: basic challenge: %v", filename, err)
+			}
+		}
+		schemeNames := map[string]bool{}
+		for _, scheme := range challengers.Schemes {
+			if len(scheme.Name) == 0 {
+				return nil, fmt.Errorf("extended config %s: challenge schemes require a name", filename)
+			}
+			if schemeNames[scheme.Name] {
+				return nil, fmt.Errorf("extended config %s: duplicate challenge scheme %q", filename, scheme.Name)
+			}
+			schemeNames[scheme.Name] = true
+			if !httpguts.ValidHeaderFieldName(scheme.Scheme) {
+				return nil, fmt.Errorf("extended config %s: invalid scheme %q of challenge scheme %q", filename, scheme.Scheme, scheme.Name)
+			}
+			if err := validateChallengeParameters(scheme.Parameters); err != nil {
+				return nil, fmt.Errorf("extended config %s: challenge scheme %q: %v", filename, scheme.Name, err)
+			}
+		}
+	}
+	if authenticationContext := extendedConfig.AuthenticationContext; authenticationContext != nil {
+		classNames := map[string]bool{}
+		for _, class := range authenticationContext.Classes {
+			if len(class.Name) == 0 {
+				return nil, fmt.Errorf("extended config %s: authentication context classes require a name", filename)
+			}
+			if classNames[class.Name] {
+				return nil, fmt.Errorf("extended config %s: duplicate authentication context class %q", filename, class.Name)
+			}
+			classNames[class.Name] = true
+			if len(class.Methods) == 0 {
+				return nil, fmt.Errorf("extended config %s: authentication context class %q requires methods", filename, class.Name)
+			}
+		}
+	}
+	if selection := extendedConfig.ProviderSelection; selection != nil && selection.SkipSelection && !selection.RememberChoice {
+		return nil, fmt.Errorf("extended config %s: skipping the provider selection requires rememberChoice", filename)
+	}
+	if jarm := extendedConfig.JARM; jarm != nil && len(jarm.SigningKeyFile) == 0 {
+		return nil, fmt.Errorf("extended config %s: jarm requires a signingKeyFile", filename)
+	}
+
+	if webhook := extendedConfig.IdentityAuthorizationWebhook; webhook != nil && len(webhook.URL) == 0 {
+		return nil, fmt.Errorf("extended config %s: identity authorization webhook requires a url", filename)
+	}
+
+	if storage := extendedConfig.SessionStorage; storage != nil {
+		switch storage.Type {
+		case SessionStorageCookie, SessionStorageMemory:
+		case SessionStorageRedis:
+			if storage.Redis == nil || len(storage.Redis.Address) == 0 {
+				return nil, fmt.Errorf("extended config %s: redis session storage requires an address", filename)
+			}
+		default:
+			return nil, fmt.Errorf("extended config %s: unknown session storage type %q", filename, storage.Type)
+		}
+	}
+
+	if limit := extendedConfig.TokenLimit; limit != nil {
+		if limit.MaxAccessTokensPerUser < 0 || limit.MaxSessionsPerUser < 0 || limit.MaxAccessTokensPerUser+limit.MaxSessionsPerUser == 0 {
+			return nil, fmt.Errorf("extended config %s: token limit requires a positive maxAccessTokensPerUser or maxSessionsPerUser", filename)
+		}
+		if storage := extendedConfig.SessionStorage; limit.MaxSessionsPerUser > 0 && (storage == nil || storage.Type == SessionStorageCookie) {
+			return nil, fmt.Errorf("extended config %s: maxSessionsPerUser requires the Memory or Redis session storage", filename)
+		}
+		switch limit.Policy {
+		case "", TokenLimitReject, TokenLimitEvictOldest:
+		default:
+			return nil, fmt.Errorf("extended config %s: unknown token limit policy %q", filename, limit.Policy)
+		}
+	}
+
+	if gc := extendedConfig.TokenGarbageCollection; gc != nil && (gc.Interval.Duration < 0 || gc.BatchSize < 0) {
+		return nil, fmt.Errorf("extended config %s: token garbage collection interval and batch size cannot be negative", filename)
+	}
+
+	if csrf := extendedConfig.CSRF; csrf != nil && csrf.TokenLifetime.Duration < 0 {
+		return nil, fmt.Errorf("extended config %s: CSRF token lifetime cannot be negative", filename)
+	}
+
+	if shutdown := extendedConfig.Shutdown; shutdown != nil && shutdown.LoginGracePeriod.Duration <= 0 {
+		return nil, fmt.Errorf("extended config %s: shutdown login grace period must be positive", filename)
+	}
+
+	if health := extendedConfig.IdentityProviderHealth; health != nil && (health.Interval.Duration < 0 || health.Timeout.Duration < 0) {
+		return nil, fmt.Errorf("extended config %s: identity provider health interval and timeout must not be negative", filename)
+	}
+	if budget := extendedConfig.LoginBudget; budget != nil && budget.Timeout.Duration < 0 {
+		return nil, fmt.Errorf("extended config %s: login budget timeout cannot be negative", filename)
+	}
+	if provisioning := extendedConfig.UserProvisioning; provisioning != nil && (provisioning.BreakerThreshold < 0 || provisioning.BreakerCooldown.Duration < 0) {
+		return nil, fmt.Errorf("extended config %s: user provisioning breaker threshold and cooldown cannot be negative", filename)
+	}
+	if connections := extendedConfig.IdentityProviderConnections; connections != nil && (connections.MaxIdleConnsPerHost < 0 || connections.IdleConnTimeout.Duration < 0 || connections.TLSSessionCacheSize < 0) {
+		return nil, fmt.Errorf("extended config %s: identity provider connections cannot have negative settings", filename)
+	}
+
+	if proxies := extendedConfig.TrustedProxies; proxies != nil {
+		if len(proxies.CIDRs) == 0 {
+			return nil, fmt.Errorf("extended config %s: trusted proxies require at least one CIDR", filename)
+		}
+		for _, cidr := range proxies.CIDRs {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				return nil, fmt.Errorf("extended config %s: invalid trusted proxy CIDR %q: %v", filename, cidr, err)
+			}
+		}
+	}
+
+	if cors := extendedConfig.CORS; cors != nil {
+		if len(cors.AllowedOrigins) == 0 {
+			return nil, fmt.Errorf("extended config %s: cors requires at least one allowed origin", filename)
+		}
+		for _, origin := range cors.AllowedOrigins {
+			if _, err := regexp.Compile(origin); err != nil {
+				return nil, fmt.Errorf("extended config %s: invalid cors allowed origin %q: %v", filename, origin, err)
+			}
+		}
+		if cors.MaxAge.Duration < 0 {
+			return nil, fmt.Errorf("extended config %s: cors max age must not be negative", filename)
+		}
+	}
+
+	if anomalies := extendedConfig.LoginAnomalies; anomalies != nil {
+		if anomalies.ImpossibleTravelWindow.Duration < 0 || anomalies.MaxFingerprints < 0 {
+			return nil, fmt.Errorf("extended config %s: login anomalies impossibleTravelWindow and maxFingerprints must not be negative", filename)
+		}
+		if anomalies.ImpossibleTravelWindow.Duration > 0 && len(anomalies.RegionsFile) == 0 {
+			return nil, fmt.Errorf("extended config %s: login anomalies impossibleTravelWindow requires a regionsFile", filename)
+		}
+		for _, method := range anomalies.RequiredMethods {
+			if len(method) == 0 {
+				return nil, fmt.Errorf("extended config %s: login anomalies required methods cannot be empty", filename)
+			}
+		}
+		if webhook := anomalies.Webhook; webhook != nil && len(webhook.URL) == 0 {
+			return nil, fmt.Errorf("extended config %s: login anomalies webhook requires a url", filename)
+		}
+	}
+
+	if upstreamTokens := extendedConfig.UpstreamTokens; upstreamTokens != nil && (len(upstreamTokens.Namespace) == 0 || len(upstreamTokens.SecretFile) == 0) {
+		return nil, fmt.Errorf("extended config %s: upstreamTokens requires a namespace and a secretFile", filename)
+	}
+
+	if consent := extendedConfig.Consent; consent != nil && consent.MaxAge.Duration < 0 {
+		return nil, fmt.Errorf("extended config %s: consent max age must not be negative", filename)
+	}
+
+	if externalURLs := extendedConfig.ExternalURLs; externalURLs != nil {
+		if prefix := externalURLs.PathPrefix; len(prefix) > 0 && (!strings.HasPrefix(prefix, "/") || prefix == "/" || path.Clean(prefix) != prefix) {
+			return nil, fmt.Errorf("extended config %s: path prefix %q must be a clean absolute path without trailing slash", filename, prefix)
+		}
+		for _, hostname := range externalURLs.AlternateHostnames {
+			if u, err := url.Parse("https://" + hostname); err != nil || u.Host != hostname || len(u.Hostname()) == 0 {
+				return nil, fmt.Errorf("extended config %s: alternate hostname %q must be a host with optional port", filename, hostname)
+			}
+		}
+	}
+
+	issuerNames, issuerURLs := map[string]bool{}, map[string]bool{}
+	for _, issuer := range extendedConfig.Issuers {
+		if !issuerNamePattern.MatchString(issuer.Name) || issuerNames[issuer.Name] {
+			return nil, fmt.Errorf("extended config %s: issuer names must be unique and consist of lower case letters, digits and dashes, got %q", filename, issuer.Name)
+		}
+		issuerNames[issuer.Name] = true
+		u, err := url.Parse(issuer.URL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || len(u.Host) == 0 || len(u.RawQuery) > 0 || len(u.Fragment) > 0 {
+			return nil, fmt.Errorf("extended config %s: issuer %s requires an http or https URL without query, got %q", filename, issuer.Name, issuer.URL)
+		}
+		issuerURL := strings.ToLower(u.Host) + strings.TrimSuffix(u.Path, "/")
+		if issuerURLs[issuerURL] {
+			return nil, fmt.Errorf("extended config %s: issuer %s has the URL of another issuer", filename, issuer.Name)
+		}
+		issuerURLs[issuerURL] = true
+		if len(issuer.IdentityProviders) == 0 {
+			return nil, fmt.Errorf("extended config %s: issuer %s requires at least one identity provider", filename, issuer.Name)
+		}
+		if (issuer.AccessTokenMaxAgeSeconds != nil && *issuer.AccessTokenMaxAgeSeconds < 0) || (issuer.AuthorizeTokenMaxAgeSeconds != nil && *issuer.AuthorizeTokenMaxAgeSeconds < 0) ||
+			(issuer.AccessTokenInactivityTimeout != nil && issuer.AccessTokenInactivityTimeout.Duration < 0) {
+			return nil, fmt.Errorf("extended config %s: issuer %s token lifetimes cannot be negative", filename, issuer.Name)
+		}
+		if theme := issuer.Theme; theme != nil && (len(theme.Directory) == 0 || theme.StaticMaxAge.Duration < 0) {
+			return nil, fmt.Errorf("extended config %s: issuer %s theme requires a directory and a static max age that is not negative", filename, issuer.Name)
+		}
+	}
+
+	if logging := extendedConfig.Logging; logging != nil {
+		switch logging.Format {
+		case "", LogFormatText, LogFormatJSON:
+		default:
+			return nil, fmt.Errorf("extended config %s: unknown log format %q", filename, logging.Format)
+		}
+	}
+
+	if errorPages := extendedConfig.ErrorPages; errorPages != nil {
+		helpCodes := map[string]bool{}
+		for _, help := range errorPages.Help {
+			if len(help.Code) == 0 || len(help.Text) == 0 {
+				return nil, fmt.Errorf("extended config %s: error help requires a code and a text", filename)
+			}
+			if helpCodes[help.Code] {
+				return nil, fmt.Errorf("extended config %s: duplicate error help for code %q", filename, help.Code)
+			}
+			helpCodes[help.Code] = true
+		}
+	}
+
+	if storage := extendedConfig.TokenStorage; storage != nil {
+		switch storage.Type {
+		case TokenStorageKubernetes, TokenStorageMemory:
+		case TokenStorageRedis:
+			if storage.Redis == nil || len(storage.Redis.Address) == 0 {
+				return nil, fmt.Errorf("extended config %s: redis token storage requires an address", filename)
+			}
+		default:
+			return nil, fmt.Errorf("extended config %s: unknown token storage type %q", filename, storage.Type)
+		}
+		if storage.Type != TokenStorageKubernetes && (storage.Reviewer == nil || len(storage.Reviewer.CAFile) == 0) {
+			return nil, fmt.Errorf("extended config %s: %s token storage requires the caFile of the reviewer of tokens", filename, storage.Type)
+		}
+	}
+
+	if bootstrapUser := extendedConfig.BootstrapUser; bootstrapUser != nil {
+		if !bootstrapUser.RequirePasswordRotation && !bootstrapUser.DisableAfterIdentityProviderLogin {
+			return nil, fmt.Errorf("extended config %s: bootstrap user settings require password rotation or disabling after identity provider logins", filename)
+		}
+		if bootstrapUser.RotationGracePeriod.Duration < 0 {
+			return nil, fmt.Errorf("extended config %s: bootstrap user rotation grace period cannot be negative", filename)
+		}
+	}
+
+	if device := extendedConfig.DeviceAuthorization; device != nil {
+		if len(device.Clients) == 0 {
+			return nil, fmt.Errorf("extended config %s: device authorization requires clients", filename)
+		}
+		if device.CodeLifetime.Duration < 0 || device.PollInterval.Duration < 0 {
+			return nil, fmt.Errorf("extended config %s: device authorization code lifetime and poll interval cannot be negative", filename)
+		}
+	}
+
+	if format := extendedConfig.TokenFormat; format != nil {
+		for _, prefix := range format.AcceptedPrefixes {
+			if !tokenPrefixPattern.MatchString(prefix) {
+				return nil, fmt.Errorf("extended config %s: token prefix %q must consist of letters, digits and _ and end with ~", filename, prefix)
+			}
+		}
+	}
+
+	if terms := extendedConfig.TermsOfService; terms != nil && (len(terms.Version) == 0 || len(terms.File) == 0) {
+		return nil, fmt.Errorf("extended config %s: terms of service require a version and a file", filename)
+	}
+
+	if securityHeaders := extendedConfig.SecurityHeaders; securityHeaders != nil {
+		for _, value := range []string{securityHeaders.ContentSecurityPolicy, securityHeaders.StrictTransportSecurity, securityHeaders.ReferrerPolicy, securityHeaders.ContentTypeOptions} {
+			if strings.ContainsAny(value, "\r\n") {
+				return nil, fmt.Errorf("extended config %s: security headers cannot contain line breaks", filename)
+			}
+		}
+		for _, source := range securityHeaders.FrameAncestors {
+			if len(source) == 0 || strings.ContainsAny(source, " ;,\r\n") {
+				return nil, fmt.Errorf("extended config %s: invalid frame ancestor %q", filename, source)
+			}
+		}
+	}
+
+	if challenge := extendedConfig.LoginChallenge; challenge != nil {
+		switch challenge.Type {
+		case LoginChallengeHCaptcha, LoginChallengeReCAPTCHA:
+			if challenge.Captcha == nil || len(challenge.Captcha.SiteKey) == 0 || len(challenge.Captcha.SecretFile) == 0 {
+				return nil, fmt.Errorf("extended config %s: %s login challenge requires a site key and a secret file", filename, challenge.Type)
+			}
+		case LoginChallengeProofOfWork:
+			if challenge.ProofOfWorkDifficulty < 0 || challenge.ProofOfWorkDifficulty > 32 {
+				return nil, fmt.Errorf("extended config %s: proof of work difficulty cannot be negative or larger than 32", filename)
+			}
+		default:
+			return nil, fmt.Errorf("extended config %s: unknown login challenge type %q", filename, challenge.Type)
+		}
+		if challenge.FailureThreshold < 0 || challenge.FailureWindow.Duration < 0 {
+			return nil, fmt.Errorf("extended config %s: login challenge failure threshold and window cannot be negative", filename)
+		}
+	}
+
+	if throttle := extendedConfig.BasicAuthThrottle; throttle != nil {
+		if throttle.InitialDelay.Duration < 0 || throttle.MaxDelay.Duration < 0 || throttle.SuppressAfter < 0 || throttle.SuppressSourceAfter < 0 || throttle.FailureWindow.Duration < 0 {
+			return nil, fmt.Errorf("extended config %s: basic auth throttle delays, suppressAfter, suppressSourceAfter and failure window cannot be negative", filename)
+		}
+	}
+
+	if redirects := extendedConfig.Redirects; redirects != nil {
+		for _, allowedPath := range redirects.AllowedPaths {
+			if !strings.HasPrefix(allowedPath, "/") || strings.HasPrefix(allowedPath, "//") || path.Clean(allowedPath) != strings.TrimSuffix(allowedPath, "/") && allowedPath != "/" {
+				return nil, fmt.Errorf("extended config %s: allowed redirect path %q must be a clean server-relative path", filename, allowedPath)
+			}
+		}
+		if redirects.MaxLoginLoops < 0 {
+			return nil, fmt.Errorf("extended config %s: max login loops cannot be negative", filename)
+		}
+	}
+
+	if maintenance := extendedConfig.Maintenance; maintenance != nil && maintenance.RetryAfter.Duration < 0 {
+		return nil, fmt.Errorf("extended config %s: maintenance retry after cannot be negative", filename)
+	}
+
+	if theme := extendedConfig.Theme; theme != nil {
+		if len(theme.Directory) == 0 {
+			return nil, fmt.Errorf("extended config %s: theme requires a directory", filename)
+		}
+		if theme.StaticMaxAge.Duration < 0 {
+			return nil, fmt.Errorf("extended config %s: theme static max age cannot be negative", filename)
+		}
+	}
+
+	if secrets := extendedConfig.SessionSecrets; secrets != nil {
+		for _, file := range secrets.PreviousSecretsFiles {
+			if len(file) == 0 {
+				return nil, fmt.Errorf("extended config %s: previous session secrets files cannot be empty", filename)
+			}
+		}
+	}
+
+	if attributes := extendedConfig.Cookies; attributes != nil {
+		switch attributes.SameSite {
+		case "", CookieSameSiteLax, CookieSameSiteStrict, CookieSameSiteNone:
+		default:
+			return nil, fmt.Errorf("extended config %s: unknown cookie SameSite mode %q", filename, attributes.SameSite)
+		}
+		if len(attributes.Path) > 0 && !strings.HasPrefix(attributes.Path, "/") {
+			return nil, fmt.Errorf("extended config %s: cookie path %q must start with /", filename, attributes.Path)
+		}
+		switch attributes.NamePrefix {
+		case "", cookies.SecurePrefix:
+		case cookies.HostPrefix:
+			if len(attributes.Domain) > 0 || (len(attributes.Path) > 0 && attributes.Path != "/") {
+				return nil, fmt.Errorf("extended config %s: cookies with the %s prefix cannot have a domain or a path other than /", filename, cookies.HostPrefix)
+			}
+		default:
+			return nil, fmt.Errorf("extended config %s: unknown cookie name prefix %q", filename, attributes.NamePrefix)
+		}
+		if attributes.Partitioned && attributes.SameSite != CookieSameSiteNone {
+			return nil, fmt.Errorf("extended config %s: partitioned cookies require SameSite None", filename)
+		}
+	}
+
+	return extendedConfig, nil
+}
+
+// validateChallengeParameters checks that the parameters are tokens with values that can be sent in headers
+// ReadOAuthClientDefinitions reads a file with a list of OAuthClient definitions in YAML or JSON
+func ReadOAuthClientDefinitions(filename string) ([]OAuthClientDefinition, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	jsonData, err := yaml.ToJSON(data)
+	if err != nil {
+		// probably just json already
+		jsonData = data
+	}
+
+	definitions := []OAuthClientDefinition{}
+	decoder := json.NewDecoder(bytes.NewBuffer(jsonData))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&definitions); err != nil {
+		return nil, fmt.Errorf("error reading OAuth clients %s: %v", filename, err)
+	}
+	if err := validateOAuthClientDefinitions(definitions); err != nil {
+		return nil, fmt.Errorf("OAuth clients %s: %v", filename, err)
+	}
+	return definitions, nil
+}
+
+// validateOAuthClientDefinitions checks the definitions of a single source, names only have to be unique across
+// sources once the sources are combined
+func validateOAuthClientDefinitions(definitions []OAuthClientDefinition) error {
+	names := map[string]bool{}
+	for _, client := range definitions {
+		if len(client.Name) == 0 {
+			return fmt.Errorf("OAuth client definitions require a name")
+		}
+		if names[client.Name] {
+			return fmt.Errorf("duplicate definition of OAuth client %q", client.Name)
+		}
+		names[client.Name] = true
+		if len(client.RedirectURIs) == 0 {
+			return fmt.Errorf("OAuth client %q requires redirect URIs", client.Name)
+		}
+		for _, redirectURI := range client.RedirectURIs {
+			if u, err := url.Parse(redirectURI); err != nil || !u.IsAbs() {
+				return fmt.Errorf("redirect URI %q of OAuth client %q must be an absolute URL", redirectURI, client.Name)
+			}
+		}
+		if len(client.GrantMethod) > 0 && !ValidGrantHandlerTypes.Has(string(client.GrantMethod)) {
+			return fmt.Errorf("unknown grant method %q of OAuth client %q", client.GrantMethod, client.Name)
+		}
+		if maxAge := client.AccessTokenMaxAgeSeconds; maxAge != nil && *maxAge < 0 {
+			return fmt.Errorf("access token max age of OAuth client %q must not be negative", client.Name)
+		}
+		if timeout := client.AccessTokenInactivityTimeoutSeconds; timeout != nil && *timeout != 0 && *timeout < 300 {
+			return fmt.Errorf("access token inactivity timeout of OAuth client %q must be 0 or at least 300 seconds", client.Name)
+		}
+	}
+	return nil
+}
+
+func validateChallengeParameters(parameters []ChallengeParameter) error {
+	for _, parameter := range parameters {
+		if !httpguts.ValidHeaderFieldName(parameter.Name) {
+			return fmt.Errorf("invalid parameter name %q", parameter.Name)
+		}
+		if !httpguts.ValidHeaderFieldValue(parameter.Value) {
+			return fmt.Errorf("invalid value %q of parameter %q", parameter.Value, parameter.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package credentialsversion
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apiserver/pkg/authentication/user"
+
+	oauthv1 "github.com/openshift/api/oauth/v1"
+	userv1 "github.com/openshift/api/user/v1"
+	oauthfake "github.com/openshift/client-go/oauth/clientset/versioned/fake"
+	userfake "github.com/openshift/client-go/user/clientset/versioned/fake"
+
+	authapi "github.com/openshift/oauth-server/pkg/api"
+	"github.com/openshift/oauth-server/pkg/deprovisioning"
+)
+
+type testMapper struct {
+	identity authapi.UserIdentityInfo
+}
+
+func (m *testMapper) UserFor(identity authapi.UserIdentityInfo) (user.Info, error) {
+	m.identity = identity
+	return &user.DefaultInfo{Name: "bob", UID: "bob-uid"}, nil
+}
+
+func newTestClients() (*userfake.Clientset, *oauthfake.Clientset, *deprovisioning.Revoker) {
+	userClient := userfake.NewSimpleClientset(
+		&userv1.User{ObjectMeta: metav1.ObjectMeta{Name: "bob", UID: "bob-uid"}},
+		&userv1.Identity{ObjectMeta: metav1.ObjectMeta{Name: "htpasswd:bob"}, User: corev1.ObjectReference{Name: "bob", UID: "bob-uid"}},
+	)
+	oauthClient := oauthfake.NewSimpleClientset()
+	return userClient, oauthClient, deprovisioning.NewRevoker(oauthClient.OauthV1().OAuthAccessTokens(), oauthClient.OauthV1().OAuthAuthorizeTokens(), nil)
+}
+
+func addToken(t *testing.T, oauthClient *oauthfake.Clientset, name string) {
+	token := &oauthv1.OAuthAccessToken{ObjectMeta: metav1.ObjectMeta{Name: name}, UserName: "bob", UserUID: "bob-uid"}
+	if _, err := oauthClient.OauthV1().OAuthAccessTokens().Create(context.TODO(), token, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func tokenCount(t *testing.T, oauthClient *oauthfake.Clientset) int {
+	tokens, err := oauthClient.OauthV1().OAuthAccessTokens().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(tokens.Items)
+}
+
+func TestUserMapper(t *testing.T) {
+	userClient, oauthClient, revoker := newTestClients()
+	delegate := &testMapper{}
+	mapper := NewUserMapper(delegate, userClient.UserV1().Users(), revoker)
+	login := func(version string) {
+		identity := authapi.NewDefaultUserIdentityInfo("htpasswd", "bob")
+		identity.Extra[authapi.IdentityCredentialsVersionKey] = version
+		if _, err := mapper.UserFor(identity); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := delegate.identity.GetExtra()[authapi.IdentityCredentialsVersionKey]; ok {
+			t.Errorf("expected the credentials version to not be passed on")
+		}
+	}
+
+	addToken(t, oauthClient, "sha256~first")
+	login("v1")
+	if count := tokenCount(t, oauthClient); count != 1 {
+		t.Errorf("expected the first recorded version to keep the tokens, got %d tokens", count)
+	}
+	login("v1")
+	if count := tokenCount(t, oauthClient); count != 1 {
+		t.Errorf("expected the same version to keep the tokens, got %d tokens", count)
+	}
+	login("v2")
+	if count := tokenCount(t, oauthClient); count != 0 {
+		t.Errorf("expected a new version to revoke the tokens, got %d tokens", count)
+	}
+
+	bob, err := userClient.UserV1().Users().Get(context.TODO(), "bob", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if versions := bob.Annotations[CredentialsVersionsAnnotation]; versions != `{"htpasswd:bob":"v2"}` {
+		t.Errorf("unexpected recorded versions %s", versions)
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	userClient, oauthClient, revoker := newTestClients()
+	addToken(t, oauthClient, "sha256~token")
+	webhook := NewWebhook("htpasswd", "secret", userClient.UserV1().Identities(), revoker)
+	mux := http.NewServeMux()
+	webhook.Install(mux, "/oauth/credentials-changed/htpasswd")
+
+	post := func(authorization, username string) int {
+		req := httptest.NewRequest(http.MethodPost, "/oauth/credentials-changed/htpasswd", strings.NewReader(url.Values{"username": {username}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Authorization", authorization)
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		return resp.Code
+	}
+
+	if code := post("Bearer wrong", "bob"); code != http.StatusUnauthorized {
+		t.Errorf("expected wrong secret to be rejected, got %d", code)
+	}
+	if code := post("secret", "bob"); code != http.StatusUnauthorized {
+		t.Errorf("expected the secret without the Bearer scheme to be rejected, got %d", code)
+	}
+	if code := post("Bearer secret", "alice"); code != http.StatusNoContent {
+		t.Errorf("expected unknown identity to be accepted, got %d", code)
+	}
+	if count := tokenCount(t, oauthClient); count != 1 {
+		t.Errorf("expected tokens to be kept, got %d tokens", count)
+	}
+	if code := post("Bearer secret", "bob"); code != http.StatusNoContent {
+		t.Errorf("expected success, got %d", code)
+	}
+	if count := tokenCount(t, oauthClient); count != 0 {
+		t.Errorf("expected tokens to be revoked, got %d tokens", count)
+	}
+}
@@ -0,0 +1,110 @@
+// Package credentialsversion revokes the tokens and sessions of users once the credentials of one of their
+// identities change, either when the identity logs in with credentials of a new version or when the identity
+// provider reports the change.
+package credentialsversion
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+
+	userclient "github.com/openshift/client-go/user/clientset/versioned/typed/user/v1"
+
+	authapi "github.com/openshift/oauth-server/pkg/api"
+	"github.com/openshift/oauth-server/pkg/deprovisioning"
+)
+
+// CredentialsVersionsAnnotation is the annotation of users that holds the versions of the credentials their
+// identities last logged in with, as a JSON object by identity name
+const CredentialsVersionsAnnotation = "oauth.openshift.io/credentials-versions"
+
+type userMapper struct {
+	delegate authapi.UserIdentityMapper
+	users    userclient.UserInterface
+	revoker  *deprovisioning.Revoker
+}
+
+// NewUserMapper returns a mapper that records the credentials version of identities in their user. When an
+// identity logs in with another version than before, the tokens and sessions of its user are revoked. The
+// version is not passed on to the delegate, so it is never stored in identities.
+func NewUserMapper(delegate authapi.UserIdentityMapper, users userclient.UserInterface, revoker *deprovisioning.Revoker) authapi.UserIdentityMapper {
+	return &userMapper{delegate: delegate, users: users, revoker: revoker}
+}
+
+func (m *userMapper) UserFor(identityInfo authapi.UserIdentityInfo) (user.Info, error) {
+	version := identityInfo.GetExtra()[authapi.IdentityCredentialsVersionKey]
+	if len(version) == 0 {
+		return m.delegate.UserFor(identityInfo)
+	}
+
+	identity := &authapi.DefaultUserIdentityInfo{
+		ProviderName:     identityInfo.GetProviderName(),
+		ProviderUserName: identityInfo.GetProviderUserName(),
+		ProviderGroups:   identityInfo.GetProviderGroups(),
+		Extra:            map[string]string{},
+	}
+	for k, v := range identityInfo.GetExtra() {
+		if k != authapi.IdentityCredentialsVersionKey {
+			identity.Extra[k] = v
+		}
+	}
+
+	u, err := m.delegate.UserFor(identity)
+	if err != nil {
+		return nil, err
+	}
+	// tokens issued for the earlier credentials must be gone before the login succeeds
+	if err := m.record(u, identity.GetIdentityName(), version); err != nil {
+		return nil, fmt.Errorf("unable to record credentials version of identity %q: %v", identity.GetIdentityName(), err)
+	}
+	return u, nil
+}
+
+// record stores the credentials version of the identity in its user, and revokes the tokens and sessions of
+// the user if a different version was recorded before
+func (m *userMapper) record(u user.Info, identityName, version string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		userObj, err := m.users.Get(context.TODO(), u.GetName(), metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if string(userObj.UID) != u.GetUID() {
+			return fmt.Errorf("user %q was replaced since the login", u.GetName())
+		}
+
+		versions := map[string]string{}
+		if data := userObj.Annotations[CredentialsVersionsAnnotation]; len(data) > 0 {
+			if err := json.Unmarshal([]byte(data), &versions); err != nil {
+				klog.Warningf("ignoring invalid %s annotation of user %q: %v", CredentialsVersionsAnnotation, u.GetName(), err)
+				versions = map[string]string{}
+			}
+		}
+		previous, recorded := versions[identityName]
+		if previous == version {
+			return nil
+		}
+		if recorded {
+			klog.V(2).Infof("credentials of identity %q changed, revoking the tokens and sessions of user %q", identityName, u.GetName())
+			if err := m.revoker.RevokeUser(u.GetName(), u.GetUID()); err != nil {
+				return err
+			}
+		}
+
+		versions[identityName] = version
+		data, err := json.Marshal(versions)
+		if err != nil {
+			return err
+		}
+		if userObj.Annotations == nil {
+			userObj.Annotations = map[string]string{}
+		}
+		userObj.Annotations[CredentialsVersionsAnnotation] = string(data)
+		_, err = m.users.Update(context.TODO(), userObj, metav1.UpdateOptions{})
+		return err
+	})
+}
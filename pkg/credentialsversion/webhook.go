@@ -0,0 +1,91 @@
+package credentialsversion
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	userclient "github.com/openshift/client-go/user/clientset/versioned/typed/user/v1"
+
+	"github.com/openshift/oauth-server/pkg"
+	authapi "github.com/openshift/oauth-server/pkg/api"
+	"github.com/openshift/oauth-server/pkg/deprovisioning"
+	"github.com/openshift/oauth-server/pkg/server/crypto"
+)
+
+// usernameParam is the form parameter that holds the name of the user at the identity provider
+const usernameParam = "username"
+
+// NewWebhook returns the endpoint an identity provider posts to when the credentials of one of its users changed.
+// Requests are authenticated by the secret as bearer token. All sessions and tokens of the user the identity of
+// the username parameter is mapped to are revoked.
+func NewWebhook(providerName, secret string, identities userclient.IdentityInterface, revoker *deprovisioning.Revoker) oauthserver.Endpoints {
+	return &webhook{
+		providerName: providerName,
+		secret:       secret,
+		identities:   identities,
+		revoker:      revoker,
+	}
+}
+
+type webhook struct {
+	providerName string
+	secret       string
+	identities   userclient.IdentityInterface
+	revoker      *deprovisioning.Revoker
+}
+
+func (h *webhook) Install(mux oauthserver.Mux, prefix string) {
+	mux.Handle(prefix, h)
+}
+
+func (h *webhook) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+
+	authorization := req.Header.Get("Authorization")
+	token := strings.TrimPrefix(authorization, "Bearer ")
+	if token == authorization || len(token) == 0 || !crypto.IsEqualConstantTime(token, h.secret) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	username := req.PostFormValue(usernameParam)
+	if len(username) == 0 {
+		http.Error(w, "missing username", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.revoke(username); err != nil {
+		klog.Errorf("error revoking the tokens of %q after its credentials changed at identity provider %q: %v", username, h.providerName, err)
+		http.Error(w, "failed to revoke tokens", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// revoke revokes the sessions and tokens of the user the identity of username is mapped to
+func (h *webhook) revoke(username string) error {
+	identityName := authapi.NewDefaultUserIdentityInfo(h.providerName, username).GetIdentityName()
+	identity, err := h.identities.Get(context.TODO(), identityName, metav1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		// the user never logged in, there is nothing to revoke
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(identity.User.Name) == 0 || len(identity.User.UID) == 0 {
+		return nil
+	}
+
+	klog.V(2).Infof("identity provider %q reported changed credentials of identity %q, revoking the tokens and sessions of user %q", h.providerName, identityName, identity.User.Name)
+	return h.revoker.RevokeUser(identity.User.Name, string(identity.User.UID))
+}
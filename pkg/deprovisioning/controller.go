@@ -0,0 +1,177 @@
+// Package deprovisioning revokes the tokens and sessions of users once they or their identities are deleted.
+package deprovisioning
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+
+	userv1 "github.com/openshift/api/user/v1"
+	oauthclient "github.com/openshift/client-go/oauth/clientset/versioned/typed/oauth/v1"
+	userclient "github.com/openshift/client-go/user/clientset/versioned/typed/user/v1"
+	userinformer "github.com/openshift/client-go/user/informers/externalversions/user/v1"
+
+	"github.com/openshift/oauth-server/pkg/server/session"
+)
+
+// userKey identifies a user whose tokens and sessions are revoked
+type userKey struct {
+	name string
+	uid  string
+}
+
+// identityKey identifies an identity that is deleted if it still belongs to the deleted user
+type identityKey struct {
+	name    string
+	userUID string
+}
+
+// Controller watches for deleted users and identities. When either is deleted, the access and
+// authorize tokens and the sessions of the user are revoked. Optionally, the identities of deleted
+// users are deleted as well. Deletions that happen while the server is not running are not seen.
+type Controller struct {
+	revoker    *Revoker
+	identities userclient.IdentityInterface
+
+	deleteIdentities bool
+
+	usersSynced      cache.InformerSynced
+	identitiesSynced cache.InformerSynced
+	queue            workqueue.RateLimitingInterface
+}
+
+// NewController returns a controller that revokes the tokens of deleted users and identities, their sessions if
+// sessions is set and their upstream tokens if upstreamTokens is set. If deleteIdentities is set, the identities of deleted users are deleted.
+func NewController(
+	users userinformer.UserInformer,
+	identities userinformer.IdentityInformer,
+	identityClient userclient.IdentityInterface,
+	accessTokens oauthclient.OAuthAccessTokenInterface,
+	authorizeTokens oauthclient.OAuthAuthorizeTokenInterface,
+	sessions *session.Revocations,
+	upstreamTokens UpstreamTokens,
+	deleteIdentities bool,
+) *Controller {
+	c := &Controller{
+		revoker:          NewRevokerWithOptions(accessTokens, authorizeTokens, RevokerOptions{Sessions: sessions, UpstreamTokens: upstreamTokens}),
+		identities:       identityClient,
+		deleteIdentities: deleteIdentities,
+		usersSynced:      users.Informer().HasSynced,
+		identitiesSynced: identities.Informer().HasSynced,
+		queue:            workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "deprovisioning"),
+	}
+	users.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{DeleteFunc: c.userDeleted})
+	identities.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{DeleteFunc: c.identityDeleted})
+	return c
+}
+
+func (c *Controller) userDeleted(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	user, ok := obj.(*userv1.User)
+	if !ok {
+		utilruntime.HandleError(fmt.Errorf("unexpected object of type %T in user deletion", obj))
+		return
+	}
+
+	c.queue.Add(userKey{name: user.Name, uid: string(user.UID)})
+	if c.deleteIdentities {
+		for _, identity := range user.Identities {
+			c.queue.Add(identityKey{name: identity, userUID: string(user.UID)})
+		}
+	}
+}
+
+func (c *Controller) identityDeleted(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	identity, ok := obj.(*userv1.Identity)
+	if !ok {
+		utilruntime.HandleError(fmt.Errorf("unexpected object of type %T in identity deletion", obj))
+		return
+	}
+
+	// identities that were never mapped to a user have nothing to revoke
+	if len(identity.User.Name) == 0 || len(identity.User.UID) == 0 {
+		return
+	}
+	c.queue.Add(userKey{name: identity.User.Name, uid: string(identity.User.UID)})
+}
+
+// Run processes deletions until stopCh is closed
+func (c *Controller) Run(workers int, stopCh <-chan struct{}) {
+	defer utilruntime.HandleCrash()
+	defer c.queue.ShutDown()
+
+	if !cache.WaitForCacheSync(stopCh, c.usersSynced, c.identitiesSynced) {
+		return
+	}
+
+	for i := 0; i < workers; i++ {
+		go wait.Until(c.runWorker, time.Second, stopCh)
+	}
+	<-stopCh
+}
+
+func (c *Controller) runWorker() {
+	for c.processNextWorkItem() {
+	}
+}
+
+func (c *Controller) processNextWorkItem() bool {
+	key, quit := c.queue.Get()
+	if quit {
+		return false
+	}
+	defer c.queue.Done(key)
+
+	if err := c.sync(key); err != nil {
+		utilruntime.HandleError(err)
+		c.queue.AddRateLimited(key)
+		return true
+	}
+	c.queue.Forget(key)
+	return true
+}
+
+func (c *Controller) sync(key interface{}) error {
+	switch key := key.(type) {
+	case userKey:
+		return c.revoker.RevokeUser(key.name, key.uid)
+	case identityKey:
+		return c.deleteIdentity(key)
+	default:
+		return fmt.Errorf("unexpected key of type %T", key)
+	}
+}
+
+// deleteIdentity deletes the identity if it is still mapped to the deleted user
+func (c *Controller) deleteIdentity(key identityKey) error {
+	identity, err := c.identities.Get(context.TODO(), key.name, metav1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error getting identity %q: %v", key.name, err)
+	}
+	if string(identity.User.UID) != key.userUID {
+		return nil
+	}
+
+	err = c.identities.Delete(context.TODO(), key.name, metav1.DeleteOptions{Preconditions: metav1.NewUIDPreconditions(string(identity.UID))})
+	if err != nil && !kerrors.IsNotFound(err) && !kerrors.IsConflict(err) {
+		return fmt.Errorf("error deleting identity %q: %v", key.name, err)
+	}
+	klog.V(4).Infof("deleted identity %q of deleted user", key.name)
+	return nil
+}
@@ -0,0 +1,132 @@
+package deprovisioning
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+
+	oauthv1 "github.com/openshift/api/oauth/v1"
+	userv1 "github.com/openshift/api/user/v1"
+	oauthfake "github.com/openshift/client-go/oauth/clientset/versioned/fake"
+	userfake "github.com/openshift/client-go/user/clientset/versioned/fake"
+	userinformer "github.com/openshift/client-go/user/informers/externalversions"
+
+	"github.com/openshift/oauth-server/pkg/server/session"
+)
+
+func newTestController(t *testing.T, deleteIdentities bool, userObjects, oauthObjects []runtime.Object) (*Controller, *userfake.Clientset, *oauthfake.Clientset, *session.Revocations) {
+	userClient := userfake.NewSimpleClientset(userObjects...)
+	oauthClient := oauthfake.NewSimpleClientset(oauthObjects...)
+	informers := userinformer.NewSharedInformerFactory(userClient, 0)
+	sessions := session.NewRevocations(time.Hour)
+
+	c := NewController(
+		informers.User().V1().Users(),
+		informers.User().V1().Identities(),
+		userClient.UserV1().Identities(),
+		oauthClient.OauthV1().OAuthAccessTokens(),
+		oauthClient.OauthV1().OAuthAuthorizeTokens(),
+		sessions,
+		nil,
+		deleteIdentities,
+	)
+	t.Cleanup(c.queue.ShutDown)
+	return c, userClient, oauthClient, sessions
+}
+
+func TestUserDeleted(t *testing.T) {
+	bob := &userv1.User{
+		ObjectMeta: metav1.ObjectMeta{Name: "bob", UID: "bob-uid"},
+		Identities: []string{"idp:bob", "other:bob"},
+	}
+	identity := &userv1.Identity{
+		ObjectMeta: metav1.ObjectMeta{Name: "idp:bob", UID: "identity-uid"},
+		User:       corev1.ObjectReference{Name: "bob", UID: "bob-uid"},
+	}
+	// mapped to a user that reused the name since
+	remappedIdentity := &userv1.Identity{
+		ObjectMeta: metav1.ObjectMeta{Name: "other:bob", UID: "other-identity-uid"},
+		User:       corev1.ObjectReference{Name: "bob", UID: "new-bob-uid"},
+	}
+	tokens := []runtime.Object{
+		&oauthv1.OAuthAccessToken{ObjectMeta: metav1.ObjectMeta{Name: "sha256~bob"}, UserName: "bob", UserUID: "bob-uid"},
+		&oauthv1.OAuthAccessToken{ObjectMeta: metav1.ObjectMeta{Name: "sha256~new-bob"}, UserName: "bob", UserUID: "new-bob-uid"},
+		&oauthv1.OAuthAccessToken{ObjectMeta: metav1.ObjectMeta{Name: "sha256~alice"}, UserName: "alice", UserUID: "alice-uid"},
+		&oauthv1.OAuthAuthorizeToken{ObjectMeta: metav1.ObjectMeta{Name: "sha256~bob-code"}, UserName: "bob", UserUID: "bob-uid"},
+	}
+
+	c, userClient, oauthClient, sessions := newTestController(t, true, []runtime.Object{identity, remappedIdentity}, tokens)
+	issuedAt := time.Now().Add(-time.Minute)
+
+	c.userDeleted(cache.DeletedFinalStateUnknown{Key: "bob", Obj: bob})
+	if c.queue.Len() != 3 {
+		t.Fatalf("expected the user and its identities to be queued, got %d items", c.queue.Len())
+	}
+	for c.queue.Len() > 0 {
+		key, _ := c.queue.Get()
+		if err := c.sync(key); err != nil {
+			t.Fatal(err)
+		}
+		c.queue.Done(key)
+	}
+
+	accessTokens, err := oauthClient.OauthV1().OAuthAccessTokens().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	remaining := map[string]bool{}
+	for _, token := range accessTokens.Items {
+		remaining[token.Name] = true
+	}
+	if remaining["sha256~bob"] || !remaining["sha256~new-bob"] || !remaining["sha256~alice"] {
+		t.Errorf("unexpected remaining access tokens %v", remaining)
+	}
+	authorizeTokens, err := oauthClient.OauthV1().OAuthAuthorizeTokens().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(authorizeTokens.Items) != 0 {
+		t.Errorf("expected authorize tokens to be revoked, got %v", authorizeTokens.Items)
+	}
+
+	if !sessions.Revoked("bob-uid", issuedAt) {
+		t.Error("expected sessions to be revoked")
+	}
+	if sessions.Revoked("bob-uid", time.Now().Add(time.Minute)) {
+		t.Error("expected later sessions to be valid")
+	}
+	if sessions.Revoked("new-bob-uid", issuedAt) {
+		t.Error("expected sessions of other users to be valid")
+	}
+
+	if _, err := userClient.UserV1().Identities().Get(context.TODO(), "idp:bob", metav1.GetOptions{}); err == nil {
+		t.Error("expected identity of deleted user to be deleted")
+	}
+	if _, err := userClient.UserV1().Identities().Get(context.TODO(), "other:bob", metav1.GetOptions{}); err != nil {
+		t.Errorf("expected remapped identity to be kept: %v", err)
+	}
+}
+
+func TestIdentityDeleted(t *testing.T) {
+	c, _, _, _ := newTestController(t, false, nil, nil)
+
+	c.identityDeleted(&userv1.Identity{ObjectMeta: metav1.ObjectMeta{Name: "idp:unmapped"}})
+	if c.queue.Len() != 0 {
+		t.Fatalf("expected unmapped identity to be ignored, got %d items", c.queue.Len())
+	}
+
+	c.identityDeleted(&userv1.Identity{
+		ObjectMeta: metav1.ObjectMeta{Name: "idp:bob"},
+		User:       corev1.ObjectReference{Name: "bob", UID: types.UID("bob-uid")},
+	})
+	key, _ := c.queue.Get()
+	if key != (userKey{name: "bob", uid: "bob-uid"}) {
+		t.Errorf("unexpected key %#v", key)
+	}
+}
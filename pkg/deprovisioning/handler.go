@@ -0,0 +1,127 @@
+package deprovisioning
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	kerrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apiserver/pkg/endpoints/request"
+
+	userclient "github.com/openshift/client-go/user/clientset/versioned/typed/user/v1"
+
+	"github.com/openshift/oauth-server/pkg/logging"
+)
+
+// Handler lets administrators force the logout of a user, of all users of an identity provider, or revoke all
+// tokens issued to a client
+type Handler struct {
+	revoker    *Revoker
+	users      userclient.UserInterface
+	identities userclient.IdentityInterface
+}
+
+// NewHandler returns a Handler that revokes with the given revoker
+func NewHandler(revoker *Revoker, users userclient.UserInterface, identities userclient.IdentityInterface) *Handler {
+	return &Handler{revoker: revoker, users: users, identities: identities}
+}
+
+// ServeHTTP revokes on POST with exactly one of the user, client or provider parameters, e.g. ?user=bob, and
+// returns the counts of what it revoked. With dryRun=true nothing is revoked, only counted. Tokens do not record the
+// identity provider they were issued through, so the provider revokes all tokens and sessions of its users.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := req.URL.Query()
+	dryRun := false
+	if value := query.Get("dryRun"); len(value) > 0 {
+		var err error
+		if dryRun, err = strconv.ParseBool(value); err != nil {
+			http.Error(w, "the dryRun parameter must be true or false", http.StatusBadRequest)
+			return
+		}
+	}
+
+	targets := 0
+	for _, parameter := range []string{"user", "client", "provider"} {
+		if len(query.Get(parameter)) > 0 {
+			targets++
+		}
+	}
+	if targets != 1 {
+		http.Error(w, "exactly one of the user, client and provider parameters is required", http.StatusBadRequest)
+		return
+	}
+
+	var revocation Revocation
+	var err error
+	switch userName, clientName, provider := query.Get("user"), query.Get("client"), query.Get("provider"); {
+	case len(userName) > 0:
+		revocation, err = h.revokeUser(userName, dryRun)
+	case len(clientName) > 0:
+		revocation, err = h.revoker.RevokeClient(clientName, dryRun)
+	default:
+		revocation, err = h.revokeProvider(provider, dryRun)
+	}
+	if err != nil {
+		if status, ok := err.(kerrs.APIStatus); ok {
+			http.Error(w, err.Error(), int(status.Status().Code))
+			return
+		}
+		logging.FromRequest(req).Error(err, "Error forcing logout", "query", req.URL.RawQuery)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !dryRun {
+		admin := ""
+		if info, ok := request.UserFrom(req.Context()); ok {
+			admin = info.GetName()
+		}
+		logging.FromRequest(req).Info(0, "Forced logout", "user", query.Get("user"), "client", query.Get("client"), "provider", query.Get("provider"),
+			"users", revocation.Users, "accessTokens", revocation.AccessTokens, "authorizeTokens", revocation.AuthorizeTokens, "admin", admin)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&revocation); err != nil {
+		utilruntime.HandleError(fmt.Errorf("unable to write revocation: %v", err))
+	}
+}
+
+func (h *Handler) revokeUser(name string, dryRun bool) (Revocation, error) {
+	user, err := h.users.Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return Revocation{}, err
+	}
+	return h.revoker.Revoke(user.Name, string(user.UID), dryRun)
+}
+
+// revokeProvider revokes the users of the identities of the provider, each user once
+func (h *Handler) revokeProvider(provider string, dryRun bool) (Revocation, error) {
+	identities, err := h.identities.List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return Revocation{}, err
+	}
+	revocation := Revocation{}
+	revoked := sets.NewString()
+	for _, identity := range identities.Items {
+		uid := string(identity.User.UID)
+		if identity.ProviderName != provider || len(identity.User.Name) == 0 || revoked.Has(uid) {
+			continue
+		}
+		revoked.Insert(uid)
+		userRevocation, err := h.revoker.Revoke(identity.User.Name, uid, dryRun)
+		if err != nil {
+			return revocation, err
+		}
+		revocation.add(userRevocation)
+	}
+	return revocation, nil
+}
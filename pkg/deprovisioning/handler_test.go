@@ -0,0 +1,158 @@
+package deprovisioning
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	oauthv1 "github.com/openshift/api/oauth/v1"
+	userv1 "github.com/openshift/api/user/v1"
+	oauthfake "github.com/openshift/client-go/oauth/clientset/versioned/fake"
+	userfake "github.com/openshift/client-go/user/clientset/versioned/fake"
+
+	"github.com/openshift/oauth-server/pkg/server/session"
+)
+
+func TestHandler(t *testing.T) {
+	testCases := map[string]struct {
+		Method string
+		URL    string
+
+		ExpectedStatus     int
+		ExpectedRevocation Revocation
+		ExpectedTokens     []string
+		ExpectedRevoked    []string
+	}{
+		"user": {
+			Method:             http.MethodPost,
+			URL:                "/debug/force-logout?user=bob",
+			ExpectedStatus:     http.StatusOK,
+			ExpectedRevocation: Revocation{Users: 1, AccessTokens: 2, AuthorizeTokens: 1},
+			ExpectedTokens:     []string{"sha256~alice-console"},
+			ExpectedRevoked:    []string{"bob-uid"},
+		},
+		"dry run": {
+			Method:             http.MethodPost,
+			URL:                "/debug/force-logout?user=bob&dryRun=true",
+			ExpectedStatus:     http.StatusOK,
+			ExpectedRevocation: Revocation{Users: 1, AccessTokens: 2, AuthorizeTokens: 1},
+			ExpectedTokens:     []string{"sha256~alice-console", "sha256~bob-cli", "sha256~bob-console"},
+		},
+		"dry run without tokens": {
+			Method:             http.MethodPost,
+			URL:                "/debug/force-logout?user=dave&dryRun=true",
+			ExpectedStatus:     http.StatusOK,
+			ExpectedRevocation: Revocation{},
+			ExpectedTokens:     []string{"sha256~alice-console", "sha256~bob-cli", "sha256~bob-console"},
+		},
+		"client": {
+			Method:             http.MethodPost,
+			URL:                "/debug/force-logout?client=console",
+			ExpectedStatus:     http.StatusOK,
+			ExpectedRevocation: Revocation{AccessTokens: 2, AuthorizeTokens: 1},
+			ExpectedTokens:     []string{"sha256~bob-cli"},
+		},
+		"provider": {
+			Method:             http.MethodPost,
+			URL:                "/debug/force-logout?provider=ldap",
+			ExpectedStatus:     http.StatusOK,
+			ExpectedRevocation: Revocation{Users: 1, AccessTokens: 1},
+			ExpectedTokens:     []string{"sha256~bob-cli", "sha256~bob-console"},
+			ExpectedRevoked:    []string{"alice-uid"},
+		},
+		"unknown user": {
+			Method:         http.MethodPost,
+			URL:            "/debug/force-logout?user=carol",
+			ExpectedStatus: http.StatusNotFound,
+			ExpectedTokens: []string{"sha256~alice-console", "sha256~bob-cli", "sha256~bob-console"},
+		},
+		"several targets": {
+			Method:         http.MethodPost,
+			URL:            "/debug/force-logout?user=bob&client=console",
+			ExpectedStatus: http.StatusBadRequest,
+			ExpectedTokens: []string{"sha256~alice-console", "sha256~bob-cli", "sha256~bob-console"},
+		},
+		"invalid dry run": {
+			Method:         http.MethodPost,
+			URL:            "/debug/force-logout?user=bob&dryRun=maybe",
+			ExpectedStatus: http.StatusBadRequest,
+			ExpectedTokens: []string{"sha256~alice-console", "sha256~bob-cli", "sha256~bob-console"},
+		},
+		"method not allowed": {
+			Method:         http.MethodGet,
+			URL:            "/debug/force-logout?user=bob",
+			ExpectedStatus: http.StatusMethodNotAllowed,
+			ExpectedTokens: []string{"sha256~alice-console", "sha256~bob-cli", "sha256~bob-console"},
+		},
+	}
+
+	for k, testCase := range testCases {
+		t.Run(k, func(t *testing.T) {
+			userClient := userfake.NewSimpleClientset(
+				&userv1.User{ObjectMeta: metav1.ObjectMeta{Name: "bob", UID: "bob-uid"}},
+				&userv1.User{ObjectMeta: metav1.ObjectMeta{Name: "alice", UID: "alice-uid"}},
+				&userv1.User{ObjectMeta: metav1.ObjectMeta{Name: "dave", UID: "dave-uid"}},
+				&userv1.Identity{ObjectMeta: metav1.ObjectMeta{Name: "htpasswd:bob"}, ProviderName: "htpasswd", User: corev1.ObjectReference{Name: "bob", UID: "bob-uid"}},
+				&userv1.Identity{ObjectMeta: metav1.ObjectMeta{Name: "ldap:alice"}, ProviderName: "ldap", User: corev1.ObjectReference{Name: "alice", UID: "alice-uid"}},
+			)
+			oauthClient := oauthfake.NewSimpleClientset(
+				&oauthv1.OAuthAccessToken{ObjectMeta: metav1.ObjectMeta{Name: "sha256~bob-console"}, ClientName: "console", UserName: "bob", UserUID: "bob-uid"},
+				&oauthv1.OAuthAccessToken{ObjectMeta: metav1.ObjectMeta{Name: "sha256~bob-cli"}, ClientName: "cli", UserName: "bob", UserUID: "bob-uid"},
+				&oauthv1.OAuthAccessToken{ObjectMeta: metav1.ObjectMeta{Name: "sha256~alice-console"}, ClientName: "console", UserName: "alice", UserUID: "alice-uid"},
+				&oauthv1.OAuthAuthorizeToken{ObjectMeta: metav1.ObjectMeta{Name: "sha256~bob-code"}, ClientName: "console", UserName: "bob", UserUID: "bob-uid"},
+			)
+			sessions := session.NewRevocations(time.Hour)
+			issuedAt := time.Now().Add(-time.Minute)
+			revoker := NewRevoker(oauthClient.OauthV1().OAuthAccessTokens(), oauthClient.OauthV1().OAuthAuthorizeTokens(), sessions)
+			handler := NewHandler(revoker, userClient.UserV1().Users(), userClient.UserV1().Identities())
+
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, httptest.NewRequest(testCase.Method, testCase.URL, nil))
+			if resp.Code != testCase.ExpectedStatus {
+				t.Fatalf("expected status %d, got %d: %s", testCase.ExpectedStatus, resp.Code, resp.Body.String())
+			}
+			if resp.Code == http.StatusOK {
+				revocation := Revocation{}
+				if err := json.Unmarshal(resp.Body.Bytes(), &revocation); err != nil {
+					t.Fatal(err)
+				}
+				if revocation != testCase.ExpectedRevocation {
+					t.Errorf("expected revocation %#v, got %#v", testCase.ExpectedRevocation, revocation)
+				}
+			}
+
+			tokens, err := oauthClient.OauthV1().OAuthAccessTokens().List(context.TODO(), metav1.ListOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			names := []string{}
+			for _, token := range tokens.Items {
+				names = append(names, token.Name)
+			}
+			if len(names) != len(testCase.ExpectedTokens) {
+				t.Fatalf("expected tokens %v, got %v", testCase.ExpectedTokens, names)
+			}
+			for i := range names {
+				if names[i] != testCase.ExpectedTokens[i] {
+					t.Errorf("expected tokens %v, got %v", testCase.ExpectedTokens, names)
+				}
+			}
+
+			for _, uid := range []string{"bob-uid", "alice-uid"} {
+				expected := false
+				for _, revoked := range testCase.ExpectedRevoked {
+					expected = expected || revoked == uid
+				}
+				if sessions.Revoked(uid, issuedAt) != expected {
+					t.Errorf("expected sessions of %s revoked to be %v", uid, expected)
+				}
+			}
+		})
+	}
+}
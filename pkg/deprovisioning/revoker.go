@@ -0,0 +1,220 @@
+package deprovisioning
+
+import (
+	"context"
+	"fmt"
+
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/klog/v2"
+
+	oauthclient "github.com/openshift/client-go/oauth/clientset/versioned/typed/oauth/v1"
+
+	"github.com/openshift/oauth-server/pkg/server/session"
+)
+
+// clientListPageSize is how many tokens are listed at once when the tokens of a client are revoked. Tokens cannot be
+// selected by client in every token storage, so all tokens are listed.
+const clientListPageSize = 500
+
+// UpstreamTokens deletes the tokens identity providers issued to users, see upstreamtoken.Vault
+type UpstreamTokens interface {
+	// DeleteUser deletes the tokens of the user with the given UID and returns how many there were. A dry run only
+	// counts them.
+	DeleteUser(uid string, dryRun bool) (int, error)
+}
+
+// Revoker revokes the access and authorize tokens, the sessions and the upstream tokens of users
+type Revoker struct {
+	accessTokens    oauthclient.OAuthAccessTokenInterface
+	authorizeTokens oauthclient.OAuthAuthorizeTokenInterface
+	sessions        *session.Revocations
+	lister          session.SessionLister
+	upstreamTokens  UpstreamTokens
+}
+
+// RevokerOptions are the optional parts of users a Revoker revokes
+type RevokerOptions struct {
+	// Sessions revokes the sessions of users, if set
+	Sessions *session.Revocations
+	// SessionLister finds the sessions of users, if set, so that users that are only logged in with a session are
+	// counted
+	SessionLister session.SessionLister
+	// UpstreamTokens deletes the tokens identity providers issued to users, if set
+	UpstreamTokens UpstreamTokens
+}
+
+// NewRevoker returns a revoker for the given tokens, sessions are only revoked if sessions is set
+func NewRevoker(accessTokens oauthclient.OAuthAccessTokenInterface, authorizeTokens oauthclient.OAuthAuthorizeTokenInterface, sessions *session.Revocations) *Revoker {
+	return NewRevokerWithOptions(accessTokens, authorizeTokens, RevokerOptions{Sessions: sessions})
+}
+
+// NewRevokerWithOptions returns a revoker like NewRevoker that also revokes what options sets
+func NewRevokerWithOptions(accessTokens oauthclient.OAuthAccessTokenInterface, authorizeTokens oauthclient.OAuthAuthorizeTokenInterface, options RevokerOptions) *Revoker {
+	return &Revoker{
+		accessTokens:    accessTokens,
+		authorizeTokens: authorizeTokens,
+		sessions:        options.Sessions,
+		lister:          options.SessionLister,
+		upstreamTokens:  options.UpstreamTokens,
+	}
+}
+
+// Revocation counts what a revocation revoked, or would revoke in a dry run
+type Revocation struct {
+	// Users is the number of users that were found to be logged in, with tokens or with sessions that can be listed.
+	// Sessions stored in cookies cannot be found, they are invalidated without being counted.
+	Users           int `json:"users"`
+	AccessTokens    int `json:"accessTokens"`
+	AuthorizeTokens int `json:"authorizeTokens"`
+	// UpstreamTokens is the number of identity providers whose tokens of users were deleted
+	UpstreamTokens int `json:"upstreamTokens,omitempty"`
+}
+
+func (r *Revocation) add(other Revocation) {
+	r.Users += other.Users
+	r.AccessTokens += other.AccessTokens
+	r.AuthorizeTokens += other.AuthorizeTokens
+	r.UpstreamTokens += other.UpstreamTokens
+}
+
+// RevokeUser deletes all tokens issued to the user, including its upstream tokens, and invalidates its sessions. Tokens of other
+// users with the same name, e.g. a user that was created again, are left alone.
+func (r *Revoker) RevokeUser(name, uid string) error {
+	revocation, err := r.Revoke(name, uid, false)
+	if err != nil {
+		return err
+	}
+	klog.V(4).Infof("revoked %d access tokens and %d authorize tokens of user %q", revocation.AccessTokens, revocation.AuthorizeTokens, name)
+	return nil
+}
+
+// Revoke is RevokeUser that returns what it revoked. A dry run only counts the tokens and sessions.
+func (r *Revoker) Revoke(name, uid string, dryRun bool) (Revocation, error) {
+	revocation := Revocation{}
+	sessions := 0
+	if r.lister != nil {
+		found, err := r.lister.ListSessions(uid)
+		if err != nil {
+			return revocation, fmt.Errorf("error listing sessions of user %q: %v", name, err)
+		}
+		sessions = len(found)
+	}
+	if r.sessions != nil && !dryRun {
+		if err := r.sessions.Revoke(uid); err != nil {
+			return revocation, fmt.Errorf("error revoking sessions of user %q: %v", name, err)
+		}
+	}
+
+	listOptions := metav1.ListOptions{FieldSelector: fields.OneTermEqualSelector("userName", name).String()}
+
+	accessTokens, err := r.accessTokens.List(context.TODO(), listOptions)
+	if err != nil {
+		return revocation, fmt.Errorf("error listing access tokens of user %q: %v", name, err)
+	}
+	for _, token := range accessTokens.Items {
+		if token.UserUID != uid {
+			continue
+		}
+		if err := r.deleteAccessToken(token.Name, dryRun); err != nil {
+			return revocation, fmt.Errorf("error revoking access token %q of user %q: %v", token.Name, name, err)
+		}
+		revocation.AccessTokens++
+	}
+
+	authorizeTokens, err := r.authorizeTokens.List(context.TODO(), listOptions)
+	if err != nil {
+		return revocation, fmt.Errorf("error listing authorize tokens of user %q: %v", name, err)
+	}
+	for _, token := range authorizeTokens.Items {
+		if token.UserUID != uid {
+			continue
+		}
+		if err := r.deleteAuthorizeToken(token.Name, dryRun); err != nil {
+			return revocation, fmt.Errorf("error revoking authorize token %q of user %q: %v", token.Name, name, err)
+		}
+		revocation.AuthorizeTokens++
+	}
+
+	if r.upstreamTokens != nil {
+		deleted, err := r.upstreamTokens.DeleteUser(uid, dryRun)
+		if err != nil {
+			return revocation, fmt.Errorf("error deleting upstream tokens of user %q: %v", name, err)
+		}
+		revocation.UpstreamTokens = deleted
+	}
+
+	if sessions > 0 || revocation.AccessTokens > 0 || revocation.AuthorizeTokens > 0 || revocation.UpstreamTokens > 0 {
+		revocation.Users = 1
+	}
+	return revocation, nil
+}
+
+// RevokeClient deletes all tokens issued to the client. Sessions are left alone, they do not belong to clients.
+func (r *Revoker) RevokeClient(clientName string, dryRun bool) (Revocation, error) {
+	revocation := Revocation{}
+
+	listOptions := metav1.ListOptions{Limit: clientListPageSize}
+	for {
+		accessTokens, err := r.accessTokens.List(context.TODO(), listOptions)
+		if err != nil {
+			return revocation, fmt.Errorf("error listing access tokens of client %q: %v", clientName, err)
+		}
+		for _, token := range accessTokens.Items {
+			if token.ClientName != clientName {
+				continue
+			}
+			if err := r.deleteAccessToken(token.Name, dryRun); err != nil {
+				return revocation, fmt.Errorf("error revoking access token %q of client %q: %v", token.Name, clientName, err)
+			}
+			revocation.AccessTokens++
+		}
+		if len(accessTokens.Continue) == 0 {
+			break
+		}
+		listOptions.Continue = accessTokens.Continue
+	}
+
+	listOptions = metav1.ListOptions{Limit: clientListPageSize}
+	for {
+		authorizeTokens, err := r.authorizeTokens.List(context.TODO(), listOptions)
+		if err != nil {
+			return revocation, fmt.Errorf("error listing authorize tokens of client %q: %v", clientName, err)
+		}
+		for _, token := range authorizeTokens.Items {
+			if token.ClientName != clientName {
+				continue
+			}
+			if err := r.deleteAuthorizeToken(token.Name, dryRun); err != nil {
+				return revocation, fmt.Errorf("error revoking authorize token %q of client %q: %v", token.Name, clientName, err)
+			}
+			revocation.AuthorizeTokens++
+		}
+		if len(authorizeTokens.Continue) == 0 {
+			break
+		}
+		listOptions.Continue = authorizeTokens.Continue
+	}
+	return revocation, nil
+}
+
+func (r *Revoker) deleteAccessToken(name string, dryRun bool) error {
+	if dryRun {
+		return nil
+	}
+	if err := r.accessTokens.Delete(context.TODO(), name, metav1.DeleteOptions{}); err != nil && !kerrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func (r *Revoker) deleteAuthorizeToken(name string, dryRun bool) error {
+	if dryRun {
+		return nil
+	}
+	if err := r.authorizeTokens.Delete(context.TODO(), name, metav1.DeleteOptions{}); err != nil && !kerrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package deprovisioning
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	oauthv1 "github.com/openshift/api/oauth/v1"
+
+	"github.com/openshift/oauth-server/pkg/server/session"
+	"github.com/openshift/oauth-server/pkg/tokenstorage"
+)
+
+type fakeSessionLister map[string][]session.SessionInfo
+
+func (l fakeSessionLister) ListSessions(uid string) ([]session.SessionInfo, error) {
+	return l[uid], nil
+}
+
+func (l fakeSessionLister) RemoveSession(uid, id string) (bool, error) {
+	return false, nil
+}
+
+// fakeUpstreamTokens counts the upstream tokens of users by UID
+type fakeUpstreamTokens map[string]int
+
+func (u fakeUpstreamTokens) DeleteUser(uid string, dryRun bool) (int, error) {
+	deleted := u[uid]
+	if !dryRun {
+		delete(u, uid)
+	}
+	return deleted, nil
+}
+
+func TestRevokeClientBackendStorage(t *testing.T) {
+	storage := tokenstorage.NewBackendStorage(session.NewMemoryBackend())
+	accessTokens := storage.OAuthAccessTokens()
+	authorizeTokens := storage.OAuthAuthorizeTokens()
+
+	// more tokens than fit on one page
+	for i := 0; i < clientListPageSize+10; i++ {
+		clientName := "console"
+		if i%2 == 1 {
+			clientName = "cli"
+		}
+		token := &oauthv1.OAuthAccessToken{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("sha256~token-%04d", i)},
+			ClientName: clientName,
+			UserName:   "bob",
+			UserUID:    "bob-uid",
+		}
+		if _, err := accessTokens.Create(context.TODO(), token, metav1.CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	code := &oauthv1.OAuthAuthorizeToken{ObjectMeta: metav1.ObjectMeta{Name: "sha256~code"}, ClientName: "console", UserName: "bob", UserUID: "bob-uid"}
+	if _, err := authorizeTokens.Create(context.TODO(), code, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	revocation, err := NewRevoker(accessTokens, authorizeTokens, nil).RevokeClient("console", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Revocation{AccessTokens: (clientListPageSize + 10) / 2, AuthorizeTokens: 1}
+	if revocation != expected {
+		t.Errorf("expected revocation %#v, got %#v", expected, revocation)
+	}
+
+	list, err := accessTokens.List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Items) != (clientListPageSize+10)/2 {
+		t.Fatalf("expected %d tokens to be left, got %d", (clientListPageSize+10)/2, len(list.Items))
+	}
+	for _, token := range list.Items {
+		if token.ClientName != "cli" {
+			t.Errorf("expected token %s of client %s to be revoked", token.Name, token.ClientName)
+		}
+	}
+}
+
+func TestRevokeCountsSessions(t *testing.T) {
+	storage := tokenstorage.NewBackendStorage(session.NewMemoryBackend())
+	lister := fakeSessionLister{"bob-uid": {{ID: "one"}}}
+	revoker := NewRevokerWithOptions(storage.OAuthAccessTokens(), storage.OAuthAuthorizeTokens(), RevokerOptions{SessionLister: lister})
+
+	for name, expected := range map[string]Revocation{"bob": {Users: 1}, "alice": {}} {
+		revocation, err := revoker.Revoke(name, name+"-uid", true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if revocation != expected {
+			t.Errorf("expected revocation %#v of %s, got %#v", expected, name, revocation)
+		}
+	}
+}
+
+func TestRevokeDeletesUpstreamTokens(t *testing.T) {
+	storage := tokenstorage.NewBackendStorage(session.NewMemoryBackend())
+	upstreamTokens := fakeUpstreamTokens{"bob-uid": 2}
+	revoker := NewRevokerWithOptions(storage.OAuthAccessTokens(), storage.OAuthAuthorizeTokens(), RevokerOptions{UpstreamTokens: upstreamTokens})
+
+	for _, dryRun := range []bool{true, false} {
+		revocation, err := revoker.Revoke("bob", "bob-uid", dryRun)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := (Revocation{Users: 1, UpstreamTokens: 2}); revocation != expected {
+			t.Errorf("expected revocation %#v with dry run %v, got %#v", expected, dryRun, revocation)
+		}
+	}
+	if len(upstreamTokens) != 0 {
+		t.Errorf("expected the upstream tokens to be deleted, got %v", upstreamTokens)
+	}
+}
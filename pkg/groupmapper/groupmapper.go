@@ -3,6 +3,7 @@ package groupmapper
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
 	"k8s.io/apimachinery/pkg/api/errors"
@@ -53,6 +54,16 @@ func (w *UserInfoGroupsWrapper) GetGroups() []string {
 	return groups.List()
 }
 
+// Options configure the synchronization of groups for an identity provider
+type Options struct {
+	// Prefix is prepended to the names of the groups of identities. Only groups with
+	// the prefix are synchronized, users are not removed from groups without it.
+	Prefix string
+	// ExtraGroups synchronizes the comma-separated groups in the extra attributes of identities
+	// if they have no provider groups, as set by e.g. LDAP, request header and basic auth providers
+	ExtraGroups bool
+}
+
 // UserGroupsMapper wraps a UserIdentityMapper with a struct that's capable to
 // create the groups for a given user based on the provided UserIdentityInfo
 type UserGroupsMapper struct {
@@ -61,15 +72,17 @@ type UserGroupsMapper struct {
 	groupsLister        userlisterv1.GroupLister
 	groupsCache         *usercache.GroupCache
 	groupsSynced        func() bool
+	options             Options
 }
 
-func NewUserGroupsMapper(delegate authapi.UserIdentityMapper, groupInformer userinformer.GroupInformer, groupsClient userclient.GroupInterface, groupsLister userlisterv1.GroupLister) *UserGroupsMapper {
+func NewUserGroupsMapper(delegate authapi.UserIdentityMapper, groupInformer userinformer.GroupInformer, groupsClient userclient.GroupInterface, groupsLister userlisterv1.GroupLister, options Options) *UserGroupsMapper {
 	return &UserGroupsMapper{
 		delegatedUserMapper: delegate,
 		groupsClient:        groupsClient,
 		groupsLister:        groupsLister,
 		groupsCache:         usercache.NewGroupCache(groupInformer),
 		groupsSynced:        groupInformer.Informer().HasSynced,
+		options:             options,
 	}
 }
 
@@ -79,8 +92,9 @@ func (m *UserGroupsMapper) UserFor(identityInfo authapi.UserIdentityInfo) (kuser
 		return userInfo, err
 	}
 
-	identityGroups := sets.NewString(identityInfo.GetProviderGroups()...)
-	if err := m.processGroups(identityInfo.GetProviderName(), identityInfo.GetProviderPreferredUserName(), identityGroups); err != nil {
+	// memberships belong to the user the identity is mapped to, which may not be named after the identity
+	identityGroups := m.identityGroups(identityInfo)
+	if err := m.processGroups(identityInfo.GetProviderName(), userInfo.GetName(), identityGroups); err != nil {
 		return nil, err
 	}
 
@@ -90,6 +104,24 @@ func (m *UserGroupsMapper) UserFor(identityInfo authapi.UserIdentityInfo) (kuser
 	}, nil
 }
 
+// identityGroups returns the prefixed names of the groups asserted for the identity
+func (m *UserGroupsMapper) identityGroups(identityInfo authapi.UserIdentityInfo) sets.String {
+	groups := identityInfo.GetProviderGroups()
+	if len(groups) == 0 && m.options.ExtraGroups {
+		if extraGroups := identityInfo.GetExtra()[authapi.IdentityGroupsKey]; len(extraGroups) > 0 {
+			groups = strings.Split(extraGroups, ",")
+		}
+	}
+
+	identityGroups := sets.NewString()
+	for _, g := range groups {
+		if len(g) > 0 {
+			identityGroups.Insert(m.options.Prefix + g)
+		}
+	}
+	return identityGroups
+}
+
 func (m *UserGroupsMapper) processGroups(idpName, username string, groups sets.String) error {
 	err := wait.PollImmediate(1*time.Second, 5*time.Second, func() (bool, error) {
 		return m.groupsSynced(), nil
@@ -103,6 +135,17 @@ func (m *UserGroupsMapper) processGroups(idpName, username string, groups sets.S
 		return err
 	}
 
+	// groups without the prefix are not managed by this mapper
+	if len(m.options.Prefix) > 0 {
+		prefixedGroups := make([]*userv1.Group, 0, len(cachedGroups))
+		for _, g := range cachedGroups {
+			if strings.HasPrefix(g.Name, m.options.Prefix) {
+				prefixedGroups = append(prefixedGroups, g)
+			}
+		}
+		cachedGroups = prefixedGroups
+	}
+
 	removeGroups, addGroups := groupsDiff(cachedGroups, groups)
 	for _, g := range removeGroups {
 		if err := m.removeUserFromGroup(idpName, username, g); err != nil {
@@ -369,6 +369,63 @@ func TestUserGroupsMapper_addUserToGroup(t *testing.T) {
 	}
 }
 
+func TestUserGroupsMapper_UserForWithOptions(t *testing.T) {
+	existingGroups := []*userv1.Group{
+		createGroupWithUsers("gh:old", "alice", "bob"),
+		createGroupWithUsers("gh:stays", "alice", "bob"),
+		createGroupWithUsers("unprefixed", "alice", "bob"),
+	}
+
+	groupObjs := []runtime.Object{}
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, g := range existingGroups {
+		groupObjs = append(groupObjs, g)
+		require.NoError(t, indexer.Add(g))
+	}
+	fakeGroupsClient := fakeuserclient.NewSimpleClientset(groupObjs...)
+
+	userInformer := userinformer.NewSharedInformerFactory(fakeGroupsClient, 5*time.Second)
+	require.NoError(t, userInformer.User().V1().Groups().Informer().AddIndexers(cache.Indexers{
+		usercache.ByUserIndexName: usercache.ByUserIndexKeys,
+	}))
+	testCtx, cancelCtx := context.WithCancel(context.Background())
+	go userInformer.Start(testCtx.Done())
+	defer cancelCtx()
+
+	m := &UserGroupsMapper{
+		// the user is not named after the identity, e.g. because of a username template
+		delegatedUserMapper: &mockUserMapper{userInfo: kuser.DefaultInfo{Name: "alice", UID: "tehUserUID"}},
+		groupsClient:        fakeGroupsClient.UserV1().Groups(),
+		groupsLister:        userlisterv1.NewGroupLister(indexer),
+		groupsCache:         usercache.NewGroupCache(userInformer.User().V1().Groups()),
+		groupsSynced:        userInformer.User().V1().Groups().Informer().HasSynced,
+		options:             Options{Prefix: "gh:", ExtraGroups: true},
+	}
+
+	identityInfo := &authapi.DefaultUserIdentityInfo{
+		ProviderName:     testIDPName,
+		ProviderUserName: "1234",
+		Extra: map[string]string{
+			authapi.IdentityPreferredUsernameKey: "al",
+			authapi.IdentityGroupsKey:            "new,stays",
+		},
+	}
+	got, err := m.UserFor(identityInfo)
+	require.NoError(t, err)
+	require.Equal(t, []string{"gh:new", "gh:stays"}, got.GetGroups())
+
+	for group, members := range map[string][]string{
+		"gh:new":     {"alice"},
+		"gh:old":     {"bob"},
+		"gh:stays":   {"alice", "bob"},
+		"unprefixed": {"alice", "bob"},
+	} {
+		g, err := fakeGroupsClient.UserV1().Groups().Get(context.Background(), group, metav1.GetOptions{})
+		require.NoError(t, err)
+		require.Equal(t, members, []string(g.Users), "members of group %s", group)
+	}
+}
+
 func createGroupWithUsers(groupname string, users ...string) *userv1.Group {
 	return &userv1.Group{
 		ObjectMeta: metav1.ObjectMeta{
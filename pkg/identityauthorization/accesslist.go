@@ -0,0 +1,119 @@
+package identityauthorization
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"k8s.io/apiserver/pkg/authentication/user"
+
+	authapi "github.com/openshift/oauth-server/pkg/api"
+)
+
+// AccessList restricts which identities may log in by their preferred username and email address.
+// Users patterns match the preferred username or the email address, email domain patterns match the
+// part of the email address after the @. Patterns are exact names or globs such as "*-admin" or
+// "*.example.com", emails and email domains are matched case-insensitively.
+//
+// Identities matching a denied pattern are rejected. If any allowed patterns are set, identities
+// must match at least one of them. Email addresses only match allowed patterns if the identity
+// provider verified them, anyone can claim an unverified address.
+type AccessList struct {
+	AllowedUsers        []string
+	DeniedUsers         []string
+	AllowedEmailDomains []string
+	DeniedEmailDomains  []string
+}
+
+// Validate returns an error if a pattern is malformed
+func (l *AccessList) Validate() error {
+	for _, patterns := range [][]string{l.AllowedUsers, l.DeniedUsers, l.AllowedEmailDomains, l.DeniedEmailDomains} {
+		for _, pattern := range patterns {
+			if len(pattern) == 0 {
+				return fmt.Errorf("empty pattern")
+			}
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid pattern %q: %v", pattern, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Check returns an AuthorizationDeniedError if the identity may not log in
+func (l *AccessList) Check(identity authapi.UserIdentityInfo) error {
+	username := identity.GetProviderPreferredUserName()
+	email := strings.ToLower(identity.GetExtra()[authapi.IdentityEmailKey])
+	var domain string
+	if i := strings.LastIndex(email, "@"); i >= 0 {
+		domain = email[i+1:]
+	}
+
+	matchesUser := func(patterns []string, email string) (string, bool) {
+		if pattern, ok := match(patterns, username, false); ok {
+			return pattern, true
+		}
+		return match(patterns, email, true)
+	}
+
+	// unverified addresses still match denied patterns, denying them is always safe
+	if pattern, ok := matchesUser(l.DeniedUsers, email); ok {
+		return authapi.NewAuthorizationDeniedError(identity, fmt.Errorf("identity %q matches denied user %q", identity.GetIdentityName(), pattern))
+	}
+	if pattern, ok := match(l.DeniedEmailDomains, domain, true); ok {
+		return authapi.NewAuthorizationDeniedError(identity, fmt.Errorf("identity %q matches denied email domain %q", identity.GetIdentityName(), pattern))
+	}
+
+	if len(l.AllowedUsers) == 0 && len(l.AllowedEmailDomains) == 0 {
+		return nil
+	}
+	verified := identity.GetExtra()[authapi.IdentityEmailVerifiedKey] == "true"
+	if !verified {
+		email, domain = "", ""
+	}
+	if _, ok := matchesUser(l.AllowedUsers, email); ok {
+		return nil
+	}
+	if _, ok := match(l.AllowedEmailDomains, domain, true); ok {
+		return nil
+	}
+	return authapi.NewAuthorizationDeniedError(identity, fmt.Errorf("identity %q with username %q and email %q (verified %v) matches no allowed user or email domain", identity.GetIdentityName(), username, identity.GetExtra()[authapi.IdentityEmailKey], verified))
+}
+
+// match returns the first pattern that matches value. Empty values never match.
+func match(patterns []string, value string, ignoreCase bool) (string, bool) {
+	if len(value) == 0 {
+		return "", false
+	}
+	for _, pattern := range patterns {
+		if ignoreCase {
+			pattern = strings.ToLower(pattern)
+		}
+		// patterns were validated, malformed patterns do not match
+		if ok, _ := path.Match(pattern, value); ok {
+			return pattern, true
+		}
+	}
+	return "", false
+}
+
+// accessListMapper checks identities against an access list before mapping them
+type accessListMapper struct {
+	delegate   authapi.UserIdentityMapper
+	accessList *AccessList
+}
+
+var _ authapi.UserIdentityMapper = &accessListMapper{}
+
+// NewAccessListMapper returns a mapper that only passes identities allowed by the access list on to delegate.
+// Users are not provisioned for rejected identities.
+func NewAccessListMapper(delegate authapi.UserIdentityMapper, accessList *AccessList) authapi.UserIdentityMapper {
+	return &accessListMapper{delegate: delegate, accessList: accessList}
+}
+
+func (m *accessListMapper) UserFor(identityInfo authapi.UserIdentityInfo) (user.Info, error) {
+	if err := m.accessList.Check(identityInfo); err != nil {
+		return nil, err
+	}
+	return m.delegate.UserFor(identityInfo)
+}
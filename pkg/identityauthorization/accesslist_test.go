@@ -0,0 +1,143 @@
+package identityauthorization
+
+import (
+	"errors"
+	"testing"
+
+	authapi "github.com/openshift/oauth-server/pkg/api"
+)
+
+func TestAccessList(t *testing.T) {
+	testcases := map[string]struct {
+		accessList   AccessList
+		username     string
+		email        string
+		unverified   bool
+		expectDenied bool
+	}{
+		"empty list allows": {
+			username: "bob",
+		},
+		"allowed user": {
+			accessList: AccessList{AllowedUsers: []string{"alice", "bob"}},
+			username:   "bob",
+		},
+		"allowed user glob": {
+			accessList: AccessList{AllowedUsers: []string{"team-*"}},
+			username:   "team-bob",
+		},
+		"allowed user by email": {
+			accessList: AccessList{AllowedUsers: []string{"Bob@Example.com"}},
+			username:   "1234",
+			email:      "bob@example.COM",
+		},
+		"not an allowed user": {
+			accessList:   AccessList{AllowedUsers: []string{"alice"}},
+			username:     "bob",
+			expectDenied: true,
+		},
+		"user names are case-sensitive": {
+			accessList:   AccessList{AllowedUsers: []string{"Bob"}},
+			username:     "bob",
+			expectDenied: true,
+		},
+		"allowed email domain": {
+			accessList: AccessList{AllowedEmailDomains: []string{"example.com"}},
+			username:   "bob",
+			email:      "bob@EXAMPLE.com",
+		},
+		"allowed email domain glob": {
+			accessList: AccessList{AllowedEmailDomains: []string{"*.example.com"}},
+			username:   "bob",
+			email:      "bob@eu.example.com",
+		},
+		"missing email is not in an allowed domain": {
+			accessList:   AccessList{AllowedEmailDomains: []string{"example.com"}},
+			username:     "bob",
+			expectDenied: true,
+		},
+		"unverified email is not an allowed user": {
+			accessList:   AccessList{AllowedUsers: []string{"bob@example.com"}},
+			username:     "1234",
+			email:        "bob@example.com",
+			unverified:   true,
+			expectDenied: true,
+		},
+		"unverified email is not in an allowed domain": {
+			accessList:   AccessList{AllowedEmailDomains: []string{"example.com"}},
+			username:     "bob",
+			email:        "bob@example.com",
+			unverified:   true,
+			expectDenied: true,
+		},
+		"unverified email is in a denied domain": {
+			accessList:   AccessList{DeniedEmailDomains: []string{"example.org"}},
+			username:     "bob",
+			email:        "bob@example.org",
+			unverified:   true,
+			expectDenied: true,
+		},
+		"allowed by either list": {
+			accessList: AccessList{AllowedUsers: []string{"contractor"}, AllowedEmailDomains: []string{"example.com"}},
+			username:   "contractor",
+			email:      "contractor@partner.org",
+		},
+		"denied user overrides allowed domain": {
+			accessList:   AccessList{DeniedUsers: []string{"*-admin"}, AllowedEmailDomains: []string{"example.com"}},
+			username:     "bob-admin",
+			email:        "bob@example.com",
+			expectDenied: true,
+		},
+		"denied email domain": {
+			accessList:   AccessList{DeniedEmailDomains: []string{"example.org"}},
+			username:     "bob",
+			email:        "bob@example.org",
+			expectDenied: true,
+		},
+		"other email domain is not denied": {
+			accessList: AccessList{DeniedEmailDomains: []string{"example.org"}},
+			username:   "bob",
+			email:      "bob@example.com",
+		},
+	}
+
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			if err := tc.accessList.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			identity := authapi.NewDefaultUserIdentityInfo("idp", "1234")
+			identity.Extra[authapi.IdentityPreferredUsernameKey] = tc.username
+			if len(tc.email) > 0 {
+				identity.Extra[authapi.IdentityEmailKey] = tc.email
+				if !tc.unverified {
+					identity.Extra[authapi.IdentityEmailVerifiedKey] = "true"
+				}
+			}
+
+			delegate := &testMapper{}
+			_, err := NewAccessListMapper(delegate, &tc.accessList).UserFor(identity)
+			if !tc.expectDenied {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if !delegate.called {
+					t.Error("expected allowed identity to be mapped")
+				}
+				return
+			}
+
+			var denied authapi.AuthorizationDeniedError
+			if !errors.As(err, &denied) {
+				t.Fatalf("expected denied error, got %v", err)
+			}
+			if delegate.called {
+				t.Error("expected denied identity not to be mapped")
+			}
+		})
+	}
+
+	if err := (&AccessList{AllowedUsers: []string{"[a-"}}).Validate(); err == nil {
+		t.Error("expected invalid pattern to be rejected")
+	}
+}
@@ -0,0 +1,184 @@
+// Package identityauthorization allows or denies logins. Access lists restrict the
+// identities of a provider by username and email before they are mapped to users.
+// An external webhook can allow or deny logins, and add extra attributes to the user,
+// after an identity was mapped to a user and before the login succeeds.
+package identityauthorization
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/klog/v2"
+
+	authapi "github.com/openshift/oauth-server/pkg/api"
+)
+
+// ReviewKind is the kind of the requests sent to the webhook
+const ReviewKind = "IdentityAuthorizationReview"
+
+// DefaultTimeout is used if no timeout is configured for the webhook
+const DefaultTimeout = 10 * time.Second
+
+// maxResponseSize limits the size of webhook responses that are read
+const maxResponseSize = 1 << 20
+
+// Review is POSTed as JSON to the webhook for every login
+type Review struct {
+	Kind     string         `json:"kind"`
+	Identity ReviewIdentity `json:"identity"`
+	User     ReviewUser     `json:"user"`
+}
+
+// ReviewIdentity describes the identity asserted by the identity provider
+type ReviewIdentity struct {
+	ProviderName     string            `json:"providerName"`
+	ProviderUserName string            `json:"providerUserName"`
+	ProviderGroups   []string          `json:"providerGroups,omitempty"`
+	Extra            map[string]string `json:"extra,omitempty"`
+}
+
+// ReviewUser describes the user the identity is mapped to
+type ReviewUser struct {
+	Name   string              `json:"name"`
+	UID    string              `json:"uid,omitempty"`
+	Groups []string            `json:"groups,omitempty"`
+	Extra  map[string][]string `json:"extra,omitempty"`
+}
+
+// ReviewResponse is the JSON response of the webhook
+type ReviewResponse struct {
+	// Allowed must be set for the login to succeed
+	Allowed bool `json:"allowed"`
+	// Reason is shown to the user if the login is denied
+	Reason string `json:"reason,omitempty"`
+	// Extra is added to the extra attributes of the user if the login is allowed,
+	// replacing existing values with the same key
+	Extra map[string][]string `json:"extra,omitempty"`
+}
+
+// Webhook sends reviews to a remote endpoint
+type Webhook struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhook returns a webhook that POSTs reviews to url.
+// If no transport is provided, http.DefaultTransport is used. A timeout of 0 uses DefaultTimeout.
+func NewWebhook(url string, transport http.RoundTripper, timeout time.Duration) *Webhook {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	client := &http.Client{Transport: transport, Timeout: timeout}
+	// like the basic auth provider, do not follow redirects to other sites with user data
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return errors.New("Redirect attempted")
+	}
+	return &Webhook{url: url, client: client}
+}
+
+// Review sends a review of the identity and user to the webhook and returns its response
+func (w *Webhook) Review(identity authapi.UserIdentityInfo, userInfo user.Info) (*ReviewResponse, error) {
+	review := Review{
+		Kind: ReviewKind,
+		Identity: ReviewIdentity{
+			ProviderName:     identity.GetProviderName(),
+			ProviderUserName: identity.GetProviderUserName(),
+			ProviderGroups:   identity.GetProviderGroups(),
+			Extra:            identity.GetExtra(),
+		},
+		User: ReviewUser{
+			Name:   userInfo.GetName(),
+			UID:    userInfo.GetUID(),
+			Groups: userInfo.GetGroups(),
+			Extra:  userInfo.GetExtra(),
+		},
+	}
+	body, err := json.Marshal(review)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response status %d", resp.StatusCode)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, err
+	}
+	response := &ReviewResponse{}
+	if err := json.Unmarshal(data, response); err != nil {
+		return nil, fmt.Errorf("invalid response: %v", err)
+	}
+	return response, nil
+}
+
+// userMapper asks the webhook whether the mapped user may log in
+type userMapper struct {
+	delegate authapi.UserIdentityMapper
+	webhook  *Webhook
+}
+
+var _ authapi.UserIdentityMapper = &userMapper{}
+
+// NewUserMapper returns a mapper that reviews the users returned by delegate with the webhook.
+// Denied logins return an AuthorizationDeniedError with the reason given by the webhook,
+// webhook failures return an AuthorizationFailedError so that logins fail closed.
+func NewUserMapper(delegate authapi.UserIdentityMapper, webhook *Webhook) authapi.UserIdentityMapper {
+	return &userMapper{delegate: delegate, webhook: webhook}
+}
+
+func (m *userMapper) UserFor(identityInfo authapi.UserIdentityInfo) (user.Info, error) {
+	userInfo, err := m.delegate.UserFor(identityInfo)
+	if err != nil {
+		return userInfo, err
+	}
+
+	response, err := m.webhook.Review(identityInfo, userInfo)
+	if err != nil {
+		return nil, authapi.NewAuthorizationFailedError(identityInfo, fmt.Errorf("identity authorization webhook failed for %q: %v", identityInfo.GetIdentityName(), err))
+	}
+	if !response.Allowed {
+		return nil, authapi.NewAuthorizationDeniedErrorWithMessage(identityInfo, fmt.Errorf("identity authorization webhook denied %q: %s", identityInfo.GetIdentityName(), response.Reason), response.Reason)
+	}
+	klog.V(4).Infof("identity authorization webhook allowed %q", identityInfo.GetIdentityName())
+
+	if len(response.Extra) == 0 {
+		return userInfo, nil
+	}
+	extra := map[string][]string{}
+	for k, v := range userInfo.GetExtra() {
+		extra[k] = v
+	}
+	for k, v := range response.Extra {
+		extra[k] = v
+	}
+	return &user.DefaultInfo{
+		Name:   userInfo.GetName(),
+		UID:    userInfo.GetUID(),
+		Groups: userInfo.GetGroups(),
+		Extra:  extra,
+	}, nil
+}
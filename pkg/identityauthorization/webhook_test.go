@@ -0,0 +1,110 @@
+package identityauthorization
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"k8s.io/apiserver/pkg/authentication/user"
+
+	authapi "github.com/openshift/oauth-server/pkg/api"
+)
+
+type testMapper struct {
+	called bool
+}
+
+func (m *testMapper) UserFor(identityInfo authapi.UserIdentityInfo) (user.Info, error) {
+	m.called = true
+	return &user.DefaultInfo{Name: identityInfo.GetProviderUserName(), UID: "uid", Extra: map[string][]string{"existing": {"value"}}}, nil
+}
+
+func TestUserFor(t *testing.T) {
+	testcases := map[string]struct {
+		status       int
+		response     string
+		expectDenied string
+		expectFailed bool
+		expectExtra  map[string][]string
+	}{
+		"allowed": {
+			response:    `{"allowed": true}`,
+			expectExtra: map[string][]string{"existing": {"value"}},
+		},
+		"allowed with extra": {
+			response:    `{"allowed": true, "extra": {"risk": ["low"], "existing": ["replaced"]}}`,
+			expectExtra: map[string][]string{"existing": {"replaced"}, "risk": {"low"}},
+		},
+		"denied": {
+			response:     `{"allowed": false, "reason": "Your account is suspended"}`,
+			expectDenied: "Your account is suspended",
+		},
+		"invalid response": {
+			response:     `allowed`,
+			expectFailed: true,
+		},
+		"error status": {
+			status:       http.StatusInternalServerError,
+			response:     `{"allowed": true}`,
+			expectFailed: true,
+		},
+	}
+
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				review := Review{}
+				if err := json.NewDecoder(r.Body).Decode(&review); err != nil {
+					t.Fatal(err)
+				}
+				expected := Review{
+					Kind:     ReviewKind,
+					Identity: ReviewIdentity{ProviderName: "idp", ProviderUserName: "bob", ProviderGroups: []string{"admins"}, Extra: map[string]string{"email": "bob@example.com"}},
+					User:     ReviewUser{Name: "bob", UID: "uid", Extra: map[string][]string{"existing": {"value"}}},
+				}
+				if r.Method != http.MethodPost || !reflect.DeepEqual(review, expected) {
+					t.Errorf("unexpected review %s %#v", r.Method, review)
+				}
+				if tc.status != 0 {
+					w.WriteHeader(tc.status)
+				}
+				w.Write([]byte(tc.response))
+			}))
+			defer server.Close()
+
+			identity := authapi.NewDefaultUserIdentityInfo("idp", "bob")
+			identity.ProviderGroups = []string{"admins"}
+			identity.Extra["email"] = "bob@example.com"
+
+			delegate := &testMapper{}
+			mapper := NewUserMapper(delegate, NewWebhook(server.URL, nil, 0))
+			userInfo, err := mapper.UserFor(identity)
+			if !delegate.called {
+				t.Errorf("expected delegate to be called")
+			}
+
+			var deniedErr authapi.AuthorizationDeniedError
+			var failedErr authapi.AuthorizationFailedError
+			switch {
+			case len(tc.expectDenied) > 0:
+				if !errors.As(err, &deniedErr) || deniedErr.UserMessage() != tc.expectDenied {
+					t.Fatalf("expected denial %q, got %v", tc.expectDenied, err)
+				}
+			case tc.expectFailed:
+				if !errors.As(err, &failedErr) {
+					t.Fatalf("expected failure, got %v", err)
+				}
+			default:
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if userInfo.GetName() != "bob" || !reflect.DeepEqual(userInfo.GetExtra(), tc.expectExtra) {
+					t.Errorf("unexpected user %#v", userInfo)
+				}
+			}
+		})
+	}
+}
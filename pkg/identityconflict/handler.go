@@ -0,0 +1,124 @@
+// Package identityconflict lets administrators resolve identities that cannot log in because the user they map to
+// belongs to other identities, by re-linking the user to the identity or by merging the identity into the user.
+package identityconflict
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	kerrs "k8s.io/apimachinery/pkg/api/errors"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	"k8s.io/apiserver/pkg/endpoints/request"
+
+	userapi "github.com/openshift/api/user/v1"
+	userclient "github.com/openshift/client-go/user/clientset/versioned/typed/user/v1"
+
+	"github.com/openshift/oauth-server/pkg/logging"
+)
+
+// Handler serves the links between an identity and a user and changes them
+type Handler struct {
+	resolver   *Resolver
+	authorizer authorizer.Authorizer
+}
+
+// NewHandler returns a Handler that updates the given identities and users. Callers must be allowed by authz to
+// get, or for changes to update, the users and identities involved, e.g. with a SubjectAccessReview.
+func NewHandler(identities userclient.IdentityInterface, users userclient.UserInterface, authz authorizer.Authorizer) *Handler {
+	return &Handler{resolver: NewResolver(identities, users), authorizer: authz}
+}
+
+// ServeHTTP returns the links between the identity and the user parameters, e.g. ?identity=idp:bob&user=bob, on GET
+// and links them on PUT. PUT requires the resolution parameter, relink or merge.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	identity, user := req.URL.Query().Get("identity"), req.URL.Query().Get("user")
+	if len(identity) == 0 || len(user) == 0 {
+		http.Error(w, "the identity and user parameters are required", http.StatusBadRequest)
+		return
+	}
+
+	var mapping *Mapping
+	var err error
+	switch req.Method {
+	case http.MethodGet:
+		if !h.authorize(w, req, "get", []string{user}, identity) {
+			return
+		}
+		mapping, err = h.resolver.Describe(req.Context(), identity, user)
+	case http.MethodPut:
+		resolution := Resolution(req.URL.Query().Get("resolution"))
+		switch resolution {
+		case Relink, Merge:
+		default:
+			http.Error(w, fmt.Sprintf("the resolution parameter must be %s or %s", Relink, Merge), http.StatusBadRequest)
+			return
+		}
+		if !h.authorize(w, req, "update", []string{user}, identity) {
+			return
+		}
+		// the user the identity references now loses it, the caller must be allowed to update it as well
+		var current *Mapping
+		if current, err = h.resolver.Describe(req.Context(), identity, user); err != nil {
+			break
+		}
+		if previous := current.IdentityUser; len(previous) > 0 && previous != user && !h.authorize(w, req, "update", []string{previous}, identity) {
+			return
+		}
+		mapping, err = h.resolver.Resolve(req.Context(), identity, user, resolution)
+		if err == nil {
+			admin := ""
+			if info, ok := request.UserFrom(req.Context()); ok {
+				admin = info.GetName()
+			}
+			logging.FromRequest(req).Info(0, "Resolved identity conflict", "identity", identity, "user", user, "resolution", resolution, "admin", admin)
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		if status, ok := err.(kerrs.APIStatus); ok {
+			http.Error(w, err.Error(), int(status.Status().Code))
+			return
+		}
+		logging.FromRequest(req).Error(err, "Error resolving identity conflict", "identity", identity, "user", user)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(mapping); err != nil {
+		utilruntime.HandleError(fmt.Errorf("unable to write identity mapping: %v", err))
+	}
+}
+
+// authorize checks that the caller may use the verb on the users and the identity and responds with 403 otherwise
+func (h *Handler) authorize(w http.ResponseWriter, req *http.Request, verb string, users []string, identity string) bool {
+	caller, ok := request.UserFrom(req.Context())
+	if !ok || h.authorizer == nil {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return false
+	}
+	resources := []authorizer.AttributesRecord{{Resource: "identities", Name: identity}}
+	for _, name := range users {
+		resources = append(resources, authorizer.AttributesRecord{Resource: "users", Name: name})
+	}
+	for _, attributes := range resources {
+		attributes.User = caller
+		attributes.Verb = verb
+		attributes.APIGroup = userapi.GroupName
+		attributes.APIVersion = "v1"
+		attributes.ResourceRequest = true
+		decision, reason, err := h.authorizer.Authorize(req.Context(), attributes)
+		if err != nil {
+			logging.FromRequest(req).Error(err, "Error authorizing identity conflict resolution", "user", caller.GetName())
+		}
+		if decision != authorizer.DecisionAllow {
+			http.Error(w, fmt.Sprintf("Forbidden: %s may not %s %s %q: %s", caller.GetName(), verb, attributes.Resource, attributes.Name, reason), http.StatusForbidden)
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,163 @@
+package identityconflict
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	"k8s.io/apiserver/pkg/endpoints/request"
+
+	userfake "github.com/openshift/client-go/user/clientset/versioned/fake"
+)
+
+// testAuthorizer allows admin everything and bob-admin to get and update bob and the identities
+type testAuthorizer struct{}
+
+func (testAuthorizer) Authorize(ctx context.Context, a authorizer.Attributes) (authorizer.Decision, string, error) {
+	if a.GetAPIGroup() != "user.openshift.io" || !a.IsResourceRequest() {
+		return authorizer.DecisionNoOpinion, "", nil
+	}
+	switch {
+	case a.GetUser().GetName() == "admin":
+		return authorizer.DecisionAllow, "", nil
+	case a.GetUser().GetName() == "bob-admin" && (a.GetResource() == "identities" || a.GetName() == "bob"):
+		return authorizer.DecisionAllow, "", nil
+	}
+	return authorizer.DecisionNoOpinion, "not allowed", nil
+}
+
+func TestHandler(t *testing.T) {
+	testCases := map[string]struct {
+		Method          string
+		URL             string
+		Caller          string
+		ExpectedStatus  int
+		ExpectedLinked  bool
+		ExpectedChanges bool
+	}{
+		"describe": {
+			Method:         http.MethodGet,
+			URL:            "/debug/identity-conflicts?identity=idp:bob&user=bob",
+			ExpectedStatus: http.StatusOK,
+		},
+		"merge": {
+			Method:          http.MethodPut,
+			URL:             "/debug/identity-conflicts?identity=idp:bob&user=bob&resolution=merge",
+			ExpectedStatus:  http.StatusOK,
+			ExpectedLinked:  true,
+			ExpectedChanges: true,
+		},
+		"relink from other user": {
+			Method:          http.MethodPut,
+			URL:             "/debug/identity-conflicts?identity=idp:carol&user=bob&resolution=relink",
+			ExpectedStatus:  http.StatusOK,
+			ExpectedLinked:  true,
+			ExpectedChanges: true,
+		},
+		"relink from forbidden user": {
+			Method:         http.MethodPut,
+			URL:            "/debug/identity-conflicts?identity=idp:carol&user=bob&resolution=relink",
+			Caller:         "bob-admin",
+			ExpectedStatus: http.StatusForbidden,
+		},
+		"merge of allowed user": {
+			Method:          http.MethodPut,
+			URL:             "/debug/identity-conflicts?identity=idp:bob&user=bob&resolution=merge",
+			Caller:          "bob-admin",
+			ExpectedStatus:  http.StatusOK,
+			ExpectedLinked:  true,
+			ExpectedChanges: true,
+		},
+		"forbidden describe": {
+			Method:         http.MethodGet,
+			URL:            "/debug/identity-conflicts?identity=idp:bob&user=bob",
+			Caller:         "carol",
+			ExpectedStatus: http.StatusForbidden,
+		},
+		"forbidden merge": {
+			Method:         http.MethodPut,
+			URL:            "/debug/identity-conflicts?identity=idp:bob&user=bob&resolution=merge",
+			Caller:         "carol",
+			ExpectedStatus: http.StatusForbidden,
+		},
+		"unauthenticated": {
+			Method:         http.MethodGet,
+			URL:            "/debug/identity-conflicts?identity=idp:bob&user=bob",
+			Caller:         "-",
+			ExpectedStatus: http.StatusForbidden,
+		},
+		"missing user": {
+			Method:         http.MethodGet,
+			URL:            "/debug/identity-conflicts?identity=idp:bob",
+			ExpectedStatus: http.StatusBadRequest,
+		},
+		"unknown resolution": {
+			Method:         http.MethodPut,
+			URL:            "/debug/identity-conflicts?identity=idp:bob&user=bob&resolution=delete",
+			ExpectedStatus: http.StatusBadRequest,
+		},
+		"unknown user": {
+			Method:         http.MethodPut,
+			URL:            "/debug/identity-conflicts?identity=idp:bob&user=alice&resolution=relink",
+			ExpectedStatus: http.StatusNotFound,
+		},
+		"invalid identity": {
+			Method:         http.MethodGet,
+			URL:            "/debug/identity-conflicts?identity=bob&user=bob",
+			ExpectedStatus: http.StatusBadRequest,
+		},
+		"method not allowed": {
+			Method:         http.MethodDelete,
+			URL:            "/debug/identity-conflicts?identity=idp:bob&user=bob",
+			ExpectedStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for k, testCase := range testCases {
+		t.Run(k, func(t *testing.T) {
+			client := userfake.NewSimpleClientset(
+				makeUser("bob-uid", "bob", "otheridp:bob"),
+				makeUser("carol-uid", "carol", "idp:carol"),
+				makeIdentity("idp", "carol", "carol-uid", "carol"),
+			)
+			handler := NewHandler(client.UserV1().Identities(), client.UserV1().Users(), testAuthorizer{})
+
+			req := httptest.NewRequest(testCase.Method, testCase.URL, nil)
+			switch testCase.Caller {
+			case "":
+				req = req.WithContext(request.WithUser(req.Context(), &user.DefaultInfo{Name: "admin"}))
+			case "-":
+			default:
+				req = req.WithContext(request.WithUser(req.Context(), &user.DefaultInfo{Name: testCase.Caller}))
+			}
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+			if resp.Code != testCase.ExpectedStatus {
+				t.Fatalf("expected status %d, got %d: %s", testCase.ExpectedStatus, resp.Code, resp.Body.String())
+			}
+			changes := 0
+			for _, action := range client.Actions() {
+				if action.GetVerb() != "get" {
+					changes++
+				}
+			}
+			if testCase.ExpectedChanges != (changes > 0) {
+				t.Errorf("expected changes=%v, got %#v", testCase.ExpectedChanges, client.Actions())
+			}
+			if resp.Code != http.StatusOK {
+				return
+			}
+			var mapping Mapping
+			if err := json.Unmarshal(resp.Body.Bytes(), &mapping); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if mapping.Linked != testCase.ExpectedLinked {
+				t.Errorf("expected linked=%v, got %#v", testCase.ExpectedLinked, mapping)
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package identityconflict
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	kerrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	userapi "github.com/openshift/api/user/v1"
+	userclient "github.com/openshift/client-go/user/clientset/versioned/typed/user/v1"
+)
+
+// Resolution is how an identity is linked to a user that has other identities
+type Resolution string
+
+const (
+	// Relink makes the identity the only identity of the user, the other identities of the user
+	// cannot log in as the user anymore
+	Relink Resolution = "relink"
+
+	// Merge adds the identity to the identities of the user, all of them log in as the user
+	Merge Resolution = "merge"
+)
+
+// Mapping describes the links between an identity and a user
+type Mapping struct {
+	Identity string `json:"identity"`
+	// IdentityExists is false for identities that were never mapped, e.g. because they could not claim a user
+	IdentityExists bool `json:"identityExists"`
+	// IdentityUser is the user that the identity references, if any
+	IdentityUser string `json:"identityUser,omitempty"`
+	User         string `json:"user"`
+	// UserIdentities are the identities that the user references
+	UserIdentities []string `json:"userIdentities"`
+	// Linked is true if the identity and the user reference each other, i.e. the identity logs in as the user
+	Linked bool `json:"linked"`
+}
+
+// Resolver links identities to users that belong to other identities
+type Resolver struct {
+	identities userclient.IdentityInterface
+	users      userclient.UserInterface
+}
+
+// NewResolver returns a Resolver that updates the given identities and users
+func NewResolver(identities userclient.IdentityInterface, users userclient.UserInterface) *Resolver {
+	return &Resolver{identities: identities, users: users}
+}
+
+// Describe returns the links between an identity and a user. The identity does not have to exist.
+func (r *Resolver) Describe(ctx context.Context, identityName, userName string) (*Mapping, error) {
+	identity, exists, err := r.getIdentity(ctx, identityName)
+	if err != nil {
+		return nil, err
+	}
+	user, err := r.users.Get(ctx, userName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return describe(identity, exists, user), nil
+}
+
+// Resolve links an identity to a user according to resolution and returns the resulting links. The identity is created if
+// it does not exist and removed from the user it referenced before.
+func (r *Resolver) Resolve(ctx context.Context, identityName, userName string, resolution Resolution) (*Mapping, error) {
+	switch resolution {
+	case Relink, Merge:
+	default:
+		return nil, fmt.Errorf("unsupported conflict resolution %q", resolution)
+	}
+
+	identity, exists, err := r.getIdentity(ctx, identityName)
+	if err != nil {
+		return nil, err
+	}
+	user, err := r.users.Get(ctx, userName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	// the previous user must not log in with the identity anymore
+	if previous := identity.User.Name; len(previous) > 0 && previous != user.Name {
+		if err := r.removeIdentity(ctx, previous, identity.Name); err != nil {
+			return nil, err
+		}
+	}
+
+	identities := sets.NewString(user.Identities...)
+	switch {
+	case resolution == Relink && (len(user.Identities) != 1 || !identities.Has(identity.Name)):
+		user.Identities = []string{identity.Name}
+		if user, err = r.users.Update(ctx, user, metav1.UpdateOptions{}); err != nil {
+			return nil, err
+		}
+	case resolution == Merge && !identities.Has(identity.Name):
+		user.Identities = append(user.Identities, identity.Name)
+		if user, err = r.users.Update(ctx, user, metav1.UpdateOptions{}); err != nil {
+			return nil, err
+		}
+	}
+
+	identityUser := corev1.ObjectReference{Name: user.Name, UID: user.UID}
+	switch {
+	case !exists:
+		identity.User = identityUser
+		if identity, err = r.identities.Create(ctx, identity, metav1.CreateOptions{}); err != nil {
+			return nil, err
+		}
+	case identity.User.Name != identityUser.Name || identity.User.UID != identityUser.UID:
+		identity.User = identityUser
+		if identity, err = r.identities.Update(ctx, identity, metav1.UpdateOptions{}); err != nil {
+			return nil, err
+		}
+	}
+
+	return describe(identity, true, user), nil
+}
+
+// getIdentity returns the identity with the given name, or an identity that is not persisted and false if it does
+// not exist
+func (r *Resolver) getIdentity(ctx context.Context, name string) (*userapi.Identity, bool, error) {
+	identity, err := r.identities.Get(ctx, name, metav1.GetOptions{})
+	if err == nil {
+		return identity, true, nil
+	}
+	if !kerrs.IsNotFound(err) {
+		return nil, false, err
+	}
+	// identity names are the name of the provider and the name of the user at the provider
+	providerName, providerUserName, ok := strings.Cut(name, ":")
+	if !ok || len(providerName) == 0 || len(providerUserName) == 0 {
+		return nil, false, kerrs.NewBadRequest(fmt.Sprintf("identity %q is not of the form <provider>:<user>", name))
+	}
+	return &userapi.Identity{
+		ObjectMeta:       metav1.ObjectMeta{Name: name},
+		ProviderName:     providerName,
+		ProviderUserName: providerUserName,
+	}, false, nil
+}
+
+// removeIdentity removes an identity from the identities of a user, users that do not exist are ignored
+func (r *Resolver) removeIdentity(ctx context.Context, userName, identityName string) error {
+	user, err := r.users.Get(ctx, userName, metav1.GetOptions{})
+	if kerrs.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !sets.NewString(user.Identities...).Has(identityName) {
+		return nil
+	}
+	identities := make([]string, 0, len(user.Identities)-1)
+	for _, identity := range user.Identities {
+		if identity != identityName {
+			identities = append(identities, identity)
+		}
+	}
+	user.Identities = identities
+	_, err = r.users.Update(ctx, user, metav1.UpdateOptions{})
+	return err
+}
+
+func describe(identity *userapi.Identity, identityExists bool, user *userapi.User) *Mapping {
+	identities := user.Identities
+	if identities == nil {
+		identities = []string{}
+	}
+	return &Mapping{
+		Identity:       identity.Name,
+		IdentityExists: identityExists,
+		IdentityUser:   identity.User.Name,
+		User:           user.Name,
+		UserIdentities: identities,
+		Linked: identity.User.Name == user.Name && identity.User.UID == user.UID &&
+			sets.NewString(user.Identities...).Has(identity.Name),
+	}
+}
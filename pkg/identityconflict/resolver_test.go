@@ -0,0 +1,160 @@
+package identityconflict
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+
+	userapi "github.com/openshift/api/user/v1"
+	userfake "github.com/openshift/client-go/user/clientset/versioned/fake"
+)
+
+func makeUser(uid, name string, identities ...string) *userapi.User {
+	return &userapi.User{ObjectMeta: metav1.ObjectMeta{Name: name, UID: types.UID(uid)}, Identities: identities}
+}
+
+func makeIdentity(providerName, providerUserName, userUID, userName string) *userapi.Identity {
+	return &userapi.Identity{
+		ObjectMeta:       metav1.ObjectMeta{Name: providerName + ":" + providerUserName},
+		ProviderName:     providerName,
+		ProviderUserName: providerUserName,
+		User:             corev1.ObjectReference{Name: userName, UID: types.UID(userUID)},
+	}
+}
+
+func TestResolve(t *testing.T) {
+	testCases := map[string]struct {
+		Existing   []runtime.Object
+		Identity   string
+		User       string
+		Resolution Resolution
+
+		ExpectedMapping    *Mapping
+		ExpectedUsers      map[string][]string
+		ExpectedIdentities map[string]string
+	}{
+		"relink claim conflict": {
+			Existing:   []runtime.Object{makeUser("bob-uid", "bob", "otheridp:bob")},
+			Identity:   "idp:bob",
+			User:       "bob",
+			Resolution: Relink,
+
+			ExpectedMapping:    &Mapping{Identity: "idp:bob", IdentityExists: true, IdentityUser: "bob", User: "bob", UserIdentities: []string{"idp:bob"}, Linked: true},
+			ExpectedUsers:      map[string][]string{"bob": {"idp:bob"}},
+			ExpectedIdentities: map[string]string{"idp:bob": "bob"},
+		},
+		"merge claim conflict": {
+			Existing:   []runtime.Object{makeUser("bob-uid", "bob", "otheridp:bob")},
+			Identity:   "idp:bob",
+			User:       "bob",
+			Resolution: Merge,
+
+			ExpectedMapping:    &Mapping{Identity: "idp:bob", IdentityExists: true, IdentityUser: "bob", User: "bob", UserIdentities: []string{"otheridp:bob", "idp:bob"}, Linked: true},
+			ExpectedUsers:      map[string][]string{"bob": {"otheridp:bob", "idp:bob"}},
+			ExpectedIdentities: map[string]string{"idp:bob": "bob"},
+		},
+		"merge identity of another user": {
+			Existing: []runtime.Object{
+				makeUser("bob-uid", "bob", "otheridp:bob"),
+				makeUser("robert-uid", "robert", "idp:bob", "idp:robert"),
+				makeIdentity("idp", "bob", "robert-uid", "robert"),
+			},
+			Identity:   "idp:bob",
+			User:       "bob",
+			Resolution: Merge,
+
+			ExpectedMapping:    &Mapping{Identity: "idp:bob", IdentityExists: true, IdentityUser: "bob", User: "bob", UserIdentities: []string{"otheridp:bob", "idp:bob"}, Linked: true},
+			ExpectedUsers:      map[string][]string{"bob": {"otheridp:bob", "idp:bob"}, "robert": {"idp:robert"}},
+			ExpectedIdentities: map[string]string{"idp:bob": "bob"},
+		},
+		"relink recreated user": {
+			Existing: []runtime.Object{
+				makeUser("new-bob-uid", "bob"),
+				makeIdentity("idp", "bob", "old-bob-uid", "bob"),
+			},
+			Identity:   "idp:bob",
+			User:       "bob",
+			Resolution: Relink,
+
+			ExpectedMapping:    &Mapping{Identity: "idp:bob", IdentityExists: true, IdentityUser: "bob", User: "bob", UserIdentities: []string{"idp:bob"}, Linked: true},
+			ExpectedUsers:      map[string][]string{"bob": {"idp:bob"}},
+			ExpectedIdentities: map[string]string{"idp:bob": "bob"},
+		},
+		"linked already": {
+			Existing: []runtime.Object{
+				makeUser("bob-uid", "bob", "idp:bob"),
+				makeIdentity("idp", "bob", "bob-uid", "bob"),
+			},
+			Identity:   "idp:bob",
+			User:       "bob",
+			Resolution: Relink,
+
+			ExpectedMapping:    &Mapping{Identity: "idp:bob", IdentityExists: true, IdentityUser: "bob", User: "bob", UserIdentities: []string{"idp:bob"}, Linked: true},
+			ExpectedUsers:      map[string][]string{"bob": {"idp:bob"}},
+			ExpectedIdentities: map[string]string{"idp:bob": "bob"},
+		},
+	}
+
+	for k, testCase := range testCases {
+		t.Run(k, func(t *testing.T) {
+			client := userfake.NewSimpleClientset(testCase.Existing...)
+			resolver := NewResolver(client.UserV1().Identities(), client.UserV1().Users())
+
+			mapping, err := resolver.Resolve(context.TODO(), testCase.Identity, testCase.User, testCase.Resolution)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(mapping, testCase.ExpectedMapping) {
+				t.Errorf("expected mapping %#v, got %#v", testCase.ExpectedMapping, mapping)
+			}
+			for name, expected := range testCase.ExpectedUsers {
+				user, err := client.UserV1().Users().Get(context.TODO(), name, metav1.GetOptions{})
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if !reflect.DeepEqual([]string(user.Identities), expected) {
+					t.Errorf("expected user %s to have identities %v, got %v", name, expected, user.Identities)
+				}
+			}
+			for name, expected := range testCase.ExpectedIdentities {
+				identity, err := client.UserV1().Identities().Get(context.TODO(), name, metav1.GetOptions{})
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				user, err := client.UserV1().Users().Get(context.TODO(), expected, metav1.GetOptions{})
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if identity.User.Name != expected || identity.User.UID != user.UID {
+					t.Errorf("expected identity %s to reference user %s, got %#v", name, expected, identity.User)
+				}
+			}
+		})
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	client := userfake.NewSimpleClientset(makeUser("bob-uid", "bob", "otheridp:bob"))
+	resolver := NewResolver(client.UserV1().Identities(), client.UserV1().Users())
+
+	mapping, err := resolver.Describe(context.TODO(), "idp:bob", "bob")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := &Mapping{Identity: "idp:bob", User: "bob", UserIdentities: []string{"otheridp:bob"}}
+	if !reflect.DeepEqual(mapping, expected) {
+		t.Errorf("expected mapping %#v, got %#v", expected, mapping)
+	}
+	if actions := client.Actions(); len(actions) != 2 {
+		t.Errorf("expected describing not to change anything, got %#v", actions)
+	}
+
+	if _, err := resolver.Describe(context.TODO(), "bob", "bob"); err == nil {
+		t.Errorf("expected error for an identity name without provider")
+	}
+}
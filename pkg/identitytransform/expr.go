@@ -0,0 +1,1196 @@
+package identitytransform
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxSourceLength limits the length of expressions
+	maxSourceLength = 4096
+	// maxDepth limits the nesting of parentheses, lists, maps, arguments, conditionals and unary operators
+	maxDepth = 32
+	// maxCost limits the work of an evaluation. Every evaluated node costs 1, and the strings and lists that
+	// operators and functions return cost their length, so that the cost also bounds the memory an evaluation uses.
+	maxCost = 1000000
+)
+
+// Program is a compiled expression of a small expression language for deriving attributes of identities. Its syntax
+// resembles the Common Expression Language, but it is neither CEL nor a subset of it, only the following is
+// supported:
+//
+//	literals      "string", 'string', 42, true, false, null, [1, 2], {"key": "value"}
+//	operators     ?: || && ! == != in + -
+//	selection     claims.email, claims["preferred_username"], list[0], map["key"]
+//	functions     size(x), has(claims.email)
+//	strings       lowerAscii() upperAscii() trim() startsWith(s) endsWith(s) contains(s)
+//	              matches(re) replace(old, new) split(sep) size()
+//	lists         map(x, expr) filter(x, expr) exists(x, expr) all(x, expr) size()
+//
+// Values are strings, bools, int64, lists ([]interface{}), maps (map[string]interface{}) and null. Expressions may
+// only refer to the variables they were compiled with and the variables of the list functions. Functions that do
+// not exist and operators applied to literals of the wrong type are compile errors, the types of variables are only
+// known during evaluation. Expressions are at most maxSourceLength bytes long, nest at most maxDepth levels deep, and
+// evaluations fail once they exceed maxCost.
+type Program struct {
+	source string
+	root   node
+}
+
+// Compile parses an expression that may refer to the given variables
+func Compile(source string, variables ...string) (*Program, error) {
+	if len(source) > maxSourceLength {
+		return nil, fmt.Errorf("invalid expression: longer than %d characters", maxSourceLength)
+	}
+	tokens, err := lex(source)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %v", source, err)
+	}
+	p := &parser{tokens: tokens, scope: variables}
+	root, err := p.parseExpr()
+	if err == nil && p.peek().kind != tokEOF {
+		err = fmt.Errorf("unexpected %q at position %d", p.peek().text, p.peek().pos)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %v", source, err)
+	}
+	return &Program{source: source, root: root}, nil
+}
+
+// Eval evaluates the expression with the given variables
+func (p *Program) Eval(vars map[string]interface{}) (interface{}, error) {
+	value, err := eval(p.root, &activation{vars: vars, budget: &budget{remaining: maxCost}})
+	if err != nil {
+		return nil, fmt.Errorf("error evaluating %q: %v", p.source, err)
+	}
+	return value, nil
+}
+
+// String returns the source of the expression
+func (p *Program) String() string {
+	return p.source
+}
+
+// activation resolves variables, macros add a scope for their iteration variable
+type activation struct {
+	vars   map[string]interface{}
+	name   string
+	value  interface{}
+	parent *activation
+	budget *budget
+}
+
+// budget is the cost that an evaluation may still spend, shared by all scopes
+type budget struct {
+	remaining int
+}
+
+// charge spends cost from the budget of the evaluation
+func (a *activation) charge(cost int) error {
+	a.budget.remaining -= cost
+	if a.budget.remaining < 0 {
+		return fmt.Errorf("expression exceeded its cost limit of %d", maxCost)
+	}
+	return nil
+}
+
+func (a *activation) lookup(name string) (interface{}, bool) {
+	for ; a != nil; a = a.parent {
+		if a.vars == nil && a.name == name {
+			return a.value, true
+		}
+		if v, ok := a.vars[name]; ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// lexer
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokInt
+	tokPunct
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+var punctuation = []string{"==", "!=", "&&", "||", ".", ",", "(", ")", "[", "]", "{", "}", "?", ":", "!", "+", "-"}
+
+func lex(src string) ([]token, error) {
+	tokens := []token{}
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '"' || c == '\'':
+			s, n, err := lexString(src[i:])
+			if err != nil {
+				return nil, fmt.Errorf("%v at position %d", err, i)
+			}
+			tokens = append(tokens, token{kind: tokString, text: s, pos: i})
+			i += n
+		case c >= '0' && c <= '9':
+			j := i
+			for j < len(src) && src[j] >= '0' && src[j] <= '9' {
+				j++
+			}
+			tokens = append(tokens, token{kind: tokInt, text: src[i:j], pos: i})
+			i = j
+		case c == '_' || (c|0x20 >= 'a' && c|0x20 <= 'z'):
+			j := i
+			for j < len(src) && (src[j] == '_' || (src[j]|0x20 >= 'a' && src[j]|0x20 <= 'z') || (src[j] >= '0' && src[j] <= '9')) {
+				j++
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: src[i:j], pos: i})
+			i = j
+		default:
+			matched := false
+			for _, p := range punctuation {
+				if strings.HasPrefix(src[i:], p) {
+					tokens = append(tokens, token{kind: tokPunct, text: p, pos: i})
+					i += len(p)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected character %q at position %d", c, i)
+			}
+		}
+	}
+	return append(tokens, token{kind: tokEOF, text: "end of expression", pos: len(src)}), nil
+}
+
+// lexString reads a quoted string and returns its value and length in the source
+func lexString(src string) (string, int, error) {
+	quote := src[0]
+	var b strings.Builder
+	for i := 1; i < len(src); i++ {
+		switch c := src[i]; c {
+		case quote:
+			return b.String(), i + 1, nil
+		case '\\':
+			i++
+			if i == len(src) {
+				return "", 0, fmt.Errorf("unterminated string")
+			}
+			switch e := src[i]; e {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case '\\', '"', '\'':
+				b.WriteByte(e)
+			default:
+				return "", 0, fmt.Errorf("invalid escape sequence \\%c", e)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated string")
+}
+
+// parser
+
+type parser struct {
+	tokens []token
+	pos    int
+	// scope holds the declared variables and the variables of the enclosing macros
+	scope []string
+	depth int
+}
+
+// enter descends a level of nesting, leave must be called once the level is parsed
+func (p *parser) enter() error {
+	p.depth++
+	if p.depth > maxDepth {
+		return fmt.Errorf("expression is nested deeper than %d levels at position %d", maxDepth, p.peek().pos)
+	}
+	return nil
+}
+
+func (p *parser) leave() {
+	p.depth--
+}
+
+func (p *parser) declared(name string) bool {
+	for _, variable := range p.scope {
+		if variable == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// accept consumes the next token if it is the given punctuation or keyword
+func (p *parser) accept(text string) bool {
+	if t := p.peek(); (t.kind == tokPunct || t.kind == tokIdent) && t.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(text string) error {
+	if !p.accept(text) {
+		return fmt.Errorf("expected %q at position %d, got %q", text, p.peek().pos, p.peek().text)
+	}
+	return nil
+}
+
+func (p *parser) parseExpr() (node, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+	cond, err := p.parseOr()
+	if err != nil || !p.accept("?") {
+		return cond, err
+	}
+	if err := checkType(cond, "condition", "bool"); err != nil {
+		return nil, err
+	}
+	ifTrue, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	ifFalse, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	return &conditionalNode{cond, ifTrue, ifFalse}, nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.accept("||") {
+		var right node
+		if right, err = p.parseAnd(); err == nil {
+			left, err = newLogicalNode(true, left, right)
+		}
+	}
+	return left, err
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseRelation()
+	for err == nil && p.accept("&&") {
+		var right node
+		if right, err = p.parseRelation(); err == nil {
+			left, err = newLogicalNode(false, left, right)
+		}
+	}
+	return left, err
+}
+
+func (p *parser) parseRelation() (node, error) {
+	left, err := p.parseAdd()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range []string{"==", "!=", "in"} {
+		if p.accept(op) {
+			right, err := p.parseAdd()
+			if err != nil {
+				return nil, err
+			}
+			return newBinaryNode(op, left, right)
+		}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAdd() (node, error) {
+	left, err := p.parseUnary()
+	for err == nil {
+		op := p.peek().text
+		if p.peek().kind != tokPunct || (op != "+" && op != "-") {
+			break
+		}
+		p.next()
+		var right node
+		if right, err = p.parseUnary(); err == nil {
+			left, err = newBinaryNode(op, left, right)
+		}
+	}
+	return left, err
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.accept("!") {
+		if err := p.enter(); err != nil {
+			return nil, err
+		}
+		defer p.leave()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if err := checkType(operand, "operand of !", "bool"); err != nil {
+			return nil, err
+		}
+		return &notNode{operand}, nil
+	}
+	if p.accept("-") {
+		if err := p.enter(); err != nil {
+			return nil, err
+		}
+		defer p.leave()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return newBinaryNode("-", &literalNode{int64(0)}, operand)
+	}
+	return p.parsePostfix()
+}
+
+func (p *parser) parsePostfix() (node, error) {
+	n, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.accept("."):
+			name := p.next()
+			if name.kind != tokIdent {
+				return nil, fmt.Errorf("expected field or method name at position %d", name.pos)
+			}
+			if !p.accept("(") {
+				if err := checkType(n, fmt.Sprintf("operand of field %q", name.text), "map"); err != nil {
+					return nil, err
+				}
+				n = &selectNode{operand: n, field: name.text}
+				continue
+			}
+			if macro, ok := macros[name.text]; ok {
+				if err := checkType(n, "operand of "+name.text, "list"); err != nil {
+					return nil, err
+				}
+				expr, variable, err := p.parseMacro(name)
+				if err != nil {
+					return nil, err
+				}
+				n = &macroNode{kind: macro, list: n, variable: variable, expr: expr}
+				continue
+			}
+			args, err := p.parseList(")")
+			if err != nil {
+				return nil, err
+			}
+			call := &callNode{function: name.text, target: n, args: args}
+			if err := call.check(); err != nil {
+				return nil, fmt.Errorf("%v at position %d", err, name.pos)
+			}
+			n = call
+		case p.accept("["):
+			index, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			switch staticType(n) {
+			case "list":
+				err = checkType(index, "list index", "int")
+			case "map":
+				err = checkType(index, "map key", "string")
+			default:
+				err = checkType(n, "indexed operand", "list", "map")
+			}
+			if err != nil {
+				return nil, err
+			}
+			n = &indexNode{operand: n, index: index}
+		default:
+			return n, nil
+		}
+	}
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokString:
+		return &literalNode{t.text}, nil
+	case tokInt:
+		i, err := strconv.ParseInt(t.text, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid integer %q at position %d", t.text, t.pos)
+		}
+		return &literalNode{i}, nil
+	case tokIdent:
+		switch t.text {
+		case "true":
+			return &literalNode{true}, nil
+		case "false":
+			return &literalNode{false}, nil
+		case "null":
+			return &literalNode{nil}, nil
+		}
+		if !p.accept("(") {
+			if !p.declared(t.text) {
+				return nil, fmt.Errorf("undeclared reference to %q at position %d", t.text, t.pos)
+			}
+			return &identNode{t.text}, nil
+		}
+		args, err := p.parseList(")")
+		if err != nil {
+			return nil, err
+		}
+		if t.text == "has" {
+			if len(args) != 1 {
+				return nil, fmt.Errorf("has requires a field selection at position %d", t.pos)
+			}
+			field, ok := args[0].(*selectNode)
+			if !ok {
+				return nil, fmt.Errorf("has requires a field selection at position %d", t.pos)
+			}
+			return &hasNode{field}, nil
+		}
+		call := &callNode{function: t.text, args: args}
+		if err := call.check(); err != nil {
+			return nil, fmt.Errorf("%v at position %d", err, t.pos)
+		}
+		return call, nil
+	case tokPunct:
+		switch t.text {
+		case "(":
+			n, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			return n, p.expect(")")
+		case "[":
+			elements, err := p.parseList("]")
+			if err != nil {
+				return nil, err
+			}
+			return &listNode{elements}, nil
+		case "{":
+			return p.parseMap()
+		}
+	}
+	return nil, fmt.Errorf("unexpected %q at position %d", t.text, t.pos)
+}
+
+// parseMacro parses the variable and expression of a list macro, the variable is only declared in the expression
+func (p *parser) parseMacro(name token) (node, string, error) {
+	variable := p.next()
+	if variable.kind != tokIdent || !p.accept(",") {
+		return nil, "", fmt.Errorf("%s requires a variable name and an expression at position %d", name.text, name.pos)
+	}
+	p.scope = append(p.scope, variable.text)
+	expr, err := p.parseExpr()
+	p.scope = p.scope[:len(p.scope)-1]
+	if err != nil {
+		return nil, "", err
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, "", err
+	}
+	if macros[name.text] != macroMap {
+		if err := checkType(expr, "predicate", "bool"); err != nil {
+			return nil, "", err
+		}
+	}
+	return expr, variable.text, nil
+}
+
+// parseList parses comma separated expressions up to the closing punctuation
+func (p *parser) parseList(closing string) ([]node, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+	nodes := []node{}
+	if p.accept(closing) {
+		return nodes, nil
+	}
+	for {
+		n, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, n)
+		if p.accept(closing) {
+			return nodes, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) parseMap() (node, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+	m := &mapNode{}
+	if p.accept("}") {
+		return m, nil
+	}
+	for {
+		key, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		value, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		m.keys, m.values = append(m.keys, key), append(m.values, value)
+		if p.accept("}") {
+			return m, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// evaluation
+
+type node interface {
+	eval(a *activation) (interface{}, error)
+}
+
+// eval evaluates a node and charges its cost
+func eval(n node, a *activation) (interface{}, error) {
+	if err := a.charge(1); err != nil {
+		return nil, err
+	}
+	return n.eval(a)
+}
+
+type literalNode struct{ value interface{} }
+
+func (n *literalNode) eval(*activation) (interface{}, error) { return n.value, nil }
+
+type identNode struct{ name string }
+
+func (n *identNode) eval(a *activation) (interface{}, error) {
+	if v, ok := a.lookup(n.name); ok {
+		return v, nil
+	}
+	return nil, fmt.Errorf("undeclared reference to %q", n.name)
+}
+
+type listNode struct{ elements []node }
+
+func (n *listNode) eval(a *activation) (interface{}, error) {
+	list := make([]interface{}, 0, len(n.elements))
+	for _, e := range n.elements {
+		v, err := eval(e, a)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+	}
+	return list, nil
+}
+
+type mapNode struct{ keys, values []node }
+
+func (n *mapNode) eval(a *activation) (interface{}, error) {
+	m := make(map[string]interface{}, len(n.keys))
+	for i := range n.keys {
+		k, err := evalString(n.keys[i], a, "map key")
+		if err != nil {
+			return nil, err
+		}
+		v, err := eval(n.values[i], a)
+		if err != nil {
+			return nil, err
+		}
+		m[k] = v
+	}
+	return m, nil
+}
+
+type selectNode struct {
+	operand node
+	field   string
+}
+
+func (n *selectNode) eval(a *activation) (interface{}, error) {
+	v, err := eval(n.operand, a)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("cannot select field %q from %s", n.field, typeName(v))
+	}
+	value, ok := m[n.field]
+	if !ok {
+		return nil, fmt.Errorf("no such key: %q", n.field)
+	}
+	return value, nil
+}
+
+type hasNode struct{ field *selectNode }
+
+func (n *hasNode) eval(a *activation) (interface{}, error) {
+	v, err := eval(n.field.operand, a)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("has() cannot test field %q of %s", n.field.field, typeName(v))
+	}
+	_, ok = m[n.field.field]
+	return ok, nil
+}
+
+type indexNode struct{ operand, index node }
+
+func (n *indexNode) eval(a *activation) (interface{}, error) {
+	v, err := eval(n.operand, a)
+	if err != nil {
+		return nil, err
+	}
+	index, err := eval(n.index, a)
+	if err != nil {
+		return nil, err
+	}
+	switch container := v.(type) {
+	case []interface{}:
+		i, ok := index.(int64)
+		if !ok {
+			return nil, fmt.Errorf("list index must be an int, got %s", typeName(index))
+		}
+		if i < 0 || i >= int64(len(container)) {
+			return nil, fmt.Errorf("index %d out of range for list of size %d", i, len(container))
+		}
+		return container[i], nil
+	case map[string]interface{}:
+		key, ok := index.(string)
+		if !ok {
+			return nil, fmt.Errorf("map key must be a string, got %s", typeName(index))
+		}
+		value, ok := container[key]
+		if !ok {
+			return nil, fmt.Errorf("no such key: %q", key)
+		}
+		return value, nil
+	default:
+		return nil, fmt.Errorf("cannot index %s", typeName(v))
+	}
+}
+
+type conditionalNode struct{ cond, ifTrue, ifFalse node }
+
+func (n *conditionalNode) eval(a *activation) (interface{}, error) {
+	cond, err := evalBool(n.cond, a, "condition")
+	if err != nil {
+		return nil, err
+	}
+	if cond {
+		return eval(n.ifTrue, a)
+	}
+	return eval(n.ifFalse, a)
+}
+
+type logicalNode struct {
+	or          bool
+	left, right node
+}
+
+func (n *logicalNode) eval(a *activation) (interface{}, error) {
+	left, err := evalBool(n.left, a, "operand")
+	if err != nil {
+		return nil, err
+	}
+	if left == n.or {
+		return left, nil
+	}
+	return evalBool(n.right, a, "operand")
+}
+
+type notNode struct{ operand node }
+
+func (n *notNode) eval(a *activation) (interface{}, error) {
+	v, err := evalBool(n.operand, a, "operand of !")
+	return !v, err
+}
+
+type binaryNode struct {
+	op          string
+	left, right node
+}
+
+func (n *binaryNode) eval(a *activation) (interface{}, error) {
+	left, err := eval(n.left, a)
+	if err != nil {
+		return nil, err
+	}
+	right, err := eval(n.right, a)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "==":
+		return reflect.DeepEqual(left, right), nil
+	case "!=":
+		return !reflect.DeepEqual(left, right), nil
+	case "in":
+		switch container := right.(type) {
+		case []interface{}:
+			for _, e := range container {
+				if reflect.DeepEqual(e, left) {
+					return true, nil
+				}
+			}
+			return false, nil
+		case map[string]interface{}:
+			key, ok := left.(string)
+			if !ok {
+				return false, nil
+			}
+			_, ok = container[key]
+			return ok, nil
+		}
+		return nil, fmt.Errorf("cannot test membership in %s", typeName(right))
+	case "+":
+		switch l := left.(type) {
+		case string:
+			if r, ok := right.(string); ok {
+				if err := a.charge(len(l) + len(r)); err != nil {
+					return nil, err
+				}
+				return l + r, nil
+			}
+		case int64:
+			if r, ok := right.(int64); ok {
+				return l + r, nil
+			}
+		case []interface{}:
+			if r, ok := right.([]interface{}); ok {
+				if err := a.charge(len(l) + len(r)); err != nil {
+					return nil, err
+				}
+				return append(append([]interface{}{}, l...), r...), nil
+			}
+		}
+	case "-":
+		l, lok := left.(int64)
+		r, rok := right.(int64)
+		if lok && rok {
+			return l - r, nil
+		}
+	}
+	return nil, fmt.Errorf("no such overload: %s %s %s", typeName(left), n.op, typeName(right))
+}
+
+type callNode struct {
+	function string
+	// target is the receiver of method calls, nil for global functions
+	target node
+	args   []node
+}
+
+func (n *callNode) eval(a *activation) (interface{}, error) {
+	args := make([]interface{}, 0, len(n.args)+1)
+	if n.target != nil {
+		target, err := eval(n.target, a)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, target)
+	}
+	for _, arg := range n.args {
+		v, err := eval(arg, a)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, v)
+	}
+
+	if n.function == "size" && len(args) == 1 {
+		switch v := args[0].(type) {
+		case string:
+			return int64(len([]rune(v))), nil
+		case []interface{}:
+			return int64(len(v)), nil
+		case map[string]interface{}:
+			return int64(len(v)), nil
+		}
+		return nil, fmt.Errorf("no such overload: size(%s)", typeName(args[0]))
+	}
+
+	if n.target != nil {
+		strs := make([]string, 0, len(args))
+		for _, arg := range args {
+			s, ok := arg.(string)
+			if !ok {
+				break
+			}
+			strs = append(strs, s)
+		}
+		if len(strs) == len(args) {
+			// the cost is charged before the call, so that calls over the limit do not do their work
+			if _, ok := stringMethods[n.function]; ok {
+				if err := a.charge(stringFunctionCost(n.function, strs[0], strs[1:])); err != nil {
+					return nil, err
+				}
+			}
+			if v, ok, err := stringFunction(n.function, strs[0], strs[1:]); ok {
+				return v, err
+			}
+		}
+	}
+
+	types := make([]string, 0, len(args))
+	for _, arg := range args {
+		types = append(types, typeName(arg))
+	}
+	return nil, fmt.Errorf("no such overload: %s(%s)", n.function, strings.Join(types, ", "))
+}
+
+// stringMethods are the methods of strings by their number of arguments
+var stringMethods = map[string]int{
+	"lowerAscii": 0,
+	"upperAscii": 0,
+	"trim":       0,
+	"startsWith": 1,
+	"endsWith":   1,
+	"contains":   1,
+	"matches":    1,
+	"replace":    2,
+	"split":      1,
+}
+
+// stringMethodTypes are the types of the results of the string methods
+var stringMethodTypes = map[string]string{
+	"lowerAscii": "string",
+	"upperAscii": "string",
+	"trim":       "string",
+	"startsWith": "bool",
+	"endsWith":   "bool",
+	"contains":   "bool",
+	"matches":    "bool",
+	"replace":    "string",
+	"split":      "list",
+}
+
+// check returns an error if the function does not exist for the number of arguments, or the types of literal
+// arguments
+func (n *callNode) check() error {
+	if n.function == "size" {
+		if (n.target == nil && len(n.args) == 1) || (n.target != nil && len(n.args) == 0) {
+			operand := n.target
+			if operand == nil {
+				operand = n.args[0]
+			}
+			return checkType(operand, "operand of size", "string", "list", "map")
+		}
+		return fmt.Errorf("size requires a single operand")
+	}
+	arity, ok := stringMethods[n.function]
+	if !ok || n.target == nil {
+		return fmt.Errorf("undeclared function %q", n.function)
+	}
+	if len(n.args) != arity {
+		return fmt.Errorf("%s requires %d arguments, got %d", n.function, arity, len(n.args))
+	}
+	if err := checkType(n.target, "operand of "+n.function, "string"); err != nil {
+		return err
+	}
+	for _, arg := range n.args {
+		if err := checkType(arg, "argument of "+n.function, "string"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stringFunction calls a method on a string, ok is false if there is no method for these arguments
+func stringFunction(name, s string, args []string) (interface{}, bool, error) {
+	switch {
+	case name == "lowerAscii" && len(args) == 0:
+		return strings.ToLower(s), true, nil
+	case name == "upperAscii" && len(args) == 0:
+		return strings.ToUpper(s), true, nil
+	case name == "trim" && len(args) == 0:
+		return strings.TrimSpace(s), true, nil
+	case name == "startsWith" && len(args) == 1:
+		return strings.HasPrefix(s, args[0]), true, nil
+	case name == "endsWith" && len(args) == 1:
+		return strings.HasSuffix(s, args[0]), true, nil
+	case name == "contains" && len(args) == 1:
+		return strings.Contains(s, args[0]), true, nil
+	case name == "matches" && len(args) == 1:
+		re, err := regexp.Compile(args[0])
+		if err != nil {
+			return nil, true, err
+		}
+		return re.MatchString(s), true, nil
+	case name == "replace" && len(args) == 2:
+		return strings.ReplaceAll(s, args[0], args[1]), true, nil
+	case name == "split" && len(args) == 1:
+		parts := strings.Split(s, args[0])
+		list := make([]interface{}, 0, len(parts))
+		for _, part := range parts {
+			list = append(list, part)
+		}
+		return list, true, nil
+	}
+	return nil, false, nil
+}
+
+type macroKind int
+
+const (
+	macroMap macroKind = iota
+	macroFilter
+	macroExists
+	macroAll
+)
+
+var macros = map[string]macroKind{
+	"map":    macroMap,
+	"filter": macroFilter,
+	"exists": macroExists,
+	"all":    macroAll,
+}
+
+type macroNode struct {
+	kind     macroKind
+	list     node
+	variable string
+	expr     node
+}
+
+func (n *macroNode) eval(a *activation) (interface{}, error) {
+	v, err := eval(n.list, a)
+	if err != nil {
+		return nil, err
+	}
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("cannot iterate over %s", typeName(v))
+	}
+
+	result := []interface{}{}
+	for _, element := range list {
+		scope := &activation{name: n.variable, value: element, parent: a, budget: a.budget}
+		if n.kind == macroMap {
+			mapped, err := eval(n.expr, scope)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, mapped)
+			continue
+		}
+		matches, err := evalBool(n.expr, scope, "predicate")
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case n.kind == macroFilter && matches:
+			result = append(result, element)
+		case n.kind == macroExists && matches:
+			return true, nil
+		case n.kind == macroAll && !matches:
+			return false, nil
+		}
+	}
+	switch n.kind {
+	case macroExists:
+		return false, nil
+	case macroAll:
+		return true, nil
+	}
+	return result, nil
+}
+
+// staticType returns the type of the values of a node if it is known without evaluating it, and an empty string if
+// it depends on variables
+func staticType(n node) string {
+	switch n := n.(type) {
+	case *literalNode:
+		return typeName(n.value)
+	case *listNode:
+		return "list"
+	case *mapNode:
+		return "map"
+	case *hasNode, *notNode, *logicalNode:
+		return "bool"
+	case *binaryNode:
+		switch n.op {
+		case "==", "!=", "in":
+			return "bool"
+		case "-":
+			return "int"
+		}
+		if left := staticType(n.left); left == staticType(n.right) {
+			return left
+		}
+	case *conditionalNode:
+		if ifTrue := staticType(n.ifTrue); ifTrue == staticType(n.ifFalse) {
+			return ifTrue
+		}
+	case *callNode:
+		if n.function == "size" {
+			return "int"
+		}
+		return stringMethodTypes[n.function]
+	case *macroNode:
+		if n.kind == macroMap || n.kind == macroFilter {
+			return "list"
+		}
+		return "bool"
+	}
+	return ""
+}
+
+// checkType returns an error if the type of the node is known and not one of the types
+func checkType(n node, what string, types ...string) error {
+	actual := staticType(n)
+	if len(actual) == 0 {
+		return nil
+	}
+	for _, t := range types {
+		if actual == t {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s must be %s, got %s", what, strings.Join(types, " or "), actual)
+}
+
+func newLogicalNode(or bool, left, right node) (node, error) {
+	if err := checkType(left, "operand", "bool"); err != nil {
+		return nil, err
+	}
+	if err := checkType(right, "operand", "bool"); err != nil {
+		return nil, err
+	}
+	return &logicalNode{or: or, left: left, right: right}, nil
+}
+
+func newBinaryNode(op string, left, right node) (node, error) {
+	leftType, rightType := staticType(left), staticType(right)
+	var err error
+	switch op {
+	case "in":
+		err = checkType(right, "operand of in", "list", "map")
+	case "-":
+		if err = checkType(left, "operand of -", "int"); err == nil {
+			err = checkType(right, "operand of -", "int")
+		}
+	case "+":
+		if err = checkType(left, "operand of +", "string", "int", "list"); err == nil {
+			err = checkType(right, "operand of +", "string", "int", "list")
+		}
+		if err == nil && len(leftType) > 0 && len(rightType) > 0 && leftType != rightType {
+			err = fmt.Errorf("no such overload: %s + %s", leftType, rightType)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &binaryNode{op: op, left: left, right: right}, nil
+}
+
+// stringFunctionCost returns the cost of calling a method on a string, which bounds both the work of the call and
+// the size of its result
+func stringFunctionCost(name, s string, args []string) int {
+	cost := len(s) + 1
+	switch {
+	case name == "matches" && len(args) == 1:
+		// compiling the pattern is linear in its length, matching in the lengths of the pattern and the string
+		cost += len(args[0]) * (len(s) + 1)
+	case name == "replace" && len(args) == 2:
+		matches := len(s) + 1
+		if len(args[0]) > 0 {
+			matches = strings.Count(s, args[0])
+		}
+		cost += matches * len(args[1])
+	default:
+		for _, arg := range args {
+			cost += len(arg)
+		}
+	}
+	return cost
+}
+
+func evalBool(n node, a *activation, what string) (bool, error) {
+	v, err := eval(n, a)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("%s must be a bool, got %s", what, typeName(v))
+	}
+	return b, nil
+}
+
+func evalString(n node, a *activation, what string) (string, error) {
+	v, err := eval(n, a)
+	if err != nil {
+		return "", err
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("%s must be a string, got %s", what, typeName(v))
+	}
+	return s, nil
+}
+
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "bool"
+	case int64:
+		return "int"
+	case []interface{}:
+		return "list"
+	case map[string]interface{}:
+		return "map"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
@@ -0,0 +1,181 @@
+package identitytransform
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEval(t *testing.T) {
+	vars := map[string]interface{}{
+		"claims": map[string]interface{}{
+			"upn":    "Bob.Smith@Example.COM",
+			"roles":  []interface{}{"ocp-admin", "ocp-dev", "mail"},
+			"groups": []interface{}{},
+		},
+	}
+
+	testcases := []struct {
+		expr     string
+		expected interface{}
+		err      string
+	}{
+		{expr: `claims.upn.lowerAscii()`, expected: "bob.smith@example.com"},
+		{expr: `claims.upn.split("@")[0]`, expected: "Bob.Smith"},
+		{expr: `claims["upn"].endsWith("@Example.COM") ? "corp" : 'guest'`, expected: "corp"},
+		{expr: `claims.roles.filter(r, r.startsWith("ocp-")).map(r, r.replace("ocp-", ""))`, expected: []interface{}{"admin", "dev"}},
+		{expr: `"ocp-admin" in claims.roles ? ["cluster-admins"] : []`, expected: []interface{}{"cluster-admins"}},
+		{expr: `claims.roles.exists(r, r == "mail") && !claims.roles.all(r, r.matches("^ocp-"))`, expected: true},
+		{expr: `has(claims.email) ? claims.email : claims.upn.lowerAscii()`, expected: "bob.smith@example.com"},
+		{expr: `{"ocp-admin": "admins"}[claims.roles[0]]`, expected: "admins"},
+		{expr: `size(claims.roles) + claims.groups.size() - 1`, expected: int64(2)},
+		{expr: `claims.roles + ["extra"]`, expected: []interface{}{"ocp-admin", "ocp-dev", "mail", "extra"}},
+		{expr: `"upn" in claims && claims.upn != ""`, expected: true},
+		{expr: `claims.email`, err: "no such key"},
+		{expr: `claims.upn + 1`, err: "no such overload"},
+		{expr: `claims.upn ? "a" : "b"`, err: "must be a bool"},
+		{expr: `claims.roles[5]`, err: "out of range"},
+		{expr: `claims.roles.startsWith("ocp-")`, err: "no such overload"},
+		{expr: `claims.upn.map(c, c)`, err: "cannot iterate"},
+		{expr: `claims.roles.filter(r, r)`, err: "must be a bool"},
+		{expr: `claims.upn[0]`, err: "cannot index"},
+	}
+
+	for _, tc := range testcases {
+		program, err := Compile(tc.expr, "claims")
+		if err != nil {
+			t.Errorf("%s: unexpected compile error: %v", tc.expr, err)
+			continue
+		}
+		value, err := program.Eval(vars)
+		if len(tc.err) > 0 {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s: expected error containing %q, got %v", tc.expr, tc.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.expr, err)
+			continue
+		}
+		if !reflect.DeepEqual(value, tc.expected) {
+			t.Errorf("%s: expected %#v, got %#v", tc.expr, tc.expected, value)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for expr, expected := range map[string]string{
+		// malformed
+		``:                         "unexpected",
+		`claims.`:                  "expected field or method name",
+		`"unterminated`:            "unterminated string",
+		`'\x'`:                     "invalid escape sequence",
+		`claims ? claims`:          `expected ":"`,
+		`[1, 2`:                    `expected ","`,
+		`claims.roles.map(1, x)`:   "requires a variable name",
+		`claims.roles.map(x)`:      "requires a variable name",
+		`has(claims)`:              "requires a field selection",
+		`claims # claims`:          "unexpected character",
+		`claims claims`:            "unexpected",
+		`99999999999999999999`:     "invalid integer",
+		`{"a": 1`:                  `expected ","`,
+		`claims.roles[0`:           `expected "]"`,
+		`claims.upn.split("@"`:     `expected ","`,
+		`claims.upn.lowerAscii(`:   "unexpected",
+		`claims.upn.replace("a")`:  "requires 2 arguments",
+		`size()`:                   "single operand",
+		`claims.roles.size(1)`:     "single operand",
+		`claims.upn.matches()`:     "requires 1 arguments",
+		`claims.roles.map(r, r)[`:  "unexpected",
+		`true ? claims : claims :`: "unexpected",
+		// declared environment
+		`unknown`:                      "undeclared reference",
+		`claims.roles.map(r, r) + [r]`: "undeclared reference",
+		`claims.upn.toLower()`:         "undeclared function",
+		`lowerAscii(claims.upn)`:       "undeclared function",
+		`exists(claims.roles, "a")`:    "undeclared function",
+		// types of literals
+		`"a" + 1`:                             "no such overload",
+		`[1] + "a"`:                           "no such overload",
+		`true + true`:                         "operand of + must be",
+		`"a" - 1`:                             "operand of - must be int",
+		`-"a"`:                                "operand of - must be int",
+		`!"a"`:                                "operand of ! must be bool",
+		`1 ? "a" : "b"`:                       "condition must be bool",
+		`claims.upn == "a" && "b"`:            "operand must be bool",
+		`"a" || claims.ok`:                    "operand must be bool",
+		`"a" in "abc"`:                        "operand of in must be list or map",
+		`"a".startsWith(1)`:                   "argument of startsWith must be string",
+		`[1].lowerAscii()`:                    "operand of lowerAscii must be string",
+		`size(1)`:                             "operand of size must be",
+		`1.field`:                             `operand of field "field" must be map`,
+		`"abc"[0]`:                            "indexed operand must be list or map",
+		`[1, 2]["a"]`:                         "list index must be int",
+		`{"a": 1}[0]`:                         "map key must be string",
+		`"abc".map(c, c)`:                     "operand of map must be list",
+		`claims.roles.all(r, r.lowerAscii())`: "predicate must be bool",
+		`claims.roles.exists(r, r.size())`:    "predicate must be bool",
+		`claims.upn.contains("a").trim()`:     "operand of trim must be string",
+		// limits
+		strings.Repeat("(", maxDepth) + "claims" + strings.Repeat(")", maxDepth): "nested deeper",
+		strings.Repeat("[", maxDepth) + strings.Repeat("]", maxDepth):            "nested deeper",
+		strings.Repeat("!", maxDepth) + "true":                                   "nested deeper",
+		strings.Repeat("-", maxDepth) + "1":                                      "nested deeper",
+		strings.Repeat("claims.upn + ", maxSourceLength/12) + "claims.upn":       "longer than",
+	} {
+		if _, err := Compile(expr, "claims"); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("%s: expected compile error containing %q, got %v", expr, expected, err)
+		}
+	}
+}
+
+func TestNesting(t *testing.T) {
+	vars := map[string]interface{}{"claims": map[string]interface{}{"upn": "bob"}}
+	// the expression itself is the first level
+	for expr, expected := range map[string]interface{}{
+		strings.Repeat("(", maxDepth-1) + "claims.upn" + strings.Repeat(")", maxDepth-1): "bob",
+		strings.Repeat("!", maxDepth-1) + "true":                                         false,
+		strings.Repeat("-", maxDepth-1) + "1":                                            int64(-1),
+	} {
+		program, err := Compile(expr, "claims")
+		if err != nil {
+			t.Errorf("%s: expected expressions at the nesting limit to compile: %v", expr, err)
+			continue
+		}
+		if value, err := program.Eval(vars); err != nil || value != expected {
+			t.Errorf("%s: expected %v, got %v %v", expr, expected, value, err)
+		}
+	}
+}
+
+func TestCostLimit(t *testing.T) {
+	many := make([]interface{}, 1000)
+	for i := range many {
+		many[i] = "element"
+	}
+	vars := map[string]interface{}{"claims": map[string]interface{}{"many": many, "upn": "bob"}}
+
+	for expr, exceeds := range map[string]bool{
+		`claims.many.map(a, claims.many.size()).size()`: false,
+		// a million iterations
+		`claims.many.map(a, claims.many.map(b, b)).size()`: true,
+		// the result doubles in size with every level
+		`claims.many.map(a, claims.many).map(b, b + b).map(c, c + c).size()`:            true,
+		`[claims.upn + claims.upn].map(a, a + a).map(b, b + b).map(c, c + c)[0].size()`: false,
+		// calls are charged before they do their work, by the lengths of their operands
+		`"` + strings.Repeat("x", 2000) + `".replace("", "` + strings.Repeat("y", 1000) + `").size()`: true,
+		`"` + strings.Repeat("x", 2000) + `".replace("x", "` + strings.Repeat("y", 100) + `").size()`: false,
+		`"` + strings.Repeat("x", 2000) + `".matches("` + strings.Repeat("x?", 300) + `")`:            true,
+		`claims.upn.matches("` + strings.Repeat("x?", 300) + `")`:                                     false,
+	} {
+		program, err := Compile(expr, "claims")
+		if err != nil {
+			t.Fatalf("%s: unexpected compile error %v", expr, err)
+		}
+		_, err = program.Eval(vars)
+		if exceeds != (err != nil && strings.Contains(err.Error(), "cost limit")) {
+			t.Errorf("%s: expected exceeding the cost limit %v, got %v", expr, exceeds, err)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+// Package identitytransform derives the username, display name, email and groups
+// of identities from the attributes asserted by the identity provider using expressions.
+package identitytransform
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apiserver/pkg/authentication/user"
+
+	authapi "github.com/openshift/oauth-server/pkg/api"
+)
+
+// Rules hold the expressions of a transformation. Rules that are not set leave the identity unchanged.
+//
+// Expressions can use the variables
+//
+//	identity  map with providerName and providerUserName
+//	claims    map with the extra attributes of the identity, e.g. email or preferred_username,
+//	          sub for the provider user name and groups for the list of provider groups
+type Rules struct {
+	// Username must return a non-empty string, it is used as preferred username
+	Username *Program
+	// DisplayName must return a string, an empty string removes the display name
+	DisplayName *Program
+	// Email must return a string, an empty string removes the email address
+	Email *Program
+	// Groups must return a list of strings, the provider groups of the identity
+	Groups *Program
+}
+
+// NewRules compiles the given expressions, empty expressions are not evaluated
+func NewRules(username, displayName, email, groups string) (*Rules, error) {
+	rules := &Rules{}
+	for _, rule := range []struct {
+		source  string
+		program **Program
+	}{
+		{username, &rules.Username},
+		{displayName, &rules.DisplayName},
+		{email, &rules.Email},
+		{groups, &rules.Groups},
+	} {
+		if len(rule.source) == 0 {
+			continue
+		}
+		program, err := Compile(rule.source, "identity", "claims")
+		if err != nil {
+			return nil, err
+		}
+		*rule.program = program
+	}
+	return rules, nil
+}
+
+// Apply returns a transformed copy of the identity
+func (r *Rules) Apply(identity authapi.UserIdentityInfo) (*authapi.DefaultUserIdentityInfo, error) {
+	transformed := &authapi.DefaultUserIdentityInfo{
+		ProviderName:     identity.GetProviderName(),
+		ProviderUserName: identity.GetProviderUserName(),
+		ProviderGroups:   identity.GetProviderGroups(),
+		Extra:            map[string]string{},
+	}
+	for k, v := range identity.GetExtra() {
+		transformed.Extra[k] = v
+	}
+
+	vars := variables(identity)
+
+	if r.Username != nil {
+		username, err := evalProgramString(r.Username, vars)
+		if err != nil {
+			return nil, err
+		}
+		if len(username) == 0 {
+			return nil, fmt.Errorf("username expression %q returned an empty string", r.Username)
+		}
+		transformed.Extra[authapi.IdentityPreferredUsernameKey] = username
+	}
+	if err := setExtra(transformed.Extra, authapi.IdentityDisplayNameKey, r.DisplayName, vars); err != nil {
+		return nil, err
+	}
+	if err := setExtra(transformed.Extra, authapi.IdentityEmailKey, r.Email, vars); err != nil {
+		return nil, err
+	}
+	if r.Groups != nil {
+		groups, err := evalProgramStrings(r.Groups, vars)
+		if err != nil {
+			return nil, err
+		}
+		transformed.ProviderGroups = groups
+		delete(transformed.Extra, authapi.IdentityGroupsKey)
+		if len(groups) > 0 {
+			transformed.Extra[authapi.IdentityGroupsKey] = strings.Join(groups, ",")
+		}
+	}
+
+	return transformed, nil
+}
+
+// variables returns the variables available to expressions
+func variables(identity authapi.UserIdentityInfo) map[string]interface{} {
+	claims := map[string]interface{}{}
+	for k, v := range identity.GetExtra() {
+		claims[k] = v
+	}
+	claims["sub"] = identity.GetProviderUserName()
+
+	groups := []interface{}{}
+	if providerGroups := identity.GetProviderGroups(); len(providerGroups) > 0 {
+		for _, group := range providerGroups {
+			groups = append(groups, group)
+		}
+	} else if extraGroups := identity.GetExtra()[authapi.IdentityGroupsKey]; len(extraGroups) > 0 {
+		for _, group := range strings.Split(extraGroups, ",") {
+			groups = append(groups, group)
+		}
+	}
+	claims["groups"] = groups
+
+	return map[string]interface{}{
+		"identity": map[string]interface{}{
+			"providerName":     identity.GetProviderName(),
+			"providerUserName": identity.GetProviderUserName(),
+		},
+		"claims": claims,
+	}
+}
+
+func setExtra(extra map[string]string, key string, program *Program, vars map[string]interface{}) error {
+	if program == nil {
+		return nil
+	}
+	value, err := evalProgramString(program, vars)
+	if err != nil {
+		return err
+	}
+	if len(value) == 0 {
+		delete(extra, key)
+	} else {
+		extra[key] = value
+	}
+	return nil
+}
+
+func evalProgramString(program *Program, vars map[string]interface{}) (string, error) {
+	v, err := program.Eval(vars)
+	if err != nil {
+		return "", err
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("expression %q must return a string, got %s", program, typeName(v))
+	}
+	return s, nil
+}
+
+func evalProgramStrings(program *Program, vars map[string]interface{}) ([]string, error) {
+	v, err := program.Eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expression %q must return a list of strings, got %s", program, typeName(v))
+	}
+	strs := make([]string, 0, len(list))
+	for _, e := range list {
+		s, ok := e.(string)
+		if !ok || len(s) == 0 || strings.Contains(s, ",") {
+			return nil, fmt.Errorf("expression %q returned invalid group %v", program, e)
+		}
+		strs = append(strs, s)
+	}
+	return strs, nil
+}
+
+// userMapper transforms identities before passing them on
+type userMapper struct {
+	delegate authapi.UserIdentityMapper
+	rules    *Rules
+}
+
+var _ authapi.UserIdentityMapper = &userMapper{}
+
+// NewUserMapper returns a mapper that transforms identities with the given rules before
+// mapping them with delegate. Identities that cannot be transformed fail to log in.
+func NewUserMapper(delegate authapi.UserIdentityMapper, rules *Rules) authapi.UserIdentityMapper {
+	return &userMapper{delegate: delegate, rules: rules}
+}
+
+func (m *userMapper) UserFor(identityInfo authapi.UserIdentityInfo) (user.Info, error) {
+	transformed, err := m.rules.Apply(identityInfo)
+	if err != nil {
+		return nil, authapi.NewAuthorizationFailedError(identityInfo, fmt.Errorf("unable to transform identity %q: %v", identityInfo.GetIdentityName(), err))
+	}
+	return m.delegate.UserFor(transformed)
+}
@@ -0,0 +1,69 @@
+package identitytransform
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"k8s.io/apiserver/pkg/authentication/user"
+
+	authapi "github.com/openshift/oauth-server/pkg/api"
+)
+
+type testMapper struct {
+	identity authapi.UserIdentityInfo
+}
+
+func (m *testMapper) UserFor(identityInfo authapi.UserIdentityInfo) (user.Info, error) {
+	m.identity = identityInfo
+	return &user.DefaultInfo{Name: identityInfo.GetProviderPreferredUserName()}, nil
+}
+
+func TestUserFor(t *testing.T) {
+	rules, err := NewRules(
+		`claims.upn.lowerAscii().split("@")[0]`,
+		`has(claims.name) ? claims.name : ""`,
+		``,
+		`claims.groups.filter(g, g.startsWith("ocp-")).map(g, g.replace("ocp-", "")) + (identity.providerName == "corp" ? ["employees"] : [])`,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	identity := authapi.NewDefaultUserIdentityInfo("corp", "1234")
+	identity.ProviderGroups = []string{"ocp-admins", "mail"}
+	identity.Extra["upn"] = "Bob@Example.com"
+	identity.Extra[authapi.IdentityEmailKey] = "bob@example.com"
+
+	delegate := &testMapper{}
+	userInfo, err := NewUserMapper(delegate, rules).UserFor(identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userInfo.GetName() != "bob" {
+		t.Errorf("expected user bob, got %q", userInfo.GetName())
+	}
+	if expected := []string{"admins", "employees"}; !reflect.DeepEqual(delegate.identity.GetProviderGroups(), expected) {
+		t.Errorf("expected groups %v, got %v", expected, delegate.identity.GetProviderGroups())
+	}
+	expectedExtra := map[string]string{
+		"upn":                                "Bob@Example.com",
+		authapi.IdentityEmailKey:             "bob@example.com",
+		authapi.IdentityPreferredUsernameKey: "bob",
+		authapi.IdentityGroupsKey:            "admins,employees",
+	}
+	if !reflect.DeepEqual(delegate.identity.GetExtra(), expectedExtra) {
+		t.Errorf("expected extra %v, got %v", expectedExtra, delegate.identity.GetExtra())
+	}
+	if len(identity.Extra) != 2 || len(identity.ProviderGroups) != 2 {
+		t.Errorf("original identity was modified: %#v", identity)
+	}
+
+	// identities missing a claim used by the rules cannot log in
+	delegate = &testMapper{}
+	_, err = NewUserMapper(delegate, rules).UserFor(authapi.NewDefaultUserIdentityInfo("corp", "5678"))
+	var failedErr authapi.AuthorizationFailedError
+	if !errors.As(err, &failedErr) || delegate.identity != nil {
+		t.Errorf("expected authorization failure, got %v", err)
+	}
+}
@@ -0,0 +1,231 @@
+// Package idphealth periodically checks that the identity providers are reachable. The cached results can fail the
+// readiness checks of the server and are served as JSON, so that failing logins can be traced to their provider.
+// Diagnostics run more thorough steps on demand, e.g. checking the client credentials, to find misconfigurations.
+package idphealth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+)
+
+const (
+	// DefaultInterval is the time between checks
+	DefaultInterval = 30 * time.Second
+	// DefaultTimeout is how long a single check may take
+	DefaultTimeout = 5 * time.Second
+)
+
+// Check returns an error if an identity provider is not reachable. It should give up once ctx is done.
+type Check func(ctx context.Context) error
+
+// Result is the outcome of the last check of an identity provider
+type Result struct {
+	Name    string `json:"name"`
+	Healthy bool   `json:"healthy"`
+	// Error explains why the identity provider is unhealthy
+	Error string `json:"error,omitempty"`
+	// LastCheck is when the last check started, it is not set before the first check finished
+	LastCheck *time.Time `json:"lastCheck,omitempty"`
+	// Duration is how long the last check took
+	Duration string `json:"duration,omitempty"`
+}
+
+// Status is the outcome of the last checks of all identity providers
+type Status struct {
+	Healthy           bool     `json:"healthy"`
+	IdentityProviders []Result `json:"identityProviders"`
+}
+
+// Checker periodically runs the checks of the identity providers and caches their results
+type Checker struct {
+	interval time.Duration
+	timeout  time.Duration
+
+	lock    sync.RWMutex
+	checks  map[string]Check
+	results map[string]Result
+	// generation counts the calls of SetChecks, results of replaced checks are dropped
+	generation int
+
+	// trigger runs the checks immediately, e.g. once they were replaced
+	trigger chan struct{}
+	now     func() time.Time
+}
+
+// NewChecker returns a checker that runs every check each interval, giving up on checks after timeout
+func NewChecker(interval, timeout time.Duration) *Checker {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	return &Checker{
+		interval: interval,
+		timeout:  timeout,
+		checks:   map[string]Check{},
+		results:  map[string]Result{},
+		trigger:  make(chan struct{}, 1),
+		now:      time.Now,
+	}
+}
+
+// SetChecks replaces the checks by identity provider name, e.g. once the configuration was reloaded. The results of
+// identity providers that are still checked are kept until their next check, which happens right away.
+func (c *Checker) SetChecks(checks map[string]Check) {
+	c.lock.Lock()
+	c.generation++
+	c.checks = map[string]Check{}
+	for name, check := range checks {
+		c.checks[name] = check
+	}
+	for name := range c.results {
+		if _, ok := checks[name]; !ok {
+			delete(c.results, name)
+		}
+	}
+	c.lock.Unlock()
+
+	select {
+	case c.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Run checks the identity providers every interval until stopCh is closed
+func (c *Checker) Run(stopCh <-chan struct{}) {
+	defer utilruntime.HandleCrash()
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		c.CheckAll()
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		case <-c.trigger:
+		}
+	}
+}
+
+// CheckAll runs all checks concurrently and waits for their results
+func (c *Checker) CheckAll() {
+	c.lock.RLock()
+	generation := c.generation
+	checks := make(map[string]Check, len(c.checks))
+	for name, check := range c.checks {
+		checks[name] = check
+	}
+	c.lock.RUnlock()
+
+	wg := sync.WaitGroup{}
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check Check) {
+			defer wg.Done()
+			defer utilruntime.HandleCrash()
+			c.record(generation, name, c.run(check))
+		}(name, check)
+	}
+	wg.Wait()
+}
+
+// run runs a single check. Checks that ignore the timeout are abandoned once it passed.
+func (c *Checker) run(check Check) Result {
+	start := c.now()
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		defer utilruntime.HandleCrash()
+		done <- check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = fmt.Errorf("timed out after %v", c.timeout)
+	}
+
+	result := Result{Healthy: err == nil, LastCheck: &start, Duration: c.now().Sub(start).String()}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// record caches the result of a check, unless the checks were replaced while it ran
+func (c *Checker) record(generation int, name string, result Result) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if generation != c.generation {
+		return
+	}
+	result.Name = name
+	c.results[name] = result
+}
+
+// Status returns the cached results. Identity providers that were not checked yet are unhealthy.
+func (c *Checker) Status() Status {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	status := Status{Healthy: true, IdentityProviders: []Result{}}
+	for name := range c.checks {
+		result, ok := c.results[name]
+		if !ok {
+			result = Result{Name: name, Error: "not checked yet"}
+		}
+		status.Healthy = status.Healthy && result.Healthy
+		status.IdentityProviders = append(status.IdentityProviders, result)
+	}
+	sort.Slice(status.IdentityProviders, func(i, j int) bool {
+		return status.IdentityProviders[i].Name < status.IdentityProviders[j].Name
+	})
+	return status
+}
+
+// Name implements healthz.HealthChecker
+func (c *Checker) Name() string {
+	return "identity-providers"
+}
+
+// Check implements healthz.HealthChecker, it fails while any identity provider is unhealthy
+func (c *Checker) Check(_ *http.Request) error {
+	unhealthy := []string{}
+	for _, result := range c.Status().IdentityProviders {
+		if !result.Healthy {
+			unhealthy = append(unhealthy, fmt.Sprintf("%s: %s", result.Name, result.Error))
+		}
+	}
+	if len(unhealthy) > 0 {
+		return fmt.Errorf("identity providers are not healthy: %s", strings.Join(unhealthy, "; "))
+	}
+	return nil
+}
+
+// ServeHTTP serves the cached results as JSON
+func (c *Checker) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	data, err := json.MarshalIndent(c.Status(), "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(data)
+}
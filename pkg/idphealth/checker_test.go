@@ -0,0 +1,84 @@
+package idphealth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChecker(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	checker := NewChecker(time.Hour, 50*time.Millisecond)
+	checker.SetChecks(map[string]Check{
+		"github": func(ctx context.Context) error { return nil },
+		"ldap":   func(ctx context.Context) error { return errors.New("invalid credentials") },
+		// ignores the timeout
+		"keystone": func(ctx context.Context) error {
+			<-release
+			return nil
+		},
+	})
+
+	if err := checker.Check(nil); err == nil || !strings.Contains(err.Error(), "not checked yet") {
+		t.Errorf("expected identity providers to be unhealthy until they were checked, got %v", err)
+	}
+
+	checker.CheckAll()
+	status := checker.Status()
+	if status.Healthy || len(status.IdentityProviders) != 3 {
+		t.Fatalf("unexpected status %#v", status)
+	}
+	expected := map[string]string{"github": "", "keystone": "timed out after 50ms", "ldap": "invalid credentials"}
+	for _, result := range status.IdentityProviders {
+		if result.Error != expected[result.Name] || result.Healthy != (len(expected[result.Name]) == 0) || result.LastCheck == nil {
+			t.Errorf("unexpected result %#v", result)
+		}
+	}
+	if err := checker.Check(nil); err == nil || !strings.Contains(err.Error(), "ldap: invalid credentials") {
+		t.Errorf("expected the unhealthy identity providers in the error, got %v", err)
+	}
+
+	resp := httptest.NewRecorder()
+	checker.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/debug/identity-providers", nil))
+	served := Status{}
+	if err := json.Unmarshal(resp.Body.Bytes(), &served); err != nil || resp.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("unexpected response %v: %s", err, resp.Body.String())
+	}
+	if served.Healthy || len(served.IdentityProviders) != 3 || served.IdentityProviders[0].Name != "github" {
+		t.Errorf("unexpected served status %#v", served)
+	}
+
+	// results of removed identity providers are dropped, the others are kept until they are checked again
+	checker.SetChecks(map[string]Check{"github": func(ctx context.Context) error { return nil }})
+	if err := checker.Check(nil); err != nil {
+		t.Errorf("expected the identity providers to be healthy, got %v", err)
+	}
+}
+
+func TestHTTPCheck(t *testing.T) {
+	status := http.StatusBadRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	check := HTTPCheck(server.URL+"/token", http.DefaultTransport)
+	if err := check(context.Background()); err != nil {
+		t.Errorf("expected rejected requests to prove the endpoint is reachable, got %v", err)
+	}
+	status = http.StatusBadGateway
+	if err := check(context.Background()); err == nil {
+		t.Error("expected server errors to fail the check")
+	}
+	server.Close()
+	if err := check(context.Background()); err == nil {
+		t.Error("expected unreachable endpoints to fail the check")
+	}
+}
@@ -0,0 +1,310 @@
+package idphealth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	jose "gopkg.in/square/go-jose.v2"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+)
+
+// maxDiagnosisBody limits how much of a response is read by a step
+const maxDiagnosisBody = 1 << 20
+
+// Step is a single step of the diagnosis of an identity provider, e.g. fetching its discovery document. It returns
+// what it found, or an error explaining what is misconfigured. It should give up once ctx is done.
+type Step struct {
+	Name string
+	Run  func(ctx context.Context) (string, error)
+}
+
+// CheckStep runs a check as a step
+func CheckStep(name string, check Check) Step {
+	return Step{Name: name, Run: func(ctx context.Context) (string, error) {
+		if err := check(ctx); err != nil {
+			return "", err
+		}
+		return "ok", nil
+	}}
+}
+
+// StepResult is the outcome of a step
+type StepResult struct {
+	Name    string `json:"name"`
+	Passed  bool   `json:"passed"`
+	Message string `json:"message,omitempty"`
+	Error   string `json:"error,omitempty"`
+	// Duration is how long the step took
+	Duration string `json:"duration"`
+}
+
+// Diagnosis is the outcome of all steps of an identity provider
+type Diagnosis struct {
+	Name   string       `json:"name"`
+	Passed bool         `json:"passed"`
+	Steps  []StepResult `json:"steps"`
+}
+
+// Diagnostics runs the steps of identity providers on demand, as a dry run of their logins that finds misconfigured
+// identity providers before users fail to log in with them
+type Diagnostics struct {
+	steps   map[string][]Step
+	timeout time.Duration
+	now     func() time.Time
+}
+
+// NewDiagnostics returns diagnostics for the steps by identity provider name, giving up on steps after timeout
+func NewDiagnostics(steps map[string][]Step, timeout time.Duration) *Diagnostics {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	return &Diagnostics{steps: steps, timeout: timeout, now: time.Now}
+}
+
+// Diagnose runs the steps of the identity provider with the given name in order. Later steps run even if earlier
+// ones failed, so that a single run reports all problems. It returns false if the identity provider is unknown.
+func (d *Diagnostics) Diagnose(name string) (Diagnosis, bool) {
+	steps, ok := d.steps[name]
+	if !ok {
+		return Diagnosis{}, false
+	}
+	diagnosis := Diagnosis{Name: name, Passed: true, Steps: []StepResult{}}
+	for _, step := range steps {
+		result := d.run(step)
+		diagnosis.Passed = diagnosis.Passed && result.Passed
+		diagnosis.Steps = append(diagnosis.Steps, result)
+	}
+	return diagnosis, true
+}
+
+// run runs a single step. Steps that ignore the timeout are abandoned once it passed.
+func (d *Diagnostics) run(step Step) StepResult {
+	start := d.now()
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+
+	type outcome struct {
+		message string
+		err     error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		defer utilruntime.HandleCrash()
+		message, err := step.Run(ctx)
+		done <- outcome{message: message, err: err}
+	}()
+	var result outcome
+	select {
+	case result = <-done:
+	case <-ctx.Done():
+		result.err = fmt.Errorf("timed out after %v", d.timeout)
+	}
+
+	stepResult := StepResult{Name: step.Name, Passed: result.err == nil, Message: result.message, Duration: d.now().Sub(start).String()}
+	if result.err != nil {
+		stepResult.Error = result.err.Error()
+	}
+	return stepResult
+}
+
+// ServeHTTP diagnoses the identity provider named by the provider parameter of POST requests, or all identity
+// providers without it, and serves the diagnoses as JSON. The request fails if any step failed.
+func (d *Diagnostics) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	// the steps send requests to the identity providers, they are not run for requests that should be safe
+	if req.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	names := []string{}
+	if name := req.URL.Query().Get("provider"); len(name) > 0 {
+		if _, ok := d.steps[name]; !ok {
+			http.Error(w, fmt.Sprintf("identity provider %q cannot be diagnosed", name), http.StatusNotFound)
+			return
+		}
+		names = append(names, name)
+	} else {
+		for name := range d.steps {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	status := http.StatusOK
+	diagnoses := []Diagnosis{}
+	for _, name := range names {
+		diagnosis, _ := d.Diagnose(name)
+		if !diagnosis.Passed {
+			status = http.StatusServiceUnavailable
+		}
+		diagnoses = append(diagnoses, diagnosis)
+	}
+	data, err := json.MarshalIndent(struct {
+		IdentityProviders []Diagnosis `json:"identityProviders"`
+	}{diagnoses}, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(data)
+}
+
+// TLSStep connects to the host of rawURL with transport and reports the certificate it presents, failing if it is not
+// trusted or expires within a week. URLs that do not use https fail, as identity providers must use TLS.
+func TLSStep(rawURL string, transport http.RoundTripper) Step {
+	return Step{Name: "tls", Run: func(ctx context.Context) (string, error) {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return "", err
+		}
+		if u.Scheme != "https" {
+			return "", fmt.Errorf("%s does not use https", rawURL)
+		}
+		resp, err := get(ctx, rawURL, "", transport)
+		if err != nil {
+			return "", err
+		}
+		resp.Body.Close()
+		if resp.TLS == nil || len(resp.TLS.PeerCertificates) == 0 {
+			return "", fmt.Errorf("%s presented no certificate", u.Host)
+		}
+		cert := resp.TLS.PeerCertificates[0]
+		message := fmt.Sprintf("%s presented %q issued by %q, valid until %s", u.Host, cert.Subject.String(), cert.Issuer.String(), cert.NotAfter.UTC().Format(time.RFC3339))
+		if time.Until(cert.NotAfter) < 7*24*time.Hour {
+			return message, fmt.Errorf("the certificate of %s expires at %s", u.Host, cert.NotAfter.UTC().Format(time.RFC3339))
+		}
+		return message, nil
+	}}
+}
+
+// DiscoveryStep fetches the OpenID discovery document of issuer and checks that it names the issuer
+func DiscoveryStep(issuer string, transport http.RoundTripper) Step {
+	return Step{Name: "discovery", Run: func(ctx context.Context) (string, error) {
+		discoveryURL := strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration"
+		data, err := getOK(ctx, discoveryURL, transport)
+		if err != nil {
+			return "", err
+		}
+		metadata := struct {
+			Issuer        string `json:"issuer"`
+			TokenEndpoint string `json:"token_endpoint"`
+			JWKSURI       string `json:"jwks_uri"`
+		}{}
+		if err := json.Unmarshal(data, &metadata); err != nil {
+			return "", fmt.Errorf("error parsing the discovery document: %v", err)
+		}
+		if metadata.Issuer != issuer {
+			return "", fmt.Errorf("discovery document issuer %q does not match the issuer %q", metadata.Issuer, issuer)
+		}
+		return fmt.Sprintf("token endpoint %s, JWKS %s", metadata.TokenEndpoint, metadata.JWKSURI), nil
+	}}
+}
+
+// JWKSStep fetches the JWKS at jwksURL and checks that it holds keys to verify signatures with
+func JWKSStep(jwksURL string, transport http.RoundTripper) Step {
+	return Step{Name: "jwks", Run: func(ctx context.Context) (string, error) {
+		data, err := getOK(ctx, jwksURL, transport)
+		if err != nil {
+			return "", err
+		}
+		keySet := jose.JSONWebKeySet{}
+		if err := json.Unmarshal(data, &keySet); err != nil {
+			return "", fmt.Errorf("error parsing the JWKS: %v", err)
+		}
+		signingKeys := 0
+		for _, key := range keySet.Keys {
+			if key.Use != "enc" {
+				signingKeys++
+			}
+		}
+		if signingKeys == 0 {
+			return "", fmt.Errorf("%s has no signing keys", jwksURL)
+		}
+		return fmt.Sprintf("%d signing keys", signingKeys), nil
+	}}
+}
+
+// ClientCredentialsStep asks the token endpoint for a token with the client_credentials grant, authenticating with
+// the client ID and secret. Providers that do not allow the grant for the client usually check its credentials first,
+// so only errors that reject the client fail the step. No tokens are kept.
+func ClientCredentialsStep(tokenURL, clientID, clientSecret string, transport http.RoundTripper) Step {
+	return Step{Name: "client-credentials", Run: func(ctx context.Context) (string, error) {
+		form := url.Values{"grant_type": {"client_credentials"}}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept", "application/json")
+		req.SetBasicAuth(url.QueryEscape(clientID), url.QueryEscape(clientSecret))
+		resp, err := newClient(transport).Do(req)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxDiagnosisBody))
+		if err != nil {
+			return "", err
+		}
+
+		tokenError := struct {
+			Error            string `json:"error"`
+			ErrorDescription string `json:"error_description"`
+		}{}
+		_ = json.Unmarshal(data, &tokenError)
+		switch {
+		// GitHub answers with incorrect_client_credentials and status 200
+		case resp.StatusCode == http.StatusUnauthorized || tokenError.Error == "invalid_client" || tokenError.Error == "incorrect_client_credentials":
+			return "", fmt.Errorf("the client credentials were rejected: %s %s", tokenError.Error, tokenError.ErrorDescription)
+		case resp.StatusCode >= http.StatusInternalServerError:
+			return "", fmt.Errorf("%s returned %s", tokenURL, resp.Status)
+		case len(tokenError.Error) > 0:
+			return fmt.Sprintf("the client was not rejected, the token endpoint answered %s", tokenError.Error), nil
+		case resp.StatusCode == http.StatusOK:
+			return "the client credentials were accepted", nil
+		default:
+			return fmt.Sprintf("%s returned %s, the client credentials could not be checked", tokenURL, resp.Status), nil
+		}
+	}}
+}
+
+func newClient(transport http.RoundTripper) *http.Client {
+	return &http.Client{
+		Transport:     transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+}
+
+func get(ctx context.Context, url, accept string, transport http.RoundTripper) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(accept) > 0 {
+		req.Header.Set("Accept", accept)
+	}
+	return newClient(transport).Do(req)
+}
+
+// getOK returns the body of url, failing for responses that are not 200
+func getOK(ctx context.Context, url string, transport http.RoundTripper) ([]byte, error) {
+	resp, err := get(ctx, url, "application/json", transport)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return ioutil.ReadAll(io.LimitReader(resp.Body, maxDiagnosisBody))
+}
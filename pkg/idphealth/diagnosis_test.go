@@ -0,0 +1,129 @@
+package idphealth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDiagnostics(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	diagnostics := NewDiagnostics(map[string][]Step{
+		"github": {
+			{Name: "tls", Run: func(ctx context.Context) (string, error) { return "trusted", nil }},
+		},
+		"ldap": {
+			CheckStep("bind", func(ctx context.Context) error { return errors.New("invalid credentials") }),
+			// ignores the timeout
+			{Name: "search", Run: func(ctx context.Context) (string, error) {
+				<-release
+				return "", nil
+			}},
+			CheckStep("after", func(ctx context.Context) error { return nil }),
+		},
+	}, 50*time.Millisecond)
+
+	diagnosis, ok := diagnostics.Diagnose("ldap")
+	if !ok || diagnosis.Passed || len(diagnosis.Steps) != 3 {
+		t.Fatalf("unexpected diagnosis %#v", diagnosis)
+	}
+	expected := []StepResult{
+		{Name: "bind", Error: "invalid credentials"},
+		{Name: "search", Error: "timed out after 50ms"},
+		{Name: "after", Passed: true, Message: "ok"},
+	}
+	for i, result := range diagnosis.Steps {
+		result.Duration = ""
+		if result != expected[i] {
+			t.Errorf("expected step %#v, got %#v", expected[i], result)
+		}
+	}
+	if _, ok := diagnostics.Diagnose("google"); ok {
+		t.Error("expected unknown identity providers not to be diagnosed")
+	}
+
+	serve := func(method, provider string) (*httptest.ResponseRecorder, []Diagnosis) {
+		resp := httptest.NewRecorder()
+		diagnostics.ServeHTTP(resp, httptest.NewRequest(method, "/debug/identity-provider-diagnosis?provider="+provider, nil))
+		served := struct {
+			IdentityProviders []Diagnosis `json:"identityProviders"`
+		}{}
+		if resp.Header().Get("Content-Type") == "application/json" {
+			if err := json.Unmarshal(resp.Body.Bytes(), &served); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return resp, served.IdentityProviders
+	}
+	if resp, _ := serve(http.MethodGet, "github"); resp.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected GET requests to be rejected, got %d", resp.Code)
+	}
+	if resp, _ := serve(http.MethodPost, "google"); resp.Code != http.StatusNotFound {
+		t.Errorf("expected unknown identity providers to be not found, got %d", resp.Code)
+	}
+	if resp, diagnoses := serve(http.MethodPost, "github"); resp.Code != http.StatusOK || len(diagnoses) != 1 || !diagnoses[0].Passed {
+		t.Errorf("unexpected diagnosis of github %d %#v", resp.Code, diagnoses)
+	}
+	if resp, diagnoses := serve(http.MethodPost, ""); resp.Code != http.StatusServiceUnavailable || len(diagnoses) != 2 || diagnoses[0].Name != "github" || diagnoses[1].Name != "ldap" {
+		t.Errorf("unexpected diagnosis of all identity providers %d %#v", resp.Code, diagnoses)
+	}
+}
+
+func TestSteps(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/.well-known/openid-configuration":
+			fmt.Fprintf(w, `{"issuer":%q,"token_endpoint":%q,"jwks_uri":%q}`, server.URL, server.URL+"/token", server.URL+"/jwks")
+		case "/jwks":
+			w.Write([]byte(`{"keys":[{"kty":"oct","k":"c2VjcmV0","use":"sig"}]}`))
+		case "/empty-jwks":
+			w.Write([]byte(`{"keys":[]}`))
+		case "/token":
+			if id, secret, ok := req.BasicAuth(); !ok || id != "client" || secret != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"error":"invalid_client"}`))
+				return
+			}
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"unauthorized_client"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	transport := server.Client().Transport
+
+	testCases := []struct {
+		Name          string
+		Step          Step
+		ExpectedError string
+	}{
+		{Name: "tls", Step: TLSStep(server.URL+"/token", transport)},
+		{Name: "untrusted tls", Step: TLSStep(server.URL+"/token", http.DefaultTransport), ExpectedError: "certificate"},
+		{Name: "plain http", Step: TLSStep("http://example.com/token", transport), ExpectedError: "does not use https"},
+		{Name: "discovery", Step: DiscoveryStep(server.URL, transport)},
+		{Name: "discovery of another issuer", Step: DiscoveryStep(server.URL+"/", transport), ExpectedError: "does not match the issuer"},
+		{Name: "jwks", Step: JWKSStep(server.URL+"/jwks", transport)},
+		{Name: "jwks without keys", Step: JWKSStep(server.URL+"/empty-jwks", transport), ExpectedError: "no signing keys"},
+		{Name: "missing jwks", Step: JWKSStep(server.URL+"/missing", transport), ExpectedError: "404"},
+		{Name: "client credentials", Step: ClientCredentialsStep(server.URL+"/token", "client", "secret", transport)},
+		{Name: "invalid client credentials", Step: ClientCredentialsStep(server.URL+"/token", "client", "wrong", transport), ExpectedError: "rejected"},
+	}
+	for _, testCase := range testCases {
+		_, err := testCase.Step.Run(context.Background())
+		switch {
+		case len(testCase.ExpectedError) == 0 && err != nil:
+			t.Errorf("%s: unexpected error: %v", testCase.Name, err)
+		case len(testCase.ExpectedError) > 0 && (err == nil || !strings.Contains(err.Error(), testCase.ExpectedError)):
+			t.Errorf("%s: expected error containing %q, got %v", testCase.Name, testCase.ExpectedError, err)
+		}
+	}
+}
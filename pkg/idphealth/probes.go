@@ -0,0 +1,57 @@
+package idphealth
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/openshift/library-go/pkg/security/ldapclient"
+)
+
+// HTTPCheck requests url with transport. Every response but server errors proves that the endpoint is reachable,
+// as token and login endpoints reject requests without credentials.
+func HTTPCheck(url string, transport http.RoundTripper) Check {
+	client := &http.Client{
+		Transport: transport,
+		// the endpoint answered, wherever it redirects to
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	return func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 4096))
+		if resp.StatusCode >= http.StatusInternalServerError {
+			return fmt.Errorf("%s returned %s", url, resp.Status)
+		}
+		return nil
+	}
+}
+
+// LDAPCheck connects to the LDAP server of config and binds with its credentials, if it has any
+func LDAPCheck(config ldapclient.Config) Check {
+	return func(ctx context.Context) error {
+		client, err := config.Connect()
+		if err != nil {
+			return fmt.Errorf("unable to connect to %s: %v", config.Host(), err)
+		}
+		defer client.Close()
+
+		bindDN, bindPassword := config.GetBindCredentials()
+		if len(bindDN) == 0 {
+			return nil
+		}
+		if err := client.Bind(bindDN, bindPassword); err != nil {
+			return fmt.Errorf("unable to bind to %s as %s: %v", config.Host(), bindDN, err)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,149 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// jsonLogger writes a JSON object per log line. klog decides which lines are written, so all levels are enabled.
+type jsonLogger struct {
+	lock *sync.Mutex
+	out  io.Writer
+	now  func() time.Time
+
+	level  int
+	name   string
+	values []interface{}
+	// depth is the number of additional stack frames between the caller and the logger
+	depth int
+}
+
+// NewJSONLogger returns a logger that writes JSON lines to out, for klog.SetLogger. The lines hold the time, the
+// verbosity, the caller, the message, the error and the key/value pairs of the line.
+func NewJSONLogger(out io.Writer) logr.Logger {
+	return &jsonLogger{lock: &sync.Mutex{}, out: out, now: time.Now}
+}
+
+func (l *jsonLogger) Enabled() bool {
+	return true
+}
+
+func (l *jsonLogger) Info(msg string, keysAndValues ...interface{}) {
+	l.write(nil, msg, keysAndValues)
+}
+
+func (l *jsonLogger) Error(err error, msg string, keysAndValues ...interface{}) {
+	if err == nil {
+		// klog passes the lines of Errorf without error
+		err = noError{}
+	}
+	l.write(err, msg, keysAndValues)
+}
+
+func (l *jsonLogger) V(level int) logr.Logger {
+	logger := *l
+	logger.level += level
+	return &logger
+}
+
+func (l *jsonLogger) WithValues(keysAndValues ...interface{}) logr.Logger {
+	logger := *l
+	logger.values = append(l.values[:len(l.values):len(l.values)], keysAndValues...)
+	return &logger
+}
+
+func (l *jsonLogger) WithName(name string) logr.Logger {
+	logger := *l
+	logger.name = name
+	if len(l.name) > 0 {
+		logger.name = l.name + "." + name
+	}
+	return &logger
+}
+
+func (l *jsonLogger) WithCallDepth(depth int) logr.Logger {
+	logger := *l
+	logger.depth += depth
+	return &logger
+}
+
+// noError marks error lines that were not logged with an error
+type noError struct{}
+
+func (noError) Error() string {
+	return ""
+}
+
+// write writes a line, it must be called by Info or Error
+func (l *jsonLogger) write(err error, msg string, keysAndValues []interface{}) {
+	buf := &bytes.Buffer{}
+	buf.WriteByte('{')
+	writeField(buf, "ts", l.now().UTC().Format(time.RFC3339Nano))
+	if err != nil {
+		writeField(buf, "level", "error")
+	} else {
+		writeField(buf, "level", "info")
+		writeField(buf, "v", l.level)
+	}
+	// the frames of write and Info or Error
+	if _, file, line, ok := runtime.Caller(2 + l.depth); ok {
+		writeField(buf, "caller", fmt.Sprintf("%s/%s:%d", filepath.Base(filepath.Dir(file)), filepath.Base(file), line))
+	}
+	if len(l.name) > 0 {
+		writeField(buf, "logger", l.name)
+	}
+	// klog passes formatted lines with their newline
+	writeField(buf, "msg", strings.TrimSuffix(msg, "\n"))
+	if _, ok := err.(noError); err != nil && !ok {
+		writeField(buf, "err", fmt.Sprint(err))
+	}
+	writeKeysAndValues(buf, l.values)
+	writeKeysAndValues(buf, keysAndValues)
+	buf.WriteString("}\n")
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	_, _ = l.out.Write(buf.Bytes())
+}
+
+func writeKeysAndValues(buf *bytes.Buffer, keysAndValues []interface{}) {
+	for i := 0; i < len(keysAndValues); i += 2 {
+		key, ok := keysAndValues[i].(string)
+		if !ok {
+			key = fmt.Sprint(keysAndValues[i])
+		}
+		var value interface{} = "(MISSING)"
+		if i+1 < len(keysAndValues) {
+			value = keysAndValues[i+1]
+		}
+		writeField(buf, key, value)
+	}
+}
+
+// writeField writes a key and its value, values that cannot be encoded are written as text
+func writeField(buf *bytes.Buffer, key string, value interface{}) {
+	if buf.Len() > 1 {
+		buf.WriteByte(',')
+	}
+	encodedKey, _ := json.Marshal(key)
+	buf.Write(encodedKey)
+	buf.WriteByte(':')
+
+	if err, ok := value.(error); ok {
+		value = fmt.Sprint(err)
+	}
+	encodedValue, err := json.Marshal(value)
+	if err != nil {
+		encodedValue, _ = json.Marshal(fmt.Sprintf("%+v", value))
+	}
+	buf.Write(encodedValue)
+}
@@ -0,0 +1,79 @@
+// Package logging writes the log lines of login flows with the context of their requests, e.g. the login attempt,
+// the identity provider and the user, as key/value pairs of klog structured logging. The lines can be emitted as
+// JSON and the verbosity can be changed while the server runs.
+package logging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"k8s.io/klog/v2"
+
+	"github.com/openshift/oauth-server/pkg/server/correlation"
+)
+
+type valuesKeyType int
+
+const valuesKey valuesKeyType = iota
+
+// WithValues returns req with key/value pairs that are added to the log lines of FromRequest, e.g. the user once
+// it is known
+func WithValues(req *http.Request, keysAndValues ...interface{}) *http.Request {
+	values, _ := req.Context().Value(valuesKey).([]interface{})
+	values = append(values[:len(values):len(values)], keysAndValues...)
+	return req.WithContext(context.WithValue(req.Context(), valuesKey, values))
+}
+
+// WithProvider adds the name of the identity provider to the log lines of the requests that handler serves
+func WithProvider(handler http.Handler, provider string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		handler.ServeHTTP(w, WithValues(req, "provider", provider))
+	})
+}
+
+// Logger writes log lines with the key/value pairs of a request
+type Logger struct {
+	values []interface{}
+}
+
+// FromRequest returns a logger for the log lines of req. They hold the method and path, the address of the client,
+// the correlation ID of the login attempt and the values of WithValues.
+func FromRequest(req *http.Request) Logger {
+	values := []interface{}{"method", req.Method}
+	if req.URL != nil {
+		values = append(values, "path", req.URL.Path)
+	}
+	values = append(values, "client", req.RemoteAddr)
+	// the ID may change while the request is served, e.g. when it is restored from the state of a callback
+	if id := correlation.ID(req); len(id) > 0 {
+		values = append(values, "attempt", id)
+	}
+	if requestValues, ok := req.Context().Value(valuesKey).([]interface{}); ok {
+		values = append(values, requestValues...)
+	}
+	return Logger{values: values}
+}
+
+// WithValues returns a logger that adds key/value pairs to the log lines of l
+func (l Logger) WithValues(keysAndValues ...interface{}) Logger {
+	return Logger{values: append(l.values[:len(l.values):len(l.values)], keysAndValues...)}
+}
+
+// Info writes msg if the verbosity of klog is at least level
+func (l Logger) Info(level klog.Level, msg string, keysAndValues ...interface{}) {
+	if !klog.V(level).Enabled() {
+		return
+	}
+	klog.InfoSDepth(1, msg, append(l.values[:len(l.values):len(l.values)], keysAndValues...)...)
+}
+
+// Error writes msg with err regardless of the verbosity of klog
+func (l Logger) Error(err error, msg string, keysAndValues ...interface{}) {
+	// klog calls the Error method directly, fmt recovers from its panics
+	if err != nil {
+		err = errors.New(fmt.Sprint(err))
+	}
+	klog.ErrorSDepth(1, err, msg, append(l.values[:len(l.values):len(l.values)], keysAndValues...)...)
+}
@@ -0,0 +1,97 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/openshift/oauth-server/pkg/server/cookies"
+	"github.com/openshift/oauth-server/pkg/server/correlation"
+)
+
+func TestFromRequest(t *testing.T) {
+	var logger Logger
+	handler := correlation.WithCorrelationID(WithProvider(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req = WithValues(req, "user", "alice")
+		logger = FromRequest(req).WithValues("groups", []string{"admins"})
+	}), "github"), cookies.Options{}, func(*http.Request) bool { return false })
+
+	req := httptest.NewRequest(http.MethodGet, "/oauth2callback/github", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	req.Header.Set(correlation.Header, "attempt-1234")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	expected := []interface{}{
+		"method", http.MethodGet,
+		"path", "/oauth2callback/github",
+		"client", "192.0.2.1:1234",
+		"attempt", "attempt-1234",
+		"provider", "github",
+		"user", "alice",
+		"groups", []string{"admins"},
+	}
+	if !reflect.DeepEqual(logger.values, expected) {
+		t.Errorf("expected values %v, got %v", expected, logger.values)
+	}
+}
+
+func TestJSONLogger(t *testing.T) {
+	out := &bytes.Buffer{}
+	logger := NewJSONLogger(out).(*jsonLogger)
+	logger.now = func() time.Time { return time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC) }
+
+	klog.SetLogger(logger)
+	defer klog.SetLogger(nil)
+
+	_, _, line, _ := runtime.Caller(0)
+	FromRequest(httptest.NewRequest(http.MethodPost, "/login", nil)).WithValues("user", "alice").Error(errors.New("denied"), "Login failed", "attempts", 3)
+	klog.Errorf("Formatted %s", "line")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %q", out.String())
+	}
+	first := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		t.Fatalf("invalid line %q: %v", lines[0], err)
+	}
+	expected := map[string]interface{}{
+		"ts":       "2026-01-02T03:04:05Z",
+		"level":    "error",
+		"caller":   fmt.Sprintf("logging/logging_test.go:%d", line+1),
+		"msg":      "Login failed",
+		"err":      "denied",
+		"method":   "POST",
+		"path":     "/login",
+		"client":   "192.0.2.1:1234",
+		"user":     "alice",
+		"attempts": float64(3),
+	}
+	if !reflect.DeepEqual(first, expected) {
+		t.Errorf("expected %v, got %v", expected, first)
+	}
+	if !strings.HasPrefix(lines[0], `{"ts":`) {
+		t.Errorf("expected the time first, got %s", lines[0])
+	}
+
+	second := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(lines[1]), &second); err != nil {
+		t.Fatalf("invalid line %q: %v", lines[1], err)
+	}
+	if second["msg"] != "Formatted line" || second["level"] != "error" || second["caller"] != fmt.Sprintf("logging/logging_test.go:%d", line+2) {
+		t.Errorf("unexpected formatted line %v", second)
+	}
+	if _, ok := second["err"]; ok {
+		t.Errorf("expected no error in formatted lines, got %v", second)
+	}
+}
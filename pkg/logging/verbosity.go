@@ -0,0 +1,147 @@
+package logging
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/klog/v2"
+)
+
+// Verbosity changes the verbosity of klog while the server runs, e.g. to debug login failures without a restart.
+// Changes can be temporary, so that the verbose log lines, which may hold sensitive data, are not written for longer
+// than necessary.
+type Verbosity struct {
+	lock  sync.Mutex
+	level klog.Level
+	// permanent is the verbosity that a temporary change is reset to
+	permanent klog.Level
+	reset     *time.Timer
+	resetAt   time.Time
+}
+
+// VerbosityStatus is the response of the endpoint
+type VerbosityStatus struct {
+	Verbosity int32 `json:"verbosity"`
+	// ResetAt is when a temporary change ends, nil for permanent changes
+	ResetAt *time.Time `json:"resetAt,omitempty"`
+	// ResetTo is the verbosity after a temporary change
+	ResetTo *int32 `json:"resetTo,omitempty"`
+}
+
+// NewVerbosity returns a Verbosity that starts with the verbosity of the -v flag
+func NewVerbosity() *Verbosity {
+	level := flagLevel()
+	return &Verbosity{level: level, permanent: level}
+}
+
+// flagLevel returns the value of the -v flag of klog, if it is registered
+func flagLevel() klog.Level {
+	if f := flag.CommandLine.Lookup("v"); f != nil {
+		if getter, ok := f.Value.(flag.Getter); ok {
+			if level, ok := getter.Get().(klog.Level); ok {
+				return level
+			}
+		}
+	}
+	return 0
+}
+
+// ServeHTTP returns the verbosity on GET and changes it on PUT. PUT requires the verbosity parameter, e.g.
+// ?verbosity=5, and resets it to the previous one after the duration parameter, e.g. &duration=15m, if it is set.
+func (v *Verbosity) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		level, err := strconv.ParseInt(req.URL.Query().Get("verbosity"), 10, 32)
+		if err != nil || level < 0 {
+			http.Error(w, "the verbosity parameter must be a non-negative number", http.StatusBadRequest)
+			return
+		}
+		var duration time.Duration
+		if value := req.URL.Query().Get("duration"); len(value) > 0 {
+			if duration, err = time.ParseDuration(value); err != nil || duration <= 0 {
+				http.Error(w, "the duration parameter must be a positive duration", http.StatusBadRequest)
+				return
+			}
+		}
+		if err := v.Set(klog.Level(level), duration); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		FromRequest(req).Info(0, "Changed the log verbosity", "verbosity", level, "duration", duration)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v.Status()); err != nil {
+		utilruntime.HandleError(fmt.Errorf("unable to write the log verbosity: %v", err))
+	}
+}
+
+// Set changes the verbosity. If duration is positive, the verbosity is reset to the last permanent one afterwards.
+func (v *Verbosity) Set(level klog.Level, duration time.Duration) error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if err := setLevel(level); err != nil {
+		return err
+	}
+	v.level = level
+	if v.reset != nil {
+		v.reset.Stop()
+		v.reset = nil
+		v.resetAt = time.Time{}
+	}
+	if duration <= 0 {
+		v.permanent = level
+		return nil
+	}
+
+	var reset *time.Timer
+	reset = time.AfterFunc(duration, func() {
+		v.lock.Lock()
+		defer v.lock.Unlock()
+		// the change was replaced by a later one
+		if v.reset != reset {
+			return
+		}
+		if err := setLevel(v.permanent); err != nil {
+			utilruntime.HandleError(fmt.Errorf("unable to reset the log verbosity: %v", err))
+			return
+		}
+		klog.Infof("Reset the log verbosity to %d", v.permanent)
+		v.level = v.permanent
+		v.reset = nil
+		v.resetAt = time.Time{}
+	})
+	v.reset = reset
+	v.resetAt = time.Now().Add(duration)
+	return nil
+}
+
+// Status returns the current verbosity and the end of a temporary change
+func (v *Verbosity) Status() VerbosityStatus {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	status := VerbosityStatus{Verbosity: int32(v.level)}
+	if v.reset != nil {
+		resetAt, resetTo := v.resetAt, int32(v.permanent)
+		status.ResetAt, status.ResetTo = &resetAt, &resetTo
+	}
+	return status
+}
+
+// setLevel sets the verbosity of klog
+func setLevel(level klog.Level) error {
+	var l klog.Level
+	return l.Set(strconv.Itoa(int(level)))
+}
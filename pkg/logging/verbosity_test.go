@@ -0,0 +1,77 @@
+package logging
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+func TestVerbosity(t *testing.T) {
+	verbosity := NewVerbosity()
+	defer func() {
+		if err := setLevel(verbosity.permanent); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	request := func(method, query string) (int, VerbosityStatus) {
+		resp := httptest.NewRecorder()
+		verbosity.ServeHTTP(resp, httptest.NewRequest(method, "/debug/logging"+query, nil))
+		status := VerbosityStatus{}
+		if resp.Code == http.StatusOK {
+			if err := json.Unmarshal(resp.Body.Bytes(), &status); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return resp.Code, status
+	}
+
+	for _, query := range []string{"", "?verbosity=-1", "?verbosity=high", "?verbosity=5&duration=0s", "?verbosity=5&duration=soon"} {
+		if code, _ := request(http.MethodPut, query); code != http.StatusBadRequest {
+			t.Errorf("expected %q to be rejected, got %d", query, code)
+		}
+	}
+	if code, _ := request(http.MethodPost, "?verbosity=5"); code != http.StatusMethodNotAllowed {
+		t.Errorf("expected POST to be rejected, got %d", code)
+	}
+
+	if code, status := request(http.MethodPut, "?verbosity=2"); code != http.StatusOK || status.Verbosity != 2 || status.ResetAt != nil {
+		t.Fatalf("expected permanent verbosity 2, got %d %+v", code, status)
+	}
+	if !klog.V(2).Enabled() || klog.V(3).Enabled() {
+		t.Errorf("expected klog verbosity 2")
+	}
+
+	code, status := request(http.MethodPut, "?verbosity=5&duration=50ms")
+	if code != http.StatusOK || status.Verbosity != 5 || status.ResetAt == nil || status.ResetTo == nil || *status.ResetTo != 2 {
+		t.Fatalf("expected temporary verbosity 5, got %d %+v", code, status)
+	}
+	if !klog.V(5).Enabled() {
+		t.Errorf("expected klog verbosity 5")
+	}
+
+	if err := wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		return verbosity.Status().Verbosity == 2, nil
+	}); err != nil {
+		t.Fatalf("expected the verbosity to be reset, got %+v", verbosity.Status())
+	}
+	if _, status := request(http.MethodGet, ""); status.Verbosity != 2 || status.ResetAt != nil {
+		t.Errorf("expected permanent verbosity 2, got %+v", status)
+	}
+	if !klog.V(2).Enabled() || klog.V(3).Enabled() {
+		t.Errorf("expected klog verbosity 2 after the reset")
+	}
+
+	// a permanent change replaces a temporary one
+	request(http.MethodPut, "?verbosity=5&duration=50ms")
+	request(http.MethodPut, "?verbosity=3")
+	time.Sleep(100 * time.Millisecond)
+	if status := verbosity.Status(); status.Verbosity != 3 || status.ResetAt != nil {
+		t.Errorf("expected permanent verbosity 3, got %+v", status)
+	}
+}
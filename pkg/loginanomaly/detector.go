@@ -0,0 +1,225 @@
+// Package loginanomaly detects interactive logins from devices and regions a user did not log in from before, and
+// logins from another region shortly after the previous login of the user (impossible travel). Anomalous logins are
+// recorded in audit events and logs, can be sent to a webhook and can be required to use stronger authentication
+// methods than other logins.
+package loginanomaly
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kuser "k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+
+	userv1 "github.com/openshift/api/user/v1"
+	userclient "github.com/openshift/client-go/user/clientset/versioned/typed/user/v1"
+
+	"github.com/openshift/oauth-server/pkg/server/clientip"
+)
+
+const (
+	// FingerprintsAnnotation is the annotation of users that holds the devices and regions they logged in from, as a
+	// JSON list of fingerprints
+	FingerprintsAnnotation = "oauth.openshift.io/login-fingerprints"
+
+	// DefaultMaxFingerprints is the number of fingerprints that are kept per user if no maximum is configured
+	DefaultMaxFingerprints = 10
+
+	// maxUserAgentLength limits the length of the user agents that are recorded
+	maxUserAgentLength = 256
+)
+
+// Anomaly is a reason a login is anomalous
+type Anomaly string
+
+const (
+	// NewDevice is a login with a user agent the user did not log in with before
+	NewDevice Anomaly = "new-device"
+	// NewRegion is a login from a region the user did not log in from before
+	NewRegion Anomaly = "new-region"
+	// ImpossibleTravel is a login from another region than the previous login of the user within the travel window
+	ImpossibleTravel Anomaly = "impossible-travel"
+)
+
+// Fingerprint describes a device and region a user logged in from
+type Fingerprint struct {
+	UserAgent string `json:"userAgent,omitempty"`
+	Region    string `json:"region,omitempty"`
+	// LastSeen is the time of the last login with the fingerprint, in seconds since the epoch
+	LastSeen int64 `json:"lastSeen"`
+}
+
+// Login describes a login that is checked for anomalies
+type Login struct {
+	Provider  string
+	ClientIP  string
+	UserAgent string
+	// Region is empty if the region of the client is not known
+	Region string
+	Time   time.Time
+}
+
+// Detector compares logins to the fingerprints of the previous logins of their users, which are kept in an
+// annotation of the users
+type Detector struct {
+	users           userclient.UserInterface
+	resolver        RegionResolver
+	travelWindow    time.Duration
+	maxFingerprints int
+	notifier        Notifier
+	requiredMethods []string
+	now             func() time.Time
+}
+
+// NewDetector returns a detector that resolves the regions of clients with resolver, if it is set. Logins from
+// another region within travelWindow of the previous login are impossible travel, a window of 0 disables the check.
+// Anomalous logins are sent to notifier if it is set, and must have been authenticated with all requiredMethods.
+func NewDetector(users userclient.UserInterface, resolver RegionResolver, travelWindow time.Duration, maxFingerprints int, notifier Notifier, requiredMethods []string) *Detector {
+	if maxFingerprints <= 0 {
+		maxFingerprints = DefaultMaxFingerprints
+	}
+	return &Detector{
+		users:           users,
+		resolver:        resolver,
+		travelWindow:    travelWindow,
+		maxFingerprints: maxFingerprints,
+		notifier:        notifier,
+		requiredMethods: requiredMethods,
+		now:             time.Now,
+	}
+}
+
+// Login returns the login of req with the identity provider
+func (d *Detector) Login(provider string, req *http.Request) Login {
+	login := Login{Provider: provider, UserAgent: req.UserAgent(), Time: d.now()}
+	if len(login.UserAgent) > maxUserAgentLength {
+		login.UserAgent = login.UserAgent[:maxUserAgentLength]
+	}
+	host := clientip.SourceIP(req)
+	login.ClientIP = host
+	if ip := net.ParseIP(host); ip != nil && d.resolver != nil {
+		region, err := d.resolver.Region(ip)
+		if err != nil {
+			klog.V(4).Infof("unable to resolve the region of %s: %v", host, err)
+		}
+		login.Region = region
+	}
+	return login
+}
+
+// Detect returns the anomalies of the login of the user. The first login of a user is not anomalous, there is
+// nothing to compare it to.
+func (d *Detector) Detect(user kuser.Info, login Login) ([]Anomaly, error) {
+	u, err := d.users.Get(context.TODO(), user.GetName(), metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	fingerprints := fingerprintsOf(u, user.GetUID())
+	if len(fingerprints) == 0 {
+		return nil, nil
+	}
+
+	knownDevice, knownRegion := false, len(login.Region) == 0
+	var last Fingerprint
+	for _, fingerprint := range fingerprints {
+		knownDevice = knownDevice || fingerprint.UserAgent == login.UserAgent
+		knownRegion = knownRegion || fingerprint.Region == login.Region
+		if fingerprint.LastSeen > last.LastSeen {
+			last = fingerprint
+		}
+	}
+
+	anomalies := []Anomaly{}
+	if !knownDevice {
+		anomalies = append(anomalies, NewDevice)
+	}
+	if !knownRegion {
+		anomalies = append(anomalies, NewRegion)
+	}
+	if d.travelWindow > 0 && len(login.Region) > 0 && len(last.Region) > 0 && last.Region != login.Region &&
+		login.Time.Sub(time.Unix(last.LastSeen, 0)) < d.travelWindow {
+		anomalies = append(anomalies, ImpossibleTravel)
+	}
+	return anomalies, nil
+}
+
+// Record records the fingerprint of the login of the user, replacing the least recently seen fingerprint if the
+// user has the maximum number of fingerprints
+func (d *Detector) Record(user kuser.Info, login Login) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		u, err := d.users.Get(context.TODO(), user.GetName(), metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if string(u.UID) != user.GetUID() {
+			return fmt.Errorf("user %q was replaced since the login", user.GetName())
+		}
+
+		fingerprints := []Fingerprint{{UserAgent: login.UserAgent, Region: login.Region, LastSeen: login.Time.Unix()}}
+		for _, fingerprint := range fingerprintsOf(u, user.GetUID()) {
+			if fingerprint.UserAgent != login.UserAgent || fingerprint.Region != login.Region {
+				fingerprints = append(fingerprints, fingerprint)
+			}
+		}
+		sort.SliceStable(fingerprints, func(i, j int) bool { return fingerprints[i].LastSeen > fingerprints[j].LastSeen })
+		if len(fingerprints) > d.maxFingerprints {
+			fingerprints = fingerprints[:d.maxFingerprints]
+		}
+
+		data, err := json.Marshal(fingerprints)
+		if err != nil {
+			return err
+		}
+		if u.Annotations == nil {
+			u.Annotations = map[string]string{}
+		}
+		u.Annotations[FingerprintsAnnotation] = string(data)
+		_, err = d.users.Update(context.TODO(), u, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// notify sends the anomalous login to the notifier in the background, so that a slow notifier does not delay logins
+func (d *Detector) notify(user kuser.Info, login Login, anomalies []Anomaly, allowed bool) {
+	if d.notifier == nil {
+		return
+	}
+	notification := Notification{
+		Kind:      NotificationKind,
+		User:      NotificationUser{Name: user.GetName(), UID: user.GetUID()},
+		Provider:  login.Provider,
+		ClientIP:  login.ClientIP,
+		UserAgent: login.UserAgent,
+		Region:    login.Region,
+		Anomalies: anomalies,
+		Allowed:   allowed,
+		Time:      metav1.NewTime(login.Time),
+	}
+	go func() {
+		if err := d.notifier.Notify(notification); err != nil {
+			klog.Errorf("unable to notify about the anomalous login of user %q: %v", user.GetName(), err)
+		}
+	}()
+}
+
+// fingerprintsOf returns the fingerprints recorded for the user with the uid, a user that was deleted and created
+// again has none
+func fingerprintsOf(u *userv1.User, uid string) []Fingerprint {
+	data, ok := u.Annotations[FingerprintsAnnotation]
+	if !ok || string(u.UID) != uid {
+		return nil
+	}
+	fingerprints := []Fingerprint{}
+	if err := json.Unmarshal([]byte(data), &fingerprints); err != nil {
+		klog.Warningf("ignoring invalid %s annotation of user %q: %v", FingerprintsAnnotation, u.Name, err)
+		return nil
+	}
+	return fingerprints
+}
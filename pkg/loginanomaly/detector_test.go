@@ -0,0 +1,130 @@
+package loginanomaly
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apiserver/pkg/authentication/user"
+
+	userv1 "github.com/openshift/api/user/v1"
+	userfake "github.com/openshift/client-go/user/clientset/versioned/fake"
+)
+
+const testRegions = `
+# offices
+192.0.2.0/24 eu-west
+192.0.2.128/25 eu-central
+198.51.100.0/24 us-east
+`
+
+func TestCIDRResolver(t *testing.T) {
+	resolver, err := NewCIDRResolver([]byte(testRegions))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ip, expected := range map[string]string{
+		"192.0.2.1":    "eu-west",
+		"192.0.2.200":  "eu-central",
+		"198.51.100.7": "us-east",
+		"203.0.113.1":  "",
+	} {
+		if region, err := resolver.Region(net.ParseIP(ip)); err != nil || region != expected {
+			t.Errorf("%s: expected region %q, got %q %v", ip, expected, region, err)
+		}
+	}
+
+	if _, err := NewCIDRResolver([]byte("192.0.2.0/24")); err == nil {
+		t.Error("expected error for a line without region")
+	}
+	if _, err := NewCIDRResolver([]byte("192.0.2.0/33 eu-west")); err == nil {
+		t.Error("expected error for an invalid network")
+	}
+}
+
+func TestDetector(t *testing.T) {
+	users := userfake.NewSimpleClientset(
+		&userv1.User{ObjectMeta: metav1.ObjectMeta{Name: "bob", UID: "bob-uid"}},
+	).UserV1().Users()
+	resolver, err := NewCIDRResolver([]byte(testRegions))
+	if err != nil {
+		t.Fatal(err)
+	}
+	detector := NewDetector(users, resolver, time.Hour, 2, nil, nil)
+	bob := &user.DefaultInfo{Name: "bob", UID: "bob-uid"}
+	start := time.Now().Truncate(time.Second)
+
+	login := func(remoteAddr, userAgent string, at time.Time) Login {
+		req := httptest.NewRequest("POST", "/login", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("User-Agent", userAgent)
+		detector.now = func() time.Time { return at }
+		return detector.Login("htpasswd", req)
+	}
+
+	first := login("192.0.2.1:1234", "firefox", start)
+	if first.Region != "eu-west" || first.ClientIP != "192.0.2.1" || first.UserAgent != "firefox" {
+		t.Fatalf("unexpected login %#v", first)
+	}
+	if anomalies, err := detector.Detect(bob, first); err != nil || len(anomalies) != 0 {
+		t.Fatalf("expected the first login not to be anomalous, got %v %v", anomalies, err)
+	}
+	if err := detector.Record(bob, first); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		Name     string
+		Login    Login
+		Expected []Anomaly
+	}{
+		{Name: "known device and region", Login: login("192.0.2.2:1234", "firefox", start.Add(time.Minute))},
+		{Name: "new device", Login: login("192.0.2.2:1234", "curl", start.Add(time.Minute)), Expected: []Anomaly{NewDevice}},
+		{Name: "unknown region", Login: login("203.0.113.1:1234", "firefox", start.Add(time.Minute))},
+		{Name: "new region after the travel window", Login: login("198.51.100.1:1234", "firefox", start.Add(2*time.Hour)), Expected: []Anomaly{NewRegion}},
+		{Name: "impossible travel", Login: login("198.51.100.1:1234", "chrome", start.Add(time.Minute)), Expected: []Anomaly{NewDevice, NewRegion, ImpossibleTravel}},
+	}
+	for _, testCase := range testCases {
+		anomalies, err := detector.Detect(bob, testCase.Login)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", testCase.Name, err)
+			continue
+		}
+		if len(anomalies) != 0 || len(testCase.Expected) != 0 {
+			if !reflect.DeepEqual(anomalies, testCase.Expected) {
+				t.Errorf("%s: expected anomalies %v, got %v", testCase.Name, testCase.Expected, anomalies)
+			}
+		}
+	}
+
+	// the least recently seen fingerprints are replaced
+	for i, userAgent := range []string{"chrome", "curl"} {
+		if err := detector.Record(bob, login("192.0.2.1:1234", userAgent, start.Add(time.Duration(i+1)*time.Minute))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	u, err := users.Get(context.TODO(), "bob", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fingerprints := fingerprintsOf(u, "bob-uid")
+	if len(fingerprints) != 2 || fingerprints[0].UserAgent != "curl" || fingerprints[1].UserAgent != "chrome" {
+		t.Errorf("expected the two most recent fingerprints, got %#v", fingerprints)
+	}
+	if anomalies, err := detector.Detect(bob, login("192.0.2.1:1234", "firefox", start.Add(time.Hour))); err != nil || !reflect.DeepEqual(anomalies, []Anomaly{NewDevice}) {
+		t.Errorf("expected the replaced device to be new, got %v %v", anomalies, err)
+	}
+
+	recreated := &user.DefaultInfo{Name: "bob", UID: "old-bob-uid"}
+	if anomalies, err := detector.Detect(recreated, first); err != nil || len(anomalies) != 0 {
+		t.Errorf("expected a user with another UID to have no fingerprints, got %v %v", anomalies, err)
+	}
+	if err := detector.Record(recreated, first); err == nil || !strings.Contains(err.Error(), "replaced") {
+		t.Errorf("expected error recording the login of a user with another UID, got %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+package loginanomaly
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	kaudit "k8s.io/apiserver/pkg/audit"
+	"k8s.io/apiserver/pkg/authentication/user"
+
+	bootstrap "github.com/openshift/library-go/pkg/authentication/bootstrapauthenticator"
+
+	"github.com/openshift/oauth-server/pkg/api"
+	"github.com/openshift/oauth-server/pkg/audit"
+	"github.com/openshift/oauth-server/pkg/logging"
+	"github.com/openshift/oauth-server/pkg/oauth/handlers"
+	metrics "github.com/openshift/oauth-server/pkg/prometheus"
+)
+
+// deniedMessage is shown to users whose anomalous login was rejected
+const deniedMessage = "Logins from new devices or locations require stronger authentication. Log in with an identity provider that provides it."
+
+// NewSuccessHandler returns a success handler that checks the logins with the identity provider for anomalies
+// before passing them to delegate, e.g. to the session. Anomalous logins that were not authenticated with the
+// required methods of the detector are rejected and their fingerprints are not recorded, so that the next login
+// from the device is anomalous as well. Errors reading or recording fingerprints only reject logins if methods are
+// required.
+func NewSuccessHandler(detector *Detector, provider string, delegate handlers.AuthenticationSuccessHandler) handlers.AuthenticationSuccessHandler {
+	return &successHandler{detector: detector, provider: provider, delegate: delegate}
+}
+
+type successHandler struct {
+	detector *Detector
+	provider string
+	delegate handlers.AuthenticationSuccessHandler
+}
+
+func (h *successHandler) AuthenticationSucceeded(user user.Info, state string, w http.ResponseWriter, req *http.Request) (bool, error) {
+	// the bootstrap user has no user object to record fingerprints in
+	if user.GetName() == bootstrap.BootstrapUser {
+		return h.delegate.AuthenticationSucceeded(user, state, w, req)
+	}
+
+	logger := logging.FromRequest(req)
+	failClosed := len(h.detector.requiredMethods) > 0
+	login := h.detector.Login(h.provider, req)
+	anomalies, err := h.detector.Detect(user, login)
+	if err != nil {
+		if failClosed {
+			return false, fmt.Errorf("unable to check the login of %q for anomalies: %v", user.GetName(), err)
+		}
+		logger.Error(err, "Error checking the login for anomalies")
+		return h.delegate.AuthenticationSucceeded(user, state, w, req)
+	}
+
+	if len(anomalies) > 0 {
+		names := make([]string, 0, len(anomalies))
+		for _, anomaly := range anomalies {
+			names = append(names, string(anomaly))
+			metrics.RecordLoginAnomaly(h.provider, string(anomaly))
+		}
+		kaudit.AddAuditAnnotation(req.Context(), audit.AnomalyAnnotation, strings.Join(names, ","))
+
+		allowed := satisfies(user, h.detector.requiredMethods)
+		logger.Info(0, "Anomalous login", "user", user.GetName(), "anomalies", names, "region", login.Region, "userAgent", login.UserAgent, "allowed", allowed)
+		h.detector.notify(user, login, anomalies, allowed)
+		if !allowed {
+			audit.AddDecisionAnnotation(req, audit.DenyDecision)
+			return false, api.NewAuthorizationDeniedErrorWithMessage(nil,
+				fmt.Errorf("anomalous login of %q was not authenticated with %v", user.GetName(), h.detector.requiredMethods), deniedMessage)
+		}
+	}
+
+	if err := h.detector.Record(user, login); err != nil {
+		if failClosed {
+			return false, fmt.Errorf("unable to record the login of %q: %v", user.GetName(), err)
+		}
+		logger.Error(err, "Error recording the login fingerprint")
+	}
+	return h.delegate.AuthenticationSucceeded(user, state, w, req)
+}
+
+// satisfies returns whether the user was authenticated with all methods
+func satisfies(user user.Info, methods []string) bool {
+	if len(methods) == 0 {
+		return true
+	}
+	authenticated, ok := user.(handlers.AuthenticationMethods)
+	return ok && sets.NewString(authenticated.GetAuthenticationMethods()...).HasAll(methods...)
+}
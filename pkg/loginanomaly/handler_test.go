@@ -0,0 +1,94 @@
+package loginanomaly
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apiserver/pkg/authentication/user"
+
+	userv1 "github.com/openshift/api/user/v1"
+	userfake "github.com/openshift/client-go/user/clientset/versioned/fake"
+
+	"github.com/openshift/oauth-server/pkg/api"
+	"github.com/openshift/oauth-server/pkg/oauth/handlers"
+)
+
+type fakeSuccessHandler struct {
+	succeeded int
+}
+
+func (h *fakeSuccessHandler) AuthenticationSucceeded(user user.Info, state string, w http.ResponseWriter, req *http.Request) (bool, error) {
+	h.succeeded++
+	return false, nil
+}
+
+func TestSuccessHandler(t *testing.T) {
+	notifications := make(chan Notification, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		notification := Notification{}
+		if err := json.NewDecoder(req.Body).Decode(&notification); err != nil {
+			t.Errorf("invalid notification: %v", err)
+		}
+		notifications <- notification
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	users := userfake.NewSimpleClientset(
+		&userv1.User{ObjectMeta: metav1.ObjectMeta{Name: "bob", UID: "bob-uid", Annotations: map[string]string{
+			FingerprintsAnnotation: `[{"userAgent":"firefox","lastSeen":1}]`,
+		}}},
+	).UserV1().Users()
+	detector := NewDetector(users, nil, 0, 0, NewWebhook(server.URL, nil, time.Second), []string{"mfa"})
+	delegate := &fakeSuccessHandler{}
+	handler := NewSuccessHandler(detector, "htpasswd", delegate)
+	bob := &user.DefaultInfo{Name: "bob", UID: "bob-uid"}
+
+	login := func(user user.Info, userAgent string) error {
+		req := httptest.NewRequest(http.MethodPost, "/login", nil)
+		req.Header.Set("User-Agent", userAgent)
+		_, err := handler.AuthenticationSucceeded(user, "", httptest.NewRecorder(), req)
+		return err
+	}
+
+	if err := login(bob, "firefox"); err != nil || delegate.succeeded != 1 {
+		t.Fatalf("expected login from a known device to succeed, got %v", err)
+	}
+
+	err := login(bob, "curl")
+	var deniedErr api.AuthorizationDeniedError
+	if !errors.As(err, &deniedErr) || deniedErr.UserMessage() != deniedMessage || delegate.succeeded != 1 {
+		t.Fatalf("expected login from a new device without mfa to be denied, got %v", err)
+	}
+	select {
+	case notification := <-notifications:
+		if notification.Kind != NotificationKind || notification.User.Name != "bob" || notification.Provider != "htpasswd" ||
+			notification.UserAgent != "curl" || notification.Allowed || !reflect.DeepEqual(notification.Anomalies, []Anomaly{NewDevice}) {
+			t.Errorf("unexpected notification %#v", notification)
+		}
+	case <-time.After(wait.ForeverTestTimeout):
+		t.Fatal("expected a notification")
+	}
+
+	// the denied device was not recorded
+	if err := login(handlers.WithAuthenticationMethods(bob, []string{"mfa", "pwd"}), "curl"); err != nil || delegate.succeeded != 2 {
+		t.Fatalf("expected login from a new device with mfa to succeed, got %v", err)
+	}
+	if notification := <-notifications; !notification.Allowed {
+		t.Errorf("expected allowed login, got %#v", notification)
+	}
+	if err := login(bob, "curl"); err != nil || delegate.succeeded != 3 {
+		t.Errorf("expected the device to be known after a login with mfa, got %v", err)
+	}
+
+	if err := login(&user.DefaultInfo{Name: "alice", UID: "alice-uid"}, "curl"); err == nil || delegate.succeeded != 3 {
+		t.Errorf("expected logins to fail closed if methods are required, got %v", err)
+	}
+}
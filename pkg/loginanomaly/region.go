@@ -0,0 +1,64 @@
+package loginanomaly
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// RegionResolver returns the coarse region of a client address, e.g. a country or a data center, or an empty
+// region if it is not known
+type RegionResolver interface {
+	Region(ip net.IP) (string, error)
+}
+
+// CIDRResolver resolves regions from a static list of networks
+type CIDRResolver struct {
+	networks []network
+}
+
+type network struct {
+	cidr   *net.IPNet
+	region string
+}
+
+var _ RegionResolver = &CIDRResolver{}
+
+// NewCIDRResolver parses lines of a network in CIDR notation and its region, e.g. "192.0.2.0/24 eu-west". Empty
+// lines and lines that start with # are ignored.
+func NewCIDRResolver(data []byte) (*CIDRResolver, error) {
+	resolver := &CIDRResolver{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if len(text) == 0 || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected a network and a region", line)
+		}
+		_, cidr, err := net.ParseCIDR(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		resolver.networks = append(resolver.networks, network{cidr: cidr, region: fields[1]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return resolver, nil
+}
+
+// Region returns the region of the most specific network that contains ip
+func (r *CIDRResolver) Region(ip net.IP) (string, error) {
+	region, longest := "", -1
+	for _, n := range r.networks {
+		if ones, _ := n.cidr.Mask.Size(); n.cidr.Contains(ip) && ones > longest {
+			region, longest = n.region, ones
+		}
+	}
+	return region, nil
+}
@@ -0,0 +1,90 @@
+package loginanomaly
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// NotificationKind is the kind of the notifications sent to the webhook
+const NotificationKind = "LoginAnomalyNotification"
+
+// DefaultTimeout is used if no timeout is configured for the webhook
+const DefaultTimeout = 10 * time.Second
+
+// Notification describes an anomalous login
+type Notification struct {
+	Kind      string           `json:"kind"`
+	User      NotificationUser `json:"user"`
+	Provider  string           `json:"provider"`
+	ClientIP  string           `json:"clientIP,omitempty"`
+	UserAgent string           `json:"userAgent,omitempty"`
+	Region    string           `json:"region,omitempty"`
+	Anomalies []Anomaly        `json:"anomalies"`
+	// Allowed is false if the login was rejected because it was not authenticated with the required methods
+	Allowed bool        `json:"allowed"`
+	Time    metav1.Time `json:"time"`
+}
+
+// NotificationUser is the user that logged in
+type NotificationUser struct {
+	Name string `json:"name"`
+	UID  string `json:"uid,omitempty"`
+}
+
+// Notifier is told about anomalous logins, e.g. to warn the user by mail
+type Notifier interface {
+	Notify(notification Notification) error
+}
+
+// Webhook POSTs notifications as JSON to a remote endpoint
+type Webhook struct {
+	url    string
+	client *http.Client
+}
+
+var _ Notifier = &Webhook{}
+
+// NewWebhook returns a webhook that POSTs notifications to url.
+// If no transport is provided, http.DefaultTransport is used. A timeout of 0 uses DefaultTimeout.
+func NewWebhook(url string, transport http.RoundTripper, timeout time.Duration) *Webhook {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	client := &http.Client{Transport: transport, Timeout: timeout}
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return errors.New("Redirect attempted")
+	}
+	return &Webhook{url: url, client: client}
+}
+
+// Notify sends the notification, any successful status is accepted
+func (w *Webhook) Notify(notification Notification) error {
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected response status %d", resp.StatusCode)
+	}
+	return nil
+}
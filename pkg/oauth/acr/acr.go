@@ -0,0 +1,264 @@
+// Package acr records how and when users authenticated as authentication method references (amr) and auth_time, and
+// lets clients require an authentication context class (acr) with the acr_values parameter and a recent login with
+// the max_age parameter of authorize requests (OpenID Connect Core 1.0, section 3.1.2.1). Users whose login does not
+// satisfy the request are asked to log in again.
+package acr
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/openshift/osin"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apiserver/pkg/authentication/authenticator"
+	"k8s.io/apiserver/pkg/authentication/user"
+
+	oauthapi "github.com/openshift/api/oauth/v1"
+	"github.com/openshift/oauth-server/pkg/oauth/handlers"
+)
+
+const (
+	// MethodPassword is the method of logins with a password
+	MethodPassword = "pwd"
+	// MethodMFA is the method of logins with more than one factor, e.g. at an identity provider that requires them
+	MethodMFA = "mfa"
+	// MethodPKI is the method of logins with a client certificate, e.g. a smart card checked by an authenticating proxy
+	MethodPKI = "pki"
+	// MethodSSO is the method of logins at an external identity provider
+	MethodSSO = "sso"
+
+	// ValuesParam is the parameter of authorize requests with the space separated classes the client requires, in
+	// order of preference
+	ValuesParam = "acr_values"
+	// MaxAgeParam is the parameter of authorize requests with the maximum number of seconds since the user logged in
+	MaxAgeParam = "max_age"
+
+	// maxAgeLeeway is added to max ages, so that the return to the authorize request after a login is not itself
+	// older than a max age of zero
+	maxAgeLeeway = 10 * time.Second
+
+	// idpParam and idpHintParam select identity providers, they are dropped when the user has to log in again
+	idpParam     = "idp"
+	idpHintParam = handlers.IdentityProviderHintParam
+)
+
+// Class is an authentication context class that clients can require
+type Class struct {
+	// Name is the value of the class in acr_values and in the acr of tokens
+	Name string
+	// Methods must all be among the methods of a login for the login to satisfy the class
+	Methods []string
+}
+
+// satisfiedBy returns whether logins with the methods satisfy the class
+func (c Class) satisfiedBy(methods sets.String) bool {
+	return methods.HasAll(c.Methods...)
+}
+
+// StepUp records the highest class the login of the user satisfies and the time of the login in the tokens of the
+// user, and asks users to log in again if their login is older than the max age or does not satisfy the class a
+// client requires. It implements osinserver.AuthorizeHandler and osinserver.InfoHandler.
+type StepUp struct {
+	classes         []Class
+	providerMethods map[string][]string
+	maxAges         map[string]time.Duration
+	handler         handlers.AuthenticationHandler
+}
+
+// NewStepUp returns a StepUp for the classes, which are ordered from the lowest to the highest. providerMethods are
+// the methods of the logins of the identity providers by name, handler asks for the login again.
+func NewStepUp(classes []Class, providerMethods map[string][]string, handler handlers.AuthenticationHandler) *StepUp {
+	return NewStepUpWithMaxAges(classes, providerMethods, nil, handler)
+}
+
+// NewStepUpWithMaxAges returns a StepUp like NewStepUp that applies the max ages by client name to the authorize
+// requests of clients without a max_age parameter
+func NewStepUpWithMaxAges(classes []Class, providerMethods map[string][]string, maxAges map[string]time.Duration, handler handlers.AuthenticationHandler) *StepUp {
+	return &StepUp{classes: classes, providerMethods: providerMethods, maxAges: maxAges, handler: handler}
+}
+
+// HandleAuthorize implements osinserver.AuthorizeHandler
+func (s *StepUp) HandleAuthorize(ar *osin.AuthorizeRequest, resp *osin.Response, w http.ResponseWriter) (bool, error) {
+	if !ar.Authorized || ar.HttpRequest == nil {
+		return false, nil
+	}
+	info, ok := ar.UserData.(user.Info)
+	if !ok {
+		return false, nil
+	}
+	methods := sets.NewString()
+	if authenticated, ok := info.(handlers.AuthenticationMethods); ok {
+		methods.Insert(authenticated.GetAuthenticationMethods()...)
+	}
+	// users authenticated by the request itself, e.g. with a challenge, logged in just now
+	authenticatedAt := time.Now()
+	if authenticated, ok := info.(handlers.AuthenticationTime); ok && !authenticated.GetAuthenticationTime().IsZero() {
+		authenticatedAt = authenticated.GetAuthenticationTime()
+	}
+	ar.UserData = handlers.WithAuthenticationTime(handlers.WithAuthenticationContextClass(info, s.highest(methods)), authenticatedAt)
+
+	maxAge, ok, err := s.maxAge(ar)
+	if err != nil {
+		ar.Authorized = false
+		setError(ar, resp, osin.E_INVALID_REQUEST, err.Error())
+		return false, nil
+	}
+	if ok && time.Since(authenticatedAt) > maxAge+maxAgeLeeway {
+		ar.Authorized = false
+		if silent(ar.HttpRequest) {
+			setError(ar, resp, "login_required", "")
+			return false, nil
+		}
+		// the user logs in again at the selected provider, which is asked for a fresh login as well
+		req := ar.HttpRequest.Clone(ar.HttpRequest.Context())
+		query := req.URL.Query()
+		query.Set(MaxAgeParam, strconv.FormatInt(int64(maxAge/time.Second), 10))
+		req.URL.RawQuery = query.Encode()
+		return s.handler.AuthenticationNeeded(ar.Client, w, req)
+	}
+
+	required := s.required(ar.HttpRequest)
+	if len(required) == 0 {
+		return false, nil
+	}
+	for _, class := range required {
+		if class.satisfiedBy(methods) {
+			return false, nil
+		}
+	}
+
+	ar.Authorized = false
+	providers := s.providers(required)
+	switch {
+	case silent(ar.HttpRequest):
+		setError(ar, resp, "login_required", "")
+		return false, nil
+	case len(providers) == 0 || respondsWithChallenges(ar.Client):
+		// challenges would only be answered with the same credentials again
+		setError(ar, resp, "access_denied", "the login does not satisfy the requested authentication context")
+		return false, nil
+	}
+
+	// the user logs in again, with one of the providers that satisfy the classes, and returns to the authorize request
+	req := ar.HttpRequest.Clone(ar.HttpRequest.Context())
+	query := req.URL.Query()
+	query.Del(idpParam)
+	query.Del(idpHintParam)
+	req.URL.RawQuery = query.Encode()
+	return s.handler.AuthenticationNeeded(ar.Client, w, handlers.WithIdentityProviders(req, providers))
+}
+
+// HandleInfo implements osinserver.InfoHandler
+func (s *StepUp) HandleInfo(ir *osin.InfoRequest, resp *osin.Response, r *http.Request) {
+	if methods, ok := ir.AccessData.UserData.(handlers.AuthenticationMethods); ok && len(methods.GetAuthenticationMethods()) > 0 {
+		resp.Output["amr"] = methods.GetAuthenticationMethods()
+	}
+	if contextClass, ok := ir.AccessData.UserData.(handlers.AuthenticationContextClass); ok && len(contextClass.GetAuthenticationContextClass()) > 0 {
+		resp.Output["acr"] = contextClass.GetAuthenticationContextClass()
+	}
+	if authenticated, ok := ir.AccessData.UserData.(handlers.AuthenticationTime); ok && !authenticated.GetAuthenticationTime().IsZero() {
+		resp.Output["auth_time"] = authenticated.GetAuthenticationTime().Unix()
+	}
+}
+
+// maxAge returns the max age of the login the authorize request requires, if any. The max_age parameter takes
+// precedence over the max age of the client.
+func (s *StepUp) maxAge(ar *osin.AuthorizeRequest) (time.Duration, bool, error) {
+	if value := ar.HttpRequest.FormValue(MaxAgeParam); len(value) > 0 {
+		seconds, err := strconv.ParseInt(value, 10, 32)
+		if err != nil || seconds < 0 {
+			return 0, false, fmt.Errorf("%s must be a non-negative number of seconds", MaxAgeParam)
+		}
+		return time.Duration(seconds) * time.Second, true, nil
+	}
+	if ar.Client == nil {
+		return 0, false, nil
+	}
+	maxAge, ok := s.maxAges[ar.Client.GetId()]
+	return maxAge, ok, nil
+}
+
+// highest returns the name of the highest class that logins with the methods satisfy
+func (s *StepUp) highest(methods sets.String) string {
+	for i := len(s.classes) - 1; i >= 0; i-- {
+		if s.classes[i].satisfiedBy(methods) {
+			return s.classes[i].Name
+		}
+	}
+	return ""
+}
+
+// required returns the known classes of the acr_values of req. Unknown values are ignored.
+func (s *StepUp) required(req *http.Request) []Class {
+	required := []Class{}
+	for _, name := range strings.Fields(req.FormValue(ValuesParam)) {
+		for _, class := range s.classes {
+			if class.Name == name {
+				required = append(required, class)
+			}
+		}
+	}
+	return required
+}
+
+// providers returns the names of the identity providers whose logins satisfy one of the classes
+func (s *StepUp) providers(classes []Class) []string {
+	providers := []string{}
+	for name, methods := range s.providerMethods {
+		for _, class := range classes {
+			if class.satisfiedBy(sets.NewString(methods...)) {
+				providers = append(providers, name)
+				break
+			}
+		}
+	}
+	return providers
+}
+
+func silent(req *http.Request) bool {
+	return sets.NewString(strings.Fields(req.FormValue(handlers.PromptParam))...).Has(handlers.PromptNone)
+}
+
+func respondsWithChallenges(client osin.Client) bool {
+	oauthClient, ok := client.GetUserData().(*oauthapi.OAuthClient)
+	return ok && oauthClient.RespondWithChallenges
+}
+
+func setError(ar *osin.AuthorizeRequest, resp *osin.Response, id, description string) {
+	resp.SetErrorState(id, description, ar.State)
+	if ar.Type == osin.TOKEN {
+		resp.SetRedirectFragment(true)
+	}
+}
+
+// NewMethodsSuccessHandler returns a success handler that records the methods in the user info of logins before
+// passing them to delegate, e.g. to the session
+func NewMethodsSuccessHandler(methods []string, delegate handlers.AuthenticationSuccessHandler) handlers.AuthenticationSuccessHandler {
+	return &methodsSuccessHandler{methods: methods, delegate: delegate}
+}
+
+type methodsSuccessHandler struct {
+	methods  []string
+	delegate handlers.AuthenticationSuccessHandler
+}
+
+func (h *methodsSuccessHandler) AuthenticationSucceeded(user user.Info, state string, w http.ResponseWriter, req *http.Request) (bool, error) {
+	return h.delegate.AuthenticationSucceeded(handlers.WithAuthenticationMethods(user, h.methods), state, w, req)
+}
+
+// NewMethodsRequest returns an authenticator that records the methods in the user info of the requests delegate
+// authenticates
+func NewMethodsRequest(methods []string, delegate authenticator.Request) authenticator.Request {
+	return authenticator.RequestFunc(func(req *http.Request) (*authenticator.Response, bool, error) {
+		response, ok, err := delegate.AuthenticateRequest(req)
+		if err != nil || !ok {
+			return response, ok, err
+		}
+		authenticated := *response
+		authenticated.User = handlers.WithAuthenticationMethods(response.User, methods)
+		return &authenticated, true, nil
+	})
+}
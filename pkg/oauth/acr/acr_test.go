@@ -0,0 +1,264 @@
+package acr
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/openshift/osin"
+	"k8s.io/apiserver/pkg/authentication/user"
+
+	oauthapi "github.com/openshift/api/oauth/v1"
+	"github.com/openshift/oauth-server/pkg/api"
+	"github.com/openshift/oauth-server/pkg/oauth/handlers"
+)
+
+type testClient struct {
+	osin.DefaultClient
+	client *oauthapi.OAuthClient
+}
+
+func (c *testClient) GetUserData() interface{} {
+	return c.client
+}
+
+// fakeAuthenticationHandler records the login it was asked for
+type fakeAuthenticationHandler struct {
+	req *http.Request
+}
+
+func (h *fakeAuthenticationHandler) AuthenticationNeeded(client api.Client, w http.ResponseWriter, req *http.Request) (bool, error) {
+	h.req = req
+	return true, nil
+}
+
+func TestStepUp(t *testing.T) {
+	classes := []Class{
+		{Name: "silver", Methods: []string{MethodPassword}},
+		{Name: "gold", Methods: []string{MethodMFA}},
+	}
+	providerMethods := map[string][]string{
+		"htpasswd": {MethodPassword},
+		"sso":      {MethodSSO, MethodMFA},
+		"proxy":    {MethodSSO, MethodPKI},
+	}
+
+	testCases := map[string]struct {
+		Methods    []string
+		Query      string
+		Challenges bool
+
+		ExpectedAuthorized   bool
+		ExpectedClass        string
+		ExpectedError        string
+		ExpectedProviders    []string
+		ExpectedAuthorizeURL string
+	}{
+		"no acr_values": {
+			Methods:            []string{MethodPassword},
+			ExpectedAuthorized: true,
+			ExpectedClass:      "silver",
+		},
+		"satisfied": {
+			Methods:            []string{MethodMFA, MethodSSO},
+			Query:              "acr_values=gold",
+			ExpectedAuthorized: true,
+			ExpectedClass:      "gold",
+		},
+		"one of the values satisfied": {
+			Methods:            []string{MethodPassword},
+			Query:              "acr_values=gold+silver",
+			ExpectedAuthorized: true,
+			ExpectedClass:      "silver",
+		},
+		"unknown values": {
+			Query:              "acr_values=platinum",
+			ExpectedAuthorized: true,
+		},
+		"step up": {
+			Methods:              []string{MethodPassword},
+			Query:                "acr_values=gold&idp=htpasswd",
+			ExpectedClass:        "silver",
+			ExpectedProviders:    []string{"sso"},
+			ExpectedAuthorizeURL: "/oauth/authorize?acr_values=gold&client_id=console",
+		},
+		"silent": {
+			Methods:       []string{MethodPassword},
+			Query:         "acr_values=gold&prompt=none",
+			ExpectedClass: "silver",
+			ExpectedError: "login_required",
+		},
+		"challenges": {
+			Methods:       []string{MethodPassword},
+			Query:         "acr_values=gold",
+			Challenges:    true,
+			ExpectedClass: "silver",
+			ExpectedError: "access_denied",
+		},
+	}
+
+	for k, testCase := range testCases {
+		t.Run(k, func(t *testing.T) {
+			handler := &fakeAuthenticationHandler{}
+			stepUp := NewStepUp(classes, providerMethods, handler)
+
+			req := httptest.NewRequest(http.MethodGet, "/oauth/authorize?client_id=console&"+testCase.Query, nil)
+			info := handlers.WithAuthenticationMethods(&user.DefaultInfo{Name: "bob"}, testCase.Methods)
+			ar := &osin.AuthorizeRequest{
+				Authorized:  true,
+				Client:      &testClient{client: &oauthapi.OAuthClient{RespondWithChallenges: testCase.Challenges}},
+				UserData:    handlers.WithUserAgent(info, "browser"),
+				HttpRequest: req,
+			}
+			resp := &osin.Response{Output: osin.ResponseData{}}
+
+			if _, err := stepUp.HandleAuthorize(ar, resp, httptest.NewRecorder()); err != nil {
+				t.Fatal(err)
+			}
+			if ar.Authorized != testCase.ExpectedAuthorized {
+				t.Errorf("expected authorized %v, got %v", testCase.ExpectedAuthorized, ar.Authorized)
+			}
+			if resp.ErrorId != testCase.ExpectedError {
+				t.Errorf("expected error %q, got %q", testCase.ExpectedError, resp.ErrorId)
+			}
+			contextClass := ""
+			if recorded, ok := ar.UserData.(handlers.AuthenticationContextClass); ok {
+				contextClass = recorded.GetAuthenticationContextClass()
+			}
+			if contextClass != testCase.ExpectedClass {
+				t.Errorf("expected class %q, got %q", testCase.ExpectedClass, contextClass)
+			}
+			if methods := ar.UserData.(handlers.AuthenticationMethods).GetAuthenticationMethods(); len(methods) != len(testCase.Methods) {
+				t.Errorf("expected the methods %v to be kept, got %v", testCase.Methods, methods)
+			}
+
+			if len(testCase.ExpectedProviders) == 0 {
+				if handler.req != nil {
+					t.Errorf("unexpected login")
+				}
+				return
+			}
+			if handler.req == nil {
+				t.Fatal("expected login")
+			}
+			if authorizeURL := handler.req.URL.String(); authorizeURL != testCase.ExpectedAuthorizeURL {
+				t.Errorf("expected login for %s, got %s", testCase.ExpectedAuthorizeURL, authorizeURL)
+			}
+			providers := stepUp.providers(stepUp.required(req))
+			sort.Strings(providers)
+			if !reflect.DeepEqual(providers, testCase.ExpectedProviders) {
+				t.Errorf("expected providers %v, got %v", testCase.ExpectedProviders, providers)
+			}
+		})
+	}
+}
+
+func TestMaxAge(t *testing.T) {
+	maxAges := map[string]time.Duration{"console": time.Hour}
+
+	testCases := map[string]struct {
+		Client          string
+		Query           string
+		AuthenticatedAt time.Time
+
+		ExpectedAuthorized   bool
+		ExpectedError        string
+		ExpectedAuthorizeURL string
+	}{
+		"recent login": {
+			Client:             "cli",
+			Query:              "max_age=300",
+			AuthenticatedAt:    time.Now().Add(-time.Minute),
+			ExpectedAuthorized: true,
+		},
+		"old login": {
+			Client:               "cli",
+			Query:                "idp=sso&max_age=300",
+			AuthenticatedAt:      time.Now().Add(-time.Hour),
+			ExpectedAuthorizeURL: "/oauth/authorize?client_id=cli&idp=sso&max_age=300",
+		},
+		"login of the request": {
+			Client:             "cli",
+			Query:              "max_age=0",
+			ExpectedAuthorized: true,
+		},
+		"max age of the client": {
+			Client:               "console",
+			AuthenticatedAt:      time.Now().Add(-2 * time.Hour),
+			ExpectedAuthorizeURL: "/oauth/authorize?client_id=console&max_age=3600",
+		},
+		"parameter overrides the client": {
+			Client:             "console",
+			Query:              "max_age=86400",
+			AuthenticatedAt:    time.Now().Add(-2 * time.Hour),
+			ExpectedAuthorized: true,
+		},
+		"silent": {
+			Client:          "console",
+			Query:           "prompt=none",
+			AuthenticatedAt: time.Now().Add(-2 * time.Hour),
+			ExpectedError:   "login_required",
+		},
+		"invalid": {
+			Client:          "cli",
+			Query:           "max_age=-1",
+			AuthenticatedAt: time.Now(),
+			ExpectedError:   osin.E_INVALID_REQUEST,
+		},
+	}
+
+	for k, testCase := range testCases {
+		t.Run(k, func(t *testing.T) {
+			handler := &fakeAuthenticationHandler{}
+			stepUp := NewStepUpWithMaxAges(nil, nil, maxAges, handler)
+
+			req := httptest.NewRequest(http.MethodGet, "/oauth/authorize?client_id="+testCase.Client+"&"+testCase.Query, nil)
+			ar := &osin.AuthorizeRequest{
+				Authorized:  true,
+				Client:      &testClient{DefaultClient: osin.DefaultClient{Id: testCase.Client}, client: &oauthapi.OAuthClient{}},
+				UserData:    handlers.WithAuthenticationTime(&user.DefaultInfo{Name: "bob"}, testCase.AuthenticatedAt),
+				HttpRequest: req,
+			}
+			resp := &osin.Response{Output: osin.ResponseData{}}
+
+			if _, err := stepUp.HandleAuthorize(ar, resp, httptest.NewRecorder()); err != nil {
+				t.Fatal(err)
+			}
+			if ar.Authorized != testCase.ExpectedAuthorized {
+				t.Errorf("expected authorized %v, got %v", testCase.ExpectedAuthorized, ar.Authorized)
+			}
+			if resp.ErrorId != testCase.ExpectedError {
+				t.Errorf("expected error %q, got %q", testCase.ExpectedError, resp.ErrorId)
+			}
+			if authenticatedAt := ar.UserData.(handlers.AuthenticationTime).GetAuthenticationTime(); authenticatedAt.IsZero() {
+				t.Errorf("expected the authentication time to be recorded")
+			}
+			authorizeURL := ""
+			if handler.req != nil {
+				authorizeURL = handler.req.URL.String()
+			}
+			if authorizeURL != testCase.ExpectedAuthorizeURL {
+				t.Errorf("expected login for %q, got %q", testCase.ExpectedAuthorizeURL, authorizeURL)
+			}
+		})
+	}
+}
+
+func TestHandleInfo(t *testing.T) {
+	info := handlers.WithAuthenticationContextClass(handlers.WithAuthenticationMethods(&user.DefaultInfo{Name: "bob"}, []string{MethodSSO, MethodMFA}), "gold")
+	info = handlers.WithAuthenticationTime(info, time.Unix(1700000000, 0))
+	resp := &osin.Response{Output: osin.ResponseData{}}
+	NewStepUp(nil, nil, nil).HandleInfo(&osin.InfoRequest{AccessData: &osin.AccessData{UserData: info}}, resp, nil)
+	if amr := resp.Output["amr"]; !reflect.DeepEqual(amr, []string{MethodMFA, MethodSSO}) {
+		t.Errorf("expected amr, got %v", amr)
+	}
+	if acr := resp.Output["acr"]; acr != "gold" {
+		t.Errorf("expected acr gold, got %v", acr)
+	}
+	if authTime := resp.Output["auth_time"]; authTime != int64(1700000000) {
+		t.Errorf("expected auth_time, got %v", authTime)
+	}
+}
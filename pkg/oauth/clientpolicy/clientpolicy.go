@@ -0,0 +1,65 @@
+// Package clientpolicy overrides the token settings of OAuth clients with the policies of the server
+// configuration. Everything that reads clients through the getter, such as the grant checks and the token
+// storage, enforces the policies.
+package clientpolicy
+
+import (
+	"context"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	oauthapi "github.com/openshift/api/oauth/v1"
+
+	"github.com/openshift/oauth-server/pkg/api"
+)
+
+// Policy overrides the token lifetimes and scope restrictions of a client
+type Policy struct {
+	// AccessTokenMaxAgeSeconds overrides the lifetime of access tokens, if it is set
+	AccessTokenMaxAgeSeconds *int32
+	// AccessTokenInactivityTimeoutSeconds overrides the inactivity timeout of access tokens, if it is set
+	AccessTokenInactivityTimeoutSeconds *int32
+	// ScopeRestrictions replace the scope restrictions of the client, if they are set
+	ScopeRestrictions []oauthapi.ScopeRestriction
+	// AllowAllScopes removes the scope restrictions of the client
+	AllowAllScopes bool
+}
+
+type getter struct {
+	delegate api.OAuthClientGetter
+	policies map[string]Policy
+}
+
+// NewOAuthClientGetter returns a getter of the clients of the delegate with the given policies applied, by client name
+func NewOAuthClientGetter(delegate api.OAuthClientGetter, policies map[string]Policy) api.OAuthClientGetter {
+	return &getter{delegate: delegate, policies: policies}
+}
+
+func (g *getter) Get(ctx context.Context, name string, options metav1.GetOptions) (*oauthapi.OAuthClient, error) {
+	client, err := g.delegate.Get(ctx, name, options)
+	if err != nil {
+		return nil, err
+	}
+	policy, ok := g.policies[name]
+	if !ok {
+		return client, nil
+	}
+
+	// the delegate may return cached clients
+	client = client.DeepCopy()
+	if policy.AccessTokenMaxAgeSeconds != nil {
+		maxAge := *policy.AccessTokenMaxAgeSeconds
+		client.AccessTokenMaxAgeSeconds = &maxAge
+	}
+	if policy.AccessTokenInactivityTimeoutSeconds != nil {
+		timeout := *policy.AccessTokenInactivityTimeoutSeconds
+		client.AccessTokenInactivityTimeoutSeconds = &timeout
+	}
+	switch {
+	case policy.AllowAllScopes:
+		client.ScopeRestrictions = nil
+	case len(policy.ScopeRestrictions) > 0:
+		client.ScopeRestrictions = policy.ScopeRestrictions
+	}
+	return client, nil
+}
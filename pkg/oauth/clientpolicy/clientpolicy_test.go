@@ -0,0 +1,69 @@
+package clientpolicy
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	oauthapi "github.com/openshift/api/oauth/v1"
+	scopemetadata "github.com/openshift/library-go/pkg/authorization/scopemetadata"
+)
+
+type testGetter map[string]*oauthapi.OAuthClient
+
+func (g testGetter) Get(ctx context.Context, name string, options metav1.GetOptions) (*oauthapi.OAuthClient, error) {
+	return g[name], nil
+}
+
+func int32Ptr(i int32) *int32 {
+	return &i
+}
+
+func TestGetter(t *testing.T) {
+	restricted := []oauthapi.ScopeRestriction{{ExactValues: []string{"user:info"}}}
+	delegate := testGetter{}
+	for _, name := range []string{"short", "expanded", "restricted", "plain"} {
+		delegate[name] = &oauthapi.OAuthClient{
+			ObjectMeta:                          metav1.ObjectMeta{Name: name},
+			AccessTokenMaxAgeSeconds:            int32Ptr(86400),
+			AccessTokenInactivityTimeoutSeconds: int32Ptr(600),
+			ScopeRestrictions:                   restricted,
+		}
+	}
+	getter := NewOAuthClientGetter(delegate, map[string]Policy{
+		"short":      {AccessTokenMaxAgeSeconds: int32Ptr(3600), AccessTokenInactivityTimeoutSeconds: int32Ptr(0)},
+		"expanded":   {AllowAllScopes: true},
+		"restricted": {ScopeRestrictions: []oauthapi.ScopeRestriction{{ExactValues: []string{"user:check-access"}}}},
+	})
+
+	client, err := getter.Get(context.TODO(), "short", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *client.AccessTokenMaxAgeSeconds != 3600 || *client.AccessTokenInactivityTimeoutSeconds != 0 || !reflect.DeepEqual(client.ScopeRestrictions, restricted) {
+		t.Errorf("unexpected client %#v", client)
+	}
+	if *delegate["short"].AccessTokenMaxAgeSeconds != 86400 {
+		t.Errorf("expected the client of the delegate to be unchanged")
+	}
+
+	client, _ = getter.Get(context.TODO(), "expanded", metav1.GetOptions{})
+	if err := scopemetadata.ValidateScopeRestrictions(client, "user:full"); err != nil {
+		t.Errorf("expected all scopes to be allowed, got %v", err)
+	}
+
+	client, _ = getter.Get(context.TODO(), "restricted", metav1.GetOptions{})
+	if err := scopemetadata.ValidateScopeRestrictions(client, "user:info"); err == nil {
+		t.Errorf("expected the restrictions of the client to be replaced")
+	}
+	if err := scopemetadata.ValidateScopeRestrictions(client, "user:check-access"); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	client, _ = getter.Get(context.TODO(), "plain", metav1.GetOptions{})
+	if client != delegate["plain"] {
+		t.Errorf("expected clients without policy to be returned as is")
+	}
+}
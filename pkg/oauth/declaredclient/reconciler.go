@@ -0,0 +1,153 @@
+// Package declaredclient reconciles the OAuthClients that are defined in the configuration of the server with the
+// OAuthClients of the API, for environments where the API is not the source of truth for clients.
+package declaredclient
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+
+	oauthapi "github.com/openshift/api/oauth/v1"
+	oauthclient "github.com/openshift/client-go/oauth/clientset/versioned/typed/oauth/v1"
+)
+
+const (
+	// DeclaredLabel marks the OAuthClients that were created from the configuration, only they are updated and
+	// deleted by the reconciler
+	DeclaredLabel = "oauth.openshift.io/declared"
+
+	// DefaultRetryInterval is the time between attempts until the first reconciliation succeeded, e.g. while the
+	// API is not reachable yet
+	DefaultRetryInterval = 10 * time.Second
+	// DefaultResyncInterval is the time between reconciliations that revert changes made through the API
+	DefaultResyncInterval = 10 * time.Minute
+
+	// jitterFactor spreads the reconciliations of several replicas
+	jitterFactor = 0.2
+)
+
+// Reconciler creates, updates and deletes the OAuthClients that carry DeclaredLabel so that they match the defined
+// clients
+type Reconciler struct {
+	clients oauthclient.OAuthClientInterface
+	defined []oauthapi.OAuthClient
+}
+
+// NewReconciler returns a Reconciler of the defined clients, which must have unique names
+func NewReconciler(clients oauthclient.OAuthClientInterface, defined []oauthapi.OAuthClient) *Reconciler {
+	return &Reconciler{clients: clients, defined: defined}
+}
+
+// Run reconciles the clients until stopCh is closed, every DefaultRetryInterval until it succeeded once and every
+// jittered DefaultResyncInterval after that
+func (r *Reconciler) Run(stopCh <-chan struct{}) {
+	defer utilruntime.HandleCrash()
+	err := wait.PollImmediateUntil(DefaultRetryInterval, func() (bool, error) {
+		if err := r.Reconcile(); err != nil {
+			utilruntime.HandleError(fmt.Errorf("error reconciling the defined OAuth clients: %v", err))
+			return false, nil
+		}
+		return true, nil
+	}, stopCh)
+	if err != nil {
+		return
+	}
+	wait.JitterUntil(func() {
+		if err := r.Reconcile(); err != nil {
+			utilruntime.HandleError(fmt.Errorf("error reconciling the defined OAuth clients: %v", err))
+		}
+	}, DefaultResyncInterval, jitterFactor, false, stopCh)
+}
+
+// Reconcile creates the defined clients that are missing, updates the ones that differ from their definition and
+// deletes the ones that are no longer defined. OAuthClients without DeclaredLabel are never changed, the
+// definitions of clients that exist without it are skipped.
+func (r *Reconciler) Reconcile() error {
+	existing, err := r.clients.List(context.TODO(), metav1.ListOptions{LabelSelector: DeclaredLabel + "=true"})
+	if err != nil {
+		return err
+	}
+	declared := map[string]*oauthapi.OAuthClient{}
+	for i := range existing.Items {
+		declared[existing.Items[i].Name] = &existing.Items[i]
+	}
+
+	errs := []error{}
+	defined := sets.NewString()
+	for i := range r.defined {
+		desired := &r.defined[i]
+		defined.Insert(desired.Name)
+
+		current, ok := declared[desired.Name]
+		if !ok {
+			client := desired.DeepCopy()
+			if client.Labels == nil {
+				client.Labels = map[string]string{}
+			}
+			client.Labels[DeclaredLabel] = "true"
+			_, err := r.clients.Create(context.TODO(), client, metav1.CreateOptions{})
+			switch {
+			case kerrors.IsAlreadyExists(err):
+				klog.Warningf("OAuth client %q was not created from the configuration, its definition is ignored", desired.Name)
+			case err != nil:
+				errs = append(errs, fmt.Errorf("unable to create OAuth client %q: %v", desired.Name, err))
+			default:
+				klog.Infof("Created the defined OAuth client %q", desired.Name)
+			}
+			continue
+		}
+
+		if matches(current, desired) {
+			continue
+		}
+		// the metadata, e.g. other labels, is kept
+		updated := current.DeepCopy()
+		updated.Secret = desired.Secret
+		updated.AdditionalSecrets = desired.AdditionalSecrets
+		updated.RespondWithChallenges = desired.RespondWithChallenges
+		updated.RedirectURIs = desired.RedirectURIs
+		updated.GrantMethod = desired.GrantMethod
+		updated.ScopeRestrictions = desired.ScopeRestrictions
+		updated.AccessTokenMaxAgeSeconds = desired.AccessTokenMaxAgeSeconds
+		updated.AccessTokenInactivityTimeoutSeconds = desired.AccessTokenInactivityTimeoutSeconds
+		if _, err := r.clients.Update(context.TODO(), updated, metav1.UpdateOptions{}); err != nil {
+			errs = append(errs, fmt.Errorf("unable to update OAuth client %q: %v", desired.Name, err))
+			continue
+		}
+		klog.Infof("Updated the defined OAuth client %q", desired.Name)
+	}
+
+	for name := range declared {
+		if defined.Has(name) {
+			continue
+		}
+		if err := r.clients.Delete(context.TODO(), name, metav1.DeleteOptions{}); err != nil && !kerrors.IsNotFound(err) {
+			errs = append(errs, fmt.Errorf("unable to delete OAuth client %q: %v", name, err))
+			continue
+		}
+		klog.Infof("Deleted the OAuth client %q that is no longer defined", name)
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// matches returns true if current has the settings of desired
+func matches(current, desired *oauthapi.OAuthClient) bool {
+	return current.Secret == desired.Secret &&
+		len(current.AdditionalSecrets) == len(desired.AdditionalSecrets) &&
+		(len(desired.AdditionalSecrets) == 0 || reflect.DeepEqual(current.AdditionalSecrets, desired.AdditionalSecrets)) &&
+		current.RespondWithChallenges == desired.RespondWithChallenges &&
+		current.GrantMethod == desired.GrantMethod &&
+		reflect.DeepEqual(current.RedirectURIs, desired.RedirectURIs) &&
+		reflect.DeepEqual(current.ScopeRestrictions, desired.ScopeRestrictions) &&
+		reflect.DeepEqual(current.AccessTokenMaxAgeSeconds, desired.AccessTokenMaxAgeSeconds) &&
+		reflect.DeepEqual(current.AccessTokenInactivityTimeoutSeconds, desired.AccessTokenInactivityTimeoutSeconds)
+}
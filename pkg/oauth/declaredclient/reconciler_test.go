@@ -0,0 +1,83 @@
+package declaredclient
+
+import (
+	"context"
+	"testing"
+
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	oauthapi "github.com/openshift/api/oauth/v1"
+	oauthfake "github.com/openshift/client-go/oauth/clientset/versioned/fake"
+)
+
+func definedClient(name, secret string, redirectURIs ...string) oauthapi.OAuthClient {
+	return oauthapi.OAuthClient{
+		ObjectMeta:   metav1.ObjectMeta{Name: name},
+		Secret:       secret,
+		RedirectURIs: redirectURIs,
+		GrantMethod:  oauthapi.GrantHandlerAuto,
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	fakeClient := oauthfake.NewSimpleClientset(
+		// created through the API
+		&oauthapi.OAuthClient{ObjectMeta: metav1.ObjectMeta{Name: "console"}, Secret: "api"},
+		// defined before, and no longer
+		&oauthapi.OAuthClient{ObjectMeta: metav1.ObjectMeta{Name: "removed", Labels: map[string]string{DeclaredLabel: "true"}}},
+		// defined before with another secret and redirect URI
+		&oauthapi.OAuthClient{ObjectMeta: metav1.ObjectMeta{Name: "changed", Labels: map[string]string{DeclaredLabel: "true", "team": "a"}}, Secret: "old", RedirectURIs: []string{"https://old.example.com"}},
+	)
+	clients := fakeClient.OauthV1().OAuthClients()
+	reconciler := NewReconciler(clients, []oauthapi.OAuthClient{
+		definedClient("new", "secret", "https://new.example.com"),
+		definedClient("changed", "new", "https://changed.example.com"),
+		definedClient("console", "defined", "https://console.example.com"),
+	})
+	if err := reconciler.Reconcile(); err != nil {
+		t.Fatal(err)
+	}
+
+	created, err := clients.Get(context.TODO(), "new", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected the new client to be created: %v", err)
+	}
+	if created.Secret != "secret" || created.Labels[DeclaredLabel] != "true" {
+		t.Errorf("expected the new client to have its secret and the declared label, got %#v", created)
+	}
+
+	changed, err := clients.Get(context.TODO(), "changed", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed.Secret != "new" || len(changed.RedirectURIs) != 1 || changed.RedirectURIs[0] != "https://changed.example.com" {
+		t.Errorf("expected the changed client to be updated, got %#v", changed)
+	}
+	if changed.Labels["team"] != "a" {
+		t.Errorf("expected the labels of the changed client to be kept, got %v", changed.Labels)
+	}
+
+	if _, err := clients.Get(context.TODO(), "removed", metav1.GetOptions{}); !kerrors.IsNotFound(err) {
+		t.Errorf("expected the removed client to be deleted, got %v", err)
+	}
+
+	console, err := clients.Get(context.TODO(), "console", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if console.Secret != "api" {
+		t.Errorf("expected the client of the API to be left alone, got secret %q", console.Secret)
+	}
+
+	// nothing changes once the clients match their definitions
+	fakeClient.ClearActions()
+	if err := reconciler.Reconcile(); err != nil {
+		t.Fatal(err)
+	}
+	for _, action := range fakeClient.Actions() {
+		if action.GetVerb() != "list" && action.GetVerb() != "create" {
+			t.Errorf("expected no changes to reconciled clients, got %s of %s", action.GetVerb(), action.GetResource().Resource)
+		}
+	}
+}
@@ -0,0 +1,387 @@
+// Package device lets tools on devices without a browser obtain tokens with the device authorization grant
+// (RFC 8628). Tools ask for a device code and a short user code, which users enter at a verification page in
+// any browser. The page sends them through the authorize endpoint of the device's client, so that they log in
+// with whichever identity provider is configured, and keeps the issued authorize code until the tool redeems
+// its device code at the token endpoint.
+package device
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/openshift/osin"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+
+	"github.com/openshift/library-go/pkg/oauth/oauthdiscovery"
+
+	oauthserver "github.com/openshift/oauth-server/pkg"
+	"github.com/openshift/oauth-server/pkg/api"
+	"github.com/openshift/oauth-server/pkg/oauth/tokenformat"
+	"github.com/openshift/oauth-server/pkg/osinserver"
+	"github.com/openshift/oauth-server/pkg/server/csrf"
+	"github.com/openshift/oauth-server/pkg/server/session"
+)
+
+const (
+	// GrantType redeems device codes at the token endpoint
+	GrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+	// AuthorizationPath is where tools ask for device and user codes, VerificationPath is the page where users
+	// enter user codes and CallbackPath receives the authorize codes, all relative to the OAuth API prefix
+	AuthorizationPath = "/device_authorization"
+	VerificationPath  = "/device"
+	CallbackPath      = "/device/callback"
+
+	// DefaultLifetime is how long users have to enter a user code
+	DefaultLifetime = 10 * time.Minute
+	// DefaultInterval is the minimum interval between the token requests of a tool
+	DefaultInterval = 5 * time.Second
+
+	errorAuthorizationPending = "authorization_pending"
+	errorSlowDown             = "slow_down"
+	errorExpiredToken         = "expired_token"
+
+	deviceCodeParam = "device_code"
+	userCodeParam   = "user_code"
+	csrfParam       = "csrf"
+
+	// userCodeAlphabet has no vowels, so that user codes do not spell words, and no characters that are
+	// easily confused (RFC 8628 section 6.1)
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+	userCodeLength   = 8
+)
+
+// pendingRequest is a device authorization request that is waiting for the user, or for the tool to redeem
+// the authorize code issued to it
+type pendingRequest struct {
+	ClientID string `json:"clientID"`
+	Scope    string `json:"scope,omitempty"`
+	UserCode string `json:"userCode"`
+	// CodeVerifier binds the authorize code to this request, nobody else can redeem it
+	CodeVerifier string    `json:"codeVerifier"`
+	Expires      time.Time `json:"expires"`
+	LastPoll     time.Time `json:"lastPoll"`
+	// Code is the authorize code once the user approved the request, and Denied is set if the user declined it
+	Code   string `json:"code,omitempty"`
+	Denied bool   `json:"denied,omitempty"`
+}
+
+// Flow serves the device authorization and verification endpoints and redeems device codes at the token
+// endpoint. It implements osinserver.GrantDecoder.
+type Flow struct {
+	backend  session.Backend
+	clients  api.OAuthClientGetter
+	allowed  sets.String
+	issuer   string
+	lifetime time.Duration
+	interval time.Duration
+	csrf     csrf.CSRF
+	now      func() time.Time
+}
+
+// NewFlow returns the device authorization flow of the allowed clients, whose pending requests are kept in the
+// backend. The issuer is the public URL of the server, the redirect URIs of the clients must include its
+// CallbackURL. Zero durations use the defaults.
+func NewFlow(backend session.Backend, clients api.OAuthClientGetter, allowed []string, issuer string, lifetime, interval time.Duration, csrf csrf.CSRF) *Flow {
+	if lifetime <= 0 {
+		lifetime = DefaultLifetime
+	}
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	return &Flow{
+		backend:  backend,
+		clients:  clients,
+		allowed:  sets.NewString(allowed...),
+		issuer:   strings.TrimRight(issuer, "/"),
+		lifetime: lifetime,
+		interval: interval,
+		csrf:     csrf,
+		now:      time.Now,
+	}
+}
+
+// CallbackURL returns the redirect URI that the clients of the flow must allow
+func CallbackURL(issuer string) string {
+	return strings.TrimRight(issuer, "/") + path.Join(oauthdiscovery.OpenShiftOAuthAPIPrefix, CallbackPath)
+}
+
+func (f *Flow) Install(mux oauthserver.Mux, prefix string) {
+	mux.HandleFunc(path.Join(prefix, AuthorizationPath), f.authorizeDevice)
+	mux.HandleFunc(path.Join(prefix, VerificationPath), f.verify)
+	mux.HandleFunc(path.Join(prefix, CallbackPath), f.callback)
+}
+
+// authorizationResponse is the response of the device authorization endpoint (RFC 8628 section 3.2)
+type authorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int64  `json:"interval"`
+}
+
+type errorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// authorizeDevice issues a device code and a user code to a client
+func (f *Flow) authorizeDevice(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, errorResponse{Error: osin.E_INVALID_REQUEST, ErrorDescription: "device authorization requests must be posted"})
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: osin.E_INVALID_REQUEST, ErrorDescription: "invalid form"})
+		return
+	}
+
+	clientID := requestClientID(r)
+	if err := f.checkClient(r.Context(), clientID); err != nil {
+		klog.V(4).Infof("device authorization of client %q rejected: %v", clientID, err)
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: osin.E_UNAUTHORIZED_CLIENT, ErrorDescription: "the client may not use the device authorization grant"})
+		return
+	}
+
+	deviceCode := tokenformat.RandomToken()
+	userCode, err := randomUserCode()
+	if err != nil {
+		f.serverError(w, err)
+		return
+	}
+	pending := &pendingRequest{
+		ClientID:     clientID,
+		Scope:        r.Form.Get("scope"),
+		UserCode:     userCode,
+		CodeVerifier: tokenformat.RandomToken(),
+		Expires:      f.now().Add(f.lifetime),
+	}
+	key := deviceKey(deviceCode)
+	if err := f.save(key, pending); err != nil {
+		f.serverError(w, err)
+		return
+	}
+	if err := f.backend.Set(userCodeKey(userCode), []byte(key), f.lifetime); err != nil {
+		f.serverError(w, err)
+		return
+	}
+
+	verificationURI := f.issuer + path.Join(oauthdiscovery.OpenShiftOAuthAPIPrefix, VerificationPath)
+	writeJSON(w, http.StatusOK, authorizationResponse{
+		DeviceCode:              deviceCode,
+		UserCode:                formatUserCode(userCode),
+		VerificationURI:         verificationURI,
+		VerificationURIComplete: verificationURI + "?" + url.Values{userCodeParam: {formatUserCode(userCode)}}.Encode(),
+		ExpiresIn:               int64(f.lifetime / time.Second),
+		Interval:                int64(f.interval / time.Second),
+	})
+}
+
+// checkClient returns an error unless the client may use the device authorization grant
+func (f *Flow) checkClient(ctx context.Context, clientID string) error {
+	if !f.allowed.Has(clientID) {
+		return fmt.Errorf("the client is not allowed")
+	}
+	client, err := f.clients.Get(ctx, clientID, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if err := osin.ValidateUriList(strings.Join(client.RedirectURIs, ","), CallbackURL(f.issuer), ","); err != nil {
+		return fmt.Errorf("the redirect URIs of the client do not include %s", CallbackURL(f.issuer))
+	}
+	return nil
+}
+
+// DecodeAccessRequest turns the token requests of tools that redeem a device code into requests for the
+// authorize code that was issued once the user approved the device
+func (f *Flow) DecodeAccessRequest(r *http.Request) *osinserver.AccessError {
+	if err := r.ParseForm(); err != nil || r.Form.Get("grant_type") != GrantType {
+		// invalid requests are rejected when they are parsed again
+		return nil
+	}
+	deviceCode := r.Form.Get(deviceCodeParam)
+	if len(deviceCode) == 0 {
+		return &osinserver.AccessError{Code: osin.E_INVALID_REQUEST, Description: "the device code is missing"}
+	}
+
+	key := deviceKey(deviceCode)
+	pending, err := f.load(key)
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("error loading device authorization request: %v", err))
+		return &osinserver.AccessError{Code: osin.E_SERVER_ERROR, Description: "the device authorization request could not be loaded"}
+	}
+	if pending == nil {
+		return &osinserver.AccessError{Code: errorExpiredToken, Description: "the device code is invalid or expired"}
+	}
+	if clientID := requestClientID(r); len(clientID) > 0 && clientID != pending.ClientID {
+		return &osinserver.AccessError{Code: osin.E_INVALID_GRANT, Description: "the device code was issued to another client"}
+	}
+
+	switch {
+	case pending.Denied:
+		f.delete(key, pending)
+		return &osinserver.AccessError{Code: osin.E_ACCESS_DENIED, Description: "the user denied the device authorization request"}
+	case len(pending.Code) == 0:
+		now := f.now()
+		slowDown := now.Sub(pending.LastPoll) < f.interval
+		pending.LastPoll = now
+		if err := f.save(key, pending); err != nil {
+			utilruntime.HandleError(fmt.Errorf("error saving device authorization request: %v", err))
+		}
+		if slowDown {
+			return &osinserver.AccessError{Code: errorSlowDown, Description: "the device polls too often"}
+		}
+		return &osinserver.AccessError{Code: errorAuthorizationPending, Description: "the user has not approved the device yet"}
+	}
+
+	// the authorize code can only be redeemed once
+	f.delete(key, pending)
+	r.Form.Del(deviceCodeParam)
+	r.Form.Set("grant_type", string(osin.AUTHORIZATION_CODE))
+	r.Form.Set("code", pending.Code)
+	r.Form.Set("redirect_uri", CallbackURL(f.issuer))
+	r.Form.Set("code_verifier", pending.CodeVerifier)
+	// tools are usually public clients that only send their ID, clients with secrets still have to authenticate
+	if _, hasSecret := r.Form["client_secret"]; !hasSecret && len(r.Header.Get("Authorization")) == 0 {
+		r.Form.Set("client_id", pending.ClientID)
+		r.Form.Set("client_secret", "")
+	}
+	return nil
+}
+
+// load returns the pending request stored under key, or nil if there is none
+func (f *Flow) load(key string) (*pendingRequest, error) {
+	data, err := f.backend.Get(key)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	pending := &pendingRequest{}
+	if err := json.Unmarshal(data, pending); err != nil {
+		return nil, err
+	}
+	if !f.now().Before(pending.Expires) {
+		return nil, nil
+	}
+	return pending, nil
+}
+
+// loadUserCode returns the key and the pending request of a user code, or nil if there is none
+func (f *Flow) loadUserCode(userCode string) (string, *pendingRequest, error) {
+	key, err := f.backend.Get(userCodeKey(userCode))
+	if err != nil || key == nil {
+		return "", nil, err
+	}
+	pending, err := f.load(string(key))
+	return string(key), pending, err
+}
+
+func (f *Flow) save(key string, pending *pendingRequest) error {
+	data, err := json.Marshal(pending)
+	if err != nil {
+		return err
+	}
+	ttl := pending.Expires.Sub(f.now())
+	if ttl <= 0 {
+		return f.backend.Delete(key)
+	}
+	return f.backend.Set(key, data, ttl)
+}
+
+func (f *Flow) delete(key string, pending *pendingRequest) {
+	for _, k := range []string{key, userCodeKey(pending.UserCode)} {
+		if err := f.backend.Delete(k); err != nil {
+			utilruntime.HandleError(fmt.Errorf("error deleting device authorization request: %v", err))
+		}
+	}
+}
+
+func (f *Flow) serverError(w http.ResponseWriter, err error) {
+	utilruntime.HandleError(fmt.Errorf("error handling device authorization request: %v", err))
+	writeJSON(w, http.StatusInternalServerError, errorResponse{Error: osin.E_SERVER_ERROR})
+}
+
+// requestClientID returns the client ID of the parameters or of the basic authentication of the request
+func requestClientID(r *http.Request) string {
+	if clientID := r.Form.Get("client_id"); len(clientID) > 0 {
+		return clientID
+	}
+	if clientID, _, ok := r.BasicAuth(); ok {
+		if unescaped, err := url.QueryUnescape(clientID); err == nil {
+			return unescaped
+		}
+	}
+	return ""
+}
+
+// deviceKey is the key of the pending request of a device code. Like sessions, requests are keyed by the
+// hash of their device code so that the contents of the backend cannot be redeemed.
+func deviceKey(deviceCode string) string {
+	hash := sha256.Sum256([]byte(deviceCode))
+	return "device~" + base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+// userCodeKey is the key of the device key of a normalized user code
+func userCodeKey(userCode string) string {
+	return "usercode~" + userCode
+}
+
+func randomUserCode() (string, error) {
+	code := make([]byte, userCodeLength)
+	max := big.NewInt(int64(len(userCodeAlphabet)))
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = userCodeAlphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// formatUserCode splits a normalized user code into halves to make it easier to read
+func formatUserCode(userCode string) string {
+	half := len(userCode) / 2
+	return userCode[:half] + "-" + userCode[half:]
+}
+
+// normalizeUserCode drops the separators and spaces users may enter and ignores the case of user codes
+func normalizeUserCode(userCode string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z':
+			return r
+		default:
+			return -1
+		}
+	}, userCode)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(data)
+}
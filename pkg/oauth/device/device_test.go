@@ -0,0 +1,200 @@
+package device
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openshift/osin"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	oauthapi "github.com/openshift/api/oauth/v1"
+
+	"github.com/openshift/oauth-server/pkg/osinserver"
+	"github.com/openshift/oauth-server/pkg/osinserver/teststorage"
+	"github.com/openshift/oauth-server/pkg/server/csrf"
+	"github.com/openshift/oauth-server/pkg/server/session"
+)
+
+const issuer = "https://oauth.example.com"
+
+type testClients map[string]*oauthapi.OAuthClient
+
+func (c testClients) Get(ctx context.Context, name string, options metav1.GetOptions) (*oauthapi.OAuthClient, error) {
+	if client, ok := c[name]; ok {
+		return client, nil
+	}
+	return nil, kerrors.NewNotFound(schema.GroupResource{Group: "oauth.openshift.io", Resource: "oauthclients"}, name)
+}
+
+func TestDeviceFlow(t *testing.T) {
+	storage := teststorage.New()
+	storage.Clients["cli"] = &osin.DefaultClient{Id: "cli", RedirectUri: CallbackURL(issuer)}
+	clients := testClients{
+		"cli":     {ObjectMeta: metav1.ObjectMeta{Name: "cli"}, RedirectURIs: []string{"https://localhost/callback", CallbackURL(issuer)}},
+		"other":   {ObjectMeta: metav1.ObjectMeta{Name: "other"}, RedirectURIs: []string{"https://localhost/callback"}},
+		"browser": {ObjectMeta: metav1.ObjectMeta{Name: "browser"}, RedirectURIs: []string{CallbackURL(issuer)}},
+	}
+
+	now := time.Now()
+	fakeCSRF := &csrf.FakeCSRF{Token: "csrf-token"}
+	flow := NewFlow(session.NewMemoryBackend(), clients, []string{"cli", "other"}, issuer, 0, 0, fakeCSRF)
+	flow.now = func() time.Time { return now }
+
+	denyNext := false
+	server := osinserver.New(
+		osinserver.NewDefaultServerConfig(),
+		storage,
+		osinserver.AuthorizeHandlerFunc(func(ar *osin.AuthorizeRequest, resp *osin.Response, w http.ResponseWriter) (bool, error) {
+			ar.Authorized = !denyNext
+			return false, nil
+		}),
+		osinserver.AccessHandlerFunc(func(ar *osin.AccessRequest, w http.ResponseWriter) error {
+			ar.Authorized = true
+			ar.GenerateRefresh = false
+			return nil
+		}),
+		osinserver.NewDefaultErrorHandler(),
+		nil,
+		nil,
+		nil,
+		nil,
+		flow,
+		nil,
+	)
+	mux := http.NewServeMux()
+	server.Install(mux, "/oauth")
+	flow.Install(mux, "/oauth")
+
+	do := func(method, target string, form url.Values) *httptest.ResponseRecorder {
+		t.Helper()
+		var req *http.Request
+		if method == http.MethodPost {
+			req = httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			req = httptest.NewRequest(method, target, nil)
+		}
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		return resp
+	}
+	authorizeDevice := func(clientID string) (int, authorizationResponse) {
+		t.Helper()
+		resp := do(http.MethodPost, issuer+"/oauth/device_authorization", url.Values{"client_id": {clientID}, "scope": {"user:info"}})
+		result := authorizationResponse{}
+		if resp.Code == http.StatusOK {
+			if err := json.Unmarshal(resp.Body.Bytes(), &result); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return resp.Code, result
+	}
+	poll := func(deviceCode string) map[string]interface{} {
+		t.Helper()
+		resp := do(http.MethodPost, issuer+"/oauth/token", url.Values{"grant_type": {GrantType}, "client_id": {"cli"}, "device_code": {deviceCode}})
+		result := map[string]interface{}{}
+		if err := json.Unmarshal(resp.Body.Bytes(), &result); err != nil {
+			t.Fatalf("unexpected token response %d: %s", resp.Code, resp.Body.String())
+		}
+		return result
+	}
+	// approve enters the user code at the verification page and follows the redirects through the authorize endpoint
+	approve := func(userCode string) *httptest.ResponseRecorder {
+		t.Helper()
+		resp := do(http.MethodPost, issuer+"/oauth/device", url.Values{"user_code": {userCode}, "csrf": {"csrf-token"}})
+		if resp.Code != http.StatusFound {
+			t.Fatalf("expected redirect to the authorize endpoint, got %d: %s", resp.Code, resp.Body.String())
+		}
+		authorizeURL, _ := url.Parse(resp.Header().Get("Location"))
+		if authorizeURL.Path != "/oauth/authorize" || authorizeURL.Query().Get("client_id") != "cli" || authorizeURL.Query().Get("scope") != "user:info" || authorizeURL.Query().Get("code_challenge_method") != osin.PKCE_S256 {
+			t.Fatalf("unexpected authorize URL %s", authorizeURL)
+		}
+		resp = do(http.MethodGet, authorizeURL.String(), nil)
+		if resp.Code != http.StatusFound || !strings.HasPrefix(resp.Header().Get("Location"), CallbackURL(issuer)+"?") {
+			t.Fatalf("expected redirect to the callback, got %d: %s", resp.Code, resp.Header().Get("Location"))
+		}
+		return do(http.MethodGet, resp.Header().Get("Location"), nil)
+	}
+
+	if code, _ := authorizeDevice("browser"); code != http.StatusBadRequest {
+		t.Errorf("expected clients that are not allowed to be rejected, got %d", code)
+	}
+	if code, _ := authorizeDevice("other"); code != http.StatusBadRequest {
+		t.Errorf("expected clients without the callback redirect URI to be rejected, got %d", code)
+	}
+
+	code, device := authorizeDevice("cli")
+	if code != http.StatusOK {
+		t.Fatalf("expected device authorization, got %d", code)
+	}
+	if len(device.UserCode) != userCodeLength+1 || device.ExpiresIn != int64(DefaultLifetime/time.Second) || device.Interval != int64(DefaultInterval/time.Second) {
+		t.Errorf("unexpected device authorization %#v", device)
+	}
+	if device.VerificationURI != issuer+"/oauth/device" || !strings.HasPrefix(device.VerificationURIComplete, device.VerificationURI+"?user_code=") {
+		t.Errorf("unexpected verification URIs %#v", device)
+	}
+
+	if result := poll(device.DeviceCode); result["error"] != errorAuthorizationPending {
+		t.Errorf("expected pending authorization, got %v", result)
+	}
+	if result := poll(device.DeviceCode); result["error"] != errorSlowDown {
+		t.Errorf("expected fast polls to slow down, got %v", result)
+	}
+
+	if resp := do(http.MethodPost, issuer+"/oauth/device", url.Values{"user_code": {device.UserCode}, "csrf": {"wrong"}}); resp.Code != http.StatusBadRequest {
+		t.Errorf("expected invalid CSRF tokens to be rejected, got %d", resp.Code)
+	}
+	if resp := do(http.MethodPost, issuer+"/oauth/device", url.Values{"user_code": {"BCDF-GHJK"}, "csrf": {"csrf-token"}}); resp.Code != http.StatusBadRequest {
+		t.Errorf("expected unknown user codes to be rejected, got %d", resp.Code)
+	}
+	// users may enter user codes in lower case and without the separator
+	if resp := approve(strings.ToLower(strings.Replace(device.UserCode, "-", "", 1))); resp.Code != http.StatusOK {
+		t.Fatalf("expected the device to be approved, got %d: %s", resp.Code, resp.Body.String())
+	}
+
+	now = now.Add(DefaultInterval)
+	result := poll(device.DeviceCode)
+	if len(result["access_token"].(string)) == 0 {
+		t.Fatalf("expected an access token, got %v", result)
+	}
+	if result := poll(device.DeviceCode); result["error"] != errorExpiredToken {
+		t.Errorf("expected redeemed device codes to be invalid, got %v", result)
+	}
+
+	// denied requests
+	denyNext = true
+	_, device = authorizeDevice("cli")
+	if resp := approve(device.UserCode); resp.Code != http.StatusOK {
+		t.Fatalf("expected the denial to be recorded, got %d", resp.Code)
+	}
+	if result := poll(device.DeviceCode); result["error"] != osin.E_ACCESS_DENIED {
+		t.Errorf("expected denied devices to be rejected, got %v", result)
+	}
+
+	// expired requests
+	_, device = authorizeDevice("cli")
+	now = now.Add(DefaultLifetime)
+	if result := poll(device.DeviceCode); result["error"] != errorExpiredToken {
+		t.Errorf("expected expired device codes to be rejected, got %v", result)
+	}
+}
+
+func TestNormalizeUserCode(t *testing.T) {
+	for input, expected := range map[string]string{
+		"BCDF-GHJK":   "BCDFGHJK",
+		"bcdf ghjk":   "BCDFGHJK",
+		" Bcdf-ghjK ": "BCDFGHJK",
+	} {
+		if actual := normalizeUserCode(input); actual != expected {
+			t.Errorf("%q: expected %q, got %q", input, expected, actual)
+		}
+	}
+}
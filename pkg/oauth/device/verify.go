@@ -0,0 +1,170 @@
+package device
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+
+	"github.com/openshift/osin"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/klog/v2"
+
+	"github.com/openshift/library-go/pkg/oauth/oauthdiscovery"
+
+	"github.com/openshift/oauth-server/pkg/server/pathprefix"
+)
+
+// pageData is rendered by the verification page. Without a CSRF token, only the message is shown.
+type pageData struct {
+	Action   string
+	UserCode string
+	CSRF     string
+	Error    string
+	Message  string
+}
+
+// verify asks users for the user code of their device and sends them to the authorize endpoint of its client
+func (f *Flow) verify(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		f.renderForm(w, r, http.StatusOK, r.URL.Query().Get(userCodeParam), "")
+	case http.MethodPost:
+		f.verifyPost(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+func (f *Flow) verifyPost(w http.ResponseWriter, r *http.Request) {
+	if ok := f.csrf.Check(r, r.PostFormValue(csrfParam)); !ok {
+		klog.V(4).Infof("Invalid CSRF token: %s", r.PostFormValue(csrfParam))
+		f.renderForm(w, r, http.StatusBadRequest, r.PostFormValue(userCodeParam), "Could not check CSRF token. Please try again.")
+		return
+	}
+
+	userCode := normalizeUserCode(r.PostFormValue(userCodeParam))
+	_, pending, err := f.loadUserCode(userCode)
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("error loading device authorization request: %v", err))
+		f.renderForm(w, r, http.StatusInternalServerError, r.PostFormValue(userCodeParam), "The code could not be checked. Please try again.")
+		return
+	}
+	if pending == nil || pending.Denied || len(pending.Code) > 0 {
+		f.renderForm(w, r, http.StatusBadRequest, r.PostFormValue(userCodeParam), "The code is invalid or expired.")
+		return
+	}
+
+	// the state binds the callback to this browser, so that nobody can approve their device for another user
+	state := url.Values{userCodeParam: {userCode}, csrfParam: {f.csrf.Generate(w, r)}}
+	challenge := sha256.Sum256([]byte(pending.CodeVerifier))
+	query := url.Values{
+		"client_id":             {pending.ClientID},
+		"response_type":         {string(osin.CODE)},
+		"redirect_uri":          {CallbackURL(f.issuer)},
+		"state":                 {state.Encode()},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {osin.PKCE_S256},
+	}
+	if len(pending.Scope) > 0 {
+		query.Set("scope", pending.Scope)
+	}
+	http.Redirect(w, r, oauthdiscovery.OpenShiftOAuthAuthorizeURL(f.issuer)+"?"+query.Encode(), http.StatusFound)
+}
+
+// callback keeps the authorize code, or the denial of the user, until the device redeems its device code
+func (f *Flow) callback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	state, err := url.ParseQuery(query.Get("state"))
+	if err != nil || !f.csrf.Check(r, state.Get(csrfParam)) {
+		klog.V(4).Infof("Invalid CSRF token in device authorization state: %s", query.Get("state"))
+		renderMessage(w, http.StatusBadRequest, "The device could not be approved because the request did not start in this browser. Please enter the code again.")
+		return
+	}
+
+	key, pending, err := f.loadUserCode(state.Get(userCodeParam))
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("error loading device authorization request: %v", err))
+		renderMessage(w, http.StatusInternalServerError, "The device could not be approved. Please try again.")
+		return
+	}
+	if pending == nil || pending.Denied || len(pending.Code) > 0 {
+		renderMessage(w, http.StatusBadRequest, "The code is invalid or expired.")
+		return
+	}
+
+	message := "Your device is logged in. You may close this window and return to your device."
+	switch errorCode := query.Get("error"); {
+	case errorCode == osin.E_ACCESS_DENIED:
+		pending.Denied = true
+		message = "The device was denied access. You may close this window."
+	case len(errorCode) > 0:
+		// e.g. the login failed, the user may enter the code again
+		klog.V(4).Infof("device authorization of client %q failed: %s %s", pending.ClientID, errorCode, query.Get("error_description"))
+		renderMessage(w, http.StatusBadRequest, "The device could not be approved. Please enter the code again.")
+		return
+	case len(query.Get("code")) == 0:
+		renderMessage(w, http.StatusBadRequest, "The device could not be approved. Please enter the code again.")
+		return
+	default:
+		pending.Code = query.Get("code")
+	}
+
+	if err := f.save(key, pending); err != nil {
+		utilruntime.HandleError(fmt.Errorf("error saving device authorization request: %v", err))
+		renderMessage(w, http.StatusInternalServerError, "The device could not be approved. Please try again.")
+		return
+	}
+	renderMessage(w, http.StatusOK, message)
+}
+
+func (f *Flow) renderForm(w http.ResponseWriter, r *http.Request, status int, userCode, errorMessage string) {
+	render(w, status, pageData{
+		Action:   pathprefix.External(r, r.URL.Path),
+		UserCode: userCode,
+		CSRF:     f.csrf.Generate(w, r),
+		Error:    errorMessage,
+	})
+}
+
+func renderMessage(w http.ResponseWriter, status int, message string) {
+	render(w, status, pageData{Message: message})
+}
+
+func render(w http.ResponseWriter, status int, data pageData) {
+	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+	w.WriteHeader(status)
+	if err := pageTemplate.Execute(w, data); err != nil {
+		utilruntime.HandleError(fmt.Errorf("unable to render device verification template: %v", err))
+	}
+}
+
+var pageTemplate = template.Must(template.New("deviceTemplate").Parse(`
+<style>
+	body   { font-family: sans-serif; font-size: 14px; margin: 2em 2%; background-color: #F9F9F9; }
+	h2     { font-size: 1.4em; }
+	input  { font-family: Menlo, Monaco, Consolas, monospace; font-size: 1.5em; text-transform: uppercase; }
+	.error { color: #c00; }
+</style>
+{{ if .CSRF }}
+  <h2>Log in a device</h2>
+  <p>Enter the code that is displayed on your device. Only continue if you started the login on the device yourself.</p>
+  {{ if .Error }}<p class="error">{{ .Error }}</p>{{ end }}
+  <form method="post" action="{{ .Action }}">
+    <input type="text" name="user_code" value="{{ .UserCode }}" autocomplete="off" autofocus required>
+    <input type="hidden" name="csrf" value="{{ .CSRF }}">
+    <button type="submit">Continue</button>
+  </form>
+{{ else }}
+  <p>{{ .Message }}</p>
+{{ end }}
+`))
@@ -0,0 +1,228 @@
+// Package dpop binds access tokens to keys held by clients with DPoP proofs (RFC 9449). A stolen token
+// is useless without the private key that signs the proofs.
+package dpop
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openshift/osin"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+	"k8s.io/apimachinery/pkg/util/cache"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apiserver/pkg/authentication/user"
+
+	"github.com/openshift/oauth-server/pkg/oauth/handlers"
+	"github.com/openshift/oauth-server/pkg/osinserver"
+)
+
+const (
+	// Header carries DPoP proofs
+	Header = "DPoP"
+
+	proofType = "dpop+jwt"
+
+	// proofMaxAge is how long after it was issued a proof is accepted, proofs are created for a single request
+	proofMaxAge = 5 * time.Minute
+	// proofLeeway allows for clock skew between the clients and this server
+	proofLeeway = time.Minute
+
+	// maxUsedProofs bounds the memory that protects against replayed proofs
+	maxUsedProofs = 10000
+
+	errorInvalidProof = "invalid_dpop_proof"
+	errorInvalidToken = "invalid_token"
+)
+
+// algorithms are the asymmetric algorithms proofs may be signed with
+var algorithms = sets.NewString(
+	string(jose.RS256), string(jose.RS384), string(jose.RS512),
+	string(jose.PS256), string(jose.PS384), string(jose.PS512),
+	string(jose.ES256), string(jose.ES384), string(jose.ES512),
+	string(jose.EdDSA),
+)
+
+// Client holds the DPoP settings of a client
+type Client struct {
+	// Required rejects token requests of the client without a DPoP proof
+	Required bool
+}
+
+// Verifier checks DPoP proofs. At the token endpoint it binds the access tokens of the clients that send proofs
+// to their keys, and at the info endpoint it requires proofs of the key for bound tokens. It implements
+// osinserver.AccessHandler and osinserver.InfoHandler.
+type Verifier struct {
+	issuer  string
+	clients map[string]Client
+	now     func() time.Time
+
+	lock sync.Mutex
+	used *cache.LRUExpireCache
+}
+
+// NewVerifier returns a verifier of proofs for the endpoints of the issuer. The proofs of clients that are not
+// given are ignored, their tokens are bearer tokens.
+func NewVerifier(issuer string, clients map[string]Client) *Verifier {
+	return &Verifier{
+		issuer:  strings.TrimSuffix(issuer, "/"),
+		clients: clients,
+		now:     time.Now,
+		used:    cache.NewLRUExpireCache(maxUsedProofs),
+	}
+}
+
+// HandleAccess implements osinserver.AccessHandler
+func (v *Verifier) HandleAccess(ar *osin.AccessRequest, w http.ResponseWriter) error {
+	if !ar.Authorized || ar.Client == nil || ar.HttpRequest == nil {
+		return nil
+	}
+	client, ok := v.clients[ar.Client.GetId()]
+	if !ok {
+		return nil
+	}
+
+	proofs := ar.HttpRequest.Header.Values(Header)
+	if len(proofs) == 0 {
+		if client.Required {
+			return &osinserver.AccessError{Code: errorInvalidProof, Description: "the client requires DPoP proofs"}
+		}
+		return nil
+	}
+	thumbprint, err := v.verify(proofs, ar.HttpRequest, "")
+	if err != nil {
+		return &osinserver.AccessError{Code: errorInvalidProof, Description: err.Error()}
+	}
+
+	info, ok := ar.UserData.(user.Info)
+	if !ok {
+		return fmt.Errorf("did not receive user.Info: %#v", ar.UserData) // should be impossible
+	}
+	ar.UserData = handlers.WithProofKeyThumbprint(info, thumbprint)
+	return nil
+}
+
+// HandleInfo implements osinserver.InfoHandler
+func (v *Verifier) HandleInfo(ir *osin.InfoRequest, resp *osin.Response, r *http.Request) {
+	bound, ok := ir.AccessData.UserData.(handlers.ProofKeyThumbprint)
+	if !ok || len(bound.GetProofKeyThumbprint()) == 0 {
+		return
+	}
+
+	thumbprint, err := v.verify(r.Header.Values(Header), r, ir.AccessData.AccessToken)
+	if err != nil {
+		resp.ErrorStatusCode = http.StatusUnauthorized
+		resp.Headers.Set("WWW-Authenticate", fmt.Sprintf("%s error=%q", Header, errorInvalidProof))
+		resp.SetError(errorInvalidProof, err.Error())
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(thumbprint), []byte(bound.GetProofKeyThumbprint())) != 1 {
+		resp.ErrorStatusCode = http.StatusUnauthorized
+		resp.Headers.Set("WWW-Authenticate", fmt.Sprintf("%s error=%q", Header, errorInvalidToken))
+		resp.SetError(errorInvalidToken, "the access token is bound to a different DPoP key")
+		return
+	}
+
+	// the token may also be bound to other keys
+	confirmation, ok := resp.Output["cnf"].(map[string]string)
+	if !ok {
+		confirmation = map[string]string{}
+	}
+	confirmation["jkt"] = thumbprint
+	resp.Output["cnf"] = confirmation
+}
+
+type proofClaims struct {
+	jwt.Claims
+	Method          string `json:"htm"`
+	URI             string `json:"htu"`
+	AccessTokenHash string `json:"ath,omitempty"`
+}
+
+// verify returns the JWK thumbprint of the key of the single proof of the request. Proofs sent with an access
+// token must have its hash.
+func (v *Verifier) verify(proofs []string, r *http.Request, accessToken string) (string, error) {
+	if len(proofs) != 1 {
+		return "", errors.New("requests must have exactly one DPoP proof")
+	}
+	token, err := jwt.ParseSigned(proofs[0])
+	if err != nil {
+		return "", fmt.Errorf("DPoP proof is not a signed JWT: %v", err)
+	}
+	if len(token.Headers) != 1 {
+		return "", errors.New("DPoP proof must have exactly one signature")
+	}
+	header := token.Headers[0]
+	if typ, _ := header.ExtraHeaders[jose.HeaderType].(string); typ != proofType {
+		return "", fmt.Errorf("DPoP proof must have type %s", proofType)
+	}
+	if !algorithms.Has(header.Algorithm) {
+		return "", fmt.Errorf("DPoP proof must be signed with an asymmetric algorithm, not %q", header.Algorithm)
+	}
+	key := header.JSONWebKey
+	if key == nil || !key.IsPublic() || !key.Valid() {
+		return "", errors.New("DPoP proof must have a public JWK")
+	}
+
+	claims := proofClaims{}
+	if err := token.Claims(key, &claims); err != nil {
+		return "", fmt.Errorf("DPoP proof signature could not be verified: %v", err)
+	}
+	if len(claims.ID) == 0 {
+		return "", errors.New("DPoP proof has no jti")
+	}
+	if claims.IssuedAt == nil {
+		return "", errors.New("DPoP proof has no iat")
+	}
+	now := v.now()
+	if issuedAt := claims.IssuedAt.Time(); issuedAt.After(now.Add(proofLeeway)) || issuedAt.Before(now.Add(-proofMaxAge)) {
+		return "", errors.New("DPoP proof was not issued recently")
+	}
+	if claims.Method != r.Method {
+		return "", fmt.Errorf("DPoP proof is for method %q", claims.Method)
+	}
+	if !v.matchesURI(claims.URI, r) {
+		return "", fmt.Errorf("DPoP proof is for URI %q", claims.URI)
+	}
+	if len(accessToken) > 0 {
+		sum := sha256.Sum256([]byte(accessToken))
+		if subtle.ConstantTimeCompare([]byte(claims.AccessTokenHash), []byte(base64.RawURLEncoding.EncodeToString(sum[:]))) != 1 {
+			return "", errors.New("DPoP proof is for a different access token")
+		}
+	}
+
+	thumbprint, err := key.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return "", err
+	}
+	jkt := base64.RawURLEncoding.EncodeToString(thumbprint)
+
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	proofID := jkt + ":" + claims.ID
+	if _, used := v.used.Get(proofID); used {
+		return "", errors.New("DPoP proof was already used")
+	}
+	v.used.Add(proofID, true, proofMaxAge+proofLeeway)
+	return jkt, nil
+}
+
+// matchesURI returns true if the URI of a proof is the endpoint of the issuer the request is for,
+// query and fragment are ignored
+func (v *Verifier) matchesURI(uri string, r *http.Request) bool {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return false
+	}
+	u.RawQuery, u.Fragment, u.RawFragment = "", "", ""
+	return u.String() == v.issuer+r.URL.Path
+}
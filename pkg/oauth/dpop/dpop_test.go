@@ -0,0 +1,205 @@
+package dpop
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openshift/osin"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+	"k8s.io/apiserver/pkg/authentication/user"
+
+	"github.com/openshift/oauth-server/pkg/osinserver"
+	"github.com/openshift/oauth-server/pkg/osinserver/teststorage"
+)
+
+const (
+	issuer      = "https://oauth.example.com"
+	redirectURI = "https://client.example.com/callback"
+)
+
+type prover struct {
+	t      *testing.T
+	signer jose.Signer
+	proofs int
+}
+
+func newProver(t *testing.T, typ jose.ContentType) *prover {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: key}, (&jose.SignerOptions{EmbedJWK: true}).WithType(typ))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &prover{t: t, signer: signer}
+}
+
+func (p *prover) proof(method, uri, accessToken string, issuedAt time.Time) string {
+	p.proofs++
+	claims := map[string]interface{}{
+		"jti": "proof-" + strconv.Itoa(p.proofs),
+		"htm": method,
+		"htu": uri,
+		"iat": issuedAt.Unix(),
+	}
+	if len(accessToken) > 0 {
+		sum := sha256.Sum256([]byte(accessToken))
+		claims["ath"] = base64.RawURLEncoding.EncodeToString(sum[:])
+	}
+	proof, err := jwt.Signed(p.signer).Claims(claims).CompactSerialize()
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	return proof
+}
+
+func TestBoundTokenFlow(t *testing.T) {
+	now := time.Now()
+	key, otherKey, untypedKey := newProver(t, proofType), newProver(t, proofType), newProver(t, "JWT")
+
+	verifier := NewVerifier(issuer+"/", map[string]Client{
+		"dpop":     {Required: true},
+		"optional": {},
+	})
+
+	storage := teststorage.New()
+	for _, id := range []string{"dpop", "optional", "plain"} {
+		storage.Clients[id] = &osin.DefaultClient{Id: id, Secret: "secret", RedirectUri: redirectURI}
+	}
+	codes := 0
+	newCode := func(clientID string) string {
+		codes++
+		code := "code" + strconv.Itoa(codes)
+		storage.Authorize[code] = &osin.AuthorizeData{
+			Client:      storage.Clients[clientID],
+			Code:        code,
+			ExpiresIn:   300,
+			CreatedAt:   now,
+			RedirectUri: redirectURI,
+			UserData:    &user.DefaultInfo{Name: "bob", UID: "bob-uid"},
+		}
+		return code
+	}
+
+	server := osinserver.New(
+		osinserver.NewDefaultServerConfig(),
+		storage,
+		osinserver.AuthorizeHandlerFunc(func(ar *osin.AuthorizeRequest, resp *osin.Response, w http.ResponseWriter) (bool, error) {
+			return false, nil
+		}),
+		osinserver.AccessHandlers{
+			osinserver.AccessHandlerFunc(func(ar *osin.AccessRequest, w http.ResponseWriter) error {
+				ar.Authorized = true
+				ar.GenerateRefresh = false
+				return nil
+			}),
+			verifier,
+		},
+		osinserver.NewDefaultErrorHandler(),
+		nil,
+		nil,
+		osinserver.InfoHandlers{verifier},
+		nil,
+		nil,
+		nil,
+	)
+	mux := http.NewServeMux()
+	server.Install(mux, "/oauth")
+
+	do := func(req *http.Request) (int, map[string]interface{}) {
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		body := map[string]interface{}{}
+		if err := json.Unmarshal(resp.Body.Bytes(), &body); err != nil {
+			t.Fatalf("unexpected response %d %s: %v", resp.Code, resp.Body.String(), err)
+		}
+		return resp.Code, body
+	}
+	tokenRequest := func(clientID string, proofs ...string) *http.Request {
+		params := url.Values{"grant_type": {"authorization_code"}, "client_id": {clientID}, "client_secret": {"secret"}, "code": {newCode(clientID)}, "redirect_uri": {redirectURI}}
+		req := httptest.NewRequest(http.MethodPost, issuer+"/oauth/token", strings.NewReader(params.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for _, proof := range proofs {
+			req.Header.Add(Header, proof)
+		}
+		return req
+	}
+	tokenURI := issuer + "/oauth/token"
+
+	replayed := key.proof(http.MethodPost, tokenURI, "", now)
+	code, body := do(tokenRequest("dpop", replayed))
+	if code != http.StatusOK || body["token_type"] != "DPoP" {
+		t.Fatalf("expected DPoP token, got %d %v", code, body)
+	}
+	token, _ := body["access_token"].(string)
+
+	for name, proofs := range map[string][]string{
+		"no proof":        nil,
+		"replayed proof":  {replayed},
+		"two proofs":      {key.proof(http.MethodPost, tokenURI, "", now), key.proof(http.MethodPost, tokenURI, "", now)},
+		"wrong method":    {key.proof(http.MethodGet, tokenURI, "", now)},
+		"wrong URI":       {key.proof(http.MethodPost, "https://other.example.com/oauth/token", "", now)},
+		"old proof":       {key.proof(http.MethodPost, tokenURI, "", now.Add(-time.Hour))},
+		"future proof":    {key.proof(http.MethodPost, tokenURI, "", now.Add(time.Hour))},
+		"wrong type":      {untypedKey.proof(http.MethodPost, tokenURI, "", now)},
+		"malformed proof": {"proof"},
+	} {
+		if code, body := do(tokenRequest("dpop", proofs...)); code != http.StatusBadRequest || body["error"] != errorInvalidProof {
+			t.Errorf("%s: expected invalid_dpop_proof, got %d %v", name, code, body)
+		}
+	}
+
+	code, body = do(tokenRequest("optional"))
+	if code != http.StatusOK || body["token_type"] != "Bearer" {
+		t.Errorf("expected bearer token without proof, got %d %v", code, body)
+	}
+	code, body = do(tokenRequest("plain", key.proof(http.MethodPost, tokenURI+"?ignored=true", "", now)))
+	if code != http.StatusOK || body["token_type"] != "Bearer" {
+		t.Errorf("expected proofs of clients without DPoP to be ignored, got %d %v", code, body)
+	}
+	bearerToken, _ := body["access_token"].(string)
+
+	infoURI := issuer + "/oauth/info"
+	infoRequest := func(scheme, token string, proofs ...string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, infoURI, nil)
+		req.Header.Set("Authorization", scheme+" "+token)
+		for _, proof := range proofs {
+			req.Header.Add(Header, proof)
+		}
+		return req
+	}
+
+	code, body = do(infoRequest("DPoP", token, key.proof(http.MethodGet, infoURI, token, now)))
+	if cnf, _ := body["cnf"].(map[string]interface{}); code != http.StatusOK || len(cnf["jkt"].(string)) == 0 {
+		t.Errorf("expected info with confirmation, got %d %v", code, body)
+	}
+	for name, testCase := range map[string]struct {
+		Request *http.Request
+		Error   string
+	}{
+		"bearer scheme":   {Request: infoRequest("Bearer", token), Error: errorInvalidProof},
+		"no access hash":  {Request: infoRequest("DPoP", token, key.proof(http.MethodGet, infoURI, "", now)), Error: errorInvalidProof},
+		"token endpoint":  {Request: infoRequest("DPoP", token, key.proof(http.MethodGet, tokenURI, token, now)), Error: errorInvalidProof},
+		"other key proof": {Request: infoRequest("DPoP", token, otherKey.proof(http.MethodGet, infoURI, token, now)), Error: errorInvalidToken},
+	} {
+		if code, body := do(testCase.Request); code != http.StatusUnauthorized || body["error"] != testCase.Error {
+			t.Errorf("%s: expected %s, got %d %v", name, testCase.Error, code, body)
+		}
+	}
+	if code, body := do(infoRequest("Bearer", bearerToken)); code != http.StatusOK || body["cnf"] != nil {
+		t.Errorf("expected info of bearer token, got %d %v", code, body)
+	}
+}
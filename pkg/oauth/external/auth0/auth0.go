@@ -0,0 +1,131 @@
+package auth0
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"github.com/openshift/oauth-server/pkg/oauth/external"
+	"github.com/openshift/oauth-server/pkg/oauth/external/openid"
+)
+
+const (
+	// https://auth0.com/docs/api/authentication
+	auth0AuthorizePath  = "/authorize"
+	auth0TokenPath      = "/oauth/token"
+	auth0UserInfoPath   = "/userinfo"
+	auth0JWKSPath       = "/.well-known/jwks.json"
+	auth0EndSessionPath = "/oidc/logout"
+
+	// auth0Connection pins the login to a connection of the application, e.g. an enterprise directory, instead of
+	// letting users choose one on the Universal Login page
+	auth0Connection = "connection"
+
+	issuerClaim = "iss"
+)
+
+var auth0Scopes = []string{"openid", "profile", "email"}
+
+// Config holds the settings of an Auth0 application
+type Config struct {
+	ClientID     string
+	ClientSecret string
+
+	// Domain is the domain of the tenant, e.g. example.eu.auth0.com, or a custom domain of the tenant, e.g.
+	// login.example.com. Auth0 issues tokens for the domain they are requested at, so all endpoints are on it.
+	Domain string
+	// Connection is passed in the connection parameter, if it is set
+	Connection string
+	// ConnectionClaim is the claim of ID tokens with the name of the connection the user logged in with, which an
+	// Action of the tenant adds, e.g. https://example.com/connection. If it is set, logins with another connection
+	// than Connection are rejected, otherwise users can remove the connection parameter to choose another one.
+	ConnectionClaim string
+	// UserInfo fetches the claims of /userinfo in addition to those of the ID token. Auth0 rate limits /userinfo per
+	// user, and the ID token holds the profile and email claims already, so it is only needed for claims that Actions
+	// add to the userinfo response alone.
+	UserInfo bool
+
+	ExtraScopes              []string
+	ExtraAuthorizeParameters map[string]string
+}
+
+// NewProvider returns an OpenID Connect provider for the Auth0 tenant at the domain of the config. The signatures of
+// ID tokens are verified with the keys of the tenant, and tokens must be issued by the configured domain.
+func NewProvider(providerName string, transport http.RoundTripper, config Config) (external.Provider, error) {
+	openIDConfig, err := NewOpenIDConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return openid.NewProvider(providerName, transport, openIDConfig)
+}
+
+// NewOpenIDConfig returns the config of the OpenID Connect provider for the Auth0 tenant
+func NewOpenIDConfig(config Config) (openid.Config, error) {
+	domain := strings.TrimSuffix(strings.TrimPrefix(config.Domain, "https://"), "/")
+	if len(domain) == 0 || strings.ContainsAny(domain, "/?#@") {
+		return openid.Config{}, fmt.Errorf("auth0 domain %q must be a host name", config.Domain)
+	}
+	if len(config.ConnectionClaim) > 0 && len(config.Connection) == 0 {
+		return openid.Config{}, errors.New("auth0 connection claim requires a connection")
+	}
+	baseURL := "https://" + domain
+	// Auth0 issuers end with a slash
+	issuer := baseURL + "/"
+
+	scopes := sets.NewString(auth0Scopes...)
+	scopes.Insert(config.ExtraScopes...)
+
+	parameters := map[string]string{}
+	for k, v := range config.ExtraAuthorizeParameters {
+		parameters[k] = v
+	}
+	if len(config.Connection) > 0 {
+		parameters[auth0Connection] = config.Connection
+	}
+
+	openIDConfig := openid.Config{
+		ClientID:     config.ClientID,
+		ClientSecret: config.ClientSecret,
+
+		Scopes: scopes.List(),
+
+		ExtraAuthorizeParameters: parameters,
+
+		Issuer: issuer,
+
+		AuthorizeURL:  baseURL + auth0AuthorizePath,
+		TokenURL:      baseURL + auth0TokenPath,
+		JWKSURL:       baseURL + auth0JWKSPath,
+		EndSessionURL: baseURL + auth0EndSessionPath,
+
+		IDClaims: []string{"sub"},
+		// database connections only set the nickname, which is not unique
+		PreferredUsernameClaims: []string{"preferred_username", "email"},
+		EmailClaims:             []string{"email"},
+		NameClaims:              []string{"name", "email"},
+
+		IDTokenValidator: func(idToken map[string]interface{}) error {
+			if iss, _ := idToken[issuerClaim].(string); iss != issuer {
+				return fmt.Errorf("id_token iss claim (%s) did not match the auth0 domain (%s)", iss, issuer)
+			}
+			if len(config.ConnectionClaim) == 0 {
+				return nil
+			}
+			connection, ok := idToken[config.ConnectionClaim].(string)
+			if !ok {
+				return fmt.Errorf("id_token did not contain the connection claim %s", config.ConnectionClaim)
+			}
+			if connection != config.Connection {
+				return fmt.Errorf("id_token connection (%s) did not match the connection (%s)", connection, config.Connection)
+			}
+			return nil
+		},
+	}
+	if config.UserInfo {
+		openIDConfig.UserInfoURL = baseURL + auth0UserInfoPath
+	}
+	return openIDConfig, nil
+}
@@ -0,0 +1,116 @@
+package auth0
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/RangelReale/osincli"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func TestAuth0(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/.well-known/jwks.json" {
+			http.NotFound(w, r)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: key.Public(), KeyID: "tenant", Algorithm: string(jose.RS256), Use: "sig"},
+		}})
+	}))
+	defer server.Close()
+	domain := strings.TrimPrefix(server.URL, "https://")
+
+	p, err := NewProvider("auth0", server.Client().Transport, Config{
+		ClientID:        "client",
+		ClientSecret:    "secret",
+		Domain:          domain,
+		Connection:      "corp-ad",
+		ConnectionClaim: "https://example.com/connection",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientConfig, err := p.NewConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clientConfig.AuthorizeUrl != server.URL+"/authorize" || clientConfig.TokenUrl != server.URL+"/oauth/token" || clientConfig.Scope != "email openid profile" {
+		t.Errorf("unexpected client config %#v", clientConfig)
+	}
+	req := &osincli.AuthorizeRequest{CustomParameters: map[string]string{}}
+	p.AddCustomParameters(req)
+	if req.CustomParameters["connection"] != "corp-ad" {
+		t.Errorf("expected the connection parameter, got %v", req.CustomParameters)
+	}
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: jose.JSONWebKey{Key: key, KeyID: "tenant"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name        string
+		claims      map[string]interface{}
+		expectError bool
+	}{
+		{
+			name:   "pinned connection",
+			claims: map[string]interface{}{"iss": server.URL + "/", "sub": "waad|123", "email": "bob@example.com", "https://example.com/connection": "corp-ad"},
+		},
+		{
+			name:        "other connection",
+			claims:      map[string]interface{}{"iss": server.URL + "/", "sub": "google-oauth2|123", "https://example.com/connection": "google-oauth2"},
+			expectError: true,
+		},
+		{
+			name:        "missing connection",
+			claims:      map[string]interface{}{"iss": server.URL + "/", "sub": "waad|123"},
+			expectError: true,
+		},
+		{
+			name:        "other domain",
+			claims:      map[string]interface{}{"iss": "https://example.eu.auth0.com/", "sub": "waad|123", "https://example.com/connection": "corp-ad"},
+			expectError: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			idToken, err := jwt.Signed(signer).Claims(tc.claims).CompactSerialize()
+			if err != nil {
+				t.Fatal(err)
+			}
+			identity, err := p.GetUserIdentity(&osincli.AccessData{ResponseData: osincli.ResponseData{"id_token": idToken}})
+			if tc.expectError != (err != nil) {
+				t.Fatalf("expected error %v, got %v", tc.expectError, err)
+			}
+			if err == nil && (identity.GetProviderUserName() != "waad|123" || identity.GetExtra()["preferred_username"] != "bob@example.com") {
+				t.Errorf("unexpected identity %#v", identity)
+			}
+		})
+	}
+}
+
+func TestAuth0Domain(t *testing.T) {
+	for domain, valid := range map[string]bool{
+		"example.eu.auth0.com":         true,
+		"https://login.example.com/":   true,
+		"":                             false,
+		"login.example.com/path":       false,
+		"user@login.example.com":       false,
+		"https://login.example.com/?x": false,
+	} {
+		_, err := NewProvider("auth0", nil, Config{ClientID: "client", ClientSecret: "secret", Domain: domain})
+		if valid != (err == nil) {
+			t.Errorf("%q: expected valid=%v, got %v", domain, valid, err)
+		}
+	}
+}
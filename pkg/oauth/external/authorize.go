@@ -0,0 +1,53 @@
+package external
+
+import (
+	"strings"
+
+	"github.com/RangelReale/osincli"
+)
+
+// WithAuthorizeSettings returns provider with the scopes of its authorize requests replaced by scopes, unless there
+// are none, and with parameters added to its authorize requests. The parameters take precedence over the custom
+// parameters of the provider, but not over its hint parameters.
+func WithAuthorizeSettings(provider Provider, scopes []string, parameters map[string]string) Provider {
+	if len(scopes) == 0 && len(parameters) == 0 {
+		return provider
+	}
+	p := authorizeProvider{Provider: provider, scope: strings.Join(scopes, " "), parameters: parameters}
+	// the handler passes hints only to providers that implement HintingProvider
+	if hinting, ok := provider.(HintingProvider); ok {
+		return hintingAuthorizeProvider{authorizeProvider: p, HintingProvider: hinting}
+	}
+	return p
+}
+
+type authorizeProvider struct {
+	Provider
+	scope      string
+	parameters map[string]string
+}
+
+// NewConfig implements external/interfaces/Provider.NewConfig
+func (p authorizeProvider) NewConfig() (*osincli.ClientConfig, error) {
+	config, err := p.Provider.NewConfig()
+	if err != nil {
+		return nil, err
+	}
+	if len(p.scope) > 0 {
+		config.Scope = p.scope
+	}
+	return config, nil
+}
+
+// AddCustomParameters implements external/interfaces/Provider.AddCustomParameters
+func (p authorizeProvider) AddCustomParameters(req *osincli.AuthorizeRequest) {
+	p.Provider.AddCustomParameters(req)
+	for name, value := range p.parameters {
+		req.CustomParameters[name] = value
+	}
+}
+
+type hintingAuthorizeProvider struct {
+	authorizeProvider
+	HintingProvider
+}
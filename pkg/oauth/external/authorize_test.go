@@ -0,0 +1,78 @@
+package external
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/RangelReale/osincli"
+
+	"github.com/openshift/oauth-server/pkg/server/csrf"
+)
+
+// scopedProvider requests the api scope and asks for consent
+type scopedProvider struct {
+	hintingProvider
+}
+
+func (scopedProvider) NewConfig() (*osincli.ClientConfig, error) {
+	return &osincli.ClientConfig{ClientId: "client", AuthorizeUrl: "https://idp.example.com/authorize", TokenUrl: "https://idp.example.com/token", Scope: "api"}, nil
+}
+
+func (scopedProvider) AddCustomParameters(req *osincli.AuthorizeRequest) {
+	req.CustomParameters["prompt"] = "consent"
+}
+
+func TestWithAuthorizeSettings(t *testing.T) {
+	for _, tc := range []struct {
+		name               string
+		scopes             []string
+		parameters         map[string]string
+		expectedScope      string
+		expectedParameters map[string]string
+	}{
+		{
+			name:               "defaults",
+			expectedScope:      "api",
+			expectedParameters: map[string]string{"prompt": "consent", "login": "bob"},
+		},
+		{
+			name:               "scopes",
+			scopes:             []string{"read_user", "openid"},
+			expectedScope:      "read_user openid",
+			expectedParameters: map[string]string{"prompt": "consent", "login": "bob"},
+		},
+		{
+			name:               "parameters",
+			parameters:         map[string]string{"prompt": "login", "audience": "lab", "login": "alice"},
+			expectedScope:      "api",
+			expectedParameters: map[string]string{"prompt": "login", "audience": "lab", "login": "bob"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			provider := WithAuthorizeSettings(scopedProvider{}, tc.scopes, tc.parameters)
+			redirector, _, err := NewExternalOAuthRedirector(provider, CSRFRedirectingState(&csrf.FakeCSRF{Token: "xyz"}, nil), "https://www.example.com/oauth2callback/idp", nil, nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/oauth/authorize?client_id=console&login_hint=bob", nil)
+			if err := redirector.AuthenticationRedirect(recorder, req); err != nil {
+				t.Fatal(err)
+			}
+			location, err := url.Parse(recorder.Header().Get("Location"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			query := location.Query()
+			if scope := query.Get("scope"); scope != tc.expectedScope {
+				t.Errorf("expected scope %q, got %q", tc.expectedScope, scope)
+			}
+			for name, expected := range tc.expectedParameters {
+				if values := query[name]; len(values) != 1 || values[0] != expected {
+					t.Errorf("expected %s=%q, got %q", name, expected, values)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,223 @@
+package cas
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/RangelReale/osincli"
+	"k8s.io/klog/v2"
+
+	authapi "github.com/openshift/oauth-server/pkg/api"
+	"github.com/openshift/oauth-server/pkg/oauth/external"
+)
+
+const (
+	// https://apereo.github.io/cas/development/protocol/CAS-Protocol-Specification.html
+	casLoginPath = "/login"
+	// the CAS 3.0 validation endpoint releases the attributes of users, /serviceValidate only does if the server is
+	// configured to release them for CAS 2.0 as well
+	casServiceValidatePath = "/p3/serviceValidate"
+
+	casServiceParam = "service"
+	casTicketParam  = "ticket"
+
+	// maxResponseSize limits the validation responses that are read
+	maxResponseSize = 1 << 20
+)
+
+var (
+	defaultEmailAttributes = []string{"mail", "email"}
+	defaultNameAttributes  = []string{"displayName", "cn"}
+)
+
+// Config holds the settings of a CAS server
+type Config struct {
+	// URL is the URL of the CAS server, e.g. https://cas.example.edu/cas
+	URL string
+	// ClientID is used as the OAuth client ID of the provider, CAS does not have one
+	ClientID string
+
+	// PreferredUsernameAttributes are the attributes with the preferred username, the name of the user is used
+	// if none are released
+	PreferredUsernameAttributes []string
+	// EmailAttributes are the attributes with the email address, defaults to mail and email
+	EmailAttributes []string
+	// NameAttributes are the attributes with the display name, defaults to displayName and cn
+	NameAttributes []string
+	// GroupAttributes are the attributes with the groups of the user, e.g. memberOf
+	GroupAttributes []string
+}
+
+type provider struct {
+	providerName string
+	transport    http.RoundTripper
+	baseURL      string
+	Config
+}
+
+// NewProvider returns a provider that authenticates users with service tickets of a CAS server. Tickets are validated
+// with the CAS 3.0 protocol, whose attributes are mapped into the identity of the user.
+func NewProvider(providerName string, transport http.RoundTripper, config Config) (external.Provider, error) {
+	u, err := url.Parse(config.URL)
+	if err != nil || u.Scheme != "https" || len(u.Host) == 0 || len(u.RawQuery) > 0 || len(u.Fragment) > 0 {
+		return nil, fmt.Errorf("CAS URL %q must be an https URL", config.URL)
+	}
+	if len(config.ClientID) == 0 {
+		return nil, errors.New("ClientID is required")
+	}
+	if len(config.EmailAttributes) == 0 {
+		config.EmailAttributes = defaultEmailAttributes
+	}
+	if len(config.NameAttributes) == 0 {
+		config.NameAttributes = defaultNameAttributes
+	}
+	return provider{
+		providerName: providerName,
+		transport:    transport,
+		baseURL:      strings.TrimSuffix(u.String(), "/"),
+		Config:       config,
+	}, nil
+}
+
+var _ external.TicketProvider = provider{}
+
+// NewConfig implements external/interfaces/Provider.NewConfig
+func (p provider) NewConfig() (*osincli.ClientConfig, error) {
+	return &osincli.ClientConfig{
+		ClientId:     p.ClientID,
+		AuthorizeUrl: p.baseURL + casLoginPath,
+		TokenUrl:     p.baseURL + casServiceValidatePath,
+	}, nil
+}
+
+// GetTransport implements external/interfaces/Provider.GetTransport
+func (p provider) GetTransport() (http.RoundTripper, error) {
+	return p.transport, nil
+}
+
+// AddCustomParameters implements external/interfaces/Provider.AddCustomParameters
+func (p provider) AddCustomParameters(*osincli.AuthorizeRequest) {}
+
+// GetUserIdentity implements external/interfaces/Provider.GetUserIdentity
+func (p provider) GetUserIdentity(*osincli.AccessData) (authapi.UserIdentityInfo, error) {
+	return nil, errors.New("CAS does not issue access tokens")
+}
+
+// LoginURL implements external/interfaces/TicketProvider.LoginURL
+func (p provider) LoginURL(service string) string {
+	return p.baseURL + casLoginPath + "?" + url.Values{casServiceParam: {service}}.Encode()
+}
+
+// ValidateTicket implements external/interfaces/TicketProvider.ValidateTicket
+func (p provider) ValidateTicket(service, ticket string) (authapi.UserIdentityInfo, error) {
+	validateURL := p.baseURL + casServiceValidatePath + "?" + url.Values{casServiceParam: {service}, casTicketParam: {ticket}}.Encode()
+	req, err := http.NewRequest(http.MethodGet, validateURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Transport: p.transport}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("non-200 response from CAS ticket validation: %d", resp.StatusCode)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, err
+	}
+
+	response := serviceResponse{}
+	if err := xml.Unmarshal(data, &response); err != nil {
+		return nil, fmt.Errorf("error parsing CAS ticket validation response: %v", err)
+	}
+	if failure := response.Failure; failure != nil {
+		return nil, fmt.Errorf("CAS rejected the ticket: %s %s", failure.Code, strings.TrimSpace(failure.Message))
+	}
+	success := response.Success
+	if success == nil || len(strings.TrimSpace(success.User)) == 0 {
+		return nil, errors.New("CAS ticket validation response did not contain a user")
+	}
+	attributes := success.Attributes.values()
+	klog.V(5).Infof("cas attributes: %#v", attributes)
+
+	user := strings.TrimSpace(success.User)
+	identity := authapi.NewDefaultUserIdentityInfo(p.providerName, user)
+	identity.Extra[authapi.IdentityPreferredUsernameKey] = user
+	if preferredUsername, ok := firstValue(attributes, p.PreferredUsernameAttributes); ok {
+		identity.Extra[authapi.IdentityPreferredUsernameKey] = preferredUsername
+	}
+	if email, ok := firstValue(attributes, p.EmailAttributes); ok {
+		identity.Extra[authapi.IdentityEmailKey] = email
+	}
+	if name, ok := firstValue(attributes, p.NameAttributes); ok {
+		identity.Extra[authapi.IdentityDisplayNameKey] = name
+	}
+	for _, attribute := range p.GroupAttributes {
+		identity.ProviderGroups = append(identity.ProviderGroups, attributes[attribute]...)
+	}
+
+	klog.V(4).Infof("identity=%#v", identity)
+
+	return identity, nil
+}
+
+// serviceResponse is the response of ticket validations, e.g.
+//
+//	<cas:serviceResponse xmlns:cas="http://www.yale.edu/tp/cas">
+//	  <cas:authenticationSuccess>
+//	    <cas:user>bob</cas:user>
+//	    <cas:attributes>
+//	      <cas:mail>bob@example.edu</cas:mail>
+//	      <cas:memberOf>staff</cas:memberOf>
+//	      <cas:memberOf>physics</cas:memberOf>
+//	    </cas:attributes>
+//	  </cas:authenticationSuccess>
+//	</cas:serviceResponse>
+type serviceResponse struct {
+	Success *struct {
+		User       string     `xml:"user"`
+		Attributes attributes `xml:"attributes"`
+	} `xml:"authenticationSuccess"`
+	Failure *struct {
+		Code    string `xml:"code,attr"`
+		Message string `xml:",chardata"`
+	} `xml:"authenticationFailure"`
+}
+
+// attributes holds the released attributes, each element is a value of the attribute it is named after
+type attributes struct {
+	Values []struct {
+		XMLName xml.Name
+		Value   string `xml:",chardata"`
+	} `xml:",any"`
+}
+
+func (a attributes) values() map[string][]string {
+	values := map[string][]string{}
+	for _, value := range a.Values {
+		if v := strings.TrimSpace(value.Value); len(v) > 0 {
+			values[value.XMLName.Local] = append(values[value.XMLName.Local], v)
+		}
+	}
+	return values
+}
+
+// firstValue returns the first value of the first of the attributes that was released
+func firstValue(values map[string][]string, attributes []string) (string, bool) {
+	for _, attribute := range attributes {
+		if v := values[attribute]; len(v) > 0 {
+			return v[0], true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,89 @@
+package cas
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/openshift/oauth-server/pkg/oauth/external"
+)
+
+const service = "https://oauth.example.com/oauth2callback/cas?state=abc"
+
+func TestCAS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/cas/p3/serviceValidate" || r.URL.Query().Get("service") != service {
+			http.NotFound(w, r)
+			return
+		}
+		switch r.URL.Query().Get("ticket") {
+		case "ST-1":
+			fmt.Fprint(w, `<cas:serviceResponse xmlns:cas="http://www.yale.edu/tp/cas">
+  <cas:authenticationSuccess>
+    <cas:user>bob</cas:user>
+    <cas:attributes>
+      <cas:mail>bob@example.edu</cas:mail>
+      <cas:displayName>Bob Builder</cas:displayName>
+      <cas:memberOf>staff</cas:memberOf>
+      <cas:memberOf>physics</cas:memberOf>
+    </cas:attributes>
+  </cas:authenticationSuccess>
+</cas:serviceResponse>`)
+		case "ST-2":
+			fmt.Fprint(w, `<cas:serviceResponse xmlns:cas="http://www.yale.edu/tp/cas">
+  <cas:authenticationSuccess>
+    <cas:user>alice</cas:user>
+  </cas:authenticationSuccess>
+</cas:serviceResponse>`)
+		default:
+			fmt.Fprint(w, `<cas:serviceResponse xmlns:cas="http://www.yale.edu/tp/cas">
+  <cas:authenticationFailure code="INVALID_TICKET">Ticket not recognized</cas:authenticationFailure>
+</cas:serviceResponse>`)
+		}
+	}))
+	defer server.Close()
+
+	p, err := NewProvider("cas", server.Client().Transport, Config{URL: server.URL + "/cas/", ClientID: "openshift", GroupAttributes: []string{"memberOf"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ticketing := p.(external.TicketProvider)
+
+	loginURL, err := url.Parse(ticketing.LoginURL(service))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loginURL.Path != "/cas/login" || loginURL.Query().Get("service") != service {
+		t.Errorf("unexpected login URL %s", loginURL)
+	}
+
+	identity, err := ticketing.ValidateTicket(service, "ST-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	extra := identity.GetExtra()
+	if identity.GetProviderUserName() != "bob" || extra["preferred_username"] != "bob" || extra["email"] != "bob@example.edu" || extra["name"] != "Bob Builder" {
+		t.Errorf("unexpected identity %#v", identity)
+	}
+	if groups := identity.GetProviderGroups(); !reflect.DeepEqual(groups, []string{"staff", "physics"}) {
+		t.Errorf("unexpected groups %v", groups)
+	}
+
+	identity, err = ticketing.ValidateTicket(service, "ST-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.GetProviderUserName() != "alice" || len(identity.GetProviderGroups()) != 0 {
+		t.Errorf("unexpected identity %#v", identity)
+	}
+
+	if _, err := ticketing.ValidateTicket(service, "ST-3"); err == nil {
+		t.Error("expected an unknown ticket to be rejected")
+	}
+	if _, err := ticketing.ValidateTicket("https://other.example.com/", "ST-1"); err == nil {
+		t.Error("expected a ticket of another service to be rejected")
+	}
+}
@@ -0,0 +1,117 @@
+package cognito
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"github.com/openshift/oauth-server/pkg/oauth/external"
+	"github.com/openshift/oauth-server/pkg/oauth/external/openid"
+)
+
+const (
+	// https://docs.aws.amazon.com/cognito/latest/developerguide/cognito-userpools-server-contract-reference.html
+	cognitoAuthorizePath = "/oauth2/authorize"
+	cognitoTokenPath     = "/oauth2/token"
+
+	// ID tokens of user pools are issued by https://cognito-idp.<region>.amazonaws.com/<user pool ID>, which also
+	// publishes the keys of the pool
+	cognitoIssuerFormat = "https://cognito-idp.%s.amazonaws.com/%s"
+	cognitoJWKSPath     = "/.well-known/jwks.json"
+
+	// The name of the user in the pool, e.g. the username of local users or <provider>_<id> for federated users
+	cognitoUsernameClaim = "cognito:username"
+	// The groups of the user in the pool, they are only in the tokens and not returned by /oauth2/userInfo
+	cognitoGroupsClaim = "cognito:groups"
+	// Cognito issues ID and access tokens with the same keys, token_use tells them apart
+	cognitoTokenUseClaim = "token_use"
+
+	issuerClaim   = "iss"
+	audienceClaim = "aud"
+)
+
+var cognitoScopes = []string{"openid", "email", "profile"}
+
+// userPoolIDPattern matches user pool IDs, which start with their region, e.g. eu-west-1_AbCdEf123
+var userPoolIDPattern = regexp.MustCompile(`^([a-z]{2}(-[a-z]+)+-\d+)_[0-9A-Za-z]+$`)
+
+// Config holds the settings of an app client of a Cognito user pool
+type Config struct {
+	ClientID     string
+	ClientSecret string
+
+	// Domain is the domain of the user pool, e.g. example.auth.eu-west-1.amazoncognito.com, or its custom domain
+	Domain string
+	// UserPoolID is the ID of the user pool, e.g. eu-west-1_AbCdEf123
+	UserPoolID string
+
+	ExtraScopes              []string
+	ExtraAuthorizeParameters map[string]string
+}
+
+// NewProvider returns an OpenID Connect provider for the app client of the user pool
+func NewProvider(providerName string, transport http.RoundTripper, config Config) (external.Provider, error) {
+	openIDConfig, err := NewOpenIDConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return openid.NewProvider(providerName, transport, openIDConfig)
+}
+
+// NewOpenIDConfig returns the config of the OpenID Connect provider for the app client of the user pool. The
+// endpoints are on the domain of the pool, ID tokens are issued and signed by the pool. The client secret is sent in
+// the Authorization header, Cognito rejects it in the parameters of token requests.
+func NewOpenIDConfig(config Config) (openid.Config, error) {
+	domain := strings.TrimSuffix(strings.TrimPrefix(config.Domain, "https://"), "/")
+	if len(domain) == 0 || strings.ContainsAny(domain, "/?#@") {
+		return openid.Config{}, fmt.Errorf("cognito domain %q must be a host name", config.Domain)
+	}
+	match := userPoolIDPattern.FindStringSubmatch(config.UserPoolID)
+	if match == nil {
+		return openid.Config{}, fmt.Errorf("cognito user pool ID %q must be <region>_<ID>", config.UserPoolID)
+	}
+	baseURL := "https://" + domain
+	issuer := fmt.Sprintf(cognitoIssuerFormat, match[1], config.UserPoolID)
+
+	scopes := sets.NewString(cognitoScopes...)
+	scopes.Insert(config.ExtraScopes...)
+
+	return openid.Config{
+		ClientID:          config.ClientID,
+		ClientSecret:      config.ClientSecret,
+		ClientSecretBasic: true,
+
+		Scopes: scopes.List(),
+
+		ExtraAuthorizeParameters: config.ExtraAuthorizeParameters,
+
+		Issuer: issuer,
+
+		AuthorizeURL: baseURL + cognitoAuthorizePath,
+		TokenURL:     baseURL + cognitoTokenPath,
+		JWKSURL:      issuer + cognitoJWKSPath,
+
+		IDClaims: []string{"sub"},
+		// federated users have generated usernames, their preferred username is mapped from the provider
+		PreferredUsernameClaims: []string{"preferred_username", cognitoUsernameClaim},
+		EmailClaims:             []string{"email"},
+		NameClaims:              []string{"name", "email"},
+		GroupClaims:             []string{cognitoGroupsClaim},
+
+		IDTokenValidator: func(idToken map[string]interface{}) error {
+			if iss, _ := idToken[issuerClaim].(string); iss != issuer {
+				return fmt.Errorf("id_token iss claim (%s) did not match the user pool (%s)", iss, issuer)
+			}
+			if tokenUse, _ := idToken[cognitoTokenUseClaim].(string); tokenUse != "id" {
+				return fmt.Errorf("id_token token_use claim (%s) is not id", tokenUse)
+			}
+			if aud, _ := idToken[audienceClaim].(string); aud != config.ClientID {
+				return fmt.Errorf("id_token aud claim (%s) did not match the app client (%s)", aud, config.ClientID)
+			}
+			return nil
+		},
+	}, nil
+}
@@ -0,0 +1,131 @@
+package cognito
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/RangelReale/osincli"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// jwksTransport answers the requests for the keys of the user pool
+type jwksTransport struct {
+	t    *testing.T
+	keys jose.JSONWebKeySet
+}
+
+func (j jwksTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.String() != "https://cognito-idp.eu-west-1.amazonaws.com/eu-west-1_AbCdEf123/.well-known/jwks.json" {
+		j.t.Errorf("unexpected request to %s", req.URL)
+		return &http.Response{StatusCode: http.StatusNotFound, Body: ioutil.NopCloser(&bytes.Buffer{}), Request: req}, nil
+	}
+	data, err := json.Marshal(j.keys)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: ioutil.NopCloser(bytes.NewReader(data)), Request: req}, nil
+}
+
+func TestCognito(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := jwksTransport{t: t, keys: jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+		{Key: key.Public(), KeyID: "pool", Algorithm: string(jose.RS256), Use: "sig"},
+	}}}
+
+	p, err := NewProvider("cognito", transport, Config{
+		ClientID:     "client",
+		ClientSecret: "secret",
+		Domain:       "example.auth.eu-west-1.amazoncognito.com",
+		UserPoolID:   "eu-west-1_AbCdEf123",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientConfig, err := p.NewConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clientConfig.AuthorizeUrl != "https://example.auth.eu-west-1.amazoncognito.com/oauth2/authorize" || clientConfig.TokenUrl != "https://example.auth.eu-west-1.amazoncognito.com/oauth2/token" {
+		t.Errorf("unexpected endpoints %#v", clientConfig)
+	}
+	if clientConfig.SendClientSecretInParams {
+		t.Error("expected the client secret to be sent in the Authorization header")
+	}
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: jose.JSONWebKey{Key: key, KeyID: "pool"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss":              "https://cognito-idp.eu-west-1.amazonaws.com/eu-west-1_AbCdEf123",
+			"aud":              "client",
+			"token_use":        "id",
+			"sub":              "5d1c2a3b-1111-2222-3333-444455556666",
+			"cognito:username": "bob",
+			"email":            "bob@example.com",
+			"cognito:groups":   []string{"admins", "developers"},
+		}
+	}
+	for _, tc := range []struct {
+		name        string
+		change      func(map[string]interface{})
+		expectError bool
+	}{
+		{name: "valid"},
+		{name: "other pool", change: func(c map[string]interface{}) {
+			c["iss"] = "https://cognito-idp.eu-west-1.amazonaws.com/eu-west-1_Other"
+		}, expectError: true},
+		{name: "other client", change: func(c map[string]interface{}) { c["aud"] = "other" }, expectError: true},
+		{name: "access token", change: func(c map[string]interface{}) { c["token_use"] = "access" }, expectError: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			claims := valid()
+			if tc.change != nil {
+				tc.change(claims)
+			}
+			idToken, err := jwt.Signed(signer).Claims(claims).CompactSerialize()
+			if err != nil {
+				t.Fatal(err)
+			}
+			identity, err := p.GetUserIdentity(&osincli.AccessData{ResponseData: osincli.ResponseData{"id_token": idToken}})
+			if tc.expectError != (err != nil) {
+				t.Fatalf("expected error %v, got %v", tc.expectError, err)
+			}
+			if err != nil {
+				return
+			}
+			if identity.GetProviderUserName() != "5d1c2a3b-1111-2222-3333-444455556666" || identity.GetExtra()["preferred_username"] != "bob" || identity.GetExtra()["email"] != "bob@example.com" {
+				t.Errorf("unexpected identity %#v", identity)
+			}
+			if groups := identity.GetProviderGroups(); !reflect.DeepEqual(groups, []string{"admins", "developers"}) {
+				t.Errorf("unexpected groups %v", groups)
+			}
+		})
+	}
+}
+
+func TestCognitoUserPoolID(t *testing.T) {
+	for poolID, valid := range map[string]bool{
+		"eu-west-1_AbCdEf123":      true,
+		"us-gov-west-1_AbCdEf123":  true,
+		"AbCdEf123":                false,
+		"eu-west-1_":               false,
+		"eu-west-1_Ab/../Other123": false,
+	} {
+		_, err := NewOpenIDConfig(Config{Domain: "example.auth.eu-west-1.amazoncognito.com", UserPoolID: poolID})
+		if valid != (err == nil) {
+			t.Errorf("%q: expected valid=%v, got %v", poolID, valid, err)
+		}
+	}
+}
@@ -1,10 +1,12 @@
 package github
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strings"
 
 	"k8s.io/apimachinery/pkg/util/sets"
@@ -29,6 +31,9 @@ const (
 	defaultGithubUserTeamURL  = "https://api.github.com/user/teams"
 	defaultGithubUserEmailURL = "https://api.github.com/user/emails"
 
+	// GitHub OAuth application API endpoint with client ID substitution
+	defaultGithubAppTokenURL = "https://api.github.com/applications/%s/token"
+
 	// GitHub Enterprise OAuth endpoints with hostname substitution
 	enterpriseGithubAuthorizeURL = "https://%s/login/oauth/authorize"
 	enterpriseGithubTokenURL     = "https://%s/login/oauth/access_token"
@@ -39,6 +44,9 @@ const (
 	enterpriseGithubUserTeamURL  = "https://%s/api/v3/user/teams"
 	enterpriseGithubUserEmailURL = "https://%s/api/v3/user/emails"
 
+	// GitHub OAuth application API endpoint with hostname and client ID substitution
+	enterpriseGithubAppTokenURL = "https://%s/api/v3/applications/%s/token"
+
 	// GitHub OAuth scopes, see provider.NewConfig
 	githubOAuthScope = "user:email"
 	githubOrgScope   = "read:org"
@@ -54,6 +62,10 @@ type provider struct {
 	clientSecret         string
 	allowedOrganizations sets.String
 	allowedTeams         sets.String
+	// groups adds the organizations and teams of users to their identities as provider groups
+	groups bool
+	// revokeToken deletes the access token of users once their identity has been read
+	revokeToken bool
 
 	// OAuth endpoints
 	githubAuthorizeURL string
@@ -65,6 +77,9 @@ type provider struct {
 	githubUserTeamURL  string
 	githubUserEmailURL string
 
+	// OAuth application API endpoint
+	githubAppTokenURL string
+
 	// incorporates the CA bundle which may be required when GitHub Enterprise is used
 	transport http.RoundTripper
 }
@@ -98,7 +113,10 @@ type githubTeam struct {
 
 var _ external.Provider = &provider{}
 
-func NewProvider(providerName, clientID, clientSecret, hostname string, transport http.RoundTripper, organizations, teams []string) external.Provider {
+// NewProvider returns a GitHub provider. If groups is set, the organizations ("org") and teams ("org/team")
+// of users are added to their identities as provider groups. If revokeToken is set, the access tokens of users
+// are deleted at GitHub once their identity has been read.
+func NewProvider(providerName, clientID, clientSecret, hostname string, transport http.RoundTripper, organizations, teams []string, groups, revokeToken bool) external.Provider {
 	allowedOrganizations := sets.NewString()
 	for _, org := range organizations {
 		if len(org) > 0 {
@@ -119,6 +137,8 @@ func NewProvider(providerName, clientID, clientSecret, hostname string, transpor
 		clientSecret:         clientSecret,
 		allowedOrganizations: allowedOrganizations,
 		allowedTeams:         allowedTeams,
+		groups:               groups,
+		revokeToken:          revokeToken,
 		transport:            transport,
 	}
 
@@ -129,6 +149,7 @@ func NewProvider(providerName, clientID, clientSecret, hostname string, transpor
 		p.githubUserOrgURL = fmt.Sprintf(enterpriseGithubUserOrgURL, hostname)
 		p.githubUserTeamURL = fmt.Sprintf(enterpriseGithubUserTeamURL, hostname)
 		p.githubUserEmailURL = fmt.Sprintf(enterpriseGithubUserEmailURL, hostname)
+		p.githubAppTokenURL = fmt.Sprintf(enterpriseGithubAppTokenURL, hostname, url.PathEscape(clientID))
 	} else {
 		p.githubAuthorizeURL = defaultGithubAuthorizeURL
 		p.githubTokenURL = defaultGithubTokenURL
@@ -136,6 +157,7 @@ func NewProvider(providerName, clientID, clientSecret, hostname string, transpor
 		p.githubUserOrgURL = defaultGithubUserOrgURL
 		p.githubUserTeamURL = defaultGithubUserTeamURL
 		p.githubUserEmailURL = defaultGithubUserEmailURL
+		p.githubAppTokenURL = fmt.Sprintf(defaultGithubAppTokenURL, url.PathEscape(clientID))
 	}
 
 	return p
@@ -148,8 +170,8 @@ func (p *provider) GetTransport() (http.RoundTripper, error) {
 // NewConfig implements external/interfaces/Provider.NewConfig
 func (p *provider) NewConfig() (*osincli.ClientConfig, error) {
 	scopes := []string{githubOAuthScope}
-	// if we're limiting to specific organizations or teams, we also need to read their org membership
-	if len(p.allowedOrganizations) > 0 || len(p.allowedTeams) > 0 {
+	// if we're limiting to specific organizations or teams or reporting them as groups, we also need to read their org membership
+	if len(p.allowedOrganizations) > 0 || len(p.allowedTeams) > 0 || p.groups {
 		scopes = append(scopes, githubOrgScope)
 	}
 
@@ -169,8 +191,25 @@ func (p *provider) NewConfig() (*osincli.ClientConfig, error) {
 func (p provider) AddCustomParameters(req *osincli.AuthorizeRequest) {
 }
 
+// AddHintParameters implements external/interfaces/HintingProvider.AddHintParameters, GitHub suggests the account of
+// the login parameter
+func (p provider) AddHintParameters(req *osincli.AuthorizeRequest, hints external.Hints) {
+	if len(hints.Login) > 0 {
+		req.CustomParameters["login"] = hints.Login
+	}
+}
+
 // GetUserIdentity implements external/interfaces/Provider.GetUserIdentity
 func (p *provider) GetUserIdentity(data *osincli.AccessData) (authapi.UserIdentityInfo, error) {
+	if p.revokeToken {
+		// the token is not used again, whether the user is allowed to log in or not
+		defer func() {
+			if err := p.deleteToken(data.AccessToken); err != nil {
+				klog.Warningf("Error deleting GitHub access token of provider %s: %v", p.providerName, err)
+			}
+		}()
+	}
+
 	userdata := githubUser{}
 	if _, err := p.getJSON(p.githubUserApiURL, data.AccessToken, &userdata); err != nil {
 		return nil, err
@@ -202,8 +241,10 @@ func (p *provider) GetUserIdentity(data *osincli.AccessData) (authapi.UserIdenti
 	klog.V(4).Infof("Got identity=%#v", identity)
 
 	// Apply authorization rules
+	var userOrgs, userTeams sets.String
+	var err error
 	if len(p.allowedOrganizations) > 0 {
-		userOrgs, err := p.getUserOrgs(data.AccessToken)
+		userOrgs, err = p.getUserOrgs(data.AccessToken)
 		if err != nil {
 			return nil, api.NewAuthorizationFailedError(identity, err)
 		}
@@ -214,7 +255,7 @@ func (p *provider) GetUserIdentity(data *osincli.AccessData) (authapi.UserIdenti
 		klog.V(4).Infof("User %s is a member of organizations %v)", userdata.Login, userOrgs.List())
 	}
 	if len(p.allowedTeams) > 0 {
-		userTeams, err := p.getUserTeams(data.AccessToken)
+		userTeams, err = p.getUserTeams(data.AccessToken)
 		if err != nil {
 			return nil, api.NewAuthorizationFailedError(identity, err)
 		}
@@ -225,9 +266,52 @@ func (p *provider) GetUserIdentity(data *osincli.AccessData) (authapi.UserIdenti
 		klog.V(4).Infof("User %s is a member of teams %v)", userdata.Login, userTeams.List())
 	}
 
+	// organizations and teams not yet read for the authorization rules are read for the groups
+	if p.groups {
+		if userOrgs == nil {
+			if userOrgs, err = p.getUserOrgs(data.AccessToken); err != nil {
+				return nil, api.NewAuthorizationFailedError(identity, err)
+			}
+		}
+		if userTeams == nil {
+			if userTeams, err = p.getUserTeams(data.AccessToken); err != nil {
+				return nil, api.NewAuthorizationFailedError(identity, err)
+			}
+		}
+		identity.ProviderGroups = userOrgs.Union(userTeams).List()
+	}
+
 	return identity, nil
 }
 
+// deleteToken deletes the access token at GitHub, which authenticates the OAuth application with its client credentials.
+// https://docs.github.com/en/rest/apps/oauth-applications#delete-an-app-token
+func (p *provider) deleteToken(token string) error {
+	body, err := json.Marshal(map[string]string{"access_token": token})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodDelete, p.githubAppTokenURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.SetBasicAuth(p.clientID, p.clientSecret)
+	req.Header.Set("Accept", githubAccept)
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := p.transport.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("Non-204 response from GitHub API call %s: %d", p.githubAppTokenURL, res.StatusCode)
+	}
+	return nil
+}
+
 // getUserOrgs retrieves the organization membership for the user with the given access token.
 func (p *provider) getUserOrgs(token string) (sets.String, error) {
 	userOrgs := sets.NewString()
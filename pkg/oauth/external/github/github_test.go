@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"reflect"
 	"testing"
 
 	"github.com/RangelReale/osincli"
@@ -59,6 +60,28 @@ func TestGetUserIdentity(t *testing.T) {
 					Body:       io.NopCloser(body),
 				}, nil
 
+			case "/user/teams":
+				type ghTeam struct {
+					ID           uint64
+					Slug         string
+					Organization struct{ Login string }
+				}
+				ghTeams := make([]ghTeam, len(orgs))
+				for i := range orgs {
+					ghTeams[i].ID = 998
+					ghTeams[i].Slug = "admins"
+					ghTeams[i].Organization.Login = orgs[i]
+				}
+
+				if err := json.NewEncoder(body).Encode(ghTeams); err != nil {
+					panic(err)
+				}
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Status:     http.StatusText(http.StatusOK),
+					Body:       io.NopCloser(body),
+				}, nil
+
 			default:
 				return nil, fmt.Errorf("this fixture does not serve the requested path: %s", req.URL.Path)
 			}
@@ -94,6 +117,14 @@ func TestGetUserIdentity(t *testing.T) {
 			return nil
 		}
 	}
+	hasGroups := func(want ...string) checkFunc {
+		return func(userIdentityInfo api.UserIdentityInfo, _ error) error {
+			if have := userIdentityInfo.GetProviderGroups(); !reflect.DeepEqual(want, have) {
+				return fmt.Errorf("expected groups %v, got %v", want, have)
+			}
+			return nil
+		}
+	}
 	isAllowed := func(_ api.UserIdentityInfo, err error) error {
 		if err != nil {
 			return fmt.Errorf("unexpected error: %v", err)
@@ -116,6 +147,7 @@ func TestGetUserIdentity(t *testing.T) {
 		username             string
 		userOrganizations    []string
 		allowedOrganizations []string
+		groups               bool
 
 		checks []checkFunc
 	}{
@@ -157,6 +189,24 @@ func TestGetUserIdentity(t *testing.T) {
 				hasUsername("hello"),
 			},
 		},
+		{
+			name:     "ok without groups",
+			username: "hello",
+			checks: []checkFunc{
+				isAllowed,
+				hasGroups(),
+			},
+		},
+		{
+			name:              "ok with organizations and teams as groups",
+			username:          "hello",
+			userOrganizations: []string{"openshift", "Kubernetes"},
+			groups:            true,
+			checks: []checkFunc{
+				isAllowed,
+				hasGroups("kubernetes", "kubernetes/admins", "openshift", "openshift/admins"),
+			},
+		},
 		{
 			name:                 "denied, not in organization",
 			username:             "hello",
@@ -186,6 +236,8 @@ func TestGetUserIdentity(t *testing.T) {
 				newGithubIdentityProvider(tc.username, tc.userOrganizations),
 				tc.allowedOrganizations,
 				nil,
+				tc.groups,
+				false,
 			).GetUserIdentity(&osincli.AccessData{})
 
 			for _, check := range tc.checks {
@@ -198,6 +250,55 @@ func TestGetUserIdentity(t *testing.T) {
 
 }
 
+func TestRevokeToken(t *testing.T) {
+	for _, tc := range []struct {
+		name                 string
+		revokeToken          bool
+		allowedOrganizations []string
+		expectDenied         bool
+	}{
+		{name: "allowed", revokeToken: true},
+		{name: "denied", revokeToken: true, allowedOrganizations: []string{"vip"}, expectDenied: true},
+		{name: "disabled"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var deleted []string
+			transport := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				body := "[]"
+				switch req.URL.Path {
+				case "/user":
+					body = `{"id":12345,"login":"hello"}`
+				case "/user/orgs":
+				case "/applications/my_client_id/token":
+					token := struct {
+						AccessToken string `json:"access_token"`
+					}{}
+					if err := json.NewDecoder(req.Body).Decode(&token); err != nil {
+						return nil, err
+					}
+					if clientID, clientSecret, _ := req.BasicAuth(); req.Method != http.MethodDelete || clientID != "my_client_id" || clientSecret != "my_client_secret" {
+						t.Errorf("unexpected token deletion %s by %s", req.Method, clientID)
+					}
+					deleted = append(deleted, token.AccessToken)
+					return &http.Response{StatusCode: http.StatusNoContent, Body: io.NopCloser(new(bytes.Buffer))}, nil
+				default:
+					return nil, fmt.Errorf("this fixture does not serve the requested path: %s", req.URL.Path)
+				}
+				return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewBufferString(body))}, nil
+			})
+
+			_, err := NewProvider("git-tub", "my_client_id", "my_client_secret", "", transport, tc.allowedOrganizations, nil, false, tc.revokeToken).GetUserIdentity(&osincli.AccessData{AccessToken: "token"})
+			var denied api.AuthorizationDeniedError
+			if tc.expectDenied != errors.As(err, &denied) {
+				t.Fatalf("expected denied=%v, got %v", tc.expectDenied, err)
+			}
+			if expected := tc.revokeToken; expected != reflect.DeepEqual(deleted, []string{"token"}) {
+				t.Errorf("expected deleted=%v, got %v", expected, deleted)
+			}
+		})
+	}
+}
+
 type roundTripperFunc func(*http.Request) (*http.Response, error)
 
 func (rt roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
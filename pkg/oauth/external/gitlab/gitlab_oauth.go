@@ -18,10 +18,13 @@ import (
 const (
 	// Uses the GitLab User-API (http://doc.gitlab.com/ce/api/users.html#current-user)
 	// and OAuth-Provider (http://doc.gitlab.com/ce/integration/oauth_provider.html)
-	// with default OAuth scope (http://doc.gitlab.com/ce/api/users.html#current-user)
-	// Requires GitLab 7.7.0 or higher
+	// with the read_user OAuth scope, which grants read-only access to the user (https://docs.gitlab.com/ee/integration/oauth_provider.html)
+	// Requires GitLab 8.15.0 or higher
 	gitlabUserAPIPath = "/api/v3/user"
-	gitlabOAuthScope  = "api"
+	gitlabOAuthScope  = "read_user"
+
+	// The access token is only used to read the user, it is revoked afterwards (RFC 7009)
+	gitlabRevokePath = "/oauth/revoke"
 )
 
 type provider struct {
@@ -30,6 +33,7 @@ type provider struct {
 	authorizeURL string
 	tokenURL     string
 	userAPIURL   string
+	revokeURL    string
 	clientID     string
 	clientSecret string
 }
@@ -54,6 +58,7 @@ func NewOAuthProvider(providerName, URL, clientID, clientSecret string, transpor
 		authorizeURL: appendPath(*u, gitlabAuthorizePath),
 		tokenURL:     appendPath(*u, gitlabTokenPath),
 		userAPIURL:   appendPath(*u, gitlabUserAPIPath),
+		revokeURL:    appendPath(*u, gitlabRevokePath),
 		clientID:     clientID,
 		clientSecret: clientSecret,
 	}, nil
@@ -85,10 +90,8 @@ func (p *provider) GetUserIdentity(data *osincli.AccessData) (authapi.UserIdenti
 	req, _ := http.NewRequest("GET", p.userAPIURL, nil)
 	req.Header.Set("Authorization", fmt.Sprintf("bearer %s", data.AccessToken))
 
-	client := http.DefaultClient
-	if p.transport != nil {
-		client = &http.Client{Transport: p.transport}
-	}
+	// a nil transport uses http.DefaultTransport
+	client := &http.Client{Transport: p.transport}
 	res, err := client.Do(req)
 	if err != nil {
 		return nil, err
@@ -122,5 +125,29 @@ func (p *provider) GetUserIdentity(data *osincli.AccessData) (authapi.UserIdenti
 	}
 	klog.V(4).Infof("Got identity=%#v", identity)
 
+	// a failed revocation does not fail the login, the token expires eventually
+	if err := p.revokeToken(client, data.AccessToken); err != nil {
+		klog.Warningf("Error revoking GitLab access token of identity %s: %v", identity.GetIdentityName(), err)
+	}
+
 	return identity, nil
 }
+
+// revokeToken revokes the access token at GitLab, the client authenticates like for the token endpoint
+func (p *provider) revokeToken(client *http.Client, accessToken string) error {
+	form := url.Values{
+		"token":           {accessToken},
+		"token_type_hint": {"access_token"},
+		"client_id":       {p.clientID},
+		"client_secret":   {p.clientSecret},
+	}
+	res, err := client.PostForm(p.revokeURL, form)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("non-200 response from %s: %d", p.revokeURL, res.StatusCode)
+	}
+	return nil
+}
@@ -1,9 +1,14 @@
 package gitlab
 
 import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"testing"
 
+	"github.com/RangelReale/osincli"
+
 	"github.com/openshift/oauth-server/pkg/oauth/external"
 )
 
@@ -19,6 +24,7 @@ func TestGitLab(t *testing.T) {
 		authorizeURL: "https://gitlab.com/oauth/authorize",
 		tokenURL:     "https://gitlab.com/oauth/token",
 		userAPIURL:   "https://gitlab.com/api/v3/user",
+		revokeURL:    "https://gitlab.com/oauth/revoke",
 		clientID:     "clientid",
 		clientSecret: "clientsecret",
 	}
@@ -26,3 +32,56 @@ func TestGitLab(t *testing.T) {
 		t.Fatalf("Expected\n%#v\ngot\n%#v", expectedProvider, p)
 	}
 }
+
+func TestGitLabRevokesToken(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		userStatus    int
+		revokeStatus  int
+		expectError   bool
+		expectRevoked bool
+	}{
+		{name: "revoked", userStatus: http.StatusOK, revokeStatus: http.StatusOK, expectRevoked: true},
+		{name: "revocation fails", userStatus: http.StatusOK, revokeStatus: http.StatusForbidden, expectRevoked: true},
+		{name: "no user", userStatus: http.StatusUnauthorized, expectError: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			revoked := false
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/api/v3/user":
+					if r.Header.Get("Authorization") != "bearer token" || tc.userStatus != http.StatusOK {
+						w.WriteHeader(tc.userStatus)
+						fmt.Fprint(w, `{"message":"401 Unauthorized"}`)
+						return
+					}
+					fmt.Fprint(w, `{"id":42,"username":"bob","email":"bob@example.com","name":"Bob"}`)
+				case "/oauth/revoke":
+					if r.Method != http.MethodPost || r.PostFormValue("token") != "token" || r.PostFormValue("client_id") != "clientid" || r.PostFormValue("client_secret") != "clientsecret" {
+						t.Errorf("unexpected revocation %s %v", r.Method, r.PostForm)
+					}
+					revoked = true
+					w.WriteHeader(tc.revokeStatus)
+				default:
+					http.NotFound(w, r)
+				}
+			}))
+			defer server.Close()
+
+			p, err := NewOAuthProvider("gitlab", server.URL, "clientid", "clientsecret", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			identity, err := p.GetUserIdentity(&osincli.AccessData{AccessToken: "token"})
+			if tc.expectError != (err != nil) {
+				t.Fatalf("expected error=%v, got %v", tc.expectError, err)
+			}
+			if err == nil && identity.GetProviderUserName() != "42" {
+				t.Errorf("unexpected identity %#v", identity)
+			}
+			if revoked != tc.expectRevoked {
+				t.Errorf("expected revoked=%v, got %v", tc.expectRevoked, revoked)
+			}
+		})
+	}
+}
@@ -3,10 +3,12 @@ package external
 import (
 	"context"
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
+	"strings"
 
 	"github.com/RangelReale/osincli"
 	"k8s.io/klog/v2"
@@ -19,8 +21,31 @@ import (
 	"github.com/openshift/oauth-server/pkg/audit"
 	openshiftauthenticator "github.com/openshift/oauth-server/pkg/authenticator"
 	"github.com/openshift/oauth-server/pkg/authenticator/identitymapper"
+	"github.com/openshift/oauth-server/pkg/logging"
+	"github.com/openshift/oauth-server/pkg/oauth/acr"
 	"github.com/openshift/oauth-server/pkg/oauth/handlers"
+	"github.com/openshift/oauth-server/pkg/server/correlation"
 	"github.com/openshift/oauth-server/pkg/server/csrf"
+	"github.com/openshift/oauth-server/pkg/server/deadline"
+	"github.com/openshift/oauth-server/pkg/server/errorpage"
+	"github.com/openshift/oauth-server/pkg/server/redirect"
+	"github.com/openshift/oauth-server/pkg/server/selectprovider"
+	"github.com/openshift/oauth-server/pkg/server/session"
+	"github.com/openshift/oauth-server/pkg/upstreamtoken"
+)
+
+const (
+	// correlationParam is the state parameter that holds the correlation ID of the login attempt
+	correlationParam = "correlation"
+
+	// LoginHintParam is the parameter of authorize requests with the login hint passed to providers
+	LoginHintParam = "login_hint"
+	// DomainHintParam is the parameter of authorize requests with the domain hint passed to providers
+	DomainHintParam = "domain_hint"
+
+	// stateParam and ticketParam are the parameters of callbacks of ticket providers
+	stateParam  = "state"
+	ticketParam = "ticket"
 )
 
 // Handler exposes an external oauth provider flow (including the call back) as an oauth.handlers.AuthenticationHandler to allow our internal oauth
@@ -36,25 +61,33 @@ type Handler struct {
 }
 
 func NewExternalOAuthRedirector(provider Provider, state State, redirectURL string, success handlers.AuthenticationSuccessHandler, errorHandler handlers.AuthenticationErrorHandler, mapper authapi.UserIdentityMapper) (handlers.AuthenticationRedirector, http.Handler, error) {
-	clientConfig, err := provider.NewConfig()
+	handler, err := newHandler(provider, state, redirectURL, success, errorHandler, mapper)
 	if err != nil {
 		return nil, nil, err
 	}
+	return handler, handler, nil
+}
+
+func newHandler(provider Provider, state State, redirectURL string, success handlers.AuthenticationSuccessHandler, errorHandler handlers.AuthenticationErrorHandler, mapper authapi.UserIdentityMapper) (*Handler, error) {
+	clientConfig, err := provider.NewConfig()
+	if err != nil {
+		return nil, err
+	}
 
 	clientConfig.RedirectUrl = redirectURL
 
 	client, err := osincli.NewClient(clientConfig)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
 	transport, err := provider.GetTransport()
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 	client.Transport = transport
 
-	handler := &Handler{
+	return &Handler{
 		provider:     provider,
 		state:        state,
 		clientConfig: clientConfig,
@@ -62,26 +95,36 @@ func NewExternalOAuthRedirector(provider Provider, state State, redirectURL stri
 		success:      success,
 		errorHandler: errorHandler,
 		mapper:       mapper,
-	}
-
-	return handler, handler, nil
+	}, nil
 }
 
 // AuthenticationRedirect implements oauth.handlers.RedirectAuthHandler
 func (h *Handler) AuthenticationRedirect(w http.ResponseWriter, req *http.Request) error {
-	klog.V(4).Infof("Authentication needed for %v", h.provider)
+	logger := logging.FromRequest(req)
+	logger.Info(4, "Authentication needed", "provider", fmt.Sprintf("%v", h.provider))
 
 	authReq := h.client.NewAuthorizeRequest(osincli.CODE)
 	h.provider.AddCustomParameters(authReq)
+	if hinting, ok := h.provider.(HintingProvider); ok {
+		query := req.URL.Query()
+		hinting.AddHintParameters(authReq, Hints{Login: query.Get(LoginHintParam), Domain: query.Get(DomainHintParam), MaxAge: query.Get(acr.MaxAgeParam)})
+	}
 
 	state, err := h.state.Generate(w, req)
 	if err != nil {
-		klog.V(4).Infof("Error generating state: %v", err)
+		logger.Info(4, "Error generating state", "err", err)
 		return err
 	}
 
+	if ticketing, ok := h.provider.(TicketProvider); ok {
+		loginURL := ticketing.LoginURL(h.serviceURL(state))
+		logger.Info(4, "Redirecting to the identity provider", "url", loginURL)
+		http.Redirect(w, req, loginURL, http.StatusFound)
+		return nil
+	}
+
 	oauthURL := authReq.GetAuthorizeUrlWithParams(state)
-	klog.V(4).Infof("redirect to %v", oauthURL)
+	logger.Info(4, "Redirecting to the identity provider", "url", oauthURL.String())
 
 	http.Redirect(w, req, oauthURL.String(), http.StatusFound)
 	return nil
@@ -117,7 +160,7 @@ func NewOAuthPasswordAuthenticator(provider Provider, mapper authapi.UserIdentit
 
 func (h *Handler) AuthenticatePassword(ctx context.Context, username, password string) (*authenticator.Response, bool, error) {
 	// Exchange password for a token
-	accessReq := h.client.NewAccessRequest(osincli.PASSWORD, &osincli.AuthorizeData{Username: username, Password: password})
+	accessReq := h.contextClient(ctx).NewAccessRequest(osincli.PASSWORD, &osincli.AuthorizeData{Username: username, Password: password})
 	accessData, err := accessReq.GetToken()
 	if err != nil {
 		if oauthErr, ok := err.(*osincli.Error); ok && oauthErr.Id == "invalid_grant" {
@@ -130,96 +173,214 @@ func (h *Handler) AuthenticatePassword(ctx context.Context, username, password s
 
 	klog.V(5).Infof("Got access data for %s", username)
 
-	identity, err := h.provider.GetUserIdentity(accessData)
+	var identity authapi.UserIdentityInfo
+	if budgetErr := deadline.Call(ctx, func() { identity, err = h.provider.GetUserIdentity(accessData) }); budgetErr != nil {
+		return nil, false, budgetErr
+	}
 	if err != nil {
 		klog.V(4).Infof("Error getting userIdentityInfo info: %v", err)
 		return nil, false, err
 	}
 
-	return identitymapper.ResponseFor(h.mapper, identity)
+	var (
+		response *authenticator.Response
+		ok       bool
+	)
+	if budgetErr := deadline.Call(ctx, func() { response, ok, err = identitymapper.ResponseFor(h.mapper, identity) }); budgetErr != nil {
+		return nil, false, budgetErr
+	}
+	return response, ok, err
+}
+
+// contextClient returns the client of the provider with requests that are sent with ctx, so that the calls to the
+// provider end with the budget of the login
+func (h *Handler) contextClient(ctx context.Context) *osincli.Client {
+	client := *h.client
+	client.Transport = deadline.Transport(ctx, h.client.Transport)
+	return &client
 }
 
 // ServeHTTP handles the callback request in response to an external oauth flow
 func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if ticketing, ok := h.provider.(TicketProvider); ok {
+		h.serveTicket(w, req, ticketing)
+		return
+	}
 
 	// Extract auth code
 	authReq := h.client.NewAuthorizeRequest(osincli.CODE)
 	authData, err := authReq.HandleRequest(req)
 	if err != nil {
-		klog.V(4).Infof("Error handling request: %v", err)
-		h.handleError(err, w, req)
+		logging.FromRequest(req).Info(4, "Error handling request", "err", err)
+		// errors of the identity provider carry the state, users may try another provider if it is valid
+		var osinErr *osincli.Error
+		if errors.As(err, &osinErr) {
+			if ok, _ := h.state.Check(osinErr.State, req); ok {
+				req = withProviderSelection(req, osinErr.State)
+			}
+		}
+		h.handleError(api.NewIdentityProviderError(err), w, req)
 		return
 	}
 
-	klog.V(4).Infof("Got auth data")
+	logging.FromRequest(req).Info(4, "Got auth data")
 
 	// Validate state before making any server-to-server calls
 	ok, err := h.state.Check(authData.State, req)
 	if err != nil {
-		klog.V(4).Infof("Error verifying state: %v", err)
-		h.handleError(err, w, req)
+		logging.FromRequest(req).Info(4, "Error verifying state", "err", err)
+		h.handleError(api.NewInvalidStateError(err), w, req)
 		return
 	}
 	if !ok {
-		klog.V(4).Infof("State is invalid")
+		logging.FromRequest(req).Info(4, "State is invalid")
 		err := errors.New("State is invalid")
-		h.handleError(err, w, req)
+		h.handleError(api.NewInvalidStateError(err), w, req)
 		return
 	}
+	continueAttempt(w, req, authData.State)
+	req = withProviderSelection(req, authData.State)
+	logger := logging.FromRequest(req)
 
 	// Exchange code for a token
-	accessReq := h.client.NewAccessRequest(osincli.AUTHORIZATION_CODE, authData)
+	accessReq := h.contextClient(req.Context()).NewAccessRequest(osincli.AUTHORIZATION_CODE, authData)
+	logger.Info(4, "Exchanging the code", "tokenURL", accessReq.GetTokenUrl())
 	accessData, err := accessReq.GetToken()
 	if err != nil {
-		klog.V(2).Infof("Error getting access token from an external OIDC provider (%s): %v", accessReq.GetTokenUrl(), err)
-		h.handleError(err, w, req)
+		logger.Info(2, "Error getting access token from an external OIDC provider", "tokenURL", accessReq.GetTokenUrl(), "err", err)
+		h.handleError(api.NewIdentityProviderError(err), w, req)
 		return
 	}
 
-	klog.V(5).Infof("Got access data")
+	logger.Info(5, "Got access data")
 	h.login(w, req, accessData, authData.State)
 }
 
+// serveTicket handles the callback of a ticket provider, which carries the state in the service URL
+func (h *Handler) serveTicket(w http.ResponseWriter, req *http.Request, ticketing TicketProvider) {
+	query := req.URL.Query()
+	state := query.Get(stateParam)
+	ticket := query.Get(ticketParam)
+	if len(ticket) == 0 {
+		logging.FromRequest(req).Info(4, "Callback without a ticket")
+		h.handleError(api.NewIdentityProviderError(errors.New("no ticket returned")), w, req)
+		return
+	}
+
+	ok, err := h.state.Check(state, req)
+	if err != nil {
+		logging.FromRequest(req).Info(4, "Error verifying state", "err", err)
+		h.handleError(api.NewInvalidStateError(err), w, req)
+		return
+	}
+	if !ok {
+		logging.FromRequest(req).Info(4, "State is invalid")
+		err := errors.New("State is invalid")
+		h.handleError(api.NewInvalidStateError(err), w, req)
+		return
+	}
+	continueAttempt(w, req, state)
+	req = withProviderSelection(req, state)
+
+	// the ticket was issued for the service URL the user was sent to the provider with
+	var identity authapi.UserIdentityInfo
+	if budgetErr := deadline.Call(req.Context(), func() { identity, err = ticketing.ValidateTicket(h.serviceURL(state), ticket) }); budgetErr != nil {
+		logging.FromRequest(req).Info(2, "Ticket validation did not complete", "err", budgetErr)
+		h.handleError(api.NewIdentityProviderError(budgetErr), w, req)
+		return
+	}
+	h.loginIdentity(w, req, identity, err, "", state)
+}
+
+// serviceURL returns the callback URL with the state, which ticket providers send users back to
+func (h *Handler) serviceURL(state string) string {
+	return h.clientConfig.RedirectUrl + "?" + url.Values{stateParam: {state}}.Encode()
+}
+
 func (h *Handler) login(w http.ResponseWriter, req *http.Request, accessData *osincli.AccessData, state string) {
-	identity, err := h.provider.GetUserIdentity(accessData)
+	var (
+		identity authapi.UserIdentityInfo
+		err      error
+	)
+	if budgetErr := deadline.Call(req.Context(), func() { identity, err = h.provider.GetUserIdentity(accessData) }); budgetErr != nil {
+		logging.FromRequest(req).Info(2, "Getting the identity from the identity provider did not complete", "err", budgetErr)
+		audit.AddDecisionAnnotation(req, audit.ErrorDecision)
+		h.handleError(api.NewIdentityProviderError(budgetErr), w, req)
+		return
+	}
+	token, _ := idToken(accessData)
+	if err == nil {
+		// the upstream token vault may keep the tokens once the login succeeded
+		req = upstreamtoken.WithTokens(req, identity.GetProviderName(), accessData)
+	}
+	h.loginIdentity(w, req, identity, err, token, state)
+}
+
+// loginIdentity logs in the identity the provider returned, or handles the error of the provider. The ID token is
+// optional.
+func (h *Handler) loginIdentity(w http.ResponseWriter, req *http.Request, identity authapi.UserIdentityInfo, err error, idToken, state string) {
+	logger := logging.FromRequest(req)
 	if err != nil {
 		var authorizationDeniedError api.AuthorizationDeniedError
 		var authorizationFailedError api.AuthorizationFailedError
 		switch {
 		case errors.As(err, &authorizationDeniedError):
-			klog.V(4).Infof("Authorization denied: %v", authorizationDeniedError)
+			logger.Info(4, "Authorization denied", "err", authorizationDeniedError)
 			audit.AddUsernameAnnotation(req, authorizationDeniedError.Identity().GetProviderPreferredUserName())
 			audit.AddDecisionAnnotation(req, audit.DenyDecision)
 			h.handleError(err, w, req)
 
 		case errors.As(err, &authorizationFailedError):
-			klog.V(4).Infof("Authorization failed: %v", authorizationFailedError)
+			logger.Info(4, "Authorization failed", "err", authorizationFailedError)
 			audit.AddUsernameAnnotation(req, authorizationFailedError.Identity().GetProviderPreferredUserName())
 			audit.AddDecisionAnnotation(req, audit.ErrorDecision)
 			h.handleError(err, w, req)
 
 		default:
-			klog.V(4).Infof("Error getting userIdentityInfo info: %v", err)
+			logger.Info(4, "Error getting userIdentityInfo info", "err", err)
 			audit.AddDecisionAnnotation(req, audit.ErrorDecision)
-			h.handleError(err, w, req)
+			h.handleError(api.NewIdentityProviderError(err), w, req)
 		}
 		return
 	}
 
-	userInfo, err := h.mapper.UserFor(identity)
+	logger = logger.WithValues("identity", identity.GetProviderName()+":"+identity.GetProviderUserName())
+	var userInfo user.Info
+	if budgetErr := deadline.Call(req.Context(), func() { userInfo, err = h.mapper.UserFor(identity) }); budgetErr != nil {
+		logger.Info(2, "Creating or updating the mapping did not complete", "err", budgetErr)
+		audit.AddDecisionAnnotation(req, audit.ErrorDecision)
+		h.handleError(budgetErr, w, req)
+		return
+	}
 	if err != nil {
-		klog.V(4).Infof("Error creating or updating mapping for: %#v due to %v", identity, err)
+		var authorizationDeniedError api.AuthorizationDeniedError
+		if errors.As(err, &authorizationDeniedError) {
+			logger.Info(4, "Authorization denied", "err", authorizationDeniedError)
+			audit.AddUsernameAnnotation(req, identity.GetProviderPreferredUserName())
+			audit.AddDecisionAnnotation(req, audit.DenyDecision)
+			h.handleError(err, w, req)
+			return
+		}
+		logger.Info(4, "Error creating or updating mapping", "err", err)
 		audit.AddDecisionAnnotation(req, audit.ErrorDecision)
 		h.handleError(err, w, req)
 		return
 	}
-	klog.V(4).Infof("Got userIdentityMapping: %#v", userInfo)
+	req = logging.WithValues(req, "user", userInfo.GetName())
+	logger.Info(4, "Got userIdentityMapping", "user", userInfo.GetName(), "groups", userInfo.GetGroups())
 	audit.AddUsernameAnnotation(req, userInfo.GetName())
 	audit.AddDecisionAnnotation(req, audit.AllowDecision)
 
+	// remember the ID token so that the session at the provider can be ended when the user logs out, and how the
+	// user authenticated at the provider
+	if len(idToken) > 0 {
+		req = session.WithProviderSession(req, session.ProviderSession{Provider: identity.GetProviderName(), IDToken: idToken})
+		userInfo = handlers.WithAuthenticationMethods(userInfo, idTokenMethods(idToken))
+	}
+
 	_, err = h.success.AuthenticationSucceeded(userInfo, state, w, req)
 	if err != nil {
-		klog.V(4).Infof("Error calling success handler: %v", err)
+		logger.Info(4, "Error calling success handler", "user", userInfo.GetName(), "err", err)
 		h.handleError(err, w, req)
 		return
 	}
@@ -231,13 +392,44 @@ func (h *Handler) handleError(err error, w http.ResponseWriter, req *http.Reques
 		return
 	}
 
-	klog.V(4).Infof("handle error failed for err: %v", err)
+	logging.FromRequest(req).Info(4, "Error handler failed", "err", err)
 	http.Error(w, "An error occured", http.StatusInternalServerError)
 }
 
+// withProviderSelection lets error pages link to the provider selection of the authorize request in a state of
+// defaultState, other states are ignored. The state must have been checked.
+func withProviderSelection(req *http.Request, state string) *http.Request {
+	values, err := decodeState(state)
+	if err != nil {
+		return req
+	}
+	then := values.Get("then")
+	thenURL, err := url.Parse(then)
+	if err != nil || !redirect.IsServerRelativeURL(then) {
+		return req
+	}
+	// the authorize request selected this provider with the idp or idp_hint parameter of the union authentication
+	// handler, or the provider was remembered and the selection page skipped
+	query := thenURL.Query()
+	query.Del("idp")
+	query.Del(handlers.IdentityProviderHintParam)
+	query.Set(selectprovider.PromptParam, selectprovider.SelectAccountPrompt)
+	thenURL.RawQuery = query.Encode()
+	return errorpage.WithProviderSelectionURL(req, thenURL.String())
+}
+
+// continueAttempt restores the correlation ID of the login attempt from a state of defaultState, other states are
+// ignored
+func continueAttempt(w http.ResponseWriter, req *http.Request, state string) {
+	if values, err := decodeState(state); err == nil {
+		correlation.Continue(w, req, values.Get(correlationParam))
+	}
+}
+
 // defaultState provides default state-building, validation, and parsing to contain CSRF and "then" redirection
 type defaultState struct {
-	csrf csrf.CSRF
+	csrf      csrf.CSRF
+	redirects *redirect.Validator
 }
 
 // RedirectorState combines state generation/verification with redirections on authentication success and error
@@ -247,8 +439,10 @@ type RedirectorState interface {
 	handlers.AuthenticationErrorHandler
 }
 
-func CSRFRedirectingState(csrf csrf.CSRF) RedirectorState {
-	return &defaultState{csrf: csrf}
+// CSRFRedirectingState returns the default state. The "then" URLs of states must be allowed by redirects, and users are
+// sent back to them with their loop count incremented.
+func CSRFRedirectingState(csrf csrf.CSRF, redirects *redirect.Validator) RedirectorState {
+	return &defaultState{csrf: csrf, redirects: redirects}
 }
 
 func (d *defaultState) Generate(w http.ResponseWriter, req *http.Request) (string, error) {
@@ -261,6 +455,10 @@ func (d *defaultState) Generate(w http.ResponseWriter, req *http.Request) (strin
 		"csrf": {d.csrf.Generate(w, req)},
 		"then": {then},
 	}
+	// the callback continues the login attempt even if the browser lost its correlation cookie
+	if id := correlation.ID(req); len(id) > 0 {
+		state.Set(correlationParam, id)
+	}
 
 	return encodeState(state), nil
 }
@@ -275,9 +473,13 @@ func (d *defaultState) Check(state string, req *http.Request) (bool, error) {
 		return false, fmt.Errorf("state did not contain a valid CSRF token")
 	}
 
-	if then := values.Get("then"); len(then) == 0 {
+	then := values.Get("then")
+	if len(then) == 0 {
 		return false, errors.New("state did not contain a redirect")
 	}
+	if !d.redirects.Allows(then) {
+		return false, fmt.Errorf("state contained a redirect that is not allowed: %q", then)
+	}
 
 	return true, nil
 }
@@ -292,6 +494,13 @@ func (d *defaultState) AuthenticationSucceeded(user user.Info, state string, w h
 	if len(then) == 0 {
 		return false, errors.New("no redirect given")
 	}
+	if !d.redirects.Allows(then) {
+		return false, fmt.Errorf("redirect is not allowed: %q", then)
+	}
+	then, err = d.redirects.Next(then)
+	if err != nil {
+		return false, err
+	}
 
 	http.Redirect(w, req, then, http.StatusFound)
 	return true, nil
@@ -302,8 +511,8 @@ func (d *defaultState) AuthenticationSucceeded(user user.Info, state string, w h
 // In any other case, or if an error is encountered, returns false and the original error
 func (d *defaultState) AuthenticationError(err error, w http.ResponseWriter, req *http.Request) (bool, error) {
 	// only handle errors that came from the remote OAuth provider...
-	osinErr, ok := err.(*osincli.Error)
-	if !ok {
+	var osinErr *osincli.Error
+	if !errors.As(err, &osinErr) {
 		return false, err
 	}
 
@@ -323,6 +532,7 @@ func (d *defaultState) AuthenticationError(err error, w http.ResponseWriter, req
 	if err != nil {
 		return false, err
 	}
+	correlation.Continue(w, req, values.Get(correlationParam))
 
 	// if it contains a redirect...
 	then := values.Get("then")
@@ -364,3 +574,32 @@ func decodeState(state string) (url.Values, error) {
 	}
 	return url.ParseQuery(string(decodedState))
 }
+
+// idToken returns the ID token of the token response, if any
+func idToken(accessData *osincli.AccessData) (string, bool) {
+	if accessData == nil {
+		return "", false
+	}
+	idToken, ok := accessData.ResponseData["id_token"].(string)
+	return idToken, ok && len(idToken) > 0
+}
+
+// idTokenMethods returns the amr claim of an ID token. The token was received from the token endpoint of the provider,
+// so its signature does not need to be verified to trust it (OpenID Connect Core 1.0, section 3.1.3.7).
+func idTokenMethods(idToken string) []string {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil
+	}
+	claims := struct {
+		AMR []string `json:"amr"`
+	}{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil
+	}
+	return claims.AMR
+}
@@ -1,17 +1,25 @@
 package external
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"testing"
 	"time"
 
 	"github.com/RangelReale/osincli"
 	"github.com/openshift/oauth-server/pkg/api"
 	"github.com/openshift/oauth-server/pkg/oauth/handlers"
+	"github.com/openshift/oauth-server/pkg/server/cookies"
+	"github.com/openshift/oauth-server/pkg/server/correlation"
 	"github.com/openshift/oauth-server/pkg/server/csrf"
+	"github.com/openshift/oauth-server/pkg/server/deadline"
+	"github.com/openshift/oauth-server/pkg/server/errorpage"
+	"github.com/openshift/oauth-server/pkg/server/redirect"
 	auditapi "k8s.io/apiserver/pkg/apis/audit"
 	"k8s.io/apiserver/pkg/audit"
 	"k8s.io/apiserver/pkg/authentication/user"
@@ -162,9 +170,9 @@ func TestRedirectingStateValidCSRF(t *testing.T) {
 	fakeCSRF := &csrf.FakeCSRF{
 		Token: "xyz",
 	}
-	redirectingState := CSRFRedirectingState(fakeCSRF)
+	redirectingState := CSRFRedirectingState(fakeCSRF, nil)
 
-	req, _ := http.NewRequest("GET", "http://www.example.com", nil)
+	req, _ := http.NewRequest("GET", "/oauth/authorize", nil)
 	state, err := redirectingState.Generate(httptest.NewRecorder(), req)
 	if err != nil {
 		t.Fatalf("Unexpected error: %#v", err)
@@ -185,9 +193,9 @@ func TestRedirectingStateInvalidCSRF(t *testing.T) {
 	fakeCSRF := &csrf.FakeCSRF{
 		Token: "xyz",
 	}
-	redirectingState := CSRFRedirectingState(fakeCSRF)
+	redirectingState := CSRFRedirectingState(fakeCSRF, nil)
 
-	req, _ := http.NewRequest("GET", "http://www.example.com", nil)
+	req, _ := http.NewRequest("GET", "/oauth/authorize", nil)
 	state, err := redirectingState.Generate(httptest.NewRecorder(), req)
 	if err != nil {
 		t.Fatalf("Unexpected error: %#v", err)
@@ -208,12 +216,12 @@ func TestRedirectingStateInvalidCSRF(t *testing.T) {
 }
 
 func TestRedirectingStateSuccess(t *testing.T) {
-	originalURL := "http://www.example.com"
+	originalURL := "/oauth/authorize?client_id=console"
 
 	fakeCSRF := &csrf.FakeCSRF{
 		Token: "xyz",
 	}
-	redirectingState := CSRFRedirectingState(fakeCSRF)
+	redirectingState := CSRFRedirectingState(fakeCSRF, nil)
 
 	req, _ := http.NewRequest("GET", originalURL, nil)
 	state, err := redirectingState.Generate(httptest.NewRecorder(), req)
@@ -238,13 +246,13 @@ func TestRedirectingStateSuccess(t *testing.T) {
 }
 
 func TestRedirectingStateOAuthError(t *testing.T) {
-	originalURL := "http://www.example.com"
-	expectedURL := "http://www.example.com?error=access_denied"
+	originalURL := "/oauth/authorize?client_id=console"
+	expectedURL := "/oauth/authorize?client_id=console&error=access_denied"
 
 	fakeCSRF := &csrf.FakeCSRF{
 		Token: "xyz",
 	}
-	redirectingState := CSRFRedirectingState(fakeCSRF)
+	redirectingState := CSRFRedirectingState(fakeCSRF, nil)
 
 	req, _ := http.NewRequest("GET", originalURL, nil)
 	state, err := redirectingState.Generate(httptest.NewRecorder(), req)
@@ -275,7 +283,7 @@ func TestRedirectingStateError(t *testing.T) {
 	fakeCSRF := &csrf.FakeCSRF{
 		Token: "xyz",
 	}
-	redirectingState := CSRFRedirectingState(fakeCSRF)
+	redirectingState := CSRFRedirectingState(fakeCSRF, nil)
 
 	req2, _ := http.NewRequest("GET", "http://www.example.com/callback", nil)
 	recorder := httptest.NewRecorder()
@@ -290,6 +298,44 @@ func TestRedirectingStateError(t *testing.T) {
 	}
 }
 
+func TestRedirectingStateRedirects(t *testing.T) {
+	redirectingState := CSRFRedirectingState(&csrf.FakeCSRF{Token: "xyz"}, redirect.NewValidator([]string{"/oauth/authorize"}, 2))
+	callback := httptest.NewRequest("GET", "/oauth2callback/github", nil)
+
+	state, err := redirectingState.Generate(httptest.NewRecorder(), httptest.NewRequest("GET", "/other?client_id=console", nil))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if ok, err := redirectingState.Check(state, callback); ok || err == nil {
+		t.Errorf("Expected a state with a redirect outside the allowed paths to be invalid, got %v %v", ok, err)
+	}
+
+	then := "/oauth/authorize?client_id=console"
+	for _, expected := range []string{"/oauth/authorize?client_id=console&login_loop=1", "/oauth/authorize?client_id=console&login_loop=2"} {
+		state, err := redirectingState.Generate(httptest.NewRecorder(), httptest.NewRequest("GET", then, nil))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		recorder := httptest.NewRecorder()
+		if handled, err := redirectingState.AuthenticationSucceeded(&user.DefaultInfo{}, state, recorder, callback); !handled || err != nil {
+			t.Fatalf("Expected handled request, got %v %v", handled, err)
+		}
+		if then = recorder.Header().Get("Location"); then != expected {
+			t.Errorf("Expected redirect to %s, got %s", expected, then)
+		}
+	}
+
+	// the login keeps coming back, e.g. because the browser drops the session cookie
+	state, err = redirectingState.Generate(httptest.NewRecorder(), httptest.NewRequest("GET", then, nil))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, err = redirectingState.AuthenticationSucceeded(&user.DefaultInfo{}, state, httptest.NewRecorder(), callback)
+	if !errors.As(err, &api.RedirectLoopError{}) {
+		t.Errorf("Expected a redirect loop error, got %v", err)
+	}
+}
+
 type provider struct {
 	name     string
 	username string
@@ -326,3 +372,220 @@ func (h *authenticationHandler) AuthenticationError(error, http.ResponseWriter,
 	h.failure = true
 	return true, nil
 }
+
+func TestRedirectingStateCorrelation(t *testing.T) {
+	redirectingState := CSRFRedirectingState(&csrf.FakeCSRF{Token: "xyz"}, nil)
+
+	var generatedID, state string
+	generate := correlation.WithCorrelationID(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		generatedID = correlation.ID(req)
+		var err error
+		if state, err = redirectingState.Generate(w, req); err != nil {
+			t.Fatalf("Unexpected error: %#v", err)
+		}
+	}), cookies.Options{}, func(*http.Request) bool { return true })
+	generate.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/oauth/authorize", nil))
+	if len(generatedID) == 0 {
+		t.Fatalf("Expected a correlation ID for the login attempt")
+	}
+
+	// the callback comes from a browser that lost the correlation cookie
+	var continuedID string
+	callback := correlation.WithCorrelationID(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		osinErr := &osincli.Error{Id: "access_denied", State: state}
+		if handled, err := redirectingState.AuthenticationError(osinErr, w, req); !handled || err != nil {
+			t.Fatalf("Expected handled request, got %v %v", handled, err)
+		}
+		continuedID = correlation.ID(req)
+	}), cookies.Options{}, func(*http.Request) bool { return false })
+	recorder := httptest.NewRecorder()
+	callback.ServeHTTP(recorder, httptest.NewRequest("GET", "http://www.example.com/callback", nil))
+	if continuedID != generatedID {
+		t.Errorf("Expected the login attempt %s to continue, got %q", generatedID, continuedID)
+	}
+	if header := recorder.Header().Get(correlation.Header); header != generatedID {
+		t.Errorf("Expected the correlation header %s, got %q", generatedID, header)
+	}
+}
+
+func TestWithProviderSelection(t *testing.T) {
+	redirectingState := CSRFRedirectingState(&csrf.FakeCSRF{Token: "xyz"}, nil)
+	renderer, err := errorpage.NewErrorPageTemplateRenderer("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	errorPage, err := errorpage.NewErrorPageHandlerWithHelp(renderer, nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := map[string]struct {
+		Then     string
+		Expected string
+	}{
+		"authorize request": {
+			Then:     "/oauth/authorize?client_id=console&idp=github&response_type=code",
+			Expected: "/oauth/authorize?client_id=console&prompt=select_account&response_type=code",
+		},
+		"absolute URL": {
+			Then: "https://www.example.com/oauth/authorize?client_id=console&idp=github",
+		},
+	}
+
+	for k, testCase := range testCases {
+		state, err := redirectingState.Generate(httptest.NewRecorder(), httptest.NewRequest("GET", testCase.Then, nil))
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", k, err)
+		}
+		req := httptest.NewRequest("GET", "/oauth2callback/github", nil)
+		req.Header.Set("Accept", "application/json")
+		recorder := httptest.NewRecorder()
+		if handled, err := errorPage.JSON().AuthenticationError(errors.New("failed"), recorder, withProviderSelection(req, state)); !handled || err != nil {
+			t.Fatalf("%s: expected handled request, got %v %v", k, handled, err)
+		}
+		var response errorpage.ErrorResponse
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+			t.Fatalf("%s: unexpected error: %v", k, err)
+		}
+		if response.ProviderSelectionURI != testCase.Expected {
+			t.Errorf("%s: expected provider selection %q, got %q", k, testCase.Expected, response.ProviderSelectionURI)
+		}
+	}
+}
+
+// hintingProvider passes the login hint in the login parameter
+type hintingProvider struct {
+	Provider
+}
+
+func (hintingProvider) NewConfig() (*osincli.ClientConfig, error) {
+	return &osincli.ClientConfig{ClientId: "client", AuthorizeUrl: "https://idp.example.com/authorize", TokenUrl: "https://idp.example.com/token"}, nil
+}
+
+func (hintingProvider) GetTransport() (http.RoundTripper, error) {
+	return nil, nil
+}
+
+func (hintingProvider) AddCustomParameters(*osincli.AuthorizeRequest) {}
+
+func (hintingProvider) AddHintParameters(req *osincli.AuthorizeRequest, hints Hints) {
+	req.CustomParameters["login"] = hints.Login
+	req.CustomParameters["domain"] = hints.Domain
+}
+
+func TestAuthenticationRedirectHints(t *testing.T) {
+	redirector, _, err := NewExternalOAuthRedirector(hintingProvider{}, CSRFRedirectingState(&csrf.FakeCSRF{Token: "xyz"}, nil), "https://www.example.com/oauth2callback/idp", nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/oauth/authorize?client_id=console&login_hint=bob%40example.com&domain_hint=example.com", nil)
+	if err := redirector.AuthenticationRedirect(recorder, req); err != nil {
+		t.Fatal(err)
+	}
+	location, err := url.Parse(recorder.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if login := location.Query().Get("login"); login != "bob@example.com" {
+		t.Errorf("expected the login hint bob@example.com, got %q", login)
+	}
+	if domain := location.Query().Get("domain"); domain != "example.com" {
+		t.Errorf("expected the domain hint example.com, got %q", domain)
+	}
+}
+
+type ticketProvider struct {
+	hintingProvider
+	service string
+}
+
+func (ticketProvider) LoginURL(service string) string {
+	return "https://cas.example.com/login?" + url.Values{"service": {service}}.Encode()
+}
+
+func (p *ticketProvider) ValidateTicket(service, ticket string) (api.UserIdentityInfo, error) {
+	p.service = service
+	if ticket != "ST-1" {
+		return nil, errors.New("INVALID_TICKET")
+	}
+	return api.NewDefaultUserIdentityInfo("cas", "bob"), nil
+}
+
+func TestTicketProvider(t *testing.T) {
+	provider := &ticketProvider{}
+	authHandler := new(authenticationHandler)
+	redirector, callback, err := NewExternalOAuthRedirector(provider, CSRFRedirectingState(&csrf.FakeCSRF{Token: "xyz"}, nil), "https://www.example.com/oauth2callback/cas", authHandler, authHandler, new(userIdentityMapper))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := httptest.NewRecorder()
+	if err := redirector.AuthenticationRedirect(recorder, httptest.NewRequest("GET", "/oauth/authorize?client_id=console", nil)); err != nil {
+		t.Fatal(err)
+	}
+	location, err := url.Parse(recorder.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := location.Query().Get("service")
+	if location.Host != "cas.example.com" || len(service) == 0 {
+		t.Fatalf("unexpected redirect to %s", location)
+	}
+
+	// the provider sends the user back to the service with the ticket
+	callback.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", service+"&ticket=ST-1", nil))
+	if !authHandler.success || authHandler.failure {
+		t.Errorf("expected a successful login, got %#v", authHandler)
+	}
+	if provider.service != service {
+		t.Errorf("expected the ticket to be validated for %s, got %s", service, provider.service)
+	}
+
+	*authHandler = authenticationHandler{}
+	callback.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", service+"&ticket=ST-2", nil))
+	if authHandler.success || !authHandler.failure {
+		t.Errorf("expected an invalid ticket to fail, got %#v", authHandler)
+	}
+}
+
+// blockingProvider returns identities once it is released
+type blockingProvider struct {
+	hintingProvider
+	release chan struct{}
+}
+
+func (p blockingProvider) GetUserIdentity(*osincli.AccessData) (api.UserIdentityInfo, error) {
+	<-p.release
+	return api.NewDefaultUserIdentityInfo("idp", "bob"), nil
+}
+
+type errorRecorder struct {
+	err error
+}
+
+func (r *errorRecorder) AuthenticationError(err error, w http.ResponseWriter, req *http.Request) (bool, error) {
+	r.err = err
+	return true, nil
+}
+
+func TestLoginBudget(t *testing.T) {
+	provider := blockingProvider{release: make(chan struct{})}
+	defer close(provider.release)
+	success, failures := new(authenticationHandler), new(errorRecorder)
+	mapper := new(userIdentityMapper)
+	h, err := newHandler(provider, CSRFRedirectingState(&csrf.FakeCSRF{Token: "xyz"}, nil), "https://www.example.com/oauth2callback/idp", success, failures, mapper)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	req := httptest.NewRequest("GET", "/oauth2callback/idp", nil).WithContext(ctx)
+	h.login(httptest.NewRecorder(), req, &osincli.AccessData{AccessToken: "token"}, "")
+	if !errors.Is(failures.err, deadline.ErrExceeded) {
+		t.Errorf("expected the login to fail once its budget is spent, got %v", failures.err)
+	}
+	if success.success || mapper.wasCalled {
+		t.Error("expected the login not to continue")
+	}
+}
@@ -0,0 +1,55 @@
+package external
+
+import (
+	"net/http"
+	"strings"
+
+	authapi "github.com/openshift/oauth-server/pkg/api"
+	"github.com/openshift/oauth-server/pkg/oauth/handlers"
+)
+
+// NewExternalOAuthRedirectorForHosts is NewExternalOAuthRedirector for servers that are reachable under several
+// hosts. Users are sent back to the callback under the host they used, alternateRedirectURLs maps the alternate
+// hosts to their callback URLs. Requests for other hosts use redirectURL.
+func NewExternalOAuthRedirectorForHosts(provider Provider, state State, redirectURL string, alternateRedirectURLs map[string]string, success handlers.AuthenticationSuccessHandler, errorHandler handlers.AuthenticationErrorHandler, mapper authapi.UserIdentityMapper) (handlers.AuthenticationRedirector, http.Handler, error) {
+	if len(alternateRedirectURLs) == 0 {
+		return NewExternalOAuthRedirector(provider, state, redirectURL, success, errorHandler, mapper)
+	}
+
+	defaultHandler, err := newHandler(provider, state, redirectURL, success, errorHandler, mapper)
+	if err != nil {
+		return nil, nil, err
+	}
+	h := &hostHandler{defaultHandler: defaultHandler, byHost: map[string]*Handler{}}
+	for host, alternateRedirectURL := range alternateRedirectURLs {
+		handler, err := newHandler(provider, state, alternateRedirectURL, success, errorHandler, mapper)
+		if err != nil {
+			return nil, nil, err
+		}
+		h.byHost[strings.ToLower(host)] = handler
+	}
+	return h, h, nil
+}
+
+// hostHandler picks the handler whose callback URL is under the host of the request
+type hostHandler struct {
+	defaultHandler *Handler
+	byHost         map[string]*Handler
+}
+
+func (h *hostHandler) handlerFor(req *http.Request) *Handler {
+	if handler, ok := h.byHost[strings.ToLower(req.Host)]; ok {
+		return handler
+	}
+	return h.defaultHandler
+}
+
+// AuthenticationRedirect implements oauth.handlers.RedirectAuthHandler
+func (h *hostHandler) AuthenticationRedirect(w http.ResponseWriter, req *http.Request) error {
+	return h.handlerFor(req).AuthenticationRedirect(w, req)
+}
+
+// ServeHTTP handles the callback request in response to an external oauth flow
+func (h *hostHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	h.handlerFor(req).ServeHTTP(w, req)
+}
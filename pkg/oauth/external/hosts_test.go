@@ -0,0 +1,68 @@
+package external
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/RangelReale/osincli"
+)
+
+type configProvider struct {
+	Provider
+}
+
+func (configProvider) NewConfig() (*osincli.ClientConfig, error) {
+	return &osincli.ClientConfig{
+		ClientId:     "client",
+		AuthorizeUrl: "https://idp.example.com/authorize",
+		TokenUrl:     "https://idp.example.com/token",
+	}, nil
+}
+
+func (configProvider) GetTransport() (http.RoundTripper, error) {
+	return nil, nil
+}
+
+func (configProvider) AddCustomParameters(*osincli.AuthorizeRequest) {}
+
+type fixedState struct{}
+
+func (fixedState) Generate(http.ResponseWriter, *http.Request) (string, error) {
+	return "state", nil
+}
+
+func (fixedState) Check(string, *http.Request) (bool, error) {
+	return true, nil
+}
+
+func TestExternalOAuthRedirectorForHosts(t *testing.T) {
+	redirector, _, err := NewExternalOAuthRedirectorForHosts(configProvider{}, fixedState{},
+		"https://oauth.example.com/auth/oauth2callback/idp",
+		map[string]string{"oauth.internal.example.com": "https://oauth.internal.example.com/auth/oauth2callback/idp"},
+		nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for host, expected := range map[string]string{
+		"oauth.example.com":          "https://oauth.example.com/auth/oauth2callback/idp",
+		"OAuth.Internal.example.com": "https://oauth.internal.example.com/auth/oauth2callback/idp",
+		"other.example.com":          "https://oauth.example.com/auth/oauth2callback/idp",
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/oauth/authorize", nil)
+		req.Host = host
+		resp := httptest.NewRecorder()
+		if err := redirector.AuthenticationRedirect(resp, req); err != nil {
+			t.Fatal(err)
+		}
+		location, err := url.Parse(resp.Header().Get("Location"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if redirectURI := location.Query().Get("redirect_uri"); redirectURI != expected {
+			t.Errorf("expected the redirect URI %s for host %s, got %s", expected, host, redirectURI)
+		}
+	}
+}
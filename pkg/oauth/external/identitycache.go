@@ -0,0 +1,64 @@
+package external
+
+import (
+	"crypto/sha256"
+	"time"
+
+	"github.com/RangelReale/osincli"
+	"k8s.io/apimachinery/pkg/util/cache"
+
+	authapi "github.com/openshift/oauth-server/pkg/api"
+	metrics "github.com/openshift/oauth-server/pkg/prometheus"
+)
+
+const (
+	// DefaultIdentityCacheTTL is how long the identity of an access token is cached by default
+	DefaultIdentityCacheTTL = 30 * time.Second
+	// DefaultIdentityCacheMaxEntries is the number of access tokens whose identity is cached by default
+	DefaultIdentityCacheMaxEntries = 1000
+)
+
+// WithIdentityCache returns provider with the identities it returns for access tokens cached for ttl, so that
+// retried callbacks and double submits do not look up the user at the provider again. Only a hash of the access
+// tokens is kept, errors are not cached. Ticket providers do not look up users with access tokens and are returned
+// as they are.
+func WithIdentityCache(name string, provider Provider, ttl time.Duration, maxEntries int) Provider {
+	if _, ok := provider.(TicketProvider); ok {
+		return provider
+	}
+	p := identityCacheProvider{Provider: provider, name: name, ttl: ttl, identities: cache.NewLRUExpireCache(maxEntries)}
+	// the handler passes hints only to providers that implement HintingProvider
+	if hinting, ok := provider.(HintingProvider); ok {
+		return hintingIdentityCacheProvider{identityCacheProvider: p, HintingProvider: hinting}
+	}
+	return p
+}
+
+type identityCacheProvider struct {
+	Provider
+	name       string
+	ttl        time.Duration
+	identities *cache.LRUExpireCache
+}
+
+// GetUserIdentity implements external/interfaces/Provider.GetUserIdentity
+func (p identityCacheProvider) GetUserIdentity(data *osincli.AccessData) (authapi.UserIdentityInfo, error) {
+	key := sha256.Sum256([]byte(data.AccessToken))
+	if identity, ok := p.identities.Get(key); ok {
+		metrics.RecordIdentityCacheLookup(p.name, metrics.CacheHitResult)
+		return identity.(authapi.UserIdentityInfo), nil
+	}
+	metrics.RecordIdentityCacheLookup(p.name, metrics.CacheMissResult)
+
+	identity, err := p.Provider.GetUserIdentity(data)
+	if err != nil {
+		return identity, err
+	}
+	p.identities.Add(key, identity, p.ttl)
+	return identity, nil
+}
+
+type hintingIdentityCacheProvider struct {
+	identityCacheProvider
+	HintingProvider
+}
@@ -0,0 +1,62 @@
+package external
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/RangelReale/osincli"
+
+	"github.com/openshift/oauth-server/pkg/api"
+)
+
+// countingProvider returns an identity named after the access token and counts the lookups
+type countingProvider struct {
+	hintingProvider
+	lookups int
+	err     error
+}
+
+func (p *countingProvider) GetUserIdentity(data *osincli.AccessData) (api.UserIdentityInfo, error) {
+	p.lookups++
+	if p.err != nil {
+		return nil, p.err
+	}
+	return api.NewDefaultUserIdentityInfo("idp", data.AccessToken), nil
+}
+
+func TestWithIdentityCache(t *testing.T) {
+	counting := &countingProvider{}
+	provider := WithIdentityCache("idp", counting, time.Minute, 10)
+	if _, ok := provider.(HintingProvider); !ok {
+		t.Error("expected the hints of the provider to be kept")
+	}
+
+	for _, token := range []string{"alice", "alice", "bob"} {
+		identity, err := provider.GetUserIdentity(&osincli.AccessData{AccessToken: token})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if identity.GetProviderUserName() != token {
+			t.Errorf("expected the identity of %s, got %s", token, identity.GetProviderUserName())
+		}
+	}
+	if counting.lookups != 2 {
+		t.Errorf("expected 2 lookups at the provider, got %d", counting.lookups)
+	}
+
+	// errors are not cached
+	counting.err = errors.New("unavailable")
+	for i := 0; i < 2; i++ {
+		if _, err := provider.GetUserIdentity(&osincli.AccessData{AccessToken: "carol"}); err == nil {
+			t.Error("expected the error of the provider")
+		}
+	}
+	if counting.lookups != 4 {
+		t.Errorf("expected failed lookups to be retried, got %d lookups", counting.lookups)
+	}
+
+	if ticketing := (&ticketProvider{}); WithIdentityCache("cas", ticketing, time.Minute, 10) != Provider(ticketing) {
+		t.Error("expected ticket providers not to be wrapped")
+	}
+}
@@ -22,6 +22,33 @@ type Provider interface {
 	GetUserIdentity(*osincli.AccessData) (authapi.UserIdentityInfo, error)
 }
 
+// Hints describe the user of an authorize request, e.g. so that a portal can pre-fill the email of the user at the
+// provider. They are taken from the login_hint, domain_hint and max_age parameters of the authorize request.
+type Hints struct {
+	// Login is the login name or email the user probably logs in with
+	Login string
+	// Domain is the domain of the account of the user, e.g. to skip the home realm discovery of the provider
+	Domain string
+	// MaxAge is the maximum number of seconds since the user logged in at the provider, if the request has one
+	MaxAge string
+}
+
+// HintingProvider is implemented by providers that pass hints about the user to their login page
+type HintingProvider interface {
+	// AddHintParameters adds the parameters of the provider for the hints to its authorize request
+	AddHintParameters(*osincli.AuthorizeRequest, Hints)
+}
+
+// TicketProvider is implemented by providers that send users back to the callback with a ticket instead of an
+// authorization code, e.g. CAS. The callback URL with the state is the service the ticket is issued for. The token
+// URL of their config is only used to check that the provider is reachable.
+type TicketProvider interface {
+	// LoginURL returns the URL of the login page of the provider, which sends users back to service with a ticket
+	LoginURL(service string) string
+	// ValidateTicket returns the identity of the user the ticket was issued to for service
+	ValidateTicket(service, ticket string) (authapi.UserIdentityInfo, error)
+}
+
 // State handles generating and verifying the state parameter round-tripped to an external OAuth flow.
 // Examples: CSRF protection, post authentication redirection
 type State interface {
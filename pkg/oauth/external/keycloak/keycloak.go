@@ -0,0 +1,176 @@
+package keycloak
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"github.com/openshift/oauth-server/pkg/oauth/external"
+	"github.com/openshift/oauth-server/pkg/oauth/external/openid"
+)
+
+const (
+	// https://www.keycloak.org/docs/latest/securing_apps/#endpoints
+	keycloakRealmPath        = "/realms/"
+	keycloakAuthorizePath    = "/protocol/openid-connect/auth"
+	keycloakTokenPath        = "/protocol/openid-connect/token"
+	keycloakJWKSPath         = "/protocol/openid-connect/certs"
+	keycloakEndSessionPath   = "/protocol/openid-connect/logout"
+	keycloakOfflineScope     = "offline_access"
+	keycloakRealmAccessClaim = "realm_access"
+	keycloakClientRolesClaim = "resource_access"
+
+	// Keycloak names the session of the user at the realm session_state, newer versions also set the standard sid
+	// claim to the same value
+	keycloakSessionStateClaim = "session_state"
+	sessionIDClaim            = "sid"
+	// Keycloak issues ID, access and refresh tokens with the same keys, typ tells them apart
+	keycloakTypeClaim = "typ"
+
+	issuerClaim = "iss"
+
+	// RealmRolesKey is the extra attribute of identities with the realm roles of the user, joined with commas
+	RealmRolesKey = "keycloak.realm_roles"
+	// ClientRolesKey is the extra attribute of identities with the roles of the user for the client, joined with commas
+	ClientRolesKey = "keycloak.client_roles"
+	// SessionKey is the extra attribute of identities with the session of the user at the realm
+	SessionKey = "keycloak.session"
+)
+
+var keycloakScopes = []string{"openid", "profile", "email"}
+
+// Config holds the settings of a client of a Keycloak realm
+type Config struct {
+	ClientID     string
+	ClientSecret string
+
+	// URL is the URL of the Keycloak server, e.g. https://sso.example.com, including the /auth path of versions
+	// before 17, e.g. https://sso.example.com/auth
+	URL string
+	// Realm is the name of the realm
+	Realm string
+	// SingleLogout ends the session at the realm when users log out
+	SingleLogout bool
+
+	ExtraScopes              []string
+	ExtraAuthorizeParameters map[string]string
+}
+
+// NewProvider returns an OpenID Connect provider for the client of the realm
+func NewProvider(providerName string, transport http.RoundTripper, config Config) (external.Provider, error) {
+	openIDConfig, err := NewOpenIDConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return openid.NewProvider(providerName, transport, openIDConfig)
+}
+
+// NewOpenIDConfig returns the config of the OpenID Connect provider for the client of the realm. The realm and client
+// roles of users are read from the access token, where Keycloak puts them by default, and mapped into the extra
+// attributes of identities together with the session of the user at the realm. Offline sessions are not requested,
+// the refresh tokens that hold them are never used and they would outlive the logout of the user.
+func NewOpenIDConfig(config Config) (openid.Config, error) {
+	u, err := url.Parse(config.URL)
+	if err != nil || u.Scheme != "https" || len(u.Host) == 0 || len(u.RawQuery) > 0 || len(u.Fragment) > 0 {
+		return openid.Config{}, fmt.Errorf("keycloak URL %q must be an https URL", config.URL)
+	}
+	if len(config.Realm) == 0 || strings.ContainsAny(config.Realm, "/?#") {
+		return openid.Config{}, fmt.Errorf("keycloak realm %q is invalid", config.Realm)
+	}
+	if sets.NewString(config.ExtraScopes...).Has(keycloakOfflineScope) {
+		return openid.Config{}, errors.New("keycloak offline sessions are not supported")
+	}
+	issuer := strings.TrimSuffix(u.String(), "/") + keycloakRealmPath + url.PathEscape(config.Realm)
+
+	scopes := sets.NewString(keycloakScopes...)
+	scopes.Insert(config.ExtraScopes...)
+
+	openIDConfig := openid.Config{
+		ClientID:     config.ClientID,
+		ClientSecret: config.ClientSecret,
+
+		Scopes: scopes.List(),
+
+		ExtraAuthorizeParameters: config.ExtraAuthorizeParameters,
+
+		Issuer: issuer,
+
+		AuthorizeURL: issuer + keycloakAuthorizePath,
+		TokenURL:     issuer + keycloakTokenPath,
+		JWKSURL:      issuer + keycloakJWKSPath,
+
+		IDClaims:                []string{"sub"},
+		PreferredUsernameClaims: []string{"preferred_username"},
+		EmailClaims:             []string{"email"},
+		NameClaims:              []string{"name"},
+		// the groups client scope of the realm adds the groups claim
+		GroupClaims: []string{"groups"},
+
+		AccessTokenClaims: true,
+		ExtraFunc: func(claims map[string]interface{}) map[string]string {
+			return map[string]string{
+				RealmRolesKey:  strings.Join(realmRoles(claims), ","),
+				ClientRolesKey: strings.Join(clientRoles(claims, config.ClientID), ","),
+				SessionKey:     sessionState(claims),
+			}
+		},
+
+		IDTokenValidator: func(idToken map[string]interface{}) error {
+			if iss, _ := idToken[issuerClaim].(string); iss != issuer {
+				return fmt.Errorf("id_token iss claim (%s) did not match the realm (%s)", iss, issuer)
+			}
+			if typ, ok := idToken[keycloakTypeClaim].(string); ok && typ != "ID" {
+				return fmt.Errorf("id_token typ claim (%s) is not ID", typ)
+			}
+			sessionState, _ := idToken[keycloakSessionStateClaim].(string)
+			sid, _ := idToken[sessionIDClaim].(string)
+			if len(sessionState) > 0 && len(sid) > 0 && sessionState != sid {
+				return fmt.Errorf("id_token session_state claim (%s) did not match sid claim (%s)", sessionState, sid)
+			}
+			return nil
+		},
+	}
+	if config.SingleLogout {
+		openIDConfig.EndSessionURL = issuer + keycloakEndSessionPath
+	}
+	return openIDConfig, nil
+}
+
+// realmRoles returns the roles of realm_access.roles
+func realmRoles(claims map[string]interface{}) []string {
+	realmAccess, _ := claims[keycloakRealmAccessClaim].(map[string]interface{})
+	return roles(realmAccess)
+}
+
+// clientRoles returns the roles of resource_access.<client>.roles
+func clientRoles(claims map[string]interface{}, clientID string) []string {
+	resourceAccess, _ := claims[keycloakClientRolesClaim].(map[string]interface{})
+	clientAccess, _ := resourceAccess[clientID].(map[string]interface{})
+	return roles(clientAccess)
+}
+
+func roles(access map[string]interface{}) []string {
+	values, _ := access["roles"].([]interface{})
+	roles := make([]string, 0, len(values))
+	for _, value := range values {
+		if role, ok := value.(string); ok && len(role) > 0 {
+			roles = append(roles, role)
+		}
+	}
+	sort.Strings(roles)
+	return roles
+}
+
+// sessionState returns the session of the user at the realm
+func sessionState(claims map[string]interface{}) string {
+	if sid, _ := claims[sessionIDClaim].(string); len(sid) > 0 {
+		return sid
+	}
+	sessionState, _ := claims[keycloakSessionStateClaim].(string)
+	return sessionState
+}
@@ -0,0 +1,146 @@
+package keycloak
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/RangelReale/osincli"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func TestKeycloak(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/auth/realms/corp/protocol/openid-connect/certs" {
+			http.NotFound(w, r)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: key.Public(), KeyID: "realm", Algorithm: string(jose.RS256), Use: "sig"},
+		}})
+	}))
+	defer server.Close()
+	issuer := server.URL + "/auth/realms/corp"
+
+	p, err := NewProvider("keycloak", server.Client().Transport, Config{
+		ClientID:     "openshift",
+		ClientSecret: "secret",
+		URL:          server.URL + "/auth/",
+		Realm:        "corp",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientConfig, err := p.NewConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clientConfig.AuthorizeUrl != issuer+"/protocol/openid-connect/auth" || clientConfig.TokenUrl != issuer+"/protocol/openid-connect/token" {
+		t.Errorf("unexpected client config %#v", clientConfig)
+	}
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: jose.JSONWebKey{Key: key, KeyID: "realm"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sign := func(claims map[string]interface{}) string {
+		token, err := jwt.Signed(signer).Claims(claims).CompactSerialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	idToken := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss":                issuer,
+			"aud":                "openshift",
+			"typ":                "ID",
+			"sub":                "f1e2d3c4",
+			"preferred_username": "bob",
+			"session_state":      "session-1",
+			"sid":                "session-1",
+		}
+	}
+	accessToken := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss":          issuer,
+			"azp":          "openshift",
+			"typ":          "Bearer",
+			"sub":          "f1e2d3c4",
+			"realm_access": map[string]interface{}{"roles": []string{"user", "admin"}},
+			"resource_access": map[string]interface{}{
+				"openshift": map[string]interface{}{"roles": []string{"cluster-reader"}},
+				"account":   map[string]interface{}{"roles": []string{"manage-account"}},
+			},
+		}
+	}
+	for _, tc := range []struct {
+		name        string
+		idToken     func(map[string]interface{})
+		accessToken func(map[string]interface{})
+		expectError bool
+	}{
+		{name: "valid"},
+		{name: "other realm", idToken: func(c map[string]interface{}) { c["iss"] = server.URL + "/auth/realms/other" }, expectError: true},
+		{name: "refresh token as ID token", idToken: func(c map[string]interface{}) { c["typ"] = "Refresh" }, expectError: true},
+		{name: "mismatched session", idToken: func(c map[string]interface{}) { c["sid"] = "session-2" }, expectError: true},
+		{name: "access token of other client", accessToken: func(c map[string]interface{}) { c["azp"] = "other" }, expectError: true},
+		{name: "access token of other user", accessToken: func(c map[string]interface{}) { c["sub"] = "a1b2c3d4" }, expectError: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			idTokenClaims, accessTokenClaims := idToken(), accessToken()
+			if tc.idToken != nil {
+				tc.idToken(idTokenClaims)
+			}
+			if tc.accessToken != nil {
+				tc.accessToken(accessTokenClaims)
+			}
+			identity, err := p.GetUserIdentity(&osincli.AccessData{
+				AccessToken:  sign(accessTokenClaims),
+				ResponseData: osincli.ResponseData{"id_token": sign(idTokenClaims)},
+			})
+			if tc.expectError != (err != nil) {
+				t.Fatalf("expected error %v, got %v", tc.expectError, err)
+			}
+			if err != nil {
+				return
+			}
+			extra := identity.GetExtra()
+			if identity.GetProviderUserName() != "f1e2d3c4" || extra["preferred_username"] != "bob" {
+				t.Errorf("unexpected identity %#v", identity)
+			}
+			if extra[RealmRolesKey] != "admin,user" || extra[ClientRolesKey] != "cluster-reader" || extra[SessionKey] != "session-1" {
+				t.Errorf("unexpected extra %v", extra)
+			}
+		})
+	}
+}
+
+func TestKeycloakConfig(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		config Config
+		valid  bool
+	}{
+		{name: "valid", config: Config{URL: "https://sso.example.com", Realm: "corp"}, valid: true},
+		{name: "http", config: Config{URL: "http://sso.example.com", Realm: "corp"}},
+		{name: "no realm", config: Config{URL: "https://sso.example.com"}},
+		{name: "realm path", config: Config{URL: "https://sso.example.com", Realm: "corp/../master"}},
+		{name: "offline access", config: Config{URL: "https://sso.example.com", Realm: "corp", ExtraScopes: []string{"offline_access"}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewOpenIDConfig(tc.config)
+			if tc.valid != (err == nil) {
+				t.Errorf("expected valid=%v, got %v", tc.valid, err)
+			}
+		})
+	}
+}
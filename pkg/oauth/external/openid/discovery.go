@@ -0,0 +1,93 @@
+package openid
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// discoveryPath is appended to the issuer to get the location of the discovery document
+// http://openid.net/specs/openid-connect-discovery-1_0.html#ProviderConfigurationRequest
+const discoveryPath = "/.well-known/openid-configuration"
+
+// Metadata holds the subset of the OpenID provider metadata used by the provider
+// http://openid.net/specs/openid-connect-discovery-1_0.html#ProviderMetadata
+type Metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserInfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+	// EndSessionEndpoint is defined by RP-initiated logout
+	// https://openid.net/specs/openid-connect-rpinitiated-1_0.html#OPMetadata
+	EndSessionEndpoint string `json:"end_session_endpoint"`
+}
+
+// DiscoverMetadata fetches the discovery document of the given issuer
+func DiscoverMetadata(issuer string, transport http.RoundTripper) (*Metadata, error) {
+	discoveryURL := strings.TrimSuffix(issuer, "/") + discoveryPath
+
+	client := &http.Client{Transport: transport}
+	resp, err := client.Get(discoveryURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("non-200 response from %s: %d", discoveryURL, resp.StatusCode)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return parseMetadata(issuer, data)
+}
+
+// ReadMetadata reads a pinned snapshot of a discovery document from a file.
+// If issuer is not empty, the issuer of the document must match it.
+func ReadMetadata(issuer, filename string) (*Metadata, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return parseMetadata(issuer, data)
+}
+
+func parseMetadata(issuer string, data []byte) (*Metadata, error) {
+	metadata := &Metadata{}
+	if err := json.Unmarshal(data, metadata); err != nil {
+		return nil, fmt.Errorf("error parsing discovery document: %v", err)
+	}
+
+	// The issuer value returned MUST be identical to the Issuer URL that was directly used to retrieve the configuration information.
+	// http://openid.net/specs/openid-connect-discovery-1_0.html#ProviderConfigurationValidation
+	if len(issuer) > 0 && metadata.Issuer != issuer {
+		return nil, fmt.Errorf("discovery document issuer %q did not match expected issuer %q", metadata.Issuer, issuer)
+	}
+	return metadata, nil
+}
+
+// Apply replaces the endpoints of config with the ones present in the metadata
+func (m *Metadata) Apply(config *Config) {
+	if len(m.Issuer) > 0 {
+		config.Issuer = m.Issuer
+	}
+	if len(m.AuthorizationEndpoint) > 0 {
+		config.AuthorizeURL = m.AuthorizationEndpoint
+	}
+	if len(m.TokenEndpoint) > 0 {
+		config.TokenURL = m.TokenEndpoint
+	}
+	if len(m.UserInfoEndpoint) > 0 {
+		config.UserInfoURL = m.UserInfoEndpoint
+	}
+	if len(m.JWKSURI) > 0 {
+		config.JWKSURL = m.JWKSURI
+	}
+	if len(m.EndSessionEndpoint) > 0 {
+		config.EndSessionURL = m.EndSessionEndpoint
+	}
+}
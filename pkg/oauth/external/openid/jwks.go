@@ -0,0 +1,112 @@
+package openid
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"gopkg.in/square/go-jose.v2"
+)
+
+// minRefreshInterval throttles the fetches of the JWKS URL, so that tokens signed with unknown keys
+// cannot make the server fetch the keys of the provider on every request
+const minRefreshInterval = 30 * time.Second
+
+// remoteKeySet verifies JWT signatures using the keys published at a JWKS URL.
+// Keys are fetched on first use and refreshed when a token is signed with an unknown key,
+// at most once per minRefreshInterval.
+type remoteKeySet struct {
+	url       string
+	transport http.RoundTripper
+	now       func() time.Time
+
+	lock      sync.Mutex
+	keys      []jose.JSONWebKey
+	fetchedAt time.Time
+}
+
+func newRemoteKeySet(url string, transport http.RoundTripper) *remoteKeySet {
+	return &remoteKeySet{url: url, transport: transport, now: time.Now}
+}
+
+// verify returns an error if the signature of the JWT cannot be verified with any of the keys
+func (r *remoteKeySet) verify(jwt string) error {
+	jws, err := jose.ParseSigned(jwt)
+	if err != nil {
+		return fmt.Errorf("error parsing JSON Web Signature: %v", err)
+	}
+	if len(jws.Signatures) != 1 {
+		return fmt.Errorf("expected exactly one signature, got %d", len(jws.Signatures))
+	}
+	keyID := jws.Signatures[0].Header.KeyID
+
+	r.lock.Lock()
+	keys := r.keys
+	r.lock.Unlock()
+	if verifyWithKeys(jws, keyID, keys) {
+		return nil
+	}
+
+	// the provider may have rotated its keys
+	keys, err = r.refresh()
+	if err != nil {
+		return err
+	}
+	if verifyWithKeys(jws, keyID, keys) {
+		return nil
+	}
+	return errors.New("failed to verify id_token signature")
+}
+
+// refresh fetches the keys unless they were fetched less than minRefreshInterval ago, failed fetches included
+func (r *remoteKeySet) refresh() ([]jose.JSONWebKey, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	now := r.now()
+	if !r.fetchedAt.IsZero() && now.Sub(r.fetchedAt) < minRefreshInterval {
+		return r.keys, nil
+	}
+	r.fetchedAt = now
+
+	client := &http.Client{Transport: r.transport}
+	resp, err := client.Get(r.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("non-200 response from JWKS URL: %d", resp.StatusCode)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	keySet := jose.JSONWebKeySet{}
+	if err := json.Unmarshal(data, &keySet); err != nil {
+		return nil, fmt.Errorf("error parsing JWKS: %v", err)
+	}
+	r.keys = keySet.Keys
+	return r.keys, nil
+}
+
+func verifyWithKeys(jws *jose.JSONWebSignature, keyID string, keys []jose.JSONWebKey) bool {
+	for _, key := range keys {
+		if len(keyID) > 0 && key.KeyID != keyID {
+			continue
+		}
+		if key.Use == "enc" {
+			continue
+		}
+		if _, err := jws.Verify(&key); err == nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+package openid
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// backChannelLogoutEvent identifies logout tokens
+	// https://openid.net/specs/openid-connect-backchannel-1_0.html#LogoutToken
+	backChannelLogoutEvent = "http://schemas.openid.net/event/backchannel-logout"
+
+	sessionIDClaim = "sid"
+)
+
+// LogoutToken holds the claims of a verified back-channel logout token
+type LogoutToken struct {
+	// Subject is the sub claim, empty if the token only identifies a session
+	Subject string
+	// SessionID is the sid claim, empty if the token only identifies a subject
+	SessionID string
+	// ID is the jti claim, used to detect replayed tokens
+	ID string
+	// IssuedAt is the iat claim
+	IssuedAt time.Time
+}
+
+// LogoutTokenVerifier is implemented by providers that accept back-channel logout tokens
+type LogoutTokenVerifier interface {
+	// VerifyLogoutToken returns the claims of the logout token if it is valid
+	VerifyLogoutToken(logoutToken string) (*LogoutToken, error)
+}
+
+var _ LogoutTokenVerifier = provider{}
+
+// VerifyLogoutToken validates a logout token as required by
+// https://openid.net/specs/openid-connect-backchannel-1_0.html#Validation
+func (p provider) VerifyLogoutToken(logoutToken string) (*LogoutToken, error) {
+	// logout tokens are not accepted without a signature, otherwise anyone could log out any user
+	if p.keySet == nil {
+		return nil, errors.New("logout tokens cannot be verified without a JWKS URL")
+	}
+	if err := p.keySet.verify(logoutToken); err != nil {
+		return nil, err
+	}
+
+	claims, err := decodeJWT(logoutToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(p.Issuer) > 0 {
+		if issuer, _ := getClaimValue(claims, "iss"); issuer != p.Issuer {
+			return nil, fmt.Errorf("logout token issuer %q did not match expected issuer %q", issuer, p.Issuer)
+		}
+	}
+	audiences, _ := getArrayOrStringClaimValue(claims, "aud")
+	if !hasString(audiences, p.ClientID) {
+		return nil, fmt.Errorf("logout token audience %q did not contain client ID %q", audiences, p.ClientID)
+	}
+
+	issuedAt, ok := claims["iat"].(float64)
+	if !ok {
+		return nil, errors.New("logout token did not contain an 'iat' claim")
+	}
+	if expires, ok := claims["exp"].(float64); ok && time.Unix(int64(expires), 0).Before(time.Now()) {
+		return nil, errors.New("logout token has expired")
+	}
+
+	events, ok := claims["events"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("logout token did not contain an 'events' claim")
+	}
+	if _, ok := events[backChannelLogoutEvent].(map[string]interface{}); !ok {
+		return nil, fmt.Errorf("logout token events did not contain %q", backChannelLogoutEvent)
+	}
+
+	// a nonce distinguishes ID tokens from logout tokens
+	if _, ok := claims["nonce"]; ok {
+		return nil, errors.New("logout token must not contain a 'nonce' claim")
+	}
+
+	token := &LogoutToken{IssuedAt: time.Unix(int64(issuedAt), 0)}
+	token.Subject, _ = getClaimValue(claims, subjectClaim)
+	token.SessionID, _ = getClaimValue(claims, sessionIDClaim)
+	token.ID, _ = getClaimValue(claims, "jti")
+	// the ID is required to detect replayed tokens
+	if len(token.ID) == 0 {
+		return nil, errors.New("logout token did not contain a 'jti' claim")
+	}
+	if len(token.Subject) == 0 && len(token.SessionID) == 0 {
+		return nil, errors.New("logout token must contain a 'sub' or 'sid' claim")
+	}
+	return token, nil
+}
+
+func hasString(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,142 @@
+package openid
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gopkg.in/square/go-jose.v2"
+)
+
+func TestVerifyLogoutToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: key.Public(), KeyID: "current", Algorithm: string(jose.RS256), Use: "sig"},
+		}})
+	}))
+	defer server.Close()
+
+	config := Config{
+		ClientID:     "foo",
+		ClientSecret: "secret",
+		Issuer:       "https://idp",
+		AuthorizeURL: "https://foo",
+		TokenURL:     "https://foo",
+		JWKSURL:      server.URL,
+		Scopes:       []string{"openid"},
+		IDClaims:     []string{"sub"},
+	}
+	p, err := NewProvider("openid", server.Client().Transport, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.JWKSURL = ""
+	unverifiable, err := NewProvider("openid", server.Client().Transport, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	iat := time.Now().Unix()
+	validClaims := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss":    "https://idp",
+			"aud":    []string{"foo", "other"},
+			"iat":    iat,
+			"jti":    "id",
+			"sub":    "user",
+			"sid":    "session",
+			"events": map[string]interface{}{backChannelLogoutEvent: map[string]interface{}{}},
+		}
+	}
+	sign := func(claims map[string]interface{}) string {
+		payload, err := json.Marshal(claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: jose.JSONWebKey{Key: key, KeyID: "current"}}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		jws, err := signer.Sign(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		token, err := jws.CompactSerialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	with := func(key string, value interface{}) string {
+		claims := validClaims()
+		if value == nil {
+			delete(claims, key)
+		} else {
+			claims[key] = value
+		}
+		return sign(claims)
+	}
+
+	for _, tc := range []struct {
+		name        string
+		provider    LogoutTokenVerifier
+		logoutToken string
+		expected    *LogoutToken
+	}{
+		{
+			name:        "valid",
+			logoutToken: sign(validClaims()),
+			expected:    &LogoutToken{Subject: "user", SessionID: "session", ID: "id", IssuedAt: time.Unix(iat, 0)},
+		},
+		{
+			name:        "session only",
+			logoutToken: with("sub", nil),
+			expected:    &LogoutToken{SessionID: "session", ID: "id", IssuedAt: time.Unix(iat, 0)},
+		},
+		{name: "no JWKS", provider: unverifiable.(LogoutTokenVerifier), logoutToken: sign(validClaims())},
+		{name: "unsigned", logoutToken: "eyJhbGciOiJub25lIn0.eyJzdWIiOiJ1c2VyIn0."},
+		{name: "wrong issuer", logoutToken: with("iss", "https://other")},
+		{name: "wrong audience", logoutToken: with("aud", "other")},
+		{name: "no iat", logoutToken: with("iat", nil)},
+		{name: "no jti", logoutToken: with("jti", nil)},
+		{name: "expired", logoutToken: with("exp", time.Now().Add(-time.Minute).Unix())},
+		{name: "no events", logoutToken: with("events", nil)},
+		{name: "other event", logoutToken: with("events", map[string]interface{}{"other": map[string]interface{}{}})},
+		{name: "nonce", logoutToken: with("nonce", "nonce")},
+		{name: "no subject and session", logoutToken: func() string {
+			claims := validClaims()
+			delete(claims, "sub")
+			delete(claims, "sid")
+			return sign(claims)
+		}()},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			verifier := tc.provider
+			if verifier == nil {
+				verifier = p.(LogoutTokenVerifier)
+			}
+			logoutToken, err := verifier.VerifyLogoutToken(tc.logoutToken)
+			if tc.expected == nil {
+				if err == nil {
+					t.Fatalf("expected error, got %#v", logoutToken)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *logoutToken != *tc.expected {
+				t.Errorf("expected %#v, got %#v", tc.expected, logoutToken)
+			}
+		})
+	}
+}
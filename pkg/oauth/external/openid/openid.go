@@ -8,6 +8,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 
 	"github.com/RangelReale/osincli"
@@ -29,20 +30,45 @@ type TokenValidator func(map[string]interface{}) error
 type Config struct {
 	ClientID     string
 	ClientSecret string
+	// ClientSecretBasic sends the client credentials of token requests in the Authorization header instead of the
+	// parameters, which some providers require, e.g. Cognito
+	ClientSecretBasic bool
 
 	Scopes []string
 
 	ExtraAuthorizeParameters map[string]string
+	// DomainHint passes domain hints to the provider in the domain_hint parameter, which Azure AD supports.
+	// Login hints are always passed in the standard login_hint parameter.
+	DomainHint bool
+
+	// Issuer is optional. If set, logout tokens must be issued by it.
+	Issuer string
 
 	AuthorizeURL string
 	TokenURL     string
 	UserInfoURL  string
+	// JWKSURL is optional. If set, the signature of ID tokens is verified using the keys it publishes.
+	JWKSURL string
+	// EndSessionURL is optional. If set, users are redirected to it to end their session at the provider when they log out.
+	EndSessionURL string
 
 	IDClaims                []string
 	PreferredUsernameClaims []string
 	EmailClaims             []string
 	NameClaims              []string
 	GroupClaims             []string
+	// ExtraClaims are copied into the extra attributes of identities under their name, e.g. department. Lists are
+	// joined with commas. Claims never replace the attributes above.
+	ExtraClaims []string
+	// ExtraFunc is optional. It returns further extra attributes of identities derived from the claims, e.g. from
+	// nested claims of a vendor. They never replace the attributes above.
+	ExtraFunc func(claims map[string]interface{}) map[string]string
+
+	// AccessTokenClaims merges the claims of access tokens that are JWTs into the claims of the ID token, e.g. the
+	// roles of Keycloak, which are only in access tokens by default. It requires a JWKS URL, access tokens must be
+	// signed by the provider for the client and the subject of the ID token. Claims of the ID token and userinfo
+	// take precedence.
+	AccessTokenClaims bool
 
 	IDTokenValidator TokenValidator
 }
@@ -50,6 +76,7 @@ type Config struct {
 type provider struct {
 	providerName string
 	transport    http.RoundTripper
+	keySet       *remoteKeySet
 	Config
 }
 
@@ -95,6 +122,22 @@ func NewProvider(providerName string, transport http.RoundTripper, config Config
 		}
 	}
 
+	if len(config.JWKSURL) > 0 {
+		if u, err := url.Parse(config.JWKSURL); err != nil {
+			return nil, errors.New("JWKS URL is invalid")
+		} else if u.Scheme != "https" {
+			return nil, errors.New("JWKS URL must use https scheme")
+		}
+	}
+
+	if len(config.EndSessionURL) > 0 {
+		if u, err := url.Parse(config.EndSessionURL); err != nil {
+			return nil, errors.New("end session URL is invalid")
+		} else if u.Scheme != "https" {
+			return nil, errors.New("end session URL must use https scheme")
+		}
+	}
+
 	if !sets.NewString(config.Scopes...).Has("openid") {
 		return nil, errors.New("scopes must include openid")
 	}
@@ -103,7 +146,15 @@ func NewProvider(providerName string, transport http.RoundTripper, config Config
 		return nil, errors.New("IDClaims must specify at least one claim")
 	}
 
-	return provider{providerName: providerName, transport: transport, Config: config}, nil
+	if config.AccessTokenClaims && len(config.JWKSURL) == 0 {
+		return nil, errors.New("access token claims require a JWKS URL")
+	}
+
+	p := provider{providerName: providerName, transport: transport, Config: config}
+	if len(config.JWKSURL) > 0 {
+		p.keySet = newRemoteKeySet(config.JWKSURL, transport)
+	}
+	return p, nil
 }
 
 // NewConfig implements external/interfaces/Provider.NewConfig
@@ -112,7 +163,7 @@ func (p provider) NewConfig() (*osincli.ClientConfig, error) {
 		ClientId:                 p.ClientID,
 		ClientSecret:             p.ClientSecret,
 		ErrorsInStatusCode:       true,
-		SendClientSecretInParams: true,
+		SendClientSecretInParams: !p.ClientSecretBasic,
 		AuthorizeUrl:             p.AuthorizeURL,
 		TokenUrl:                 p.TokenURL,
 		Scope:                    strings.Join(p.Scopes, " "),
@@ -131,6 +182,20 @@ func (p provider) AddCustomParameters(req *osincli.AuthorizeRequest) {
 	}
 }
 
+// AddHintParameters implements external/interfaces/HintingProvider.AddHintParameters
+func (p provider) AddHintParameters(req *osincli.AuthorizeRequest, hints external.Hints) {
+	if len(hints.Login) > 0 {
+		req.CustomParameters["login_hint"] = hints.Login
+	}
+	if p.DomainHint && len(hints.Domain) > 0 {
+		req.CustomParameters["domain_hint"] = hints.Domain
+	}
+	// the provider asks for the credentials again if its own session is older
+	if len(hints.MaxAge) > 0 {
+		req.CustomParameters["max_age"] = hints.MaxAge
+	}
+}
+
 // GetUserIdentity implements external/interfaces/Provider.GetUserIdentity
 func (p provider) GetUserIdentity(data *osincli.AccessData) (authapi.UserIdentityInfo, error) {
 	// Token response MUST include id_token
@@ -140,6 +205,12 @@ func (p provider) GetUserIdentity(data *osincli.AccessData) (authapi.UserIdentit
 		return nil, fmt.Errorf("no id_token returned in %#v", data.ResponseData)
 	}
 
+	if p.keySet != nil {
+		if err := p.keySet.verify(idToken); err != nil {
+			return nil, err
+		}
+	}
+
 	// id_token MUST be a valid JWT
 	idTokenClaims, err := decodeJWT(idToken)
 	if err != nil {
@@ -165,6 +236,18 @@ func (p provider) GetUserIdentity(data *osincli.AccessData) (authapi.UserIdentit
 	// Use id_token claims by default
 	claims := idTokenClaims
 
+	if p.AccessTokenClaims {
+		accessTokenClaims, err := p.accessTokenClaims(data.AccessToken, idTokenSubject)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range accessTokenClaims {
+			if _, ok := claims[k]; !ok {
+				claims[k] = v
+			}
+		}
+	}
+
 	// If we have a userinfo URL, use it to get more detailed claims
 	if len(p.UserInfoURL) != 0 {
 		userInfoClaims, err := fetchUserInfo(p.UserInfoURL, data.AccessToken, p.transport)
@@ -207,6 +290,11 @@ func (p provider) GetUserIdentity(data *osincli.AccessData) (authapi.UserIdentit
 
 	if email, ok := getClaimValue(claims, p.EmailClaims...); ok {
 		identity.Extra[authapi.IdentityEmailKey] = email
+		// email_verified only applies to the email claim. It is a boolean, some providers send it as a string.
+		// The key is always set so that extra claims cannot set it for another claim.
+		verified, _ := claims["email_verified"].(bool)
+		verified = verified || claims["email_verified"] == "true"
+		identity.Extra[authapi.IdentityEmailVerifiedKey] = strconv.FormatBool(verified && email == claims["email"])
 	}
 
 	if name, ok := getClaimValue(claims, p.NameClaims...); ok {
@@ -217,6 +305,23 @@ func (p provider) GetUserIdentity(data *osincli.AccessData) (authapi.UserIdentit
 		identity.ProviderGroups = groups
 	}
 
+	for _, claim := range p.ExtraClaims {
+		if _, exists := identity.Extra[claim]; exists {
+			continue
+		}
+		if value, ok := getExtraClaimValue(claims, claim); ok {
+			identity.Extra[claim] = value
+		}
+	}
+
+	if p.ExtraFunc != nil {
+		for k, v := range p.ExtraFunc(claims) {
+			if _, exists := identity.Extra[k]; !exists && len(v) > 0 {
+				identity.Extra[k] = v
+			}
+		}
+	}
+
 	klog.V(4).Infof("identity=%#v", identity)
 
 	return identity, nil
@@ -252,6 +357,52 @@ func getArrayOrStringClaimValue(data map[string]interface{}, claims ...string) (
 	return nil, false
 }
 
+// getExtraClaimValue returns the claim as a string, numbers and booleans are formatted and lists are joined with commas
+func getExtraClaimValue(data map[string]interface{}, claim string) (string, bool) {
+	switch value := data[claim].(type) {
+	case string:
+		return value, len(value) > 0
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(value), true
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, v := range value {
+			if s, ok := getExtraClaimValue(map[string]interface{}{claim: v}, claim); ok {
+				values = append(values, s)
+			}
+		}
+		return strings.Join(values, ","), len(values) > 0
+	}
+	return "", false
+}
+
+// accessTokenClaims returns the claims of the access token, which must be a JWT signed by the provider for the client
+// and the subject
+func (p provider) accessTokenClaims(accessToken, subject string) (map[string]interface{}, error) {
+	if err := p.keySet.verify(accessToken); err != nil {
+		return nil, fmt.Errorf("error verifying access token: %v", err)
+	}
+	claims, err := decodeJWT(accessToken)
+	if err != nil {
+		return nil, err
+	}
+	if len(p.Issuer) > 0 {
+		if issuer, _ := getClaimValue(claims, "iss"); issuer != p.Issuer {
+			return nil, fmt.Errorf("access token issuer %q did not match expected issuer %q", issuer, p.Issuer)
+		}
+	}
+	// access tokens are issued for resource servers, the client they were issued to is the authorized party
+	if azp, _ := getClaimValue(claims, "azp"); azp != p.ClientID {
+		return nil, fmt.Errorf("access token 'azp' claim (%s) did not match client ID (%s)", azp, p.ClientID)
+	}
+	if accessTokenSubject, _ := getClaimValue(claims, subjectClaim); accessTokenSubject != subject {
+		return nil, fmt.Errorf("access token 'sub' claim (%s) did not match id_token 'sub' claim (%s)", accessTokenSubject, subject)
+	}
+	return claims, nil
+}
+
 // fetch and decode JSON from the given UserInfo URL
 func fetchUserInfo(url, accessToken string, transport http.RoundTripper) (map[string]interface{}, error) {
 	req, err := http.NewRequest("GET", url, nil)
@@ -1,9 +1,23 @@
 package openid
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 
+	"github.com/RangelReale/osincli"
+	"gopkg.in/square/go-jose.v2"
+
+	authapi "github.com/openshift/oauth-server/pkg/api"
 	"github.com/openshift/oauth-server/pkg/oauth/external"
 )
 
@@ -23,6 +37,55 @@ func TestOpenID(t *testing.T) {
 
 }
 
+func TestHints(t *testing.T) {
+	testCases := map[string]struct {
+		DomainHint bool
+		Hints      external.Hints
+
+		Expected map[string]string
+	}{
+		"login hint": {
+			Hints:    external.Hints{Login: "bob@example.com", Domain: "example.com"},
+			Expected: map[string]string{"login_hint": "bob@example.com"},
+		},
+		"domain hint": {
+			DomainHint: true,
+			Hints:      external.Hints{Login: "bob@example.com", Domain: "example.com"},
+			Expected:   map[string]string{"login_hint": "bob@example.com", "domain_hint": "example.com"},
+		},
+		"max age": {
+			Hints:    external.Hints{MaxAge: "0"},
+			Expected: map[string]string{"max_age": "0"},
+		},
+		"no hints": {
+			DomainHint: true,
+			Expected:   map[string]string{},
+		},
+	}
+
+	for k, testCase := range testCases {
+		t.Run(k, func(t *testing.T) {
+			p, err := NewProvider("openid", nil, Config{
+				ClientID:     "foo",
+				ClientSecret: "secret",
+				AuthorizeURL: "https://foo",
+				TokenURL:     "https://foo",
+				Scopes:       []string{"openid"},
+				IDClaims:     []string{"sub"},
+				DomainHint:   testCase.DomainHint,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := &osincli.AuthorizeRequest{CustomParameters: map[string]string{}}
+			p.(external.HintingProvider).AddHintParameters(req, testCase.Hints)
+			if !reflect.DeepEqual(req.CustomParameters, testCase.Expected) {
+				t.Errorf("expected parameters %v, got %v", testCase.Expected, req.CustomParameters)
+			}
+		})
+	}
+}
+
 func TestDecodeJWT(t *testing.T) {
 	testcases := []struct {
 		Name       string
@@ -93,3 +156,259 @@ func TestDecodeJWT(t *testing.T) {
 		}
 	}
 }
+
+func TestMetadata(t *testing.T) {
+	var issuer string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/.well-known/openid-configuration" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(w, `{"issuer":%q,"authorization_endpoint":"https://idp/authorize","token_endpoint":"https://idp/token","jwks_uri":"https://idp/keys"}`, issuer)
+	}))
+	defer server.Close()
+	issuer = server.URL
+
+	metadata, err := DiscoverMetadata(issuer, server.Client().Transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := Config{AuthorizeURL: "https://old/authorize", TokenURL: "https://old/token", UserInfoURL: "https://old/userinfo"}
+	metadata.Apply(&config)
+	expected := Config{Issuer: issuer, AuthorizeURL: "https://idp/authorize", TokenURL: "https://idp/token", UserInfoURL: "https://old/userinfo", JWKSURL: "https://idp/keys"}
+	if !reflect.DeepEqual(config, expected) {
+		t.Errorf("expected %#v, got %#v", expected, config)
+	}
+
+	if _, err := DiscoverMetadata(issuer+"/other", server.Client().Transport); err == nil {
+		t.Errorf("expected error for unknown issuer")
+	}
+
+	snapshot := filepath.Join(t.TempDir(), "discovery.json")
+	if err := ioutil.WriteFile(snapshot, []byte(`{"issuer":"https://pinned","token_endpoint":"https://pinned/token"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if metadata, err := ReadMetadata("https://pinned", snapshot); err != nil || metadata.TokenEndpoint != "https://pinned/token" {
+		t.Errorf("unexpected pinned metadata %#v: %v", metadata, err)
+	}
+	if _, err := ReadMetadata("https://other", snapshot); err == nil {
+		t.Errorf("expected error for pinned document with mismatched issuer")
+	}
+}
+
+func TestIDTokenSignature(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: key.Public(), KeyID: "current", Algorithm: string(jose.RS256), Use: "sig"},
+		}})
+	}))
+	defer server.Close()
+
+	p, err := NewProvider("openid", server.Client().Transport, Config{
+		ClientID:     "foo",
+		ClientSecret: "secret",
+		AuthorizeURL: "https://foo",
+		TokenURL:     "https://foo",
+		JWKSURL:      server.URL,
+		Scopes:       []string{"openid"},
+		IDClaims:     []string{"sub"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sign := func(signingKey *rsa.PrivateKey, keyID string) string {
+		signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: jose.JSONWebKey{Key: signingKey, KeyID: keyID}}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		jws, err := signer.Sign([]byte(`{"sub":"user"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		token, err := jws.CompactSerialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	for _, tc := range []struct {
+		name        string
+		idToken     string
+		expectError bool
+	}{
+		{name: "valid signature", idToken: sign(key, "current")},
+		{name: "unknown key", idToken: sign(otherKey, "current"), expectError: true},
+		{name: "unsigned", idToken: "eyJhbGciOiJub25lIn0.eyJzdWIiOiJ1c2VyIn0.", expectError: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			identity, err := p.GetUserIdentity(&osincli.AccessData{ResponseData: osincli.ResponseData{"id_token": tc.idToken}})
+			if tc.expectError != (err != nil) {
+				t.Fatalf("expected error %v, got %v", tc.expectError, err)
+			}
+			if err == nil && identity.GetProviderUserName() != "user" {
+				t.Errorf("unexpected identity %#v", identity)
+			}
+		})
+	}
+}
+
+func TestKeySetRefreshThrottle(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotatedKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fetches := 0
+	keys := []jose.JSONWebKey{{Key: key.Public(), KeyID: "current", Algorithm: string(jose.RS256), Use: "sig"}}
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		_ = json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: keys})
+	}))
+	defer server.Close()
+
+	now := time.Now()
+	keySet := newRemoteKeySet(server.URL, server.Client().Transport)
+	keySet.now = func() time.Time { return now }
+
+	sign := func(signingKey *rsa.PrivateKey, keyID string) string {
+		signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: jose.JSONWebKey{Key: signingKey, KeyID: keyID}}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		jws, err := signer.Sign([]byte(`{"sub":"user"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		token, err := jws.CompactSerialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	if err := keySet.verify(sign(key, "current")); err != nil || fetches != 1 {
+		t.Fatalf("expected the keys to be fetched once, got %d fetches: %v", fetches, err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := keySet.verify(sign(rotatedKey, "unknown")); err == nil {
+			t.Fatal("expected unknown key to be rejected")
+		}
+	}
+	if fetches != 1 {
+		t.Errorf("expected unknown keys not to refetch the keys within %v, got %d fetches", minRefreshInterval, fetches)
+	}
+
+	keys = append(keys, jose.JSONWebKey{Key: rotatedKey.Public(), KeyID: "rotated", Algorithm: string(jose.RS256), Use: "sig"})
+	now = now.Add(minRefreshInterval)
+	if err := keySet.verify(sign(rotatedKey, "rotated")); err != nil || fetches != 2 {
+		t.Errorf("expected rotated key to be fetched after %v, got %d fetches: %v", minRefreshInterval, fetches, err)
+	}
+}
+
+func TestExtraClaims(t *testing.T) {
+	p, err := NewProvider("openid", nil, Config{
+		ClientID:     "foo",
+		ClientSecret: "secret",
+		AuthorizeURL: "https://foo",
+		TokenURL:     "https://foo",
+		Scopes:       []string{"openid"},
+		IDClaims:     []string{"sub"},
+		EmailClaims:  []string{"email"},
+		ExtraClaims:  []string{"department", "employee_id", "tenants", "verified", "email", "missing"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"user","email":"user@example.com","department":"finance","employee_id":12345,"tenants":["a","b"],"verified":true}`))
+	identity, err := p.GetUserIdentity(&osincli.AccessData{ResponseData: osincli.ResponseData{"id_token": "eyJhbGciOiJub25lIn0." + payload + "."}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"email":          "user@example.com",
+		"email_verified": "false",
+		"department":     "finance",
+		"employee_id":    "12345",
+		"tenants":        "a,b",
+		"verified":       "true",
+	}
+	if !reflect.DeepEqual(identity.GetExtra(), expected) {
+		t.Errorf("expected extra %v, got %v", expected, identity.GetExtra())
+	}
+}
+
+func TestEmailVerified(t *testing.T) {
+	testcases := map[string]struct {
+		emailClaims    []string
+		claims         string
+		expectVerified string
+	}{
+		"verified": {
+			claims:         `"email":"user@example.com","email_verified":true`,
+			expectVerified: "true",
+		},
+		"verified as a string": {
+			claims:         `"email":"user@example.com","email_verified":"true"`,
+			expectVerified: "true",
+		},
+		"unverified": {
+			claims:         `"email":"user@example.com","email_verified":false`,
+			expectVerified: "false",
+		},
+		"missing claim": {
+			claims:         `"email":"user@example.com"`,
+			expectVerified: "false",
+		},
+		"other email claim": {
+			emailClaims:    []string{"upn"},
+			claims:         `"upn":"user@example.com","email":"other@example.com","email_verified":true`,
+			expectVerified: "false",
+		},
+	}
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			emailClaims := tc.emailClaims
+			if emailClaims == nil {
+				emailClaims = []string{"email"}
+			}
+			p, err := NewProvider("openid", nil, Config{
+				ClientID:     "foo",
+				ClientSecret: "secret",
+				AuthorizeURL: "https://foo",
+				TokenURL:     "https://foo",
+				Scopes:       []string{"openid"},
+				IDClaims:     []string{"sub"},
+				EmailClaims:  emailClaims,
+				ExtraClaims:  []string{"email_verified"},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			payload := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"user",` + tc.claims + `}`))
+			identity, err := p.GetUserIdentity(&osincli.AccessData{ResponseData: osincli.ResponseData{"id_token": "eyJhbGciOiJub25lIn0." + payload + "."}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if verified := identity.GetExtra()[authapi.IdentityEmailVerifiedKey]; verified != tc.expectVerified {
+				t.Errorf("expected email verified %q, got %q", tc.expectVerified, verified)
+			}
+		})
+	}
+}
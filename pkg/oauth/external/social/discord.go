@@ -0,0 +1,110 @@
+package social
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/RangelReale/osincli"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+
+	authapi "github.com/openshift/oauth-server/pkg/api"
+)
+
+const (
+	// https://discord.com/developers/docs/topics/oauth2
+	discordAuthorizeURL = "https://discord.com/oauth2/authorize"
+	discordTokenURL     = "https://discord.com/api/oauth2/token"
+	// https://discord.com/developers/docs/resources/user#get-current-user
+	discordUserURL = "https://discord.com/api/users/@me"
+	// https://discord.com/developers/docs/resources/user#get-current-user-guilds
+	discordGuildsURL = "https://discord.com/api/users/@me/guilds"
+
+	discordIdentifyScope = "identify"
+	discordEmailScope    = "email"
+	discordGuildsScope   = "guilds"
+)
+
+type discordProvider struct {
+	oauthApp
+	userURL   string
+	guildsURL string
+	// guilds are the IDs of the guilds users must be a member of one of, if any
+	guilds sets.String
+}
+
+// https://discord.com/developers/docs/resources/user#user-object
+type discordUser struct {
+	ID         string `json:"id"`
+	Username   string `json:"username"`
+	GlobalName string `json:"global_name"`
+	Email      string `json:"email"`
+	Verified   bool   `json:"verified"`
+}
+
+// https://discord.com/developers/docs/resources/guild#guild-object
+type discordGuild struct {
+	ID string `json:"id"`
+}
+
+func newDiscordProvider(app oauthApp, guilds []string) *discordProvider {
+	app.authorizeURL = discordAuthorizeURL
+	app.tokenURL = discordTokenURL
+	app.scopes = []string{discordIdentifyScope, discordEmailScope}
+	if len(guilds) > 0 {
+		app.scopes = append(app.scopes, discordGuildsScope)
+	}
+	return &discordProvider{
+		oauthApp:  app,
+		userURL:   discordUserURL,
+		guildsURL: discordGuildsURL,
+		guilds:    sets.NewString(guilds...),
+	}
+}
+
+// GetUserIdentity implements external/interfaces/Provider.GetUserIdentity
+func (p *discordProvider) GetUserIdentity(data *osincli.AccessData) (authapi.UserIdentityInfo, error) {
+	user := discordUser{}
+	if err := p.get(p.userURL, data.AccessToken, nil, &user); err != nil {
+		return nil, err
+	}
+	if len(user.ID) == 0 {
+		return nil, errors.New("could not retrieve Discord id")
+	}
+
+	// user names can be changed, the snowflake ID of users cannot
+	identity := authapi.NewDefaultUserIdentityInfo(p.providerName, user.ID)
+	if len(user.Username) > 0 {
+		identity.Extra[authapi.IdentityPreferredUsernameKey] = user.Username
+	}
+	if len(user.GlobalName) > 0 {
+		identity.Extra[authapi.IdentityDisplayNameKey] = user.GlobalName
+	}
+	// unverified email addresses were never confirmed to belong to the user
+	if len(user.Email) > 0 && user.Verified {
+		identity.Extra[authapi.IdentityEmailKey] = user.Email
+		identity.Extra[authapi.IdentityEmailVerifiedKey] = "true"
+	}
+
+	if p.guilds.Len() > 0 {
+		guilds := []discordGuild{}
+		if err := p.get(p.guildsURL, data.AccessToken, nil, &guilds); err != nil {
+			return nil, err
+		}
+		if !p.isMember(guilds) {
+			return nil, authapi.NewAuthorizationDeniedError(identity, fmt.Errorf("user is not a member of any allowed Discord guild %v", p.guilds.List()))
+		}
+	}
+
+	klog.V(4).Infof("Got identity=%#v", identity)
+	return identity, nil
+}
+
+func (p *discordProvider) isMember(guilds []discordGuild) bool {
+	for _, guild := range guilds {
+		if p.guilds.Has(guild.ID) {
+			return true
+		}
+	}
+	return false
+}
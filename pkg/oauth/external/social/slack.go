@@ -0,0 +1,95 @@
+package social
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/RangelReale/osincli"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+
+	authapi "github.com/openshift/oauth-server/pkg/api"
+)
+
+const (
+	// https://api.slack.com/authentication/sign-in-with-slack
+	slackAuthorizeURL = "https://slack.com/openid/connect/authorize"
+	slackTokenURL     = "https://slack.com/api/openid.connect.token"
+	slackUserInfoURL  = "https://slack.com/api/openid.connect.userInfo"
+
+	// slackTeamParam preselects the workspace users sign in to
+	slackTeamParam = "team"
+)
+
+var slackScopes = []string{"openid", "profile", "email"}
+
+type slackProvider struct {
+	oauthApp
+	userInfoURL string
+	// teams are the IDs of the workspaces users must sign in to one of, if any
+	teams sets.String
+}
+
+// https://api.slack.com/methods/openid.connect.userInfo
+type slackUserInfo struct {
+	// Slack answers errors with status 200, ok is false
+	OK    bool   `json:"ok"`
+	Error string `json:"error"`
+
+	Sub           string `json:"sub"`
+	TeamID        string `json:"https://slack.com/team_id"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+}
+
+func newSlackProvider(app oauthApp, teams []string) *slackProvider {
+	app.authorizeURL = slackAuthorizeURL
+	app.tokenURL = slackTokenURL
+	app.scopes = slackScopes
+	return &slackProvider{
+		oauthApp:    app,
+		userInfoURL: slackUserInfoURL,
+		teams:       sets.NewString(teams...),
+	}
+}
+
+// AddCustomParameters implements external/interfaces/Provider.AddCustomParameters
+func (p *slackProvider) AddCustomParameters(req *osincli.AuthorizeRequest) {
+	// users that are signed in to several workspaces would have to pick the only one they can log in with
+	if p.teams.Len() == 1 {
+		req.CustomParameters[slackTeamParam] = p.teams.List()[0]
+	}
+}
+
+// GetUserIdentity implements external/interfaces/Provider.GetUserIdentity
+func (p *slackProvider) GetUserIdentity(data *osincli.AccessData) (authapi.UserIdentityInfo, error) {
+	userInfo := slackUserInfo{}
+	if err := p.get(p.userInfoURL, data.AccessToken, nil, &userInfo); err != nil {
+		return nil, err
+	}
+	if !userInfo.OK {
+		return nil, fmt.Errorf("error getting Slack user: %s", userInfo.Error)
+	}
+	if len(userInfo.Sub) == 0 {
+		return nil, errors.New("could not retrieve Slack id")
+	}
+
+	identity := authapi.NewDefaultUserIdentityInfo(p.providerName, userInfo.Sub)
+	// Slack has no user names that users pick, their email address is the closest
+	if len(userInfo.Email) > 0 && userInfo.EmailVerified {
+		identity.Extra[authapi.IdentityEmailKey] = userInfo.Email
+		identity.Extra[authapi.IdentityEmailVerifiedKey] = "true"
+		identity.Extra[authapi.IdentityPreferredUsernameKey] = userInfo.Email
+	}
+	if len(userInfo.Name) > 0 {
+		identity.Extra[authapi.IdentityDisplayNameKey] = userInfo.Name
+	}
+
+	if p.teams.Len() > 0 && !p.teams.Has(userInfo.TeamID) {
+		return nil, authapi.NewAuthorizationDeniedError(identity, fmt.Errorf("user signed in to Slack workspace %q, which is not allowed", userInfo.TeamID))
+	}
+
+	klog.V(4).Infof("Got identity=%#v", identity)
+	return identity, nil
+}
@@ -0,0 +1,129 @@
+// Package social implements lightweight providers for consumer platforms, e.g. chat platforms for community and lab
+// clusters whose contributors are known by their chat identity, or the dominant identity providers of a region.
+// Users are identified with the OAuth2 flow and the identity API of the platform.
+package social
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/RangelReale/osincli"
+
+	"github.com/openshift/oauth-server/pkg/oauth/external"
+)
+
+// Platform is a platform users can log in with
+type Platform string
+
+const (
+	Discord Platform = "Discord"
+	Slack   Platform = "Slack"
+	Twitch  Platform = "Twitch"
+	Yandex  Platform = "Yandex"
+	VK      Platform = "VK"
+
+	// maxResponseSize limits the responses of identity APIs that are read
+	maxResponseSize = 1 << 20
+)
+
+// NewProvider returns the provider of the platform. If communities are given, users must be members of one of them,
+// they are the IDs of Discord guilds or Slack workspaces. The other platforms have no communities.
+func NewProvider(providerName string, platform Platform, clientID, clientSecret string, communities []string, transport http.RoundTripper) (external.Provider, error) {
+	app := oauthApp{providerName: providerName, clientID: clientID, clientSecret: clientSecret, transport: transport}
+	var provider external.Provider
+	switch platform {
+	case Discord:
+		return newDiscordProvider(app, communities), nil
+	case Slack:
+		return newSlackProvider(app, communities), nil
+	case Twitch:
+		provider = newTwitchProvider(app)
+	case Yandex:
+		provider = newYandexProvider(app)
+	case VK:
+		provider = newVKProvider(app)
+	default:
+		return nil, fmt.Errorf("unknown platform %q", platform)
+	}
+	if len(communities) > 0 {
+		return nil, fmt.Errorf("%s has no communities", platform)
+	}
+	return provider, nil
+}
+
+// oauthApp holds the OAuth2 application at a platform
+type oauthApp struct {
+	providerName string
+	clientID     string
+	clientSecret string
+	authorizeURL string
+	tokenURL     string
+	scopes       []string
+	transport    http.RoundTripper
+}
+
+// NewConfig implements external/interfaces/Provider.NewConfig
+func (a oauthApp) NewConfig() (*osincli.ClientConfig, error) {
+	return &osincli.ClientConfig{
+		ClientId:                 a.clientID,
+		ClientSecret:             a.clientSecret,
+		ErrorsInStatusCode:       true,
+		SendClientSecretInParams: true,
+		AuthorizeUrl:             a.authorizeURL,
+		TokenUrl:                 a.tokenURL,
+		Scope:                    strings.Join(a.scopes, " "),
+	}, nil
+}
+
+// GetTransport implements external/interfaces/Provider.GetTransport
+func (a oauthApp) GetTransport() (http.RoundTripper, error) {
+	return a.transport, nil
+}
+
+// AddCustomParameters implements external/interfaces/Provider.AddCustomParameters
+func (a oauthApp) AddCustomParameters(*osincli.AuthorizeRequest) {}
+
+// get decodes the JSON response of the identity API at rawURL into v. The access token is sent as bearer token, unless
+// it is empty because the platform expects it elsewhere.
+func (a oauthApp) get(rawURL, accessToken string, header http.Header, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	for k, values := range header {
+		req.Header[k] = values
+	}
+	if len(accessToken) > 0 {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	req.Header.Set("Accept", "application/json")
+	// the query may hold the access token
+	endpoint := req.URL.Scheme + "://" + req.URL.Host + req.URL.Path
+
+	client := &http.Client{Transport: a.transport}
+	resp, err := client.Do(req)
+	if err != nil {
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("error requesting %s: %v", endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("non-200 response from %s: %d", endpoint, resp.StatusCode)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("error parsing response from %s: %v", endpoint, err)
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package social
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/RangelReale/osincli"
+
+	authapi "github.com/openshift/oauth-server/pkg/api"
+	"github.com/openshift/oauth-server/pkg/oauth/external"
+)
+
+func TestSocialProviders(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/yandex/info":
+			if r.Header.Get("Authorization") != "OAuth token" {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `{"id":"1000034426","login":"ivan","client_id":"client","display_name":"Vanya","real_name":"Ivan Ivanov","emails":["ivan@yandex.ru"]}`)
+			return
+		case "/vk/users.get":
+			if r.URL.Query().Get("access_token") != "token" {
+				fmt.Fprint(w, `{"error":{"error_code":5,"error_msg":"User authorization failed"}}`)
+				return
+			}
+			fmt.Fprint(w, `{"response":[{"id":210700286,"first_name":"Lindsey","last_name":"Stirling","screen_name":"lindseystirling"}]}`)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/discord/users/@me":
+			fmt.Fprint(w, `{"id":"80351110224678912","username":"nelly","global_name":"Nelly","email":"nelly@example.com","verified":true}`)
+		case "/discord/users/@me/guilds":
+			fmt.Fprint(w, `[{"id":"41771983423143937","name":"Lab"},{"id":"41771983423143938","name":"Other"}]`)
+		case "/slack/userInfo":
+			fmt.Fprint(w, `{"ok":true,"sub":"U0R7JM","https://slack.com/team_id":"T0R7GR","email":"krane@example.com","email_verified":true,"name":"Krane"}`)
+		case "/twitch/users":
+			if r.Header.Get("Client-Id") != "client" {
+				http.Error(w, "missing client ID", http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `{"data":[{"id":"141981764","login":"twitchdev","display_name":"TwitchDev","email":"dev@example.com"}]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	app := oauthApp{providerName: "social", clientID: "client", clientSecret: "secret", transport: server.Client().Transport}
+
+	discord := func(guilds ...string) external.Provider {
+		p := newDiscordProvider(app, guilds)
+		p.userURL, p.guildsURL = server.URL+"/discord/users/@me", server.URL+"/discord/users/@me/guilds"
+		return p
+	}
+	slack := func(teams ...string) external.Provider {
+		p := newSlackProvider(app, teams)
+		p.userInfoURL = server.URL + "/slack/userInfo"
+		return p
+	}
+	twitch := newTwitchProvider(app)
+	twitch.usersURL = server.URL + "/twitch/users"
+	yandex := func(clientID string) external.Provider {
+		otherApp := app
+		otherApp.clientID = clientID
+		p := newYandexProvider(otherApp)
+		p.userInfoURL = server.URL + "/yandex/info"
+		return p
+	}
+	vk := newVKProvider(app)
+	vk.usersURL = server.URL + "/vk/users.get"
+
+	for _, tc := range []struct {
+		name             string
+		provider         external.Provider
+		expectedID       string
+		expectedUsername string
+		expectedEmail    string
+		expectDenied     bool
+		expectError      bool
+		responseData     osincli.ResponseData
+	}{
+		{name: "discord", provider: discord(), expectedID: "80351110224678912", expectedUsername: "nelly", expectedEmail: "nelly@example.com"},
+		{name: "discord guild member", provider: discord("41771983423143937"), expectedID: "80351110224678912", expectedUsername: "nelly", expectedEmail: "nelly@example.com"},
+		{name: "discord other guild", provider: discord("1"), expectDenied: true},
+		{name: "slack", provider: slack(), expectedID: "U0R7JM", expectedUsername: "krane@example.com", expectedEmail: "krane@example.com"},
+		{name: "slack workspace", provider: slack("T0R7GR", "T1"), expectedID: "U0R7JM", expectedUsername: "krane@example.com", expectedEmail: "krane@example.com"},
+		{name: "slack other workspace", provider: slack("T1"), expectDenied: true},
+		{name: "twitch", provider: twitch, expectedID: "141981764", expectedUsername: "twitchdev", expectedEmail: "dev@example.com"},
+		{name: "yandex", provider: yandex("client"), expectedID: "1000034426", expectedUsername: "ivan", expectedEmail: "ivan@yandex.ru"},
+		{name: "yandex token of other client", provider: yandex("other"), expectError: true},
+		{name: "vk", provider: vk, responseData: osincli.ResponseData{"user_id": float64(210700286), "email": "lindsey@example.com"}, expectedID: "210700286", expectedUsername: "lindseystirling", expectedEmail: "lindsey@example.com"},
+		{name: "vk without email", provider: vk, responseData: osincli.ResponseData{"user_id": float64(210700286)}, expectedID: "210700286", expectedUsername: "lindseystirling"},
+		{name: "vk other user", provider: vk, responseData: osincli.ResponseData{"user_id": float64(1)}, expectError: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			identity, err := tc.provider.GetUserIdentity(&osincli.AccessData{AccessToken: "token", ResponseData: tc.responseData})
+			var denied authapi.AuthorizationDeniedError
+			if tc.expectDenied != errors.As(err, &denied) {
+				t.Fatalf("expected denied=%v, got %v", tc.expectDenied, err)
+			}
+			if tc.expectError && err == nil {
+				t.Fatal("expected an error")
+			}
+			if tc.expectDenied || tc.expectError {
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if identity.GetProviderUserName() != tc.expectedID || identity.GetProviderPreferredUserName() != tc.expectedUsername || identity.GetExtra()[authapi.IdentityEmailKey] != tc.expectedEmail {
+				t.Errorf("unexpected identity %#v", identity)
+			}
+		})
+	}
+}
+
+func TestSlackTeamParameter(t *testing.T) {
+	for _, tc := range []struct {
+		teams    []string
+		expected string
+	}{
+		{teams: nil},
+		{teams: []string{"T1"}, expected: "T1"},
+		{teams: []string{"T1", "T2"}},
+	} {
+		req := &osincli.AuthorizeRequest{CustomParameters: map[string]string{}}
+		newSlackProvider(oauthApp{}, tc.teams).AddCustomParameters(req)
+		if req.CustomParameters["team"] != tc.expected {
+			t.Errorf("%v: expected team %q, got %q", tc.teams, tc.expected, req.CustomParameters["team"])
+		}
+	}
+}
+
+func TestNewProvider(t *testing.T) {
+	for _, platform := range []Platform{Twitch, Yandex, VK} {
+		if _, err := NewProvider("social", platform, "client", "secret", []string{"community"}, nil); err == nil {
+			t.Errorf("expected %s communities to be rejected", platform)
+		}
+	}
+	if _, err := NewProvider("irc", Platform("IRC"), "client", "secret", nil, nil); err == nil {
+		t.Error("expected an unknown platform to be rejected")
+	}
+}
+
+func TestVKTokenTransport(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"access_token":"token","expires_in":86400,"user_id":210700286,"email":"lindsey@example.com"}`)
+	}))
+	defer server.Close()
+
+	client, err := osincli.NewClient(&osincli.ClientConfig{
+		ClientId:                 "client",
+		ClientSecret:             "secret",
+		SendClientSecretInParams: true,
+		AuthorizeUrl:             server.URL + "/authorize",
+		TokenUrl:                 server.URL + "/access_token",
+		RedirectUrl:              "https://oauth.example.com/oauth2callback/vk",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Transport = vkTokenTransport{tokenURL: server.URL + "/access_token", base: server.Client().Transport}
+	accessData, err := client.NewAccessRequest(osincli.AUTHORIZATION_CODE, &osincli.AuthorizeData{Code: "code"}).GetToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if accessData.AccessToken != "token" || accessData.ResponseData["user_id"] != float64(210700286) || accessData.ResponseData["email"] != "lindsey@example.com" {
+		t.Errorf("unexpected access data %#v", accessData)
+	}
+}
@@ -0,0 +1,74 @@
+package social
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/RangelReale/osincli"
+	"k8s.io/klog/v2"
+
+	authapi "github.com/openshift/oauth-server/pkg/api"
+)
+
+const (
+	// https://dev.twitch.tv/docs/authentication/getting-tokens-oauth/#authorization-code-grant-flow
+	twitchAuthorizeURL = "https://id.twitch.tv/oauth2/authorize"
+	twitchTokenURL     = "https://id.twitch.tv/oauth2/token"
+	// https://dev.twitch.tv/docs/api/reference/#get-users
+	twitchUsersURL = "https://api.twitch.tv/helix/users"
+
+	twitchEmailScope = "user:read:email"
+	// the Helix API requires the client ID next to the access token
+	twitchClientIDHeader = "Client-Id"
+)
+
+type twitchProvider struct {
+	oauthApp
+	usersURL string
+}
+
+// https://dev.twitch.tv/docs/api/reference/#get-users
+type twitchUsers struct {
+	Data []struct {
+		ID          string `json:"id"`
+		Login       string `json:"login"`
+		DisplayName string `json:"display_name"`
+		Email       string `json:"email"`
+	} `json:"data"`
+}
+
+func newTwitchProvider(app oauthApp) *twitchProvider {
+	app.authorizeURL = twitchAuthorizeURL
+	app.tokenURL = twitchTokenURL
+	app.scopes = []string{twitchEmailScope}
+	return &twitchProvider{oauthApp: app, usersURL: twitchUsersURL}
+}
+
+// GetUserIdentity implements external/interfaces/Provider.GetUserIdentity
+func (p *twitchProvider) GetUserIdentity(data *osincli.AccessData) (authapi.UserIdentityInfo, error) {
+	users := twitchUsers{}
+	// without parameters, the user of the access token is returned
+	if err := p.get(p.usersURL, data.AccessToken, http.Header{twitchClientIDHeader: {p.clientID}}, &users); err != nil {
+		return nil, err
+	}
+	if len(users.Data) != 1 || len(users.Data[0].ID) == 0 {
+		return nil, errors.New("could not retrieve Twitch id")
+	}
+	user := users.Data[0]
+
+	identity := authapi.NewDefaultUserIdentityInfo(p.providerName, user.ID)
+	if len(user.Login) > 0 {
+		identity.Extra[authapi.IdentityPreferredUsernameKey] = user.Login
+	}
+	if len(user.DisplayName) > 0 {
+		identity.Extra[authapi.IdentityDisplayNameKey] = user.DisplayName
+	}
+	// Twitch only returns verified email addresses
+	if len(user.Email) > 0 {
+		identity.Extra[authapi.IdentityEmailKey] = user.Email
+		identity.Extra[authapi.IdentityEmailVerifiedKey] = "true"
+	}
+
+	klog.V(4).Infof("Got identity=%#v", identity)
+	return identity, nil
+}
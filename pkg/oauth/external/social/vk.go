@@ -0,0 +1,143 @@
+package social
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/RangelReale/osincli"
+	"k8s.io/klog/v2"
+
+	authapi "github.com/openshift/oauth-server/pkg/api"
+)
+
+const (
+	// https://dev.vk.com/en/api/access-token/authcode-flow-user
+	vkAuthorizeURL = "https://oauth.vk.com/authorize"
+	vkTokenURL     = "https://oauth.vk.com/access_token"
+	// https://dev.vk.com/en/method/users.get
+	vkUsersURL = "https://api.vk.com/method/users.get"
+
+	vkEmailScope = "email"
+	// vkAPIVersion is the version of the API requests and authorizations are made with
+	vkAPIVersion = "5.131"
+)
+
+type vkProvider struct {
+	oauthApp
+	usersURL string
+}
+
+// https://dev.vk.com/en/method/users.get
+type vkUsers struct {
+	Response []struct {
+		ID         int64  `json:"id"`
+		FirstName  string `json:"first_name"`
+		LastName   string `json:"last_name"`
+		ScreenName string `json:"screen_name"`
+	} `json:"response"`
+	// VK answers errors with status 200
+	Error *struct {
+		Code    int    `json:"error_code"`
+		Message string `json:"error_msg"`
+	} `json:"error"`
+}
+
+func newVKProvider(app oauthApp) *vkProvider {
+	app.authorizeURL = vkAuthorizeURL
+	app.tokenURL = vkTokenURL
+	app.scopes = []string{vkEmailScope}
+	app.transport = vkTokenTransport{tokenURL: vkTokenURL, base: app.transport}
+	return &vkProvider{oauthApp: app, usersURL: vkUsersURL}
+}
+
+// AddCustomParameters implements external/interfaces/Provider.AddCustomParameters
+func (p *vkProvider) AddCustomParameters(req *osincli.AuthorizeRequest) {
+	req.CustomParameters["v"] = vkAPIVersion
+}
+
+// GetUserIdentity implements external/interfaces/Provider.GetUserIdentity
+func (p *vkProvider) GetUserIdentity(data *osincli.AccessData) (authapi.UserIdentityInfo, error) {
+	// the token response names the user, and holds the email address, which the API does not return
+	userID, ok := data.ResponseData["user_id"].(float64)
+	if !ok || userID <= 0 {
+		return nil, errors.New("could not retrieve VK id")
+	}
+	id := strconv.FormatInt(int64(userID), 10)
+
+	// the API expects the access token in the parameters
+	query := url.Values{"v": {vkAPIVersion}, "fields": {"screen_name"}, "access_token": {data.AccessToken}}
+	users := vkUsers{}
+	if err := p.get(p.usersURL+"?"+query.Encode(), "", nil, &users); err != nil {
+		return nil, err
+	}
+	if users.Error != nil {
+		return nil, fmt.Errorf("error getting VK user: %d %s", users.Error.Code, users.Error.Message)
+	}
+	if len(users.Response) != 1 || strconv.FormatInt(users.Response[0].ID, 10) != id {
+		return nil, fmt.Errorf("VK user did not match user %s of the token", id)
+	}
+	user := users.Response[0]
+
+	identity := authapi.NewDefaultUserIdentityInfo(p.providerName, id)
+	// users without a short name have id<ID> as screen name
+	if len(user.ScreenName) > 0 {
+		identity.Extra[authapi.IdentityPreferredUsernameKey] = user.ScreenName
+	}
+	if name := strings.TrimSpace(user.FirstName + " " + user.LastName); len(name) > 0 {
+		identity.Extra[authapi.IdentityDisplayNameKey] = name
+	}
+	// the email address is only returned if the user has one and granted the email scope
+	if email, ok := data.ResponseData["email"].(string); ok && len(email) > 0 {
+		identity.Extra[authapi.IdentityEmailKey] = email
+	}
+
+	klog.V(4).Infof("Got identity=%#v", identity)
+	return identity, nil
+}
+
+// vkTokenTransport adds the token_type that VK leaves out of its token responses, without which the OAuth client
+// rejects them
+type vkTokenTransport struct {
+	tokenURL string
+	base     http.RoundTripper
+}
+
+func (t vkTokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	resp, err := base.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusOK || req.URL.Scheme+"://"+req.URL.Host+req.URL.Path != t.tokenURL {
+		return resp, err
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	token := map[string]interface{}{}
+	if err := json.Unmarshal(data, &token); err == nil {
+		_, hasAccessToken := token["access_token"]
+		_, hasTokenType := token["token_type"]
+		if hasAccessToken && !hasTokenType {
+			token["token_type"] = "bearer"
+			if patched, err := json.Marshal(token); err == nil {
+				data = patched
+			}
+		}
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(data))
+	resp.ContentLength = int64(len(data))
+	resp.Header.Del("Content-Length")
+	return resp, nil
+}
@@ -0,0 +1,84 @@
+package social
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/RangelReale/osincli"
+	"k8s.io/klog/v2"
+
+	authapi "github.com/openshift/oauth-server/pkg/api"
+)
+
+const (
+	// https://yandex.com/dev/id/doc/en/codes/code-url
+	yandexAuthorizeURL = "https://oauth.yandex.com/authorize"
+	yandexTokenURL     = "https://oauth.yandex.com/token"
+	// https://yandex.com/dev/id/doc/en/user-information
+	yandexUserInfoURL = "https://login.yandex.ru/info?format=json"
+
+	// the permissions of the application must include these scopes, Yandex rejects others
+	yandexInfoScope  = "login:info"
+	yandexEmailScope = "login:email"
+	// the user info endpoint expects its own authorization scheme instead of bearer tokens
+	yandexAuthorizationScheme = "OAuth "
+)
+
+type yandexProvider struct {
+	oauthApp
+	userInfoURL string
+}
+
+// https://yandex.com/dev/id/doc/en/user-information#common
+type yandexUserInfo struct {
+	ID          string `json:"id"`
+	Login       string `json:"login"`
+	ClientID    string `json:"client_id"`
+	DisplayName string `json:"display_name"`
+	RealName    string `json:"real_name"`
+	// DefaultEmail is the address the user picked, Emails are all addresses of the account
+	DefaultEmail string   `json:"default_email"`
+	Emails       []string `json:"emails"`
+}
+
+func newYandexProvider(app oauthApp) *yandexProvider {
+	app.authorizeURL = yandexAuthorizeURL
+	app.tokenURL = yandexTokenURL
+	app.scopes = []string{yandexInfoScope, yandexEmailScope}
+	return &yandexProvider{oauthApp: app, userInfoURL: yandexUserInfoURL}
+}
+
+// GetUserIdentity implements external/interfaces/Provider.GetUserIdentity
+func (p *yandexProvider) GetUserIdentity(data *osincli.AccessData) (authapi.UserIdentityInfo, error) {
+	userInfo := yandexUserInfo{}
+	if err := p.get(p.userInfoURL, "", http.Header{"Authorization": {yandexAuthorizationScheme + data.AccessToken}}, &userInfo); err != nil {
+		return nil, err
+	}
+	if len(userInfo.ID) == 0 {
+		return nil, errors.New("could not retrieve Yandex id")
+	}
+	// the user info of any token of any application is returned, it must have been issued to this one
+	if userInfo.ClientID != p.clientID {
+		return nil, fmt.Errorf("Yandex token was issued to client %q", userInfo.ClientID)
+	}
+
+	identity := authapi.NewDefaultUserIdentityInfo(p.providerName, userInfo.ID)
+	if len(userInfo.Login) > 0 {
+		identity.Extra[authapi.IdentityPreferredUsernameKey] = userInfo.Login
+	}
+	if len(userInfo.RealName) > 0 {
+		identity.Extra[authapi.IdentityDisplayNameKey] = userInfo.RealName
+	} else if len(userInfo.DisplayName) > 0 {
+		identity.Extra[authapi.IdentityDisplayNameKey] = userInfo.DisplayName
+	}
+	// the default email is empty if the user never picked one
+	if len(userInfo.DefaultEmail) > 0 {
+		identity.Extra[authapi.IdentityEmailKey] = userInfo.DefaultEmail
+	} else if len(userInfo.Emails) > 0 {
+		identity.Extra[authapi.IdentityEmailKey] = userInfo.Emails[0]
+	}
+
+	klog.V(4).Infof("Got identity=%#v", identity)
+	return identity, nil
+}
@@ -43,18 +43,28 @@ type TokenTimeoutSeconds interface {
 
 // HandleAuthorize implements osinserver.AuthorizeHandler to ensure the AuthorizeRequest is authenticated.
 // If the request is authenticated, UserData and Authorized are set and false is returned.
-// If the request is not authenticated, the auth handler is called and the request is not authorized
+// If the request is not authenticated, the auth handler is called and the request is not authorized.
+// Requests with prompt=none are returned to the client with a login_required error instead.
 func (h *authorizeAuthenticator) HandleAuthorize(ar *osin.AuthorizeRequest, resp *osin.Response, w http.ResponseWriter) (bool, error) {
 	info, ok, err := h.request.AuthenticateRequest(ar.HttpRequest)
 	if err != nil {
 		klog.V(4).Infof("OAuth authentication error: %v", err)
+		if silent(ar.HttpRequest) {
+			setSilentError(ar, resp, errLoginRequired)
+			return false, nil
+		}
 		return h.errorHandler.AuthenticationError(err, w, ar.HttpRequest)
 	}
 	if !ok {
+		// the login pages and challenges cannot be shown to silent requests
+		if silent(ar.HttpRequest) {
+			setSilentError(ar, resp, errLoginRequired)
+			return false, nil
+		}
 		return h.handler.AuthenticationNeeded(ar.Client, w, ar.HttpRequest)
 	}
 	klog.V(4).Infof("OAuth authentication succeeded: %#v", info.User)
-	ar.UserData = info.User
+	ar.UserData = WithUserAgent(info.User, requestUserAgent(ar.HttpRequest))
 	ar.Authorized = true
 
 	// If requesting a token directly, optionally override the expiration
@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apiserver/pkg/authentication/user"
+)
+
+// CertificateThumbprint is implemented by user data that binds tokens to a TLS client certificate
+type CertificateThumbprint interface {
+	// GetCertificateThumbprint returns the base64url encoded SHA-256 hash of the certificate
+	GetCertificateThumbprint() string
+}
+
+// ProofKeyThumbprint is implemented by user data that binds tokens to the key of DPoP proofs
+type ProofKeyThumbprint interface {
+	// GetProofKeyThumbprint returns the base64url encoded SHA-256 JWK thumbprint of the key
+	GetProofKeyThumbprint() string
+}
+
+// Actor is implemented by user data of delegated tokens, which another user uses to act for the user
+type Actor interface {
+	// GetActor returns the name of the user that acts for the user
+	GetActor() string
+}
+
+// PriorActors is implemented by user data of tokens that were delegated again, e.g. a token that another user acts
+// for was exchanged for a token of a further actor
+type PriorActors interface {
+	// GetPriorActors returns the users that the actor acts for in turn, the most recent first
+	GetPriorActors() []string
+}
+
+// Audiences is implemented by user data of tokens that are restricted to audiences
+type Audiences interface {
+	GetAudiences() []string
+}
+
+// AuthenticationMethods is implemented by user data that records how the user authenticated, e.g. pwd or mfa
+type AuthenticationMethods interface {
+	// GetAuthenticationMethods returns the sorted authentication method references (amr) of the login
+	GetAuthenticationMethods() []string
+}
+
+// IdentityProvider is implemented by user data that records the identity provider the user logged in with
+type IdentityProvider interface {
+	GetIdentityProvider() string
+}
+
+// AuthenticationContextClass is implemented by user data of tokens that record the authentication context class
+// (acr) the login satisfied
+type AuthenticationContextClass interface {
+	GetAuthenticationContextClass() string
+}
+
+// AuthenticationTime is implemented by user data that records when the user logged in (auth_time)
+type AuthenticationTime interface {
+	GetAuthenticationTime() time.Time
+}
+
+// boundInfo carries the keys and parties that the tokens issued to the user are bound to, and how the user
+// authenticated
+type boundInfo struct {
+	user.Info
+	certificateThumbprint string
+	proofKeyThumbprint    string
+	actors                []string
+	audiences             []string
+	methods               []string
+	identityProvider      string
+	contextClass          string
+	authenticatedAt       time.Time
+}
+
+func (u *boundInfo) GetCertificateThumbprint() string {
+	return u.certificateThumbprint
+}
+
+func (u *boundInfo) GetProofKeyThumbprint() string {
+	return u.proofKeyThumbprint
+}
+
+func (u *boundInfo) GetActor() string {
+	if len(u.actors) == 0 {
+		return ""
+	}
+	return u.actors[0]
+}
+
+func (u *boundInfo) GetPriorActors() []string {
+	if len(u.actors) < 2 {
+		return nil
+	}
+	return u.actors[1:]
+}
+
+func (u *boundInfo) GetAudiences() []string {
+	return u.audiences
+}
+
+func (u *boundInfo) GetAuthenticationMethods() []string {
+	return u.methods
+}
+
+func (u *boundInfo) GetIdentityProvider() string {
+	return u.identityProvider
+}
+
+func (u *boundInfo) GetAuthenticationContextClass() string {
+	return u.contextClass
+}
+
+func (u *boundInfo) GetAuthenticationTime() time.Time {
+	return u.authenticatedAt
+}
+
+// GetTokenType returns DPoP for tokens that are only accepted with DPoP proofs, and nothing otherwise
+func (u *boundInfo) GetTokenType() string {
+	if len(u.proofKeyThumbprint) > 0 {
+		return "DPoP"
+	}
+	return ""
+}
+
+// GetUserAgent keeps the user agent of the wrapped user info
+func (u *boundInfo) GetUserAgent() string {
+	if userAgent, ok := u.Info.(UserAgent); ok {
+		return userAgent.GetUserAgent()
+	}
+	return ""
+}
+
+// WithCertificateThumbprint returns user info that binds the tokens issued to the user to a certificate
+func WithCertificateThumbprint(info user.Info, thumbprint string) user.Info {
+	if len(thumbprint) == 0 {
+		return info
+	}
+	bound := withBinding(info)
+	bound.certificateThumbprint = thumbprint
+	return bound
+}
+
+// WithProofKeyThumbprint returns user info that binds the tokens issued to the user to the key of DPoP proofs
+func WithProofKeyThumbprint(info user.Info, thumbprint string) user.Info {
+	if len(thumbprint) == 0 {
+		return info
+	}
+	bound := withBinding(info)
+	bound.proofKeyThumbprint = thumbprint
+	return bound
+}
+
+// WithActor returns user info for delegated tokens that the actor uses to act for the user. If the user info already
+// has an actor, it becomes a prior actor that the actor acts for in turn.
+func WithActor(info user.Info, actor string) user.Info {
+	if len(actor) == 0 {
+		return info
+	}
+	bound := withBinding(info)
+	bound.actors = append([]string{actor}, bound.actors...)
+	return bound
+}
+
+// WithAudiences returns user info that restricts the tokens issued to the user to the audiences
+func WithAudiences(info user.Info, audiences []string) user.Info {
+	if len(audiences) == 0 {
+		return info
+	}
+	bound := withBinding(info)
+	bound.audiences = audiences
+	return bound
+}
+
+// WithAuthenticationMethods returns user info that records the methods in addition to the authentication methods
+// the user info already has
+func WithAuthenticationMethods(info user.Info, methods []string) user.Info {
+	if len(methods) == 0 {
+		return info
+	}
+	bound := withBinding(info)
+	bound.methods = sets.NewString(bound.methods...).Insert(methods...).List()
+	return bound
+}
+
+// WithIdentityProvider returns user info that records the identity provider the user logged in with
+func WithIdentityProvider(info user.Info, identityProvider string) user.Info {
+	if len(identityProvider) == 0 {
+		return info
+	}
+	bound := withBinding(info)
+	bound.identityProvider = identityProvider
+	return bound
+}
+
+// WithAuthenticationContextClass returns user info that records the authentication context class of the login
+func WithAuthenticationContextClass(info user.Info, contextClass string) user.Info {
+	if len(contextClass) == 0 {
+		return info
+	}
+	bound := withBinding(info)
+	bound.contextClass = contextClass
+	return bound
+}
+
+// WithAuthenticationTime returns user info that records when the user logged in
+func WithAuthenticationTime(info user.Info, authenticatedAt time.Time) user.Info {
+	if authenticatedAt.IsZero() {
+		return info
+	}
+	bound := withBinding(info)
+	bound.authenticatedAt = authenticatedAt
+	return bound
+}
+
+func withBinding(info user.Info) *boundInfo {
+	if bound, ok := info.(*boundInfo); ok {
+		copied := *bound
+		return &copied
+	}
+	bound := &boundInfo{Info: info}
+	// keep how the user authenticated if the user info wraps bound user info, e.g. to record the user agent
+	if methods, ok := info.(AuthenticationMethods); ok {
+		bound.methods = methods.GetAuthenticationMethods()
+	}
+	if identityProvider, ok := info.(IdentityProvider); ok {
+		bound.identityProvider = identityProvider.GetIdentityProvider()
+	}
+	if contextClass, ok := info.(AuthenticationContextClass); ok {
+		bound.contextClass = contextClass.GetAuthenticationContextClass()
+	}
+	if authenticated, ok := info.(AuthenticationTime); ok {
+		bound.authenticatedAt = authenticated.GetAuthenticationTime()
+	}
+	return bound
+}
@@ -0,0 +1,26 @@
+package handlers
+
+import "strings"
+
+// ChallengeParameter is an auth parameter of a challenge, e.g. realm="openshift"
+type ChallengeParameter struct {
+	Name  string
+	Value string
+}
+
+// FormatChallenge returns the WWW-Authenticate value of the scheme with the parameters in order, their values are quoted
+func FormatChallenge(scheme string, parameters ...ChallengeParameter) string {
+	formatted := make([]string, 0, len(parameters))
+	for _, parameter := range parameters {
+		formatted = append(formatted, parameter.Name+"="+quote(parameter.Value))
+	}
+	if len(formatted) == 0 {
+		return scheme
+	}
+	return scheme + " " + strings.Join(formatted, ", ")
+}
+
+// quote returns value as a quoted-string of RFC 7230
+func quote(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
@@ -1,29 +1,68 @@
 package handlers
 
 import (
+	"context"
 	"fmt"
 	"html"
+	"html/template"
 	"net/http"
+	"net/url"
 	"regexp"
 	"strings"
+	"time"
 
 	kerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/klog/v2"
 
 	oauthapi "github.com/openshift/api/oauth/v1"
 	authapi "github.com/openshift/oauth-server/pkg/api"
+	"github.com/openshift/oauth-server/pkg/server/pathprefix"
 )
 
 // unionAuthenticationHandler is an oauth.AuthenticationHandler that muxes multiple challenge handlers and redirect handlers
 type unionAuthenticationHandler struct {
-	challengers      map[string]AuthenticationChallenger
-	redirectors      *AuthenticationRedirectors
-	errorHandler     AuthenticationErrorHandler
-	selectionHandler AuthenticationSelectionHandler
+	challengers map[string]AuthenticationChallenger
+	// challengerOrder are the names of the challengers in the order they are asked for challenges
+	challengerOrder []string
+	// challengerTimeout limits how long each challenger is waited for
+	challengerTimeout time.Duration
+	restrictions      ClientRestrictions
+
+	redirectors *AuthenticationRedirectors
+	// clientRedirectors are the redirectors of the clients that are restricted to some identity providers
+	clientRedirectors map[string]*AuthenticationRedirectors
+	errorHandler      AuthenticationErrorHandler
+	selectionHandler  AuthenticationSelectionHandler
 }
 
+// ClientRestrictions restricts the challengers and identity providers offered to clients, all maps are by client name.
+// Clients that are not keys of a map are not restricted by it.
+type ClientRestrictions struct {
+	// Challengers are the names of the challengers that may respond to a client
+	Challengers map[string][]string
+	// IdentityProviders are the names of the identity providers a client may offer. They restrict the redirectors
+	// by name and the challengers by ChallengerIdentityProviders.
+	IdentityProviders map[string][]string
+	// ChallengerIdentityProviders are the names of the identity providers whose credentials a challenger asks for, by
+	// challenger name. Challengers that are not keys respond to clients regardless of their identity providers.
+	ChallengerIdentityProviders map[string][]string
+}
+
+// DefaultChallengerTimeout is how long each challenger is waited for by default
+const DefaultChallengerTimeout = 5 * time.Second
+
 // NewUnionAuthenticationHandler returns an oauth.AuthenticationHandler that muxes multiple challenge handlers and redirect handlers
 func NewUnionAuthenticationHandler(passedChallengers map[string]AuthenticationChallenger, passedRedirectors *AuthenticationRedirectors, errorHandler AuthenticationErrorHandler, selectionHandler AuthenticationSelectionHandler) AuthenticationHandler {
+	return NewUnionAuthenticationHandlerWithClientRestrictions(passedChallengers, nil, 0, ClientRestrictions{}, passedRedirectors, errorHandler, selectionHandler)
+}
+
+// NewUnionAuthenticationHandlerWithClientRestrictions returns an oauth.AuthenticationHandler like NewUnionAuthenticationHandler
+// that merges the headers of the challengers in the order ChallengerOrder returns for priority, and only offers clients
+// the challengers and redirectors their restrictions allow. The challengers are asked concurrently, the ones that do
+// not respond within challengerTimeout are skipped. A challengerTimeout of 0 uses DefaultChallengerTimeout.
+func NewUnionAuthenticationHandlerWithClientRestrictions(passedChallengers map[string]AuthenticationChallenger, priority []string, challengerTimeout time.Duration, restrictions ClientRestrictions, passedRedirectors *AuthenticationRedirectors, errorHandler AuthenticationErrorHandler, selectionHandler AuthenticationSelectionHandler) AuthenticationHandler {
 	challengers := passedChallengers
 	if challengers == nil {
 		challengers = make(map[string]AuthenticationChallenger, 1)
@@ -34,7 +73,139 @@ func NewUnionAuthenticationHandler(passedChallengers map[string]AuthenticationCh
 		redirectors = new(AuthenticationRedirectors)
 	}
 
-	return &unionAuthenticationHandler{challengers: challengers, redirectors: redirectors, errorHandler: errorHandler, selectionHandler: selectionHandler}
+	if challengerTimeout == 0 {
+		challengerTimeout = DefaultChallengerTimeout
+	}
+
+	clientRedirectors := map[string]*AuthenticationRedirectors{}
+	for clientName, providers := range restrictions.IdentityProviders {
+		clientRedirectors[clientName] = filterRedirectors(redirectors, providers)
+	}
+
+	return &unionAuthenticationHandler{
+		challengers:       challengers,
+		challengerOrder:   ChallengerOrder(challengers, priority),
+		challengerTimeout: challengerTimeout,
+		restrictions:      restrictions,
+		redirectors:       redirectors,
+		clientRedirectors: clientRedirectors,
+		errorHandler:      errorHandler,
+		selectionHandler:  selectionHandler,
+	}
+}
+
+// ChallengerOrder returns the names of the challengers in the order their headers are merged: the names in priority
+// that have a challenger first, then the remaining names sorted
+func ChallengerOrder(challengers map[string]AuthenticationChallenger, priority []string) []string {
+	order := []string{}
+	ordered := sets.NewString()
+	for _, name := range priority {
+		if _, ok := challengers[name]; ok && !ordered.Has(name) {
+			order = append(order, name)
+			ordered.Insert(name)
+		}
+	}
+	return append(order, sets.StringKeySet(challengers).Difference(ordered).List()...)
+}
+
+// challengerNames returns the names of the challengers of the client in the order they are asked for challenges
+func (authHandler *unionAuthenticationHandler) challengerNames(clientName string) []string {
+	allowedChallengers, restrictChallengers := authHandler.restrictions.Challengers[clientName]
+	allowedProviders, restrictProviders := authHandler.restrictions.IdentityProviders[clientName]
+	if !restrictChallengers && !restrictProviders {
+		return authHandler.challengerOrder
+	}
+	challengerSet, providerSet := sets.NewString(allowedChallengers...), sets.NewString(allowedProviders...)
+	names := []string{}
+	for _, name := range authHandler.challengerOrder {
+		if restrictChallengers && !challengerSet.Has(name) {
+			continue
+		}
+		if providers, ok := authHandler.restrictions.ChallengerIdentityProviders[name]; ok && restrictProviders && !providerSet.HasAny(providers...) {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// challenge is the response of a challenger
+type challenge struct {
+	headers http.Header
+	err     error
+}
+
+// challenges asks the named challengers for their challenges concurrently and returns their responses in order. Each
+// challenger gets a request whose context ends after the challenger timeout, a challenger that did not respond by then
+// is skipped with an error so that it cannot stall the challenges of the others.
+func (authHandler *unionAuthenticationHandler) challenges(names []string, req *http.Request) []challenge {
+	responses := make([]chan challenge, len(names))
+	contexts := make([]context.Context, len(names))
+	for i, name := range names {
+		ctx, cancel := context.WithTimeout(req.Context(), authHandler.challengerTimeout)
+		defer cancel()
+		contexts[i] = ctx
+		// buffered so that a challenger that is not waited for anymore does not block
+		responses[i] = make(chan challenge, 1)
+		go func(challenger AuthenticationChallenger, req *http.Request, response chan<- challenge) {
+			headers, err := challenger.AuthenticationChallenge(req)
+			response <- challenge{headers: headers, err: err}
+		}(authHandler.challengers[name], req.WithContext(ctx), responses[i])
+	}
+
+	challenges := make([]challenge, len(names))
+	for i, name := range names {
+		select {
+		case challenges[i] = <-responses[i]:
+		case <-contexts[i].Done():
+			// the challengers time out together, the ones that responded while another one was waited for count
+			select {
+			case challenges[i] = <-responses[i]:
+				continue
+			default:
+			}
+			klog.V(2).Infof("Challenger %q did not respond within %v", name, authHandler.challengerTimeout)
+			challenges[i] = challenge{err: fmt.Errorf("challenger %q did not respond within %v", name, authHandler.challengerTimeout)}
+		}
+	}
+	return challenges
+}
+
+// redirectorsFor returns the redirectors the client may offer
+func (authHandler *unionAuthenticationHandler) redirectorsFor(clientName string) *AuthenticationRedirectors {
+	if redirectors, ok := authHandler.clientRedirectors[clientName]; ok {
+		return redirectors
+	}
+	return authHandler.redirectors
+}
+
+// filterRedirectors returns the redirectors of the providers in order
+func filterRedirectors(redirectors *AuthenticationRedirectors, providers []string) *AuthenticationRedirectors {
+	allowed := sets.NewString(providers...)
+	filtered := new(AuthenticationRedirectors)
+	for _, name := range redirectors.GetNames() {
+		if allowed.Has(name) {
+			redirector, _ := redirectors.Get(name)
+			filtered.AddWithDisplay(name, redirector, redirectors.Display(name))
+		}
+	}
+	return filtered
+}
+
+type identityProvidersKeyType int
+
+const identityProvidersKey identityProvidersKeyType = iota
+
+// WithIdentityProviders returns a copy of req whose authentication is restricted to the redirectors of the identity
+// providers, e.g. to those that satisfy a requested authentication context class
+func WithIdentityProviders(req *http.Request, providers []string) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), identityProvidersKey, providers))
+}
+
+// identityProvidersFrom returns the identity providers the authentication of req is restricted to, if any
+func identityProvidersFrom(req *http.Request) ([]string, bool) {
+	providers, ok := req.Context().Value(identityProvidersKey).([]string)
+	return providers, ok
 }
 
 const (
@@ -48,8 +219,24 @@ const (
 	warningHeaderTextIndex = 3
 
 	useRedirectParam = "idp"
+	// IdentityProviderHintParam names the identity provider the user probably logs in with. Unlike the idp parameter,
+	// providers that are unknown or not offered to the client are ignored and the selection is shown.
+	IdentityProviderHintParam = "idp_hint"
 )
 
+// providerListTemplate lists the identity providers if there is no selection handler
+var providerListTemplate = template.Must(template.New("providerList").Parse(`<!DOCTYPE html>
+<html>
+  <head><meta charset="UTF-8"><title>Log in</title></head>
+  <body>
+    <ul>
+      {{ range . }}<li><a href="{{ .URL }}">{{ or .DisplayName .Name }}</a></li>
+      {{ end }}
+    </ul>
+  </body>
+</html>
+`))
+
 var (
 	// http://tools.ietf.org/html/rfc2616#section-14.46
 	warningRegex = regexp.MustCompile(strings.Join([]string{
@@ -73,7 +260,7 @@ var (
 )
 
 // AuthenticationNeeded looks at the oauth Client to determine whether it wants try to authenticate with challenges or using a redirect path
-// If the client wants a challenge path, it muxes together all the different challenges from the challenge handlers
+// If the client wants a challenge path, it muxes together the challenges from the challenge handlers of the client in order
 // If (the client wants a redirect path) and ((there is one redirect handler) or (a redirect handler was requested via the "idp" parameter),
 // then the redirect handler is called.  Otherwise, you get an error (currently) or a redirect to a page letting you choose how you'd like to authenticate.
 // It returns whether the response was written and/or an error
@@ -86,15 +273,14 @@ func (authHandler *unionAuthenticationHandler) AuthenticationNeeded(apiClient au
 	if client.RespondWithChallenges {
 		errors := []error{}
 		headers := http.Header(make(map[string][]string))
-		for _, challengingHandler := range authHandler.challengers {
-			currHeaders, err := challengingHandler.AuthenticationChallenge(req)
-			if err != nil {
-				errors = append(errors, err)
+		for _, challenge := range authHandler.challenges(authHandler.challengerNames(client.Name), req) {
+			if challenge.err != nil {
+				errors = append(errors, challenge.err)
 				continue
 			}
 
 			// merge header values
-			mergeHeaders(headers, currHeaders)
+			mergeHeaders(headers, challenge.headers)
 		}
 
 		if len(headers) > 0 {
@@ -140,13 +326,26 @@ func (authHandler *unionAuthenticationHandler) AuthenticationNeeded(apiClient au
 
 	}
 
+	redirectors := authHandler.redirectorsFor(client.Name)
+	if providers, ok := identityProvidersFrom(req); ok {
+		redirectors = filterRedirectors(redirectors, providers)
+	}
+
 	// See if a single provider was selected
 	redirectHandlerName := req.URL.Query().Get(useRedirectParam)
+	if hint := req.URL.Query().Get(IdentityProviderHintParam); len(redirectHandlerName) == 0 && len(hint) > 0 {
+		if _, ok := redirectors.Get(hint); ok {
+			redirectHandlerName = hint
+		}
+	}
 	if len(redirectHandlerName) > 0 {
-		redirectHandler, ok := authHandler.redirectors.Get(redirectHandlerName)
+		redirectHandler, ok := redirectors.Get(redirectHandlerName)
 		if !ok {
 			return false, fmt.Errorf("Unable to locate redirect handler: %v", html.EscapeString(redirectHandlerName))
 		}
+		if recorder, ok := authHandler.selectionHandler.(AuthenticationSelectionRecorder); ok {
+			recorder.ProviderSelected(redirectHandlerName, w, req)
+		}
 		err := redirectHandler.AuthenticationRedirect(w, req)
 		if err != nil {
 			return authHandler.errorHandler.AuthenticationError(err, w, req)
@@ -154,20 +353,10 @@ func (authHandler *unionAuthenticationHandler) AuthenticationNeeded(apiClient au
 		return true, nil
 	}
 
+	providers := providerLinks(redirectors, *req.URL, req)
+
 	// Delegate to provider selection
 	if authHandler.selectionHandler != nil {
-		providers := []authapi.ProviderInfo{}
-		for _, name := range authHandler.redirectors.GetNames() {
-			u := *req.URL
-			q := u.Query()
-			q.Set(useRedirectParam, name)
-			u.RawQuery = q.Encode()
-			providerInfo := authapi.ProviderInfo{
-				Name: name,
-				URL:  u.String(),
-			}
-			providers = append(providers, providerInfo)
-		}
 		selectedProvider, handled, err := authHandler.selectionHandler.SelectAuthentication(providers, w, req)
 		if err != nil {
 			return authHandler.errorHandler.AuthenticationError(err, w, req)
@@ -176,7 +365,7 @@ func (authHandler *unionAuthenticationHandler) AuthenticationNeeded(apiClient au
 			return handled, nil
 		}
 		if selectedProvider != nil {
-			redirectHandler, ok := authHandler.redirectors.Get(selectedProvider.Name)
+			redirectHandler, ok := redirectors.Get(selectedProvider.Name)
 			if !ok {
 				return false, fmt.Errorf("Unable to locate redirect handler: %v", selectedProvider.Name)
 			}
@@ -190,8 +379,8 @@ func (authHandler *unionAuthenticationHandler) AuthenticationNeeded(apiClient au
 	}
 
 	// Otherwise, automatically select a single provider, and error on multiple
-	if authHandler.redirectors.Count() == 1 {
-		redirectHandler, ok := authHandler.redirectors.Get(authHandler.redirectors.GetNames()[0])
+	if redirectors.Count() == 1 {
+		redirectHandler, ok := redirectors.Get(redirectors.GetNames()[0])
 		if !ok {
 			return authHandler.errorHandler.AuthenticationError(fmt.Errorf("No valid redirectors"), w, req)
 		}
@@ -202,15 +391,47 @@ func (authHandler *unionAuthenticationHandler) AuthenticationNeeded(apiClient au
 		}
 		return true, nil
 
-	} else if authHandler.redirectors.Count() > 1 {
-		// TODO this clearly doesn't work right.  There should probably be a redirect to an interstitial page.
-		// however, this is just as good as we have now.
-		return false, fmt.Errorf("Too many potential redirect handlers: %v", authHandler.redirectors)
+	} else if redirectors.Count() > 1 {
+		// without a selection handler, let the user choose from a plain list of the providers
+		w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+		w.WriteHeader(http.StatusOK)
+		if err := providerListTemplate.Execute(w, providers); err != nil {
+			return false, fmt.Errorf("unable to render identity provider list: %v", err)
+		}
+		return true, nil
 	}
 
 	return false, nil
 }
 
+// ProviderLinks implements ProviderLister
+func (authHandler *unionAuthenticationHandler) ProviderLinks(clientName string, authorizeURL url.URL, req *http.Request) []authapi.ProviderInfo {
+	return providerLinks(authHandler.redirectorsFor(clientName), authorizeURL, req)
+}
+
+// providerLinks returns the providers of the redirectors with the external URLs that select them on authorizeURL and
+// their display information
+func providerLinks(redirectors *AuthenticationRedirectors, authorizeURL url.URL, req *http.Request) []authapi.ProviderInfo {
+	providers := []authapi.ProviderInfo{}
+	for _, name := range redirectors.GetNames() {
+		u := authorizeURL
+		q := u.Query()
+		q.Set(useRedirectParam, name)
+		u.RawQuery = q.Encode()
+		display := redirectors.Display(name)
+		providerInfo := authapi.ProviderInfo{
+			Name:        name,
+			URL:         pathprefix.External(req, u.String()),
+			DisplayName: display.DisplayName,
+			IconURL:     display.IconURL,
+			Description: display.Description,
+			Order:       display.Order,
+		}
+		providers = append(providers, providerInfo)
+	}
+	return providers
+}
+
 func mergeHeaders(dest http.Header, toAdd http.Header) {
 	for key, values := range toAdd {
 		for _, value := range values {
@@ -7,6 +7,10 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
 
 	oauthapi "github.com/openshift/api/oauth/v1"
 )
@@ -249,6 +253,289 @@ func TestWithRedirect(t *testing.T) {
 	}
 }
 
+type mockRedirector struct{}
+
+func (mockRedirector) AuthenticationRedirect(w http.ResponseWriter, req *http.Request) error {
+	return nil
+}
+
+func TestWithMultipleRedirectorsWithoutSelection(t *testing.T) {
+	redirectors := new(AuthenticationRedirectors)
+	redirectors.Add("first", mockRedirector{})
+	redirectors.Add("second", mockRedirector{})
+
+	authHandler := NewUnionAuthenticationHandler(nil, redirectors, nil, nil)
+	client := &testClient{&oauthapi.OAuthClient{}}
+	req, _ := http.NewRequest("GET", "http://example.org/authorize?client_id=test", nil)
+	responseRecorder := httptest.NewRecorder()
+
+	handled, err := authHandler.AuthenticationNeeded(client, responseRecorder, req)
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if !handled {
+		t.Error("Expected handling.")
+	}
+
+	body := responseRecorder.Body.String()
+	for _, expected := range []string{"client_id=test&amp;idp=first", "client_id=test&amp;idp=second"} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("Expected provider list containing %q, got %s", expected, body)
+		}
+	}
+}
+
+func TestIdentityProviderHint(t *testing.T) {
+	redirectors := new(AuthenticationRedirectors)
+	redirectors.Add("first", mockRedirector{})
+	redirectors.Add("second", mockRedirector{})
+	restrictions := ClientRestrictions{IdentityProviders: map[string][]string{"restricted": {"first"}}}
+	authHandler := NewUnionAuthenticationHandlerWithClientRestrictions(nil, nil, 0, restrictions, redirectors, nil, nil)
+
+	testCases := map[string]struct {
+		Client string
+		Query  string
+
+		ExpectedRedirect bool
+	}{
+		"known provider": {
+			Client:           "test",
+			Query:            "idp_hint=second",
+			ExpectedRedirect: true,
+		},
+		"unknown provider": {
+			Client: "test",
+			Query:  "idp_hint=third",
+		},
+		"provider not offered to the client": {
+			Client: "restricted",
+			Query:  "idp_hint=second",
+			// the only provider of the client is selected regardless of the hint
+			ExpectedRedirect: true,
+		},
+		"idp takes precedence": {
+			Client:           "test",
+			Query:            "idp=first&idp_hint=third",
+			ExpectedRedirect: true,
+		},
+	}
+
+	for k, testCase := range testCases {
+		t.Run(k, func(t *testing.T) {
+			client := &testClient{&oauthapi.OAuthClient{ObjectMeta: metav1.ObjectMeta{Name: testCase.Client}}}
+			req, _ := http.NewRequest("GET", "http://example.org/authorize?client_id="+testCase.Client+"&"+testCase.Query, nil)
+			responseRecorder := httptest.NewRecorder()
+
+			handled, err := authHandler.AuthenticationNeeded(client, responseRecorder, req)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !handled {
+				t.Fatal("Expected handling.")
+			}
+			// the mock redirector does not write a response, the provider list does
+			if redirected := responseRecorder.Body.Len() == 0; redirected != testCase.ExpectedRedirect {
+				t.Errorf("Expected redirect %v, got %s", testCase.ExpectedRedirect, responseRecorder.Body.String())
+			}
+		})
+	}
+}
+
+func TestChallengerSelection(t *testing.T) {
+	challengers := map[string]AuthenticationChallenger{
+		"basic-challenge":          &mockChallenger{headerName: "WWW-Authenticate", headerValue: "Basic"},
+		"requestheader-a-redirect": &mockChallenger{headerName: "WWW-Authenticate", headerValue: "Negotiate"},
+		"requestheader-b-redirect": &mockChallenger{headerName: "WWW-Authenticate", headerValue: "Bearer"},
+	}
+
+	challengerProviders := map[string][]string{
+		"basic-challenge":          {"htpasswd", "ldap"},
+		"requestheader-a-redirect": {"a"},
+		"requestheader-b-redirect": {"b"},
+	}
+
+	testCases := map[string]struct {
+		Priority          []string
+		ClientChallengers map[string][]string
+		ClientProviders   map[string][]string
+		Client            string
+
+		ExpectedHeaders []string
+	}{
+		"by name": {
+			Client:          "cli",
+			ExpectedHeaders: []string{"Basic", "Negotiate", "Bearer"},
+		},
+		"priority": {
+			Priority:        []string{"requestheader-b-redirect", "unknown", "requestheader-a-redirect"},
+			Client:          "cli",
+			ExpectedHeaders: []string{"Bearer", "Negotiate", "Basic"},
+		},
+		"restricted client": {
+			Priority:          []string{"requestheader-b-redirect"},
+			ClientChallengers: map[string][]string{"cli": {"basic-challenge", "requestheader-b-redirect"}},
+			Client:            "cli",
+			ExpectedHeaders:   []string{"Bearer", "Basic"},
+		},
+		"other client": {
+			ClientChallengers: map[string][]string{"cli": {"basic-challenge"}},
+			Client:            "other",
+			ExpectedHeaders:   []string{"Basic", "Negotiate", "Bearer"},
+		},
+		"restricted identity providers": {
+			ClientProviders: map[string][]string{"cli": {"ldap", "b"}},
+			Client:          "cli",
+			ExpectedHeaders: []string{"Basic", "Bearer"},
+		},
+		"restricted challengers and identity providers": {
+			ClientChallengers: map[string][]string{"cli": {"basic-challenge", "requestheader-a-redirect"}},
+			ClientProviders:   map[string][]string{"cli": {"ldap", "b"}},
+			Client:            "cli",
+			ExpectedHeaders:   []string{"Basic"},
+		},
+	}
+
+	for k, testCase := range testCases {
+		t.Run(k, func(t *testing.T) {
+			restrictions := ClientRestrictions{Challengers: testCase.ClientChallengers, IdentityProviders: testCase.ClientProviders, ChallengerIdentityProviders: challengerProviders}
+			authHandler := NewUnionAuthenticationHandlerWithClientRestrictions(challengers, testCase.Priority, 0, restrictions, nil, nil, nil)
+			client := &testClient{&oauthapi.OAuthClient{ObjectMeta: metav1.ObjectMeta{Name: testCase.Client}, RespondWithChallenges: true}}
+			req, _ := http.NewRequest("GET", "http://example.org", nil)
+			responseRecorder := httptest.NewRecorder()
+
+			handled, err := authHandler.AuthenticationNeeded(client, responseRecorder, req)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !handled {
+				t.Error("Expected handling.")
+			}
+			if headers := responseRecorder.Header()["Www-Authenticate"]; !reflect.DeepEqual(headers, testCase.ExpectedHeaders) {
+				t.Errorf("Expected challenges %v, got %v", testCase.ExpectedHeaders, headers)
+			}
+		})
+	}
+}
+
+// blockingChallenger responds once it is released
+type blockingChallenger struct {
+	release chan struct{}
+}
+
+func (h *blockingChallenger) AuthenticationChallenge(req *http.Request) (http.Header, error) {
+	<-h.release
+	return http.Header{"Www-Authenticate": {"Slow"}}, nil
+}
+
+func TestChallengerTimeout(t *testing.T) {
+	slow := &blockingChallenger{release: make(chan struct{})}
+	defer close(slow.release)
+	challengers := map[string]AuthenticationChallenger{
+		"basic-challenge":          &mockChallenger{headerName: "WWW-Authenticate", headerValue: "Basic"},
+		"requestheader-a-redirect": slow,
+		"requestheader-b-redirect": &mockChallenger{headerName: "WWW-Authenticate", headerValue: "Bearer"},
+	}
+	authHandler := NewUnionAuthenticationHandlerWithClientRestrictions(challengers, nil, 50*time.Millisecond, ClientRestrictions{}, nil, nil, nil)
+	client := &testClient{&oauthapi.OAuthClient{ObjectMeta: metav1.ObjectMeta{Name: "cli"}, RespondWithChallenges: true}}
+	req, _ := http.NewRequest("GET", "http://example.org", nil)
+	responseRecorder := httptest.NewRecorder()
+
+	start := time.Now()
+	handled, err := authHandler.AuthenticationNeeded(client, responseRecorder, req)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !handled {
+		t.Error("Expected handling.")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the slow challenger to be skipped, took %v", elapsed)
+	}
+	if headers := responseRecorder.Header()["Www-Authenticate"]; !reflect.DeepEqual(headers, []string{"Basic", "Bearer"}) {
+		t.Errorf("Expected the challenges of the other challengers in order, got %v", headers)
+	}
+	if responseRecorder.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401, got %d", responseRecorder.Code)
+	}
+}
+
+func TestIdentityProviderRestrictions(t *testing.T) {
+	redirectors := new(AuthenticationRedirectors)
+	redirectors.Add("sso", mockRedirector{})
+	redirectors.Add("htpasswd", mockRedirector{})
+	redirectors.Add("ldap", mockRedirector{})
+	restrictions := ClientRestrictions{IdentityProviders: map[string][]string{
+		"console":     {"sso"},
+		"break-glass": {"htpasswd", "ldap"},
+	}}
+	authHandler := NewUnionAuthenticationHandlerWithClientRestrictions(nil, nil, 0, restrictions, redirectors, nil, nil)
+
+	testCases := map[string]struct {
+		Client string
+		URL    string
+
+		ExpectedProviders []string
+		ExpectedRedirect  bool
+		ExpectedError     bool
+	}{
+		"single provider is selected": {
+			Client:           "console",
+			URL:              "http://example.org/authorize?client_id=console",
+			ExpectedRedirect: true,
+		},
+		"providers of the client are listed": {
+			Client:            "break-glass",
+			URL:               "http://example.org/authorize?client_id=break-glass",
+			ExpectedProviders: []string{"htpasswd", "ldap"},
+		},
+		"unrestricted client": {
+			Client:            "other",
+			URL:               "http://example.org/authorize?client_id=other",
+			ExpectedProviders: []string{"sso", "htpasswd", "ldap"},
+		},
+		"provider of the client": {
+			Client:           "break-glass",
+			URL:              "http://example.org/authorize?client_id=break-glass&idp=ldap",
+			ExpectedRedirect: true,
+		},
+		"provider of another client": {
+			Client:        "console",
+			URL:           "http://example.org/authorize?client_id=console&idp=htpasswd",
+			ExpectedError: true,
+		},
+	}
+
+	for k, testCase := range testCases {
+		t.Run(k, func(t *testing.T) {
+			client := &testClient{&oauthapi.OAuthClient{ObjectMeta: metav1.ObjectMeta{Name: testCase.Client}}}
+			req, _ := http.NewRequest("GET", testCase.URL, nil)
+			responseRecorder := httptest.NewRecorder()
+
+			handled, err := authHandler.AuthenticationNeeded(client, responseRecorder, req)
+			if testCase.ExpectedError != (err != nil) {
+				t.Fatalf("Expected error=%v, got %v", testCase.ExpectedError, err)
+			}
+			if testCase.ExpectedError {
+				return
+			}
+			if !handled {
+				t.Error("Expected handling.")
+			}
+			// the mock redirectors write nothing, the provider list is written otherwise
+			body := responseRecorder.Body.String()
+			if redirected := len(body) == 0; redirected != testCase.ExpectedRedirect {
+				t.Errorf("Expected redirect=%v, got %s", testCase.ExpectedRedirect, body)
+			}
+			for _, name := range []string{"sso", "htpasswd", "ldap"} {
+				listed := strings.Contains(body, "idp="+name)
+				if expected := sets.NewString(testCase.ExpectedProviders...).Has(name); listed != expected {
+					t.Errorf("Expected %s to be listed=%v, got %s", name, expected, body)
+				}
+			}
+		})
+	}
+}
+
 type badTestClient struct {
 	client *oauthapi.OAuthClient
 }
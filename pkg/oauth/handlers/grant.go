@@ -106,6 +106,20 @@ func (h *GrantCheck) HandleAuthorize(ar *osin.AuthorizeRequest, resp *osin.Respo
 		return false, nil
 	}
 
+	// Grants that are approved without asking the user are fine for silent requests, others need consent
+	if silent(ar.HttpRequest) {
+		authorized, _, err := h.handler.GrantNeeded(user, grant, &discardResponseWriter{}, ar.HttpRequest)
+		if err != nil {
+			return false, err
+		}
+		if !authorized {
+			setSilentError(ar, resp, errConsentRequired)
+			return false, nil
+		}
+		ar.Authorized = true
+		return false, nil
+	}
+
 	// React to an unauthorized grant
 	authorized, handled, err := h.handler.GrantNeeded(user, grant, w, ar.HttpRequest)
 	if authorized {
@@ -155,6 +169,19 @@ func NewRedirectGrant(subpath string) GrantHandler {
 
 // GrantNeeded implements the GrantHandler interface
 func (g *redirectGrant) GrantNeeded(user user.Info, grant *api.Grant, w http.ResponseWriter, req *http.Request) (bool, bool, error) {
+	if err := redirectToSubpath(g.subpath, url.Values{
+		"client_id":    {grant.Client.GetId()},
+		"scope":        {grant.Scope},
+		"redirect_uri": {grant.RedirectURI},
+	}, w, req); err != nil {
+		return false, false, err
+	}
+	return false, true, nil
+}
+
+// redirectToSubpath redirects to the subpath of the current request, adding the given query parameters
+// and a "then" parameter to return to the current request.
+func redirectToSubpath(subpath string, params url.Values, w http.ResponseWriter, req *http.Request) error {
 	_, lastSegment := path.Split(req.URL.Path)
 
 	// We're going to descend one dir for the approve endpoint.
@@ -169,27 +196,26 @@ func (g *redirectGrant) GrantNeeded(user user.Info, grant *api.Grant, w http.Res
 	//   User -> https://auth.example.com/foo/oauth/authorize?...
 	reqURL, err := url.Parse(req.URL.String())
 	if err != nil {
-		return false, false, err
+		return err
 	}
 
 	reqURL.Host = ""
 	reqURL.Scheme = ""
 	reqURL.Path = path.Join("..", lastSegment)
 
+	query := url.Values{"then": {reqURL.String()}}
+	for k, v := range params {
+		query[k] = v
+	}
+
 	// Make our redirect URL a relative redirect to the subpath
 	redirectURL := &url.URL{
-		Path: path.Join(lastSegment, g.subpath),
-		RawQuery: url.Values{
-			"then":         {reqURL.String()},
-			"client_id":    {grant.Client.GetId()},
-			"scope":        {grant.Scope},
-			"redirect_uri": {grant.RedirectURI},
-		}.Encode(),
+		Path:     path.Join(lastSegment, subpath),
+		RawQuery: query.Encode(),
 	}
 	w.Header().Set("Location", redirectURL.String())
 	w.WriteHeader(http.StatusFound)
-
-	return false, true, nil
+	return nil
 }
 
 type perClientGrant struct {
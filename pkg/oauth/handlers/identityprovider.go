@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/openshift/osin"
+	"k8s.io/klog/v2"
+
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apiserver/pkg/authentication/authenticator"
+	"k8s.io/apiserver/pkg/authentication/user"
+
+	"github.com/openshift/oauth-server/pkg/osinserver"
+)
+
+// NewIdentityProviderRequest returns an authenticator that records the identity provider in the user info of the
+// requests delegate authenticates
+func NewIdentityProviderRequest(identityProvider string, delegate authenticator.Request) authenticator.Request {
+	return authenticator.RequestFunc(func(req *http.Request) (*authenticator.Response, bool, error) {
+		response, ok, err := delegate.AuthenticateRequest(req)
+		if err != nil || !ok {
+			return response, ok, err
+		}
+		authenticated := *response
+		authenticated.User = WithIdentityProvider(response.User, identityProvider)
+		return &authenticated, true, nil
+	})
+}
+
+// NewIdentityProviderSuccessHandler returns a success handler that records the identity provider in the user info of
+// logins before passing them to delegate, e.g. to the session
+func NewIdentityProviderSuccessHandler(identityProvider string, delegate AuthenticationSuccessHandler) AuthenticationSuccessHandler {
+	return &identityProviderSuccessHandler{identityProvider: identityProvider, delegate: delegate}
+}
+
+type identityProviderSuccessHandler struct {
+	identityProvider string
+	delegate         AuthenticationSuccessHandler
+}
+
+func (h *identityProviderSuccessHandler) AuthenticationSucceeded(user user.Info, state string, w http.ResponseWriter, req *http.Request) (bool, error) {
+	return h.delegate.AuthenticationSucceeded(WithIdentityProvider(user, h.identityProvider), state, w, req)
+}
+
+type clientIdentityProviderCheck struct {
+	identityProviders map[string]sets.String
+	handler           AuthenticationHandler
+}
+
+// NewClientIdentityProviderCheck returns an AuthorizeHandler that enforces the identity providers of the clients
+// that restrictions limits to some. Users that did not log in with one of them, e.g. that have a session of another
+// provider, are not authorized and handler asks them to log in again. Requests with prompt=none are returned to the
+// client with a login_required error instead.
+func NewClientIdentityProviderCheck(restrictions ClientRestrictions, handler AuthenticationHandler) osinserver.AuthorizeHandler {
+	identityProviders := map[string]sets.String{}
+	for clientName, providers := range restrictions.IdentityProviders {
+		identityProviders[clientName] = sets.NewString(providers...)
+	}
+	return &clientIdentityProviderCheck{identityProviders: identityProviders, handler: handler}
+}
+
+func (h *clientIdentityProviderCheck) HandleAuthorize(ar *osin.AuthorizeRequest, resp *osin.Response, w http.ResponseWriter) (bool, error) {
+	if !ar.Authorized {
+		return false, nil
+	}
+	allowed, ok := h.identityProviders[ar.Client.GetId()]
+	if !ok {
+		return false, nil
+	}
+
+	info, ok := ar.UserData.(user.Info)
+	if !ok || info == nil {
+		utilruntime.HandleError(fmt.Errorf("the provided user data is not a user.Info object: %#v", ar.UserData))
+		ar.Authorized = false
+		resp.SetError("server_error", "")
+		return false, nil
+	}
+	// logins that did not record their provider, e.g. sessions issued before it was recorded, are not allowed
+	identityProvider := ""
+	if authenticated, ok := info.(IdentityProvider); ok {
+		identityProvider = authenticated.GetIdentityProvider()
+	}
+	if allowed.Has(identityProvider) {
+		return false, nil
+	}
+
+	klog.V(4).Infof("User %q logged in with identity provider %q, which client %q does not allow", info.GetName(), identityProvider, ar.Client.GetId())
+	ar.Authorized = false
+	if silent(ar.HttpRequest) {
+		setSilentError(ar, resp, errLoginRequired)
+		return false, nil
+	}
+	return h.handler.AuthenticationNeeded(ar.Client, w, ar.HttpRequest)
+}
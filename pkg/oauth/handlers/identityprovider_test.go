@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/openshift/osin"
+
+	"k8s.io/apiserver/pkg/authentication/authenticator"
+	"k8s.io/apiserver/pkg/authentication/user"
+
+	oauthapi "github.com/openshift/api/oauth/v1"
+	"github.com/openshift/oauth-server/pkg/api"
+)
+
+// recordingAuthenticationHandler records the requests that need authentication
+type recordingAuthenticationHandler struct {
+	needed int
+}
+
+func (h *recordingAuthenticationHandler) AuthenticationNeeded(client api.Client, w http.ResponseWriter, req *http.Request) (bool, error) {
+	h.needed++
+	return true, nil
+}
+
+func TestClientIdentityProviderCheck(t *testing.T) {
+	bob := &user.DefaultInfo{Name: "bob", UID: "bob-uid"}
+	restrictions := ClientRestrictions{IdentityProviders: map[string][]string{"console": {"sso"}}}
+
+	tests := []struct {
+		name   string
+		client string
+		user   user.Info
+		query  string
+
+		expectAuthorized bool
+		expectNeeded     bool
+		expectError      string
+	}{
+		{
+			name:             "unrestricted client",
+			client:           "cli",
+			user:             WithIdentityProvider(bob, "htpasswd"),
+			expectAuthorized: true,
+		},
+		{
+			name:             "allowed provider",
+			client:           "console",
+			user:             WithUserAgent(WithIdentityProvider(bob, "sso"), "browser"),
+			expectAuthorized: true,
+		},
+		{
+			name:         "other provider",
+			client:       "console",
+			user:         WithIdentityProvider(bob, "htpasswd"),
+			expectNeeded: true,
+		},
+		{
+			name:         "unknown provider",
+			client:       "console",
+			user:         bob,
+			expectNeeded: true,
+		},
+		{
+			name:        "other provider without prompt",
+			client:      "console",
+			user:        WithIdentityProvider(bob, "htpasswd"),
+			query:       "?prompt=none",
+			expectError: errLoginRequired,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := &recordingAuthenticationHandler{}
+			ar := &osin.AuthorizeRequest{
+				Authorized:  true,
+				Client:      &testClient{client: &oauthapi.OAuthClient{}},
+				UserData:    tt.user,
+				HttpRequest: httptest.NewRequest(http.MethodGet, "/oauth/authorize"+tt.query, nil),
+			}
+			ar.Client.(*testClient).client.Name = tt.client
+			resp := &osin.Response{Output: osin.ResponseData{}}
+
+			handled, err := NewClientIdentityProviderCheck(restrictions, handler).HandleAuthorize(ar, resp, httptest.NewRecorder())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ar.Authorized != tt.expectAuthorized {
+				t.Errorf("expected authorized %v, got %v", tt.expectAuthorized, ar.Authorized)
+			}
+			if (handler.needed > 0) != tt.expectNeeded || handled != tt.expectNeeded {
+				t.Errorf("expected authentication needed %v, got %d calls and handled %v", tt.expectNeeded, handler.needed, handled)
+			}
+			if resp.ErrorId != tt.expectError {
+				t.Errorf("expected error %q, got %q", tt.expectError, resp.ErrorId)
+			}
+		})
+	}
+}
+
+func TestIdentityProviderRequest(t *testing.T) {
+	delegate := authenticator.RequestFunc(func(req *http.Request) (*authenticator.Response, bool, error) {
+		return &authenticator.Response{User: WithAuthenticationMethods(&user.DefaultInfo{Name: "bob"}, []string{"pwd"})}, true, nil
+	})
+	response, ok, err := NewIdentityProviderRequest("htpasswd", delegate).AuthenticateRequest(httptest.NewRequest(http.MethodGet, "/", nil))
+	if err != nil || !ok {
+		t.Fatalf("unexpected result %v %v", ok, err)
+	}
+	if identityProvider, ok := response.User.(IdentityProvider); !ok || identityProvider.GetIdentityProvider() != "htpasswd" {
+		t.Errorf("expected the identity provider to be recorded, got %#v", response.User)
+	}
+	if methods, ok := response.User.(AuthenticationMethods); !ok || len(methods.GetAuthenticationMethods()) != 1 {
+		t.Errorf("expected the authentication methods to be kept, got %#v", response.User)
+	}
+}
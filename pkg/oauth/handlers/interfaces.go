@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"net/http"
+	"net/url"
 
 	"github.com/openshift/oauth-server/pkg/api"
 	"k8s.io/apiserver/pkg/authentication/user"
@@ -30,6 +31,7 @@ type AuthenticationRedirector interface {
 type AuthenticationRedirectors struct {
 	names         []string
 	redirectorMap map[string]AuthenticationRedirector
+	displays      map[string]api.ProviderDisplay
 }
 
 // Add a name and a matching redirection routine to the list
@@ -51,6 +53,25 @@ func (ar *AuthenticationRedirectors) Add(name string, redirector AuthenticationR
 	ar.redirectorMap[name] = redirector
 }
 
+// AddWithDisplay adds a name and a matching redirection routine to the list like Add, with the
+// presentation of the identity provider to users choosing one
+func (ar *AuthenticationRedirectors) AddWithDisplay(name string, redirector AuthenticationRedirector, display api.ProviderDisplay) {
+	if _, exists := ar.redirectorMap[name]; exists {
+		return
+	}
+	ar.Add(name, redirector)
+	if ar.displays == nil {
+		ar.displays = map[string]api.ProviderDisplay{}
+	}
+	ar.displays[name] = display
+}
+
+// Display returns the presentation of the identity provider with the given name, which is empty if
+// it was added without one
+func (ar *AuthenticationRedirectors) Display(name string) api.ProviderDisplay {
+	return ar.displays[name]
+}
+
 // Get the AuthenticationRedirector associated with a name.
 // Also returns a boolean indicating whether the name matched
 func (ar *AuthenticationRedirectors) Get(name string) (AuthenticationRedirector, bool) {
@@ -84,6 +105,20 @@ type AuthenticationSelectionHandler interface {
 	SelectAuthentication([]api.ProviderInfo, http.ResponseWriter, *http.Request) (selected *api.ProviderInfo, handled bool, err error)
 }
 
+// AuthenticationSelectionRecorder is optionally implemented by AuthenticationSelectionHandlers that
+// want to know which identity provider the user chose on the selection page
+type AuthenticationSelectionRecorder interface {
+	// ProviderSelected is called before the user is redirected to the chosen identity provider
+	ProviderSelected(name string, w http.ResponseWriter, req *http.Request)
+}
+
+// ProviderLister is optionally implemented by AuthenticationHandlers that offer identity providers
+type ProviderLister interface {
+	// ProviderLinks returns the identity providers the client with the given name offers, with the URLs that log in
+	// with them. The URLs are authorizeURL with the parameter that selects the provider.
+	ProviderLinks(clientName string, authorizeURL url.URL, req *http.Request) []api.ProviderInfo
+}
+
 // AuthenticationSuccessHandler reacts to a user authenticating
 type AuthenticationSuccessHandler interface {
 	// AuthenticationSucceeded reacts to a user authenticating, returns true if the response was written,
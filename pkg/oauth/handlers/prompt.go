@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/openshift/osin"
+)
+
+const (
+	// PromptParam is the parameter of authorize requests that asks for or against pages shown to users
+	// (OpenID Connect Core 1.0, section 3.1.2.1)
+	PromptParam = "prompt"
+	// PromptNone asks to authorize without showing any page, e.g. to refresh tokens in a hidden iframe. Requests
+	// that would need a page are returned to the client with one of the errors below instead.
+	PromptNone = "none"
+
+	// errLoginRequired is returned to silent requests of users without a session
+	errLoginRequired = "login_required"
+	// errConsentRequired is returned to silent requests that would ask users to grant scopes
+	errConsentRequired = "consent_required"
+	// errInteractionRequired is returned to silent requests that would show other pages, e.g. the terms of service
+	errInteractionRequired = "interaction_required"
+)
+
+// silent returns whether the authorize request asks not to be shown any page
+func silent(req *http.Request) bool {
+	if req == nil {
+		return false
+	}
+	for _, prompt := range strings.Fields(req.FormValue(PromptParam)) {
+		if prompt == PromptNone {
+			return true
+		}
+	}
+	return false
+}
+
+// setSilentError returns the authorize request to the client with the given error
+func setSilentError(ar *osin.AuthorizeRequest, resp *osin.Response, errorCode string) {
+	ar.Authorized = false
+	resp.SetErrorState(errorCode, "", ar.State)
+	if ar.Type == osin.TOKEN {
+		resp.SetRedirectFragment(true)
+	}
+}
+
+// discardResponseWriter drops everything that is written to it, it lets silent requests find out whether a handler
+// would have shown a page
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (w *discardResponseWriter) Header() http.Header {
+	if w.header == nil {
+		w.header = http.Header{}
+	}
+	return w.header
+}
+
+func (w *discardResponseWriter) Write(data []byte) (int, error) {
+	return len(data), nil
+}
+
+func (w *discardResponseWriter) WriteHeader(int) {}
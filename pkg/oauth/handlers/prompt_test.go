@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"k8s.io/apiserver/pkg/authentication/authenticator"
+	"k8s.io/apiserver/pkg/authentication/user"
+
+	oauthapi "github.com/openshift/api/oauth/v1"
+	"github.com/openshift/oauth-server/pkg/api"
+	"github.com/openshift/osin"
+)
+
+type fakeGrantChecker bool
+
+func (c fakeGrantChecker) HasAuthorizedClient(user.Info, *api.Grant) (bool, error) {
+	return bool(c), nil
+}
+
+func TestSilentAuthorize(t *testing.T) {
+	loggedIn := authenticator.RequestFunc(func(*http.Request) (*authenticator.Response, bool, error) {
+		return &authenticator.Response{User: &user.DefaultInfo{Name: "bob"}}, true, nil
+	})
+	loggedOut := authenticator.RequestFunc(func(*http.Request) (*authenticator.Response, bool, error) {
+		return nil, false, nil
+	})
+	failing := authenticator.RequestFunc(func(*http.Request) (*authenticator.Response, bool, error) {
+		return nil, false, errors.New("failed")
+	})
+
+	testCases := map[string]struct {
+		Query   string
+		Type    osin.AuthorizeRequestType
+		Request authenticator.Request
+		Granted bool
+		Grant   GrantHandler
+
+		ExpectedAuthorized bool
+		ExpectedHandled    bool
+		ExpectedError      string
+		ExpectedFragment   bool
+	}{
+		"session": {
+			Query:              "prompt=none",
+			Request:            loggedIn,
+			Granted:            true,
+			ExpectedAuthorized: true,
+		},
+		"no session": {
+			Query:         "prompt=none",
+			Request:       loggedOut,
+			ExpectedError: errLoginRequired,
+		},
+		"no session with token": {
+			Query:            "prompt=none",
+			Type:             osin.TOKEN,
+			Request:          loggedOut,
+			ExpectedError:    errLoginRequired,
+			ExpectedFragment: true,
+		},
+		"authentication error": {
+			Query:         "prompt=none",
+			Request:       failing,
+			ExpectedError: errLoginRequired,
+		},
+		"grant needed": {
+			Query:         "prompt=none",
+			Request:       loggedIn,
+			Grant:         NewRedirectGrant("approve"),
+			ExpectedError: errConsentRequired,
+		},
+		"auto grant": {
+			Query:              "prompt=none",
+			Request:            loggedIn,
+			Grant:              NewAutoGrant(),
+			ExpectedAuthorized: true,
+		},
+		"not silent": {
+			Request:         loggedIn,
+			Grant:           NewRedirectGrant("approve"),
+			ExpectedHandled: true,
+		},
+	}
+
+	for k, testCase := range testCases {
+		t.Run(k, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "https://example.com/oauth/authorize?client_id=console&state=123&"+testCase.Query, nil)
+			ar := &osin.AuthorizeRequest{
+				Type:        testCase.Type,
+				Client:      &testClient{client: &oauthapi.OAuthClient{}},
+				State:       "123",
+				HttpRequest: req,
+			}
+			resp := &osin.Response{Output: osin.ResponseData{}}
+			w := httptest.NewRecorder()
+
+			handlers := []interface {
+				HandleAuthorize(*osin.AuthorizeRequest, *osin.Response, http.ResponseWriter) (bool, error)
+			}{
+				NewAuthorizeAuthenticator(testCase.Request, nil, nil),
+				NewGrantCheck(fakeGrantChecker(testCase.Granted), testCase.Grant, nil),
+			}
+			handled := false
+			for _, handler := range handlers {
+				var err error
+				if handled, err = handler.HandleAuthorize(ar, resp, w); err != nil {
+					t.Fatal(err)
+				}
+				if handled || resp.IsError {
+					break
+				}
+			}
+
+			if handled != testCase.ExpectedHandled {
+				t.Errorf("expected handled %v, got %v", testCase.ExpectedHandled, handled)
+			}
+			if ar.Authorized != testCase.ExpectedAuthorized {
+				t.Errorf("expected authorized %v, got %v", testCase.ExpectedAuthorized, ar.Authorized)
+			}
+			if resp.ErrorId != testCase.ExpectedError {
+				t.Errorf("expected error %q, got %q", testCase.ExpectedError, resp.ErrorId)
+			}
+			if resp.IsError && resp.Output["state"] != "123" {
+				t.Errorf("expected the state in the error, got %v", resp.Output)
+			}
+			if resp.RedirectInFragment != testCase.ExpectedFragment {
+				t.Errorf("expected the error in the fragment %v, got %v", testCase.ExpectedFragment, resp.RedirectInFragment)
+			}
+		})
+	}
+}
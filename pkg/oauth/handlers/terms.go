@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+
+	"github.com/openshift/osin"
+
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apiserver/pkg/authentication/user"
+
+	"github.com/openshift/oauth-server/pkg/osinserver"
+)
+
+// TermsChecker determines whether users accepted the terms of service
+type TermsChecker interface {
+	// Accepted returns true if the user accepted the given version of the terms of service
+	Accepted(user user.Info, version string) (bool, error)
+}
+
+type termsCheck struct {
+	checker TermsChecker
+	version string
+	subpath string
+}
+
+// NewTermsCheck returns an AuthorizeHandler that redirects authorized requests of users that did not accept
+// the given version of the terms of service to the given subpath, before they are asked to grant scopes.
+// The subpath is expected to return to the "then" URL once the terms were accepted, or with an
+// access_denied error if they were declined.
+func NewTermsCheck(checker TermsChecker, version, subpath string) osinserver.AuthorizeHandler {
+	return &termsCheck{checker: checker, version: version, subpath: subpath}
+}
+
+func (h *termsCheck) HandleAuthorize(ar *osin.AuthorizeRequest, resp *osin.Response, w http.ResponseWriter) (bool, error) {
+	if !ar.Authorized {
+		return false, nil
+	}
+
+	user, ok := ar.UserData.(user.Info)
+	if !ok || user == nil {
+		utilruntime.HandleError(fmt.Errorf("the provided user data is not a user.Info object: %#v", user))
+		ar.Authorized = false
+		resp.SetError("server_error", "")
+		return false, nil
+	}
+
+	accepted, err := h.checker.Accepted(user, h.version)
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("error checking whether user %q accepted the terms of service: %v", user.GetName(), err))
+		ar.Authorized = false
+		resp.SetError("server_error", "")
+		return false, nil
+	}
+	if accepted {
+		return false, nil
+	}
+
+	if silent(ar.HttpRequest) {
+		setSilentError(ar, resp, errInteractionRequired)
+		return false, nil
+	}
+	ar.Authorized = false
+	// the terms page only returns to server-relative URLs, unlike the grant page the return URL is not a relative
+	// backstep. A path prefix of the server is added to the Location when the page redirects.
+	req := ar.HttpRequest
+	then := &url.URL{Path: req.URL.Path, RawQuery: req.URL.RawQuery}
+	_, lastSegment := path.Split(req.URL.Path)
+	redirectURL := &url.URL{
+		Path:     path.Join(lastSegment, h.subpath),
+		RawQuery: url.Values{"then": {then.String()}}.Encode(),
+	}
+	w.Header().Set("Location", redirectURL.String())
+	w.WriteHeader(http.StatusFound)
+	return true, nil
+}
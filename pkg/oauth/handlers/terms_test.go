@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/openshift/osin"
+
+	"k8s.io/apiserver/pkg/authentication/user"
+)
+
+type fakeTermsChecker struct {
+	accepted bool
+	err      error
+}
+
+func (c fakeTermsChecker) Accepted(user.Info, string) (bool, error) {
+	return c.accepted, c.err
+}
+
+func TestTermsCheck(t *testing.T) {
+	tests := []struct {
+		name       string
+		authorized bool
+		checker    fakeTermsChecker
+		query      string
+
+		expectAuthorized bool
+		expectHandled    bool
+		expectRedirect   string
+		expectError      string
+	}{
+		{
+			name: "not authorized",
+		},
+		{
+			name:             "accepted",
+			authorized:       true,
+			checker:          fakeTermsChecker{accepted: true},
+			expectAuthorized: true,
+		},
+		{
+			name:           "not accepted",
+			authorized:     true,
+			expectHandled:  true,
+			expectRedirect: "authorize/terms?then=%2Foauth%2Fauthorize%3Fclient_id%3Dfoo",
+		},
+		{
+			name:        "not accepted silently",
+			authorized:  true,
+			query:       "&prompt=none",
+			expectError: "interaction_required",
+		},
+		{
+			name:        "error",
+			authorized:  true,
+			checker:     fakeTermsChecker{err: errors.New("failed")},
+			expectError: "server_error",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", "https://example.com/oauth/authorize?client_id=foo"+tt.query, nil)
+			ar := &osin.AuthorizeRequest{Authorized: tt.authorized, UserData: &user.DefaultInfo{Name: "bob", UID: "bob-uid"}, HttpRequest: req}
+			resp := &osin.Response{Output: osin.ResponseData{}}
+			w := httptest.NewRecorder()
+
+			handled, err := NewTermsCheck(tt.checker, "v1", "terms").HandleAuthorize(ar, resp, w)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if handled != tt.expectHandled {
+				t.Errorf("expected handled %v, got %v", tt.expectHandled, handled)
+			}
+			if ar.Authorized != tt.expectAuthorized {
+				t.Errorf("expected authorized %v, got %v", tt.expectAuthorized, ar.Authorized)
+			}
+			if location := w.Header().Get("Location"); location != tt.expectRedirect {
+				t.Errorf("expected redirect to %q, got %q", tt.expectRedirect, location)
+			}
+			if resp.ErrorId != tt.expectError {
+				t.Errorf("expected error %q, got %q", tt.expectError, resp.ErrorId)
+			}
+		})
+	}
+}
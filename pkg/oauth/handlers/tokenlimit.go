@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/openshift/osin"
+
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apiserver/pkg/authentication/user"
+
+	"github.com/openshift/oauth-server/pkg/osinserver"
+)
+
+// TokenLimiter decides whether users may be issued more access tokens
+type TokenLimiter interface {
+	// Exceeded returns true if the user may not be issued another access token
+	Exceeded(user user.Info) (bool, error)
+}
+
+type tokenLimitCheck struct {
+	limiter TokenLimiter
+}
+
+// NewTokenLimitCheck returns an AuthorizeHandler that denies authorized requests of users
+// that hold the maximum number of access tokens, before they are issued an authorize code.
+func NewTokenLimitCheck(limiter TokenLimiter) osinserver.AuthorizeHandler {
+	return &tokenLimitCheck{limiter: limiter}
+}
+
+func (h *tokenLimitCheck) HandleAuthorize(ar *osin.AuthorizeRequest, resp *osin.Response, w http.ResponseWriter) (bool, error) {
+	if !ar.Authorized {
+		return false, nil
+	}
+
+	user, ok := ar.UserData.(user.Info)
+	if !ok || user == nil {
+		utilruntime.HandleError(fmt.Errorf("the provided user data is not a user.Info object: %#v", user))
+		ar.Authorized = false
+		resp.SetError("server_error", "")
+		return false, nil
+	}
+
+	exceeded, err := h.limiter.Exceeded(user)
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("error checking the access tokens of user %q: %v", user.GetName(), err))
+		ar.Authorized = false
+		resp.SetError("server_error", "")
+		return false, nil
+	}
+	if exceeded {
+		ar.Authorized = false
+		resp.SetError("access_denied", "the maximum number of access tokens was reached, log out of another session first")
+	}
+	return false, nil
+}
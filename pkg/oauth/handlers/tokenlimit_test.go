@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/openshift/osin"
+
+	"k8s.io/apiserver/pkg/authentication/user"
+)
+
+type fakeTokenLimiter struct {
+	exceeded bool
+	err      error
+}
+
+func (l fakeTokenLimiter) Exceeded(user.Info) (bool, error) {
+	return l.exceeded, l.err
+}
+
+func TestTokenLimitCheck(t *testing.T) {
+	tests := []struct {
+		name       string
+		authorized bool
+		limiter    fakeTokenLimiter
+
+		expectAuthorized bool
+		expectError      string
+	}{
+		{
+			name:    "not authorized",
+			limiter: fakeTokenLimiter{exceeded: true},
+		},
+		{
+			name:             "below limit",
+			authorized:       true,
+			expectAuthorized: true,
+		},
+		{
+			name:        "limit exceeded",
+			authorized:  true,
+			limiter:     fakeTokenLimiter{exceeded: true},
+			expectError: "access_denied",
+		},
+		{
+			name:        "error",
+			authorized:  true,
+			limiter:     fakeTokenLimiter{err: errors.New("failed")},
+			expectError: "server_error",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ar := &osin.AuthorizeRequest{Authorized: tt.authorized, UserData: &user.DefaultInfo{Name: "bob", UID: "bob-uid"}}
+			resp := &osin.Response{Output: osin.ResponseData{}}
+
+			handled, err := NewTokenLimitCheck(tt.limiter).HandleAuthorize(ar, resp, nil)
+			if handled || err != nil {
+				t.Fatalf("unexpected result %v %v", handled, err)
+			}
+			if ar.Authorized != tt.expectAuthorized {
+				t.Errorf("expected authorized %v, got %v", tt.expectAuthorized, ar.Authorized)
+			}
+			if resp.ErrorId != tt.expectError {
+				t.Errorf("expected error %q, got %q", tt.expectError, resp.ErrorId)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"k8s.io/apiserver/pkg/authentication/user"
+)
+
+// maxUserAgentLength limits the size of user agents recorded in tokens
+const maxUserAgentLength = 256
+
+// UserAgent is implemented by user data that records the user agent of the request that asked for a token
+type UserAgent interface {
+	GetUserAgent() string
+}
+
+type userAgentInfo struct {
+	user.Info
+	userAgent string
+}
+
+func (u *userAgentInfo) GetUserAgent() string {
+	return u.userAgent
+}
+
+// GetAuthenticationMethods keeps the authentication methods of the wrapped user info
+func (u *userAgentInfo) GetAuthenticationMethods() []string {
+	if methods, ok := u.Info.(AuthenticationMethods); ok {
+		return methods.GetAuthenticationMethods()
+	}
+	return nil
+}
+
+// GetIdentityProvider keeps the identity provider of the wrapped user info
+func (u *userAgentInfo) GetIdentityProvider() string {
+	if identityProvider, ok := u.Info.(IdentityProvider); ok {
+		return identityProvider.GetIdentityProvider()
+	}
+	return ""
+}
+
+// GetAuthenticationContextClass keeps the authentication context class of the wrapped user info
+func (u *userAgentInfo) GetAuthenticationContextClass() string {
+	if contextClass, ok := u.Info.(AuthenticationContextClass); ok {
+		return contextClass.GetAuthenticationContextClass()
+	}
+	return ""
+}
+
+// GetAuthenticationTime keeps the authentication time of the wrapped user info
+func (u *userAgentInfo) GetAuthenticationTime() time.Time {
+	if authenticated, ok := u.Info.(AuthenticationTime); ok {
+		return authenticated.GetAuthenticationTime()
+	}
+	return time.Time{}
+}
+
+// WithUserAgent returns user info that carries the user agent into the tokens issued to the user
+func WithUserAgent(info user.Info, userAgent string) user.Info {
+	if len(userAgent) == 0 {
+		return info
+	}
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+	return &userAgentInfo{Info: info, userAgent: userAgent}
+}
+
+// requestUserAgent returns the user agent of req, which may be nil
+func requestUserAgent(req *http.Request) string {
+	if req == nil {
+		return ""
+	}
+	return req.UserAgent()
+}
@@ -0,0 +1,215 @@
+// Package jar supports JWT-secured authorization requests (JAR, RFC 9101), whose parameters are passed in a
+// request object signed by the client, and JWT-secured authorization responses (JARM), whose parameters are
+// returned to the client in a JWT signed by the server.
+package jar
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+
+	"github.com/openshift/oauth-server/pkg/scopecovers"
+)
+
+const (
+	requestParam    = "request"
+	requestURIParam = "request_uri"
+	clientIDParam   = "client_id"
+	scopeParam      = "scope"
+
+	// requestObjectLeeway allows for clock skew between the clients and this server
+	requestObjectLeeway = time.Minute
+)
+
+// authorizationParams are the parameters of authorization requests that are only taken from the request object,
+// if the request has one. Other parameters, such as the errors and choices added by the pages of this server
+// when they return to the authorize endpoint, are kept.
+var authorizationParams = sets.NewString(
+	"response_type", "client_id", "redirect_uri", "scope", "state", "response_mode", "nonce", "display", "prompt",
+	"max_age", "ui_locales", "id_token_hint", "login_hint", "acr_values", "claims", "resource",
+	"code_challenge", "code_challenge_method", requestURIParam,
+)
+
+// registeredClaims describe the request object itself rather than the authorization request
+var registeredClaims = sets.NewString("iss", "aud", "exp", "nbf", "iat", "jti", "sub")
+
+// Client holds the settings of a client for JWT-secured authorization requests and responses
+type Client struct {
+	// Keys verify the request objects of the client
+	Keys jose.JSONWebKeySet
+	// RequireRequestObject rejects authorization requests of the client without a request object
+	RequireRequestObject bool
+	// RequireJARM returns all authorization responses to the client as signed JWTs
+	RequireJARM bool
+}
+
+// Codec verifies request objects and signs authorization responses, it implements osinserver.AuthorizeCodec
+type Codec struct {
+	issuer  string
+	clients map[string]Client
+	signer  jose.Signer
+	key     *jose.JSONWebKey
+	now     func() time.Time
+}
+
+// NewCodec returns a codec for the given clients, by client ID. Request objects must have the issuer as audience,
+// and signed responses are issued by it. Without a signing key, responses are never signed.
+func NewCodec(issuer string, clients map[string]Client, signingKey *jose.JSONWebKey) (*Codec, error) {
+	c := &Codec{issuer: issuer, clients: clients, now: time.Now}
+	if signingKey != nil {
+		signer, err := jose.NewSigner(
+			jose.SigningKey{Algorithm: jose.SignatureAlgorithm(signingKey.Algorithm), Key: signingKey},
+			(&jose.SignerOptions{}).WithType("JWT"),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create response signer: %v", err)
+		}
+		c.signer = signer
+		public := signingKey.Public()
+		c.key = &public
+	}
+	return c, nil
+}
+
+// DecodeAuthorizeRequest replaces the authorization parameters of the request with the claims of its request object
+func (c *Codec) DecodeAuthorizeRequest(r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		// the request is rejected when it is parsed again
+		return
+	}
+
+	clientID := r.Form.Get(clientIDParam)
+	client, ok := c.clients[clientID]
+	if len(r.Form.Get(requestURIParam)) > 0 {
+		reject(r, "request_uri_not_supported", "request objects must be passed by value")
+		return
+	}
+	request := r.Form.Get(requestParam)
+	if len(request) == 0 {
+		if client.RequireRequestObject {
+			reject(r, "invalid_request", "the client requires signed request objects")
+		}
+		return
+	}
+	if !ok || len(client.Keys.Keys) == 0 {
+		reject(r, "invalid_request_object", "the client has no keys to verify request objects")
+		return
+	}
+
+	params, err := c.verify(request, clientID, client.Keys)
+	if err != nil {
+		klog.V(4).Infof("invalid request object of client %q: %v", clientID, err)
+		reject(r, "invalid_request_object", err.Error())
+		return
+	}
+
+	values := url.Values{}
+	for k, v := range r.Form {
+		if !authorizationParams.Has(k) {
+			values[k] = v
+		}
+	}
+	for k, v := range params {
+		values[k] = v
+	}
+	values.Set(clientIDParam, clientID)
+	// users may approve fewer scopes than the request object asks for, the grant page narrows the scope parameter
+	if scope := r.Form.Get(scopeParam); len(scope) > 0 && scopecovers.Covers(scopecovers.Split(params.Get(scopeParam)), scopecovers.Split(scope)) {
+		values.Set(scopeParam, scope)
+	}
+	setForm(r, values)
+}
+
+// verify returns the authorization parameters of a request object of the client
+func (c *Codec) verify(request, clientID string, keys jose.JSONWebKeySet) (url.Values, error) {
+	token, err := jwt.ParseSigned(request)
+	if err != nil {
+		return nil, fmt.Errorf("request object is not a signed JWT: %v", err)
+	}
+	if len(token.Headers) != 1 {
+		return nil, errors.New("request object must have exactly one signature")
+	}
+
+	claims := jwt.Claims{}
+	rawClaims := map[string]interface{}{}
+	verified := false
+	for i := range keys.Keys {
+		key := keys.Keys[i]
+		if (len(token.Headers[0].KeyID) > 0 && key.KeyID != token.Headers[0].KeyID) || key.Use == "enc" {
+			continue
+		}
+		if err := token.Claims(&key, &claims, &rawClaims); err == nil {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, errors.New("request object signature could not be verified with the keys of the client")
+	}
+
+	if claims.Expiry == nil {
+		return nil, errors.New("request object has no expiration time")
+	}
+	if err := claims.ValidateWithLeeway(jwt.Expected{Issuer: clientID, Audience: jwt.Audience{c.issuer}, Time: c.now()}, requestObjectLeeway); err != nil {
+		return nil, fmt.Errorf("invalid request object claims: %v", err)
+	}
+	if id, ok := rawClaims[clientIDParam]; ok && id != clientID {
+		return nil, errors.New("client_id of the request object does not match the request")
+	}
+
+	params := url.Values{}
+	for name, value := range rawClaims {
+		if registeredClaims.Has(name) || name == requestParam || name == requestURIParam {
+			continue
+		}
+		switch value := value.(type) {
+		case string:
+			params.Set(name, value)
+		case float64:
+			params.Set(name, strconv.FormatFloat(value, 'f', -1, 64))
+		case []interface{}:
+			// e.g. multiple resource indicators
+			for _, v := range value {
+				if s, ok := v.(string); ok {
+					params.Add(name, s)
+				}
+			}
+		default:
+			// e.g. the claims parameter is a JSON object
+			data, err := json.Marshal(value)
+			if err != nil {
+				return nil, err
+			}
+			params.Set(name, string(data))
+		}
+	}
+	return params, nil
+}
+
+// reject replaces the request with one that returns the error to the redirect URI, which is still
+// validated against the client
+func reject(r *http.Request, code, description string) {
+	values := url.Values{"error": {code}, "error_description": {description}}
+	for _, param := range []string{"response_type", clientIDParam, "redirect_uri", "state", "response_mode"} {
+		if value := r.Form.Get(param); len(value) > 0 {
+			values.Set(param, value)
+		}
+	}
+	setForm(r, values)
+}
+
+func setForm(r *http.Request, values url.Values) {
+	r.URL.RawQuery = values.Encode()
+	r.Form = values
+	// keep the body from being parsed again
+	r.PostForm = url.Values{}
+}
@@ -0,0 +1,340 @@
+package jar
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/openshift/osin"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+	"k8s.io/client-go/util/keyutil"
+
+	"github.com/openshift/oauth-server/pkg/osinserver"
+	"github.com/openshift/oauth-server/pkg/osinserver/teststorage"
+)
+
+const issuer = "https://oauth.example.com"
+
+func newKey(t *testing.T, keyID string) *jose.JSONWebKey {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &jose.JSONWebKey{Key: privateKey, KeyID: keyID, Algorithm: string(jose.ES256), Use: "sig"}
+}
+
+func sign(t *testing.T, key *jose.JSONWebKey, claims map[string]interface{}) string {
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: key}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request, err := jwt.Signed(signer).Claims(claims).CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return request
+}
+
+func TestDecodeAuthorizeRequest(t *testing.T) {
+	now := time.Now()
+	clientKey, otherKey := newKey(t, "client"), newKey(t, "other")
+	clients := map[string]Client{
+		"client":   {Keys: jose.JSONWebKeySet{Keys: []jose.JSONWebKey{clientKey.Public()}}},
+		"strict":   {Keys: jose.JSONWebKeySet{Keys: []jose.JSONWebKey{clientKey.Public()}}, RequireRequestObject: true},
+		"keysless": {},
+	}
+	codec, err := NewCodec(issuer, clients, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	codec.now = func() time.Time { return now }
+
+	requestObject := func(key *jose.JSONWebKey, overrides map[string]interface{}) string {
+		claims := map[string]interface{}{
+			"iss":           "client",
+			"aud":           issuer,
+			"exp":           now.Add(time.Minute).Unix(),
+			"client_id":     "client",
+			"response_type": "code",
+			"redirect_uri":  "https://client.example.com/callback",
+			"scope":         "user:info user:check-access",
+			"state":         "signed-state",
+			"max_age":       300,
+		}
+		for k, v := range overrides {
+			if v == nil {
+				delete(claims, k)
+				continue
+			}
+			claims[k] = v
+		}
+		return sign(t, key, claims)
+	}
+	// ECDSA signatures differ every time, the request objects are compared as they were passed
+	validRequest, narrowRequest := requestObject(clientKey, nil), requestObject(clientKey, map[string]interface{}{"scope": "user:info"})
+
+	testCases := []struct {
+		Name   string
+		Query  url.Values
+		Expect url.Values
+	}{
+		{
+			Name:   "plain request",
+			Query:  url.Values{"client_id": {"client"}, "response_type": {"code"}, "state": {"plain"}},
+			Expect: url.Values{"client_id": {"client"}, "response_type": {"code"}, "state": {"plain"}},
+		},
+		{
+			Name:  "request object",
+			Query: url.Values{"client_id": {"client"}, "response_type": {"code"}, "state": {"unsigned"}, "code_challenge": {"unsigned"}, "request": {validRequest}},
+			Expect: url.Values{
+				"client_id":     {"client"},
+				"response_type": {"code"},
+				"redirect_uri":  {"https://client.example.com/callback"},
+				"scope":         {"user:info user:check-access"},
+				"state":         {"signed-state"},
+				"max_age":       {"300"},
+				"request":       {validRequest},
+			},
+		},
+		{
+			Name:  "request object with narrowed scope and internal parameters",
+			Query: url.Values{"client_id": {"client"}, "scope": {"user:info"}, "error": {"access_denied"}, "request": {validRequest}},
+			Expect: url.Values{
+				"client_id":     {"client"},
+				"response_type": {"code"},
+				"redirect_uri":  {"https://client.example.com/callback"},
+				"scope":         {"user:info"},
+				"state":         {"signed-state"},
+				"max_age":       {"300"},
+				"error":         {"access_denied"},
+				"request":       {validRequest},
+			},
+		},
+		{
+			Name:  "request object with widened scope",
+			Query: url.Values{"client_id": {"client"}, "scope": {"user:full"}, "request": {narrowRequest}},
+			Expect: url.Values{
+				"client_id":     {"client"},
+				"response_type": {"code"},
+				"redirect_uri":  {"https://client.example.com/callback"},
+				"scope":         {"user:info"},
+				"state":         {"signed-state"},
+				"max_age":       {"300"},
+				"request":       {narrowRequest},
+			},
+		},
+		{
+			Name:   "request object signed with another key",
+			Query:  url.Values{"client_id": {"client"}, "response_type": {"code"}, "state": {"unsigned"}, "request": {requestObject(otherKey, nil)}},
+			Expect: url.Values{"client_id": {"client"}, "response_type": {"code"}, "state": {"unsigned"}, "error": {"invalid_request_object"}},
+		},
+		{
+			Name:   "expired request object",
+			Query:  url.Values{"client_id": {"client"}, "request": {requestObject(clientKey, map[string]interface{}{"exp": now.Add(-time.Hour).Unix()})}},
+			Expect: url.Values{"client_id": {"client"}, "error": {"invalid_request_object"}},
+		},
+		{
+			Name:   "request object without expiration",
+			Query:  url.Values{"client_id": {"client"}, "request": {requestObject(clientKey, map[string]interface{}{"exp": nil})}},
+			Expect: url.Values{"client_id": {"client"}, "error": {"invalid_request_object"}},
+		},
+		{
+			Name:   "request object for another audience",
+			Query:  url.Values{"client_id": {"client"}, "request": {requestObject(clientKey, map[string]interface{}{"aud": "https://other.example.com"})}},
+			Expect: url.Values{"client_id": {"client"}, "error": {"invalid_request_object"}},
+		},
+		{
+			Name:   "request object of another client",
+			Query:  url.Values{"client_id": {"client"}, "request": {requestObject(clientKey, map[string]interface{}{"client_id": "other"})}},
+			Expect: url.Values{"client_id": {"client"}, "error": {"invalid_request_object"}},
+		},
+		{
+			Name:   "client without keys",
+			Query:  url.Values{"client_id": {"keysless"}, "request": {requestObject(clientKey, nil)}},
+			Expect: url.Values{"client_id": {"keysless"}, "error": {"invalid_request_object"}},
+		},
+		{
+			Name:   "request uri",
+			Query:  url.Values{"client_id": {"client"}, "request_uri": {"https://client.example.com/request"}},
+			Expect: url.Values{"client_id": {"client"}, "error": {"request_uri_not_supported"}},
+		},
+		{
+			Name:   "missing required request object",
+			Query:  url.Values{"client_id": {"strict"}, "response_type": {"code"}},
+			Expect: url.Values{"client_id": {"strict"}, "response_type": {"code"}, "error": {"invalid_request"}},
+		},
+	}
+
+	for _, testCase := range testCases {
+		req := httptest.NewRequest(http.MethodGet, "/oauth/authorize?"+testCase.Query.Encode(), nil)
+		codec.DecodeAuthorizeRequest(req)
+
+		actual := req.URL.Query()
+		// descriptions explain errors to developers, they are not compared
+		actual.Del("error_description")
+		if actual.Encode() != testCase.Expect.Encode() {
+			t.Errorf("%s: expected parameters\n%s\ngot\n%s", testCase.Name, testCase.Expect.Encode(), actual.Encode())
+		}
+		if req.Form.Encode() != req.URL.Query().Encode() {
+			t.Errorf("%s: expected the form to match the query, got %v", testCase.Name, req.Form)
+		}
+	}
+}
+
+func TestSignedAuthorizeFlow(t *testing.T) {
+	now := time.Now()
+	clientKey, serverKey := newKey(t, "client"), newKey(t, "server")
+	codec, err := NewCodec(issuer, map[string]Client{
+		"client": {Keys: jose.JSONWebKeySet{Keys: []jose.JSONWebKey{clientKey.Public()}}},
+		"jarm":   {RequireJARM: true},
+	}, serverKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storage := teststorage.New()
+	for _, id := range []string{"client", "jarm", "plain"} {
+		storage.Clients[id] = &osin.DefaultClient{Id: id, Secret: "secret", RedirectUri: "https://client.example.com/callback"}
+	}
+	server := osinserver.New(
+		osinserver.NewDefaultServerConfig(),
+		storage,
+		osinserver.AuthorizeHandlerFunc(func(ar *osin.AuthorizeRequest, resp *osin.Response, w http.ResponseWriter) (bool, error) {
+			ar.Authorized = true
+			return false, nil
+		}),
+		osinserver.AccessHandlerFunc(func(ar *osin.AccessRequest, w http.ResponseWriter) error {
+			return nil
+		}),
+		osinserver.NewDefaultErrorHandler(),
+		codec,
+		nil,
+		nil,
+		nil,
+		nil,
+		nil,
+	)
+	mux := http.NewServeMux()
+	server.Install(mux, "/oauth")
+	codec.Install(mux, "/oauth/jwks")
+
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/oauth/jwks", nil))
+	keySet := jose.JSONWebKeySet{}
+	if err := json.Unmarshal(resp.Body.Bytes(), &keySet); err != nil || len(keySet.Keys) != 1 || !keySet.Keys[0].IsPublic() {
+		t.Fatalf("unexpected key set %s: %v", resp.Body.String(), err)
+	}
+
+	testCases := []struct {
+		Name       string
+		Query      url.Values
+		ExpectJARM bool
+		Expect     map[string]string
+	}{
+		{
+			Name: "signed request and response",
+			Query: url.Values{"client_id": {"client"}, "response_type": {"code"}, "request": {sign(t, clientKey, map[string]interface{}{
+				"iss": "client", "aud": issuer, "exp": now.Add(time.Minute).Unix(),
+				"response_type": "code", "state": "signed-state", "response_mode": "query.jwt",
+			})}},
+			ExpectJARM: true,
+			Expect:     map[string]string{"state": "signed-state", "iss": issuer, "aud": "client"},
+		},
+		{
+			Name: "invalid request object",
+			Query: url.Values{"client_id": {"client"}, "response_type": {"code"}, "state": {"unsigned"}, "response_mode": {"jwt"}, "request": {sign(t, serverKey, map[string]interface{}{
+				"iss": "client", "aud": issuer, "exp": now.Add(time.Minute).Unix(),
+			})}},
+			ExpectJARM: true,
+			Expect:     map[string]string{"state": "unsigned", "error": "invalid_request_object"},
+		},
+		{
+			Name:       "client requiring signed responses",
+			Query:      url.Values{"client_id": {"jarm"}, "response_type": {"code"}, "state": {"state"}},
+			ExpectJARM: true,
+			Expect:     map[string]string{"state": "state", "aud": "jarm"},
+		},
+		{
+			Name:   "plain request and response",
+			Query:  url.Values{"client_id": {"plain"}, "response_type": {"code"}, "state": {"state"}},
+			Expect: map[string]string{"state": "state"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/oauth/authorize?"+testCase.Query.Encode(), nil))
+		if resp.Code != http.StatusFound {
+			t.Errorf("%s: expected redirect, got %d: %s", testCase.Name, resp.Code, resp.Body.String())
+			continue
+		}
+		location, err := url.Parse(resp.Header().Get("Location"))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", testCase.Name, err)
+			continue
+		}
+
+		params := map[string]interface{}{}
+		query := location.Query()
+		if testCase.ExpectJARM {
+			if len(query) != 1 {
+				t.Errorf("%s: expected only the response parameter, got %v", testCase.Name, query)
+				continue
+			}
+			token, err := jwt.ParseSigned(query.Get("response"))
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", testCase.Name, err)
+				continue
+			}
+			if err := token.Claims(&keySet.Keys[0], &params); err != nil {
+				t.Errorf("%s: unexpected error: %v", testCase.Name, err)
+				continue
+			}
+		} else {
+			for k := range query {
+				params[k] = query.Get(k)
+			}
+		}
+
+		for k, v := range testCase.Expect {
+			if params[k] != v {
+				t.Errorf("%s: expected %s %q, got %v", testCase.Name, k, v, params[k])
+			}
+		}
+		if _, hasCode := params["code"]; hasCode == (len(testCase.Expect["error"]) > 0) {
+			t.Errorf("%s: unexpected parameters %v", testCase.Name, params)
+		}
+	}
+}
+
+func TestSigningKeyFromFile(t *testing.T) {
+	data, err := keyutil.MakeEllipticPrivateKeyPEM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(file, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := SigningKeyFromFile(file)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if key.Algorithm != string(jose.ES256) || len(key.KeyID) == 0 || key.IsPublic() {
+		t.Errorf("unexpected key %#v", key)
+	}
+
+	if _, err := SigningKeyFromFile(filepath.Join(t.TempDir(), "missing.pem")); err == nil {
+		t.Error("expected error for missing key")
+	}
+}
@@ -0,0 +1,125 @@
+package jar
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/openshift/osin"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+	"k8s.io/client-go/util/keyutil"
+
+	oauthserver "github.com/openshift/oauth-server/pkg"
+)
+
+const (
+	responseModeParam = "response_mode"
+
+	// response modes of JARM, jwt is query.jwt for codes and fragment.jwt for tokens
+	responseModeJWT         = "jwt"
+	responseModeQueryJWT    = "query.jwt"
+	responseModeFragmentJWT = "fragment.jwt"
+
+	// responseLifetime is how long clients accept signed responses, they are used right away
+	responseLifetime = 5 * time.Minute
+)
+
+// EncodeAuthorizeResponse returns the parameters of the response in a signed JWT, if the client asked for
+// a JWT response mode or always requires it
+func (c *Codec) EncodeAuthorizeResponse(resp *osin.Response, r *http.Request) error {
+	if c.signer == nil {
+		return nil
+	}
+	clientID := r.Form.Get(clientIDParam)
+	mode := r.Form.Get(responseModeParam)
+	switch {
+	case mode == responseModeJWT || mode == responseModeQueryJWT || mode == responseModeFragmentJWT:
+	case c.clients[clientID].RequireJARM:
+	default:
+		return nil
+	}
+
+	now := c.now()
+	claims := map[string]interface{}{}
+	for k, v := range resp.Output {
+		claims[k] = v
+	}
+	claims["iss"] = c.issuer
+	claims["aud"] = clientID
+	claims["exp"] = jwt.NewNumericDate(now.Add(responseLifetime))
+	response, err := jwt.Signed(c.signer).Claims(claims).CompactSerialize()
+	if err != nil {
+		return fmt.Errorf("unable to sign authorization response: %v", err)
+	}
+
+	resp.Output = osin.ResponseData{"response": response}
+	switch mode {
+	case responseModeQueryJWT:
+		resp.SetRedirectFragment(false)
+	case responseModeFragmentJWT:
+		resp.SetRedirectFragment(true)
+	}
+	return nil
+}
+
+// Install serves the public key that verifies signed responses as a JWK set, if responses are signed
+func (c *Codec) Install(mux oauthserver.Mux, path string) {
+	if c.key == nil {
+		return
+	}
+	keySet, err := json.Marshal(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{*c.key}})
+	if err != nil {
+		panic(err)
+	}
+	mux.HandleFunc(path, func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/jwk-set+json")
+		w.Write(keySet)
+	})
+}
+
+// SigningKeyFromFile reads the PEM encoded RSA or ECDSA private key that signs responses. Its key ID is
+// its thumbprint, so that clients pick up rotated keys.
+func SigningKeyFromFile(file string) (*jose.JSONWebKey, error) {
+	privateKey, err := keyutil.PrivateKeyFromFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &jose.JSONWebKey{Key: privateKey, Use: "sig"}
+	switch privateKey := privateKey.(type) {
+	case *rsa.PrivateKey:
+		key.Algorithm = string(jose.RS256)
+	case *ecdsa.PrivateKey:
+		switch privateKey.Curve {
+		case elliptic.P256():
+			key.Algorithm = string(jose.ES256)
+		case elliptic.P384():
+			key.Algorithm = string(jose.ES384)
+		case elliptic.P521():
+			key.Algorithm = string(jose.ES512)
+		default:
+			return nil, fmt.Errorf("unsupported elliptic curve %s", privateKey.Curve.Params().Name)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", privateKey)
+	}
+
+	thumbprint, err := key.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+	key.KeyID = base64.RawURLEncoding.EncodeToString(thumbprint)
+	return key, nil
+}
@@ -0,0 +1,167 @@
+// Package mtls authenticates OAuth clients with their TLS client certificates and binds the access tokens
+// issued to them to their certificates (RFC 8705).
+package mtls
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/openshift/osin"
+	"k8s.io/apiserver/pkg/authentication/user"
+
+	"github.com/openshift/oauth-server/pkg/oauth/handlers"
+	"github.com/openshift/oauth-server/pkg/server/crypto"
+)
+
+// Client holds the certificates that authenticate a client. Every name that is set must match.
+type Client struct {
+	// Roots verify the certificates of the client
+	Roots *x509.CertPool
+	// SubjectDN is the expected subject of the certificate in RFC 4514 format
+	SubjectDN string
+	// DNSName is an expected DNS subject alternative name of the certificate
+	DNSName string
+	// URI is an expected URI subject alternative name of the certificate
+	URI string
+	// BoundTokens binds the access tokens issued to the client to its certificate
+	BoundTokens bool
+}
+
+// Authenticator authenticates clients with their certificates at the token endpoint and enforces certificate-bound
+// access tokens at the info endpoint. It implements osinserver.ClientAuthenticator, osinserver.AccessHandler and
+// osinserver.InfoHandler.
+type Authenticator struct {
+	clients map[string]Client
+}
+
+// NewAuthenticator returns an authenticator for the given clients, by client ID. Other clients authenticate
+// with their secrets.
+func NewAuthenticator(clients map[string]Client) *Authenticator {
+	return &Authenticator{clients: clients}
+}
+
+// AuthenticateClient implements osinserver.ClientAuthenticator
+func (a *Authenticator) AuthenticateClient(r *http.Request) (string, error) {
+	if err := r.ParseForm(); err != nil {
+		// the request is rejected when it is parsed again
+		return "", nil
+	}
+	clientID := r.Form.Get("client_id")
+	if len(clientID) == 0 {
+		if auth, err := osin.CheckBasicAuth(r); err == nil && auth != nil {
+			clientID = auth.Username
+		}
+	}
+	client, ok := a.clients[clientID]
+	if !ok {
+		return "", nil
+	}
+	if _, err := client.Verify(r); err != nil {
+		return "", fmt.Errorf("client %q: %v", clientID, err)
+	}
+	return clientID, nil
+}
+
+// HandleAccess implements osinserver.AccessHandler, it binds the access tokens of clients that require it to
+// the certificate that authenticated the token request
+func (a *Authenticator) HandleAccess(ar *osin.AccessRequest, w http.ResponseWriter) error {
+	if !ar.Authorized || ar.Client == nil || !a.clients[ar.Client.GetId()].BoundTokens {
+		return nil
+	}
+	info, ok := ar.UserData.(user.Info)
+	if !ok {
+		return fmt.Errorf("did not receive user.Info: %#v", ar.UserData) // should be impossible
+	}
+	thumbprint := certificateThumbprint(ar.HttpRequest)
+	if len(thumbprint) == 0 {
+		// clients with bound tokens always authenticate with their certificate
+		return errors.New("token request has no client certificate")
+	}
+	ar.UserData = handlers.WithCertificateThumbprint(info, thumbprint)
+	return nil
+}
+
+// HandleInfo implements osinserver.InfoHandler, it only describes bound access tokens to requests that present
+// the certificate the token is bound to
+func (a *Authenticator) HandleInfo(ir *osin.InfoRequest, resp *osin.Response, r *http.Request) {
+	bound, ok := ir.AccessData.UserData.(handlers.CertificateThumbprint)
+	if !ok || len(bound.GetCertificateThumbprint()) == 0 {
+		return
+	}
+	thumbprint := certificateThumbprint(r)
+	if len(thumbprint) == 0 || !crypto.IsEqualConstantTime(thumbprint, bound.GetCertificateThumbprint()) {
+		resp.ErrorStatusCode = http.StatusUnauthorized
+		resp.SetError("invalid_token", "the access token is bound to a different client certificate")
+		return
+	}
+	// the token may also be bound to other keys
+	confirmation, ok := resp.Output["cnf"].(map[string]string)
+	if !ok {
+		confirmation = map[string]string{}
+	}
+	confirmation["x5t#S256"] = thumbprint
+	resp.Output["cnf"] = confirmation
+}
+
+// Verify returns the certificate of the request if it authenticates the client
+func (c *Client) Verify(r *http.Request) (*x509.Certificate, error) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil, errors.New("no client certificate")
+	}
+	certificate := r.TLS.PeerCertificates[0]
+	intermediates := x509.NewCertPool()
+	for _, intermediate := range r.TLS.PeerCertificates[1:] {
+		intermediates.AddCert(intermediate)
+	}
+	opts := x509.VerifyOptions{
+		Roots:         c.Roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if _, err := certificate.Verify(opts); err != nil {
+		return nil, err
+	}
+
+	if len(c.SubjectDN) > 0 && certificate.Subject.String() != c.SubjectDN {
+		return nil, fmt.Errorf("unexpected certificate subject %q", certificate.Subject.String())
+	}
+	if len(c.DNSName) > 0 && !hasDNSName(certificate, c.DNSName) {
+		return nil, fmt.Errorf("certificate is not valid for DNS name %q", c.DNSName)
+	}
+	if len(c.URI) > 0 && !hasURI(certificate, c.URI) {
+		return nil, fmt.Errorf("certificate is not valid for URI %q", c.URI)
+	}
+	return certificate, nil
+}
+
+func hasDNSName(certificate *x509.Certificate, name string) bool {
+	for _, dnsName := range certificate.DNSNames {
+		if dnsName == name {
+			return true
+		}
+	}
+	return false
+}
+
+func hasURI(certificate *x509.Certificate, uri string) bool {
+	for _, u := range certificate.URIs {
+		if u.String() == uri {
+			return true
+		}
+	}
+	return false
+}
+
+// certificateThumbprint returns the base64url encoded SHA-256 hash of the client certificate of the request,
+// or an empty string if it has none
+func certificateThumbprint(r *http.Request) string {
+	if r == nil || r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256(r.TLS.PeerCertificates[0].Raw)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
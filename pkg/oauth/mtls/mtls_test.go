@@ -0,0 +1,200 @@
+package mtls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openshift/osin"
+	"k8s.io/apiserver/pkg/authentication/user"
+
+	"github.com/openshift/oauth-server/pkg/osinserver"
+	"github.com/openshift/oauth-server/pkg/osinserver/teststorage"
+)
+
+const redirectURI = "https://client.example.com/callback"
+
+func newCertificate(t *testing.T, template *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certificate, key
+}
+
+func newCA(t *testing.T, name string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	return newCertificate(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: name},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+}
+
+func newClientCertificate(t *testing.T, ca *x509.Certificate, caKey *ecdsa.PrivateKey, commonName, dnsName string) *x509.Certificate {
+	certificate, _ := newCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: commonName, Organization: []string{"example"}},
+		DNSNames:    []string{dnsName},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+	}, ca, caKey)
+	return certificate
+}
+
+func TestCertificateBoundTokenFlow(t *testing.T) {
+	ca, caKey := newCA(t, "client-ca")
+	otherCA, otherCAKey := newCA(t, "other-ca")
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+
+	clientCert := newClientCertificate(t, ca, caKey, "client", "client.example.com")
+	rotatedCert := newClientCertificate(t, ca, caKey, "client", "client.example.com")
+	wrongNameCert := newClientCertificate(t, ca, caKey, "client", "other.example.com")
+	untrustedCert := newClientCertificate(t, otherCA, otherCAKey, "client", "client.example.com")
+
+	authenticator := NewAuthenticator(map[string]Client{
+		"mtls": {Roots: roots, SubjectDN: "CN=client,O=example", DNSName: "client.example.com", BoundTokens: true},
+	})
+
+	storage := teststorage.New()
+	for _, id := range []string{"mtls", "plain"} {
+		storage.Clients[id] = &osin.DefaultClient{Id: id, Secret: "secret", RedirectUri: redirectURI}
+	}
+	codes := 0
+	newCode := func(clientID string) string {
+		codes++
+		code := "code" + strings.Repeat("x", codes)
+		storage.Authorize[code] = &osin.AuthorizeData{
+			Client:      storage.Clients[clientID],
+			Code:        code,
+			ExpiresIn:   300,
+			CreatedAt:   time.Now(),
+			RedirectUri: redirectURI,
+			UserData:    &user.DefaultInfo{Name: "bob", UID: "bob-uid"},
+		}
+		return code
+	}
+
+	server := osinserver.New(
+		osinserver.NewDefaultServerConfig(),
+		storage,
+		osinserver.AuthorizeHandlerFunc(func(ar *osin.AuthorizeRequest, resp *osin.Response, w http.ResponseWriter) (bool, error) {
+			return false, nil
+		}),
+		osinserver.AccessHandlers{
+			osinserver.AccessHandlerFunc(func(ar *osin.AccessRequest, w http.ResponseWriter) error {
+				ar.Authorized = true
+				ar.GenerateRefresh = false
+				return nil
+			}),
+			authenticator,
+		},
+		osinserver.NewDefaultErrorHandler(),
+		nil,
+		authenticator,
+		osinserver.InfoHandlers{authenticator},
+		nil,
+		nil,
+		nil,
+	)
+	mux := http.NewServeMux()
+	server.Install(mux, "/oauth")
+
+	do := func(req *http.Request, certificate *x509.Certificate) (int, map[string]interface{}) {
+		if certificate != nil {
+			req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{certificate}}
+		}
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		body := map[string]interface{}{}
+		if err := json.Unmarshal(resp.Body.Bytes(), &body); err != nil {
+			t.Fatalf("unexpected response %d %s: %v", resp.Code, resp.Body.String(), err)
+		}
+		return resp.Code, body
+	}
+	tokenRequest := func(params url.Values) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/oauth/token", strings.NewReader(params.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return req
+	}
+	codeParams := func(clientID string) url.Values {
+		return url.Values{"grant_type": {"authorization_code"}, "client_id": {clientID}, "code": {newCode(clientID)}, "redirect_uri": {redirectURI}}
+	}
+
+	for name, certificate := range map[string]*x509.Certificate{
+		"no certificate":        nil,
+		"untrusted certificate": untrustedCert,
+		"wrong DNS name":        wrongNameCert,
+	} {
+		params := codeParams("mtls")
+		// the secret of a client that authenticates with its certificate is not accepted
+		params.Set("client_secret", "secret")
+		if code, body := do(tokenRequest(params), certificate); code != http.StatusUnauthorized || body["error"] != osin.E_INVALID_CLIENT {
+			t.Errorf("%s: expected invalid_client, got %d %v", name, code, body)
+		}
+	}
+
+	code, body := do(tokenRequest(codeParams("mtls")), clientCert)
+	if code != http.StatusOK {
+		t.Fatalf("expected token, got %d %v", code, body)
+	}
+	token, _ := body["access_token"].(string)
+
+	code, body = do(tokenRequest(codeParams("plain")), clientCert)
+	if code == http.StatusOK {
+		t.Errorf("expected clients without TLS authentication to require their secret, got %d %v", code, body)
+	}
+	plainParams := codeParams("plain")
+	plainParams.Set("client_secret", "secret")
+	code, body = do(tokenRequest(plainParams), nil)
+	if code != http.StatusOK {
+		t.Fatalf("expected token for secret, got %d %v", code, body)
+	}
+	plainToken, _ := body["access_token"].(string)
+
+	infoRequest := func(token string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/oauth/info", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		return req
+	}
+	code, body = do(infoRequest(token), clientCert)
+	if cnf, _ := body["cnf"].(map[string]interface{}); code != http.StatusOK || cnf["x5t#S256"] != certificateThumbprint(&http.Request{TLS: &tls.ConnectionState{PeerCertificates: []*x509.Certificate{clientCert}}}) {
+		t.Errorf("expected info with confirmation, got %d %v", code, body)
+	}
+	for name, certificate := range map[string]*x509.Certificate{
+		"no certificate":      nil,
+		"rotated certificate": rotatedCert,
+	} {
+		if code, body := do(infoRequest(token), certificate); code != http.StatusUnauthorized || body["error"] != "invalid_token" {
+			t.Errorf("%s: expected invalid_token, got %d %v", name, code, body)
+		}
+	}
+	if code, body := do(infoRequest(plainToken), nil); code != http.StatusOK || body["cnf"] != nil {
+		t.Errorf("expected info of unbound token, got %d %v", code, body)
+	}
+}
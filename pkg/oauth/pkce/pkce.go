@@ -0,0 +1,126 @@
+// Package pkce enforces proof keys for code exchange (PKCE, RFC 7636) in authorize requests for codes. osin verifies
+// the code verifiers of token requests against the challenges of the codes, this requires challenges and restricts
+// their methods before codes are issued.
+package pkce
+
+import (
+	"encoding/base64"
+	"net/http"
+
+	"github.com/openshift/osin"
+
+	metrics "github.com/openshift/oauth-server/pkg/prometheus"
+)
+
+const (
+	challengeParam       = "code_challenge"
+	challengeMethodParam = "code_challenge_method"
+
+	// methodNone is the method recorded in metrics for requests without a challenge
+	methodNone = "none"
+
+	// s256ChallengeLength is the length of a base64url encoded SHA-256 hash without padding
+	s256ChallengeLength = 43
+)
+
+// Policy determines which authorize requests for codes must have a challenge
+type Policy struct {
+	// Required rejects requests without a challenge
+	Required bool
+	// AllowPlain accepts challenges with the plain method, which does not protect codes from attackers that can
+	// read authorize requests
+	AllowPlain bool
+}
+
+// ClientPolicy overrides the parts of the default policy that are set for a client
+type ClientPolicy struct {
+	Required   *bool
+	AllowPlain *bool
+	// CodeOnly rejects requests of the client for tokens, whose implicit flow cannot be protected with challenges
+	CodeOnly bool
+}
+
+// Enforcer rejects authorize requests for codes that do not satisfy the policy of their client, and records the
+// methods clients use in metrics. It implements osinserver.AuthorizeHandler.
+type Enforcer struct {
+	publicClients       Policy
+	confidentialClients Policy
+	clients             map[string]ClientPolicy
+}
+
+// NewEnforcer returns an Enforcer that applies the policy for public clients, clients without a secret, and for
+// confidential clients, overridden by the policies of clients by name
+func NewEnforcer(publicClients, confidentialClients Policy, clients map[string]ClientPolicy) *Enforcer {
+	return &Enforcer{publicClients: publicClients, confidentialClients: confidentialClients, clients: clients}
+}
+
+// HandleAuthorize implements osinserver.AuthorizeHandler
+func (e *Enforcer) HandleAuthorize(ar *osin.AuthorizeRequest, resp *osin.Response, w http.ResponseWriter) (bool, error) {
+	if !ar.Authorized || ar.HttpRequest == nil {
+		return false, nil
+	}
+	if ar.Type != osin.CODE {
+		if e.clients[ar.Client.GetId()].CodeOnly {
+			ar.Authorized = false
+			resp.SetErrorState(osin.E_UNAUTHORIZED_CLIENT, "the client may only request codes with "+challengeParam+" (RFC 7636)", ar.State)
+		}
+		return false, nil
+	}
+	clientName := ar.Client.GetId()
+	policy := e.policy(ar.Client)
+
+	// osin parsed and validated the challenge, its method defaults to plain
+	method := ar.CodeChallengeMethod
+	if len(ar.CodeChallenge) == 0 {
+		method = methodNone
+	}
+	metrics.RecordAuthorizePKCE(clientName, method)
+
+	switch {
+	case len(ar.CodeChallenge) == 0:
+		if policy.Required {
+			reject(ar, resp, challengeParam+" (RFC 7636) is required")
+		}
+	case method == osin.PKCE_PLAIN:
+		if !policy.AllowPlain {
+			reject(ar, resp, challengeMethodParam+" must be "+osin.PKCE_S256)
+		}
+	case method == osin.PKCE_S256:
+		if !validS256Challenge(ar.CodeChallenge) {
+			reject(ar, resp, challengeParam+" must be the base64url encoded SHA-256 hash of the code verifier")
+		}
+	}
+	return false, nil
+}
+
+// policy returns the policy of the client
+func (e *Enforcer) policy(client osin.Client) Policy {
+	policy := e.confidentialClients
+	if osin.CheckClientSecret(client, "") {
+		policy = e.publicClients
+	}
+	if clientPolicy, ok := e.clients[client.GetId()]; ok {
+		if clientPolicy.Required != nil {
+			policy.Required = *clientPolicy.Required
+		}
+		if clientPolicy.AllowPlain != nil {
+			policy.AllowPlain = *clientPolicy.AllowPlain
+		}
+	}
+	return policy
+}
+
+// validS256Challenge returns whether the challenge can be a SHA-256 hash, osin only checks the characters and length
+// that all challenges share
+func validS256Challenge(challenge string) bool {
+	if len(challenge) != s256ChallengeLength {
+		return false
+	}
+	_, err := base64.RawURLEncoding.DecodeString(challenge)
+	return err == nil
+}
+
+func reject(ar *osin.AuthorizeRequest, resp *osin.Response, description string) {
+	ar.Authorized = false
+	resp.SetErrorState(osin.E_INVALID_REQUEST, description, ar.State)
+}
@@ -0,0 +1,87 @@
+package pkce
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/openshift/osin"
+)
+
+func TestHandleAuthorize(t *testing.T) {
+	hash := sha256.Sum256([]byte(strings.Repeat("v", 43)))
+	s256Challenge := base64.RawURLEncoding.EncodeToString(hash[:])
+	plainChallenge := strings.Repeat("p", 50)
+
+	enabled := true
+	enforcer := NewEnforcer(
+		Policy{Required: true},
+		Policy{},
+		map[string]ClientPolicy{
+			"legacy":     {AllowPlain: &enabled},
+			"exempt":     {Required: new(bool)},
+			"strict-app": {Required: &enabled},
+			"native-app": {Required: &enabled, CodeOnly: true},
+		},
+	)
+
+	testCases := []struct {
+		Name      string
+		Client    string
+		Secret    string
+		Type      osin.AuthorizeRequestType
+		Challenge string
+		Method    string
+		Error     bool
+		ErrorID   string
+	}{
+		{Name: "public client with S256", Client: "spa", Challenge: s256Challenge, Method: osin.PKCE_S256},
+		{Name: "public client without challenge", Client: "spa", Error: true},
+		{Name: "public client with plain", Client: "spa", Challenge: plainChallenge, Method: osin.PKCE_PLAIN, Error: true},
+		{Name: "S256 challenge that is no hash", Client: "spa", Challenge: plainChallenge, Method: osin.PKCE_S256, Error: true},
+		{Name: "confidential client without challenge", Client: "console", Secret: "secret"},
+		{Name: "confidential client with plain", Client: "console", Secret: "secret", Challenge: plainChallenge, Method: osin.PKCE_PLAIN, Error: true},
+		{Name: "client allowed plain", Client: "legacy", Challenge: plainChallenge, Method: osin.PKCE_PLAIN},
+		{Name: "exempt public client", Client: "exempt"},
+		{Name: "confidential client that requires challenges", Client: "strict-app", Secret: "secret", Error: true},
+		{Name: "token request", Client: "spa", Type: osin.TOKEN},
+		{Name: "native app with S256", Client: "native-app", Challenge: s256Challenge, Method: osin.PKCE_S256},
+		{Name: "token request of native app", Client: "native-app", Type: osin.TOKEN, Error: true, ErrorID: osin.E_UNAUTHORIZED_CLIENT},
+	}
+	for _, testCase := range testCases {
+		requestType := testCase.Type
+		if len(requestType) == 0 {
+			requestType = osin.CODE
+		}
+		ar := &osin.AuthorizeRequest{
+			Type:                requestType,
+			Client:              &osin.DefaultClient{Id: testCase.Client, Secret: testCase.Secret},
+			Authorized:          true,
+			State:               "state",
+			CodeChallenge:       testCase.Challenge,
+			CodeChallengeMethod: testCase.Method,
+			HttpRequest:         httptest.NewRequest(http.MethodGet, "/oauth/authorize", nil),
+		}
+		resp := &osin.Response{Output: osin.ResponseData{}}
+		if handled, err := enforcer.HandleAuthorize(ar, resp, httptest.NewRecorder()); handled || err != nil {
+			t.Errorf("%s: unexpected result %v %v", testCase.Name, handled, err)
+			continue
+		}
+		if testCase.Error {
+			errorID := testCase.ErrorID
+			if len(errorID) == 0 {
+				errorID = osin.E_INVALID_REQUEST
+			}
+			if ar.Authorized || resp.ErrorId != errorID || resp.Output["state"] != "state" {
+				t.Errorf("%s: expected %s, got %v %v", testCase.Name, errorID, ar.Authorized, resp.Output)
+			}
+			continue
+		}
+		if !ar.Authorized || resp.IsError {
+			t.Errorf("%s: unexpected error %v", testCase.Name, resp.Output)
+		}
+	}
+}
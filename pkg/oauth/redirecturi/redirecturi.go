@@ -0,0 +1,78 @@
+// Package redirecturi matches the redirect URIs of authorize and token requests against the redirect URIs registered
+// for their clients with policies per client. Clients without a policy are matched like osin matches them: a redirect
+// URI matches a registered URI with the same scheme and host, and the same path or a subpath of it.
+package redirecturi
+
+import (
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/openshift/osin"
+)
+
+// Policy determines which redirect URIs match the registered redirect URIs of a client
+type Policy struct {
+	// Exact only matches the registered redirect URIs themselves, not their subpaths or other queries
+	Exact bool
+	// LoopbackAnyPort matches registered redirect URIs on loopback interfaces with any port, so that native apps can
+	// listen on the port the operating system assigns them (RFC 8252 section 7.3). The host must still match, e.g.
+	// http://127.0.0.1/callback matches http://127.0.0.1:51004/callback but not http://localhost:51004/callback.
+	LoopbackAnyPort bool
+	// PrivateUseSchemes matches redirect URIs with private-use schemes of native apps, e.g. com.example.app:/callback,
+	// only exactly (RFC 8252 section 7.1). Their schemes must be reverse domain names. osin would match URIs
+	// without path such as com.example.app:callback with any other URI of the scheme.
+	PrivateUseSchemes bool
+}
+
+// Matcher matches redirect URIs with the policies of their clients. It implements osinserver.RedirectURIMatcher.
+type Matcher struct {
+	policies map[string]Policy
+}
+
+// NewMatcher returns a Matcher with the policies of clients by name
+func NewMatcher(policies map[string]Policy) *Matcher {
+	return &Matcher{policies: policies}
+}
+
+// MatchRedirectURI implements osinserver.RedirectURIMatcher
+func (m *Matcher) MatchRedirectURI(clientID string, registered []string, redirectURI string) bool {
+	policy := m.policies[clientID]
+	for _, registeredURI := range registered {
+		if policy.matches(registeredURI, redirectURI) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p Policy) matches(registeredURI, redirectURI string) bool {
+	registered, err := url.Parse(registeredURI)
+	if err != nil || len(registered.Fragment) > 0 {
+		return false
+	}
+	redirect, err := url.Parse(redirectURI)
+	if err != nil || len(redirect.Fragment) > 0 {
+		return false
+	}
+
+	if p.PrivateUseSchemes && registered.Scheme != "http" && registered.Scheme != "https" {
+		return strings.Contains(registered.Scheme, ".") && registered.String() == redirect.String()
+	}
+	if p.LoopbackAnyPort && isLoopback(registered.Hostname()) && registered.Hostname() == redirect.Hostname() {
+		registered.Host = redirect.Host
+	}
+	if p.Exact {
+		return registered.String() == redirect.String()
+	}
+	return osin.ValidateUri(registered.String(), redirectURI) == nil
+}
+
+// isLoopback returns whether host is a loopback IP address or localhost, which RFC 8252 allows but does not recommend
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,53 @@
+package redirecturi
+
+import "testing"
+
+func TestMatchRedirectURI(t *testing.T) {
+	matcher := NewMatcher(map[string]Policy{
+		"exact":        {Exact: true},
+		"native":       {LoopbackAnyPort: true},
+		"strictnative": {Exact: true, LoopbackAnyPort: true},
+		"desktop":      {LoopbackAnyPort: true, PrivateUseSchemes: true},
+	})
+	registered := []string{"https://app.example.com/callback", "http://127.0.0.1/cli", "http://[::1]:8080/cli", "http://localhost/cli", "com.example.app:callback", "myapp:/callback"}
+
+	testCases := []struct {
+		Client      string
+		RedirectURI string
+		Expected    bool
+	}{
+		{Client: "default", RedirectURI: "https://app.example.com/callback", Expected: true},
+		{Client: "default", RedirectURI: "https://app.example.com/callback/sub", Expected: true},
+		{Client: "default", RedirectURI: "https://app.example.com/callback/../admin"},
+		{Client: "default", RedirectURI: "https://evil.example.com/callback"},
+		{Client: "default", RedirectURI: "http://127.0.0.1:51004/cli"},
+		{Client: "default", RedirectURI: "https://app.example.com/callback#fragment"},
+
+		{Client: "exact", RedirectURI: "https://app.example.com/callback", Expected: true},
+		{Client: "exact", RedirectURI: "https://app.example.com/callback/sub"},
+		{Client: "exact", RedirectURI: "https://app.example.com/callback?next=/admin"},
+		{Client: "exact", RedirectURI: "http://127.0.0.1:51004/cli"},
+
+		{Client: "native", RedirectURI: "http://127.0.0.1:51004/cli", Expected: true},
+		{Client: "native", RedirectURI: "http://127.0.0.1/cli/sub", Expected: true},
+		{Client: "native", RedirectURI: "http://[::1]:51004/cli", Expected: true},
+		{Client: "native", RedirectURI: "http://localhost:51004/cli", Expected: true},
+		{Client: "native", RedirectURI: "http://127.0.0.2:51004/cli"},
+		{Client: "native", RedirectURI: "https://127.0.0.1:51004/cli"},
+		{Client: "native", RedirectURI: "https://app.example.com:8443/callback"},
+
+		{Client: "strictnative", RedirectURI: "http://127.0.0.1:51004/cli", Expected: true},
+		{Client: "strictnative", RedirectURI: "http://127.0.0.1:51004/cli/sub"},
+
+		{Client: "default", RedirectURI: "com.example.app:other", Expected: true},
+		{Client: "desktop", RedirectURI: "com.example.app:callback", Expected: true},
+		{Client: "desktop", RedirectURI: "com.example.app:other"},
+		{Client: "desktop", RedirectURI: "myapp:/callback"},
+		{Client: "desktop", RedirectURI: "http://127.0.0.1:51004/cli", Expected: true},
+	}
+	for _, testCase := range testCases {
+		if matched := matcher.MatchRedirectURI(testCase.Client, registered, testCase.RedirectURI); matched != testCase.Expected {
+			t.Errorf("%s: expected %s to match %v, got %v", testCase.Client, testCase.RedirectURI, testCase.Expected, matched)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package registry
+
+import (
+	"encoding/json"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	oauth "github.com/openshift/api/oauth/v1"
+
+	"github.com/openshift/oauth-server/pkg/scopecovers"
+)
+
+const (
+	// ScopeApprovalsAnnotation is the annotation of client authorizations that holds when the user approved each of
+	// the scopes, as a JSON object of seconds since the epoch by scope
+	ScopeApprovalsAnnotation = "oauth.openshift.io/scope-approvals"
+	// ConsentVersionAnnotation is the annotation of client authorizations that holds the consent version of the
+	// client the user approved
+	ConsentVersionAnnotation = "oauth.openshift.io/consent-version"
+)
+
+// ConsentPolicy determines which of the scopes a user approved for a client are still approved
+type ConsentPolicy struct {
+	// MaxAge is how long approvals of scopes last, they do not expire if it is zero
+	MaxAge time.Duration
+	// Versions are the consent versions of clients by name. Approvals of other versions are not valid, so that
+	// changing the version of a client asks its users to approve again.
+	Versions map[string]string
+}
+
+// ApprovedScopes returns the scopes of the authorization that are still approved at now. Scopes without a recorded
+// approval, e.g. of authorizations that were created before approvals were recorded, were approved when the
+// authorization was created.
+func (p *ConsentPolicy) ApprovedScopes(authorization *oauth.OAuthClientAuthorization, now time.Time) []string {
+	if p == nil {
+		return authorization.Scopes
+	}
+	if authorization.Annotations[ConsentVersionAnnotation] != p.Versions[authorization.ClientName] {
+		return nil
+	}
+	if p.MaxAge <= 0 {
+		return authorization.Scopes
+	}
+	approvals := ScopeApprovals(authorization)
+	approved := []string{}
+	for _, scope := range authorization.Scopes {
+		approvedAt, ok := approvals[scope]
+		if !ok {
+			approvedAt = authorization.CreationTimestamp.Time
+		}
+		if now.Sub(approvedAt) < p.MaxAge {
+			approved = append(approved, scope)
+		}
+	}
+	return approved
+}
+
+// ExpiresAt returns when the approval of the scope of the authorization expires, and false if it does not expire
+func (p *ConsentPolicy) ExpiresAt(authorization *oauth.OAuthClientAuthorization, scope string) (time.Time, bool) {
+	if p == nil || p.MaxAge <= 0 {
+		return time.Time{}, false
+	}
+	approvedAt, ok := ScopeApprovals(authorization)[scope]
+	if !ok {
+		approvedAt = authorization.CreationTimestamp.Time
+	}
+	return approvedAt.Add(p.MaxAge), true
+}
+
+// Approve records that the user approved the scopes at now. Scopes of other consent versions are replaced, the
+// approvals of other scopes are kept.
+func (p *ConsentPolicy) Approve(authorization *oauth.OAuthClientAuthorization, scopes []string, now time.Time) {
+	version := ""
+	if p != nil {
+		version = p.Versions[authorization.ClientName]
+	}
+	approvals := ScopeApprovals(authorization)
+	if authorization.Annotations[ConsentVersionAnnotation] != version {
+		authorization.Scopes = nil
+		approvals = map[string]time.Time{}
+	}
+	authorization.Scopes = scopecovers.Add(authorization.Scopes, scopes)
+	for _, scope := range scopes {
+		approvals[scope] = now
+	}
+
+	seconds := map[string]int64{}
+	for _, scope := range authorization.Scopes {
+		if approvedAt, ok := approvals[scope]; ok {
+			seconds[scope] = approvedAt.Unix()
+		}
+	}
+	data, err := json.Marshal(seconds)
+	if err != nil {
+		// cannot happen, maps of strings to integers always marshal
+		klog.Errorf("unable to record scope approvals: %v", err)
+		return
+	}
+	if authorization.Annotations == nil {
+		authorization.Annotations = map[string]string{}
+	}
+	authorization.Annotations[ScopeApprovalsAnnotation] = string(data)
+	if len(version) > 0 {
+		authorization.Annotations[ConsentVersionAnnotation] = version
+	} else {
+		delete(authorization.Annotations, ConsentVersionAnnotation)
+	}
+}
+
+// ScopeApprovals returns when the scopes of the authorization were approved, if it is recorded
+func ScopeApprovals(authorization *oauth.OAuthClientAuthorization) map[string]time.Time {
+	approvals := map[string]time.Time{}
+	data, ok := authorization.Annotations[ScopeApprovalsAnnotation]
+	if !ok {
+		return approvals
+	}
+	seconds := map[string]int64{}
+	if err := json.Unmarshal([]byte(data), &seconds); err != nil {
+		klog.V(4).Infof("ignoring invalid scope approvals of client authorization %q: %v", authorization.Name, err)
+		return approvals
+	}
+	for scope, approvedAt := range seconds {
+		approvals[scope] = time.Unix(approvedAt, 0)
+	}
+	return approvals
+}
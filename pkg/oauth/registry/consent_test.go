@@ -0,0 +1,89 @@
+package registry
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apiserver/pkg/authentication/user"
+
+	oauth "github.com/openshift/api/oauth/v1"
+	oauthfake "github.com/openshift/client-go/oauth/clientset/versioned/fake"
+	"github.com/openshift/osin"
+
+	"github.com/openshift/oauth-server/pkg/api"
+)
+
+func TestConsentPolicy(t *testing.T) {
+	created := time.Now().Add(-48 * time.Hour).Truncate(time.Second)
+	now := created.Add(48 * time.Hour)
+	legacy := &oauth.OAuthClientAuthorization{
+		ObjectMeta: metav1.ObjectMeta{Name: "bob:console", CreationTimestamp: metav1.NewTime(created)},
+		ClientName: "console",
+		Scopes:     []string{"user:info"},
+	}
+
+	var noPolicy *ConsentPolicy
+	if scopes := noPolicy.ApprovedScopes(legacy, now); !reflect.DeepEqual(scopes, []string{"user:info"}) {
+		t.Errorf("expected all scopes to be approved without a policy, got %v", scopes)
+	}
+	if _, ok := noPolicy.ExpiresAt(legacy, "user:info"); ok {
+		t.Error("expected approvals not to expire without a policy")
+	}
+
+	policy := &ConsentPolicy{MaxAge: 24 * time.Hour, Versions: map[string]string{"console": "v1"}}
+	if scopes := policy.ApprovedScopes(legacy, now); len(scopes) != 0 {
+		t.Errorf("expected approvals without a version not to be valid, got %v", scopes)
+	}
+
+	// approving replaces the scopes approved for another version
+	authorization := legacy.DeepCopy()
+	policy.Approve(authorization, []string{"user:full"}, now.Add(-2*time.Hour))
+	if authorization.Annotations[ConsentVersionAnnotation] != "v1" || !reflect.DeepEqual(authorization.Scopes, []string{"user:full"}) {
+		t.Fatalf("unexpected approved authorization %#v", authorization)
+	}
+	policy.Approve(authorization, []string{"user:info"}, now.Add(-23*time.Hour))
+	if !reflect.DeepEqual(authorization.Scopes, []string{"user:full", "user:info"}) {
+		t.Errorf("expected scopes to be added, got %v", authorization.Scopes)
+	}
+	if scopes := policy.ApprovedScopes(authorization, now); !reflect.DeepEqual(scopes, []string{"user:full", "user:info"}) {
+		t.Errorf("expected both scopes to be approved, got %v", scopes)
+	}
+	if scopes := policy.ApprovedScopes(authorization, now.Add(2*time.Hour)); !reflect.DeepEqual(scopes, []string{"user:full"}) {
+		t.Errorf("expected the older approval to expire, got %v", scopes)
+	}
+	if expiresAt, ok := policy.ExpiresAt(authorization, "user:full"); !ok || !expiresAt.Equal(now.Add(22*time.Hour)) {
+		t.Errorf("unexpected expiry %v %v", expiresAt, ok)
+	}
+	if approvals := ScopeApprovals(authorization); len(approvals) != 2 || !approvals["user:info"].Equal(now.Add(-23*time.Hour)) {
+		t.Errorf("unexpected approvals %v", approvals)
+	}
+
+	changed := &ConsentPolicy{Versions: map[string]string{"console": "v2"}}
+	if scopes := changed.ApprovedScopes(authorization, now); len(scopes) != 0 {
+		t.Errorf("expected a new consent version to require approval, got %v", scopes)
+	}
+}
+
+func TestGrantCheckerConsent(t *testing.T) {
+	created := time.Now().Add(-48 * time.Hour)
+	authorization := &oauth.OAuthClientAuthorization{
+		ObjectMeta: metav1.ObjectMeta{Name: "bob:console", CreationTimestamp: metav1.NewTime(created)},
+		ClientName: "console",
+		UserName:   "bob",
+		UserUID:    "bob-uid",
+		Scopes:     []string{"user:info"},
+	}
+	client := oauthfake.NewSimpleClientset(authorization).OauthV1().OAuthClientAuthorizations()
+	bob := &user.DefaultInfo{Name: "bob", UID: "bob-uid"}
+	grant := &api.Grant{Client: &osin.DefaultClient{Id: "console"}, Scope: "user:info"}
+
+	if approved, err := NewClientAuthorizationGrantChecker(client).HasAuthorizedClient(bob, grant); err != nil || !approved {
+		t.Errorf("expected grant without a consent policy, got %v %v", approved, err)
+	}
+	checker := NewClientAuthorizationGrantCheckerWithConsent(client, &ConsentPolicy{MaxAge: 24 * time.Hour})
+	if approved, err := checker.HasAuthorizedClient(bob, grant); err != nil || approved {
+		t.Errorf("expected expired approval to require a grant, got %v %v", approved, err)
+	}
+}
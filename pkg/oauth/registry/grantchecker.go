@@ -3,6 +3,7 @@ package registry
 import (
 	"context"
 	stderrors "errors"
+	"time"
 
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -20,10 +21,19 @@ var errEmptyUID = stderrors.New("user from request has empty UID and thus cannot
 
 type ClientAuthorizationGrantChecker struct {
 	client oauthclient.OAuthClientAuthorizationInterface
+	// consent determines which approved scopes are still approved, all are if it is nil
+	consent *ConsentPolicy
+	now     func() time.Time
 }
 
 func NewClientAuthorizationGrantChecker(client oauthclient.OAuthClientAuthorizationInterface) *ClientAuthorizationGrantChecker {
-	return &ClientAuthorizationGrantChecker{client}
+	return NewClientAuthorizationGrantCheckerWithConsent(client, nil)
+}
+
+// NewClientAuthorizationGrantCheckerWithConsent returns a grant checker that only considers the scopes that are still
+// approved according to the consent policy
+func NewClientAuthorizationGrantCheckerWithConsent(client oauthclient.OAuthClientAuthorizationInterface, consent *ConsentPolicy) *ClientAuthorizationGrantChecker {
+	return &ClientAuthorizationGrantChecker{client: client, consent: consent, now: time.Now}
 }
 
 func (c *ClientAuthorizationGrantChecker) HasAuthorizedClient(user kuser.Info, grant *api.Grant) (approved bool, err error) {
@@ -49,7 +59,7 @@ func (c *ClientAuthorizationGrantChecker) HasAuthorizedClient(user kuser.Info, g
 	}
 
 	// TODO: improve this to allow the scope implementation to determine overlap
-	if authorization == nil || !scopecovers.Covers(authorization.Scopes, scopecovers.Split(grant.Scope)) {
+	if authorization == nil || !scopecovers.Covers(c.consent.ApprovedScopes(authorization, c.now()), scopecovers.Split(grant.Scope)) {
 		return false, nil
 	}
 
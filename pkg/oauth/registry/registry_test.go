@@ -289,6 +289,7 @@ func TestRegistryAndServer(t *testing.T) {
 			fakeOAuthClient.OauthV1().OAuthClients(),
 			fakeTokenReviewClient.AuthenticationV1().TokenReviews(),
 			0,
+			nil,
 		)
 		config := osinserver.NewDefaultServerConfig()
 
@@ -313,6 +314,12 @@ func TestRegistryAndServer(t *testing.T) {
 				handlers.NewDenyAccessAuthenticator(),
 			},
 			h,
+			nil,
+			nil,
+			nil,
+			nil,
+			nil,
+			nil,
 		)
 		mux := http.NewServeMux()
 		server.Install(mux, "")
@@ -0,0 +1,67 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kuser "k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/client-go/util/retry"
+
+	userclient "github.com/openshift/client-go/user/clientset/versioned/typed/user/v1"
+	bootstrap "github.com/openshift/library-go/pkg/authentication/bootstrapauthenticator"
+)
+
+// AcceptedTermsAnnotation is the annotation of users that holds the version of the terms of service they accepted
+const AcceptedTermsAnnotation = "oauth.openshift.io/accepted-terms-version"
+
+// UserTermsRecorder records the acceptance of the terms of service in an annotation of the user
+type UserTermsRecorder struct {
+	client userclient.UserInterface
+}
+
+func NewUserTermsRecorder(client userclient.UserInterface) *UserTermsRecorder {
+	return &UserTermsRecorder{client: client}
+}
+
+// Accepted returns true if the user accepted the given version of the terms of service
+func (r *UserTermsRecorder) Accepted(user kuser.Info, version string) (bool, error) {
+	// the bootstrap user has no user object to record the acceptance in, it is meant for
+	// administrators that set up the cluster rather than for its users
+	if user.GetName() == bootstrap.BootstrapUser {
+		return true, nil
+	}
+
+	u, err := r.client.Get(context.TODO(), user.GetName(), metav1.GetOptions{})
+	if err != nil {
+		return false, err
+	}
+	// a user that was deleted and created again has to accept the terms again
+	if string(u.UID) != user.GetUID() {
+		return false, nil
+	}
+	return u.Annotations[AcceptedTermsAnnotation] == version, nil
+}
+
+// Accept records that the user accepted the given version of the terms of service
+func (r *UserTermsRecorder) Accept(user kuser.Info, version string) error {
+	if user.GetName() == bootstrap.BootstrapUser {
+		return nil
+	}
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		u, err := r.client.Get(context.TODO(), user.GetName(), metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if string(u.UID) != user.GetUID() {
+			return fmt.Errorf("user %q was replaced since the login", user.GetName())
+		}
+		if u.Annotations == nil {
+			u.Annotations = map[string]string{}
+		}
+		u.Annotations[AcceptedTermsAnnotation] = version
+		_, err = r.client.Update(context.TODO(), u, metav1.UpdateOptions{})
+		return err
+	})
+}
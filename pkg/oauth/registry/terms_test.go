@@ -0,0 +1,45 @@
+package registry
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apiserver/pkg/authentication/user"
+
+	userv1 "github.com/openshift/api/user/v1"
+	userfake "github.com/openshift/client-go/user/clientset/versioned/fake"
+)
+
+func TestUserTermsRecorder(t *testing.T) {
+	users := userfake.NewSimpleClientset(
+		&userv1.User{ObjectMeta: metav1.ObjectMeta{Name: "bob", UID: "bob-uid"}},
+	).UserV1().Users()
+	recorder := NewUserTermsRecorder(users)
+	bob := &user.DefaultInfo{Name: "bob", UID: "bob-uid"}
+
+	if accepted, err := recorder.Accepted(bob, "v1"); err != nil || accepted {
+		t.Fatalf("expected terms not to be accepted, got %v %v", accepted, err)
+	}
+	if err := recorder.Accept(bob, "v1"); err != nil {
+		t.Fatal(err)
+	}
+	if accepted, err := recorder.Accepted(bob, "v1"); err != nil || !accepted {
+		t.Errorf("expected terms to be accepted, got %v %v", accepted, err)
+	}
+	if accepted, err := recorder.Accepted(bob, "v2"); err != nil || accepted {
+		t.Errorf("expected new version not to be accepted, got %v %v", accepted, err)
+	}
+
+	recreated := &user.DefaultInfo{Name: "bob", UID: "old-bob-uid"}
+	if accepted, err := recorder.Accepted(recreated, "v1"); err != nil || accepted {
+		t.Errorf("expected terms not to be accepted by a user with another UID, got %v %v", accepted, err)
+	}
+	if err := recorder.Accept(recreated, "v2"); err == nil {
+		t.Error("expected error recording the acceptance of a user with another UID")
+	}
+
+	bootstrapUser := &user.DefaultInfo{Name: "kube:admin"}
+	if accepted, err := recorder.Accepted(bootstrapUser, "v1"); err != nil || !accepted {
+		t.Errorf("expected the bootstrap user to be exempt, got %v %v", accepted, err)
+	}
+}
@@ -0,0 +1,116 @@
+// Package resource restricts the audience of access tokens to the resources that clients indicate in their
+// authorize and token requests (RFC 8707).
+package resource
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/openshift/osin"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apiserver/pkg/authentication/user"
+
+	"github.com/openshift/oauth-server/pkg/oauth/handlers"
+	"github.com/openshift/oauth-server/pkg/osinserver"
+)
+
+const (
+	resourceParam = "resource"
+
+	errorInvalidTarget = "invalid_target"
+)
+
+// Indicators restricts the audience of the tokens of clients to the resources they indicate. Clients may only
+// indicate the resources they are allowed, the resource parameters of other clients are ignored. It implements
+// osinserver.AuthorizeHandler, osinserver.AccessHandler and osinserver.InfoHandler.
+type Indicators struct {
+	resources map[string]sets.String
+}
+
+// NewIndicators returns resource indicators for the clients with the given allowed resources, by client ID
+func NewIndicators(resources map[string]sets.String) *Indicators {
+	return &Indicators{resources: resources}
+}
+
+// HandleAuthorize implements osinserver.AuthorizeHandler, it records the resources of authenticated requests
+// in the authorize code
+func (i *Indicators) HandleAuthorize(ar *osin.AuthorizeRequest, resp *osin.Response, w http.ResponseWriter) (bool, error) {
+	if !ar.Authorized || ar.HttpRequest == nil {
+		return false, nil
+	}
+	audiences, err := i.audiences(ar.Client, ar.HttpRequest.Form[resourceParam], nil)
+	if err != nil {
+		ar.Authorized = false
+		resp.SetErrorState(errorInvalidTarget, err.Error(), ar.State)
+		return false, nil
+	}
+	if len(audiences) > 0 {
+		ar.UserData = handlers.WithAudiences(ar.UserData.(user.Info), audiences)
+	}
+	return false, nil
+}
+
+// HandleAccess implements osinserver.AccessHandler, it restricts the audience of the token to the resources
+// of the request, which must be among the resources the token was authorized for
+func (i *Indicators) HandleAccess(ar *osin.AccessRequest, w http.ResponseWriter) error {
+	if !ar.Authorized || ar.HttpRequest == nil {
+		return nil
+	}
+	info, ok := ar.UserData.(user.Info)
+	if !ok {
+		return nil
+	}
+	var authorized []string
+	if restricted, ok := info.(handlers.Audiences); ok {
+		authorized = restricted.GetAudiences()
+	}
+	audiences, err := i.audiences(ar.Client, ar.HttpRequest.Form[resourceParam], authorized)
+	if err != nil {
+		return &osinserver.AccessError{Code: errorInvalidTarget, Description: err.Error()}
+	}
+	if len(audiences) > 0 {
+		ar.UserData = handlers.WithAudiences(info, audiences)
+	}
+	return nil
+}
+
+// HandleInfo implements osinserver.InfoHandler
+func (i *Indicators) HandleInfo(ir *osin.InfoRequest, resp *osin.Response, r *http.Request) {
+	if audiences, ok := ir.AccessData.UserData.(handlers.Audiences); ok && len(audiences.GetAudiences()) > 0 {
+		resp.Output["aud"] = audiences.GetAudiences()
+	}
+}
+
+// audiences returns the resources of a request of the client, if the client may indicate resources. If the
+// token is already restricted to audiences, the resources must be among them.
+func (i *Indicators) audiences(client osin.Client, resources, authorized []string) ([]string, error) {
+	if client == nil || len(resources) == 0 {
+		return nil, nil
+	}
+	allowed, ok := i.resources[client.GetId()]
+	if !ok {
+		return nil, nil
+	}
+	for _, resource := range resources {
+		if err := validate(resource); err != nil {
+			return nil, err
+		}
+		if !allowed.Has(resource) {
+			return nil, fmt.Errorf("the client may not request resource %q", resource)
+		}
+		if len(authorized) > 0 && !sets.NewString(authorized...).Has(resource) {
+			return nil, fmt.Errorf("resource %q was not authorized", resource)
+		}
+	}
+	return sets.NewString(resources...).List(), nil
+}
+
+// validate returns an error if the resource is not an absolute URI without fragment
+func validate(resource string) error {
+	u, err := url.Parse(resource)
+	if err != nil || !u.IsAbs() || len(u.Fragment) > 0 || len(u.RawFragment) > 0 {
+		return fmt.Errorf("resource %q must be an absolute URI without fragment", resource)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package resource
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/openshift/osin"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apiserver/pkg/authentication/user"
+
+	"github.com/openshift/oauth-server/pkg/oauth/handlers"
+	"github.com/openshift/oauth-server/pkg/osinserver"
+)
+
+const (
+	api     = "https://api.example.com"
+	billing = "https://billing.example.com/v1"
+)
+
+func newRequest(resources ...string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/oauth/authorize?"+url.Values{resourceParam: resources}.Encode(), nil)
+	req.ParseForm()
+	return req
+}
+
+func audiences(userData interface{}) []string {
+	if restricted, ok := userData.(handlers.Audiences); ok {
+		return restricted.GetAudiences()
+	}
+	return nil
+}
+
+func TestHandleAuthorize(t *testing.T) {
+	indicators := NewIndicators(map[string]sets.String{"client": sets.NewString(api, billing)})
+	bob := &user.DefaultInfo{Name: "bob"}
+
+	testCases := []struct {
+		Name      string
+		Client    string
+		Resources []string
+		Expect    []string
+		Error     bool
+	}{
+		{Name: "no resources", Client: "client"},
+		{Name: "allowed resources", Client: "client", Resources: []string{billing, api, api}, Expect: []string{api, billing}},
+		{Name: "resource not allowed", Client: "client", Resources: []string{api, "https://other.example.com"}, Error: true},
+		{Name: "relative resource", Client: "client", Resources: []string{"/api"}, Error: true},
+		{Name: "resource with fragment", Client: "client", Resources: []string{api + "#fragment"}, Error: true},
+		{Name: "client without resources", Client: "other", Resources: []string{"https://other.example.com"}},
+	}
+	for _, testCase := range testCases {
+		ar := &osin.AuthorizeRequest{
+			Client:      &osin.DefaultClient{Id: testCase.Client},
+			Authorized:  true,
+			UserData:    bob,
+			State:       "state",
+			HttpRequest: newRequest(testCase.Resources...),
+		}
+		resp := &osin.Response{Output: osin.ResponseData{}}
+		if handled, err := indicators.HandleAuthorize(ar, resp, httptest.NewRecorder()); handled || err != nil {
+			t.Errorf("%s: unexpected result %v %v", testCase.Name, handled, err)
+			continue
+		}
+		if testCase.Error {
+			if ar.Authorized || resp.ErrorId != errorInvalidTarget || resp.Output["state"] != "state" {
+				t.Errorf("%s: expected invalid_target, got %v %v", testCase.Name, ar.Authorized, resp.Output)
+			}
+			continue
+		}
+		if !ar.Authorized || resp.IsError {
+			t.Errorf("%s: unexpected error %v", testCase.Name, resp.Output)
+		}
+		if actual := audiences(ar.UserData); !reflect.DeepEqual(actual, testCase.Expect) {
+			t.Errorf("%s: expected audiences %v, got %v", testCase.Name, testCase.Expect, actual)
+		}
+	}
+}
+
+func TestHandleAccess(t *testing.T) {
+	indicators := NewIndicators(map[string]sets.String{"client": sets.NewString(api, billing)})
+	bob := &user.DefaultInfo{Name: "bob"}
+
+	testCases := []struct {
+		Name       string
+		Authorized []string
+		Resources  []string
+		Expect     []string
+		Error      bool
+	}{
+		{Name: "authorized audiences", Authorized: []string{api, billing}, Expect: []string{api, billing}},
+		{Name: "narrowed audiences", Authorized: []string{api, billing}, Resources: []string{billing}, Expect: []string{billing}},
+		{Name: "resource not authorized", Authorized: []string{api}, Resources: []string{billing}, Error: true},
+		{Name: "resource without authorized audiences", Resources: []string{billing}, Expect: []string{billing}},
+		{Name: "resource not allowed", Resources: []string{"https://other.example.com"}, Error: true},
+	}
+	for _, testCase := range testCases {
+		ar := &osin.AccessRequest{
+			Client:      &osin.DefaultClient{Id: "client"},
+			Authorized:  true,
+			UserData:    handlers.WithAudiences(bob, testCase.Authorized),
+			HttpRequest: newRequest(testCase.Resources...),
+		}
+		err := indicators.HandleAccess(ar, httptest.NewRecorder())
+		if testCase.Error {
+			if accessErr, ok := err.(*osinserver.AccessError); !ok || accessErr.Code != errorInvalidTarget {
+				t.Errorf("%s: expected invalid_target, got %v", testCase.Name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", testCase.Name, err)
+		}
+		if actual := audiences(ar.UserData); !reflect.DeepEqual(actual, testCase.Expect) {
+			t.Errorf("%s: expected audiences %v, got %v", testCase.Name, testCase.Expect, actual)
+		}
+	}
+
+	resp := &osin.Response{Output: osin.ResponseData{}}
+	indicators.HandleInfo(&osin.InfoRequest{AccessData: &osin.AccessData{UserData: handlers.WithAudiences(bob, []string{api})}}, resp, nil)
+	if aud, _ := resp.Output["aud"].([]string); !reflect.DeepEqual(aud, []string{api}) {
+		t.Errorf("expected audience in info, got %v", resp.Output)
+	}
+}
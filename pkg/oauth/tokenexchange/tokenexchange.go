@@ -0,0 +1,191 @@
+// Package tokenexchange lets trusted clients exchange the access tokens of users for tokens with fewer scopes,
+// restricted audiences, or for another user to act on behalf of the user (RFC 8693).
+package tokenexchange
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/openshift/osin"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/klog/v2"
+
+	oauthapi "github.com/openshift/api/oauth/v1"
+	scopemetadata "github.com/openshift/library-go/pkg/authorization/scopemetadata"
+
+	"github.com/openshift/oauth-server/pkg/oauth/handlers"
+	"github.com/openshift/oauth-server/pkg/osinserver"
+	"github.com/openshift/oauth-server/pkg/scopecovers"
+)
+
+const (
+	subjectTokenTypeParam   = "subject_token_type"
+	actorTokenParam         = "actor_token"
+	actorTokenTypeParam     = "actor_token_type"
+	requestedTokenTypeParam = "requested_token_type"
+	audienceParam           = "audience"
+
+	// AnyUser allows the tokens of all users to be exchanged
+	AnyUser = "*"
+
+	errorInvalidTarget = "invalid_target"
+)
+
+// Policy determines which tokens a client may exchange
+type Policy struct {
+	// Subjects are the names of the users whose access tokens the client may exchange
+	Subjects sets.String
+	// Actors are the names of the users that may act for the subjects, without actors tokens are only
+	// exchanged without actor tokens
+	Actors sets.String
+	// Audiences are the audiences the client may restrict exchanged tokens to
+	Audiences sets.String
+}
+
+// Exchanger handles token exchange requests and describes the actors of exchanged tokens.
+// It implements osinserver.AccessHandler and osinserver.InfoHandler.
+type Exchanger struct {
+	storage  osin.Storage
+	policies map[string]Policy
+	now      func() time.Time
+}
+
+// NewExchanger returns an exchanger of the access tokens in the storage for the clients with policies, by client ID
+func NewExchanger(storage osin.Storage, policies map[string]Policy) *Exchanger {
+	return &Exchanger{storage: storage, policies: policies, now: time.Now}
+}
+
+// HandleAccess implements osinserver.AccessHandler
+func (e *Exchanger) HandleAccess(ar *osin.AccessRequest, w http.ResponseWriter) error {
+	if ar.Type != osin.ASSERTION || ar.AssertionType != osinserver.TokenExchangeGrantType || ar.HttpRequest == nil {
+		return nil
+	}
+	policy, ok := e.policies[ar.Client.GetId()]
+	if !ok {
+		return &osinserver.AccessError{Code: osin.E_UNAUTHORIZED_CLIENT, Description: "the client may not exchange tokens"}
+	}
+	r := ar.HttpRequest
+	if tokenType := r.Form.Get(subjectTokenTypeParam); tokenType != osinserver.AccessTokenType {
+		return &osinserver.AccessError{Code: osin.E_INVALID_REQUEST, Description: fmt.Sprintf("unsupported subject token type %q", tokenType)}
+	}
+	if tokenType := r.Form.Get(requestedTokenTypeParam); len(tokenType) > 0 && tokenType != osinserver.AccessTokenType {
+		return &osinserver.AccessError{Code: osin.E_INVALID_REQUEST, Description: fmt.Sprintf("unsupported requested token type %q", tokenType)}
+	}
+
+	subject, subjectUser, err := e.load(ar.Assertion)
+	if err != nil {
+		klog.V(4).Infof("invalid subject token for token exchange of client %q: %v", ar.Client.GetId(), err)
+		return &osinserver.AccessError{Code: osin.E_INVALID_GRANT, Description: "the subject token is invalid"}
+	}
+	if !policy.Subjects.Has(AnyUser) && !policy.Subjects.Has(subjectUser.GetName()) {
+		return &osinserver.AccessError{Code: osin.E_INVALID_GRANT, Description: "the client may not exchange tokens of the subject"}
+	}
+
+	actor := ""
+	if actorToken := r.Form.Get(actorTokenParam); len(actorToken) > 0 {
+		if tokenType := r.Form.Get(actorTokenTypeParam); tokenType != osinserver.AccessTokenType {
+			return &osinserver.AccessError{Code: osin.E_INVALID_REQUEST, Description: fmt.Sprintf("unsupported actor token type %q", tokenType)}
+		}
+		_, actorUser, err := e.load(actorToken)
+		if err != nil {
+			klog.V(4).Infof("invalid actor token for token exchange of client %q: %v", ar.Client.GetId(), err)
+			return &osinserver.AccessError{Code: osin.E_INVALID_GRANT, Description: "the actor token is invalid"}
+		}
+		if !policy.Actors.Has(AnyUser) && !policy.Actors.Has(actorUser.GetName()) {
+			return &osinserver.AccessError{Code: osin.E_INVALID_GRANT, Description: "the actor may not act for the subject"}
+		}
+		actor = actorUser.GetName()
+	}
+
+	// exchanged tokens never have more scopes than the subject token
+	subjectScopes := scopecovers.Split(subject.Scope)
+	scopes := scopecovers.Split(ar.Scope)
+	if len(scopes) == 0 {
+		scopes = subjectScopes
+	} else if !scopecovers.Covers(subjectScopes, scopes) {
+		return &osinserver.AccessError{Code: osin.E_INVALID_SCOPE, Description: "the requested scopes exceed the scopes of the subject token"}
+	}
+	if client, ok := ar.Client.GetUserData().(*oauthapi.OAuthClient); ok {
+		if err := scopemetadata.ValidateScopeRestrictions(client, scopes...); err != nil {
+			return &osinserver.AccessError{Code: osin.E_INVALID_SCOPE, Description: err.Error()}
+		}
+	}
+	// exchanged tokens are never valid for more audiences than the subject token, without requested audiences they
+	// keep the audiences of the subject token
+	var subjectAudiences sets.String
+	if restricted, ok := subjectUser.(handlers.Audiences); ok && len(restricted.GetAudiences()) > 0 {
+		subjectAudiences = sets.NewString(restricted.GetAudiences()...)
+	}
+	audiences := r.Form[audienceParam]
+	for _, audience := range audiences {
+		if !policy.Audiences.Has(audience) {
+			return &osinserver.AccessError{Code: errorInvalidTarget, Description: fmt.Sprintf("the client may not request audience %q", audience)}
+		}
+		if subjectAudiences != nil && !subjectAudiences.Has(audience) {
+			return &osinserver.AccessError{Code: errorInvalidTarget, Description: fmt.Sprintf("the subject token is not valid for audience %q", audience)}
+		}
+	}
+
+	ar.Scope = scopecovers.Join(scopes)
+	// an actor of the subject token is kept, the new actor acts for it in turn
+	ar.UserData = handlers.WithAudiences(handlers.WithActor(subjectUser, actor), audiences)
+	ar.Authorized = true
+	ar.GenerateRefresh = false
+
+	// exchanged tokens expire with the subject token
+	if client, ok := ar.Client.(handlers.TokenMaxAgeSeconds); ok {
+		if maxAge := client.GetTokenMaxAgeSeconds(); maxAge != nil && *maxAge < ar.Expiration {
+			ar.Expiration = *maxAge
+		}
+	}
+	if remaining := int32(subject.ExpireAt().Sub(e.now()) / time.Second); remaining < ar.Expiration {
+		ar.Expiration = remaining
+	}
+	return nil
+}
+
+// HandleInfo implements osinserver.InfoHandler. The prior actors of tokens that were delegated more than once are
+// nested in the act claim of the actor, as in RFC 8693 section 4.1.
+func (e *Exchanger) HandleInfo(ir *osin.InfoRequest, resp *osin.Response, r *http.Request) {
+	actor, ok := ir.AccessData.UserData.(handlers.Actor)
+	if !ok || len(actor.GetActor()) == 0 {
+		return
+	}
+	act := map[string]interface{}{"sub": actor.GetActor()}
+	resp.Output["act"] = act
+	if priorActors, ok := ir.AccessData.UserData.(handlers.PriorActors); ok {
+		for _, priorActor := range priorActors.GetPriorActors() {
+			prior := map[string]interface{}{"sub": priorActor}
+			act["act"] = prior
+			act = prior
+		}
+	}
+}
+
+// load returns the data and user of a valid access token. Tokens bound to keys are never exchanged, that would
+// let clients use them without the keys.
+func (e *Exchanger) load(token string) (*osin.AccessData, user.Info, error) {
+	data, err := e.storage.LoadAccess(token)
+	if err != nil {
+		return nil, nil, err
+	}
+	if data == nil {
+		return nil, nil, fmt.Errorf("token not found")
+	}
+	if data.IsExpiredAt(e.now()) {
+		return nil, nil, fmt.Errorf("token expired")
+	}
+	if certificate, ok := data.UserData.(handlers.CertificateThumbprint); ok && len(certificate.GetCertificateThumbprint()) > 0 {
+		return nil, nil, fmt.Errorf("token is bound to a certificate")
+	}
+	if proofKey, ok := data.UserData.(handlers.ProofKeyThumbprint); ok && len(proofKey.GetProofKeyThumbprint()) > 0 {
+		return nil, nil, fmt.Errorf("token is bound to a DPoP key")
+	}
+	info, ok := data.UserData.(user.Info)
+	if !ok {
+		return nil, nil, fmt.Errorf("did not receive user.Info: %#v", data.UserData) // should be impossible
+	}
+	return data, info, nil
+}
@@ -0,0 +1,165 @@
+package tokenexchange
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openshift/osin"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apiserver/pkg/authentication/user"
+
+	"github.com/openshift/oauth-server/pkg/oauth/handlers"
+	"github.com/openshift/oauth-server/pkg/osinserver"
+	"github.com/openshift/oauth-server/pkg/osinserver/teststorage"
+)
+
+const redirectURI = "https://client.example.com/callback"
+
+func TestTokenExchange(t *testing.T) {
+	now := time.Now()
+	bob := &user.DefaultInfo{Name: "bob", UID: "bob-uid"}
+	alice := &user.DefaultInfo{Name: "alice", UID: "alice-uid"}
+	carol := &user.DefaultInfo{Name: "carol", UID: "carol-uid"}
+
+	storage := teststorage.New()
+	for _, id := range []string{"exchanger", "other"} {
+		storage.Clients[id] = &osin.DefaultClient{Id: id, Secret: "secret", RedirectUri: redirectURI}
+	}
+	for token, userData := range map[string]user.Info{
+		"bob":   bob,
+		"alice": alice,
+		"carol": carol,
+		"bound": handlers.WithProofKeyThumbprint(bob, "jkt"),
+		// delegated to carol, for the API only
+		"delegated": handlers.WithAudiences(handlers.WithActor(bob, "carol"), []string{"https://api.example.com"}),
+	} {
+		storage.Access[token] = &osin.AccessData{Client: storage.Clients["other"], AccessToken: token, Scope: "user:info user:check-access", CreatedAt: now, ExpiresIn: 600, UserData: userData}
+	}
+	storage.Access["expired"] = &osin.AccessData{Client: storage.Clients["other"], AccessToken: "expired", Scope: "user:info", CreatedAt: now.Add(-time.Hour), ExpiresIn: 600, UserData: bob}
+
+	exchanger := NewExchanger(storage, map[string]Policy{
+		"exchanger": {
+			Subjects:  sets.NewString("bob"),
+			Actors:    sets.NewString("alice"),
+			Audiences: sets.NewString("https://api.example.com", "https://billing.example.com"),
+		},
+	})
+	server := osinserver.New(
+		osinserver.NewDefaultServerConfig(),
+		storage,
+		osinserver.AuthorizeHandlerFunc(func(ar *osin.AuthorizeRequest, resp *osin.Response, w http.ResponseWriter) (bool, error) {
+			return false, nil
+		}),
+		osinserver.AccessHandlers{
+			handlers.NewDenyAccessAuthenticator(),
+			exchanger,
+		},
+		osinserver.NewDefaultErrorHandler(),
+		nil,
+		nil,
+		osinserver.InfoHandlers{exchanger},
+		nil,
+		nil,
+		nil,
+	)
+	mux := http.NewServeMux()
+	server.Install(mux, "/oauth")
+
+	do := func(req *http.Request) (int, map[string]interface{}) {
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		body := map[string]interface{}{}
+		if err := json.Unmarshal(resp.Body.Bytes(), &body); err != nil {
+			t.Fatalf("unexpected response %d %s: %v", resp.Code, resp.Body.String(), err)
+		}
+		return resp.Code, body
+	}
+	exchange := func(clientID string, params url.Values) (int, map[string]interface{}) {
+		params.Set("grant_type", osinserver.TokenExchangeGrantType)
+		params.Set("client_id", clientID)
+		params.Set("client_secret", "secret")
+		if _, ok := params[subjectTokenTypeParam]; !ok {
+			params.Set(subjectTokenTypeParam, osinserver.AccessTokenType)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/oauth/token", strings.NewReader(params.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return do(req)
+	}
+	info := func(token string) (int, map[string]interface{}) {
+		req := httptest.NewRequest(http.MethodGet, "/oauth/info", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		return do(req)
+	}
+
+	code, body := exchange("exchanger", url.Values{"subject_token": {"bob"}, "scope": {"user:info"}, "audience": {"https://api.example.com"}})
+	if code != http.StatusOK || body["issued_token_type"] != osinserver.AccessTokenType || body["scope"] != "user:info" {
+		t.Fatalf("expected exchanged token, got %d %v", code, body)
+	}
+	if expiresIn, _ := body["expires_in"].(float64); expiresIn > 600 {
+		t.Errorf("expected exchanged token to expire with the subject token, got %v", expiresIn)
+	}
+	token, _ := body["access_token"].(string)
+	code, body = info(token)
+	if code != http.StatusOK || body["act"] != nil {
+		t.Errorf("expected info without actor, got %d %v", code, body)
+	}
+	if name := storage.Access[token].UserData.(user.Info).GetName(); name != "bob" {
+		t.Errorf("expected token of bob, got %q", name)
+	}
+	if audiences := storage.Access[token].UserData.(handlers.Audiences).GetAudiences(); len(audiences) != 1 || audiences[0] != "https://api.example.com" {
+		t.Errorf("expected token restricted to the audience, got %v", audiences)
+	}
+
+	code, body = exchange("exchanger", url.Values{"subject_token": {"bob"}, "actor_token": {"alice"}, "actor_token_type": {osinserver.AccessTokenType}})
+	if code != http.StatusOK || body["scope"] != "user:info user:check-access" {
+		t.Fatalf("expected delegated token, got %d %v", code, body)
+	}
+	token, _ = body["access_token"].(string)
+	code, body = info(token)
+	if act, _ := body["act"].(map[string]interface{}); code != http.StatusOK || act["sub"] != "alice" {
+		t.Errorf("expected info with actor, got %d %v", code, body)
+	}
+
+	// the audiences and actor of a delegated subject token are kept
+	code, body = exchange("exchanger", url.Values{"subject_token": {"delegated"}, "actor_token": {"alice"}, "actor_token_type": {osinserver.AccessTokenType}})
+	if code != http.StatusOK {
+		t.Fatalf("expected token delegated again, got %d %v", code, body)
+	}
+	token, _ = body["access_token"].(string)
+	if audiences := storage.Access[token].UserData.(handlers.Audiences).GetAudiences(); len(audiences) != 1 || audiences[0] != "https://api.example.com" {
+		t.Errorf("expected token restricted to the audience of the subject token, got %v", audiences)
+	}
+	code, body = info(token)
+	act, _ := body["act"].(map[string]interface{})
+	priorAct, _ := act["act"].(map[string]interface{})
+	if code != http.StatusOK || act["sub"] != "alice" || priorAct["sub"] != "carol" {
+		t.Errorf("expected info with alice acting for carol, got %d %v", code, body)
+	}
+
+	for name, testCase := range map[string]struct {
+		Client string
+		Params url.Values
+		Error  string
+	}{
+		"client without policy":    {Client: "other", Params: url.Values{"subject_token": {"bob"}}, Error: osin.E_UNAUTHORIZED_CLIENT},
+		"unsupported token type":   {Client: "exchanger", Params: url.Values{"subject_token": {"bob"}, subjectTokenTypeParam: {"urn:ietf:params:oauth:token-type:id_token"}}, Error: osin.E_INVALID_REQUEST},
+		"unsupported issued type":  {Client: "exchanger", Params: url.Values{"subject_token": {"bob"}, requestedTokenTypeParam: {"urn:ietf:params:oauth:token-type:refresh_token"}}, Error: osin.E_INVALID_REQUEST},
+		"unknown subject token":    {Client: "exchanger", Params: url.Values{"subject_token": {"unknown"}}, Error: osin.E_INVALID_GRANT},
+		"expired subject token":    {Client: "exchanger", Params: url.Values{"subject_token": {"expired"}}, Error: osin.E_INVALID_GRANT},
+		"bound subject token":      {Client: "exchanger", Params: url.Values{"subject_token": {"bound"}}, Error: osin.E_INVALID_GRANT},
+		"subject not allowed":      {Client: "exchanger", Params: url.Values{"subject_token": {"carol"}}, Error: osin.E_INVALID_GRANT},
+		"actor not allowed":        {Client: "exchanger", Params: url.Values{"subject_token": {"bob"}, "actor_token": {"carol"}, "actor_token_type": {osinserver.AccessTokenType}}, Error: osin.E_INVALID_GRANT},
+		"more scopes than subject": {Client: "exchanger", Params: url.Values{"subject_token": {"bob"}, "scope": {"user:full"}}, Error: osin.E_INVALID_SCOPE},
+		"audience not allowed":     {Client: "exchanger", Params: url.Values{"subject_token": {"bob"}, "audience": {"https://other.example.com"}}, Error: errorInvalidTarget},
+		"audience of no subject":   {Client: "exchanger", Params: url.Values{"subject_token": {"delegated"}, "audience": {"https://billing.example.com"}}, Error: errorInvalidTarget},
+	} {
+		if code, body := exchange(testCase.Client, testCase.Params); code != http.StatusBadRequest || body["error"] != testCase.Error {
+			t.Errorf("%s: expected %s, got %d %v", name, testCase.Error, code, body)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+// Package tokenformat determines how the access and authorize tokens of the server look to clients and
+// how they are named at rest, so that the format can be changed while tokens of earlier formats remain valid.
+package tokenformat
+
+import (
+	"strings"
+
+	"github.com/openshift/oauth-server/pkg/server/crypto"
+)
+
+// separator ends the prefix of a token. Random tokens never contain it.
+const separator = "~"
+
+// Format of tokens. Prefixed tokens are stored by the sha256~ digest of their random part, so that the
+// stored objects cannot be used as tokens. Legacy tokens have no prefix and are stored in plain text.
+type Format struct {
+	// Prefix identifies the tokens of the format, it must end with ~. Defaults to sha256~.
+	Prefix string
+	// Legacy tokens have no prefix and are stored as they are
+	Legacy bool
+}
+
+var (
+	// SHA256 is the format of tokens that the API server authenticates without a lookup by plain text
+	SHA256 = Format{Prefix: crypto.SHA256Prefix}
+	// Legacy is the format of tokens issued by earlier releases
+	Legacy = Format{Legacy: true}
+
+	// Default issues SHA256 tokens and still accepts legacy tokens
+	Default = Formats{SHA256, Legacy}
+)
+
+func (f Format) prefix() string {
+	switch {
+	case f.Legacy:
+		return ""
+	case len(f.Prefix) == 0:
+		return crypto.SHA256Prefix
+	default:
+		return f.Prefix
+	}
+}
+
+// Generate returns a new random token of the format
+func (f Format) Generate() string {
+	return f.prefix() + RandomToken()
+}
+
+// Matches returns whether the token has the format
+func (f Format) Matches(token string) bool {
+	if f.Legacy {
+		// tokens with a prefix are never looked up in plain text, otherwise the name of a stored digest would be a token
+		return !strings.Contains(token, separator)
+	}
+	prefix := f.prefix()
+	return strings.HasPrefix(token, prefix) && !strings.Contains(token[len(prefix):], separator)
+}
+
+// Formats accepts tokens of all of its formats and issues tokens of the first one
+type Formats []Format
+
+// Generate returns a new random token of the first format
+func (f Formats) Generate() string {
+	if len(f) == 0 {
+		return SHA256.Generate()
+	}
+	return f[0].Generate()
+}
+
+// Accepts returns whether the token has one of the formats
+func (f Formats) Accepts(token string) bool {
+	for _, format := range f {
+		if format.Matches(token) {
+			return true
+		}
+	}
+	return false
+}
+
+// ObjectName returns the name of the object that stores the token. Prefixed tokens are stored by the sha256~
+// digest of their random part whatever their prefix, so names do not depend on the configured formats.
+func ObjectName(token string) string {
+	i := strings.LastIndex(token, separator)
+	if i < 0 {
+		return token
+	}
+	return crypto.SHA256Token(token[i+1:])
+}
+
+// RandomToken returns an unprefixed random token
+func RandomToken() string {
+	for {
+		// guaranteed to have no / characters and no trailing ='s
+		token := crypto.Random256BitsString()
+
+		// Don't generate tokens with leading dashes... they're hard to use on the command line
+		if strings.HasPrefix(token, "-") {
+			continue
+		}
+
+		return token
+	}
+}
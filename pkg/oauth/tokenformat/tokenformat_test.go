@@ -0,0 +1,75 @@
+package tokenformat
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/openshift/oauth-server/pkg/server/crypto"
+)
+
+func TestFormats(t *testing.T) {
+	custom := Format{Prefix: "acme_oat~"}
+	testCases := map[string]struct {
+		Formats  Formats
+		Prefix   string
+		Accepted []string
+		Rejected []string
+	}{
+		"default": {
+			Formats:  Default,
+			Prefix:   "sha256~",
+			Accepted: []string{"sha256~random", "random"},
+			Rejected: []string{"acme_oat~random", "sha256~sha256~random"},
+		},
+		"custom prefix": {
+			Formats:  Formats{custom, SHA256},
+			Prefix:   "acme_oat~",
+			Accepted: []string{"acme_oat~random", "sha256~random"},
+			Rejected: []string{"random", "other~random"},
+		},
+		"legacy": {
+			Formats:  Formats{Legacy, SHA256},
+			Accepted: []string{"random", "sha256~random"},
+			Rejected: []string{"acme_oat~random"},
+		},
+		"empty": {
+			Prefix: "sha256~",
+		},
+	}
+	for name, testCase := range testCases {
+		token := testCase.Formats.Generate()
+		if !strings.HasPrefix(token, testCase.Prefix) || strings.Contains(strings.TrimPrefix(token, testCase.Prefix), separator) {
+			t.Errorf("%s: expected token with prefix %q, got %q", name, testCase.Prefix, token)
+		}
+		if len(testCase.Formats) > 0 && !testCase.Formats.Accepts(token) {
+			t.Errorf("%s: expected generated token %q to be accepted", name, token)
+		}
+		for _, accepted := range testCase.Accepted {
+			if !testCase.Formats.Accepts(accepted) {
+				t.Errorf("%s: expected %q to be accepted", name, accepted)
+			}
+		}
+		for _, rejected := range testCase.Rejected {
+			if testCase.Formats.Accepts(rejected) {
+				t.Errorf("%s: expected %q to be rejected", name, rejected)
+			}
+		}
+	}
+}
+
+func TestObjectName(t *testing.T) {
+	digest := crypto.SHA256Token("random")
+	for token, expected := range map[string]string{
+		"random":          "random",
+		"sha256~random":   digest,
+		"acme_oat~random": digest,
+	} {
+		if name := ObjectName(token); name != expected {
+			t.Errorf("expected object name %q of %q, got %q", expected, token, name)
+		}
+	}
+	// the name of a stored digest is not a token
+	if ObjectName(digest) == digest {
+		t.Errorf("expected the digest %q to not name itself", digest)
+	}
+}
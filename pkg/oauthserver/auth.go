@@ -1,18 +1,23 @@
 package oauthserver
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"path"
+	"strings"
+	"time"
 
 	"github.com/RangelReale/osincli"
 	"github.com/openshift/osin"
+	"gopkg.in/square/go-jose.v2"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	knet "k8s.io/apimachinery/pkg/util/net"
@@ -21,6 +26,7 @@ import (
 	"k8s.io/apiserver/pkg/authentication/request/union"
 	x509request "k8s.io/apiserver/pkg/authentication/request/x509"
 	kuser "k8s.io/apiserver/pkg/authentication/user"
+	genericapiserver "k8s.io/apiserver/pkg/server"
 	"k8s.io/apiserver/pkg/server/dynamiccertificates"
 	ktransport "k8s.io/client-go/transport"
 	"k8s.io/client-go/util/cert"
@@ -29,12 +35,10 @@ import (
 	oauthapi "github.com/openshift/api/oauth/v1"
 	osinv1 "github.com/openshift/api/osin/v1"
 	oauthclient "github.com/openshift/client-go/oauth/clientset/versioned/typed/oauth/v1"
-	userclient "github.com/openshift/client-go/user/clientset/versioned/typed/user/v1"
-	userinformer "github.com/openshift/client-go/user/informers/externalversions/user/v1"
-	userlisterv1 "github.com/openshift/client-go/user/listers/user/v1"
 	bootstrap "github.com/openshift/library-go/pkg/authentication/bootstrapauthenticator"
 	"github.com/openshift/library-go/pkg/oauth/oauthdiscovery"
 	"github.com/openshift/library-go/pkg/oauth/oauthserviceaccountclient"
+	"github.com/openshift/library-go/pkg/oauth/usercache"
 	"github.com/openshift/library-go/pkg/security/ldapclient"
 	"github.com/openshift/library-go/pkg/security/ldaputil"
 
@@ -44,8 +48,10 @@ import (
 	openshiftauthenticator "github.com/openshift/oauth-server/pkg/authenticator"
 	"github.com/openshift/oauth-server/pkg/authenticator/challenger/passwordchallenger"
 	"github.com/openshift/oauth-server/pkg/authenticator/challenger/placeholderchallenger"
+	"github.com/openshift/oauth-server/pkg/authenticator/challenger/schemechallenger"
 	"github.com/openshift/oauth-server/pkg/authenticator/password/allowanypassword"
 	"github.com/openshift/oauth-server/pkg/authenticator/password/basicauthpassword"
+	"github.com/openshift/oauth-server/pkg/authenticator/password/cachedpassword"
 	"github.com/openshift/oauth-server/pkg/authenticator/password/denypassword"
 	"github.com/openshift/oauth-server/pkg/authenticator/password/htpasswd"
 	"github.com/openshift/oauth-server/pkg/authenticator/password/keystonepassword"
@@ -54,49 +60,117 @@ import (
 	"github.com/openshift/oauth-server/pkg/authenticator/request/basicauthrequest"
 	"github.com/openshift/oauth-server/pkg/authenticator/request/headerrequest"
 	"github.com/openshift/oauth-server/pkg/config"
+	"github.com/openshift/oauth-server/pkg/credentialsversion"
+	"github.com/openshift/oauth-server/pkg/deprovisioning"
 	"github.com/openshift/oauth-server/pkg/groupmapper"
+	"github.com/openshift/oauth-server/pkg/identityauthorization"
+	"github.com/openshift/oauth-server/pkg/identityconflict"
+	"github.com/openshift/oauth-server/pkg/identitytransform"
+	"github.com/openshift/oauth-server/pkg/idphealth"
+	"github.com/openshift/oauth-server/pkg/logging"
+	"github.com/openshift/oauth-server/pkg/loginanomaly"
+	"github.com/openshift/oauth-server/pkg/oauth/acr"
+	"github.com/openshift/oauth-server/pkg/oauth/clientpolicy"
+	"github.com/openshift/oauth-server/pkg/oauth/declaredclient"
+	"github.com/openshift/oauth-server/pkg/oauth/device"
+	"github.com/openshift/oauth-server/pkg/oauth/dpop"
 	"github.com/openshift/oauth-server/pkg/oauth/external"
+	"github.com/openshift/oauth-server/pkg/oauth/external/auth0"
+	"github.com/openshift/oauth-server/pkg/oauth/external/cas"
+	"github.com/openshift/oauth-server/pkg/oauth/external/cognito"
 	"github.com/openshift/oauth-server/pkg/oauth/external/github"
 	"github.com/openshift/oauth-server/pkg/oauth/external/gitlab"
 	"github.com/openshift/oauth-server/pkg/oauth/external/google"
+	"github.com/openshift/oauth-server/pkg/oauth/external/keycloak"
 	"github.com/openshift/oauth-server/pkg/oauth/external/openid"
+	"github.com/openshift/oauth-server/pkg/oauth/external/social"
 	"github.com/openshift/oauth-server/pkg/oauth/handlers"
+	"github.com/openshift/oauth-server/pkg/oauth/jar"
+	"github.com/openshift/oauth-server/pkg/oauth/mtls"
+	"github.com/openshift/oauth-server/pkg/oauth/pkce"
+	"github.com/openshift/oauth-server/pkg/oauth/redirecturi"
 	"github.com/openshift/oauth-server/pkg/oauth/registry"
+	"github.com/openshift/oauth-server/pkg/oauth/resource"
+	"github.com/openshift/oauth-server/pkg/oauth/tokenexchange"
+	"github.com/openshift/oauth-server/pkg/oauth/tokenformat"
 	"github.com/openshift/oauth-server/pkg/osinserver"
 	"github.com/openshift/oauth-server/pkg/osinserver/registrystorage"
+	"github.com/openshift/oauth-server/pkg/scim"
+	"github.com/openshift/oauth-server/pkg/server/captcha"
+	"github.com/openshift/oauth-server/pkg/server/cors"
 	"github.com/openshift/oauth-server/pkg/server/csrf"
 	"github.com/openshift/oauth-server/pkg/server/errorpage"
 	"github.com/openshift/oauth-server/pkg/server/grant"
+	"github.com/openshift/oauth-server/pkg/server/journey"
 	"github.com/openshift/oauth-server/pkg/server/login"
 	"github.com/openshift/oauth-server/pkg/server/logout"
+	"github.com/openshift/oauth-server/pkg/server/redirect"
 	"github.com/openshift/oauth-server/pkg/server/selectprovider"
+	"github.com/openshift/oauth-server/pkg/server/selfservice"
+	"github.com/openshift/oauth-server/pkg/server/terms"
+	"github.com/openshift/oauth-server/pkg/server/theme"
 	"github.com/openshift/oauth-server/pkg/server/tokenrequest"
+	"github.com/openshift/oauth-server/pkg/server/tokenreview"
+	"github.com/openshift/oauth-server/pkg/topology"
+	"github.com/openshift/oauth-server/pkg/upstreamtoken"
+	"github.com/openshift/oauth-server/pkg/userextra"
 	"github.com/openshift/oauth-server/pkg/userregistry/identitymapper"
 )
 
 const (
-	openShiftLoginPrefix         = "/login"
-	openShiftLogoutPrefix        = "/logout"
-	openShiftApproveSubpath      = "approve"
-	openShiftOAuthCallbackPrefix = "/oauth2callback"
-	openShiftBrowserClientID     = "openshift-browser-client"
+	openShiftLoginPrefix              = "/login"
+	openShiftLogoutPrefix             = "/logout"
+	openShiftBackChannelLogoutPrefix  = "/logout/backchannel"
+	openShiftCredentialsChangedPrefix = "/oauth/credentials-changed"
+	openShiftSCIMPrefix               = "/scim/v2"
+	openShiftApproveSubpath           = "approve"
+	openShiftTermsSubpath             = "terms"
+	openShiftProvidersSubpath         = "providers"
+	openShiftOAuthCallbackPrefix      = "/oauth2callback"
+	openShiftSelfServicePrefix        = "/oauth/self"
+	openShiftStaticPrefix             = "/static"
+	openShiftProofOfWorkScriptPath    = "/oauth/login/proof-of-work.js"
+	openShiftJWKSPath                 = "/oauth/jwks"
+	openShiftBrowserClientID          = "openshift-browser-client"
+	authTopologyPath                  = "/debug/auth-topology"
+	identityProviderHealthPath        = "/debug/identity-providers"
+	logVerbosityPath                  = "/debug/logging"
+	identityConflictsPath             = "/debug/identity-conflicts"
+	forceLogoutPath                   = "/debug/force-logout"
+	identityProviderDiagnosisPath     = "/debug/identity-provider-diagnosis"
+	maintenancePath                   = "/debug/maintenance"
+	upstreamTokensPath                = "/upstream-tokens"
+)
+
+const (
+	// defaultIdentityProviderMaxIdleConnsPerHost and defaultIdentityProviderTLSSessionCacheSize size the shared
+	// transports of identity providers unless their connections are configured
+	defaultIdentityProviderMaxIdleConnsPerHost = 100
+	defaultIdentityProviderTLSSessionCacheSize = 64
 )
 
 // WithOAuth decorates the given handler by serving the OAuth2 endpoints while
 // passing through all other requests to the given handler.
 func (c *OAuthServerConfig) WithOAuth(handler http.Handler) (http.Handler, error) {
-	mux := http.NewServeMux()
+	serveMux := http.NewServeMux()
 
 	// pass through all other requests
-	mux.Handle("/", handler)
-
-	combinedOAuthClientGetter := oauthserviceaccountclient.NewServiceAccountOAuthClientGetter(
-		c.ExtraOAuthConfig.KubeClient.CoreV1(),
-		c.ExtraOAuthConfig.KubeClient.CoreV1(),
-		c.ExtraOAuthConfig.EventsClient,
-		c.ExtraOAuthConfig.RouteClient,
-		c.ExtraOAuthConfig.OAuthClientClient,
-		oauthapi.GrantHandlerType(c.ExtraOAuthConfig.Options.GrantConfig.ServiceAccountMethod),
+	serveMux.Handle("/", handler)
+
+	// record everything that is installed for the authentication topology
+	authTopology := c.ExtraOAuthConfig.getTopology()
+	mux := authTopology.Mux(serveMux)
+
+	combinedOAuthClientGetter := clientpolicy.NewOAuthClientGetter(
+		oauthserviceaccountclient.NewServiceAccountOAuthClientGetter(
+			c.ExtraOAuthConfig.KubeClient.CoreV1(),
+			c.ExtraOAuthConfig.KubeClient.CoreV1(),
+			c.ExtraOAuthConfig.EventsClient,
+			c.ExtraOAuthConfig.RouteClient,
+			c.ExtraOAuthConfig.OAuthClientClient,
+			oauthapi.GrantHandlerType(c.ExtraOAuthConfig.Options.GrantConfig.ServiceAccountMethod),
+		),
+		c.getClientPolicies(),
 	)
 
 	errorPageHandler, err := c.getErrorHandler()
@@ -113,13 +187,32 @@ func (c *OAuthServerConfig) WithOAuth(handler http.Handler) (http.Handler, error
 	if timeout := c.ExtraOAuthConfig.Options.TokenConfig.AccessTokenInactivityTimeout; timeout != nil {
 		tokentimeout = int32(timeout.Seconds())
 	}
+	tokenFormats := c.getTokenFormats()
 	storage := registrystorage.New(
 		c.ExtraOAuthConfig.OAuthAccessTokenClient,
 		c.ExtraOAuthConfig.OAuthAuthorizeTokenClient,
 		combinedOAuthClientGetter,
 		c.ExtraOAuthConfig.TokenReviewClient,
 		tokentimeout,
+		tokenFormats,
 	)
+	// tokenLimitCheck rejects logins of users that hold too many tokens before an authorize code is issued,
+	// the storage enforces the limit for every token
+	tokenLimitCheck := osinserver.AuthorizeHandlers{}
+	if limitConfig := c.ExtraOAuthConfig.ExtendedOptions.TokenLimit; limitConfig != nil && limitConfig.MaxAccessTokensPerUser > 0 {
+		tokenLimit := registrystorage.NewTokenLimit(
+			c.ExtraOAuthConfig.OAuthAccessTokenClient,
+			limitConfig.MaxAccessTokensPerUser,
+			limitConfig.Policy == config.TokenLimitEvictOldest,
+		)
+		storage = tokenLimit.Storage(storage)
+		tokenLimitCheck = append(tokenLimitCheck, handlers.NewTokenLimitCheck(tokenLimit))
+	}
+	// loginJourneys end the logins of authorize requests that are answered
+	loginJourneys := osinserver.AuthorizeHandlers{}
+	if journeys := c.getLoginJourneys(); journeys != nil {
+		loginJourneys = append(loginJourneys, journeys)
+	}
 	config := osinserver.NewDefaultServerConfig()
 	if authorizationExpiration := c.ExtraOAuthConfig.Options.TokenConfig.AuthorizeTokenMaxAgeSeconds; authorizationExpiration > 0 {
 		config.AuthorizationExpiration = authorizationExpiration
@@ -128,12 +221,59 @@ func (c *OAuthServerConfig) WithOAuth(handler http.Handler) (http.Handler, error
 		config.AccessExpiration = accessExpiration
 	}
 
-	grantChecker := registry.NewClientAuthorizationGrantChecker(c.ExtraOAuthConfig.OAuthClientAuthorizationClient)
-	grantHandler, err := c.getGrantHandler(mux, authRequestHandler, combinedOAuthClientGetter, c.ExtraOAuthConfig.OAuthClientAuthorizationClient)
+	termsCheck, err := c.getTermsCheck(mux, authRequestHandler)
+	if err != nil {
+		return nil, err
+	}
+
+	consentPolicy := c.getConsentPolicy()
+	grantChecker := registry.NewClientAuthorizationGrantCheckerWithConsent(c.ExtraOAuthConfig.OAuthClientAuthorizationClient, consentPolicy)
+	grantHandler, err := c.getGrantHandler(mux, authRequestHandler, combinedOAuthClientGetter, c.ExtraOAuthConfig.OAuthClientAuthorizationClient, consentPolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	authorizeCodec, err := c.getAuthorizeCodec(mux)
 	if err != nil {
 		return nil, err
 	}
 
+	accessHandlers := osinserver.AccessHandlers{
+		handlers.NewDenyAccessAuthenticator(),
+	}
+	resourceIndicators := c.getResourceIndicators()
+	stepUp := c.getStepUp(authHandler)
+	infoHandlers := osinserver.InfoHandlers{
+		resourceIndicators,
+		stepUp,
+	}
+	// exchanged tokens are bound to keys like other tokens
+	if tokenExchanger := c.getTokenExchanger(storage); tokenExchanger != nil {
+		accessHandlers = append(accessHandlers, tokenExchanger)
+		infoHandlers = append(infoHandlers, tokenExchanger)
+	}
+	accessHandlers = append(accessHandlers, resourceIndicators)
+	var clientAuthenticator osinserver.ClientAuthenticator
+	tlsClientAuthenticator, err := c.getTLSClientAuthenticator()
+	if err != nil {
+		return nil, err
+	}
+	if tlsClientAuthenticator != nil {
+		clientAuthenticator = tlsClientAuthenticator
+		accessHandlers = append(accessHandlers, tlsClientAuthenticator)
+		infoHandlers = append(infoHandlers, tlsClientAuthenticator)
+	}
+	if dpopVerifier := c.getDPoPVerifier(); dpopVerifier != nil {
+		accessHandlers = append(accessHandlers, dpopVerifier)
+		infoHandlers = append(infoHandlers, dpopVerifier)
+	}
+
+	deviceFlow := c.getDeviceFlow(mux, combinedOAuthClientGetter)
+	var redirectURIMatcher osinserver.RedirectURIMatcher
+	if matcher := c.getRedirectURIMatcher(); matcher != nil {
+		redirectURIMatcher = matcher
+	}
+
 	server := osinserver.New(
 		config,
 		storage,
@@ -143,17 +283,28 @@ func (c *OAuthServerConfig) WithOAuth(handler http.Handler) (http.Handler, error
 				authHandler,
 				errorPageHandler,
 			),
+			c.getClientIdentityProviderCheck(authHandler),
+			c.getPKCEEnforcer(),
+			stepUp,
+			resourceIndicators,
+			termsCheck,
 			handlers.NewGrantCheck(
 				grantChecker,
 				grantHandler,
 				errorPageHandler,
 			),
+			tokenLimitCheck,
+			loginJourneys,
 			authFinalizer,
 		},
-		osinserver.AccessHandlers{
-			handlers.NewDenyAccessAuthenticator(),
-		},
+		accessHandlers,
 		osinserver.NewDefaultErrorHandler(),
+		authorizeCodec,
+		clientAuthenticator,
+		infoHandlers,
+		tokenFormats,
+		deviceFlow,
+		redirectURIMatcher,
 	)
 	server.Install(mux, oauthdiscovery.OpenShiftOAuthAPIPrefix)
 
@@ -165,12 +316,516 @@ func (c *OAuthServerConfig) WithOAuth(handler http.Handler) (http.Handler, error
 	tokenRequestEndpoints := tokenrequest.NewTokenRequest(loginURL, openShiftLogoutPrefix, c.getOsinOAuthClient, c.ExtraOAuthConfig.OAuthAccessTokenClient, c.getCSRF())
 	tokenRequestEndpoints.Install(mux, oauthdiscovery.OpenShiftOAuthAPIPrefix)
 
+	// the API server cannot authenticate tokens that are not kept in the Kubernetes API itself
+	if externalTokenStorage(c.ExtraOAuthConfig.ExtendedOptions) {
+		reviewer, err := c.getTokenReviewer()
+		if err != nil {
+			return nil, err
+		}
+		tokenReview := tokenreview.NewTokenReview(
+			reviewer,
+			c.ExtraOAuthConfig.OAuthAccessTokenClient,
+			c.ExtraOAuthConfig.UserClient,
+			c.ExtraOAuthConfig.BootstrapUserDataGetter,
+			usercache.NewGroupCache(c.ExtraOAuthConfig.GroupInformer),
+			combinedOAuthClientGetter,
+			tokenFormats,
+			tokentimeout,
+		)
+		tokenReview.Install(mux, oauthdiscovery.OpenShiftOAuthAPIPrefix)
+	}
+
 	if session := c.ExtraOAuthConfig.SessionAuth; session != nil {
-		logoutHandler := logout.NewLogout(session, c.ExtraOAuthConfig.Options.AssetPublicURL)
+		logoutHandler := logout.NewLogout(session, c.ExtraOAuthConfig.Options.AssetPublicURL, c.ExtraOAuthConfig.OAuthAccessTokenClient, c.ExtraOAuthConfig.providerLogouts)
 		logoutHandler.Install(mux, openShiftLogoutPrefix)
 	}
 
-	return mux, nil
+	selfService := selfservice.NewSelfServiceWithConsents(c.ExtraOAuthConfig.OAuthAccessTokenClient, c.ExtraOAuthConfig.OAuthClientClient, c.ExtraOAuthConfig.SessionLister, tokentimeout,
+		c.ExtraOAuthConfig.OAuthClientAuthorizationClient, consentPolicy)
+	selfService.Install(mux, openShiftSelfServicePrefix)
+
+	if pageTheme := c.ExtraOAuthConfig.Theme; pageTheme != nil {
+		pageTheme.Install(mux, openShiftStaticPrefix)
+	}
+
+	if proofOfWork, ok := c.ExtraOAuthConfig.LoginChallenge.(*captcha.ProofOfWork); ok {
+		proofOfWork.Install(mux, openShiftProofOfWorkScriptPath)
+	}
+
+	c.recordTopology()
+	serveMux.Handle(authTopologyPath, authTopology)
+
+	if checker := c.ExtraOAuthConfig.IdentityProviderHealth; checker != nil {
+		checks := c.ExtraOAuthConfig.identityProviderChecks
+		c.ExtraOAuthConfig.addPostStartHook("openshift.io-identity-provider-checks", func(ctx genericapiserver.PostStartHookContext) error {
+			checker.SetChecks(checks)
+			return nil
+		})
+		serveMux.Handle(identityProviderHealthPath, checker)
+	}
+
+	if oauthClients := c.ExtraOAuthConfig.ExtendedOptions.OAuthClients; oauthClients != nil {
+		defined, err := definedOAuthClients(oauthClients)
+		if err != nil {
+			return nil, err
+		}
+		reconciler := declaredclient.NewReconciler(c.ExtraOAuthConfig.OAuthClientClient, defined)
+		c.ExtraOAuthConfig.addPostStartHook("openshift.io-oauth-clients", func(ctx genericapiserver.PostStartHookContext) error {
+			go reconciler.Run(ctx.StopCh)
+			return nil
+		})
+	}
+
+	if verbosity := c.ExtraOAuthConfig.LogVerbosity; verbosity != nil {
+		serveMux.Handle(logVerbosityPath, verbosity)
+	}
+
+	if mode := c.ExtraOAuthConfig.Maintenance; mode != nil {
+		serveMux.Handle(maintenancePath, mode)
+	}
+
+	if vault := c.ExtraOAuthConfig.UpstreamTokens; vault != nil {
+		serveMux.Handle(upstreamTokensPath, upstreamtoken.NewHandler(vault, c.ExtraOAuthConfig.UserClient, c.ExtraOAuthConfig.IdentityClient, c.GenericConfig.Authorization.Authorizer))
+	}
+
+	if c.ExtraOAuthConfig.ExtendedOptions.IdentityConflicts != nil {
+		serveMux.Handle(identityConflictsPath, identityconflict.NewHandler(c.ExtraOAuthConfig.IdentityClient, c.ExtraOAuthConfig.UserClient, c.GenericConfig.Authorization.Authorizer))
+	}
+
+	forceLogoutRevoker := deprovisioning.NewRevokerWithOptions(c.ExtraOAuthConfig.OAuthAccessTokenClient, c.ExtraOAuthConfig.OAuthAuthorizeTokenClient, deprovisioning.RevokerOptions{
+		Sessions:       c.ExtraOAuthConfig.SessionRevocations,
+		SessionLister:  c.ExtraOAuthConfig.SessionLister,
+		UpstreamTokens: deprovisioningUpstreamTokens(c.ExtraOAuthConfig.UpstreamTokens),
+	})
+	serveMux.Handle(forceLogoutPath, deprovisioning.NewHandler(forceLogoutRevoker, c.ExtraOAuthConfig.UserClient, c.ExtraOAuthConfig.IdentityClient))
+
+	var diagnosisTimeout time.Duration
+	if healthConfig := c.ExtraOAuthConfig.ExtendedOptions.IdentityProviderHealth; healthConfig != nil {
+		diagnosisTimeout = healthConfig.Timeout.Duration
+	}
+	serveMux.Handle(identityProviderDiagnosisPath, idphealth.NewDiagnostics(c.ExtraOAuthConfig.identityProviderDiagnoses, diagnosisTimeout))
+
+	if corsConfig := c.ExtraOAuthConfig.ExtendedOptions.CORS; corsConfig != nil {
+		policy, err := cors.NewPolicy(corsConfig.AllowedOrigins, corsConfig.AllowedMethods, corsConfig.AllowedHeaders, corsConfig.ExposedHeaders, corsConfig.MaxAge.Duration)
+		if err != nil {
+			return nil, err
+		}
+		return cors.WithCORS(serveMux, policy,
+			path.Join(oauthdiscovery.OpenShiftOAuthAPIPrefix, oauthdiscovery.TokenPath),
+			path.Join(oauthdiscovery.OpenShiftOAuthAPIPrefix, oauthdiscovery.InfoPath),
+			path.Join(oauthdiscovery.OpenShiftOAuthAPIPrefix, device.AuthorizationPath),
+			openShiftJWKSPath,
+		), nil
+	}
+
+	return serveMux, nil
+}
+
+// getAuthorizeCodec returns the codec that verifies signed request objects and signs authorization responses,
+// or nil if no client has keys and responses are not signed
+func (c *OAuthServerConfig) getAuthorizeCodec(mux oauthserver.Mux) (osinserver.AuthorizeCodec, error) {
+	clients := map[string]jar.Client{}
+	for _, client := range c.ExtraOAuthConfig.ExtendedOptions.Clients {
+		jarClient := jar.Client{RequireRequestObject: client.RequireRequestObject, RequireJARM: client.RequireJARM}
+		if len(client.JWKSFile) > 0 {
+			data, err := ioutil.ReadFile(client.JWKSFile)
+			if err != nil {
+				return nil, err
+			}
+			if err := json.Unmarshal(data, &jarClient.Keys); err != nil {
+				return nil, fmt.Errorf("error parsing JWKS of client %q: %v", client.Name, err)
+			}
+		}
+		clients[client.Name] = jarClient
+	}
+
+	var signingKey *jose.JSONWebKey
+	if jarmConfig := c.ExtraOAuthConfig.ExtendedOptions.JARM; jarmConfig != nil {
+		key, err := jar.SigningKeyFromFile(jarmConfig.SigningKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading JARM signing key: %v", err)
+		}
+		signingKey = key
+	}
+
+	if len(clients) == 0 && signingKey == nil {
+		return nil, nil
+	}
+	codec, err := jar.NewCodec(c.ExtraOAuthConfig.Options.MasterPublicURL, clients, signingKey)
+	if err != nil {
+		return nil, err
+	}
+	codec.Install(mux, openShiftJWKSPath)
+	return codec, nil
+}
+
+// getDeviceFlow returns the device authorization grant, or nil if it is not enabled
+func (c *OAuthServerConfig) getDeviceFlow(mux oauthserver.Mux, clients api.OAuthClientGetter) osinserver.GrantDecoder {
+	deviceConfig := c.ExtraOAuthConfig.ExtendedOptions.DeviceAuthorization
+	if deviceConfig == nil {
+		return nil
+	}
+	flow := device.NewFlow(
+		c.ExtraOAuthConfig.DeviceBackend,
+		clients,
+		deviceConfig.Clients,
+		c.ExtraOAuthConfig.Options.MasterPublicURL,
+		deviceConfig.CodeLifetime.Duration,
+		deviceConfig.PollInterval.Duration,
+		c.getCSRF(),
+	)
+	flow.Install(mux, oauthdiscovery.OpenShiftOAuthAPIPrefix)
+	return flow
+}
+
+// getTLSClientAuthenticator returns the authenticator of clients with TLS client certificates, or nil if no
+// client authenticates with its certificate
+func (c *OAuthServerConfig) getTLSClientAuthenticator() (*mtls.Authenticator, error) {
+	clients := map[string]mtls.Client{}
+	for _, client := range c.ExtraOAuthConfig.ExtendedOptions.Clients {
+		tlsClientAuth := client.TLSClientAuth
+		if tlsClientAuth == nil {
+			continue
+		}
+		roots, err := c.addClientCA("client-"+client.Name+"-ca", tlsClientAuth.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		clients[client.Name] = mtls.Client{
+			Roots:       roots,
+			SubjectDN:   tlsClientAuth.SubjectDN,
+			DNSName:     tlsClientAuth.DNSName,
+			URI:         tlsClientAuth.URI,
+			BoundTokens: tlsClientAuth.CertificateBoundTokens,
+		}
+	}
+	if len(clients) == 0 {
+		return nil, nil
+	}
+	return mtls.NewAuthenticator(clients), nil
+}
+
+// getTokenReviewer returns the client certificate of the API server that posts token reviews
+func (c *OAuthServerConfig) getTokenReviewer() (*mtls.Client, error) {
+	reviewer := c.ExtraOAuthConfig.ExtendedOptions.TokenStorage.Reviewer
+	if reviewer == nil {
+		return nil, fmt.Errorf("token reviews require the client CA of the reviewer")
+	}
+	roots, err := c.addClientCA("token-reviewer-ca", reviewer.CAFile)
+	if err != nil {
+		return nil, err
+	}
+	return &mtls.Client{Roots: roots, SubjectDN: reviewer.SubjectDN}, nil
+}
+
+// addClientCA returns the certificates of the CA file and asks for client certificates of the CA during the TLS
+// handshake
+func (c *OAuthServerConfig) addClientCA(name, caFile string) (*x509.CertPool, error) {
+	caData, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading %s: %v", caFile, err)
+	}
+	roots := x509.NewCertPool()
+	if ok := roots.AppendCertsFromPEM(caData); !ok {
+		return nil, fmt.Errorf("Error loading certs from %s", caFile)
+	}
+
+	// the OAuth server only asks for client certificates during the TLS handshake if it advertises CAs
+	caProvider, err := dynamiccertificates.NewStaticCAContent(name, caData)
+	if err != nil {
+		return nil, fmt.Errorf("error adding certs from %s to secureServing: %v", caFile, err)
+	}
+	if c.GenericConfig.SecureServing.ClientCA == nil {
+		c.GenericConfig.SecureServing.ClientCA = caProvider
+	} else {
+		c.GenericConfig.SecureServing.ClientCA = dynamiccertificates.NewUnionCAContentProvider(c.GenericConfig.SecureServing.ClientCA, caProvider)
+	}
+	return roots, nil
+}
+
+// getDPoPVerifier returns the verifier of DPoP proofs, or nil if no client uses DPoP
+func (c *OAuthServerConfig) getDPoPVerifier() *dpop.Verifier {
+	clients := map[string]dpop.Client{}
+	for _, client := range c.ExtraOAuthConfig.ExtendedOptions.Clients {
+		if len(client.DPoP) > 0 {
+			clients[client.Name] = dpop.Client{Required: client.DPoP == config.DPoPRequired}
+		}
+	}
+	if len(clients) == 0 {
+		return nil
+	}
+	return dpop.NewVerifier(c.ExtraOAuthConfig.Options.MasterPublicURL, clients)
+}
+
+// getTokenFormats returns the formats of tokens. SHA256 tokens are always issued, the API server authenticates
+// no others, other formats are only accepted.
+func (c *OAuthServerConfig) getTokenFormats() tokenformat.Formats {
+	formatConfig := c.ExtraOAuthConfig.ExtendedOptions.TokenFormat
+	if formatConfig == nil {
+		return tokenformat.Default
+	}
+
+	formats := tokenformat.Formats{tokenformat.SHA256}
+	for _, prefix := range formatConfig.AcceptedPrefixes {
+		formats = append(formats, tokenformat.Format{Prefix: prefix})
+	}
+	if !formatConfig.RejectLegacy {
+		formats = append(formats, tokenformat.Legacy)
+	}
+	return formats
+}
+
+// getClientPolicies returns the policies that override the token settings of clients, by client name
+func (c *OAuthServerConfig) getClientPolicies() map[string]clientpolicy.Policy {
+	policies := map[string]clientpolicy.Policy{}
+	for _, client := range c.ExtraOAuthConfig.ExtendedOptions.Clients {
+		if policy := client.TokenPolicy; policy != nil {
+			policies[client.Name] = clientpolicy.Policy{
+				AccessTokenMaxAgeSeconds:            policy.AccessTokenMaxAgeSeconds,
+				AccessTokenInactivityTimeoutSeconds: policy.AccessTokenInactivityTimeoutSeconds,
+				ScopeRestrictions:                   policy.ScopeRestrictions,
+				AllowAllScopes:                      policy.AllowAllScopes,
+			}
+		}
+	}
+	return policies
+}
+
+// definedOAuthClients returns the OAuthClients of the inline definitions and the definition files, with the
+// secrets of their secret files
+func definedOAuthClients(oauthClients *config.OAuthClients) ([]oauthapi.OAuthClient, error) {
+	definitions := append([]config.OAuthClientDefinition{}, oauthClients.Clients...)
+	for _, file := range oauthClients.Files {
+		fileDefinitions, err := config.ReadOAuthClientDefinitions(file)
+		if err != nil {
+			return nil, err
+		}
+		definitions = append(definitions, fileDefinitions...)
+	}
+
+	clients := make([]oauthapi.OAuthClient, 0, len(definitions))
+	names := sets.NewString()
+	for _, definition := range definitions {
+		if names.Has(definition.Name) {
+			return nil, fmt.Errorf("OAuth client %q is defined more than once", definition.Name)
+		}
+		names.Insert(definition.Name)
+		client := oauthapi.OAuthClient{
+			ObjectMeta:                          metav1.ObjectMeta{Name: definition.Name},
+			RedirectURIs:                        definition.RedirectURIs,
+			GrantMethod:                         definition.GrantMethod,
+			ScopeRestrictions:                   definition.ScopeRestrictions,
+			RespondWithChallenges:               definition.RespondWithChallenges,
+			AccessTokenMaxAgeSeconds:            definition.AccessTokenMaxAgeSeconds,
+			AccessTokenInactivityTimeoutSeconds: definition.AccessTokenInactivityTimeoutSeconds,
+		}
+		if len(definition.SecretFile) > 0 {
+			secret, err := ioutil.ReadFile(definition.SecretFile)
+			if err != nil {
+				return nil, fmt.Errorf("unable to read the secret of OAuth client %q: %v", definition.Name, err)
+			}
+			if client.Secret = string(bytes.TrimSpace(secret)); len(client.Secret) == 0 {
+				return nil, fmt.Errorf("secret file %s of OAuth client %q is empty", definition.SecretFile, definition.Name)
+			}
+		}
+		clients = append(clients, client)
+	}
+	return clients, nil
+}
+
+// getBasicChallenger returns the challenger of all password identity providers, with the realm and parameters of the
+// basic challenge config
+func (c *OAuthServerConfig) getBasicChallenger() handlers.AuthenticationChallenger {
+	realm := "openshift"
+	var parameters []handlers.ChallengeParameter
+	if challengerConfig := c.ExtraOAuthConfig.ExtendedOptions.Challengers; challengerConfig != nil && challengerConfig.Basic != nil {
+		if len(challengerConfig.Basic.Realm) > 0 {
+			realm = challengerConfig.Basic.Realm
+		}
+		parameters = challengeParameters(challengerConfig.Basic.Parameters)
+	}
+	return passwordchallenger.NewBasicAuthChallengerWithParameters(realm, parameters...)
+}
+
+func challengeParameters(parameters []config.ChallengeParameter) []handlers.ChallengeParameter {
+	challengeParameters := make([]handlers.ChallengeParameter, 0, len(parameters))
+	for _, parameter := range parameters {
+		challengeParameters = append(challengeParameters, handlers.ChallengeParameter{Name: parameter.Name, Value: parameter.Value})
+	}
+	return challengeParameters
+}
+
+// getRedirectURIMatcher returns the matcher of the redirect URIs of clients, or nil if osin matches them for all
+// clients
+func (c *OAuthServerConfig) getRedirectURIMatcher() *redirecturi.Matcher {
+	policies := map[string]redirecturi.Policy{}
+	for _, client := range c.ExtraOAuthConfig.ExtendedOptions.Clients {
+		if client.RedirectURIs == nil && !client.NativeApp {
+			continue
+		}
+		policy := redirecturi.Policy{LoopbackAnyPort: client.NativeApp, PrivateUseSchemes: client.NativeApp}
+		if redirectURIs := client.RedirectURIs; redirectURIs != nil {
+			policy.Exact = redirectURIs.Matching == config.RedirectURIMatchingExact
+			policy.LoopbackAnyPort = policy.LoopbackAnyPort || redirectURIs.LoopbackAnyPort
+		}
+		policies[client.Name] = policy
+	}
+	if len(policies) == 0 {
+		return nil
+	}
+	return redirecturi.NewMatcher(policies)
+}
+
+// getResourceIndicators returns the resource indicators of the clients that may restrict the audience of their tokens
+func (c *OAuthServerConfig) getResourceIndicators() *resource.Indicators {
+	resources := map[string]sets.String{}
+	for _, client := range c.ExtraOAuthConfig.ExtendedOptions.Clients {
+		if len(client.Resources) > 0 {
+			resources[client.Name] = sets.NewString(client.Resources...)
+		}
+	}
+	return resource.NewIndicators(resources)
+}
+
+// getPKCEEnforcer returns the handler that requires code challenges in authorize requests for codes. Without pkce
+// settings, challenges are optional and may use the plain method, as osin accepts them.
+func (c *OAuthServerConfig) getPKCEEnforcer() *pkce.Enforcer {
+	publicClients := pkce.Policy{AllowPlain: true}
+	confidentialClients := pkce.Policy{AllowPlain: true}
+	if pkceConfig := c.ExtraOAuthConfig.ExtendedOptions.PKCE; pkceConfig != nil {
+		publicClients = pkce.Policy{Required: pkceConfig.RequireForPublicClients, AllowPlain: pkceConfig.AllowPlain}
+		confidentialClients = pkce.Policy{Required: pkceConfig.RequireForConfidentialClients, AllowPlain: pkceConfig.AllowPlain}
+	}
+	clients := map[string]pkce.ClientPolicy{}
+	required := true
+	for _, client := range c.ExtraOAuthConfig.ExtendedOptions.Clients {
+		switch {
+		// native apps cannot keep secrets, codes must not be redeemable without the verifier
+		case client.NativeApp:
+			clients[client.Name] = pkce.ClientPolicy{Required: &required, AllowPlain: new(bool), CodeOnly: true}
+		case client.PKCE != nil:
+			clients[client.Name] = pkce.ClientPolicy{Required: client.PKCE.Required, AllowPlain: client.PKCE.AllowPlain}
+		}
+	}
+	return pkce.NewEnforcer(publicClients, confidentialClients, clients)
+}
+
+// getLoginAnomalyDetector returns the detector of anomalous logins, or nil if they are not detected
+func (c *OAuthServerConfig) getLoginAnomalyDetector() (*loginanomaly.Detector, error) {
+	anomalyConfig := c.ExtraOAuthConfig.ExtendedOptions.LoginAnomalies
+	if anomalyConfig == nil {
+		return nil, nil
+	}
+
+	var resolver loginanomaly.RegionResolver
+	if len(anomalyConfig.RegionsFile) > 0 {
+		data, err := ioutil.ReadFile(anomalyConfig.RegionsFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read login anomalies regions: %v", err)
+		}
+		cidrResolver, err := loginanomaly.NewCIDRResolver(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid login anomalies regions file %s: %v", anomalyConfig.RegionsFile, err)
+		}
+		resolver = cidrResolver
+	}
+
+	var notifier loginanomaly.Notifier
+	if webhookConfig := anomalyConfig.Webhook; webhookConfig != nil {
+		transport, err := transportFor(webhookConfig.CA, webhookConfig.CertFile, webhookConfig.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error building login anomalies webhook client: %v", err)
+		}
+		notifier = loginanomaly.NewWebhook(webhookConfig.URL, transport, webhookConfig.Timeout.Duration)
+	}
+
+	return loginanomaly.NewDetector(c.ExtraOAuthConfig.UserClient, resolver, anomalyConfig.ImpossibleTravelWindow.Duration, anomalyConfig.MaxFingerprints, notifier, anomalyConfig.RequiredMethods), nil
+}
+
+// getLoginJourneys returns the tracker of complete browser logins, or nil without sessions to sign its cookie
+func (c *OAuthServerConfig) getLoginJourneys() *journey.Tracker {
+	if c.ExtraOAuthConfig.SessionKeys == nil {
+		return nil
+	}
+	return journey.NewTracker(c.ExtraOAuthConfig.CookieOptions, c.ExtraOAuthConfig.SessionKeys)
+}
+
+// sessionSuccessHandler returns the success handler that starts the sessions of logins with the identity provider,
+// after checking them for anomalies if detector is set. The logins are tracked by journeys if set. The sessions
+// record the provider, so that clients restricted to other providers do not accept them.
+func (c *OAuthServerConfig) sessionSuccessHandler(provider string, detector *loginanomaly.Detector, journeys *journey.Tracker) handlers.AuthenticationSuccessHandler {
+	successHandler := handlers.AuthenticationSuccessHandler(c.ExtraOAuthConfig.SessionAuth)
+	if detector != nil {
+		successHandler = loginanomaly.NewSuccessHandler(detector, provider, successHandler)
+	}
+	if journeys != nil {
+		successHandler = journeys.SuccessHandler(provider, successHandler)
+	}
+	return handlers.NewIdentityProviderSuccessHandler(provider, successHandler)
+}
+
+// getConsentPolicy returns when approvals of scopes expire and the consent versions of clients, approvals without a
+// version stay valid for clients without one
+func (c *OAuthServerConfig) getConsentPolicy() *registry.ConsentPolicy {
+	policy := &registry.ConsentPolicy{Versions: map[string]string{}}
+	if consent := c.ExtraOAuthConfig.ExtendedOptions.Consent; consent != nil {
+		policy.MaxAge = consent.MaxAge.Duration
+	}
+	for _, client := range c.ExtraOAuthConfig.ExtendedOptions.Clients {
+		if len(client.ConsentVersion) > 0 {
+			policy.Versions[client.Name] = client.ConsentVersion
+		}
+	}
+	return policy
+}
+
+// getStepUp returns the handler that records how and when users authenticated in their tokens, and asks users to log
+// in again if their login is too old or does not satisfy the authentication context class a client requires
+func (c *OAuthServerConfig) getStepUp(authHandler handlers.AuthenticationHandler) *acr.StepUp {
+	var classes []acr.Class
+	if authenticationContext := c.ExtraOAuthConfig.ExtendedOptions.AuthenticationContext; authenticationContext != nil {
+		for _, class := range authenticationContext.Classes {
+			classes = append(classes, acr.Class{Name: class.Name, Methods: class.Methods})
+		}
+	}
+	providerMethods := map[string][]string{}
+	for _, identityProvider := range c.ExtraOAuthConfig.Options.IdentityProviders {
+		providerMethods[identityProvider.Name] = c.authenticationMethods(identityProvider)
+	}
+	maxAges := map[string]time.Duration{}
+	for _, client := range c.ExtraOAuthConfig.ExtendedOptions.Clients {
+		if client.MaxAgeSeconds != nil {
+			maxAges[client.Name] = time.Duration(*client.MaxAgeSeconds) * time.Second
+		}
+	}
+	return acr.NewStepUpWithMaxAges(classes, providerMethods, maxAges, authHandler)
+}
+
+// authenticationMethods returns the methods recorded for logins with the identity provider
+func (c *OAuthServerConfig) authenticationMethods(identityProvider osinv1.IdentityProvider) []string {
+	methods := []string{acr.MethodSSO}
+	if config.IsPasswordAuthenticator(identityProvider) {
+		methods = []string{acr.MethodPassword}
+	}
+	return append(methods, c.ExtraOAuthConfig.ExtendedOptions.IdentityProvider(identityProvider.Name).AuthenticationMethods...)
+}
+
+// getTokenExchanger returns the handler of token exchange requests, or nil if no client may exchange tokens
+func (c *OAuthServerConfig) getTokenExchanger(storage osin.Storage) *tokenexchange.Exchanger {
+	policies := map[string]tokenexchange.Policy{}
+	for _, client := range c.ExtraOAuthConfig.ExtendedOptions.Clients {
+		if exchange := client.TokenExchange; exchange != nil {
+			policies[client.Name] = tokenexchange.Policy{
+				Subjects:  sets.NewString(exchange.Subjects...),
+				Actors:    sets.NewString(exchange.Actors...),
+				Audiences: sets.NewString(exchange.Audiences...),
+			}
+		}
+	}
+	if len(policies) == 0 {
+		return nil
+	}
+	return tokenexchange.NewExchanger(storage, policies)
 }
 
 func (c *OAuthServerConfig) getOsinOAuthClient() (*osincli.Client, error) {
@@ -206,11 +861,34 @@ func (c *OAuthServerConfig) getErrorHandler() (*errorpage.ErrorPage, error) {
 	if c.ExtraOAuthConfig.Options.Templates != nil {
 		errorTemplate = c.ExtraOAuthConfig.Options.Templates.Error
 	}
-	errorPageRenderer, err := errorpage.NewErrorPageTemplateRenderer(errorTemplate)
+	errorPageRenderer, err := errorpage.NewErrorPageTemplateRenderer(c.templateFile(errorTemplate, theme.ErrorTemplate))
 	if err != nil {
 		return nil, err
 	}
-	return errorpage.NewErrorPageHandler(errorPageRenderer), nil
+	var help map[string]errorpage.Help
+	if errorPages := c.ExtraOAuthConfig.ExtendedOptions.ErrorPages; errorPages != nil {
+		help = map[string]errorpage.Help{}
+		for _, errorHelp := range errorPages.Help {
+			help[errorHelp.Code] = errorpage.Help{Text: errorHelp.Text, LocalizedText: errorHelp.LocalizedText}
+		}
+	}
+	// users whose login with an identity provider failed may try another one if there is a choice
+	loginProviders := 0
+	for _, identityProvider := range c.ExtraOAuthConfig.Options.IdentityProviders {
+		if identityProvider.UseAsLogin {
+			loginProviders++
+		}
+	}
+	return errorpage.NewErrorPageHandlerWithHelp(errorPageRenderer, help, loginProviders > 1)
+}
+
+// templateFile returns the template of a page. A template configured in osinv1.OAuthConfig takes
+// precedence over the one of the theme, an empty string selects the built-in template.
+func (c *OAuthServerConfig) templateFile(configured, themeTemplate string) string {
+	if len(configured) > 0 {
+		return configured
+	}
+	return c.ExtraOAuthConfig.Theme.Template(themeTemplate)
 }
 
 // newOpenShiftOAuthClientConfig provides config for OpenShift OAuth client
@@ -229,9 +907,11 @@ func newOpenShiftOAuthClientConfig(clientId, clientSecret, masterPublicURL, mast
 
 // getCSRF returns the object responsible for generating and checking CSRF tokens
 func (c *OAuthServerConfig) getCSRF() csrf.CSRF {
-	// TODO we really need to enforce HTTPS always
-	secure := isHTTPS(c.ExtraOAuthConfig.Options.MasterPublicURL)
-	return csrf.NewCookieCSRF("csrf", "/", "", secure)
+	if c.ExtraOAuthConfig.CSRF != nil {
+		return c.ExtraOAuthConfig.CSRF
+	}
+	options := c.ExtraOAuthConfig.CookieOptions
+	return csrf.NewCookieCSRF(options.Name("csrf"), options)
 }
 
 func (c *OAuthServerConfig) getAuthorizeAuthenticationHandlers(mux oauthserver.Mux, errorHandler handlers.AuthenticationErrorHandler) (authenticator.Request, handlers.AuthenticationHandler, osinserver.AuthorizeHandler, error) {
@@ -248,8 +928,29 @@ func (c *OAuthServerConfig) getAuthorizeAuthenticationHandlers(mux oauthserver.M
 	return authRequestHandler, authHandler, authFinalizer, nil
 }
 
+// clientIdentityProviders returns the identity providers of the clients that are restricted to some, by client name
+func (c *OAuthServerConfig) clientIdentityProviders() map[string][]string {
+	identityProviders := map[string][]string{}
+	for _, client := range c.ExtraOAuthConfig.ExtendedOptions.Clients {
+		if len(client.IdentityProviders) > 0 {
+			identityProviders[client.Name] = client.IdentityProviders
+		}
+	}
+	return identityProviders
+}
+
+// getClientIdentityProviderCheck returns the handlers that deny users that logged in with an identity provider their
+// client does not allow, if a client is restricted to some
+func (c *OAuthServerConfig) getClientIdentityProviderCheck(authHandler handlers.AuthenticationHandler) osinserver.AuthorizeHandlers {
+	identityProviders := c.clientIdentityProviders()
+	if len(identityProviders) == 0 {
+		return osinserver.AuthorizeHandlers{}
+	}
+	return osinserver.AuthorizeHandlers{handlers.NewClientIdentityProviderCheck(handlers.ClientRestrictions{IdentityProviders: identityProviders}, authHandler)}
+}
+
 // getGrantHandler returns the object that handles approving or rejecting grant requests
-func (c *OAuthServerConfig) getGrantHandler(mux oauthserver.Mux, auth authenticator.Request, clientregistry api.OAuthClientGetter, authregistry oauthclient.OAuthClientAuthorizationInterface) (handlers.GrantHandler, error) {
+func (c *OAuthServerConfig) getGrantHandler(mux oauthserver.Mux, auth authenticator.Request, clientregistry api.OAuthClientGetter, authregistry oauthclient.OAuthClientAuthorizationInterface, consent *registry.ConsentPolicy) (handlers.GrantHandler, error) {
 	// check that the global default strategy is something we honor
 	if !config.ValidGrantHandlerTypes.Has(string(c.ExtraOAuthConfig.Options.GrantConfig.Method)) {
 		return nil, fmt.Errorf("No grant handler found that matches %v.  The OAuth server cannot start!", c.ExtraOAuthConfig.Options.GrantConfig.Method)
@@ -257,7 +958,11 @@ func (c *OAuthServerConfig) getGrantHandler(mux oauthserver.Mux, auth authentica
 
 	// Since any OAuth client could require prompting, we will unconditionally
 	// start the GrantServer here.
-	grantServer := grant.NewGrant(c.getCSRF(), auth, grant.DefaultFormRenderer, clientregistry, authregistry)
+	grantFormRenderer, err := grant.NewGrantFormRenderer(c.ExtraOAuthConfig.Theme.Template(theme.GrantTemplate))
+	if err != nil {
+		return nil, err
+	}
+	grantServer := grant.NewGrantWithConsent(c.getCSRF(), auth, grantFormRenderer, clientregistry, authregistry, consent)
 	grantServer.Install(mux, path.Join(oauthdiscovery.OpenShiftOAuthAPIPrefix, oauthdiscovery.AuthorizePath, openShiftApproveSubpath))
 
 	// Set defaults for standard clients. These can be overridden.
@@ -267,9 +972,36 @@ func (c *OAuthServerConfig) getGrantHandler(mux oauthserver.Mux, auth authentica
 	), nil
 }
 
+// getTermsCheck returns the handlers that ask users to accept the terms of service, if they are configured
+func (c *OAuthServerConfig) getTermsCheck(mux oauthserver.Mux, auth authenticator.Request) (osinserver.AuthorizeHandlers, error) {
+	termsConfig := c.ExtraOAuthConfig.ExtendedOptions.TermsOfService
+	if termsConfig == nil {
+		return osinserver.AuthorizeHandlers{}, nil
+	}
+
+	text, err := ioutil.ReadFile(termsConfig.File)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read terms of service: %v", err)
+	}
+	termsFormRenderer, err := terms.NewTermsFormRenderer(c.ExtraOAuthConfig.Theme.Template(theme.TermsTemplate))
+	if err != nil {
+		return nil, err
+	}
+
+	recorder := registry.NewUserTermsRecorder(c.ExtraOAuthConfig.UserClient)
+	termsServer := terms.NewTerms(c.getCSRF(), auth, termsFormRenderer, recorder, termsConfig.Version, string(text))
+	termsServer.Install(mux, path.Join(oauthdiscovery.OpenShiftOAuthAPIPrefix, oauthdiscovery.AuthorizePath, openShiftTermsSubpath))
+
+	return osinserver.AuthorizeHandlers{handlers.NewTermsCheck(recorder, termsConfig.Version, openShiftTermsSubpath)}, nil
+}
+
 // getAuthenticationFinalizer returns an authentication finalizer which is called just prior to writing a response to an authorization request
 func (c *OAuthServerConfig) getAuthenticationFinalizer() osinserver.AuthorizeHandler {
-	if c.ExtraOAuthConfig.SessionAuth != nil {
+	// Kept sessions let later authorize requests with prompt=none succeed without a login
+	silentConfig := c.ExtraOAuthConfig.ExtendedOptions.SilentAuthentication
+	keepSessions := silentConfig != nil && silentConfig.KeepSessions
+
+	if c.ExtraOAuthConfig.SessionAuth != nil && !keepSessions {
 		// The session needs to know the authorize flow is done so it can invalidate the session
 		return osinserver.AuthorizeHandlerFunc(func(ar *osin.AuthorizeRequest, resp *osin.Response, w http.ResponseWriter) (bool, error) {
 			user, ok := ar.UserData.(kuser.Info)
@@ -278,7 +1010,7 @@ func (c *OAuthServerConfig) getAuthenticationFinalizer() osinserver.AuthorizeHan
 				user = &kuser.DefaultInfo{} // set non-nil so we always try to invalidate
 			}
 
-			if err := c.ExtraOAuthConfig.SessionAuth.InvalidateAuthentication(w, user); err != nil {
+			if err := c.ExtraOAuthConfig.SessionAuth.InvalidateAuthentication(w, ar.HttpRequest, user); err != nil {
 				klog.V(5).Infof("error invaliding cookie session: %v", err)
 			}
 			// do not fail the OAuth flow if we cannot invalidate the cookie
@@ -293,9 +1025,61 @@ func (c *OAuthServerConfig) getAuthenticationFinalizer() osinserver.AuthorizeHan
 	})
 }
 
+// providerDisplay returns the configured presentation of the identity provider to users choosing one
+func (c *OAuthServerConfig) providerDisplay(name string) api.ProviderDisplay {
+	display := c.ExtraOAuthConfig.ExtendedOptions.IdentityProvider(name).Display
+	if display == nil {
+		return api.ProviderDisplay{}
+	}
+	return api.ProviderDisplay{
+		DisplayName: display.DisplayName,
+		IconURL:     display.IconURL,
+		Description: display.Description,
+		Order:       display.Order,
+	}
+}
+
+// withCanaries sends the logins of identity providers with a canary to the target of the canary or to the provider
+// itself. The targets are removed, so that they are not offered on the provider selection page.
+func (c *OAuthServerConfig) withCanaries(redirectors *handlers.AuthenticationRedirectors) (*handlers.AuthenticationRedirectors, error) {
+	targets := sets.NewString()
+	for _, idp := range c.ExtraOAuthConfig.ExtendedOptions.IdentityProviders {
+		if idp.Canary != nil {
+			targets.Insert(idp.Canary.IdentityProvider)
+		}
+	}
+	if targets.Len() == 0 {
+		return redirectors, nil
+	}
+
+	withCanaries := new(handlers.AuthenticationRedirectors)
+	for _, name := range redirectors.GetNames() {
+		if targets.Has(name) {
+			continue
+		}
+		loginRedirector, _ := redirectors.Get(name)
+		if canary := c.ExtraOAuthConfig.ExtendedOptions.IdentityProvider(name).Canary; canary != nil {
+			target, ok := redirectors.Get(canary.IdentityProvider)
+			if !ok {
+				return nil, fmt.Errorf("canary of identity provider %q: %q is not an identity provider used as login", name, canary.IdentityProvider)
+			}
+			loginRedirector = redirector.NewCanaryRedirector(name, loginRedirector, redirector.Canary{
+				Name:       canary.IdentityProvider,
+				Redirector: target,
+				Percentage: canary.Percentage,
+				Users:      canary.Users,
+			}, c.ExtraOAuthConfig.CookieOptions)
+		}
+		withCanaries.AddWithDisplay(name, loginRedirector, redirectors.Display(name))
+	}
+	return withCanaries, nil
+}
+
 func (c *OAuthServerConfig) getAuthenticationHandler(mux oauthserver.Mux, errorHandler handlers.AuthenticationErrorHandler) (handlers.AuthenticationHandler, error) {
-	// TODO: make this ordered once we can have more than one
+	// challengers are asked in the order of the extended config, see handlers.ChallengerOrder
 	challengers := map[string]handlers.AuthenticationChallenger{}
+	// challengerProviders are the names of the identity providers whose credentials the challengers ask for
+	challengerProviders := map[string][]string{}
 
 	redirectors := new(handlers.AuthenticationRedirectors)
 
@@ -312,20 +1096,45 @@ func (c *OAuthServerConfig) getAuthenticationHandler(mux oauthserver.Mux, errorH
 		}
 	}
 
+	anomalyDetector, err := c.getLoginAnomalyDetector()
+	if err != nil {
+		return nil, err
+	}
+	journeys := c.getLoginJourneys()
+
+	authTopology := c.ExtraOAuthConfig.getTopology()
 	for _, identityProvider := range c.ExtraOAuthConfig.Options.IdentityProviders {
-		identityMapper, err := newIdentityUserMapperWithGroups(
-			c.ExtraOAuthConfig.IdentityClient,
-			c.ExtraOAuthConfig.UserClient,
-			c.ExtraOAuthConfig.GroupInformer,
-			c.ExtraOAuthConfig.GroupClient,
-			c.ExtraOAuthConfig.GroupLister,
-			c.ExtraOAuthConfig.UserIdentityMappingClient,
-			identitymapper.MappingMethodType(identityProvider.MappingMethod),
-		)
+		identityMapper, err := c.getIdentityMapper(identityProvider)
 		if err != nil {
 			return nil, err
 		}
 
+		idpTopology := c.identityProviderTopology(identityProvider)
+		display := c.providerDisplay(identityProvider.Name)
+
+		if revocation := c.ExtraOAuthConfig.ExtendedOptions.IdentityProvider(identityProvider.Name).CredentialsRevocation; revocation != nil && len(revocation.WebhookSecretFile) > 0 {
+			secret, err := ioutil.ReadFile(revocation.WebhookSecretFile)
+			if err != nil {
+				return nil, fmt.Errorf("identity provider %q: unable to read credentials webhook secret: %v", identityProvider.Name, err)
+			}
+			if len(bytes.TrimSpace(secret)) == 0 {
+				return nil, fmt.Errorf("identity provider %q: credentials webhook secret file %s is empty", identityProvider.Name, revocation.WebhookSecretFile)
+			}
+			revoker := deprovisioning.NewRevoker(c.ExtraOAuthConfig.OAuthAccessTokenClient, c.ExtraOAuthConfig.OAuthAuthorizeTokenClient, c.ExtraOAuthConfig.SessionRevocations)
+			webhook := credentialsversion.NewWebhook(identityProvider.Name, string(bytes.TrimSpace(secret)), c.ExtraOAuthConfig.IdentityClient, revoker)
+			webhook.Install(mux, path.Join(openShiftCredentialsChangedPrefix, identityProvider.Name))
+			idpTopology.Policies["credentialsChangedWebhook"] = "true"
+		}
+
+		if scimConfig := c.ExtraOAuthConfig.ExtendedOptions.IdentityProvider(identityProvider.Name).SCIM; scimConfig != nil {
+			scimServer, err := c.getSCIMServer(identityProvider, scimConfig)
+			if err != nil {
+				return nil, err
+			}
+			scimServer.Install(mux, path.Join(openShiftSCIMPrefix, identityProvider.Name))
+			idpTopology.Policies["scim"] = "true"
+		}
+
 		// TODO: refactor handler building per type
 		if config.IsPasswordAuthenticator(identityProvider) {
 			passwordAuth, err := c.getPasswordAuthenticator(identityProvider)
@@ -340,7 +1149,7 @@ func (c *OAuthServerConfig) getAuthenticationHandler(mux oauthserver.Mux, errorH
 				if c.ExtraOAuthConfig.SessionAuth == nil {
 					return nil, errors.New("SessionAuth is required for password-based login")
 				}
-				passwordSuccessHandler := handlers.AuthenticationSuccessHandlers{c.ExtraOAuthConfig.SessionAuth, redirectSuccessHandler{}}
+				passwordSuccessHandler := handlers.AuthenticationSuccessHandlers{acr.NewMethodsSuccessHandler(c.authenticationMethods(identityProvider), c.sessionSuccessHandler(identityProvider.Name, anomalyDetector, journeys)), redirectSuccessHandler{redirects: c.ExtraOAuthConfig.Redirects}}
 
 				var (
 					// loginPath is unescaped, the way the mux will see it once URL-decoding is done
@@ -359,32 +1168,49 @@ func (c *OAuthServerConfig) getAuthenticationHandler(mux oauthserver.Mux, errorH
 				}
 
 				// Since we're redirecting to a local login page, we don't need to force absolute URL resolution
-				redirectors.Add(identityProvider.Name, redirector.NewRedirector(nil, redirectLoginPath+"?then=${server-relative-url}"))
+				redirectors.AddWithDisplay(identityProvider.Name, redirector.NewRedirector(nil, redirectLoginPath+"?then=${server-relative-url}"), display)
 
 				var loginTemplateFile string
 				if c.ExtraOAuthConfig.Options.Templates != nil {
 					loginTemplateFile = c.ExtraOAuthConfig.Options.Templates.Login
 				}
-				loginFormRenderer, err := login.NewLoginFormRenderer(loginTemplateFile)
+				loginFormRenderer, err := login.NewLoginFormRenderer(c.templateFile(loginTemplateFile, theme.LoginTemplate))
 				if err != nil {
 					return nil, err
 				}
 
-				login := login.NewLogin(identityProvider.Name, c.getCSRF(), &callbackPasswordAuthenticator{PasswordAuthenticator: passwordAuth, AuthenticationSuccessHandler: passwordSuccessHandler}, loginFormRenderer)
+				login := login.NewLogin(identityProvider.Name, c.getCSRF(), &callbackPasswordAuthenticator{PasswordAuthenticator: passwordAuth, AuthenticationSuccessHandler: passwordSuccessHandler}, loginFormRenderer, c.ExtraOAuthConfig.LoginCaptcha, c.ExtraOAuthConfig.Redirects)
 				login.Install(mux, loginPath)
+				idpTopology.LoginPath = loginPath
 			}
 			if identityProvider.UseAsChallenger {
 				// For now, all password challenges share a single basic challenger, since they'll all respond to any basic credentials
-				challengers["basic-challenge"] = passwordchallenger.NewBasicAuthChallenger("openshift")
+				challengers["basic-challenge"] = c.ExtraOAuthConfig.BasicAuthThrottle.Challenger(c.getBasicChallenger())
+				challengerProviders["basic-challenge"] = append(challengerProviders["basic-challenge"], identityProvider.Name)
 			}
 		} else if config.IsOAuthIdentityProvider(identityProvider) {
 			oauthProvider, err := c.getOAuthProvider(identityProvider)
 			if err != nil {
 				return nil, err
 			}
+			// the provider itself is kept for the interfaces of its vendor, e.g. to verify logout tokens
+			authorizeProvider, err := c.withAuthorizeSettings(identityProvider, c.withIdentityCache(identityProvider.Name, oauthProvider))
+			if err != nil {
+				return nil, err
+			}
+			if oauthConfig, err := oauthProvider.NewConfig(); err == nil && len(oauthConfig.TokenUrl) > 0 {
+				if transport, err := oauthProvider.GetTransport(); err == nil {
+					c.ExtraOAuthConfig.addIdentityProviderCheck(identityProvider.Name, idphealth.HTTPCheck(oauthConfig.TokenUrl, transport))
+					c.ExtraOAuthConfig.addIdentityProviderDiagnosis(identityProvider.Name, idphealth.TLSStep(oauthConfig.TokenUrl, transport))
+					// ticket providers have no client credentials
+					if _, ticketing := oauthProvider.(external.TicketProvider); !ticketing {
+						c.ExtraOAuthConfig.addIdentityProviderDiagnosis(identityProvider.Name, idphealth.ClientCredentialsStep(oauthConfig.TokenUrl, oauthConfig.ClientId, oauthConfig.ClientSecret, transport))
+					}
+				}
+			}
 
 			// Default state builder, combining CSRF and return URL handling
-			state := external.CSRFRedirectingState(c.getCSRF())
+			state := external.CSRFRedirectingState(c.getCSRF(), c.ExtraOAuthConfig.Redirects)
 
 			// OAuth auth requires
 			// 1. a session success handler (to remember you logged in)
@@ -392,24 +1218,60 @@ func (c *OAuthServerConfig) getAuthenticationHandler(mux oauthserver.Mux, errorH
 			if c.ExtraOAuthConfig.SessionAuth == nil {
 				return nil, errors.New("SessionAuth is required for OAuth-based login")
 			}
-			oauthSuccessHandler := handlers.AuthenticationSuccessHandlers{c.ExtraOAuthConfig.SessionAuth, state}
+			oauthSuccessHandler := handlers.AuthenticationSuccessHandlers{acr.NewMethodsSuccessHandler(c.authenticationMethods(identityProvider), c.sessionSuccessHandler(identityProvider.Name, anomalyDetector, journeys)), state}
+			if c.ExtraOAuthConfig.ExtendedOptions.IdentityProvider(identityProvider.Name).KeepUpstreamTokens {
+				refresher, err := c.upstreamTokenRefresher(identityProvider.Name, authorizeProvider)
+				if err != nil {
+					return nil, err
+				}
+				c.ExtraOAuthConfig.UpstreamTokens.AddProvider(identityProvider.Name, refresher)
+				// the tokens are kept before the session is created and the user is sent on
+				oauthSuccessHandler = append(handlers.AuthenticationSuccessHandlers{c.ExtraOAuthConfig.UpstreamTokens}, oauthSuccessHandler...)
+			}
 
 			// If the specified errorHandler doesn't handle the login error, let the state error handler attempt to propagate specific errors back to the token requester
 			oauthErrorHandler := handlers.AuthenticationErrorHandlers{errorHandler, state}
+			if journeys != nil {
+				// failed callbacks end the login
+				oauthErrorHandler = append(handlers.AuthenticationErrorHandlers{journeys}, oauthErrorHandler...)
+			}
+			// Clients that accept JSON and are not sent back to the token requester get the JSON variant of the error page
+			if errorPage, ok := errorHandler.(*errorpage.ErrorPage); ok {
+				oauthErrorHandler = append(oauthErrorHandler, errorPage.JSON())
+			}
 
 			callbackPath := path.Join(openShiftOAuthCallbackPrefix, identityProvider.Name)
-			oauthRedirector, oauthHandler, err := external.NewExternalOAuthRedirector(oauthProvider, state, c.ExtraOAuthConfig.Options.MasterPublicURL+callbackPath, oauthSuccessHandler, oauthErrorHandler, identityMapper)
+			// users are sent back to the host they logged in on, the callbacks under all hosts must be registered
+			oauthRedirector, oauthHandler, err := external.NewExternalOAuthRedirectorForHosts(authorizeProvider, state, c.ExtraOAuthConfig.Options.MasterPublicURL+callbackPath, c.ExtraOAuthConfig.alternateExternalURLs(callbackPath), oauthSuccessHandler, oauthErrorHandler, identityMapper)
 			if err != nil {
 				return nil, fmt.Errorf("unexpected error: %v", err)
 			}
 
-			mux.Handle(callbackPath, oauthHandler)
+			mux.Handle(callbackPath, logging.WithProvider(oauthHandler, identityProvider.Name))
+			idpTopology.CallbackPath = callbackPath
+			if providerLogout, ok := c.ExtraOAuthConfig.providerLogouts[identityProvider.Name]; ok {
+				idpTopology.Policies["endSessionURL"] = providerLogout.EndSessionURL
+			}
+			if c.ExtraOAuthConfig.ExtendedOptions.IdentityProvider(identityProvider.Name).BackChannelLogout() {
+				verifier, ok := oauthProvider.(openid.LogoutTokenVerifier)
+				if !ok {
+					return nil, fmt.Errorf("identity provider %s does not support back-channel logout", identityProvider.Name)
+				}
+				revoker := deprovisioning.NewRevoker(c.ExtraOAuthConfig.OAuthAccessTokenClient, c.ExtraOAuthConfig.OAuthAuthorizeTokenClient, c.ExtraOAuthConfig.SessionRevocations)
+				backChannelLogout := logout.NewBackChannelLogout(identityProvider.Name, verifier, c.ExtraOAuthConfig.IdentityClient, revoker)
+				backChannelLogout.Install(mux, path.Join(openShiftBackChannelLogoutPrefix, identityProvider.Name))
+				idpTopology.Policies["backChannelLogout"] = "true"
+			}
+			if oauthConfig, err := authorizeProvider.NewConfig(); err == nil && len(oauthConfig.Scope) > 0 {
+				idpTopology.Scopes = strings.Fields(oauthConfig.Scope)
+			}
 			if identityProvider.UseAsLogin {
-				redirectors.Add(identityProvider.Name, oauthRedirector)
+				redirectors.AddWithDisplay(identityProvider.Name, oauthRedirector, display)
 			}
 			if identityProvider.UseAsChallenger {
 				// For now, all password challenges share a single basic challenger, since they'll all respond to any basic credentials
-				challengers["basic-challenge"] = passwordchallenger.NewBasicAuthChallenger("openshift")
+				challengers["basic-challenge"] = c.ExtraOAuthConfig.BasicAuthThrottle.Challenger(c.getBasicChallenger())
+				challengerProviders["basic-challenge"] = append(challengerProviders["basic-challenge"], identityProvider.Name)
 			}
 		} else if requestHeaderProvider, isRequestHeader := identityProvider.Provider.Object.(*osinv1.RequestHeaderIdentityProvider); isRequestHeader {
 			// We might be redirecting to an external site, we need to fully resolve the request URL to the public master
@@ -418,12 +1280,25 @@ func (c *OAuthServerConfig) getAuthenticationHandler(mux oauthserver.Mux, errorH
 				return nil, err
 			}
 			if identityProvider.UseAsChallenger {
-				challengers["requestheader-"+identityProvider.Name+"-redirect"] = redirector.NewChallenger(baseRequestURL, requestHeaderProvider.ChallengeURL)
+				challengerName := "requestheader-" + identityProvider.Name + "-redirect"
+				challengers[challengerName] = redirector.NewChallenger(baseRequestURL, requestHeaderProvider.ChallengeURL)
+				challengerProviders[challengerName] = []string{identityProvider.Name}
 			}
 			if identityProvider.UseAsLogin {
-				redirectors.Add(identityProvider.Name, redirector.NewRedirector(baseRequestURL, requestHeaderProvider.LoginURL))
+				redirectors.AddWithDisplay(identityProvider.Name, redirector.NewRedirector(baseRequestURL, requestHeaderProvider.LoginURL), display)
 			}
 		}
+
+		if canary := c.ExtraOAuthConfig.ExtendedOptions.IdentityProvider(identityProvider.Name).Canary; canary != nil {
+			idpTopology.Policies["canary"] = canary.IdentityProvider
+		}
+
+		authTopology.AddIdentityProvider(idpTopology)
+	}
+
+	redirectors, err = c.withCanaries(redirectors)
+	if err != nil {
+		return nil, err
 	}
 
 	if redirectors.Count() > 0 && len(challengers) == 0 {
@@ -435,26 +1310,88 @@ func (c *OAuthServerConfig) getAuthenticationHandler(mux oauthserver.Mux, errorH
 	if c.ExtraOAuthConfig.Options.Templates != nil {
 		selectProviderTemplateFile = c.ExtraOAuthConfig.Options.Templates.ProviderSelection
 	}
-	selectProviderRenderer, err := selectprovider.NewSelectProviderRenderer(selectProviderTemplateFile)
+	selectProviderRenderer, err := selectprovider.NewSelectProviderRenderer(c.templateFile(selectProviderTemplateFile, theme.ProviderSelectionTemplate))
 	if err != nil {
 		return nil, err
 	}
 
-	selectProvider := selectprovider.NewSelectProvider(selectProviderRenderer, c.ExtraOAuthConfig.Options.AlwaysShowProviderSelection)
+	var remember *selectprovider.RememberOptions
+	if selection := c.ExtraOAuthConfig.ExtendedOptions.ProviderSelection; selection != nil && selection.RememberChoice {
+		remember = &selectprovider.RememberOptions{Cookie: c.ExtraOAuthConfig.CookieOptions, SkipSelection: selection.SkipSelection}
+		// the remembered provider is only skipped to if the cookie is signed
+		if keys := c.ExtraOAuthConfig.SessionKeys; keys != nil {
+			remember.Codec = keys
+		} else if selection.SkipSelection {
+			return nil, errors.New("skipping the provider selection requires a session config")
+		}
+	}
+	selectProvider := selectprovider.NewSelectProviderWithRemember(selectProviderRenderer, c.ExtraOAuthConfig.Options.AlwaysShowProviderSelection, remember)
 
 	// the bootstrap user IDP is always set as the first one when sessions are enabled
 	if c.ExtraOAuthConfig.Options.SessionConfig != nil {
 		selectProvider = selectprovider.NewBootstrapSelectProvider(selectProvider, c.ExtraOAuthConfig.BootstrapUserDataGetter)
 	}
 
-	authHandler := handlers.NewUnionAuthenticationHandler(challengers, redirectors, errorHandler, selectProvider)
+	var (
+		challengerPriority []string
+		challengerTimeout  time.Duration
+	)
+	if challengerConfig := c.ExtraOAuthConfig.ExtendedOptions.Challengers; challengerConfig != nil {
+		challengerPriority = challengerConfig.Order
+		challengerTimeout = challengerConfig.Timeout.Duration
+		for _, scheme := range challengerConfig.Schemes {
+			if _, ok := challengers[scheme.Name]; ok {
+				return nil, fmt.Errorf("challenge scheme %q has the name of another challenger", scheme.Name)
+			}
+			challengers[scheme.Name] = schemechallenger.New(scheme.Scheme, challengeParameters(scheme.Parameters)...)
+		}
+	}
+	for _, name := range challengerPriority {
+		if _, ok := challengers[name]; !ok {
+			klog.Warningf("Challenger %q of the challenger order is not configured", name)
+		}
+	}
+	restrictions := handlers.ClientRestrictions{
+		Challengers:                 map[string][]string{},
+		IdentityProviders:           c.clientIdentityProviders(),
+		ChallengerIdentityProviders: challengerProviders,
+	}
+	for _, client := range c.ExtraOAuthConfig.ExtendedOptions.Clients {
+		for _, name := range client.Challengers {
+			if _, ok := challengers[name]; !ok {
+				klog.Warningf("Challenger %q of client %q is not configured", name, client.Name)
+			}
+		}
+		if len(client.Challengers) > 0 {
+			restrictions.Challengers[client.Name] = client.Challengers
+		}
+	}
+
+	authTopology.Update(func(t *topology.Topology) {
+		t.Challengers = handlers.ChallengerOrder(challengers, challengerPriority)
+	})
+
+	authHandler := handlers.NewUnionAuthenticationHandlerWithClientRestrictions(challengers, challengerPriority, challengerTimeout, restrictions, redirectors, errorHandler, selectProvider)
+
+	// deep links to the identity providers, e.g. for buttons of the console
+	authorizePath := path.Join(oauthdiscovery.OpenShiftOAuthAPIPrefix, oauthdiscovery.AuthorizePath)
+	var bootstrapGetter bootstrap.BootstrapUserDataGetter
+	if c.ExtraOAuthConfig.Options.SessionConfig != nil {
+		bootstrapGetter = c.ExtraOAuthConfig.BootstrapUserDataGetter
+	}
+	providerLinks := selectprovider.NewLinks(authHandler.(handlers.ProviderLister), authorizePath, bootstrapGetter)
+	providerLinks.Install(mux, path.Join(authorizePath, openShiftProvidersSubpath))
+
+	if journeys != nil {
+		return journeys.AuthenticationHandler(authHandler), nil
+	}
 	return authHandler, nil
 }
 
 func (c *OAuthServerConfig) getOAuthProvider(identityProvider osinv1.IdentityProvider) (external.Provider, error) {
 	switch provider := identityProvider.Provider.Object.(type) {
 	case *osinv1.GitHubIdentityProvider:
-		transport, err := transportFor(provider.CA, "", "")
+		transport, err := c.ExtraOAuthConfig.getIdentityProviderTransport(provider.CA, "", "")
 		if err != nil {
 			return nil, err
 		}
@@ -462,10 +1399,16 @@ func (c *OAuthServerConfig) getOAuthProvider(identityProvider osinv1.IdentityPro
 		if err != nil {
 			return nil, err
 		}
-		return github.NewProvider(identityProvider.Name, provider.ClientID, clientSecret, provider.Hostname, transport, provider.Organizations, provider.Teams), nil
+		extension := c.ExtraOAuthConfig.ExtendedOptions.IdentityProvider(identityProvider.Name)
+		groups := extension.GroupSync != nil
+		revokeToken := extension.GitHub != nil && extension.GitHub.RevokeToken
+		if revokeToken && extension.KeepUpstreamTokens {
+			return nil, fmt.Errorf("Error configuring GitHubIdentityProvider %s: upstream tokens cannot be kept when the token is revoked after the login", identityProvider.Name)
+		}
+		return github.NewProvider(identityProvider.Name, provider.ClientID, clientSecret, provider.Hostname, transport, provider.Organizations, provider.Teams, groups, revokeToken), nil
 
 	case *osinv1.GitLabIdentityProvider:
-		transport, err := transportFor(provider.CA, "", "")
+		transport, err := c.ExtraOAuthConfig.getIdentityProviderTransport(provider.CA, "", "")
 		if err != nil {
 			return nil, err
 		}
@@ -473,10 +1416,14 @@ func (c *OAuthServerConfig) getOAuthProvider(identityProvider osinv1.IdentityPro
 		if err != nil {
 			return nil, err
 		}
+		// GitLab providers revoke the token once the user was read, there would be no token to keep
+		if c.ExtraOAuthConfig.ExtendedOptions.IdentityProvider(identityProvider.Name).KeepUpstreamTokens {
+			return nil, fmt.Errorf("Error configuring GitLabIdentityProvider %s: upstream tokens cannot be kept, the token is revoked after the login", identityProvider.Name)
+		}
 		return gitlab.NewProvider(identityProvider.Name, provider.URL, provider.ClientID, clientSecret, transport, provider.Legacy)
 
 	case *osinv1.GoogleIdentityProvider:
-		transport, err := transportFor("", "", "")
+		transport, err := c.ExtraOAuthConfig.getIdentityProviderTransport("", "", "")
 		if err != nil {
 			return nil, err
 		}
@@ -487,7 +1434,7 @@ func (c *OAuthServerConfig) getOAuthProvider(identityProvider osinv1.IdentityPro
 		return google.NewProvider(identityProvider.Name, provider.ClientID, clientSecret, provider.HostedDomain, transport)
 
 	case *osinv1.OpenIDIdentityProvider:
-		transport, err := transportFor(provider.CA, "", "")
+		transport, err := c.ExtraOAuthConfig.getIdentityProviderTransport(provider.CA, "", "")
 		if err != nil {
 			return nil, err
 		}
@@ -496,6 +1443,33 @@ func (c *OAuthServerConfig) getOAuthProvider(identityProvider osinv1.IdentityPro
 			return nil, err
 		}
 
+		if casExtension := c.ExtraOAuthConfig.ExtendedOptions.IdentityProvider(identityProvider.Name).CAS; casExtension != nil {
+			// CAS has no password grant
+			if identityProvider.UseAsChallenger {
+				return nil, fmt.Errorf("Error configuring OpenIDIdentityProvider %s: CAS cannot be used as challenger", identityProvider.Name)
+			}
+			return cas.NewProvider(identityProvider.Name, transport, cas.Config{
+				URL:                         casExtension.URL,
+				ClientID:                    provider.ClientID,
+				PreferredUsernameAttributes: casExtension.PreferredUsernameAttributes,
+				EmailAttributes:             casExtension.EmailAttributes,
+				NameAttributes:              casExtension.NameAttributes,
+				GroupAttributes:             casExtension.GroupAttributes,
+			})
+		}
+
+		if socialExtension := c.ExtraOAuthConfig.ExtendedOptions.IdentityProvider(identityProvider.Name).Social; socialExtension != nil {
+			return social.NewProvider(identityProvider.Name, social.Platform(socialExtension.Platform), provider.ClientID, clientSecret, socialExtension.Communities, transport)
+		}
+
+		vendorConfig, err := c.vendorOpenIDConfig(identityProvider.Name, provider, clientSecret)
+		if err != nil {
+			return nil, fmt.Errorf("Error configuring OpenIDIdentityProvider %s: %v", identityProvider.Name, err)
+		}
+		if vendorConfig != nil {
+			return c.getVendorOpenIDProvider(identityProvider.Name, *vendorConfig, transport)
+		}
+
 		// OpenID Connect requests MUST contain the openid scope value
 		// http://openid.net/specs/openid-connect-core-1_0.html#AuthRequest
 		scopes := sets.NewString("openid")
@@ -520,7 +1494,37 @@ func (c *OAuthServerConfig) getOAuthProvider(identityProvider osinv1.IdentityPro
 			GroupClaims:             provider.Claims.Groups,
 		}
 
-		return openid.NewProvider(identityProvider.Name, transport, config)
+		if openIDExtension := c.ExtraOAuthConfig.ExtendedOptions.IdentityProvider(identityProvider.Name).OpenID; openIDExtension != nil {
+			if err := applyOpenIDExtension(&config, openIDExtension, transport); err != nil {
+				return nil, fmt.Errorf("Error configuring OpenIDIdentityProvider %s: %v", identityProvider.Name, err)
+			}
+		}
+
+		// logout tokens name identities by their sub claim and must be signed
+		if openIDExtension := c.ExtraOAuthConfig.ExtendedOptions.IdentityProvider(identityProvider.Name).OpenID; openIDExtension != nil && openIDExtension.BackChannelLogout {
+			if len(config.JWKSURL) == 0 {
+				return nil, fmt.Errorf("Error configuring OpenIDIdentityProvider %s: back-channel logout requires a JWKS URL", identityProvider.Name)
+			}
+			if len(config.IDClaims) == 0 || config.IDClaims[0] != "sub" {
+				return nil, fmt.Errorf("Error configuring OpenIDIdentityProvider %s: back-channel logout requires sub as first ID claim", identityProvider.Name)
+			}
+		}
+
+		openIDProvider, err := openid.NewProvider(identityProvider.Name, transport, config)
+		if err != nil {
+			return nil, err
+		}
+		// the discovery document is fetched again, it may have changed since the server started
+		if openIDExtension := c.ExtraOAuthConfig.ExtendedOptions.IdentityProvider(identityProvider.Name).OpenID; openIDExtension != nil && len(openIDExtension.Issuer) > 0 {
+			c.ExtraOAuthConfig.addIdentityProviderDiagnosis(identityProvider.Name, idphealth.DiscoveryStep(openIDExtension.Issuer, transport))
+		}
+		if len(config.JWKSURL) > 0 {
+			c.ExtraOAuthConfig.addIdentityProviderDiagnosis(identityProvider.Name, idphealth.JWKSStep(config.JWKSURL, transport))
+		}
+		if len(config.EndSessionURL) > 0 {
+			c.ExtraOAuthConfig.addProviderLogout(identityProvider.Name, logout.ProviderLogout{EndSessionURL: config.EndSessionURL, ClientID: config.ClientID})
+		}
+		return openIDProvider, nil
 
 	default:
 		return nil, fmt.Errorf("No OAuth provider found that matches %v.  The OAuth server cannot start!", identityProvider)
@@ -528,16 +1532,141 @@ func (c *OAuthServerConfig) getOAuthProvider(identityProvider osinv1.IdentityPro
 
 }
 
-func (c *OAuthServerConfig) getPasswordAuthenticator(identityProvider osinv1.IdentityProvider) (openshiftauthenticator.PasswordAuthenticator, error) {
-	identityMapper, err := newIdentityUserMapperWithGroups(
-		c.ExtraOAuthConfig.IdentityClient,
-		c.ExtraOAuthConfig.UserClient,
-		c.ExtraOAuthConfig.GroupInformer,
-		c.ExtraOAuthConfig.GroupClient,
-		c.ExtraOAuthConfig.GroupLister,
-		c.ExtraOAuthConfig.UserIdentityMappingClient,
-		identitymapper.MappingMethodType(identityProvider.MappingMethod),
+// deprovisioningUpstreamTokens returns the vault for the revokers of users, which is nil, not a nil vault, if there is
+// no vault
+func deprovisioningUpstreamTokens(vault *upstreamtoken.Vault) deprovisioning.UpstreamTokens {
+	if vault == nil {
+		return nil
+	}
+	return vault
+}
+
+// upstreamTokenRefresher returns the refresher of the upstream tokens of an OAuth identity provider, which uses the
+// client of the provider
+func (c *OAuthServerConfig) upstreamTokenRefresher(name string, provider external.Provider) (upstreamtoken.Refresher, error) {
+	if c.ExtraOAuthConfig.UpstreamTokens == nil {
+		return nil, fmt.Errorf("identity provider %s keeps upstream tokens, but they are not configured", name)
+	}
+	// ticket providers issue no tokens
+	if _, ticketing := provider.(external.TicketProvider); ticketing {
+		return nil, fmt.Errorf("identity provider %s does not issue upstream tokens", name)
+	}
+	clientConfig, err := provider.NewConfig()
+	if err != nil {
+		return nil, err
+	}
+	// unused for refresh grants
+	clientConfig.RedirectUrl = "/"
+	client, err := osincli.NewClient(clientConfig)
+	if err != nil {
+		return nil, err
+	}
+	transport, err := provider.GetTransport()
+	if err != nil {
+		return nil, err
+	}
+	client.Transport = transport
+	return upstreamtoken.ClientRefresher{Client: client}, nil
+}
+
+// withIdentityCache returns the provider of an OAuth identity provider with the identities of access tokens cached,
+// if its extended config has an identity cache
+func (c *OAuthServerConfig) withIdentityCache(providerName string, provider external.Provider) external.Provider {
+	identityCache := c.ExtraOAuthConfig.ExtendedOptions.IdentityProvider(providerName).IdentityCache
+	if identityCache == nil {
+		return provider
+	}
+	ttl, maxEntries := identityCache.TTL.Duration, identityCache.MaxEntries
+	if ttl == 0 {
+		ttl = external.DefaultIdentityCacheTTL
+	}
+	if maxEntries == 0 {
+		maxEntries = external.DefaultIdentityCacheMaxEntries
+	}
+	return external.WithIdentityCache(providerName, provider, ttl, maxEntries)
+}
+
+// withAuthorizeSettings returns the provider of an OAuth identity provider with the scopes and parameters of the
+// authorize settings of its extended config
+func (c *OAuthServerConfig) withAuthorizeSettings(identityProvider osinv1.IdentityProvider, provider external.Provider) (external.Provider, error) {
+	extension := c.ExtraOAuthConfig.ExtendedOptions.IdentityProvider(identityProvider.Name)
+	authorize := extension.Authorize
+	if authorize == nil {
+		return provider, nil
+	}
+	// without the openid scope there is no ID token to identify users with
+	if _, isOpenID := identityProvider.Provider.Object.(*osinv1.OpenIDIdentityProvider); isOpenID && extension.Social == nil && len(authorize.Scopes) > 0 && !sets.NewString(authorize.Scopes...).Has("openid") {
+		return nil, fmt.Errorf("Error configuring OpenIDIdentityProvider %s: authorize scopes must include openid", identityProvider.Name)
+	}
+	return external.WithAuthorizeSettings(provider, authorize.Scopes, authorize.Parameters), nil
+}
+
+// vendorOpenIDConfig returns the config of an OpenID identity provider with the settings of a vendor, e.g. Auth0,
+// whose endpoints and claims are derived from these settings instead of the OpenID identity provider. The client and
+// the extra scopes and parameters are taken from the OpenID identity provider. It returns nil without vendor settings.
+func (c *OAuthServerConfig) vendorOpenIDConfig(name string, provider *osinv1.OpenIDIdentityProvider, clientSecret string) (*openid.Config, error) {
+	extension := c.ExtraOAuthConfig.ExtendedOptions.IdentityProvider(name)
+	var (
+		openIDConfig openid.Config
+		err          error
 	)
+	switch {
+	case extension.Auth0 != nil:
+		openIDConfig, err = auth0.NewOpenIDConfig(auth0.Config{
+			ClientID:                 provider.ClientID,
+			ClientSecret:             clientSecret,
+			Domain:                   extension.Auth0.Domain,
+			Connection:               extension.Auth0.Connection,
+			ConnectionClaim:          extension.Auth0.ConnectionClaim,
+			UserInfo:                 extension.Auth0.UserInfo,
+			ExtraScopes:              provider.ExtraScopes,
+			ExtraAuthorizeParameters: provider.ExtraAuthorizeParameters,
+		})
+	case extension.Cognito != nil:
+		openIDConfig, err = cognito.NewOpenIDConfig(cognito.Config{
+			ClientID:                 provider.ClientID,
+			ClientSecret:             clientSecret,
+			Domain:                   extension.Cognito.Domain,
+			UserPoolID:               extension.Cognito.UserPoolID,
+			ExtraScopes:              provider.ExtraScopes,
+			ExtraAuthorizeParameters: provider.ExtraAuthorizeParameters,
+		})
+	case extension.Keycloak != nil:
+		openIDConfig, err = keycloak.NewOpenIDConfig(keycloak.Config{
+			ClientID:                 provider.ClientID,
+			ClientSecret:             clientSecret,
+			URL:                      extension.Keycloak.URL,
+			Realm:                    extension.Keycloak.Realm,
+			SingleLogout:             extension.Keycloak.SingleLogout,
+			ExtraScopes:              provider.ExtraScopes,
+			ExtraAuthorizeParameters: provider.ExtraAuthorizeParameters,
+		})
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &openIDConfig, nil
+}
+
+// getVendorOpenIDProvider returns the provider of an OpenID identity provider with vendor settings
+func (c *OAuthServerConfig) getVendorOpenIDProvider(name string, openIDConfig openid.Config, transport http.RoundTripper) (external.Provider, error) {
+	openIDProvider, err := openid.NewProvider(name, transport, openIDConfig)
+	if err != nil {
+		return nil, err
+	}
+	if len(openIDConfig.JWKSURL) > 0 {
+		c.ExtraOAuthConfig.addIdentityProviderDiagnosis(name, idphealth.JWKSStep(openIDConfig.JWKSURL, transport))
+	}
+	if len(openIDConfig.EndSessionURL) > 0 {
+		c.ExtraOAuthConfig.addProviderLogout(name, logout.ProviderLogout{EndSessionURL: openIDConfig.EndSessionURL, ClientID: openIDConfig.ClientID})
+	}
+	return openIDProvider, nil
+}
+
+func (c *OAuthServerConfig) getPasswordAuthenticator(identityProvider osinv1.IdentityProvider) (openshiftauthenticator.PasswordAuthenticator, error) {
+	identityMapper, err := c.getIdentityMapper(identityProvider)
 	if err != nil {
 		return nil, err
 	}
@@ -559,6 +1688,10 @@ func (c *OAuthServerConfig) getPasswordAuthenticator(identityProvider osinv1.Ide
 		if err != nil {
 			return nil, err
 		}
+		ldapExtension := c.ExtraOAuthConfig.ExtendedOptions.IdentityProvider(identityProvider.Name).LDAP
+		if ldapExtension == nil {
+			ldapExtension = &config.LDAPExtension{}
+		}
 		clientConfig, err := ldapclient.NewLDAPClientConfig(provider.URL,
 			provider.BindDN,
 			bindPassword,
@@ -567,13 +1700,37 @@ func (c *OAuthServerConfig) getPasswordAuthenticator(identityProvider osinv1.Ide
 		if err != nil {
 			return nil, err
 		}
+		c.ExtraOAuthConfig.addIdentityProviderCheck(identityProvider.Name, idphealth.LDAPCheck(clientConfig))
+		c.ExtraOAuthConfig.addIdentityProviderDiagnosis(identityProvider.Name, idphealth.CheckStep("bind", idphealth.LDAPCheck(clientConfig)))
 
+		attributeDefiner := ldappassword.NewLDAPUserAttributeDefiner(provider.Attributes)
+		if revocation := c.ExtraOAuthConfig.ExtendedOptions.IdentityProvider(identityProvider.Name).CredentialsRevocation; revocation != nil && revocation.OnLogin {
+			attributeDefiner = attributeDefiner.WithCredentialsVersion(revocation.VersionAttribute)
+		}
 		opts := ldappassword.Options{
 			URL:                  url,
 			ClientConfig:         clientConfig,
-			UserAttributeDefiner: ldappassword.NewLDAPUserAttributeDefiner(provider.Attributes),
+			UserAttributeDefiner: attributeDefiner,
+			FollowReferrals:      ldapExtension.FollowReferrals,
+			MaxReferralHops:      ldapExtension.MaxReferralHops,
+			AllowedReferralHosts: ldapExtension.AllowedReferralHosts,
+			// referred servers are connected to with the same credentials and TLS settings
+			ReferralClientConfig: func(referral string) (ldapclient.Config, error) {
+				return ldapclient.NewLDAPClientConfig(referral, provider.BindDN, bindPassword, provider.CA, provider.Insecure)
+			},
+		}
+		if len(ldapExtension.RequiredGroups) > 0 || ldapExtension.SyncGroups {
+			groupAuthorization, err := ldapGroupAuthorization(ldapExtension)
+			if err != nil {
+				return nil, fmt.Errorf("Error configuring LDAPPasswordIdentityProvider group authorization: %v", err)
+			}
+			opts.GroupAuthorization = groupAuthorization
+		}
+		ldapAuth, err := ldappassword.New(identityProvider.Name, opts, identityMapper)
+		if err != nil {
+			return nil, err
 		}
-		return ldappassword.New(identityProvider.Name, opts, identityMapper)
+		return c.withOfflineFallback(identityProvider.Name, ldapAuth), nil
 
 	case *osinv1.HTPasswdPasswordIdentityProvider:
 		htpasswdFile := provider.File
@@ -583,6 +1740,14 @@ func (c *OAuthServerConfig) getPasswordAuthenticator(identityProvider osinv1.Ide
 		if htpasswordAuth, err := htpasswd.New(identityProvider.Name, htpasswdFile, identityMapper); err != nil {
 			return nil, fmt.Errorf("Error loading htpasswd file %s: %v", htpasswdFile, err)
 		} else {
+			htpasswordAuth.OnReload(func() { c.ExtraOAuthConfig.getTopology().Reloaded(identityProvider.Name) })
+			if revocation := c.ExtraOAuthConfig.ExtendedOptions.IdentityProvider(identityProvider.Name).CredentialsRevocation; revocation != nil && revocation.OnLogin {
+				htpasswordAuth.ReportCredentialsVersion()
+			}
+			c.ExtraOAuthConfig.addPostStartHook("openshift.io-htpasswd-"+identityProvider.Name, func(ctx genericapiserver.PostStartHookContext) error {
+				go htpasswordAuth.Run(htpasswd.DefaultPollInterval, ctx.StopCh)
+				return nil
+			})
 			return htpasswordAuth, nil
 		}
 
@@ -591,25 +1756,49 @@ func (c *OAuthServerConfig) getPasswordAuthenticator(identityProvider osinv1.Ide
 		if len(connectionInfo.URL) == 0 {
 			return nil, fmt.Errorf("URL is required for BasicAuthPasswordIdentityProvider")
 		}
-		transport, err := transportFor(connectionInfo.CA, connectionInfo.CertInfo.CertFile, connectionInfo.CertInfo.KeyFile)
+		transport, err := c.ExtraOAuthConfig.getIdentityProviderTransport(connectionInfo.CA, connectionInfo.CertInfo.CertFile, connectionInfo.CertInfo.KeyFile)
 		if err != nil {
 			return nil, fmt.Errorf("Error building BasicAuthPasswordIdentityProvider client: %v", err)
 		}
-		return basicauthpassword.New(identityProvider.Name, connectionInfo.URL, transport, identityMapper), nil
+		c.ExtraOAuthConfig.addIdentityProviderCheck(identityProvider.Name, idphealth.HTTPCheck(connectionInfo.URL, transport))
+		c.ExtraOAuthConfig.addIdentityProviderDiagnosis(identityProvider.Name,
+			idphealth.TLSStep(connectionInfo.URL, transport),
+			idphealth.CheckStep("reachability", idphealth.HTTPCheck(connectionInfo.URL, transport)),
+		)
+		fields := basicauthpassword.Fields{}
+		if basicAuthExtension := c.ExtraOAuthConfig.ExtendedOptions.IdentityProvider(identityProvider.Name).BasicAuth; basicAuthExtension != nil {
+			fields = basicauthpassword.Fields(basicAuthExtension.Fields)
+		}
+		return c.withOfflineFallback(identityProvider.Name, basicauthpassword.New(identityProvider.Name, connectionInfo.URL, transport, identityMapper, fields)), nil
 
 	case *osinv1.KeystonePasswordIdentityProvider:
 		connectionInfo := provider.RemoteConnectionInfo
 		if len(connectionInfo.URL) == 0 {
 			return nil, fmt.Errorf("URL is required for KeystonePasswordIdentityProvider")
 		}
-		transport, err := transportFor(connectionInfo.CA, connectionInfo.CertInfo.CertFile, connectionInfo.CertInfo.KeyFile)
+		transport, err := c.ExtraOAuthConfig.getIdentityProviderTransport(connectionInfo.CA, connectionInfo.CertInfo.CertFile, connectionInfo.CertInfo.KeyFile)
 		if err != nil {
 			return nil, fmt.Errorf("Error building KeystonePasswordIdentityProvider client: %v", err)
 		}
+		c.ExtraOAuthConfig.addIdentityProviderCheck(identityProvider.Name, idphealth.HTTPCheck(connectionInfo.URL, transport))
+		c.ExtraOAuthConfig.addIdentityProviderDiagnosis(identityProvider.Name,
+			idphealth.TLSStep(connectionInfo.URL, transport),
+			idphealth.CheckStep("reachability", idphealth.HTTPCheck(connectionInfo.URL, transport)),
+		)
+
+		options := keystonepassword.Options{}
+		if keystoneExtension := c.ExtraOAuthConfig.ExtendedOptions.IdentityProvider(identityProvider.Name).Keystone; keystoneExtension != nil {
+			options.ScopeDomainName = keystoneExtension.ScopeDomainName
+			options.ScopeProjectName = keystoneExtension.ScopeProjectName
+			options.ApplicationCredentials = keystoneExtension.ApplicationCredentials
+		}
 
-		return keystonepassword.New(identityProvider.Name, connectionInfo.URL, transport, provider.DomainName, identityMapper, provider.UseKeystoneIdentity), nil
+		return keystonepassword.New(identityProvider.Name, connectionInfo.URL, transport, provider.DomainName, identityMapper, provider.UseKeystoneIdentity, options), nil
 
 	case *config.BootstrapIdentityProvider:
+		if guard := c.ExtraOAuthConfig.BootstrapUserGuard; guard != nil {
+			return guard.Password(), nil
+		}
 		return bootstrap.New(c.ExtraOAuthConfig.BootstrapUserDataGetter), nil
 
 	default:
@@ -618,6 +1807,25 @@ func (c *OAuthServerConfig) getPasswordAuthenticator(identityProvider osinv1.Ide
 
 }
 
+// withOfflineFallback lets logins with the credentials of recent successful logins succeed while the provider is
+// unavailable, if it is configured for the provider
+func (c *OAuthServerConfig) withOfflineFallback(providerName string, auth openshiftauthenticator.PasswordAuthenticator) openshiftauthenticator.PasswordAuthenticator {
+	fallback := c.ExtraOAuthConfig.ExtendedOptions.IdentityProvider(providerName).OfflineFallback
+	if fallback == nil {
+		return auth
+	}
+	maxAge, maxEntries := fallback.MaxAge.Duration, fallback.MaxEntries
+	if maxAge == 0 {
+		maxAge = cachedpassword.DefaultMaxAge
+	}
+	if maxEntries == 0 {
+		maxEntries = cachedpassword.DefaultMaxEntries
+	}
+	// the login form and basic auth headers share the remembered credentials
+	credentials := c.ExtraOAuthConfig.getOfflineCredentials(providerName, maxAge, maxEntries)
+	return cachedpassword.New(providerName, auth, credentials)
+}
+
 // getAuthenticationRequestHandlerWithAudit wraps getAuthenticationRequestHandler's
 // authenticator.Request with audit logging with regartds to the final decision.
 func (c *OAuthServerConfig) getAuthenticationRequestHandlerWithAudit() (authenticator.Request, error) {
@@ -637,15 +1845,7 @@ func (c *OAuthServerConfig) getAuthenticationRequestHandler() (authenticator.Req
 	}
 
 	for _, identityProvider := range c.ExtraOAuthConfig.Options.IdentityProviders {
-		identityMapper, err := newIdentityUserMapperWithGroups(
-			c.ExtraOAuthConfig.IdentityClient,
-			c.ExtraOAuthConfig.UserClient,
-			c.ExtraOAuthConfig.GroupInformer,
-			c.ExtraOAuthConfig.GroupClient,
-			c.ExtraOAuthConfig.GroupLister,
-			c.ExtraOAuthConfig.UserIdentityMappingClient,
-			identitymapper.MappingMethodType(identityProvider.MappingMethod),
-		)
+		identityMapper, err := c.getIdentityMapper(identityProvider)
 		if err != nil {
 			return nil, err
 		}
@@ -655,19 +1855,25 @@ func (c *OAuthServerConfig) getAuthenticationRequestHandler() (authenticator.Req
 			if err != nil {
 				return nil, err
 			}
-			authRequestHandlers = append(authRequestHandlers, basicauthrequest.NewBasicAuthAuthentication(identityProvider.Name, passwordAuthenticator, true))
+			authRequestHandlers = append(authRequestHandlers, acr.NewMethodsRequest(c.authenticationMethods(identityProvider), handlers.NewIdentityProviderRequest(identityProvider.Name, basicauthrequest.NewBasicAuthAuthentication(identityProvider.Name, passwordAuthenticator, true))))
 
 		} else if identityProvider.UseAsChallenger && config.IsOAuthIdentityProvider(identityProvider) {
 			oauthProvider, err := c.getOAuthProvider(identityProvider)
 			if err != nil {
 				return nil, err
 			}
+			// the password grant requests the same scopes as the browser logins
+			oauthProvider, err = c.withAuthorizeSettings(identityProvider, oauthProvider)
+			if err != nil {
+				return nil, err
+			}
 			oauthPasswordAuthenticator, err := external.NewOAuthPasswordAuthenticator(oauthProvider, identityMapper)
 			if err != nil {
 				return nil, fmt.Errorf("unexpected error: %v", err)
 			}
 
-			authRequestHandlers = append(authRequestHandlers, basicauthrequest.NewBasicAuthAuthentication(identityProvider.Name, oauthPasswordAuthenticator, true))
+			// the password is checked with the password grant of the provider, which skips its other factors
+			authRequestHandlers = append(authRequestHandlers, acr.NewMethodsRequest([]string{acr.MethodPassword}, handlers.NewIdentityProviderRequest(identityProvider.Name, basicauthrequest.NewBasicAuthAuthentication(identityProvider.Name, oauthPasswordAuthenticator, true))))
 
 		} else {
 			switch provider := identityProvider.Provider.Object.(type) {
@@ -680,6 +1886,22 @@ func (c *OAuthServerConfig) getAuthenticationRequestHandler() (authenticator.Req
 					EmailHeaders:             provider.EmailHeaders,
 					PreferredUsernameHeaders: provider.PreferredUsernameHeaders,
 				}
+				if requestHeaderExtension := c.ExtraOAuthConfig.ExtendedOptions.IdentityProvider(identityProvider.Name).RequestHeader; requestHeaderExtension != nil {
+					authRequestConfig.GroupHeaders = requestHeaderExtension.GroupHeaders
+					authRequestConfig.GroupSeparator = ","
+					if requestHeaderExtension.GroupSeparator != nil {
+						authRequestConfig.GroupSeparator = *requestHeaderExtension.GroupSeparator
+					}
+					authRequestConfig.GroupPrefix = requestHeaderExtension.GroupPrefix
+					authRequestConfig.ExtraHeaderPrefixes = requestHeaderExtension.ExtraHeaderPrefixes
+					if assertion := requestHeaderExtension.Assertion; assertion != nil {
+						verifier, err := c.getAssertionVerifier(identityProvider.Name, assertion)
+						if err != nil {
+							return nil, err
+						}
+						authRequestConfig.Assertion = verifier
+					}
+				}
 				authRequestHandler = headerrequest.NewAuthenticator(identityProvider.Name, authRequestConfig, identityMapper)
 
 				// Wrap with an x509 verifier
@@ -704,40 +1926,187 @@ func (c *OAuthServerConfig) getAuthenticationRequestHandler() (authenticator.Req
 
 					authRequestHandler = x509request.NewVerifier(opts, authRequestHandler, sets.NewString(provider.ClientCommonNames...))
 				}
-				authRequestHandlers = append(authRequestHandlers, authRequestHandler)
+				authRequestHandlers = append(authRequestHandlers, acr.NewMethodsRequest(c.authenticationMethods(identityProvider), handlers.NewIdentityProviderRequest(identityProvider.Name, authRequestHandler)))
 
 			}
 		}
 	}
 
-	authRequestHandler := union.New(authRequestHandlers...)
+	// only requests with basic auth credentials are throttled
+	authRequestHandler := c.ExtraOAuthConfig.BasicAuthThrottle.Authenticator(union.New(authRequestHandlers...))
 	return authRequestHandler, nil
 }
 
-func newIdentityUserMapperWithGroups(
-	identities userclient.IdentityInterface,
-	users userclient.UserInterface,
-	groupsInformer userinformer.GroupInformer,
-	groups userclient.GroupInterface,
-	groupsLister userlisterv1.GroupLister,
-	userIdentityMapping userclient.UserIdentityMappingInterface,
-	method identitymapper.MappingMethodType,
-) (api.UserIdentityMapper, error) {
+// getAssertionVerifier returns the verifier of the signed assertions of an authenticating proxy, which are issued for
+// the public URL of the server unless other audiences are configured
+func (c *OAuthServerConfig) getAssertionVerifier(providerName string, assertion *config.ProxyAssertion) (*headerrequest.AssertionVerifier, error) {
+	data, err := ioutil.ReadFile(assertion.JWKSFile)
+	if err != nil {
+		return nil, err
+	}
+	keys := jose.JSONWebKeySet{}
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("error parsing assertion JWKS of identity provider %q: %v", providerName, err)
+	}
+	if len(keys.Keys) == 0 {
+		return nil, fmt.Errorf("assertion JWKS of identity provider %q has no keys", providerName)
+	}
+	audiences := assertion.Audiences
+	if len(audiences) == 0 {
+		audiences = []string{c.ExtraOAuthConfig.Options.MasterPublicURL}
+	}
+	maxAge := assertion.MaxAge.Duration
+	if maxAge == 0 {
+		maxAge = defaultAssertionMaxAge
+	}
+	return headerrequest.NewAssertionVerifier(assertion.Header, keys, assertion.Issuer, audiences, maxAge), nil
+}
+
+// getProvisioningMapper returns the mapper that provisions the users of the identities of the given provider with its
+// mapping method
+func (c *OAuthServerConfig) getProvisioningMapper(identityProvider osinv1.IdentityProvider) (api.UserIdentityMapper, error) {
+	var usernameTemplate *identitymapper.UsernameTemplate
+	if template := c.ExtraOAuthConfig.ExtendedOptions.IdentityProvider(identityProvider.Name).UsernameTemplate; len(template) > 0 {
+		var err error
+		if usernameTemplate, err = identitymapper.NewUsernameTemplate(template); err != nil {
+			return nil, fmt.Errorf("identity provider %q: %v", identityProvider.Name, err)
+		}
+	}
+
 	userMapper, err := identitymapper.NewIdentityUserMapper(
-		identities,
-		users,
-		userIdentityMapping,
-		method,
+		c.ExtraOAuthConfig.IdentityClient,
+		c.ExtraOAuthConfig.UserClient,
+		c.ExtraOAuthConfig.UserIdentityMappingClient,
+		identitymapper.MappingMethodType(identityProvider.MappingMethod),
+		usernameTemplate,
+		c.ExtraOAuthConfig.getProvisioningBreaker(),
 	)
+	if err != nil {
+		return nil, fmt.Errorf("identity provider %q: %v", identityProvider.Name, err)
+	}
+	return userMapper, nil
+}
+
+// getIdentityMapper returns the mapper from identities of the given provider to users. Identities are transformed
+// first and checked against the access lists of the provider, and their groups are only synchronized once the
+// identity authorization webhook, if any, allowed the login.
+func (c *OAuthServerConfig) getIdentityMapper(identityProvider osinv1.IdentityProvider) (api.UserIdentityMapper, error) {
+	extension := c.ExtraOAuthConfig.ExtendedOptions.IdentityProvider(identityProvider.Name)
+
+	userMapper, err := c.getProvisioningMapper(identityProvider)
 	if err != nil {
 		return nil, err
 	}
 
-	return groupmapper.NewUserGroupsMapper(
+	// only logins use the cached users, SCIM provisions with the provisioning mapper itself
+	if mappingCache := extension.MappingCache; mappingCache != nil {
+		ttl, maxEntries := mappingCache.TTL.Duration, mappingCache.MaxEntries
+		if ttl == 0 {
+			ttl = identitymapper.DefaultMappingCacheTTL
+		}
+		if maxEntries == 0 {
+			maxEntries = identitymapper.DefaultMappingCacheMaxEntries
+		}
+		userMapper = identitymapper.NewCachingIdentityMapper(identityProvider.Name, userMapper, ttl, maxEntries)
+	}
+
+	if webhook := c.ExtraOAuthConfig.IdentityAuthorizationWebhook; webhook != nil {
+		userMapper = identityauthorization.NewUserMapper(userMapper, webhook)
+	}
+
+	var groupOptions groupmapper.Options
+	if groupSync := extension.GroupSync; groupSync != nil {
+		groupOptions = groupmapper.Options{Prefix: groupSync.Prefix, ExtraGroups: true}
+	}
+	userMapper = groupmapper.NewUserGroupsMapper(
 		userMapper,
-		groupsInformer,
-		groups,
-		groupsLister,
+		c.ExtraOAuthConfig.GroupInformer,
+		c.ExtraOAuthConfig.GroupClient,
+		c.ExtraOAuthConfig.GroupLister,
+		groupOptions,
+	)
+
+	if len(extension.UserExtra) > 0 {
+		keys := map[string]string{}
+		for _, userExtra := range extension.UserExtra {
+			keys[userExtra.Attribute] = userExtra.Key
+		}
+		userMapper = userextra.NewUserMapper(userMapper, keys)
+	}
+
+	// identities deactivated by the provider are rejected before users are provisioned for them
+	if extension.SCIM != nil {
+		userMapper = scim.NewUserMapper(userMapper, c.ExtraOAuthConfig.IdentityClient)
+	}
+
+	// access lists reject identities before users are provisioned for them
+	if access := extension.Access; access != nil {
+		accessList := identityauthorization.AccessList(*access)
+		if err := accessList.Validate(); err != nil {
+			return nil, fmt.Errorf("identity provider %q: %v", identityProvider.Name, err)
+		}
+		userMapper = identityauthorization.NewAccessListMapper(userMapper, &accessList)
+	}
+
+	// credentials versions are only recorded once everything else allowed the login, and they are never stored in identities
+	if revocation := extension.CredentialsRevocation; revocation != nil && revocation.OnLogin {
+		switch identityProvider.Provider.Object.(type) {
+		case *osinv1.HTPasswdPasswordIdentityProvider:
+		case *osinv1.LDAPPasswordIdentityProvider:
+			if len(revocation.VersionAttribute) == 0 {
+				return nil, fmt.Errorf("identity provider %q: credentials revocation on login requires a version attribute", identityProvider.Name)
+			}
+		default:
+			return nil, fmt.Errorf("identity provider %q: credentials revocation on login is only supported by htpasswd and LDAP providers", identityProvider.Name)
+		}
+		revoker := deprovisioning.NewRevoker(c.ExtraOAuthConfig.OAuthAccessTokenClient, c.ExtraOAuthConfig.OAuthAuthorizeTokenClient, c.ExtraOAuthConfig.SessionRevocations)
+		userMapper = credentialsversion.NewUserMapper(userMapper, c.ExtraOAuthConfig.UserClient, revoker)
+	}
+
+	// transformations change the identity, including its groups, before anything else sees it
+	if transform := extension.Transform; transform != nil {
+		rules, err := identitytransform.NewRules(transform.Username, transform.DisplayName, transform.Email, transform.Groups)
+		if err != nil {
+			return nil, fmt.Errorf("identity provider %q: %v", identityProvider.Name, err)
+		}
+		userMapper = identitytransform.NewUserMapper(userMapper, rules)
+	}
+
+	// the bootstrap user is only disabled once the login succeeded
+	if guard := c.ExtraOAuthConfig.BootstrapUserGuard; guard != nil && c.ExtraOAuthConfig.ExtendedOptions.BootstrapUser.DisableAfterIdentityProviderLogin {
+		userMapper = guard.UserIdentityMapper(userMapper)
+	}
+
+	return userMapper, nil
+}
+
+// getSCIMServer returns the SCIM endpoint of the identity provider. New SCIM users are provisioned by the identity
+// mapper without the checks of logins, e.g. access lists, and without the side effects, e.g. group synchronization.
+func (c *OAuthServerConfig) getSCIMServer(identityProvider osinv1.IdentityProvider, scimConfig *config.SCIM) (*scim.Server, error) {
+	token, err := ioutil.ReadFile(scimConfig.TokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("identity provider %q: unable to read SCIM token: %v", identityProvider.Name, err)
+	}
+	if len(bytes.TrimSpace(token)) == 0 {
+		return nil, fmt.Errorf("identity provider %q: SCIM token file %s is empty", identityProvider.Name, scimConfig.TokenFile)
+	}
+	provisioner, err := c.getProvisioningMapper(identityProvider)
+	if err != nil {
+		return nil, err
+	}
+	revoker := deprovisioning.NewRevokerWithOptions(c.ExtraOAuthConfig.OAuthAccessTokenClient, c.ExtraOAuthConfig.OAuthAuthorizeTokenClient, deprovisioning.RevokerOptions{
+		Sessions:       c.ExtraOAuthConfig.SessionRevocations,
+		UpstreamTokens: deprovisioningUpstreamTokens(c.ExtraOAuthConfig.UpstreamTokens),
+	})
+	return scim.NewServer(
+		identityProvider.Name,
+		string(bytes.TrimSpace(token)),
+		scimConfig.GroupPrefix,
+		provisioner,
+		c.ExtraOAuthConfig.UserClient,
+		c.ExtraOAuthConfig.IdentityClient,
+		c.ExtraOAuthConfig.GroupClient,
+		revoker,
 	), nil
 }
 
@@ -749,18 +2118,102 @@ type callbackPasswordAuthenticator struct {
 }
 
 // redirectSuccessHandler redirects to the then param on successful authentication
-type redirectSuccessHandler struct{}
+type redirectSuccessHandler struct {
+	redirects *redirect.Validator
+}
 
 // AuthenticationSucceeded informs client when authentication was successful
-func (redirectSuccessHandler) AuthenticationSucceeded(user kuser.Info, then string, w http.ResponseWriter, req *http.Request) (bool, error) {
+func (h redirectSuccessHandler) AuthenticationSucceeded(user kuser.Info, then string, w http.ResponseWriter, req *http.Request) (bool, error) {
 	if len(then) == 0 {
 		return false, fmt.Errorf("Auth succeeded, but no redirect existed - user=%#v", user)
 	}
+	then, err := h.redirects.Next(then)
+	if err != nil {
+		return false, err
+	}
 
 	http.Redirect(w, req, then, http.StatusFound)
 	return true, nil
 }
 
+// applyOpenIDExtension replaces the endpoints of an OpenID provider with the ones from a pinned or
+// discovered metadata document and applies individual endpoint overrides on top
+func applyOpenIDExtension(openIDConfig *openid.Config, openIDExtension *config.OpenIDExtension, transport http.RoundTripper) error {
+	var metadata *openid.Metadata
+	var err error
+	switch {
+	case len(openIDExtension.DiscoveryDocument) > 0:
+		metadata, err = openid.ReadMetadata(openIDExtension.Issuer, openIDExtension.DiscoveryDocument)
+	case len(openIDExtension.Issuer) > 0:
+		metadata, err = openid.DiscoverMetadata(openIDExtension.Issuer, transport)
+	}
+	if err != nil {
+		return err
+	}
+	if metadata != nil {
+		metadata.Apply(openIDConfig)
+	}
+
+	if len(openIDExtension.TokenURL) > 0 {
+		openIDConfig.TokenURL = openIDExtension.TokenURL
+	}
+	if len(openIDExtension.UserInfoURL) > 0 {
+		openIDConfig.UserInfoURL = openIDExtension.UserInfoURL
+	}
+	if len(openIDExtension.JWKSURL) > 0 {
+		openIDConfig.JWKSURL = openIDExtension.JWKSURL
+	}
+	if len(openIDExtension.EndSessionURL) > 0 {
+		openIDConfig.EndSessionURL = openIDExtension.EndSessionURL
+	}
+	openIDConfig.DomainHint = openIDExtension.DomainHint
+	openIDConfig.ExtraClaims = openIDExtension.ExtraClaims
+	return nil
+}
+
+// ldapGroupAuthorization converts the group settings of an LDAP identity provider, applying defaults
+func ldapGroupAuthorization(ldapExtension *config.LDAPExtension) (*ldappassword.GroupAuthorization, error) {
+	groupAuthorization := &ldappassword.GroupAuthorization{
+		RequiredGroups:      ldapExtension.RequiredGroups,
+		AllGroups:           ldapExtension.SyncGroups,
+		MembershipAttribute: ldapExtension.GroupMembershipAttribute,
+	}
+
+	groupSearch := ldapExtension.GroupSearch
+	if groupSearch == nil {
+		if len(groupAuthorization.MembershipAttribute) == 0 {
+			groupAuthorization.MembershipAttribute = "memberOf"
+		}
+		return groupAuthorization, nil
+	}
+
+	if len(groupSearch.BaseDN) == 0 {
+		return nil, errors.New("groupSearch.baseDN is required")
+	}
+	scope, err := ldaputil.DetermineLDAPScope(groupSearch.Scope)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := ldaputil.DetermineLDAPFilter(groupSearch.Filter)
+	if err != nil {
+		return nil, err
+	}
+	groupAuthorization.GroupSearch = &ldappassword.GroupSearch{
+		BaseDN:          groupSearch.BaseDN,
+		Scope:           scope,
+		Filter:          filter,
+		MemberAttribute: groupSearch.MemberAttribute,
+		NameAttribute:   groupSearch.NameAttribute,
+	}
+	if len(groupAuthorization.GroupSearch.MemberAttribute) == 0 {
+		groupAuthorization.GroupSearch.MemberAttribute = "member"
+	}
+	if len(groupAuthorization.GroupSearch.NameAttribute) == 0 {
+		groupAuthorization.GroupSearch.NameAttribute = "cn"
+	}
+	return groupAuthorization, nil
+}
+
 // transportFor returns an http.Transport for the given ca and client cert (which may be empty strings)
 func transportFor(ca, certFile, keyFile string) (http.RoundTripper, error) {
 	transport, err := transportForInner(ca, certFile, keyFile)
@@ -775,14 +2228,55 @@ func transportForInner(ca, certFile, keyFile string) (http.RoundTripper, error)
 		return http.DefaultTransport, nil
 	}
 
+	// Copy default transport
+	transport, err := tlsTransportFor(ca, certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return knet.SetTransportDefaults(transport), nil
+}
+
+// identityProviderTransportFor returns a transport for identity providers with the given ca and client cert (which may
+// be empty strings), whose pool of idle connections and TLS sessions are sized for high login rates unless connections
+// say otherwise
+func identityProviderTransportFor(ca, certFile, keyFile string, connections *config.IdentityProviderConnections) (http.RoundTripper, error) {
+	transport, err := tlsTransportFor(ca, certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	if connections == nil {
+		connections = &config.IdentityProviderConnections{}
+	}
+
+	// the default of two idle connections per host closes most connections of concurrent logins after their request
+	transport.MaxIdleConnsPerHost = defaultIdentityProviderMaxIdleConnsPerHost
+	if connections.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = connections.MaxIdleConnsPerHost
+	}
+	transport.IdleConnTimeout = connections.IdleConnTimeout.Duration
+	sessionCacheSize := defaultIdentityProviderTLSSessionCacheSize
+	if connections.TLSSessionCacheSize > 0 {
+		sessionCacheSize = connections.TLSSessionCacheSize
+	}
+	transport.TLSClientConfig.ClientSessionCache = tls.NewLRUClientSessionCache(sessionCacheSize)
+	if connections.DisableHTTP2 {
+		// without h2 among the protocols the transport is not configured for HTTP/2
+		transport.TLSClientConfig.NextProtos = []string{"http/1.1"}
+	}
+
+	return ktransport.DebugWrappers(knet.SetTransportDefaults(transport)), nil
+}
+
+// tlsTransportFor returns an http.Transport without defaults for the given ca and client cert (which may be empty
+// strings)
+func tlsTransportFor(ca, certFile, keyFile string) (*http.Transport, error) {
 	if (len(certFile) == 0) != (len(keyFile) == 0) {
 		return nil, errors.New("certFile and keyFile must be specified together")
 	}
 
-	// Copy default transport
-	transport := knet.SetTransportDefaults(&http.Transport{
+	transport := &http.Transport{
 		TLSClientConfig: &tls.Config{},
-	})
+	}
 
 	if len(ca) != 0 {
 		roots, err := cert.NewPool(ca)
@@ -0,0 +1,212 @@
+package oauthserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	osinv1 "github.com/openshift/api/osin/v1"
+
+	"github.com/openshift/oauth-server/pkg/config"
+	"github.com/openshift/oauth-server/pkg/server/pathprefix"
+	"github.com/openshift/oauth-server/pkg/topology"
+)
+
+type issuerKeyType int
+
+const issuerKey issuerKeyType = iota
+
+// issuerRoute selects the requests of an issuer by the host and path of its URL
+type issuerRoute struct {
+	name string
+	host string
+	// prefix is the path of the issuer URL without the path prefix of the server, it is removed from requests
+	prefix string
+}
+
+// matches returns true if req is sent to the URL of the issuer
+func (r issuerRoute) matches(req *http.Request) bool {
+	if !strings.EqualFold(req.Host, r.host) {
+		return false
+	}
+	return len(r.prefix) == 0 || req.URL.Path == r.prefix || strings.HasPrefix(req.URL.Path, r.prefix+"/")
+}
+
+// issuerRoutes returns the routes of the issuers, the ones with the longest prefixes first
+func issuerRoutes(extendedConfig *config.ExtendedOAuthConfig) ([]issuerRoute, error) {
+	serverPrefix := ""
+	if externalURLs := extendedConfig.ExternalURLs; externalURLs != nil {
+		serverPrefix = externalURLs.PathPrefix
+	}
+	routes := []issuerRoute{}
+	for _, issuer := range extendedConfig.Issuers {
+		u, err := url.Parse(issuer.URL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid URL of issuer %s: %v", issuer.Name, err)
+		}
+		issuerPath := strings.TrimSuffix(u.Path, "/")
+		if issuerPath != serverPrefix && !strings.HasPrefix(issuerPath, serverPrefix+"/") {
+			return nil, fmt.Errorf("the path of the URL of issuer %s must start with the path prefix %q", issuer.Name, serverPrefix)
+		}
+		routes = append(routes, issuerRoute{name: issuer.Name, host: u.Host, prefix: strings.TrimPrefix(issuerPath, serverPrefix)})
+	}
+	sort.SliceStable(routes, func(i, j int) bool {
+		return len(routes[i].prefix) > len(routes[j].prefix)
+	})
+	return routes, nil
+}
+
+// withIssuerSelection records the issuer of requests and removes the path of its URL before requests are
+// authorized, so that the paths of the OAuth endpoints are always allowed
+func withIssuerSelection(handler http.Handler, routes []issuerRoute) http.Handler {
+	prefixed := map[string]http.Handler{}
+	for _, route := range routes {
+		prefixed[route.name] = handler
+		if len(route.prefix) > 0 {
+			prefixed[route.name] = pathprefix.WithPathPrefix(handler, route.prefix)
+		}
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		for _, route := range routes {
+			if route.matches(req) {
+				req = req.WithContext(context.WithValue(req.Context(), issuerKey, route.name))
+				prefixed[route.name].ServeHTTP(w, req)
+				return
+			}
+		}
+		handler.ServeHTTP(w, req)
+	})
+}
+
+// withIssuers returns the OAuth handlers of the default issuer and the issuers of the extended config. The handlers
+// of an issuer serve the requests that were selected for it, the ones of the default issuer all other requests.
+func (c *OAuthServerConfig) withIssuers(handler http.Handler) (http.Handler, error) {
+	issuers := c.ExtraOAuthConfig.ExtendedOptions.Issuers
+	if len(issuers) == 0 {
+		return c.WithOAuth(handler)
+	}
+
+	byIssuer := map[string]http.Handler{}
+	assigned := sets.NewString()
+	for _, issuer := range issuers {
+		issuerConfig, err := c.issuerConfig(issuer)
+		if err != nil {
+			return nil, err
+		}
+		issuerHandler, err := issuerConfig.WithOAuth(handler)
+		if err != nil {
+			return nil, fmt.Errorf("issuer %s: %v", issuer.Name, err)
+		}
+		byIssuer[issuer.Name] = issuerHandler
+		assigned.Insert(issuer.IdentityProviders...)
+
+		// the hooks and health checks of issuers run with the ones of the default issuer
+		for name, hook := range issuerConfig.ExtraOAuthConfig.postStartHooks {
+			c.ExtraOAuthConfig.addPostStartHook(name, hook)
+		}
+		for name, check := range issuerConfig.ExtraOAuthConfig.identityProviderChecks {
+			c.ExtraOAuthConfig.addIdentityProviderCheck(name, check)
+		}
+		for name, steps := range issuerConfig.ExtraOAuthConfig.identityProviderDiagnoses {
+			c.ExtraOAuthConfig.addIdentityProviderDiagnosis(name, steps...)
+		}
+	}
+
+	// the default issuer shares the state of c, its handlers only differ by the identity providers
+	defaultConfig := *c
+	defaultConfig.ExtraOAuthConfig.Options.IdentityProviders = nil
+	for _, identityProvider := range c.ExtraOAuthConfig.Options.IdentityProviders {
+		if !assigned.Has(identityProvider.Name) {
+			defaultConfig.ExtraOAuthConfig.Options.IdentityProviders = append(defaultConfig.ExtraOAuthConfig.Options.IdentityProviders, identityProvider)
+		}
+	}
+	defaultConfig.ExtraOAuthConfig.getTopology().Update(func(t *topology.Topology) {
+		t.Issuers = sets.StringKeySet(byIssuer).List()
+	})
+	defaultHandler, err := defaultConfig.WithOAuth(handler)
+	if err != nil {
+		return nil, err
+	}
+	c.ExtraOAuthConfig.postStartHooks = defaultConfig.ExtraOAuthConfig.postStartHooks
+	c.ExtraOAuthConfig.providerLogouts = defaultConfig.ExtraOAuthConfig.providerLogouts
+	c.ExtraOAuthConfig.identityProviderChecks = defaultConfig.ExtraOAuthConfig.identityProviderChecks
+	c.ExtraOAuthConfig.identityProviderDiagnoses = defaultConfig.ExtraOAuthConfig.identityProviderDiagnoses
+	c.ExtraOAuthConfig.topology = defaultConfig.ExtraOAuthConfig.topology
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if name, ok := req.Context().Value(issuerKey).(string); ok {
+			byIssuer[name].ServeHTTP(w, req)
+			return
+		}
+		defaultHandler.ServeHTTP(w, req)
+	}), nil
+}
+
+// issuerConfig returns the config of the handlers of issuer. It shares the clients, keys and secrets of c, the
+// hooks, provider logouts, health checks and topology recorded while the handlers are built are its own.
+func (c *OAuthServerConfig) issuerConfig(issuer config.Issuer) (*OAuthServerConfig, error) {
+	// the issuers share the API server, and with it the breaker of user provisioning
+	c.ExtraOAuthConfig.getProvisioningBreaker()
+	issuerConfig := &OAuthServerConfig{
+		GenericConfig:    c.GenericConfig,
+		ExtraOAuthConfig: c.ExtraOAuthConfig,
+	}
+	extra := &issuerConfig.ExtraOAuthConfig
+	extra.postStartHooks = nil
+	extra.providerLogouts = nil
+	extra.identityProviderChecks = nil
+	extra.identityProviderDiagnoses = nil
+	extra.offlineCredentials = nil
+	extra.topology = nil
+	// the default issuer checks and diagnoses the identity providers of all issuers and serves the results
+	extra.IdentityProviderHealth = nil
+	// the default issuer reconciles the defined OAuth clients, they are shared by the issuers
+	extra.ExtendedOptions.OAuthClients = nil
+
+	extra.Options.MasterPublicURL = issuer.URL
+	extra.Options.LoginURL = issuer.URL
+	// the alternate hostnames belong to the masterPublicURL
+	extra.ExtendedOptions.ExternalURLs = nil
+
+	extra.Options.IdentityProviders = nil
+	byName := map[string]osinv1.IdentityProvider{}
+	for _, identityProvider := range c.ExtraOAuthConfig.Options.IdentityProviders {
+		byName[identityProvider.Name] = identityProvider
+	}
+	for _, name := range issuer.IdentityProviders {
+		identityProvider, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("issuer %s: unknown identity provider %s", issuer.Name, name)
+		}
+		extra.Options.IdentityProviders = append(extra.Options.IdentityProviders, identityProvider)
+	}
+
+	if maxAge := issuer.AccessTokenMaxAgeSeconds; maxAge != nil {
+		extra.Options.TokenConfig.AccessTokenMaxAgeSeconds = *maxAge
+	}
+	if maxAge := issuer.AuthorizeTokenMaxAgeSeconds; maxAge != nil {
+		extra.Options.TokenConfig.AuthorizeTokenMaxAgeSeconds = *maxAge
+	}
+	if timeout := issuer.AccessTokenInactivityTimeout; timeout != nil {
+		extra.Options.TokenConfig.AccessTokenInactivityTimeout = timeout
+	}
+
+	if issuer.Theme != nil {
+		pageTheme, err := buildTheme(issuer.Theme)
+		if err != nil {
+			return nil, fmt.Errorf("issuer %s: %v", issuer.Name, err)
+		}
+		extra.Theme = pageTheme
+		extra.Options.Templates = nil
+	}
+
+	if extra.SessionAuth != nil {
+		extra.SessionAuth = c.ExtraOAuthConfig.issuerSessions[issuer.Name]
+	}
+	return issuerConfig, nil
+}
@@ -0,0 +1,135 @@
+package oauthserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	fakekube "k8s.io/client-go/kubernetes/fake"
+
+	osinv1 "github.com/openshift/api/osin/v1"
+	fakeoauthclient "github.com/openshift/client-go/oauth/clientset/versioned/fake"
+	fakeuserclient "github.com/openshift/client-go/user/clientset/versioned/fake"
+	userinformer "github.com/openshift/client-go/user/informers/externalversions"
+
+	"github.com/openshift/oauth-server/pkg/config"
+	"github.com/openshift/oauth-server/pkg/topology"
+	"github.com/openshift/oauth-server/pkg/userregistry/identitymapper"
+)
+
+func TestIssuers(t *testing.T) {
+	identityProvider := func(name string) osinv1.IdentityProvider {
+		return osinv1.IdentityProvider{
+			Name:            name,
+			UseAsChallenger: true,
+			MappingMethod:   string(identitymapper.MappingMethodClaim),
+			Provider:        runtime.RawExtension{Object: &osinv1.AllowAllPasswordIdentityProvider{}},
+		}
+	}
+	accessTokenMaxAge := int32(600)
+	extendedConfig := config.ExtendedOAuthConfig{
+		Issuers: []config.Issuer{
+			{Name: "tenant-a", URL: "https://oauth.example.com/tenant-a", IdentityProviders: []string{"a"}, AccessTokenMaxAgeSeconds: &accessTokenMaxAge},
+			{Name: "tenant-b", URL: "https://tenant-b.example.com", IdentityProviders: []string{"b"}},
+		},
+	}
+	informer := userinformer.NewSharedInformerFactory(fakeuserclient.NewSimpleClientset(), 30*time.Second)
+	c := &OAuthServerConfig{
+		ExtraOAuthConfig: ExtraOAuthConfig{
+			Options: osinv1.OAuthConfig{
+				MasterPublicURL:   "https://oauth.example.com",
+				IdentityProviders: []osinv1.IdentityProvider{identityProvider("default"), identityProvider("a"), identityProvider("b")},
+				GrantConfig:       osinv1.GrantConfig{Method: osinv1.GrantHandlerAuto},
+				TokenConfig:       osinv1.TokenConfig{AccessTokenMaxAgeSeconds: 86400},
+			},
+			ExtendedOptions:   extendedConfig,
+			KubeClient:        fakekube.NewSimpleClientset(),
+			OAuthClientClient: fakeoauthclient.NewSimpleClientset().OauthV1().OAuthClients(),
+			GroupInformer:     informer.User().V1().Groups(),
+		},
+	}
+
+	oauthHandler, err := c.withIssuers(http.NotFoundHandler())
+	if err != nil {
+		t.Fatal(err)
+	}
+	routes, err := issuerRoutes(&extendedConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := withIssuerSelection(oauthHandler, routes)
+
+	testCases := []struct {
+		name                      string
+		host                      string
+		path                      string
+		expectedIdentityProviders []string
+		expectedAccessTokenMaxAge int32
+		expectedIssuers           []string
+	}{
+		{
+			name:                      "default issuer",
+			host:                      "oauth.example.com",
+			path:                      authTopologyPath,
+			expectedIdentityProviders: []string{"default"},
+			expectedAccessTokenMaxAge: 86400,
+			expectedIssuers:           []string{"tenant-a", "tenant-b"},
+		},
+		{
+			name:                      "issuer with path",
+			host:                      "oauth.example.com",
+			path:                      "/tenant-a" + authTopologyPath,
+			expectedIdentityProviders: []string{"a"},
+			expectedAccessTokenMaxAge: 600,
+		},
+		{
+			name:                      "issuer with hostname",
+			host:                      "tenant-b.example.com",
+			path:                      authTopologyPath,
+			expectedIdentityProviders: []string{"b"},
+			expectedAccessTokenMaxAge: 86400,
+		},
+		{
+			name:                      "path of an issuer on another host",
+			host:                      "tenant-b.example.com",
+			path:                      "/tenant-a" + authTopologyPath,
+			expectedIdentityProviders: nil,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			req.Host = tc.host
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+			if tc.expectedIdentityProviders == nil {
+				if resp.Code != http.StatusNotFound {
+					t.Errorf("expected the request to be passed on, got %d", resp.Code)
+				}
+				return
+			}
+
+			current := topology.Topology{}
+			if err := json.Unmarshal(resp.Body.Bytes(), &current); err != nil {
+				t.Fatal(err)
+			}
+			names := []string{}
+			for _, idp := range current.IdentityProviders {
+				names = append(names, idp.Name)
+			}
+			if !reflect.DeepEqual(names, tc.expectedIdentityProviders) {
+				t.Errorf("expected identity providers %v, got %v", tc.expectedIdentityProviders, names)
+			}
+			if current.AccessTokenMaxAgeSeconds != tc.expectedAccessTokenMaxAge {
+				t.Errorf("expected access token max age %d, got %d", tc.expectedAccessTokenMaxAge, current.AccessTokenMaxAgeSeconds)
+			}
+			if !reflect.DeepEqual(current.Issuers, tc.expectedIssuers) {
+				t.Errorf("expected issuers %v, got %v", tc.expectedIssuers, current.Issuers)
+			}
+		})
+	}
+}
@@ -3,23 +3,34 @@ package oauthserver
 import (
 	"bytes"
 	"crypto/sha256"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"path"
+	"strings"
 	"time"
 
+	kapiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/yaml"
 	genericapiserver "k8s.io/apiserver/pkg/server"
+	coreinformers "k8s.io/client-go/informers/core/v1"
 	kclientset "k8s.io/client-go/kubernetes"
+	kubescheme "k8s.io/client-go/kubernetes/scheme"
 	authenticationv1client "k8s.io/client-go/kubernetes/typed/authentication/v1"
 	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/cert"
+	"k8s.io/klog/v2"
 
 	osinv1 "github.com/openshift/api/osin/v1"
 	oauthclient "github.com/openshift/client-go/oauth/clientset/versioned/typed/oauth/v1"
@@ -30,11 +41,35 @@ import (
 	userinformerv1 "github.com/openshift/client-go/user/informers/externalversions/user/v1"
 	userlisterv1 "github.com/openshift/client-go/user/listers/user/v1"
 	bootstrap "github.com/openshift/library-go/pkg/authentication/bootstrapauthenticator"
+	"github.com/openshift/library-go/pkg/oauth/oauthdiscovery"
 	"github.com/openshift/library-go/pkg/oauth/usercache"
+	"github.com/openshift/oauth-server/pkg/authenticator/password/cachedpassword"
+	"github.com/openshift/oauth-server/pkg/authenticator/throttle"
+	"github.com/openshift/oauth-server/pkg/bootstrapuser"
 	"github.com/openshift/oauth-server/pkg/config"
+	"github.com/openshift/oauth-server/pkg/deprovisioning"
+	"github.com/openshift/oauth-server/pkg/identityauthorization"
+	"github.com/openshift/oauth-server/pkg/idphealth"
+	"github.com/openshift/oauth-server/pkg/logging"
+	"github.com/openshift/oauth-server/pkg/server/captcha"
+	"github.com/openshift/oauth-server/pkg/server/clientip"
+	"github.com/openshift/oauth-server/pkg/server/cookies"
+	"github.com/openshift/oauth-server/pkg/server/correlation"
 	"github.com/openshift/oauth-server/pkg/server/crypto"
+	"github.com/openshift/oauth-server/pkg/server/csrf"
+	"github.com/openshift/oauth-server/pkg/server/deadline"
 	"github.com/openshift/oauth-server/pkg/server/headers"
+	"github.com/openshift/oauth-server/pkg/server/logout"
+	"github.com/openshift/oauth-server/pkg/server/maintenance"
+	"github.com/openshift/oauth-server/pkg/server/pathprefix"
+	"github.com/openshift/oauth-server/pkg/server/redirect"
 	"github.com/openshift/oauth-server/pkg/server/session"
+	"github.com/openshift/oauth-server/pkg/server/shutdown"
+	"github.com/openshift/oauth-server/pkg/server/theme"
+	"github.com/openshift/oauth-server/pkg/tokengc"
+	"github.com/openshift/oauth-server/pkg/tokenstorage"
+	"github.com/openshift/oauth-server/pkg/topology"
+	"github.com/openshift/oauth-server/pkg/upstreamtoken"
 	"github.com/openshift/oauth-server/pkg/userregistry/identitymapper"
 )
 
@@ -43,25 +78,34 @@ var (
 	codecs = serializer.NewCodecFactory(scheme)
 )
 
+const (
+	defaultLoginChallengeFailureThreshold = 5
+	defaultLoginChallengeFailureWindow    = 15 * time.Minute
+
+	defaultBasicAuthThrottleInitialDelay  = time.Second
+	defaultBasicAuthThrottleMaxDelay      = 30 * time.Second
+	defaultBasicAuthThrottleSuppressAfter = 10
+	defaultBasicAuthThrottleFailureWindow = 15 * time.Minute
+	// source IPs may fail this many times as often for all usernames as for one
+	defaultBasicAuthThrottleSourceFactor = 10
+
+	defaultMaxLoginLoops = 3
+
+	defaultMaintenanceRetryAfter = 5 * time.Minute
+
+	// defaultAssertionMaxAge accepts assertions of authenticating proxies that were issued for the current request
+	defaultAssertionMaxAge = 5 * time.Minute
+)
+
 func init() {
 	utilruntime.Must(osinv1.Install(scheme))
 }
 
 // TODO we need to switch the oauth server to an external type, but that can be done after we get our externally facing flag values fixed
 // TODO remaining bits involve the session file, LDAP util code, validation, ...
-func NewOAuthServerConfig(oauthConfig osinv1.OAuthConfig, userClientConfig *rest.Config, genericConfig *genericapiserver.RecommendedConfig) (*OAuthServerConfig, error) {
-	// TODO: there is probably some better way to do this
-	decoder := codecs.UniversalDecoder(osinv1.GroupVersion)
-	for i, idp := range oauthConfig.IdentityProviders {
-		if idp.Provider.Object != nil {
-			// depending on how you get here, the IDP objects may or may not be filled out
-			break
-		}
-		idpObject, err := runtime.Decode(decoder, idp.Provider.Raw)
-		if err != nil {
-			return nil, err
-		}
-		oauthConfig.IdentityProviders[i].Provider.Object = idpObject
+func NewOAuthServerConfig(oauthConfig osinv1.OAuthConfig, extendedConfig config.ExtendedOAuthConfig, userClientConfig *rest.Config, genericConfig *genericapiserver.RecommendedConfig) (*OAuthServerConfig, error) {
+	if err := decodeIdentityProviders(&oauthConfig); err != nil {
+		return nil, err
 	}
 
 	// this leaves the embedded OAuth server code path alone
@@ -92,24 +136,374 @@ func NewOAuthServerConfig(oauthConfig osinv1.OAuthConfig, userClientConfig *rest
 		return nil, err
 	}
 
-	bootstrapUserDataGetter := bootstrap.NewBootstrapUserDataGetter(kubeClient.CoreV1(), kubeClient.CoreV1())
+	var bootstrapUserDataGetter bootstrap.BootstrapUserDataGetter = bootstrap.NewBootstrapUserDataGetter(kubeClient.CoreV1(), kubeClient.CoreV1())
+	// the guard hides the bootstrap user from sessions and the provider selection while it is rejected
+	var bootstrapUserGuard *bootstrapuser.Guard
+	// bootstrapUserStateInformer caches the state of the bootstrap user, so that logins do not read it from the API
+	var bootstrapUserStateInformer cache.SharedIndexInformer
+	if bootstrapUserConfig := extendedConfig.BootstrapUser; bootstrapUserConfig != nil {
+		eventBroadcaster := record.NewBroadcaster()
+		eventBroadcaster.StartRecordingToSink(&corev1.EventSinkImpl{Interface: eventsClient.Events("")})
+		bootstrapUserStateInformer = coreinformers.NewFilteredConfigMapInformer(kubeClient, metav1.NamespaceSystem, 10*time.Minute, cache.Indexers{}, func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", bootstrapuser.StateConfigMap).String()
+		})
+		bootstrapUserGuard = bootstrapuser.NewGuardWithInformer(
+			bootstrapUserDataGetter,
+			kubeClient.CoreV1(),
+			bootstrapUserStateInformer,
+			bootstrapuser.Policy{
+				RequirePasswordRotation:           bootstrapUserConfig.RequirePasswordRotation,
+				RotationGracePeriod:               bootstrapUserConfig.RotationGracePeriod.Duration,
+				DisableAfterIdentityProviderLogin: bootstrapUserConfig.DisableAfterIdentityProviderLogin,
+			},
+			eventBroadcaster.NewRecorder(kubescheme.Scheme, kapiv1.EventSource{Component: "oauth-server"}),
+		)
+		bootstrapUserDataGetter = bootstrapUserGuard
+	}
+
+	cookieOptions, err := buildCookieOptions(oauthConfig.MasterPublicURL, extendedConfig.Cookies)
+	if err != nil {
+		return nil, err
+	}
+
+	pageTheme, err := buildTheme(extendedConfig.Theme)
+	if err != nil {
+		return nil, err
+	}
+
+	var loginChallenge captcha.Challenge
+	var loginCaptcha *captcha.Guard
+	if challengeConfig := extendedConfig.LoginChallenge; challengeConfig != nil {
+		loginChallenge, err = buildLoginChallenge(challengeConfig)
+		if err != nil {
+			return nil, fmt.Errorf("invalid login challenge: %v", err)
+		}
+		threshold, window := challengeConfig.FailureThreshold, challengeConfig.FailureWindow.Duration
+		if threshold == 0 {
+			threshold = defaultLoginChallengeFailureThreshold
+		}
+		if window == 0 {
+			window = defaultLoginChallengeFailureWindow
+		}
+		loginCaptcha = captcha.NewGuard(loginChallenge, threshold, window)
+	}
+	var basicAuthThrottle *throttle.Throttle
+	if throttleConfig := extendedConfig.BasicAuthThrottle; throttleConfig != nil {
+		initialDelay, maxDelay := throttleConfig.InitialDelay.Duration, throttleConfig.MaxDelay.Duration
+		suppressAfter, suppressSourceAfter, window := throttleConfig.SuppressAfter, throttleConfig.SuppressSourceAfter, throttleConfig.FailureWindow.Duration
+		if initialDelay == 0 {
+			initialDelay = defaultBasicAuthThrottleInitialDelay
+		}
+		if maxDelay == 0 {
+			maxDelay = defaultBasicAuthThrottleMaxDelay
+		}
+		if suppressAfter == 0 {
+			suppressAfter = defaultBasicAuthThrottleSuppressAfter
+		}
+		if suppressSourceAfter == 0 {
+			suppressSourceAfter = defaultBasicAuthThrottleSourceFactor * suppressAfter
+		}
+		if window == 0 {
+			window = defaultBasicAuthThrottleFailureWindow
+		}
+		basicAuthThrottle = throttle.NewThrottle(initialDelay, maxDelay, suppressAfter, suppressSourceAfter, window)
+		if extendedConfig.TrustedProxies == nil {
+			klog.Warning("The basic auth throttle is enabled without trusted proxies, clients behind a proxy share its address and can suppress the basic auth logins of any user")
+		}
+	}
+	// logins only return to the authorize endpoint, where users are sent to log in from
+	redirectPaths := []string{path.Join(oauthdiscovery.OpenShiftOAuthAPIPrefix, oauthdiscovery.AuthorizePath)}
+	maxLoginLoops := defaultMaxLoginLoops
+	if redirectsConfig := extendedConfig.Redirects; redirectsConfig != nil {
+		redirectPaths = append(redirectPaths, redirectsConfig.AllowedPaths...)
+		if redirectsConfig.MaxLoginLoops > 0 {
+			maxLoginLoops = redirectsConfig.MaxLoginLoops
+		}
+	}
 
 	var sessionAuth session.SessionAuthenticator
+	var sessionLister session.SessionLister
+	var sessionRevocations *session.Revocations
+	var sessionKeys *session.Keys
+	var sessionSecretsFiles []string
+	var issuerSessions map[string]session.SessionAuthenticator
 	if oauthConfig.SessionConfig != nil {
-		// TODO we really need to enforce HTTPS always
-		secure := isHTTPS(oauthConfig.MasterPublicURL)
-		auth, err := buildSessionAuth(secure, oauthConfig.SessionConfig, bootstrapUserDataGetter)
+		if extendedConfig.Deprovisioning != nil || revokesSessions(extendedConfig) {
+			// revocations are shared through the session storage, so that all servers reject the revoked sessions
+			backend, err := buildSessionBackend(extendedConfig.SessionStorage)
+			if err != nil {
+				return nil, err
+			}
+			sessionRevocations = session.NewSharedRevocations(time.Duration(oauthConfig.SessionConfig.SessionMaxAgeSeconds)*time.Second, backend)
+		}
+
+		var previousSecretsFiles []string
+		if extendedConfig.SessionSecrets != nil {
+			previousSecretsFiles = extendedConfig.SessionSecrets.PreviousSecretsFiles
+		}
+		if len(previousSecretsFiles) > 0 && len(oauthConfig.SessionConfig.SessionSecretsFile) == 0 {
+			return nil, fmt.Errorf("previous session secrets files require a sessionSecretsFile")
+		}
+		secrets, err := getSessionKeys(oauthConfig.SessionConfig.SessionSecretsFile, previousSecretsFiles)
+		if err != nil {
+			return nil, err
+		}
+		sessionKeys = session.NewKeys(secrets...)
+		if secretsFile := oauthConfig.SessionConfig.SessionSecretsFile; len(secretsFile) > 0 {
+			sessionSecretsFiles = append([]string{secretsFile}, previousSecretsFiles...)
+		}
+
+		auth, lister, err := buildSessionAuth(cookieOptions, oauthConfig.SessionConfig, extendedConfig.SessionStorage, sessionLimit(extendedConfig.TokenLimit), sessionKeys, bootstrapUserDataGetter, sessionRevocations)
 		if err != nil {
 			return nil, err
 		}
 		sessionAuth = auth
+		sessionLister = lister
+
+		// users log in to every issuer on its own
+		issuerSessions = map[string]session.SessionAuthenticator{}
+		for _, issuer := range extendedConfig.Issuers {
+			issuerSessionConfig := *oauthConfig.SessionConfig
+			issuerSessionConfig.SessionName += "-" + issuer.Name
+			auth, _, err := buildSessionAuth(cookieOptions, &issuerSessionConfig, extendedConfig.SessionStorage, sessionLimit(extendedConfig.TokenLimit), sessionKeys, bootstrapUserDataGetter, sessionRevocations)
+			if err != nil {
+				return nil, err
+			}
+			issuerSessions[issuer.Name] = auth
+		}
+	}
+
+	if silentConfig := extendedConfig.SilentAuthentication; silentConfig != nil && silentConfig.KeepSessions && oauthConfig.SessionConfig == nil {
+		return nil, fmt.Errorf("keeping sessions for silent authentication requires a session config")
+	}
+
+	if err := addDefaultIdentityProviders(&oauthConfig, bootstrapUserDataGetter); err != nil {
+		return nil, err
+	}
+
+	csrfProtection, err := buildCSRF(cookieOptions, extendedConfig.CSRF, sessionKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	userInformer := userinformer.NewSharedInformerFactory(userClient, time.Second*30)
+	if err := userInformer.User().V1().Groups().Informer().AddIndexers(cache.Indexers{
+		usercache.ByUserIndexName: usercache.ByUserIndexKeys,
+	}); err != nil {
+		return nil, err
+	}
+
+	var identityAuthorizationWebhook *identityauthorization.Webhook
+	if webhookConfig := extendedConfig.IdentityAuthorizationWebhook; webhookConfig != nil {
+		transport, err := transportFor(webhookConfig.CA, webhookConfig.CertFile, webhookConfig.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error building identity authorization webhook client: %v", err)
+		}
+		identityAuthorizationWebhook = identityauthorization.NewWebhook(webhookConfig.URL, transport, webhookConfig.Timeout.Duration)
+	}
+
+	// pending device authorization requests are shared by the replicas that share sessions
+	var deviceBackend session.Backend
+	if extendedConfig.DeviceAuthorization != nil {
+		backend, err := buildSessionBackend(extendedConfig.SessionStorage)
+		if err != nil {
+			return nil, err
+		}
+		if backend == nil {
+			backend = session.NewMemoryBackend()
+		}
+		deviceBackend = backend
+	}
+
+	tokenStorage, err := buildTokenStorage(extendedConfig.TokenStorage, oauthClient)
+	if err != nil {
+		return nil, err
+	}
+
+	// the listeners stay open for the grace period while readiness checks fail
+	var shutdownGate *shutdown.Gate
+	if shutdownConfig := extendedConfig.Shutdown; shutdownConfig != nil {
+		genericConfig.ShutdownDelayDuration = shutdownConfig.LoginGracePeriod.Duration
+		shutdownGate = shutdown.NewGate()
+	}
+
+	maintenanceMode, err := maintenance.NewMode(maintenanceSettings(extendedConfig.Maintenance, pageTheme))
+	if err != nil {
+		return nil, fmt.Errorf("invalid maintenance: %v", err)
+	}
+
+	var trustedProxies *clientip.Trusted
+	if proxiesConfig := extendedConfig.TrustedProxies; proxiesConfig != nil {
+		trustedProxies, err = clientip.NewTrusted(proxiesConfig.CIDRs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if externalURLs := extendedConfig.ExternalURLs; externalURLs != nil && len(externalURLs.PathPrefix) > 0 {
+		publicURL, err := url.Parse(oauthConfig.MasterPublicURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid masterPublicURL %q: %v", oauthConfig.MasterPublicURL, err)
+		}
+		if strings.TrimSuffix(publicURL.Path, "/") != externalURLs.PathPrefix {
+			return nil, fmt.Errorf("the path of masterPublicURL %q must be the path prefix %q", oauthConfig.MasterPublicURL, externalURLs.PathPrefix)
+		}
+	}
+
+	var identityProviderHealth *idphealth.Checker
+	if healthConfig := extendedConfig.IdentityProviderHealth; healthConfig != nil {
+		identityProviderHealth = idphealth.NewChecker(healthConfig.Interval.Duration, healthConfig.Timeout.Duration)
+		if healthConfig.Readiness {
+			genericConfig.ReadyzChecks = append(genericConfig.ReadyzChecks, identityProviderHealth)
+		}
+	}
 
+	var upstreamTokens *upstreamtoken.Vault
+	if upstreamTokensConfig := extendedConfig.UpstreamTokens; upstreamTokensConfig != nil {
+		secret, err := ioutil.ReadFile(upstreamTokensConfig.SecretFile)
+		if err != nil {
+			return nil, err
+		}
+		upstreamTokens, err = upstreamtoken.NewVault(kubeClient.CoreV1().Secrets(upstreamTokensConfig.Namespace), bytes.TrimSpace(secret))
+		if err != nil {
+			return nil, fmt.Errorf("invalid upstream tokens secret file %s: %v", upstreamTokensConfig.SecretFile, err)
+		}
+	}
+
+	ret := &OAuthServerConfig{
+		GenericConfig: genericConfig,
+		ExtraOAuthConfig: ExtraOAuthConfig{
+			Options:                        oauthConfig,
+			ExtendedOptions:                extendedConfig,
+			KubeClient:                     kubeClient,
+			EventsClient:                   eventsClient.Events(""),
+			RouteClient:                    routeClient,
+			UserClient:                     userClient.UserV1().Users(),
+			GroupClient:                    userClient.UserV1().Groups(),
+			GroupLister:                    userInformer.User().V1().Groups().Lister(),
+			GroupInformer:                  userInformer.User().V1().Groups(),
+			IdentityClient:                 userClient.UserV1().Identities(),
+			UserIdentityMappingClient:      userClient.UserV1().UserIdentityMappings(),
+			OAuthAccessTokenClient:         tokenStorage.OAuthAccessTokens(),
+			OAuthAuthorizeTokenClient:      tokenStorage.OAuthAuthorizeTokens(),
+			OAuthClientClient:              oauthClient.OAuthClients(),
+			OAuthClientAuthorizationClient: oauthClient.OAuthClientAuthorizations(),
+			SessionAuth:                    sessionAuth,
+			SessionRevocations:             sessionRevocations,
+			SessionLister:                  sessionLister,
+			SessionKeys:                    sessionKeys,
+			CookieOptions:                  cookieOptions,
+			CSRF:                           csrfProtection,
+			Theme:                          pageTheme,
+			LoginChallenge:                 loginChallenge,
+			LoginCaptcha:                   loginCaptcha,
+			BasicAuthThrottle:              basicAuthThrottle,
+			Redirects:                      redirect.NewValidator(redirectPaths, maxLoginLoops),
+			BootstrapUserDataGetter:        bootstrapUserDataGetter,
+			BootstrapUserGuard:             bootstrapUserGuard,
+			TokenReviewClient:              kubeClient.AuthenticationV1().TokenReviews(),
+			IdentityAuthorizationWebhook:   identityAuthorizationWebhook,
+			DeviceBackend:                  deviceBackend,
+			ShutdownGate:                   shutdownGate,
+			Maintenance:                    maintenanceMode,
+			IdentityProviderHealth:         identityProviderHealth,
+			TrustedProxies:                 trustedProxies,
+			LogVerbosity:                   logging.NewVerbosity(),
+			UpstreamTokens:                 upstreamTokens,
+
+			issuerSessions: issuerSessions,
+
+			postStartHooks: map[string]genericapiserver.PostStartHookFunc{
+				"openshift.io-StartUserInformer": func(ctx genericapiserver.PostStartHookContext) error {
+					go userInformer.Start(ctx.StopCh)
+					return nil
+				},
+			},
+		},
+	}
+
+	if bootstrapUserStateInformer != nil {
+		ret.ExtraOAuthConfig.addPostStartHook("openshift.io-StartBootstrapUserInformer", func(ctx genericapiserver.PostStartHookContext) error {
+			go bootstrapUserStateInformer.Run(ctx.StopCh)
+			return nil
+		})
+	}
+
+	if deprovisioningConfig := extendedConfig.Deprovisioning; deprovisioningConfig != nil {
+		deprovisioningController := deprovisioning.NewController(
+			userInformer.User().V1().Users(),
+			userInformer.User().V1().Identities(),
+			userClient.UserV1().Identities(),
+			tokenStorage.OAuthAccessTokens(),
+			tokenStorage.OAuthAuthorizeTokens(),
+			sessionRevocations,
+			deprovisioningUpstreamTokens(upstreamTokens),
+			deprovisioningConfig.DeleteIdentities,
+		)
+		ret.ExtraOAuthConfig.addPostStartHook("openshift.io-deprovisioning", func(ctx genericapiserver.PostStartHookContext) error {
+			go deprovisioningController.Run(1, ctx.StopCh)
+			return nil
+		})
+	}
+
+	if gcConfig := extendedConfig.TokenGarbageCollection; gcConfig != nil {
+		collector := tokengc.NewCollector(tokenStorage.OAuthAccessTokens(), tokenStorage.OAuthAuthorizeTokens(), gcConfig.Interval.Duration, gcConfig.BatchSize)
+		ret.ExtraOAuthConfig.addPostStartHook("openshift.io-token-gc", func(ctx genericapiserver.PostStartHookContext) error {
+			go collector.Run(ctx.StopCh)
+			return nil
+		})
+	}
+
+	if identityProviderHealth != nil {
+		ret.ExtraOAuthConfig.addPostStartHook("openshift.io-identity-provider-health", func(ctx genericapiserver.PostStartHookContext) error {
+			go identityProviderHealth.Run(ctx.StopCh)
+			return nil
+		})
+	}
+
+	if len(sessionSecretsFiles) > 0 {
+		secretsFile, previousSecretsFiles := sessionSecretsFiles[0], sessionSecretsFiles[1:]
+		ret.ExtraOAuthConfig.addPostStartHook("openshift.io-session-secrets", func(ctx genericapiserver.PostStartHookContext) error {
+			go sessionKeys.Run(sessionSecretsFiles, func() ([][]byte, error) {
+				return getSessionKeys(secretsFile, previousSecretsFiles)
+			}, session.DefaultKeysPollInterval, ctx.StopCh)
+			return nil
+		})
+	}
+
+	genericConfig.BuildHandlerChainFunc = ret.buildHandlerChainForOAuth
+
+	return ret, nil
+}
+
+// decodeIdentityProviders decodes the raw identity providers of the config
+func decodeIdentityProviders(oauthConfig *osinv1.OAuthConfig) error {
+	// TODO: there is probably some better way to do this
+	decoder := codecs.UniversalDecoder(osinv1.GroupVersion)
+	for i, idp := range oauthConfig.IdentityProviders {
+		if idp.Provider.Object != nil {
+			// depending on how you get here, the IDP objects may or may not be filled out
+			break
+		}
+		idpObject, err := runtime.Decode(decoder, idp.Provider.Raw)
+		if err != nil {
+			return err
+		}
+		oauthConfig.IdentityProviders[i].Provider.Object = idpObject
+	}
+	return nil
+}
+
+// addDefaultIdentityProviders adds the bootstrap identity provider if sessions are enabled, and denies all logins
+// if no identity provider is configured
+func addDefaultIdentityProviders(oauthConfig *osinv1.OAuthConfig, bootstrapUserDataGetter bootstrap.BootstrapUserDataGetter) error {
+	if oauthConfig.SessionConfig != nil {
 		// session capability is the only thing required to enable the bootstrap IDP
 		// we dynamically enable or disable its UI based on the backing secret
 		// this must be the first IDP to make sure that it can handle basic auth challenges first
 		// this mostly avoids weird cases with the allow all IDP
 		if bootstrapUserEnabled, err := bootstrapUserDataGetter.IsEnabled(); err != nil {
-			return nil, err
+			return err
 		} else if bootstrapUserEnabled {
 			oauthConfig.IdentityProviders = append(
 				[]osinv1.IdentityProvider{
@@ -143,56 +537,255 @@ func NewOAuthServerConfig(oauthConfig osinv1.OAuthConfig, userClientConfig *rest
 			},
 		}
 	}
+	return nil
+}
 
-	userInformer := userinformer.NewSharedInformerFactory(userClient, time.Second*30)
-	if err := userInformer.User().V1().Groups().Informer().AddIndexers(cache.Indexers{
-		usercache.ByUserIndexName: usercache.ByUserIndexKeys,
-	}); err != nil {
-		return nil, err
+// revokesSessions returns true if any identity provider may revoke the sessions of users, through the back channel
+// logout or when their credentials changed
+func revokesSessions(extendedConfig config.ExtendedOAuthConfig) bool {
+	for _, idp := range extendedConfig.IdentityProviders {
+		if idp.BackChannelLogout() || idp.CredentialsRevocation != nil {
+			return true
+		}
 	}
+	return false
+}
 
-	ret := &OAuthServerConfig{
-		GenericConfig: genericConfig,
-		ExtraOAuthConfig: ExtraOAuthConfig{
-			Options:                        oauthConfig,
-			KubeClient:                     kubeClient,
-			EventsClient:                   eventsClient.Events(""),
-			RouteClient:                    routeClient,
-			UserClient:                     userClient.UserV1().Users(),
-			GroupClient:                    userClient.UserV1().Groups(),
-			GroupLister:                    userInformer.User().V1().Groups().Lister(),
-			GroupInformer:                  userInformer.User().V1().Groups(),
-			IdentityClient:                 userClient.UserV1().Identities(),
-			UserIdentityMappingClient:      userClient.UserV1().UserIdentityMappings(),
-			OAuthAccessTokenClient:         oauthClient.OAuthAccessTokens(),
-			OAuthAuthorizeTokenClient:      oauthClient.OAuthAuthorizeTokens(),
-			OAuthClientClient:              oauthClient.OAuthClients(),
-			OAuthClientAuthorizationClient: oauthClient.OAuthClientAuthorizations(),
-			SessionAuth:                    sessionAuth,
-			BootstrapUserDataGetter:        bootstrapUserDataGetter,
-			TokenReviewClient:              kubeClient.AuthenticationV1().TokenReviews(),
+// externalTokenStorage returns true if tokens are not kept in the Kubernetes API
+func externalTokenStorage(extendedConfig config.ExtendedOAuthConfig) bool {
+	return extendedConfig.TokenStorage != nil && extendedConfig.TokenStorage.Type != config.TokenStorageKubernetes
+}
 
-			postStartHooks: map[string]genericapiserver.PostStartHookFunc{
-				"openshift.io-StartUserInformer": func(ctx genericapiserver.PostStartHookContext) error {
-					go userInformer.Start(ctx.StopCh)
-					return nil
-				},
-			},
-		},
+// buildCSRF returns the CSRF protection of forms, signed tokens require the session keys
+func buildCSRF(cookieOptions cookies.Options, csrfConfig *config.CSRF, keys *session.Keys) (csrf.CSRF, error) {
+	name := cookieOptions.Name("csrf")
+	if csrfConfig == nil || !csrfConfig.Signed {
+		return csrf.NewCookieCSRF(name, cookieOptions), nil
 	}
-	genericConfig.BuildHandlerChainFunc = ret.buildHandlerChainForOAuth
+	if keys == nil {
+		return nil, fmt.Errorf("signed CSRF tokens require a session config")
+	}
+	return csrf.NewSignedCSRF(name, cookieOptions, keys, csrfConfig.TokenLifetime.Duration), nil
+}
 
-	return ret, nil
+// buildTheme returns the theme of the pages, or nil if the built-in pages are used
+func buildTheme(themeConfig *config.Theme) (*theme.Theme, error) {
+	if themeConfig == nil {
+		return nil, nil
+	}
+	staticMaxAge := themeConfig.StaticMaxAge.Duration
+	if staticMaxAge == 0 {
+		staticMaxAge = theme.DefaultStaticMaxAge
+	}
+	pageTheme, err := theme.New(themeConfig.Directory, staticMaxAge)
+	if err != nil {
+		return nil, fmt.Errorf("invalid theme: %v", err)
+	}
+	return pageTheme, nil
+}
+
+// maintenanceSettings returns the settings of the maintenance mode, the arguments of maintenance.NewMode. The template
+// of the setting takes precedence over the one of the theme.
+func maintenanceSettings(maintenanceConfig *config.Maintenance, pageTheme *theme.Theme) (bool, string, time.Duration, string) {
+	templateFile := pageTheme.Template(theme.MaintenanceTemplate)
+	if maintenanceConfig == nil {
+		return false, "", defaultMaintenanceRetryAfter, templateFile
+	}
+	retryAfter := maintenanceConfig.RetryAfter.Duration
+	if retryAfter == 0 {
+		retryAfter = defaultMaintenanceRetryAfter
+	}
+	if len(maintenanceConfig.Template) > 0 {
+		templateFile = maintenanceConfig.Template
+	}
+	return maintenanceConfig.Enabled, maintenanceConfig.Message, retryAfter, templateFile
+}
+
+// buildLoginChallenge returns the challenge of the password login forms
+func buildLoginChallenge(challengeConfig *config.LoginChallenge) (captcha.Challenge, error) {
+	switch challengeConfig.Type {
+	case config.LoginChallengeHCaptcha, config.LoginChallengeReCAPTCHA:
+		secret, err := ioutil.ReadFile(challengeConfig.Captcha.SecretFile)
+		if err != nil {
+			return nil, err
+		}
+		transport, err := transportFor("", "", "")
+		if err != nil {
+			return nil, err
+		}
+		if challengeConfig.Type == config.LoginChallengeHCaptcha {
+			return captcha.NewHCaptcha(challengeConfig.Captcha.SiteKey, strings.TrimSpace(string(secret)), transport), nil
+		}
+		return captcha.NewReCAPTCHA(challengeConfig.Captcha.SiteKey, strings.TrimSpace(string(secret)), transport), nil
+	case config.LoginChallengeProofOfWork:
+		difficulty := challengeConfig.ProofOfWorkDifficulty
+		if difficulty == 0 {
+			difficulty = captcha.DefaultProofOfWorkDifficulty
+		}
+		return captcha.NewProofOfWork(difficulty, openShiftProofOfWorkScriptPath), nil
+	default:
+		return nil, fmt.Errorf("unknown type %q", challengeConfig.Type)
+	}
+}
+
+// securityHeaders returns the overrides of the standard headers
+func securityHeaders(config *config.SecurityHeaders, loginChallenge captcha.Challenge) headers.SecurityHeaders {
+	securityHeaders := headers.SecurityHeaders{}
+	if loginChallenge != nil {
+		securityHeaders.ExtraSources = loginChallenge.Sources()
+	}
+	if config == nil {
+		return securityHeaders
+	}
+	securityHeaders.ContentSecurityPolicy = config.ContentSecurityPolicy
+	securityHeaders.StrictTransportSecurity = config.StrictTransportSecurity
+	securityHeaders.ReferrerPolicy = config.ReferrerPolicy
+	securityHeaders.ContentTypeOptions = config.ContentTypeOptions
+	securityHeaders.FrameAncestors = config.FrameAncestors
+	return securityHeaders
+}
+
+// buildCookieOptions returns the attributes of the session and CSRF cookies
+func buildCookieOptions(masterPublicURL string, attributes *config.CookieAttributes) (cookies.Options, error) {
+	// TODO we really need to enforce HTTPS always
+	options := cookies.Options{Secure: isHTTPS(masterPublicURL)}
+	if attributes == nil {
+		return options, nil
+	}
+
+	options.NamePrefix = attributes.NamePrefix
+	options.Domain = attributes.Domain
+	options.Path = attributes.Path
+	options.Partitioned = attributes.Partitioned
+	switch attributes.SameSite {
+	case config.CookieSameSiteLax:
+		options.SameSite = http.SameSiteLaxMode
+	case config.CookieSameSiteStrict:
+		options.SameSite = http.SameSiteStrictMode
+	case config.CookieSameSiteNone:
+		options.SameSite = http.SameSiteNoneMode
+	}
+
+	// browsers drop these cookies unless they are secure
+	if !options.Secure && (len(options.NamePrefix) > 0 || options.SameSite == http.SameSiteNoneMode || options.Partitioned) {
+		return cookies.Options{}, fmt.Errorf("cookie name prefixes, SameSite None and partitioned cookies require an https masterPublicURL")
+	}
+	return options, nil
+}
+
+// sessionLimit returns the limit of sessions per user of the token limit, if any
+func sessionLimit(limit *config.TokenLimit) session.Limit {
+	if limit == nil {
+		return session.Limit{}
+	}
+	return session.Limit{
+		MaxSessionsPerUser: limit.MaxSessionsPerUser,
+		EvictOldest:        limit.Policy == config.TokenLimitEvictOldest,
+	}
+}
+
+// buildSessionAuth returns the session authenticator and, if sessions are stored on the server, their lister.
+// Sessions stored on the server are limited per user.
+func buildSessionAuth(cookieOptions cookies.Options, config *osinv1.SessionConfig, storage *config.SessionStorage, limit session.Limit, keys *session.Keys, getter bootstrap.BootstrapUserDataGetter, revocations *session.Revocations) (session.SessionAuthenticator, session.SessionLister, error) {
+	backend, err := buildSessionBackend(storage)
+	if err != nil {
+		return nil, nil, err
+	}
+	var sessionStore session.Store
+	var sessionLister session.SessionLister
+	if backend != nil {
+		sessionStore = session.NewServerStoreWithLimit(cookieOptions.Name(config.SessionName), cookieOptions, backend, keys, limit)
+		sessionLister = sessionStore.(session.SessionLister)
+	} else {
+		sessionStore = session.NewStore(cookieOptions.Name(config.SessionName), cookieOptions, keys)
+	}
+	sessionAuthenticator := session.NewAuthenticator(sessionStore, time.Duration(config.SessionMaxAgeSeconds)*time.Second, revocations)
+	return session.NewBootstrapAuthenticator(sessionAuthenticator, getter, sessionStore), sessionLister, nil
+}
+
+// buildSessionBackend returns the backend for server-side sessions, or nil if sessions are stored in cookies
+func buildSessionBackend(storage *config.SessionStorage) (session.Backend, error) {
+	if storage == nil {
+		return nil, nil
+	}
+
+	switch storage.Type {
+	case config.SessionStorageMemory:
+		return session.NewMemoryBackend(), nil
+
+	case config.SessionStorageRedis:
+		return buildRedisBackend(storage.Redis)
+
+	default:
+		return nil, nil
+	}
+}
+
+// buildTokenStorage returns the storage of access and authorize tokens, the Kubernetes API unless another one is configured
+func buildTokenStorage(storage *config.TokenStorage, kubeStorage tokenstorage.Storage) (tokenstorage.Storage, error) {
+	if storage == nil {
+		return kubeStorage, nil
+	}
+
+	switch storage.Type {
+	case config.TokenStorageMemory:
+		return tokenstorage.NewBackendStorage(session.NewMemoryBackend()), nil
+
+	case config.TokenStorageRedis:
+		backend, err := buildRedisBackend(storage.Redis)
+		if err != nil {
+			return nil, err
+		}
+		return tokenstorage.NewBackendStorage(backend), nil
+
+	default:
+		return kubeStorage, nil
+	}
+}
+
+func buildRedisBackend(redis *config.RedisSessionStorage) (session.Backend, error) {
+	options := session.RedisOptions{
+		Address:   redis.Address,
+		Username:  redis.Username,
+		DB:        redis.DB,
+		KeyPrefix: redis.KeyPrefix,
+		Timeout:   redis.Timeout.Duration,
+	}
+	if len(redis.PasswordFile) > 0 {
+		password, err := ioutil.ReadFile(redis.PasswordFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading redis password file: %v", err)
+		}
+		options.Password = strings.TrimRight(string(password), "\r\n")
+	}
+	if redis.TLS {
+		options.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		if len(redis.CA) > 0 {
+			roots, err := cert.NewPool(redis.CA)
+			if err != nil {
+				return nil, fmt.Errorf("error loading cert pool from ca file %s: %v", redis.CA, err)
+			}
+			options.TLSConfig.RootCAs = roots
+		}
+	}
+	return session.NewRedisBackend(options), nil
 }
 
-func buildSessionAuth(secure bool, config *osinv1.SessionConfig, getter bootstrap.BootstrapUserDataGetter) (session.SessionAuthenticator, error) {
-	secrets, err := getSessionSecrets(config.SessionSecretsFile)
+// getSessionKeys returns the secrets of the sessionSecretsFile followed by the secrets of the files with
+// previous secrets. Only the first secret encodes cookies, so previous secrets only decode existing cookies.
+func getSessionKeys(filename string, previousFiles []string) ([][]byte, error) {
+	secrets, err := getSessionSecrets(filename)
 	if err != nil {
 		return nil, err
 	}
-	sessionStore := session.NewStore(config.SessionName, secure, secrets...)
-	sessionAuthenticator := session.NewAuthenticator(sessionStore, time.Duration(config.SessionMaxAgeSeconds)*time.Second)
-	return session.NewBootstrapAuthenticator(sessionAuthenticator, getter, sessionStore), nil
+	for _, previousFile := range previousFiles {
+		previousSecrets, err := getSessionSecrets(previousFile)
+		if err != nil {
+			return nil, err
+		}
+		secrets = append(secrets, previousSecrets...)
+	}
+	return secrets, nil
 }
 
 func getSessionSecrets(filename string) ([][]byte, error) {
@@ -254,6 +847,9 @@ func isRequestHeaderSetAsChallenger(providers []osinv1.IdentityProvider) bool {
 type ExtraOAuthConfig struct {
 	Options osinv1.OAuthConfig
 
+	// ExtendedOptions holds settings that are not part of osinv1.OAuthConfig
+	ExtendedOptions config.ExtendedOAuthConfig
+
 	// KubeClient is kubeclient with enough permission for the auth API
 	KubeClient kclientset.Interface
 
@@ -277,11 +873,86 @@ type ExtraOAuthConfig struct {
 	OAuthClientAuthorizationClient oauthclient.OAuthClientAuthorizationInterface
 
 	SessionAuth session.SessionAuthenticator
+	// SessionRevocations invalidate the sessions of users, if set
+	SessionRevocations *session.Revocations
+	// SessionLister lists the sessions of users, it is only set if sessions are stored on the server
+	SessionLister session.SessionLister
+	// SessionKeys sign and encrypt session cookies, they are only set if sessions are enabled
+	SessionKeys *session.Keys
+	// CookieOptions are the attributes of the session and CSRF cookies
+	CookieOptions cookies.Options
+	// CSRF protects the forms of the server
+	CSRF csrf.CSRF
+	// Theme replaces the templates of the built-in pages and serves static assets, if set
+	Theme *theme.Theme
+	// LoginChallenge is the challenge of the password login forms, and LoginCaptcha requires it
+	// after repeated failures, if set
+	LoginChallenge captcha.Challenge
+	LoginCaptcha   *captcha.Guard
+	// BasicAuthThrottle slows down failed basic auth attempts, if set
+	BasicAuthThrottle *throttle.Throttle
+	// Redirects checks the "then" URLs that logins send users back to
+	Redirects *redirect.Validator
 
 	BootstrapUserDataGetter bootstrap.BootstrapUserDataGetter
 	TokenReviewClient       authenticationv1client.TokenReviewInterface
 
+	// BootstrapUserGuard restricts the bootstrap user, if set. It is also the BootstrapUserDataGetter.
+	BootstrapUserGuard *bootstrapuser.Guard
+
+	// IdentityAuthorizationWebhook authorizes logins after identities were mapped to users, if set
+	IdentityAuthorizationWebhook *identityauthorization.Webhook
+
+	// DeviceBackend keeps pending device authorization requests, it is only set if the device authorization
+	// grant is enabled
+	DeviceBackend session.Backend
+
+	// ShutdownGate rejects new logins once the server shuts down, if set
+	ShutdownGate *shutdown.Gate
+	// Maintenance turns away new logins while it is enabled
+	Maintenance *maintenance.Mode
+
+	// IdentityProviderHealth checks that the identity providers are reachable, if set
+	IdentityProviderHealth *idphealth.Checker
+
+	// TrustedProxies pass the addresses of clients, if set
+	TrustedProxies *clientip.Trusted
+
+	// LogVerbosity changes the verbosity of the log while the server runs, if set
+	LogVerbosity *logging.Verbosity
+
+	// UpstreamTokens keeps the tokens that identity providers issue at login, if set
+	UpstreamTokens *upstreamtoken.Vault
+
 	postStartHooks map[string]genericapiserver.PostStartHookFunc
+
+	// issuerSessions authenticate the sessions of the issuers, by issuer name
+	issuerSessions map[string]session.SessionAuthenticator
+
+	// providerLogouts describe how to end the sessions at identity providers, by provider name
+	providerLogouts map[string]logout.ProviderLogout
+
+	// identityProviderChecks check that the identity providers are reachable, by provider name
+	identityProviderChecks map[string]idphealth.Check
+
+	// identityProviderDiagnoses are the steps that diagnose the identity providers on demand, by provider name
+	identityProviderDiagnoses map[string][]idphealth.Step
+
+	// offlineCredentials remember the credentials of password logins for provider outages, by provider name
+	offlineCredentials map[string]*cachedpassword.Credentials
+
+	// identityProviderTransports are shared by the identity providers with the same CA and client certificate, by
+	// their files
+	identityProviderTransports map[identityProviderTransportKey]http.RoundTripper
+
+	// provisioningBreaker is shared by the identity mappers that provision users, as they share the API server
+	provisioningBreaker *identitymapper.Breaker
+
+	// topology records the effective authentication setup while handlers are built
+	topology *topology.Recorder
+
+	// reload rebuilds the OAuth handlers when the configuration changes, if set
+	reload *ConfigReload
 }
 
 type OAuthServerConfig struct {
@@ -334,21 +1005,182 @@ func (c completedOAuthConfig) New(delegationTarget genericapiserver.DelegationTa
 		}
 	}
 
+	if gate := c.ExtraOAuthConfig.ShutdownGate; gate != nil {
+		// pre shutdown hooks run as soon as the server is asked to stop, before the shutdown delay
+		if err := s.GenericAPIServer.AddPreShutdownHook("openshift.io-login-drain", func() error {
+			klog.Infof("Shutting down, completing the logins in progress for %v", c.GenericConfig.ShutdownDelayDuration)
+			gate.Close()
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+	}
+
 	return s, nil
 }
 
+// getTopology returns the recorder for the authentication topology
+func (c *ExtraOAuthConfig) getTopology() *topology.Recorder {
+	if c.topology == nil {
+		c.topology = topology.NewRecorder()
+	}
+	return c.topology
+}
+
+// addProviderLogout records how to end sessions at the identity provider with the given name
+func (c *ExtraOAuthConfig) addProviderLogout(name string, providerLogout logout.ProviderLogout) {
+	if c.providerLogouts == nil {
+		c.providerLogouts = map[string]logout.ProviderLogout{}
+	}
+	c.providerLogouts[name] = providerLogout
+}
+
+// addIdentityProviderCheck records how to check that the identity provider with the given name is reachable
+func (c *ExtraOAuthConfig) addIdentityProviderCheck(name string, check idphealth.Check) {
+	if c.identityProviderChecks == nil {
+		c.identityProviderChecks = map[string]idphealth.Check{}
+	}
+	c.identityProviderChecks[name] = check
+}
+
+// addIdentityProviderDiagnosis appends steps to the diagnosis of the identity provider with the given name
+func (c *ExtraOAuthConfig) addIdentityProviderDiagnosis(name string, steps ...idphealth.Step) {
+	if c.identityProviderDiagnoses == nil {
+		c.identityProviderDiagnoses = map[string][]idphealth.Step{}
+	}
+	c.identityProviderDiagnoses[name] = append(c.identityProviderDiagnoses[name], steps...)
+}
+
+// getOfflineCredentials returns the remembered credentials of the password identity provider with the given name
+func (c *ExtraOAuthConfig) getOfflineCredentials(name string, maxAge time.Duration, maxEntries int) *cachedpassword.Credentials {
+	if credentials, ok := c.offlineCredentials[name]; ok {
+		return credentials
+	}
+	if c.offlineCredentials == nil {
+		c.offlineCredentials = map[string]*cachedpassword.Credentials{}
+	}
+	credentials := cachedpassword.NewCredentials(maxAge, maxEntries)
+	c.offlineCredentials[name] = credentials
+	return credentials
+}
+
+// identityProviderTransportKey are the CA and client certificate files of the transport of an identity provider
+type identityProviderTransportKey struct {
+	ca, certFile, keyFile string
+}
+
+// getIdentityProviderTransport returns the transport for identity providers with the given ca and client cert (which
+// may be empty strings). Identity providers with the same files share the transport, and with it its connections and
+// TLS sessions.
+func (c *ExtraOAuthConfig) getIdentityProviderTransport(ca, certFile, keyFile string) (http.RoundTripper, error) {
+	key := identityProviderTransportKey{ca: ca, certFile: certFile, keyFile: keyFile}
+	if transport, ok := c.identityProviderTransports[key]; ok {
+		return transport, nil
+	}
+	transport, err := identityProviderTransportFor(ca, certFile, keyFile, c.ExtendedOptions.IdentityProviderConnections)
+	if err != nil {
+		return nil, err
+	}
+	if c.identityProviderTransports == nil {
+		c.identityProviderTransports = map[identityProviderTransportKey]http.RoundTripper{}
+	}
+	c.identityProviderTransports[key] = transport
+	return transport, nil
+}
+
+// getProvisioningBreaker returns the circuit breaker of the identity mappers that provision users
+func (c *ExtraOAuthConfig) getProvisioningBreaker() *identitymapper.Breaker {
+	if c.provisioningBreaker != nil {
+		return c.provisioningBreaker
+	}
+	threshold, cooldown := identitymapper.DefaultBreakerThreshold, identitymapper.DefaultBreakerCooldown
+	if provisioning := c.ExtendedOptions.UserProvisioning; provisioning != nil {
+		if provisioning.BreakerThreshold > 0 {
+			threshold = provisioning.BreakerThreshold
+		}
+		if provisioning.BreakerCooldown.Duration > 0 {
+			cooldown = provisioning.BreakerCooldown.Duration
+		}
+	}
+	c.provisioningBreaker = identitymapper.NewBreaker(threshold, cooldown)
+	return c.provisioningBreaker
+}
+
+// alternateExternalURLs returns the URLs of the server-relative path under the alternate hostnames, by hostname
+func (c *ExtraOAuthConfig) alternateExternalURLs(path string) map[string]string {
+	externalURLs := c.ExtendedOptions.ExternalURLs
+	if externalURLs == nil || len(externalURLs.AlternateHostnames) == 0 {
+		return nil
+	}
+	// the masterPublicURL is validated when the server starts
+	publicURL, err := url.Parse(c.Options.MasterPublicURL)
+	if err != nil {
+		return nil
+	}
+	alternateURLs := map[string]string{}
+	for _, hostname := range externalURLs.AlternateHostnames {
+		alternateURL := *publicURL
+		alternateURL.Host = hostname
+		alternateURLs[hostname] = alternateURL.String() + path
+	}
+	return alternateURLs
+}
+
+// addPostStartHook registers a hook that is run once the server started. Hooks registered
+// with a name that is already in use get a numeric suffix, as handler building may create
+// several instances of the same component.
+func (c *ExtraOAuthConfig) addPostStartHook(name string, hook genericapiserver.PostStartHookFunc) {
+	if c.postStartHooks == nil {
+		c.postStartHooks = map[string]genericapiserver.PostStartHookFunc{}
+	}
+	hookName := name
+	for i := 2; c.postStartHooks[hookName] != nil; i++ {
+		hookName = fmt.Sprintf("%s-%d", name, i)
+	}
+	c.postStartHooks[hookName] = hook
+}
+
 func (c *OAuthServerConfig) buildHandlerChainForOAuth(startingHandler http.Handler, genericConfig *genericapiserver.Config) http.Handler {
 	// add OAuth handlers on top of the generic API server handlers
-	handler, err := c.WithOAuth(startingHandler)
+	var handler http.Handler
+	var err error
+	if c.ExtraOAuthConfig.reload != nil {
+		handler, err = c.withReloadingOAuth(startingHandler)
+	} else {
+		handler, err = c.withIssuers(startingHandler)
+	}
 	if err != nil {
 		// the existing errors all cause the OAuth server to die anyway
 		panic(err)
 	}
 
+	// the steps of a login share the budget of its request instead of each call to an identity provider or the API
+	// having its own timeout
+	budget := deadline.DefaultBudget
+	if loginBudget := c.ExtraOAuthConfig.ExtendedOptions.LoginBudget; loginBudget != nil && loginBudget.Timeout.Duration > 0 {
+		budget = loginBudget.Timeout.Duration
+	}
+	handler = deadline.WithBudget(handler, budget, isLoginRequest)
+
+	if gate := c.ExtraOAuthConfig.ShutdownGate; gate != nil {
+		handler = shutdown.WithGate(handler, gate, c.startsLogin)
+	}
+
+	if mode := c.ExtraOAuthConfig.Maintenance; mode != nil {
+		// logins that were started before the maintenance may not submit the login form either
+		handler = maintenance.WithMode(handler, mode, func(req *http.Request) bool {
+			return c.startsLogin(req) || req.URL.Path == openShiftLoginPrefix || strings.HasPrefix(req.URL.Path, openShiftLoginPrefix+"/")
+		})
+	}
+
 	// add back the Authorization header so that WithOAuth can use it even after WithAuthentication deletes it
 	// WithOAuth sees users' passwords and can mint tokens so this is not really an issue
 	handler = headers.WithRestoreAuthorizationHeader(handler)
 
+	// login attempts are identified in the logs and audit events of all their requests, audit needs the context of
+	// the generic chain
+	handler = correlation.WithCorrelationID(handler, c.ExtraOAuthConfig.CookieOptions, c.startsLogin)
+
 	// this is the normal kube handler chain
 	handler = genericapiserver.DefaultBuildHandlerChain(handler, genericConfig)
 
@@ -356,7 +1188,53 @@ func (c *OAuthServerConfig) buildHandlerChainForOAuth(startingHandler http.Handl
 	handler = headers.WithPreserveAuthorizationHeader(handler)
 
 	// protected endpoints should not be cached
-	handler = headers.WithStandardHeaders(handler)
+	handler = headers.WithStandardHeaders(handler, securityHeaders(c.ExtraOAuthConfig.ExtendedOptions.SecurityHeaders, c.ExtraOAuthConfig.LoginChallenge))
+
+	// issuers are selected before their paths are authorized
+	if len(c.ExtraOAuthConfig.ExtendedOptions.Issuers) > 0 {
+		routes, err := issuerRoutes(&c.ExtraOAuthConfig.ExtendedOptions)
+		if err != nil {
+			panic(err)
+		}
+		handler = withIssuerSelection(handler, routes)
+	}
+
+	// everything, including audit and login rate limits, sees the addresses of clients instead of proxies
+	if trustedProxies := c.ExtraOAuthConfig.TrustedProxies; trustedProxies != nil {
+		handler = clientip.WithClientIP(handler, trustedProxies)
+	}
+
+	// the prefix is removed before the paths are authorized and routed
+	if externalURLs := c.ExtraOAuthConfig.ExtendedOptions.ExternalURLs; externalURLs != nil && len(externalURLs.PathPrefix) > 0 {
+		handler = pathprefix.WithPathPrefix(handler, externalURLs.PathPrefix)
+	}
 
 	return handler
 }
+
+// isLoginRequest returns true for the requests of logins: authorize requests, callbacks of identity providers and
+// login forms
+func isLoginRequest(req *http.Request) bool {
+	switch {
+	case req.URL.Path == path.Join(oauthdiscovery.OpenShiftOAuthAPIPrefix, oauthdiscovery.AuthorizePath):
+		return true
+	case strings.HasPrefix(req.URL.Path, openShiftOAuthCallbackPrefix+"/"):
+		return true
+	default:
+		return req.URL.Path == openShiftLoginPrefix || strings.HasPrefix(req.URL.Path, openShiftLoginPrefix+"/")
+	}
+}
+
+// startsLogin returns true for authorize requests of users that are not logged in. Users that were sent back to
+// the authorize endpoint after they logged in with an identity provider have a session.
+func (c *OAuthServerConfig) startsLogin(req *http.Request) bool {
+	if req.URL.Path != path.Join(oauthdiscovery.OpenShiftOAuthAPIPrefix, oauthdiscovery.AuthorizePath) {
+		return false
+	}
+	sessionAuth := c.ExtraOAuthConfig.SessionAuth
+	if sessionAuth == nil {
+		return true
+	}
+	_, ok, err := sessionAuth.AuthenticateRequest(req)
+	return err != nil || !ok
+}
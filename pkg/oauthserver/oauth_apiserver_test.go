@@ -2,12 +2,17 @@ package oauthserver
 
 import (
 	"io/ioutil"
+	"net/http"
 	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 
 	osinv1 "github.com/openshift/api/osin/v1"
 	"github.com/openshift/library-go/pkg/config/helpers"
+
+	"github.com/openshift/oauth-server/pkg/config"
+	"github.com/openshift/oauth-server/pkg/server/cookies"
 )
 
 func TestGetDefaultSessionSecrets(t *testing.T) {
@@ -100,3 +105,98 @@ func TestGetValidSessionSecretsFile(t *testing.T) {
 		t.Errorf("Unexpected %v, got %v", expectedSecrets, readSecrets)
 	}
 }
+
+func TestBuildCookieOptions(t *testing.T) {
+	embedded := &config.CookieAttributes{SameSite: config.CookieSameSiteNone, NamePrefix: cookies.HostPrefix, Partitioned: true}
+
+	options, err := buildCookieOptions("https://oauth.example.com", nil)
+	if err != nil || options != (cookies.Options{Secure: true}) {
+		t.Errorf("unexpected default options %#v %v", options, err)
+	}
+	options, err = buildCookieOptions("https://oauth.example.com", embedded)
+	if err != nil || options != (cookies.Options{NamePrefix: "__Host-", Secure: true, SameSite: http.SameSiteNoneMode, Partitioned: true}) {
+		t.Errorf("unexpected options %#v %v", options, err)
+	}
+	if options.Name("ssn") != "__Host-ssn" {
+		t.Errorf("expected prefixed name, got %q", options.Name("ssn"))
+	}
+	options, err = buildCookieOptions("http://oauth.example.com", &config.CookieAttributes{SameSite: config.CookieSameSiteStrict, Domain: "example.com"})
+	if err != nil || options != (cookies.Options{Domain: "example.com", SameSite: http.SameSiteStrictMode}) {
+		t.Errorf("unexpected insecure options %#v %v", options, err)
+	}
+	if _, err := buildCookieOptions("http://oauth.example.com", embedded); err == nil {
+		t.Error("expected secure attributes to require https")
+	}
+}
+
+func TestGetIdentityProviderTransport(t *testing.T) {
+	c := &ExtraOAuthConfig{}
+	transport, err := c.getIdentityProviderTransport("", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shared, _ := c.getIdentityProviderTransport("", "", ""); shared != transport {
+		t.Error("expected identity providers without CA to share the transport")
+	}
+	if transport == http.DefaultTransport {
+		t.Error("expected a transport of the identity providers instead of the default transport")
+	}
+	defaults, ok := transport.(*http.Transport)
+	if !ok {
+		t.Fatalf("unexpected transport %T", transport)
+	}
+	if defaults.MaxIdleConnsPerHost != defaultIdentityProviderMaxIdleConnsPerHost || defaults.TLSClientConfig.ClientSessionCache == nil || defaults.IdleConnTimeout == 0 {
+		t.Errorf("unexpected default connections %d %v %v", defaults.MaxIdleConnsPerHost, defaults.TLSClientConfig.ClientSessionCache, defaults.IdleConnTimeout)
+	}
+	if _, err := c.getIdentityProviderTransport("", "cert.pem", ""); err == nil {
+		t.Error("expected a client cert without key to be rejected")
+	}
+
+	c = &ExtraOAuthConfig{ExtendedOptions: config.ExtendedOAuthConfig{IdentityProviderConnections: &config.IdentityProviderConnections{MaxIdleConnsPerHost: 10, DisableHTTP2: true}}}
+	transport, err = c.getIdentityProviderTransport("", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tuned := transport.(*http.Transport)
+	if tuned.MaxIdleConnsPerHost != 10 || !reflect.DeepEqual(tuned.TLSClientConfig.NextProtos, []string{"http/1.1"}) {
+		t.Errorf("unexpected connections %d %v", tuned.MaxIdleConnsPerHost, tuned.TLSClientConfig.NextProtos)
+	}
+}
+
+func TestDefinedOAuthClients(t *testing.T) {
+	dir := t.TempDir()
+	secretFile := filepath.Join(dir, "secret")
+	if err := ioutil.WriteFile(secretFile, []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	clientsFile := filepath.Join(dir, "clients.yaml")
+	if err := ioutil.WriteFile(clientsFile, []byte(`
+- name: grafana
+  secretFile: `+secretFile+`
+  redirectURIs:
+  - https://grafana.example.com/login/generic_oauth
+  grantMethod: prompt
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	oauthClients := &config.OAuthClients{
+		Clients: []config.OAuthClientDefinition{{Name: "cli", RedirectURIs: []string{"http://localhost"}, RespondWithChallenges: true}},
+		Files:   []string{clientsFile},
+	}
+	clients, err := definedOAuthClients(oauthClients)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clients) != 2 || clients[0].Name != "cli" || len(clients[0].Secret) != 0 || !clients[0].RespondWithChallenges {
+		t.Fatalf("expected the public cli client first, got %#v", clients)
+	}
+	if grafana := clients[1]; grafana.Name != "grafana" || grafana.Secret != "s3cret" || grafana.GrantMethod != "prompt" {
+		t.Errorf("expected the grafana client of the file with its secret, got %#v", grafana)
+	}
+
+	oauthClients.Clients = append(oauthClients.Clients, config.OAuthClientDefinition{Name: "grafana", RedirectURIs: []string{"https://other.example.com"}})
+	if _, err := definedOAuthClients(oauthClients); err == nil {
+		t.Error("expected clients that are defined twice to be rejected")
+	}
+}
@@ -0,0 +1,403 @@
+package oauthserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	genericapiserver "k8s.io/apiserver/pkg/server"
+	"k8s.io/klog/v2"
+
+	osinv1 "github.com/openshift/api/osin/v1"
+
+	"github.com/openshift/oauth-server/pkg/config"
+	"github.com/openshift/oauth-server/pkg/server/filewatch"
+	"github.com/openshift/oauth-server/pkg/server/theme"
+)
+
+// DefaultConfigReloadPollInterval is how often the configuration files are checked for changes in case filesystem
+// notifications are unavailable or missed
+const DefaultConfigReloadPollInterval = time.Minute
+
+// ConfigReload describes how the configuration is read again when its files change
+type ConfigReload struct {
+	// Files are the configuration files. The files that the configuration references are watched as well.
+	Files []string
+	// Load reads the configuration from the files
+	Load func() (*osinv1.OAuthConfig, *config.ExtendedOAuthConfig, error)
+	// PollInterval defaults to DefaultConfigReloadPollInterval
+	PollInterval time.Duration
+}
+
+// EnableReload rebuilds the OAuth handlers whenever the configuration or the files it references change, e.g. to
+// add identity providers or to replace their secrets and templates. Requests that are in flight finish with the
+// previous handlers. Settings that are only applied when the server starts are kept until it restarts. The serving
+// certificates and the session secrets are reloaded by the server on their own.
+func (c *OAuthServerConfig) EnableReload(reload ConfigReload) {
+	if reload.PollInterval == 0 {
+		reload.PollInterval = DefaultConfigReloadPollInterval
+	}
+	c.ExtraOAuthConfig.reload = &reload
+}
+
+// generation is one build of the OAuth handlers
+type generation struct {
+	config  *OAuthServerConfig
+	handler http.Handler
+
+	// lock guards draining and adding to inFlight, so that no request is added once the generation drains
+	lock     sync.Mutex
+	draining bool
+	// inFlight counts the requests that are served
+	inFlight sync.WaitGroup
+	// stopCh stops the hooks of the generation once it was replaced and drained, or the server stopped
+	stopCh chan struct{}
+}
+
+// reloadingHandler serves requests with the current generation of the OAuth handlers
+type reloadingHandler struct {
+	reload          ConfigReload
+	startingHandler http.Handler
+
+	// current holds the *generation that serves new requests
+	current atomic.Value
+
+	// fingerprint identifies the content of the files of the current generation
+	fingerprint string
+	// hookContext is passed to the post start hooks of later generations
+	hookContext genericapiserver.PostStartHookContext
+}
+
+// withReloadingOAuth returns the OAuth handlers of the current configuration. The post start hooks of the handlers
+// are tied to their generation, so that they stop when the handlers are replaced.
+func (c *OAuthServerConfig) withReloadingOAuth(startingHandler http.Handler) (http.Handler, error) {
+	h := &reloadingHandler{
+		reload:          *c.ExtraOAuthConfig.reload,
+		startingHandler: startingHandler,
+	}
+	first, err := h.build(c.GenericConfig, c.ExtraOAuthConfig)
+	if err != nil {
+		return nil, err
+	}
+	h.current.Store(first)
+	if h.fingerprint, err = fingerprintFiles(h.files()); err != nil {
+		// the first check reloads the configuration
+		klog.Warningf("Unable to read the configuration files: %v", err)
+	}
+
+	c.ExtraOAuthConfig.addPostStartHook("openshift.io-config-reload", func(ctx genericapiserver.PostStartHookContext) error {
+		h.hookContext = ctx
+		first.start(ctx)
+		go func() {
+			filewatch.Run(h.files, h.reloadConfig, h.reload.PollInterval, ctx.StopCh)
+			close(h.generation().stopCh)
+		}()
+		return nil
+	})
+	return h, nil
+}
+
+func (h *reloadingHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	g := h.generation()
+	// generations only drain once they were replaced, the next one serves the request
+	for !g.serve() {
+		g = h.generation()
+	}
+	defer g.inFlight.Done()
+	g.handler.ServeHTTP(w, req)
+}
+
+// serve adds a request to the generation, unless the generation is drained
+func (g *generation) serve() bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.draining {
+		return false
+	}
+	g.inFlight.Add(1)
+	return true
+}
+
+func (h *reloadingHandler) generation() *generation {
+	return h.current.Load().(*generation)
+}
+
+// files returns the configuration files and the files referenced by the configuration of the current generation
+func (h *reloadingHandler) files() []string {
+	options := h.generation().config.ExtraOAuthConfig
+	return append(append([]string{}, h.reload.Files...), referencedFiles(&options.Options, &options.ExtendedOptions)...)
+}
+
+// reloadConfig replaces the current generation if the content of the files changed. The current generation is kept
+// if the configuration is invalid.
+func (h *reloadingHandler) reloadConfig() error {
+	fingerprint, err := fingerprintFiles(h.files())
+	if err != nil {
+		return err
+	}
+	if fingerprint == h.fingerprint {
+		return nil
+	}
+
+	oauthConfig, extendedConfig, err := h.reload.Load()
+	if err != nil {
+		return err
+	}
+	if extendedConfig == nil {
+		extendedConfig = &config.ExtendedOAuthConfig{}
+	}
+	if err := decodeIdentityProviders(oauthConfig); err != nil {
+		return err
+	}
+	previous := h.generation()
+	for _, setting := range keepStartupSettings(&previous.config.ExtraOAuthConfig, oauthConfig, extendedConfig) {
+		klog.Warningf("The %s setting changed, it is applied when the server restarts", setting)
+	}
+	if err := addDefaultIdentityProviders(oauthConfig, previous.config.ExtraOAuthConfig.BootstrapUserDataGetter); err != nil {
+		return err
+	}
+	pageTheme, err := buildTheme(extendedConfig.Theme)
+	if err != nil {
+		return err
+	}
+
+	extra := previous.config.ExtraOAuthConfig
+	extra.Options = *oauthConfig
+	extra.ExtendedOptions = *extendedConfig
+	extra.Theme = pageTheme
+	next, err := h.build(previous.config.GenericConfig, extra)
+	if err != nil {
+		return fmt.Errorf("keeping the previous OAuth handlers: %v", err)
+	}
+	// the maintenance mode is shared by the generations, it keeps when it started if it stays enabled
+	if mode := extra.Maintenance; mode != nil {
+		if err := mode.Configure(maintenanceSettings(extendedConfig.Maintenance, pageTheme)); err != nil {
+			return fmt.Errorf("keeping the previous OAuth handlers: invalid maintenance: %v", err)
+		}
+		klog.Infof("Reconfigured the maintenance mode, enabled: %v", mode.Enabled())
+	}
+	h.fingerprint = fingerprint
+	h.replace(next)
+	klog.Infof("Reloaded the OAuth configuration with %d identity providers", len(oauthConfig.IdentityProviders))
+	return nil
+}
+
+// replace serves new requests with the next generation and starts its hooks. The previous generation stops once
+// its requests finished.
+func (h *reloadingHandler) replace(next *generation) {
+	previous := h.generation()
+	h.current.Store(next)
+	next.start(h.hookContext)
+	go previous.drain()
+}
+
+// build returns a generation of handlers for the config. Generations share the clients, sessions and keys of the
+// server, the hooks, provider logouts and topology recorded while the handlers are built are their own.
+func (h *reloadingHandler) build(genericConfig *genericapiserver.RecommendedConfig, extra ExtraOAuthConfig) (*generation, error) {
+	c := &OAuthServerConfig{
+		GenericConfig:    genericConfig,
+		ExtraOAuthConfig: extra,
+	}
+	c.ExtraOAuthConfig.postStartHooks = nil
+	c.ExtraOAuthConfig.providerLogouts = nil
+	c.ExtraOAuthConfig.identityProviderChecks = nil
+	c.ExtraOAuthConfig.identityProviderDiagnoses = nil
+	c.ExtraOAuthConfig.offlineCredentials = nil
+	// the CA and client certificate files may have changed
+	c.ExtraOAuthConfig.identityProviderTransports = nil
+	// the breaker settings may have changed
+	c.ExtraOAuthConfig.provisioningBreaker = nil
+	c.ExtraOAuthConfig.topology = nil
+
+	handler, err := c.withIssuers(h.startingHandler)
+	if err != nil {
+		return nil, err
+	}
+	return &generation{
+		config:  c,
+		handler: handler,
+		stopCh:  make(chan struct{}),
+	}, nil
+}
+
+// start runs the post start hooks of the generation until it stops
+func (g *generation) start(ctx genericapiserver.PostStartHookContext) {
+	ctx.StopCh = g.stopCh
+	for name, hook := range g.config.ExtraOAuthConfig.postStartHooks {
+		if err := hook(ctx); err != nil {
+			utilruntime.HandleError(fmt.Errorf("post start hook %s failed: %v", name, err))
+		}
+	}
+}
+
+// drain waits for the requests of the generation to finish and stops its hooks
+func (g *generation) drain() {
+	g.lock.Lock()
+	g.draining = true
+	g.lock.Unlock()
+	g.inFlight.Wait()
+	close(g.stopCh)
+	klog.V(2).Infof("Drained the previous OAuth handlers")
+}
+
+// startupSettings returns pointers to the settings that are only applied when the server starts, by name
+func startupSettings(oauthConfig *osinv1.OAuthConfig, extendedConfig *config.ExtendedOAuthConfig) map[string]interface{} {
+	return map[string]interface{}{
+		"masterPublicURL":              &oauthConfig.MasterPublicURL,
+		"sessionConfig":                &oauthConfig.SessionConfig,
+		"identityAuthorizationWebhook": &extendedConfig.IdentityAuthorizationWebhook,
+		"deprovisioning":               &extendedConfig.Deprovisioning,
+		"sessionStorage":               &extendedConfig.SessionStorage,
+		"tokenGarbageCollection":       &extendedConfig.TokenGarbageCollection,
+		"tokenStorage":                 &extendedConfig.TokenStorage,
+		"bootstrapUser":                &extendedConfig.BootstrapUser,
+		"deviceAuthorization":          &extendedConfig.DeviceAuthorization,
+		"cookies":                      &extendedConfig.Cookies,
+		"csrf":                         &extendedConfig.CSRF,
+		"sessionSecrets":               &extendedConfig.SessionSecrets,
+		"securityHeaders":              &extendedConfig.SecurityHeaders,
+		"loginChallenge":               &extendedConfig.LoginChallenge,
+		"shutdown":                     &extendedConfig.Shutdown,
+		"identityProviderHealth":       &extendedConfig.IdentityProviderHealth,
+		"trustedProxies":               &extendedConfig.TrustedProxies,
+		"externalURLs":                 &extendedConfig.ExternalURLs,
+		"issuers":                      &extendedConfig.Issuers,
+		"logging":                      &extendedConfig.Logging,
+	}
+}
+
+// keepStartupSettings replaces the settings of the loaded configuration that are only applied when the server
+// starts with the running ones, and returns the names of the settings that changed
+func keepStartupSettings(running *ExtraOAuthConfig, oauthConfig *osinv1.OAuthConfig, extendedConfig *config.ExtendedOAuthConfig) []string {
+	runningSettings := startupSettings(&running.Options, &running.ExtendedOptions)
+	changed := []string{}
+	for name, loaded := range startupSettings(oauthConfig, extendedConfig) {
+		runningValue, loadedValue := reflect.ValueOf(runningSettings[name]).Elem(), reflect.ValueOf(loaded).Elem()
+		if !reflect.DeepEqual(runningValue.Interface(), loadedValue.Interface()) {
+			changed = append(changed, name)
+			loadedValue.Set(runningValue)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// referencedFiles returns the files referenced by the configuration that are read while the handlers are built
+func referencedFiles(oauthConfig *osinv1.OAuthConfig, extendedConfig *config.ExtendedOAuthConfig) []string {
+	files := []string{}
+	add := func(names ...string) {
+		for _, name := range names {
+			if len(name) > 0 {
+				files = append(files, name)
+			}
+		}
+	}
+
+	if templates := oauthConfig.Templates; templates != nil {
+		add(templates.Login, templates.ProviderSelection, templates.Error)
+	}
+	for _, identityProvider := range oauthConfig.IdentityProviders {
+		// htpasswd files are reloaded by their identity providers
+		switch provider := identityProvider.Provider.Object.(type) {
+		case *osinv1.BasicAuthPasswordIdentityProvider:
+			add(provider.RemoteConnectionInfo.CA, provider.RemoteConnectionInfo.CertInfo.CertFile, provider.RemoteConnectionInfo.CertInfo.KeyFile)
+		case *osinv1.KeystonePasswordIdentityProvider:
+			add(provider.RemoteConnectionInfo.CA, provider.RemoteConnectionInfo.CertInfo.CertFile, provider.RemoteConnectionInfo.CertInfo.KeyFile)
+		case *osinv1.LDAPPasswordIdentityProvider:
+			add(provider.CA, provider.BindPassword.File)
+		case *osinv1.RequestHeaderIdentityProvider:
+			add(provider.ClientCA)
+		case *osinv1.GitHubIdentityProvider:
+			add(provider.CA, provider.ClientSecret.File)
+		case *osinv1.GitLabIdentityProvider:
+			add(provider.CA, provider.ClientSecret.File)
+		case *osinv1.GoogleIdentityProvider:
+			add(provider.ClientSecret.File)
+		case *osinv1.OpenIDIdentityProvider:
+			add(provider.CA, provider.ClientSecret.File)
+		}
+	}
+
+	for _, identityProvider := range extendedConfig.IdentityProviders {
+		if revocation := identityProvider.CredentialsRevocation; revocation != nil {
+			add(revocation.WebhookSecretFile)
+		}
+		if requestHeader := identityProvider.RequestHeader; requestHeader != nil && requestHeader.Assertion != nil {
+			add(requestHeader.Assertion.JWKSFile)
+		}
+	}
+	if storage := extendedConfig.TokenStorage; storage != nil && storage.Reviewer != nil {
+		add(storage.Reviewer.CAFile)
+	}
+	for _, client := range extendedConfig.Clients {
+		add(client.JWKSFile)
+		if tlsClientAuth := client.TLSClientAuth; tlsClientAuth != nil {
+			add(tlsClientAuth.CAFile)
+		}
+	}
+	if oauthClients := extendedConfig.OAuthClients; oauthClients != nil {
+		add(oauthClients.Files...)
+		definitions := append([]config.OAuthClientDefinition{}, oauthClients.Clients...)
+		for _, file := range oauthClients.Files {
+			// invalid files fail the reload of the configuration, which reports them
+			if fileDefinitions, err := config.ReadOAuthClientDefinitions(file); err == nil {
+				definitions = append(definitions, fileDefinitions...)
+			}
+		}
+		for _, definition := range definitions {
+			add(definition.SecretFile)
+		}
+	}
+	if jarm := extendedConfig.JARM; jarm != nil {
+		add(jarm.SigningKeyFile)
+	}
+	if terms := extendedConfig.TermsOfService; terms != nil {
+		add(terms.File)
+	}
+	if maintenance := extendedConfig.Maintenance; maintenance != nil {
+		add(maintenance.Template)
+	}
+	themes := []*config.Theme{extendedConfig.Theme}
+	for _, issuer := range extendedConfig.Issuers {
+		themes = append(themes, issuer.Theme)
+	}
+	for _, pageTheme := range themes {
+		if pageTheme == nil {
+			continue
+		}
+		// static assets are served from the directory, they do not require new handlers
+		for _, name := range []string{theme.LoginTemplate, theme.ProviderSelectionTemplate, theme.GrantTemplate, theme.ErrorTemplate, theme.TermsTemplate, theme.MaintenanceTemplate} {
+			add(filepath.Join(pageTheme.Directory, name))
+		}
+	}
+	return files
+}
+
+// fingerprintFiles returns a hash of the names and contents of the files. Files that do not exist are part of the
+// hash, so that creating them is a change, e.g. a template added to the theme.
+func fingerprintFiles(files []string) (string, error) {
+	hash := sha256.New()
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if os.IsNotExist(err) {
+			fmt.Fprintf(hash, "%s\x00missing\x00", file)
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(hash, "%s\x00%d\x00", file, len(data))
+		hash.Write(data)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,200 @@
+package oauthserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	fakekube "k8s.io/client-go/kubernetes/fake"
+
+	osinv1 "github.com/openshift/api/osin/v1"
+	fakeoauthclient "github.com/openshift/client-go/oauth/clientset/versioned/fake"
+	fakeuserclient "github.com/openshift/client-go/user/clientset/versioned/fake"
+	userinformer "github.com/openshift/client-go/user/informers/externalversions"
+
+	"github.com/openshift/oauth-server/pkg/config"
+	"github.com/openshift/oauth-server/pkg/topology"
+	"github.com/openshift/oauth-server/pkg/userregistry/identitymapper"
+)
+
+func TestReloadConfig(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config")
+	writeConfig := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(configFile, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the file holds the name of the identity provider, or invalid
+	load := func() (*osinv1.OAuthConfig, *config.ExtendedOAuthConfig, error) {
+		data, err := os.ReadFile(configFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		name := strings.TrimSpace(string(data))
+		if name == "invalid" {
+			return nil, nil, errors.New("invalid config")
+		}
+		oauthConfig := &osinv1.OAuthConfig{
+			MasterPublicURL: "https://" + name + ".example.com",
+			IdentityProviders: []osinv1.IdentityProvider{{
+				Name:            name,
+				UseAsChallenger: true,
+				MappingMethod:   string(identitymapper.MappingMethodClaim),
+				Provider:        runtime.RawExtension{Object: &osinv1.AllowAllPasswordIdentityProvider{}},
+			}},
+			GrantConfig: osinv1.GrantConfig{Method: osinv1.GrantHandlerAuto},
+		}
+		return oauthConfig, &config.ExtendedOAuthConfig{}, nil
+	}
+
+	writeConfig("first")
+	oauthConfig, extendedConfig, _ := load()
+	informer := userinformer.NewSharedInformerFactory(fakeuserclient.NewSimpleClientset(), 30*time.Second)
+	c := &OAuthServerConfig{
+		ExtraOAuthConfig: ExtraOAuthConfig{
+			Options:           *oauthConfig,
+			ExtendedOptions:   *extendedConfig,
+			KubeClient:        fakekube.NewSimpleClientset(),
+			OAuthClientClient: fakeoauthclient.NewSimpleClientset().OauthV1().OAuthClients(),
+			GroupInformer:     informer.User().V1().Groups(),
+		},
+	}
+	c.EnableReload(ConfigReload{Files: []string{configFile}, Load: load})
+	handler, err := c.withReloadingOAuth(http.NotFoundHandler())
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := handler.(*reloadingHandler)
+	if _, ok := c.ExtraOAuthConfig.postStartHooks["openshift.io-config-reload"]; !ok || len(c.ExtraOAuthConfig.postStartHooks) != 1 {
+		t.Errorf("expected only the reload hook to be registered with the server, got %v", c.ExtraOAuthConfig.postStartHooks)
+	}
+
+	identityProviders := func() []string {
+		t.Helper()
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, authTopologyPath, nil))
+		current := topology.Topology{}
+		if err := json.Unmarshal(resp.Body.Bytes(), &current); err != nil {
+			t.Fatal(err)
+		}
+		names := []string{}
+		for _, idp := range current.IdentityProviders {
+			names = append(names, idp.Name)
+		}
+		return names
+	}
+	if names := identityProviders(); len(names) != 1 || names[0] != "first" {
+		t.Fatalf("unexpected identity providers %v", names)
+	}
+
+	// unchanged files do not rebuild the handlers
+	first := h.generation()
+	if err := h.reloadConfig(); err != nil || h.generation() != first {
+		t.Errorf("expected the handlers to be kept, got %v", err)
+	}
+
+	writeConfig("second")
+	if err := h.reloadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if names := identityProviders(); len(names) != 1 || names[0] != "second" {
+		t.Errorf("expected the identity providers to be reloaded, got %v", names)
+	}
+	second := h.generation()
+	if url := second.config.ExtraOAuthConfig.Options.MasterPublicURL; url != "https://first.example.com" {
+		t.Errorf("expected settings that are applied on startup to be kept, got %s", url)
+	}
+	select {
+	case <-first.stopCh:
+	case <-time.After(wait.ForeverTestTimeout):
+		t.Errorf("expected the previous handlers to be stopped")
+	}
+
+	writeConfig("invalid")
+	if err := h.reloadConfig(); err == nil || h.generation() != second {
+		t.Errorf("expected invalid configs to keep the handlers, got %v", err)
+	}
+	if names := identityProviders(); len(names) != 1 || names[0] != "second" {
+		t.Errorf("expected the previous identity providers, got %v", names)
+	}
+}
+
+func TestReloadDrainsRequests(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	newGeneration := func(handler http.HandlerFunc) *generation {
+		return &generation{config: &OAuthServerConfig{}, handler: handler, stopCh: make(chan struct{})}
+	}
+	previous := newGeneration(func(w http.ResponseWriter, req *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte("previous"))
+	})
+	next := newGeneration(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("next"))
+	})
+	h := &reloadingHandler{}
+	h.current.Store(previous)
+
+	inFlight := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.ServeHTTP(inFlight, httptest.NewRequest(http.MethodGet, "/", nil))
+	}()
+	<-started
+
+	h.replace(next)
+	resp := httptest.NewRecorder()
+	h.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/", nil))
+	if resp.Body.String() != "next" {
+		t.Errorf("expected new requests to be served by the next handlers, got %q", resp.Body.String())
+	}
+	select {
+	case <-previous.stopCh:
+		t.Fatal("expected the previous handlers to run until their requests finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	<-done
+	if inFlight.Body.String() != "previous" {
+		t.Errorf("expected the request in flight to finish, got %q", inFlight.Body.String())
+	}
+	select {
+	case <-previous.stopCh:
+	case <-time.After(wait.ForeverTestTimeout):
+		t.Error("expected the previous handlers to stop once they were drained")
+	}
+}
+
+func TestKeepStartupSettings(t *testing.T) {
+	running := &ExtraOAuthConfig{
+		Options:         osinv1.OAuthConfig{MasterPublicURL: "https://oauth.example.com"},
+		ExtendedOptions: config.ExtendedOAuthConfig{CSRF: &config.CSRF{Signed: true}},
+	}
+	oauthConfig := &osinv1.OAuthConfig{MasterPublicURL: "https://oauth.example.com", LoginURL: "https://login.example.com"}
+	extendedConfig := &config.ExtendedOAuthConfig{
+		Cookies:    &config.CookieAttributes{Path: "/"},
+		TokenLimit: &config.TokenLimit{MaxAccessTokensPerUser: 10},
+	}
+
+	changed := keepStartupSettings(running, oauthConfig, extendedConfig)
+	if strings.Join(changed, ",") != "cookies,csrf" {
+		t.Errorf("unexpected changed settings %v", changed)
+	}
+	if extendedConfig.Cookies != nil || extendedConfig.CSRF == nil || !extendedConfig.CSRF.Signed {
+		t.Errorf("expected the running settings to be kept, got %#v", extendedConfig)
+	}
+	if oauthConfig.LoginURL != "https://login.example.com" || extendedConfig.TokenLimit == nil {
+		t.Errorf("expected other settings to be reloaded")
+	}
+}
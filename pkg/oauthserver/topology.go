@@ -0,0 +1,151 @@
+package oauthserver
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+
+	genericapiserver "k8s.io/apiserver/pkg/server"
+
+	osinv1 "github.com/openshift/api/osin/v1"
+
+	"github.com/openshift/oauth-server/pkg/config"
+	"github.com/openshift/oauth-server/pkg/topology"
+)
+
+// recordTopology records the server wide settings and logs the topology when the server starts and stops
+func (c *OAuthServerConfig) recordTopology() {
+	options := c.ExtraOAuthConfig.Options
+	authTopology := c.ExtraOAuthConfig.getTopology()
+
+	authTopology.Update(func(t *topology.Topology) {
+		t.GrantMethod = string(options.GrantConfig.Method)
+		t.ServiceAccountGrantMethod = string(options.GrantConfig.ServiceAccountMethod)
+		t.Sessions = c.ExtraOAuthConfig.SessionAuth != nil
+		if t.Sessions {
+			t.SessionStorage = string(config.SessionStorageCookie)
+			if storage := c.ExtraOAuthConfig.ExtendedOptions.SessionStorage; storage != nil {
+				t.SessionStorage = string(storage.Type)
+			}
+		}
+		t.AccessTokenMaxAgeSeconds = options.TokenConfig.AccessTokenMaxAgeSeconds
+		t.AuthorizeTokenMaxAgeSeconds = options.TokenConfig.AuthorizeTokenMaxAgeSeconds
+		if timeout := options.TokenConfig.AccessTokenInactivityTimeout; timeout != nil {
+			t.AccessTokenInactivityTimeout = int32(timeout.Seconds())
+		}
+		t.ExternalURLs = []string{options.MasterPublicURL}
+		if externalURLs := c.ExtraOAuthConfig.ExtendedOptions.ExternalURLs; externalURLs != nil {
+			alternateURLs := c.ExtraOAuthConfig.alternateExternalURLs("")
+			for _, hostname := range externalURLs.AlternateHostnames {
+				t.ExternalURLs = append(t.ExternalURLs, alternateURLs[hostname])
+			}
+			t.PathPrefix = externalURLs.PathPrefix
+		}
+	})
+
+	c.ExtraOAuthConfig.addPostStartHook("openshift.io-auth-topology", func(ctx genericapiserver.PostStartHookContext) error {
+		authTopology.Log("startup")
+		go func() {
+			<-ctx.StopCh
+			authTopology.Log("shutdown")
+		}()
+		return nil
+	})
+}
+
+// identityProviderTopology describes the configured identity provider. Endpoints are added while its handlers are built.
+func (c *OAuthServerConfig) identityProviderTopology(identityProvider osinv1.IdentityProvider) topology.IdentityProvider {
+	idp := topology.IdentityProvider{
+		Name:          identityProvider.Name,
+		MappingMethod: identityProvider.MappingMethod,
+		Redirector:    identityProvider.UseAsLogin,
+		Challenger:    identityProvider.UseAsChallenger,
+		Policies:      map[string]string{},
+	}
+	if identityProvider.Provider.Object != nil {
+		idp.Type = reflect.Indirect(reflect.ValueOf(identityProvider.Provider.Object)).Type().Name()
+	}
+
+	switch provider := identityProvider.Provider.Object.(type) {
+	case *osinv1.LDAPPasswordIdentityProvider:
+		idp.Policies["insecure"] = strconv.FormatBool(provider.Insecure)
+	case *osinv1.GitHubIdentityProvider:
+		addListPolicy(idp.Policies, "organizations", provider.Organizations)
+		addListPolicy(idp.Policies, "teams", provider.Teams)
+	case *osinv1.GoogleIdentityProvider:
+		if len(provider.HostedDomain) > 0 {
+			idp.Policies["hostedDomain"] = provider.HostedDomain
+		}
+	case *osinv1.KeystonePasswordIdentityProvider:
+		idp.Policies["domainName"] = provider.DomainName
+	case *osinv1.RequestHeaderIdentityProvider:
+		addListPolicy(idp.Policies, "clientCommonNames", provider.ClientCommonNames)
+	}
+
+	extension := c.ExtraOAuthConfig.ExtendedOptions.IdentityProvider(identityProvider.Name)
+	if len(extension.UsernameTemplate) > 0 {
+		idp.Policies["usernameTemplate"] = extension.UsernameTemplate
+	}
+	if access := extension.Access; access != nil {
+		addListPolicy(idp.Policies, "allowedUsers", access.AllowedUsers)
+		addListPolicy(idp.Policies, "deniedUsers", access.DeniedUsers)
+		addListPolicy(idp.Policies, "allowedEmailDomains", access.AllowedEmailDomains)
+		addListPolicy(idp.Policies, "deniedEmailDomains", access.DeniedEmailDomains)
+	}
+	if groupSync := extension.GroupSync; groupSync != nil {
+		idp.Policies["groupSync"] = "enabled"
+		if len(groupSync.Prefix) > 0 {
+			idp.Policies["groupSyncPrefix"] = groupSync.Prefix
+		}
+	}
+	if revocation := extension.CredentialsRevocation; revocation != nil && revocation.OnLogin {
+		idp.Policies["credentialsVersionOnLogin"] = "true"
+	}
+	if transform := extension.Transform; transform != nil {
+		for name, expression := range map[string]string{
+			"transformUsername":    transform.Username,
+			"transformDisplayName": transform.DisplayName,
+			"transformEmail":       transform.Email,
+			"transformGroups":      transform.Groups,
+		} {
+			if len(expression) > 0 {
+				idp.Policies[name] = expression
+			}
+		}
+	}
+	if ldap := extension.LDAP; ldap != nil {
+		idp.Policies["followReferrals"] = strconv.FormatBool(ldap.FollowReferrals)
+		addListPolicy(idp.Policies, "allowedReferralHosts", ldap.AllowedReferralHosts)
+		addListPolicy(idp.Policies, "requiredGroups", ldap.RequiredGroups)
+		if ldap.SyncGroups {
+			idp.Policies["syncGroups"] = "true"
+		}
+	}
+	if openID := extension.OpenID; openID != nil && len(openID.Issuer) > 0 {
+		idp.Policies["issuer"] = openID.Issuer
+	}
+	if keystone := extension.Keystone; keystone != nil {
+		if len(keystone.ScopeProjectName) > 0 {
+			idp.Policies["scopeProjectName"] = keystone.ScopeProjectName
+		}
+		if len(keystone.ScopeDomainName) > 0 {
+			idp.Policies["scopeDomainName"] = keystone.ScopeDomainName
+		}
+		idp.Policies["applicationCredentials"] = strconv.FormatBool(keystone.ApplicationCredentials)
+	}
+	if requestHeader := extension.RequestHeader; requestHeader != nil {
+		addListPolicy(idp.Policies, "groupHeaders", requestHeader.GroupHeaders)
+		addListPolicy(idp.Policies, "extraHeaderPrefixes", requestHeader.ExtraHeaderPrefixes)
+	}
+
+	if len(idp.Policies) == 0 {
+		idp.Policies = nil
+	}
+	return idp
+}
+
+func addListPolicy(policies map[string]string, name string, values []string) {
+	if len(values) > 0 {
+		policies[name] = strings.Join(values, ",")
+	}
+}
@@ -0,0 +1,164 @@
+package oauthserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/client-go/rest"
+
+	osinv1 "github.com/openshift/api/osin/v1"
+
+	"github.com/openshift/oauth-server/pkg/config"
+	"github.com/openshift/oauth-server/pkg/server/errorpage"
+	"github.com/openshift/oauth-server/pkg/server/login"
+	"github.com/openshift/oauth-server/pkg/server/selectprovider"
+)
+
+// offlineHost is the API server of the clients built for validation, it never resolves
+const offlineHost = "https://kubernetes.invalid"
+
+// offlineTransport answers the requests of the clients built for validation as if no object existed, so that the
+// cluster is never contacted
+type offlineTransport struct{}
+
+func (offlineTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	status := &metav1.Status{
+		TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"},
+		Status:   metav1.StatusFailure,
+		Reason:   metav1.StatusReasonNotFound,
+		Code:     http.StatusNotFound,
+		Message:  "the cluster is not contacted during validation",
+	}
+	body, err := json.Marshal(status)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Response{
+		StatusCode: http.StatusNotFound,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       ioutil.NopCloser(bytes.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+// ValidateConfig builds the server of the configuration without starting it, and returns every error it finds with
+// the path of the field that causes it. Identity providers and templates are checked on their own, so that one
+// invalid provider does not hide the errors of the others. The server is built with clients of an API server that
+// does not exist and holds no objects, the cluster is never contacted, but identity providers may be, e.g. to
+// discover OpenID metadata.
+func ValidateConfig(oauthConfig osinv1.OAuthConfig, extendedConfig config.ExtendedOAuthConfig) field.ErrorList {
+	errs := field.ErrorList{}
+	oauthPath := field.NewPath("oauthConfig")
+	providersPath := oauthPath.Child("identityProviders")
+
+	// identity providers that cannot be decoded are left out, the others are still checked
+	decoder := codecs.UniversalDecoder(osinv1.GroupVersion)
+	identityProviders := make([]osinv1.IdentityProvider, 0, len(oauthConfig.IdentityProviders))
+	providerPaths := map[string]*field.Path{}
+	invalidProviders := sets.NewString()
+	for i, identityProvider := range oauthConfig.IdentityProviders {
+		if identityProvider.Provider.Object == nil {
+			object, err := runtime.Decode(decoder, identityProvider.Provider.Raw)
+			if err != nil {
+				errs = append(errs, field.Invalid(providersPath.Index(i).Child("provider"), identityProvider.Name, err.Error()))
+				invalidProviders.Insert(identityProvider.Name)
+				continue
+			}
+			identityProvider.Provider.Object = object
+		}
+		identityProviders = append(identityProviders, identityProvider)
+		providerPaths[identityProvider.Name] = providersPath.Index(i)
+	}
+	oauthConfig.IdentityProviders = identityProviders
+
+	// invalid templates are replaced by the built-in ones, so that they do not fail building the server
+	if templates := oauthConfig.Templates; templates != nil {
+		validTemplates := *templates
+		templatesPath := oauthPath.Child("templates")
+		for _, template := range []struct {
+			name     string
+			file     *string
+			validate func([]byte) []error
+		}{
+			{name: "login", file: &validTemplates.Login, validate: login.ValidateLoginTemplate},
+			{name: "providerSelection", file: &validTemplates.ProviderSelection, validate: selectprovider.ValidateSelectProviderTemplate},
+			{name: "error", file: &validTemplates.Error, validate: errorpage.ValidateErrorPageTemplate},
+		} {
+			if templateErrs := validateTemplate(templatesPath.Child(template.name), *template.file, template.validate); len(templateErrs) > 0 {
+				errs = append(errs, templateErrs...)
+				*template.file = ""
+			}
+		}
+		oauthConfig.Templates = &validTemplates
+	}
+
+	c, err := NewOAuthServerConfig(oauthConfig, extendedConfig, &rest.Config{Host: offlineHost, Transport: offlineTransport{}}, nil)
+	if err != nil {
+		return append(errs, field.Invalid(oauthPath, nil, err.Error()))
+	}
+
+	// the default identity providers that were added are valid
+	validProviders := make([]osinv1.IdentityProvider, 0, len(c.ExtraOAuthConfig.Options.IdentityProviders))
+	for _, identityProvider := range c.ExtraOAuthConfig.Options.IdentityProviders {
+		providerPath, ok := providerPaths[identityProvider.Name]
+		if !ok {
+			validProviders = append(validProviders, identityProvider)
+			continue
+		}
+		var err error
+		switch {
+		case config.IsOAuthIdentityProvider(identityProvider):
+			_, err = c.getOAuthProvider(identityProvider)
+		case config.IsPasswordAuthenticator(identityProvider):
+			_, err = c.getPasswordAuthenticator(identityProvider)
+		}
+		if err != nil {
+			errs = append(errs, field.Invalid(providerPath, identityProvider.Name, err.Error()))
+			invalidProviders.Insert(identityProvider.Name)
+			continue
+		}
+		validProviders = append(validProviders, identityProvider)
+	}
+	c.ExtraOAuthConfig.Options.IdentityProviders = validProviders
+	// issuers do not refer to the invalid identity providers that were left out
+	issuers := make([]config.Issuer, 0, len(extendedConfig.Issuers))
+	for _, issuer := range extendedConfig.Issuers {
+		names := []string{}
+		for _, name := range issuer.IdentityProviders {
+			if !invalidProviders.Has(name) {
+				names = append(names, name)
+			}
+		}
+		issuer.IdentityProviders = names
+		issuers = append(issuers, issuer)
+	}
+	c.ExtraOAuthConfig.ExtendedOptions.Issuers = issuers
+
+	// everything else is checked by building the handlers of all issuers
+	if _, err := c.withIssuers(http.NotFoundHandler()); err != nil {
+		errs = append(errs, field.Invalid(oauthPath, nil, err.Error()))
+	}
+	return errs
+}
+
+// validateTemplate checks that the template file renders the fields its page requires
+func validateTemplate(templatePath *field.Path, file string, validate func([]byte) []error) field.ErrorList {
+	if len(file) == 0 {
+		return nil
+	}
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return field.ErrorList{field.Invalid(templatePath, file, err.Error())}
+	}
+	errs := field.ErrorList{}
+	for _, err := range validate(content) {
+		errs = append(errs, field.Invalid(templatePath, file, err.Error()))
+	}
+	return errs
+}
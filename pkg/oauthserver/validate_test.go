@@ -0,0 +1,97 @@
+package oauthserver
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime"
+
+	osinv1 "github.com/openshift/api/osin/v1"
+
+	"github.com/openshift/oauth-server/pkg/config"
+	"github.com/openshift/oauth-server/pkg/userregistry/identitymapper"
+)
+
+func TestValidateConfig(t *testing.T) {
+	dir := t.TempDir()
+	invalidTemplate := filepath.Join(dir, "login.html")
+	if err := ioutil.WriteFile(invalidTemplate, []byte("{{ .Unclosed"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	identityProvider := func(name string, provider runtime.RawExtension) osinv1.IdentityProvider {
+		return osinv1.IdentityProvider{
+			Name:          name,
+			UseAsLogin:    true,
+			MappingMethod: string(identitymapper.MappingMethodClaim),
+			Provider:      provider,
+		}
+	}
+	oauthConfig := func(identityProviders ...osinv1.IdentityProvider) osinv1.OAuthConfig {
+		return osinv1.OAuthConfig{
+			MasterPublicURL:   "https://oauth.example.com",
+			IdentityProviders: identityProviders,
+			GrantConfig:       osinv1.GrantConfig{Method: osinv1.GrantHandlerAuto},
+			TokenConfig:       osinv1.TokenConfig{AccessTokenMaxAgeSeconds: 86400},
+			SessionConfig:     &osinv1.SessionConfig{SessionName: "ssn", SessionMaxAgeSeconds: 300},
+		}
+	}
+
+	valid := oauthConfig(identityProvider("anypassword", runtime.RawExtension{Object: &osinv1.AllowAllPasswordIdentityProvider{}}))
+	if errs := ValidateConfig(valid, config.ExtendedOAuthConfig{}); len(errs) != 0 {
+		t.Errorf("expected a valid config, got %v", errs)
+	}
+
+	invalid := oauthConfig(
+		identityProvider("htpasswd", runtime.RawExtension{Object: &osinv1.HTPasswdPasswordIdentityProvider{File: filepath.Join(dir, "missing")}}),
+		identityProvider("anypassword", runtime.RawExtension{Object: &osinv1.AllowAllPasswordIdentityProvider{}}),
+		identityProvider("undecodable", runtime.RawExtension{Raw: []byte(`{"kind":"UnknownIdentityProvider","apiVersion":"osin.config.openshift.io/v1"}`)}),
+		identityProvider("github", runtime.RawExtension{Object: &osinv1.GitHubIdentityProvider{ClientID: "client", CA: filepath.Join(dir, "missing-ca")}}),
+	)
+	invalid.Templates = &osinv1.OAuthTemplates{Login: invalidTemplate}
+	// the issuer is still built without its invalid identity provider
+	extendedConfig := config.ExtendedOAuthConfig{
+		Issuers: []config.Issuer{{Name: "tenant", URL: "https://tenant.example.com", IdentityProviders: []string{"anypassword", "github"}}},
+	}
+
+	fields := []string{}
+	for _, err := range ValidateConfig(invalid, extendedConfig) {
+		fields = append(fields, err.Field)
+	}
+	expected := []string{
+		"oauthConfig.identityProviders[2].provider",
+		"oauthConfig.templates.login",
+		"oauthConfig.identityProviders[0]",
+		"oauthConfig.identityProviders[3]",
+	}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Errorf("expected errors of %v, got %v", expected, fields)
+	}
+
+	// providers that revoke their tokens after the login cannot keep them
+	revoking := oauthConfig(
+		identityProvider("gitlab", runtime.RawExtension{Object: &osinv1.GitLabIdentityProvider{URL: "https://gitlab.example.com", ClientID: "client"}}),
+		identityProvider("github", runtime.RawExtension{Object: &osinv1.GitHubIdentityProvider{ClientID: "client"}}),
+		identityProvider("github-kept", runtime.RawExtension{Object: &osinv1.GitHubIdentityProvider{ClientID: "client"}}),
+	)
+	extendedConfig = config.ExtendedOAuthConfig{
+		IdentityProviders: []config.IdentityProviderExtension{
+			{Name: "gitlab", KeepUpstreamTokens: true},
+			{Name: "github", KeepUpstreamTokens: true, GitHub: &config.GitHubExtension{RevokeToken: true}},
+			{Name: "github-kept", GitHub: &config.GitHubExtension{RevokeToken: true}},
+		},
+	}
+	fields = []string{}
+	for _, err := range ValidateConfig(revoking, extendedConfig) {
+		fields = append(fields, err.Field)
+	}
+	expected = []string{
+		"oauthConfig.identityProviders[0]",
+		"oauthConfig.identityProviders[1]",
+	}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Errorf("expected errors of %v, got %v", expected, fields)
+	}
+}
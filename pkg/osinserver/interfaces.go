@@ -33,12 +33,54 @@ func (all AuthorizeHandlers) HandleAuthorize(ar *osin.AuthorizeRequest, resp *os
 	return false, nil
 }
 
+// AuthorizeCodec changes how authorize requests are read and how the responses that redirect to clients
+// are written, e.g. to support signed request objects and responses
+type AuthorizeCodec interface {
+	// DecodeAuthorizeRequest may replace the parameters of an authorize request before it is handled.
+	// Invalid requests are turned into requests with an error parameter, which is returned to the client.
+	DecodeAuthorizeRequest(r *http.Request)
+	// EncodeAuthorizeResponse may change the parameters of a response that redirects to the client
+	EncodeAuthorizeResponse(resp *osin.Response, r *http.Request) error
+}
+
+// GrantDecoder supports extension grants that are handled as grants osin knows, e.g. device codes that are
+// redeemed as the authorize codes issued once users approved the devices
+type GrantDecoder interface {
+	// DecodeAccessRequest may replace the parameters of a token request before it is handled. Requests it
+	// rejects return an error, which is returned to the client.
+	DecodeAccessRequest(r *http.Request) *AccessError
+}
+
+// RedirectURIMatcher matches the redirect URIs of authorize and token requests against the redirect URIs registered
+// for their clients, instead of osin, which matches the scheme and host of a registered URI and its path or a subpath
+type RedirectURIMatcher interface {
+	// MatchRedirectURI returns whether redirectURI is one of the registered redirect URIs of the client
+	MatchRedirectURI(clientID string, registered []string, redirectURI string) bool
+}
+
 // AccessHandler populates an AccessRequest
 type AccessHandler interface {
 	// HandleAccess populates an AccessRequest (typically the Authorized and UserData fields)
 	HandleAccess(ar *osin.AccessRequest, w http.ResponseWriter) error
 }
 
+// AccessError is returned by access handlers to reject a token request with an OAuth error response
+// rather than an internal error
+type AccessError struct {
+	Code        string
+	Description string
+}
+
+func (e *AccessError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+// TokenType is implemented by user data whose access tokens are not bearer tokens
+type TokenType interface {
+	// GetTokenType returns the token type of the access token response, or an empty string for the default type
+	GetTokenType() string
+}
+
 type AccessHandlerFunc func(ar *osin.AccessRequest, w http.ResponseWriter) error
 
 func (f AccessHandlerFunc) HandleAccess(ar *osin.AccessRequest, w http.ResponseWriter) error {
@@ -56,6 +98,32 @@ func (all AccessHandlers) HandleAccess(ar *osin.AccessRequest, w http.ResponseWr
 	return nil
 }
 
+// ClientAuthenticator authenticates clients at the token endpoint by other means than their secret,
+// e.g. with the certificate of the TLS connection
+type ClientAuthenticator interface {
+	// AuthenticateClient returns the ID of the client the request authenticates, or an empty ID if the client
+	// must authenticate with its secret. Requests that fail to authenticate the client return an error.
+	AuthenticateClient(r *http.Request) (clientID string, err error)
+}
+
+// InfoHandler checks requests for information about an access token
+type InfoHandler interface {
+	// HandleInfo may add to the output of the response, or populates its error fields if the request
+	// may not see the access token
+	HandleInfo(ir *osin.InfoRequest, resp *osin.Response, r *http.Request)
+}
+
+type InfoHandlers []InfoHandler
+
+func (all InfoHandlers) HandleInfo(ir *osin.InfoRequest, resp *osin.Response, r *http.Request) {
+	for _, h := range all {
+		if resp.IsError {
+			return
+		}
+		h.HandleInfo(ir, resp, r)
+	}
+}
+
 // ErrorHandler writes an error response
 type ErrorHandler interface {
 	// HandleError writes an error response
@@ -1,9 +1,12 @@
 package osinserver
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"path"
+	"strings"
 
 	"github.com/openshift/osin"
 	"k8s.io/klog/v2"
@@ -12,6 +15,7 @@ import (
 
 	"github.com/openshift/library-go/pkg/oauth/oauthdiscovery"
 	oauthserver "github.com/openshift/oauth-server/pkg"
+	"github.com/openshift/oauth-server/pkg/oauth/tokenformat"
 )
 
 type osinServer struct {
@@ -20,6 +24,11 @@ type osinServer struct {
 	authorize    AuthorizeHandler
 	access       AccessHandler
 	errorHandler ErrorHandler
+	codec        AuthorizeCodec
+	clientAuth   ClientAuthenticator
+	info         InfoHandler
+	grants       GrantDecoder
+	redirects    RedirectURIMatcher
 }
 
 // Logger captures additional osin server errors
@@ -31,12 +40,14 @@ func (l Logger) Printf(format string, v ...interface{}) {
 	}
 }
 
-func New(config *osin.ServerConfig, storage osin.Storage, authorize AuthorizeHandler, access AccessHandler, errorHandler ErrorHandler) oauthserver.Endpoints {
+// New returns the OAuth endpoints. The codec, client authenticator, info handler, grant decoder and redirect URI
+// matcher are optional. Tokens are issued in the first of the formats, which default to sha256~ tokens.
+func New(config *osin.ServerConfig, storage osin.Storage, authorize AuthorizeHandler, access AccessHandler, errorHandler ErrorHandler, codec AuthorizeCodec, clientAuth ClientAuthenticator, info InfoHandler, formats tokenformat.Formats, grants GrantDecoder, redirects RedirectURIMatcher) oauthserver.Endpoints {
 	server := osin.NewServer(config, storage)
 
 	// Override tokengen to ensure we get valid length tokens
-	server.AuthorizeTokenGen = TokenGen{}
-	server.AccessTokenGen = TokenGen{}
+	server.AuthorizeTokenGen = TokenGen{Formats: formats}
+	server.AccessTokenGen = TokenGen{Formats: formats}
 	server.Logger = Logger{}
 
 	return &osinServer{
@@ -45,6 +56,11 @@ func New(config *osin.ServerConfig, storage osin.Storage, authorize AuthorizeHan
 		authorize:    authorize,
 		access:       access,
 		errorHandler: errorHandler,
+		codec:        codec,
+		clientAuth:   clientAuth,
+		info:         info,
+		grants:       grants,
+		redirects:    redirects,
 	}
 }
 
@@ -58,7 +74,17 @@ func (s *osinServer) handleAuthorize(w http.ResponseWriter, r *http.Request) {
 	resp := s.server.NewResponse()
 	defer resp.Close()
 
+	if s.codec != nil {
+		s.codec.DecodeAuthorizeRequest(r)
+	}
+	// osin unescapes the redirect URIs of authorize requests
+	redirects := s.matchRedirectURIs(resp, func() string {
+		redirectURI, _ := url.QueryUnescape(r.Form.Get("redirect_uri"))
+		return redirectURI
+	})
+
 	if ar := s.server.HandleAuthorizeRequest(resp, r); ar != nil {
+		ar.Client = unwrapClient(ar.Client)
 
 		if errorCode := r.FormValue("error"); len(errorCode) != 0 {
 
@@ -85,11 +111,19 @@ func (s *osinServer) handleAuthorize(w http.ResponseWriter, r *http.Request) {
 			s.server.FinishAuthorizeRequest(resp, r, ar)
 
 		}
+	} else {
+		redirects.rejectUnmatched(resp, r)
 	}
 
 	if resp.IsError && resp.InternalError != nil {
 		utilruntime.HandleError(fmt.Errorf("internal error: %s", resp.InternalError))
 	}
+	if s.codec != nil && resp.Type == osin.REDIRECT {
+		if err := s.codec.EncodeAuthorizeResponse(resp, r); err != nil {
+			s.errorHandler.HandleError(err, w, r)
+			return
+		}
+	}
 	if err := osin.OutputJSON(resp, w, r); err != nil {
 		klog.Infof("output JSON through osin: %v", err)
 		http.Error(w, "an internal error occured", http.StatusInternalServerError)
@@ -100,12 +134,33 @@ func (s *osinServer) handleToken(w http.ResponseWriter, r *http.Request) {
 	resp := s.server.NewResponse()
 	defer resp.Close()
 
-	if ar := s.server.HandleAccessRequest(resp, r); ar != nil {
+	redirects := s.matchRedirectURIs(resp, func() string {
+		return r.Form.Get("redirect_uri")
+	})
+	decodeTokenExchange(r)
+	if err := s.decodeGrant(r); err != nil {
+		resp.SetError(err.Code, err.Description)
+	} else if err := s.authenticateClient(resp, r); err != nil {
+		klog.V(4).Infof("client authentication failed: %v", err)
+		resp.ErrorStatusCode = http.StatusUnauthorized
+		resp.SetError(osin.E_INVALID_CLIENT, "")
+	} else if ar := s.server.HandleAccessRequest(resp, r); ar != nil {
+		ar.Client = unwrapClient(ar.Client)
 		if err := s.access.HandleAccess(ar, w); err != nil {
-			s.errorHandler.HandleError(err, w, r)
-			return
+			accessErr := &AccessError{}
+			if !errors.As(err, &accessErr) {
+				s.errorHandler.HandleError(err, w, r)
+				return
+			}
+			resp.SetError(accessErr.Code, accessErr.Description)
 		}
 		s.server.FinishAccessRequest(resp, r, ar)
+		if tokenType, ok := ar.UserData.(TokenType); ok && !resp.IsError && len(tokenType.GetTokenType()) > 0 {
+			resp.Output["token_type"] = tokenType.GetTokenType()
+		}
+		encodeTokenExchange(resp, ar)
+	} else {
+		redirects.rejectUnmatched(resp, r)
 	}
 	if resp.IsError && resp.InternalError != nil {
 		utilruntime.HandleError(fmt.Errorf("internal error: %s", resp.InternalError))
@@ -120,7 +175,15 @@ func (s *osinServer) handleInfo(w http.ResponseWriter, r *http.Request) {
 	resp := s.server.NewResponse()
 	defer resp.Close()
 
+	// tokens bound to DPoP keys are sent with the DPoP scheme, the info handlers check their proofs
+	if scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " "); ok && strings.EqualFold(scheme, "DPoP") {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+
 	if ir := s.server.HandleInfoRequest(resp, r); ir != nil {
+		if s.info != nil {
+			s.info.HandleInfo(ir, resp, r)
+		}
 		s.server.FinishInfoRequest(resp, r, ir)
 	}
 	if err := osin.OutputJSON(resp, w, r); err != nil {
@@ -128,3 +191,130 @@ func (s *osinServer) handleInfo(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "an internal error occured", http.StatusInternalServerError)
 	}
 }
+
+// decodeGrant passes the request to the grant decoder, if any
+func (s *osinServer) decodeGrant(r *http.Request) *AccessError {
+	if s.grants == nil {
+		return nil
+	}
+	return s.grants.DecodeAccessRequest(r)
+}
+
+// authenticateClient lets the clients the client authenticator authenticates skip their secret
+func (s *osinServer) authenticateClient(resp *osin.Response, r *http.Request) error {
+	if s.clientAuth == nil {
+		return nil
+	}
+	clientID, err := s.clientAuth.AuthenticateClient(r)
+	if err != nil || len(clientID) == 0 {
+		return err
+	}
+	// osin takes the client credentials from the parameters if the secret parameter is set
+	r.Form.Set("client_id", clientID)
+	r.Form.Set("client_secret", "")
+	resp.Storage = &authenticatedStorage{Storage: resp.Storage, clientID: clientID}
+	return nil
+}
+
+// authenticatedStorage returns an already authenticated client, whose secret is not checked
+type authenticatedStorage struct {
+	osin.Storage
+	clientID string
+}
+
+func (s *authenticatedStorage) GetClient(id string) (osin.Client, error) {
+	client, err := s.Storage.GetClient(id)
+	if err != nil || client == nil || id != s.clientID {
+		return client, err
+	}
+	return &authenticatedClient{Client: client}, nil
+}
+
+type authenticatedClient struct {
+	osin.Client
+}
+
+func (c *authenticatedClient) ClientSecretMatches(string) bool {
+	return true
+}
+
+// unwrapClient returns the client of the storage that the wrappers of the server replaced, the handlers may rely on
+// its type
+func unwrapClient(client osin.Client) osin.Client {
+	for {
+		switch wrapper := client.(type) {
+		case *authenticatedClient:
+			client = wrapper.Client
+		case *redirectClient:
+			client = wrapper.Client
+		default:
+			return client
+		}
+	}
+}
+
+// matchRedirectURIs lets the redirect URI matcher, if any, match the redirect URI of the request instead of osin
+func (s *osinServer) matchRedirectURIs(resp *osin.Response, redirectURI func() string) *redirectStorage {
+	if s.redirects == nil {
+		return nil
+	}
+	storage := &redirectStorage{Storage: resp.Storage, matcher: s.redirects, separator: s.config.RedirectUriSeparator, redirectURI: redirectURI}
+	resp.Storage = storage
+	return storage
+}
+
+// redirectStorage returns clients whose redirect URIs are matched by the redirect URI matcher
+type redirectStorage struct {
+	osin.Storage
+	matcher     RedirectURIMatcher
+	separator   string
+	redirectURI func() string
+	// unmatched is the redirect URI of the request if it did not match
+	unmatched string
+}
+
+func (s *redirectStorage) GetClient(id string) (osin.Client, error) {
+	client, err := s.Storage.GetClient(id)
+	if err != nil || client == nil {
+		return client, err
+	}
+	return &redirectClient{Client: client, storage: s}, nil
+}
+
+// rejectUnmatched replaces the error of a request whose redirect URI did not match, osin rejects the clients
+// without redirect URIs the matcher returns as unauthorized
+func (s *redirectStorage) rejectUnmatched(resp *osin.Response, r *http.Request) {
+	if s == nil || len(s.unmatched) == 0 {
+		return
+	}
+	resp.SetErrorState(osin.E_INVALID_REQUEST, "", r.Form.Get("state"))
+	resp.InternalError = fmt.Errorf("redirect uri %q is not registered", s.unmatched)
+}
+
+type redirectClient struct {
+	osin.Client
+	storage *redirectStorage
+}
+
+func (c *redirectClient) ClientSecretMatches(secret string) bool {
+	return osin.CheckClientSecret(c.Client, secret)
+}
+
+// GetRedirectUri returns only the redirect URI of the request if it matches, which osin accepts, or no redirect URIs
+// if it does not, which osin rejects. Requests without redirect URI use the first registered one.
+func (c *redirectClient) GetRedirectUri() string {
+	registered := c.Client.GetRedirectUri()
+	redirectURI := c.storage.redirectURI()
+	if len(registered) == 0 || len(redirectURI) == 0 {
+		return registered
+	}
+	registeredURIs := []string{registered}
+	if separator := c.storage.separator; len(separator) > 0 {
+		registeredURIs = strings.Split(registered, separator)
+	}
+	if !c.storage.matcher.MatchRedirectURI(c.GetId(), registeredURIs, redirectURI) {
+		c.storage.unmatched = redirectURI
+		return ""
+	}
+	return redirectURI
+}
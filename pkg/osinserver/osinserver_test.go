@@ -4,6 +4,8 @@ import (
 	"context"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
 
 	"github.com/RangelReale/osincli"
@@ -33,6 +35,12 @@ func TestClientCredentialFlow(t *testing.T) {
 			return nil
 		}),
 		NewDefaultErrorHandler(),
+		nil,
+		nil,
+		nil,
+		nil,
+		nil,
+		nil,
 	)
 	mux := http.NewServeMux()
 	oauthServer.Install(mux, "")
@@ -76,6 +84,12 @@ func TestAuthorizeStartFlow(t *testing.T) {
 			return nil
 		}),
 		NewDefaultErrorHandler(),
+		nil,
+		nil,
+		nil,
+		nil,
+		nil,
+		nil,
 	)
 	mux := http.NewServeMux()
 	oauthServer.Install(mux, "")
@@ -140,3 +154,77 @@ func TestAuthorizeStartFlow(t *testing.T) {
 		t.Errorf("unexpected empty access token: %#v", token)
 	}
 }
+
+type loopbackMatcher struct{}
+
+func (loopbackMatcher) MatchRedirectURI(clientID string, registered []string, redirectURI string) bool {
+	return redirectURI == "http://127.0.0.1:51004/callback"
+}
+
+func TestRedirectURIMatcher(t *testing.T) {
+	storage := teststorage.New()
+	storage.Clients["test"] = &osin.DefaultClient{
+		Id:          "test",
+		Secret:      "secret",
+		RedirectUri: "http://127.0.0.1/callback",
+	}
+	oauthServer := New(
+		NewDefaultServerConfig(),
+		storage,
+		AuthorizeHandlerFunc(func(ar *osin.AuthorizeRequest, resp *osin.Response, w http.ResponseWriter) (bool, error) {
+			if _, ok := ar.Client.(*osin.DefaultClient); !ok {
+				t.Errorf("expected the client of the storage, got %#v", ar.Client)
+			}
+			ar.Authorized = true
+			return false, nil
+		}),
+		AccessHandlerFunc(func(ar *osin.AccessRequest, w http.ResponseWriter) error {
+			ar.Authorized = true
+			ar.GenerateRefresh = false
+			return nil
+		}),
+		NewDefaultErrorHandler(),
+		nil,
+		nil,
+		nil,
+		nil,
+		nil,
+		loopbackMatcher{},
+	)
+	mux := http.NewServeMux()
+	oauthServer.Install(mux, "")
+
+	authorize := func(redirectURI string) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		query := url.Values{"client_id": {"test"}, "response_type": {"code"}, "redirect_uri": {redirectURI}, "state": {"abc"}}
+		mux.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/authorize?"+query.Encode(), nil))
+		return resp
+	}
+	token := func(code, redirectURI string) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		form := url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {redirectURI}}
+		req := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("test", "secret")
+		mux.ServeHTTP(resp, req)
+		return resp
+	}
+
+	// osin accepts the subpath, the matcher does not
+	if resp := authorize("http://127.0.0.1/callback/sub"); resp.Code != http.StatusBadRequest || !strings.Contains(resp.Body.String(), osin.E_INVALID_REQUEST) {
+		t.Errorf("expected the redirect URI to be rejected, got %d %s", resp.Code, resp.Body.String())
+	}
+
+	resp := authorize("http://127.0.0.1:51004/callback")
+	location, err := url.Parse(resp.Header().Get("Location"))
+	if resp.Code != http.StatusFound || err != nil || location.Host != "127.0.0.1:51004" {
+		t.Fatalf("expected a redirect to the loopback port, got %d %s", resp.Code, resp.Header().Get("Location"))
+	}
+	code := location.Query().Get("code")
+	if resp := token(code, "http://127.0.0.1:51005/callback"); resp.Code != http.StatusBadRequest || !strings.Contains(resp.Body.String(), osin.E_INVALID_REQUEST) {
+		t.Errorf("expected the token request to be rejected, got %d %s", resp.Code, resp.Body.String())
+	}
+	if resp := token(code, "http://127.0.0.1:51004/callback"); resp.Code != http.StatusOK || storage.AccessData == nil {
+		t.Errorf("expected a token, got %d %s", resp.Code, resp.Body.String())
+	}
+}
@@ -0,0 +1,131 @@
+package registrystorage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/openshift/osin"
+
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	kuser "k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/klog/v2"
+
+	oauthapi "github.com/openshift/api/oauth/v1"
+	oauthclient "github.com/openshift/client-go/oauth/clientset/versioned/typed/oauth/v1"
+)
+
+// TokenLimit restricts the number of active access tokens per user. Once a user holds the maximum
+// number of tokens, new tokens are either rejected or the oldest tokens of the user are deleted.
+// The limit is checked before each token is saved, concurrent logins of the same user may exceed it.
+type TokenLimit struct {
+	accessTokens oauthclient.OAuthAccessTokenInterface
+	max          int
+	evictOldest  bool
+}
+
+// NewTokenLimit returns a limit of max active access tokens per user
+func NewTokenLimit(accessTokens oauthclient.OAuthAccessTokenInterface, max int, evictOldest bool) *TokenLimit {
+	return &TokenLimit{
+		accessTokens: accessTokens,
+		max:          max,
+		evictOldest:  evictOldest,
+	}
+}
+
+// Exceeded returns true if new access tokens of the user are rejected because the user holds the
+// maximum number of tokens. It is always false if the oldest tokens are evicted instead.
+func (l *TokenLimit) Exceeded(user kuser.Info) (bool, error) {
+	if l.evictOldest {
+		return false, nil
+	}
+	tokens, err := l.activeTokens(user.GetName(), user.GetUID())
+	if err != nil {
+		return false, err
+	}
+	return len(tokens) >= l.max, nil
+}
+
+// Storage returns storage that enforces the limit whenever an access token is saved
+func (l *TokenLimit) Storage(storage osin.Storage) osin.Storage {
+	return &limitedStorage{Storage: storage, limit: l}
+}
+
+// admit makes room for a new access token of the user or returns an error if it is rejected
+func (l *TokenLimit) admit(name, uid string) error {
+	tokens, err := l.activeTokens(name, uid)
+	if err != nil {
+		return err
+	}
+	if len(tokens) < l.max {
+		return nil
+	}
+	if !l.evictOldest {
+		return fmt.Errorf("user %q holds the maximum of %d access tokens", name, l.max)
+	}
+
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].CreationTimestamp.Before(&tokens[j].CreationTimestamp)
+	})
+	for _, token := range tokens[:len(tokens)-l.max+1] {
+		err := l.accessTokens.Delete(context.TODO(), token.Name, metav1.DeleteOptions{Preconditions: metav1.NewUIDPreconditions(string(token.UID))})
+		if err != nil && !kerrors.IsNotFound(err) {
+			return fmt.Errorf("error evicting access token %q of user %q: %v", token.Name, name, err)
+		}
+		klog.V(4).Infof("evicted access token %q of user %q", token.Name, name)
+	}
+	return nil
+}
+
+// activeTokens returns the access tokens of the user that have neither expired nor timed out
+func (l *TokenLimit) activeTokens(name, uid string) ([]oauthapi.OAuthAccessToken, error) {
+	tokens, err := l.accessTokens.List(context.TODO(), metav1.ListOptions{FieldSelector: fields.OneTermEqualSelector("userName", name).String()})
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	active := []oauthapi.OAuthAccessToken{}
+	for _, token := range tokens.Items {
+		if token.UserName == name && token.UserUID == uid && TokenActive(&token, now) {
+			active = append(active, token)
+		}
+	}
+	return active, nil
+}
+
+type limitedStorage struct {
+	osin.Storage
+	limit *TokenLimit
+}
+
+func (s *limitedStorage) Clone() osin.Storage {
+	return &limitedStorage{Storage: s.Storage.Clone(), limit: s.limit}
+}
+
+// SaveAccess enforces the limit before the access token is saved
+func (s *limitedStorage) SaveAccess(data *osin.AccessData) error {
+	name, uid, err := convertFromUser(data.UserData)
+	if err != nil {
+		return err
+	}
+	if err := s.limit.admit(name, uid); err != nil {
+		return err
+	}
+	return s.Storage.SaveAccess(data)
+}
+
+// TokenActive returns true if the access token has neither expired nor timed out at the given time.
+// Each use of a token with an inactivity timeout extends the timeout, so it is relative to the creation.
+func TokenActive(token *oauthapi.OAuthAccessToken, now time.Time) bool {
+	created := token.CreationTimestamp.Time
+	if token.ExpiresIn > 0 && created.Add(time.Duration(token.ExpiresIn)*time.Second).Before(now) {
+		return false
+	}
+	if token.InactivityTimeoutSeconds > 0 && created.Add(time.Duration(token.InactivityTimeoutSeconds)*time.Second).Before(now) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,117 @@
+package registrystorage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/openshift/osin"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apiserver/pkg/authentication/user"
+
+	oauthapi "github.com/openshift/api/oauth/v1"
+	oauthfake "github.com/openshift/client-go/oauth/clientset/versioned/fake"
+)
+
+// savedStorage records the access tokens it is asked to save
+type savedStorage struct {
+	osin.Storage
+	saved []string
+}
+
+func (s *savedStorage) Clone() osin.Storage {
+	return s
+}
+
+func (s *savedStorage) SaveAccess(data *osin.AccessData) error {
+	s.saved = append(s.saved, data.AccessToken)
+	return nil
+}
+
+func limitTestToken(name, userName, userUID string, created time.Time, expiresIn int64) *oauthapi.OAuthAccessToken {
+	return &oauthapi.OAuthAccessToken{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			UID:               types.UID("uid-" + name),
+			CreationTimestamp: metav1.NewTime(created),
+		},
+		UserName:  userName,
+		UserUID:   userUID,
+		ExpiresIn: expiresIn,
+	}
+}
+
+func TestTokenLimit(t *testing.T) {
+	now := time.Now()
+	bob := &user.DefaultInfo{Name: "bob", UID: "bob-uid"}
+
+	tests := []struct {
+		name        string
+		evictOldest bool
+
+		expectExceeded bool
+		expectSaved    bool
+		expectTokens   []string
+	}{
+		{
+			name:           "reject",
+			expectExceeded: true,
+			expectTokens:   []string{"sha256~expired", "sha256~newer", "sha256~oldest", "sha256~other-user", "sha256~recreated-user"},
+		},
+		{
+			name:         "evict oldest",
+			evictOldest:  true,
+			expectSaved:  true,
+			expectTokens: []string{"sha256~expired", "sha256~newer", "sha256~other-user", "sha256~recreated-user"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			accessTokens := oauthfake.NewSimpleClientset(
+				limitTestToken("sha256~oldest", "bob", "bob-uid", now.Add(-2*time.Hour), 0),
+				limitTestToken("sha256~newer", "bob", "bob-uid", now.Add(-time.Hour), 0),
+				limitTestToken("sha256~expired", "bob", "bob-uid", now.Add(-3*time.Hour), 60),
+				limitTestToken("sha256~recreated-user", "bob", "old-bob-uid", now.Add(-3*time.Hour), 0),
+				limitTestToken("sha256~other-user", "alice", "alice-uid", now.Add(-3*time.Hour), 0),
+			).OauthV1().OAuthAccessTokens()
+			limit := NewTokenLimit(accessTokens, 2, tt.evictOldest)
+
+			exceeded, err := limit.Exceeded(bob)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if exceeded != tt.expectExceeded {
+				t.Errorf("expected exceeded %v, got %v", tt.expectExceeded, exceeded)
+			}
+			if exceeded, err := limit.Exceeded(&user.DefaultInfo{Name: "alice", UID: "alice-uid"}); err != nil || exceeded {
+				t.Errorf("expected limit of other user not to be exceeded, got %v %v", exceeded, err)
+			}
+
+			saved := &savedStorage{}
+			err = limit.Storage(saved).Clone().SaveAccess(&osin.AccessData{AccessToken: "new", UserData: bob})
+			if saved := len(saved.saved) == 1; saved != tt.expectSaved || (err == nil) != tt.expectSaved {
+				t.Errorf("expected token saved %v, got %v (%v)", tt.expectSaved, saved, err)
+			}
+
+			list, err := accessTokens.List(context.TODO(), metav1.ListOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			names := []string{}
+			for _, token := range list.Items {
+				names = append(names, token.Name)
+			}
+			if len(names) != len(tt.expectTokens) {
+				t.Fatalf("expected tokens %v, got %v", tt.expectTokens, names)
+			}
+			for i := range names {
+				if names[i] != tt.expectTokens[i] {
+					t.Errorf("expected tokens %v, got %v", tt.expectTokens, names)
+					break
+				}
+			}
+		})
+	}
+}
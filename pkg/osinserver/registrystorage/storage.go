@@ -2,9 +2,12 @@ package registrystorage
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/openshift/osin"
 	"gopkg.in/square/go-jose.v2/jwt"
@@ -23,16 +26,49 @@ import (
 
 	"github.com/openshift/oauth-server/pkg/api"
 	"github.com/openshift/oauth-server/pkg/oauth/handlers"
+	"github.com/openshift/oauth-server/pkg/oauth/tokenformat"
 	"github.com/openshift/oauth-server/pkg/scopecovers"
 	"github.com/openshift/oauth-server/pkg/server/crypto"
 )
 
+// UserAgentAnnotation records the user agent of the request that asked for a token
+const UserAgentAnnotation = "oauth.openshift.io/user-agent"
+
+// CertificateThumbprintAnnotation records the thumbprint of the client certificate an access token is bound to
+const CertificateThumbprintAnnotation = "oauth.openshift.io/x5t-s256"
+
+// ProofKeyThumbprintAnnotation records the thumbprint of the DPoP key an access token is bound to
+const ProofKeyThumbprintAnnotation = "oauth.openshift.io/dpop-jkt"
+
+// ActorAnnotation records the user that acts for the user of a delegated access token
+const ActorAnnotation = "oauth.openshift.io/actor"
+
+// PriorActorsAnnotation records the users that the actor of a token delegated more than once acts for in turn, as a
+// JSON list with the most recent first
+const PriorActorsAnnotation = "oauth.openshift.io/prior-actors"
+
+// AudiencesAnnotation records the comma separated audiences an access token is restricted to
+const AudiencesAnnotation = "oauth.openshift.io/audiences"
+
+// AuthenticationMethodsAnnotation records the space separated methods the user of a token authenticated with
+const AuthenticationMethodsAnnotation = "oauth.openshift.io/amr"
+
+// AuthenticationContextClassAnnotation records the authentication context class the login of a token satisfied
+const AuthenticationContextClassAnnotation = "oauth.openshift.io/acr"
+
+// AuthenticationTimeAnnotation records when the user of a token logged in, in seconds since the epoch
+const AuthenticationTimeAnnotation = "oauth.openshift.io/auth-time"
+
+// ExtraAnnotation records the extra user info of the user of a token as a JSON object
+const ExtraAnnotation = "oauth.openshift.io/extra"
+
 type storage struct {
 	accesstoken    oauthclient.OAuthAccessTokenInterface
 	authorizetoken oauthclient.OAuthAuthorizeTokenInterface
 	tokenReview    authenticationv1client.TokenReviewInterface
 	client         api.OAuthClientGetter
 	tokentimeout   int32
+	formats        tokenformat.Formats
 }
 
 func New(
@@ -41,13 +77,18 @@ func New(
 	client api.OAuthClientGetter,
 	tokenReview authenticationv1client.TokenReviewInterface,
 	tokentimeout int32,
+	formats tokenformat.Formats,
 ) osin.Storage {
+	if len(formats) == 0 {
+		formats = tokenformat.Default
+	}
 	return &storage{
 		accesstoken:    access,
 		authorizetoken: authorize,
 		client:         client,
 		tokentimeout:   tokentimeout,
 		tokenReview:    tokenReview,
+		formats:        formats,
 	}
 }
 
@@ -175,6 +216,10 @@ func (s *storage) SaveAuthorize(data *osin.AuthorizeData) error {
 // Client information MUST be loaded together.
 // Optionally can return error if expired.
 func (s *storage) LoadAuthorize(code string) (*osin.AuthorizeData, error) {
+	if !s.formats.Accepts(code) {
+		klog.V(5).Info("Authorization code has a format that is not accepted")
+		return nil, nil
+	}
 	authorize, err := s.authorizetoken.Get(context.TODO(), TokenToObjectName(code), metav1.GetOptions{})
 	if kerrors.IsNotFound(err) {
 		klog.V(5).Info("Authorization code not found")
@@ -207,6 +252,10 @@ func (s *storage) SaveAccess(data *osin.AccessData) error {
 // AuthorizeData and AccessData DON'T NEED to be loaded if not easily available.
 // Optionally can return error if expired.
 func (s *storage) LoadAccess(code string) (*osin.AccessData, error) {
+	if !s.formats.Accepts(code) {
+		// tokens of formats that are no longer accepted are treated like unknown tokens
+		return nil, kerrors.NewNotFound(oauthapi.Resource("oauthaccesstokens"), "")
+	}
 	access, err := s.accesstoken.Get(context.TODO(), TokenToObjectName(code), metav1.GetOptions{})
 	if err != nil {
 		return nil, err
@@ -251,11 +300,13 @@ func (s *storage) convertToAuthorizeToken(data *osin.AuthorizeData) (*oauthapi.O
 	if token.UserName, token.UserUID, err = convertFromUser(data.UserData); err != nil {
 		return nil, err
 	}
+	setUserAgent(&token.ObjectMeta, data.UserData)
+	setBindings(&token.ObjectMeta, data.UserData)
 	return token, nil
 }
 
 func (s *storage) convertFromAuthorizeToken(code string, authorize *oauthapi.OAuthAuthorizeToken) (*osin.AuthorizeData, error) {
-	user, err := convertFromToken(authorize.UserName, authorize.UserUID)
+	user, err := convertFromToken(authorize.UserName, authorize.UserUID, authorize.Annotations)
 	if err != nil {
 		return nil, err
 	}
@@ -277,7 +328,8 @@ func (s *storage) convertFromAuthorizeToken(code string, authorize *oauthapi.OAu
 		RedirectUri:         authorize.RedirectURI,
 		State:               authorize.State,
 		CreatedAt:           authorize.CreationTimestamp.Time,
-		UserData:            user,
+		// the access token is issued to the user agent and for the audiences that were authorized
+		UserData: bindings(handlers.WithUserAgent(user, authorize.Annotations[UserAgentAnnotation]), authorize.Annotations),
 	}, nil
 }
 
@@ -301,6 +353,8 @@ func (s *storage) convertToAccessToken(data *osin.AccessData) (*oauthapi.OAuthAc
 	if token.UserName, token.UserUID, err = convertFromUser(data.UserData); err != nil {
 		return nil, err
 	}
+	setUserAgent(&token.ObjectMeta, data.UserData)
+	setBindings(&token.ObjectMeta, data.UserData)
 
 	token.InactivityTimeoutSeconds = s.tokentimeout
 	// Check if we have a client specific inactivity Timeout to set
@@ -314,7 +368,7 @@ func (s *storage) convertToAccessToken(data *osin.AccessData) (*oauthapi.OAuthAc
 }
 
 func (s *storage) convertFromAccessToken(code string, access *oauthapi.OAuthAccessToken) (*osin.AccessData, error) {
-	user, err := convertFromToken(access.UserName, access.UserUID)
+	user, err := convertFromToken(access.UserName, access.UserUID, access.Annotations)
 	if err != nil {
 		return nil, err
 	}
@@ -325,6 +379,8 @@ func (s *storage) convertFromAccessToken(code string, access *oauthapi.OAuthAcce
 	if err := scopemetadata.ValidateScopeRestrictions(client, access.Scopes...); err != nil {
 		return nil, err
 	}
+	// the token stays bound to the keys and parties it was issued for
+	userData := bindings(user, access.Annotations)
 
 	return &osin.AccessData{
 		AccessToken:  code,
@@ -334,7 +390,7 @@ func (s *storage) convertFromAccessToken(code string, access *oauthapi.OAuthAcce
 		Scope:        scopecovers.Join(access.Scopes),
 		RedirectUri:  access.RedirectURI,
 		CreatedAt:    access.CreationTimestamp.Time,
-		UserData:     user,
+		UserData:     userData,
 	}, nil
 }
 
@@ -353,23 +409,111 @@ func convertFromUser(user interface{}) (name, uid string, err error) {
 	return name, uid, nil
 }
 
-func convertFromToken(name, uid string) (kuser.Info, error) {
+func convertFromToken(name, uid string, annotations map[string]string) (kuser.Info, error) {
 	if len(name) == 0 || len(uid) == 0 {
 		return nil, fmt.Errorf("token has no user name or UID stored: name=%s uid=%s", name, uid) // should be impossible
 	}
 
 	return &kuser.DefaultInfo{
-		Name: name,
-		UID:  uid,
+		Name:  name,
+		UID:   uid,
+		Extra: Extra(annotations),
 	}, nil
 }
 
+// Extra returns the extra user info recorded in the annotations of a token, if any
+func Extra(annotations map[string]string) map[string][]string {
+	encoded := annotations[ExtraAnnotation]
+	if len(encoded) == 0 {
+		return nil
+	}
+	var extra map[string][]string
+	if err := json.Unmarshal([]byte(encoded), &extra); err != nil {
+		klog.V(4).Infof("ignoring invalid extra user info of token: %v", err)
+		return nil
+	}
+	return extra
+}
+
+// setUserAgent records the user agent that requested the token, if it is known
+func setUserAgent(meta *metav1.ObjectMeta, userData interface{}) {
+	userAgent, ok := userData.(handlers.UserAgent)
+	if !ok || len(userAgent.GetUserAgent()) == 0 {
+		return
+	}
+	setAnnotation(meta, UserAgentAnnotation, userAgent.GetUserAgent())
+}
+
+// setBindings records the keys and parties the token is bound to, and how its user authenticated
+func setBindings(meta *metav1.ObjectMeta, userData interface{}) {
+	if certificate, ok := userData.(handlers.CertificateThumbprint); ok && len(certificate.GetCertificateThumbprint()) > 0 {
+		setAnnotation(meta, CertificateThumbprintAnnotation, certificate.GetCertificateThumbprint())
+	}
+	if proofKey, ok := userData.(handlers.ProofKeyThumbprint); ok && len(proofKey.GetProofKeyThumbprint()) > 0 {
+		setAnnotation(meta, ProofKeyThumbprintAnnotation, proofKey.GetProofKeyThumbprint())
+	}
+	if actor, ok := userData.(handlers.Actor); ok && len(actor.GetActor()) > 0 {
+		setAnnotation(meta, ActorAnnotation, actor.GetActor())
+	}
+	if priorActors, ok := userData.(handlers.PriorActors); ok && len(priorActors.GetPriorActors()) > 0 {
+		if encoded, err := json.Marshal(priorActors.GetPriorActors()); err == nil {
+			setAnnotation(meta, PriorActorsAnnotation, string(encoded))
+		}
+	}
+	if audiences, ok := userData.(handlers.Audiences); ok && len(audiences.GetAudiences()) > 0 {
+		setAnnotation(meta, AudiencesAnnotation, strings.Join(audiences.GetAudiences(), ","))
+	}
+	if methods, ok := userData.(handlers.AuthenticationMethods); ok && len(methods.GetAuthenticationMethods()) > 0 {
+		setAnnotation(meta, AuthenticationMethodsAnnotation, strings.Join(methods.GetAuthenticationMethods(), " "))
+	}
+	if contextClass, ok := userData.(handlers.AuthenticationContextClass); ok && len(contextClass.GetAuthenticationContextClass()) > 0 {
+		setAnnotation(meta, AuthenticationContextClassAnnotation, contextClass.GetAuthenticationContextClass())
+	}
+	if authenticated, ok := userData.(handlers.AuthenticationTime); ok && !authenticated.GetAuthenticationTime().IsZero() {
+		setAnnotation(meta, AuthenticationTimeAnnotation, strconv.FormatInt(authenticated.GetAuthenticationTime().Unix(), 10))
+	}
+	if info, ok := userData.(kuser.Info); ok && len(info.GetExtra()) > 0 {
+		if extra, err := json.Marshal(info.GetExtra()); err == nil {
+			setAnnotation(meta, ExtraAnnotation, string(extra))
+		}
+	}
+}
+
+// bindings returns user info with the keys, parties and authentication recorded in the annotations of a token
+func bindings(info kuser.Info, annotations map[string]string) kuser.Info {
+	info = handlers.WithCertificateThumbprint(info, annotations[CertificateThumbprintAnnotation])
+	info = handlers.WithProofKeyThumbprint(info, annotations[ProofKeyThumbprintAnnotation])
+	if encoded := annotations[PriorActorsAnnotation]; len(encoded) > 0 {
+		var priorActors []string
+		if err := json.Unmarshal([]byte(encoded), &priorActors); err != nil {
+			klog.V(4).Infof("ignoring invalid prior actors of token: %v", err)
+		}
+		// the earliest actor is added first, every actor acts for the ones before it
+		for i := len(priorActors) - 1; i >= 0; i-- {
+			info = handlers.WithActor(info, priorActors[i])
+		}
+	}
+	info = handlers.WithActor(info, annotations[ActorAnnotation])
+	if audiences := annotations[AudiencesAnnotation]; len(audiences) > 0 {
+		info = handlers.WithAudiences(info, strings.Split(audiences, ","))
+	}
+	info = handlers.WithAuthenticationMethods(info, strings.Fields(annotations[AuthenticationMethodsAnnotation]))
+	info = handlers.WithAuthenticationContextClass(info, annotations[AuthenticationContextClassAnnotation])
+	if authenticatedAt, err := strconv.ParseInt(annotations[AuthenticationTimeAnnotation], 10, 64); err == nil {
+		info = handlers.WithAuthenticationTime(info, time.Unix(authenticatedAt, 0))
+	}
+	return info
+}
+
+func setAnnotation(meta *metav1.ObjectMeta, key, value string) {
+	if meta.Annotations == nil {
+		meta.Annotations = map[string]string{}
+	}
+	meta.Annotations[key] = value
+}
+
 // TokenToObjectName returns the oauthaccesstokens object name for the given raw token,
-// i.e. the sha256 hash prefixed with "sha256~".
+// i.e. the sha256 hash prefixed with "sha256~", or the token itself for legacy tokens.
 func TokenToObjectName(code string) string {
-	name, prefixed := crypto.TrimSHA256Prefix(code)
-	if prefixed {
-		return crypto.SHA256Token(name)
-	}
-	return name
+	return tokenformat.ObjectName(code)
 }
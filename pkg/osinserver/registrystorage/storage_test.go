@@ -2,8 +2,146 @@ package registrystorage
 
 import (
 	"testing"
+	"time"
+
+	"github.com/openshift/osin"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apiserver/pkg/authentication/user"
+
+	"github.com/openshift/oauth-server/pkg/oauth/handlers"
+	"github.com/openshift/oauth-server/pkg/oauth/tokenformat"
 )
 
 func TestRegistry(t *testing.T) {
 	_ = storage{}
 }
+
+func TestUserAgentAnnotation(t *testing.T) {
+	s := &storage{}
+	client := &osin.DefaultClient{Id: "client"}
+	bob := &user.DefaultInfo{Name: "bob", UID: "bob-uid"}
+
+	authorizeToken, err := s.convertToAuthorizeToken(&osin.AuthorizeData{Code: "code", Client: client, UserData: handlers.WithUserAgent(bob, "browser")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userAgent := authorizeToken.Annotations[UserAgentAnnotation]; userAgent != "browser" {
+		t.Errorf("expected user agent browser on authorize token, got %q", userAgent)
+	}
+
+	accessToken, err := s.convertToAccessToken(&osin.AccessData{AccessToken: "token", Client: client, UserData: handlers.WithUserAgent(bob, "browser")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userAgent := accessToken.Annotations[UserAgentAnnotation]; userAgent != "browser" {
+		t.Errorf("expected user agent browser on access token, got %q", userAgent)
+	}
+	if accessToken.UserName != "bob" || accessToken.UserUID != "bob-uid" {
+		t.Errorf("unexpected user %q %q", accessToken.UserName, accessToken.UserUID)
+	}
+
+	accessToken, err = s.convertToAccessToken(&osin.AccessData{AccessToken: "token", Client: client, UserData: bob})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if accessToken.Annotations != nil {
+		t.Errorf("expected no annotations without user agent, got %v", accessToken.Annotations)
+	}
+}
+
+func TestBindingAnnotations(t *testing.T) {
+	s := &storage{}
+	client := &osin.DefaultClient{Id: "client"}
+	bob := &user.DefaultInfo{Name: "bob", UID: "bob-uid"}
+
+	userData := handlers.WithProofKeyThumbprint(handlers.WithCertificateThumbprint(handlers.WithUserAgent(bob, "cli"), "thumbprint"), "jkt")
+	userData = handlers.WithAudiences(handlers.WithActor(handlers.WithActor(userData, "carol"), "alice"), []string{"https://api.example.com", "billing"})
+	userData = handlers.WithAuthenticationContextClass(handlers.WithAuthenticationMethods(userData, []string{"pwd", "mfa"}), "gold")
+	userData = handlers.WithAuthenticationTime(userData, time.Unix(1700000000, 0))
+	accessToken, err := s.convertToAccessToken(&osin.AccessData{AccessToken: "token", Client: client, UserData: userData})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if thumbprint := accessToken.Annotations[CertificateThumbprintAnnotation]; thumbprint != "thumbprint" {
+		t.Errorf("expected certificate thumbprint on access token, got %q", thumbprint)
+	}
+	if thumbprint := accessToken.Annotations[ProofKeyThumbprintAnnotation]; thumbprint != "jkt" {
+		t.Errorf("expected DPoP key thumbprint on access token, got %q", thumbprint)
+	}
+	if userAgent := accessToken.Annotations[UserAgentAnnotation]; userAgent != "cli" {
+		t.Errorf("expected user agent cli on bound access token, got %q", userAgent)
+	}
+	if methods := accessToken.Annotations[AuthenticationMethodsAnnotation]; methods != "mfa pwd" {
+		t.Errorf("expected authentication methods on access token, got %q", methods)
+	}
+	if authenticatedAt := accessToken.Annotations[AuthenticationTimeAnnotation]; authenticatedAt != "1700000000" {
+		t.Errorf("expected authentication time on access token, got %q", authenticatedAt)
+	}
+
+	authorizeToken, err := s.convertToAuthorizeToken(&osin.AuthorizeData{Code: "code", Client: client, UserData: handlers.WithAudiences(bob, []string{"https://api.example.com"})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if audiences := authorizeToken.Annotations[AudiencesAnnotation]; audiences != "https://api.example.com" {
+		t.Errorf("expected audiences on authorize token, got %q", audiences)
+	}
+
+	restored := bindings(bob, accessToken.Annotations)
+	if actor := restored.(handlers.Actor).GetActor(); actor != "alice" {
+		t.Errorf("expected actor alice, got %q", actor)
+	}
+	if priorActors := restored.(handlers.PriorActors).GetPriorActors(); len(priorActors) != 1 || priorActors[0] != "carol" {
+		t.Errorf("expected prior actor carol, got %v", priorActors)
+	}
+	if audiences := restored.(handlers.Audiences).GetAudiences(); len(audiences) != 2 || audiences[1] != "billing" {
+		t.Errorf("expected audiences, got %v", audiences)
+	}
+	if thumbprint := restored.(handlers.ProofKeyThumbprint).GetProofKeyThumbprint(); thumbprint != "jkt" {
+		t.Errorf("expected DPoP key thumbprint jkt, got %q", thumbprint)
+	}
+	if contextClass := restored.(handlers.AuthenticationContextClass).GetAuthenticationContextClass(); contextClass != "gold" {
+		t.Errorf("expected authentication context class gold, got %q", contextClass)
+	}
+	if methods := restored.(handlers.AuthenticationMethods).GetAuthenticationMethods(); len(methods) != 2 || methods[0] != "mfa" {
+		t.Errorf("expected authentication methods, got %v", methods)
+	}
+	if authenticatedAt := restored.(handlers.AuthenticationTime).GetAuthenticationTime(); authenticatedAt.Unix() != 1700000000 {
+		t.Errorf("expected authentication time, got %v", authenticatedAt)
+	}
+}
+
+func TestExtraAnnotation(t *testing.T) {
+	s := &storage{}
+	client := &osin.DefaultClient{Id: "client"}
+	bob := &user.DefaultInfo{Name: "bob", UID: "bob-uid", Extra: map[string][]string{"department.example.com": {"finance"}}}
+
+	accessToken, err := s.convertToAccessToken(&osin.AccessData{AccessToken: "token", Client: client, UserData: handlers.WithUserAgent(bob, "cli")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if extra := accessToken.Annotations[ExtraAnnotation]; extra != `{"department.example.com":["finance"]}` {
+		t.Errorf("expected extra user info on access token, got %q", extra)
+	}
+
+	restored, err := convertFromToken(accessToken.UserName, accessToken.UserUID, accessToken.Annotations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if extra := restored.GetExtra()["department.example.com"]; len(extra) != 1 || extra[0] != "finance" {
+		t.Errorf("expected extra user info, got %v", restored.GetExtra())
+	}
+	if extra := Extra(map[string]string{ExtraAnnotation: "invalid"}); extra != nil {
+		t.Errorf("expected invalid extra user info to be ignored, got %v", extra)
+	}
+}
+
+func TestRejectedTokenFormats(t *testing.T) {
+	// tokens of formats that are not accepted are never looked up
+	s := &storage{formats: tokenformat.Formats{tokenformat.SHA256}}
+	if _, err := s.LoadAccess("legacy"); !kerrors.IsNotFound(err) {
+		t.Errorf("expected legacy access token to not be found, got %v", err)
+	}
+	if data, err := s.LoadAuthorize("legacy"); data != nil || err != nil {
+		t.Errorf("expected legacy authorize code to not be found, got %v %v", data, err)
+	}
+}
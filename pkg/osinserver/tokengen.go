@@ -1,11 +1,9 @@
 package osinserver
 
 import (
-	"strings"
-
 	"github.com/openshift/osin"
 
-	"github.com/openshift/oauth-server/pkg/server/crypto"
+	"github.com/openshift/oauth-server/pkg/oauth/tokenformat"
 )
 
 var (
@@ -13,33 +11,22 @@ var (
 	_ osin.AccessTokenGen    = TokenGen{}
 )
 
-func randomToken() string {
-	for {
-		// guaranteed to have no / characters and no trailing ='s
-		token := crypto.Random256BitsString()
-
-		// Don't generate tokens with leading dashes... they're hard to use on the command line
-		if strings.HasPrefix(token, "-") {
-			continue
-		}
-
-		return token
-	}
+// TokenGen generates authorize and access tokens of the first of its formats, which defaults to sha256~ tokens
+type TokenGen struct {
+	Formats tokenformat.Formats
 }
 
-type TokenGen struct{}
-
-func (TokenGen) GenerateAuthorizeToken(data *osin.AuthorizeData) (ret string, err error) {
-	return crypto.SHA256Prefix + randomToken(), nil
+func (g TokenGen) GenerateAuthorizeToken(data *osin.AuthorizeData) (ret string, err error) {
+	return g.Formats.Generate(), nil
 }
 
-func (TokenGen) GenerateAccessToken(data *osin.AccessData, generaterefresh bool) (string, string, error) {
-	accesstoken := randomToken()
+func (g TokenGen) GenerateAccessToken(data *osin.AccessData, generaterefresh bool) (string, string, error) {
+	accesstoken := g.Formats.Generate()
 
 	refreshtoken := ""
 	if generaterefresh {
-		refreshtoken = randomToken()
+		refreshtoken = tokenformat.RandomToken()
 	}
 
-	return crypto.SHA256Prefix + accesstoken, refreshtoken, nil
+	return accesstoken, refreshtoken, nil
 }
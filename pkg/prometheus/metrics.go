@@ -1,6 +1,8 @@
 package metrics
 
 import (
+	"time"
+
 	"k8s.io/component-base/metrics"
 	"k8s.io/component-base/metrics/legacyregistry"
 )
@@ -13,6 +15,31 @@ const (
 	SuccessResult = "success"
 	FailResult    = "failure"
 	ErrorResult   = "error"
+	// AbandonedResult is the result of logins that did not finish
+	AbandonedResult = "abandoned"
+)
+
+const (
+	// DelayedAction and SuppressedAction are what the basic password throttle does to a source that failed to log in
+	DelayedAction    = "delayed"
+	SuppressedAction = "suppressed"
+)
+
+const (
+	// UserReason and PercentageReason are why a canary sent a login to an identity provider
+	UserReason       = "user"
+	PercentageReason = "percentage"
+)
+
+const (
+	// CacheHitResult and CacheMissResult are the results of lookups in a cache
+	CacheHitResult  = "hit"
+	CacheMissResult = "miss"
+)
+
+const (
+	AccessTokenType    = "access"
+	AuthorizeTokenType = "authorize"
 )
 
 var (
@@ -51,19 +78,128 @@ var (
 			Help:      "Counts basic password authentication attempts by result",
 		}, []string{"result"},
 	)
+	authBasicThrottled = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Subsystem: authSubsystem,
+			Name:      "basic_password_throttled_total",
+			Help:      "Counts basic password authentication attempts whose response was delayed or whose credentials were not checked because their source failed too often, by action",
+		}, []string{"action"},
+	)
+	expiredTokensDeleted = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Subsystem: authSubsystem,
+			Name:      "expired_tokens_deleted_total",
+			Help:      "Counts expired tokens deleted by the token garbage collection by token type",
+		}, []string{"type"},
+	)
+	tokenCollectionErrors = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Subsystem: authSubsystem,
+			Name:      "token_collection_errors_total",
+			Help:      "Counts failed token garbage collections by token type",
+		}, []string{"type"},
+	)
+	authorizePKCE = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Subsystem: authSubsystem,
+			Name:      "authorize_pkce_total",
+			Help:      "Counts authorized requests for authorize codes by client and PKCE code challenge method, none for requests without a challenge",
+		}, []string{"client", "method"},
+	)
+	loginAnomalies = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Subsystem: authSubsystem,
+			Name:      "login_anomalies_total",
+			Help:      "Counts anomalous logins by identity provider and anomaly, e.g. new-device",
+		}, []string{"provider", "anomaly"},
+	)
+	loginsTotal = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Subsystem: authSubsystem,
+			Name:      "logins_total",
+			Help:      "Counts browser logins from the authorize request that asks the user to log in to the authorize response by identity provider and result",
+		}, []string{"provider", "result"},
+	)
+	canaryRoutes = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Subsystem: authSubsystem,
+			Name:      "canary_routes_total",
+			Help:      "Counts browser logins of identity providers with a canary by identity provider, the identity provider the login was sent to and the reason, e.g. user or percentage",
+		}, []string{"provider", "target", "reason"},
+	)
+	identityCacheLookups = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Subsystem: authSubsystem,
+			Name:      "identity_cache_lookups_total",
+			Help:      "Counts lookups of the identities of access tokens of OAuth identity providers in the identity cache by identity provider and result, hit or miss",
+		}, []string{"provider", "result"},
+	)
+	mappingCacheLookups = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Subsystem: authSubsystem,
+			Name:      "mapping_cache_lookups_total",
+			Help:      "Counts lookups of the users of identities in the mapping cache by identity provider and result, hit or miss",
+		}, []string{"provider", "result"},
+	)
+	loginDuration = metrics.NewHistogramVec(
+		&metrics.HistogramOpts{
+			Subsystem: authSubsystem,
+			Name:      "login_duration_seconds",
+			Help:      "End-to-end latency of browser logins from the authorize request that asks the user to log in to the authorize response by identity provider and result",
+			Buckets:   loginBuckets,
+		}, []string{"provider", "result"},
+	)
+	loginAuthenticationDuration = metrics.NewHistogramVec(
+		&metrics.HistogramOpts{
+			Subsystem: authSubsystem,
+			Name:      "login_authentication_duration_seconds",
+			Help:      "Latency of browser logins from the authorize request that asks the user to log in until the user authenticated with the identity provider",
+			Buckets:   loginBuckets,
+		}, []string{"provider"},
+	)
+	loginCompletionDuration = metrics.NewHistogramVec(
+		&metrics.HistogramOpts{
+			Subsystem: authSubsystem,
+			Name:      "login_completion_duration_seconds",
+			Help:      "Latency of browser logins from the authentication with the identity provider to the authorize response, e.g. approving scopes",
+			Buckets:   loginBuckets,
+		}, []string{"provider"},
+	)
 )
 
+// loginBuckets span logins that only redirect to logins in which users type passwords and approve scopes
+var loginBuckets = []float64{0.25, 0.5, 1, 2, 5, 10, 20, 30, 60, 120, 300, 600}
+
 func init() {
 	legacyregistry.MustRegister(authPasswordTotal)
 	legacyregistry.MustRegister(authFormCounter)
 	legacyregistry.MustRegister(authFormCounterResult)
 	legacyregistry.MustRegister(authBasicCounter)
 	legacyregistry.MustRegister(authBasicCounterResult)
+	legacyregistry.MustRegister(authBasicThrottled)
+	legacyregistry.MustRegister(expiredTokensDeleted)
+	legacyregistry.MustRegister(tokenCollectionErrors)
+	legacyregistry.MustRegister(authorizePKCE)
+	legacyregistry.MustRegister(loginAnomalies)
+	legacyregistry.MustRegister(loginsTotal)
+	legacyregistry.MustRegister(canaryRoutes)
+	legacyregistry.MustRegister(identityCacheLookups)
+	legacyregistry.MustRegister(mappingCacheLookups)
+	legacyregistry.MustRegister(loginDuration)
+	legacyregistry.MustRegister(loginAuthenticationDuration)
+	legacyregistry.MustRegister(loginCompletionDuration)
 
 	for _, resultLabel := range []string{SuccessResult, FailResult, ErrorResult} {
 		authBasicCounterResult.WithLabelValues(resultLabel)
 		authFormCounterResult.WithLabelValues(resultLabel)
 	}
+	for _, actionLabel := range []string{DelayedAction, SuppressedAction} {
+		authBasicThrottled.WithLabelValues(actionLabel)
+	}
+	for _, typeLabel := range []string{AccessTokenType, AuthorizeTokenType} {
+		expiredTokensDeleted.WithLabelValues(typeLabel)
+		tokenCollectionErrors.WithLabelValues(typeLabel)
+	}
 }
 
 func RecordBasicPasswordAuth(result string) {
@@ -72,8 +208,59 @@ func RecordBasicPasswordAuth(result string) {
 	authBasicCounterResult.WithLabelValues(result).Inc()
 }
 
+func RecordBasicPasswordThrottle(action string) {
+	authBasicThrottled.WithLabelValues(action).Inc()
+}
+
 func RecordFormPasswordAuth(result string) {
 	authPasswordTotal.Inc()
 	authFormCounter.Inc()
 	authFormCounterResult.WithLabelValues(result).Inc()
 }
+
+func RecordExpiredTokensDeleted(tokenType string, count int) {
+	expiredTokensDeleted.WithLabelValues(tokenType).Add(float64(count))
+}
+
+func RecordTokenCollectionError(tokenType string) {
+	tokenCollectionErrors.WithLabelValues(tokenType).Inc()
+}
+
+func RecordAuthorizePKCE(client, method string) {
+	authorizePKCE.WithLabelValues(client, method).Inc()
+}
+
+func RecordLoginAnomaly(provider, anomaly string) {
+	loginAnomalies.WithLabelValues(provider, anomaly).Inc()
+}
+
+// RecordLogin records the result and end-to-end duration of a browser login
+func RecordLogin(provider, result string, duration time.Duration) {
+	loginsTotal.WithLabelValues(provider, result).Inc()
+	loginDuration.WithLabelValues(provider, result).Observe(duration.Seconds())
+}
+
+// RecordCanaryRoute records the identity provider a login with the provider of a canary was sent to
+func RecordCanaryRoute(provider, target, reason string) {
+	canaryRoutes.WithLabelValues(provider, target, reason).Inc()
+}
+
+// RecordLoginAuthentication records the duration of a browser login until the user authenticated
+func RecordLoginAuthentication(provider string, duration time.Duration) {
+	loginAuthenticationDuration.WithLabelValues(provider).Observe(duration.Seconds())
+}
+
+// RecordLoginCompletion records the duration of a browser login from the authentication to the authorize response
+func RecordLoginCompletion(provider string, duration time.Duration) {
+	loginCompletionDuration.WithLabelValues(provider).Observe(duration.Seconds())
+}
+
+// RecordIdentityCacheLookup records whether the identity of an access token of an identity provider was cached
+func RecordIdentityCacheLookup(provider, result string) {
+	identityCacheLookups.WithLabelValues(provider, result).Inc()
+}
+
+// RecordMappingCacheLookup records whether the user of an identity of an identity provider was cached
+func RecordMappingCacheLookup(provider, result string) {
+	mappingCacheLookups.WithLabelValues(provider, result).Inc()
+}
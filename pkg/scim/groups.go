@@ -0,0 +1,411 @@
+package scim
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/util/retry"
+
+	userv1 "github.com/openshift/api/user/v1"
+)
+
+// memberFilterPattern matches the paths of patch operations that remove single members, e.g. members[value eq "bob"]
+var memberFilterPattern = regexp.MustCompile(`^members\[\s*value\s+eq\s+"((?:[^"\\]|\\.)*)"\s*\]$`)
+
+// Group is a SCIM group, its id is its display name. The name of the group is the display name with the group prefix.
+type Group struct {
+	Schemas     []string `json:"schemas"`
+	ID          string   `json:"id,omitempty"`
+	ExternalID  string   `json:"externalId,omitempty"`
+	DisplayName string   `json:"displayName"`
+	Members     []Member `json:"members"`
+	Meta        *Meta    `json:"meta,omitempty"`
+}
+
+// Member is a user in a group, its value is the id of the user
+type Member struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+}
+
+func (s *Server) serveGroups(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		groups, err := s.listGroups(req)
+		if err != nil {
+			writeFailure(w, "listing groups", err)
+			return
+		}
+		writeList(w, req, groups)
+	case http.MethodPost:
+		var group Group
+		if !decode(w, req, &group) {
+			return
+		}
+		created, err := s.createGroup(&group)
+		if err != nil {
+			writeFailure(w, "creating group", err)
+			return
+		}
+		w.Header().Set("Location", created.Meta.Location)
+		writeJSON(w, http.StatusCreated, created)
+	default:
+		methodNotAllowed(w)
+	}
+}
+
+func (s *Server) serveGroup(w http.ResponseWriter, req *http.Request) {
+	id := req.URL.Path
+	var group *Group
+	var err error
+	switch req.Method {
+	case http.MethodGet:
+		group, err = s.getGroup(id)
+	case http.MethodPut:
+		var replacement Group
+		if !decode(w, req, &replacement) {
+			return
+		}
+		group, err = s.replaceGroup(id, &replacement)
+	case http.MethodPatch:
+		var patch PatchRequest
+		if !decode(w, req, &patch) {
+			return
+		}
+		group, err = s.patchGroup(id, &patch)
+	case http.MethodDelete:
+		if err := s.deleteGroup(id); err != nil {
+			writeFailure(w, "deleting group", err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		methodNotAllowed(w)
+		return
+	}
+	if err != nil {
+		writeFailure(w, "updating group", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, group)
+}
+
+func (s *Server) listGroups(req *http.Request) ([]interface{}, error) {
+	displayName, filtered, err := filterValue(req, "displayName")
+	if err != nil {
+		return nil, err
+	}
+	groups := []interface{}{}
+	if filtered {
+		group, err := s.getManagedGroup(displayName)
+		if isNotFound(err) {
+			return groups, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		converted, err := s.toGroup(group)
+		if err != nil {
+			return nil, err
+		}
+		return append(groups, converted), nil
+	}
+
+	list, err := s.groups.List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for i := range list.Items {
+		if !s.managed(&list.Items[i]) {
+			continue
+		}
+		converted, err := s.toGroup(&list.Items[i])
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, converted)
+	}
+	return groups, nil
+}
+
+func (s *Server) getGroup(id string) (*Group, error) {
+	group, err := s.getManagedGroup(id)
+	if err != nil {
+		return nil, err
+	}
+	return s.toGroup(group)
+}
+
+func (s *Server) createGroup(group *Group) (*Group, error) {
+	if len(group.DisplayName) == 0 {
+		return nil, badRequest(errInvalidValue, "displayName is required")
+	}
+	users, err := s.memberUsers(group.Members, false)
+	if err != nil {
+		return nil, err
+	}
+	created := &userv1.Group{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        s.groupPrefix + group.DisplayName,
+			Annotations: map[string]string{fmt.Sprintf(groupManagedKeyFmt, s.providerName): "true"},
+		},
+		Users: users.List(),
+	}
+	setAnnotation(&created.ObjectMeta, ExternalIDAnnotation, group.ExternalID)
+	created, err = s.groups.Create(context.TODO(), created, metav1.CreateOptions{})
+	switch {
+	case kerrors.IsAlreadyExists(err):
+		return nil, &requestError{status: http.StatusConflict, scimType: errUniqueness, detail: fmt.Sprintf("group %q already exists", group.DisplayName)}
+	case kerrors.IsInvalid(err):
+		return nil, badRequest(errInvalidValue, "invalid displayName %q", group.DisplayName)
+	case err != nil:
+		return nil, err
+	}
+	return s.toGroup(created)
+}
+
+// replaceGroup replaces the members of the group, the displayName cannot change
+func (s *Server) replaceGroup(id string, replacement *Group) (*Group, error) {
+	if len(replacement.DisplayName) > 0 && replacement.DisplayName != id {
+		return nil, badRequest(errMutability, "displayName cannot change")
+	}
+	users, err := s.memberUsers(replacement.Members, false)
+	if err != nil {
+		return nil, err
+	}
+	return s.updateGroup(id, func(group *userv1.Group) {
+		group.Users = users.List()
+		setAnnotation(&group.ObjectMeta, ExternalIDAnnotation, replacement.ExternalID)
+	})
+}
+
+func (s *Server) patchGroup(id string, patch *PatchRequest) (*Group, error) {
+	// the members are resolved before the group is updated, so that conflicts do not repeat the lookups
+	type change struct {
+		op         string
+		users      sets.String
+		externalID *string
+	}
+	changes := []change{}
+	for _, operation := range patch.Operations {
+		op := strings.ToLower(operation.Op)
+		attribute := strings.ToLower(operation.Path)
+		switch {
+		case op != "add" && op != "replace" && op != "remove":
+			return nil, badRequest(errInvalidSyntax, "unknown operation %q", operation.Op)
+
+		case op == "remove" && memberFilterPattern.MatchString(operation.Path):
+			var value string
+			if err := json.Unmarshal([]byte(`"`+memberFilterPattern.FindStringSubmatch(operation.Path)[1]+`"`), &value); err != nil {
+				return nil, badRequest(errInvalidFilter, "invalid member filter %q", operation.Path)
+			}
+			users, err := s.memberUsers([]Member{{Value: value}}, true)
+			if err != nil {
+				return nil, err
+			}
+			changes = append(changes, change{op: op, users: users})
+
+		case attribute == "members":
+			var members []Member
+			if len(operation.Value) > 0 {
+				if err := json.Unmarshal(operation.Value, &members); err != nil {
+					return nil, badRequest(errInvalidValue, "members must be a list of members")
+				}
+			} else if op == "remove" {
+				// removing the attribute removes all members
+				op = "replace"
+			}
+			users, err := s.memberUsers(members, op == "remove")
+			if err != nil {
+				return nil, err
+			}
+			changes = append(changes, change{op: op, users: users})
+
+		case attribute == "externalid" || (len(attribute) == 0 && op != "remove"):
+			attributes := map[string]json.RawMessage{}
+			if len(attribute) > 0 {
+				attributes[operation.Path] = operation.Value
+			} else if err := json.Unmarshal(operation.Value, &attributes); err != nil {
+				return nil, badRequest(errInvalidValue, "the value of operations without path must be an object")
+			}
+			for name, value := range attributes {
+				switch strings.ToLower(name) {
+				case "displayname":
+					var displayName string
+					if err := json.Unmarshal(value, &displayName); err != nil || displayName != id {
+						return nil, badRequest(errMutability, "displayName cannot change")
+					}
+				case "externalid":
+					var externalID string
+					if op != "remove" {
+						if err := json.Unmarshal(value, &externalID); err != nil {
+							return nil, badRequest(errInvalidValue, "externalId must be a string")
+						}
+					}
+					changes = append(changes, change{externalID: &externalID})
+				case "members":
+					var members []Member
+					if err := json.Unmarshal(value, &members); err != nil {
+						return nil, badRequest(errInvalidValue, "members must be a list of members")
+					}
+					users, err := s.memberUsers(members, false)
+					if err != nil {
+						return nil, err
+					}
+					changes = append(changes, change{op: op, users: users})
+				}
+			}
+
+		case attribute == "displayname":
+			var displayName string
+			if err := json.Unmarshal(operation.Value, &displayName); err != nil || displayName != id {
+				return nil, badRequest(errMutability, "displayName cannot change")
+			}
+		}
+	}
+
+	return s.updateGroup(id, func(group *userv1.Group) {
+		users := sets.NewString(group.Users...)
+		for _, change := range changes {
+			switch {
+			case change.externalID != nil:
+				setAnnotation(&group.ObjectMeta, ExternalIDAnnotation, *change.externalID)
+			case change.op == "add":
+				users.Insert(change.users.UnsortedList()...)
+			case change.op == "remove":
+				users.Delete(change.users.UnsortedList()...)
+			case change.op == "replace":
+				users = change.users
+			}
+		}
+		group.Users = users.List()
+	})
+}
+
+func (s *Server) deleteGroup(id string) error {
+	group, err := s.getManagedGroup(id)
+	if err != nil {
+		return err
+	}
+	err = s.groups.Delete(context.TODO(), group.Name, metav1.DeleteOptions{Preconditions: &metav1.Preconditions{UID: &group.UID}})
+	if kerrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+func (s *Server) updateGroup(id string, update func(*userv1.Group)) (*Group, error) {
+	var updated *userv1.Group
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		group, err := s.getManagedGroup(id)
+		if err != nil {
+			return err
+		}
+		update(group)
+		updated, err = s.groups.Update(context.TODO(), group, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.toGroup(updated)
+}
+
+// removeMember removes the user from the groups of the identity provider, e.g. before the user is deleted
+func (s *Server) removeMember(userName string) error {
+	list, err := s.groups.List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for i := range list.Items {
+		group := &list.Items[i]
+		if !s.managed(group) || !sets.NewString(group.Users...).Has(userName) {
+			continue
+		}
+		if _, err := s.updateGroup(strings.TrimPrefix(group.Name, s.groupPrefix), func(group *userv1.Group) {
+			group.Users = sets.NewString(group.Users...).Delete(userName).List()
+		}); err != nil && !isNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// getManagedGroup returns the group of the id, groups that the identity provider does not manage are not found
+func (s *Server) getManagedGroup(id string) (*userv1.Group, error) {
+	group, err := s.groups.Get(context.TODO(), s.groupPrefix+id, metav1.GetOptions{})
+	if kerrors.IsNotFound(err) || (err == nil && !s.managed(group)) {
+		return nil, notFound("group %q not found", id)
+	}
+	return group, err
+}
+
+func (s *Server) managed(group *userv1.Group) bool {
+	return group.Annotations[fmt.Sprintf(groupManagedKeyFmt, s.providerName)] == "true" && strings.HasPrefix(group.Name, s.groupPrefix)
+}
+
+// memberUsers returns the names of the users of the identities of the members. Unknown members are an error,
+// unless they are ignored, e.g. when they are removed.
+func (s *Server) memberUsers(members []Member, ignoreUnknown bool) (sets.String, error) {
+	users := sets.NewString()
+	for _, member := range members {
+		identity, err := s.identities.Get(context.TODO(), s.identityName(member.Value), metav1.GetOptions{})
+		if kerrors.IsNotFound(err) || (err == nil && len(identity.User.Name) == 0) {
+			if ignoreUnknown {
+				continue
+			}
+			return nil, badRequest(errInvalidValue, "unknown member %q", member.Value)
+		}
+		if err != nil {
+			return nil, err
+		}
+		users.Insert(identity.User.Name)
+	}
+	return users, nil
+}
+
+// toGroup returns the SCIM group of the group. Its members are the users of the group that have an identity of the
+// identity provider.
+func (s *Server) toGroup(group *userv1.Group) (*Group, error) {
+	displayName := strings.TrimPrefix(group.Name, s.groupPrefix)
+	converted := &Group{
+		Schemas:     []string{groupSchema},
+		ID:          displayName,
+		ExternalID:  group.Annotations[ExternalIDAnnotation],
+		DisplayName: displayName,
+		Members:     []Member{},
+		Meta:        &Meta{ResourceType: "Group", Location: path.Join(s.prefix, groupsPath, url.PathEscape(displayName))},
+	}
+	identityPrefix := s.identityName("")
+	for _, userName := range group.Users {
+		user, err := s.users.Get(context.TODO(), userName, metav1.GetOptions{})
+		if kerrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, identity := range user.Identities {
+			if strings.HasPrefix(identity, identityPrefix) {
+				converted.Members = append(converted.Members, Member{Value: strings.TrimPrefix(identity, identityPrefix), Display: user.FullName})
+				break
+			}
+		}
+	}
+	return converted, nil
+}
+
+func isNotFound(err error) bool {
+	requestErr, ok := err.(*requestError)
+	return ok && requestErr.status == http.StatusNotFound
+}
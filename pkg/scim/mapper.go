@@ -0,0 +1,35 @@
+package scim
+
+import (
+	"context"
+	"fmt"
+
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apiserver/pkg/authentication/user"
+
+	userclient "github.com/openshift/client-go/user/clientset/versioned/typed/user/v1"
+
+	authapi "github.com/openshift/oauth-server/pkg/api"
+)
+
+type userMapper struct {
+	delegate   authapi.UserIdentityMapper
+	identities userclient.IdentityInterface
+}
+
+// NewUserMapper returns a mapper that rejects the logins of identities the identity provider deactivated
+func NewUserMapper(delegate authapi.UserIdentityMapper, identities userclient.IdentityInterface) authapi.UserIdentityMapper {
+	return &userMapper{delegate: delegate, identities: identities}
+}
+
+func (m *userMapper) UserFor(identityInfo authapi.UserIdentityInfo) (user.Info, error) {
+	identity, err := m.identities.Get(context.TODO(), identityInfo.GetIdentityName(), metav1.GetOptions{})
+	if err != nil && !kerrors.IsNotFound(err) {
+		return nil, err
+	}
+	if err == nil && identity.Annotations[DeactivatedAnnotation] == "true" {
+		return nil, authapi.NewAuthorizationDeniedError(identityInfo, fmt.Errorf("identity %q was deactivated by its identity provider", identityInfo.GetIdentityName()))
+	}
+	return m.delegate.UserFor(identityInfo)
+}
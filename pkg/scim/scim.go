@@ -0,0 +1,266 @@
+// Package scim serves a SCIM 2.0 endpoint (RFC 7643, RFC 7644) for an identity provider, so that the provider can
+// push the lifecycle of its users and groups, e.g. from Okta or Azure AD. SCIM users are the identities of the
+// provider, their id is the provider user name. Deactivated and deleted users cannot log in anymore, and their
+// tokens and sessions are revoked. SCIM groups are groups whose members are the users of the identities.
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/klog/v2"
+
+	userclient "github.com/openshift/client-go/user/clientset/versioned/typed/user/v1"
+
+	"github.com/openshift/oauth-server/pkg"
+	authapi "github.com/openshift/oauth-server/pkg/api"
+	"github.com/openshift/oauth-server/pkg/deprovisioning"
+	"github.com/openshift/oauth-server/pkg/server/crypto"
+)
+
+const (
+	userSchema         = "urn:ietf:params:scim:schemas:core:2.0:User"
+	groupSchema        = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	listResponseSchema = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	errorSchema        = "urn:ietf:params:scim:api:messages:2.0:Error"
+
+	// contentType is the media type of SCIM requests and responses
+	contentType = "application/scim+json"
+
+	usersPath  = "/Users"
+	groupsPath = "/Groups"
+
+	// maxBodyBytes limits the size of SCIM requests
+	maxBodyBytes = 1024 * 1024
+
+	// scimType values of errors (RFC 7644, section 3.12)
+	errInvalidFilter = "invalidFilter"
+	errInvalidSyntax = "invalidSyntax"
+	errInvalidValue  = "invalidValue"
+	errMutability    = "mutability"
+	errUniqueness    = "uniqueness"
+)
+
+// DeactivatedAnnotation marks identities that the identity provider deactivated, they cannot log in
+const DeactivatedAnnotation = "oauth.openshift.io/scim-deactivated"
+
+// ExternalIDAnnotation holds the id the identity provider gave an identity or a group
+const ExternalIDAnnotation = "oauth.openshift.io/scim-external-id"
+
+// groupManagedKeyFmt marks the groups managed by the SCIM endpoint of an identity provider. It differs from the
+// annotation of synchronized groups, so that logins never change the members of SCIM groups.
+const groupManagedKeyFmt = "oauth.openshift.io/scim.%s"
+
+// eqFilterPattern matches the only filters supported, equality of a single attribute, e.g. userName eq "bob"
+var eqFilterPattern = regexp.MustCompile(`^\s*(\w+)\s+eq\s+"((?:[^"\\]|\\.)*)"\s*$`)
+
+// Server is the SCIM endpoint of an identity provider
+type Server struct {
+	providerName string
+	token        string
+	groupPrefix  string
+	// prefix is the path the endpoint is installed at, it is part of the locations of resources
+	prefix string
+	// mapper provisions the users of new identities
+	mapper     authapi.UserIdentityMapper
+	users      userclient.UserInterface
+	identities userclient.IdentityInterface
+	groups     userclient.GroupInterface
+	revoker    *deprovisioning.Revoker
+}
+
+// NewServer returns the SCIM endpoint of the identity provider. Requests are authenticated by the token as bearer
+// token. New identities are mapped to users by mapper, the names of groups are prefixed with groupPrefix.
+func NewServer(providerName, token, groupPrefix string, mapper authapi.UserIdentityMapper, users userclient.UserInterface, identities userclient.IdentityInterface, groups userclient.GroupInterface, revoker *deprovisioning.Revoker) *Server {
+	return &Server{
+		providerName: providerName,
+		token:        token,
+		groupPrefix:  groupPrefix,
+		mapper:       mapper,
+		users:        users,
+		identities:   identities,
+		groups:       groups,
+		revoker:      revoker,
+	}
+}
+
+var _ oauthserver.Endpoints = &Server{}
+
+// Install registers the Users and Groups resources at prefix
+func (s *Server) Install(mux oauthserver.Mux, prefix string) {
+	s.prefix = prefix
+	mux.Handle(prefix+usersPath, s.authenticated(s.serveUsers))
+	mux.Handle(prefix+usersPath+"/", http.StripPrefix(prefix+usersPath+"/", s.authenticated(s.serveUser)))
+	mux.Handle(prefix+groupsPath, s.authenticated(s.serveGroups))
+	mux.Handle(prefix+groupsPath+"/", http.StripPrefix(prefix+groupsPath+"/", s.authenticated(s.serveGroup)))
+}
+
+// authenticated only passes requests with the token of the identity provider
+func (s *Server) authenticated(handler http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		scheme, token, _ := strings.Cut(req.Header.Get("Authorization"), " ")
+		if !strings.EqualFold(scheme, "Bearer") || len(token) == 0 || !crypto.IsEqualConstantTime(token, s.token) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, "", "unauthorized")
+			return
+		}
+		req.Body = http.MaxBytesReader(w, req.Body, maxBodyBytes)
+		handler(w, req)
+	})
+}
+
+// Meta describes a resource
+type Meta struct {
+	ResourceType string `json:"resourceType"`
+	Location     string `json:"location,omitempty"`
+}
+
+// ListResponse is the response to queries of resources
+type ListResponse struct {
+	Schemas      []string    `json:"schemas"`
+	TotalResults int         `json:"totalResults"`
+	StartIndex   int         `json:"startIndex"`
+	ItemsPerPage int         `json:"itemsPerPage"`
+	Resources    interface{} `json:"Resources"`
+}
+
+// Error is the response to failed requests
+type Error struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail,omitempty"`
+}
+
+// PatchRequest modifies a resource with a list of operations
+type PatchRequest struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []PatchOperation `json:"Operations"`
+}
+
+// PatchOperation adds, removes or replaces the attribute at path. Operations without path hold the attributes
+// in value.
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// page returns the bounds of the requested page of total resources. The startIndex parameter is one-based.
+func page(req *http.Request, total int) (int, int) {
+	startIndex, count := 1, total
+	if value, err := strconv.Atoi(req.URL.Query().Get("startIndex")); err == nil {
+		startIndex = value
+	}
+	if value, err := strconv.Atoi(req.URL.Query().Get("count")); err == nil {
+		count = value
+	}
+	if startIndex < 1 {
+		startIndex = 1
+	}
+	if count < 0 {
+		count = 0
+	}
+	from := startIndex - 1
+	if from > total {
+		from = total
+	}
+	to := from + count
+	if to > total {
+		to = total
+	}
+	return from, to
+}
+
+// filterValue returns the value of a filter of the attribute. Attribute names are case insensitive.
+func filterValue(req *http.Request, attribute string) (string, bool, error) {
+	filter := req.URL.Query().Get("filter")
+	if len(filter) == 0 {
+		return "", false, nil
+	}
+	match := eqFilterPattern.FindStringSubmatch(filter)
+	if match == nil || !strings.EqualFold(match[1], attribute) {
+		return "", false, badRequest(errInvalidFilter, "only filters of the form %s eq \"value\" are supported", attribute)
+	}
+	var value string
+	if err := json.Unmarshal([]byte(`"`+match[2]+`"`), &value); err != nil {
+		return "", false, badRequest(errInvalidFilter, "invalid filter value: %v", err)
+	}
+	return value, true, nil
+}
+
+func writeList(w http.ResponseWriter, req *http.Request, resources []interface{}) {
+	from, to := page(req, len(resources))
+	writeJSON(w, http.StatusOK, &ListResponse{
+		Schemas:      []string{listResponseSchema},
+		TotalResults: len(resources),
+		StartIndex:   from + 1,
+		ItemsPerPage: to - from,
+		Resources:    resources[from:to],
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		utilruntime.HandleError(fmt.Errorf("unable to write SCIM response: %v", err))
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, scimType, detail string) {
+	writeJSON(w, status, &Error{
+		Schemas:  []string{errorSchema},
+		Status:   strconv.Itoa(status),
+		ScimType: scimType,
+		Detail:   detail,
+	})
+}
+
+// requestError is an error caused by the request, it is returned with its status and scimType
+type requestError struct {
+	status   int
+	scimType string
+	detail   string
+}
+
+func (e *requestError) Error() string {
+	return e.detail
+}
+
+func badRequest(scimType, format string, args ...interface{}) error {
+	return &requestError{status: http.StatusBadRequest, scimType: scimType, detail: fmt.Sprintf(format, args...)}
+}
+
+func notFound(format string, args ...interface{}) error {
+	return &requestError{status: http.StatusNotFound, detail: fmt.Sprintf(format, args...)}
+}
+
+// writeFailure returns request errors to the identity provider, and logs other errors
+func writeFailure(w http.ResponseWriter, action string, err error) {
+	if requestErr, ok := err.(*requestError); ok {
+		writeError(w, requestErr.status, requestErr.scimType, requestErr.detail)
+		return
+	}
+	klog.Errorf("error %s: %v", action, err)
+	writeError(w, http.StatusInternalServerError, "", "failed "+action)
+}
+
+func methodNotAllowed(w http.ResponseWriter) {
+	writeError(w, http.StatusMethodNotAllowed, "", "method not allowed")
+}
+
+// decode reads the JSON body of the request into value
+func decode(w http.ResponseWriter, req *http.Request, value interface{}) bool {
+	if err := json.NewDecoder(req.Body).Decode(value); err != nil {
+		writeError(w, http.StatusBadRequest, errInvalidSyntax, fmt.Sprintf("invalid request body: %v", err))
+		return false
+	}
+	return true
+}
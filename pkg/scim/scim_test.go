@@ -0,0 +1,226 @@
+package scim
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apiserver/pkg/authentication/user"
+
+	oauthv1 "github.com/openshift/api/oauth/v1"
+	userv1 "github.com/openshift/api/user/v1"
+	oauthfake "github.com/openshift/client-go/oauth/clientset/versioned/fake"
+	userfake "github.com/openshift/client-go/user/clientset/versioned/fake"
+
+	authapi "github.com/openshift/oauth-server/pkg/api"
+	"github.com/openshift/oauth-server/pkg/deprovisioning"
+)
+
+const testToken = "secret"
+
+// testMapper provisions a user with the name of the identity like the claim mapping method would
+type testMapper struct {
+	userClient *userfake.Clientset
+}
+
+func (m *testMapper) UserFor(identityInfo authapi.UserIdentityInfo) (user.Info, error) {
+	name := identityInfo.GetProviderUserName()
+	uid := types.UID(name + "-uid")
+	if _, err := m.userClient.UserV1().Users().Create(context.TODO(), &userv1.User{
+		ObjectMeta: metav1.ObjectMeta{Name: name, UID: uid},
+		Identities: []string{identityInfo.GetIdentityName()},
+	}, metav1.CreateOptions{}); err != nil {
+		return nil, err
+	}
+	if _, err := m.userClient.UserV1().Identities().Create(context.TODO(), &userv1.Identity{
+		ObjectMeta:       metav1.ObjectMeta{Name: identityInfo.GetIdentityName()},
+		ProviderName:     identityInfo.GetProviderName(),
+		ProviderUserName: name,
+		User:             corev1.ObjectReference{Name: name, UID: uid},
+		Extra:            identityInfo.GetExtra(),
+	}, metav1.CreateOptions{}); err != nil {
+		return nil, err
+	}
+	return &user.DefaultInfo{Name: name, UID: string(uid)}, nil
+}
+
+func newTestServer(t *testing.T) (*httptest.Server, *userfake.Clientset, *oauthfake.Clientset) {
+	userClient := userfake.NewSimpleClientset()
+	oauthClient := oauthfake.NewSimpleClientset()
+	revoker := deprovisioning.NewRevoker(oauthClient.OauthV1().OAuthAccessTokens(), oauthClient.OauthV1().OAuthAuthorizeTokens(), nil)
+	server := NewServer("okta", testToken, "okta:", &testMapper{userClient: userClient}, userClient.UserV1().Users(), userClient.UserV1().Identities(), userClient.UserV1().Groups(), revoker)
+	mux := http.NewServeMux()
+	server.Install(mux, "/scim/v2/okta")
+	s := httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s, userClient, oauthClient
+}
+
+func do(t *testing.T, s *httptest.Server, method, path, body string, result interface{}) int {
+	req, err := http.NewRequest(method, s.URL+"/scim/v2/okta"+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	req.Header.Set("Content-Type", contentType)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if result != nil && resp.StatusCode < 300 && resp.StatusCode != http.StatusNoContent {
+		if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestAuthentication(t *testing.T) {
+	s, _, _ := newTestServer(t)
+	for _, authorization := range []string{"", "Bearer wrong", testToken} {
+		req, _ := http.NewRequest(http.MethodGet, s.URL+"/scim/v2/okta/Users", nil)
+		if len(authorization) > 0 {
+			req.Header.Set("Authorization", authorization)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("expected 401 for authorization %q, got %d", authorization, resp.StatusCode)
+		}
+	}
+}
+
+func TestUsers(t *testing.T) {
+	s, userClient, oauthClient := newTestServer(t)
+
+	created := &User{}
+	body := `{"schemas":["urn:ietf:params:scim:schemas:core:2.0:User"],"userName":"bob","externalId":"00u1","displayName":"Bob","emails":[{"value":"bob@example.com","primary":true}],"active":true}`
+	if status := do(t, s, http.MethodPost, "/Users", body, created); status != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", status)
+	}
+	if created.ID != "bob" || created.ExternalID != "00u1" || created.Active == nil || !*created.Active || created.Meta.Location != "/scim/v2/okta/Users/bob" {
+		t.Errorf("unexpected user %#v", created)
+	}
+	identity, err := userClient.UserV1().Identities().Get(context.TODO(), "okta:bob", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Extra[authapi.IdentityEmailKey] != "bob@example.com" || identity.Extra[authapi.IdentityDisplayNameKey] != "Bob" {
+		t.Errorf("unexpected extra %v", identity.Extra)
+	}
+	if status := do(t, s, http.MethodPost, "/Users", body, nil); status != http.StatusConflict {
+		t.Errorf("expected 409 for an existing user, got %d", status)
+	}
+
+	list := &ListResponse{}
+	if status := do(t, s, http.MethodGet, `/Users?filter=userName+eq+%22bob%22`, "", list); status != http.StatusOK || list.TotalResults != 1 {
+		t.Errorf("expected to find bob, got %d %#v", status, list)
+	}
+	if status := do(t, s, http.MethodGet, `/Users?filter=userName+eq+%22alice%22`, "", list); status != http.StatusOK || list.TotalResults != 0 {
+		t.Errorf("expected no users, got %d %#v", status, list)
+	}
+	if status := do(t, s, http.MethodGet, `/Users?filter=emails+co+%22bob%22`, "", nil); status != http.StatusBadRequest {
+		t.Errorf("expected 400 for an unsupported filter, got %d", status)
+	}
+
+	if _, err := oauthClient.OauthV1().OAuthAccessTokens().Create(context.TODO(), &oauthv1.OAuthAccessToken{ObjectMeta: metav1.ObjectMeta{Name: "sha256~bob"}, UserName: "bob", UserUID: "bob-uid"}, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	// Azure AD sends booleans as strings
+	patched := &User{}
+	patch := `{"schemas":["urn:ietf:params:scim:api:messages:2.0:PatchOp"],"Operations":[{"op":"Replace","path":"active","value":"False"}]}`
+	if status := do(t, s, http.MethodPatch, "/Users/bob", patch, patched); status != http.StatusOK {
+		t.Fatalf("expected 200, got %d", status)
+	}
+	if patched.Active == nil || *patched.Active {
+		t.Errorf("expected bob to be deactivated, got %#v", patched)
+	}
+	tokens, err := oauthClient.OauthV1().OAuthAccessTokens().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens.Items) != 0 {
+		t.Errorf("expected the tokens of a deactivated user to be revoked, got %d tokens", len(tokens.Items))
+	}
+	mapper := NewUserMapper(&testMapper{userClient: userClient}, userClient.UserV1().Identities())
+	if _, err := mapper.UserFor(authapi.NewDefaultUserIdentityInfo("okta", "bob")); err == nil {
+		t.Errorf("expected the login of a deactivated user to be rejected")
+	}
+
+	if status := do(t, s, http.MethodDelete, "/Users/bob", "", nil); status != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", status)
+	}
+	if _, err := userClient.UserV1().Identities().Get(context.TODO(), "okta:bob", metav1.GetOptions{}); err == nil {
+		t.Errorf("expected the identity to be deleted")
+	}
+	if _, err := userClient.UserV1().Users().Get(context.TODO(), "bob", metav1.GetOptions{}); err == nil {
+		t.Errorf("expected the user without identities to be deleted")
+	}
+	if status := do(t, s, http.MethodGet, "/Users/bob", "", nil); status != http.StatusNotFound {
+		t.Errorf("expected 404 for a deleted user, got %d", status)
+	}
+}
+
+func TestGroups(t *testing.T) {
+	s, userClient, _ := newTestServer(t)
+	for _, name := range []string{"alice", "bob"} {
+		if status := do(t, s, http.MethodPost, "/Users", `{"userName":"`+name+`"}`, nil); status != http.StatusCreated {
+			t.Fatalf("expected 201, got %d", status)
+		}
+	}
+	if _, err := userClient.UserV1().Groups().Create(context.TODO(), &userv1.Group{ObjectMeta: metav1.ObjectMeta{Name: "okta:local"}}, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	group := &Group{}
+	if status := do(t, s, http.MethodPost, "/Groups", `{"displayName":"admins","members":[{"value":"alice"}]}`, group); status != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", status)
+	}
+	if status := do(t, s, http.MethodPost, "/Groups", `{"displayName":"admins"}`, nil); status != http.StatusConflict {
+		t.Errorf("expected 409 for an existing group, got %d", status)
+	}
+	if status := do(t, s, http.MethodPost, "/Groups", `{"displayName":"others","members":[{"value":"carol"}]}`, nil); status != http.StatusBadRequest {
+		t.Errorf("expected 400 for an unknown member, got %d", status)
+	}
+	if status := do(t, s, http.MethodGet, "/Groups/local", "", nil); status != http.StatusNotFound {
+		t.Errorf("expected groups not managed by SCIM to not be found, got %d", status)
+	}
+
+	patch := `{"Operations":[{"op":"add","path":"members","value":[{"value":"bob"}]},{"op":"remove","path":"members[value eq \"alice\"]"}]}`
+	if status := do(t, s, http.MethodPatch, "/Groups/admins", patch, group); status != http.StatusOK {
+		t.Fatalf("expected 200, got %d", status)
+	}
+	if len(group.Members) != 1 || group.Members[0].Value != "bob" {
+		t.Errorf("expected bob to be the only member, got %#v", group.Members)
+	}
+	stored, err := userClient.UserV1().Groups().Get(context.TODO(), "okta:admins", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored.Users) != 1 || stored.Users[0] != "bob" {
+		t.Errorf("expected the group to have the user bob, got %v", stored.Users)
+	}
+	if status := do(t, s, http.MethodPatch, "/Groups/admins", `{"Operations":[{"op":"replace","path":"displayName","value":"owners"}]}`, nil); status != http.StatusBadRequest {
+		t.Errorf("expected 400 for a new displayName, got %d", status)
+	}
+
+	if status := do(t, s, http.MethodDelete, "/Users/bob", "", nil); status != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", status)
+	}
+	if status := do(t, s, http.MethodGet, "/Groups/admins", "", group); status != http.StatusOK || len(group.Members) != 0 {
+		t.Errorf("expected deleted users to be removed from groups, got %d %#v", status, group.Members)
+	}
+	if status := do(t, s, http.MethodDelete, "/Groups/admins", "", nil); status != http.StatusNoContent {
+		t.Errorf("expected 204, got %d", status)
+	}
+}
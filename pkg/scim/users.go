@@ -0,0 +1,457 @@
+package scim
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+
+	userv1 "github.com/openshift/api/user/v1"
+
+	authapi "github.com/openshift/oauth-server/pkg/api"
+)
+
+// User is a SCIM user, the identity of a user at the identity provider
+type User struct {
+	Schemas     []string `json:"schemas"`
+	ID          string   `json:"id,omitempty"`
+	ExternalID  string   `json:"externalId,omitempty"`
+	UserName    string   `json:"userName"`
+	DisplayName string   `json:"displayName,omitempty"`
+	Emails      []Email  `json:"emails,omitempty"`
+	// Active is true for users that did not set it
+	Active *bool `json:"active,omitempty"`
+	Meta   *Meta `json:"meta,omitempty"`
+}
+
+// Email is an email address of a user, only the primary or first address is kept
+type Email struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// userChanges are the attributes of a user that requests change, nil attributes are left alone
+type userChanges struct {
+	displayName *string
+	email       *string
+	externalID  *string
+	active      *bool
+}
+
+func (s *Server) serveUsers(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		users, err := s.listUsers(req)
+		if err != nil {
+			writeFailure(w, "listing users", err)
+			return
+		}
+		writeList(w, req, users)
+	case http.MethodPost:
+		var user User
+		if !decode(w, req, &user) {
+			return
+		}
+		created, err := s.createUser(&user)
+		if err != nil {
+			writeFailure(w, "creating user", err)
+			return
+		}
+		w.Header().Set("Location", created.Meta.Location)
+		writeJSON(w, http.StatusCreated, created)
+	default:
+		methodNotAllowed(w)
+	}
+}
+
+func (s *Server) serveUser(w http.ResponseWriter, req *http.Request) {
+	id := req.URL.Path
+	var user *User
+	var err error
+	switch req.Method {
+	case http.MethodGet:
+		user, err = s.getUser(id)
+	case http.MethodPut:
+		var replacement User
+		if !decode(w, req, &replacement) {
+			return
+		}
+		user, err = s.replaceUser(id, &replacement)
+	case http.MethodPatch:
+		var patch PatchRequest
+		if !decode(w, req, &patch) {
+			return
+		}
+		user, err = s.patchUser(id, &patch)
+	case http.MethodDelete:
+		if err := s.deleteUser(id); err != nil {
+			writeFailure(w, "deleting user", err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		methodNotAllowed(w)
+		return
+	}
+	if err != nil {
+		writeFailure(w, "updating user", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, user)
+}
+
+func (s *Server) listUsers(req *http.Request) ([]interface{}, error) {
+	userName, filtered, err := filterValue(req, "userName")
+	if err != nil {
+		return nil, err
+	}
+	users := []interface{}{}
+	if filtered {
+		identity, err := s.identities.Get(context.TODO(), s.identityName(userName), metav1.GetOptions{})
+		if kerrors.IsNotFound(err) {
+			return users, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return append(users, s.toUser(identity)), nil
+	}
+
+	identities, err := s.identities.List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for i := range identities.Items {
+		if identities.Items[i].ProviderName == s.providerName {
+			users = append(users, s.toUser(&identities.Items[i]))
+		}
+	}
+	return users, nil
+}
+
+func (s *Server) getUser(id string) (*User, error) {
+	identity, err := s.getIdentity(id)
+	if err != nil {
+		return nil, err
+	}
+	return s.toUser(identity), nil
+}
+
+// createUser provisions the user of a new identity like a login of the identity would
+func (s *Server) createUser(user *User) (*User, error) {
+	if len(user.UserName) == 0 {
+		return nil, badRequest(errInvalidValue, "userName is required")
+	}
+	_, err := s.identities.Get(context.TODO(), s.identityName(user.UserName), metav1.GetOptions{})
+	if err == nil {
+		return nil, &requestError{status: http.StatusConflict, scimType: errUniqueness, detail: fmt.Sprintf("user %q already exists", user.UserName)}
+	}
+	if !kerrors.IsNotFound(err) {
+		return nil, err
+	}
+
+	changes := userChanges{displayName: &user.DisplayName, externalID: &user.ExternalID, active: user.Active}
+	if email := primaryEmail(user.Emails); len(email) > 0 {
+		changes.email = &email
+	}
+	identityInfo := authapi.NewDefaultUserIdentityInfo(s.providerName, user.UserName)
+	changes.applyExtra(identityInfo.Extra)
+	if _, err := s.mapper.UserFor(identityInfo); err != nil {
+		return nil, fmt.Errorf("unable to provision user for identity %q: %v", identityInfo.GetIdentityName(), err)
+	}
+	return s.updateUser(user.UserName, changes)
+}
+
+// replaceUser replaces the attributes of the user, the userName cannot change
+func (s *Server) replaceUser(id string, replacement *User) (*User, error) {
+	if len(replacement.UserName) > 0 && replacement.UserName != id {
+		return nil, badRequest(errMutability, "userName cannot change")
+	}
+	email := primaryEmail(replacement.Emails)
+	return s.updateUser(id, userChanges{
+		displayName: &replacement.DisplayName,
+		email:       &email,
+		externalID:  &replacement.ExternalID,
+		active:      replacement.Active,
+	})
+}
+
+func (s *Server) patchUser(id string, patch *PatchRequest) (*User, error) {
+	changes := userChanges{}
+	for _, operation := range patch.Operations {
+		switch strings.ToLower(operation.Op) {
+		case "add", "replace":
+			if len(operation.Path) > 0 {
+				if err := changes.set(id, operation.Path, operation.Value); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			var attributes map[string]json.RawMessage
+			if err := json.Unmarshal(operation.Value, &attributes); err != nil {
+				return nil, badRequest(errInvalidValue, "the value of operations without path must be an object")
+			}
+			for attribute, value := range attributes {
+				if err := changes.set(id, attribute, value); err != nil {
+					return nil, err
+				}
+			}
+		case "remove":
+			changes.remove(operation.Path)
+		default:
+			return nil, badRequest(errInvalidSyntax, "unknown operation %q", operation.Op)
+		}
+	}
+	return s.updateUser(id, changes)
+}
+
+// deleteUser revokes the tokens and sessions of the user of the identity and deletes the identity. The user is
+// deleted with its last identity.
+func (s *Server) deleteUser(id string) error {
+	identity, err := s.getIdentity(id)
+	if err != nil {
+		return err
+	}
+	if err := s.revoke(identity); err != nil {
+		return err
+	}
+	if err := s.identities.Delete(context.TODO(), identity.Name, metav1.DeleteOptions{}); err != nil && !kerrors.IsNotFound(err) {
+		return err
+	}
+	if len(identity.User.Name) == 0 {
+		return nil
+	}
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		user, err := s.users.Get(context.TODO(), identity.User.Name, metav1.GetOptions{})
+		if kerrors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if string(user.UID) != string(identity.User.UID) {
+			return nil
+		}
+		identities := []string{}
+		for _, name := range user.Identities {
+			if name != identity.Name {
+				identities = append(identities, name)
+			}
+		}
+		if len(identities) > 0 {
+			user.Identities = identities
+			_, err := s.users.Update(context.TODO(), user, metav1.UpdateOptions{})
+			return err
+		}
+		klog.V(2).Infof("identity provider %q deleted the last identity %q of user %q, deleting the user", s.providerName, identity.Name, user.Name)
+		if err := s.removeMember(user.Name); err != nil {
+			return err
+		}
+		return s.users.Delete(context.TODO(), user.Name, metav1.DeleteOptions{})
+	})
+}
+
+// updateUser applies the changes to the identity, and revokes the tokens and sessions of its user once it is
+// deactivated
+func (s *Server) updateUser(id string, changes userChanges) (*User, error) {
+	var updated *userv1.Identity
+	deactivated := false
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		identity, err := s.getIdentity(id)
+		if err != nil {
+			return err
+		}
+		wasActive := identity.Annotations[DeactivatedAnnotation] != "true"
+		if identity.Extra == nil {
+			identity.Extra = map[string]string{}
+		}
+		changes.applyExtra(identity.Extra)
+		if changes.externalID != nil {
+			setAnnotation(&identity.ObjectMeta, ExternalIDAnnotation, *changes.externalID)
+		}
+		if changes.active != nil {
+			value := ""
+			if !*changes.active {
+				value = "true"
+			}
+			setAnnotation(&identity.ObjectMeta, DeactivatedAnnotation, value)
+		}
+		deactivated = wasActive && identity.Annotations[DeactivatedAnnotation] == "true"
+
+		updated, err = s.identities.Update(context.TODO(), identity, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if deactivated {
+		klog.V(2).Infof("identity provider %q deactivated identity %q, revoking the tokens and sessions of user %q", s.providerName, updated.Name, updated.User.Name)
+		if err := s.revoke(updated); err != nil {
+			return nil, err
+		}
+	}
+	return s.toUser(updated), nil
+}
+
+// revoke revokes the tokens and sessions of the user of the identity
+func (s *Server) revoke(identity *userv1.Identity) error {
+	if len(identity.User.Name) == 0 || len(identity.User.UID) == 0 {
+		return nil
+	}
+	return s.revoker.RevokeUser(identity.User.Name, string(identity.User.UID))
+}
+
+func (s *Server) getIdentity(id string) (*userv1.Identity, error) {
+	identity, err := s.identities.Get(context.TODO(), s.identityName(id), metav1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		return nil, notFound("user %q not found", id)
+	}
+	return identity, err
+}
+
+func (s *Server) identityName(userName string) string {
+	return authapi.NewDefaultUserIdentityInfo(s.providerName, userName).GetIdentityName()
+}
+
+func (s *Server) toUser(identity *userv1.Identity) *User {
+	active := identity.Annotations[DeactivatedAnnotation] != "true"
+	user := &User{
+		Schemas:     []string{userSchema},
+		ID:          identity.ProviderUserName,
+		ExternalID:  identity.Annotations[ExternalIDAnnotation],
+		UserName:    identity.ProviderUserName,
+		DisplayName: identity.Extra[authapi.IdentityDisplayNameKey],
+		Active:      &active,
+		Meta:        &Meta{ResourceType: "User", Location: path.Join(s.prefix, usersPath, url.PathEscape(identity.ProviderUserName))},
+	}
+	if email := identity.Extra[authapi.IdentityEmailKey]; len(email) > 0 {
+		user.Emails = []Email{{Value: email, Primary: true}}
+	}
+	return user
+}
+
+// set records the change of the attribute at path, unknown attributes are ignored
+func (c *userChanges) set(id, path string, value json.RawMessage) error {
+	attribute := strings.ToLower(path)
+	switch {
+	case attribute == "username":
+		var userName string
+		if err := json.Unmarshal(value, &userName); err != nil || userName != id {
+			return badRequest(errMutability, "userName cannot change")
+		}
+	case attribute == "displayname":
+		return unmarshalString(path, value, &c.displayName)
+	case attribute == "externalid":
+		return unmarshalString(path, value, &c.externalID)
+	case attribute == "active":
+		active, err := unmarshalBool(value)
+		if err != nil {
+			return badRequest(errInvalidValue, "active must be a boolean")
+		}
+		c.active = &active
+	case attribute == "emails":
+		var emails []Email
+		if err := json.Unmarshal(value, &emails); err != nil {
+			return badRequest(errInvalidValue, "emails must be a list of emails")
+		}
+		email := primaryEmail(emails)
+		c.email = &email
+	case strings.HasPrefix(attribute, "emails[") && strings.HasSuffix(attribute, "].value"):
+		return unmarshalString(path, value, &c.email)
+	default:
+		klog.V(4).Infof("ignoring change of unsupported SCIM user attribute %q", path)
+	}
+	return nil
+}
+
+// remove records the removal of the attribute at path, attributes that cannot be removed are ignored
+func (c *userChanges) remove(path string) {
+	empty := ""
+	switch attribute := strings.ToLower(path); {
+	case attribute == "displayname":
+		c.displayName = &empty
+	case attribute == "externalid":
+		c.externalID = &empty
+	case strings.HasPrefix(attribute, "emails"):
+		c.email = &empty
+	default:
+		klog.V(4).Infof("ignoring removal of unsupported SCIM user attribute %q", path)
+	}
+}
+
+// applyExtra records the display name and email in the extra attributes of an identity
+func (c *userChanges) applyExtra(extra map[string]string) {
+	for key, value := range map[string]*string{authapi.IdentityDisplayNameKey: c.displayName, authapi.IdentityEmailKey: c.email} {
+		switch {
+		case value == nil:
+		case len(*value) == 0:
+			delete(extra, key)
+		default:
+			extra[key] = *value
+		}
+	}
+}
+
+func unmarshalString(path string, value json.RawMessage, target **string) error {
+	var s string
+	if err := json.Unmarshal(value, &s); err != nil {
+		return badRequest(errInvalidValue, "%s must be a string", path)
+	}
+	*target = &s
+	return nil
+}
+
+// unmarshalBool accepts booleans and the strings "true" and "false" in any case, which Azure AD sends
+func unmarshalBool(value json.RawMessage) (bool, error) {
+	var b bool
+	if err := json.Unmarshal(value, &b); err == nil {
+		return b, nil
+	}
+	var s string
+	if err := json.Unmarshal(value, &s); err != nil {
+		return false, err
+	}
+	switch strings.ToLower(s) {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid boolean %q", s)
+}
+
+// primaryEmail returns the primary email, or the first one if none is primary
+func primaryEmail(emails []Email) string {
+	for _, email := range emails {
+		if email.Primary {
+			return email.Value
+		}
+	}
+	if len(emails) > 0 {
+		return emails[0].Value
+	}
+	return ""
+}
+
+func setAnnotation(meta *metav1.ObjectMeta, key, value string) {
+	if len(value) == 0 {
+		delete(meta.Annotations, key)
+		return
+	}
+	if meta.Annotations == nil {
+		meta.Annotations = map[string]string{}
+	}
+	meta.Annotations[key] = value
+}
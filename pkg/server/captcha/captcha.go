@@ -0,0 +1,148 @@
+// Package captcha asks users to solve a CAPTCHA or a proof-of-work challenge on the password login form
+// once their source IP or username failed to log in too often, to slow down guessing passwords.
+package captcha
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/cache"
+
+	"github.com/openshift/oauth-server/pkg/server/clientip"
+)
+
+const (
+	// TypeHCaptcha is rendered as the widget of hCaptcha
+	TypeHCaptcha = "hcaptcha"
+	// TypeReCAPTCHA is rendered as the widget of reCAPTCHA
+	TypeReCAPTCHA = "recaptcha"
+	// TypeProofOfWork is rendered as hidden inputs that a script fills with the solution
+	TypeProofOfWork = "proofofwork"
+
+	// maxTrackedKeys bounds the memory used to count failures, the least recently failing
+	// source IPs and usernames are forgotten first
+	maxTrackedKeys = 10000
+)
+
+// Challenge is a CAPTCHA or another challenge that users solve in the browser
+type Challenge interface {
+	// Form returns what the login form renders to let users solve a new challenge
+	Form() Form
+	// Verify returns true if the submitted form holds a solution of a challenge. Errors mean
+	// that the solution could not be checked.
+	Verify(req *http.Request) (bool, error)
+	// Sources are the origins the challenge loads scripts, styles and frames from,
+	// they have to be allowed by the Content-Security-Policy of the login page
+	Sources() []string
+}
+
+// Form holds what templates need to render a challenge
+type Form struct {
+	// Type is one of TypeHCaptcha, TypeReCAPTCHA and TypeProofOfWork
+	Type string
+	// ScriptURL is the script that renders the widget or solves the challenge
+	ScriptURL string
+	// SiteKey identifies the site to CAPTCHA services
+	SiteKey string
+
+	// Challenge and Difficulty are the proof-of-work challenge, the script submits
+	// its solution in the input named NonceName
+	Challenge     string
+	Difficulty    int
+	ChallengeName string
+	NonceName     string
+}
+
+// Guard requires a challenge once a source IP or username failed to log in threshold times. Failures
+// are forgotten after window passed without another failure. The methods of a nil Guard never
+// require a challenge.
+type Guard struct {
+	challenge Challenge
+	threshold int
+	window    time.Duration
+
+	lock     sync.Mutex
+	failures *cache.LRUExpireCache
+}
+
+func NewGuard(challenge Challenge, threshold int, window time.Duration) *Guard {
+	return newGuard(challenge, threshold, window, cache.NewLRUExpireCache(maxTrackedKeys))
+}
+
+func newGuard(challenge Challenge, threshold int, window time.Duration, failures *cache.LRUExpireCache) *Guard {
+	return &Guard{
+		challenge: challenge,
+		threshold: threshold,
+		window:    window,
+		failures:  failures,
+	}
+}
+
+// Required returns true if the source IP of the request or the username, if any, crossed the threshold
+func (g *Guard) Required(req *http.Request, username string) bool {
+	if g == nil {
+		return false
+	}
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.count(ipKey(req)) >= g.threshold {
+		return true
+	}
+	return len(username) > 0 && g.count(userKey(username)) >= g.threshold
+}
+
+// Form returns a new challenge for the login form, or nil without a guard
+func (g *Guard) Form() *Form {
+	if g == nil {
+		return nil
+	}
+	form := g.challenge.Form()
+	return &form
+}
+
+// Verify returns true if the request holds a solution of the challenge
+func (g *Guard) Verify(req *http.Request) (bool, error) {
+	if g == nil {
+		return true, nil
+	}
+	return g.challenge.Verify(req)
+}
+
+// Failed counts a failed login of the username from the source IP of the request
+func (g *Guard) Failed(req *http.Request, username string) {
+	if g == nil {
+		return
+	}
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	for _, key := range []string{ipKey(req), userKey(username)} {
+		g.failures.Add(key, g.count(key)+1, g.window)
+	}
+}
+
+// Succeeded forgets the failed logins of the username. Failures of the source IP are kept,
+// so that knowing one password does not allow to keep guessing the passwords of other users.
+func (g *Guard) Succeeded(username string) {
+	if g == nil {
+		return
+	}
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.failures.Remove(userKey(username))
+}
+
+func (g *Guard) count(key string) int {
+	if count, ok := g.failures.Get(key); ok {
+		return count.(int)
+	}
+	return 0
+}
+
+func ipKey(req *http.Request) string {
+	return "ip:" + clientip.SourceIP(req)
+}
+
+func userKey(username string) string {
+	return "user:" + username
+}
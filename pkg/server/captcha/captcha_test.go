@@ -0,0 +1,55 @@
+package captcha
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGuard(t *testing.T) {
+	guard := NewGuard(NewProofOfWork(1, "/pow.js"), 2, time.Minute)
+
+	req := httptest.NewRequest("GET", "/login", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	otherReq := httptest.NewRequest("GET", "/login", nil)
+	otherReq.RemoteAddr = "192.0.2.2:1234"
+
+	guard.Failed(req, "alice")
+	if guard.Required(req, "alice") {
+		t.Fatal("expected no challenge below the threshold")
+	}
+	guard.Failed(otherReq, "alice")
+	if !guard.Required(otherReq, "alice") {
+		t.Error("expected a challenge for the username")
+	}
+	if guard.Required(otherReq, "bob") || guard.Required(otherReq, "") {
+		t.Error("expected no challenge for other usernames")
+	}
+
+	guard.Failed(req, "bob")
+	if !guard.Required(req, "") {
+		t.Error("expected a challenge for the source IP")
+	}
+
+	guard.Succeeded("alice")
+	if guard.Required(otherReq, "alice") {
+		t.Error("expected successful logins to forget the failures of the username")
+	}
+	if !guard.Required(req, "carol") {
+		t.Error("expected successful logins to keep the failures of the source IP")
+	}
+
+	if form := guard.Form(); form == nil || form.Type != TypeProofOfWork {
+		t.Errorf("unexpected form %#v", form)
+	}
+
+	var nilGuard *Guard
+	nilGuard.Failed(req, "alice")
+	nilGuard.Succeeded("alice")
+	if nilGuard.Required(req, "alice") || nilGuard.Form() != nil {
+		t.Error("expected no challenge without a guard")
+	}
+	if solved, err := nilGuard.Verify(req); !solved || err != nil {
+		t.Errorf("expected requests to pass without a guard, got %v %v", solved, err)
+	}
+}
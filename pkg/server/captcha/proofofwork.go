@@ -0,0 +1,179 @@
+package captcha
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"math/bits"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/cache"
+
+	oauthserver "github.com/openshift/oauth-server/pkg"
+)
+
+const (
+	// ProofOfWorkChallengeParam is the form field of the proof-of-work challenge
+	ProofOfWorkChallengeParam = "pow_challenge"
+	// ProofOfWorkNonceParam is the form field of the solution of the proof-of-work challenge
+	ProofOfWorkNonceParam = "pow_nonce"
+
+	// DefaultProofOfWorkDifficulty takes browsers about a second to solve
+	DefaultProofOfWorkDifficulty = 16
+
+	// proofOfWorkTTL is how long a challenge can be solved, and how long its solution is remembered
+	proofOfWorkTTL = 10 * time.Minute
+	// proofOfWorkClockSkew is how far challenges may be ahead of the clock of this server
+	proofOfWorkClockSkew = time.Minute
+	// maxUsedChallenges bounds the memory used to reject solutions that are submitted again
+	maxUsedChallenges = 100000
+)
+
+// ProofOfWork is a challenge without third parties: the browser has to find a nonce so that the SHA-256 hash of
+// "<challenge>:<nonce>" starts with difficulty zero bits. Challenges are a timestamp and random data. They are not
+// signed, which allows any replica to verify them, as every solution costs the same work no matter who made up the
+// challenge. Every solution is accepted once per replica.
+type ProofOfWork struct {
+	difficulty int
+	scriptURL  string
+	now        func() time.Time
+
+	lock sync.Mutex
+	used *cache.LRUExpireCache
+}
+
+// NewProofOfWork returns a proof-of-work challenge solved by the script installed at scriptURL
+func NewProofOfWork(difficulty int, scriptURL string) *ProofOfWork {
+	return &ProofOfWork{
+		difficulty: difficulty,
+		scriptURL:  scriptURL,
+		now:        time.Now,
+		used:       cache.NewLRUExpireCache(maxUsedChallenges),
+	}
+}
+
+// Install serves the script that solves the challenges
+func (p *ProofOfWork) Install(mux oauthserver.Mux, path string) {
+	mux.HandleFunc(path, func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "text/javascript; charset=UTF-8")
+		w.Write([]byte(proofOfWorkScript))
+	})
+}
+
+func (p *ProofOfWork) Form() Form {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		// without randomness, the challenges only differ by their timestamps
+		random = nil
+	}
+	return Form{
+		Type:          TypeProofOfWork,
+		ScriptURL:     p.scriptURL,
+		Challenge:     strconv.FormatInt(p.now().Unix(), 10) + "." + base64.RawURLEncoding.EncodeToString(random),
+		Difficulty:    p.difficulty,
+		ChallengeName: ProofOfWorkChallengeParam,
+		NonceName:     ProofOfWorkNonceParam,
+	}
+}
+
+func (p *ProofOfWork) Sources() []string {
+	return nil
+}
+
+func (p *ProofOfWork) Verify(req *http.Request) (bool, error) {
+	challenge, nonce := req.PostFormValue(ProofOfWorkChallengeParam), req.PostFormValue(ProofOfWorkNonceParam)
+	if len(challenge) == 0 || len(nonce) == 0 {
+		return false, nil
+	}
+
+	timestamp, _, _ := strings.Cut(challenge, ".")
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false, nil
+	}
+	issued, now := time.Unix(seconds, 0), p.now()
+	if issued.Before(now.Add(-proofOfWorkTTL)) || issued.After(now.Add(proofOfWorkClockSkew)) {
+		return false, nil
+	}
+
+	if leadingZeroBits(sha256.Sum256([]byte(challenge+":"+nonce))) < p.difficulty {
+		return false, nil
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if _, used := p.used.Get(challenge); used {
+		return false, nil
+	}
+	p.used.Add(challenge, true, proofOfWorkTTL+proofOfWorkClockSkew)
+	return true, nil
+}
+
+func leadingZeroBits(hash [sha256.Size]byte) int {
+	zeros := 0
+	for _, b := range hash {
+		zeros += bits.LeadingZeros8(b)
+		if b != 0 {
+			break
+		}
+	}
+	return zeros
+}
+
+// proofOfWorkScript solves the challenges of the page as soon as it is loaded, and delays
+// submitting the form until they are solved
+const proofOfWorkScript = `(function () {
+  'use strict';
+
+  function leadingZeroBits(bytes) {
+    var zeros = 0;
+    for (var i = 0; i < bytes.length; i++) {
+      if (bytes[i] === 0) {
+        zeros += 8;
+        continue;
+      }
+      return zeros + Math.clz32(bytes[i]) - 24;
+    }
+    return zeros;
+  }
+
+  async function solve(challenge, difficulty) {
+    var encoder = new TextEncoder();
+    for (var nonce = 0; ; nonce++) {
+      var hash = await crypto.subtle.digest('SHA-256', encoder.encode(challenge + ':' + nonce));
+      if (leadingZeroBits(new Uint8Array(hash)) >= difficulty) {
+        return String(nonce);
+      }
+    }
+  }
+
+  document.addEventListener('DOMContentLoaded', function () {
+    document.querySelectorAll('input[data-proof-of-work-nonce]').forEach(function (challenge) {
+      var form = challenge.form;
+      var nonce = form.elements.namedItem(challenge.getAttribute('data-proof-of-work-nonce'));
+      var submitting = false;
+      var solved = solve(challenge.value, parseInt(challenge.getAttribute('data-proof-of-work-difficulty'), 10)).then(function (solution) {
+        nonce.value = solution;
+      });
+      form.addEventListener('submit', function (event) {
+        if (nonce.value || submitting) {
+          return;
+        }
+        event.preventDefault();
+        submitting = true;
+        solved.then(function () {
+          form.submit();
+        });
+      });
+    });
+  });
+})();
+`
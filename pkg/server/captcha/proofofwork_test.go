@@ -0,0 +1,92 @@
+package captcha
+
+import (
+	"crypto/sha256"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func solve(challenge string, difficulty int) string {
+	for nonce := 0; ; nonce++ {
+		if leadingZeroBits(sha256.Sum256([]byte(challenge+":"+strconv.Itoa(nonce)))) >= difficulty {
+			return strconv.Itoa(nonce)
+		}
+	}
+}
+
+func submit(challenge, nonce string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(url.Values{
+		ProofOfWorkChallengeParam: {challenge},
+		ProofOfWorkNonceParam:     {nonce},
+	}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req
+}
+
+func TestProofOfWork(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	pow := NewProofOfWork(8, "/pow.js")
+	pow.now = func() time.Time { return now }
+
+	form := pow.Form()
+	if form.Type != TypeProofOfWork || form.ScriptURL != "/pow.js" || form.Difficulty != 8 || !strings.HasPrefix(form.Challenge, "1700000000.") {
+		t.Fatalf("unexpected form %#v", form)
+	}
+	if other := pow.Form(); other.Challenge == form.Challenge {
+		t.Error("expected a new challenge for every form")
+	}
+
+	nonce := solve(form.Challenge, 8)
+	wrongNonce := nonce + "0"
+	for leadingZeroBits(sha256.Sum256([]byte(form.Challenge+":"+wrongNonce))) >= 8 {
+		wrongNonce += "0"
+	}
+	old := strconv.FormatInt(now.Add(-time.Hour).Unix(), 10) + ".abc"
+	future := strconv.FormatInt(now.Add(time.Hour).Unix(), 10) + ".abc"
+
+	testCases := []struct {
+		Name      string
+		Challenge string
+		Nonce     string
+		Expect    bool
+	}{
+		{Name: "missing solution", Challenge: form.Challenge},
+		{Name: "wrong solution", Challenge: form.Challenge, Nonce: wrongNonce},
+		{Name: "invalid challenge", Challenge: "abc", Nonce: solve("abc", 8)},
+		{Name: "expired challenge", Challenge: old, Nonce: solve(old, 8)},
+		{Name: "future challenge", Challenge: future, Nonce: solve(future, 8)},
+		{Name: "solution", Challenge: form.Challenge, Nonce: nonce, Expect: true},
+		{Name: "replayed solution", Challenge: form.Challenge, Nonce: nonce},
+	}
+	for _, testCase := range testCases {
+		solved, err := pow.Verify(submit(testCase.Challenge, testCase.Nonce))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", testCase.Name, err)
+		}
+		if solved != testCase.Expect {
+			t.Errorf("%s: expected %v, got %v", testCase.Name, testCase.Expect, solved)
+		}
+	}
+}
+
+func TestProofOfWorkScript(t *testing.T) {
+	mux := http.NewServeMux()
+	NewProofOfWork(8, "/pow.js").Install(mux, "/pow.js")
+
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/pow.js", nil))
+	if resp.Code != http.StatusOK || !strings.HasPrefix(resp.Header().Get("Content-Type"), "text/javascript") || !strings.Contains(resp.Body.String(), "crypto.subtle.digest") {
+		t.Errorf("unexpected response %d %v", resp.Code, resp.Header())
+	}
+
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/pow.js", nil))
+	if resp.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected POST to be rejected, got %d", resp.Code)
+	}
+}
@@ -0,0 +1,111 @@
+package captcha
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/openshift/oauth-server/pkg/server/clientip"
+)
+
+// verifyTimeout bounds the time a login waits for the CAPTCHA service
+const verifyTimeout = 10 * time.Second
+
+// service is a CAPTCHA service that verifies the responses of its widget with a siteverify endpoint,
+// such as hCaptcha and reCAPTCHA
+type service struct {
+	typ           string
+	scriptURL     string
+	verifyURL     string
+	responseParam string
+	sources       []string
+
+	siteKey string
+	secret  string
+	client  *http.Client
+}
+
+// NewHCaptcha returns a challenge that renders the hCaptcha widget of the site and verifies its responses with the secret
+func NewHCaptcha(siteKey, secret string, transport http.RoundTripper) Challenge {
+	return &service{
+		typ:           TypeHCaptcha,
+		scriptURL:     "https://js.hcaptcha.com/1/api.js",
+		verifyURL:     "https://api.hcaptcha.com/siteverify",
+		responseParam: "h-captcha-response",
+		sources:       []string{"https://hcaptcha.com", "https://*.hcaptcha.com"},
+		siteKey:       siteKey,
+		secret:        secret,
+		client:        &http.Client{Transport: transport, Timeout: verifyTimeout},
+	}
+}
+
+// NewReCAPTCHA returns a challenge that renders the reCAPTCHA widget of the site and verifies its responses with the secret
+func NewReCAPTCHA(siteKey, secret string, transport http.RoundTripper) Challenge {
+	return &service{
+		typ:           TypeReCAPTCHA,
+		scriptURL:     "https://www.google.com/recaptcha/api.js",
+		verifyURL:     "https://www.google.com/recaptcha/api/siteverify",
+		responseParam: "g-recaptcha-response",
+		sources:       []string{"https://www.google.com/recaptcha/", "https://www.gstatic.com/recaptcha/", "https://recaptcha.google.com/recaptcha/"},
+		siteKey:       siteKey,
+		secret:        secret,
+		client:        &http.Client{Transport: transport, Timeout: verifyTimeout},
+	}
+}
+
+func (s *service) Form() Form {
+	return Form{Type: s.typ, ScriptURL: s.scriptURL, SiteKey: s.siteKey}
+}
+
+func (s *service) Sources() []string {
+	return s.sources
+}
+
+type verifyResponse struct {
+	Success    bool     `json:"success"`
+	ErrorCodes []string `json:"error-codes"`
+}
+
+func (s *service) Verify(req *http.Request) (bool, error) {
+	response := req.PostFormValue(s.responseParam)
+	if len(response) == 0 {
+		return false, nil
+	}
+
+	values := url.Values{
+		"secret":   {s.secret},
+		"response": {response},
+		"sitekey":  {s.siteKey},
+	}
+	if remoteIP := clientip.SourceIP(req); len(remoteIP) > 0 {
+		values.Set("remoteip", remoteIP)
+	}
+	verifyReq, err := http.NewRequestWithContext(req.Context(), http.MethodPost, s.verifyURL, strings.NewReader(values.Encode()))
+	if err != nil {
+		return false, err
+	}
+	verifyReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := s.client.Do(verifyReq)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("%s verification returned %s", s.typ, resp.Status)
+	}
+	result := verifyResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false, fmt.Errorf("unable to decode %s verification: %v", s.typ, err)
+	}
+	// invalid secrets are a misconfiguration rather than a wrong solution
+	for _, code := range result.ErrorCodes {
+		if code == "invalid-input-secret" || code == "missing-input-secret" {
+			return false, fmt.Errorf("%s rejected the secret: %s", s.typ, code)
+		}
+	}
+	return result.Success, nil
+}
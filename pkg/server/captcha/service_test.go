@@ -0,0 +1,68 @@
+package captcha
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestService(t *testing.T) {
+	var verified url.Values
+	verifyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req.ParseForm()
+		verified = req.PostForm
+		switch req.PostForm.Get("response") {
+		case "valid":
+			w.Write([]byte(`{"success": true}`))
+		case "broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			if req.PostForm.Get("secret") != "mysecret" {
+				w.Write([]byte(`{"success": false, "error-codes": ["invalid-input-secret"]}`))
+				return
+			}
+			w.Write([]byte(`{"success": false, "error-codes": ["invalid-input-response"]}`))
+		}
+	}))
+	defer verifyServer.Close()
+
+	testCases := []struct {
+		Name        string
+		Secret      string
+		Response    string
+		Expect      bool
+		ExpectError bool
+	}{
+		{Name: "missing response", Secret: "mysecret"},
+		{Name: "valid response", Secret: "mysecret", Response: "valid", Expect: true},
+		{Name: "invalid response", Secret: "mysecret", Response: "invalid"},
+		{Name: "invalid secret", Secret: "wrong", Response: "invalid", ExpectError: true},
+		{Name: "service error", Secret: "mysecret", Response: "broken", ExpectError: true},
+	}
+	for _, testCase := range testCases {
+		challenge := NewHCaptcha("mysitekey", testCase.Secret, http.DefaultTransport).(*service)
+		challenge.verifyURL = verifyServer.URL
+
+		req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(url.Values{"h-captcha-response": {testCase.Response}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.RemoteAddr = "192.0.2.1:1234"
+		verified = nil
+
+		solved, err := challenge.Verify(req)
+		if (err != nil) != testCase.ExpectError {
+			t.Errorf("%s: unexpected error: %v", testCase.Name, err)
+		}
+		if solved != testCase.Expect {
+			t.Errorf("%s: expected %v, got %v", testCase.Name, testCase.Expect, solved)
+		}
+		if len(testCase.Response) > 0 && (verified.Get("sitekey") != "mysitekey" || verified.Get("remoteip") != "192.0.2.1") {
+			t.Errorf("%s: unexpected verification request %v", testCase.Name, verified)
+		}
+	}
+
+	if form := NewReCAPTCHA("mysitekey", "mysecret", nil).Form(); form.Type != TypeReCAPTCHA || form.SiteKey != "mysitekey" {
+		t.Errorf("unexpected form %#v", form)
+	}
+}
@@ -0,0 +1,115 @@
+// Package clientip determines the addresses of clients behind trusted proxies, e.g. load balancers, so that audit
+// events, login rate limits and logs record the clients instead of the proxies.
+package clientip
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	forwardedForHeader = "X-Forwarded-For"
+	realIPHeader       = "X-Real-Ip"
+)
+
+// Trusted are the networks of the proxies whose forwarding headers are trusted
+type Trusted struct {
+	networks []*net.IPNet
+}
+
+// NewTrusted parses the CIDRs of the trusted proxies
+func NewTrusted(cidrs []string) (*Trusted, error) {
+	t := &Trusted{}
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy CIDR %q: %v", cidr, err)
+		}
+		t.networks = append(t.networks, network)
+	}
+	return t, nil
+}
+
+// Contains returns true if ip is the address of a trusted proxy
+func (t *Trusted) Contains(ip net.IP) bool {
+	for _, network := range t.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the address of the client that sent req. The X-Forwarded-For header is read from the end, the
+// client is the first address that is not a trusted proxy. The X-Real-IP header is only used without
+// X-Forwarded-For header. The headers are ignored unless the request was sent by a trusted proxy.
+func (t *Trusted) ClientIP(req *http.Request) net.IP {
+	peer := remoteIP(req.RemoteAddr)
+	if peer == nil || !t.Contains(peer) {
+		return peer
+	}
+
+	if forwardedFor := req.Header.Values(forwardedForHeader); len(forwardedFor) > 0 {
+		// several headers are treated as a single list
+		addresses := strings.Split(strings.Join(forwardedFor, ","), ",")
+		client := peer
+		for i := len(addresses) - 1; i >= 0; i-- {
+			ip := net.ParseIP(strings.TrimSpace(addresses[i]))
+			if ip == nil {
+				// the address was not added by a trusted proxy, the last valid address is the best guess
+				break
+			}
+			client = ip
+			if !t.Contains(ip) {
+				break
+			}
+		}
+		return client
+	}
+
+	if ip := net.ParseIP(strings.TrimSpace(req.Header.Get(realIPHeader))); ip != nil {
+		return ip
+	}
+	return peer
+}
+
+// WithClientIP sets the remote address of requests to the address of the client and removes the forwarding
+// headers, so that the handlers that follow, e.g. audit logging, do not need to know about the proxies
+func WithClientIP(handler http.Handler, trusted *Trusted) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if client := trusted.ClientIP(req); client != nil {
+			if _, port, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+				if remoteAddr := net.JoinHostPort(client.String(), port); remoteAddr != req.RemoteAddr {
+					klog.V(5).Infof("Client of request from %s forwarded for %v is %s", req.RemoteAddr, req.Header.Values(forwardedForHeader), client)
+					req.RemoteAddr = remoteAddr
+				}
+			}
+		}
+		req.Header.Del(forwardedForHeader)
+		req.Header.Del(realIPHeader)
+		handler.ServeHTTP(w, req)
+	})
+}
+
+// SourceIP returns the host of the remote address of req, which is the address of the client once WithClientIP
+// replaced the address of a trusted proxy
+func SourceIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// remoteIP returns the IP of a remote address in host:port form
+func remoteIP(remoteAddr string) net.IP {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	return net.ParseIP(host)
+}
@@ -0,0 +1,103 @@
+package clientip
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted, err := NewTrusted([]string{"10.0.0.0/8", "fd00::/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string
+		realIP       string
+		expected     string
+	}{
+		{
+			name:       "direct client",
+			remoteAddr: "192.0.2.1:1234",
+			expected:   "192.0.2.1",
+		},
+		{
+			name:         "untrusted peer",
+			remoteAddr:   "192.0.2.1:1234",
+			forwardedFor: []string{"198.51.100.1"},
+			realIP:       "198.51.100.2",
+			expected:     "192.0.2.1",
+		},
+		{
+			name:         "trusted proxy",
+			remoteAddr:   "10.0.0.1:1234",
+			forwardedFor: []string{"198.51.100.1"},
+			expected:     "198.51.100.1",
+		},
+		{
+			name:         "chain of trusted proxies",
+			remoteAddr:   "10.0.0.1:1234",
+			forwardedFor: []string{"198.51.100.1, 10.0.0.2", "10.0.0.3"},
+			expected:     "198.51.100.1",
+		},
+		{
+			name:         "spoofed addresses before the client",
+			remoteAddr:   "10.0.0.1:1234",
+			forwardedFor: []string{"203.0.113.1, 198.51.100.1"},
+			expected:     "198.51.100.1",
+		},
+		{
+			name:         "invalid address",
+			remoteAddr:   "10.0.0.1:1234",
+			forwardedFor: []string{"invalid, 10.0.0.2"},
+			expected:     "10.0.0.2",
+		},
+		{
+			name:       "real IP",
+			remoteAddr: "[fd00::1]:1234",
+			realIP:     "2001:db8::1",
+			expected:   "2001:db8::1",
+		},
+		{
+			name:       "trusted proxy without headers",
+			remoteAddr: "10.0.0.1:1234",
+			expected:   "10.0.0.1",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/oauth/authorize", nil)
+			req.RemoteAddr = tc.remoteAddr
+			for _, value := range tc.forwardedFor {
+				req.Header.Add(forwardedForHeader, value)
+			}
+			if len(tc.realIP) > 0 {
+				req.Header.Set(realIPHeader, tc.realIP)
+			}
+
+			if ip := trusted.ClientIP(req); ip.String() != tc.expected {
+				t.Errorf("expected %s, got %s", tc.expected, ip)
+			}
+
+			var served *http.Request
+			WithClientIP(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				served = req
+			}), trusted).ServeHTTP(httptest.NewRecorder(), req)
+			if ip := remoteIP(served.RemoteAddr); ip.String() != tc.expected {
+				t.Errorf("expected the remote address of %s, got %s", tc.expected, served.RemoteAddr)
+			}
+			if len(served.Header.Values(forwardedForHeader)) > 0 || len(served.Header.Get(realIPHeader)) > 0 {
+				t.Errorf("expected the forwarding headers to be removed, got %v", served.Header)
+			}
+		})
+	}
+}
+
+func TestNewTrusted(t *testing.T) {
+	if _, err := NewTrusted([]string{"10.0.0.1"}); err == nil {
+		t.Error("expected addresses without prefix length to be rejected")
+	}
+}
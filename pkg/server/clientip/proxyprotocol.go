@@ -0,0 +1,232 @@
+package clientip
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultHeaderTimeout is how long proxies may take to send the PROXY protocol header
+	DefaultHeaderTimeout = 10 * time.Second
+
+	// keepAlivePeriod matches the generic API server, which cannot set it on wrapped connections
+	keepAlivePeriod = 3 * time.Minute
+
+	// maxV1HeaderLength is the longest header of version 1, including the CRLF
+	maxV1HeaderLength = 107
+	// v2HeaderLength is the length of the fixed part of version 2 headers
+	v2HeaderLength = 16
+)
+
+var (
+	v1Signature = []byte("PROXY ")
+	v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// NewProxyProtocolListener accepts connections that start with a PROXY protocol header (version 1 or 2) from trusted
+// proxies, e.g. TCP load balancers. The remote address of these connections is the client address of the header.
+// Connections without header are served as they are, so that e.g. health checks do not need to send one. Headers of
+// other peers are rejected.
+func NewProxyProtocolListener(listener net.Listener, trusted *Trusted, headerTimeout time.Duration) net.Listener {
+	if headerTimeout <= 0 {
+		headerTimeout = DefaultHeaderTimeout
+	}
+	return &proxyProtocolListener{Listener: listener, trusted: trusted, headerTimeout: headerTimeout}
+}
+
+type proxyProtocolListener struct {
+	net.Listener
+	trusted       *Trusted
+	headerTimeout time.Duration
+}
+
+// Accept returns the next connection. Its header is read by the first Read or RemoteAddr call, i.e. by the
+// goroutine that serves the connection.
+func (l *proxyProtocolListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if tc, ok := c.(*net.TCPConn); ok {
+		_ = tc.SetKeepAlive(true)
+		_ = tc.SetKeepAlivePeriod(keepAlivePeriod)
+	}
+	return &proxyProtocolConn{Conn: c, reader: bufio.NewReader(c), trusted: l.trusted, headerTimeout: l.headerTimeout}, nil
+}
+
+type proxyProtocolConn struct {
+	net.Conn
+	reader        *bufio.Reader
+	trusted       *Trusted
+	headerTimeout time.Duration
+
+	once       sync.Once
+	remoteAddr net.Addr
+	err        error
+
+	// readDeadline is restored once the header was read
+	deadlineLock sync.Mutex
+	readDeadline time.Time
+}
+
+func (c *proxyProtocolConn) Read(b []byte) (int, error) {
+	c.readHeader()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+func (c *proxyProtocolConn) RemoteAddr() net.Addr {
+	c.readHeader()
+	return c.remoteAddr
+}
+
+func (c *proxyProtocolConn) SetDeadline(t time.Time) error {
+	c.setReadDeadline(t)
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *proxyProtocolConn) SetReadDeadline(t time.Time) error {
+	c.setReadDeadline(t)
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *proxyProtocolConn) setReadDeadline(t time.Time) {
+	c.deadlineLock.Lock()
+	defer c.deadlineLock.Unlock()
+	c.readDeadline = t
+}
+
+func (c *proxyProtocolConn) readHeader() {
+	c.once.Do(func() {
+		c.remoteAddr = c.Conn.RemoteAddr()
+
+		_ = c.Conn.SetReadDeadline(time.Now().Add(c.headerTimeout))
+		defer func() {
+			c.deadlineLock.Lock()
+			defer c.deadlineLock.Unlock()
+			_ = c.Conn.SetReadDeadline(c.readDeadline)
+		}()
+
+		remoteAddr, err := c.parseHeader()
+		if err != nil {
+			c.err = fmt.Errorf("invalid PROXY protocol header from %s: %v", c.remoteAddr, err)
+			return
+		}
+		if remoteAddr != nil {
+			c.remoteAddr = remoteAddr
+		}
+	})
+}
+
+// parseHeader reads the header, if there is one. It returns the client address, or nil if the connection has no
+// header or the proxy did not pass an address, e.g. for its own health checks.
+func (c *proxyProtocolConn) parseHeader() (net.Addr, error) {
+	first, err := c.reader.Peek(1)
+	if err != nil {
+		// the connection is served without header, reads return the error
+		return nil, nil
+	}
+	var signature []byte
+	switch first[0] {
+	case v1Signature[0]:
+		signature = v1Signature
+	case v2Signature[0]:
+		signature = v2Signature
+	default:
+		return nil, nil
+	}
+	if prefix, err := c.reader.Peek(len(signature)); err != nil || !bytes.Equal(prefix, signature) {
+		return nil, nil
+	}
+
+	if peer := remoteIP(c.Conn.RemoteAddr().String()); peer == nil || !c.trusted.Contains(peer) {
+		return nil, errors.New("the peer is not a trusted proxy")
+	}
+	if signature[0] == v1Signature[0] {
+		return c.parseV1()
+	}
+	return c.parseV2()
+}
+
+// parseV1 parses headers like "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"
+func (c *proxyProtocolConn) parseV1() (net.Addr, error) {
+	line := make([]byte, 0, maxV1HeaderLength)
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) == maxV1HeaderLength {
+			return nil, errors.New("header is too long")
+		}
+		b, err := c.reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+	}
+
+	fields := strings.Fields(strings.TrimSuffix(string(line), "\r\n"))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("malformed header %q", line)
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil {
+		return nil, fmt.Errorf("malformed source address in header %q", line)
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// parseV2 parses binary headers
+func (c *proxyProtocolConn) parseV2() (net.Addr, error) {
+	header := make([]byte, v2HeaderLength)
+	if _, err := io.ReadFull(c.reader, header); err != nil {
+		return nil, err
+	}
+	versionCommand, family := header[12], header[13]
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return nil, err
+	}
+
+	if versionCommand>>4 != 2 {
+		return nil, fmt.Errorf("unsupported version %d", versionCommand>>4)
+	}
+	switch versionCommand & 0x0f {
+	case 0x0:
+		// LOCAL, e.g. health checks of the proxy itself
+		return nil, nil
+	case 0x1:
+		// PROXY
+	default:
+		return nil, fmt.Errorf("unsupported command %d", versionCommand&0x0f)
+	}
+
+	// the remaining bytes are optional TLVs, which are ignored
+	switch family {
+	case 0x11: // TCP over IPv4
+		if len(payload) < 12 {
+			return nil, errors.New("short IPv4 addresses")
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:10]))}, nil
+	case 0x21: // TCP over IPv6
+		if len(payload) < 36 {
+			return nil, errors.New("short IPv6 addresses")
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:34]))}, nil
+	default:
+		// UNSPEC or other transports do not pass a usable address
+		return nil, nil
+	}
+}
@@ -0,0 +1,131 @@
+package clientip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProxyProtocolListener(t *testing.T) {
+	v2 := func(command byte, ip net.IP, port uint16) []byte {
+		header := append([]byte{}, v2Signature...)
+		header = append(header, 0x20|command, 0x11, 0, 12)
+		payload := append(append([]byte{}, ip.To4()...), 192, 0, 2, 100, 0, 0, 1, 187)
+		binary.BigEndian.PutUint16(payload[8:10], port)
+		return append(header, payload...)
+	}
+
+	testCases := []struct {
+		name           string
+		trusted        string
+		send           []byte
+		expectedRemote string
+		expectedData   string
+		expectedError  string
+	}{
+		{
+			name:           "version 1",
+			trusted:        "127.0.0.0/8",
+			send:           []byte("PROXY TCP4 198.51.100.1 192.0.2.100 56324 443\r\nhello"),
+			expectedRemote: "198.51.100.1:56324",
+			expectedData:   "hello",
+		},
+		{
+			name:           "version 1 IPv6",
+			trusted:        "127.0.0.0/8",
+			send:           []byte("PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\nhello"),
+			expectedRemote: "[2001:db8::1]:56324",
+			expectedData:   "hello",
+		},
+		{
+			name:         "version 1 unknown",
+			trusted:      "127.0.0.0/8",
+			send:         []byte("PROXY UNKNOWN\r\nhello"),
+			expectedData: "hello",
+		},
+		{
+			name:           "version 2",
+			trusted:        "127.0.0.0/8",
+			send:           append(v2(0x1, net.ParseIP("198.51.100.1"), 56324), []byte("hello")...),
+			expectedRemote: "198.51.100.1:56324",
+			expectedData:   "hello",
+		},
+		{
+			name:         "version 2 local",
+			trusted:      "127.0.0.0/8",
+			send:         append(v2(0x0, net.ParseIP("198.51.100.1"), 56324), []byte("hello")...),
+			expectedData: "hello",
+		},
+		{
+			name:         "without header",
+			trusted:      "127.0.0.0/8",
+			send:         []byte("\x16\x03\x01hello"),
+			expectedData: "\x16\x03\x01hello",
+		},
+		{
+			name:          "untrusted peer",
+			trusted:       "10.0.0.0/8",
+			send:          []byte("PROXY TCP4 198.51.100.1 192.0.2.100 56324 443\r\nhello"),
+			expectedError: "the peer is not a trusted proxy",
+		},
+		{
+			name:          "malformed header",
+			trusted:       "127.0.0.0/8",
+			send:          []byte("PROXY TCP4 198.51.100.1\r\nhello"),
+			expectedError: "malformed header",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			trusted, err := NewTrusted([]string{tc.trusted})
+			if err != nil {
+				t.Fatal(err)
+			}
+			tcpListener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			listener := NewProxyProtocolListener(tcpListener, trusted, time.Second)
+			defer listener.Close()
+
+			client, err := net.Dial("tcp", tcpListener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+			if _, err := client.Write(tc.send); err != nil {
+				t.Fatal(err)
+			}
+			client.(*net.TCPConn).CloseWrite()
+
+			conn, err := listener.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			expectedRemote := tc.expectedRemote
+			if len(expectedRemote) == 0 {
+				expectedRemote = client.LocalAddr().String()
+			}
+			if remote := conn.RemoteAddr().String(); remote != expectedRemote {
+				t.Errorf("expected remote address %s, got %s", expectedRemote, remote)
+			}
+
+			data, err := ioutil.ReadAll(conn)
+			if len(tc.expectedError) > 0 {
+				if err == nil || !strings.Contains(err.Error(), tc.expectedError) {
+					t.Errorf("expected error %q, got %v", tc.expectedError, err)
+				}
+				return
+			}
+			if err != nil || !bytes.Equal(data, []byte(tc.expectedData)) {
+				t.Errorf("expected %q, got %q: %v", tc.expectedData, data, err)
+			}
+		})
+	}
+}
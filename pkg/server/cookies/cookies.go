@@ -0,0 +1,62 @@
+// Package cookies holds the attributes of the cookies set by the oauth-server.
+package cookies
+
+import (
+	"net/http"
+)
+
+const (
+	// HostPrefix requires cookies to be secure, to have the path / and no domain
+	HostPrefix = "__Host-"
+	// SecurePrefix requires cookies to be secure
+	SecurePrefix = "__Secure-"
+)
+
+// Options are the attributes of the session and CSRF cookies. Expires and Max-Age are never set,
+// all cookies are session cookies. They are always HttpOnly.
+type Options struct {
+	// NamePrefix is prepended to the names of the cookies, either HostPrefix or SecurePrefix
+	NamePrefix string
+	Domain     string
+	// Path defaults to /
+	Path     string
+	Secure   bool
+	SameSite http.SameSite
+	// Partitioned keeps the cookies of embedded pages apart per top-level site (CHIPS)
+	Partitioned bool
+}
+
+// Name returns the name of the cookie with the given base name
+func (o Options) Name(name string) string {
+	return o.NamePrefix + name
+}
+
+// New returns a cookie with the given name and value and the attributes of the options.
+// The name must already include the prefix.
+func (o Options) New(name, value string) *http.Cookie {
+	path := o.Path
+	if len(path) == 0 {
+		path = "/"
+	}
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		Domain:   o.Domain,
+		Secure:   o.Secure,
+		HttpOnly: true,
+		SameSite: o.SameSite,
+	}
+}
+
+// Set adds the cookie to the response. Unlike http.SetCookie, it adds the Partitioned attribute if requested.
+func (o Options) Set(w http.ResponseWriter, cookie *http.Cookie) {
+	v := cookie.String()
+	if len(v) == 0 {
+		return
+	}
+	if o.Partitioned {
+		v += "; Partitioned"
+	}
+	w.Header().Add("Set-Cookie", v)
+}
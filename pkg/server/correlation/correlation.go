@@ -0,0 +1,99 @@
+// Package correlation identifies the requests of a login attempt, e.g. the authorize request, the login form and
+// the callback of the identity provider, so that the log lines and audit events of an attempt can be found from
+// the reference that error pages show to users.
+package correlation
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+
+	"k8s.io/apiserver/pkg/audit"
+	"k8s.io/klog/v2"
+
+	"github.com/openshift/oauth-server/pkg/server/cookies"
+	"github.com/openshift/oauth-server/pkg/server/crypto"
+)
+
+const (
+	// Header passes the correlation ID of requests, e.g. from proxies or clients that start a login attempt. It is
+	// also set on responses.
+	Header = "X-Correlation-ID"
+	// AuditAnnotation records the correlation ID in audit events
+	AuditAnnotation = "authentication.openshift.io/correlation-id"
+
+	cookieName = "correlation"
+)
+
+// idPattern matches the accepted IDs, they are written to logs and pages as they are
+var idPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{8,64}$`)
+
+type attemptKeyType int
+
+const attemptKey attemptKeyType = iota
+
+// attempt holds the correlation ID of a request, it may be replaced while the request is served
+type attempt struct {
+	id         string
+	cookieName string
+	options    cookies.Options
+}
+
+// WithCorrelationID assigns a correlation ID to requests and records it in their audit events. Requests for which
+// startsAttempt returns true get a new ID, other requests keep the ID of the login attempt that a cookie holds. IDs
+// passed in the Header take precedence.
+func WithCorrelationID(handler http.Handler, cookieOptions cookies.Options, startsAttempt func(*http.Request) bool) http.Handler {
+	name := cookieOptions.Name(cookieName)
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		a := &attempt{cookieName: name, options: cookieOptions}
+		if id := req.Header.Get(Header); Valid(id) {
+			a.id = id
+		} else if startsAttempt(req) {
+			a.id = crypto.RandomBitsString(96)
+			klog.V(4).Infof("Login attempt %s started by %s %s", a.id, req.Method, req.URL.Path)
+		} else if cookie, err := req.Cookie(name); err == nil && Valid(cookie.Value) {
+			a.id = cookie.Value
+		}
+		if len(a.id) > 0 {
+			a.set(w, req)
+		}
+
+		handler.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), attemptKey, a)))
+
+		if len(a.id) > 0 {
+			audit.AddAuditAnnotation(req.Context(), AuditAnnotation, a.id)
+		}
+	})
+}
+
+// set returns the ID in the response and remembers it for the following requests of the browser
+func (a *attempt) set(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set(Header, a.id)
+	if cookie, err := req.Cookie(a.cookieName); err != nil || cookie.Value != a.id {
+		a.options.Set(w, a.options.New(a.cookieName, a.id))
+	}
+}
+
+// ID returns the correlation ID of req, or an empty string if it has none
+func ID(req *http.Request) string {
+	if a, ok := req.Context().Value(attemptKey).(*attempt); ok {
+		return a.id
+	}
+	return ""
+}
+
+// Continue makes id the correlation ID of req and the following requests, e.g. when it is restored from the state
+// parameter of an identity provider callback. Invalid IDs are ignored.
+func Continue(w http.ResponseWriter, req *http.Request, id string) {
+	a, ok := req.Context().Value(attemptKey).(*attempt)
+	if !ok || !Valid(id) || a.id == id {
+		return
+	}
+	a.id = id
+	a.set(w, req)
+}
+
+// Valid returns true if id can be used as correlation ID
+func Valid(id string) bool {
+	return idPattern.MatchString(id)
+}
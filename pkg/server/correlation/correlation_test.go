@@ -0,0 +1,123 @@
+package correlation
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/openshift/oauth-server/pkg/server/cookies"
+)
+
+func TestWithCorrelationID(t *testing.T) {
+	testCases := []struct {
+		name          string
+		header        string
+		cookie        string
+		startsAttempt bool
+		expectedID    string
+		expectNewID   bool
+		expectCookie  bool
+	}{
+		{
+			name: "no login attempt",
+		},
+		{
+			name:          "new login attempt",
+			startsAttempt: true,
+			expectNewID:   true,
+			expectCookie:  true,
+		},
+		{
+			name:       "login attempt of the cookie",
+			cookie:     "attempt-1234",
+			expectedID: "attempt-1234",
+		},
+		{
+			name:          "new login attempt replaces the cookie",
+			cookie:        "attempt-1234",
+			startsAttempt: true,
+			expectNewID:   true,
+			expectCookie:  true,
+		},
+		{
+			name:         "header takes precedence",
+			header:       "request-5678",
+			cookie:       "attempt-1234",
+			expectedID:   "request-5678",
+			expectCookie: true,
+		},
+		{
+			name:          "invalid header",
+			header:        "<script>",
+			startsAttempt: true,
+			expectNewID:   true,
+			expectCookie:  true,
+		},
+		{
+			name:   "invalid cookie",
+			cookie: "short",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var id string
+			handler := WithCorrelationID(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				id = ID(req)
+			}), cookies.Options{NamePrefix: "__Host-"}, func(*http.Request) bool { return tc.startsAttempt })
+
+			req := httptest.NewRequest(http.MethodGet, "/oauth/authorize", nil)
+			if len(tc.header) > 0 {
+				req.Header.Set(Header, tc.header)
+			}
+			if len(tc.cookie) > 0 {
+				req.AddCookie(&http.Cookie{Name: "__Host-correlation", Value: tc.cookie})
+			}
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+
+			switch {
+			case tc.expectNewID:
+				if !Valid(id) || id == tc.cookie {
+					t.Errorf("expected a new ID, got %q", id)
+				}
+			case id != tc.expectedID:
+				t.Errorf("expected ID %q, got %q", tc.expectedID, id)
+			}
+			if header := resp.Header().Get(Header); header != id {
+				t.Errorf("expected the header %q, got %q", id, header)
+			}
+			setCookies := resp.Result().Cookies()
+			if tc.expectCookie != (len(setCookies) == 1 && setCookies[0].Name == "__Host-correlation" && setCookies[0].Value == id) {
+				t.Errorf("expected cookie %v, got %v", tc.expectCookie, setCookies)
+			}
+		})
+	}
+}
+
+func TestContinue(t *testing.T) {
+	var id string
+	handler := WithCorrelationID(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		Continue(w, req, "<invalid>")
+		Continue(w, req, "attempt-1234")
+		id = ID(req)
+	}), cookies.Options{}, func(*http.Request) bool { return false })
+
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/oauth2callback/idp", nil))
+	if id != "attempt-1234" {
+		t.Errorf("expected the login attempt to continue, got %q", id)
+	}
+	if header := resp.Header().Get(Header); header != id {
+		t.Errorf("expected the header %q, got %q", id, header)
+	}
+	if setCookies := resp.Result().Cookies(); len(setCookies) != 1 || setCookies[0].Value != id {
+		t.Errorf("expected the cookie of the login attempt, got %v", setCookies)
+	}
+
+	// requests outside of the filter have no login attempt
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	Continue(httptest.NewRecorder(), req, "attempt-1234")
+	if id := ID(req); len(id) > 0 {
+		t.Errorf("expected no ID, got %q", id)
+	}
+}
@@ -0,0 +1,109 @@
+// Package cors lets browser applications on other origins call the JSON endpoints of the server, e.g. single-page
+// applications that are public clients and exchange their authorization codes at the token endpoint.
+package cors
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// DefaultAllowedMethods are the methods of the JSON endpoints
+	DefaultAllowedMethods = []string{http.MethodGet, http.MethodPost}
+	// DefaultAllowedHeaders are the headers clients send to the JSON endpoints
+	DefaultAllowedHeaders = []string{"Authorization", "Content-Type", "DPoP"}
+)
+
+// Policy determines which cross-origin requests browsers allow. Credentials, i.e. cookies, are never allowed,
+// clients authenticate with the parameters and headers of their requests.
+type Policy struct {
+	// AllowedOrigins are matched against the Origin header, like the corsAllowedOrigins of the osin config
+	AllowedOrigins []*regexp.Regexp
+	// AllowedMethods and AllowedHeaders are allowed in requests, they default to DefaultAllowedMethods and
+	// DefaultAllowedHeaders
+	AllowedMethods []string
+	AllowedHeaders []string
+	// ExposedHeaders are the response headers that applications may read in addition to the CORS-safelisted ones
+	ExposedHeaders []string
+	// MaxAge is how long browsers may cache the responses of preflight requests, they choose if it is not set
+	MaxAge time.Duration
+}
+
+// NewPolicy compiles the regular expressions of the allowed origins
+func NewPolicy(allowedOrigins, allowedMethods, allowedHeaders, exposedHeaders []string, maxAge time.Duration) (*Policy, error) {
+	policy := &Policy{
+		AllowedMethods: allowedMethods,
+		AllowedHeaders: allowedHeaders,
+		ExposedHeaders: exposedHeaders,
+		MaxAge:         maxAge,
+	}
+	if len(policy.AllowedMethods) == 0 {
+		policy.AllowedMethods = DefaultAllowedMethods
+	}
+	if len(policy.AllowedHeaders) == 0 {
+		policy.AllowedHeaders = DefaultAllowedHeaders
+	}
+	for _, origin := range allowedOrigins {
+		re, err := regexp.Compile(origin)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowed origin %q: %v", origin, err)
+		}
+		policy.AllowedOrigins = append(policy.AllowedOrigins, re)
+	}
+	return policy, nil
+}
+
+// allowed returns true if requests from origin are allowed
+func (p *Policy) allowed(origin string) bool {
+	for _, re := range p.AllowedOrigins {
+		if re.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// WithCORS adds the CORS headers of policy to the responses to requests for paths and answers their preflight
+// requests. Requests for other paths, and requests whose responses already have CORS headers, are passed to handler
+// unchanged.
+func WithCORS(handler http.Handler, policy *Policy, paths ...string) http.Handler {
+	covered := map[string]bool{}
+	for _, path := range paths {
+		covered[path] = true
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !covered[req.URL.Path] {
+			handler.ServeHTTP(w, req)
+			return
+		}
+
+		// responses differ by origin, caches must not mix them up
+		w.Header().Add("Vary", "Origin")
+		origin := req.Header.Get("Origin")
+		// the generic CORS filter of the handler chain already added the headers for the corsAllowedOrigins of the
+		// osin config, only one of the two policies may apply to a response
+		if len(origin) == 0 || len(w.Header().Get("Access-Control-Allow-Origin")) > 0 || !policy.allowed(origin) {
+			handler.ServeHTTP(w, req)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		if req.Method == http.MethodOptions && len(req.Header.Get("Access-Control-Request-Method")) > 0 {
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(policy.AllowedMethods, ", "))
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(policy.AllowedHeaders, ", "))
+			if policy.MaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(policy.MaxAge.Seconds())))
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if len(policy.ExposedHeaders) > 0 {
+			w.Header().Set("Access-Control-Expose-Headers", strings.Join(policy.ExposedHeaders, ", "))
+		}
+		handler.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,117 @@
+package cors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWithCORS(t *testing.T) {
+	policy, err := NewPolicy([]string{`^https://(app|console)\.example\.com$`}, nil, nil, []string{"DPoP-Nonce"}, 10*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cors := WithCORS(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}), policy, "/oauth/token")
+	// stands in for the generic CORS filter of the handler chain and its corsAllowedOrigins
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if origin := req.Header.Get("Origin"); origin == "https://console.example.com" {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+		cors.ServeHTTP(w, req)
+	})
+
+	testCases := []struct {
+		name            string
+		method          string
+		path            string
+		origin          string
+		expectedCode    int
+		expectedHeaders map[string]string
+	}{
+		{
+			name:         "preflight",
+			method:       http.MethodOptions,
+			path:         "/oauth/token",
+			origin:       "https://app.example.com",
+			expectedCode: http.StatusNoContent,
+			expectedHeaders: map[string]string{
+				"Access-Control-Allow-Origin":      "https://app.example.com",
+				"Access-Control-Allow-Methods":     "GET, POST",
+				"Access-Control-Allow-Headers":     "Authorization, Content-Type, DPoP",
+				"Access-Control-Max-Age":           "600",
+				"Access-Control-Allow-Credentials": "",
+				"Vary":                             "Origin",
+			},
+		},
+		{
+			name:         "request",
+			method:       http.MethodPost,
+			path:         "/oauth/token",
+			origin:       "https://app.example.com",
+			expectedCode: http.StatusOK,
+			expectedHeaders: map[string]string{
+				"Access-Control-Allow-Origin":   "https://app.example.com",
+				"Access-Control-Expose-Headers": "DPoP-Nonce",
+				"Access-Control-Allow-Methods":  "",
+			},
+		},
+		{
+			name:         "other origin",
+			method:       http.MethodOptions,
+			path:         "/oauth/token",
+			origin:       "https://app.example.com.attacker.com",
+			expectedCode: http.StatusOK,
+			expectedHeaders: map[string]string{
+				"Access-Control-Allow-Origin": "",
+				"Vary":                        "Origin",
+			},
+		},
+		{
+			name:         "generic filter origin",
+			method:       http.MethodPost,
+			path:         "/oauth/token",
+			origin:       "https://console.example.com",
+			expectedCode: http.StatusOK,
+			expectedHeaders: map[string]string{
+				"Access-Control-Allow-Origin":      "https://console.example.com",
+				"Access-Control-Allow-Credentials": "true",
+				"Access-Control-Expose-Headers":    "",
+			},
+		},
+		{
+			name:         "other path",
+			method:       http.MethodOptions,
+			path:         "/oauth/authorize",
+			origin:       "https://app.example.com",
+			expectedCode: http.StatusOK,
+			expectedHeaders: map[string]string{
+				"Access-Control-Allow-Origin": "",
+				"Vary":                        "",
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			req.Header.Set("Origin", tc.origin)
+			if tc.method == http.MethodOptions {
+				req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			}
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+
+			if resp.Code != tc.expectedCode {
+				t.Errorf("expected %d, got %d", tc.expectedCode, resp.Code)
+			}
+			for header, expected := range tc.expectedHeaders {
+				if value := resp.Header().Get(header); value != expected {
+					t.Errorf("expected %s %q, got %q", header, expected, value)
+				}
+			}
+		})
+	}
+}
@@ -3,25 +3,22 @@ package csrf
 import (
 	"net/http"
 
+	"github.com/openshift/oauth-server/pkg/server/cookies"
 	"github.com/openshift/oauth-server/pkg/server/crypto"
 )
 
 type cookieCsrf struct {
-	name   string
-	path   string
-	domain string
-	secure bool
+	name    string
+	options cookies.Options
 }
 
 // NewCookieCSRF stores random CSRF tokens in a cookie created with the given options.
-// Empty CSRF tokens or tokens that do not match the value of the cookie on the request
-// are rejected.
-func NewCookieCSRF(name, path, domain string, secure bool) CSRF {
+// The name must already include the prefix of the options. Empty CSRF tokens or tokens
+// that do not match the value of the cookie on the request are rejected.
+func NewCookieCSRF(name string, options cookies.Options) CSRF {
 	return &cookieCsrf{
-		name:   name,
-		path:   path,
-		domain: domain,
-		secure: secure,
+		name:    name,
+		options: options,
 	}
 }
 
@@ -34,16 +31,9 @@ func (c *cookieCsrf) Generate(w http.ResponseWriter, req *http.Request) string {
 		return cookie.Value
 	}
 
-	// do not set Expires or MaxAge to make this a session cookie
-	cookie = &http.Cookie{
-		Name:     c.name,
-		Value:    crypto.Random256BitsString(),
-		Path:     c.path,
-		Domain:   c.domain,
-		Secure:   c.secure,
-		HttpOnly: true,
-	}
-	http.SetCookie(w, cookie)
+	// the options do not set Expires or MaxAge, this is a session cookie
+	cookie = c.options.New(c.name, crypto.Random256BitsString())
+	c.options.Set(w, cookie)
 
 	return cookie.Value
 }
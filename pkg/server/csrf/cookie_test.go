@@ -4,19 +4,21 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+
+	"github.com/openshift/oauth-server/pkg/server/cookies"
 )
 
 func TestCookieGenerate(t *testing.T) {
 
 	testCases := map[string]struct {
 		Name           string
-		Path           string
-		Domain         string
-		Secure         bool
+		Options        cookies.Options
 		ExistingCookie *http.Cookie
 
 		ExpectToken     string
 		ExpectSetCookie bool
+		// ExpectAttributes are the attributes of the Set-Cookie header after the value
+		ExpectAttributes string
 	}{
 		"use existing": {
 			Name:           "csrf",
@@ -29,28 +31,37 @@ func TestCookieGenerate(t *testing.T) {
 		"set missing": {
 			Name: "csrf",
 
-			ExpectSetCookie: true,
+			ExpectSetCookie:  true,
+			ExpectAttributes: "; Path=/; HttpOnly",
 		},
 
 		"set missing with other cookies": {
 			Name:           "csrf",
 			ExistingCookie: &http.Cookie{Name: "csrf2", Value: "existingvalue"},
 
-			ExpectSetCookie: true,
+			ExpectSetCookie:  true,
+			ExpectAttributes: "; Path=/; HttpOnly",
 		},
 
 		"set missing with cookie options": {
-			Name:   "csrf",
-			Path:   "/",
-			Domain: "foo.com",
-			Secure: true,
+			Name:    "csrf",
+			Options: cookies.Options{Path: "/oauth", Domain: "foo.com", Secure: true},
 
-			ExpectSetCookie: true,
+			ExpectSetCookie:  true,
+			ExpectAttributes: "; Path=/oauth; Domain=foo.com; HttpOnly; Secure",
+		},
+
+		"set missing with same site and partitioned": {
+			Name:    "__Host-csrf",
+			Options: cookies.Options{NamePrefix: cookies.HostPrefix, Secure: true, SameSite: http.SameSiteNoneMode, Partitioned: true},
+
+			ExpectSetCookie:  true,
+			ExpectAttributes: "; Path=/; HttpOnly; Secure; SameSite=None; Partitioned",
 		},
 	}
 
 	for k, testCase := range testCases {
-		csrf := NewCookieCSRF(testCase.Name, testCase.Path, testCase.Domain, testCase.Secure)
+		csrf := NewCookieCSRF(testCase.Name, testCase.Options)
 
 		req, _ := http.NewRequest("GET", "/", nil)
 		if testCase.ExistingCookie != nil {
@@ -72,16 +83,9 @@ func TestCookieGenerate(t *testing.T) {
 				continue
 			}
 
-			protoCookie := &http.Cookie{
-				Name:     testCase.Name,
-				Value:    token,
-				Path:     testCase.Path,
-				Domain:   testCase.Domain,
-				Secure:   testCase.Secure,
-				HttpOnly: true,
-			}
-			if setCookie != protoCookie.String() {
-				t.Errorf("%s: Expected Set-Cookie header of \"%s\", got \"%s\"", k, protoCookie.String(), setCookie)
+			expected := testCase.Name + "=" + token + testCase.ExpectAttributes
+			if setCookie != expected {
+				t.Errorf("%s: Expected Set-Cookie header of \"%s\", got \"%s\"", k, expected, setCookie)
 				continue
 			}
 		} else {
@@ -151,7 +155,7 @@ func TestCookieCheck(t *testing.T) {
 	}
 
 	for k, testCase := range testCases {
-		csrf := NewCookieCSRF(testCase.Name, "", "", false)
+		csrf := NewCookieCSRF(testCase.Name, cookies.Options{})
 
 		req, _ := http.NewRequest("GET", "/", nil)
 		if testCase.ExistingCookie != nil {
@@ -0,0 +1,98 @@
+package csrf
+
+import (
+	"net/http"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/openshift/oauth-server/pkg/server/cookies"
+	"github.com/openshift/oauth-server/pkg/server/crypto"
+)
+
+// DefaultTokenLifetime is how long signed CSRF tokens are valid
+const DefaultTokenLifetime = time.Hour
+
+// Codec signs and encrypts values, e.g. the session keys
+type Codec interface {
+	Encode(name string, value interface{}) (string, error)
+	Decode(name, value string, dst interface{}) error
+}
+
+// signedToken is the content of a signed CSRF token
+type signedToken struct {
+	// ID is the value of the CSRF cookie of the browser the token was issued to
+	ID string
+	// Expires is the unix time the token expires at
+	Expires int64
+}
+
+type signedCsrf struct {
+	name     string
+	options  cookies.Options
+	codec    Codec
+	lifetime time.Duration
+	now      func() time.Time
+}
+
+// NewSignedCSRF issues CSRF tokens that are signed with the codec and expire after the lifetime. Tokens are bound to
+// the browser by a random ID in a cookie created with the given options, the name must already include the prefix
+// of the options. Servers that share the secrets of the codec validate each other's tokens without shared state,
+// and cookies that were set by other sites of the domain cannot be paired with tokens.
+func NewSignedCSRF(name string, options cookies.Options, codec Codec, lifetime time.Duration) CSRF {
+	if lifetime <= 0 {
+		lifetime = DefaultTokenLifetime
+	}
+	return &signedCsrf{
+		name:     name,
+		options:  options,
+		codec:    codec,
+		lifetime: lifetime,
+		now:      time.Now,
+	}
+}
+
+// Generate implements the CSRF interface
+func (c *signedCsrf) Generate(w http.ResponseWriter, req *http.Request) string {
+	id := ""
+	if cookie, err := req.Cookie(c.name); err == nil {
+		id = cookie.Value
+	}
+	if len(id) == 0 {
+		// the options do not set Expires or MaxAge, this is a session cookie
+		cookie := c.options.New(c.name, crypto.Random256BitsString())
+		c.options.Set(w, cookie)
+		id = cookie.Value
+	}
+
+	token, err := c.codec.Encode(c.name, &signedToken{ID: id, Expires: c.now().Add(c.lifetime).Unix()})
+	if err != nil {
+		// the form cannot be submitted, the user has to try again
+		klog.Errorf("error signing CSRF token: %v", err)
+		return ""
+	}
+	return token
+}
+
+// Check implements the CSRF interface
+func (c *signedCsrf) Check(req *http.Request, value string) bool {
+	if len(value) == 0 {
+		return false
+	}
+
+	cookie, err := req.Cookie(c.name)
+	if err != nil || len(cookie.Value) == 0 {
+		return false
+	}
+
+	token := &signedToken{}
+	if err := c.codec.Decode(c.name, value, token); err != nil {
+		klog.V(4).Infof("invalid CSRF token: %v", err)
+		return false
+	}
+	if c.now().Unix() >= token.Expires {
+		klog.V(4).Infof("expired CSRF token")
+		return false
+	}
+	return crypto.IsEqualConstantTime(cookie.Value, token.ID)
+}
@@ -0,0 +1,78 @@
+package csrf
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/openshift/oauth-server/pkg/server/cookies"
+	"github.com/openshift/oauth-server/pkg/server/session"
+)
+
+func TestSignedCSRF(t *testing.T) {
+	// secrets are pairs of authentication and encryption keys
+	previous := [][]byte{[]byte("0123456789abcdef0123456789abcdef"), []byte("abcdef0123456789abcdef0123456789")}
+	current := [][]byte{[]byte("fedcba9876543210fedcba9876543210"), []byte("9876543210fedcba9876543210fedcba")}
+	keys := session.NewKeys(previous...)
+	now := time.Now()
+	replica := func() *signedCsrf {
+		c := NewSignedCSRF("csrf", cookies.Options{}, keys, 0).(*signedCsrf)
+		c.now = func() time.Time { return now }
+		return c
+	}
+	first, second := replica(), replica()
+
+	w := httptest.NewRecorder()
+	token := first.Generate(w, httptest.NewRequest(http.MethodGet, "/login", nil))
+	cookie := w.Result().Cookies()[0]
+	if cookie.Name != "csrf" || len(cookie.Value) == 0 || token == cookie.Value {
+		t.Fatalf("expected a random ID in the cookie and a signed token, got %v and %q", cookie, token)
+	}
+
+	request := func(cookie *http.Cookie) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/login", nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		return req
+	}
+
+	// the cookie is reused
+	w = httptest.NewRecorder()
+	if other := second.Generate(w, request(cookie)); !first.Check(request(cookie), other) || len(w.Result().Cookies()) > 0 {
+		t.Errorf("expected a token for the existing cookie")
+	}
+
+	if !second.Check(request(cookie), token) {
+		t.Errorf("expected other replicas to accept the token")
+	}
+	if second.Check(request(nil), token) {
+		t.Errorf("expected tokens to require the cookie")
+	}
+	if second.Check(request(&http.Cookie{Name: "csrf", Value: "injected"}), token) {
+		t.Errorf("expected tokens to be bound to the cookie they were issued for")
+	}
+	if second.Check(request(cookie), cookie.Value) {
+		t.Errorf("expected the value of the cookie not to be a valid token")
+	}
+	if second.Check(request(cookie), "") {
+		t.Errorf("expected empty tokens to be rejected")
+	}
+
+	// rotated secrets still decode tokens of the previous secrets
+	keys.Set(append(current, previous...)...)
+	if !second.Check(request(cookie), token) {
+		t.Errorf("expected tokens of previous secrets to be accepted")
+	}
+	keys.Set(current...)
+	if second.Check(request(cookie), token) {
+		t.Errorf("expected tokens of removed secrets to be rejected")
+	}
+
+	token = first.Generate(httptest.NewRecorder(), request(cookie))
+	now = now.Add(DefaultTokenLifetime)
+	if second.Check(request(cookie), token) {
+		t.Errorf("expected expired tokens to be rejected")
+	}
+}
@@ -0,0 +1,82 @@
+// Package deadline limits the time the server spends on the requests of logins. The budget of a request is the
+// deadline of its context, calls that take a context or a request honor it, calls to identity providers and the API
+// that do not are abandoned once it passes.
+package deadline
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// DefaultBudget is the time the server spends on a request of a login by default
+const DefaultBudget = 30 * time.Second
+
+// ErrExceeded is returned for calls that did not complete within the budget of their request
+var ErrExceeded = errors.New("the login did not complete in time")
+
+// WithBudget limits the context of the requests that isLogin returns true for to budget, so that every step of the
+// login shares a single deadline instead of each call having its own timeout
+func WithBudget(handler http.Handler, budget time.Duration, isLogin func(*http.Request) bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !isLogin(req) {
+			handler.ServeHTTP(w, req)
+			return
+		}
+		ctx, cancel := context.WithTimeout(req.Context(), budget)
+		defer cancel()
+		handler.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
+
+// Call runs fn, unless ctx ends before fn returns. It returns ErrExceeded once the deadline of ctx passed, or the
+// error of ctx if it was canceled, e.g. because the client went away. fn keeps running in the background then, the
+// results it sets must only be used if Call returns nil.
+func Call(ctx context.Context, fn func()) error {
+	if ctx.Done() == nil {
+		fn()
+		return nil
+	}
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn()
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return contextError(ctx)
+	}
+}
+
+func contextError(ctx context.Context) error {
+	err := ctx.Err()
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrExceeded
+	}
+	return err
+}
+
+// Transport returns a transport that sends requests with ctx, e.g. for clients that do not pass the context of the
+// requests they serve on. A nil transport uses http.DefaultTransport.
+func Transport(ctx context.Context, transport http.RoundTripper) http.RoundTripper {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return &contextTransport{ctx: ctx, transport: transport}
+}
+
+type contextTransport struct {
+	ctx       context.Context
+	transport http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.transport.RoundTrip(req.WithContext(t.ctx))
+}
@@ -0,0 +1,67 @@
+package deadline
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWithBudget(t *testing.T) {
+	var deadlines []bool
+	handler := WithBudget(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, ok := req.Context().Deadline()
+		deadlines = append(deadlines, ok)
+	}), time.Minute, func(req *http.Request) bool {
+		return req.URL.Path == "/oauth/authorize"
+	})
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/oauth/authorize", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/oauth/token/display", nil))
+	if len(deadlines) != 2 || !deadlines[0] || deadlines[1] {
+		t.Errorf("expected only the login to have a deadline, got %v", deadlines)
+	}
+}
+
+func TestCall(t *testing.T) {
+	called := false
+	if err := Call(context.Background(), func() { called = true }); err != nil || !called {
+		t.Errorf("expected the call without deadline to complete, got %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	release := make(chan struct{})
+	defer close(release)
+	if err := Call(ctx, func() { <-release }); !errors.Is(err, ErrExceeded) {
+		t.Errorf("expected ErrExceeded, got %v", err)
+	}
+	if err := Call(ctx, func() { t.Error("expected no call once the budget is spent") }); !errors.Is(err, ErrExceeded) {
+		t.Errorf("expected ErrExceeded, got %v", err)
+	}
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := Call(canceled, func() {}); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the cancellation, got %v", err)
+	}
+}
+
+func TestTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-req.Context().Done()
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	client := &http.Client{Transport: Transport(ctx, nil)}
+	start := time.Now()
+	if _, err := client.Get(server.URL); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the request to end with the context, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected the request to end with the context, took %v", elapsed)
+	}
+}
@@ -1,12 +1,34 @@
 package errorpage
 
-import "github.com/openshift/oauth-server/pkg/userregistry/identitymapper"
+import (
+	"errors"
+	"net/http"
+
+	"github.com/openshift/oauth-server/pkg/api"
+	"github.com/openshift/oauth-server/pkg/server/deadline"
+	"github.com/openshift/oauth-server/pkg/server/locales"
+	"github.com/openshift/oauth-server/pkg/userregistry/identitymapper"
+)
 
 const (
 	// error occurred attempting to claim a user
 	errorCodeClaim = "mapping_claim_error"
 	// error occurred looking up the user
 	errorCodeLookup = "mapping_lookup_error"
+	// the identity references a user that belongs to other identities
+	errorCodeConflict = "mapping_conflict"
+	// the state of an identity provider callback could not be verified, e.g. due to a CSRF mismatch
+	errorCodeInvalidState = "invalid_state"
+	// the identity provider returned an error or could not be reached
+	errorCodeIdentityProvider = "identity_provider_error"
+	// the user was authenticated, but access was denied
+	errorCodeAccessDenied = "access_denied"
+	// the login succeeded, but the user was sent back to log in too often, e.g. because cookies are blocked
+	errorCodeRedirectLoop = "redirect_loop"
+	// the login did not complete within the budget of its request, e.g. because the identity provider is slow
+	errorCodeTimeout = "login_timeout"
+	// the user could not be provisioned because the API server is overloaded
+	errorCodeUnavailable = "server_unavailable"
 	// general authentication error
 	errorCodeAuthentication = "authentication_error"
 	// general grant error
@@ -16,11 +38,26 @@ const (
 // AuthenticationErrorCode returns an error code for the given authentication error.
 // If the error is not recognized, a generic error code is returned.
 func AuthenticationErrorCode(err error) string {
+	var authorizationDeniedError api.AuthorizationDeniedError
 	switch {
+	case errors.Is(err, deadline.ErrExceeded):
+		return errorCodeTimeout
+	case errors.As(err, &authorizationDeniedError):
+		return errorCodeAccessDenied
+	case identitymapper.IsUnavailableError(err):
+		return errorCodeUnavailable
 	case identitymapper.IsClaimError(err):
 		return errorCodeClaim
 	case identitymapper.IsLookupError(err):
 		return errorCodeLookup
+	case identitymapper.IsMappingConflictError(err):
+		return errorCodeConflict
+	case errors.As(err, &api.InvalidStateError{}):
+		return errorCodeInvalidState
+	case errors.As(err, &api.IdentityProviderError{}):
+		return errorCodeIdentityProvider
+	case errors.As(err, &api.RedirectLoopError{}):
+		return errorCodeRedirectLoop
 	default:
 		return errorCodeAuthentication
 	}
@@ -34,11 +71,92 @@ func AuthenticationErrorMessage(code string) string {
 		return "Could not create user."
 	case errorCodeLookup:
 		return "Could not find user."
+	case errorCodeConflict:
+		return "The user of this identity belongs to another identity."
+	case errorCodeAccessDenied:
+		return "Access denied."
+	case errorCodeInvalidState:
+		return "The login could not be verified. Please try again."
+	case errorCodeIdentityProvider:
+		return "The identity provider could not log you in."
+	case errorCodeRedirectLoop:
+		return "You were sent back to the login too often. Please allow cookies and try again."
+	case errorCodeTimeout:
+		return "The login took too long. Please try again."
+	case errorCodeUnavailable:
+		return "The server is busy right now. Please try again in a minute."
 	default:
 		return "An authentication error occurred."
 	}
 }
 
+// LocalizedAuthenticationErrorMessage returns the error message for the given authentication error code
+// in the language of the localization, falling back to the English message if it has no translation.
+func LocalizedAuthenticationErrorMessage(code string, locale locales.Localization) string {
+	key := "AnAuthenticationErrorOccurred"
+	switch code {
+	case errorCodeClaim:
+		key = "CouldNotCreateUser"
+	case errorCodeLookup:
+		key = "CouldNotFindUser"
+	case errorCodeConflict:
+		key = "UserBelongsToAnotherIdentity"
+	case errorCodeAccessDenied:
+		key = "AccessDenied"
+	case errorCodeInvalidState:
+		key = "TheLoginCouldNotBeVerified"
+	case errorCodeIdentityProvider:
+		key = "TheIdentityProviderCouldNotLogYouIn"
+	case errorCodeRedirectLoop:
+		key = "YouWereSentBackToTheLoginTooOften"
+	case errorCodeTimeout:
+		key = "TheLoginTookTooLong"
+	case errorCodeUnavailable:
+		key = "TheServerIsBusy"
+	}
+	if msg, ok := locale[key]; ok {
+		return msg
+	}
+	return AuthenticationErrorMessage(code)
+}
+
+// ErrorCodes returns the codes of authentication and grant errors
+func ErrorCodes() []string {
+	return []string{
+		errorCodeClaim,
+		errorCodeLookup,
+		errorCodeConflict,
+		errorCodeAccessDenied,
+		errorCodeInvalidState,
+		errorCodeIdentityProvider,
+		errorCodeRedirectLoop,
+		errorCodeTimeout,
+		errorCodeUnavailable,
+		errorCodeAuthentication,
+		errorCodeGrant,
+	}
+}
+
+// errorCodeStatus returns the HTTP status of JSON error responses with the given code
+func errorCodeStatus(code string) int {
+	switch code {
+	case errorCodeAccessDenied:
+		return http.StatusForbidden
+	case errorCodeClaim, errorCodeConflict:
+		return http.StatusConflict
+	case errorCodeInvalidState, errorCodeRedirectLoop:
+		return http.StatusBadRequest
+	case errorCodeIdentityProvider:
+		return http.StatusBadGateway
+	case errorCodeTimeout:
+		return http.StatusGatewayTimeout
+	case errorCodeUnavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
 // GrantErrorCode returns an error code for the given grant error.
 // If the error is not recognized, a generic error code is returned.
 func GrantErrorCode(err error) string {
@@ -50,3 +168,22 @@ func GrantErrorCode(err error) string {
 func GrantErrorMessage(code string) string {
 	return "A grant error occurred."
 }
+
+// LocalizedGrantErrorMessage returns the error message for the given grant error code in the language
+// of the localization, falling back to the English message if it has no translation.
+func LocalizedGrantErrorMessage(code string, locale locales.Localization) string {
+	if msg, ok := locale["AGrantErrorOccurred"]; ok {
+		return msg
+	}
+	return GrantErrorMessage(code)
+}
+
+// AuthenticationErrorUserMessage returns the message that the denying party wants
+// to show to the user, or an empty string if there is none.
+func AuthenticationErrorUserMessage(err error) string {
+	var authorizationDeniedError api.AuthorizationDeniedError
+	if errors.As(err, &authorizationDeniedError) {
+		return authorizationDeniedError.UserMessage()
+	}
+	return ""
+}
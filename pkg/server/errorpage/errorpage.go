@@ -2,21 +2,39 @@ package errorpage
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"html/template"
 	"net/http"
 
+	"github.com/munnerz/goautoneg"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
-	"k8s.io/klog/v2"
+	"k8s.io/apimachinery/pkg/util/sets"
 
 	"github.com/openshift/library-go/pkg/apiserver/httprequest"
 
+	"github.com/openshift/oauth-server/pkg/logging"
+	"github.com/openshift/oauth-server/pkg/server/correlation"
 	"github.com/openshift/oauth-server/pkg/server/locales"
+	"github.com/openshift/oauth-server/pkg/server/pathprefix"
+	"github.com/openshift/oauth-server/pkg/userregistry/identitymapper"
 )
 
 // ErrorPage implements auth and grant error handling by rendering an error page for browser-like clients
 type ErrorPage struct {
 	render ErrorPageRenderer
+	// help holds the text shown with errors, by error code
+	help map[string]Help
+	// offerProviderSelection links error pages of identity provider logins to the provider selection
+	offerProviderSelection bool
+}
+
+// Help is text shown on the error pages of an error code, e.g. how to request access
+type Help struct {
+	Text string
+	// LocalizedText overrides Text by language, e.g. "ja"
+	LocalizedText map[string]string
 }
 
 // NewErrorPageHandler returns an auth and grant error handler using the given renderer
@@ -24,41 +42,171 @@ func NewErrorPageHandler(renderer ErrorPageRenderer) *ErrorPage {
 	return &ErrorPage{render: renderer}
 }
 
+// NewErrorPageHandlerWithHelp returns an auth and grant error handler using the given renderer that shows the help
+// of error codes. If offerProviderSelection is true, users whose login with an identity provider failed are offered
+// to select another one.
+func NewErrorPageHandlerWithHelp(renderer ErrorPageRenderer, help map[string]Help, offerProviderSelection bool) (*ErrorPage, error) {
+	codes := sets.NewString(ErrorCodes()...)
+	for code := range help {
+		if !codes.Has(code) {
+			return nil, fmt.Errorf("unknown error code %q, expected one of %v", code, ErrorCodes())
+		}
+	}
+	return &ErrorPage{render: renderer, help: help, offerProviderSelection: offerProviderSelection}, nil
+}
+
+type providerSelectionKeyType int
+
+const providerSelectionKey providerSelectionKeyType = iota
+
+// WithProviderSelectionURL returns req with the server-relative URL of the authorize request that shows the provider
+// selection again, for error pages of identity provider logins
+func WithProviderSelectionURL(req *http.Request, selectionURL string) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), providerSelectionKey, selectionURL))
+}
+
 func (p *ErrorPage) AuthenticationError(err error, w http.ResponseWriter, req *http.Request) (bool, error) {
-	klog.Errorf("AuthenticationError: %v", err)
+	logging.FromRequest(req).Error(err, "AuthenticationError")
 	// Only render html error pages for browser-like things
 	if !httprequest.PrefersHTML(req) {
 		return false, err
 	}
 
-	errorData := ErrorData{}
-	errorData.ErrorCode = AuthenticationErrorCode(err)
-	errorData.Error = AuthenticationErrorMessage(errorData.ErrorCode)
-
-	p.render.Render(errorData, w, req)
+	p.render.Render(p.authenticationErrorData(err, req), w, req)
 	return true, nil
 }
 
 func (p *ErrorPage) GrantError(err error, w http.ResponseWriter, req *http.Request) (bool, error) {
-	klog.Errorf("GrantError: %v", err)
+	logging.FromRequest(req).Error(err, "GrantError")
 	// Only render html error pages for browser-like things
 	if !httprequest.PrefersHTML(req) {
 		return false, err
 	}
 
-	errorData := ErrorData{}
+	p.render.Render(p.grantErrorData(err, req), w, req)
+	return true, nil
+}
+
+// JSON returns the handler of the JSON variant of the error pages. It writes the errors of clients that accept JSON,
+// so it should follow the handlers that may redirect them back to OAuth clients.
+func (p *ErrorPage) JSON() *JSONErrorHandler {
+	return &JSONErrorHandler{page: p}
+}
+
+func (p *ErrorPage) authenticationErrorData(err error, req *http.Request) ErrorData {
+	locale := locales.ForRequest(req)
+	errorData := ErrorData{CorrelationID: correlation.ID(req)}
+	errorData.ErrorCode = AuthenticationErrorCode(err)
+	errorData.Error = LocalizedAuthenticationErrorMessage(errorData.ErrorCode, locale)
+	if message := AuthenticationErrorUserMessage(err); len(message) > 0 {
+		errorData.Error = message
+	}
+	if identity, user, ok := identitymapper.Conflict(err); ok {
+		errorData.Conflict = &Conflict{Identity: identity, User: user}
+	}
+	errorData.Help = p.helpText(errorData.ErrorCode, locale)
+	if selectionURL, ok := req.Context().Value(providerSelectionKey).(string); ok && p.offerProviderSelection {
+		errorData.ProviderSelectionURL = pathprefix.External(req, selectionURL)
+	}
+	return errorData
+}
+
+func (p *ErrorPage) grantErrorData(err error, req *http.Request) ErrorData {
+	locale := locales.ForRequest(req)
+	errorData := ErrorData{CorrelationID: correlation.ID(req)}
 	errorData.ErrorCode = GrantErrorCode(err)
-	errorData.Error = GrantErrorMessage(errorData.ErrorCode)
+	errorData.Error = LocalizedGrantErrorMessage(errorData.ErrorCode, locale)
+	errorData.Help = p.helpText(errorData.ErrorCode, locale)
+	return errorData
+}
 
-	p.render.Render(errorData, w, req)
-	return true, nil
+// helpText returns the help of code in the language of the localization, if there is any
+func (p *ErrorPage) helpText(code string, locale locales.Localization) string {
+	help, ok := p.help[code]
+	if !ok {
+		return ""
+	}
+	if text, ok := help.LocalizedText[locale["Lang"]]; ok {
+		return text
+	}
+	return help.Text
 }
 
 // ErrorData holds fields for the error page renderer
 type ErrorData struct {
 	Error     string
 	ErrorCode string
-	Locale    locales.Localization
+	// Help is the text that the operator configured for the error code
+	Help string
+	// ProviderSelectionURL lets users select another identity provider, if it is set
+	ProviderSelectionURL string
+	// CorrelationID is the reference of the login attempt in the logs and audit events of the server
+	CorrelationID string
+	// Conflict is set if the identity could not log in because its user belongs to other identities
+	Conflict *Conflict
+	Locale   locales.Localization
+}
+
+// Conflict names the identity and the user of a mapping conflict, which an administrator can resolve by linking them
+type Conflict struct {
+	Identity string
+	User     string
+}
+
+// JSONErrorHandler writes authentication and grant errors as JSON for clients that accept it
+type JSONErrorHandler struct {
+	page *ErrorPage
+}
+
+// ErrorResponse is the JSON variant of the error page
+type ErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+	Help             string `json:"help,omitempty"`
+	// ProviderSelectionURI lets users select another identity provider
+	ProviderSelectionURI string `json:"provider_selection_uri,omitempty"`
+	CorrelationID        string `json:"correlation_id,omitempty"`
+}
+
+func (h *JSONErrorHandler) AuthenticationError(err error, w http.ResponseWriter, req *http.Request) (bool, error) {
+	if !acceptsJSON(req) {
+		return false, err
+	}
+	writeJSON(h.page.authenticationErrorData(err, req), w)
+	return true, nil
+}
+
+func (h *JSONErrorHandler) GrantError(err error, w http.ResponseWriter, req *http.Request) (bool, error) {
+	if !acceptsJSON(req) {
+		return false, err
+	}
+	writeJSON(h.page.grantErrorData(err, req), w)
+	return true, nil
+}
+
+// acceptsJSON returns true if the request explicitly accepts JSON
+func acceptsJSON(req *http.Request) bool {
+	for _, accept := range goautoneg.ParseAccept(req.Header.Get("Accept")) {
+		if accept.Type == "application" && accept.SubType == "json" {
+			return true
+		}
+	}
+	return false
+}
+
+func writeJSON(data ErrorData, w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(errorCodeStatus(data.ErrorCode))
+	response := ErrorResponse{
+		Error:                data.ErrorCode,
+		ErrorDescription:     data.Error,
+		Help:                 data.Help,
+		ProviderSelectionURI: data.ProviderSelectionURL,
+		CorrelationID:        data.CorrelationID,
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		utilruntime.HandleError(fmt.Errorf("unable to write error response: %v", err))
+	}
 }
 
 // ErrorPageRenderer handles rendering a given error code/message
@@ -91,7 +239,7 @@ func NewErrorPageTemplateRenderer(templateFile string) (ErrorPageRenderer, error
 func (r *errorPageTemplateRenderer) Render(data ErrorData, w http.ResponseWriter, req *http.Request) {
 	w.Header().Add("Content-Type", "text/html; charset=UTF-8")
 	w.WriteHeader(http.StatusOK)
-	data.Locale = locales.GetLocale(req.Header.Get("Accept-Language"))
+	data.Locale = locales.ForRequest(req)
 	if err := r.errorPageTemplate.Execute(w, data); err != nil {
 		utilruntime.HandleError(fmt.Errorf("unable to render error page template: %v", err))
 	}
@@ -1,9 +1,20 @@
 package errorpage
 
 import (
+	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+
+	userapi "github.com/openshift/api/user/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/openshift/oauth-server/pkg/api"
+	"github.com/openshift/oauth-server/pkg/server/cookies"
+	"github.com/openshift/oauth-server/pkg/server/correlation"
+	"github.com/openshift/oauth-server/pkg/userregistry/identitymapper"
 )
 
 func TestErrorPage(t *testing.T) {
@@ -65,6 +76,285 @@ func TestErrorPage(t *testing.T) {
 	}
 }
 
+func TestAuthenticationErrorDenied(t *testing.T) {
+	renderer, err := NewErrorPageTemplateRenderer("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := NewErrorPageHandler(renderer)
+	req := &http.Request{Header: http.Header{"Accept": []string{"text/html"}}}
+
+	for message, expected := range map[string]string{
+		"":                          "Access denied.",
+		"Your account is suspended": "Your account is suspended",
+	} {
+		err := api.NewAuthorizationDeniedErrorWithMessage(nil, errors.New("denied"), message)
+		if code := AuthenticationErrorCode(err); code != errorCodeAccessDenied {
+			t.Errorf("expected code %q, got %q", errorCodeAccessDenied, code)
+		}
+
+		resp := httptest.NewRecorder()
+		if handled, err := handler.AuthenticationError(err, resp, req); !handled || err != nil {
+			t.Fatalf("expected error to be handled, got %v %v", handled, err)
+		}
+		if !strings.Contains(resp.Body.String(), expected) {
+			t.Errorf("expected page to contain %q, got %s", expected, resp.Body.String())
+		}
+	}
+}
+
+func TestErrorPageCorrelationID(t *testing.T) {
+	renderer, err := NewErrorPageTemplateRenderer("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	errorPage := NewErrorPageHandler(renderer)
+	handler := correlation.WithCorrelationID(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if handled, err := errorPage.AuthenticationError(errors.New("failed"), w, req); !handled || err != nil {
+			t.Fatalf("expected error to be handled, got %v %v", handled, err)
+		}
+	}), cookies.Options{}, func(*http.Request) bool { return false })
+
+	req := httptest.NewRequest(http.MethodGet, "/oauth2callback/idp", nil)
+	req.Header.Set("Accept", "text/html")
+	req.Header.Set(correlation.Header, "attempt-1234")
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	if expected := "Reference: attempt-1234"; !strings.Contains(resp.Body.String(), expected) {
+		t.Errorf("expected page to contain %q, got %s", expected, resp.Body.String())
+	}
+}
+
+func TestErrorPageHelp(t *testing.T) {
+	renderer, err := NewErrorPageTemplateRenderer("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewErrorPageHandlerWithHelp(renderer, map[string]Help{"unknown": {Text: "help"}}, false); err == nil {
+		t.Errorf("expected error for unknown error code")
+	}
+	help := map[string]Help{
+		errorCodeAccessDenied: {Text: "Ask the admins for access.", LocalizedText: map[string]string{"ja": "管理者にアクセスを依頼してください。"}},
+	}
+
+	testCases := map[string]struct {
+		Err                    error
+		AcceptLanguage         string
+		ProviderSelectionURL   string
+		OfferProviderSelection bool
+		Expected               []string
+		Unexpected             []string
+	}{
+		"help of the code": {
+			Err:        api.NewAuthorizationDeniedError(nil, errors.New("denied")),
+			Expected:   []string{"Error code: access_denied", "Ask the admins for access."},
+			Unexpected: []string{"/oauth/authorize"},
+		},
+		"localized help": {
+			Err:            api.NewAuthorizationDeniedError(nil, errors.New("denied")),
+			AcceptLanguage: "ja",
+			Expected:       []string{"管理者にアクセスを依頼してください。"},
+		},
+		"no help for other codes": {
+			Err:        api.NewInvalidStateError(errors.New("csrf mismatch")),
+			Expected:   []string{"Error code: invalid_state", "The login could not be verified."},
+			Unexpected: []string{"Ask the admins for access."},
+		},
+		"provider selection": {
+			Err:                    api.NewIdentityProviderError(errors.New("unreachable")),
+			ProviderSelectionURL:   "/oauth/authorize?client_id=console&response_type=code",
+			OfferProviderSelection: true,
+			Expected:               []string{"Error code: identity_provider_error", `href="/oauth/authorize?client_id=console&amp;response_type=code"`, "Try another identity provider"},
+		},
+		"provider selection not offered": {
+			Err:                  api.NewIdentityProviderError(errors.New("unreachable")),
+			ProviderSelectionURL: "/oauth/authorize?client_id=console",
+			Unexpected:           []string{"/oauth/authorize", "Try another identity provider"},
+		},
+	}
+
+	for k, testCase := range testCases {
+		handler, err := NewErrorPageHandlerWithHelp(renderer, help, testCase.OfferProviderSelection)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", k, err)
+		}
+		req := httptest.NewRequest(http.MethodGet, "/oauth2callback/idp", nil)
+		req.Header.Set("Accept", "text/html")
+		req.Header.Set("Accept-Language", testCase.AcceptLanguage)
+		if len(testCase.ProviderSelectionURL) > 0 {
+			req = WithProviderSelectionURL(req, testCase.ProviderSelectionURL)
+		}
+		resp := httptest.NewRecorder()
+		if handled, err := handler.AuthenticationError(testCase.Err, resp, req); !handled || err != nil {
+			t.Fatalf("%s: expected error to be handled, got %v %v", k, handled, err)
+		}
+		for _, expected := range testCase.Expected {
+			if !strings.Contains(resp.Body.String(), expected) {
+				t.Errorf("%s: expected page to contain %q, got %s", k, expected, resp.Body.String())
+			}
+		}
+		for _, unexpected := range testCase.Unexpected {
+			if strings.Contains(resp.Body.String(), unexpected) {
+				t.Errorf("%s: expected page not to contain %q, got %s", k, unexpected, resp.Body.String())
+			}
+		}
+	}
+}
+
+func TestErrorPageConflict(t *testing.T) {
+	renderer, err := NewErrorPageTemplateRenderer("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := NewErrorPageHandler(renderer)
+	user := &userapi.User{ObjectMeta: metav1.ObjectMeta{Name: "bob"}, Identities: []string{"otheridp:bob"}}
+	identity := &userapi.Identity{ObjectMeta: metav1.ObjectMeta{Name: "idp:bob"}}
+
+	req := httptest.NewRequest(http.MethodGet, "/oauth2callback/idp", nil)
+	req.Header.Set("Accept", "text/html")
+	resp := httptest.NewRecorder()
+	if handled, err := handler.AuthenticationError(identitymapper.NewClaimError(user, identity), resp, req); !handled || err != nil {
+		t.Fatalf("expected error to be handled, got %v %v", handled, err)
+	}
+	for _, expected := range []string{"Error code: mapping_claim_error", "Identity: idp:bob", "Username: bob", "An administrator can link the identity to the user."} {
+		if !strings.Contains(resp.Body.String(), expected) {
+			t.Errorf("expected page to contain %q, got %s", expected, resp.Body.String())
+		}
+	}
+	// the other identities of the user are not revealed
+	if strings.Contains(resp.Body.String(), "otheridp") {
+		t.Errorf("expected page not to contain the other identities of the user, got %s", resp.Body.String())
+	}
+}
+
+func TestJSONErrorHandler(t *testing.T) {
+	renderer, err := NewErrorPageTemplateRenderer("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	errorPage, err := NewErrorPageHandlerWithHelp(renderer, map[string]Help{errorCodeIdentityProvider: {Text: "Check the status page."}}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := errorPage.JSON()
+
+	req := httptest.NewRequest(http.MethodGet, "/oauth2callback/idp", nil)
+	if handled, _ := handler.AuthenticationError(errors.New("failed"), httptest.NewRecorder(), req); handled {
+		t.Errorf("expected error of a client that does not accept JSON not to be handled")
+	}
+
+	testCases := map[string]struct {
+		Err            error
+		ExpectedStatus int
+		Expected       ErrorResponse
+	}{
+		"identity provider error": {
+			Err:            api.NewIdentityProviderError(errors.New("unreachable")),
+			ExpectedStatus: http.StatusBadGateway,
+			Expected: ErrorResponse{
+				Error:                "identity_provider_error",
+				ErrorDescription:     "The identity provider could not log you in.",
+				Help:                 "Check the status page.",
+				ProviderSelectionURI: "/oauth/authorize?client_id=cli",
+			},
+		},
+		"csrf mismatch": {
+			Err:            api.NewInvalidStateError(errors.New("csrf mismatch")),
+			ExpectedStatus: http.StatusBadRequest,
+			Expected: ErrorResponse{
+				Error:                "invalid_state",
+				ErrorDescription:     "The login could not be verified. Please try again.",
+				ProviderSelectionURI: "/oauth/authorize?client_id=cli",
+			},
+		},
+		"mapping conflict": {
+			Err:            identitymapper.NewClaimError(&userapi.User{}, &userapi.Identity{}),
+			ExpectedStatus: http.StatusConflict,
+			Expected: ErrorResponse{
+				Error:                "mapping_claim_error",
+				ErrorDescription:     "Could not create user.",
+				ProviderSelectionURI: "/oauth/authorize?client_id=cli",
+			},
+		},
+		"denied": {
+			Err:            api.NewAuthorizationDeniedError(nil, errors.New("denied")),
+			ExpectedStatus: http.StatusForbidden,
+			Expected: ErrorResponse{
+				Error:                "access_denied",
+				ErrorDescription:     "Access denied.",
+				ProviderSelectionURI: "/oauth/authorize?client_id=cli",
+			},
+		},
+	}
+
+	for k, testCase := range testCases {
+		req := httptest.NewRequest(http.MethodGet, "/oauth2callback/idp", nil)
+		req.Header.Set("Accept", "application/json")
+		req = WithProviderSelectionURL(req, "/oauth/authorize?client_id=cli")
+		resp := httptest.NewRecorder()
+		if handled, err := handler.AuthenticationError(testCase.Err, resp, req); !handled || err != nil {
+			t.Fatalf("%s: expected error to be handled, got %v %v", k, handled, err)
+		}
+		if resp.Code != testCase.ExpectedStatus {
+			t.Errorf("%s: expected status %d, got %d", k, testCase.ExpectedStatus, resp.Code)
+		}
+		if contentType := resp.Header().Get("Content-Type"); contentType != "application/json" {
+			t.Errorf("%s: unexpected content type %q", k, contentType)
+		}
+		var response ErrorResponse
+		if err := json.Unmarshal(resp.Body.Bytes(), &response); err != nil {
+			t.Fatalf("%s: unexpected error: %v", k, err)
+		}
+		if response != testCase.Expected {
+			t.Errorf("%s: expected %#v, got %#v", k, testCase.Expected, response)
+		}
+	}
+}
+
+func TestErrorPageLocalized(t *testing.T) {
+	renderer, err := NewErrorPageTemplateRenderer("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := NewErrorPageHandler(renderer)
+
+	testCases := map[string]struct {
+		URL            string
+		AcceptLanguage string
+		Expected       []string
+	}{
+		"english by default": {
+			URL:      "https://example.com/oauth/authorize",
+			Expected: []string{`lang="en"`, "Could not find user."},
+		},
+		"negotiated language": {
+			URL:            "https://example.com/oauth/authorize",
+			AcceptLanguage: "ja;q=0.9, en;q=0.8",
+			Expected:       []string{`lang="ja"`, "ユーザーが見つかりませんでした。"},
+		},
+		"language parameter takes precedence": {
+			URL:            "https://example.com/oauth/authorize?lang=ko",
+			AcceptLanguage: "ja;q=0.9, en;q=0.8",
+			Expected:       []string{`lang="ko"`, "사용자를 찾을 수 없습니다."},
+		},
+	}
+
+	for k, testCase := range testCases {
+		req := httptest.NewRequest("GET", testCase.URL, nil)
+		req.Header.Set("Accept", "text/html")
+		req.Header.Set("Accept-Language", testCase.AcceptLanguage)
+		resp := httptest.NewRecorder()
+		if handled, err := handler.AuthenticationError(identitymapper.NewLookupError(api.NewDefaultUserIdentityInfo("idp", "bob"), errors.New("not found")), resp, req); !handled || err != nil {
+			t.Fatalf("%s: expected error to be handled, got %v %v", k, handled, err)
+		}
+		for _, expected := range testCase.Expected {
+			if !strings.Contains(resp.Body.String(), expected) {
+				t.Errorf("%s: expected page to contain %q, got %s", k, expected, resp.Body.String())
+			}
+		}
+	}
+}
+
 func TestValidateErrorPageTemplate(t *testing.T) {
 	testCases := map[string]struct {
 		Template      string
@@ -7,7 +7,7 @@ import (
 var defaultErrorPageTemplate = template.Must(template.New("defaultErrorPageTemplate").Parse(defaultErrorPageTemplateString))
 
 const defaultErrorPageTemplateString = `<!DOCTYPE html>
-<html lang="en-us" data-test-id="login">
+<html lang="{{ or .Locale.Lang "en" }}" data-test-id="login">
   <head>
     <title>{{ .Locale.Error }} . OKD</title>
     <meta http-equiv="X-UA-Compatible" content="IE=edge">
@@ -136,6 +136,23 @@ body { background-color: var(--pf-global--BackgroundColor--dark-100); }
               </svg>
               {{ .Error }}
             </p>
+            {{ if .Conflict }}
+            <p class="pf-c-form__helper-text">{{ .Locale.Identity }}: {{ .Conflict.Identity }}</p>
+            <p class="pf-c-form__helper-text">{{ .Locale.Username }}: {{ .Conflict.User }}</p>
+            <p class="pf-c-form__helper-text">{{ .Locale.AnAdministratorCanLinkThem }}</p>
+            {{ end }}
+            {{ if .Help }}
+            <p class="pf-c-form__helper-text">{{ .Help }}</p>
+            {{ end }}
+            {{ if .ProviderSelectionURL }}
+            <p class="pf-c-form__helper-text"><a href="{{ .ProviderSelectionURL }}">{{ .Locale.TryAnotherProvider }}</a></p>
+            {{ end }}
+            {{ if .ErrorCode }}
+            <p class="pf-c-form__helper-text">{{ .Locale.ErrorCode }}: {{ .ErrorCode }}</p>
+            {{ end }}
+            {{ if .CorrelationID }}
+            <p class="pf-c-form__helper-text">{{ .Locale.Reference }}: {{ .CorrelationID }}</p>
+            {{ end }}
           </div>
         </main>
       </div>
@@ -0,0 +1,100 @@
+// Package filewatch calls a function whenever one of a set of files changes, e.g. to reload mounted secrets and
+// configuration while the server runs.
+package filewatch
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/klog/v2"
+)
+
+// Run calls reload whenever one of the files returned by files changes, until stopCh is closed. Changes are detected
+// using filesystem notifications and, as a fallback, by checking the files every pollInterval. The files are checked
+// again with every call, so reload may change the set of files. The files are considered changed on the first check,
+// they may have changed since they were loaded. If reload fails, it is called again on the next check.
+func Run(files func() []string, reload func() error, pollInterval time.Duration, stopCh <-chan struct{}) {
+	var events <-chan fsnotify.Event
+	var errs <-chan error
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		klog.Warningf("Unable to watch files, falling back to polling: %v", err)
+	} else {
+		defer watcher.Close()
+		events, errs = watcher.Events, watcher.Errors
+	}
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	watched := map[string]bool{}
+	var fileInfos map[string]os.FileInfo
+	for {
+		current := files()
+		if watcher != nil {
+			// watch the directories, mounted secrets and config maps are replaced instead of written to
+			for _, file := range current {
+				dir := filepath.Dir(file)
+				if watched[dir] {
+					continue
+				}
+				if err := watcher.Add(dir); err != nil {
+					klog.Warningf("Unable to watch file %s, falling back to polling: %v", file, err)
+				}
+				watched[dir] = true
+			}
+		}
+
+		if infos := statFiles(current); fileInfos == nil || filesChanged(fileInfos, infos) {
+			if err := reload(); err != nil {
+				klog.Warningf("Error reloading %v: %v", current, err)
+			} else {
+				fileInfos = infos
+			}
+		}
+
+		select {
+		case <-stopCh:
+			return
+		case event := <-events:
+			klog.V(5).Infof("file watch event %v", event)
+		case err := <-errs:
+			utilruntime.HandleError(err)
+		case <-ticker.C:
+		}
+	}
+}
+
+// statFiles returns the info of the files, nil for files that cannot be read
+func statFiles(files []string) map[string]os.FileInfo {
+	infos := make(map[string]os.FileInfo, len(files))
+	for _, file := range files {
+		// follows symlinks, mounted secrets are symlinks to the current version
+		info, err := os.Stat(file)
+		if err != nil {
+			info = nil
+		}
+		infos[file] = info
+	}
+	return infos
+}
+
+func filesChanged(old, current map[string]os.FileInfo) bool {
+	if len(old) != len(current) {
+		return true
+	}
+	for file, info := range current {
+		oldInfo, ok := old[file]
+		if !ok || (oldInfo == nil) != (info == nil) {
+			return true
+		}
+		if info != nil && (oldInfo.ModTime() != info.ModTime() || oldInfo.Size() != info.Size()) {
+			return true
+		}
+	}
+	return false
+}
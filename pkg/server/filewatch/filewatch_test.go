@@ -0,0 +1,103 @@
+package filewatch
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	config, secret := filepath.Join(dir, "config"), filepath.Join(dir, "secret")
+	// files are replaced like mounted secrets are, so reloads never see partially written files
+	write := func(file, content string) {
+		t.Helper()
+		if err := os.WriteFile(file+".tmp", []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(file+".tmp", file); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(config, "initial")
+	write(secret, "secret")
+
+	lock := sync.Mutex{}
+	files := []string{config}
+	loaded := ""
+	reloads := 0
+	getFiles := func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]string{}, files...)
+	}
+	reload := func() error {
+		lock.Lock()
+		defer lock.Unlock()
+		reloads++
+		contents := []string{}
+		for _, file := range files {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			if string(data) == "invalid" {
+				return errors.New("invalid content")
+			}
+			contents = append(contents, string(data))
+		}
+		loaded = strings.Join(contents, ",")
+		return nil
+	}
+	waitFor := func(expected string) {
+		t.Helper()
+		for i := 0; i < 100; i++ {
+			lock.Lock()
+			current := loaded
+			lock.Unlock()
+			if current == expected {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("expected %q to be loaded, got %q", expected, loaded)
+	}
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go Run(getFiles, reload, 10*time.Millisecond, stopCh)
+	waitFor("initial")
+
+	// the size changes, so the change is detected regardless of the resolution of modification times
+	write(config, "changed")
+	waitFor("changed")
+
+	// files added by reloads are watched
+	lock.Lock()
+	files = append(files, secret)
+	lock.Unlock()
+	waitFor("changed,secret")
+	write(secret, "rotated secret")
+	waitFor("changed,rotated secret")
+
+	// failed reloads are retried until they succeed
+	write(config, "invalid")
+	time.Sleep(50 * time.Millisecond)
+	waitFor("changed,rotated secret")
+	write(config, "fixed")
+	waitFor("fixed,rotated secret")
+
+	lock.Lock()
+	previous := reloads
+	lock.Unlock()
+	time.Sleep(50 * time.Millisecond)
+	lock.Lock()
+	defer lock.Unlock()
+	if reloads != previous {
+		t.Errorf("expected no reloads without changes, got %d more reloads", reloads-previous)
+	}
+}
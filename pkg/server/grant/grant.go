@@ -1,11 +1,14 @@
 package grant
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"html/template"
 	"net/http"
 	"net/url"
 	"path"
+	"time"
 
 	"k8s.io/klog/v2"
 
@@ -20,8 +23,10 @@ import (
 	scopemetadata "github.com/openshift/library-go/pkg/authorization/scopemetadata"
 	oauthserver "github.com/openshift/oauth-server/pkg"
 	"github.com/openshift/oauth-server/pkg/api"
+	"github.com/openshift/oauth-server/pkg/oauth/registry"
 	"github.com/openshift/oauth-server/pkg/scopecovers"
 	"github.com/openshift/oauth-server/pkg/server/csrf"
+	"github.com/openshift/oauth-server/pkg/server/locales"
 	"github.com/openshift/oauth-server/pkg/server/redirect"
 )
 
@@ -54,6 +59,8 @@ type Form struct {
 
 	Names  GrantFormFields
 	Values GrantFormFields
+
+	Locale locales.Localization
 }
 
 type GrantFormFields struct {
@@ -86,15 +93,24 @@ type Grant struct {
 	render         FormRenderer
 	clientregistry api.OAuthClientGetter
 	authregistry   oauthclient.OAuthClientAuthorizationInterface
+	// consent determines which approved scopes are still approved, all are if it is nil
+	consent *registry.ConsentPolicy
 }
 
 func NewGrant(csrf csrf.CSRF, auth authenticator.Request, render FormRenderer, clientregistry api.OAuthClientGetter, authregistry oauthclient.OAuthClientAuthorizationInterface) *Grant {
+	return NewGrantWithConsent(csrf, auth, render, clientregistry, authregistry, nil)
+}
+
+// NewGrantWithConsent returns a Grant that records when scopes were approved and only shows the scopes that are still
+// approved according to the consent policy as granted
+func NewGrantWithConsent(csrf csrf.CSRF, auth authenticator.Request, render FormRenderer, clientregistry api.OAuthClientGetter, authregistry oauthclient.OAuthClientAuthorizationInterface, consent *registry.ConsentPolicy) *Grant {
 	return &Grant{
 		auth:           auth,
 		csrf:           csrf,
 		render:         render,
 		clientregistry: clientregistry,
 		authregistry:   authregistry,
+		consent:        consent,
 	}
 }
 
@@ -143,7 +159,7 @@ func (l *Grant) handleForm(user user.Info, w http.ResponseWriter, req *http.Requ
 
 	clientAuthID := user.GetName() + ":" + client.Name
 	if clientAuth, err := l.authregistry.Get(context.TODO(), clientAuthID, metav1.GetOptions{}); err == nil {
-		grantedScopeNames = clientAuth.Scopes
+		grantedScopeNames = l.consent.ApprovedScopes(clientAuth, time.Now())
 	}
 
 	for _, s := range scopes {
@@ -226,6 +242,14 @@ func (l *Grant) handleGrant(user user.Info, w http.ResponseWriter, req *http.Req
 		return
 	}
 
+	// users may approve any subset of the requested scopes, but no other scopes
+	if thenURL, err := url.Parse(then); err == nil {
+		if requested := thenURL.Query().Get(scopeParam); len(requested) > 0 && !scopecovers.Covers(scopecovers.Split(requested), scopecovers.Split(scopes)) {
+			l.failed(fmt.Sprintf("Approved scopes (%v) were not requested (%v)", scopes, requested), w, req)
+			return
+		}
+	}
+
 	clientID := req.PostFormValue(clientIDParam)
 	client, err := l.clientregistry.Get(context.TODO(), clientID, metav1.GetOptions{})
 	if err != nil || client == nil {
@@ -242,8 +266,8 @@ func (l *Grant) handleGrant(user user.Info, w http.ResponseWriter, req *http.Req
 
 	clientAuth, err := l.authregistry.Get(context.TODO(), clientAuthID, metav1.GetOptions{})
 	if err == nil && clientAuth != nil {
-		// Add new scopes and update
-		clientAuth.Scopes = scopecovers.Add(clientAuth.Scopes, scopecovers.Split(scopes))
+		// Add new scopes, renew the approvals of the approved scopes and update
+		l.consent.Approve(clientAuth, scopecovers.Split(scopes), time.Now())
 		if _, err = l.authregistry.Update(context.TODO(), clientAuth, metav1.UpdateOptions{}); err != nil {
 			klog.Errorf("Unable to update authorization: %v", err)
 			l.failed("Could not update client authorization", w, req)
@@ -255,9 +279,9 @@ func (l *Grant) handleGrant(user user.Info, w http.ResponseWriter, req *http.Req
 			UserName:   user.GetName(),
 			UserUID:    user.GetUID(),
 			ClientName: client.Name,
-			Scopes:     scopecovers.Split(scopes),
 		}
 		clientAuth.Name = clientAuthID
+		l.consent.Approve(clientAuth, scopecovers.Split(scopes), time.Now())
 
 		if _, err = l.authregistry.Create(context.TODO(), clientAuth, metav1.CreateOptions{}); err != nil {
 			klog.Errorf("Unable to create authorization: %v", err)
@@ -322,15 +346,98 @@ func getScopeData(scopeName string, grantedScopeNames []string) Scope {
 
 // DefaultFormRenderer displays a page prompting the user to approve an OAuth grant.
 // The requesting client id, requested scopes, and redirect URI are displayed to the user.
-var DefaultFormRenderer = grantTemplateRenderer{}
+var DefaultFormRenderer = grantTemplateRenderer{grantTemplate: defaultGrantTemplate}
 
-type grantTemplateRenderer struct{}
+type grantTemplateRenderer struct {
+	grantTemplate *template.Template
+}
+
+// NewGrantFormRenderer creates a grant form renderer that takes in an optional custom template to
+// allow branding of the page. Uses the default if templateFile is not set.
+func NewGrantFormRenderer(templateFile string) (FormRenderer, error) {
+	if len(templateFile) == 0 {
+		return DefaultFormRenderer, nil
+	}
+	customTemplate, err := template.ParseFiles(templateFile)
+	if err != nil {
+		return nil, err
+	}
+	return grantTemplateRenderer{grantTemplate: customTemplate}, nil
+}
 
 func (r grantTemplateRenderer) Render(form Form, w http.ResponseWriter, req *http.Request) {
 	w.Header().Add("Content-Type", "text/html; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
 
-	if err := defaultGrantTemplate.Execute(w, form); err != nil {
+	form.Locale = locales.ForRequest(req)
+	if err := r.grantTemplate.Execute(w, form); err != nil {
 		utilruntime.HandleError(fmt.Errorf("unable to render grant template: %v", err))
 	}
 }
+
+// ValidateGrantTemplate ensures the given template renders the fields that are required to approve a grant
+func ValidateGrantTemplate(templateContent []byte) []error {
+	var allErrs []error
+
+	template, err := template.New("grantTemplateTest").Parse(string(templateContent))
+	if err != nil {
+		return append(allErrs, err)
+	}
+
+	// Execute the template with dummy values and check if they're there.
+	scopesName, scopeValue := "MyScopesName", "MyScopeValue"
+	form := Form{
+		Action: "MyAction",
+		Names: GrantFormFields{
+			Then:        "MyThenName",
+			CSRF:        "MyCSRFName",
+			ClientID:    "MyClientIDName",
+			UserName:    "MyUserNameName",
+			Scopes:      scopesName,
+			RedirectURI: "MyRedirectURIName",
+			Approve:     "MyApproveName",
+			Deny:        "MyDenyName",
+		},
+		Values: GrantFormFields{
+			Then:        "MyThenValue",
+			CSRF:        "MyCSRFValue",
+			ClientID:    "MyClientIDValue",
+			UserName:    "MyUserNameValue",
+			Scopes:      []Scope{{Name: scopeValue}},
+			RedirectURI: "MyRedirectURIValue",
+		},
+	}
+
+	var buffer bytes.Buffer
+	err = template.Execute(&buffer, form)
+	if err != nil {
+		return append(allErrs, err)
+	}
+	output := buffer.Bytes()
+
+	var testFields = map[string]string{
+		"Action":             form.Action,
+		"Names.Then":         form.Names.Then,
+		"Names.CSRF":         form.Names.CSRF,
+		"Names.ClientID":     form.Names.ClientID,
+		"Names.UserName":     form.Names.UserName,
+		"Names.Scopes":       scopesName,
+		"Names.RedirectURI":  form.Names.RedirectURI,
+		"Names.Approve":      form.Names.Approve,
+		"Names.Deny":         form.Names.Deny,
+		"Values.Then":        form.Values.Then,
+		"Values.CSRF":        form.Values.CSRF,
+		"Values.ClientID":    form.Values.ClientID,
+		"Values.UserName":    form.Values.UserName,
+		"Values.Scopes.Name": scopeValue,
+		"Values.RedirectURI": form.Values.RedirectURI,
+	}
+
+	for field, value := range testFields {
+		if !bytes.Contains(output, []byte(value)) {
+			allErrs = append(allErrs, fmt.Errorf("template is missing parameter {{ .%s }}", field))
+		}
+	}
+
+	return allErrs
+}
@@ -20,6 +20,7 @@ import (
 	oapi "github.com/openshift/api/oauth/v1"
 	oauthfake "github.com/openshift/client-go/oauth/clientset/versioned/fake"
 	"github.com/openshift/oauth-server/pkg/api"
+	"github.com/openshift/oauth-server/pkg/oauth/registry"
 	"github.com/openshift/oauth-server/pkg/server/csrf"
 )
 
@@ -76,12 +77,24 @@ func existingAuthRegistry(scopes []string) *oauthfake.Clientset {
 	return oauthfake.NewSimpleClientset(auth)
 }
 
+// versionedAuthRegistry returns an authorization of myclient that the user approved with the consent version
+func versionedAuthRegistry(version string, scopes []string) *oauthfake.Clientset {
+	auth := &oapi.OAuthClientAuthorization{
+		ObjectMeta: metav1.ObjectMeta{Name: "username:myclient", Annotations: map[string]string{registry.ConsentVersionAnnotation: version}},
+		UserName:   "username",
+		ClientName: "myclient",
+		Scopes:     scopes,
+	}
+	return oauthfake.NewSimpleClientset(auth)
+}
+
 func TestGrant(t *testing.T) {
 	testCases := map[string]struct {
 		CSRF           csrf.CSRF
 		Auth           *testAuth
 		ClientRegistry api.OAuthClientGetter
 		AuthRegistry   *oauthfake.Clientset
+		Consent        *registry.ConsentPolicy
 
 		Path       string
 		PostValues url.Values
@@ -112,6 +125,24 @@ func TestGrant(t *testing.T) {
 			},
 		},
 
+		"display localized form": {
+			CSRF:           &csrf.FakeCSRF{Token: "test"},
+			Auth:           goodAuth("username"),
+			ClientRegistry: goodClientRegistry("myclient", []string{"myredirect"}, []string{"user:info"}),
+			AuthRegistry:   emptyAuthRegistry(),
+			Path:           "/grant?client_id=myclient&scope=user%3Ainfo&redirect_uri=/myredirect&then=/authorize&lang=ja",
+
+			ExpectStatusCode: 200,
+			ExpectContains: []string{
+				`lang="ja"`,
+				`アクセスの承認`,
+				`checked name="scope" value="user:info"`,
+				`<span class="scope-description">Read-only access to your user information`,
+				`<span class="scope-name">user:info</span>`,
+				`value="選択した権限を許可"`,
+			},
+		},
+
 		"display form with existing scopes": {
 			CSRF:           &csrf.FakeCSRF{Token: "test"},
 			Auth:           goodAuth("username"),
@@ -133,6 +164,21 @@ func TestGrant(t *testing.T) {
 			},
 		},
 
+		"display form with existing scopes of another consent version": {
+			CSRF:           &csrf.FakeCSRF{Token: "test"},
+			Auth:           goodAuth("username"),
+			ClientRegistry: goodClientRegistry("myclient", []string{"myredirect"}, []string{"newscope1", "existingscope1"}),
+			AuthRegistry:   versionedAuthRegistry("v1", []string{"existingscope1"}),
+			Consent:        &registry.ConsentPolicy{Versions: map[string]string{"myclient": "v2"}},
+			Path:           "/grant?client_id=myclient&scope=newscope1%20existingscope1&redirect_uri=/myredirect&then=/authorize",
+
+			ExpectStatusCode: 200,
+			ExpectContains: []string{
+				`checked name="scope" value="newscope1"`,
+				`checked name="scope" value="existingscope1"`,
+			},
+		},
+
 		"Unauthenticated with redirect": {
 			CSRF:           &csrf.FakeCSRF{Token: "test"},
 			Auth:           badAuth(nil),
@@ -331,6 +377,28 @@ func TestGrant(t *testing.T) {
 			ExpectRedirect:          "/authorize?scope=newscope1+existingscope1",
 		},
 
+		"successful update grant of another consent version": {
+			CSRF:           &csrf.FakeCSRF{Token: "test"},
+			Auth:           goodAuth("username"),
+			ClientRegistry: goodClientRegistry("myclient", []string{"myredirect"}, []string{"newscope1", "existingscope1", "existingscope2"}),
+			AuthRegistry:   versionedAuthRegistry("v1", []string{"existingscope2", "existingscope1"}),
+			Consent:        &registry.ConsentPolicy{Versions: map[string]string{"myclient": "v2"}},
+			Path:           "/grant",
+			PostValues: url.Values{
+				"approve":      {"true"},
+				"client_id":    {"myclient"},
+				"scope":        {"newscope1", "existingscope1"},
+				"redirect_uri": {"/myredirect"},
+				"then":         {"/authorize"},
+				"csrf":         {"test"},
+				"user_name":    {"username"},
+			},
+
+			ExpectStatusCode:        302,
+			ExpectUpdatedAuthScopes: []string{"existingscope1", "newscope1"},
+			ExpectRedirect:          "/authorize?scope=newscope1+existingscope1",
+		},
+
 		"successful update grant with additional scopes": {
 			CSRF:           &csrf.FakeCSRF{Token: "test"},
 			Auth:           goodAuth("username"),
@@ -352,6 +420,26 @@ func TestGrant(t *testing.T) {
 			ExpectRedirect:          "/authorize?scope=newscope1+existingscope1",
 		},
 
+		"error when approving scopes that were not requested": {
+			CSRF:           &csrf.FakeCSRF{Token: "test"},
+			Auth:           goodAuth("username"),
+			ClientRegistry: goodClientRegistry("myclient", []string{"myredirect"}, []string{"myscope1", "myscope2"}),
+			AuthRegistry:   emptyAuthRegistry(),
+			Path:           "/grant",
+			PostValues: url.Values{
+				"approve":      {"true"},
+				"client_id":    {"myclient"},
+				"scope":        {"myscope1", "myscope2"},
+				"redirect_uri": {"/myredirect"},
+				"then":         {"/authorize?scope=myscope1"},
+				"csrf":         {"test"},
+				"user_name":    {"username"},
+			},
+
+			ExpectStatusCode: 200,
+			ExpectContains:   []string{"were not requested"},
+		},
+
 		"successful reject grant via deny button": {
 			CSRF:           &csrf.FakeCSRF{Token: "test"},
 			Auth:           goodAuth("username"),
@@ -394,7 +482,7 @@ func TestGrant(t *testing.T) {
 	}
 
 	for k, testCase := range testCases {
-		server := httptest.NewServer(NewGrant(testCase.CSRF, testCase.Auth, DefaultFormRenderer, testCase.ClientRegistry, testCase.AuthRegistry.OauthV1().OAuthClientAuthorizations()))
+		server := httptest.NewServer(NewGrantWithConsent(testCase.CSRF, testCase.Auth, DefaultFormRenderer, testCase.ClientRegistry, testCase.AuthRegistry.OauthV1().OAuthClientAuthorizations(), testCase.Consent))
 
 		var resp *http.Response
 		if testCase.PostValues != nil {
@@ -497,3 +585,34 @@ func getURL(url string) (resp *http.Response, err error) {
 	}
 	return tr.RoundTrip(req)
 }
+
+func TestValidateGrantTemplate(t *testing.T) {
+	testCases := map[string]struct {
+		Template      string
+		TemplateValid bool
+	}{
+		"default grant template": {
+			Template:      defaultGrantTemplateString,
+			TemplateValid: true,
+		},
+		"template without scopes": {
+			Template:      `<form action="{{ .Action }}"><input name="{{ .Names.CSRF }}" value="{{ .Values.CSRF }}"></form>`,
+			TemplateValid: false,
+		},
+		"unparseable template": {
+			Template:      `{{ .Action `,
+			TemplateValid: false,
+		},
+	}
+
+	for k, testCase := range testCases {
+		allErrs := ValidateGrantTemplate([]byte(testCase.Template))
+		if testCase.TemplateValid {
+			for _, err := range allErrs {
+				t.Errorf("%s: template validation failed when it should have succeeded: %v", k, err)
+			}
+		} else if len(allErrs) == 0 {
+			t.Errorf("%s: template validation succeeded when it should have failed", k)
+		}
+	}
+}
@@ -6,66 +6,73 @@ var defaultGrantTemplate = template.Must(template.New("defaultGrantForm").Parse(
 
 const defaultGrantTemplateString = `<!DOCTYPE html>
 
-<html>
+<html lang="{{ or .Locale.Lang "en" }}">
 
 <head>
+    <meta charset="UTF-8">
     <meta http-equiv="X-UA-Compatible" content="IE=edge">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
     <title>
-      Authorize 
+      {{ .Locale.AuthorizeAccess }}
       {{ if and .ServiceAccountName .ServiceAccountNamespace }}
-        service account {{ .ServiceAccountName }} in project {{ .ServiceAccountNamespace }}
+        {{ .ServiceAccountNamespace }}/{{ .ServiceAccountName }}
       {{ else }}
         {{ .Values.ClientID }}
       {{ end }}
     </title>
     <style>
-        body    { font-family: sans-serif; line-height: 1.2em; margin: 2em 5%; color: #363636; }
+        body    { font-family: sans-serif; line-height: 1.4em; margin: 2em 5%; color: #363636; }
+        main    { max-width: 640px; }
 
-        table         { border-collapse: collapse; margin: 2em 0; width: 100%; max-width: 600px; }
-        table caption { text-align: left; }
-        td            { padding: .5em .25em; vertical-align: top; }
-        tr + tr       { border-top: 1px solid #d1d1d1; }
+        h1 { font-weight: normal; line-height: 1.3em; margin-bottom: .5em; }
+        h2 { font-size: 1em; font-weight: bold; margin: 2em 0 .5em; }
 
-        h1,h2,h3 { font-weight: normal; line-height: 1.3em; }
+        dl    { display: grid; grid-template-columns: max-content auto; gap: .25em 1em; margin: 0; }
+        dt    { color: #8b8d8f; }
+        dd    { margin: 0; font-weight: bold; word-break: break-all; }
 
-        input[type=submit]                      { font-size: 1em;   }
-        input[type=submit] + input[type=submit] { margin-left: 1em; }
+        .scopes       { list-style: none; margin: 0; padding: 0; border: 1px solid #d1d1d1; }
+        .scopes li    { display: flex; gap: .75em; padding: .75em; }
+        .scopes li + li { border-top: 1px solid #d1d1d1; }
+        .scopes input { margin-top: .3em; }
+        .scopes label { flex: 1; cursor: pointer; }
+
+        .scope-description { display: block; }
+        .scope-name        { display: block; font-family: monospace; font-size: .85em; color: #8b8d8f; }
+        .scope-warning     { display: block; font-size: .85em; color: #ec7a08; }
+        .scope-error       { display: block; font-size: .85em; color: #cc0000; }
+        .existing-permission { color: #3f9c35; }
 
-        .identifier-highlight { color: #8b8d8f; }
+        .hint          { font-size: .85em; color: #8b8d8f; margin: .5em 0 0; }
+        .redirect-info { font-size: .85em; color: #8b8d8f; margin: 2em 0; word-break: break-all; }
 
-        .scope-title         { font-size: .9em; font-weight: bold; padding-top: .1em; padding-bottom: .25em; }
-        .scope-details       { font-size: .8em; }
-        .redirect-info       { font-size: .8em; margin: 2em 0 2em .25em; }
-        .existing-permission { color: #3f9c35; text-align: center; }
+        input[type=submit]                      { font-size: 1em; padding: .5em 1em; }
+        input[type=submit] + input[type=submit] { margin-left: 1em; }
 
-        .muted   { color: #9c9c9c; }
-        .warning { color: #ec7a08; }
-        .error   { color: #cc0000; }
+        .error { color: #cc0000; }
 
         @media (max-width:481px) {
           body { margin: .5em; }
-          h1,h2,h3 { margin: 0; padding-bottom: .5em; }
-          table { margin: .5em 0 }
+          h1 { margin: 0; padding-bottom: .5em; font-size: 1.5em; }
+          dl { grid-template-columns: auto; }
           input[type=submit] { display: block; width: 100%; margin: 1em 0 !important; }
-          h1 { font-size: 1.5em; }
-          h2 { font-size: 1.3em; }
-          h3 { font-size: 1.1em; }
         }
     </style>
 </head>
 
 <!-- Define a subtemplate to use for rendering existing or requested scopes -->
 {{ define "scope" }}
-          <div class="scope-title">{{ .Name }}</div>
-          {{ if .Description }}<div class="scope-details muted"  >{{ .Description }}</div>
+          {{ if .Description }}<span class="scope-description">{{ .Description }}</span>
 {{ end -}}
-          {{ if .Warning     }}<div class="scope-details warning">{{ .Warning }}</div>
+          <span class="scope-name">{{ .Name }}</span>
+          {{ if .Warning     }}<span class="scope-warning">{{ .Warning }}</span>
 {{ end -}}
-          {{ if .Error       }}<div class="scope-details error"  >{{ .Error }}</div>
+          {{ if .Error       }}<span class="scope-error">{{ .Error }}</span>
 {{ end -}}
 {{ end }}
 
 <body>
+<main>
 {{ if .Error }}
 <div class="error">{{ .Error }}</div>
 {{ else }}
@@ -76,46 +83,33 @@ const defaultGrantTemplateString = `<!DOCTYPE html>
   <input type="hidden" name="{{ .Names.UserName    }}" value="{{ .Values.UserName    }}">
   <input type="hidden" name="{{ .Names.RedirectURI }}" value="{{ .Values.RedirectURI }}">
 
-  <h1>Authorize Access</h1>
+  <h1>{{ .Locale.AuthorizeAccess }}</h1>
 
-  <h3>
+  <dl>
     {{ if and .ServiceAccountName .ServiceAccountNamespace }}
-      Service account <span class="identifier-highlight">{{ .ServiceAccountName }}</span> in project <span class="identifier-highlight">{{ .ServiceAccountNamespace }}</span>
+    <dt>{{ .Locale.ServiceAccount }}</dt>
+    <dd>{{ .ServiceAccountNamespace }}/{{ .ServiceAccountName }}</dd>
     {{ else }}
-      <span class="identifier-highlight">{{ .Values.ClientID }}</span>
+    <dt>{{ .Locale.Application }}</dt>
+    <dd>{{ .Values.ClientID }}</dd>
     {{ end }}
-
-    is requesting
-    
-    {{ if .GrantedScopes }}
-      additional permissions
-    {{ else }}
-      permission
-    {{ end }}
-    
-    to access your account (<span class="identifier-highlight">{{ .Values.UserName }}</span>)
-  </h3>
+    <dt>{{ .Locale.Account }}</dt>
+    <dd>{{ .Values.UserName }}</dd>
+  </dl>
 
   <!-- Display scopes that have already been granted -->
   {{ if .GrantedScopes -}}
-  <table>
-    <caption>Existing access</caption>
-    <colgroup><col><col width="100%"></colgroup>
+  <h2>{{ .Locale.ExistingPermissions }}</h2>
+  <ul class="scopes">
     {{ range $i,$scope := .GrantedScopes -}}
-    <tr>
-      <td>
-        <div class="scope-title existing-permission">&#10003;</div>
-        <!-- Add an invisible checkbox to make spacing match the "requested permissions" table -->
-        <input type="checkbox" checked disabled style="visibility:hidden">
-      </td>
-      <td>
-        <div>
+    <li>
+      <span class="existing-permission">&#10003;</span>
+      <div>
 {{ template "scope" . }}
-        </div>
-      </td>
-    </tr>
+      </div>
+    </li>
     {{ end }}
-  </table>
+  </ul>
   {{ end }}
 
   <!-- Write hidden inputs for requested scopes that have already been granted -->
@@ -125,45 +119,40 @@ const defaultGrantTemplateString = `<!DOCTYPE html>
     {{- end }}
   {{ end }}
 
-  <!-- Display requested scopes that have not been granted -->
-  <table>
-    <caption>
-      {{- if .GrantedScopes -}}
-        Additional requested permissions
-      {{- else -}}
-        Requested permissions
-      {{- end -}}
-    </caption>
-    <colgroup><col><col width="100%"></colgroup>
+  <!-- Display requested scopes that have not been granted, users may approve any subset of them -->
+  <h2>
+    {{- if .GrantedScopes -}}
+      {{ .Locale.AdditionalRequestedPermissions }}
+    {{- else -}}
+      {{ .Locale.RequestedPermissions }}
+    {{- end -}}
+  </h2>
+  <ul class="scopes">
   {{ range $i,$scope := .Values.Scopes }}
     {{ if not .Granted }}
-    <tr>
-      <td>
-        <input type="checkbox" checked name="{{ $.Names.Scopes }}" value="{{ .Name }}" id="scope-{{$i}}">
-      </td>
-      <td>
-        <label for="scope-{{ $i }}">
+    <li>
+      <input type="checkbox" checked name="{{ $.Names.Scopes }}" value="{{ .Name }}" id="scope-{{$i}}">
+      <label for="scope-{{ $i }}">
 {{ template "scope" . }}
-        </label>
-      </td>
-    </tr>
+      </label>
+    </li>
     {{ end }}
   {{ end }}
-  </table>
+  </ul>
+  <p class="hint">{{ .Locale.UncheckPermissionsHint }}</p>
 
   <!-- Tell the user where they're going -->
   {{ if .Values.RedirectURI -}}
-  <div class="redirect-info">
-    <div class="muted">You will be redirected to {{ .Values.RedirectURI }}</div>
-  </div>
+  <div class="redirect-info">{{ .Locale.YouWillBeRedirectedTo }} {{ .Values.RedirectURI }}</div>
   {{- end }}
 
   <div>
-    <input type="submit" name="{{ .Names.Approve }}" value="Allow selected permissions">
-    <input type="submit" name="{{ .Names.Deny    }}" value="Deny">
+    <input type="submit" name="{{ .Names.Approve }}" value="{{ .Locale.AllowSelectedPermissions }}">
+    <input type="submit" name="{{ .Names.Deny    }}" value="{{ .Locale.Deny }}">
   </div>
 </form>
 {{ end }}
+</main>
 </body>
 </html>
 `
@@ -1,6 +1,14 @@
 package headers
 
-import "net/http"
+import (
+	"net/http"
+	"strings"
+)
+
+// DefaultContentSecurityPolicy only allows the resources the built-in pages and themes use: inline styles,
+// scripts, stylesheets, fonts and images served by the OAuth server, and provider icons from https URLs.
+// It has no form-action directive, as browsers apply it to the redirects to the clients after a form was submitted.
+const DefaultContentSecurityPolicy = "default-src 'none'; script-src 'self'; style-src 'self' 'unsafe-inline'; img-src 'self' https: data:; font-src 'self'; base-uri 'none'"
 
 // We cannot set HSTS by default, it has too many drawbacks in environments
 // that use self-signed certs
@@ -9,6 +17,8 @@ var standardHeaders = map[string]string{
 	"Cache-Control": "no-cache, no-store, max-age=0, must-revalidate",
 	"Pragma":        "no-cache",
 	"Expires":       "0",
+	// Only load what the pages need, this limits the damage of injected markup
+	"Content-Security-Policy": DefaultContentSecurityPolicy + "; frame-ancestors 'none'",
 	// Use a reasonably strict Referer policy by default
 	"Referrer-Policy": "strict-origin-when-cross-origin",
 	// Do not allow embedding as that can lead to clickjacking attacks
@@ -19,11 +29,77 @@ var standardHeaders = map[string]string{
 	"X-XSS-Protection":       "1; mode=block",
 }
 
-func WithStandardHeaders(handler http.Handler) http.Handler {
+// SecurityHeaders overrides the security headers of the responses of the OAuth server.
+// Empty values keep the defaults.
+type SecurityHeaders struct {
+	// ContentSecurityPolicy replaces the default policy. The frame-ancestors directive is
+	// added unless the policy has one.
+	ContentSecurityPolicy string
+	// StrictTransportSecurity is not set by default
+	StrictTransportSecurity string
+	ReferrerPolicy          string
+	ContentTypeOptions      string
+	// ExtraSources are allowed by the default policy to provide scripts, styles, frames and connections,
+	// such as the widget of a CAPTCHA service
+	ExtraSources []string
+	// FrameAncestors are the sources that may embed the pages, e.g. 'self' or https://console.example.com.
+	// By default, no page may embed them. X-Frame-Options is dropped if sources are set, as it cannot allow them.
+	FrameAncestors []string
+}
+
+// Headers returns the standard headers with the overrides applied
+func (s SecurityHeaders) Headers() map[string]string {
+	h := make(map[string]string, len(standardHeaders))
+	for k, v := range standardHeaders {
+		h[k] = v
+	}
+
+	frameAncestors := "'none'"
+	if len(s.FrameAncestors) > 0 {
+		frameAncestors = strings.Join(s.FrameAncestors, " ")
+		delete(h, "X-Frame-Options")
+	}
+	csp := DefaultContentSecurityPolicy
+	if len(s.ExtraSources) > 0 {
+		sources := strings.Join(s.ExtraSources, " ")
+		csp = "default-src 'none'; script-src 'self' " + sources + "; style-src 'self' 'unsafe-inline' " + sources +
+			"; img-src 'self' https: data:; font-src 'self'; frame-src " + sources + "; connect-src " + sources + "; base-uri 'none'"
+	}
+	if len(s.ContentSecurityPolicy) > 0 {
+		csp = strings.TrimSuffix(strings.TrimSpace(s.ContentSecurityPolicy), ";")
+	}
+	if !hasDirective(csp, "frame-ancestors") {
+		csp += "; frame-ancestors " + frameAncestors
+	}
+	h["Content-Security-Policy"] = csp
+
+	for k, v := range map[string]string{
+		"Strict-Transport-Security": s.StrictTransportSecurity,
+		"Referrer-Policy":           s.ReferrerPolicy,
+		"X-Content-Type-Options":    s.ContentTypeOptions,
+	} {
+		if len(v) > 0 {
+			h[k] = v
+		}
+	}
+	return h
+}
+
+func hasDirective(policy, directive string) bool {
+	for _, d := range strings.Split(policy, ";") {
+		if fields := strings.Fields(d); len(fields) > 0 && strings.EqualFold(fields[0], directive) {
+			return true
+		}
+	}
+	return false
+}
+
+func WithStandardHeaders(handler http.Handler, securityHeaders SecurityHeaders) http.Handler {
+	headers := securityHeaders.Headers()
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// force every request into the OAuth server to have our standard headers
 		h := w.Header()
-		for k, v := range standardHeaders {
+		for k, v := range headers {
 			h.Set(k, v)
 		}
 
@@ -0,0 +1,77 @@
+package headers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWithStandardHeaders(t *testing.T) {
+	testCases := map[string]struct {
+		SecurityHeaders SecurityHeaders
+		Expect          map[string]string
+	}{
+		"defaults": {
+			Expect: map[string]string{
+				"Content-Security-Policy":   DefaultContentSecurityPolicy + "; frame-ancestors 'none'",
+				"Strict-Transport-Security": "",
+				"Referrer-Policy":           "strict-origin-when-cross-origin",
+				"X-Content-Type-Options":    "nosniff",
+				"X-Frame-Options":           "DENY",
+				"Cache-Control":             "no-cache, no-store, max-age=0, must-revalidate",
+			},
+		},
+		"overrides": {
+			SecurityHeaders: SecurityHeaders{
+				ContentSecurityPolicy:   "default-src 'self';",
+				StrictTransportSecurity: "max-age=31536000",
+				ReferrerPolicy:          "no-referrer",
+			},
+			Expect: map[string]string{
+				"Content-Security-Policy":   "default-src 'self'; frame-ancestors 'none'",
+				"Strict-Transport-Security": "max-age=31536000",
+				"Referrer-Policy":           "no-referrer",
+				"X-Content-Type-Options":    "nosniff",
+				"X-Frame-Options":           "DENY",
+			},
+		},
+		"extra sources": {
+			SecurityHeaders: SecurityHeaders{
+				ExtraSources: []string{"https://hcaptcha.com"},
+			},
+			Expect: map[string]string{
+				"Content-Security-Policy": "default-src 'none'; script-src 'self' https://hcaptcha.com; style-src 'self' 'unsafe-inline' https://hcaptcha.com; img-src 'self' https: data:; font-src 'self'; frame-src https://hcaptcha.com; connect-src https://hcaptcha.com; base-uri 'none'; frame-ancestors 'none'",
+			},
+		},
+		"frame ancestors": {
+			SecurityHeaders: SecurityHeaders{
+				FrameAncestors: []string{"'self'", "https://console.example.com"},
+			},
+			Expect: map[string]string{
+				"Content-Security-Policy": DefaultContentSecurityPolicy + "; frame-ancestors 'self' https://console.example.com",
+				"X-Frame-Options":         "",
+			},
+		},
+		"policy with frame ancestors": {
+			SecurityHeaders: SecurityHeaders{
+				ContentSecurityPolicy: "default-src 'self'; Frame-Ancestors 'self'",
+				FrameAncestors:        []string{"https://console.example.com"},
+			},
+			Expect: map[string]string{
+				"Content-Security-Policy": "default-src 'self'; Frame-Ancestors 'self'",
+				"X-Frame-Options":         "",
+			},
+		},
+	}
+
+	for k, testCase := range testCases {
+		handler := WithStandardHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), testCase.SecurityHeaders)
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/login", nil))
+		for header, expected := range testCase.Expect {
+			if actual := resp.Header().Get(header); actual != expected {
+				t.Errorf("%s: expected %s %q, got %q", k, header, expected, actual)
+			}
+		}
+	}
+}
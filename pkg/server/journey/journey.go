@@ -0,0 +1,185 @@
+// Package journey measures complete browser logins, from the authorize request that asks the user to log in, through
+// the redirect to the identity provider and its callback, to the authorize response that issues the code or token.
+// The state of a login is kept in a signed cookie, so that its latency spans all of its requests on any replica.
+// The durations and results of logins are exported as the openshift_auth_login_* metrics, e.g. for a success ratio
+// and a latency SLI.
+package journey
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/openshift/osin"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apiserver/pkg/authentication/user"
+
+	"github.com/openshift/oauth-server/pkg/api"
+	"github.com/openshift/oauth-server/pkg/oauth/handlers"
+	metrics "github.com/openshift/oauth-server/pkg/prometheus"
+	"github.com/openshift/oauth-server/pkg/server/cookies"
+)
+
+const (
+	cookieName = "login-journey"
+
+	// MaxDuration is the duration after which a login that did not finish is counted as abandoned, once the browser
+	// starts another one. Logins of browsers that never return are not counted.
+	MaxDuration = 30 * time.Minute
+
+	// cookieMaxAge keeps the cookie of unfinished logins long enough to count them as abandoned
+	cookieMaxAge = 24 * time.Hour
+
+	// unknownProvider is the provider of logins that failed or were abandoned before the user authenticated
+	unknownProvider = "unknown"
+)
+
+// Codec signs and encrypts values, e.g. the session keys
+type Codec interface {
+	Encode(name string, value interface{}) (string, error)
+	Decode(name, value string, dst interface{}) error
+}
+
+// progress is the progress of a login, times are in nanoseconds since the epoch
+type progress struct {
+	Start         int64
+	Authenticated int64
+	Provider      string
+}
+
+// Tracker follows logins through their requests. It implements osinserver.AuthorizeHandler, which ends logins, and
+// handlers.AuthenticationErrorHandler, which fails them.
+type Tracker struct {
+	cookie cookies.Options
+	codec  Codec
+	now    func() time.Time
+}
+
+// NewTracker returns a tracker that keeps the state of logins in a cookie with the options, signed by codec
+func NewTracker(cookie cookies.Options, codec Codec) *Tracker {
+	return &Tracker{cookie: cookie, codec: codec, now: time.Now}
+}
+
+// AuthenticationHandler returns a handler that starts a login before delegate asks the user to log in, unless the
+// browser is in the middle of one
+func (t *Tracker) AuthenticationHandler(delegate handlers.AuthenticationHandler) handlers.AuthenticationHandler {
+	return &authenticationHandler{tracker: t, delegate: delegate}
+}
+
+// SuccessHandler returns a success handler that records that the user authenticated with the identity provider
+// once delegate, e.g. the session, accepted the login
+func (t *Tracker) SuccessHandler(provider string, delegate handlers.AuthenticationSuccessHandler) handlers.AuthenticationSuccessHandler {
+	return &successHandler{tracker: t, provider: provider, delegate: delegate}
+}
+
+// AuthenticationError fails the login of the request and passes the error on to the next error handler
+func (t *Tracker) AuthenticationError(err error, w http.ResponseWriter, req *http.Request) (bool, error) {
+	if s, ok := t.get(req); ok {
+		t.end(w, s, metrics.FailResult)
+	}
+	return false, err
+}
+
+// HandleAuthorize implements osinserver.AuthorizeHandler. It ends the login of the request, which succeeded if the
+// authorize request was authorized.
+func (t *Tracker) HandleAuthorize(ar *osin.AuthorizeRequest, resp *osin.Response, w http.ResponseWriter) (bool, error) {
+	if ar.HttpRequest == nil {
+		return false, nil
+	}
+	if s, ok := t.get(ar.HttpRequest); ok {
+		result := metrics.SuccessResult
+		if !ar.Authorized {
+			result = metrics.FailResult
+		}
+		t.end(w, s, result)
+	}
+	return false, nil
+}
+
+// start starts a login, a login that did not finish within MaxDuration is abandoned
+func (t *Tracker) start(w http.ResponseWriter, req *http.Request) {
+	now := t.now()
+	if s, ok := t.get(req); ok {
+		if now.Sub(time.Unix(0, s.Start)) < MaxDuration {
+			return
+		}
+		metrics.RecordLogin(s.provider(), metrics.AbandonedResult, now.Sub(time.Unix(0, s.Start)))
+	}
+	t.set(w, progress{Start: now.UnixNano()})
+}
+
+// end records the duration and result of the login and removes its cookie
+func (t *Tracker) end(w http.ResponseWriter, s progress, result string) {
+	now := t.now()
+	metrics.RecordLogin(s.provider(), result, now.Sub(time.Unix(0, s.Start)))
+	if s.Authenticated > 0 {
+		metrics.RecordLoginCompletion(s.Provider, now.Sub(time.Unix(0, s.Authenticated)))
+	}
+	cookie := t.cookie.New(t.cookie.Name(cookieName), "")
+	cookie.MaxAge = -1
+	t.cookie.Set(w, cookie)
+}
+
+// get returns the state of the login of the browser of req
+func (t *Tracker) get(req *http.Request) (progress, bool) {
+	name := t.cookie.Name(cookieName)
+	cookie, err := req.Cookie(name)
+	if err != nil {
+		return progress{}, false
+	}
+	s := progress{}
+	if err := t.codec.Decode(name, cookie.Value, &s); err != nil || s.Start == 0 {
+		return progress{}, false
+	}
+	return s, true
+}
+
+func (t *Tracker) set(w http.ResponseWriter, s progress) {
+	name := t.cookie.Name(cookieName)
+	encoded, err := t.codec.Encode(name, s)
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("unable to encode the login journey: %v", err))
+		return
+	}
+	cookie := t.cookie.New(name, encoded)
+	cookie.MaxAge = int(cookieMaxAge.Seconds())
+	t.cookie.Set(w, cookie)
+}
+
+func (s progress) provider() string {
+	if len(s.Provider) == 0 {
+		return unknownProvider
+	}
+	return s.Provider
+}
+
+type authenticationHandler struct {
+	tracker  *Tracker
+	delegate handlers.AuthenticationHandler
+}
+
+func (h *authenticationHandler) AuthenticationNeeded(client api.Client, w http.ResponseWriter, req *http.Request) (bool, error) {
+	h.tracker.start(w, req)
+	return h.delegate.AuthenticationNeeded(client, w, req)
+}
+
+type successHandler struct {
+	tracker  *Tracker
+	provider string
+	delegate handlers.AuthenticationSuccessHandler
+}
+
+func (h *successHandler) AuthenticationSucceeded(user user.Info, state string, w http.ResponseWriter, req *http.Request) (bool, error) {
+	handled, err := h.delegate.AuthenticationSucceeded(user, state, w, req)
+	if err != nil {
+		return handled, err
+	}
+	if s, ok := h.tracker.get(req); ok {
+		now := h.tracker.now()
+		metrics.RecordLoginAuthentication(h.provider, now.Sub(time.Unix(0, s.Start)))
+		s.Authenticated = now.UnixNano()
+		s.Provider = h.provider
+		h.tracker.set(w, s)
+	}
+	return handled, err
+}
@@ -0,0 +1,129 @@
+package journey
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/openshift/osin"
+	"k8s.io/apiserver/pkg/authentication/user"
+
+	"github.com/openshift/oauth-server/pkg/api"
+	"github.com/openshift/oauth-server/pkg/server/cookies"
+	"github.com/openshift/oauth-server/pkg/server/session"
+)
+
+type fakeAuthenticationHandler struct{}
+
+func (fakeAuthenticationHandler) AuthenticationNeeded(client api.Client, w http.ResponseWriter, req *http.Request) (bool, error) {
+	w.WriteHeader(http.StatusFound)
+	return true, nil
+}
+
+type fakeSuccessHandler struct {
+	err error
+}
+
+func (h fakeSuccessHandler) AuthenticationSucceeded(user user.Info, state string, w http.ResponseWriter, req *http.Request) (bool, error) {
+	return false, h.err
+}
+
+func TestTracker(t *testing.T) {
+	tracker := NewTracker(cookies.Options{Secure: true}, session.NewKeys([]byte("0123456789abcdef0123456789abcdef")))
+	start := time.Now().Truncate(time.Second)
+	at := start
+	tracker.now = func() time.Time { return at }
+
+	// request sends the cookies of the browser and returns the cookie of the journey that was set, if any
+	var browser *http.Cookie
+	request := func(handle func(w http.ResponseWriter, req *http.Request)) *http.Cookie {
+		req := httptest.NewRequest(http.MethodGet, "/oauth/authorize", nil)
+		if browser != nil {
+			req.AddCookie(browser)
+		}
+		w := httptest.NewRecorder()
+		handle(w, req)
+		for _, cookie := range w.Result().Cookies() {
+			if cookie.Name == cookieName {
+				browser = cookie
+				if cookie.MaxAge < 0 {
+					browser = nil
+				}
+				return cookie
+			}
+		}
+		return nil
+	}
+	progressOf := func(cookie *http.Cookie) progress {
+		s := progress{}
+		if err := tracker.codec.Decode(cookieName, cookie.Value, &s); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	authHandler := tracker.AuthenticationHandler(fakeAuthenticationHandler{})
+	needsLogin := func(w http.ResponseWriter, req *http.Request) {
+		if _, err := authHandler.AuthenticationNeeded(nil, w, req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cookie := request(needsLogin)
+	if cookie == nil || progressOf(cookie).Start != start.UnixNano() {
+		t.Fatalf("expected the login to start, got %#v", cookie)
+	}
+
+	// asking again during the login keeps its start
+	at = start.Add(time.Minute)
+	if cookie := request(needsLogin); cookie != nil {
+		t.Errorf("expected the login to continue, got %#v", cookie)
+	}
+
+	// a failed login with the identity provider is not recorded
+	failed := tracker.SuccessHandler("github", fakeSuccessHandler{err: errors.New("denied")})
+	if cookie := request(func(w http.ResponseWriter, req *http.Request) { failed.AuthenticationSucceeded(nil, "", w, req) }); cookie != nil {
+		t.Errorf("expected no update after a failed login, got %#v", cookie)
+	}
+
+	at = start.Add(2 * time.Minute)
+	succeeded := tracker.SuccessHandler("github", fakeSuccessHandler{})
+	cookie = request(func(w http.ResponseWriter, req *http.Request) { succeeded.AuthenticationSucceeded(nil, "", w, req) })
+	if cookie == nil {
+		t.Fatal("expected the authentication to be recorded")
+	}
+	if s := progressOf(cookie); s.Start != start.UnixNano() || s.Authenticated != at.UnixNano() || s.Provider != "github" {
+		t.Errorf("unexpected progress %#v", s)
+	}
+
+	at = start.Add(3 * time.Minute)
+	cookie = request(func(w http.ResponseWriter, req *http.Request) {
+		tracker.HandleAuthorize(&osin.AuthorizeRequest{HttpRequest: req, Authorized: true}, nil, w)
+	})
+	if cookie == nil || cookie.MaxAge >= 0 || browser != nil {
+		t.Fatalf("expected the login to end, got %#v", cookie)
+	}
+	if cookie := request(func(w http.ResponseWriter, req *http.Request) {
+		tracker.HandleAuthorize(&osin.AuthorizeRequest{HttpRequest: req, Authorized: true}, nil, w)
+	}); cookie != nil {
+		t.Errorf("expected authorize requests without a login not to be tracked, got %#v", cookie)
+	}
+
+	// a login that did not finish is replaced by a new one after MaxDuration
+	request(needsLogin)
+	at = at.Add(MaxDuration)
+	cookie = request(needsLogin)
+	if cookie == nil || progressOf(cookie).Start != at.UnixNano() {
+		t.Fatalf("expected an abandoned login to be replaced, got %#v", cookie)
+	}
+
+	cookie = request(func(w http.ResponseWriter, req *http.Request) {
+		if handled, err := tracker.AuthenticationError(errors.New("callback failed"), w, req); handled || err == nil {
+			t.Errorf("expected the error to be passed on, got %v %v", handled, err)
+		}
+	})
+	if cookie == nil || cookie.MaxAge >= 0 {
+		t.Errorf("expected a failed callback to end the login, got %#v", cookie)
+	}
+}
@@ -1,10 +1,17 @@
 package locales
 
 import (
+	"net/http"
+
 	"golang.org/x/text/language"
 	"k8s.io/klog/v2"
 )
 
+// LangParam is the query parameter that selects the language of a page, it takes precedence over
+// the Accept-Language header
+const LangParam = "lang"
+
+// Localization is the message catalog of a language, keyed by message name
 type Localization map[string]string
 
 var supportedLocalizations = map[string]Localization{
@@ -14,6 +21,26 @@ var supportedLocalizations = map[string]Localization{
 	language.Korean.String():   locale_ko,
 }
 
+// ForRequest returns the localization of the language selected by the lang query parameter of the request,
+// or negotiated using its Accept-Language header if the parameter is not set or not supported
+func ForRequest(req *http.Request) Localization {
+	if req.URL != nil {
+		if lang := req.URL.Query().Get(LangParam); len(lang) > 0 {
+			if tag, err := language.Parse(lang); err == nil {
+				matched, _, confidence := language.NewMatcher(supportedLangs).Match(tag)
+				if confidence != language.No {
+					base, _ := matched.Base()
+					if locale, ok := supportedLocalizations[base.String()]; ok {
+						return locale
+					}
+				}
+			}
+			klog.V(5).Infof("Unsupported language %q requested, falling back to the 'Accept-Language' header", lang)
+		}
+	}
+	return GetLocale(req.Header.Get("Accept-Language"))
+}
+
 func GetLocale(acceptLangHeader string) Localization {
 	locale, ok := supportedLocalizations[getPreferredLang(acceptLangHeader)]
 	if !ok {
@@ -42,6 +69,7 @@ var supportedLangs = []language.Tag{
 }
 
 var locale_en = Localization{
+	"Lang":                                 "en",
 	"LogInToYourAccount":                   "Log in to your account",
 	"Username":                             "Username",
 	"Password":                             "Password",
@@ -52,9 +80,44 @@ var locale_en = Localization{
 	"LoginIsRequiredPleaseTryAgain":        "Login is required. Please try again.",
 	"CouldNotCheckCSRFTokenPleaseTryAgain": "Could not check CSRF token. Please try again.",
 	"InvalidLoginOrPasswordPleaseTryAgain": "Invalid login or password. Please try again.",
+	"CouldNotCreateUser":                   "Could not create user.",
+	"CouldNotFindUser":                     "Could not find user.",
+	"AccessDenied":                         "Access denied.",
+	"AnAuthenticationErrorOccurred":        "An authentication error occurred.",
+	"AGrantErrorOccurred":                  "A grant error occurred.",
+	"TermsOfService":                       "Terms of service",
+	"Accept":                               "Accept",
+	"Decline":                              "Decline",
+	"AuthorizeAccess":                      "Authorize access",
+	"Application":                          "Application",
+	"ServiceAccount":                       "Service account",
+	"Account":                              "Account",
+	"ExistingPermissions":                  "Existing permissions",
+	"RequestedPermissions":                 "Requested permissions",
+	"AdditionalRequestedPermissions":       "Additional requested permissions",
+	"UncheckPermissionsHint":               "Uncheck the permissions you do not want to grant.",
+	"YouWillBeRedirectedTo":                "You will be redirected to",
+	"AllowSelectedPermissions":             "Allow selected permissions",
+	"Deny":                                 "Deny",
+	"CompleteTheVerificationAndTryAgain":   "Please complete the verification and try again.",
+	"EnableJavaScriptToLogIn":              "JavaScript is required to verify the login.",
+	"Reference":                            "Reference",
+	"TheLoginCouldNotBeVerified":           "The login could not be verified. Please try again.",
+	"TheIdentityProviderCouldNotLogYouIn":  "The identity provider could not log you in.",
+	"TheLoginTookTooLong":                  "The login took too long. Please try again.",
+	"TheServerIsBusy":                      "The server is busy right now. Please try again in a minute.",
+	"YouWereSentBackToTheLoginTooOften":    "You were sent back to the login too often. Please allow cookies and try again.",
+	"LoginsAreTemporarilyDisabled":         "Logins are temporarily disabled",
+	"LoginsAreDisabledForMaintenance":      "Logins are disabled for maintenance. Please try again later.",
+	"ErrorCode":                            "Error code",
+	"TryAnotherProvider":                   "Try another identity provider",
+	"UserBelongsToAnotherIdentity":         "The user of this identity belongs to another identity.",
+	"AnAdministratorCanLinkThem":           "An administrator can link the identity to the user.",
+	"Identity":                             "Identity",
 }
 
 var locale_zh = Localization{
+	"Lang":                                 "zh",
 	"LogInToYourAccount":                   "登录到您的帐户",
 	"Username":                             "用户名",
 	"Password":                             "密码",
@@ -65,9 +128,44 @@ var locale_zh = Localization{
 	"LoginIsRequiredPleaseTryAgain":        "需要登录。请再次尝试。",
 	"CouldNotCheckCSRFTokenPleaseTryAgain": "无法检查 CSRF 令牌。请重试。",
 	"InvalidLoginOrPasswordPleaseTryAgain": "无效的登录或密码。请再次尝试。",
+	"CouldNotCreateUser":                   "无法创建用户。",
+	"CouldNotFindUser":                     "找不到用户。",
+	"AccessDenied":                         "访问被拒绝。",
+	"AnAuthenticationErrorOccurred":        "发生了身份验证错误。",
+	"AGrantErrorOccurred":                  "发生了授权错误。",
+	"TermsOfService":                       "服务条款",
+	"Accept":                               "接受",
+	"Decline":                              "拒绝",
+	"AuthorizeAccess":                      "授权访问",
+	"Application":                          "应用程序",
+	"ServiceAccount":                       "服务帐户",
+	"Account":                              "帐户",
+	"ExistingPermissions":                  "现有权限",
+	"RequestedPermissions":                 "请求的权限",
+	"AdditionalRequestedPermissions":       "请求的其他权限",
+	"UncheckPermissionsHint":               "取消选中您不想授予的权限。",
+	"YouWillBeRedirectedTo":                "您将被重定向到",
+	"AllowSelectedPermissions":             "允许所选权限",
+	"Deny":                                 "拒绝",
+	"CompleteTheVerificationAndTryAgain":   "请完成验证后重试。",
+	"EnableJavaScriptToLogIn":              "需要 JavaScript 才能验证登录。",
+	"Reference":                            "参考编号",
+	"TheLoginCouldNotBeVerified":           "无法验证登录。请重试。",
+	"TheIdentityProviderCouldNotLogYouIn":  "身份提供程序无法让您登录。",
+	"TheLoginTookTooLong":                  "登录耗时过长。请重试。",
+	"TheServerIsBusy":                      "服务器当前繁忙。请稍后重试。",
+	"YouWereSentBackToTheLoginTooOften":    "登录后多次被重定向回登录页面。请允许 Cookie 后重试。",
+	"LoginsAreTemporarilyDisabled":         "登录暂时被禁用",
+	"LoginsAreDisabledForMaintenance":      "登录因维护而被禁用。请稍后重试。",
+	"ErrorCode":                            "错误代码",
+	"TryAnotherProvider":                   "尝试其他身份提供程序",
+	"UserBelongsToAnotherIdentity":         "此身份的用户属于另一个身份。",
+	"AnAdministratorCanLinkThem":           "管理员可以将该身份关联到该用户。",
+	"Identity":                             "身份",
 }
 
 var locale_ja = Localization{
+	"Lang":                                 "ja",
 	"LogInToYourAccount":                   "アカウントにログイン",
 	"Username":                             "ユーザー名",
 	"Password":                             "パスワード",
@@ -78,9 +176,44 @@ var locale_ja = Localization{
 	"LoginIsRequiredPleaseTryAgain":        "ログインが必要です。もう一度やり直してください。",
 	"CouldNotCheckCSRFTokenPleaseTryAgain": "CSRF トークンを確認できませんでした。もう一度やり直してください。",
 	"InvalidLoginOrPasswordPleaseTryAgain": "無効なログインまたはパスワードです。もう一度やり直してください。",
+	"CouldNotCreateUser":                   "ユーザーを作成できませんでした。",
+	"CouldNotFindUser":                     "ユーザーが見つかりませんでした。",
+	"AccessDenied":                         "アクセスが拒否されました。",
+	"AnAuthenticationErrorOccurred":        "認証エラーが発生しました。",
+	"AGrantErrorOccurred":                  "付与エラーが発生しました。",
+	"TermsOfService":                       "利用規約",
+	"Accept":                               "同意する",
+	"Decline":                              "同意しない",
+	"AuthorizeAccess":                      "アクセスの承認",
+	"Application":                          "アプリケーション",
+	"ServiceAccount":                       "サービスアカウント",
+	"Account":                              "アカウント",
+	"ExistingPermissions":                  "既存の権限",
+	"RequestedPermissions":                 "要求された権限",
+	"AdditionalRequestedPermissions":       "追加で要求された権限",
+	"UncheckPermissionsHint":               "付与しない権限のチェックを外してください。",
+	"YouWillBeRedirectedTo":                "リダイレクト先:",
+	"AllowSelectedPermissions":             "選択した権限を許可",
+	"Deny":                                 "拒否",
+	"CompleteTheVerificationAndTryAgain":   "確認を完了してから、もう一度お試しください。",
+	"EnableJavaScriptToLogIn":              "ログインを確認するには JavaScript が必要です。",
+	"Reference":                            "参照番号",
+	"TheLoginCouldNotBeVerified":           "ログインを確認できませんでした。もう一度お試しください。",
+	"TheIdentityProviderCouldNotLogYouIn":  "アイデンティティープロバイダーでログインできませんでした。",
+	"TheLoginTookTooLong":                  "ログインに時間がかかりすぎました。もう一度お試しください。",
+	"TheServerIsBusy":                      "サーバーは現在混み合っています。しばらくしてからもう一度お試しください。",
+	"YouWereSentBackToTheLoginTooOften":    "ログインページに何度も戻されました。Cookie を許可してもう一度お試しください。",
+	"LoginsAreTemporarilyDisabled":         "ログインは一時的に無効になっています",
+	"LoginsAreDisabledForMaintenance":      "メンテナンスのためログインは無効になっています。後でもう一度お試しください。",
+	"ErrorCode":                            "エラーコード",
+	"TryAnotherProvider":                   "別のアイデンティティープロバイダーを試す",
+	"UserBelongsToAnotherIdentity":         "このアイデンティティーのユーザーは別のアイデンティティーに属しています。",
+	"AnAdministratorCanLinkThem":           "管理者はアイデンティティーをユーザーにリンクできます。",
+	"Identity":                             "アイデンティティー",
 }
 
 var locale_ko = Localization{
+	"Lang":                                 "ko",
 	"LogInToYourAccount":                   "귀하의 계정에 로그인하십시오",
 	"Username":                             "사용자 이름",
 	"Password":                             "암호",
@@ -91,4 +224,38 @@ var locale_ko = Localization{
 	"LoginIsRequiredPleaseTryAgain":        "로그인이 필요합니다. 다시 시도하십시오.",
 	"CouldNotCheckCSRFTokenPleaseTryAgain": "CSRF 토큰을 확인할 수 없습니다. 다시 시도하십시오.",
 	"InvalidLoginOrPasswordPleaseTryAgain": "로그인 또는 비밀번호가 잘못되었습니다. 다시 시도하십시오",
+	"CouldNotCreateUser":                   "사용자를 생성할 수 없습니다.",
+	"CouldNotFindUser":                     "사용자를 찾을 수 없습니다.",
+	"AccessDenied":                         "액세스가 거부되었습니다.",
+	"AnAuthenticationErrorOccurred":        "인증 오류가 발생했습니다.",
+	"AGrantErrorOccurred":                  "권한 부여 오류가 발생했습니다.",
+	"TermsOfService":                       "서비스 약관",
+	"Accept":                               "동의",
+	"Decline":                              "거부",
+	"AuthorizeAccess":                      "액세스 승인",
+	"Application":                          "애플리케이션",
+	"ServiceAccount":                       "서비스 계정",
+	"Account":                              "계정",
+	"ExistingPermissions":                  "기존 권한",
+	"RequestedPermissions":                 "요청된 권한",
+	"AdditionalRequestedPermissions":       "추가로 요청된 권한",
+	"UncheckPermissionsHint":               "부여하지 않을 권한의 선택을 해제하십시오.",
+	"YouWillBeRedirectedTo":                "리디렉션 대상:",
+	"AllowSelectedPermissions":             "선택한 권한 허용",
+	"Deny":                                 "거부",
+	"CompleteTheVerificationAndTryAgain":   "확인을 완료한 후 다시 시도하십시오.",
+	"EnableJavaScriptToLogIn":              "로그인을 확인하려면 JavaScript가 필요합니다.",
+	"Reference":                            "참조 번호",
+	"TheLoginCouldNotBeVerified":           "로그인을 확인할 수 없습니다. 다시 시도하십시오.",
+	"TheIdentityProviderCouldNotLogYouIn":  "ID 공급자에서 로그인할 수 없습니다.",
+	"TheLoginTookTooLong":                  "로그인 시간이 너무 오래 걸렸습니다. 다시 시도하십시오.",
+	"TheServerIsBusy":                      "서버가 현재 사용 중입니다. 잠시 후 다시 시도하십시오.",
+	"YouWereSentBackToTheLoginTooOften":    "로그인 페이지로 너무 여러 번 되돌아왔습니다. 쿠키를 허용한 후 다시 시도하십시오.",
+	"LoginsAreTemporarilyDisabled":         "로그인이 일시적으로 비활성화되었습니다",
+	"LoginsAreDisabledForMaintenance":      "유지 관리로 인해 로그인이 비활성화되었습니다. 나중에 다시 시도하십시오.",
+	"ErrorCode":                            "오류 코드",
+	"TryAnotherProvider":                   "다른 ID 공급자 사용",
+	"UserBelongsToAnotherIdentity":         "이 ID의 사용자는 다른 ID에 속해 있습니다.",
+	"AnAdministratorCanLinkThem":           "관리자가 ID를 사용자에 연결할 수 있습니다.",
+	"Identity":                             "ID",
 }
@@ -1,6 +1,7 @@
 package locales
 
 import (
+	"net/http/httptest"
 	"reflect"
 	"testing"
 )
@@ -50,3 +51,64 @@ func TestLocales(t *testing.T) {
 		})
 	}
 }
+
+func TestForRequest(t *testing.T) {
+	tests := []struct {
+		name   string
+		url    string
+		header string
+		locale Localization
+	}{
+		{
+			name:   "negotiated with the 'Accept-Language' request header without parameter",
+			url:    "/login",
+			header: "ja;q=0.8, en;q=0.7",
+			locale: locale_ja,
+		},
+		{
+			name:   "parameter takes precedence over the 'Accept-Language' request header",
+			url:    "/login?lang=ko",
+			header: "ja;q=0.8, en;q=0.7",
+			locale: locale_ko,
+		},
+		{
+			name:   "parameter with region",
+			url:    "/login?lang=zh-CN",
+			locale: locale_zh,
+		},
+		{
+			name:   "unsupported parameter falls back to the 'Accept-Language' request header",
+			url:    "/login?lang=fr",
+			header: "ja;q=0.8, en;q=0.7",
+			locale: locale_ja,
+		},
+		{
+			name:   "invalid parameter falls back to the 'Accept-Language' request header",
+			url:    "/login?lang=%3Cscript%3E",
+			header: "ko",
+			locale: locale_ko,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.url, nil)
+			req.Header.Set("Accept-Language", tt.header)
+			if locale := ForRequest(req); !reflect.DeepEqual(locale, tt.locale) {
+				t.Errorf("expected %s, got %s", tt.locale["Lang"], locale["Lang"])
+			}
+		})
+	}
+}
+
+func TestLocalizationsComplete(t *testing.T) {
+	for lang, locale := range supportedLocalizations {
+		for key := range locale_en {
+			if len(locale[key]) == 0 {
+				t.Errorf("localization %s is missing message %s", lang, key)
+			}
+		}
+		if len(locale) != len(locale_en) {
+			t.Errorf("localization %s has %d messages, expected %d", lang, len(locale), len(locale_en))
+		}
+	}
+}
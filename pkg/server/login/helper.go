@@ -4,6 +4,8 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+
+	"github.com/openshift/oauth-server/pkg/server/locales"
 )
 
 func failed(reason string, w http.ResponseWriter, req *http.Request) {
@@ -17,6 +19,9 @@ func failed(reason string, w http.ResponseWriter, req *http.Request) {
 	if then := req.FormValue(thenParam); len(then) != 0 {
 		query.Set(thenParam, then)
 	}
+	if lang := req.URL.Query().Get(locales.LangParam); len(lang) != 0 {
+		query.Set(locales.LangParam, lang)
+	}
 	uri.RawQuery = query.Encode()
 	http.Redirect(w, req, uri.String(), http.StatusFound)
 }
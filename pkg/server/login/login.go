@@ -2,21 +2,26 @@ package login
 
 import (
 	"bytes"
-	"context"
+	"errors"
 	"fmt"
 	"html/template"
 	"net/http"
-
-	"k8s.io/klog/v2"
+	"net/url"
 
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	kauthenticator "k8s.io/apiserver/pkg/authentication/authenticator"
 
 	oauthserver "github.com/openshift/oauth-server/pkg"
+	"github.com/openshift/oauth-server/pkg/api"
 	"github.com/openshift/oauth-server/pkg/audit"
 	"github.com/openshift/oauth-server/pkg/authenticator"
+	"github.com/openshift/oauth-server/pkg/logging"
 	"github.com/openshift/oauth-server/pkg/oauth/handlers"
 	metrics "github.com/openshift/oauth-server/pkg/prometheus"
+	"github.com/openshift/oauth-server/pkg/server/captcha"
+	"github.com/openshift/oauth-server/pkg/server/correlation"
 	"github.com/openshift/oauth-server/pkg/server/csrf"
+	"github.com/openshift/oauth-server/pkg/server/deadline"
 	"github.com/openshift/oauth-server/pkg/server/errorpage"
 	"github.com/openshift/oauth-server/pkg/server/locales"
 	"github.com/openshift/oauth-server/pkg/server/redirect"
@@ -35,6 +40,8 @@ const (
 	errorCodeUserRequired = "user_required"
 	errorCodeTokenExpired = "token_expired"
 	errorCodeAccessDenied = "access_denied"
+	// errorCodeCaptchaRequired asks to solve the challenge that is required after repeated failures
+	errorCodeCaptchaRequired = "captcha_required"
 )
 
 // Error messages that correlate to the error codes above.
@@ -43,6 +50,8 @@ var errorMessages = map[string]string{
 	errorCodeUserRequired: "LoginIsRequiredPleaseTryAgain",
 	errorCodeTokenExpired: "CouldNotCheckCSRFTokenPleaseTryAgain",
 	errorCodeAccessDenied: "InvalidLoginOrPasswordPleaseTryAgain",
+
+	errorCodeCaptchaRequired: "CompleteTheVerificationAndTryAgain",
 }
 
 type PasswordAuthenticator interface {
@@ -61,10 +70,15 @@ type LoginForm struct {
 
 	Error     string
 	ErrorCode string
+	// CorrelationID is the reference of the login attempt in the logs of the server, it is set with errors
+	CorrelationID string
 
 	Names  LoginFormFields
 	Values LoginFormFields
 
+	// Captcha is the challenge users have to solve after repeated failures, nil if none is required
+	Captcha *captcha.Form
+
 	Locale locales.Localization
 }
 
@@ -76,18 +90,25 @@ type LoginFormFields struct {
 }
 
 type Login struct {
-	provider string
-	csrf     csrf.CSRF
-	auth     PasswordAuthenticator
-	render   LoginFormRenderer
+	provider  string
+	csrf      csrf.CSRF
+	auth      PasswordAuthenticator
+	render    LoginFormRenderer
+	captcha   *captcha.Guard
+	redirects *redirect.Validator
 }
 
-func NewLogin(provider string, csrf csrf.CSRF, auth PasswordAuthenticator, render LoginFormRenderer) *Login {
+// NewLogin returns the password login form of the provider. If captcha is not nil, users have
+// to solve its challenge once their source IP or username failed to log in too often. The "then"
+// URLs the form returns to must be allowed by redirects.
+func NewLogin(provider string, csrf csrf.CSRF, auth PasswordAuthenticator, render LoginFormRenderer, captcha *captcha.Guard, redirects *redirect.Validator) *Login {
 	return &Login{
-		provider: provider,
-		csrf:     csrf,
-		auth:     auth,
-		render:   render,
+		provider:  provider,
+		csrf:      csrf,
+		auth:      auth,
+		render:    render,
+		captcha:   captcha,
+		redirects: redirects,
 	}
 }
 
@@ -107,12 +128,21 @@ func (l *Login) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 }
 
 func (l *Login) handleLoginForm(w http.ResponseWriter, req *http.Request) {
+	l.renderLoginForm(w, req, req.URL.Query().Get(thenParam), req.URL.Query().Get(reasonParam), "")
+}
+
+// renderLoginForm renders the login form. If message is set, it is shown instead of the message for errorCode.
+func (l *Login) renderLoginForm(w http.ResponseWriter, req *http.Request, then, errorCode, message string) {
 	uri, err := getBaseURL(req)
 	if err != nil {
 		utilruntime.HandleError(fmt.Errorf("Unable to generate base URL: %v", err))
 		http.Error(w, "Unable to determine URL", http.StatusInternalServerError)
 		return
 	}
+	// keep the language selected by the query parameter when the form is submitted
+	if lang := req.URL.Query().Get(locales.LangParam); len(lang) > 0 {
+		uri.RawQuery = url.Values{locales.LangParam: {lang}}.Encode()
+	}
 
 	form := LoginForm{
 		ProviderName: l.provider,
@@ -124,22 +154,32 @@ func (l *Login) handleLoginForm(w http.ResponseWriter, req *http.Request) {
 			Password: passwordParam,
 		},
 	}
-	if then := req.URL.Query().Get(thenParam); redirect.IsServerRelativeURL(then) {
+	if l.redirects.Allows(then) {
 		form.Values.Then = then
 	} else {
 		http.Redirect(w, req, "/", http.StatusFound)
 		return
 	}
 
-	form.Locale = locales.GetLocale(req.Header.Get("Accept-Language"))
-	form.ErrorCode = req.URL.Query().Get(reasonParam)
-	if len(form.ErrorCode) > 0 {
+	form.Locale = locales.ForRequest(req)
+	form.ErrorCode = errorCode
+	if len(message) > 0 {
+		form.Error = message
+	} else if len(form.ErrorCode) > 0 {
 		if msg, hasMsg := form.Locale[errorMessages[form.ErrorCode]]; hasMsg {
 			form.Error = msg
 		} else {
-			form.Error = errorpage.AuthenticationErrorMessage(form.ErrorCode)
+			form.Error = errorpage.LocalizedAuthenticationErrorMessage(form.ErrorCode, form.Locale)
 		}
 	}
+	if len(form.Error) > 0 {
+		form.CorrelationID = correlation.ID(req)
+	}
+
+	// the username is only known once the form is submitted, which fails with errorCodeCaptchaRequired
+	if errorCode == errorCodeCaptchaRequired || l.captcha.Required(req, "") {
+		form.Captcha = l.captcha.Form()
+	}
 
 	form.Values.CSRF = l.csrf.Generate(w, req)
 
@@ -148,13 +188,13 @@ func (l *Login) handleLoginForm(w http.ResponseWriter, req *http.Request) {
 
 func (l *Login) handleLogin(w http.ResponseWriter, req *http.Request) {
 	if ok := l.csrf.Check(req, req.FormValue(csrfParam)); !ok {
-		klog.V(4).Infof("Invalid CSRF token: %s", req.FormValue(csrfParam))
+		logging.FromRequest(req).Info(4, "Invalid CSRF token", "provider", l.provider)
 		failed(errorCodeTokenExpired, w, req)
 		return
 	}
 
 	then := req.FormValue(thenParam)
-	if !redirect.IsServerRelativeURL(then) {
+	if !l.redirects.Allows(then) {
 		http.Redirect(w, req, "/", http.StatusFound)
 		return
 	}
@@ -170,17 +210,60 @@ func (l *Login) handleLogin(w http.ResponseWriter, req *http.Request) {
 	}
 
 	audit.AddUsernameAnnotation(req, username)
+	req = logging.WithValues(req, "provider", l.provider, "user", username)
+	logger := logging.FromRequest(req)
+
+	if l.captcha.Required(req, username) {
+		solved, err := l.captcha.Verify(req)
+		if err != nil {
+			logger.Error(err, "Error verifying the challenge")
+		}
+		if !solved {
+			logger.Info(4, "Login requires a challenge")
+			failed(errorCodeCaptchaRequired, w, req)
+			audit.AddDecisionAnnotation(req, audit.DenyDecision)
+			metrics.RecordFormPasswordAuth(metrics.FailResult)
+			return
+		}
+	}
 
-	authResponse, ok, err := l.auth.AuthenticatePassword(context.TODO(), username, password)
+	var (
+		authResponse *kauthenticator.Response
+		ok           bool
+		err          error
+	)
+	// providers that ignore the context, e.g. LDAP, are not waited for once the budget of the login is spent
+	if budgetErr := deadline.Call(req.Context(), func() { authResponse, ok, err = l.auth.AuthenticatePassword(req.Context(), username, password) }); budgetErr != nil {
+		logger.Error(budgetErr, "Error authenticating")
+		failed(errorpage.AuthenticationErrorCode(budgetErr), w, req)
+		audit.AddDecisionAnnotation(req, audit.ErrorDecision)
+		metrics.RecordFormPasswordAuth(metrics.ErrorResult)
+		return
+	}
+	var authorizationDeniedError api.AuthorizationDeniedError
+	if errors.As(err, &authorizationDeniedError) {
+		logger.Info(4, "Login denied", "err", err)
+		audit.AddDecisionAnnotation(req, audit.DenyDecision)
+		metrics.RecordFormPasswordAuth(metrics.FailResult)
+		l.captcha.Failed(req, username)
+		// the message cannot be passed in the redirect without allowing anyone to forge it, show it right away
+		if message := authorizationDeniedError.UserMessage(); len(message) > 0 {
+			l.renderLoginForm(w, req, then, errorCodeAccessDenied, message)
+			return
+		}
+		failed(errorCodeAccessDenied, w, req)
+		return
+	}
 	if err != nil {
-		utilruntime.HandleError(fmt.Errorf(`Error authenticating %q with provider %q: %v`, username, l.provider, err))
+		logger.Error(err, "Error authenticating")
 		failed(errorpage.AuthenticationErrorCode(err), w, req)
 		audit.AddDecisionAnnotation(req, audit.ErrorDecision)
 		metrics.RecordFormPasswordAuth(metrics.ErrorResult)
 		return
 	}
 	if !ok {
-		klog.V(4).Infof(`Login with provider %q failed for %q`, l.provider, username)
+		logger.Info(4, "Login failed")
+		l.captcha.Failed(req, username)
 		failed(errorCodeAccessDenied, w, req)
 		audit.AddDecisionAnnotation(req, audit.DenyDecision)
 		metrics.RecordFormPasswordAuth(metrics.FailResult)
@@ -188,13 +271,11 @@ func (l *Login) handleLogin(w http.ResponseWriter, req *http.Request) {
 	}
 
 	audit.AddDecisionAnnotation(req, audit.AllowDecision)
-	klog.V(4).Infof(`Login with provider %q succeeded for %q: %#v`, l.provider, username, authResponse.User)
+	l.captcha.Succeeded(username)
+	logger.Info(4, "Login succeeded", "name", authResponse.User.GetName(), "groups", authResponse.User.GetGroups())
 	_, err = l.auth.AuthenticationSucceeded(authResponse.User, then, w, req)
 	if err != nil {
-		utilruntime.HandleError(fmt.Errorf(
-			`Error succeeding authentication for %q with provider %q: %v`,
-			username, l.provider, err,
-		))
+		logger.Error(err, "Error succeeding authentication")
 		failed(errorpage.AuthenticationErrorCode(err), w, req)
 		metrics.RecordFormPasswordAuth(metrics.ErrorResult)
 		return
@@ -9,11 +9,15 @@ import (
 	"net/url"
 	"strings"
 	"testing"
+	"time"
 
 	"k8s.io/apiserver/pkg/authentication/authenticator"
 	"k8s.io/apiserver/pkg/authentication/user"
 
+	"github.com/openshift/oauth-server/pkg/api"
+	"github.com/openshift/oauth-server/pkg/server/captcha"
 	"github.com/openshift/oauth-server/pkg/server/csrf"
+	"github.com/openshift/oauth-server/pkg/server/redirect"
 	"github.com/openshift/oauth-server/pkg/userregistry/identitymapper"
 )
 
@@ -40,10 +44,28 @@ func (t *testAuth) AuthenticationSucceeded(user user.Info, then string, w http.R
 	return false, nil
 }
 
+type testChallenge struct {
+	Solved bool
+}
+
+func (c *testChallenge) Form() captcha.Form {
+	return captcha.Form{Type: captcha.TypeHCaptcha, ScriptURL: "https://js.hcaptcha.com/1/api.js", SiteKey: "mysitekey"}
+}
+
+func (c *testChallenge) Verify(req *http.Request) (bool, error) {
+	return c.Solved, nil
+}
+
+func (c *testChallenge) Sources() []string {
+	return nil
+}
+
 func TestLogin(t *testing.T) {
 	testCases := map[string]struct {
 		CSRF       csrf.CSRF
 		Auth       *testAuth
+		Captcha    *captcha.Guard
+		Redirects  *redirect.Validator
 		Path       string
 		PostValues url.Values
 
@@ -77,6 +99,18 @@ func TestLogin(t *testing.T) {
 				`danger`,
 			},
 		},
+		"display form in selected language": {
+			CSRF: &csrf.FakeCSRF{Token: "test"},
+			Auth: &testAuth{},
+			Path: "/login?then=%2F&reason=user_required&lang=ja",
+
+			ExpectStatusCode: 200,
+			ExpectContains: []string{
+				`lang="ja"`,
+				`action="/login?lang=ja"`,
+				`ログインが必要です。もう一度やり直してください。`,
+			},
+		},
 		"redirect when GET has no then param": {
 			CSRF: &csrf.FakeCSRF{Token: "test"},
 			Auth: &testAuth{},
@@ -85,6 +119,28 @@ func TestLogin(t *testing.T) {
 			ExpectStatusCode: 302,
 			ExpectRedirect:   "/",
 		},
+		"redirect when GET has a then param outside the allowed paths": {
+			CSRF:      &csrf.FakeCSRF{Token: "test"},
+			Auth:      &testAuth{},
+			Redirects: redirect.NewValidator([]string{"/oauth/authorize"}, 0),
+			Path:      "/login?then=%2Foauth%2Fauthorize%2F..%2F..%2Fother",
+
+			ExpectStatusCode: 302,
+			ExpectRedirect:   "/",
+		},
+		"redirect when POST has a then param outside the allowed paths": {
+			CSRF:      &csrf.FakeCSRF{Token: "test"},
+			Auth:      &testAuth{},
+			Redirects: redirect.NewValidator([]string{"/oauth/authorize"}, 0),
+			Path:      "/login",
+			PostValues: url.Values{
+				"csrf":     []string{"test"},
+				"then":     []string{"/\\example.com"},
+				"username": []string{"user"},
+				"password": []string{"pass"},
+			},
+			ExpectRedirect: "/",
+		},
 		"redirect when POST is missing then param": {
 			CSRF:           &csrf.FakeCSRF{Token: "test"},
 			Auth:           &testAuth{},
@@ -106,6 +162,13 @@ func TestLogin(t *testing.T) {
 			PostValues:     url.Values{"csrf": []string{"wrong"}},
 			ExpectRedirect: "/login?reason=token_expired&then=%2Ftest",
 		},
+		"redirect with language when POST fails CSRF": {
+			CSRF:           &csrf.FakeCSRF{Token: "test"},
+			Auth:           &testAuth{},
+			Path:           "/login?lang=ko",
+			PostValues:     url.Values{"csrf": []string{"wrong"}, "then": []string{"/test"}},
+			ExpectRedirect: "/login?lang=ko&reason=token_expired&then=%2Ftest",
+		},
 		"redirect when no username": {
 			CSRF: &csrf.FakeCSRF{Token: "test"},
 			Auth: &testAuth{},
@@ -174,6 +237,22 @@ func TestLogin(t *testing.T) {
 			},
 			ExpectRedirect: "/login?reason=mapping_claim_error&then=%2Fanotherurl",
 		},
+		"show message on denial": {
+			CSRF: &csrf.FakeCSRF{Token: "test"},
+			Auth: &testAuth{Err: api.NewAuthorizationDeniedErrorWithMessage(nil, errors.New("denied"), "Your account is suspended")},
+			Path: "/login",
+			PostValues: url.Values{
+				"csrf":     []string{"test"},
+				"username": []string{"user"},
+				"password": []string{"pass"},
+				"then":     []string{"/anotherurl"},
+			},
+			ExpectStatusCode: 200,
+			ExpectContains: []string{
+				"Your account is suspended",
+				`value="/anotherurl"`,
+			},
+		},
 		"redirect preserving then param": {
 			CSRF: &csrf.FakeCSRF{Token: "test"},
 			Auth: &testAuth{Err: errors.New("failed")},
@@ -186,6 +265,55 @@ func TestLogin(t *testing.T) {
 			},
 			ExpectRedirect: "/login?reason=authentication_error&then=%2Fanotherurl",
 		},
+		"display form with required captcha": {
+			CSRF:    &csrf.FakeCSRF{Token: "test"},
+			Auth:    &testAuth{},
+			Captcha: captcha.NewGuard(&testChallenge{}, 0, time.Minute),
+			Path:    "/login?then=%2F",
+
+			ExpectStatusCode: 200,
+			ExpectContains: []string{
+				`<script src="https://js.hcaptcha.com/1/api.js" async defer></script>`,
+				`<div class="h-captcha" data-sitekey="mysitekey"></div>`,
+			},
+		},
+		"display form with captcha after it was required for the username": {
+			CSRF:    &csrf.FakeCSRF{Token: "test"},
+			Auth:    &testAuth{},
+			Captcha: captcha.NewGuard(&testChallenge{}, 5, time.Minute),
+			Path:    "/login?then=%2F&reason=captcha_required",
+
+			ExpectStatusCode: 200,
+			ExpectContains: []string{
+				`data-sitekey="mysitekey"`,
+				`Please complete the verification and try again.`,
+			},
+		},
+		"unsolved captcha": {
+			CSRF:    &csrf.FakeCSRF{Token: "test"},
+			Auth:    &testAuth{Success: true, User: &user.DefaultInfo{Name: "user"}},
+			Captcha: captcha.NewGuard(&testChallenge{}, 0, time.Minute),
+			Path:    "/login",
+			PostValues: url.Values{
+				"csrf":     []string{"test"},
+				"username": []string{"user"},
+				"password": []string{"pass"},
+				"then":     []string{"/anotherurl"},
+			},
+			ExpectRedirect: "/login?reason=captcha_required&then=%2Fanotherurl",
+		},
+		"login successful with solved captcha": {
+			CSRF:    &csrf.FakeCSRF{Token: "test"},
+			Auth:    &testAuth{Success: true, User: &user.DefaultInfo{Name: "user"}},
+			Captcha: captcha.NewGuard(&testChallenge{Solved: true}, 0, time.Minute),
+			Path:    "/login?then=%2Fdone",
+			PostValues: url.Values{
+				"csrf":     []string{"test"},
+				"username": []string{"user"},
+				"password": []string{"pass"},
+			},
+			ExpectThen: "/done",
+		},
 		"login successful": {
 			CSRF: &csrf.FakeCSRF{Token: "test"},
 			Auth: &testAuth{Success: true, User: &user.DefaultInfo{Name: "user"}},
@@ -197,6 +325,18 @@ func TestLogin(t *testing.T) {
 			},
 			ExpectThen: "/done",
 		},
+		"login successful with allowed then": {
+			CSRF:      &csrf.FakeCSRF{Token: "test"},
+			Auth:      &testAuth{Success: true, User: &user.DefaultInfo{Name: "user"}},
+			Redirects: redirect.NewValidator([]string{"/oauth/authorize"}, 0),
+			Path:      "/login?then=%2Foauth%2Fauthorize%3Fclient_id%3Dconsole",
+			PostValues: url.Values{
+				"csrf":     []string{"test"},
+				"username": []string{"user"},
+				"password": []string{"pass"},
+			},
+			ExpectThen: "/oauth/authorize?client_id=console",
+		},
 	}
 
 	for k, testCase := range testCases {
@@ -205,7 +345,7 @@ func TestLogin(t *testing.T) {
 			t.Errorf("%s: unexpected error: %v", k, err)
 			continue
 		}
-		server := httptest.NewServer(NewLogin("myprovider", testCase.CSRF, testCase.Auth, loginFormRenderer))
+		server := httptest.NewServer(NewLogin("myprovider", testCase.CSRF, testCase.Auth, loginFormRenderer, testCase.Captcha, testCase.Redirects))
 
 		var resp *http.Response
 		if testCase.PostValues != nil {
@@ -241,6 +381,10 @@ func TestLogin(t *testing.T) {
 			}
 		}
 
+		if testCase.ExpectRedirect != "" && testCase.Auth.Called {
+			t.Errorf("%s: unexpected login", k)
+		}
+
 		if testCase.ExpectThen != "" && (!testCase.Auth.Called || testCase.Auth.Then != testCase.ExpectThen) {
 			t.Errorf("%s: did not find expected 'then' value: %#v", k, testCase.Auth)
 		}
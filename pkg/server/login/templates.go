@@ -7,7 +7,7 @@ import (
 var defaultLoginTemplate = template.Must(template.New("defaultLoginForm").Parse(defaultLoginTemplateString))
 
 const defaultLoginTemplateString = `<!DOCTYPE html>
-<html lang="en-us" data-test-id="login">
+<html lang="{{ or .Locale.Lang "en" }}" data-test-id="login">
   <head>
     <title>{{ .Locale.LogIn }} . OKD</title>
     <meta http-equiv="X-UA-Compatible" content="IE=edge">
@@ -260,6 +260,9 @@ select.pf-c-form-control.pf-m-success { --pf-c-form-control--PaddingRight: var(-
                   </svg>
                   {{ .Error }}
                 </p>
+                {{ if .CorrelationID }}
+                <p class="pf-c-form__helper-text">{{ .Locale.Reference }}: {{ .CorrelationID }}</p>
+                {{ end }}
                 {{ end }}
               </div>
               <div class="pf-c-form__group">
@@ -276,6 +279,19 @@ select.pf-c-form-control.pf-m-success { --pf-c-form-control--PaddingRight: var(-
                 </label>
                 <input type="password" class="pf-c-form-control" id="inputPassword" placeholder="" tabindex="2" type="password" name="{{ .Names.Password }}" value="">
               </div>
+              {{ with .Captcha }}
+              <div class="pf-c-form__group">
+                {{ if eq .Type "proofofwork" }}
+                <script src="{{ .ScriptURL }}" defer></script>
+                <input type="hidden" name="{{ .ChallengeName }}" value="{{ .Challenge }}" data-proof-of-work-nonce="{{ .NonceName }}" data-proof-of-work-difficulty="{{ .Difficulty }}">
+                <input type="hidden" name="{{ .NonceName }}" value="">
+                <noscript><p class="pf-c-form__helper-text pf-m-error">{{ $.Locale.EnableJavaScriptToLogIn }}</p></noscript>
+                {{ else }}
+                <script src="{{ .ScriptURL }}" async defer></script>
+                <div class="{{ if eq .Type "hcaptcha" }}h-captcha{{ else }}g-recaptcha{{ end }}" data-sitekey="{{ .SiteKey }}"></div>
+                {{ end }}
+              </div>
+              {{ end }}
               <div class="pf-c-form__group pf-m-action">
                 <button class="pf-c-button pf-m-primary pf-m-block" type="submit" tabindex="3">{{ .Locale.LogIn }}</button>
               </div>
@@ -0,0 +1,142 @@
+package logout
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	userclient "github.com/openshift/client-go/user/clientset/versioned/typed/user/v1"
+
+	"github.com/openshift/oauth-server/pkg"
+	authapi "github.com/openshift/oauth-server/pkg/api"
+	"github.com/openshift/oauth-server/pkg/deprovisioning"
+	"github.com/openshift/oauth-server/pkg/oauth/external/openid"
+)
+
+const (
+	logoutTokenParam = "logout_token"
+
+	// logoutTokenMaxAge is how long logout tokens are accepted after they were issued,
+	// their IDs are remembered as long to reject replayed tokens
+	logoutTokenMaxAge = 10 * time.Minute
+	// logoutTokenClockSkew is how far in the future logout tokens may be issued
+	logoutTokenClockSkew = time.Minute
+)
+
+// NewBackChannelLogout returns the back-channel logout endpoint of an OpenID identity provider
+// https://openid.net/specs/openid-connect-backchannel-1_0.html
+// The provider posts logout tokens when users log out or are disabled at the provider. All sessions
+// and tokens of the user the identity named by the sub claim is mapped to are revoked. Sessions are
+// not indexed by the sid claim, so logout tokens without a sub claim are rejected.
+func NewBackChannelLogout(providerName string, verifier openid.LogoutTokenVerifier, identities userclient.IdentityInterface, revoker *deprovisioning.Revoker) oauthserver.Endpoints {
+	return &backChannelLogout{
+		providerName: providerName,
+		verifier:     verifier,
+		identities:   identities,
+		revoker:      revoker,
+		seen:         map[string]time.Time{},
+	}
+}
+
+type backChannelLogout struct {
+	providerName string
+	verifier     openid.LogoutTokenVerifier
+	identities   userclient.IdentityInterface
+	revoker      *deprovisioning.Revoker
+
+	// seen holds the IDs of accepted logout tokens and when they were issued
+	lock sync.Mutex
+	seen map[string]time.Time
+}
+
+func (b *backChannelLogout) Install(mux oauthserver.Mux, prefix string) {
+	mux.Handle(prefix, b)
+}
+
+func (b *backChannelLogout) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+
+	logoutToken, err := b.verifier.VerifyLogoutToken(req.PostFormValue(logoutTokenParam))
+	if err != nil {
+		klog.V(4).Infof("invalid logout token from identity provider %q: %v", b.providerName, err)
+		http.Error(w, "invalid logout token", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	if now.Sub(logoutToken.IssuedAt) > logoutTokenMaxAge || logoutToken.IssuedAt.Sub(now) > logoutTokenClockSkew {
+		klog.V(4).Infof("logout token from identity provider %q issued at %v is not recent", b.providerName, logoutToken.IssuedAt)
+		http.Error(w, "invalid logout token", http.StatusBadRequest)
+		return
+	}
+	if b.replayed(logoutToken.ID, now) {
+		klog.V(4).Infof("replayed logout token %q from identity provider %q", logoutToken.ID, b.providerName)
+		http.Error(w, "invalid logout token", http.StatusBadRequest)
+		return
+	}
+	if len(logoutToken.Subject) == 0 {
+		klog.V(4).Infof("logout token from identity provider %q without sub claim is not supported", b.providerName)
+		http.Error(w, "logout tokens without sub claim are not supported", http.StatusBadRequest)
+		return
+	}
+
+	if err := b.logout(logoutToken.Subject); err != nil {
+		klog.Errorf("error processing logout token from identity provider %q: %v", b.providerName, err)
+		http.Error(w, "failed to log out", http.StatusInternalServerError)
+		return
+	}
+	b.remember(logoutToken.ID, logoutToken.IssuedAt)
+}
+
+// logout revokes the sessions and tokens of the user the identity of subject is mapped to
+func (b *backChannelLogout) logout(subject string) error {
+	identityName := authapi.NewDefaultUserIdentityInfo(b.providerName, subject).GetIdentityName()
+	identity, err := b.identities.Get(context.TODO(), identityName, metav1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		// the user never logged in, there is nothing to revoke
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(identity.User.Name) == 0 || len(identity.User.UID) == 0 {
+		return nil
+	}
+
+	klog.V(4).Infof("identity provider %q logged out identity %q of user %q", b.providerName, identityName, identity.User.Name)
+	return b.revoker.RevokeUser(identity.User.Name, string(identity.User.UID))
+}
+
+// replayed returns true if a logout token with the given ID was accepted before. Tokens without
+// an ID cannot be told apart from replayed ones, they are treated as replayed.
+func (b *backChannelLogout) replayed(id string, now time.Time) bool {
+	if len(id) == 0 {
+		return true
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for seenID, issuedAt := range b.seen {
+		if now.Sub(issuedAt) > logoutTokenMaxAge {
+			delete(b.seen, seenID)
+		}
+	}
+	_, ok := b.seen[id]
+	return ok
+}
+
+func (b *backChannelLogout) remember(id string, issuedAt time.Time) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.seen[id] = issuedAt
+}
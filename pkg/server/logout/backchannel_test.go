@@ -0,0 +1,148 @@
+package logout
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	oauthv1 "github.com/openshift/api/oauth/v1"
+	userv1 "github.com/openshift/api/user/v1"
+	oauthfake "github.com/openshift/client-go/oauth/clientset/versioned/fake"
+	userfake "github.com/openshift/client-go/user/clientset/versioned/fake"
+
+	"github.com/openshift/oauth-server/pkg/deprovisioning"
+	"github.com/openshift/oauth-server/pkg/oauth/external/openid"
+	"github.com/openshift/oauth-server/pkg/server/session"
+)
+
+// fakeVerifier accepts logout tokens of the form subject/sid/jti
+type fakeVerifier struct {
+	issuedAt time.Time
+}
+
+func (v fakeVerifier) VerifyLogoutToken(logoutToken string) (*openid.LogoutToken, error) {
+	parts := strings.Split(logoutToken, "/")
+	if len(parts) != 3 {
+		return nil, errors.New("invalid logout token")
+	}
+	return &openid.LogoutToken{Subject: parts[0], SessionID: parts[1], ID: parts[2], IssuedAt: v.issuedAt}, nil
+}
+
+func TestBackChannelLogout(t *testing.T) {
+	tests := []struct {
+		name         string
+		method       string
+		logoutTokens []string
+		issuedAt     time.Time
+
+		expectedCodes []int
+		expectRevoked bool
+	}{
+		{
+			name:          "revokes user",
+			logoutTokens:  []string{"alice//1"},
+			expectedCodes: []int{http.StatusOK},
+			expectRevoked: true,
+		},
+		{
+			name:          "replayed token",
+			logoutTokens:  []string{"alice//1", "alice//1"},
+			expectedCodes: []int{http.StatusOK, http.StatusBadRequest},
+			expectRevoked: true,
+		},
+		{
+			name:          "token without ID",
+			logoutTokens:  []string{"alice//"},
+			expectedCodes: []int{http.StatusBadRequest},
+		},
+		{
+			name:          "unknown identity",
+			logoutTokens:  []string{"bob//1"},
+			expectedCodes: []int{http.StatusOK},
+		},
+		{
+			name:          "session only",
+			logoutTokens:  []string{"/session/1"},
+			expectedCodes: []int{http.StatusBadRequest},
+		},
+		{
+			name:          "invalid token",
+			logoutTokens:  []string{"invalid"},
+			expectedCodes: []int{http.StatusBadRequest},
+		},
+		{
+			name:          "old token",
+			logoutTokens:  []string{"alice//1"},
+			issuedAt:      time.Now().Add(-time.Hour),
+			expectedCodes: []int{http.StatusBadRequest},
+		},
+		{
+			name:          "GET",
+			method:        http.MethodGet,
+			logoutTokens:  []string{"alice//1"},
+			expectedCodes: []int{http.StatusMethodNotAllowed},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userClient := userfake.NewSimpleClientset(&userv1.Identity{
+				ObjectMeta:       metav1.ObjectMeta{Name: "idp:alice"},
+				ProviderName:     "idp",
+				ProviderUserName: "alice",
+				User:             corev1.ObjectReference{Name: "alice", UID: "alice-uid"},
+			})
+			oauthClient := oauthfake.NewSimpleClientset(&oauthv1.OAuthAccessToken{
+				ObjectMeta: metav1.ObjectMeta{Name: "sha256~token"},
+				UserName:   "alice",
+				UserUID:    "alice-uid",
+			})
+			backend := session.NewMemoryBackend()
+			revocations := session.NewSharedRevocations(time.Hour, backend)
+			sessionIssuedAt := time.Now().Add(-time.Second)
+			revoker := deprovisioning.NewRevoker(oauthClient.OauthV1().OAuthAccessTokens(), oauthClient.OauthV1().OAuthAuthorizeTokens(), revocations)
+
+			issuedAt := tt.issuedAt
+			if issuedAt.IsZero() {
+				issuedAt = time.Now()
+			}
+			handler := NewBackChannelLogout("idp", fakeVerifier{issuedAt: issuedAt}, userClient.UserV1().Identities(), revoker).(*backChannelLogout)
+
+			method := tt.method
+			if len(method) == 0 {
+				method = http.MethodPost
+			}
+			for i, logoutToken := range tt.logoutTokens {
+				req := httptest.NewRequest(method, "/logout/backchannel/idp", strings.NewReader(url.Values{logoutTokenParam: {logoutToken}}.Encode()))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, req)
+				if w.Code != tt.expectedCodes[i] {
+					t.Errorf("expected status %d for logout token %d, got %d: %s", tt.expectedCodes[i], i, w.Code, w.Body.String())
+				}
+			}
+
+			tokens, err := oauthClient.OauthV1().OAuthAccessTokens().List(context.TODO(), metav1.ListOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if revoked := len(tokens.Items) == 0; revoked != tt.expectRevoked {
+				t.Errorf("expected tokens revoked %v, got %v", tt.expectRevoked, revoked)
+			}
+			if revoked := revocations.Revoked("alice-uid", sessionIssuedAt); revoked != tt.expectRevoked {
+				t.Errorf("expected sessions revoked %v, got %v", tt.expectRevoked, revoked)
+			}
+			// other servers sharing the session storage reject the sessions as well
+			if revoked := session.NewSharedRevocations(time.Hour, backend).Revoked("alice-uid", sessionIssuedAt); revoked != tt.expectRevoked {
+				t.Errorf("expected sessions revoked on other servers %v, got %v", tt.expectRevoked, revoked)
+			}
+		})
+	}
+}
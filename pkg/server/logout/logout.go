@@ -1,30 +1,57 @@
 package logout
 
 import (
+	"context"
 	"net/http"
+	"net/url"
+	"strings"
 
 	"github.com/openshift/osin"
 	"k8s.io/klog/v2"
 
-	"k8s.io/apiserver/pkg/authentication/user"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	oauthclient "github.com/openshift/client-go/oauth/clientset/versioned/typed/oauth/v1"
 
 	"github.com/openshift/oauth-server/pkg"
+	"github.com/openshift/oauth-server/pkg/osinserver/registrystorage"
 	"github.com/openshift/oauth-server/pkg/server/redirect"
 	"github.com/openshift/oauth-server/pkg/server/session"
 )
 
-const thenParam = "then"
+const (
+	thenParam = "then"
+	// tokenParam optionally carries an access token of the browser that is revoked
+	tokenParam = "token"
+)
+
+// ProviderLogout describes how to end the session of a user at an identity provider
+// https://openid.net/specs/openid-connect-rpinitiated-1_0.html
+type ProviderLogout struct {
+	// EndSessionURL is the end session endpoint of the provider
+	EndSessionURL string
+	// ClientID is the client ID of the oauth-server at the provider
+	ClientID string
+}
 
-func NewLogout(invalidator session.SessionInvalidator, redirect string) oauthserver.Endpoints {
+// NewLogout returns the logout endpoint. It removes the session of the browser and revokes the access token
+// passed as bearer token or in the token parameter, if any. If the user logged in with an identity provider in
+// providers, the browser is redirected to the provider to end the session there, too.
+func NewLogout(terminator session.SessionTerminator, redirect string, accessTokens oauthclient.OAuthAccessTokenInterface, providers map[string]ProviderLogout) oauthserver.Endpoints {
 	return &logout{
-		invalidator: invalidator,
-		redirect:    redirect,
+		terminator:   terminator,
+		redirect:     redirect,
+		accessTokens: accessTokens,
+		providers:    providers,
 	}
 }
 
 type logout struct {
-	invalidator session.SessionInvalidator
-	redirect    string
+	terminator   session.SessionTerminator
+	redirect     string
+	accessTokens oauthclient.OAuthAccessTokenInterface
+	providers    map[string]ProviderLogout
 }
 
 func (l *logout) Install(mux oauthserver.Mux, prefix string) {
@@ -43,20 +70,83 @@ func (l *logout) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	// invalidate with empty user to force session removal
-	if err := l.invalidator.InvalidateAuthentication(w, &user.DefaultInfo{}); err != nil {
+	// possession of the token is sufficient to revoke it
+	if token := l.token(req); len(token) > 0 && l.accessTokens != nil {
+		err := l.accessTokens.Delete(context.TODO(), registrystorage.TokenToObjectName(token), metav1.DeleteOptions{})
+		if err != nil && !kerrors.IsNotFound(err) {
+			klog.V(5).Infof("error revoking access token on logout: %v", err)
+			http.Error(w, "failed to log out", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	providerSession, err := l.terminator.Logout(w, req)
+	if err != nil {
 		klog.V(5).Infof("error logging out: %v", err)
 		http.Error(w, "failed to log out", http.StatusInternalServerError)
 		return
 	}
 
+	then := req.FormValue(thenParam)
+	if !l.isValidRedirect(then) {
+		then = ""
+	}
+
+	// end the session at the identity provider, which redirects back afterwards
+	if providerSession != nil {
+		if providerLogout, ok := l.providers[providerSession.Provider]; ok {
+			http.Redirect(w, req, l.endSessionURL(providerLogout, providerSession, then), http.StatusFound)
+			return
+		}
+	}
+
 	// optionally redirect if safe to do so
-	if then := req.FormValue(thenParam); l.isValidRedirect(then) {
+	if len(then) > 0 {
 		http.Redirect(w, req, then, http.StatusFound)
 		return
 	}
 }
 
+// token returns the access token passed as bearer token or form value
+func (l *logout) token(req *http.Request) string {
+	if auth := strings.TrimSpace(req.Header.Get("Authorization")); len(auth) > 7 && strings.EqualFold(auth[:7], "bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return req.PostFormValue(tokenParam)
+}
+
+// endSessionURL returns the end session request for the provider. The provider redirects to the
+// absolute then URL or the default redirect afterwards, which must be registered at the provider.
+func (l *logout) endSessionURL(providerLogout ProviderLogout, providerSession *session.ProviderSession, then string) string {
+	postLogoutRedirect := l.redirect
+	if u, err := url.Parse(then); err == nil && u.IsAbs() {
+		postLogoutRedirect = then
+	}
+
+	params := url.Values{}
+	if len(providerSession.IDToken) > 0 {
+		params.Set("id_token_hint", providerSession.IDToken)
+	}
+	if len(providerLogout.ClientID) > 0 {
+		params.Set("client_id", providerLogout.ClientID)
+	}
+	if len(postLogoutRedirect) > 0 {
+		params.Set("post_logout_redirect_uri", postLogoutRedirect)
+	}
+
+	endSessionURL, err := url.Parse(providerLogout.EndSessionURL)
+	if err != nil {
+		// the URL was validated when the provider was configured
+		return providerLogout.EndSessionURL
+	}
+	query := endSessionURL.Query()
+	for k, v := range params {
+		query[k] = v
+	}
+	endSessionURL.RawQuery = query.Encode()
+	return endSessionURL.String()
+}
+
 func (l *logout) isValidRedirect(then string) bool {
 	if redirect.IsServerRelativeURL(then) {
 		return true
@@ -0,0 +1,173 @@
+package logout
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apiserver/pkg/authentication/user"
+
+	oauthv1 "github.com/openshift/api/oauth/v1"
+	oauthfake "github.com/openshift/client-go/oauth/clientset/versioned/fake"
+
+	"github.com/openshift/oauth-server/pkg/osinserver/registrystorage"
+	"github.com/openshift/oauth-server/pkg/server/cookies"
+	"github.com/openshift/oauth-server/pkg/server/session"
+)
+
+const (
+	consoleURL = "https://console.example.com/"
+	token      = "sha256~logouttoken"
+)
+
+func TestLogout(t *testing.T) {
+	providers := map[string]ProviderLogout{
+		"oidc": {EndSessionURL: "https://idp.example.com/logout?tenant=a", ClientID: "oauth-server"},
+	}
+
+	tests := []struct {
+		name            string
+		providerSession *session.ProviderSession
+		then            string
+		bearer          bool
+
+		expectedLocation string
+		expectedQuery    url.Values
+		expectRevoked    bool
+	}{
+		{
+			name:             "no provider session",
+			then:             consoleURL + "logged-out",
+			expectedLocation: consoleURL + "logged-out",
+		},
+		{
+			name:             "provider without end session endpoint",
+			providerSession:  &session.ProviderSession{Provider: "github"},
+			then:             "/login",
+			expectedLocation: "/login",
+		},
+		{
+			name:             "provider session",
+			providerSession:  &session.ProviderSession{Provider: "oidc", IDToken: "idtoken"},
+			then:             consoleURL + "logged-out",
+			expectedLocation: "https://idp.example.com/logout",
+			expectedQuery: url.Values{
+				"tenant":                   {"a"},
+				"id_token_hint":            {"idtoken"},
+				"client_id":                {"oauth-server"},
+				"post_logout_redirect_uri": {consoleURL + "logged-out"},
+			},
+		},
+		{
+			name:             "provider session with relative then",
+			providerSession:  &session.ProviderSession{Provider: "oidc", IDToken: "idtoken"},
+			then:             "/login",
+			expectedLocation: "https://idp.example.com/logout",
+			expectedQuery: url.Values{
+				"tenant":                   {"a"},
+				"id_token_hint":            {"idtoken"},
+				"client_id":                {"oauth-server"},
+				"post_logout_redirect_uri": {consoleURL},
+			},
+		},
+		{
+			name:             "invalid then",
+			then:             "https://evil.example.com/",
+			expectedLocation: "",
+		},
+		{
+			name:             "revoke bearer token",
+			then:             "/login",
+			bearer:           true,
+			expectedLocation: "/login",
+			expectRevoked:    true,
+		},
+		{
+			name:             "revoke token parameter",
+			then:             "/login",
+			expectedLocation: "/login",
+			expectRevoked:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := session.NewStore("ssn", cookies.Options{Secure: true}, session.NewKeys([]byte("0123456789abcdef0123456789abcdef")))
+			authenticator := session.NewAuthenticator(store, time.Hour, nil)
+			accessTokens := oauthfake.NewSimpleClientset(&oauthv1.OAuthAccessToken{
+				ObjectMeta: metav1.ObjectMeta{Name: registrystorage.TokenToObjectName(token)},
+			}).OauthV1().OAuthAccessTokens()
+
+			// log in
+			loginReq := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.providerSession != nil {
+				loginReq = session.WithProviderSession(loginReq, *tt.providerSession)
+			}
+			w := httptest.NewRecorder()
+			if _, err := authenticator.AuthenticationSucceeded(&user.DefaultInfo{Name: "bob", UID: "bob-uid"}, "", w, loginReq); err != nil {
+				t.Fatal(err)
+			}
+
+			form := url.Values{thenParam: {tt.then}}
+			if tt.expectRevoked && !tt.bearer {
+				form.Set(tokenParam, token)
+			}
+			req := httptest.NewRequest(http.MethodPost, "/logout", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.bearer {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+			for _, cookie := range w.Result().Cookies() {
+				req.AddCookie(cookie)
+			}
+
+			w = httptest.NewRecorder()
+			NewLogout(authenticator, consoleURL, accessTokens, providers).(*logout).ServeHTTP(w, req)
+
+			location := w.Header().Get("Location")
+			if len(tt.expectedLocation) == 0 {
+				if w.Code != http.StatusOK || len(location) > 0 {
+					t.Errorf("expected no redirect, got %d %q", w.Code, location)
+				}
+			} else {
+				if w.Code != http.StatusFound {
+					t.Fatalf("expected redirect, got %d", w.Code)
+				}
+				u, err := url.Parse(location)
+				if err != nil {
+					t.Fatal(err)
+				}
+				query := u.Query()
+				u.RawQuery = ""
+				if u.String() != tt.expectedLocation {
+					t.Errorf("expected redirect to %q, got %q", tt.expectedLocation, location)
+				}
+				if tt.expectedQuery != nil && query.Encode() != tt.expectedQuery.Encode() {
+					t.Errorf("expected query %q, got %q", tt.expectedQuery.Encode(), query.Encode())
+				}
+			}
+
+			_, err := accessTokens.Get(context.TODO(), registrystorage.TokenToObjectName(token), metav1.GetOptions{})
+			if revoked := kerrors.IsNotFound(err); revoked != tt.expectRevoked {
+				t.Errorf("expected token revoked %v, got %v (%v)", tt.expectRevoked, revoked, err)
+			}
+
+			// the session is removed, including the provider session
+			loggedOut := httptest.NewRequest(http.MethodGet, "/", nil)
+			for _, cookie := range w.Result().Cookies() {
+				loggedOut.AddCookie(cookie)
+			}
+			if _, ok, _ := authenticator.AuthenticateRequest(loggedOut); ok {
+				t.Error("expected session to be removed")
+			}
+			if providerSession, err := authenticator.Logout(httptest.NewRecorder(), loggedOut); err != nil || providerSession != nil {
+				t.Errorf("expected provider session to be removed, got %v %v", providerSession, err)
+			}
+		})
+	}
+}
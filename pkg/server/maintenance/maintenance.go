@@ -0,0 +1,194 @@
+// Package maintenance turns away new logins while operators work on the identity providers, e.g. to migrate users to
+// another one, with a page that tells users that logins are disabled instead of the errors of providers that are
+// being reconfigured. Users that are logged in are still served, as are token, introspection and revocation requests.
+package maintenance
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/klog/v2"
+
+	"github.com/openshift/library-go/pkg/apiserver/httprequest"
+
+	"github.com/openshift/oauth-server/pkg/server/locales"
+)
+
+// Page holds the fields of the maintenance page
+type Page struct {
+	// Message tells users why logins are disabled, it is the configured message or the built-in one
+	Message string
+	// RetryAfter is how long users are asked to wait before they try again
+	RetryAfter time.Duration
+	Locale     locales.Localization
+}
+
+// Status is the response of the endpoint
+type Status struct {
+	Enabled bool `json:"enabled"`
+	// Since is when the maintenance started, if it is enabled
+	Since *time.Time `json:"since,omitempty"`
+	// Message is the configured message, the built-in one is shown if it is empty
+	Message string `json:"message,omitempty"`
+}
+
+// Mode is the maintenance mode of the server. It is turned on and off by configuring it again, e.g. when the
+// configuration is reloaded, so that all servers that read the same configuration agree on it.
+type Mode struct {
+	lock       sync.RWMutex
+	enabled    bool
+	since      time.Time
+	message    string
+	retryAfter time.Duration
+	page       *template.Template
+}
+
+// NewMode returns a maintenance mode that shows the template file, or the built-in page if templateFile is empty.
+// Rejected requests are asked to retry after retryAfter.
+func NewMode(enabled bool, message string, retryAfter time.Duration, templateFile string) (*Mode, error) {
+	m := &Mode{}
+	if err := m.Configure(enabled, message, retryAfter, templateFile); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Configure replaces all settings of the mode, e.g. when the configuration is reloaded
+func (m *Mode) Configure(enabled bool, message string, retryAfter time.Duration, templateFile string) error {
+	page := defaultMaintenanceTemplate
+	if len(templateFile) > 0 {
+		content, err := ioutil.ReadFile(templateFile)
+		if err != nil {
+			return err
+		}
+		if errs := ValidateMaintenanceTemplate(content); len(errs) > 0 {
+			return fmt.Errorf("maintenance template %s: %v", templateFile, utilerrors.NewAggregate(errs))
+		}
+		if page, err = template.New(filepath.Base(templateFile)).Parse(string(content)); err != nil {
+			return err
+		}
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.page = page
+	m.message = message
+	m.retryAfter = retryAfter
+	m.setEnabled(enabled)
+	return nil
+}
+
+// setEnabled turns the mode on or off, the lock must be held
+func (m *Mode) setEnabled(enabled bool) {
+	if enabled && !m.enabled {
+		m.since = time.Now()
+	}
+	m.enabled = enabled
+}
+
+// Enabled returns true while new logins are turned away
+func (m *Mode) Enabled() bool {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.enabled
+}
+
+// Status returns whether the mode is enabled and since when
+func (m *Mode) Status() Status {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	status := Status{Enabled: m.enabled, Message: m.message}
+	if m.enabled {
+		since := m.since
+		status.Since = &since
+	}
+	return status
+}
+
+// ServeHTTP returns the status of the mode on GET. The mode is not changed through the endpoint, a change would
+// only apply to the server that received it.
+func (m *Mode) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Method not allowed, the maintenance mode is changed in the configuration", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(m.Status()); err != nil {
+		utilruntime.HandleError(fmt.Errorf("unable to write the maintenance mode: %v", err))
+	}
+}
+
+// WithMode answers the requests that startsLogin returns true for with the maintenance page while the mode is
+// enabled. All other requests are served, e.g. the token requests and the callbacks of logins that started before.
+func WithMode(handler http.Handler, mode *Mode, startsLogin func(*http.Request) bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if mode.Enabled() && startsLogin(req) {
+			klog.V(4).Infof("Rejecting new login %s during maintenance", req.URL.Path)
+			mode.reject(w, req)
+			return
+		}
+		handler.ServeHTTP(w, req)
+	})
+}
+
+// reject writes the maintenance page to browsers and the message to other clients, e.g. oc login
+func (m *Mode) reject(w http.ResponseWriter, req *http.Request) {
+	m.lock.RLock()
+	page := Page{Message: m.message, RetryAfter: m.retryAfter, Locale: locales.ForRequest(req)}
+	tmpl := m.page
+	m.lock.RUnlock()
+	if len(page.Message) == 0 {
+		page.Message = page.Locale["LoginsAreDisabledForMaintenance"]
+	}
+
+	if page.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(page.RetryAfter.Seconds())))
+	}
+	if !httprequest.PrefersHTML(req) {
+		http.Error(w, page.Message, http.StatusServiceUnavailable)
+		return
+	}
+
+	// rendered before the status is written, so that a failing custom template does not leave an empty page
+	var buffer bytes.Buffer
+	if err := tmpl.Execute(&buffer, page); err != nil {
+		utilruntime.HandleError(fmt.Errorf("unable to render the maintenance page: %v", err))
+		http.Error(w, page.Message, http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	if _, err := w.Write(buffer.Bytes()); err != nil {
+		utilruntime.HandleError(fmt.Errorf("unable to write the maintenance page: %v", err))
+	}
+}
+
+// ValidateMaintenanceTemplate ensures the given template renders the message of the page
+func ValidateMaintenanceTemplate(templateContent []byte) []error {
+	tmpl, err := template.New("maintenanceTemplateTest").Parse(string(templateContent))
+	if err != nil {
+		return []error{err}
+	}
+
+	page := Page{Message: "MyMessage", RetryAfter: time.Minute, Locale: locales.GetLocale("")}
+	var buffer bytes.Buffer
+	if err := tmpl.Execute(&buffer, page); err != nil {
+		return []error{err}
+	}
+	if !bytes.Contains(buffer.Bytes(), []byte(page.Message)) {
+		return []error{fmt.Errorf("template is missing parameter {{ .Message }}")}
+	}
+	return nil
+}
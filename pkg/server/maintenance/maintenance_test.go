@@ -0,0 +1,111 @@
+package maintenance
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWithMode(t *testing.T) {
+	mode, err := NewMode(false, "", time.Minute, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := WithMode(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}), mode, func(req *http.Request) bool {
+		return strings.HasPrefix(req.URL.Path, "/oauth/authorize")
+	})
+	serve := func(path, accept string) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Accept", accept)
+		handler.ServeHTTP(resp, req)
+		return resp
+	}
+	toggle := func(enabled bool, message string) (int, Status) {
+		if err := mode.Configure(enabled, message, time.Minute, ""); err != nil {
+			t.Fatal(err)
+		}
+		resp := httptest.NewRecorder()
+		mode.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/debug/maintenance", nil))
+		status := Status{}
+		if resp.Code == http.StatusOK {
+			if err := json.Unmarshal(resp.Body.Bytes(), &status); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return resp.Code, status
+	}
+
+	if resp := serve("/oauth/authorize", "text/html"); resp.Code != http.StatusOK {
+		t.Errorf("expected logins to start without maintenance, got %d", resp.Code)
+	}
+
+	// changes through the endpoint would only apply to one server
+	resp := httptest.NewRecorder()
+	mode.ServeHTTP(resp, httptest.NewRequest(http.MethodPut, "/debug/maintenance?enabled=true", nil))
+	if resp.Code != http.StatusMethodNotAllowed || mode.Enabled() {
+		t.Errorf("expected the mode not to be changed through the endpoint, got %d", resp.Code)
+	}
+	if code, status := toggle(true, ""); code != http.StatusOK || !status.Enabled || status.Since == nil {
+		t.Fatalf("expected the maintenance to be enabled, got %d %+v", code, status)
+	}
+	resp = serve("/oauth/authorize", "text/html")
+	if resp.Code != http.StatusServiceUnavailable || resp.Header().Get("Retry-After") != "60" || !strings.Contains(resp.Body.String(), "Logins are disabled for maintenance.") {
+		t.Errorf("expected the maintenance page, got %d %v %s", resp.Code, resp.Header(), resp.Body.String())
+	}
+	if resp := serve("/oauth/authorize?lang=ja", "text/html"); !strings.Contains(resp.Body.String(), "メンテナンスのため") {
+		t.Errorf("expected the localized maintenance page, got %s", resp.Body.String())
+	}
+	if resp := serve("/oauth/token", "application/json"); resp.Code != http.StatusOK {
+		t.Errorf("expected token requests to be served during maintenance, got %d", resp.Code)
+	}
+
+	if code, status := toggle(true, "Back at noon."); code != http.StatusOK || status.Message != "Back at noon." {
+		t.Fatalf("expected the message to be replaced, got %d %+v", code, status)
+	}
+	resp = serve("/oauth/authorize", "*/*")
+	if resp.Code != http.StatusServiceUnavailable || strings.TrimSpace(resp.Body.String()) != "Back at noon." {
+		t.Errorf("expected the message for clients that are not browsers, got %d %s", resp.Code, resp.Body.String())
+	}
+
+	if code, status := toggle(false, ""); code != http.StatusOK || status.Enabled || status.Since != nil {
+		t.Fatalf("expected the maintenance to be disabled, got %d %+v", code, status)
+	}
+	if resp := serve("/oauth/authorize", "text/html"); resp.Code != http.StatusOK {
+		t.Errorf("expected logins to start after the maintenance, got %d", resp.Code)
+	}
+}
+
+func TestMaintenanceTemplate(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		file := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+
+	if _, err := NewMode(true, "", 0, write("missing-message.html", "<p>{{ .Locale.LoginsAreTemporarilyDisabled }}</p>")); err == nil {
+		t.Error("expected a template without the message to be rejected")
+	}
+
+	mode, err := NewMode(true, "Migrating users.", 0, write("custom.html", "<p class=custom>{{ .Message }}</p>"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/login", nil)
+	req.Header.Set("Accept", "text/html")
+	mode.reject(resp, req)
+	if resp.Code != http.StatusServiceUnavailable || len(resp.Header().Get("Retry-After")) > 0 || resp.Body.String() != "<p class=custom>Migrating users.</p>" {
+		t.Errorf("expected the custom page, got %d %v %s", resp.Code, resp.Header(), resp.Body.String())
+	}
+}
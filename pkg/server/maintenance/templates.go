@@ -0,0 +1,37 @@
+package maintenance
+
+import "html/template"
+
+var defaultMaintenanceTemplate = template.Must(template.New("defaultMaintenancePage").Parse(defaultMaintenanceTemplateString))
+
+const defaultMaintenanceTemplateString = `<!DOCTYPE html>
+
+<html lang="{{ or .Locale.Lang "en" }}">
+
+<head>
+    <meta charset="UTF-8">
+    <meta http-equiv="X-UA-Compatible" content="IE=edge">
+    <title>{{ .Locale.LogInToYourAccount }}</title>
+    <style>
+        body    { font-family: sans-serif; line-height: 1.2em; margin: 2em 5%; color: #363636; }
+
+        h1 { font-weight: normal; line-height: 1.3em; }
+
+        .message { white-space: pre-wrap; max-width: 600px; }
+
+        @media (max-width:481px) {
+          body { margin: .5em; }
+          h1 { margin: 0; padding-bottom: .5em; font-size: 1.5em; }
+        }
+    </style>
+</head>
+
+<body>
+
+<h1>{{ .Locale.LoginsAreTemporarilyDisabled }}</h1>
+
+<div class="message">{{ .Message }}</div>
+
+</body>
+</html>
+`
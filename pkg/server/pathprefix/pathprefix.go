@@ -0,0 +1,89 @@
+// Package pathprefix serves the endpoints of the server below a path prefix, for ingresses that route a path of
+// their host to the server and may or may not remove the prefix on the way.
+package pathprefix
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+type prefixKeyType int
+
+const prefixKey prefixKeyType = iota
+
+// WithPathPrefix removes prefix from the paths of requests, so that the handlers that follow serve them as if there
+// was no prefix, and adds it to the server-relative Location headers of responses. Requests without prefix are
+// served as well, for ingresses that remove it. The request URI is set to the URI under the prefix, which pages
+// post their forms to, and the prefix is stored for External.
+func WithPathPrefix(handler http.Handler, prefix string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if trimmed, ok := trimPrefix(req.URL.Path, prefix); ok {
+			req.URL.Path = trimmed
+			if rawTrimmed, ok := trimPrefix(req.URL.RawPath, prefix); ok {
+				req.URL.RawPath = rawTrimmed
+			} else {
+				req.URL.RawPath = ""
+			}
+		}
+		// prefixes of nested filters add up
+		external := prefix
+		if outer, ok := req.Context().Value(prefixKey).(string); ok {
+			external = outer + prefix
+		}
+		req.RequestURI = external + req.URL.RequestURI()
+		req = req.WithContext(context.WithValue(req.Context(), prefixKey, external))
+		handler.ServeHTTP(&prefixWriter{ResponseWriter: w, prefix: prefix}, req)
+	})
+}
+
+// External returns the path under which the server-relative path is reached by clients, for links and forms of
+// pages. Other URLs, e.g. relative or absolute ones, are returned unchanged.
+func External(req *http.Request, path string) string {
+	prefix, _ := req.Context().Value(prefixKey).(string)
+	if !serverRelative(path) {
+		return path
+	}
+	return prefix + path
+}
+
+// trimPrefix removes prefix from path if it is followed by the end or a slash
+func trimPrefix(path, prefix string) (string, bool) {
+	if !strings.HasPrefix(path, prefix) {
+		return path, false
+	}
+	trimmed := path[len(prefix):]
+	switch {
+	case len(trimmed) == 0:
+		return "/", true
+	case trimmed[0] == '/':
+		return trimmed, true
+	default:
+		return path, false
+	}
+}
+
+// serverRelative returns true for URLs with an absolute path and without scheme and host
+func serverRelative(url string) bool {
+	return strings.HasPrefix(url, "/") && !strings.HasPrefix(url, "//")
+}
+
+// prefixWriter adds the prefix to server-relative redirects
+type prefixWriter struct {
+	http.ResponseWriter
+	prefix string
+}
+
+func (w *prefixWriter) WriteHeader(code int) {
+	if location := w.Header().Get("Location"); serverRelative(location) {
+		w.Header().Set("Location", w.prefix+location)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Flush passes flushes on to the writer, if it supports them
+func (w *prefixWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
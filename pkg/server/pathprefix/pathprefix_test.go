@@ -0,0 +1,102 @@
+package pathprefix
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWithPathPrefix(t *testing.T) {
+	testCases := []struct {
+		name               string
+		uri                string
+		location           string
+		expectedPath       string
+		expectedRequestURI string
+		expectedLocation   string
+	}{
+		{
+			name:               "prefix passed on",
+			uri:                "/auth/oauth/authorize?client_id=test",
+			location:           "/login?then=%2Foauth%2Fauthorize",
+			expectedPath:       "/oauth/authorize",
+			expectedRequestURI: "/auth/oauth/authorize?client_id=test",
+			expectedLocation:   "/auth/login?then=%2Foauth%2Fauthorize",
+		},
+		{
+			name:               "prefix removed by the ingress",
+			uri:                "/oauth/authorize?client_id=test",
+			location:           "https://idp.example.com/authorize",
+			expectedPath:       "/oauth/authorize",
+			expectedRequestURI: "/auth/oauth/authorize?client_id=test",
+			expectedLocation:   "https://idp.example.com/authorize",
+		},
+		{
+			name:               "prefix only",
+			uri:                "/auth",
+			location:           "authorize/approve",
+			expectedPath:       "/",
+			expectedRequestURI: "/auth/",
+			expectedLocation:   "authorize/approve",
+		},
+		{
+			name:               "path that starts like the prefix",
+			uri:                "/authorize",
+			location:           "//attacker.example.com",
+			expectedPath:       "/authorize",
+			expectedRequestURI: "/auth/authorize",
+			expectedLocation:   "//attacker.example.com",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var served *http.Request
+			handler := WithPathPrefix(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				served = req
+				w.Header().Set("Location", tc.location)
+				w.WriteHeader(http.StatusFound)
+			}), "/auth")
+
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, tc.uri, nil))
+
+			if served.URL.Path != tc.expectedPath {
+				t.Errorf("expected path %s, got %s", tc.expectedPath, served.URL.Path)
+			}
+			if served.RequestURI != tc.expectedRequestURI {
+				t.Errorf("expected request URI %s, got %s", tc.expectedRequestURI, served.RequestURI)
+			}
+			if location := resp.Header().Get("Location"); location != tc.expectedLocation {
+				t.Errorf("expected location %s, got %s", tc.expectedLocation, location)
+			}
+			if external := External(served, "/logout"); external != "/auth/logout" {
+				t.Errorf("expected external path /auth/logout, got %s", external)
+			}
+		})
+	}
+
+	if external := External(httptest.NewRequest(http.MethodGet, "/logout", nil), "/logout"); external != "/logout" {
+		t.Errorf("expected the path to be unchanged without prefix, got %s", external)
+	}
+}
+
+func TestNestedPathPrefixes(t *testing.T) {
+	var served *http.Request
+	handler := WithPathPrefix(WithPathPrefix(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		served = req
+		http.Redirect(w, req, "/login", http.StatusFound)
+	}), "/tenant-a"), "/auth")
+
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/auth/tenant-a/oauth/authorize", nil))
+
+	if served.URL.Path != "/oauth/authorize" || served.RequestURI != "/auth/tenant-a/oauth/authorize" {
+		t.Errorf("expected path /oauth/authorize under /auth/tenant-a, got %s and %s", served.URL.Path, served.RequestURI)
+	}
+	if external := External(served, "/logout"); external != "/auth/tenant-a/logout" {
+		t.Errorf("expected external path /auth/tenant-a/logout, got %s", external)
+	}
+	if location := resp.Header().Get("Location"); location != "/auth/tenant-a/login" {
+		t.Errorf("expected location /auth/tenant-a/login, got %s", location)
+	}
+}
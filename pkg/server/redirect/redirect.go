@@ -1,20 +1,94 @@
 package redirect
 
 import (
+	"errors"
 	"net/url"
+	"path"
+	"strconv"
 	"strings"
+
+	"github.com/openshift/oauth-server/pkg/api"
 )
 
+// LoopParam counts in the query of "then" URLs how often a login succeeded and sent the user back, so that
+// logins that keep ending up at the login again, e.g. because the browser drops the session cookie, are stopped
+const LoopParam = "login_loop"
+
 // IsServerRelativeURL is used to prevent open redirect issues
 func IsServerRelativeURL(then string) bool {
 	if len(then) == 0 {
 		return false
 	}
+	// browsers treat backslashes like slashes, /\example.com is the host example.com to them
+	if strings.Contains(then, `\`) {
+		return false
+	}
+
+	u, err := url.Parse(then)
+	if err != nil {
+		return false
+	}
+
+	return len(u.Scheme) == 0 && len(u.Host) == 0 && len(u.Opaque) == 0 && strings.HasPrefix(u.Path, "/") &&
+		!strings.HasPrefix(u.Path, "//") && !strings.HasPrefix(u.EscapedPath(), "//")
+}
+
+// Validator restricts "then" redirects to server-relative URLs below the paths of the server that send users to log
+// in, and stops logins that loop. The methods of a nil Validator allow any server-relative URL and do not count loops.
+type Validator struct {
+	paths    []string
+	maxLoops int
+}
+
+// NewValidator returns a Validator that allows redirects to paths and their subpaths, and fails logins that sent the
+// user back maxLoops times already. Loops are not limited if maxLoops is 0.
+func NewValidator(paths []string, maxLoops int) *Validator {
+	return &Validator{paths: paths, maxLoops: maxLoops}
+}
 
+// Allows returns true if then is a server-relative URL below one of the allowed paths. The path must not contain dot
+// segments, so that it cannot leave the allowed paths once the browser resolves it.
+func (v *Validator) Allows(then string) bool {
+	if !IsServerRelativeURL(then) {
+		return false
+	}
+	if v == nil {
+		return true
+	}
 	u, err := url.Parse(then)
 	if err != nil {
 		return false
 	}
+	if cleaned := path.Clean(u.Path); cleaned != strings.TrimSuffix(u.Path, "/") && cleaned != u.Path {
+		return false
+	}
+	for _, allowed := range v.paths {
+		if u.Path == allowed || strings.HasPrefix(u.Path, strings.TrimSuffix(allowed, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
 
-	return len(u.Scheme) == 0 && len(u.Host) == 0 && strings.HasPrefix(u.Path, "/")
+// Next returns then with its loop count incremented, the URL to send the user to after a successful login. It fails
+// with an api.RedirectLoopError if the user was sent back too often already.
+func (v *Validator) Next(then string) (string, error) {
+	if v == nil || v.maxLoops <= 0 {
+		return then, nil
+	}
+	u, err := url.Parse(then)
+	if err != nil {
+		return "", err
+	}
+	query := u.Query()
+	loops, _ := strconv.Atoi(query.Get(LoopParam))
+	if loops < 0 {
+		loops = 0
+	}
+	if loops >= v.maxLoops {
+		return "", api.NewRedirectLoopError(errors.New("the login was completed too often without the authorize request recognizing the user, the browser may reject the session cookie"))
+	}
+	query.Set(LoopParam, strconv.Itoa(loops+1))
+	u.RawQuery = query.Encode()
+	return u.String(), nil
 }
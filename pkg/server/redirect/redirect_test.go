@@ -0,0 +1,79 @@
+package redirect
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/openshift/oauth-server/pkg/api"
+)
+
+func TestIsServerRelativeURL(t *testing.T) {
+	for then, expected := range map[string]bool{
+		"/oauth/authorize?client_id=console": true,
+		"/":                                  true,
+		"":                                   false,
+		"oauth/authorize":                    false,
+		"https://example.com/":               false,
+		"//example.com/":                     false,
+		`/\example.com/`:                     false,
+		"/%2Fexample.com/":                   false,
+		"/\t/example.com/":                   false,
+		"javascript:alert(1)":                false,
+	} {
+		if actual := IsServerRelativeURL(then); actual != expected {
+			t.Errorf("%q: expected %v, got %v", then, expected, actual)
+		}
+	}
+}
+
+func TestValidatorAllows(t *testing.T) {
+	validator := NewValidator([]string{"/oauth/authorize", "/portal/"}, 0)
+	for then, expected := range map[string]bool{
+		"/oauth/authorize?client_id=console":  true,
+		"/oauth/authorize/":                   true,
+		"/oauth/authorize/approve?then=x":     true,
+		"/portal/welcome":                     true,
+		"/portal":                             false,
+		"/oauth/authorizer":                   false,
+		"/oauth/authorize/../../other":        false,
+		"/oauth/authorize/%2e%2e/token":       false,
+		"/logout":                             false,
+		"https://example.com/oauth/authorize": false,
+	} {
+		if actual := validator.Allows(then); actual != expected {
+			t.Errorf("%q: expected %v, got %v", then, expected, actual)
+		}
+	}
+
+	var nilValidator *Validator
+	if !nilValidator.Allows("/logout") || nilValidator.Allows("//example.com") {
+		t.Error("expected a nil validator to allow server-relative URLs only")
+	}
+}
+
+func TestValidatorNext(t *testing.T) {
+	validator := NewValidator([]string{"/oauth/authorize"}, 2)
+	then := "/oauth/authorize?client_id=console"
+	for _, expected := range []string{
+		"/oauth/authorize?client_id=console&login_loop=1",
+		"/oauth/authorize?client_id=console&login_loop=2",
+	} {
+		var err error
+		if then, err = validator.Next(then); err != nil || then != expected {
+			t.Fatalf("expected %s, got %s %v", expected, then, err)
+		}
+	}
+	if _, err := validator.Next(then); !errors.As(err, &api.RedirectLoopError{}) {
+		t.Errorf("expected a redirect loop error, got %v", err)
+	}
+	// negative counts start over at zero
+	if _, err := validator.Next("/oauth/authorize?login_loop=-5"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	for _, unlimited := range []*Validator{nil, NewValidator([]string{"/oauth/authorize"}, 0)} {
+		if next, err := unlimited.Next(then); err != nil || next != then {
+			t.Errorf("expected loops not to be counted, got %s %v", next, err)
+		}
+	}
+}
@@ -35,3 +35,10 @@ func (b *bootstrapSelectProvider) SelectAuthentication(providers []api.ProviderI
 
 	return b.delegate.SelectAuthentication(providers, w, req)
 }
+
+// ProviderSelected passes the choice of the user on to the delegate
+func (b *bootstrapSelectProvider) ProviderSelected(name string, w http.ResponseWriter, req *http.Request) {
+	if recorder, ok := b.delegate.(handlers.AuthenticationSelectionRecorder); ok {
+		recorder.ProviderSelected(name, w, req)
+	}
+}
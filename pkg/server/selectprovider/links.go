@@ -0,0 +1,77 @@
+package selectprovider
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+
+	bootstrap "github.com/openshift/library-go/pkg/authentication/bootstrapauthenticator"
+	oauthserver "github.com/openshift/oauth-server/pkg"
+	"github.com/openshift/oauth-server/pkg/oauth/handlers"
+)
+
+// Link is an identity provider with the URL that logs in with it
+type Link struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+	IconURL     string `json:"iconURL,omitempty"`
+	Description string `json:"description,omitempty"`
+	URL         string `json:"url"`
+}
+
+// Links serves the identity providers a client offers as JSON, with deep links that skip the selection page,
+// e.g. for buttons of the console
+type Links struct {
+	lister        handlers.ProviderLister
+	authorizePath string
+	// getter filters out the bootstrap user if its secret is not functional, if set
+	getter bootstrap.BootstrapUserDataGetter
+}
+
+// NewLinks returns the links of the providers of lister to the authorize endpoint at authorizePath
+func NewLinks(lister handlers.ProviderLister, authorizePath string, getter bootstrap.BootstrapUserDataGetter) *Links {
+	return &Links{lister: lister, authorizePath: authorizePath, getter: getter}
+}
+
+// Install registers the links at prefix
+func (l *Links) Install(mux oauthserver.Mux, prefix string) {
+	mux.Handle(prefix, l)
+}
+
+// ServeHTTP returns the links for the parameters of an authorize request, e.g. ?client_id=console&response_type=code.
+// The links are the authorize request with the idp parameter.
+func (l *Links) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	clientID := req.URL.Query().Get("client_id")
+	if len(clientID) == 0 {
+		http.Error(w, "the client_id parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	authorizeURL := url.URL{Path: l.authorizePath, RawQuery: req.URL.RawQuery}
+	links := []Link{}
+	for _, provider := range l.lister.ProviderLinks(clientID, authorizeURL, req) {
+		if provider.Name == bootstrap.BootstrapUser && l.getter != nil {
+			if _, ok, err := l.getter.Get(); err != nil || !ok {
+				continue
+			}
+		}
+		link := Link{Name: provider.Name, DisplayName: provider.DisplayName, IconURL: provider.IconURL, Description: provider.Description, URL: provider.URL}
+		if len(link.DisplayName) == 0 {
+			link.DisplayName = provider.Name
+		}
+		links = append(links, link)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(links); err != nil {
+		utilruntime.HandleError(fmt.Errorf("unable to write identity provider links: %v", err))
+	}
+}
@@ -0,0 +1,83 @@
+package selectprovider
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/openshift/oauth-server/pkg/api"
+	"github.com/openshift/oauth-server/pkg/oauth/handlers"
+)
+
+type mockRedirector struct{}
+
+func (mockRedirector) AuthenticationRedirect(w http.ResponseWriter, req *http.Request) error {
+	return nil
+}
+
+func TestLinks(t *testing.T) {
+	redirectors := new(handlers.AuthenticationRedirectors)
+	redirectors.AddWithDisplay("sso", mockRedirector{}, api.ProviderDisplay{DisplayName: "Corporate SSO", IconURL: "/static/sso.svg", Description: "Employees"})
+	redirectors.Add("htpasswd", mockRedirector{})
+	restrictions := handlers.ClientRestrictions{IdentityProviders: map[string][]string{"console": {"sso"}}}
+	lister := handlers.NewUnionAuthenticationHandlerWithClientRestrictions(nil, nil, 0, restrictions, redirectors, nil, nil).(handlers.ProviderLister)
+	links := NewLinks(lister, "/oauth/authorize", nil)
+
+	testCases := map[string]struct {
+		Method string
+		URL    string
+
+		ExpectedStatus int
+		ExpectedLinks  []Link
+	}{
+		"restricted client": {
+			Method:         http.MethodGet,
+			URL:            "https://example.com/oauth/authorize/providers?client_id=console&response_type=code",
+			ExpectedStatus: http.StatusOK,
+			ExpectedLinks: []Link{
+				{Name: "sso", DisplayName: "Corporate SSO", IconURL: "/static/sso.svg", Description: "Employees", URL: "/oauth/authorize?client_id=console&idp=sso&response_type=code"},
+			},
+		},
+		"other client": {
+			Method:         http.MethodGet,
+			URL:            "https://example.com/oauth/authorize/providers?client_id=cli",
+			ExpectedStatus: http.StatusOK,
+			ExpectedLinks: []Link{
+				{Name: "sso", DisplayName: "Corporate SSO", IconURL: "/static/sso.svg", Description: "Employees", URL: "/oauth/authorize?client_id=cli&idp=sso"},
+				{Name: "htpasswd", DisplayName: "htpasswd", URL: "/oauth/authorize?client_id=cli&idp=htpasswd"},
+			},
+		},
+		"missing client": {
+			Method:         http.MethodGet,
+			URL:            "https://example.com/oauth/authorize/providers",
+			ExpectedStatus: http.StatusBadRequest,
+		},
+		"method not allowed": {
+			Method:         http.MethodPost,
+			URL:            "https://example.com/oauth/authorize/providers?client_id=cli",
+			ExpectedStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for k, testCase := range testCases {
+		t.Run(k, func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			links.ServeHTTP(resp, httptest.NewRequest(testCase.Method, testCase.URL, nil))
+			if resp.Code != testCase.ExpectedStatus {
+				t.Fatalf("expected status %d, got %d: %s", testCase.ExpectedStatus, resp.Code, resp.Body.String())
+			}
+			if resp.Code != http.StatusOK {
+				return
+			}
+			var got []Link
+			if err := json.Unmarshal(resp.Body.Bytes(), &got); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, testCase.ExpectedLinks) {
+				t.Errorf("expected links %#v, got %#v", testCase.ExpectedLinks, got)
+			}
+		})
+	}
+}
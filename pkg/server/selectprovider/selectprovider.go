@@ -6,11 +6,13 @@ import (
 	"fmt"
 	"html/template"
 	"net/http"
+	"sort"
 
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 
 	"github.com/openshift/oauth-server/pkg/api"
 	"github.com/openshift/oauth-server/pkg/oauth/handlers"
+	"github.com/openshift/oauth-server/pkg/server/cookies"
 	"github.com/openshift/oauth-server/pkg/server/locales"
 )
 
@@ -18,15 +20,61 @@ type SelectProviderRenderer interface {
 	Render(redirectors []api.ProviderInfo, w http.ResponseWriter, req *http.Request)
 }
 
+const (
+	// rememberCookieName is the name of the cookie holding the identity provider the user chose last
+	rememberCookieName = "idp"
+	// rememberCookieMaxAge is how long the choice is remembered, in seconds
+	rememberCookieMaxAge = 30 * 24 * 60 * 60
+
+	// PromptParam is the parameter of authorize requests that asks for the selection page with SelectAccountPrompt
+	PromptParam = handlers.PromptParam
+	// SelectAccountPrompt shows the selection page even if the provider the user chose last is skipped to
+	SelectAccountPrompt = "select_account"
+)
+
+// Codec signs and encrypts values, e.g. the session keys
+type Codec interface {
+	Encode(name string, value interface{}) (string, error)
+	Decode(name, value string, dst interface{}) error
+}
+
+// RememberOptions configure how the identity provider a user chose is remembered
+type RememberOptions struct {
+	// Cookie holds the attributes of the cookie that remembers the choice of the user
+	Cookie cookies.Options
+	// Codec signs the cookie, if set. Unsigned cookies can be set by anyone, they only list the provider first.
+	Codec Codec
+	// SkipSelection sends users to the provider they chose last instead of showing the selection page, unless the
+	// authorize request has the select_account prompt. It is ignored without a Codec.
+	SkipSelection bool
+}
+
 type selectProvider struct {
 	render            SelectProviderRenderer
 	forceInterstitial bool
+	// remember configures the cookie that remembers the choice of the user, if set
+	remember *RememberOptions
+}
+
+// NewSelectProvider returns the handler that lets users choose an identity provider on a selection page,
+// unless there is only a single provider and forceInterstitial is false. Providers are presented with the display
+// information of their ProviderInfo and sorted by its order. If rememberCookie is set, the provider the user chose
+// last is remembered in a cookie with these attributes and listed first.
+func NewSelectProvider(render SelectProviderRenderer, forceInterstitial bool, rememberCookie *cookies.Options) handlers.AuthenticationSelectionHandler {
+	var remember *RememberOptions
+	if rememberCookie != nil {
+		remember = &RememberOptions{Cookie: *rememberCookie}
+	}
+	return NewSelectProviderWithRemember(render, forceInterstitial, remember)
 }
 
-func NewSelectProvider(render SelectProviderRenderer, forceInterstitial bool) handlers.AuthenticationSelectionHandler {
+// NewSelectProviderWithRemember returns the handler of NewSelectProvider that remembers the provider the user chose
+// last as configured by remember, if it is set
+func NewSelectProviderWithRemember(render SelectProviderRenderer, forceInterstitial bool, remember *RememberOptions) handlers.AuthenticationSelectionHandler {
 	return &selectProvider{
 		render:            render,
 		forceInterstitial: forceInterstitial,
+		remember:          remember,
 	}
 }
 
@@ -61,10 +109,80 @@ func (s *selectProvider) SelectAuthentication(providers []api.ProviderInfo, w ht
 		return &providers[0], false, nil
 	}
 
-	s.render.Render(providers, w, req)
+	remembered := s.remembered(req)
+	if s.remember != nil && s.remember.SkipSelection && s.remember.Codec != nil && !s.forceInterstitial && req.URL.Query().Get(PromptParam) != SelectAccountPrompt {
+		for i := range providers {
+			if len(remembered) > 0 && providers[i].Name == remembered {
+				return &providers[i], false, nil
+			}
+		}
+	}
+
+	s.render.Render(s.present(providers, remembered), w, req)
 	return nil, true, nil
 }
 
+// ProviderSelected implements handlers.AuthenticationSelectionRecorder to remember the choice of the user
+func (s *selectProvider) ProviderSelected(name string, w http.ResponseWriter, req *http.Request) {
+	if s.remember == nil {
+		return
+	}
+	cookieName := s.remember.Cookie.Name(rememberCookieName)
+	value := name
+	if s.remember.Codec != nil {
+		encoded, err := s.remember.Codec.Encode(cookieName, name)
+		if err != nil {
+			utilruntime.HandleError(fmt.Errorf("unable to encode the remembered identity provider: %v", err))
+			return
+		}
+		value = encoded
+	}
+	cookie := s.remember.Cookie.New(cookieName, value)
+	cookie.MaxAge = rememberCookieMaxAge
+	s.remember.Cookie.Set(w, cookie)
+}
+
+// remembered returns the name of the provider the user chose last, if it is remembered
+func (s *selectProvider) remembered(req *http.Request) string {
+	if s.remember == nil {
+		return ""
+	}
+	cookieName := s.remember.Cookie.Name(rememberCookieName)
+	cookie, err := req.Cookie(cookieName)
+	if err != nil {
+		return ""
+	}
+	if s.remember.Codec == nil {
+		return cookie.Value
+	}
+	name := ""
+	if err := s.remember.Codec.Decode(cookieName, cookie.Value, &name); err != nil {
+		return ""
+	}
+	return name
+}
+
+// present returns the providers in the order they are shown, providers with the same order keep the order of the
+// configuration. Providers without a display name are shown with their name.
+func (s *selectProvider) present(providers []api.ProviderInfo, remembered string) []api.ProviderInfo {
+	presented := make([]api.ProviderInfo, len(providers))
+	copy(presented, providers)
+	for i := range presented {
+		if len(presented[i].DisplayName) == 0 {
+			presented[i].DisplayName = presented[i].Name
+		}
+		presented[i].Remembered = len(remembered) > 0 && presented[i].Name == remembered
+	}
+	sort.SliceStable(presented, func(i, j int) bool {
+		// the remembered provider comes first
+		if presented[i].Remembered != presented[j].Remembered {
+			return presented[i].Remembered
+		}
+		return presented[i].Order < presented[j].Order
+	})
+	return presented
+}
+
 func ValidateSelectProviderTemplate(templateContent []byte) []error {
 	var allErrs []error
 
@@ -110,7 +228,7 @@ type selectProviderTemplateRenderer struct {
 func (r selectProviderTemplateRenderer) Render(providers []api.ProviderInfo, w http.ResponseWriter, req *http.Request) {
 	w.Header().Add("Content-Type", "text/html; charset=UTF-8")
 	w.WriteHeader(http.StatusOK)
-	locale := locales.GetLocale(req.Header.Get("Accept-Language"))
+	locale := locales.ForRequest(req)
 	if err := r.selectProviderTemplate.Execute(w, ProviderData{Providers: providers, Locale: locale}); err != nil {
 		utilruntime.HandleError(fmt.Errorf("unable to render select provider template: %v", err))
 	}
@@ -4,10 +4,14 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"strings"
 	"testing"
 
 	"github.com/openshift/oauth-server/pkg/api"
+	"github.com/openshift/oauth-server/pkg/oauth/handlers"
+	"github.com/openshift/oauth-server/pkg/server/cookies"
+	"github.com/openshift/oauth-server/pkg/server/session"
 )
 
 func TestSelectAuthentication(t *testing.T) {
@@ -76,7 +80,7 @@ func TestSelectAuthentication(t *testing.T) {
 			t.Errorf("%s: unexpected error: %v", k, err)
 			continue
 		}
-		selectProvider := NewSelectProvider(selectProviderRenderer, testCase.ForceInterstitial)
+		selectProvider := NewSelectProvider(selectProviderRenderer, testCase.ForceInterstitial, nil)
 		resp := httptest.NewRecorder()
 		provider, handled, err := selectProvider.SelectAuthentication(testCase.Providers, resp, &http.Request{})
 
@@ -216,3 +220,177 @@ the example below.
   </body>
 </html>
 `
+
+type recordingRenderer struct {
+	providers []api.ProviderInfo
+}
+
+func (r *recordingRenderer) Render(providers []api.ProviderInfo, w http.ResponseWriter, req *http.Request) {
+	r.providers = providers
+}
+
+func TestSelectAuthenticationDisplay(t *testing.T) {
+	providers := []api.ProviderInfo{
+		{Name: "ldap", URL: "http://example.com/ldap"},
+		{Name: "github", URL: "http://example.com/github", DisplayName: "GitHub", IconURL: "https://example.com/github.png", Description: "Contractors", Order: -1},
+		{Name: "google", URL: "http://example.com/google", Order: 1},
+	}
+
+	testCases := map[string]struct {
+		Remember    bool
+		Cookie      *http.Cookie
+		ExpectOrder []string
+		ExpectFirst api.ProviderInfo
+	}{
+		"ordered with display information": {
+			ExpectOrder: []string{"github", "ldap", "google"},
+			ExpectFirst: api.ProviderInfo{Name: "github", URL: "http://example.com/github", DisplayName: "GitHub", IconURL: "https://example.com/github.png", Description: "Contractors", Order: -1},
+		},
+		"remembered provider first": {
+			Remember:    true,
+			Cookie:      &http.Cookie{Name: "__Host-idp", Value: "google"},
+			ExpectOrder: []string{"google", "github", "ldap"},
+			ExpectFirst: api.ProviderInfo{Name: "google", URL: "http://example.com/google", DisplayName: "google", Order: 1, Remembered: true},
+		},
+		"cookie ignored without remembering": {
+			Cookie:      &http.Cookie{Name: "__Host-idp", Value: "google"},
+			ExpectOrder: []string{"github", "ldap", "google"},
+			ExpectFirst: api.ProviderInfo{Name: "github", URL: "http://example.com/github", DisplayName: "GitHub", IconURL: "https://example.com/github.png", Description: "Contractors", Order: -1},
+		},
+	}
+
+	for k, testCase := range testCases {
+		var rememberCookie *cookies.Options
+		if testCase.Remember {
+			rememberCookie = &cookies.Options{NamePrefix: cookies.HostPrefix, Secure: true}
+		}
+		renderer := &recordingRenderer{}
+		req := httptest.NewRequest("GET", "https://example.com/oauth/authorize", nil)
+		if testCase.Cookie != nil {
+			req.AddCookie(testCase.Cookie)
+		}
+
+		_, handled, err := NewSelectProvider(renderer, false, rememberCookie).SelectAuthentication(providers, httptest.NewRecorder(), req)
+		if err != nil || !handled {
+			t.Errorf("%s: unexpected result %v %v", k, handled, err)
+			continue
+		}
+
+		names := []string{}
+		for _, provider := range renderer.providers {
+			names = append(names, provider.Name)
+		}
+		if !reflect.DeepEqual(names, testCase.ExpectOrder) {
+			t.Errorf("%s: expected order %v, got %v", k, testCase.ExpectOrder, names)
+		}
+		if renderer.providers[0] != testCase.ExpectFirst {
+			t.Errorf("%s: expected first provider %#v, got %#v", k, testCase.ExpectFirst, renderer.providers[0])
+		}
+	}
+
+	if providers[0].Name != "ldap" || len(providers[0].DisplayName) != 0 {
+		t.Errorf("expected providers not to be modified, got %#v", providers)
+	}
+}
+
+func TestProviderSelected(t *testing.T) {
+	options := &cookies.Options{NamePrefix: cookies.HostPrefix, Secure: true, SameSite: http.SameSiteLaxMode}
+	selectProvider := NewSelectProvider(nil, false, options).(handlers.AuthenticationSelectionRecorder)
+
+	resp := httptest.NewRecorder()
+	selectProvider.ProviderSelected("github", resp, httptest.NewRequest("GET", "https://example.com/oauth/authorize", nil))
+	setCookies := resp.Result().Cookies()
+	if len(setCookies) != 1 {
+		t.Fatalf("expected one cookie, got %v", setCookies)
+	}
+	if cookie := setCookies[0]; cookie.Name != "__Host-idp" || cookie.Value != "github" || cookie.MaxAge != rememberCookieMaxAge || !cookie.Secure {
+		t.Errorf("unexpected cookie %#v", cookie)
+	}
+
+	resp = httptest.NewRecorder()
+	NewSelectProvider(nil, false, nil).(handlers.AuthenticationSelectionRecorder).ProviderSelected("github", resp, nil)
+	if setCookies := resp.Result().Cookies(); len(setCookies) != 0 {
+		t.Errorf("expected no cookie without remembering, got %v", setCookies)
+	}
+}
+
+func TestSkipSelection(t *testing.T) {
+	providers := []api.ProviderInfo{
+		{Name: "ldap", URL: "http://example.com/ldap"},
+		{Name: "github", URL: "http://example.com/github"},
+	}
+	keys := session.NewKeys([]byte("0123456789abcdef0123456789abcdef"), []byte("0123456789abcdef"))
+	remember := &RememberOptions{Cookie: cookies.Options{NamePrefix: cookies.HostPrefix, Secure: true}, Codec: keys, SkipSelection: true}
+
+	// remember the choice of the user
+	resp := httptest.NewRecorder()
+	NewSelectProviderWithRemember(nil, false, remember).(handlers.AuthenticationSelectionRecorder).ProviderSelected("github", resp, httptest.NewRequest("GET", "https://example.com/oauth/authorize", nil))
+	setCookies := resp.Result().Cookies()
+	if len(setCookies) != 1 || setCookies[0].Value == "github" {
+		t.Fatalf("expected a signed cookie, got %v", setCookies)
+	}
+	signed := setCookies[0]
+
+	testCases := map[string]struct {
+		URL               string
+		Cookie            *http.Cookie
+		ForceInterstitial bool
+		WithoutCodec      bool
+		ExpectSelected    string
+	}{
+		"remembered provider": {
+			URL:            "https://example.com/oauth/authorize",
+			Cookie:         signed,
+			ExpectSelected: "github",
+		},
+		"select account prompt": {
+			URL:    "https://example.com/oauth/authorize?prompt=select_account",
+			Cookie: signed,
+		},
+		"forced interstitial": {
+			URL:               "https://example.com/oauth/authorize",
+			Cookie:            signed,
+			ForceInterstitial: true,
+		},
+		"unsigned cookie": {
+			URL:    "https://example.com/oauth/authorize",
+			Cookie: &http.Cookie{Name: "__Host-idp", Value: "github"},
+		},
+		"without codec": {
+			URL:          "https://example.com/oauth/authorize",
+			Cookie:       &http.Cookie{Name: "__Host-idp", Value: "github"},
+			WithoutCodec: true,
+		},
+		"nothing remembered": {
+			URL: "https://example.com/oauth/authorize",
+		},
+	}
+
+	for k, testCase := range testCases {
+		t.Run(k, func(t *testing.T) {
+			options := *remember
+			if testCase.WithoutCodec {
+				options.Codec = nil
+			}
+			renderer := &recordingRenderer{}
+			req := httptest.NewRequest("GET", testCase.URL, nil)
+			if testCase.Cookie != nil {
+				req.AddCookie(&http.Cookie{Name: testCase.Cookie.Name, Value: testCase.Cookie.Value})
+			}
+
+			selected, handled, err := NewSelectProviderWithRemember(renderer, testCase.ForceInterstitial, &options).SelectAuthentication(providers, httptest.NewRecorder(), req)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(testCase.ExpectSelected) > 0 {
+				if handled || selected == nil || selected.Name != testCase.ExpectSelected {
+					t.Errorf("expected %s to be selected, got %#v %v", testCase.ExpectSelected, selected, handled)
+				}
+				return
+			}
+			if !handled || selected != nil {
+				t.Errorf("expected the selection page, got %#v %v", selected, handled)
+			}
+		})
+	}
+}
@@ -7,7 +7,7 @@ import (
 var defaultSelectProviderTemplate = template.Must(template.New("defaultSelectProvider").Parse(defaultSelectProviderTemplateString))
 
 const defaultSelectProviderTemplateString = `<!DOCTYPE html>
-<html lang="en-us" data-test-id="login">
+<html lang="{{ or .Locale.Lang "en" }}" data-test-id="login">
   <head>
     <title>{{ .Locale.LogIn }} . OKD</title>
     <meta http-equiv="X-UA-Compatible" content="IE=edge">
@@ -174,6 +174,8 @@ body { background-color: var(--pf-global--BackgroundColor--dark-100); }
 .pf-c-title.pf-m-3xl { font-size: 28px; line-height: 36.4px; }
 
 .idp { margin: 24px 0 0; }
+.idp-icon { height: 1.25em; margin-right: 8px; vertical-align: middle; }
+.idp-description { margin: 4px 0 0; font-size: 0.875rem; color: #737679; }
 
     </style>
   </head>
@@ -195,7 +197,9 @@ body { background-color: var(--pf-global--BackgroundColor--dark-100); }
                 {{ $logInWith := .Locale.LogInWith }}
                 {{ range $provider := .Providers }}
                   <li class="idp">
-                    <a href="{{$provider.URL}}" class="pf-c-button pf-m-secondary pf-m-block" title="{{ $logInWith }} {{$provider.Name}}">{{$provider.Name}}</a>
+                    {{ $name := or $provider.DisplayName $provider.Name }}
+                    <a href="{{$provider.URL}}" class="pf-c-button {{ if $provider.Remembered }}pf-m-primary{{ else }}pf-m-secondary{{ end }} pf-m-block" title="{{ $logInWith }} {{$name}}">{{ if $provider.IconURL }}<img src="{{$provider.IconURL}}" alt="" class="idp-icon" />{{ end }}{{$name}}</a>
+                    {{ if $provider.Description }}<p class="idp-description">{{$provider.Description}}</p>{{ end }}
                   </li>
                 {{ end }}
               </ul>
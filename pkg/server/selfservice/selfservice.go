@@ -0,0 +1,428 @@
+// Package selfservice lets users list where they are logged in and revoke individual access tokens and sessions, and
+// review and withdraw the scopes they approved for clients.
+package selfservice
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/klog/v2"
+
+	oauthv1 "github.com/openshift/api/oauth/v1"
+	oauthclient "github.com/openshift/client-go/oauth/clientset/versioned/typed/oauth/v1"
+
+	"github.com/openshift/oauth-server/pkg"
+	"github.com/openshift/oauth-server/pkg/oauth/registry"
+	"github.com/openshift/oauth-server/pkg/osinserver/registrystorage"
+	"github.com/openshift/oauth-server/pkg/server/session"
+)
+
+const (
+	// scopesKey is the key of the extra user info that holds the scopes of the access token that authenticated the user
+	scopesKey = "scopes.authorization.openshift.io"
+	// fullScope is the scope required to use the endpoints, as they manage the credentials of the user
+	fullScope = "user:full"
+
+	accessTokensPath = "/accesstokens"
+	sessionsPath     = "/sessions"
+	consentsPath     = "/consents"
+)
+
+// AccessToken describes an access token of the user
+type AccessToken struct {
+	// Name identifies the token, it cannot be used to authenticate as the user
+	Name       string   `json:"name"`
+	ClientName string   `json:"clientName"`
+	Scopes     []string `json:"scopes"`
+	// UserAgent is the user agent that requested the token, if it is known
+	UserAgent string    `json:"userAgent,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	// ExpiresAt is not set for tokens that do not expire
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	// LastUsedAt is only known for tokens with an inactivity timeout. It is approximate,
+	// as the use of tokens is only recorded once per fraction of the timeout.
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	// Current is set for the token that authenticated the request
+	Current bool `json:"current,omitempty"`
+}
+
+// AccessTokenList is the response to listing access tokens
+type AccessTokenList struct {
+	AccessTokens []AccessToken `json:"accessTokens"`
+}
+
+// Session describes a browser session of the user
+type Session struct {
+	// ID identifies the session, it cannot be used to authenticate as the user
+	ID        string    `json:"id"`
+	UserAgent string    `json:"userAgent,omitempty"`
+	Provider  string    `json:"provider,omitempty"`
+	IssuedAt  time.Time `json:"issuedAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	// LastUsedAt is not set if the session was not used since the user logged in
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+}
+
+// SessionList is the response to listing sessions
+type SessionList struct {
+	Sessions []Session `json:"sessions"`
+}
+
+// Consent describes the scopes the user approved for a client that are still approved
+type Consent struct {
+	ClientName string          `json:"clientName"`
+	Scopes     []ApprovedScope `json:"scopes"`
+}
+
+// ApprovedScope describes the approval of a scope
+type ApprovedScope struct {
+	Name string `json:"name"`
+	// ApprovedAt is not known for scopes approved before approvals were recorded
+	ApprovedAt *time.Time `json:"approvedAt,omitempty"`
+	// ExpiresAt is not set for approvals that do not expire
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+// ConsentList is the response to listing consents
+type ConsentList struct {
+	Consents []Consent `json:"consents"`
+}
+
+// NewSelfService returns the endpoints that let users authenticated with an access token list and revoke
+// their access tokens and sessions. Sessions are only available if sessions is set, as sessions stored in
+// cookies cannot be listed. inactivityTimeout is the default inactivity timeout of access tokens in seconds.
+func NewSelfService(accessTokens oauthclient.OAuthAccessTokenInterface, clients oauthclient.OAuthClientInterface, sessions session.SessionLister, inactivityTimeout int32) oauthserver.Endpoints {
+	return NewSelfServiceWithConsents(accessTokens, clients, sessions, inactivityTimeout, nil, nil)
+}
+
+// NewSelfServiceWithConsents returns the endpoints of NewSelfService and the endpoints that let users list the scopes
+// they approved for clients and withdraw them, if authorizations is set. Withdrawing the consent for a client also
+// revokes the access tokens of the user for the client.
+func NewSelfServiceWithConsents(accessTokens oauthclient.OAuthAccessTokenInterface, clients oauthclient.OAuthClientInterface, sessions session.SessionLister, inactivityTimeout int32, authorizations oauthclient.OAuthClientAuthorizationInterface, consent *registry.ConsentPolicy) oauthserver.Endpoints {
+	return &selfService{
+		accessTokens:      accessTokens,
+		clients:           clients,
+		sessions:          sessions,
+		inactivityTimeout: inactivityTimeout,
+		authorizations:    authorizations,
+		consent:           consent,
+	}
+}
+
+type selfService struct {
+	accessTokens      oauthclient.OAuthAccessTokenInterface
+	clients           oauthclient.OAuthClientInterface
+	sessions          session.SessionLister
+	inactivityTimeout int32
+	authorizations    oauthclient.OAuthClientAuthorizationInterface
+	consent           *registry.ConsentPolicy
+}
+
+func (s *selfService) Install(mux oauthserver.Mux, prefix string) {
+	mux.Handle(prefix+accessTokensPath, s.authenticated(s.listAccessTokens, http.MethodGet))
+	mux.Handle(prefix+accessTokensPath+"/", http.StripPrefix(prefix+accessTokensPath+"/", s.authenticated(s.revokeAccessToken, http.MethodDelete)))
+	if s.sessions != nil {
+		mux.Handle(prefix+sessionsPath, s.authenticated(s.listSessions, http.MethodGet))
+		mux.Handle(prefix+sessionsPath+"/", http.StripPrefix(prefix+sessionsPath+"/", s.authenticated(s.removeSession, http.MethodDelete)))
+	}
+	if s.authorizations != nil {
+		mux.Handle(prefix+consentsPath, s.authenticated(s.listConsents, http.MethodGet))
+		mux.Handle(prefix+consentsPath+"/", http.StripPrefix(prefix+consentsPath+"/", s.authenticated(s.withdrawConsent, http.MethodDelete)))
+	}
+}
+
+// authenticated only passes requests with the given method by users that were authenticated with a token
+// that is not restricted to scopes other than user:full
+func (s *selfService) authenticated(handle func(w http.ResponseWriter, req *http.Request, user user.Info), method string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != method {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+
+		user, ok := request.UserFrom(req.Context())
+		if !ok || len(user.GetUID()) == 0 || user.GetName() == "system:anonymous" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !hasFullScope(user) {
+			http.Error(w, "Forbidden: the token requires the "+fullScope+" scope", http.StatusForbidden)
+			return
+		}
+		handle(w, req, user)
+	})
+}
+
+// hasFullScope returns true if the user was authenticated with a token that has the user:full scope,
+// or with credentials that are not restricted by scopes
+func hasFullScope(user user.Info) bool {
+	scopes, ok := user.GetExtra()[scopesKey]
+	if !ok {
+		return true
+	}
+	for _, scope := range scopes {
+		if scope == fullScope {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *selfService) listAccessTokens(w http.ResponseWriter, req *http.Request, user user.Info) {
+	tokens, err := s.userAccessTokens(user)
+	if err != nil {
+		klog.Errorf("error listing access tokens of user %q: %v", user.GetName(), err)
+		http.Error(w, "failed to list access tokens", http.StatusInternalServerError)
+		return
+	}
+
+	current := ""
+	if token := bearerToken(req); len(token) > 0 {
+		current = registrystorage.TokenToObjectName(token)
+	}
+
+	now := time.Now()
+	timeouts := map[string]int32{}
+	list := AccessTokenList{AccessTokens: []AccessToken{}}
+	for _, token := range tokens {
+		if !registrystorage.TokenActive(&token, now) {
+			continue
+		}
+		created := token.CreationTimestamp.Time
+
+		accessToken := AccessToken{
+			Name:       token.Name,
+			ClientName: token.ClientName,
+			Scopes:     token.Scopes,
+			UserAgent:  token.Annotations[registrystorage.UserAgentAnnotation],
+			CreatedAt:  created,
+			Current:    token.Name == current,
+		}
+		if token.ExpiresIn > 0 {
+			expiresAt := created.Add(time.Duration(token.ExpiresIn) * time.Second)
+			accessToken.ExpiresAt = &expiresAt
+		}
+		if token.InactivityTimeoutSeconds > 0 {
+			timeout, ok := timeouts[token.ClientName]
+			if !ok {
+				timeout = s.clientInactivityTimeout(token.ClientName)
+				timeouts[token.ClientName] = timeout
+			}
+			// each use extends the timeout to the time since the token was created plus the timeout
+			if used := token.InactivityTimeoutSeconds - timeout; timeout > 0 && used > 0 {
+				lastUsedAt := created.Add(time.Duration(used) * time.Second)
+				accessToken.LastUsedAt = &lastUsedAt
+			}
+		}
+		list.AccessTokens = append(list.AccessTokens, accessToken)
+	}
+	writeJSON(w, list)
+}
+
+func (s *selfService) revokeAccessToken(w http.ResponseWriter, req *http.Request, user user.Info) {
+	name := req.URL.Path
+	if len(name) == 0 || strings.Contains(name, "/") {
+		http.NotFound(w, req)
+		return
+	}
+
+	token, err := s.accessTokens.Get(context.TODO(), name, metav1.GetOptions{})
+	if kerrors.IsNotFound(err) || (err == nil && !ownedBy(token, user)) {
+		http.NotFound(w, req)
+		return
+	}
+	if err == nil {
+		err = s.accessTokens.Delete(context.TODO(), name, metav1.DeleteOptions{Preconditions: metav1.NewUIDPreconditions(string(token.UID))})
+	}
+	if err != nil && !kerrors.IsNotFound(err) {
+		klog.Errorf("error revoking access token %q of user %q: %v", name, user.GetName(), err)
+		http.Error(w, "failed to revoke access token", http.StatusInternalServerError)
+		return
+	}
+	klog.V(4).Infof("user %q revoked access token %q", user.GetName(), name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *selfService) listSessions(w http.ResponseWriter, req *http.Request, user user.Info) {
+	sessions, err := s.sessions.ListSessions(user.GetUID())
+	if err != nil {
+		klog.Errorf("error listing sessions of user %q: %v", user.GetName(), err)
+		http.Error(w, "failed to list sessions", http.StatusInternalServerError)
+		return
+	}
+
+	list := SessionList{Sessions: []Session{}}
+	for _, info := range sessions {
+		session := Session{
+			ID:        info.ID,
+			UserAgent: info.UserAgent,
+			Provider:  info.Provider,
+			IssuedAt:  info.IssuedAt,
+			ExpiresAt: info.ExpiresAt,
+		}
+		if !info.LastUsedAt.IsZero() {
+			lastUsedAt := info.LastUsedAt
+			session.LastUsedAt = &lastUsedAt
+		}
+		list.Sessions = append(list.Sessions, session)
+	}
+	writeJSON(w, list)
+}
+
+func (s *selfService) removeSession(w http.ResponseWriter, req *http.Request, user user.Info) {
+	id := req.URL.Path
+	if len(id) == 0 || strings.Contains(id, "/") {
+		http.NotFound(w, req)
+		return
+	}
+
+	removed, err := s.sessions.RemoveSession(user.GetUID(), id)
+	if err != nil {
+		klog.Errorf("error removing session of user %q: %v", user.GetName(), err)
+		http.Error(w, "failed to remove session", http.StatusInternalServerError)
+		return
+	}
+	if !removed {
+		http.NotFound(w, req)
+		return
+	}
+	klog.V(4).Infof("user %q removed session %q", user.GetName(), id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *selfService) listConsents(w http.ResponseWriter, req *http.Request, user user.Info) {
+	authorizations, err := s.authorizations.List(context.TODO(), metav1.ListOptions{FieldSelector: fields.OneTermEqualSelector("userName", user.GetName()).String()})
+	if err != nil {
+		klog.Errorf("error listing client authorizations of user %q: %v", user.GetName(), err)
+		http.Error(w, "failed to list consents", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	list := ConsentList{Consents: []Consent{}}
+	for i := range authorizations.Items {
+		authorization := &authorizations.Items[i]
+		if authorization.UserName != user.GetName() || authorization.UserUID != user.GetUID() {
+			continue
+		}
+		approvals := registry.ScopeApprovals(authorization)
+		consent := Consent{ClientName: authorization.ClientName, Scopes: []ApprovedScope{}}
+		for _, scope := range s.consent.ApprovedScopes(authorization, now) {
+			approved := ApprovedScope{Name: scope}
+			if approvedAt, ok := approvals[scope]; ok {
+				approved.ApprovedAt = &approvedAt
+			}
+			if expiresAt, ok := s.consent.ExpiresAt(authorization, scope); ok {
+				approved.ExpiresAt = &expiresAt
+			}
+			consent.Scopes = append(consent.Scopes, approved)
+		}
+		if len(consent.Scopes) > 0 {
+			list.Consents = append(list.Consents, consent)
+		}
+	}
+	writeJSON(w, list)
+}
+
+func (s *selfService) withdrawConsent(w http.ResponseWriter, req *http.Request, user user.Info) {
+	clientName := req.URL.Path
+	if len(clientName) == 0 || strings.Contains(clientName, "/") {
+		http.NotFound(w, req)
+		return
+	}
+
+	name := user.GetName() + ":" + clientName
+	authorization, err := s.authorizations.Get(context.TODO(), name, metav1.GetOptions{})
+	if kerrors.IsNotFound(err) || (err == nil && authorization.UserUID != user.GetUID()) {
+		http.NotFound(w, req)
+		return
+	}
+	if err == nil {
+		err = s.authorizations.Delete(context.TODO(), name, metav1.DeleteOptions{Preconditions: metav1.NewUIDPreconditions(string(authorization.UID))})
+	}
+	if err != nil && !kerrors.IsNotFound(err) {
+		klog.Errorf("error withdrawing the consent of user %q for client %q: %v", user.GetName(), clientName, err)
+		http.Error(w, "failed to withdraw consent", http.StatusInternalServerError)
+		return
+	}
+
+	// the tokens of the client were issued with the consent
+	tokens, err := s.userAccessTokens(user)
+	if err != nil {
+		klog.Errorf("error listing access tokens of user %q: %v", user.GetName(), err)
+		http.Error(w, "failed to revoke access tokens", http.StatusInternalServerError)
+		return
+	}
+	for _, token := range tokens {
+		if token.ClientName != clientName {
+			continue
+		}
+		if err := s.accessTokens.Delete(context.TODO(), token.Name, metav1.DeleteOptions{}); err != nil && !kerrors.IsNotFound(err) {
+			klog.Errorf("error revoking access token %q of user %q: %v", token.Name, user.GetName(), err)
+			http.Error(w, "failed to revoke access tokens", http.StatusInternalServerError)
+			return
+		}
+	}
+	klog.V(4).Infof("user %q withdrew the consent for client %q", user.GetName(), clientName)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// userAccessTokens returns the access tokens issued to the user. Tokens of other users with the same name,
+// e.g. a user that was deleted and created again, are left out.
+func (s *selfService) userAccessTokens(user user.Info) ([]oauthv1.OAuthAccessToken, error) {
+	tokens, err := s.accessTokens.List(context.TODO(), metav1.ListOptions{FieldSelector: fields.OneTermEqualSelector("userName", user.GetName()).String()})
+	if err != nil {
+		return nil, err
+	}
+	owned := []oauthv1.OAuthAccessToken{}
+	for _, token := range tokens.Items {
+		if ownedBy(&token, user) {
+			owned = append(owned, token)
+		}
+	}
+	return owned, nil
+}
+
+// clientInactivityTimeout returns the inactivity timeout of tokens issued to the client
+func (s *selfService) clientInactivityTimeout(clientName string) int32 {
+	client, err := s.clients.Get(context.TODO(), clientName, metav1.GetOptions{})
+	if err != nil {
+		// e.g. service account clients, which use the default timeout
+		return s.inactivityTimeout
+	}
+	if client.AccessTokenInactivityTimeoutSeconds != nil {
+		return *client.AccessTokenInactivityTimeoutSeconds
+	}
+	return s.inactivityTimeout
+}
+
+func ownedBy(token *oauthv1.OAuthAccessToken, user user.Info) bool {
+	return token.UserName == user.GetName() && token.UserUID == user.GetUID()
+}
+
+// bearerToken returns the access token the request was authenticated with, if any
+func bearerToken(req *http.Request) string {
+	if auth := strings.TrimSpace(req.Header.Get("Authorization")); len(auth) > 7 && strings.EqualFold(auth[:7], "bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(data)
+}
@@ -0,0 +1,264 @@
+package selfservice
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/endpoints/request"
+
+	oauthv1 "github.com/openshift/api/oauth/v1"
+	oauthfake "github.com/openshift/client-go/oauth/clientset/versioned/fake"
+
+	"github.com/openshift/oauth-server/pkg/oauth/registry"
+	"github.com/openshift/oauth-server/pkg/osinserver/registrystorage"
+	"github.com/openshift/oauth-server/pkg/server/session"
+)
+
+var bob = &user.DefaultInfo{Name: "bob", UID: "bob-uid"}
+
+type fakeLister struct {
+	sessions []session.SessionInfo
+	removed  []string
+}
+
+func (l *fakeLister) ListSessions(uid string) ([]session.SessionInfo, error) {
+	if uid != bob.UID {
+		return nil, nil
+	}
+	return l.sessions, nil
+}
+
+func (l *fakeLister) RemoveSession(uid, id string) (bool, error) {
+	if uid != bob.UID || id != "sha256~session" {
+		return false, nil
+	}
+	l.removed = append(l.removed, id)
+	return true, nil
+}
+
+func accessToken(name, userName, userUID string, created time.Time, expiresIn int64, inactivityTimeout int32) *oauthv1.OAuthAccessToken {
+	return &oauthv1.OAuthAccessToken{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			UID:               types.UID("uid-" + name),
+			CreationTimestamp: metav1.NewTime(created),
+			Annotations:       map[string]string{registrystorage.UserAgentAnnotation: "browser"},
+		},
+		ClientName:               "console",
+		Scopes:                   []string{"user:full"},
+		UserName:                 userName,
+		UserUID:                  userUID,
+		ExpiresIn:                expiresIn,
+		InactivityTimeoutSeconds: inactivityTimeout,
+	}
+}
+
+func serve(handler http.Handler, method, target string, user user.Info, bearer string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	if user != nil {
+		req = req.WithContext(request.WithUser(req.Context(), user))
+	}
+	if len(bearer) > 0 {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func TestAccessTokens(t *testing.T) {
+	created := time.Now().Add(-time.Hour).Truncate(time.Second)
+	recent := time.Now().Add(-30 * time.Minute).Truncate(time.Second)
+	currentName := registrystorage.TokenToObjectName("sha256~current")
+	oauthClient := oauthfake.NewSimpleClientset(
+		accessToken(currentName, "bob", "bob-uid", created, 86400, 0),
+		// used 25 minutes after it was created with the default timeout of 10 minutes
+		accessToken("sha256~used", "bob", "bob-uid", recent, 0, 2100),
+		accessToken("sha256~expired", "bob", "bob-uid", created, 60, 0),
+		accessToken("sha256~inactive", "bob", "bob-uid", created, 0, 600),
+		accessToken("sha256~recreated", "bob", "old-bob-uid", created, 0, 0),
+		accessToken("sha256~alice", "alice", "alice-uid", created, 0, 0),
+	)
+	mux := http.NewServeMux()
+	NewSelfService(oauthClient.OauthV1().OAuthAccessTokens(), oauthClient.OauthV1().OAuthClients(), nil, 600).Install(mux, "/oauth/self")
+
+	if w := serve(mux, http.MethodGet, "/oauth/self/accesstokens", nil, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected unauthenticated request to be rejected, got %d", w.Code)
+	}
+	if w := serve(mux, http.MethodGet, "/oauth/self/accesstokens", &user.DefaultInfo{Name: "system:anonymous"}, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected anonymous request to be rejected, got %d", w.Code)
+	}
+	scoped := &user.DefaultInfo{Name: bob.Name, UID: bob.UID, Extra: map[string][]string{scopesKey: {"user:info", "user:check-access"}}}
+	if w := serve(mux, http.MethodGet, "/oauth/self/accesstokens", scoped, ""); w.Code != http.StatusForbidden {
+		t.Errorf("expected request with a scoped token to be forbidden, got %d", w.Code)
+	}
+	if w := serve(mux, http.MethodDelete, "/oauth/self/accesstokens/sha256~used", scoped, ""); w.Code != http.StatusForbidden {
+		t.Errorf("expected revocation with a scoped token to be forbidden, got %d", w.Code)
+	}
+	full := &user.DefaultInfo{Name: bob.Name, UID: bob.UID, Extra: map[string][]string{scopesKey: {"user:info", fullScope}}}
+	if w := serve(mux, http.MethodGet, "/oauth/self/accesstokens", full, ""); w.Code != http.StatusOK {
+		t.Errorf("expected request with a user:full token to be allowed, got %d", w.Code)
+	}
+
+	w := serve(mux, http.MethodGet, "/oauth/self/accesstokens", bob, "sha256~current")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected tokens to be listed, got %d: %s", w.Code, w.Body.String())
+	}
+	list := AccessTokenList{}
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	tokens := map[string]AccessToken{}
+	for _, token := range list.AccessTokens {
+		tokens[token.Name] = token
+	}
+	if len(tokens) != 2 {
+		t.Fatalf("expected the current and the used token, got %#v", list.AccessTokens)
+	}
+	current := tokens[currentName]
+	if !current.Current || current.ClientName != "console" || current.UserAgent != "browser" || current.ExpiresAt == nil || !current.ExpiresAt.Equal(created.Add(24*time.Hour)) || current.LastUsedAt != nil {
+		t.Errorf("unexpected current token %#v", current)
+	}
+	used := tokens["sha256~used"]
+	if used.Current || used.ExpiresAt != nil || used.LastUsedAt == nil || !used.LastUsedAt.Equal(recent.Add(25*time.Minute)) {
+		t.Errorf("unexpected used token %#v", used)
+	}
+
+	// tokens of other users cannot be revoked
+	for _, name := range []string{"sha256~alice", "sha256~recreated", "sha256~missing"} {
+		if w := serve(mux, http.MethodDelete, "/oauth/self/accesstokens/"+name, bob, ""); w.Code != http.StatusNotFound {
+			t.Errorf("expected %s not to be found, got %d", name, w.Code)
+		}
+	}
+	if w := serve(mux, http.MethodDelete, "/oauth/self/accesstokens/sha256~used", bob, ""); w.Code != http.StatusNoContent {
+		t.Errorf("expected token to be revoked, got %d: %s", w.Code, w.Body.String())
+	}
+	if _, err := oauthClient.OauthV1().OAuthAccessTokens().Get(context.TODO(), "sha256~used", metav1.GetOptions{}); err == nil {
+		t.Error("expected revoked token to be deleted")
+	}
+	if _, err := oauthClient.OauthV1().OAuthAccessTokens().Get(context.TODO(), "sha256~alice", metav1.GetOptions{}); err != nil {
+		t.Errorf("expected token of other user to be kept, got %v", err)
+	}
+
+	if w := serve(mux, http.MethodGet, "/oauth/self/sessions", bob, ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected sessions not to be served without server-side sessions, got %d", w.Code)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	issued := time.Now().Add(-time.Hour).Truncate(time.Second)
+	lister := &fakeLister{sessions: []session.SessionInfo{
+		{ID: "sha256~session", IssuedAt: issued, ExpiresAt: issued.Add(24 * time.Hour), LastUsedAt: issued.Add(time.Minute), UserAgent: "browser", Provider: "github"},
+		{ID: "sha256~unused", IssuedAt: issued, ExpiresAt: issued.Add(24 * time.Hour)},
+	}}
+	oauthClient := oauthfake.NewSimpleClientset()
+	mux := http.NewServeMux()
+	NewSelfService(oauthClient.OauthV1().OAuthAccessTokens(), oauthClient.OauthV1().OAuthClients(), lister, 0).Install(mux, "/oauth/self")
+
+	if w := serve(mux, http.MethodPost, "/oauth/self/sessions", bob, ""); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected POST to be rejected, got %d", w.Code)
+	}
+
+	w := serve(mux, http.MethodGet, "/oauth/self/sessions", bob, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected sessions to be listed, got %d: %s", w.Code, w.Body.String())
+	}
+	list := SessionList{}
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Sessions) != 2 {
+		t.Fatalf("expected two sessions, got %#v", list.Sessions)
+	}
+	if s := list.Sessions[0]; s.ID != "sha256~session" || s.UserAgent != "browser" || s.Provider != "github" || s.LastUsedAt == nil || !s.LastUsedAt.Equal(issued.Add(time.Minute)) {
+		t.Errorf("unexpected session %#v", s)
+	}
+	if s := list.Sessions[1]; s.LastUsedAt != nil {
+		t.Errorf("expected unused session, got %#v", s)
+	}
+
+	if w := serve(mux, http.MethodDelete, "/oauth/self/sessions/sha256~other", bob, ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected unknown session not to be found, got %d", w.Code)
+	}
+	if w := serve(mux, http.MethodDelete, "/oauth/self/sessions/sha256~session", bob, ""); w.Code != http.StatusNoContent {
+		t.Errorf("expected session to be removed, got %d", w.Code)
+	}
+	if len(lister.removed) != 1 {
+		t.Errorf("expected one removed session, got %v", lister.removed)
+	}
+}
+
+func TestConsents(t *testing.T) {
+	created := time.Now().Add(-48 * time.Hour).Truncate(time.Second)
+	approved := time.Now().Add(-time.Hour).Truncate(time.Second)
+	oauthClient := oauthfake.NewSimpleClientset(
+		&oauthv1.OAuthClientAuthorization{
+			ObjectMeta: metav1.ObjectMeta{Name: "bob:console", CreationTimestamp: metav1.NewTime(created), Annotations: map[string]string{
+				registry.ScopeApprovalsAnnotation: fmt.Sprintf(`{"user:full":%d}`, approved.Unix()),
+			}},
+			ClientName: "console", UserName: "bob", UserUID: "bob-uid", Scopes: []string{"user:full", "user:info"},
+		},
+		&oauthv1.OAuthClientAuthorization{
+			ObjectMeta: metav1.ObjectMeta{Name: "bob:expired", CreationTimestamp: metav1.NewTime(created)},
+			ClientName: "expired", UserName: "bob", UserUID: "bob-uid", Scopes: []string{"user:info"},
+		},
+		&oauthv1.OAuthClientAuthorization{
+			ObjectMeta: metav1.ObjectMeta{Name: "bob:recreated", CreationTimestamp: metav1.NewTime(created)},
+			ClientName: "recreated", UserName: "bob", UserUID: "old-bob-uid", Scopes: []string{"user:info"},
+		},
+		accessToken("sha256~console", "bob", "bob-uid", created, 0, 0),
+		accessToken("sha256~alice", "alice", "alice-uid", created, 0, 0),
+	)
+	consent := &registry.ConsentPolicy{MaxAge: 24 * time.Hour}
+	mux := http.NewServeMux()
+	NewSelfServiceWithConsents(oauthClient.OauthV1().OAuthAccessTokens(), oauthClient.OauthV1().OAuthClients(), nil, 0,
+		oauthClient.OauthV1().OAuthClientAuthorizations(), consent).Install(mux, "/oauth/self")
+
+	w := serve(mux, http.MethodGet, "/oauth/self/consents", bob, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected consents to be listed, got %d: %s", w.Code, w.Body.String())
+	}
+	list := ConsentList{}
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Consents) != 1 || list.Consents[0].ClientName != "console" || len(list.Consents[0].Scopes) != 1 {
+		t.Fatalf("expected the unexpired approval of the console, got %#v", list.Consents)
+	}
+	if scope := list.Consents[0].Scopes[0]; scope.Name != "user:full" || scope.ApprovedAt == nil || !scope.ApprovedAt.Equal(approved) ||
+		scope.ExpiresAt == nil || !scope.ExpiresAt.Equal(approved.Add(24*time.Hour)) {
+		t.Errorf("unexpected approved scope %#v", scope)
+	}
+
+	for _, name := range []string{"recreated", "missing"} {
+		if w := serve(mux, http.MethodDelete, "/oauth/self/consents/"+name, bob, ""); w.Code != http.StatusNotFound {
+			t.Errorf("expected consent for %s not to be found, got %d", name, w.Code)
+		}
+	}
+	if w := serve(mux, http.MethodDelete, "/oauth/self/consents/console", bob, ""); w.Code != http.StatusNoContent {
+		t.Fatalf("expected consent to be withdrawn, got %d: %s", w.Code, w.Body.String())
+	}
+	if _, err := oauthClient.OauthV1().OAuthClientAuthorizations().Get(context.TODO(), "bob:console", metav1.GetOptions{}); err == nil {
+		t.Error("expected withdrawn authorization to be deleted")
+	}
+	if _, err := oauthClient.OauthV1().OAuthAccessTokens().Get(context.TODO(), "sha256~console", metav1.GetOptions{}); err == nil {
+		t.Error("expected access token of the client to be revoked")
+	}
+	if _, err := oauthClient.OauthV1().OAuthAccessTokens().Get(context.TODO(), "sha256~alice", metav1.GetOptions{}); err != nil {
+		t.Errorf("expected token of other user to be kept, got %v", err)
+	}
+
+	mux = http.NewServeMux()
+	NewSelfService(oauthClient.OauthV1().OAuthAccessTokens(), oauthClient.OauthV1().OAuthClients(), nil, 0).Install(mux, "/oauth/self")
+	if w := serve(mux, http.MethodGet, "/oauth/self/consents", bob, ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected consents not to be served without authorizations, got %d", w.Code)
+	}
+}
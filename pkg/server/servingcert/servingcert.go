@@ -0,0 +1,87 @@
+// Package servingcert reloads the serving certificates and the client CA of the server as soon as their files change.
+// The content providers of the generic API server only check their files every minute.
+package servingcert
+
+import (
+	"fmt"
+	"time"
+
+	"k8s.io/apiserver/pkg/server/dynamiccertificates"
+
+	"github.com/openshift/oauth-server/pkg/server/filewatch"
+)
+
+// DefaultPollInterval is how often the files are checked for changes in case filesystem notifications are
+// unavailable or missed
+const DefaultPollInterval = time.Minute
+
+// NewCertKey returns the certificate and key of certFile and keyFile
+func NewCertKey(purpose, certFile, keyFile string) (dynamiccertificates.CertKeyContentProvider, error) {
+	content, err := dynamiccertificates.NewDynamicServingContentFromFiles(purpose, certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &certKey{CertKeyContentProvider: content, watcher: &watcher{content: content, files: []string{certFile, keyFile}}}, nil
+}
+
+// NewSNICertKey returns the certificate and key of certFile and keyFile that is served for the given names, in
+// addition to the names of the certificate
+func NewSNICertKey(purpose, certFile, keyFile string, names ...string) (dynamiccertificates.SNICertKeyContentProvider, error) {
+	content, err := dynamiccertificates.NewDynamicSNIContentFromFiles(purpose, certFile, keyFile, names...)
+	if err != nil {
+		return nil, err
+	}
+	return &sniCertKey{SNICertKeyContentProvider: content, watcher: &watcher{content: content, files: []string{certFile, keyFile}}}, nil
+}
+
+// NewCABundle returns the CA bundle of caFile that verifies client certificates
+func NewCABundle(purpose, caFile string) (dynamiccertificates.CAContentProvider, error) {
+	content, err := dynamiccertificates.NewDynamicCAContentFromFile(purpose, caFile)
+	if err != nil {
+		return nil, err
+	}
+	return &caBundle{CAContentProvider: content, watcher: &watcher{content: content, files: []string{caFile}}}, nil
+}
+
+type certKey struct {
+	dynamiccertificates.CertKeyContentProvider
+	*watcher
+}
+
+type sniCertKey struct {
+	dynamiccertificates.SNICertKeyContentProvider
+	*watcher
+}
+
+type caBundle struct {
+	dynamiccertificates.CAContentProvider
+	*watcher
+}
+
+// watcher runs a content provider of the generic API server and makes it load its files whenever they change.
+// The provider notifies its listeners, e.g. the TLS config of the server, if the loaded content differs.
+type watcher struct {
+	content dynamiccertificates.ControllerRunner
+	files   []string
+}
+
+var _ dynamiccertificates.ControllerRunner = &watcher{}
+
+// RunOnce loads the files once
+func (w *watcher) RunOnce() error {
+	return w.content.RunOnce()
+}
+
+// Run loads the files whenever they change until stopCh is closed. Files that fail to load, e.g. a certificate that
+// was replaced before its key, are loaded again on the next change.
+func (w *watcher) Run(workers int, stopCh <-chan struct{}) {
+	// the provider keeps polling its files and retries with a backoff
+	go w.content.Run(workers, stopCh)
+
+	filewatch.Run(func() []string { return w.files }, func() error {
+		if err := w.content.RunOnce(); err != nil {
+			return fmt.Errorf("keeping previous content: %v", err)
+		}
+		return nil
+	}, DefaultPollInterval, stopCh)
+}
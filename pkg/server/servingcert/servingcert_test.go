@@ -0,0 +1,81 @@
+package servingcert
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apiserver/pkg/server/dynamiccertificates"
+	"k8s.io/client-go/util/cert"
+)
+
+type listener chan struct{}
+
+func (l listener) Enqueue() {
+	select {
+	case l <- struct{}{}:
+	default:
+	}
+}
+
+func TestCertKeyReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	write := func(host string) []byte {
+		t.Helper()
+		certPEM, keyPEM, err := cert.GenerateSelfSignedCertKey(host, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for file, content := range map[string][]byte{certFile: certPEM, keyFile: keyPEM} {
+			// files are replaced like mounted secrets are
+			if err := os.WriteFile(file+".tmp", content, 0600); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Rename(file+".tmp", file); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return certPEM
+	}
+	initial := write("initial.example.com")
+
+	content, err := NewCertKey("serving-cert", certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current, _ := content.CurrentCertKeyContent(); !bytes.Equal(current, initial) {
+		t.Fatal("expected the initial certificate to be loaded")
+	}
+	if _, ok := content.(dynamiccertificates.ControllerRunner); !ok {
+		t.Fatal("expected the content to be run by the server")
+	}
+
+	changed := make(listener, 1)
+	content.AddListener(changed)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go content.(dynamiccertificates.ControllerRunner).Run(1, stopCh)
+
+	rotated := write("rotated.example.com")
+	select {
+	case <-changed:
+	case <-time.After(wait.ForeverTestTimeout):
+		t.Fatal("expected the listeners to be notified of the rotated certificate")
+	}
+	if current, _ := content.CurrentCertKeyContent(); !bytes.Equal(current, rotated) {
+		t.Error("expected the rotated certificate to be loaded")
+	}
+
+	// invalid content keeps the previous certificate
+	if err := os.WriteFile(keyFile, []byte("invalid"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if current, _ := content.CurrentCertKeyContent(); !bytes.Equal(current, rotated) {
+		t.Error("expected the previous certificate to be kept")
+	}
+}
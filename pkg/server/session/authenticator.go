@@ -1,11 +1,15 @@
 package session
 
 import (
+	"encoding/json"
 	"net/http"
+	"strings"
 	"time"
 
 	"k8s.io/apiserver/pkg/authentication/authenticator"
 	"k8s.io/apiserver/pkg/authentication/user"
+
+	"github.com/openshift/oauth-server/pkg/oauth/handlers"
 )
 
 const (
@@ -14,17 +18,31 @@ const (
 
 	// expKey is stored as an int64 unix time
 	expKey = "exp"
+	// iatKey is the time the session was issued at, stored as an int64 unix time
+	iatKey = "iat"
+	// userAgentKey is the user agent of the browser that logged in
+	userAgentKey = "ua"
+	// amrKey holds the space separated methods the user authenticated with
+	amrKey = "amr"
+	// idpKey holds the name of the identity provider the user logged in with
+	idpKey = "idp"
+	// extraKey holds the extra user info of the user as a JSON object, e.g. claims passed on from the identity
+	extraKey = "extra"
 )
 
 type sessionAuthenticator struct {
-	store  Store
-	maxAge time.Duration
+	store       Store
+	maxAge      time.Duration
+	revocations *Revocations
 }
 
-func NewAuthenticator(store Store, maxAge time.Duration) SessionAuthenticator {
+// NewAuthenticator returns an authenticator for sessions in the given store. Sessions of users
+// in revocations, if set, are rejected.
+func NewAuthenticator(store Store, maxAge time.Duration, revocations *Revocations) SessionAuthenticator {
 	return &sessionAuthenticator{
-		store:  store,
-		maxAge: maxAge,
+		store:       store,
+		maxAge:      maxAge,
+		revocations: revocations,
 	}
 }
 
@@ -50,19 +68,51 @@ func (a *sessionAuthenticator) AuthenticateRequest(req *http.Request) (*authenti
 		return nil, false, nil
 	}
 
+	// sessions issued before the issue time was recorded are treated as issued at the beginning of time
+	issuedAt, _ := values.GetInt64(iatKey)
+	if a.revocations != nil {
+		if a.revocations.Revoked(uid, time.Unix(issuedAt, 0)) {
+			return nil, false, nil
+		}
+	}
+
+	if recorder, ok := a.store.(usageRecorder); ok {
+		recorder.recordUse(req, time.Unix(expires, 0))
+	}
+
+	// sessions keep how and when the user logged in, e.g. so that clients can require a second factor or a recent login
+	amr, _ := values.GetString(amrKey)
+	var extra map[string][]string
+	if encoded, ok := values.GetString(extraKey); ok {
+		// sessions with unreadable extra user info are used without it
+		_ = json.Unmarshal([]byte(encoded), &extra)
+	}
+	info := handlers.WithAuthenticationMethods(&user.DefaultInfo{
+		Name:  name,
+		UID:   uid,
+		Extra: extra,
+	}, strings.Fields(amr))
+	if idp, ok := values.GetString(idpKey); ok {
+		info = handlers.WithIdentityProvider(info, idp)
+	}
+	if issuedAt > 0 {
+		info = handlers.WithAuthenticationTime(info, time.Unix(issuedAt, 0))
+	}
+
 	return &authenticator.Response{
-		User: &user.DefaultInfo{
-			Name: name,
-			UID:  uid,
-		},
+		User: info,
 	}, true, nil
 }
 
 func (a *sessionAuthenticator) AuthenticationSucceeded(user user.Info, state string, w http.ResponseWriter, req *http.Request) (bool, error) {
-	return false, putUser(a.store, w, user, a.maxAge)
+	return false, putUser(a.store, w, req, user, a.maxAge)
 }
 
-func (a *sessionAuthenticator) InvalidateAuthentication(w http.ResponseWriter, _ user.Info) error {
+func (a *sessionAuthenticator) InvalidateAuthentication(w http.ResponseWriter, req *http.Request, _ user.Info) error {
 	// zero out all fields
-	return putUser(a.store, w, &user.DefaultInfo{}, 0)
+	return putUser(a.store, w, req, &user.DefaultInfo{}, 0)
+}
+
+func (a *sessionAuthenticator) Logout(w http.ResponseWriter, req *http.Request) (*ProviderSession, error) {
+	return removeSession(a.store, w, req)
 }
@@ -52,12 +52,12 @@ func (b *bootstrapAuthenticator) AuthenticationSucceeded(user user.Info, state s
 	// since osin is the IDP for this user, we increase the length
 	// of the session to allow for transitions between components
 	// this means the user could stay authenticated for one hour + OAuth access token lifetime
-	return false, putUser(b.store, w, user, time.Hour)
+	return false, putUser(b.store, w, req, user, time.Hour)
 }
 
-func (b *bootstrapAuthenticator) InvalidateAuthentication(w http.ResponseWriter, user user.Info) error {
+func (b *bootstrapAuthenticator) InvalidateAuthentication(w http.ResponseWriter, req *http.Request, user user.Info) error {
 	if user.GetName() != bootstrap.BootstrapUser {
-		return b.delegate.InvalidateAuthentication(w, user)
+		return b.delegate.InvalidateAuthentication(w, req, user)
 	}
 
 	// the IDP is responsible for maintaining the user's session
@@ -65,3 +65,8 @@ func (b *bootstrapAuthenticator) InvalidateAuthentication(w http.ResponseWriter,
 	// this is safe to do because we tie the cookie and token to the password hash
 	return nil
 }
+
+func (b *bootstrapAuthenticator) Logout(w http.ResponseWriter, req *http.Request) (*ProviderSession, error) {
+	// removing the session logs out every user, including the bootstrap user
+	return b.delegate.Logout(w, req)
+}
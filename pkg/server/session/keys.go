@@ -0,0 +1,69 @@
+package session
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+
+	"k8s.io/klog/v2"
+
+	"github.com/openshift/oauth-server/pkg/server/filewatch"
+)
+
+// DefaultKeysPollInterval is how often the files of watched keys are checked for changes
+// in case filesystem notifications are unavailable or missed
+const DefaultKeysPollInterval = time.Minute
+
+// Keys are the secrets that session cookies are signed and encrypted with. Secrets are pairs of
+// authentication and encryption keys, the first pair encodes new cookies and all pairs decode
+// existing cookies. Keys can be replaced while the server runs to rotate secrets.
+type Keys struct {
+	// store holds the *sessions.CookieStore with the codecs of the current secrets
+	store atomic.Value
+}
+
+// NewKeys returns keys with the given secrets
+func NewKeys(secrets ...[]byte) *Keys {
+	k := &Keys{}
+	k.Set(secrets...)
+	return k
+}
+
+// Set replaces the secrets. Cookies that none of the new secrets decode are ignored.
+func (k *Keys) Set(secrets ...[]byte) {
+	k.store.Store(sessions.NewCookieStore(secrets...))
+}
+
+// cookieStore returns the store that encodes and decodes cookies with the current secrets
+func (k *Keys) cookieStore() *sessions.CookieStore {
+	return k.store.Load().(*sessions.CookieStore)
+}
+
+// Encode signs and encrypts the value with the first secrets. The name is part of the signature, values can only
+// be decoded with the name they were encoded with.
+func (k *Keys) Encode(name string, value interface{}) (string, error) {
+	return securecookie.EncodeMulti(name, value, k.cookieStore().Codecs...)
+}
+
+// Decode decodes a value that was encoded with the name and any of the secrets into dst
+func (k *Keys) Decode(name, value string, dst interface{}) error {
+	return securecookie.DecodeMulti(name, value, dst, k.cookieStore().Codecs...)
+}
+
+// Run replaces the secrets with the ones returned by load whenever one of the files changes, until
+// stopCh is closed. Changes are detected using filesystem notifications and, as a fallback, by checking
+// the files every pollInterval. If load fails, the current secrets are kept.
+func (k *Keys) Run(files []string, load func() ([][]byte, error), pollInterval time.Duration, stopCh <-chan struct{}) {
+	filewatch.Run(func() []string { return files }, func() error {
+		secrets, err := load()
+		if err != nil {
+			return fmt.Errorf("keeping previous session secrets: %v", err)
+		}
+		k.Set(secrets...)
+		klog.V(4).Infof("Loaded %d session secrets", len(secrets)/2)
+		return nil
+	}, pollInterval, stopCh)
+}
@@ -0,0 +1,99 @@
+package session
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/openshift/oauth-server/pkg/server/cookies"
+)
+
+// secrets are pairs of authentication and encryption keys
+var (
+	oldSecret = [][]byte{[]byte("0123456789abcdef0123456789abcdef"), []byte("abcdef0123456789abcdef0123456789")}
+	newSecret = [][]byte{[]byte("fedcba9876543210fedcba9876543210"), []byte("9876543210fedcba9876543210fedcba")}
+)
+
+func pairs(secrets ...[][]byte) [][]byte {
+	all := [][]byte{}
+	for _, secret := range secrets {
+		all = append(all, secret...)
+	}
+	return all
+}
+
+func TestKeysRotation(t *testing.T) {
+	keys := NewKeys(oldSecret...)
+	store := NewStore("ssn", cookies.Options{}, keys)
+
+	w := httptest.NewRecorder()
+	if err := store.Put(w, nil, Values{"user": "bob"}); err != nil {
+		t.Fatal(err)
+	}
+	oldCookie := requestWithCookies(w)
+
+	// the new secret encodes, the old one still decodes
+	keys.Set(pairs(newSecret, oldSecret)...)
+	if user, _ := store.Get(oldCookie).GetString("user"); user != "bob" {
+		t.Errorf("expected cookie encoded with the old secret to be decoded, got %q", user)
+	}
+	w = httptest.NewRecorder()
+	if err := store.Put(w, nil, Values{"user": "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	newCookie := requestWithCookies(w)
+
+	keys.Set(newSecret...)
+	if values := store.Get(oldCookie); len(values) != 0 {
+		t.Errorf("expected cookie encoded with the removed secret to be ignored, got %v", values)
+	}
+	if user, _ := store.Get(newCookie).GetString("user"); user != "alice" {
+		t.Errorf("expected cookie encoded with the new secret to be decoded, got %q", user)
+	}
+}
+
+func TestKeysRun(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "secrets")
+	if err := os.WriteFile(file, bytes.Join(oldSecret, []byte("\n")), 0600); err != nil {
+		t.Fatal(err)
+	}
+	load := func() ([][]byte, error) {
+		data, err := os.ReadFile(file)
+		return bytes.Split(data, []byte("\n")), err
+	}
+
+	keys := NewKeys(oldSecret...)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go keys.Run([]string{file}, load, 10*time.Millisecond, stopCh)
+
+	// the size changes, so the change is detected regardless of the resolution of modification times
+	rotated := pairs(newSecret, oldSecret)
+	if err := os.WriteFile(file, bytes.Join(rotated, []byte("\n")), 0600); err != nil {
+		t.Fatal(err)
+	}
+	expected := NewKeys(rotated...).cookieStore().Codecs
+	for i := 0; i < 100; i++ {
+		if codecs := keys.cookieStore().Codecs; len(codecs) == len(expected) && encodesLike(t, codecs[0], expected[0]) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("expected keys to be reloaded after the file changed")
+}
+
+// encodesLike returns true if the value encoded by codec is decoded by expected
+func encodesLike(t *testing.T, codec, expected interface {
+	Encode(name string, value interface{}) (string, error)
+	Decode(name, value string, dst interface{}) error
+}) bool {
+	encoded, err := codec.Encode("ssn", []byte("value"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := []byte{}
+	return expected.Decode("ssn", encoded, &decoded) == nil && bytes.Equal(decoded, []byte("value"))
+}
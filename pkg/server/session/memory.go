@@ -0,0 +1,207 @@
+package session
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// memoryPruneInterval is the minimum interval between removals of expired sessions
+const memoryPruneInterval = time.Minute
+
+type memoryEntry struct {
+	values  []byte
+	expires time.Time
+}
+
+type memorySet struct {
+	members map[string]struct{}
+	expires time.Time
+}
+
+// memoryIndex keeps its members sorted, so that pages are found with a binary search
+type memoryIndex struct {
+	members []string
+	expires time.Time
+}
+
+type memoryBackend struct {
+	lock       sync.Mutex
+	entries    map[string]memoryEntry
+	sets       map[string]memorySet
+	indexes    map[string]memoryIndex
+	lastPruned time.Time
+}
+
+// NewMemoryBackend returns a backend that keeps sessions in memory. Sessions are lost when the
+// server restarts and are not shared between multiple instances of the server.
+func NewMemoryBackend() Backend {
+	return &memoryBackend{entries: map[string]memoryEntry{}, sets: map[string]memorySet{}, indexes: map[string]memoryIndex{}}
+}
+
+func (b *memoryBackend) Get(key string) ([]byte, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	entry, ok := b.entries[key]
+	if !ok || !time.Now().Before(entry.expires) {
+		return nil, nil
+	}
+	return entry.values, nil
+}
+
+func (b *memoryBackend) Set(key string, values []byte, ttl time.Duration) error {
+	now := time.Now()
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.prune(now)
+	b.entries[key] = memoryEntry{values: values, expires: now.Add(ttl)}
+	return nil
+}
+
+// prune removes expired entries and sets, the lock must be held
+func (b *memoryBackend) prune(now time.Time) {
+	if now.Sub(b.lastPruned) <= memoryPruneInterval {
+		return
+	}
+	for k, entry := range b.entries {
+		if !now.Before(entry.expires) {
+			delete(b.entries, k)
+		}
+	}
+	for k, set := range b.sets {
+		if !now.Before(set.expires) {
+			delete(b.sets, k)
+		}
+	}
+	for k, index := range b.indexes {
+		if !now.Before(index.expires) {
+			delete(b.indexes, k)
+		}
+	}
+	b.lastPruned = now
+}
+
+func (b *memoryBackend) Delete(key string) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	delete(b.entries, key)
+	return nil
+}
+
+func (b *memoryBackend) AddMember(key, member string, ttl time.Duration) error {
+	now := time.Now()
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.prune(now)
+	set, ok := b.sets[key]
+	if !ok || !now.Before(set.expires) {
+		set = memorySet{members: map[string]struct{}{}}
+	}
+	set.members[member] = struct{}{}
+	// only ever extend the lifetime, the set holds sessions with different expirations
+	if expires := now.Add(ttl); expires.After(set.expires) {
+		set.expires = expires
+	}
+	b.sets[key] = set
+	return nil
+}
+
+func (b *memoryBackend) Members(key string) ([]string, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	set, ok := b.sets[key]
+	if !ok || !time.Now().Before(set.expires) {
+		return nil, nil
+	}
+	members := make([]string, 0, len(set.members))
+	for member := range set.members {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+	return members, nil
+}
+
+func (b *memoryBackend) RemoveMember(key, member string) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if set, ok := b.sets[key]; ok {
+		delete(set.members, member)
+		if len(set.members) == 0 {
+			delete(b.sets, key)
+		}
+	}
+	return nil
+}
+
+func (b *memoryBackend) AddIndexMember(key, member string, ttl time.Duration) error {
+	now := time.Now()
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.prune(now)
+	index, ok := b.indexes[key]
+	if !ok || !now.Before(index.expires) {
+		index = memoryIndex{}
+	}
+	if i := sort.SearchStrings(index.members, member); i == len(index.members) || index.members[i] != member {
+		index.members = append(index.members, "")
+		copy(index.members[i+1:], index.members[i:])
+		index.members[i] = member
+	}
+	// only ever extend the lifetime, like the lifetime of sets
+	if expires := now.Add(ttl); expires.After(index.expires) {
+		index.expires = expires
+	}
+	b.indexes[key] = index
+	return nil
+}
+
+func (b *memoryBackend) IndexMembers(key, after string, limit int) ([]string, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	index, ok := b.indexes[key]
+	if !ok || !time.Now().Before(index.expires) {
+		return nil, nil
+	}
+	start := 0
+	if len(after) > 0 {
+		start = sort.SearchStrings(index.members, after)
+		if start < len(index.members) && index.members[start] == after {
+			start++
+		}
+	}
+	end := len(index.members)
+	if limit > 0 && start+limit < end {
+		end = start + limit
+	}
+	return append([]string{}, index.members[start:end]...), nil
+}
+
+func (b *memoryBackend) RemoveIndexMember(key, member string) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	index, ok := b.indexes[key]
+	if !ok {
+		return nil
+	}
+	if i := sort.SearchStrings(index.members, member); i < len(index.members) && index.members[i] == member {
+		index.members = append(index.members[:i], index.members[i+1:]...)
+	}
+	if len(index.members) == 0 {
+		delete(b.indexes, key)
+		return nil
+	}
+	b.indexes[key] = index
+	return nil
+}
@@ -0,0 +1,44 @@
+package session
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemoryBackendIndexes(t *testing.T) {
+	backend := NewMemoryBackend()
+
+	for _, member := range []string{"c", "a", "d", "b", "a"} {
+		if err := backend.AddIndexMember("index", member, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := backend.RemoveIndexMember("index", "c"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		after    string
+		limit    int
+		expected string
+	}{
+		{after: "", limit: 0, expected: "a,b,d"},
+		{after: "", limit: 2, expected: "a,b"},
+		{after: "b", limit: 2, expected: "d"},
+		{after: "c", limit: 0, expected: "d"},
+		{after: "d", limit: 2, expected: ""},
+	} {
+		members, err := backend.IndexMembers("index", tc.after, tc.limit)
+		if err != nil || strings.Join(members, ",") != tc.expected {
+			t.Errorf("expected members %q after %q, got %v %v", tc.expected, tc.after, members, err)
+		}
+	}
+
+	if err := backend.AddIndexMember("expired", "a", -time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if members, err := backend.IndexMembers("expired", "", 0); err != nil || len(members) != 0 {
+		t.Errorf("expected the expired index to be empty, got %v %v", members, err)
+	}
+}
@@ -0,0 +1,69 @@
+package session
+
+import (
+	"context"
+	"net/http"
+)
+
+const (
+	providerNameKey    = "idp.name"
+	providerIDTokenKey = "idp.id_token"
+	// providerExpKey is stored as an int64 unix time, the provider session outlives the
+	// authentication of the session so that it is still known when the user logs out
+	providerExpKey = "idp.exp"
+)
+
+// ProviderSession describes the session of a user at the external identity provider it logged in with
+type ProviderSession struct {
+	// Provider is the name of the identity provider
+	Provider string
+	// IDToken is the ID token issued by the provider, used as hint when ending the session at the provider
+	IDToken string
+}
+
+type providerSessionKeyType int
+
+const providerSessionKey providerSessionKeyType = iota
+
+// WithProviderSession returns a copy of req that records the session at the identity provider
+// in the session created by a successful authentication
+func WithProviderSession(req *http.Request, providerSession ProviderSession) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), providerSessionKey, providerSession))
+}
+
+func providerSessionFrom(req *http.Request) (ProviderSession, bool) {
+	if req == nil {
+		return ProviderSession{}, false
+	}
+	providerSession, ok := req.Context().Value(providerSessionKey).(ProviderSession)
+	return providerSession, ok && len(providerSession.Provider) > 0
+}
+
+// getProviderSession returns the provider session stored in values if it has not expired
+func (v Values) getProviderSession(now int64) (ProviderSession, int64, bool) {
+	expires, ok := v.GetInt64(providerExpKey)
+	if !ok || expires < now {
+		return ProviderSession{}, 0, false
+	}
+	name, ok := v.GetString(providerNameKey)
+	if !ok {
+		return ProviderSession{}, 0, false
+	}
+	idToken, _ := v.GetString(providerIDTokenKey)
+	return ProviderSession{Provider: name, IDToken: idToken}, expires, true
+}
+
+func (v Values) setProviderSession(providerSession ProviderSession, expires int64) {
+	v[providerNameKey] = providerSession.Provider
+	v[providerIDTokenKey] = providerSession.IDToken
+	v[providerExpKey] = expires
+}
+
+// expires returns the time until which any part of the session is valid
+func (v Values) expires() int64 {
+	expires, _ := v.GetInt64(expKey)
+	if providerExpires, _ := v.GetInt64(providerExpKey); providerExpires > expires {
+		return providerExpires
+	}
+	return expires
+}
@@ -1,13 +1,20 @@
 package session
 
 import (
+	"encoding/json"
 	"net/http"
+	"strings"
 	"time"
 
 	"k8s.io/apiserver/pkg/authentication/user"
+
+	"github.com/openshift/oauth-server/pkg/oauth/handlers"
 )
 
-func putUser(store Store, w http.ResponseWriter, user user.Info, expiresIn time.Duration) error {
+// maxUserAgentLength limits the size of user agents stored in sessions
+const maxUserAgentLength = 256
+
+func putUser(store Store, w http.ResponseWriter, req *http.Request, user user.Info, expiresIn time.Duration) error {
 	values := Values{}
 
 	values[userNameKey] = user.GetName()
@@ -18,6 +25,53 @@ func putUser(store Store, w http.ResponseWriter, user user.Info, expiresIn time.
 		expires = time.Now().Add(expiresIn).Unix()
 	}
 	values[expKey] = expires
+	values[iatKey] = time.Now().Unix()
+	if methods, ok := user.(handlers.AuthenticationMethods); ok && expires > 0 && len(methods.GetAuthenticationMethods()) > 0 {
+		values[amrKey] = strings.Join(methods.GetAuthenticationMethods(), " ")
+	}
+	if identityProvider, ok := user.(handlers.IdentityProvider); ok && expires > 0 && len(identityProvider.GetIdentityProvider()) > 0 {
+		values[idpKey] = identityProvider.GetIdentityProvider()
+	}
+	if extra := user.GetExtra(); len(extra) > 0 && expires > 0 {
+		encoded, err := json.Marshal(extra)
+		if err != nil {
+			return err
+		}
+		values[extraKey] = string(encoded)
+	}
+	if req != nil && expires > 0 {
+		if userAgent := req.UserAgent(); len(userAgent) > 0 {
+			if len(userAgent) > maxUserAgentLength {
+				userAgent = userAgent[:maxUserAgentLength]
+			}
+			values[userAgentKey] = userAgent
+		}
+	}
 
-	return store.Put(w, values)
+	// a new login records the session at the identity provider, removing the
+	// authentication keeps the one of the existing session for logouts
+	if providerSession, ok := providerSessionFrom(req); ok && expires > 0 {
+		values.setProviderSession(providerSession, expires)
+	} else if expiresIn == 0 && req != nil {
+		if providerSession, providerExpires, ok := store.Get(req).getProviderSession(time.Now().Unix()); ok {
+			values.setProviderSession(providerSession, providerExpires)
+		}
+	}
+
+	return store.Put(w, req, values)
+}
+
+// removeSession removes all values of the session and returns the provider session it held, if any
+func removeSession(store Store, w http.ResponseWriter, req *http.Request) (*ProviderSession, error) {
+	var providerSession *ProviderSession
+	if current, _, ok := store.Get(req).getProviderSession(time.Now().Unix()); ok {
+		providerSession = &current
+	}
+
+	values := Values{
+		userNameKey: "",
+		userUIDKey:  "",
+		expKey:      int64(0),
+	}
+	return providerSession, store.Put(w, req, values)
 }
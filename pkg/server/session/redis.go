@@ -0,0 +1,333 @@
+package session
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	// DefaultRedisTimeout limits the duration of a single redis command if no timeout is configured
+	DefaultRedisTimeout = 5 * time.Second
+	// redisMaxIdleConns is the number of connections that are kept open for reuse
+	redisMaxIdleConns = 8
+	// redisMaxBulkSize limits the size of values read from redis
+	redisMaxBulkSize = 1 << 20
+
+	// addMemberScript adds ARGV[1] to the set KEYS[1] and extends its lifetime to ARGV[2] milliseconds. The lifetime
+	// is only ever extended, the set holds sessions with different expirations. Scripts run atomically, so concurrent
+	// additions cannot shorten the lifetime, and it works with servers older than redis 7, which lack PEXPIRE GT.
+	addMemberScript = `redis.call('SADD', KEYS[1], ARGV[1])
+if redis.call('PTTL', KEYS[1]) < tonumber(ARGV[2]) then
+  redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 1`
+	// addIndexMemberScript is addMemberScript for indexes, which are sorted sets whose members all have the score 0,
+	// so that they are sorted by their bytes and can be read in pages with ZRANGEBYLEX
+	addIndexMemberScript = `redis.call('ZADD', KEYS[1], 0, ARGV[1])
+if redis.call('PTTL', KEYS[1]) < tonumber(ARGV[2]) then
+  redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 1`
+)
+
+// RedisOptions configure the connection to a redis server
+type RedisOptions struct {
+	// Address is the host:port of the server
+	Address string
+	// Username and Password authenticate with the server if Password is set.
+	// Username requires redis 6 ACLs and may be empty for the default user.
+	Username string
+	Password string
+	// DB is the number of the database that is selected
+	DB int
+	// TLSConfig enables TLS if set
+	TLSConfig *tls.Config
+	// KeyPrefix is prepended to the keys of all sessions
+	KeyPrefix string
+	// Timeout limits the duration of a single command. Defaults to DefaultRedisTimeout.
+	Timeout time.Duration
+}
+
+// redisError is an error reply of the server
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// redisBackend speaks RESP2 itself for the few commands it needs, instead of vendoring a redis client library and
+// its dependencies. Replies are read with the limits of readRedisReply, and connections are discarded after protocol
+// errors, so that a misbehaving server cannot leave a connection in the pool mid-reply.
+type redisBackend struct {
+	options RedisOptions
+	idle    chan *redisConn
+}
+
+// NewRedisBackend returns a backend that stores sessions in redis with an expiry,
+// so that sessions are shared between all instances of the server.
+func NewRedisBackend(options RedisOptions) Backend {
+	if options.Timeout == 0 {
+		options.Timeout = DefaultRedisTimeout
+	}
+	return &redisBackend{
+		options: options,
+		idle:    make(chan *redisConn, redisMaxIdleConns),
+	}
+}
+
+func (b *redisBackend) Get(key string) ([]byte, error) {
+	reply, err := b.do("GET", b.options.KeyPrefix+key)
+	if err != nil || reply == nil {
+		return nil, err
+	}
+	value, ok := reply.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("redis: unexpected reply %v to GET", reply)
+	}
+	return value, nil
+}
+
+func (b *redisBackend) Set(key string, values []byte, ttl time.Duration) error {
+	milliseconds := ttl.Milliseconds()
+	if milliseconds <= 0 {
+		milliseconds = 1
+	}
+	_, err := b.do("SET", b.options.KeyPrefix+key, string(values), "PX", strconv.FormatInt(milliseconds, 10))
+	return err
+}
+
+func (b *redisBackend) Delete(key string) error {
+	_, err := b.do("DEL", b.options.KeyPrefix+key)
+	return err
+}
+
+func (b *redisBackend) AddMember(key, member string, ttl time.Duration) error {
+	milliseconds := ttl.Milliseconds()
+	if milliseconds <= 0 {
+		milliseconds = 1
+	}
+	_, err := b.do("EVAL", addMemberScript, "1", b.options.KeyPrefix+key, member, strconv.FormatInt(milliseconds, 10))
+	return err
+}
+
+func (b *redisBackend) Members(key string) ([]string, error) {
+	reply, err := b.do("SMEMBERS", b.options.KeyPrefix+key)
+	if err != nil || reply == nil {
+		return nil, err
+	}
+	elements, ok := reply.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("redis: unexpected reply %v to SMEMBERS", reply)
+	}
+	members := make([]string, 0, len(elements))
+	for _, element := range elements {
+		member, ok := element.([]byte)
+		if !ok {
+			return nil, fmt.Errorf("redis: unexpected reply %v to SMEMBERS", element)
+		}
+		members = append(members, string(member))
+	}
+	return members, nil
+}
+
+func (b *redisBackend) RemoveMember(key, member string) error {
+	_, err := b.do("SREM", b.options.KeyPrefix+key, member)
+	return err
+}
+
+func (b *redisBackend) AddIndexMember(key, member string, ttl time.Duration) error {
+	milliseconds := ttl.Milliseconds()
+	if milliseconds <= 0 {
+		milliseconds = 1
+	}
+	_, err := b.do("EVAL", addIndexMemberScript, "1", b.options.KeyPrefix+key, member, strconv.FormatInt(milliseconds, 10))
+	return err
+}
+
+func (b *redisBackend) IndexMembers(key, after string, limit int) ([]string, error) {
+	args := []string{"ZRANGEBYLEX", b.options.KeyPrefix + key, "-", "+"}
+	if len(after) > 0 {
+		args[2] = "(" + after
+	}
+	if limit > 0 {
+		args = append(args, "LIMIT", "0", strconv.Itoa(limit))
+	}
+	reply, err := b.do(args...)
+	if err != nil || reply == nil {
+		return nil, err
+	}
+	elements, ok := reply.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("redis: unexpected reply %v to ZRANGEBYLEX", reply)
+	}
+	members := make([]string, 0, len(elements))
+	for _, element := range elements {
+		member, ok := element.([]byte)
+		if !ok {
+			return nil, fmt.Errorf("redis: unexpected reply %v to ZRANGEBYLEX", element)
+		}
+		members = append(members, string(member))
+	}
+	return members, nil
+}
+
+func (b *redisBackend) RemoveIndexMember(key, member string) error {
+	_, err := b.do("ZREM", b.options.KeyPrefix+key, member)
+	return err
+}
+
+// do runs a command on a pooled connection. Connections are discarded after network or protocol errors.
+func (b *redisBackend) do(args ...string) (interface{}, error) {
+	conn, err := b.get()
+	if err != nil {
+		return nil, err
+	}
+	reply, err := b.command(conn, args...)
+	if err != nil {
+		if _, isReply := err.(redisError); !isReply {
+			conn.conn.Close()
+			return nil, err
+		}
+	}
+	b.put(conn)
+	return reply, err
+}
+
+func (b *redisBackend) get() (*redisConn, error) {
+	select {
+	case conn := <-b.idle:
+		return conn, nil
+	default:
+	}
+
+	dialer := &net.Dialer{Timeout: b.options.Timeout}
+	var conn net.Conn
+	var err error
+	if b.options.TLSConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", b.options.Address, b.options.TLSConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", b.options.Address)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("redis: %v", err)
+	}
+	c := &redisConn{conn: conn, reader: bufio.NewReader(conn)}
+
+	if len(b.options.Password) > 0 {
+		args := []string{"AUTH", b.options.Password}
+		if len(b.options.Username) > 0 {
+			args = []string{"AUTH", b.options.Username, b.options.Password}
+		}
+		if _, err := b.command(c, args...); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if b.options.DB != 0 {
+		if _, err := b.command(c, "SELECT", strconv.Itoa(b.options.DB)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (b *redisBackend) put(conn *redisConn) {
+	select {
+	case b.idle <- conn:
+	default:
+		conn.conn.Close()
+	}
+}
+
+// command writes the arguments as an array of bulk strings and reads the reply
+func (b *redisBackend) command(conn *redisConn, args ...string) (interface{}, error) {
+	if err := conn.conn.SetDeadline(time.Now().Add(b.options.Timeout)); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 0, 64)
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(args)), 10)
+	buf = append(buf, '\r', '\n')
+	for _, arg := range args {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+		buf = append(buf, '\r', '\n')
+		buf = append(buf, arg...)
+		buf = append(buf, '\r', '\n')
+	}
+	if _, err := conn.conn.Write(buf); err != nil {
+		return nil, err
+	}
+	return readRedisReply(conn.reader)
+}
+
+// readRedisReply reads a single RESP reply. Simple strings and integers are returned as strings,
+// bulk strings as []byte, arrays as []interface{} and nil replies as nil.
+func readRedisReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("redis: malformed reply")
+	}
+	kind, payload := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+', ':':
+		return payload, nil
+	case '-':
+		return nil, redisError(payload)
+	case '$':
+		size, err := strconv.Atoi(payload)
+		if err != nil || size < -1 || size > redisMaxBulkSize {
+			return nil, fmt.Errorf("redis: invalid bulk size %q", payload)
+		}
+		if size == -1 {
+			return nil, nil
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		if data[size] != '\r' || data[size+1] != '\n' {
+			return nil, errors.New("redis: malformed bulk string")
+		}
+		return data[:size], nil
+	case '*':
+		size, err := strconv.Atoi(payload)
+		if err != nil || size < -1 || size > redisMaxBulkSize {
+			return nil, fmt.Errorf("redis: invalid array size %q", payload)
+		}
+		if size == -1 {
+			return nil, nil
+		}
+		elements := make([]interface{}, 0, size)
+		for i := 0; i < size; i++ {
+			element, err := readRedisReply(r)
+			if _, isReply := err.(redisError); isReply {
+				// keep reading the remaining elements to leave the connection usable
+				element = err
+			} else if err != nil {
+				return nil, err
+			}
+			elements = append(elements, element)
+		}
+		return elements, nil
+	default:
+		return nil, fmt.Errorf("redis: unknown reply type %q", kind)
+	}
+}
@@ -0,0 +1,357 @@
+package session
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// fakeRedis serves a subset of the redis protocol from memory
+type fakeRedis struct {
+	listener net.Listener
+	password string
+
+	lock     sync.Mutex
+	data     map[string]string
+	sets     map[string]map[string]bool
+	ttls     map[string]int64
+	commands []string
+}
+
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &fakeRedis{listener: listener, password: password, data: map[string]string{}, sets: map[string]map[string]bool{}, ttls: map[string]int64{}}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go r.serve(conn)
+		}
+	}()
+	return r
+}
+
+func (r *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	authenticated := len(r.password) == 0
+	for {
+		reply, err := readRedisReply(reader)
+		if err != nil {
+			return
+		}
+		args := []string{}
+		for _, arg := range reply.([]interface{}) {
+			args = append(args, string(arg.([]byte)))
+		}
+
+		r.lock.Lock()
+		r.commands = append(r.commands, args[0])
+		var response string
+		switch {
+		case args[0] == "AUTH":
+			if args[len(args)-1] == r.password {
+				authenticated = true
+				response = "+OK\r\n"
+			} else {
+				response = "-WRONGPASS invalid password\r\n"
+			}
+		case !authenticated:
+			response = "-NOAUTH Authentication required.\r\n"
+		case args[0] == "SELECT":
+			response = "+OK\r\n"
+		case args[0] == "SET":
+			r.data[args[1]] = args[2]
+			response = "+OK\r\n"
+		case args[0] == "GET":
+			if value, ok := r.data[args[1]]; ok {
+				response = "$" + strconv.Itoa(len(value)) + "\r\n" + value + "\r\n"
+			} else {
+				response = "$-1\r\n"
+			}
+		case args[0] == "DEL":
+			_, ok := r.data[args[1]]
+			delete(r.data, args[1])
+			if ok {
+				response = ":1\r\n"
+			} else {
+				response = ":0\r\n"
+			}
+		case args[0] == "SADD":
+			if r.sets[args[1]] == nil {
+				r.sets[args[1]] = map[string]bool{}
+			}
+			r.sets[args[1]][args[2]] = true
+			response = ":1\r\n"
+		case args[0] == "SREM":
+			delete(r.sets[args[1]], args[2])
+			response = ":1\r\n"
+		case args[0] == "SMEMBERS":
+			members := []string{}
+			for member := range r.sets[args[1]] {
+				members = append(members, "$"+strconv.Itoa(len(member))+"\r\n"+member+"\r\n")
+			}
+			response = "*" + strconv.Itoa(len(members)) + "\r\n" + strings.Join(members, "")
+		case args[0] == "EVAL" && args[1] == addMemberScript && args[2] == "1":
+			if r.sets[args[3]] == nil {
+				r.sets[args[3]] = map[string]bool{}
+			}
+			r.sets[args[3]][args[4]] = true
+			if ttl, _ := strconv.ParseInt(args[5], 10, 64); ttl > r.ttls[args[3]] {
+				r.ttls[args[3]] = ttl
+			}
+			response = ":1\r\n"
+		case args[0] == "EVAL" && args[1] == addIndexMemberScript && args[2] == "1":
+			if r.sets[args[3]] == nil {
+				r.sets[args[3]] = map[string]bool{}
+			}
+			r.sets[args[3]][args[4]] = true
+			if ttl, _ := strconv.ParseInt(args[5], 10, 64); ttl > r.ttls[args[3]] {
+				r.ttls[args[3]] = ttl
+			}
+			response = ":1\r\n"
+		case args[0] == "ZREM":
+			delete(r.sets[args[1]], args[2])
+			response = ":1\r\n"
+		case args[0] == "ZRANGEBYLEX":
+			sorted := []string{}
+			for member := range r.sets[args[1]] {
+				if args[2] == "-" || member > strings.TrimPrefix(args[2], "(") {
+					sorted = append(sorted, member)
+				}
+			}
+			sort.Strings(sorted)
+			if len(args) == 7 && args[4] == "LIMIT" {
+				if limit, _ := strconv.Atoi(args[6]); limit < len(sorted) {
+					sorted = sorted[:limit]
+				}
+			}
+			members := []string{}
+			for _, member := range sorted {
+				members = append(members, "$"+strconv.Itoa(len(member))+"\r\n"+member+"\r\n")
+			}
+			response = "*" + strconv.Itoa(len(members)) + "\r\n" + strings.Join(members, "")
+		default:
+			response = fmt.Sprintf("-ERR unknown command '%s'\r\n", args[0])
+		}
+		r.lock.Unlock()
+
+		if _, err := conn.Write([]byte(response)); err != nil {
+			return
+		}
+	}
+}
+
+func TestRedisBackend(t *testing.T) {
+	server := newFakeRedis(t, "secret")
+	backend := NewRedisBackend(RedisOptions{
+		Address:   server.listener.Addr().String(),
+		Password:  "secret",
+		DB:        2,
+		KeyPrefix: "oauth:",
+		Timeout:   time.Second,
+	})
+
+	value := "binary\r\n\x00value"
+	if err := backend.Set("key", []byte(value), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if server.data["oauth:key"] != value {
+		t.Errorf("expected prefixed key to be set, got %v", server.data)
+	}
+	got, err := backend.Get("key")
+	if err != nil || string(got) != value {
+		t.Errorf("expected %q, got %q %v", value, got, err)
+	}
+	if err := backend.Delete("key"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := backend.Get("key"); err != nil || got != nil {
+		t.Errorf("expected deleted key, got %q %v", got, err)
+	}
+
+	// the connection is reused, authentication and database selection happen once
+	if commands := strings.Join(server.commands, " "); commands != "AUTH SELECT SET GET DEL GET" {
+		t.Errorf("unexpected commands %s", commands)
+	}
+}
+
+func TestRedisBackendSets(t *testing.T) {
+	server := newFakeRedis(t, "")
+	backend := NewRedisBackend(RedisOptions{Address: server.listener.Addr().String(), KeyPrefix: "oauth:"})
+
+	for _, member := range []string{"a", "b"} {
+		if err := backend.AddMember("set", member, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := backend.AddMember("set", "b", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := server.ttls["oauth:set"]; ttl != time.Minute.Milliseconds() {
+		t.Errorf("expected the lifetime of the set to be only extended, got %dms", ttl)
+	}
+	// the member is added and the lifetime extended by a single atomic command
+	if commands := strings.Join(server.commands, " "); commands != "EVAL EVAL EVAL" {
+		t.Errorf("unexpected commands %s", commands)
+	}
+	if err := backend.RemoveMember("set", "a"); err != nil {
+		t.Fatal(err)
+	}
+	members, err := backend.Members("set")
+	if err != nil || strings.Join(members, ",") != "b" {
+		t.Errorf("expected members b, got %v %v", members, err)
+	}
+	if !server.sets["oauth:set"]["b"] {
+		t.Errorf("expected prefixed set, got %v", server.sets)
+	}
+	if members, err := backend.Members("other"); err != nil || len(members) != 0 {
+		t.Errorf("expected no members, got %v %v", members, err)
+	}
+}
+
+func TestRedisBackendIndexes(t *testing.T) {
+	server := newFakeRedis(t, "")
+	backend := NewRedisBackend(RedisOptions{Address: server.listener.Addr().String(), KeyPrefix: "oauth:"})
+
+	for _, member := range []string{"c", "a", "d", "b"} {
+		if err := backend.AddIndexMember("index", member, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := backend.RemoveIndexMember("index", "c"); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := server.ttls["oauth:index"]; ttl != time.Minute.Milliseconds() {
+		t.Errorf("expected the lifetime of the index to be set, got %dms", ttl)
+	}
+
+	for _, tc := range []struct {
+		after    string
+		limit    int
+		expected string
+	}{
+		{after: "", limit: 0, expected: "a,b,d"},
+		{after: "", limit: 2, expected: "a,b"},
+		{after: "b", limit: 2, expected: "d"},
+		{after: "d", limit: 2, expected: ""},
+	} {
+		members, err := backend.IndexMembers("index", tc.after, tc.limit)
+		if err != nil || strings.Join(members, ",") != tc.expected {
+			t.Errorf("expected members %q after %q, got %v %v", tc.expected, tc.after, members, err)
+		}
+	}
+}
+
+func TestRedisBackendWrongPassword(t *testing.T) {
+	server := newFakeRedis(t, "secret")
+	backend := NewRedisBackend(RedisOptions{Address: server.listener.Addr().String(), Password: "wrong"})
+	if _, err := backend.Get("key"); err == nil || !strings.Contains(err.Error(), "WRONGPASS") {
+		t.Errorf("expected authentication error, got %v", err)
+	}
+}
+
+func TestReadRedisReply(t *testing.T) {
+	testCases := []struct {
+		name     string
+		reply    string
+		expected interface{}
+		err      string
+	}{
+		{name: "simple string", reply: "+OK\r\n", expected: "OK"},
+		{name: "integer", reply: ":-1\r\n", expected: "-1"},
+		{name: "error", reply: "-ERR wrong type\r\n", err: "redis: ERR wrong type"},
+		{name: "bulk string", reply: "$7\r\na\r\nb\x00cd\r\n", expected: []byte("a\r\nb\x00cd")},
+		{name: "nil bulk string", reply: "$-1\r\n", expected: nil},
+		{name: "empty bulk string", reply: "$0\r\n\r\n", expected: []byte{}},
+		{name: "nil array", reply: "*-1\r\n", expected: nil},
+		{name: "nested array", reply: "*2\r\n*1\r\n:1\r\n$1\r\nb\r\n", expected: []interface{}{[]interface{}{"1"}, []byte("b")}},
+		{
+			name:     "error inside array",
+			reply:    "*3\r\n$1\r\na\r\n-ERR failed\r\n$1\r\nc\r\n",
+			expected: []interface{}{[]byte("a"), redisError("ERR failed"), []byte("c")},
+		},
+		{name: "missing carriage return", reply: "+OK\n", err: "malformed reply"},
+		{name: "bulk string longer than its size", reply: "$1\r\nab\r\n", err: "malformed bulk string"},
+		{name: "bulk string too large", reply: "$2000000\r\n", err: "invalid bulk size"},
+		{name: "invalid array size", reply: "*x\r\n", err: "invalid array size"},
+		{name: "unknown type", reply: "!3\r\nerr\r\n", err: "unknown reply type"},
+		{name: "truncated bulk string", reply: "$5\r\nab", err: "EOF"},
+		{name: "truncated array", reply: "*2\r\n:1\r\n", err: "EOF"},
+	}
+	for _, testCase := range testCases {
+		// the server may send replies in pieces, every read returns a single byte
+		reader := bufio.NewReaderSize(iotest.OneByteReader(strings.NewReader(testCase.reply)), 16)
+		reply, err := readRedisReply(reader)
+		if len(testCase.err) > 0 {
+			if err == nil || !strings.Contains(err.Error(), testCase.err) {
+				t.Errorf("%s: expected error %q, got %v %v", testCase.name, testCase.err, reply, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", testCase.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(reply, testCase.expected) {
+			t.Errorf("%s: expected %#v, got %#v", testCase.name, testCase.expected, reply)
+		}
+	}
+}
+
+func TestRedisBackendDiscardsBrokenConnections(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	connections := make(chan int, 2)
+	go func() {
+		for i := 0; ; i++ {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			connections <- i
+			go func(conn net.Conn, first bool) {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				if _, err := readRedisReply(reader); err != nil {
+					return
+				}
+				if first {
+					// a reply cut off in the middle of a bulk string
+					conn.Write([]byte("$5\r\nab"))
+					return
+				}
+				conn.Write([]byte("$5\r\nvalue\r\n"))
+			}(conn, i == 0)
+		}
+	}()
+
+	backend := NewRedisBackend(RedisOptions{Address: listener.Addr().String(), Timeout: time.Second})
+	if _, err := backend.Get("key"); err == nil {
+		t.Fatal("expected an error for a truncated reply")
+	}
+	value, err := backend.Get("key")
+	if err != nil || string(value) != "value" {
+		t.Errorf("expected the value from a new connection, got %q %v", value, err)
+	}
+	if len(connections) != 2 {
+		t.Errorf("expected the broken connection to be replaced, got %d connections", len(connections))
+	}
+}
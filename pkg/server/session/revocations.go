@@ -0,0 +1,93 @@
+package session
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// Revocations records users whose sessions are no longer valid. Sessions are stored in
+// cookies, so they cannot be deleted, instead sessions issued to a revoked user before
+// the revocation are rejected.
+type Revocations struct {
+	// maxAge is the longest lifetime of a session, older revocations are forgotten
+	maxAge time.Duration
+	// backend shares the revocations with the other servers, if set
+	backend Backend
+
+	lock    sync.RWMutex
+	revoked map[string]time.Time
+}
+
+// NewRevocations returns an empty revocation list for sessions that live at most maxAge
+func NewRevocations(maxAge time.Duration) *Revocations {
+	return NewSharedRevocations(maxAge, nil)
+}
+
+// NewSharedRevocations returns a revocation list like NewRevocations that also stores the
+// revocations in backend, if it is set, so that every server sharing the backend rejects
+// the revoked sessions and not only the server that revoked them
+func NewSharedRevocations(maxAge time.Duration, backend Backend) *Revocations {
+	return &Revocations{
+		maxAge:  maxAge,
+		backend: backend,
+		revoked: map[string]time.Time{},
+	}
+}
+
+// revocationKey is the key of the revocation of the user with the given UID in the backend.
+// Sessions are keyed by hashes prefixed with sha256~, so the keys cannot collide with them.
+func revocationKey(uid string) string {
+	return "revoked~" + uid
+}
+
+// Revoke invalidates all sessions of the user with the given UID issued up to now
+func (r *Revocations) Revoke(uid string) error {
+	now := time.Now()
+
+	r.lock.Lock()
+	// sessions issued before maxAge have expired anyway
+	for revokedUID, revokedAt := range r.revoked {
+		if now.Sub(revokedAt) > r.maxAge {
+			delete(r.revoked, revokedUID)
+		}
+	}
+	r.revoked[uid] = now
+	r.lock.Unlock()
+
+	if r.backend == nil {
+		return nil
+	}
+	return r.backend.Set(revocationKey(uid), []byte(strconv.FormatInt(now.UnixNano(), 10)), r.maxAge)
+}
+
+// Revoked returns true if the session of the user with the given UID issued at the given time was revoked.
+// Sessions are treated as revoked if the shared revocations cannot be read.
+func (r *Revocations) Revoked(uid string, issuedAt time.Time) bool {
+	r.lock.RLock()
+	revokedAt, ok := r.revoked[uid]
+	r.lock.RUnlock()
+	if ok && !issuedAt.After(revokedAt) {
+		return true
+	}
+
+	if r.backend == nil {
+		return false
+	}
+	value, err := r.backend.Get(revocationKey(uid))
+	if err != nil {
+		klog.Errorf("error reading the session revocation of user %q: %v", uid, err)
+		return true
+	}
+	if value == nil {
+		return false
+	}
+	revokedAtNanos, err := strconv.ParseInt(string(value), 10, 64)
+	if err != nil {
+		klog.Errorf("invalid session revocation of user %q: %v", uid, err)
+		return true
+	}
+	return !issuedAt.After(time.Unix(0, revokedAtNanos))
+}
@@ -0,0 +1,52 @@
+package session
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// failingBackend fails to read and write values
+type failingBackend struct {
+	Backend
+}
+
+func (failingBackend) Get(key string) ([]byte, error) {
+	return nil, errors.New("unavailable")
+}
+
+func (failingBackend) Set(key string, values []byte, ttl time.Duration) error {
+	return errors.New("unavailable")
+}
+
+func TestSharedRevocations(t *testing.T) {
+	backend := NewMemoryBackend()
+	revoking, other := NewSharedRevocations(time.Hour, backend), NewSharedRevocations(time.Hour, backend)
+	issuedAt := time.Now().Add(-time.Second)
+
+	if err := revoking.Revoke("bob-uid"); err != nil {
+		t.Fatal(err)
+	}
+	for name, revocations := range map[string]*Revocations{"revoking": revoking, "other": other} {
+		if !revocations.Revoked("bob-uid", issuedAt) {
+			t.Errorf("%s: expected earlier sessions to be revoked", name)
+		}
+		if revocations.Revoked("bob-uid", time.Now().Add(time.Minute)) {
+			t.Errorf("%s: expected later sessions to be valid", name)
+		}
+		if revocations.Revoked("alice-uid", issuedAt) {
+			t.Errorf("%s: expected the sessions of other users to be valid", name)
+		}
+	}
+	if NewRevocations(time.Hour).Revoked("bob-uid", issuedAt) {
+		t.Error("expected revocations without a backend not to be shared")
+	}
+
+	failing := NewSharedRevocations(time.Hour, failingBackend{})
+	if err := failing.Revoke("bob-uid"); err == nil {
+		t.Error("expected revoking to fail if the revocation cannot be shared")
+	}
+	if !failing.Revoked("alice-uid", issuedAt) {
+		t.Error("expected sessions to be rejected if the revocations cannot be read")
+	}
+}
@@ -0,0 +1,280 @@
+package session
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/openshift/oauth-server/pkg/server/cookies"
+	"github.com/openshift/oauth-server/pkg/server/crypto"
+)
+
+// sessionIDKey is the only value stored in the cookies of server-side sessions
+const sessionIDKey = "session.id"
+
+// Backend stores the values of server-side sessions. Sessions are keyed by the hash of their ID,
+// so that the contents of the backend cannot be used to hijack sessions.
+type Backend interface {
+	// Get returns the values stored under key, or nil if there are none
+	Get(key string) ([]byte, error)
+	// Set stores the values under key until the ttl expired
+	Set(key string, values []byte, ttl time.Duration) error
+	// Delete removes the values stored under key, if any
+	Delete(key string) error
+
+	// AddMember adds member to the set stored under key and extends the lifetime of the set to ttl
+	AddMember(key, member string, ttl time.Duration) error
+	// Members returns the members of the set stored under key
+	Members(key string) ([]string, error)
+	// RemoveMember removes member from the set stored under key, if it is a member
+	RemoveMember(key, member string) error
+
+	// AddIndexMember adds member to the index stored under key and extends the lifetime of the index to ttl. Indexes
+	// are sets that are read in pages, their members are sorted by their bytes.
+	AddIndexMember(key, member string, ttl time.Duration) error
+	// IndexMembers returns up to limit members of the index stored under key that sort after the member after, from
+	// the first member if after is empty. A limit of zero returns all of them.
+	IndexMembers(key, after string, limit int) ([]string, error)
+	// RemoveIndexMember removes member from the index stored under key, if it is a member
+	RemoveIndexMember(key, member string) error
+}
+
+// userSessionsKey is the key of the set of sessions of the user with the given UID. Sessions
+// are keyed by hashes prefixed with sha256~, so the keys of the sets cannot collide with them.
+func userSessionsKey(uid string) string {
+	return "user~" + uid
+}
+
+// usedKey is the key of the time the session stored under key was last used. It is kept apart
+// from the session values so that recording the use never recreates a replaced session.
+func usedKey(key string) string {
+	return "used~" + key
+}
+
+type serverStore struct {
+	// name of the cookie used for the session ID
+	name string
+	// keys sign and encrypt the session ID in the cookie
+	keys *Keys
+	// options are the attributes of the cookie
+	options cookies.Options
+	backend Backend
+	limit   Limit
+}
+
+// Limit restricts the number of sessions per user. It is checked before each session of a user is stored,
+// concurrent logins of the same user may exceed it.
+type Limit struct {
+	// MaxSessionsPerUser is the number of sessions a user may hold, there is no limit if it is 0
+	MaxSessionsPerUser int
+	// EvictOldest removes the oldest sessions of the user to make room for a new one, otherwise it is rejected
+	EvictOldest bool
+}
+
+// NewServerStore returns a store that keeps session values in the backend. The session cookie only
+// carries a random session ID, a new one is issued whenever the session changes. Replaced sessions
+// are deleted from the backend, which makes logouts effective even if the old cookie is replayed.
+func NewServerStore(name string, options cookies.Options, backend Backend, keys *Keys) Store {
+	return NewServerStoreWithLimit(name, options, backend, keys, Limit{})
+}
+
+// NewServerStoreWithLimit returns a server store that enforces the limit whenever a session of a user is stored
+func NewServerStoreWithLimit(name string, options cookies.Options, backend Backend, keys *Keys, limit Limit) Store {
+	// like the cookie store, expiration information is part of the session values
+	return &serverStore{name: name, keys: keys, options: options, backend: backend, limit: limit}
+}
+
+func (s *serverStore) sessionKey(r *http.Request) (string, bool) {
+	if r == nil {
+		return "", false
+	}
+	// always use New to avoid global state
+	session, err := s.keys.cookieStore().New(r, s.name)
+	if err != nil {
+		// see store.Get, junk cookies are ignored
+		klog.V(4).Infof("failed to decode secure session cookie %s: %v", s.name, err)
+		return "", false
+	}
+	id, _ := session.Values[sessionIDKey].(string)
+	if len(id) == 0 {
+		return "", false
+	}
+	return crypto.SHA256Token(id), true
+}
+
+func (s *serverStore) Get(r *http.Request) Values {
+	key, ok := s.sessionKey(r)
+	if !ok {
+		return Values{}
+	}
+	values, err := s.get(key)
+	if err != nil {
+		klog.Errorf("failed to get session %s from backend: %v", s.name, err)
+		return Values{}
+	}
+	return values
+}
+
+// get returns the values of the session stored under key, empty values if there is none
+func (s *serverStore) get(key string) (Values, error) {
+	data, err := s.backend.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return Values{}, nil
+	}
+	values := Values{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&values); err != nil {
+		klog.V(4).Infof("failed to decode session %s: %v", s.name, err)
+		return Values{}, nil
+	}
+	return values, nil
+}
+
+func (s *serverStore) Put(w http.ResponseWriter, r *http.Request, v Values) error {
+	// the previous session is replaced, never reuse its ID
+	if key, ok := s.sessionKey(r); ok {
+		if err := s.backend.Delete(key); err != nil {
+			return err
+		}
+		if err := s.backend.Delete(usedKey(key)); err != nil {
+			return err
+		}
+	}
+
+	cookieValues := Values{}
+	if expires := v.expires(); expires > 0 {
+		if ttl := time.Until(time.Unix(expires, 0)); ttl > 0 {
+			var data bytes.Buffer
+			if err := gob.NewEncoder(&data).Encode(v); err != nil {
+				return err
+			}
+			id := crypto.Random256BitsString()
+			key := crypto.SHA256Token(id)
+			uid, hasUID := v.GetString(userUIDKey)
+			// the replaced session was deleted above, so it does not count against the limit
+			if hasUID {
+				if err := s.admit(uid); err != nil {
+					return err
+				}
+			}
+			if err := s.backend.Set(key, data.Bytes(), ttl); err != nil {
+				return err
+			}
+			if hasUID {
+				if err := s.backend.AddMember(userSessionsKey(uid), key, ttl); err != nil {
+					return err
+				}
+			}
+			cookieValues[sessionIDKey] = id
+		}
+	}
+
+	return saveCookie(w, s.keys, s.name, cookieValues, s.options)
+}
+
+// admit makes room for a new session of the user or returns an error if it is rejected
+func (s *serverStore) admit(uid string) error {
+	if s.limit.MaxSessionsPerUser <= 0 {
+		return nil
+	}
+	sessions, err := s.ListSessions(uid)
+	if err != nil {
+		return err
+	}
+	if len(sessions) < s.limit.MaxSessionsPerUser {
+		return nil
+	}
+	if !s.limit.EvictOldest {
+		return fmt.Errorf("user with UID %q holds the maximum of %d sessions", uid, s.limit.MaxSessionsPerUser)
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].IssuedAt.Before(sessions[j].IssuedAt)
+	})
+	for _, session := range sessions[:len(sessions)-s.limit.MaxSessionsPerUser+1] {
+		if _, err := s.RemoveSession(uid, session.ID); err != nil {
+			return fmt.Errorf("error evicting session of user with UID %q: %v", uid, err)
+		}
+		klog.V(4).Infof("evicted session of user with UID %q issued at %v", uid, session.IssuedAt)
+	}
+	return nil
+}
+
+var _ SessionLister = &serverStore{}
+
+// ListSessions returns the sessions of the user. Sessions that were replaced or expired
+// since they were added to the set of sessions of the user are removed from it.
+func (s *serverStore) ListSessions(uid string) ([]SessionInfo, error) {
+	keys, err := s.backend.Members(userSessionsKey(uid))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Unix()
+	sessions := []SessionInfo{}
+	for _, key := range keys {
+		values, err := s.get(key)
+		if err != nil {
+			return nil, err
+		}
+		expires, _ := values.GetInt64(expKey)
+		if sessionUID, _ := values.GetString(userUIDKey); sessionUID != uid || expires < now {
+			if err := s.backend.RemoveMember(userSessionsKey(uid), key); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		info := SessionInfo{ID: key, ExpiresAt: time.Unix(expires, 0)}
+		if issuedAt, ok := values.GetInt64(iatKey); ok {
+			info.IssuedAt = time.Unix(issuedAt, 0)
+		}
+		info.UserAgent, _ = values.GetString(userAgentKey)
+		info.Provider, _ = values.GetString(providerNameKey)
+		if used, err := s.backend.Get(usedKey(key)); err != nil {
+			return nil, err
+		} else if usedAt, err := strconv.ParseInt(string(used), 10, 64); err == nil {
+			info.LastUsedAt = time.Unix(usedAt, 0)
+		}
+		sessions = append(sessions, info)
+	}
+	return sessions, nil
+}
+
+// RemoveSession deletes the session with the given ID if it belongs to the user
+func (s *serverStore) RemoveSession(uid, id string) (bool, error) {
+	values, err := s.get(id)
+	if err != nil {
+		return false, err
+	}
+	if sessionUID, _ := values.GetString(userUIDKey); sessionUID != uid {
+		return false, nil
+	}
+	if err := s.backend.Delete(id); err != nil {
+		return false, err
+	}
+	if err := s.backend.Delete(usedKey(id)); err != nil {
+		return false, err
+	}
+	return true, s.backend.RemoveMember(userSessionsKey(uid), id)
+}
+
+// recordUse records that the session of the request authenticated a request until it expires
+func (s *serverStore) recordUse(r *http.Request, expires time.Time) {
+	key, ok := s.sessionKey(r)
+	if !ok {
+		return
+	}
+	now := time.Now()
+	if err := s.backend.Set(usedKey(key), []byte(strconv.FormatInt(now.Unix(), 10)), expires.Sub(now)); err != nil {
+		klog.V(4).Infof("failed to record use of session %s: %v", s.name, err)
+	}
+}
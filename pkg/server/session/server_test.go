@@ -0,0 +1,240 @@
+package session
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"k8s.io/apiserver/pkg/authentication/user"
+
+	"github.com/openshift/oauth-server/pkg/oauth/handlers"
+	"github.com/openshift/oauth-server/pkg/server/cookies"
+)
+
+// requestWithCookies returns a request carrying the cookies set by the response
+func requestWithCookies(w *httptest.ResponseRecorder) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, cookie := range w.Result().Cookies() {
+		req.AddCookie(cookie)
+	}
+	return req
+}
+
+func TestServerStore(t *testing.T) {
+	backend := NewMemoryBackend().(*memoryBackend)
+	store := NewServerStore("ssn", cookies.Options{Secure: true}, backend, NewKeys([]byte("0123456789abcdef0123456789abcdef")))
+	authenticator := NewAuthenticator(store, time.Hour, nil)
+
+	// log in
+	w := httptest.NewRecorder()
+	if _, err := authenticator.AuthenticationSucceeded(handlers.WithIdentityProvider(&user.DefaultInfo{Name: "bob", UID: "bob-uid"}, "htpasswd"), "", w, httptest.NewRequest(http.MethodGet, "/", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if len(backend.entries) != 1 {
+		t.Fatalf("expected one stored session, got %d", len(backend.entries))
+	}
+	loggedIn := requestWithCookies(w)
+
+	response, ok, err := authenticator.AuthenticateRequest(loggedIn)
+	if err != nil || !ok {
+		t.Fatalf("expected session to authenticate, got %v %v", ok, err)
+	}
+	if response.User.GetName() != "bob" || response.User.GetUID() != "bob-uid" {
+		t.Errorf("unexpected user %#v", response.User)
+	}
+	if identityProvider, ok := response.User.(handlers.IdentityProvider); !ok || identityProvider.GetIdentityProvider() != "htpasswd" {
+		t.Errorf("expected the session to keep the identity provider, got %#v", response.User)
+	}
+
+	// log out, the old cookie must not work anymore
+	w = httptest.NewRecorder()
+	if err := authenticator.InvalidateAuthentication(w, loggedIn, &user.DefaultInfo{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(backend.entries) != 0 {
+		t.Errorf("expected session to be deleted, got %d sessions", len(backend.entries))
+	}
+	if _, ok, _ := authenticator.AuthenticateRequest(loggedIn); ok {
+		t.Error("expected replayed session to be rejected after logout")
+	}
+	if _, ok, _ := authenticator.AuthenticateRequest(requestWithCookies(w)); ok {
+		t.Error("expected logged out session to be rejected")
+	}
+}
+
+func TestMemoryBackendExpiry(t *testing.T) {
+	backend := NewMemoryBackend()
+	if err := backend.Set("key", []byte("value"), time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if value, err := backend.Get("key"); err != nil || value != nil {
+		t.Errorf("expected expired session, got %q %v", value, err)
+	}
+}
+
+func TestServerStoreProviderSession(t *testing.T) {
+	backend := NewMemoryBackend().(*memoryBackend)
+	store := NewServerStore("ssn", cookies.Options{Secure: true}, backend, NewKeys([]byte("0123456789abcdef0123456789abcdef")))
+	authenticator := NewAuthenticator(store, time.Hour, nil)
+
+	// log in with an identity provider
+	w := httptest.NewRecorder()
+	req := WithProviderSession(httptest.NewRequest(http.MethodGet, "/", nil), ProviderSession{Provider: "oidc", IDToken: "idtoken"})
+	if _, err := authenticator.AuthenticationSucceeded(&user.DefaultInfo{Name: "bob", UID: "bob-uid"}, "", w, req); err != nil {
+		t.Fatal(err)
+	}
+
+	loggedIn := requestWithCookies(w)
+
+	// removing the authentication keeps the provider session
+	w = httptest.NewRecorder()
+	if err := authenticator.InvalidateAuthentication(w, loggedIn, &user.DefaultInfo{}); err != nil {
+		t.Fatal(err)
+	}
+	invalidated := requestWithCookies(w)
+	if _, ok, _ := authenticator.AuthenticateRequest(invalidated); ok {
+		t.Error("expected invalidated session to be rejected")
+	}
+
+	// logging out returns and removes it
+	w = httptest.NewRecorder()
+	providerSession, err := authenticator.Logout(w, invalidated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if providerSession == nil || *providerSession != (ProviderSession{Provider: "oidc", IDToken: "idtoken"}) {
+		t.Errorf("unexpected provider session %#v", providerSession)
+	}
+	if len(backend.entries) != 0 {
+		t.Errorf("expected session to be deleted, got %d sessions", len(backend.entries))
+	}
+}
+
+func TestServerStoreListSessions(t *testing.T) {
+	backend := NewMemoryBackend()
+	store := NewServerStore("ssn", cookies.Options{Secure: true}, backend, NewKeys([]byte("0123456789abcdef0123456789abcdef")))
+	lister := store.(SessionLister)
+	authenticator := NewAuthenticator(store, time.Hour, nil)
+
+	login := func(name, uid, userAgent string) *http.Request {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("User-Agent", userAgent)
+		if _, err := authenticator.AuthenticationSucceeded(&user.DefaultInfo{Name: name, UID: uid}, "", w, req); err != nil {
+			t.Fatal(err)
+		}
+		return requestWithCookies(w)
+	}
+	laptop := login("bob", "bob-uid", "laptop")
+	phone := login("bob", "bob-uid", "phone")
+	login("alice", "alice-uid", "laptop")
+
+	// logging in again from the laptop replaces its session
+	laptop.Header.Set("User-Agent", "laptop")
+	w := httptest.NewRecorder()
+	if _, err := authenticator.AuthenticationSucceeded(&user.DefaultInfo{Name: "bob", UID: "bob-uid"}, "", w, laptop); err != nil {
+		t.Fatal(err)
+	}
+	laptop = requestWithCookies(w)
+
+	if _, ok, _ := authenticator.AuthenticateRequest(phone); !ok {
+		t.Fatal("expected phone session to authenticate")
+	}
+
+	sessions, err := lister.ListSessions("bob-uid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("expected two sessions, got %#v", sessions)
+	}
+	var phoneSession SessionInfo
+	for _, session := range sessions {
+		if session.UserAgent == "phone" {
+			phoneSession = session
+		}
+		if session.IssuedAt.IsZero() || session.ExpiresAt.Before(time.Now()) {
+			t.Errorf("unexpected session %#v", session)
+		}
+	}
+	if phoneSession.LastUsedAt.IsZero() {
+		t.Errorf("expected use of phone session to be recorded, got %#v", phoneSession)
+	}
+
+	// sessions of other users cannot be removed
+	if removed, err := lister.RemoveSession("alice-uid", phoneSession.ID); err != nil || removed {
+		t.Errorf("expected session of other user not to be removed, got %v %v", removed, err)
+	}
+	if removed, err := lister.RemoveSession("bob-uid", phoneSession.ID); err != nil || !removed {
+		t.Errorf("expected session to be removed, got %v %v", removed, err)
+	}
+	if _, ok, _ := authenticator.AuthenticateRequest(phone); ok {
+		t.Error("expected removed session to be rejected")
+	}
+	if _, ok, _ := authenticator.AuthenticateRequest(laptop); !ok {
+		t.Error("expected other session to still authenticate")
+	}
+	if sessions, err := lister.ListSessions("bob-uid"); err != nil || len(sessions) != 1 || sessions[0].UserAgent != "laptop" {
+		t.Errorf("expected only the laptop session, got %#v %v", sessions, err)
+	}
+}
+
+func TestServerStoreLimit(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		evictOldest    bool
+		expectRejected bool
+		expectAgents   []string
+	}{
+		{name: "reject", expectRejected: true, expectAgents: []string{"laptop", "phone"}},
+		{name: "evict oldest", evictOldest: true, expectAgents: []string{"laptop", "tablet"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			store := NewServerStoreWithLimit("ssn", cookies.Options{Secure: true}, NewMemoryBackend(), NewKeys([]byte("0123456789abcdef0123456789abcdef")), Limit{MaxSessionsPerUser: 2, EvictOldest: tc.evictOldest})
+			lister := store.(SessionLister)
+			authenticator := NewAuthenticator(store, time.Hour, nil)
+
+			login := func(req *http.Request, userAgent string) (*http.Request, error) {
+				w := httptest.NewRecorder()
+				req.Header.Set("User-Agent", userAgent)
+				if _, err := authenticator.AuthenticationSucceeded(&user.DefaultInfo{Name: "bob", UID: "bob-uid"}, "", w, req); err != nil {
+					return nil, err
+				}
+				return requestWithCookies(w), nil
+			}
+			laptop, err := login(httptest.NewRequest(http.MethodGet, "/", nil), "laptop")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := login(httptest.NewRequest(http.MethodGet, "/", nil), "phone"); err != nil {
+				t.Fatal(err)
+			}
+			// a session that is replaced does not count against the limit, the new one is newer than the phone
+			// session as sessions are ordered by their issue time in seconds
+			time.Sleep(time.Second)
+			if laptop, err = login(laptop, "laptop"); err != nil {
+				t.Fatalf("expected logging in again to replace the session, got %v", err)
+			}
+			if _, err := login(httptest.NewRequest(http.MethodGet, "/", nil), "tablet"); (err != nil) != tc.expectRejected {
+				t.Fatalf("expected rejected %v, got %v", tc.expectRejected, err)
+			}
+
+			sessions, err := lister.ListSessions("bob-uid")
+			if err != nil {
+				t.Fatal(err)
+			}
+			agents := []string{}
+			for _, session := range sessions {
+				agents = append(agents, session.UserAgent)
+			}
+			sort.Strings(agents)
+			if !reflect.DeepEqual(agents, tc.expectAgents) {
+				t.Errorf("expected sessions %v, got %v", tc.expectAgents, agents)
+			}
+		})
+	}
+}
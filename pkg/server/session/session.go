@@ -3,8 +3,10 @@ package session
 import (
 	"net/http"
 
-	"github.com/gorilla/sessions"
+	"github.com/gorilla/securecookie"
 	"k8s.io/klog/v2"
+
+	"github.com/openshift/oauth-server/pkg/server/cookies"
 )
 
 type store struct {
@@ -13,22 +15,22 @@ type store struct {
 	// do not use store's Get method, it mucks with global state for caching purposes
 	// decoding a single small cookie multiple times is not the end of the world
 	// currently we do not have any single request paths that decode the cookie multiple times
-	store sessions.Store
+	keys *Keys
+	// options are the attributes of the cookie
+	options cookies.Options
 }
 
-func NewStore(name string, secure bool, secrets ...[]byte) Store {
-	cookie := sessions.NewCookieStore(secrets...)
+// NewStore returns a store that keeps the session values in the named cookie, encoded with the keys.
+// The name must already include the prefix of the options.
+func NewStore(name string, options cookies.Options, keys *Keys) Store {
 	// we encode expiration information into the cookie data to avoid browser bugs
-	// since we do not set the Expires or Max-Age attributes, all cookies created by this store are session cookies
-	cookie.Options.MaxAge = 0
-	cookie.Options.HttpOnly = true
-	cookie.Options.Secure = secure
-	return &store{name: name, store: cookie}
+	// since the options never set the Expires or Max-Age attributes, all cookies created by this store are session cookies
+	return &store{name: name, keys: keys, options: options}
 }
 
 func (s *store) Get(r *http.Request) Values {
 	// always use New to avoid global state
-	session, err := s.store.New(r, s.name)
+	session, err := s.keys.cookieStore().New(r, s.name)
 	if err != nil {
 		// ignore all errors, this could occur from poorly handling key rotation.
 		// depending on how keys are incorrectly rotated,
@@ -49,18 +51,16 @@ func (s *store) Get(r *http.Request) Values {
 	return session.Values
 }
 
-func (s *store) Put(w http.ResponseWriter, v Values) error {
-	// build a session from an empty request to avoid any decoding overhead
-	// always use New to avoid global state
-	r := &http.Request{}
-	session, err := s.store.New(r, s.name)
+func (s *store) Put(w http.ResponseWriter, _ *http.Request, v Values) error {
+	return saveCookie(w, s.keys, s.name, v, s.options)
+}
+
+// saveCookie writes the values to the named cookie, encoded like the cookie store encodes sessions
+func saveCookie(w http.ResponseWriter, keys *Keys, name string, v Values, options cookies.Options) error {
+	encoded, err := securecookie.EncodeMulti(name, map[interface{}]interface{}(v), keys.cookieStore().Codecs...)
 	if err != nil {
 		return err
 	}
-
-	// override the values for the session
-	session.Values = v
-
-	// write the encoded cookie, the request parameter is ignored
-	return s.store.Save(r, w, session)
+	options.Set(w, options.New(name, encoded))
+	return nil
 }
@@ -2,6 +2,7 @@ package session
 
 import (
 	"net/http"
+	"time"
 
 	"k8s.io/apiserver/pkg/authentication/authenticator"
 	"k8s.io/apiserver/pkg/authentication/user"
@@ -13,8 +14,8 @@ import (
 type Store interface {
 	// Get and decode the Values associated with the given request
 	Get(r *http.Request) Values
-	// Put encodes and writes the given Values to the response
-	Put(w http.ResponseWriter, v Values) error
+	// Put encodes and writes the given Values to the response, replacing the session of the request
+	Put(w http.ResponseWriter, r *http.Request, v Values) error
 }
 
 type Values map[interface{}]interface{}
@@ -30,11 +31,49 @@ func (v Values) GetInt64(key string) (int64, bool) {
 }
 
 type SessionInvalidator interface {
-	InvalidateAuthentication(w http.ResponseWriter, user user.Info) error
+	InvalidateAuthentication(w http.ResponseWriter, req *http.Request, user user.Info) error
+}
+
+// SessionTerminator ends sessions when users log out
+type SessionTerminator interface {
+	// Logout removes the session of the request and returns the session at the
+	// identity provider the user logged in with, if it is known
+	Logout(w http.ResponseWriter, req *http.Request) (*ProviderSession, error)
+}
+
+// SessionInfo describes an active session of a user
+type SessionInfo struct {
+	// ID identifies the session, it cannot be used to authenticate as the user
+	ID string
+	// IssuedAt is the time the user logged in
+	IssuedAt time.Time
+	// ExpiresAt is the time the session expires
+	ExpiresAt time.Time
+	// LastUsedAt is the time the session last authenticated a request, zero if it never did
+	LastUsedAt time.Time
+	// UserAgent is the user agent of the browser that logged in
+	UserAgent string
+	// Provider is the identity provider the user logged in with, if it is known
+	Provider string
+}
+
+// SessionLister lists and removes the sessions of users. Only sessions stored on the server can be listed.
+type SessionLister interface {
+	// ListSessions returns the active sessions of the user with the given UID
+	ListSessions(uid string) ([]SessionInfo, error)
+	// RemoveSession removes the session with the given ID. It returns false if the
+	// session does not exist or does not belong to the user with the given UID.
+	RemoveSession(uid, id string) (bool, error)
+}
+
+// usageRecorder is implemented by stores that record when sessions are used
+type usageRecorder interface {
+	recordUse(r *http.Request, expires time.Time)
 }
 
 type SessionAuthenticator interface {
 	authenticator.Request
 	handlers.AuthenticationSuccessHandler
 	SessionInvalidator
+	SessionTerminator
 }
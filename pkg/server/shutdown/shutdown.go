@@ -0,0 +1,60 @@
+// Package shutdown lets the logins that are in progress complete while the server shuts down, and sends new
+// logins to other replicas.
+package shutdown
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// retryAfter is the delay clients are asked to wait before they retry rejected requests. The load balancer has
+// usually stopped sending requests to the server by then.
+const retryAfter = 5 * time.Second
+
+// Gate is closed once the server starts to shut down
+type Gate struct {
+	closed chan struct{}
+	once   sync.Once
+}
+
+// NewGate returns an open gate
+func NewGate() *Gate {
+	return &Gate{closed: make(chan struct{})}
+}
+
+// Close marks the start of the shutdown, it may be called several times
+func (g *Gate) Close() {
+	g.once.Do(func() {
+		close(g.closed)
+	})
+}
+
+// ShuttingDown returns true once the gate was closed
+func (g *Gate) ShuttingDown() bool {
+	select {
+	case <-g.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// WithGate rejects the requests that startsLogin returns true for once the gate is closed. Clients are asked to
+// retry on a new connection, which the load balancer sends to another replica. All other requests are served,
+// e.g. the callbacks of identity providers for logins that started before.
+func WithGate(handler http.Handler, gate *Gate, startsLogin func(*http.Request) bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if gate.ShuttingDown() && startsLogin(req) {
+			klog.V(4).Infof("Rejecting new login %s while shutting down", req.URL.Path)
+			w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
+			w.Header().Set("Connection", "close")
+			http.Error(w, "The server is shutting down, please try again.", http.StatusServiceUnavailable)
+			return
+		}
+		handler.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,39 @@
+package shutdown
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWithGate(t *testing.T) {
+	gate := NewGate()
+	handler := WithGate(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}), gate, func(req *http.Request) bool {
+		return strings.HasPrefix(req.URL.Path, "/oauth/authorize")
+	})
+	serve := func(path string) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, path, nil))
+		return resp
+	}
+
+	if resp := serve("/oauth/authorize"); resp.Code != http.StatusOK {
+		t.Errorf("expected logins to start before the shutdown, got %d", resp.Code)
+	}
+
+	gate.Close()
+	gate.Close()
+	if !gate.ShuttingDown() {
+		t.Fatal("expected the gate to be closed")
+	}
+	resp := serve("/oauth/authorize")
+	if resp.Code != http.StatusServiceUnavailable || resp.Header().Get("Retry-After") != "5" || resp.Header().Get("Connection") != "close" {
+		t.Errorf("expected new logins to be sent to other replicas, got %d %v", resp.Code, resp.Header())
+	}
+	if resp := serve("/oauth2callback/github"); resp.Code != http.StatusOK {
+		t.Errorf("expected logins in progress to complete, got %d", resp.Code)
+	}
+}
@@ -0,0 +1,58 @@
+package terms
+
+import "html/template"
+
+var defaultTermsTemplate = template.Must(template.New("defaultTermsForm").Parse(defaultTermsTemplateString))
+
+const defaultTermsTemplateString = `<!DOCTYPE html>
+
+<html lang="{{ or .Locale.Lang "en" }}">
+
+<head>
+    <meta charset="UTF-8">
+    <meta http-equiv="X-UA-Compatible" content="IE=edge">
+    <title>{{ .Locale.TermsOfService }}</title>
+    <style>
+        body    { font-family: sans-serif; line-height: 1.2em; margin: 2em 5%; color: #363636; }
+
+        h1 { font-weight: normal; line-height: 1.3em; }
+
+        .terms { white-space: pre-wrap; max-width: 600px; max-height: 60vh; overflow-y: auto; padding: 1em; border: 1px solid #d1d1d1; }
+
+        input[type=submit]                      { font-size: 1em; margin-top: 2em; }
+        input[type=submit] + input[type=submit] { margin-left: 1em; }
+
+        .error { color: #cc0000; }
+
+        @media (max-width:481px) {
+          body { margin: .5em; }
+          h1 { margin: 0; padding-bottom: .5em; font-size: 1.5em; }
+          input[type=submit] { display: block; width: 100%; margin: 1em 0 !important; }
+        }
+    </style>
+</head>
+
+<body>
+
+{{ if .Error }}
+<div class="error">{{ .Error }}</div>
+{{ else }}
+<form action="{{ .Action }}" method="POST">
+  <input type="hidden" name="{{ .Names.Then    }}" value="{{ .Values.Then    }}">
+  <input type="hidden" name="{{ .Names.CSRF    }}" value="{{ .Values.CSRF    }}">
+  <input type="hidden" name="{{ .Names.Version }}" value="{{ .Values.Version }}">
+
+  <h1>{{ .Locale.TermsOfService }}</h1>
+
+  <div class="terms">{{ .Terms }}</div>
+
+  <div>
+    <input type="submit" name="{{ .Names.Accept  }}" value="{{ .Locale.Accept }}">
+    <input type="submit" name="{{ .Names.Decline }}" value="{{ .Locale.Decline }}">
+  </div>
+</form>
+{{ end }}
+
+</body>
+</html>
+`
@@ -0,0 +1,251 @@
+// Package terms serves the page that asks users to accept the terms of service before they are logged in.
+package terms
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"path"
+
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apiserver/pkg/authentication/authenticator"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/klog/v2"
+
+	oauthserver "github.com/openshift/oauth-server/pkg"
+	"github.com/openshift/oauth-server/pkg/server/csrf"
+	"github.com/openshift/oauth-server/pkg/server/locales"
+	"github.com/openshift/oauth-server/pkg/server/redirect"
+)
+
+const (
+	thenParam    = "then"
+	csrfParam    = "csrf"
+	versionParam = "version"
+	acceptParam  = "accept"
+	declineParam = "decline"
+)
+
+// TermsRecorder records that users accepted the terms of service
+type TermsRecorder interface {
+	Accept(user user.Info, version string) error
+}
+
+// FormRenderer is responsible for rendering a Form to ask the user to accept the terms of service
+type FormRenderer interface {
+	Render(form Form, w http.ResponseWriter, req *http.Request)
+}
+
+type Form struct {
+	Action string
+	Error  string
+
+	// Terms is the text of the terms of service
+	Terms string
+
+	Names  FormFields
+	Values FormFields
+
+	Locale locales.Localization
+}
+
+type FormFields struct {
+	Then    string
+	CSRF    string
+	Version string
+	Accept  string
+	Decline string
+}
+
+type Terms struct {
+	auth     authenticator.Request
+	csrf     csrf.CSRF
+	render   FormRenderer
+	recorder TermsRecorder
+	version  string
+	text     string
+}
+
+// NewTerms returns the page that shows the text of the given version of the terms of service. Users that
+// accept them are recorded and return to the "then" URL, users that decline them return to the "then" URL
+// with an access_denied error.
+func NewTerms(csrf csrf.CSRF, auth authenticator.Request, render FormRenderer, recorder TermsRecorder, version, text string) *Terms {
+	return &Terms{
+		auth:     auth,
+		csrf:     csrf,
+		render:   render,
+		recorder: recorder,
+		version:  version,
+		text:     text,
+	}
+}
+
+func (t *Terms) Install(mux oauthserver.Mux, prefix string) {
+	mux.Handle(prefix, t)
+}
+
+func (t *Terms) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	authResponse, ok, err := t.auth.AuthenticateRequest(req)
+	if err != nil || !ok {
+		t.failed("You must reauthenticate before continuing", w, req)
+		return
+	}
+	switch req.Method {
+	case http.MethodGet:
+		t.handleForm(w, req)
+	case http.MethodPost:
+		t.handleAccept(authResponse.User, w, req)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (t *Terms) handleForm(w http.ResponseWriter, req *http.Request) {
+	// Submit to the current path, so we can target this page even via an auth proxy.
+	// Depends on any auth proxies matching at least the last segment of the URL.
+	_, lastSegment := path.Split(req.URL.Path)
+
+	form := Form{
+		Action: lastSegment,
+		Terms:  t.text,
+		Names: FormFields{
+			Then:    thenParam,
+			CSRF:    csrfParam,
+			Version: versionParam,
+			Accept:  acceptParam,
+			Decline: declineParam,
+		},
+		Values: FormFields{
+			Then:    req.URL.Query().Get(thenParam),
+			CSRF:    t.csrf.Generate(w, req),
+			Version: t.version,
+		},
+	}
+	t.render.Render(form, w, req)
+}
+
+func (t *Terms) handleAccept(user user.Info, w http.ResponseWriter, req *http.Request) {
+	if ok := t.csrf.Check(req, req.PostFormValue(csrfParam)); !ok {
+		klog.V(4).Infof("Invalid CSRF token: %s", req.PostFormValue(csrfParam))
+		t.failed("Invalid CSRF token", w, req)
+		return
+	}
+
+	then, err := url.Parse(req.PostFormValue(thenParam))
+	if err != nil || !redirect.IsServerRelativeURL(req.PostFormValue(thenParam)) {
+		t.failed("No valid redirect URL was specified", w, req)
+		return
+	}
+
+	if len(req.PostFormValue(acceptParam)) == 0 {
+		q := then.Query()
+		q.Set("error", "access_denied")
+		then.RawQuery = q.Encode()
+		redirectTo(then, w)
+		return
+	}
+
+	// the terms may have changed since the form was shown, the user is asked again when returning
+	if version := req.PostFormValue(versionParam); version == t.version {
+		if err := t.recorder.Accept(user, version); err != nil {
+			utilruntime.HandleError(fmt.Errorf("unable to record that user %q accepted the terms of service: %v", user.GetName(), err))
+			t.failed("Could not record the acceptance of the terms of service", w, req)
+			return
+		}
+	}
+	redirectTo(then, w)
+}
+
+// redirectTo keeps server-relative URLs server-relative, a path prefix of the server is added to them
+func redirectTo(then *url.URL, w http.ResponseWriter) {
+	w.Header().Set("Location", then.String())
+	w.WriteHeader(http.StatusFound)
+}
+
+func (t *Terms) failed(reason string, w http.ResponseWriter, req *http.Request) {
+	t.render.Render(Form{Error: reason}, w, req)
+}
+
+type termsTemplateRenderer struct {
+	termsTemplate *template.Template
+}
+
+// NewTermsFormRenderer creates a terms form renderer that takes in an optional custom template to
+// allow branding of the page. Uses the default if templateFile is not set.
+func NewTermsFormRenderer(templateFile string) (FormRenderer, error) {
+	if len(templateFile) == 0 {
+		return termsTemplateRenderer{termsTemplate: defaultTermsTemplate}, nil
+	}
+	customTemplate, err := template.ParseFiles(templateFile)
+	if err != nil {
+		return nil, err
+	}
+	return termsTemplateRenderer{termsTemplate: customTemplate}, nil
+}
+
+func (r termsTemplateRenderer) Render(form Form, w http.ResponseWriter, req *http.Request) {
+	w.Header().Add("Content-Type", "text/html; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	form.Locale = locales.ForRequest(req)
+	if err := r.termsTemplate.Execute(w, form); err != nil {
+		utilruntime.HandleError(fmt.Errorf("unable to render terms template: %v", err))
+	}
+}
+
+// ValidateTermsTemplate ensures the given template renders the fields that are required to accept the terms of service
+func ValidateTermsTemplate(templateContent []byte) []error {
+	var allErrs []error
+
+	template, err := template.New("termsTemplateTest").Parse(string(templateContent))
+	if err != nil {
+		return append(allErrs, err)
+	}
+
+	// Execute the template with dummy values and check if they're there.
+	form := Form{
+		Action: "MyAction",
+		Terms:  "MyTerms",
+		Names: FormFields{
+			Then:    "MyThenName",
+			CSRF:    "MyCSRFName",
+			Version: "MyVersionName",
+			Accept:  "MyAcceptName",
+			Decline: "MyDeclineName",
+		},
+		Values: FormFields{
+			Then:    "MyThenValue",
+			CSRF:    "MyCSRFValue",
+			Version: "MyVersionValue",
+		},
+	}
+
+	var buffer bytes.Buffer
+	err = template.Execute(&buffer, form)
+	if err != nil {
+		return append(allErrs, err)
+	}
+	output := buffer.Bytes()
+
+	var testFields = map[string]string{
+		"Action":         form.Action,
+		"Terms":          form.Terms,
+		"Names.Then":     form.Names.Then,
+		"Names.CSRF":     form.Names.CSRF,
+		"Names.Version":  form.Names.Version,
+		"Names.Accept":   form.Names.Accept,
+		"Names.Decline":  form.Names.Decline,
+		"Values.Then":    form.Values.Then,
+		"Values.CSRF":    form.Values.CSRF,
+		"Values.Version": form.Values.Version,
+	}
+
+	for field, value := range testFields {
+		if !bytes.Contains(output, []byte(value)) {
+			allErrs = append(allErrs, fmt.Errorf("template is missing parameter {{ .%s }}", field))
+		}
+	}
+
+	return allErrs
+}
@@ -0,0 +1,201 @@
+package terms
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"k8s.io/apiserver/pkg/authentication/authenticator"
+	"k8s.io/apiserver/pkg/authentication/user"
+
+	"github.com/openshift/oauth-server/pkg/server/csrf"
+)
+
+type testAuth struct {
+	Success bool
+}
+
+func (t *testAuth) AuthenticateRequest(req *http.Request) (*authenticator.Response, bool, error) {
+	return &authenticator.Response{User: &user.DefaultInfo{Name: "bob", UID: "bob-uid"}}, t.Success, nil
+}
+
+type testRecorder struct {
+	Err      error
+	Accepted []string
+}
+
+func (r *testRecorder) Accept(user user.Info, version string) error {
+	if r.Err != nil {
+		return r.Err
+	}
+	r.Accepted = append(r.Accepted, user.GetName()+"@"+version)
+	return nil
+}
+
+func TestTerms(t *testing.T) {
+	testCases := map[string]struct {
+		Auth       *testAuth
+		Recorder   *testRecorder
+		Path       string
+		PostValues url.Values
+
+		ExpectStatusCode int
+		ExpectContains   []string
+		ExpectRedirect   string
+		ExpectAccepted   []string
+	}{
+		"display form": {
+			Auth: &testAuth{Success: true},
+			Path: "/oauth/authorize/terms?then=%2Foauth%2Fauthorize%3Fclient_id%3Dfoo",
+
+			ExpectStatusCode: 200,
+			ExpectContains: []string{
+				`action="terms"`,
+				`name="csrf" value="test"`,
+				`name="then" value="/oauth/authorize?client_id=foo"`,
+				`name="version" value="v2"`,
+				`Be nice &lt;3`,
+			},
+		},
+		"not authenticated": {
+			Auth: &testAuth{},
+			Path: "/oauth/authorize/terms?then=%2Foauth%2Fauthorize",
+
+			ExpectStatusCode: 200,
+			ExpectContains:   []string{"You must reauthenticate before continuing"},
+		},
+		"accept": {
+			Auth:       &testAuth{Success: true},
+			Path:       "/oauth/authorize/terms",
+			PostValues: url.Values{"csrf": {"test"}, "then": {"/oauth/authorize?client_id=foo"}, "version": {"v2"}, "accept": {"Accept"}},
+
+			ExpectStatusCode: 302,
+			ExpectRedirect:   "/oauth/authorize?client_id=foo",
+			ExpectAccepted:   []string{"bob@v2"},
+		},
+		"accept outdated version": {
+			Auth:       &testAuth{Success: true},
+			Path:       "/oauth/authorize/terms",
+			PostValues: url.Values{"csrf": {"test"}, "then": {"/oauth/authorize?client_id=foo"}, "version": {"v1"}, "accept": {"Accept"}},
+
+			ExpectStatusCode: 302,
+			ExpectRedirect:   "/oauth/authorize?client_id=foo",
+		},
+		"decline": {
+			Auth:       &testAuth{Success: true},
+			Path:       "/oauth/authorize/terms",
+			PostValues: url.Values{"csrf": {"test"}, "then": {"/oauth/authorize?client_id=foo"}, "version": {"v2"}, "decline": {"Decline"}},
+
+			ExpectStatusCode: 302,
+			ExpectRedirect:   "/oauth/authorize?client_id=foo&error=access_denied",
+		},
+		"invalid CSRF": {
+			Auth:       &testAuth{Success: true},
+			Path:       "/oauth/authorize/terms",
+			PostValues: url.Values{"csrf": {"wrong"}, "then": {"/oauth/authorize"}, "version": {"v2"}, "accept": {"Accept"}},
+
+			ExpectStatusCode: 200,
+			ExpectContains:   []string{"Invalid CSRF token"},
+		},
+		"absolute redirect": {
+			Auth:       &testAuth{Success: true},
+			Path:       "/oauth/authorize/terms",
+			PostValues: url.Values{"csrf": {"test"}, "then": {"https://example.com/"}, "version": {"v2"}, "accept": {"Accept"}},
+
+			ExpectStatusCode: 200,
+			ExpectContains:   []string{"No valid redirect URL was specified"},
+		},
+		"relative redirect": {
+			Auth:       &testAuth{Success: true},
+			Path:       "/oauth/authorize/terms",
+			PostValues: url.Values{"csrf": {"test"}, "then": {"../authorize"}, "version": {"v2"}, "accept": {"Accept"}},
+
+			ExpectStatusCode: 200,
+			ExpectContains:   []string{"No valid redirect URL was specified"},
+		},
+		"protocol-relative redirect": {
+			Auth:       &testAuth{Success: true},
+			Path:       "/oauth/authorize/terms",
+			PostValues: url.Values{"csrf": {"test"}, "then": {"/\\example.com/"}, "version": {"v2"}, "accept": {"Accept"}},
+
+			ExpectStatusCode: 200,
+			ExpectContains:   []string{"No valid redirect URL was specified"},
+		},
+		"recording fails": {
+			Auth:       &testAuth{Success: true},
+			Recorder:   &testRecorder{Err: errors.New("conflict")},
+			Path:       "/oauth/authorize/terms",
+			PostValues: url.Values{"csrf": {"test"}, "then": {"/oauth/authorize"}, "version": {"v2"}, "accept": {"Accept"}},
+
+			ExpectStatusCode: 200,
+			ExpectContains:   []string{"Could not record the acceptance of the terms of service"},
+		},
+	}
+
+	for k, testCase := range testCases {
+		recorder := testCase.Recorder
+		if recorder == nil {
+			recorder = &testRecorder{}
+		}
+		renderer, err := NewTermsFormRenderer("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		handler := NewTerms(&csrf.FakeCSRF{Token: "test"}, testCase.Auth, renderer, recorder, "v2", "Be nice <3")
+
+		var req *http.Request
+		if testCase.PostValues != nil {
+			req = httptest.NewRequest("POST", testCase.Path, strings.NewReader(testCase.PostValues.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			req = httptest.NewRequest("GET", testCase.Path, nil)
+		}
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+
+		if resp.Code != testCase.ExpectStatusCode {
+			t.Errorf("%s: expected status %d, got %d", k, testCase.ExpectStatusCode, resp.Code)
+		}
+		if location := resp.Header().Get("Location"); location != testCase.ExpectRedirect {
+			t.Errorf("%s: expected redirect to %q, got %q", k, testCase.ExpectRedirect, location)
+		}
+		for _, expected := range testCase.ExpectContains {
+			if !strings.Contains(resp.Body.String(), expected) {
+				t.Errorf("%s: expected page to contain %q, got %s", k, expected, resp.Body.String())
+			}
+		}
+		if strings.Join(recorder.Accepted, ",") != strings.Join(testCase.ExpectAccepted, ",") {
+			t.Errorf("%s: expected acceptances %v, got %v", k, testCase.ExpectAccepted, recorder.Accepted)
+		}
+	}
+}
+
+func TestValidateTermsTemplate(t *testing.T) {
+	testCases := map[string]struct {
+		Template      string
+		TemplateValid bool
+	}{
+		"default terms template": {
+			Template:      defaultTermsTemplateString,
+			TemplateValid: true,
+		},
+		"template without terms": {
+			Template:      `<form action="{{ .Action }}"><input name="{{ .Names.CSRF }}" value="{{ .Values.CSRF }}"></form>`,
+			TemplateValid: false,
+		},
+	}
+
+	for k, testCase := range testCases {
+		allErrs := ValidateTermsTemplate([]byte(testCase.Template))
+		if testCase.TemplateValid {
+			for _, err := range allErrs {
+				t.Errorf("%s: template validation failed when it should have succeeded: %v", k, err)
+			}
+		} else if len(allErrs) == 0 {
+			t.Errorf("%s: template validation succeeded when it should have failed", k)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+// Package theme replaces the built-in pages of the oauth-server with the templates of a directory
+// and serves the static assets they refer to, such as logos and stylesheets.
+package theme
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+
+	oauthserver "github.com/openshift/oauth-server/pkg"
+	"github.com/openshift/oauth-server/pkg/server/errorpage"
+	"github.com/openshift/oauth-server/pkg/server/grant"
+	"github.com/openshift/oauth-server/pkg/server/login"
+	"github.com/openshift/oauth-server/pkg/server/maintenance"
+	"github.com/openshift/oauth-server/pkg/server/selectprovider"
+	"github.com/openshift/oauth-server/pkg/server/terms"
+)
+
+const (
+	// LoginTemplate is the file name of the template of the login page
+	LoginTemplate = "login.html"
+	// ProviderSelectionTemplate is the file name of the template of the provider selection page
+	ProviderSelectionTemplate = "provider-selection.html"
+	// GrantTemplate is the file name of the template of the grant approval page
+	GrantTemplate = "grant.html"
+	// ErrorTemplate is the file name of the template of the error page
+	ErrorTemplate = "error.html"
+	// TermsTemplate is the file name of the template of the terms of service page
+	TermsTemplate = "terms.html"
+	// MaintenanceTemplate is the file name of the template of the page shown while logins are disabled
+	MaintenanceTemplate = "maintenance.html"
+
+	// StaticDir is the subdirectory of the theme holding the static assets
+	StaticDir = "static"
+
+	// DefaultStaticMaxAge is how long browsers cache static assets if no other duration is configured
+	DefaultStaticMaxAge = time.Hour
+)
+
+// validators check that the templates render the fields their pages require
+var validators = map[string]func([]byte) []error{
+	LoginTemplate:             login.ValidateLoginTemplate,
+	ProviderSelectionTemplate: selectprovider.ValidateSelectProviderTemplate,
+	GrantTemplate:             grant.ValidateGrantTemplate,
+	ErrorTemplate:             errorpage.ValidateErrorPageTemplate,
+	TermsTemplate:             terms.ValidateTermsTemplate,
+	MaintenanceTemplate:       maintenance.ValidateMaintenanceTemplate,
+}
+
+// Theme is a directory holding templates that replace the built-in pages, and static assets.
+// Pages without a template in the directory keep their built-in template.
+type Theme struct {
+	dir string
+	// templates holds the paths of the templates of the directory by file name
+	templates    map[string]string
+	staticMaxAge time.Duration
+}
+
+// New returns the theme of the directory, such as a mounted ConfigMap. Its templates are validated,
+// so that mistakes are found on startup rather than when a user logs in. Static assets are cached
+// by browsers for staticMaxAge.
+func New(dir string, staticMaxAge time.Duration) (*Theme, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("theme %s is not a directory", dir)
+	}
+
+	t := &Theme{dir: dir, templates: map[string]string{}, staticMaxAge: staticMaxAge}
+	var errs []error
+	for name, validate := range validators {
+		file := filepath.Join(dir, name)
+		content, err := ioutil.ReadFile(file)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, err := range validate(content) {
+			errs = append(errs, fmt.Errorf("theme template %s: %v", file, err))
+		}
+		t.templates[name] = file
+	}
+	if len(errs) > 0 {
+		return nil, utilerrors.NewAggregate(errs)
+	}
+	return t, nil
+}
+
+// Template returns the path of the template with the given file name, or an empty string
+// if the theme keeps the built-in template
+func (t *Theme) Template(name string) string {
+	if t == nil {
+		return ""
+	}
+	return t.templates[name]
+}
+
+// Install serves the static assets of the theme below prefix
+func (t *Theme) Install(mux oauthserver.Mux, prefix string) {
+	prefix = strings.TrimSuffix(prefix, "/") + "/"
+	mux.Handle(prefix, http.StripPrefix(prefix, t.staticHandler()))
+}
+
+func (t *Theme) staticHandler() http.Handler {
+	files := http.FileServer(http.Dir(filepath.Join(t.dir, StaticDir)))
+	maxAge := fmt.Sprintf("public, max-age=%d", int(t.staticMaxAge.Seconds()))
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		// do not list the contents of directories or serve hidden files, such as the ..data
+		// directories of mounted ConfigMaps
+		if len(req.URL.Path) == 0 || strings.HasSuffix(req.URL.Path, "/") || strings.HasPrefix(req.URL.Path, ".") || strings.Contains(req.URL.Path, "/.") {
+			http.NotFound(w, req)
+			return
+		}
+		// assets are the same for every user, unlike the pages that are never cached
+		w.Header().Set("Cache-Control", maxAge)
+		w.Header().Del("Pragma")
+		w.Header().Del("Expires")
+		files.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,149 @@
+package theme
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const validErrorTemplate = `<html><body>{{ .Error }}</body></html>`
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		file := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestNew(t *testing.T) {
+	testCases := map[string]struct {
+		Files           map[string]string
+		ExpectTemplates []string
+		ExpectError     string
+	}{
+		"empty theme keeps built-in templates": {
+			Files: map[string]string{"static/logo.png": "png"},
+		},
+		"valid template": {
+			Files:           map[string]string{ErrorTemplate: validErrorTemplate},
+			ExpectTemplates: []string{ErrorTemplate},
+		},
+		"unparseable template": {
+			Files:       map[string]string{ErrorTemplate: `{{ .Error `},
+			ExpectError: ErrorTemplate,
+		},
+		"template missing required fields": {
+			Files:       map[string]string{LoginTemplate: `<html></html>`},
+			ExpectError: "template is missing parameter",
+		},
+	}
+
+	for k, testCase := range testCases {
+		dir := writeFiles(t, testCase.Files)
+		theme, err := New(dir, time.Hour)
+		if len(testCase.ExpectError) > 0 {
+			if err == nil || !strings.Contains(err.Error(), testCase.ExpectError) {
+				t.Errorf("%s: expected error containing %q, got %v", k, testCase.ExpectError, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", k, err)
+			continue
+		}
+		for name := range validators {
+			expected := ""
+			for _, expectedName := range testCase.ExpectTemplates {
+				if expectedName == name {
+					expected = filepath.Join(dir, name)
+				}
+			}
+			if template := theme.Template(name); template != expected {
+				t.Errorf("%s: expected template %q for %s, got %q", k, expected, name, template)
+			}
+		}
+	}
+
+	if _, err := New(filepath.Join(t.TempDir(), "missing"), time.Hour); err == nil {
+		t.Error("expected error for missing directory")
+	}
+	if template := (*Theme)(nil).Template(ErrorTemplate); len(template) != 0 {
+		t.Errorf("expected no template without theme, got %q", template)
+	}
+}
+
+func TestStatic(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"static/logo.svg":      "<svg></svg>",
+		"static/..data/secret": "secret",
+	})
+	theme, err := New(dir, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	theme.Install(mux, "/static")
+
+	testCases := map[string]struct {
+		Method       string
+		Path         string
+		ExpectStatus int
+		ExpectBody   string
+	}{
+		"asset": {
+			Path:         "/static/logo.svg",
+			ExpectStatus: http.StatusOK,
+			ExpectBody:   "<svg></svg>",
+		},
+		"missing asset": {
+			Path:         "/static/missing.png",
+			ExpectStatus: http.StatusNotFound,
+		},
+		"directory listing": {
+			Path:         "/static/",
+			ExpectStatus: http.StatusNotFound,
+		},
+		"hidden file": {
+			Path:         "/static/..data/secret",
+			ExpectStatus: http.StatusNotFound,
+		},
+		"post": {
+			Method:       http.MethodPost,
+			Path:         "/static/logo.svg",
+			ExpectStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for k, testCase := range testCases {
+		method := testCase.Method
+		if len(method) == 0 {
+			method = http.MethodGet
+		}
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, httptest.NewRequest(method, testCase.Path, nil))
+		if resp.Code != testCase.ExpectStatus {
+			t.Errorf("%s: expected status %d, got %d", k, testCase.ExpectStatus, resp.Code)
+			continue
+		}
+		if testCase.ExpectStatus != http.StatusOK {
+			continue
+		}
+		if body := resp.Body.String(); body != testCase.ExpectBody {
+			t.Errorf("%s: expected body %q, got %q", k, testCase.ExpectBody, body)
+		}
+		if cacheControl := resp.Header().Get("Cache-Control"); cacheControl != "public, max-age=3600" {
+			t.Errorf("%s: unexpected Cache-Control %q", k, cacheControl)
+		}
+	}
+}
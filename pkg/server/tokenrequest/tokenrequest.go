@@ -2,12 +2,16 @@ package tokenrequest
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"html/template"
 	"io"
+	"mime"
 	"net/http"
 	"net/url"
 	"path"
+	"strings"
+	"time"
 
 	"github.com/RangelReale/osincli"
 
@@ -22,10 +26,43 @@ import (
 	oauthserver "github.com/openshift/oauth-server/pkg"
 	"github.com/openshift/oauth-server/pkg/osinserver/registrystorage"
 	"github.com/openshift/oauth-server/pkg/server/csrf"
+	"github.com/openshift/oauth-server/pkg/server/pathprefix"
 )
 
 const csrfParam = "csrf"
 
+// Token is the JSON response of the display endpoint to tools that accept application/json
+type Token struct {
+	AccessToken string   `json:"accessToken"`
+	TokenType   string   `json:"tokenType"`
+	UserName    string   `json:"userName"`
+	Scopes      []string `json:"scopes"`
+	// ExpiresIn and ExpiresAt are not set for tokens that do not expire
+	ExpiresIn int64      `json:"expiresIn,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	// Server is the API server the token authenticates to
+	Server string `json:"server"`
+}
+
+// AuthorizeRequest is the JSON response of the request endpoint to tools that accept application/json,
+// they open the URL in a browser and receive the code at the display endpoint
+type AuthorizeRequest struct {
+	AuthorizeURL string `json:"authorizeURL"`
+}
+
+// TokenForm is the JSON response of the display endpoint to GET requests of tools that accept application/json.
+// Like the form shown to browsers, it does not exchange the code. Tools post the code and the CSRF token back
+// to the display endpoint, with the CSRF cookie of the response, to exchange the code for a Token.
+type TokenForm struct {
+	Code string `json:"code"`
+	CSRF string `json:"csrf"`
+}
+
+// Error is the JSON response to tools if a token cannot be displayed
+type Error struct {
+	Error string `json:"error"`
+}
+
 type tokenRequest struct {
 	publicMasterURL string
 	// osinOAuthClientGetter is used to initialize osinOAuthClient.
@@ -72,18 +109,79 @@ func (t *tokenRequest) requestToken(osinOAuthClient *osincli.Client, w http.Resp
 	authReq := osinOAuthClient.NewAuthorizeRequest(osincli.CODE)
 	oauthURL := authReq.GetAuthorizeUrl()
 
+	if wantsJSON(req) {
+		writeJSON(w, http.StatusOK, AuthorizeRequest{AuthorizeURL: oauthURL.String()})
+		return
+	}
 	http.Redirect(w, req, oauthURL.String(), http.StatusFound)
 }
 
 func (t *tokenRequest) displayToken(osinOAuthClient *osincli.Client, w http.ResponseWriter, req *http.Request) {
-	switch req.Method {
-	case http.MethodGet:
+	switch {
+	case req.Method != http.MethodGet && req.Method != http.MethodPost:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	case wantsJSON(req) && req.Method == http.MethodGet:
+		t.displayTokenJSONForm(osinOAuthClient, w, req)
+	case wantsJSON(req):
+		t.displayTokenJSON(osinOAuthClient, w, req)
+	case req.Method == http.MethodGet:
 		t.displayTokenGet(osinOAuthClient, w, req)
-	case http.MethodPost:
-		t.displayTokenPost(osinOAuthClient, w, req)
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		t.displayTokenPost(osinOAuthClient, w, req)
+	}
+}
+
+// displayTokenJSONForm returns the code with a CSRF token, so that a GET request cannot exchange the code
+func (t *tokenRequest) displayTokenJSONForm(osinOAuthClient *osincli.Client, w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	authorizeData, err := osinOAuthClient.NewAuthorizeRequest(osincli.CODE).HandleRequest(req)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, Error{Error: fmt.Sprintf("Error handling auth request: %v", err)})
+		return
 	}
+	writeJSON(w, http.StatusOK, TokenForm{Code: authorizeData.Code, CSRF: t.csrf.Generate(w, req)})
+}
+
+// displayTokenJSON exchanges the posted code and returns the token with its expiration and scopes
+func (t *tokenRequest) displayTokenJSON(osinOAuthClient *osincli.Client, w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	if ok := t.csrf.Check(req, req.FormValue(csrfParam)); !ok {
+		klog.V(4).Infof("Invalid CSRF token: %s", req.FormValue(csrfParam))
+		writeJSON(w, http.StatusBadRequest, Error{Error: "Could not check CSRF token. Please try again."})
+		return
+	}
+
+	authorizeData, err := osinOAuthClient.NewAuthorizeRequest(osincli.CODE).HandleRequest(req)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, Error{Error: fmt.Sprintf("Error handling auth request: %v", err)})
+		return
+	}
+	accessData, err := osinOAuthClient.NewAccessRequest(osincli.AUTHORIZATION_CODE, authorizeData).GetToken()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, Error{Error: fmt.Sprintf("Error getting token: %v", err)})
+		return
+	}
+	token, err := t.tokens.Get(context.TODO(), registrystorage.TokenToObjectName(accessData.AccessToken), metav1.GetOptions{})
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, Error{Error: "Error checking token"})
+		return
+	}
+
+	response := Token{
+		AccessToken: accessData.AccessToken,
+		TokenType:   "Bearer",
+		UserName:    token.UserName,
+		Scopes:      token.Scopes,
+		Server:      t.publicMasterURL,
+	}
+	if token.ExpiresIn > 0 {
+		expiresAt := token.CreationTimestamp.Add(time.Duration(token.ExpiresIn) * time.Second)
+		response.ExpiresIn = token.ExpiresIn
+		response.ExpiresAt = &expiresAt
+	}
+	writeJSON(w, http.StatusOK, response)
 }
 
 func (t *tokenRequest) displayTokenGet(osinOAuthClient *osincli.Client, w http.ResponseWriter, req *http.Request) {
@@ -140,7 +238,7 @@ func (t *tokenRequest) displayTokenPost(osinOAuthClient *osincli.Client, w http.
 
 	if token.UserName == bootstrap.BootstrapUser {
 		// only the bootstrap user has a session we maintain for one more than OAuth flow
-		data.LogoutURL = t.openShiftLogoutPrefix
+		data.LogoutURL = pathprefix.External(req, t.openShiftLogoutPrefix)
 	}
 
 	data.AccessToken = accessData.AccessToken
@@ -151,7 +249,7 @@ func displayTokenStart(osinOAuthClient *osincli.Client, w http.ResponseWriter, r
 	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
 
 	requestURL := oauthdiscovery.OpenShiftOAuthTokenRequestURL("") // relative url to token request endpoint
-	data.RequestURL = requestURL                                   // always set this field even on error cases
+	data.RequestURL = pathprefix.External(req, requestURL)         // always set this field even on error cases
 
 	authorizeReq := osinOAuthClient.NewAuthorizeRequest(osincli.CODE)
 	authorizeData, err := authorizeReq.HandleRequest(req)
@@ -183,6 +281,35 @@ type tokenData struct {
 	LogoutURL       string
 }
 
+// wantsJSON returns true if the request accepts application/json rather than text/html, e.g. because it was
+// made by a tool rather than a browser
+func wantsJSON(req *http.Request) bool {
+	for _, accept := range strings.Split(req.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil || params["q"] == "0" {
+			continue
+		}
+		switch mediaType {
+		case "application/json":
+			return true
+		case "text/html":
+			return false
+		}
+	}
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(data)
+}
+
 func getBaseURL(req *http.Request) (*url.URL, error) {
 	uri, err := url.Parse(req.RequestURI)
 	if err != nil {
@@ -0,0 +1,127 @@
+package tokenrequest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RangelReale/osincli"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	oauthv1 "github.com/openshift/api/oauth/v1"
+	oauthfake "github.com/openshift/client-go/oauth/clientset/versioned/fake"
+
+	"github.com/openshift/oauth-server/pkg/osinserver/registrystorage"
+	"github.com/openshift/oauth-server/pkg/server/csrf"
+)
+
+func TestWantsJSON(t *testing.T) {
+	for accept, expected := range map[string]bool{
+		"":                                      false,
+		"application/json":                      true,
+		"application/json; charset=utf-8":       true,
+		"text/html,application/xhtml+xml,*/*":   false,
+		"application/json, text/html":           true,
+		"text/html;q=0, application/json;q=0.9": true,
+		"application/json;q=0, text/html":       false,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/oauth/token/display", nil)
+		req.Header.Set("Accept", accept)
+		if actual := wantsJSON(req); actual != expected {
+			t.Errorf("expected %v for Accept %q, got %v", expected, accept, actual)
+		}
+	}
+}
+
+func TestJSON(t *testing.T) {
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if err := req.ParseForm(); err != nil || req.Form.Get("code") != "code" {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"sha256~token","token_type":"Bearer","expires_in":3600}`))
+	}))
+	defer tokenServer.Close()
+
+	created := metav1.NewTime(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	oauthClient := oauthfake.NewSimpleClientset(&oauthv1.OAuthAccessToken{
+		ObjectMeta: metav1.ObjectMeta{Name: registrystorage.TokenToObjectName("sha256~token"), CreationTimestamp: created},
+		UserName:   "bob",
+		Scopes:     []string{"user:full"},
+		ExpiresIn:  3600,
+	})
+	endpoints := NewTokenRequest("https://api.example.com", "/logout", func() (*osincli.Client, error) {
+		return osincli.NewClient(&osincli.ClientConfig{
+			ClientId:     "openshift-browser-client",
+			AuthorizeUrl: "https://oauth.example.com/oauth/authorize",
+			TokenUrl:     tokenServer.URL,
+			RedirectUrl:  "https://oauth.example.com/oauth/token/display",
+		})
+	}, oauthClient.OauthV1().OAuthAccessTokens(), &csrf.FakeCSRF{Token: "csrf"})
+	mux := http.NewServeMux()
+	endpoints.Install(mux, "/oauth")
+
+	get := func(url string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		req.Header.Set("Accept", "application/json")
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		return resp
+	}
+	post := func(url string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, url, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept", "application/json")
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		return resp
+	}
+
+	resp := get("/oauth/token/request")
+	authorizeRequest := AuthorizeRequest{}
+	if err := json.Unmarshal(resp.Body.Bytes(), &authorizeRequest); err != nil || resp.Code != http.StatusOK {
+		t.Fatalf("unexpected response %d %s", resp.Code, resp.Body.String())
+	}
+	if !strings.HasPrefix(authorizeRequest.AuthorizeURL, "https://oauth.example.com/oauth/authorize?") {
+		t.Errorf("unexpected authorize URL %q", authorizeRequest.AuthorizeURL)
+	}
+
+	// a GET does not exchange the code
+	resp = get("/oauth/token/display?code=code")
+	form := TokenForm{}
+	if err := json.Unmarshal(resp.Body.Bytes(), &form); err != nil || resp.Code != http.StatusOK {
+		t.Fatalf("unexpected response %d %s", resp.Code, resp.Body.String())
+	}
+	if form.Code != "code" || form.CSRF != "csrf" {
+		t.Errorf("unexpected form %#v", form)
+	}
+
+	resp = post("/oauth/token/display", url.Values{"code": {form.Code}, "csrf": {"wrong"}})
+	if resp.Code != http.StatusBadRequest || resp.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("expected the code not to be exchanged without the CSRF token, got %d %s", resp.Code, resp.Body.String())
+	}
+
+	resp = post("/oauth/token/display", url.Values{"code": {form.Code}, "csrf": {form.CSRF}})
+	token := Token{}
+	if err := json.Unmarshal(resp.Body.Bytes(), &token); err != nil || resp.Code != http.StatusOK {
+		t.Fatalf("unexpected response %d %s", resp.Code, resp.Body.String())
+	}
+	expiresAt := created.Add(time.Hour)
+	if token.AccessToken != "sha256~token" || token.UserName != "bob" || token.ExpiresIn != 3600 || token.ExpiresAt == nil || !token.ExpiresAt.Equal(expiresAt) || token.Server != "https://api.example.com" || len(token.Scopes) != 1 {
+		t.Errorf("unexpected token %#v", token)
+	}
+	if cacheControl := resp.Header().Get("Cache-Control"); cacheControl != "no-store" {
+		t.Errorf("expected the token to not be cached, got %q", cacheControl)
+	}
+
+	resp = get("/oauth/token/display?error=access_denied")
+	if resp.Code != http.StatusBadRequest || resp.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("expected JSON error, got %d %s", resp.Code, resp.Body.String())
+	}
+}
@@ -0,0 +1,268 @@
+// Package tokenreview authenticates access tokens for the webhook token authenticator of the Kubernetes API server.
+// It is needed when tokens are kept in a storage that the API server does not know. Only the API server may review
+// tokens, it authenticates with its client certificate.
+package tokenreview
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+
+	oauthapi "github.com/openshift/api/oauth/v1"
+	userapi "github.com/openshift/api/user/v1"
+	oauthclient "github.com/openshift/client-go/oauth/clientset/versioned/typed/oauth/v1"
+	userclient "github.com/openshift/client-go/user/clientset/versioned/typed/user/v1"
+	bootstrap "github.com/openshift/library-go/pkg/authentication/bootstrapauthenticator"
+
+	"github.com/openshift/oauth-server/pkg"
+	"github.com/openshift/oauth-server/pkg/api"
+	"github.com/openshift/oauth-server/pkg/oauth/mtls"
+	"github.com/openshift/oauth-server/pkg/oauth/tokenformat"
+	"github.com/openshift/oauth-server/pkg/osinserver/registrystorage"
+)
+
+const (
+	tokenReviewPath = "/tokenreview"
+
+	// ScopesKey is the key of the extra user info that holds the scopes of the token
+	ScopesKey = "scopes.authorization.openshift.io"
+	// AuthenticationMethodsKey is the key of the extra user info that holds the methods the user of the token
+	// authenticated with
+	AuthenticationMethodsKey = "amr.authentication.openshift.io"
+	// AuthenticationContextClassKey is the key of the extra user info that holds the authentication context class
+	// the login of the token satisfied
+	AuthenticationContextClassKey = "acr.authentication.openshift.io"
+	// AuthenticationTimeKey is the key of the extra user info that holds when the user of the token logged in, in
+	// seconds since the epoch
+	AuthenticationTimeKey = "auth_time.authentication.openshift.io"
+
+	// maxBodyBytes limits the size of token reviews
+	maxBodyBytes = 64 * 1024
+
+	// bootstrapUserGroup is the group of the bootstrap user, which has no user object
+	bootstrapUserGroup = "system:cluster-admins"
+
+	// timeoutUpdateFraction is the fraction of the inactivity timeout that has to pass before the use of a token is
+	// recorded again
+	timeoutUpdateFraction = 10
+)
+
+// GroupsGetter returns the groups a user is a member of
+type GroupsGetter interface {
+	GroupsFor(username string) ([]*userapi.Group, error)
+}
+
+// tokenReview answers the TokenReviews of the API server like the authenticator of the OpenShift API server does
+type tokenReview struct {
+	reviewer          *mtls.Client
+	accessTokens      oauthclient.OAuthAccessTokenInterface
+	users             userclient.UserInterface
+	bootstrapUser     bootstrap.BootstrapUserDataGetter
+	groups            GroupsGetter
+	clients           api.OAuthClientGetter
+	formats           tokenformat.Formats
+	inactivityTimeout int32
+	now               func() time.Time
+}
+
+// NewTokenReview returns the token review endpoint for the reviewer. Tokens must have one of the formats,
+// inactivityTimeout is the default inactivity timeout of access tokens in seconds. Tokens of the bootstrap user are
+// checked against bootstrapUser, they are never valid without it.
+func NewTokenReview(reviewer *mtls.Client, accessTokens oauthclient.OAuthAccessTokenInterface, users userclient.UserInterface, bootstrapUser bootstrap.BootstrapUserDataGetter, groups GroupsGetter, clients api.OAuthClientGetter, formats tokenformat.Formats, inactivityTimeout int32) oauthserver.Endpoints {
+	if len(formats) == 0 {
+		formats = tokenformat.Default
+	}
+	return &tokenReview{
+		reviewer:          reviewer,
+		accessTokens:      accessTokens,
+		users:             users,
+		bootstrapUser:     bootstrapUser,
+		groups:            groups,
+		clients:           clients,
+		formats:           formats,
+		inactivityTimeout: inactivityTimeout,
+		now:               time.Now,
+	}
+}
+
+func (t *tokenReview) Install(mux oauthserver.Mux, prefix string) {
+	mux.HandleFunc(prefix+tokenReviewPath, t.review)
+}
+
+func (t *tokenReview) review(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	if _, err := t.reviewer.Verify(req); err != nil {
+		klog.V(4).Infof("rejecting token review of %s: %v", req.RemoteAddr, err)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	review := &authenticationv1.TokenReview{}
+	if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxBodyBytes)).Decode(review); err != nil {
+		http.Error(w, "invalid token review", http.StatusBadRequest)
+		return
+	}
+
+	// the response has the API version of the request, the API server may send v1 or v1beta1
+	response := &authenticationv1.TokenReview{TypeMeta: review.TypeMeta}
+	user, audiences, err := t.authenticate(review.Spec.Token, review.Spec.Audiences)
+	switch {
+	case err != nil:
+		klog.V(4).Infof("token review failed: %v", err)
+		response.Status.Error = "failed to authenticate the token"
+	case user != nil:
+		response.Status.Authenticated = true
+		response.Status.User = *user
+		response.Status.Audiences = audiences
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		klog.Errorf("error writing token review: %v", err)
+	}
+}
+
+// authenticate returns the user of the token and the audiences of the review it is valid for, or nil if the token is
+// not valid. The API server cannot check the keys that tokens are bound to, bound tokens are never valid.
+func (t *tokenReview) authenticate(code string, reviewAudiences []string) (*authenticationv1.UserInfo, []string, error) {
+	if !t.formats.Accepts(code) {
+		return nil, nil, nil
+	}
+	token, err := t.accessTokens.Get(context.TODO(), registrystorage.TokenToObjectName(code), metav1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	now := t.now()
+	if !registrystorage.TokenActive(token, now) {
+		return nil, nil, nil
+	}
+	if len(token.Annotations[registrystorage.CertificateThumbprintAnnotation]) > 0 || len(token.Annotations[registrystorage.ProofKeyThumbprintAnnotation]) > 0 {
+		klog.V(4).Infof("access token %q is bound to a key, it cannot be used with the API server", token.Name)
+		return nil, nil, nil
+	}
+	audiences := reviewAudiences
+	if tokenAudiences := token.Annotations[registrystorage.AudiencesAnnotation]; len(tokenAudiences) > 0 {
+		audiences = sets.NewString(strings.Split(tokenAudiences, ",")...).Intersection(sets.NewString(reviewAudiences...)).List()
+		if len(audiences) == 0 {
+			klog.V(4).Infof("access token %q is not valid for the audiences %v", token.Name, reviewAudiences)
+			return nil, nil, nil
+		}
+	}
+
+	user, groups, err := t.getUser(token.UserName)
+	if err != nil {
+		return nil, nil, err
+	}
+	if user == nil {
+		return nil, nil, nil
+	}
+	// tokens of deleted users stay invalid for users that were created again with the same name
+	if string(user.UID) != token.UserUID {
+		klog.V(4).Infof("user %q of access token %q has UID %q, not %q", user.Name, token.Name, user.UID, token.UserUID)
+		return nil, nil, nil
+	}
+
+	t.recordUse(token, now)
+
+	info := &authenticationv1.UserInfo{
+		Username: user.Name,
+		UID:      string(user.UID),
+		Groups:   groups,
+	}
+	// the extra user info of the identity cannot override the keys below
+	extra := map[string]authenticationv1.ExtraValue{}
+	for key, values := range registrystorage.Extra(token.Annotations) {
+		extra[key] = values
+	}
+	if len(token.Scopes) > 0 {
+		extra[ScopesKey] = token.Scopes
+	}
+	if methods := strings.Fields(token.Annotations[registrystorage.AuthenticationMethodsAnnotation]); len(methods) > 0 {
+		extra[AuthenticationMethodsKey] = methods
+	}
+	if contextClass := token.Annotations[registrystorage.AuthenticationContextClassAnnotation]; len(contextClass) > 0 {
+		extra[AuthenticationContextClassKey] = authenticationv1.ExtraValue{contextClass}
+	}
+	if authenticatedAt := token.Annotations[registrystorage.AuthenticationTimeAnnotation]; len(authenticatedAt) > 0 {
+		extra[AuthenticationTimeKey] = authenticationv1.ExtraValue{authenticatedAt}
+	}
+	if len(extra) > 0 {
+		info.Extra = extra
+	}
+	return info, audiences, nil
+}
+
+// getUser returns the user with the given name and its groups, or nil if there is none. The bootstrap user has no
+// user object, its UID changes with its password.
+func (t *tokenReview) getUser(name string) (*userapi.User, []string, error) {
+	if name == bootstrap.BootstrapUser {
+		if t.bootstrapUser == nil {
+			return nil, nil, nil
+		}
+		data, ok, err := t.bootstrapUser.Get()
+		if err != nil || !ok {
+			return nil, nil, err
+		}
+		user := &userapi.User{ObjectMeta: metav1.ObjectMeta{Name: bootstrap.BootstrapUser, UID: types.UID(data.UID)}}
+		return user, []string{bootstrapUserGroup}, nil
+	}
+
+	user, err := t.users.Get(context.TODO(), name, metav1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	groups := append([]string{}, user.Groups...)
+	memberships, err := t.groups.GroupsFor(user.Name)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, group := range memberships {
+		groups = append(groups, group.Name)
+	}
+	return user, groups, nil
+}
+
+// recordUse extends the inactivity timeout of the token. Failures do not fail the authentication, the next use of the
+// token records it again.
+func (t *tokenReview) recordUse(token *oauthapi.OAuthAccessToken, now time.Time) {
+	if token.InactivityTimeoutSeconds <= 0 {
+		return
+	}
+	timeout := t.inactivityTimeout
+	if client, err := t.clients.Get(context.TODO(), token.ClientName, metav1.GetOptions{}); err == nil && client.AccessTokenInactivityTimeoutSeconds != nil {
+		timeout = *client.AccessTokenInactivityTimeoutSeconds
+	}
+	if timeout <= 0 {
+		return
+	}
+
+	extended := int32(now.Sub(token.CreationTimestamp.Time)/time.Second) + timeout
+	if extended-token.InactivityTimeoutSeconds < timeout/timeoutUpdateFraction {
+		return
+	}
+	token = token.DeepCopy()
+	token.InactivityTimeoutSeconds = extended
+	if _, err := t.accessTokens.Update(context.TODO(), token, metav1.UpdateOptions{}); err != nil && !kerrors.IsConflict(err) {
+		klog.V(2).Infof("error recording the use of access token %q: %v", token.Name, err)
+	}
+}
@@ -0,0 +1,369 @@
+package tokenreview
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	oauthapi "github.com/openshift/api/oauth/v1"
+	userapi "github.com/openshift/api/user/v1"
+	oauthfake "github.com/openshift/client-go/oauth/clientset/versioned/fake"
+	userfake "github.com/openshift/client-go/user/clientset/versioned/fake"
+	bootstrap "github.com/openshift/library-go/pkg/authentication/bootstrapauthenticator"
+
+	"github.com/openshift/oauth-server/pkg/oauth/mtls"
+	"github.com/openshift/oauth-server/pkg/oauth/tokenformat"
+	"github.com/openshift/oauth-server/pkg/osinserver/registrystorage"
+)
+
+type testGroups map[string][]*userapi.Group
+
+func (g testGroups) GroupsFor(username string) ([]*userapi.Group, error) {
+	return g[username], nil
+}
+
+// newCertificate returns a certificate signed by parent, or a self-signed CA without parent
+func newCertificate(t *testing.T, commonName string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	if parent == nil {
+		template.IsCA, template.BasicConstraintsValid, template.KeyUsage = true, true, x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certificate, key
+}
+
+// newReviewer returns the reviewer of the tests and its client certificate
+func newReviewer(t *testing.T) (*mtls.Client, *x509.Certificate) {
+	ca, caKey := newCertificate(t, "kube-apiserver-ca", nil, nil)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	certificate, _ := newCertificate(t, "system:kube-apiserver", ca, caKey)
+	return &mtls.Client{Roots: roots, SubjectDN: "CN=system:kube-apiserver"}, certificate
+}
+
+// newReviewRequest returns a token review request with the client certificate, if any
+func newReviewRequest(t *testing.T, certificate *x509.Certificate, spec authenticationv1.TokenReviewSpec) *http.Request {
+	body, err := json.Marshal(&authenticationv1.TokenReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "authentication.k8s.io/v1", Kind: "TokenReview"},
+		Spec:     spec,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/oauth/tokenreview", bytes.NewReader(body))
+	if certificate != nil {
+		req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{certificate}}
+	}
+	return req
+}
+
+func TestTokenReview(t *testing.T) {
+	now := time.Now()
+	created := metav1.NewTime(now.Add(-time.Hour))
+	token, expiredToken, previousToken := tokenformat.SHA256.Generate(), tokenformat.SHA256.Generate(), tokenformat.SHA256.Generate()
+	oauthClient := oauthfake.NewSimpleClientset(
+		&oauthapi.OAuthClient{ObjectMeta: metav1.ObjectMeta{Name: "console"}},
+		&oauthapi.OAuthAccessToken{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              tokenformat.ObjectName(token),
+				CreationTimestamp: created,
+				Annotations:       map[string]string{registrystorage.AuthenticationMethodsAnnotation: "mfa sso", registrystorage.AuthenticationContextClassAnnotation: "gold", registrystorage.AuthenticationTimeAnnotation: "1700000000", registrystorage.ExtraAnnotation: `{"department.example.com":["finance"],"scopes.authorization.openshift.io":["user:full"]}`},
+			},
+			ClientName:               "console",
+			UserName:                 "alice",
+			UserUID:                  "alice-uid",
+			ExpiresIn:                86400,
+			InactivityTimeoutSeconds: 3700,
+			Scopes:                   []string{"user:info"},
+		},
+		&oauthapi.OAuthAccessToken{
+			ObjectMeta: metav1.ObjectMeta{Name: tokenformat.ObjectName(expiredToken), CreationTimestamp: created},
+			ClientName: "console",
+			UserName:   "alice",
+			UserUID:    "alice-uid",
+			ExpiresIn:  60,
+		},
+		&oauthapi.OAuthAccessToken{
+			ObjectMeta: metav1.ObjectMeta{Name: tokenformat.ObjectName(previousToken), CreationTimestamp: created},
+			ClientName: "console",
+			UserName:   "alice",
+			UserUID:    "previous-alice-uid",
+		},
+	)
+	userClient := userfake.NewSimpleClientset(&userapi.User{ObjectMeta: metav1.ObjectMeta{Name: "alice", UID: "alice-uid"}, Groups: []string{"admins"}})
+	groups := testGroups{"alice": {{ObjectMeta: metav1.ObjectMeta{Name: "developers"}}}}
+
+	reviewer, certificate := newReviewer(t)
+	endpoint := NewTokenReview(reviewer, oauthClient.OauthV1().OAuthAccessTokens(), userClient.UserV1().Users(), nil, groups, oauthClient.OauthV1().OAuthClients(), nil, 600)
+	endpoint.(*tokenReview).now = func() time.Time { return now }
+	mux := http.NewServeMux()
+	endpoint.Install(mux, "/oauth")
+
+	review := func(token string) authenticationv1.TokenReviewStatus {
+		t.Helper()
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, newReviewRequest(t, certificate, authenticationv1.TokenReviewSpec{Token: token}))
+		if resp.Code != http.StatusOK {
+			t.Fatalf("unexpected response %d: %s", resp.Code, resp.Body.String())
+		}
+		result := &authenticationv1.TokenReview{}
+		if err := json.Unmarshal(resp.Body.Bytes(), result); err != nil {
+			t.Fatal(err)
+		}
+		if result.APIVersion != "authentication.k8s.io/v1" || result.Kind != "TokenReview" {
+			t.Errorf("expected the version of the request, got %#v", result.TypeMeta)
+		}
+		return result.Status
+	}
+
+	status := review(token)
+	if !status.Authenticated || status.User.Username != "alice" || status.User.UID != "alice-uid" {
+		t.Fatalf("expected the token to authenticate alice, got %#v", status)
+	}
+	if len(status.User.Groups) != 2 || status.User.Groups[0] != "admins" || status.User.Groups[1] != "developers" {
+		t.Errorf("unexpected groups %v", status.User.Groups)
+	}
+	if scopes := status.User.Extra[ScopesKey]; len(scopes) != 1 || scopes[0] != "user:info" {
+		t.Errorf("unexpected scopes %v", status.User.Extra)
+	}
+	if methods := status.User.Extra[AuthenticationMethodsKey]; len(methods) != 2 || methods[0] != "mfa" {
+		t.Errorf("unexpected authentication methods %v", status.User.Extra)
+	}
+	if contextClass := status.User.Extra[AuthenticationContextClassKey]; len(contextClass) != 1 || contextClass[0] != "gold" {
+		t.Errorf("unexpected authentication context class %v", status.User.Extra)
+	}
+	if authenticatedAt := status.User.Extra[AuthenticationTimeKey]; len(authenticatedAt) != 1 || authenticatedAt[0] != "1700000000" {
+		t.Errorf("unexpected authentication time %v", status.User.Extra)
+	}
+	if department := status.User.Extra["department.example.com"]; len(department) != 1 || department[0] != "finance" {
+		t.Errorf("unexpected extra user info %v", status.User.Extra)
+	}
+
+	// the use of the token extends its inactivity timeout
+	stored, err := oauthClient.OauthV1().OAuthAccessTokens().Get(context.TODO(), tokenformat.ObjectName(token), metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.InactivityTimeoutSeconds != 3600+600 {
+		t.Errorf("expected the inactivity timeout to be extended, got %d", stored.InactivityTimeoutSeconds)
+	}
+
+	for name, token := range map[string]string{
+		"unknown":            tokenformat.SHA256.Generate(),
+		"expired":            expiredToken,
+		"user created again": previousToken,
+		"unknown legacy":     tokenformat.Legacy.Generate(),
+		"empty":              "",
+	} {
+		if status := review(token); status.Authenticated || len(status.Error) > 0 {
+			t.Errorf("%s: expected the token not to authenticate, got %#v", name, status)
+		}
+	}
+}
+
+func TestTokenReviewMethod(t *testing.T) {
+	mux := http.NewServeMux()
+	NewTokenReview(nil, nil, nil, nil, nil, nil, nil, 0).Install(mux, "/oauth")
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/oauth/tokenreview", nil))
+	if resp.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected only POST requests, got %d", resp.Code)
+	}
+}
+
+func TestTokenReviewReviewer(t *testing.T) {
+	reviewer, certificate := newReviewer(t)
+	otherCA, otherCAKey := newCertificate(t, "other-ca", nil, nil)
+	untrusted, _ := newCertificate(t, "system:kube-apiserver", otherCA, otherCAKey)
+	trustedCA, trustedCAKey := newCertificate(t, "kube-apiserver-ca", nil, nil)
+	reviewer.Roots.AddCert(trustedCA)
+	otherSubject, _ := newCertificate(t, "system:anonymous", trustedCA, trustedCAKey)
+
+	accessTokens := oauthfake.NewSimpleClientset().OauthV1().OAuthAccessTokens()
+	mux := http.NewServeMux()
+	NewTokenReview(reviewer, accessTokens, nil, nil, nil, nil, nil, 600).Install(mux, "/oauth")
+
+	for name, testCase := range map[string]struct {
+		certificate *x509.Certificate
+		code        int
+	}{
+		"API server":          {certificate: certificate, code: http.StatusOK},
+		"without certificate": {code: http.StatusUnauthorized},
+		"untrusted CA":        {certificate: untrusted, code: http.StatusUnauthorized},
+		"other subject":       {certificate: otherSubject, code: http.StatusUnauthorized},
+	} {
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, newReviewRequest(t, testCase.certificate, authenticationv1.TokenReviewSpec{Token: tokenformat.SHA256.Generate()}))
+		if resp.Code != testCase.code {
+			t.Errorf("%s: expected %d, got %d %s", name, testCase.code, resp.Code, resp.Body.String())
+		}
+	}
+}
+
+func TestTokenReviewBindingsAndAudiences(t *testing.T) {
+	now := time.Now()
+	created := metav1.NewTime(now.Add(-time.Hour))
+	tokens := map[string]string{}
+	objects := []runtime.Object{&oauthapi.OAuthClient{ObjectMeta: metav1.ObjectMeta{Name: "console"}}}
+	for name, annotations := range map[string]map[string]string{
+		"unrestricted":   nil,
+		"certificate":    {registrystorage.CertificateThumbprintAnnotation: "thumbprint"},
+		"dpop":           {registrystorage.ProofKeyThumbprintAnnotation: "jkt"},
+		"audiences":      {registrystorage.AudiencesAnnotation: "https://kubernetes.default.svc,billing"},
+		"other audience": {registrystorage.AudiencesAnnotation: "billing"},
+	} {
+		tokens[name] = tokenformat.SHA256.Generate()
+		objects = append(objects, &oauthapi.OAuthAccessToken{
+			ObjectMeta:               metav1.ObjectMeta{Name: tokenformat.ObjectName(tokens[name]), CreationTimestamp: created, Annotations: annotations},
+			ClientName:               "console",
+			UserName:                 "alice",
+			UserUID:                  "alice-uid",
+			ExpiresIn:                86400,
+			InactivityTimeoutSeconds: 3700,
+		})
+	}
+	oauthClient := oauthfake.NewSimpleClientset(objects...)
+	userClient := userfake.NewSimpleClientset(&userapi.User{ObjectMeta: metav1.ObjectMeta{Name: "alice", UID: "alice-uid"}})
+
+	reviewer, certificate := newReviewer(t)
+	endpoint := NewTokenReview(reviewer, oauthClient.OauthV1().OAuthAccessTokens(), userClient.UserV1().Users(), nil, testGroups{}, oauthClient.OauthV1().OAuthClients(), nil, 600)
+	endpoint.(*tokenReview).now = func() time.Time { return now }
+	mux := http.NewServeMux()
+	endpoint.Install(mux, "/oauth")
+
+	apiAudiences := []string{"https://kubernetes.default.svc", "https://api.example.com"}
+	for _, testCase := range []struct {
+		token         string
+		audiences     []string
+		authenticated bool
+		expected      []string
+	}{
+		{token: "unrestricted", authenticated: true},
+		{token: "unrestricted", audiences: apiAudiences, authenticated: true, expected: apiAudiences},
+		{token: "certificate", audiences: apiAudiences},
+		{token: "dpop", audiences: apiAudiences},
+		{token: "audiences", audiences: apiAudiences, authenticated: true, expected: []string{"https://kubernetes.default.svc"}},
+		{token: "audiences"},
+		{token: "other audience", audiences: apiAudiences},
+	} {
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, newReviewRequest(t, certificate, authenticationv1.TokenReviewSpec{Token: tokens[testCase.token], Audiences: testCase.audiences}))
+		result := &authenticationv1.TokenReview{}
+		if err := json.Unmarshal(resp.Body.Bytes(), result); err != nil {
+			t.Fatalf("unexpected response %d %s: %v", resp.Code, resp.Body.String(), err)
+		}
+		if result.Status.Authenticated != testCase.authenticated || !reflect.DeepEqual(result.Status.Audiences, testCase.expected) {
+			t.Errorf("%s with audiences %v: expected authenticated %v for %v, got %#v", testCase.token, testCase.audiences, testCase.authenticated, testCase.expected, result.Status)
+		}
+	}
+
+	// reviews of bound tokens do not keep them from timing out
+	stored, err := oauthClient.OauthV1().OAuthAccessTokens().Get(context.TODO(), tokenformat.ObjectName(tokens["dpop"]), metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.InactivityTimeoutSeconds != 3700 {
+		t.Errorf("expected the inactivity timeout of the bound token to be kept, got %d", stored.InactivityTimeoutSeconds)
+	}
+}
+
+type testBootstrapUser struct {
+	data *bootstrap.BootstrapUserData
+}
+
+func (b *testBootstrapUser) Get() (*bootstrap.BootstrapUserData, bool, error) {
+	return b.data, b.data != nil, nil
+}
+
+func (b *testBootstrapUser) IsEnabled() (bool, error) {
+	return b.data != nil, nil
+}
+
+func TestTokenReviewBootstrapUser(t *testing.T) {
+	created := metav1.NewTime(time.Now().Add(-time.Minute))
+	token, previousToken := tokenformat.SHA256.Generate(), tokenformat.SHA256.Generate()
+	oauthClient := oauthfake.NewSimpleClientset(
+		&oauthapi.OAuthAccessToken{
+			ObjectMeta: metav1.ObjectMeta{Name: tokenformat.ObjectName(token), CreationTimestamp: created},
+			ClientName: "console",
+			UserName:   bootstrap.BootstrapUser,
+			UserUID:    "password-uid",
+		},
+		&oauthapi.OAuthAccessToken{
+			ObjectMeta: metav1.ObjectMeta{Name: tokenformat.ObjectName(previousToken), CreationTimestamp: created},
+			ClientName: "console",
+			UserName:   bootstrap.BootstrapUser,
+			UserUID:    "previous-password-uid",
+		},
+	)
+	// the bootstrap user has no user object
+	userClient := userfake.NewSimpleClientset()
+	bootstrapUser := &testBootstrapUser{data: &bootstrap.BootstrapUserData{UID: "password-uid"}}
+
+	reviewer, certificate := newReviewer(t)
+	mux := http.NewServeMux()
+	NewTokenReview(reviewer, oauthClient.OauthV1().OAuthAccessTokens(), userClient.UserV1().Users(), bootstrapUser, testGroups{}, oauthClient.OauthV1().OAuthClients(), nil, 600).Install(mux, "/oauth")
+
+	review := func(token string) authenticationv1.TokenReviewStatus {
+		t.Helper()
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, newReviewRequest(t, certificate, authenticationv1.TokenReviewSpec{Token: token}))
+		result := &authenticationv1.TokenReview{}
+		if err := json.Unmarshal(resp.Body.Bytes(), result); err != nil {
+			t.Fatalf("unexpected response %d %s: %v", resp.Code, resp.Body.String(), err)
+		}
+		return result.Status
+	}
+
+	status := review(token)
+	if !status.Authenticated || status.User.Username != bootstrap.BootstrapUser || status.User.UID != "password-uid" {
+		t.Fatalf("expected the token to authenticate the bootstrap user, got %#v", status)
+	}
+	if !reflect.DeepEqual(status.User.Groups, []string{bootstrapUserGroup}) {
+		t.Errorf("unexpected groups %v", status.User.Groups)
+	}
+	// the password changed since the token was issued
+	if status := review(previousToken); status.Authenticated {
+		t.Errorf("expected the token of the previous password not to authenticate, got %#v", status)
+	}
+	// the bootstrap user was removed
+	bootstrapUser.data = nil
+	if status := review(token); status.Authenticated {
+		t.Errorf("expected the token of the removed bootstrap user not to authenticate, got %#v", status)
+	}
+}
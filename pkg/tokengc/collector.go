@@ -0,0 +1,156 @@
+// Package tokengc deletes access tokens and authorize tokens that expired or timed out, so that they do not
+// accumulate in the cluster.
+package tokengc
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+
+	oauthapi "github.com/openshift/api/oauth/v1"
+	oauthclient "github.com/openshift/client-go/oauth/clientset/versioned/typed/oauth/v1"
+
+	"github.com/openshift/oauth-server/pkg/osinserver/registrystorage"
+	metrics "github.com/openshift/oauth-server/pkg/prometheus"
+)
+
+const (
+	// DefaultInterval is the time between collections
+	DefaultInterval = time.Hour
+	// DefaultBatchSize is the number of tokens that are listed at once
+	DefaultBatchSize = 500
+
+	// jitterFactor spreads the collections of several replicas, which would otherwise delete the same tokens
+	jitterFactor = 0.2
+)
+
+// Collector periodically deletes expired access and authorize tokens
+type Collector struct {
+	accessTokens    oauthclient.OAuthAccessTokenInterface
+	authorizeTokens oauthclient.OAuthAuthorizeTokenInterface
+	interval        time.Duration
+	batchSize       int64
+	now             func() time.Time
+}
+
+// NewCollector returns a collector that deletes expired tokens every interval, listing batchSize tokens at once
+func NewCollector(accessTokens oauthclient.OAuthAccessTokenInterface, authorizeTokens oauthclient.OAuthAuthorizeTokenInterface, interval time.Duration, batchSize int64) *Collector {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+	return &Collector{
+		accessTokens:    accessTokens,
+		authorizeTokens: authorizeTokens,
+		interval:        interval,
+		batchSize:       batchSize,
+		now:             time.Now,
+	}
+}
+
+// Run collects expired tokens until stopCh is closed. The first collection happens after a jittered interval,
+// so that restarts of the server do not cause a burst of requests.
+func (c *Collector) Run(stopCh <-chan struct{}) {
+	defer utilruntime.HandleCrash()
+	wait.JitterUntil(c.Collect, c.interval, jitterFactor, false, stopCh)
+}
+
+// Collect deletes the access and authorize tokens that are expired now
+func (c *Collector) Collect() {
+	start := c.now()
+	deleted, err := c.collectAccessTokens(start)
+	metrics.RecordExpiredTokensDeleted(metrics.AccessTokenType, deleted)
+	if err != nil {
+		metrics.RecordTokenCollectionError(metrics.AccessTokenType)
+		utilruntime.HandleError(fmt.Errorf("error collecting expired access tokens: %v", err))
+	}
+	deletedCodes, err := c.collectAuthorizeTokens(start)
+	metrics.RecordExpiredTokensDeleted(metrics.AuthorizeTokenType, deletedCodes)
+	if err != nil {
+		metrics.RecordTokenCollectionError(metrics.AuthorizeTokenType)
+		utilruntime.HandleError(fmt.Errorf("error collecting expired authorize tokens: %v", err))
+	}
+	klog.V(4).Infof("deleted %d expired access tokens and %d expired authorize tokens in %v", deleted, deletedCodes, c.now().Sub(start))
+}
+
+func (c *Collector) collectAccessTokens(now time.Time) (int, error) {
+	deleted := 0
+	options := metav1.ListOptions{Limit: c.batchSize}
+	for {
+		tokens, err := c.accessTokens.List(context.TODO(), options)
+		if err != nil {
+			return deleted, err
+		}
+		for i := range tokens.Items {
+			token := &tokens.Items[i]
+			if registrystorage.TokenActive(token, now) {
+				continue
+			}
+			// the resource version keeps tokens whose inactivity timeout was just extended
+			err := c.accessTokens.Delete(context.TODO(), token.Name, metav1.DeleteOptions{Preconditions: preconditions(token.ObjectMeta)})
+			if err != nil && !kerrors.IsNotFound(err) && !kerrors.IsConflict(err) {
+				return deleted, fmt.Errorf("error deleting access token %q: %v", token.Name, err)
+			}
+			if err == nil {
+				deleted++
+			}
+		}
+		if len(tokens.Continue) == 0 {
+			return deleted, nil
+		}
+		options.Continue = tokens.Continue
+	}
+}
+
+func (c *Collector) collectAuthorizeTokens(now time.Time) (int, error) {
+	deleted := 0
+	options := metav1.ListOptions{Limit: c.batchSize}
+	for {
+		tokens, err := c.authorizeTokens.List(context.TODO(), options)
+		if err != nil {
+			return deleted, err
+		}
+		for i := range tokens.Items {
+			token := &tokens.Items[i]
+			if !authorizeTokenExpired(token, now) {
+				continue
+			}
+			err := c.authorizeTokens.Delete(context.TODO(), token.Name, metav1.DeleteOptions{Preconditions: preconditions(token.ObjectMeta)})
+			if err != nil && !kerrors.IsNotFound(err) && !kerrors.IsConflict(err) {
+				return deleted, fmt.Errorf("error deleting authorize token %q: %v", token.Name, err)
+			}
+			if err == nil {
+				deleted++
+			}
+		}
+		if len(tokens.Continue) == 0 {
+			return deleted, nil
+		}
+		options.Continue = tokens.Continue
+	}
+}
+
+// authorizeTokenExpired returns true if the authorize token can no longer be exchanged at the given time
+func authorizeTokenExpired(token *oauthapi.OAuthAuthorizeToken, now time.Time) bool {
+	return token.ExpiresIn > 0 && token.CreationTimestamp.Add(time.Duration(token.ExpiresIn)*time.Second).Before(now)
+}
+
+// preconditions only delete the token that was listed
+func preconditions(meta metav1.ObjectMeta) *metav1.Preconditions {
+	preconditions := &metav1.Preconditions{}
+	if uid := meta.UID; len(uid) > 0 {
+		preconditions.UID = &uid
+	}
+	if resourceVersion := meta.ResourceVersion; len(resourceVersion) > 0 {
+		preconditions.ResourceVersion = &resourceVersion
+	}
+	return preconditions
+}
@@ -0,0 +1,52 @@
+package tokengc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	oauthv1 "github.com/openshift/api/oauth/v1"
+	oauthfake "github.com/openshift/client-go/oauth/clientset/versioned/fake"
+)
+
+func TestCollect(t *testing.T) {
+	now := time.Now()
+	created := metav1.NewTime(now.Add(-time.Hour))
+	meta := func(name string) metav1.ObjectMeta {
+		return metav1.ObjectMeta{Name: name, CreationTimestamp: created}
+	}
+	client := oauthfake.NewSimpleClientset(
+		&oauthv1.OAuthAccessToken{ObjectMeta: meta("sha256~expired"), ExpiresIn: 60},
+		&oauthv1.OAuthAccessToken{ObjectMeta: meta("sha256~timed-out"), ExpiresIn: 86400, InactivityTimeoutSeconds: 600},
+		&oauthv1.OAuthAccessToken{ObjectMeta: meta("sha256~active"), ExpiresIn: 86400, InactivityTimeoutSeconds: 7200},
+		&oauthv1.OAuthAccessToken{ObjectMeta: meta("sha256~never-expires")},
+		&oauthv1.OAuthAuthorizeToken{ObjectMeta: meta("sha256~expired-code"), ExpiresIn: 300},
+		&oauthv1.OAuthAuthorizeToken{ObjectMeta: meta("sha256~code"), ExpiresIn: 86400},
+	)
+	collector := NewCollector(client.OauthV1().OAuthAccessTokens(), client.OauthV1().OAuthAuthorizeTokens(), 0, 1)
+	collector.now = func() time.Time { return now }
+	collector.Collect()
+
+	accessTokens, err := client.OauthV1().OAuthAccessTokens().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	remaining := sets.NewString()
+	for _, token := range accessTokens.Items {
+		remaining.Insert(token.Name)
+	}
+	if expected := sets.NewString("sha256~active", "sha256~never-expires"); !remaining.Equal(expected) {
+		t.Errorf("expected access tokens %v to remain, got %v", expected.List(), remaining.List())
+	}
+
+	authorizeTokens, err := client.OauthV1().OAuthAuthorizeTokens().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(authorizeTokens.Items) != 1 || authorizeTokens.Items[0].Name != "sha256~code" {
+		t.Errorf("expected only the unexpired authorize token to remain, got %v", authorizeTokens.Items)
+	}
+}
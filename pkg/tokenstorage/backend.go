@@ -0,0 +1,431 @@
+package tokenstorage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/apimachinery/pkg/watch"
+
+	oauthapi "github.com/openshift/api/oauth/v1"
+	oauthclient "github.com/openshift/client-go/oauth/clientset/versioned/typed/oauth/v1"
+
+	"github.com/openshift/oauth-server/pkg/server/session"
+)
+
+// noExpiryTTL is how long tokens that never expire are kept
+const noExpiryTTL = 10 * 365 * 24 * time.Hour
+
+// NewBackendStorage returns a storage that keeps tokens in the backend, e.g. redis, instead of the Kubernetes API.
+// Tokens are removed from the backend once they expire or time out. Tokens are keyed by their object name, which is
+// a hash of the token, so that the contents of the backend cannot be used to authenticate.
+//
+// The Kubernetes API does not know the tokens of the backend, they can only be validated with the token review
+// endpoint of the server. Updates and deletions check their preconditions before they write, concurrent writes of
+// the same token may be lost.
+func NewBackendStorage(backend session.Backend) Storage {
+	return &backendStorage{
+		accessTokens: &accessTokens{store: &objectStore{
+			backend:  backend,
+			resource: oauthapi.Resource("oauthaccesstokens"),
+			prefix:   "oauthaccesstoken~",
+			now:      time.Now,
+		}},
+		authorizeTokens: &authorizeTokens{store: &objectStore{
+			backend:  backend,
+			resource: oauthapi.Resource("oauthauthorizetokens"),
+			prefix:   "oauthauthorizetoken~",
+			now:      time.Now,
+		}},
+	}
+}
+
+type backendStorage struct {
+	accessTokens    *accessTokens
+	authorizeTokens *authorizeTokens
+}
+
+func (s *backendStorage) OAuthAccessTokens() oauthclient.OAuthAccessTokenInterface {
+	return s.accessTokens
+}
+
+func (s *backendStorage) OAuthAuthorizeTokens() oauthclient.OAuthAuthorizeTokenInterface {
+	return s.authorizeTokens
+}
+
+// storedObject is the part of the stored tokens that the store itself uses
+type storedObject struct {
+	metav1.ObjectMeta `json:"metadata"`
+	UserName          string `json:"userName"`
+}
+
+// objectStore keeps objects as JSON under their name. The names of all objects and the names of the objects of each
+// user are kept in indexes, so that they can be listed in pages.
+type objectStore struct {
+	backend  session.Backend
+	resource schema.GroupResource
+	prefix   string
+	now      func() time.Time
+}
+
+func (s *objectStore) key(name string) string {
+	return s.prefix + name
+}
+
+// allKey is the key of the index of the names of all objects. Object names never start with "~", so the keys of the
+// indexes cannot collide with the keys of objects.
+func (s *objectStore) allKey() string {
+	return s.prefix + "~index"
+}
+
+func (s *objectStore) userKey(userName string) string {
+	return s.prefix + "~index~user~" + userName
+}
+
+// load decodes the object stored under name into obj. It returns false if there is none.
+func (s *objectStore) load(name string, obj interface{}) (bool, error) {
+	if len(name) == 0 {
+		return false, nil
+	}
+	data, err := s.backend.Get(s.key(name))
+	if err != nil || data == nil {
+		return false, err
+	}
+	if err := json.Unmarshal(data, obj); err != nil {
+		return false, fmt.Errorf("error decoding %s %q: %v", s.resource, name, err)
+	}
+	return true, nil
+}
+
+// get decodes the object stored under name into obj or returns a NotFound error
+func (s *objectStore) get(name string, obj interface{}) error {
+	found, err := s.load(name, obj)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return kerrors.NewNotFound(s.resource, name)
+	}
+	return nil
+}
+
+// create stores a new object, setting the fields of the meta that are controlled by the API
+func (s *objectStore) create(meta *metav1.ObjectMeta, userName string, obj interface{}, lifetime func() time.Duration) error {
+	if len(meta.Name) == 0 {
+		return kerrors.NewBadRequest(fmt.Sprintf("%s require a name", s.resource))
+	}
+	existing := &storedObject{}
+	if found, err := s.load(meta.Name, existing); err != nil {
+		return err
+	} else if found {
+		return kerrors.NewAlreadyExists(s.resource, meta.Name)
+	}
+	meta.CreationTimestamp = metav1.NewTime(s.now().Truncate(time.Second))
+	meta.UID = uuid.NewUUID()
+	meta.ResourceVersion = "1"
+	return s.save(meta, userName, obj, lifetime())
+}
+
+// update replaces an object, if the resource version of the meta is set, it must be the stored one
+func (s *objectStore) update(meta *metav1.ObjectMeta, userName string, obj interface{}, lifetime func() time.Duration) error {
+	existing := &storedObject{}
+	if err := s.get(meta.Name, existing); err != nil {
+		return err
+	}
+	if len(meta.ResourceVersion) > 0 && meta.ResourceVersion != existing.ResourceVersion {
+		return kerrors.NewConflict(s.resource, meta.Name, fmt.Errorf("the object has been modified"))
+	}
+	version, _ := strconv.ParseInt(existing.ResourceVersion, 10, 64)
+	meta.ResourceVersion = strconv.FormatInt(version+1, 10)
+	meta.CreationTimestamp = existing.CreationTimestamp
+	meta.UID = existing.UID
+	if existing.UserName != userName {
+		if err := s.backend.RemoveIndexMember(s.userKey(existing.UserName), meta.Name); err != nil {
+			return err
+		}
+	}
+	return s.save(meta, userName, obj, lifetime())
+}
+
+func (s *objectStore) save(meta *metav1.ObjectMeta, userName string, obj interface{}, ttl time.Duration) error {
+	if ttl <= 0 {
+		// the object expired already
+		return s.remove(meta.Name, userName)
+	}
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	if err := s.backend.Set(s.key(meta.Name), data, ttl); err != nil {
+		return err
+	}
+	if err := s.backend.AddIndexMember(s.allKey(), meta.Name, ttl); err != nil {
+		return err
+	}
+	return s.backend.AddIndexMember(s.userKey(userName), meta.Name, ttl)
+}
+
+func (s *objectStore) delete(name string, options metav1.DeleteOptions) error {
+	existing := &storedObject{}
+	if err := s.get(name, existing); err != nil {
+		return err
+	}
+	if preconditions := options.Preconditions; preconditions != nil {
+		if preconditions.UID != nil && *preconditions.UID != existing.UID {
+			return kerrors.NewConflict(s.resource, name, fmt.Errorf("the UID in the precondition (%s) does not match the UID in record (%s)", *preconditions.UID, existing.UID))
+		}
+		if preconditions.ResourceVersion != nil && *preconditions.ResourceVersion != existing.ResourceVersion {
+			return kerrors.NewConflict(s.resource, name, fmt.Errorf("the ResourceVersion in the precondition (%s) does not match the ResourceVersion in record (%s)", *preconditions.ResourceVersion, existing.ResourceVersion))
+		}
+	}
+	return s.remove(name, existing.UserName)
+}
+
+func (s *objectStore) remove(name, userName string) error {
+	if err := s.backend.Delete(s.key(name)); err != nil {
+		return err
+	}
+	if err := s.backend.RemoveIndexMember(s.allKey(), name); err != nil {
+		return err
+	}
+	return s.backend.RemoveIndexMember(s.userKey(userName), name)
+}
+
+// list passes the objects that match the field selector of the options to add, which returns whether the object
+// also matched the label selector and was added to the list. It returns the continue token of the next page.
+// Objects are listed in the order of their names, a page continues after the last name of the previous one.
+func (s *objectStore) list(options metav1.ListOptions, add func(data []byte, selector labels.Selector) (bool, error)) (string, error) {
+	setKey := s.allKey()
+	if len(options.FieldSelector) > 0 {
+		selector, err := fields.ParseSelector(options.FieldSelector)
+		if err != nil {
+			return "", kerrors.NewBadRequest(err.Error())
+		}
+		userName, ok := selector.RequiresExactMatch("userName")
+		if !ok || len(selector.Requirements()) != 1 {
+			return "", kerrors.NewBadRequest(fmt.Sprintf("%s can only be selected by userName", s.resource))
+		}
+		setKey = s.userKey(userName)
+	}
+	labelSelector, err := labels.Parse(options.LabelSelector)
+	if err != nil {
+		return "", kerrors.NewBadRequest(err.Error())
+	}
+
+	// pages are read from the index, so that listing a page does not read the names of all objects
+	batch := 0
+	if options.Limit > 0 {
+		batch = int(options.Limit)
+	}
+	after, last := options.Continue, ""
+	count := int64(0)
+	for {
+		names, err := s.backend.IndexMembers(setKey, after, batch)
+		if err != nil {
+			return "", err
+		}
+		for _, name := range names {
+			if options.Limit > 0 && count == options.Limit {
+				return last, nil
+			}
+			last = name
+			data, err := s.backend.Get(s.key(name))
+			if err != nil {
+				return "", err
+			}
+			if data == nil {
+				// the object expired, the index outlives its members
+				if err := s.backend.RemoveIndexMember(setKey, name); err != nil {
+					return "", err
+				}
+				continue
+			}
+			added, err := add(data, labelSelector)
+			if err != nil {
+				return "", fmt.Errorf("error decoding %s %q: %v", s.resource, name, err)
+			}
+			if added {
+				count++
+			}
+		}
+		if batch == 0 || len(names) < batch {
+			return "", nil
+		}
+		after = names[len(names)-1]
+	}
+}
+
+// lifetime returns how long a token that was created at the given time is kept. Zero durations are unlimited.
+func (s *objectStore) lifetime(created time.Time, durations ...time.Duration) time.Duration {
+	expires := created.Add(noExpiryTTL)
+	for _, d := range durations {
+		if d > 0 && created.Add(d).Before(expires) {
+			expires = created.Add(d)
+		}
+	}
+	return expires.Sub(s.now())
+}
+
+type accessTokens struct {
+	store *objectStore
+}
+
+func (t *accessTokens) lifetime(token *oauthapi.OAuthAccessToken) func() time.Duration {
+	return func() time.Duration {
+		return t.store.lifetime(token.CreationTimestamp.Time,
+			time.Duration(token.ExpiresIn)*time.Second,
+			time.Duration(token.InactivityTimeoutSeconds)*time.Second,
+		)
+	}
+}
+
+func (t *accessTokens) Create(ctx context.Context, token *oauthapi.OAuthAccessToken, opts metav1.CreateOptions) (*oauthapi.OAuthAccessToken, error) {
+	token = token.DeepCopy()
+	if err := t.store.create(&token.ObjectMeta, token.UserName, token, t.lifetime(token)); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+func (t *accessTokens) Update(ctx context.Context, token *oauthapi.OAuthAccessToken, opts metav1.UpdateOptions) (*oauthapi.OAuthAccessToken, error) {
+	token = token.DeepCopy()
+	if err := t.store.update(&token.ObjectMeta, token.UserName, token, t.lifetime(token)); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+func (t *accessTokens) Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error {
+	return t.store.delete(name, opts)
+}
+
+func (t *accessTokens) DeleteCollection(ctx context.Context, opts metav1.DeleteOptions, listOpts metav1.ListOptions) error {
+	tokens, err := t.List(ctx, listOpts)
+	if err != nil {
+		return err
+	}
+	for _, token := range tokens.Items {
+		if err := t.Delete(ctx, token.Name, opts); err != nil && !kerrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *accessTokens) Get(ctx context.Context, name string, opts metav1.GetOptions) (*oauthapi.OAuthAccessToken, error) {
+	token := &oauthapi.OAuthAccessToken{}
+	if err := t.store.get(name, token); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+func (t *accessTokens) List(ctx context.Context, opts metav1.ListOptions) (*oauthapi.OAuthAccessTokenList, error) {
+	list := &oauthapi.OAuthAccessTokenList{}
+	next, err := t.store.list(opts, func(data []byte, selector labels.Selector) (bool, error) {
+		token := oauthapi.OAuthAccessToken{}
+		if err := json.Unmarshal(data, &token); err != nil || !selector.Matches(labels.Set(token.Labels)) {
+			return false, err
+		}
+		list.Items = append(list.Items, token)
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	list.Continue = next
+	return list, nil
+}
+
+func (t *accessTokens) Watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+	return nil, kerrors.NewMethodNotSupported(t.store.resource, "watch")
+}
+
+func (t *accessTokens) Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts metav1.PatchOptions, subresources ...string) (*oauthapi.OAuthAccessToken, error) {
+	return nil, kerrors.NewMethodNotSupported(t.store.resource, "patch")
+}
+
+type authorizeTokens struct {
+	store *objectStore
+}
+
+func (t *authorizeTokens) lifetime(token *oauthapi.OAuthAuthorizeToken) func() time.Duration {
+	return func() time.Duration {
+		return t.store.lifetime(token.CreationTimestamp.Time, time.Duration(token.ExpiresIn)*time.Second)
+	}
+}
+
+func (t *authorizeTokens) Create(ctx context.Context, token *oauthapi.OAuthAuthorizeToken, opts metav1.CreateOptions) (*oauthapi.OAuthAuthorizeToken, error) {
+	token = token.DeepCopy()
+	if err := t.store.create(&token.ObjectMeta, token.UserName, token, t.lifetime(token)); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+func (t *authorizeTokens) Update(ctx context.Context, token *oauthapi.OAuthAuthorizeToken, opts metav1.UpdateOptions) (*oauthapi.OAuthAuthorizeToken, error) {
+	token = token.DeepCopy()
+	if err := t.store.update(&token.ObjectMeta, token.UserName, token, t.lifetime(token)); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+func (t *authorizeTokens) Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error {
+	return t.store.delete(name, opts)
+}
+
+func (t *authorizeTokens) DeleteCollection(ctx context.Context, opts metav1.DeleteOptions, listOpts metav1.ListOptions) error {
+	tokens, err := t.List(ctx, listOpts)
+	if err != nil {
+		return err
+	}
+	for _, token := range tokens.Items {
+		if err := t.Delete(ctx, token.Name, opts); err != nil && !kerrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *authorizeTokens) Get(ctx context.Context, name string, opts metav1.GetOptions) (*oauthapi.OAuthAuthorizeToken, error) {
+	token := &oauthapi.OAuthAuthorizeToken{}
+	if err := t.store.get(name, token); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+func (t *authorizeTokens) List(ctx context.Context, opts metav1.ListOptions) (*oauthapi.OAuthAuthorizeTokenList, error) {
+	list := &oauthapi.OAuthAuthorizeTokenList{}
+	next, err := t.store.list(opts, func(data []byte, selector labels.Selector) (bool, error) {
+		token := oauthapi.OAuthAuthorizeToken{}
+		if err := json.Unmarshal(data, &token); err != nil || !selector.Matches(labels.Set(token.Labels)) {
+			return false, err
+		}
+		list.Items = append(list.Items, token)
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	list.Continue = next
+	return list, nil
+}
+
+func (t *authorizeTokens) Watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+	return nil, kerrors.NewMethodNotSupported(t.store.resource, "watch")
+}
+
+func (t *authorizeTokens) Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts metav1.PatchOptions, subresources ...string) (*oauthapi.OAuthAuthorizeToken, error) {
+	return nil, kerrors.NewMethodNotSupported(t.store.resource, "patch")
+}
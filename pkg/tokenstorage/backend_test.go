@@ -0,0 +1,194 @@
+package tokenstorage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+
+	oauthapi "github.com/openshift/api/oauth/v1"
+
+	"github.com/openshift/oauth-server/pkg/server/session"
+)
+
+func accessToken(name, userName string, expiresIn int64) *oauthapi.OAuthAccessToken {
+	return &oauthapi.OAuthAccessToken{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		ClientName: "console",
+		UserName:   userName,
+		UserUID:    userName + "-uid",
+		ExpiresIn:  expiresIn,
+		Scopes:     []string{"user:full"},
+	}
+}
+
+func TestAccessTokens(t *testing.T) {
+	storage := NewBackendStorage(session.NewMemoryBackend())
+	tokens := storage.OAuthAccessTokens()
+	ctx := context.TODO()
+
+	created, err := tokens.Create(ctx, accessToken("sha256~a", "alice", 3600), metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(created.UID) == 0 || created.ResourceVersion != "1" || created.CreationTimestamp.IsZero() {
+		t.Errorf("expected the fields controlled by the API to be set, got %#v", created.ObjectMeta)
+	}
+	if _, err := tokens.Create(ctx, accessToken("sha256~a", "alice", 3600), metav1.CreateOptions{}); !kerrors.IsAlreadyExists(err) {
+		t.Errorf("expected tokens to be created once, got %v", err)
+	}
+	for _, token := range []*oauthapi.OAuthAccessToken{accessToken("sha256~b", "alice", 0), accessToken("sha256~c", "bob", 3600)} {
+		if _, err := tokens.Create(ctx, token, metav1.CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	token, err := tokens.Get(ctx, "sha256~a", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.UID != created.UID || token.UserUID != "alice-uid" || token.Scopes[0] != "user:full" {
+		t.Errorf("unexpected token %#v", token)
+	}
+	if _, err := tokens.Get(ctx, "sha256~unknown", metav1.GetOptions{}); !kerrors.IsNotFound(err) {
+		t.Errorf("expected unknown tokens not to be found, got %v", err)
+	}
+
+	// updates
+	token.InactivityTimeoutSeconds = 600
+	updated, err := tokens.Update(ctx, token, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.ResourceVersion != "2" || updated.UID != created.UID || !updated.CreationTimestamp.Equal(&created.CreationTimestamp) {
+		t.Errorf("unexpected updated token %#v", updated.ObjectMeta)
+	}
+	if _, err := tokens.Update(ctx, token, metav1.UpdateOptions{}); !kerrors.IsConflict(err) {
+		t.Errorf("expected updates of outdated tokens to conflict, got %v", err)
+	}
+
+	// lists
+	list, err := tokens.List(ctx, metav1.ListOptions{FieldSelector: fields.OneTermEqualSelector("userName", "alice").String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Items) != 2 || list.Items[0].Name != "sha256~a" || list.Items[1].Name != "sha256~b" {
+		t.Errorf("unexpected tokens of alice %v", list.Items)
+	}
+	if _, err := tokens.List(ctx, metav1.ListOptions{FieldSelector: fields.OneTermEqualSelector("clientName", "console").String()}); !kerrors.IsBadRequest(err) {
+		t.Errorf("expected unsupported field selectors to be rejected, got %v", err)
+	}
+	names := []string{}
+	options := metav1.ListOptions{Limit: 2}
+	for {
+		list, err := tokens.List(ctx, options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list.Items) > 2 {
+			t.Errorf("expected pages of 2 tokens, got %d", len(list.Items))
+		}
+		for _, token := range list.Items {
+			names = append(names, token.Name)
+		}
+		if len(list.Continue) == 0 {
+			break
+		}
+		options.Continue = list.Continue
+	}
+	if len(names) != 3 {
+		t.Errorf("expected all tokens to be listed, got %v", names)
+	}
+
+	// deletions
+	if err := tokens.Delete(ctx, "sha256~a", metav1.DeleteOptions{Preconditions: metav1.NewUIDPreconditions("other")}); !kerrors.IsConflict(err) {
+		t.Errorf("expected deletions with other UIDs to conflict, got %v", err)
+	}
+	if err := tokens.Delete(ctx, "sha256~a", metav1.DeleteOptions{Preconditions: metav1.NewUIDPreconditions(string(created.UID))}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tokens.Delete(ctx, "sha256~a", metav1.DeleteOptions{}); !kerrors.IsNotFound(err) {
+		t.Errorf("expected deleted tokens not to be found, got %v", err)
+	}
+	list, err = tokens.List(ctx, metav1.ListOptions{FieldSelector: fields.OneTermEqualSelector("userName", "alice").String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Items) != 1 {
+		t.Errorf("expected deleted tokens not to be listed, got %v", list.Items)
+	}
+}
+
+func TestTokenLifetime(t *testing.T) {
+	backend := session.NewMemoryBackend()
+	storage := NewBackendStorage(backend).(*backendStorage)
+	now := time.Now()
+	storage.accessTokens.store.now = func() time.Time { return now }
+	tokens := storage.OAuthAccessTokens()
+
+	token := accessToken("sha256~a", "alice", 3600)
+	token.InactivityTimeoutSeconds = 300
+	token, err := tokens.Create(context.TODO(), token, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lifetime := storage.accessTokens.lifetime(token)(); lifetime > 300*time.Second || lifetime < 299*time.Second {
+		t.Errorf("expected tokens to be kept until they time out, got %v", lifetime)
+	}
+	token.InactivityTimeoutSeconds = 0
+	if lifetime := storage.accessTokens.lifetime(token)(); lifetime > time.Hour || lifetime < time.Hour-time.Second {
+		t.Errorf("expected tokens to be kept until they expire, got %v", lifetime)
+	}
+	token.ExpiresIn = 0
+	if lifetime := storage.accessTokens.lifetime(token)(); lifetime < noExpiryTTL-time.Second {
+		t.Errorf("expected tokens that never expire to be kept, got %v", lifetime)
+	}
+
+	// updating a token after it timed out removes it
+	now = now.Add(time.Hour)
+	token, err = tokens.Get(context.TODO(), "sha256~a", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tokens.Update(context.TODO(), token, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tokens.Get(context.TODO(), "sha256~a", metav1.GetOptions{}); !kerrors.IsNotFound(err) {
+		t.Errorf("expected timed out tokens to be removed, got %v", err)
+	}
+}
+
+func TestAuthorizeTokens(t *testing.T) {
+	tokens := NewBackendStorage(session.NewMemoryBackend()).OAuthAuthorizeTokens()
+	ctx := context.TODO()
+
+	code := &oauthapi.OAuthAuthorizeToken{
+		ObjectMeta: metav1.ObjectMeta{Name: "sha256~code"},
+		ClientName: "console",
+		UserName:   "alice",
+		UserUID:    "alice-uid",
+		ExpiresIn:  300,
+	}
+	if _, err := tokens.Create(ctx, code, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	list, err := tokens.List(ctx, metav1.ListOptions{FieldSelector: fields.OneTermEqualSelector("userName", "alice").String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Items) != 1 || list.Items[0].ClientName != "console" {
+		t.Errorf("unexpected authorize tokens %v", list.Items)
+	}
+	if err := tokens.Delete(ctx, "sha256~code", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tokens.Get(ctx, "sha256~code", metav1.GetOptions{}); !kerrors.IsNotFound(err) {
+		t.Errorf("expected deleted authorize tokens not to be found, got %v", err)
+	}
+	if _, err := tokens.Watch(ctx, metav1.ListOptions{}); !kerrors.IsMethodNotSupported(err) {
+		t.Errorf("expected watches to be unsupported, got %v", err)
+	}
+}
@@ -0,0 +1,17 @@
+// Package tokenstorage abstracts where the access and authorize tokens the server issues are kept. By default they
+// are OAuthAccessToken and OAuthAuthorizeToken objects of the Kubernetes API, which clusters with a lot of token
+// churn may want to keep out of etcd.
+package tokenstorage
+
+import (
+	oauthclient "github.com/openshift/client-go/oauth/clientset/versioned/typed/oauth/v1"
+)
+
+// Storage holds the access and authorize tokens. The OAuth client of the Kubernetes API implements it.
+//
+// Other storages only have to support what the server itself does with tokens: create, get, update and delete
+// them, and list them, optionally selected by the userName field and paged with a limit.
+type Storage interface {
+	oauthclient.OAuthAccessTokensGetter
+	oauthclient.OAuthAuthorizeTokensGetter
+}
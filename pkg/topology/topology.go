@@ -0,0 +1,191 @@
+// Package topology records the effective authentication setup of the oauth-server,
+// i.e. the identity providers, challengers, redirectors and endpoints that are
+// actually live, so that it can be compared with what was configured.
+package topology
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	oauthserver "github.com/openshift/oauth-server/pkg"
+)
+
+// Topology is a machine-readable description of the authentication setup
+type Topology struct {
+	// Generation is incremented every time the topology changes
+	Generation int       `json:"generation"`
+	Updated    time.Time `json:"updated"`
+
+	IdentityProviders []IdentityProvider `json:"identityProviders"`
+	// Challengers are the names of the challengers used for clients that cannot show login pages, in the order their
+	// challenges are sent
+	Challengers []string `json:"challengers"`
+	// Endpoints are the paths handled by the oauth-server itself
+	Endpoints []string `json:"endpoints"`
+	// ExternalURLs are the URLs under which users reach the server, starting with the masterPublicURL
+	ExternalURLs []string `json:"externalURLs,omitempty"`
+	// PathPrefix is the path below which an ingress serves the endpoints, if set
+	PathPrefix string `json:"pathPrefix,omitempty"`
+	// Issuers are the names of the issuers hosted in addition to the default one, their topologies are served under
+	// their URLs
+	Issuers []string `json:"issuers,omitempty"`
+
+	GrantMethod               string `json:"grantMethod"`
+	ServiceAccountGrantMethod string `json:"serviceAccountGrantMethod"`
+	Sessions                  bool   `json:"sessions"`
+	// SessionStorage is where sessions are kept, if enabled
+	SessionStorage string `json:"sessionStorage,omitempty"`
+
+	AccessTokenMaxAgeSeconds     int32 `json:"accessTokenMaxAgeSeconds"`
+	AuthorizeTokenMaxAgeSeconds  int32 `json:"authorizeTokenMaxAgeSeconds"`
+	AccessTokenInactivityTimeout int32 `json:"accessTokenInactivityTimeoutSeconds,omitempty"`
+}
+
+// IdentityProvider describes a single live identity provider
+type IdentityProvider struct {
+	Name          string `json:"name"`
+	Type          string `json:"type"`
+	MappingMethod string `json:"mappingMethod"`
+
+	// Redirector is set if the provider is offered to browsers
+	Redirector bool `json:"redirector"`
+	// Challenger is set if the provider accepts credentials from non-browser clients
+	Challenger bool `json:"challenger"`
+
+	LoginPath    string   `json:"loginPath,omitempty"`
+	CallbackPath string   `json:"callbackPath,omitempty"`
+	Scopes       []string `json:"scopes,omitempty"`
+	// Policies are provider specific restrictions and settings, e.g. required groups or organizations
+	Policies map[string]string `json:"policies,omitempty"`
+
+	// LastReload is set for providers that reload their configuration at runtime
+	LastReload *time.Time `json:"lastReload,omitempty"`
+}
+
+// Recorder collects the topology while handlers are built and serves it as JSON
+type Recorder struct {
+	lock     sync.RWMutex
+	topology Topology
+}
+
+// NewRecorder returns a Recorder for an empty topology
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// Update applies f to the topology
+func (r *Recorder) Update(f func(*Topology)) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	f(&r.topology)
+	r.topology.Generation++
+	r.topology.Updated = time.Now()
+}
+
+// AddIdentityProvider adds or replaces the identity provider with the same name
+func (r *Recorder) AddIdentityProvider(idp IdentityProvider) {
+	r.Update(func(t *Topology) {
+		for i := range t.IdentityProviders {
+			if t.IdentityProviders[i].Name == idp.Name {
+				t.IdentityProviders[i] = idp
+				return
+			}
+		}
+		t.IdentityProviders = append(t.IdentityProviders, idp)
+	})
+}
+
+// UpdateIdentityProvider applies f to the identity provider with the given name, if it exists
+func (r *Recorder) UpdateIdentityProvider(name string, f func(*IdentityProvider)) {
+	r.Update(func(t *Topology) {
+		for i := range t.IdentityProviders {
+			if t.IdentityProviders[i].Name == name {
+				f(&t.IdentityProviders[i])
+			}
+		}
+	})
+}
+
+// Reloaded records that an identity provider reloaded its configuration and logs the new topology
+func (r *Recorder) Reloaded(name string) {
+	now := time.Now()
+	r.UpdateIdentityProvider(name, func(idp *IdentityProvider) {
+		idp.LastReload = &now
+	})
+	r.Log("reload of identity provider " + name)
+}
+
+// Snapshot returns a copy of the current topology with sorted endpoints
+func (r *Recorder) Snapshot() Topology {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	t := r.topology
+	t.IdentityProviders = append([]IdentityProvider(nil), r.topology.IdentityProviders...)
+	t.Challengers = append([]string(nil), r.topology.Challengers...)
+	t.Endpoints = sortedCopy(r.topology.Endpoints)
+	return t
+}
+
+// Log writes the current topology as a single JSON log line
+func (r *Recorder) Log(event string) {
+	data, err := json.Marshal(r.Snapshot())
+	if err != nil {
+		klog.Errorf("Unable to encode authentication topology: %v", err)
+		return
+	}
+	klog.Infof("Authentication topology at %s: %s", event, data)
+}
+
+// ServeHTTP serves the current topology as JSON
+func (r *Recorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	data, err := json.MarshalIndent(r.Snapshot(), "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(data)
+}
+
+// Mux wraps mux and records every pattern that is registered with it as an endpoint
+func (r *Recorder) Mux(mux oauthserver.Mux) oauthserver.Mux {
+	return &recordingMux{mux: mux, recorder: r}
+}
+
+type recordingMux struct {
+	mux      oauthserver.Mux
+	recorder *Recorder
+}
+
+func (m *recordingMux) Handle(pattern string, handler http.Handler) {
+	m.record(pattern)
+	m.mux.Handle(pattern, handler)
+}
+
+func (m *recordingMux) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	m.record(pattern)
+	m.mux.HandleFunc(pattern, handler)
+}
+
+func (m *recordingMux) record(pattern string) {
+	m.recorder.Update(func(t *Topology) {
+		t.Endpoints = append(t.Endpoints, pattern)
+	})
+}
+
+func sortedCopy(in []string) []string {
+	out := append([]string(nil), in...)
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,57 @@
+package topology
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestRecorder(t *testing.T) {
+	recorder := NewRecorder()
+
+	mux := recorder.Mux(http.NewServeMux())
+	mux.Handle("/oauth/token", http.NotFoundHandler())
+	mux.HandleFunc("/login", func(http.ResponseWriter, *http.Request) {})
+
+	recorder.AddIdentityProvider(IdentityProvider{Name: "htpasswd", Type: "HTPasswdPasswordIdentityProvider", Redirector: true})
+	recorder.AddIdentityProvider(IdentityProvider{Name: "github", Type: "GitHubIdentityProvider", Scopes: []string{"user:email"}})
+	recorder.Update(func(t *Topology) {
+		t.Challengers = []string{"placeholder", "basic-challenge"}
+	})
+	recorder.Reloaded("htpasswd")
+
+	snapshot := recorder.Snapshot()
+	if expected := []string{"/login", "/oauth/token"}; !reflect.DeepEqual(snapshot.Endpoints, expected) {
+		t.Errorf("expected endpoints %v, got %v", expected, snapshot.Endpoints)
+	}
+	if expected := []string{"placeholder", "basic-challenge"}; !reflect.DeepEqual(snapshot.Challengers, expected) {
+		t.Errorf("expected challengers %v, got %v", expected, snapshot.Challengers)
+	}
+	if len(snapshot.IdentityProviders) != 2 || snapshot.IdentityProviders[0].LastReload == nil || snapshot.IdentityProviders[1].LastReload != nil {
+		t.Errorf("unexpected identity providers %#v", snapshot.IdentityProviders)
+	}
+	if snapshot.Generation != 6 {
+		t.Errorf("expected generation 6, got %d", snapshot.Generation)
+	}
+
+	w := httptest.NewRecorder()
+	recorder.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/auth-topology", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("unexpected response %d %v", w.Code, w.Header())
+	}
+	served := Topology{}
+	if err := json.Unmarshal(w.Body.Bytes(), &served); err != nil {
+		t.Fatal(err)
+	}
+	if served.Generation != snapshot.Generation || len(served.IdentityProviders) != 2 {
+		t.Errorf("unexpected served topology %#v", served)
+	}
+
+	w = httptest.NewRecorder()
+	recorder.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/debug/auth-topology", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected %d, got %d", http.StatusMethodNotAllowed, w.Code)
+	}
+}
@@ -0,0 +1,196 @@
+package upstreamtoken
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	"k8s.io/apiserver/pkg/endpoints/request"
+
+	userapi "github.com/openshift/api/user/v1"
+	userclient "github.com/openshift/client-go/user/clientset/versioned/typed/user/v1"
+
+	"github.com/openshift/oauth-server/pkg/logging"
+)
+
+// Handler lets in-cluster components retrieve and refresh the upstream tokens of users. Users may use their own
+// tokens, the tokens of other users need the authorization to get (GET) or update (POST) the upstreamtokens
+// subresource of the user.
+type Handler struct {
+	vault      *Vault
+	users      userclient.UserInterface
+	identities userclient.IdentityInterface
+	authorizer authorizer.Authorizer
+}
+
+// NewHandler returns a Handler for the tokens of the vault
+func NewHandler(vault *Vault, users userclient.UserInterface, identities userclient.IdentityInterface, authz authorizer.Authorizer) *Handler {
+	return &Handler{vault: vault, users: users, identities: identities, authorizer: authz}
+}
+
+// Response is the access token of an identity. Refresh tokens are never returned, they are only used by the server.
+type Response struct {
+	Identity    string     `json:"identity"`
+	Provider    string     `json:"provider"`
+	TokenType   string     `json:"tokenType,omitempty"`
+	AccessToken string     `json:"accessToken"`
+	Expiry      *time.Time `json:"expiry,omitempty"`
+	// Refreshable is whether a POST can refresh the access token
+	Refreshable bool `json:"refreshable"`
+}
+
+// target is the identity of a request and the user it is mapped to
+type target struct {
+	identity string
+	provider string
+	userName string
+	userUID  string
+}
+
+// ServeHTTP returns the access token of an identity on GET, and refreshes it on POST. The identity is given by the
+// identity parameter, or by the user and provider parameters for the identity of the user with the provider.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodGet+", "+http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+
+	caller, ok := request.UserFrom(req.Context())
+	if !ok {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	target, err := h.target(req)
+	if err != nil {
+		if status, ok := err.(kerrors.APIStatus); ok {
+			http.Error(w, err.Error(), int(status.Status().Code))
+			return
+		}
+		if errors.Is(err, ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	verb := "get"
+	if req.Method == http.MethodPost {
+		verb = "update"
+	}
+	allowed, err := h.authorized(req.Context(), caller, verb, target)
+	if err != nil {
+		logging.FromRequest(req).Error(err, "Error authorizing upstream token request", "user", target.userName, "caller", caller.GetName())
+	}
+	if !allowed {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var token Token
+	if req.Method == http.MethodPost {
+		token, err = h.vault.Refresh(target.userUID, target.provider)
+	} else {
+		token, err = h.vault.Get(target.userUID, target.provider)
+	}
+	var refreshErr *RefreshError
+	switch {
+	case err == nil:
+	case errors.Is(err, ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, ErrNoRefreshToken), kerrors.IsConflict(err):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.As(err, &refreshErr):
+		logging.FromRequest(req).Info(2, "Error refreshing upstream tokens", "identity", target.identity, "err", err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	default:
+		logging.FromRequest(req).Error(err, "Error reading upstream tokens", "identity", target.identity)
+		http.Error(w, "error reading the upstream tokens", http.StatusInternalServerError)
+		return
+	}
+
+	logging.FromRequest(req).Info(2, "Upstream access token retrieved", "identity", target.identity, "user", target.userName, "refreshed", req.Method == http.MethodPost, "caller", caller.GetName())
+
+	response := Response{
+		Identity:    target.identity,
+		Provider:    token.Provider,
+		TokenType:   token.TokenType,
+		AccessToken: token.AccessToken,
+		Refreshable: len(token.RefreshToken) > 0,
+	}
+	if !token.Expiry.IsZero() {
+		response.Expiry = &token.Expiry
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&response); err != nil {
+		utilruntime.HandleError(fmt.Errorf("unable to write upstream token: %v", err))
+	}
+}
+
+// target returns the identity of the request and the user it is mapped to
+func (h *Handler) target(req *http.Request) (target, error) {
+	query := req.URL.Query()
+	identityName, userName, provider := query.Get("identity"), query.Get("user"), query.Get("provider")
+	switch {
+	case len(identityName) > 0 && len(userName) == 0 && len(provider) == 0:
+		identity, err := h.identities.Get(req.Context(), identityName, metav1.GetOptions{})
+		if err != nil {
+			return target{}, err
+		}
+		if len(identity.User.Name) == 0 || len(identity.User.UID) == 0 {
+			return target{}, fmt.Errorf("identity %s is not mapped to a user: %w", identityName, ErrNotFound)
+		}
+		return target{identity: identity.Name, provider: identity.ProviderName, userName: identity.User.Name, userUID: string(identity.User.UID)}, nil
+	case len(identityName) == 0 && len(userName) > 0 && len(provider) > 0:
+	default:
+		return target{}, errors.New("either the identity parameter or the user and provider parameters are required")
+	}
+
+	user, err := h.users.Get(req.Context(), userName, metav1.GetOptions{})
+	if err != nil {
+		return target{}, err
+	}
+	for _, identity := range user.Identities {
+		if strings.HasPrefix(identity, provider+":") {
+			return target{identity: identity, provider: provider, userName: user.Name, userUID: string(user.UID)}, nil
+		}
+	}
+	return target{}, fmt.Errorf("user %s has no identity of provider %s: %w", userName, provider, ErrNotFound)
+}
+
+// authorized returns whether the caller may use the tokens of the target user with the verb. Users may always use
+// their own tokens.
+func (h *Handler) authorized(ctx context.Context, caller user.Info, verb string, target target) (bool, error) {
+	if len(caller.GetUID()) > 0 && caller.GetUID() == target.userUID && caller.GetName() == target.userName {
+		return true, nil
+	}
+	if h.authorizer == nil {
+		return false, nil
+	}
+	decision, _, err := h.authorizer.Authorize(ctx, authorizer.AttributesRecord{
+		User:            caller,
+		Verb:            verb,
+		APIGroup:        userapi.GroupName,
+		APIVersion:      "v1",
+		Resource:        "users",
+		Subresource:     "upstreamtokens",
+		Name:            target.userName,
+		ResourceRequest: true,
+	})
+	return decision == authorizer.DecisionAllow, err
+}
@@ -0,0 +1,338 @@
+// Package upstreamtoken keeps the access and refresh tokens that identity providers issue when users log in, so that
+// in-cluster components can call the API of a provider on behalf of its users. The tokens of each user and provider
+// are kept encrypted in a secret of their own.
+package upstreamtoken
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/RangelReale/osincli"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apiserver/pkg/authentication/user"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// UserUIDLabel is the label of the secrets that holds the UID of the user of the tokens
+	UserUIDLabel = "oauth.openshift.io/upstream-token-user-uid"
+	// ProviderAnnotation is the annotation of the secrets that holds the name of the identity provider of the tokens
+	ProviderAnnotation = "oauth.openshift.io/upstream-token-provider"
+
+	// secretPrefix is the prefix of the names of the secrets, followed by a hash of the UID of the user and the name
+	// of the provider
+	secretPrefix = "upstream-token-"
+	// tokenKey is the key of the secret data that holds the encrypted tokens
+	tokenKey = "token"
+)
+
+// ErrNotFound is returned for users without tokens of a provider
+var ErrNotFound = errors.New("no upstream tokens found")
+
+// ErrNoRefreshToken is returned when tokens without a refresh token are refreshed
+var ErrNoRefreshToken = errors.New("the upstream tokens have no refresh token")
+
+// Token holds the tokens an identity provider issued to a user
+type Token struct {
+	// Provider is the name of the identity provider that issued the tokens
+	Provider     string    `json:"provider"`
+	TokenType    string    `json:"tokenType,omitempty"`
+	AccessToken  string    `json:"accessToken"`
+	RefreshToken string    `json:"refreshToken,omitempty"`
+	Expiry       time.Time `json:"expiry"`
+}
+
+// Refresher exchanges a refresh token for new tokens at an identity provider
+type Refresher interface {
+	Refresh(refreshToken string) (*osincli.AccessData, error)
+}
+
+// ClientRefresher refreshes tokens with the OAuth client of an identity provider
+type ClientRefresher struct {
+	Client *osincli.Client
+}
+
+// Refresh implements Refresher
+func (r ClientRefresher) Refresh(refreshToken string) (*osincli.AccessData, error) {
+	return r.Client.NewAccessRequest(osincli.REFRESH_TOKEN, &osincli.AuthorizeData{Code: refreshToken}).GetToken()
+}
+
+// Vault keeps the tokens of users in secrets. It is the success handler of logins with identity providers, which
+// keeps the tokens of the login for the providers that were added. Tokens are kept by the UID of the user the
+// identity was mapped to, so that they are found no matter how the identity was named and are gone with the user.
+type Vault struct {
+	secrets corev1client.SecretInterface
+	aead    cipher.AEAD
+	now     func() time.Time
+
+	lock       sync.RWMutex
+	refreshers map[string]Refresher
+}
+
+// NewVault returns a vault that keeps tokens in secrets, encrypted with a key derived from secret
+func NewVault(secrets corev1client.SecretInterface, secret []byte) (*Vault, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("the upstream token secret is empty")
+	}
+	key := sha256.Sum256(secret)
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Vault{secrets: secrets, aead: aead, now: time.Now, refreshers: map[string]Refresher{}}, nil
+}
+
+// AddProvider keeps the tokens of logins with the identity provider, which are refreshed with refresher
+func (v *Vault) AddProvider(name string, refresher Refresher) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.refreshers[name] = refresher
+}
+
+func (v *Vault) refresher(provider string) (Refresher, bool) {
+	v.lock.RLock()
+	defer v.lock.RUnlock()
+	refresher, ok := v.refreshers[provider]
+	return refresher, ok
+}
+
+// AuthenticationSucceeded implements handlers.AuthenticationSuccessHandler. The tokens of the login are kept for the
+// user if the provider was added, a failure is logged and does not fail the login.
+func (v *Vault) AuthenticationSucceeded(user user.Info, _ string, _ http.ResponseWriter, req *http.Request) (bool, error) {
+	tokens, ok := tokensFrom(req)
+	if !ok {
+		return false, nil
+	}
+	if _, ok := v.refresher(tokens.provider); !ok {
+		return false, nil
+	}
+	if user == nil || len(user.GetUID()) == 0 {
+		klog.Errorf("not storing the upstream tokens of provider %s, the user has no UID", tokens.provider)
+		return false, nil
+	}
+	if err := v.Store(user.GetUID(), v.token(tokens.provider, tokens.accessData, "")); err != nil {
+		klog.Errorf("error storing the upstream tokens of provider %s for user %s: %v", tokens.provider, user.GetName(), err)
+	}
+	return false, nil
+}
+
+// token returns the tokens of the access data, the previous refresh token is kept if the provider did not issue a
+// new one
+func (v *Vault) token(provider string, accessData *osincli.AccessData, previousRefreshToken string) Token {
+	token := Token{
+		Provider:     provider,
+		TokenType:    accessData.TokenType,
+		AccessToken:  accessData.AccessToken,
+		RefreshToken: accessData.RefreshToken,
+	}
+	if len(token.RefreshToken) == 0 {
+		token.RefreshToken = previousRefreshToken
+	}
+	if accessData.Expiration != nil && *accessData.Expiration > 0 {
+		token.Expiry = v.now().Add(time.Duration(*accessData.Expiration) * time.Second).UTC().Truncate(time.Second)
+	}
+	return token
+}
+
+// Get returns the tokens the provider issued to the user with the given UID, or ErrNotFound
+func (v *Vault) Get(userUID, provider string) (Token, error) {
+	_, token, err := v.get(userUID, provider)
+	return token, err
+}
+
+func (v *Vault) get(userUID, provider string) (*corev1.Secret, Token, error) {
+	secret, err := v.secrets.Get(context.TODO(), secretName(userUID, provider), metav1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		return nil, Token{}, ErrNotFound
+	}
+	if err != nil {
+		return nil, Token{}, err
+	}
+	token, err := v.decrypt(userUID, provider, secret.Data[tokenKey])
+	if err != nil {
+		return nil, Token{}, fmt.Errorf("error decrypting the upstream tokens of provider %s for user %s: %v", provider, userUID, err)
+	}
+	return secret, token, nil
+}
+
+// Store keeps the tokens of the user with the given UID, replacing the previous tokens of the provider of the token
+func (v *Vault) Store(userUID string, token Token) error {
+	data, err := v.encrypt(userUID, token.Provider, token)
+	if err != nil {
+		return err
+	}
+	name := secretName(userUID, token.Provider)
+	secret, err := v.secrets.Get(context.TODO(), name, metav1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		_, err = v.secrets.Create(context.TODO(), &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Labels:      map[string]string{UserUIDLabel: userUID},
+				Annotations: map[string]string{ProviderAnnotation: token.Provider},
+			},
+			Type: corev1.SecretTypeOpaque,
+			Data: map[string][]byte{tokenKey: data},
+		}, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	return v.update(secret, data)
+}
+
+// update replaces the tokens of the secret, it fails if the secret changed since it was read
+func (v *Vault) update(secret *corev1.Secret, data []byte) error {
+	secret = secret.DeepCopy()
+	secret.Data = map[string][]byte{tokenKey: data}
+	_, err := v.secrets.Update(context.TODO(), secret, metav1.UpdateOptions{})
+	return err
+}
+
+// DeleteUser deletes the tokens of all providers of the user with the given UID and returns how many providers had
+// issued tokens to the user. A dry run only counts them.
+func (v *Vault) DeleteUser(userUID string, dryRun bool) (int, error) {
+	secrets, err := v.secrets.List(context.TODO(), metav1.ListOptions{LabelSelector: labels.SelectorFromSet(labels.Set{UserUIDLabel: userUID}).String()})
+	if err != nil {
+		return 0, err
+	}
+	deleted := 0
+	for _, secret := range secrets.Items {
+		if !dryRun {
+			err := v.secrets.Delete(context.TODO(), secret.Name, metav1.DeleteOptions{Preconditions: metav1.NewUIDPreconditions(string(secret.UID))})
+			if kerrors.IsNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return deleted, err
+			}
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// Refresh exchanges the refresh token the provider issued to the user with the given UID for new tokens at the
+// provider, keeps and returns them
+func (v *Vault) Refresh(userUID, provider string) (Token, error) {
+	secret, token, err := v.get(userUID, provider)
+	if err != nil {
+		return Token{}, err
+	}
+	if len(token.RefreshToken) == 0 {
+		return Token{}, ErrNoRefreshToken
+	}
+	refresher, ok := v.refresher(token.Provider)
+	if !ok {
+		return Token{}, fmt.Errorf("the tokens of identity provider %s are not kept", token.Provider)
+	}
+	accessData, err := refresher.Refresh(token.RefreshToken)
+	if err != nil {
+		return Token{}, &RefreshError{err: err}
+	}
+
+	refreshed := v.token(token.Provider, accessData, token.RefreshToken)
+	data, err := v.encrypt(userUID, provider, refreshed)
+	if err != nil {
+		return Token{}, err
+	}
+	// concurrent refreshes conflict, the refresh token may have been rotated by the other one
+	if err := v.update(secret, data); err != nil {
+		return Token{}, err
+	}
+	return refreshed, nil
+}
+
+// RefreshError is returned when the identity provider did not refresh the tokens
+type RefreshError struct {
+	err error
+}
+
+func (e *RefreshError) Error() string {
+	return fmt.Sprintf("error refreshing the upstream tokens: %v", e.err)
+}
+
+func (e *RefreshError) Unwrap() error {
+	return e.err
+}
+
+// encrypt returns the nonce followed by the encrypted tokens. The user and the provider are authenticated with the
+// tokens, so that the tokens of one user cannot be passed off as the tokens of another one by copying secrets.
+func (v *Vault) encrypt(userUID, provider string, token Token) ([]byte, error) {
+	plaintext, err := json.Marshal(token)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, v.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return v.aead.Seal(nonce, nonce, plaintext, []byte(tokensID(userUID, provider))), nil
+}
+
+func (v *Vault) decrypt(userUID, provider string, data []byte) (Token, error) {
+	if len(data) < v.aead.NonceSize() {
+		return Token{}, errors.New("the encrypted tokens are too short")
+	}
+	nonce, ciphertext := data[:v.aead.NonceSize()], data[v.aead.NonceSize():]
+	plaintext, err := v.aead.Open(nil, nonce, ciphertext, []byte(tokensID(userUID, provider)))
+	if err != nil {
+		return Token{}, err
+	}
+	token := Token{}
+	if err := json.Unmarshal(plaintext, &token); err != nil {
+		return Token{}, err
+	}
+	return token, nil
+}
+
+// tokensID identifies the tokens of a user and a provider. UIDs never contain a slash.
+func tokensID(userUID, provider string) string {
+	return userUID + "/" + provider
+}
+
+// secretName returns the name of the secret of the tokens of the user and the provider. Provider names are not
+// always valid in object names, they are hashed.
+func secretName(userUID, provider string) string {
+	hash := sha256.Sum256([]byte(tokensID(userUID, provider)))
+	return secretPrefix + hex.EncodeToString(hash[:])
+}
+
+type tokensKeyType int
+
+const tokensKey tokensKeyType = iota
+
+// loginTokens are the tokens a provider issued for a login
+type loginTokens struct {
+	provider   string
+	accessData *osincli.AccessData
+}
+
+// WithTokens returns a copy of req that carries the tokens the provider issued for the login to the success handlers
+// of the login, which know the user the identity of the login was mapped to
+func WithTokens(req *http.Request, provider string, accessData *osincli.AccessData) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), tokensKey, loginTokens{provider: provider, accessData: accessData}))
+}
+
+func tokensFrom(req *http.Request) (loginTokens, bool) {
+	tokens, ok := req.Context().Value(tokensKey).(loginTokens)
+	return tokens, ok && tokens.accessData != nil && len(tokens.accessData.AccessToken) > 0
+}
@@ -0,0 +1,250 @@
+package upstreamtoken
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/RangelReale/osincli"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	"k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/client-go/kubernetes/fake"
+
+	userapi "github.com/openshift/api/user/v1"
+	userfake "github.com/openshift/client-go/user/clientset/versioned/fake"
+)
+
+type fakeRefresher struct {
+	refreshTokens []string
+	accessData    *osincli.AccessData
+	err           error
+}
+
+func (r *fakeRefresher) Refresh(refreshToken string) (*osincli.AccessData, error) {
+	r.refreshTokens = append(r.refreshTokens, refreshToken)
+	return r.accessData, r.err
+}
+
+// testAuthorizer allows admin to get the upstream tokens of every user and to refresh those of bob
+type testAuthorizer struct{}
+
+func (testAuthorizer) Authorize(ctx context.Context, a authorizer.Attributes) (authorizer.Decision, string, error) {
+	if a.GetUser().GetName() != "admin" || a.GetAPIGroup() != "user.openshift.io" || a.GetResource() != "users" || a.GetSubresource() != "upstreamtokens" {
+		return authorizer.DecisionNoOpinion, "", nil
+	}
+	if a.GetVerb() == "get" || a.GetName() == "bob" {
+		return authorizer.DecisionAllow, "", nil
+	}
+	return authorizer.DecisionNoOpinion, "not allowed", nil
+}
+
+func newTestVault(t *testing.T) (*Vault, *fake.Clientset) {
+	client := fake.NewSimpleClientset()
+	vault, err := NewVault(client.CoreV1().Secrets("openshift-authentication"), []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vault.now = func() time.Time { return time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC) }
+	return vault, client
+}
+
+func TestAuthenticationSucceeded(t *testing.T) {
+	vault, client := newTestVault(t)
+	vault.AddProvider("gitlab", &fakeRefresher{})
+
+	bob := &user.DefaultInfo{Name: "bob", UID: "bob-uid"}
+	expiresIn := int32(3600)
+	for _, login := range []struct {
+		provider string
+		user     user.Info
+	}{
+		{provider: "gitlab", user: bob},
+		{provider: "github", user: bob},
+		{provider: "gitlab", user: &user.DefaultInfo{Name: "no-uid"}},
+	} {
+		req := WithTokens(httptest.NewRequest("GET", "/oauth2callback/"+login.provider, nil), login.provider, &osincli.AccessData{TokenType: "bearer", AccessToken: "access", RefreshToken: "refresh", Expiration: &expiresIn})
+		if handled, err := vault.AuthenticationSucceeded(login.user, "", httptest.NewRecorder(), req); handled || err != nil {
+			t.Fatalf("expected the login to continue, got %v %v", handled, err)
+		}
+	}
+
+	token, err := vault.Get("bob-uid", "gitlab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Token{Provider: "gitlab", TokenType: "bearer", AccessToken: "access", RefreshToken: "refresh", Expiry: time.Date(2024, 1, 1, 1, 0, 0, 0, time.UTC)}
+	if token != expected {
+		t.Errorf("expected %#v, got %#v", expected, token)
+	}
+	if _, err := vault.Get("bob-uid", "github"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected the tokens of a provider that was not added to be dropped, got %v", err)
+	}
+	if secrets, err := client.CoreV1().Secrets("openshift-authentication").List(context.TODO(), metav1.ListOptions{}); err != nil || len(secrets.Items) != 1 {
+		t.Errorf("expected only the tokens of users with a UID to be kept, got %v %v", secrets, err)
+	}
+
+	// the tokens are encrypted and bound to their user and provider
+	secret, err := client.CoreV1().Secrets("openshift-authentication").Get(context.TODO(), secretName("bob-uid", "gitlab"), metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.Labels[UserUIDLabel] != "bob-uid" || secret.Annotations[ProviderAnnotation] != "gitlab" {
+		t.Errorf("unexpected labels %v and annotations %v", secret.Labels, secret.Annotations)
+	}
+	if _, err := vault.decrypt("alice-uid", "gitlab", secret.Data[tokenKey]); err == nil {
+		t.Error("expected the tokens of another user to be rejected")
+	}
+	if _, err := vault.decrypt("bob-uid", "github", secret.Data[tokenKey]); err == nil {
+		t.Error("expected the tokens of another provider to be rejected")
+	}
+	other, _ := NewVault(client.CoreV1().Secrets("openshift-authentication"), []byte("other"))
+	if _, err := other.Get("bob-uid", "gitlab"); err == nil {
+		t.Error("expected the tokens to be unreadable with another secret")
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	vault, _ := newTestVault(t)
+	refresher := &fakeRefresher{accessData: &osincli.AccessData{TokenType: "bearer", AccessToken: "access2"}}
+	vault.AddProvider("gitlab", refresher)
+	if err := vault.Store("bob-uid", Token{Provider: "gitlab", AccessToken: "access", RefreshToken: "refresh"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := vault.Store("alice-uid", Token{Provider: "gitlab", AccessToken: "access"}); err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := vault.Refresh("bob-uid", "gitlab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the refresh token is kept if the provider does not rotate it
+	if token.AccessToken != "access2" || token.RefreshToken != "refresh" || len(refresher.refreshTokens) != 1 || refresher.refreshTokens[0] != "refresh" {
+		t.Errorf("unexpected refresh %#v with %v", token, refresher.refreshTokens)
+	}
+	if stored, err := vault.Get("bob-uid", "gitlab"); err != nil || stored != token {
+		t.Errorf("expected the refreshed tokens to be stored, got %#v %v", stored, err)
+	}
+
+	if _, err := vault.Refresh("alice-uid", "gitlab"); !errors.Is(err, ErrNoRefreshToken) {
+		t.Errorf("expected ErrNoRefreshToken, got %v", err)
+	}
+	refresher.err = errors.New("invalid_grant")
+	var refreshErr *RefreshError
+	if _, err := vault.Refresh("bob-uid", "gitlab"); !errors.As(err, &refreshErr) {
+		t.Errorf("expected a RefreshError, got %v", err)
+	}
+}
+
+func TestDeleteUser(t *testing.T) {
+	vault, _ := newTestVault(t)
+	for _, provider := range []string{"gitlab", "github"} {
+		if err := vault.Store("bob-uid", Token{Provider: provider, AccessToken: "access"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := vault.Store("alice-uid", Token{Provider: "gitlab", AccessToken: "access"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, dryRun := range []bool{true, false} {
+		if deleted, err := vault.DeleteUser("bob-uid", dryRun); err != nil || deleted != 2 {
+			t.Errorf("expected 2 tokens to be deleted with dry run %v, got %d %v", dryRun, deleted, err)
+		}
+	}
+	for _, provider := range []string{"gitlab", "github"} {
+		if _, err := vault.Get("bob-uid", provider); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected the tokens of provider %s to be deleted, got %v", provider, err)
+		}
+	}
+	if _, err := vault.Get("alice-uid", "gitlab"); err != nil {
+		t.Errorf("expected the tokens of other users to be kept, got %v", err)
+	}
+	if deleted, err := vault.DeleteUser("bob-uid", false); err != nil || deleted != 0 {
+		t.Errorf("expected no tokens to be left, got %d %v", deleted, err)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	vault, _ := newTestVault(t)
+	vault.AddProvider("gitlab", &fakeRefresher{accessData: &osincli.AccessData{TokenType: "bearer", AccessToken: "access2"}})
+	for _, uid := range []string{"bob-uid", "alice-uid"} {
+		if err := vault.Store(uid, Token{Provider: "gitlab", TokenType: "bearer", AccessToken: "access", RefreshToken: "refresh"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	client := userfake.NewSimpleClientset(
+		&userapi.User{ObjectMeta: metav1.ObjectMeta{Name: "bob", UID: "bob-uid"}, Identities: []string{"github:7", "gitlab:42"}},
+		&userapi.User{ObjectMeta: metav1.ObjectMeta{Name: "alice", UID: "alice-uid"}, Identities: []string{"gitlab:43"}},
+		&userapi.Identity{ObjectMeta: metav1.ObjectMeta{Name: "gitlab:42"}, ProviderName: "gitlab", ProviderUserName: "42", User: corev1.ObjectReference{Name: "bob", UID: "bob-uid"}},
+		&userapi.Identity{ObjectMeta: metav1.ObjectMeta{Name: "gitlab:43"}, ProviderName: "gitlab", ProviderUserName: "43", User: corev1.ObjectReference{Name: "alice", UID: "alice-uid"}},
+		&userapi.Identity{ObjectMeta: metav1.ObjectMeta{Name: "gitlab:44"}, ProviderName: "gitlab", ProviderUserName: "44"},
+	)
+	handler := NewHandler(vault, client.UserV1().Users(), client.UserV1().Identities(), testAuthorizer{})
+
+	for _, tc := range []struct {
+		name             string
+		method           string
+		query            string
+		caller           user.Info
+		expectedStatus   int
+		expectedToken    string
+		expectedIdentity string
+	}{
+		{name: "identity", method: "GET", query: "identity=gitlab:42", expectedStatus: http.StatusOK, expectedToken: "access", expectedIdentity: "gitlab:42"},
+		{name: "user", method: "GET", query: "user=bob&provider=gitlab", expectedStatus: http.StatusOK, expectedToken: "access", expectedIdentity: "gitlab:42"},
+		{name: "other user", method: "GET", query: "user=alice&provider=gitlab", expectedStatus: http.StatusOK, expectedToken: "access", expectedIdentity: "gitlab:43"},
+		{name: "no tokens", method: "GET", query: "user=bob&provider=github", expectedStatus: http.StatusNotFound},
+		{name: "no identity", method: "GET", query: "user=bob&provider=google", expectedStatus: http.StatusNotFound},
+		{name: "no user", method: "GET", query: "user=carol&provider=gitlab", expectedStatus: http.StatusNotFound},
+		{name: "unmapped identity", method: "GET", query: "identity=gitlab:44", expectedStatus: http.StatusNotFound},
+		{name: "ambiguous", method: "GET", query: "identity=gitlab:42&user=bob", expectedStatus: http.StatusBadRequest},
+		{name: "refresh", method: "POST", query: "identity=gitlab:42", expectedStatus: http.StatusOK, expectedToken: "access2", expectedIdentity: "gitlab:42"},
+		{name: "refresh forbidden", method: "POST", query: "identity=gitlab:43", expectedStatus: http.StatusForbidden},
+		{name: "own tokens", method: "GET", query: "identity=gitlab:43", caller: &user.DefaultInfo{Name: "alice", UID: "alice-uid"}, expectedStatus: http.StatusOK, expectedToken: "access", expectedIdentity: "gitlab:43"},
+		{name: "tokens of others", method: "GET", query: "identity=gitlab:42", caller: &user.DefaultInfo{Name: "alice", UID: "alice-uid"}, expectedStatus: http.StatusForbidden},
+		{name: "user created again", method: "GET", query: "identity=gitlab:43", caller: &user.DefaultInfo{Name: "alice", UID: "other-uid"}, expectedStatus: http.StatusForbidden},
+		{name: "delete", method: "DELETE", query: "identity=gitlab:42", expectedStatus: http.StatusMethodNotAllowed},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			caller := tc.caller
+			if caller == nil {
+				caller = &user.DefaultInfo{Name: "admin"}
+			}
+			req := httptest.NewRequest(tc.method, "/upstream-tokens?"+tc.query, nil)
+			req = req.WithContext(request.WithUser(req.Context(), caller))
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+			if recorder.Code != tc.expectedStatus {
+				t.Fatalf("expected status %d, got %d: %s", tc.expectedStatus, recorder.Code, recorder.Body.String())
+			}
+			if tc.expectedStatus != http.StatusOK {
+				return
+			}
+			response := Response{}
+			if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			if response.AccessToken != tc.expectedToken || response.Identity != tc.expectedIdentity || !response.Refreshable {
+				t.Errorf("unexpected response %#v", response)
+			}
+			if recorder.Header().Get("Cache-Control") != "no-store" {
+				t.Error("expected the response not to be stored")
+			}
+		})
+	}
+
+	// unauthenticated requests are forbidden
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/upstream-tokens?identity=gitlab:42", nil))
+	if recorder.Code != http.StatusForbidden {
+		t.Errorf("expected unauthenticated requests to be forbidden, got %d", recorder.Code)
+	}
+}
@@ -0,0 +1,58 @@
+// Package userextra passes extra attributes of identities, e.g. the department or employee ID claims of an OpenID
+// provider, on to the extra user info of the user they log in as. The extra user info is kept in the session and
+// the tokens of the user, so that token reviews return it to authorizers and audit logs.
+package userextra
+
+import (
+	"k8s.io/apiserver/pkg/authentication/user"
+
+	authapi "github.com/openshift/oauth-server/pkg/api"
+)
+
+type userMapper struct {
+	delegate authapi.UserIdentityMapper
+	// keys are the keys of the extra user info by the extra attribute of identities they are copied from
+	keys map[string]string
+}
+
+// NewUserMapper returns a mapper that copies the extra attributes of identities that are keys of keys into the extra
+// user info of their user, under the value of the attribute in keys. Identities without the attributes are mapped
+// like before.
+func NewUserMapper(delegate authapi.UserIdentityMapper, keys map[string]string) authapi.UserIdentityMapper {
+	return &userMapper{delegate: delegate, keys: keys}
+}
+
+func (m *userMapper) UserFor(identityInfo authapi.UserIdentityInfo) (user.Info, error) {
+	u, err := m.delegate.UserFor(identityInfo)
+	if err != nil {
+		return u, err
+	}
+
+	extra := map[string][]string{}
+	for attribute, key := range m.keys {
+		if value := identityInfo.GetExtra()[attribute]; len(value) > 0 {
+			extra[key] = []string{value}
+		}
+	}
+	if len(extra) == 0 {
+		return u, nil
+	}
+	return &extraInfo{Info: u, extra: extra}, nil
+}
+
+// extraInfo adds extra user info to the user info of the delegate
+type extraInfo struct {
+	user.Info
+	extra map[string][]string
+}
+
+func (u *extraInfo) GetExtra() map[string][]string {
+	extra := map[string][]string{}
+	for key, values := range u.Info.GetExtra() {
+		extra[key] = values
+	}
+	for key, values := range u.extra {
+		extra[key] = values
+	}
+	return extra
+}
@@ -0,0 +1,59 @@
+package userextra
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apiserver/pkg/authentication/user"
+
+	authapi "github.com/openshift/oauth-server/pkg/api"
+)
+
+type testMapper struct{}
+
+func (testMapper) UserFor(identity authapi.UserIdentityInfo) (user.Info, error) {
+	return &user.DefaultInfo{Name: "bob", UID: "bob-uid", Extra: map[string][]string{"existing": {"value"}}}, nil
+}
+
+func TestUserMapper(t *testing.T) {
+	mapper := NewUserMapper(testMapper{}, map[string]string{
+		"department":  "department.example.com",
+		"employee_id": "employee-id.example.com",
+	})
+
+	testCases := map[string]struct {
+		Extra map[string]string
+
+		ExpectedExtra map[string][]string
+	}{
+		"attributes": {
+			Extra: map[string]string{"department": "finance", "employee_id": "1234", "email": "bob@example.com"},
+			ExpectedExtra: map[string][]string{
+				"existing":                {"value"},
+				"department.example.com":  {"finance"},
+				"employee-id.example.com": {"1234"},
+			},
+		},
+		"missing attributes": {
+			Extra:         map[string]string{"department": ""},
+			ExpectedExtra: map[string][]string{"existing": {"value"}},
+		},
+	}
+
+	for k, testCase := range testCases {
+		t.Run(k, func(t *testing.T) {
+			identity := authapi.NewDefaultUserIdentityInfo("sso", "bob")
+			identity.Extra = testCase.Extra
+			u, err := mapper.UserFor(identity)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if u.GetName() != "bob" || u.GetUID() != "bob-uid" {
+				t.Errorf("expected the user of the delegate, got %#v", u)
+			}
+			if !reflect.DeepEqual(u.GetExtra(), testCase.ExpectedExtra) {
+				t.Errorf("expected extra %v, got %v", testCase.ExpectedExtra, u.GetExtra())
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package identitymapper
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	kerrs "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// DefaultBreakerThreshold is the number of mappings in a row that fail because the API server is overloaded
+	// before the breaker opens by default
+	DefaultBreakerThreshold = 5
+	// DefaultBreakerCooldown is how long an open breaker fails mappings fast by default
+	DefaultBreakerCooldown = 30 * time.Second
+)
+
+// errBreakerOpen is the cause of the unavailable errors of mappings that were not attempted
+var errBreakerOpen = errors.New("the API server is overloaded, users are not provisioned until it recovers")
+
+// Breaker keeps provisioning mappers from calling an overloaded API server. Once a number of mappings in a row
+// failed because the API server throttled them or timed out, it opens and fails mappings fast for a cooldown.
+// After the cooldown mappings are attempted again, a success closes the breaker and another overload opens it again.
+type Breaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	lock      sync.Mutex
+	failures  int
+	openUntil time.Time
+}
+
+// NewBreaker returns a Breaker that opens for cooldown after threshold overloaded mappings in a row
+func NewBreaker(threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+// Allow returns an unavailable error while the breaker is open
+func (b *Breaker) Allow() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.now().Before(b.openUntil) {
+		return unavailableError{CausedBy: errBreakerOpen}
+	}
+	return nil
+}
+
+// Record records the result of a mapping. Errors that do not stem from an overloaded API server, e.g. claim
+// conflicts, leave the breaker as it is.
+func (b *Breaker) Record(err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	switch {
+	case err == nil:
+		b.failures = 0
+	case isOverloaded(err):
+		b.failures++
+		if b.failures >= b.threshold {
+			klog.Warningf("Provisioning of users paused for %v after %d failures in a row, last: %v", b.cooldown, b.failures, err)
+			b.openUntil = b.now().Add(b.cooldown)
+		}
+	}
+}
+
+// isOverloaded returns true for errors of an API server that throttles requests or cannot serve them in time
+func isOverloaded(err error) bool {
+	return kerrs.IsTooManyRequests(err) || kerrs.IsServerTimeout(err) || kerrs.IsTimeout(err) || kerrs.IsServiceUnavailable(err)
+}
+
+// unavailableError is returned when users cannot be provisioned because the API server is overloaded
+type unavailableError struct {
+	CausedBy error
+}
+
+// IsUnavailableError returns true if err, or an error it wraps, is returned because the API server is overloaded.
+// Logins that fail with it may succeed once the API server recovers.
+func IsUnavailableError(err error) bool {
+	return errors.As(err, &unavailableError{})
+}
+
+func (e unavailableError) Error() string {
+	return fmt.Sprintf("users cannot be provisioned right now: %v", e.CausedBy)
+}
+
+// Unwrap returns the underlying error to satisfy errors.As() and errors.Is().
+func (e unavailableError) Unwrap() error { return e.CausedBy }
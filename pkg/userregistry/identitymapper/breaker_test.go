@@ -0,0 +1,122 @@
+package identitymapper
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	kerrs "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	clienttesting "k8s.io/client-go/testing"
+
+	userapi "github.com/openshift/api/user/v1"
+	userv1fakeclient "github.com/openshift/client-go/user/clientset/versioned/fake"
+	authapi "github.com/openshift/oauth-server/pkg/api"
+)
+
+func TestBreaker(t *testing.T) {
+	now := time.Now()
+	breaker := NewBreaker(2, time.Minute)
+	breaker.now = func() time.Time { return now }
+
+	throttled := kerrs.NewTooManyRequests("slow down", 1)
+	breaker.Record(throttled)
+	breaker.Record(kerrs.NewAlreadyExists(userapi.Resource("users"), "bob"))
+	if err := breaker.Allow(); err != nil {
+		t.Errorf("expected the breaker to stay closed after a single overload, got %v", err)
+	}
+	breaker.Record(throttled)
+	if err := breaker.Allow(); !IsUnavailableError(err) {
+		t.Errorf("expected the breaker to open after two overloads in a row, got %v", err)
+	}
+
+	now = now.Add(time.Minute)
+	if err := breaker.Allow(); err != nil {
+		t.Errorf("expected mappings to be attempted after the cooldown, got %v", err)
+	}
+	breaker.Record(kerrs.NewServerTimeout(userapi.Resource("users"), "get", 1))
+	if err := breaker.Allow(); !IsUnavailableError(err) {
+		t.Errorf("expected another overload after the cooldown to open the breaker again, got %v", err)
+	}
+
+	now = now.Add(time.Minute)
+	breaker.Record(nil)
+	breaker.Record(throttled)
+	if err := breaker.Allow(); err != nil {
+		t.Errorf("expected a success to close the breaker, got %v", err)
+	}
+}
+
+func TestProvisioningRetries(t *testing.T) {
+	fakeClient := userv1fakeclient.NewSimpleClientset(
+		makeIdentity("bobIdentityUID", "idp", "bob", "bobUserUID", "bob"),
+		makeUser("bobUserUID", "bob", "idp:bob"),
+	)
+	throttles := 2
+	fakeClient.PrependReactor("get", "identities", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		if throttles > 0 {
+			throttles--
+			return true, nil, kerrs.NewTooManyRequests("slow down", 1)
+		}
+		return false, nil, nil
+	})
+	breaker := NewBreaker(1, time.Minute)
+	mapper := &provisioningIdentityMapper{
+		identity:             fakeClient.UserV1().Identities(),
+		user:                 fakeClient.UserV1().Users(),
+		provisioningStrategy: &testNewIdentityGetter{},
+		backoff:              wait.Backoff{Steps: 4},
+		breaker:              breaker,
+	}
+
+	user, err := mapper.UserFor(authapi.NewDefaultUserIdentityInfo("idp", "bob"))
+	if err != nil {
+		t.Fatalf("expected throttled requests to be retried, got %v", err)
+	}
+	if user.GetName() != "bob" {
+		t.Errorf("expected user bob, got %s", user.GetName())
+	}
+
+	// the retries run out
+	throttles = 4
+	if _, err := mapper.UserFor(authapi.NewDefaultUserIdentityInfo("idp", "bob")); !IsUnavailableError(err) || !kerrs.IsTooManyRequests(errors.Unwrap(err)) {
+		t.Errorf("expected an unavailable error once the retries ran out, got %v", err)
+	}
+	fakeClient.ClearActions()
+	if _, err := mapper.UserFor(authapi.NewDefaultUserIdentityInfo("idp", "bob")); !IsUnavailableError(err) {
+		t.Errorf("expected the open breaker to fail the mapping, got %v", err)
+	}
+	if actions := fakeClient.Actions(); len(actions) != 0 {
+		t.Errorf("expected no requests while the breaker is open, got %d", len(actions))
+	}
+}
+
+func TestProvisioningCreatesIdentityOnce(t *testing.T) {
+	fakeClient := userv1fakeclient.NewSimpleClientset()
+	// the identity was created by an earlier attempt whose response was lost
+	gets := 0
+	fakeClient.PrependReactor("get", "identities", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		gets++
+		if gets == 1 {
+			return true, nil, kerrs.NewNotFound(userapi.Resource("identities"), "idp:bob")
+		}
+		return true, makeIdentity("bobIdentityUID", "idp", "bob", "bobUserUID", "bob"), nil
+	})
+	fakeClient.PrependReactor("create", "identities", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		return true, nil, kerrs.NewAlreadyExists(userapi.Resource("identities"), "idp:bob")
+	})
+	mapper := &provisioningIdentityMapper{
+		identity:             fakeClient.UserV1().Identities(),
+		user:                 fakeClient.UserV1().Users(),
+		provisioningStrategy: &testNewIdentityGetter{responses: []interface{}{makeUser("bobUserUID", "bob", "idp:bob")}},
+	}
+
+	user, err := mapper.UserFor(authapi.NewDefaultUserIdentityInfo("idp", "bob"))
+	if err != nil {
+		t.Fatalf("expected the existing identity of the user to be used, got %v", err)
+	}
+	if user.GetName() != "bob" {
+		t.Errorf("expected user bob, got %s", user.GetName())
+	}
+}
@@ -0,0 +1,75 @@
+package identitymapper
+
+import (
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/cache"
+	kuser "k8s.io/apiserver/pkg/authentication/user"
+
+	authapi "github.com/openshift/oauth-server/pkg/api"
+	metrics "github.com/openshift/oauth-server/pkg/prometheus"
+)
+
+const (
+	// DefaultMappingCacheTTL is how long the user of an identity is cached by default
+	DefaultMappingCacheTTL = 10 * time.Second
+	// DefaultMappingCacheMaxEntries is the number of identities whose user is cached by default
+	DefaultMappingCacheMaxEntries = 1000
+)
+
+var _ = authapi.UserIdentityMapper(&cachingIdentityMapper{})
+
+// cachingIdentityMapper remembers the users that identities were mapped to, so that repeated logins do not get and
+// create identities and users again
+type cachingIdentityMapper struct {
+	delegate authapi.UserIdentityMapper
+	name     string
+	ttl      time.Duration
+	users    *cache.LRUExpireCache
+}
+
+// mappingCacheKey identifies an identity by its provider and the name of the user at the provider
+type mappingCacheKey struct {
+	provider string
+	user     string
+}
+
+// mappedUser is the static part of the user an identity is mapped to, see userToInfo
+type mappedUser struct {
+	name string
+	uid  string
+}
+
+// NewCachingIdentityMapper returns a UserIdentityMapper that caches the users delegate maps the identities of the named
+// provider to for ttl. Errors are not cached, and the cached user of an identity is forgotten once delegate fails to
+// map it, e.g. because of a conflict with another identity, so that the next login maps it again. Users that are
+// deleted or re-linked to other identities are still returned for up to ttl, which should therefore be short.
+func NewCachingIdentityMapper(name string, delegate authapi.UserIdentityMapper, ttl time.Duration, maxEntries int) authapi.UserIdentityMapper {
+	return &cachingIdentityMapper{
+		delegate: delegate,
+		name:     name,
+		ttl:      ttl,
+		users:    cache.NewLRUExpireCache(maxEntries),
+	}
+}
+
+// UserFor returns the cached user of the identity, or maps it with the delegate
+func (m *cachingIdentityMapper) UserFor(info authapi.UserIdentityInfo) (kuser.Info, error) {
+	key := mappingCacheKey{provider: info.GetProviderName(), user: info.GetProviderUserName()}
+	if cached, ok := m.users.Get(key); ok {
+		metrics.RecordMappingCacheLookup(m.name, metrics.CacheHitResult)
+		user := cached.(mappedUser)
+		// a new info each time, the mappers around this one may add to it
+		return &kuser.DefaultInfo{Name: user.name, UID: user.uid}, nil
+	}
+	metrics.RecordMappingCacheLookup(m.name, metrics.CacheMissResult)
+
+	user, err := m.delegate.UserFor(info)
+	if err != nil {
+		// another login may have cached the identity in the meantime
+		m.users.Remove(key)
+		return nil, err
+	}
+	m.users.Add(key, mappedUser{name: user.GetName(), uid: user.GetUID()}, m.ttl)
+	return user, nil
+}
@@ -0,0 +1,48 @@
+package identitymapper
+
+import (
+	"testing"
+	"time"
+
+	userv1fakeclient "github.com/openshift/client-go/user/clientset/versioned/fake"
+	authapi "github.com/openshift/oauth-server/pkg/api"
+)
+
+func TestCachingIdentityMapper(t *testing.T) {
+	fakeClient := userv1fakeclient.NewSimpleClientset(
+		makeIdentity("bobIdentityUID", "idp", "bob", "bobUserUID", "bob"),
+		makeUser("bobUserUID", "bob", "idp:bob"),
+		// the user of alice does not reference her identity
+		makeIdentity("aliceIdentityUID", "idp", "alice", "aliceUserUID", "alice"),
+		makeUser("aliceUserUID", "alice"),
+	)
+	mapper := NewCachingIdentityMapper("idp", &provisioningIdentityMapper{
+		identity:             fakeClient.UserV1().Identities(),
+		user:                 fakeClient.UserV1().Users(),
+		provisioningStrategy: &testNewIdentityGetter{},
+	}, time.Minute, 10)
+
+	for i := 0; i < 3; i++ {
+		user, err := mapper.UserFor(authapi.NewDefaultUserIdentityInfo("idp", "bob"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.GetName() != "bob" || user.GetUID() != "bobUserUID" {
+			t.Errorf("expected user bob, got %#v", user)
+		}
+	}
+	if actions := fakeClient.Actions(); len(actions) != 2 {
+		t.Errorf("expected the identity and user to be fetched once, got %d actions", len(actions))
+	}
+
+	// conflicts are not cached
+	fakeClient.ClearActions()
+	for i := 0; i < 2; i++ {
+		if _, err := mapper.UserFor(authapi.NewDefaultUserIdentityInfo("idp", "alice")); !IsMappingConflictError(err) {
+			t.Errorf("expected a mapping conflict, got %v", err)
+		}
+	}
+	if actions := fakeClient.Actions(); len(actions) != 4 {
+		t.Errorf("expected the conflicting identity to be mapped every time, got %d actions", len(actions))
+	}
+}
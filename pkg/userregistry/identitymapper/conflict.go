@@ -0,0 +1,51 @@
+package identitymapper
+
+import (
+	kerrs "k8s.io/apimachinery/pkg/api/errors"
+
+	userapi "github.com/openshift/api/user/v1"
+)
+
+// mappingConflictError is returned when an identity references a user that does not reference it, e.g. because the
+// user was recreated or re-linked to another identity
+type mappingConflictError struct {
+	Identity *userapi.Identity
+	User     *userapi.User
+	CausedBy error
+}
+
+func newMappingConflictError(identity *userapi.Identity, user *userapi.User) error {
+	return mappingConflictError{
+		Identity: identity,
+		User:     user,
+		CausedBy: kerrs.NewNotFound(userapi.Resource("useridentitymapping"), identity.Name),
+	}
+}
+
+func IsMappingConflictError(err error) bool {
+	_, ok := err.(mappingConflictError)
+	return ok
+}
+
+func (c mappingConflictError) Error() string {
+	return c.CausedBy.Error()
+}
+
+// Unwrap returns the underlying error to satisfy errors.As() and errors.Is().
+func (c mappingConflictError) Unwrap() error { return c.CausedBy }
+
+// Conflict returns the names of the identity and the user of a claim or mapping conflict, ok is false for other
+// errors
+func Conflict(err error) (identity, user string, ok bool) {
+	switch e := err.(type) {
+	case claimError:
+		if e.Identity == nil || e.User == nil {
+			return "", "", false
+		}
+		return e.Identity.Name, e.User.Name, true
+	case mappingConflictError:
+		return e.Identity.Name, e.User.Name, true
+	default:
+		return "", "", false
+	}
+}
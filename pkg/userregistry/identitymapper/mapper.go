@@ -30,23 +30,28 @@ const (
 // 1. Returns an existing user if the identity exists and is associated with an existing user
 // 2. Returns an error if the identity exists and is not associated with a user (or is associated with a missing user)
 // 3. Handles new identities according to the requested method
-func NewIdentityUserMapper(identities userclient.IdentityInterface, users userclient.UserInterface, userIdentityMapping userclient.UserIdentityMappingInterface, method MappingMethodType) (authapi.UserIdentityMapper, error) {
+// If usernameTemplate is set, it determines the username for new identities instead of their preferred username.
+// Methods other than lookup retry with DefaultProvisioningBackoff, and fail fast while breaker is open if it is set.
+func NewIdentityUserMapper(identities userclient.IdentityInterface, users userclient.UserInterface, userIdentityMapping userclient.UserIdentityMappingInterface, method MappingMethodType, usernameTemplate *UsernameTemplate, breaker *Breaker) (authapi.UserIdentityMapper, error) {
 	// initUser initializes fields in a User API object from its associated Identity
 	// called when adding the first Identity to a User (during create or update of a User)
 	initUser := NewDefaultUserInitStrategy()
 
 	switch method {
 	case MappingMethodLookup:
+		if usernameTemplate != nil {
+			return nil, fmt.Errorf("a username template cannot be used with mapping method %q", method)
+		}
 		return &lookupIdentityMapper{userIdentityMapping, users}, nil
 
 	case MappingMethodClaim:
-		return &provisioningIdentityMapper{identities, users, NewStrategyClaim(users, initUser)}, nil
+		return &provisioningIdentityMapper{identities, users, NewStrategyClaim(users, initUser), usernameTemplate, DefaultProvisioningBackoff, breaker}, nil
 
 	case MappingMethodAdd:
-		return &provisioningIdentityMapper{identities, users, NewStrategyAdd(users, initUser)}, nil
+		return &provisioningIdentityMapper{identities, users, NewStrategyAdd(users, initUser), usernameTemplate, DefaultProvisioningBackoff, breaker}, nil
 
 	case MappingMethodGenerate:
-		return &provisioningIdentityMapper{identities, users, NewStrategyGenerate(users, initUser)}, nil
+		return &provisioningIdentityMapper{identities, users, NewStrategyGenerate(users, initUser), usernameTemplate, DefaultProvisioningBackoff, breaker}, nil
 
 	default:
 		return nil, fmt.Errorf("unsupported mapping method %q", method)
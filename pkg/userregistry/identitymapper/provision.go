@@ -2,6 +2,7 @@ package identitymapper
 
 import (
 	"context"
+	"time"
 
 	"k8s.io/klog/v2"
 
@@ -9,8 +10,10 @@ import (
 	kerrs "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
 	kuser "k8s.io/apiserver/pkg/authentication/user"
 	apirequest "k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/client-go/util/retry"
 
 	userapi "github.com/openshift/api/user/v1"
 	userclient "github.com/openshift/client-go/user/clientset/versioned/typed/user/v1"
@@ -24,6 +27,14 @@ type UserForNewIdentityGetter interface {
 	UserForNewIdentity(ctx context.Context, preferredUserName string, identity *userapi.Identity) (*userapi.User, error)
 }
 
+// DefaultProvisioningBackoff retries a mapping up to three times, with jittered waits that double from 50ms
+var DefaultProvisioningBackoff = wait.Backoff{
+	Duration: 50 * time.Millisecond,
+	Factor:   2,
+	Jitter:   0.5,
+	Steps:    4,
+}
+
 var _ = authapi.UserIdentityMapper(&provisioningIdentityMapper{})
 
 // provisioningIdentityMapper implements api.UserIdentityMapper
@@ -34,10 +45,22 @@ type provisioningIdentityMapper struct {
 	identity             userclient.IdentityInterface
 	user                 userclient.UserInterface
 	provisioningStrategy UserForNewIdentityGetter
+	// usernameTemplate determines the username for new identities, if set
+	usernameTemplate *UsernameTemplate
+	// backoff spaces the retries of mappings, DefaultProvisioningBackoff is used if it has no steps
+	backoff wait.Backoff
+	// breaker fails mappings fast while the API server is overloaded, if set
+	breaker *Breaker
 }
 
 // UserFor returns info about the user for whom identity info have been provided
 func (p *provisioningIdentityMapper) UserFor(info authapi.UserIdentityInfo) (kuser.Info, error) {
+	if p.breaker != nil {
+		if err := p.breaker.Allow(); err != nil {
+			return nil, err
+		}
+	}
+
 	// Retrying up to three times lets us handle race conditions with up to two conflicting identity providers without returning an error
 	// * A single race is possible on user creation for every conflicting identity provider
 	// * A single race is possible on user creation between two instances of the same provider
@@ -45,27 +68,51 @@ func (p *provisioningIdentityMapper) UserFor(info authapi.UserIdentityInfo) (kus
 	//
 	// A race condition between three conflicting identity providers *and* multiple instances of the same identity provider
 	// seems like a reasonable situation to return an error (you would get an AlreadyExists error on either the user or the identity)
-	return p.userForWithRetries(info, 3)
+	//
+	// The retries back off with jitter, so that the same retries ease the load of an API server that throttles or times out
+	backoff := p.backoff
+	if backoff.Steps == 0 {
+		backoff = DefaultProvisioningBackoff
+	}
+	var user kuser.Info
+	err := retry.OnError(backoff, isRetriable, func() error {
+		var err error
+		user, err = p.userFor(info)
+		return err
+	})
+
+	if p.breaker != nil {
+		p.breaker.Record(err)
+	}
+	if isOverloaded(err) {
+		return nil, unavailableError{CausedBy: err}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// isRetriable returns true for the errors of mappings that may succeed when they are retried:
+// AlreadyExists errors:
+// * The same user was created by another identity provider with the same preferred username
+// * The same user was created by another instance of this identity provider (e.g. double-clicked login button)
+// * The same identity was created by another instance of this identity provider (e.g. double-clicked login button)
+// Conflict errors:
+// * The same user was updated be another identity provider to add identity info
+// Overload errors:
+// * The API server throttled the request or could not serve it in time
+func isRetriable(err error) bool {
+	return kerrs.IsAlreadyExists(err) || kerrs.IsConflict(err) || isOverloaded(err)
 }
 
-func (p *provisioningIdentityMapper) userForWithRetries(info authapi.UserIdentityInfo, allowedRetries int) (kuser.Info, error) {
+func (p *provisioningIdentityMapper) userFor(info authapi.UserIdentityInfo) (kuser.Info, error) {
 	ctx := apirequest.NewContext()
 
 	identity, err := p.identity.Get(context.TODO(), info.GetIdentityName(), metav1.GetOptions{})
 
 	if kerrs.IsNotFound(err) {
-		user, err := p.createIdentityAndMapping(ctx, info)
-		// Only retry for the following types of errors:
-		// AlreadyExists errors:
-		// * The same user was created by another identity provider with the same preferred username
-		// * The same user was created by another instance of this identity provider (e.g. double-clicked login button)
-		// * The same identity was created by another instance of this identity provider (e.g. double-clicked login button)
-		// Conflict errors:
-		// * The same user was updated be another identity provider to add identity info
-		if (kerrs.IsAlreadyExists(err) || kerrs.IsConflict(err)) && allowedRetries > 0 {
-			return p.userForWithRetries(info, allowedRetries-1)
-		}
-		return user, err
+		return p.createIdentityAndMapping(ctx, info)
 	}
 
 	if err != nil {
@@ -87,8 +134,17 @@ func (p *provisioningIdentityMapper) createIdentityAndMapping(ctx context.Contex
 		Extra:            info.GetExtra(),
 	}
 
+	preferredUserName := getPreferredUserName(identity)
+	if p.usernameTemplate != nil {
+		username, err := p.usernameTemplate.Username(identity)
+		if err != nil {
+			return nil, err
+		}
+		preferredUserName = username
+	}
+
 	// GetIdentities or create a persisted user pointing to the identity
-	persistedUser, err := p.provisioningStrategy.UserForNewIdentity(ctx, getPreferredUserName(identity), identity)
+	persistedUser, err := p.provisioningStrategy.UserForNewIdentity(ctx, preferredUserName, identity)
 	if err != nil {
 		return nil, err
 	}
@@ -99,6 +155,14 @@ func (p *provisioningIdentityMapper) createIdentityAndMapping(ctx context.Contex
 		UID:  persistedUser.UID,
 	}
 	if _, err := p.identity.Create(context.TODO(), identity, metav1.CreateOptions{}); err != nil {
+		// the identity may exist because an earlier attempt created it without getting the response, or because of a
+		// double submit of the same login, it was created if it references the same user
+		if kerrs.IsAlreadyExists(err) {
+			existing, getErr := p.identity.Get(context.TODO(), identity.Name, metav1.GetOptions{})
+			if getErr == nil && existing.User.Name == persistedUser.Name && existing.User.UID == persistedUser.UID {
+				return userToInfo(persistedUser), nil
+			}
+		}
 		return nil, err
 	}
 
@@ -115,11 +179,11 @@ func (p *provisioningIdentityMapper) getMapping(ctx context.Context, identity *u
 	}
 	if u.UID != identity.User.UID {
 		klog.Errorf("identity.user.uid (%s) and user.uid (%s) do not match for identity %s", identity.User.UID, u.UID, identity.Name)
-		return nil, kerrs.NewNotFound(userapi.Resource("useridentitymapping"), identity.Name)
+		return nil, newMappingConflictError(identity, u)
 	}
 	if !sets.NewString(u.Identities...).Has(identity.Name) {
 		klog.Errorf("user.identities (%#v) does not include identity (%s)", u, identity.Name)
-		return nil, kerrs.NewNotFound(userapi.Resource("useridentitymapping"), identity.Name)
+		return nil, newMappingConflictError(identity, u)
 	}
 	return userToInfo(u), nil
 }
@@ -62,6 +62,7 @@ func TestProvision(t *testing.T) {
 
 		ValidateActions  func(t *testing.T, actions []clienttesting.Action)
 		ExpectedError    bool
+		ExpectedConflict bool
 		ExpectedUserName string
 	}{
 		"no identity, create user succeeds": {
@@ -270,7 +271,8 @@ func TestProvision(t *testing.T) {
 					t.Error(spew.Sdump(actions))
 				}
 			},
-			ExpectedError: true,
+			ExpectedError:    true,
+			ExpectedConflict: true,
 		},
 		"existing identity, user reference without identity backreference": {
 			ProviderName:     "idp",
@@ -293,7 +295,8 @@ func TestProvision(t *testing.T) {
 					t.Error(spew.Sdump(actions))
 				}
 			},
-			ExpectedError: true,
+			ExpectedError:    true,
+			ExpectedConflict: true,
 		},
 		"existing identity, user reference": {
 			ProviderName:     "idp",
@@ -336,6 +339,9 @@ func TestProvision(t *testing.T) {
 			if tc.ExpectedError != (err != nil) {
 				t.Fatalf("Expected error=%v, got %v", tc.ExpectedError, err)
 			}
+			if tc.ExpectedConflict != IsMappingConflictError(err) {
+				t.Errorf("Expected conflict=%v, got %v", tc.ExpectedConflict, err)
+			}
 			if !tc.ExpectedError && user.GetName() != tc.ExpectedUserName {
 				t.Fatalf("Expected username %v, got %v", tc.ExpectedUserName, user.GetName())
 			}
@@ -0,0 +1,85 @@
+package identitymapper
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+
+	userapi "github.com/openshift/api/user/v1"
+)
+
+// usernameTemplateFuncs are available to username templates in addition to the text/template builtins
+var usernameTemplateFuncs = template.FuncMap{
+	// localpart returns the part of an email address before the last @
+	"localpart": func(s string) string {
+		if i := strings.LastIndex(s, "@"); i >= 0 {
+			return s[:i]
+		}
+		return s
+	},
+	// domain returns the part of an email address after the last @
+	"domain": func(s string) string {
+		if i := strings.LastIndex(s, "@"); i >= 0 {
+			return s[i+1:]
+		}
+		return ""
+	},
+	"lower":      strings.ToLower,
+	"upper":      strings.ToUpper,
+	"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+	"replace":    func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+}
+
+// UsernameTemplate computes the name of the user for a new identity, instead of using its preferred username.
+// The template is executed with the extra attributes of the identity, e.g. {{.email}}, as well as
+// {{.providerName}}, {{.providerUserName}} and {{.preferred_username}}, which defaults to the provider user name.
+// Referring to a missing attribute is an error, {{index . "name"}} returns an empty string instead.
+type UsernameTemplate struct {
+	template *template.Template
+}
+
+// NewUsernameTemplate parses a username template such as "{{.preferred_username}}@{{.providerName}}"
+// or "{{.email | localpart | lower}}"
+func NewUsernameTemplate(text string) (*UsernameTemplate, error) {
+	t, err := template.New("username").Funcs(usernameTemplateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid username template %q: %v", text, err)
+	}
+	return &UsernameTemplate{template: t}, nil
+}
+
+// Username returns the name of the user for the given identity
+func (t *UsernameTemplate) Username(identity *userapi.Identity) (string, error) {
+	data := map[string]string{}
+	for k, v := range identity.Extra {
+		data[k] = v
+	}
+	data["providerName"] = identity.ProviderName
+	data["providerUserName"] = identity.ProviderUserName
+	data["preferred_username"] = getPreferredUserName(identity)
+
+	var b bytes.Buffer
+	if err := t.template.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("unable to determine username for identity %q: %v", identity.Name, err)
+	}
+	username := strings.TrimSpace(b.String())
+	if err := validateUsername(username); err != nil {
+		return "", fmt.Errorf("invalid username %q for identity %q: %v", username, identity.Name, err)
+	}
+	return username, nil
+}
+
+// validateUsername rejects names that are not valid names of User objects
+func validateUsername(username string) error {
+	switch {
+	case len(username) == 0:
+		return fmt.Errorf("username must not be empty")
+	case username == "." || username == "..":
+		return fmt.Errorf("username must not be %q", username)
+	case strings.ContainsAny(username, "/%:~"):
+		return fmt.Errorf("username must not contain '/', '%%', ':' or '~'")
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package identitymapper
+
+import (
+	"testing"
+
+	userapi "github.com/openshift/api/user/v1"
+)
+
+func TestUsernameTemplate(t *testing.T) {
+	identity := &userapi.Identity{
+		ProviderName:     "corp",
+		ProviderUserName: "1234",
+		Extra: map[string]string{
+			"email":              "Bob.Smith@Example.com",
+			"preferred_username": "bob",
+		},
+	}
+	identity.Name = "corp:1234"
+
+	testcases := []struct {
+		name      string
+		template  string
+		identity  *userapi.Identity
+		expected  string
+		expectErr bool
+	}{
+		{
+			name:     "preferred username and provider",
+			template: "{{.preferred_username}}@{{.providerName}}",
+			identity: identity,
+			expected: "bob@corp",
+		},
+		{
+			name:     "email localpart",
+			template: "{{.email | localpart | lower}}",
+			identity: identity,
+			expected: "bob.smith",
+		},
+		{
+			name:     "preferred username defaults to provider user name",
+			template: "{{.preferred_username}}",
+			identity: &userapi.Identity{ProviderName: "corp", ProviderUserName: "1234"},
+			expected: "1234",
+		},
+		{
+			name:     "missing attribute via index",
+			template: `{{or (index . "nickname") .providerUserName}}`,
+			identity: identity,
+			expected: "1234",
+		},
+		{
+			name:      "missing attribute",
+			template:  "{{.nickname}}",
+			identity:  identity,
+			expectErr: true,
+		},
+		{
+			name:      "empty username",
+			template:  `{{index . "nickname"}}`,
+			identity:  identity,
+			expectErr: true,
+		},
+		{
+			name:      "invalid username",
+			template:  "{{.providerName}}:{{.providerUserName}}",
+			identity:  identity,
+			expectErr: true,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			tmpl, err := NewUsernameTemplate(tc.template)
+			if err != nil {
+				t.Fatal(err)
+			}
+			username, err := tmpl.Username(tc.identity)
+			if tc.expectErr {
+				if err == nil {
+					t.Fatalf("expected error, got username %q", username)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if username != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, username)
+			}
+		})
+	}
+
+	if _, err := NewUsernameTemplate("{{.email"); err == nil {
+		t.Error("expected parse error")
+	}
+}